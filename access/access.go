@@ -0,0 +1,147 @@
+// Package access guards the administrative API of a server (settings,
+// audit log, backups, queue and worker maintenance) with an optional list
+// of client networks allowed to reach it and an optional bearer token, so
+// the download API can be exposed while admin stays internal.
+package access
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrForbidden is returned for clients outside the allowlist
+	ErrForbidden = errors.New("client address is not allowed to use the admin API")
+	// ErrUnauthorized is returned for requests without the admin token
+	ErrUnauthorized = errors.New("missing or wrong admin token")
+)
+
+// Allowlist is the networks clients may connect from; an empty list
+// allows every address
+type Allowlist []*net.IPNet
+
+// ParseAllowlist reads a comma-separated list of addresses and CIDR
+// networks, e.g. "127.0.0.1, ::1, 10.0.0.0/8"
+func ParseAllowlist(raw string) (Allowlist, error) {
+	var list Allowlist
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", entry)
+		}
+		list = append(list, network)
+	}
+	return list, nil
+}
+
+// Allows reports whether ip is in one of the networks
+func (a Allowlist) Allows(ip net.IP) bool {
+	if len(a) == 0 {
+		return true
+	}
+	for _, network := range a {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is who may use the admin API. The zero Policy lets everyone in.
+type Policy struct {
+	Allow Allowlist
+	// Token, when set, must be sent as "Authorization: Bearer <token>"
+	Token string
+}
+
+// PolicyFromEnv reads the policy of a server: ADMIN_ALLOWED_IPS is the
+// allowlist and ADMIN_TOKEN the token, or ADMIN_TOKEN_FILE a file holding it
+func PolicyFromEnv() (Policy, error) {
+	var policy Policy
+	allow, err := ParseAllowlist(os.Getenv("ADMIN_ALLOWED_IPS"))
+	if err != nil {
+		return policy, fmt.Errorf("invalid ADMIN_ALLOWED_IPS: %w", err)
+	}
+	policy.Allow = allow
+	policy.Token = os.Getenv("ADMIN_TOKEN")
+	if file := os.Getenv("ADMIN_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return policy, fmt.Errorf("failed to read ADMIN_TOKEN_FILE: %w", err)
+		}
+		policy.Token = strings.TrimSpace(string(data))
+		if policy.Token == "" {
+			return policy, fmt.Errorf("ADMIN_TOKEN_FILE %s is empty", file)
+		}
+	}
+	return policy, nil
+}
+
+// Check returns nil when r may use the admin API, otherwise ErrForbidden or
+// ErrUnauthorized. The address checked is that of the connection, so
+// forwarding headers cannot be used to get in; connections over a Unix
+// socket, whose permissions already decide who connects, pass the
+// allowlist.
+func (p Policy) Check(r *http.Request) error {
+	if len(p.Allow) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err == nil {
+			ip := net.ParseIP(host)
+			if ip == nil || !p.Allow.Allows(ip) {
+				return fmt.Errorf("%w: %s", ErrForbidden, host)
+			}
+		}
+	}
+	if p.Token != "" {
+		const prefix = "Bearer "
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, prefix) ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, prefix)), []byte(p.Token)) != 1 {
+			return ErrUnauthorized
+		}
+	}
+	return nil
+}
+
+// Handler serves next to the requests the policy lets in and answers the
+// others with 401 or 403, for admin endpoints served without a router
+func (p Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := p.Check(r)
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Open reports whether the policy lets everyone in
+func (p Policy) Open() bool {
+	return len(p.Allow) == 0 && p.Token == ""
+}
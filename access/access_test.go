@@ -0,0 +1,120 @@
+package access
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowlist(t *testing.T) {
+	list, err := ParseAllowlist(" 127.0.0.1, ::1 ,10.0.0.0/8,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"127.0.0.1":  true,
+		"127.0.0.2":  false,
+		"::1":        true,
+		"10.20.30.4": true,
+		"192.0.2.1":  false,
+		"2001:db8::": false,
+	} {
+		if got := list.Allows(net.ParseIP(addr)); got != want {
+			t.Errorf("Allows(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if !Allowlist(nil).Allows(net.ParseIP("192.0.2.1")) {
+		t.Error("an empty allowlist refused an address")
+	}
+	for _, raw := range []string{"localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := ParseAllowlist(raw); err == nil {
+			t.Errorf("ParseAllowlist(%q) accepted", raw)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	allow, _ := ParseAllowlist("10.0.0.0/8")
+	policy := Policy{Allow: allow, Token: "s3cret"}
+
+	request := func(remote, auth string) error {
+		r := httptest.NewRequest("GET", "/api/v2/settings", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", "10.0.0.1")
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		return policy.Check(r)
+	}
+	if err := request("10.1.2.3:5000", "Bearer s3cret"); err != nil {
+		t.Errorf("allowed client with the token: %v", err)
+	}
+	if err := request("192.0.2.1:5000", "Bearer s3cret"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("client outside the allowlist: %v, want ErrForbidden", err)
+	}
+	if err := request("10.1.2.3:5000", "Bearer wrong"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("wrong token: %v, want ErrUnauthorized", err)
+	}
+	if err := request("10.1.2.3:5000", ""); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("no token: %v, want ErrUnauthorized", err)
+	}
+	// Unix socket clients have no address to check
+	if err := request("@", "Bearer s3cret"); err != nil {
+		t.Errorf("Unix socket client: %v", err)
+	}
+	if (Policy{}).Check(httptest.NewRequest("GET", "/", nil)) != nil || !(Policy{}).Open() {
+		t.Error("the zero Policy refused a request")
+	}
+}
+
+func TestPolicyHandler(t *testing.T) {
+	allow, _ := ParseAllowlist("10.0.0.0/8")
+	handler := Policy{Allow: allow, Token: "s3cret"}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("profile"))
+	}))
+
+	serve := func(remote, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/debug/pprof/heap", nil)
+		r.RemoteAddr = remote
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	if w := serve("10.1.2.3:5000", "Bearer s3cret"); w.Code != http.StatusOK || w.Body.String() != "profile" {
+		t.Errorf("allowed client: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve("10.1.2.3:5000", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("no token: %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := serve("192.0.2.1:5000", "Bearer s3cret"); w.Code != http.StatusForbidden {
+		t.Errorf("client outside the allowlist: %d", w.Code)
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(file, []byte("from-file\n"), 0600)
+	t.Setenv("ADMIN_ALLOWED_IPS", "127.0.0.1")
+	t.Setenv("ADMIN_TOKEN", "from-env")
+	t.Setenv("ADMIN_TOKEN_FILE", file)
+
+	policy, err := PolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Token != "from-file" || len(policy.Allow) != 1 {
+		t.Errorf("PolicyFromEnv = %+v", policy)
+	}
+
+	t.Setenv("ADMIN_ALLOWED_IPS", "nowhere")
+	if _, err := PolicyFromEnv(); err == nil {
+		t.Error("PolicyFromEnv accepted an invalid allowlist")
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"multithreaded-downloader/admin"
+)
+
+// mtdl-admin manages a running server through its API, for provisioning
+// tools and operators. Build it with: go build -o mtdl-admin admin.go
+func main() {
+	os.Exit(admin.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
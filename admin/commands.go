@@ -0,0 +1,411 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/secrets"
+	"multithreaded-downloader/settings"
+)
+
+// DefaultURL is the server mtdl-admin talks to without --url or MTDL_API_URL
+const DefaultURL = "http://localhost:8080"
+
+// Exit codes of Run
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+// errUsage marks mistakes in the command line, which exit with ExitUsage
+var errUsage = errors.New("usage")
+
+func usageError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+const usage = `Usage: mtdl-admin [--url URL] [--token T] [--timeout D] <command>
+
+Commands:
+  health                          Show the server health
+  settings get                    Show the runtime settings
+  settings set name=value...      Change runtime settings; unchanged values are not sent
+  audit [--limit n]               Show recent setting changes
+  key generate --out FILE         Write a new secrets master key unless FILE holds one
+  key check FILE                  Check that FILE holds a valid master key
+  downloads export [--status s,...] [--format json|yaml] [--out FILE]
+                                  Export unfinished downloads as a manifest
+  downloads import FILE           Start the downloads of a manifest, skipping running ones
+  backup --out FILE               Save a backup of the database and progress files
+  cookies import FILE             Import a Netscape cookies.txt file
+  cookies clear                   Forget every imported cookie
+  queue stats                     Show queue lengths (queue server)
+  queue jobs                      List queued jobs in order (queue server)
+  queue completed|failed [--limit n]
+                                  List recently finished jobs (queue server)
+  queue front ID                  Move a queued job to the front (queue server)
+  workers                         Show worker statistics (queue server)
+
+The server address defaults to $MTDL_API_URL, then ` + DefaultURL + `; point it
+at ADMIN_ADDR when the server serves its admin routes there. The token the
+server's ADMIN_TOKEN requires defaults to $MTDL_ADMIN_TOKEN.
+Every command prints JSON; commands that change something report "changed".
+`
+
+// Run executes mtdl-admin with args (without the program name) and returns
+// its exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mtdl-admin", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	baseURL := fs.String("url", envOr("MTDL_API_URL", DefaultURL), "Server address")
+	token := fs.String("token", os.Getenv("MTDL_ADMIN_TOKEN"), "Admin token of the server")
+	timeout := fs.Duration("timeout", 30*time.Second, "How long a command may take")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	client := NewClient(*baseURL)
+	client.Token = *token
+	result, err := dispatch(ctx, client, fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(stderr, usage)
+			return ExitUsage
+		}
+		return ExitError
+	}
+	if raw, ok := result.(rawOutput); ok {
+		if _, err := stdout.Write(raw); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return ExitError
+		}
+		return ExitOK
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// rawOutput is printed as is instead of as JSON
+type rawOutput []byte
+
+// dispatch runs the command named by the first words of args
+func dispatch(ctx context.Context, c *Client, args []string) (interface{}, error) {
+	command, rest := args[0], args[1:]
+	switch command {
+	case "health":
+		return get(ctx, c, "/health")
+	case "workers":
+		return get(ctx, c, "/workers/stats")
+	case "audit":
+		return auditCommand(ctx, c, rest)
+	case "backup":
+		return backupCommand(ctx, c, rest)
+	}
+
+	if len(rest) == 0 {
+		return nil, usageError("unknown command %q", command)
+	}
+	sub, rest := rest[0], rest[1:]
+	switch command + " " + sub {
+	case "settings get":
+		return get(ctx, c, "/settings")
+	case "settings set":
+		return SetSettings(ctx, c, rest)
+	case "key generate":
+		return keyGenerateCommand(rest)
+	case "key check":
+		if len(rest) != 1 {
+			return nil, usageError("key check takes one file")
+		}
+		if _, err := secrets.LoadBox(rest[0]); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"file": rest[0], "valid": true}, nil
+	case "downloads export":
+		return exportCommand(ctx, c, rest)
+	case "downloads import":
+		if len(rest) != 1 {
+			return nil, usageError("downloads import takes one file")
+		}
+		data, err := os.ReadFile(rest[0])
+		if err != nil {
+			return nil, err
+		}
+		var out openapi.ManifestImport
+		if err := c.Do(ctx, "POST", "/downloads/import", data, &out); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"changed": out.Imported > 0, "import": out}, nil
+	case "cookies import":
+		if len(rest) != 1 {
+			return nil, usageError("cookies import takes one file")
+		}
+		data, err := os.ReadFile(rest[0])
+		if err != nil {
+			return nil, err
+		}
+		var out map[string]interface{}
+		if err := c.Do(ctx, "POST", "/cookies", data, &out); err != nil {
+			return nil, err
+		}
+		out["changed"] = true
+		return out, nil
+	case "cookies clear":
+		if err := c.Do(ctx, "DELETE", "/cookies", nil, nil); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"changed": true}, nil
+	case "queue stats":
+		return get(ctx, c, "/queue/stats")
+	case "queue jobs":
+		return get(ctx, c, "/queue/jobs")
+	case "queue completed", "queue failed":
+		limit, err := limitFlag(sub, rest)
+		if err != nil {
+			return nil, err
+		}
+		return get(ctx, c, "/queue/"+sub+limit)
+	case "queue front":
+		if len(rest) != 1 {
+			return nil, usageError("queue front takes one job ID")
+		}
+		if err := c.Do(ctx, "POST", "/queue/jobs/"+rest[0]+"/move-to-front", nil, nil); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"changed": true, "id": rest[0]}, nil
+	}
+	return nil, usageError("unknown command %q", command+" "+sub)
+}
+
+// limitFlag parses an optional --limit into a query string
+func limitFlag(name string, args []string) (string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	limit := fs.Int("limit", 0, "")
+	if err := fs.Parse(args); err != nil {
+		return "", usageError("%v", err)
+	}
+	if *limit == 0 {
+		return "", nil
+	}
+	return "?limit=" + strconv.Itoa(*limit), nil
+}
+
+func auditCommand(ctx context.Context, c *Client, args []string) (interface{}, error) {
+	limit, err := limitFlag("audit", args)
+	if err != nil {
+		return nil, err
+	}
+	return get(ctx, c, "/audit"+limit)
+}
+
+// get returns the response of a GET request as it came
+func get(ctx context.Context, c *Client, path string) (interface{}, error) {
+	var out json.RawMessage
+	if err := c.Do(ctx, "GET", path, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func exportCommand(ctx context.Context, c *Client, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("downloads export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	status := fs.String("status", "", "")
+	format := fs.String("format", "json", "")
+	out := fs.String("out", "", "")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError("%v", err)
+	}
+
+	query := url.Values{"format": {*format}}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+	var manifest []byte
+	if err := c.Do(ctx, "GET", "/downloads/export?"+query.Encode(), nil, &manifest); err != nil {
+		return nil, err
+	}
+	if *out == "" {
+		return rawOutput(manifest), nil
+	}
+	// The manifest holds full URLs and request bodies
+	if err := os.WriteFile(*out, manifest, 0600); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"changed": true, "file": *out}, nil
+}
+
+func backupCommand(ctx context.Context, c *Client, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	out := fs.String("out", "", "")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError("%v", err)
+	}
+	if *out == "" {
+		return nil, usageError("backup needs --out")
+	}
+
+	var archive []byte
+	if err := c.Do(ctx, "GET", "/backup", nil, &archive); err != nil {
+		return nil, err
+	}
+	// Write next to the target first so a failed backup never replaces a good one
+	tmp := *out + ".partial"
+	if err := os.WriteFile(tmp, archive, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return map[string]interface{}{"changed": true, "file": *out, "size_bytes": len(archive)}, nil
+}
+
+// SettingsResult reports what settings set did
+type SettingsResult struct {
+	Changed  bool              `json:"changed"`
+	Changes  []settings.Change `json:"changes"`
+	Settings openapi.Settings  `json:"settings"`
+}
+
+// SetSettings applies name=value assignments. Only settings that differ
+// from the server's are sent, so running it twice changes nothing and adds
+// nothing to the audit log.
+func SetSettings(ctx context.Context, c *Client, assignments []string) (*SettingsResult, error) {
+	if len(assignments) == 0 {
+		return nil, usageError("settings set needs at least one name=value")
+	}
+	var current openapi.Settings
+	if err := c.Do(ctx, "GET", "/settings", nil, &current); err != nil {
+		return nil, err
+	}
+	before := fromAPI(current)
+
+	values := before.Values()
+	for _, assignment := range assignments {
+		pair := strings.SplitN(assignment, "=", 2)
+		if _, known := values[pair[0]]; len(pair) != 2 || !known {
+			return nil, usageError("%q is not name=value for one of %s", assignment, strings.Join(settings.Names, ", "))
+		}
+		values[pair[0]] = pair[1]
+	}
+	after, err := settings.Parse(values)
+	if err != nil {
+		return nil, usageError("%v", err)
+	}
+
+	changes := settings.Diff(before, after)
+	result := &SettingsResult{Changed: len(changes) > 0, Changes: changes, Settings: current}
+	if len(changes) == 0 {
+		return result, nil
+	}
+	update := openapi.SettingsUpdate{}
+	for _, change := range changes {
+		switch change.Name {
+		case settings.GlobalRateLimit:
+			update.GlobalRateLimit = &after.GlobalRateLimit
+		case settings.MaxConcurrentDownloads:
+			update.MaxConcurrentDownloads = &after.MaxConcurrentDownloads
+		case settings.DefaultThreads:
+			update.DefaultThreads = &after.DefaultThreads
+		case settings.RetentionDays:
+			update.RetentionDays = &after.RetentionDays
+		case settings.MinPartSize:
+			update.MinPartSize = &after.MinPartSize
+		}
+	}
+	if err := c.Do(ctx, "PATCH", "/settings", update, &result.Settings); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func fromAPI(s openapi.Settings) settings.Settings {
+	return settings.Settings{
+		GlobalRateLimit:        s.GlobalRateLimit,
+		MaxConcurrentDownloads: s.MaxConcurrentDownloads,
+		DefaultThreads:         s.DefaultThreads,
+		RetentionDays:          s.RetentionDays,
+		MinPartSize:            s.MinPartSize,
+	}
+}
+
+func keyGenerateCommand(args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("key generate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	out := fs.String("out", "", "")
+	force := fs.Bool("force", false, "")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError("%v", err)
+	}
+	if *out == "" {
+		return nil, usageError("key generate needs --out")
+	}
+	changed, err := GenerateKeyFile(*out, *force)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"changed": changed, "file": *out}, nil
+}
+
+// GenerateKeyFile writes a new master key for SECRETS_MASTER_KEY_FILE to
+// path, readable only by its owner. A file that already holds a valid key is
+// kept unless force is set, because replacing the key makes every sealed
+// secret unreadable. It reports whether it wrote a key.
+func GenerateKeyFile(path string, force bool) (bool, error) {
+	if !force {
+		if _, err := secrets.LoadBox(path); err == nil {
+			return false, nil
+		} else if _, statErr := os.Stat(path); statErr == nil {
+			return false, fmt.Errorf("%s exists but is not a valid master key (%v); use --force to replace it", path, err)
+		}
+	}
+
+	key := make([]byte, secrets.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return false, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
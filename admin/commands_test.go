@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/secrets"
+)
+
+// settingsServer serves GET and PATCH /api/v2/settings and counts the PATCHes
+func settingsServer(t *testing.T, current *openapi.Settings, patches *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/settings" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Not found"}`))
+			return
+		}
+		if r.Method == "PATCH" {
+			*patches++
+			var update openapi.SettingsUpdate
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				t.Errorf("PATCH body: %v", err)
+			}
+			if update.DefaultThreads != nil {
+				current.DefaultThreads = *update.DefaultThreads
+			}
+			if update.RetentionDays != nil {
+				current.RetentionDays = *update.RetentionDays
+			}
+			if update.GlobalRateLimit != nil || update.MinPartSize != nil || update.MaxConcurrentDownloads != nil {
+				t.Errorf("PATCH sent unchanged settings: %+v", update)
+			}
+		}
+		json.NewEncoder(w).Encode(current)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSettingsSetIsIdempotent(t *testing.T) {
+	current := openapi.Settings{DefaultThreads: 4, RetentionDays: 7, MinPartSize: 1 << 20}
+	patches := 0
+	server := settingsServer(t, &current, &patches)
+
+	for i, wantChanged := range []bool{true, false} {
+		var stdout, stderr bytes.Buffer
+		code := Run([]string{"--url", server.URL, "settings", "set", "default_threads=8", "retention_days=30", "min_part_size=1048576"}, &stdout, &stderr)
+		if code != ExitOK {
+			t.Fatalf("run %d: exit %d, stderr %s", i, code, stderr.String())
+		}
+		var result SettingsResult
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			t.Fatalf("run %d: output %q: %v", i, stdout.String(), err)
+		}
+		if result.Changed != wantChanged {
+			t.Errorf("run %d: changed = %v, want %v", i, result.Changed, wantChanged)
+		}
+		if result.Settings.DefaultThreads != 8 || result.Settings.RetentionDays != 30 {
+			t.Errorf("run %d: settings = %+v", i, result.Settings)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("sent %d PATCH requests, want 1", patches)
+	}
+}
+
+func TestTokenIsSent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Admin token required"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"healthy"}`))
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"--url", server.URL, "health"}, &stdout, &stderr); code != ExitError || !strings.Contains(stderr.String(), "HTTP 401") {
+		t.Errorf("without a token: exit %d, stderr %q", code, stderr.String())
+	}
+	t.Setenv("MTDL_ADMIN_TOKEN", "s3cret")
+	stderr.Reset()
+	if code := Run([]string{"--url", server.URL, "health"}, &stdout, &stderr); code != ExitOK {
+		t.Errorf("with MTDL_ADMIN_TOKEN: exit %d, stderr %q", code, stderr.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	current := openapi.Settings{DefaultThreads: 4}
+	patches := 0
+	server := settingsServer(t, &current, &patches)
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{args: []string{"settings", "set", "threads=8"}, code: ExitUsage, want: "not name=value"},
+		{args: []string{"settings", "set", "default_threads=eight"}, code: ExitUsage, want: "invalid setting"},
+		{args: []string{"settings", "frobnicate"}, code: ExitUsage, want: "unknown command"},
+		{args: []string{"queue", "stats"}, code: ExitError, want: "Not found (HTTP 404)"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := Run(append([]string{"--url", server.URL}, tt.args...), &stdout, &stderr)
+		if code != tt.code || !strings.Contains(stderr.String(), tt.want) {
+			t.Errorf("Run(%v) = %d, stderr %q; want %d containing %q", tt.args, code, stderr.String(), tt.code, tt.want)
+		}
+	}
+	if patches != 0 {
+		t.Errorf("failed commands sent %d PATCH requests", patches)
+	}
+}
+
+func TestGenerateKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+
+	changed, err := GenerateKeyFile(path, false)
+	if err != nil || !changed {
+		t.Fatalf("GenerateKeyFile() = %v, %v", changed, err)
+	}
+	first, _ := os.ReadFile(path)
+	if _, err := secrets.LoadBox(path); err != nil {
+		t.Fatalf("generated key does not load: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	changed, err = GenerateKeyFile(path, false)
+	if second, _ := os.ReadFile(path); err != nil || changed || !bytes.Equal(first, second) {
+		t.Errorf("second GenerateKeyFile() = %v, %v; replaced the key", changed, err)
+	}
+
+	os.WriteFile(path, []byte("not a key"), 0600)
+	if _, err := GenerateKeyFile(path, false); err == nil {
+		t.Error("GenerateKeyFile() replaced an invalid key file without --force")
+	}
+	if changed, err := GenerateKeyFile(path, true); err != nil || !changed {
+		t.Errorf("GenerateKeyFile(force) = %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,420 @@
+// Package agent runs the downloader as a long-lived desktop agent. Other
+// commands hand it downloads over a Unix socket; it records them in the job
+// registry at once, even while the host is offline, and runs them one after
+// the other whenever the network is usable. Downloads it was running when it
+// stopped are taken up again when it starts.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/listener"
+	"multithreaded-downloader/netwatch"
+	"multithreaded-downloader/registry"
+)
+
+// SocketName is the name of the agent's socket in the mtdl home directory
+const SocketName = "agent.sock"
+
+// DefaultSocket returns the agent socket: $MTDL_HOME/agent.sock when set
+// and ~/.mtdl/agent.sock otherwise, next to the job registry
+func DefaultSocket() (string, error) {
+	dir, err := registry.DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dir), SocketName), nil
+}
+
+// Request is a download handed to the agent
+type Request struct {
+	URL string `json:"url"`
+	// Output is the absolute path of the file to write
+	Output    string `json:"output"`
+	Threads   int    `json:"threads,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Referer   string `json:"referer,omitempty"`
+	// AllowMetered lets this download run on a metered network
+	AllowMetered bool `json:"allow_metered,omitempty"`
+}
+
+// Validate checks that the agent can run the request
+func (r *Request) Validate() error {
+	parsed, err := url.Parse(r.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	if r.Output == "" || !filepath.IsAbs(r.Output) {
+		return fmt.Errorf("output must be an absolute path")
+	}
+	if downloader.HasOutputTemplate(r.Output) {
+		return fmt.Errorf("output templates are not supported by the agent")
+	}
+	if r.Threads < 0 {
+		return fmt.Errorf("threads cannot be negative")
+	}
+	return nil
+}
+
+// Status describes what the agent is doing
+type Status struct {
+	Network netwatch.State `json:"network"`
+	Running int            `json:"running"`
+	Queued  int            `json:"queued"`
+}
+
+// Runner runs one download to its end, recording the outcome in its job.
+// It returns when ctx ends, with the progress saved.
+type Runner func(ctx context.Context, job *registry.Job) error
+
+// Options configure an agent
+type Options struct {
+	// Concurrency is how many downloads run at once, 1 by default
+	Concurrency int
+	// AllowMetered runs every download on metered networks
+	AllowMetered bool
+	// Check reports the network; netwatch.Probe("") by default
+	Check netwatch.Checker
+	// Interval is how often the network is checked
+	Interval time.Duration
+	// Logf reports what the agent does; log.Printf style
+	Logf func(format string, args ...interface{})
+}
+
+// Agent queues downloads in the registry and runs them while the network
+// is usable
+type Agent struct {
+	registry *registry.Registry
+	run      Runner
+	opts     Options
+
+	mu      sync.Mutex
+	network netwatch.State
+	// running maps the jobs being run to the functions that stop them
+	running map[string]context.CancelFunc
+	wake    chan struct{}
+}
+
+// New creates an agent that keeps its jobs in reg and runs them with run
+func New(reg *registry.Registry, run Runner, opts Options) *Agent {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Check == nil {
+		opts.Check = netwatch.Probe("")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = netwatch.DefaultInterval
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	return &Agent{
+		registry: reg,
+		run:      run,
+		opts:     opts,
+		network:  netwatch.Offline,
+		running:  make(map[string]context.CancelFunc),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Submit records a download as queued and returns its job; the agent
+// starts it once the network allows
+func (a *Agent) Submit(req Request) (*registry.Job, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	existing, err := a.registry.Find(req.URL, req.Output)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("%w: %s is already job %s", ErrDuplicate, req.Output, existing.ShortID())
+	}
+
+	threads := req.Threads
+	if threads == 0 {
+		threads = 4
+	}
+	job, err := a.registry.New(req.URL, req.Output, registry.Options{
+		Threads:         threads,
+		MinPartSize:     downloader.DefaultMinPartSize,
+		ChecksumRetries: downloader.DefaultChecksumRetries,
+		UserAgent:       req.UserAgent,
+		Referer:         req.Referer,
+		AllowMetered:    req.AllowMetered,
+		ThreadsDefault:  req.Threads == 0,
+	})
+	if err != nil {
+		return nil, err
+	}
+	job.Status = lifecycle.Queued
+	job.Agent = true
+	if err := a.registry.Save(job); err != nil {
+		return nil, err
+	}
+	a.opts.Logf("Queued job %s: %s -> %s", job.ShortID(), req.URL, job.Output)
+	a.poke()
+	return job, nil
+}
+
+// ErrDuplicate is returned for a download of a URL to an output that is
+// already registered and not finished
+var ErrDuplicate = errors.New("download already registered")
+
+// poke makes Run look for jobs to start
+func (a *Agent) poke() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns what the agent is doing
+func (a *Agent) Status() (Status, error) {
+	a.mu.Lock()
+	status := Status{Network: a.network, Running: len(a.running)}
+	a.mu.Unlock()
+
+	jobs, err := a.Jobs()
+	if err != nil {
+		return status, err
+	}
+	for _, job := range jobs {
+		if job.Status == lifecycle.Queued {
+			status.Queued++
+		}
+	}
+	return status, nil
+}
+
+// Jobs returns the jobs submitted to the agent, oldest first
+func (a *Agent) Jobs() ([]*registry.Job, error) {
+	jobs, err := a.registry.List()
+	if err != nil {
+		return nil, err
+	}
+	var own []*registry.Job
+	for _, job := range jobs {
+		if job.Agent {
+			own = append(own, job)
+		}
+	}
+	return own, nil
+}
+
+// Run starts queued downloads while the network is usable until ctx ends,
+// then waits for the running ones to stop
+func (a *Agent) Run(ctx context.Context) error {
+	if err := a.requeueInterrupted(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	done := make(chan string)
+	states := netwatch.Watch(ctx, a.opts.Interval, a.opts.Check)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case state, ok := <-states:
+			if !ok {
+				return nil
+			}
+			a.mu.Lock()
+			changed := a.network != state
+			a.network = state
+			a.mu.Unlock()
+			if changed {
+				a.opts.Logf("Network %s", state)
+			}
+		case <-a.wake:
+		case id := <-done:
+			a.mu.Lock()
+			delete(a.running, id)
+			a.mu.Unlock()
+		}
+		if err := a.startJobs(ctx, &wg, done); err != nil {
+			a.opts.Logf("Failed to start queued downloads: %v", err)
+		}
+	}
+}
+
+// Stop stops one of the downloads the agent is running, with its progress
+// saved, and reports whether it was running
+func (a *Agent) Stop(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stop, ok := a.running[id]
+	if ok {
+		stop()
+	}
+	return ok
+}
+
+// requeueInterrupted queues the agent's jobs again that were running or
+// waiting for the network when the agent last stopped
+func (a *Agent) requeueInterrupted() error {
+	jobs, err := a.Jobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.Status == lifecycle.Queued || job.Running() || job.State() != lifecycle.Paused {
+			continue
+		}
+		job.Status = lifecycle.Queued
+		job.PID = 0
+		if err := a.registry.Save(job); err != nil {
+			return err
+		}
+		a.opts.Logf("Resuming job %s", job.ShortID())
+	}
+	return nil
+}
+
+// startJobs starts the oldest queued jobs the network allows, up to the
+// concurrency
+func (a *Agent) startJobs(ctx context.Context, wg *sync.WaitGroup, done chan<- string) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	jobs, err := a.Jobs()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, job := range jobs {
+		if len(a.running) >= a.opts.Concurrency {
+			return nil
+		}
+		if _, ok := a.running[job.ID]; ok || job.Status != lifecycle.Queued {
+			continue
+		}
+		if !a.network.Usable(a.opts.AllowMetered || job.Options.AllowMetered) {
+			continue
+		}
+
+		jobCtx, stop := context.WithCancel(ctx)
+		a.running[job.ID] = stop
+		wg.Add(1)
+		go func(job *registry.Job) {
+			defer wg.Done()
+			defer stop()
+			a.opts.Logf("Starting job %s: %s", job.ShortID(), job.Output)
+			if err := a.run(jobCtx, job); err != nil && jobCtx.Err() == nil {
+				a.opts.Logf("Job %s failed: %v", job.ShortID(), err)
+			} else if err == nil {
+				a.opts.Logf("Job %s completed", job.ShortID())
+			}
+			select {
+			case done <- job.ID:
+			case <-ctx.Done():
+			}
+		}(job)
+	}
+	return nil
+}
+
+// Listen opens the agent socket at path, readable and writable by the user
+// only. A socket left behind by an agent that died is replaced; one that
+// still answers means another agent is running.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	return listener.Unix(path, 0600)
+}
+
+// Handler serves the agent API:
+//
+//	POST /downloads   queue a Request, 202 with the job
+//	GET  /downloads   the agent's jobs
+//	POST /stop?id=ID  stop a running download, 404 if it is not running
+//	GET  /status      the agent Status
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/downloads", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var req Request
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid request format", err)
+				return
+			}
+			job, err := a.Submit(req)
+			switch {
+			case errors.Is(err, ErrDuplicate):
+				writeError(w, http.StatusConflict, "Download already registered", err)
+			case err != nil && req.Validate() != nil:
+				writeError(w, http.StatusBadRequest, "Invalid request", err)
+			case err != nil:
+				writeError(w, http.StatusInternalServerError, "Failed to queue download", err)
+			default:
+				writeJSON(w, http.StatusAccepted, job)
+			}
+		case http.MethodGet:
+			jobs, err := a.Jobs()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to list downloads", err)
+				return
+			}
+			if jobs == nil {
+				jobs = []*registry.Job{}
+			}
+			writeJSON(w, http.StatusOK, jobs)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		}
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+			return
+		}
+		if !a.Stop(r.URL.Query().Get("id")) {
+			writeError(w, http.StatusNotFound, "Download is not running", nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := a.Status()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to read status", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string, err error) {
+	body := map[string]string{"error": message}
+	if err != nil {
+		body["details"] = err.Error()
+	}
+	writeJSON(w, code, body)
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/netwatch"
+	"multithreaded-downloader/registry"
+)
+
+// network is a netwatch.Checker the test switches
+type network struct {
+	mu    sync.Mutex
+	state netwatch.State
+}
+
+func (n *network) set(state netwatch.State) {
+	n.mu.Lock()
+	n.state = state
+	n.mu.Unlock()
+}
+
+func (n *network) check(context.Context) netwatch.State {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state
+}
+
+func TestAgentQueuesWhileOfflineOverSocket(t *testing.T) {
+	dir := t.TempDir()
+	reg, err := registry.Open(filepath.Join(dir, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := &network{state: netwatch.Offline}
+	started := make(chan string, 4)
+	run := func(ctx context.Context, job *registry.Job) error {
+		started <- job.ID
+		job.Status = lifecycle.Completed
+		return reg.Save(job)
+	}
+	a := New(reg, run, Options{Check: link.check, Interval: time.Millisecond})
+
+	listener, err := Listen(filepath.Join(dir, SocketName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: a.Handler()}
+	go server.Serve(listener)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx)
+
+	client := NewClient(filepath.Join(dir, SocketName))
+	output := filepath.Join(dir, "file.bin")
+	job, err := client.Submit(ctx, Request{URL: "https://example.com/file.bin", Output: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != lifecycle.Queued || !job.Agent || job.Options.Threads != 4 {
+		t.Errorf("submitted job = %+v", job)
+	}
+	if _, err := client.Submit(ctx, Request{URL: "https://example.com/file.bin", Output: output}); err == nil {
+		t.Error("second Submit() of the same download succeeded")
+	}
+	if _, err := client.Submit(ctx, Request{URL: "ftp://example.com/x", Output: output}); err == nil {
+		t.Error("Submit() accepted an ftp URL")
+	}
+
+	select {
+	case id := <-started:
+		t.Fatalf("job %s started while offline", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if status, err := client.Status(ctx); err != nil || status.Queued != 1 || status.Network != netwatch.Offline {
+		t.Errorf("Status() = %+v, %v", status, err)
+	}
+
+	link.set(netwatch.Metered)
+	select {
+	case id := <-started:
+		t.Fatalf("job %s started on a metered network", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	link.set(netwatch.Online)
+	select {
+	case id := <-started:
+		if id != job.ID {
+			t.Errorf("started %s, want %s", id, job.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job not started once online")
+	}
+}
+
+func TestRequeueInterrupted(t *testing.T) {
+	reg, err := registry.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(status lifecycle.Status, agent bool) *registry.Job {
+		job, err := reg.New("https://example.com/"+string(status), filepath.Join(t.TempDir(), "out"), registry.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Status, job.Agent = status, agent
+		if err := reg.Save(job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	interrupted := save(lifecycle.Downloading, true)
+	paused := save(lifecycle.Paused, true)
+	done := save(lifecycle.Completed, true)
+	notOurs := save(lifecycle.Paused, false)
+
+	a := New(reg, func(context.Context, *registry.Job) error { return errors.New("not run") }, Options{})
+	if err := a.requeueInterrupted(); err != nil {
+		t.Fatal(err)
+	}
+	for job, want := range map[*registry.Job]lifecycle.Status{
+		interrupted: lifecycle.Queued,
+		paused:      lifecycle.Queued,
+		done:        lifecycle.Completed,
+		notOurs:     lifecycle.Paused,
+	} {
+		got, err := reg.Get(job.ID)
+		if err != nil || got.Status != want {
+			t.Errorf("job saved as %s: status %v, %v; want %s", job.Status, got.Status, err, want)
+		}
+	}
+}
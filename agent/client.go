@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"multithreaded-downloader/registry"
+)
+
+// Client talks to an agent over its socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a client for the agent listening on socket
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+// Submit hands a download to the agent and returns its job
+func (c *Client) Submit(ctx context.Context, req Request) (*registry.Job, error) {
+	var job registry.Job
+	if err := c.do(ctx, http.MethodPost, "/downloads", req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Jobs returns the jobs submitted to the agent
+func (c *Client) Jobs(ctx context.Context) ([]*registry.Job, error) {
+	var jobs []*registry.Job
+	if err := c.do(ctx, http.MethodGet, "/downloads", nil, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Stop stops a download the agent is running; its progress is saved
+func (c *Client) Stop(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/stop?id="+url.QueryEscape(id), nil, nil)
+}
+
+// Status returns what the agent is doing
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	// The host is ignored; every request goes to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://agent"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent not reachable, start it with the agent command: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error   string `json:"error"`
+			Details string `json:"details"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Details != "" {
+			return fmt.Errorf("%s: %s", apiErr.Error, apiErr.Details)
+		}
+		return fmt.Errorf("agent answered %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
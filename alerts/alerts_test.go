@@ -0,0 +1,255 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/events"
+)
+
+func TestEngine(t *testing.T) {
+	var sent []Notification
+	engine := NewEngine("queue-1", []Rule{
+		{Name: "backlog", Metric: QueueDepth, Op: ">", Threshold: 100, For: 10 * time.Minute},
+		{Name: "slow", Metric: AverageSpeed, Op: "<", Threshold: 1000},
+	}, NotifierFunc(func(ctx context.Context, n Notification) error {
+		sent = append(sent, n)
+		return nil
+	}))
+
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	steps := []struct {
+		after   time.Duration
+		metrics map[string]float64
+		states  string
+		sent    string
+	}{
+		{0, map[string]float64{QueueDepth: 150}, "pending,ok", ""},
+		{5 * time.Minute, map[string]float64{QueueDepth: 150, AverageSpeed: 500}, "pending,firing", "slow:firing"},
+		{10 * time.Minute, map[string]float64{QueueDepth: 120, AverageSpeed: 500}, "firing,firing", "backlog:firing"},
+		{11 * time.Minute, map[string]float64{QueueDepth: 120}, "firing,ok", "slow:resolved"},
+		{12 * time.Minute, map[string]float64{QueueDepth: 10}, "ok,ok", "backlog:resolved"},
+	}
+	for _, step := range steps {
+		sent = nil
+		engine.Evaluate(context.Background(), start.Add(step.after), step.metrics)
+		statuses, _ := engine.Statuses()
+		var states, notified []string
+		for _, s := range statuses {
+			states = append(states, string(s.State))
+		}
+		for _, n := range sent {
+			notified = append(notified, n.Rule.Name+":"+string(n.State))
+		}
+		if got := strings.Join(states, ","); got != step.states {
+			t.Errorf("after %s: states %s, want %s", step.after, got, step.states)
+		}
+		if got := strings.Join(notified, ","); got != step.sent {
+			t.Errorf("after %s: sent %s, want %s", step.after, got, step.sent)
+		}
+	}
+
+	alerts := engine.Alerts()
+	if alerts.Firing != 0 || len(alerts.Alerts) != 2 || alerts.EvaluatedAt != "2026-10-16T09:12:00Z" {
+		t.Errorf("alerts = %+v", alerts)
+	}
+	if alerts.Alerts[0].ForSeconds != 600 || alerts.Alerts[1].Value != nil {
+		t.Errorf("alerts = %+v", alerts.Alerts)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	rules := "rules:\n  - name: failing\n    metric: failure_rate\n    op: '>'\n    threshold: 20\n    for: 5m\n    severity: critical\n"
+	os.WriteFile(path, []byte(rules), 0644)
+	loaded, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].For != 5*time.Minute || loaded[0].String() != "failure_rate > 20 for 5m0s" {
+		t.Errorf("loaded %+v", loaded)
+	}
+
+	for _, bad := range []string{
+		"rules:\n  - name: x\n    metric: disk\n    op: '>'\n",
+		"rules:\n  - name: x\n    metric: queue_depth\n    op: '=='\n",
+		"rules:\n  - name: x\n    metric: queue_depth\n    op: '>'\n  - name: x\n    metric: queue_depth\n    op: '<'\n",
+		"rules:\n  - name: x\n    metric: queue_depth\n    op: '>'\n    window: 5m\n",
+		"rules: []\n",
+	} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := LoadRules(path); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	if rules, err := LoadRules(""); err != nil || rules != nil {
+		t.Errorf("no file: %v, %v", rules, err)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	for _, e := range []events.Event{
+		{Type: events.Created, DownloadID: "a", Time: start},
+		{Type: events.Created, DownloadID: "b", Time: start},
+		{Type: events.Started, DownloadID: "a", Time: start.Add(10 * time.Second)},
+		{Type: events.Started, DownloadID: "b", Time: start.Add(30 * time.Second)},
+		{Type: events.Completed, DownloadID: "a", Time: start.Add(time.Minute)},
+		{Type: events.Failed, DownloadID: "b", Time: start.Add(time.Minute)},
+		{Type: events.Failed, DownloadID: "c", Time: start.Add(20 * time.Minute)},
+	} {
+		tracker.Handle(e)
+	}
+
+	metrics := tracker.Metrics(start.Add(2 * time.Minute))
+	if metrics[QueueWait] != 20 || metrics[FailureRate] != 100*2/3.0 {
+		t.Errorf("metrics = %v", metrics)
+	}
+	// a and b fell out of the window
+	metrics = tracker.Metrics(start.Add(30 * time.Minute))
+	if _, ok := metrics[QueueWait]; ok || metrics[FailureRate] != 100 {
+		t.Errorf("metrics = %v", metrics)
+	}
+
+	AddSpeeds(metrics, nil)
+	if _, ok := metrics[AverageSpeed]; ok || metrics[TotalSpeed] != 0 {
+		t.Errorf("speeds with nothing running = %v", metrics)
+	}
+	AddSpeeds(metrics, []events.Stats{{Speed: 100}, {Speed: 300}})
+	if metrics[AverageSpeed] != 200 || metrics[TotalSpeed] != 400 {
+		t.Errorf("speeds = %v", metrics)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var body webhookBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	n := Notification{
+		Rule:  Rule{Name: "backlog", Metric: QueueDepth, Op: ">", Threshold: 100, For: 10 * time.Minute},
+		State: Firing,
+		Value: 140,
+		Time:  time.Now(),
+		Node:  "queue-1",
+	}
+	if err := (&Webhook{URL: server.URL}).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if body.Text != "[FIRING] backlog: queue_depth > 100 for 10m0s (value 140) on queue-1" || body.ForSeconds != 600 {
+		t.Errorf("posted %+v", body)
+	}
+	if err := (&Webhook{URL: server.URL + "/down"}).Notify(context.Background(), n); err == nil {
+		t.Error("502 from the webhook not reported")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	w := NewWatchdog(10*time.Minute, 1000)
+	w.MaxNodes = 6
+	if _, ok := w.Last(); ok {
+		t.Fatal("Last() before any sample")
+	}
+
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	steps := []struct {
+		after   time.Duration
+		sample  Sample
+		covered bool
+		stalled bool
+		desired int
+	}{
+		// Two nodes running four jobs each are busy, not stalled
+		{0, Sample{QueueDepth: 4, Running: 8, Speed: 5e6, Nodes: 2, Slots: 8}, false, false, 3},
+		{10 * time.Minute, Sample{QueueDepth: 10, Running: 8, Speed: 5e6, Finished: 3, Nodes: 2, Slots: 8}, true, false, 5},
+		// The workers stop moving bytes, but a job finished in the window
+		{15 * time.Minute, Sample{QueueDepth: 12, Running: 8, Finished: 4, Nodes: 2, Slots: 8}, true, false, 5},
+		{26 * time.Minute, Sample{QueueDepth: 14, Running: 8, Finished: 4, Nodes: 2, Slots: 8}, true, true, 6},
+		// The nodes died: a node per job, up to MaxNodes
+		{30 * time.Minute, Sample{QueueDepth: 15, Finished: 4}, true, true, 6},
+		{41 * time.Minute, Sample{QueueDepth: 0, Running: 0, Finished: 4}, true, false, 1},
+	}
+	var since time.Time
+	for _, step := range steps {
+		step.sample.Time = start.Add(step.after)
+		hint := w.Observe(step.sample)
+		if hint.Covered != step.covered || hint.Stalled != step.stalled || hint.DesiredNodes != step.desired {
+			t.Errorf("after %s: covered %v, stalled %v, desired %d; want %v, %v, %d", step.after, hint.Covered, hint.Stalled, hint.DesiredNodes, step.covered, step.stalled, step.desired)
+		}
+		if hint.Stalled && since.IsZero() {
+			since = hint.StalledSince
+		}
+	}
+	if want := start.Add(26 * time.Minute); !since.Equal(want) {
+		t.Errorf("stalled since %s, want %s", since, want)
+	}
+
+	w.Observe(Sample{Time: start.Add(52 * time.Minute), QueueDepth: 3, Finished: 4})
+	metrics := map[string]float64{}
+	hint, _ := w.Last()
+	hint.AddMetrics(metrics)
+	if metrics[QueueGrowth] != 3 || metrics[QueueStalled] != 1 || metrics[DesiredWorkers] != 3 {
+		t.Errorf("metrics = %v", metrics)
+	}
+	api := w.API()
+	if !api.Stalled || api.StalledSince != "2026-10-16T09:52:00Z" || api.QueueGrowth == nil || *api.QueueGrowth != 3 || api.WindowSeconds != 600 {
+		t.Errorf("API() = %+v", api)
+	}
+
+	var out strings.Builder
+	if err := w.WritePrometheus(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"mtdl_queue_depth 3\n", "mtdl_queue_stalled 1\n", "mtdl_desired_workers 3\n", "# TYPE mtdl_queue_growth gauge\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Prometheus output lacks %q:\n%s", line, out.String())
+		}
+	}
+
+	// Idle nodes that take no jobs get one more beside them
+	stuck := NewWatchdog(time.Minute, 0)
+	stuck.Observe(Sample{Time: start, QueueDepth: 2, Nodes: 4, Slots: 16})
+	if hint := stuck.Observe(Sample{Time: start.Add(time.Minute), QueueDepth: 3, Nodes: 4, Slots: 16}); !hint.Stalled || hint.DesiredNodes != 5 {
+		t.Errorf("stuck nodes: %+v", hint)
+	}
+
+	rule := w.StalledRule()
+	if err := Validate([]Rule{rule}); err != nil || !rule.Holds(1) || rule.Holds(0) {
+		t.Errorf("stalled rule %+v: %v", rule, err)
+	}
+}
+
+func TestScaleWebhook(t *testing.T) {
+	var body scaleBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	w := NewWatchdog(time.Minute, 0)
+	w.Observe(Sample{Time: time.Now(), QueueDepth: 9, Running: 3, Nodes: 1, Slots: 3})
+	if err := (&Webhook{URL: server.URL}).Scale(context.Background(), "queue-1", 1, w.API()); err != nil {
+		t.Fatal(err)
+	}
+	if body.Text != "[SCALE] desired workers 1 -> 4: 9 queued, 3 running" || body.Scaling.DesiredWorkers != 4 || body.Node != "queue-1" {
+		t.Errorf("posted %+v", body)
+	}
+}
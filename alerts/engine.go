@@ -0,0 +1,196 @@
+package alerts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+// State is where a rule stands
+type State string
+
+const (
+	// OK rules do not hold
+	OK State = "ok"
+	// Pending rules hold, but not for their For yet
+	Pending State = "pending"
+	// Firing rules held for their For and were notified
+	Firing State = "firing"
+	// Resolved is only sent to notifiers, when a firing rule stops holding
+	Resolved State = "resolved"
+)
+
+// Status is the state of one rule after the last evaluation
+type Status struct {
+	Rule  Rule
+	State State
+	// Value is the metric at the last evaluation; HasValue is false when
+	// the server did not report it
+	Value    float64
+	HasValue bool
+	// Since is when the rule started holding, and FiredAt when it fired
+	Since   time.Time
+	FiredAt time.Time
+}
+
+// API describes the status as the API reports it
+func (s Status) API() openapi.AlertStatus {
+	status := openapi.AlertStatus{
+		Name:        s.Rule.Name,
+		Metric:      s.Rule.Metric,
+		Op:          s.Rule.Op,
+		Threshold:   s.Rule.Threshold,
+		ForSeconds:  int64(s.Rule.For / time.Second),
+		Severity:    s.Rule.Severity,
+		Description: s.Rule.Description,
+		State:       string(s.State),
+	}
+	if s.HasValue {
+		value := s.Value
+		status.Value = &value
+	}
+	if !s.Since.IsZero() {
+		status.Since = s.Since.UTC().Format(time.RFC3339)
+	}
+	if !s.FiredAt.IsZero() {
+		status.FiredAt = s.FiredAt.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// Notification tells a notifier that a rule started or stopped firing
+type Notification struct {
+	Rule Rule
+	// State is Firing or Resolved
+	State State
+	Value float64
+	Time  time.Time
+	// Node is the server that evaluated the rule
+	Node string
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierFunc is a function used as a Notifier
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+// Notify calls f
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+// NotifyTimeout bounds each notification
+const NotifyTimeout = 10 * time.Second
+
+// Engine evaluates rules against metrics and notifies when they fire and
+// resolve. It is safe for concurrent use.
+type Engine struct {
+	node      string
+	notifiers []Notifier
+	// OnError is called for notifications that could not be delivered
+	OnError func(n Notification, err error)
+
+	mu          sync.Mutex
+	statuses    []Status
+	evaluatedAt time.Time
+}
+
+// NewEngine creates an engine for rules, which Validate accepted, on the
+// server named node
+func NewEngine(node string, rules []Rule, notifiers ...Notifier) *Engine {
+	statuses := make([]Status, len(rules))
+	for i, rule := range rules {
+		statuses[i] = Status{Rule: rule, State: OK}
+	}
+	return &Engine{node: node, notifiers: notifiers, statuses: statuses}
+}
+
+// Evaluate updates every rule with metrics measured at now and sends the
+// notifications of the rules that started or stopped firing
+func (e *Engine) Evaluate(ctx context.Context, now time.Time, metrics map[string]float64) {
+	var notifications []Notification
+	e.mu.Lock()
+	e.evaluatedAt = now
+	for i := range e.statuses {
+		s := &e.statuses[i]
+		s.Value, s.HasValue = metrics[s.Rule.Metric]
+		holds := s.HasValue && s.Rule.Holds(s.Value)
+
+		switch {
+		case !holds:
+			if s.State == Firing {
+				notifications = append(notifications, Notification{Rule: s.Rule, State: Resolved, Value: s.Value, Time: now, Node: e.node})
+			}
+			s.State, s.Since, s.FiredAt = OK, time.Time{}, time.Time{}
+		case s.State == OK:
+			s.State, s.Since = Pending, now
+			fallthrough
+		case s.State == Pending:
+			if now.Sub(s.Since) >= s.Rule.For {
+				s.State, s.FiredAt = Firing, now
+				notifications = append(notifications, Notification{Rule: s.Rule, State: Firing, Value: s.Value, Time: now, Node: e.node})
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, n := range notifications {
+		for _, notifier := range e.notifiers {
+			notifyCtx, cancel := context.WithTimeout(ctx, NotifyTimeout)
+			err := notifier.Notify(notifyCtx, n)
+			cancel()
+			if err != nil && e.OnError != nil {
+				e.OnError(n, err)
+			}
+		}
+	}
+}
+
+// Statuses returns the state of every rule, in the order they were given,
+// and when they were last evaluated
+func (e *Engine) Statuses() ([]Status, time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Status(nil), e.statuses...), e.evaluatedAt
+}
+
+// Alerts describes the rules as the API reports them
+func (e *Engine) Alerts() openapi.Alerts {
+	statuses, evaluatedAt := e.Statuses()
+	alerts := openapi.Alerts{Alerts: make([]openapi.AlertStatus, len(statuses))}
+	for i, s := range statuses {
+		alerts.Alerts[i] = s.API()
+		if s.State == Firing {
+			alerts.Firing++
+		}
+	}
+	if !evaluatedAt.IsZero() {
+		alerts.EvaluatedAt = evaluatedAt.UTC().Format(time.RFC3339)
+	}
+	return alerts
+}
+
+// Run evaluates the rules every interval with the metrics source measures
+// until ctx ends. Failed measurements are passed to onError and skipped.
+func (e *Engine) Run(ctx context.Context, interval time.Duration, source func(ctx context.Context) (map[string]float64, error), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			metrics, err := source(ctx)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			e.Evaluate(ctx, now, metrics)
+		}
+	}
+}
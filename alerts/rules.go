@@ -0,0 +1,219 @@
+// Package alerts watches service levels: rules such as "more than 100 jobs
+// queued for 10 minutes" or "over 20% of downloads failing" are evaluated
+// against the metrics a server reports, and rules that start or stop firing
+// are sent to notifiers such as a webhook.
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Metrics rules can watch. A server reports the ones it knows; a rule whose
+// metric has no value, such as the average speed while nothing runs, does
+// not hold.
+const (
+	// QueueDepth is how many downloads wait for a worker or a slot
+	QueueDepth = "queue_depth"
+	// RunningDownloads is how many downloads are transferring
+	RunningDownloads = "running_downloads"
+	// AverageSpeed is the mean speed of the running downloads in bytes per
+	// second
+	AverageSpeed = "average_speed"
+	// TotalSpeed is the speed of all running downloads together
+	TotalSpeed = "total_speed"
+	// FailureRate is the percentage of the downloads finished within the
+	// tracker's window that failed
+	FailureRate = "failure_rate"
+	// QueueWait is the mean seconds downloads started within the window
+	// waited in the queue
+	QueueWait = "queue_wait_seconds"
+)
+
+// Metrics lists every metric rules can watch
+var Metrics = []string{QueueDepth, RunningDownloads, AverageSpeed, TotalSpeed, FailureRate, QueueWait, QueueGrowth, QueueStalled, DesiredWorkers}
+
+// Rule fires when its metric compares to Threshold with Op for at least For
+type Rule struct {
+	Name   string `yaml:"name"`
+	Metric string `yaml:"metric"`
+	// Op is >, >=, < or <=
+	Op        string  `yaml:"op"`
+	Threshold float64 `yaml:"threshold"`
+	// For is how long the condition must hold before the rule fires; zero
+	// fires on the first evaluation it holds
+	For time.Duration `yaml:"for"`
+	// Severity is passed on to notifiers, e.g. warning or critical
+	Severity    string `yaml:"severity"`
+	Description string `yaml:"description"`
+}
+
+// Holds reports whether value meets the rule's condition
+func (r Rule) Holds(value float64) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	}
+	return false
+}
+
+// String describes the condition, e.g. queue_depth > 100 for 10m0s
+func (r Rule) String() string {
+	condition := fmt.Sprintf("%s %s %g", r.Metric, r.Op, r.Threshold)
+	if r.For > 0 {
+		condition += " for " + r.For.String()
+	}
+	return condition
+}
+
+// Validate checks rules for unknown metrics and operators and duplicate
+// names
+func Validate(rules []Rule) error {
+	known := make(map[string]bool, len(Metrics))
+	for _, metric := range Metrics {
+		known[metric] = true
+	}
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		switch {
+		case rule.Name == "":
+			return fmt.Errorf("rule %d: name is required", i+1)
+		case names[rule.Name]:
+			return fmt.Errorf("rule %s: defined twice", rule.Name)
+		case !known[rule.Metric]:
+			return fmt.Errorf("rule %s: unknown metric %q, want one of %v", rule.Name, rule.Metric, Metrics)
+		case rule.Op != ">" && rule.Op != ">=" && rule.Op != "<" && rule.Op != "<=":
+			return fmt.Errorf("rule %s: op must be >, >=, < or <=, got %q", rule.Name, rule.Op)
+		case rule.For < 0:
+			return fmt.Errorf("rule %s: for must not be negative", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
+
+// LoadRules reads the rules file at path, YAML or JSON with a list of rules
+// under "rules". An empty path holds no rules.
+func LoadRules(path string) ([]Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+	var doc struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	// JSON is YAML too
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid alert rules %s: %w", path, err)
+	}
+	if len(doc.Rules) == 0 {
+		return nil, errors.New("alert rules file lists no rules")
+	}
+	if err := Validate(doc.Rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc.Rules, nil
+}
+
+// DefaultInterval is how often rules are evaluated when ALERT_INTERVAL is
+// not set
+const DefaultInterval = 30 * time.Second
+
+// Config is the alerting a server runs
+type Config struct {
+	Rules    []Rule
+	Interval time.Duration
+	// Webhooks are the URLs notifications are posted to
+	Webhooks []string
+	// StallWindow is the window of the stalled-queue watchdog of the queue
+	// server, which is off when it is zero; IdleSpeed is the total speed
+	// in bytes per second at or under which the workers count as idle
+	StallWindow time.Duration
+	IdleSpeed   float64
+	// MinWorkers and MaxWorkers bound the worker nodes the watchdog
+	// suggests; MaxWorkers 0 is no bound
+	MinWorkers int
+	MaxWorkers int
+	// ScaleWebhooks are posted the scaling hint whenever the suggested
+	// worker nodes change
+	ScaleWebhooks []string
+}
+
+// FromEnv reads the rules from ALERT_RULES_FILE, the comma separated
+// ALERT_WEBHOOK_URL and ALERT_INTERVAL, and the watchdog from
+// QUEUE_STALL_WINDOW, QUEUE_IDLE_SPEED, SCALE_MIN_WORKERS,
+// SCALE_MAX_WORKERS and the comma separated SCALE_WEBHOOK_URL. Without
+// rules or a watchdog there is nothing to run.
+func FromEnv() (Config, error) {
+	cfg := Config{Interval: DefaultInterval, StallWindow: DefaultStallWindow, IdleSpeed: DefaultIdleSpeed, MinWorkers: 1}
+	rules, err := LoadRules(os.Getenv("ALERT_RULES_FILE"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Rules = rules
+	if raw := os.Getenv("ALERT_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("ALERT_INTERVAL must be a positive duration, got %q", raw)
+		}
+		cfg.Interval = interval
+	}
+	cfg.Webhooks = splitURLs(os.Getenv("ALERT_WEBHOOK_URL"))
+	if raw := os.Getenv("QUEUE_STALL_WINDOW"); raw != "" {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window < 0 {
+			return cfg, fmt.Errorf("QUEUE_STALL_WINDOW must be a duration, or 0 to turn the watchdog off, got %q", raw)
+		}
+		cfg.StallWindow = window
+	}
+	if raw := os.Getenv("QUEUE_IDLE_SPEED"); raw != "" {
+		speed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || speed < 0 {
+			return cfg, fmt.Errorf("QUEUE_IDLE_SPEED must be bytes per second, got %q", raw)
+		}
+		cfg.IdleSpeed = speed
+	}
+	for _, bound := range []struct {
+		name  string
+		value *int
+	}{{"SCALE_MIN_WORKERS", &cfg.MinWorkers}, {"SCALE_MAX_WORKERS", &cfg.MaxWorkers}} {
+		if raw := os.Getenv(bound.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s must be a number of worker nodes, got %q", bound.name, raw)
+			}
+			*bound.value = n
+		}
+	}
+	if cfg.MaxWorkers > 0 && cfg.MinWorkers > cfg.MaxWorkers {
+		return cfg, fmt.Errorf("SCALE_MIN_WORKERS %d is above SCALE_MAX_WORKERS %d", cfg.MinWorkers, cfg.MaxWorkers)
+	}
+	cfg.ScaleWebhooks = splitURLs(os.Getenv("SCALE_WEBHOOK_URL"))
+	return cfg, nil
+}
+
+// splitURLs splits a comma separated list of URLs
+func splitURLs(raw string) []string {
+	var urls []string
+	for _, url := range strings.Split(raw, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,132 @@
+package alerts
+
+import (
+	"sync"
+	"time"
+
+	"multithreaded-downloader/events"
+)
+
+// DefaultWindow is how far back a Tracker counts finished and started
+// downloads
+const DefaultWindow = 15 * time.Minute
+
+// TrackedEvents are the events a Tracker needs
+var TrackedEvents = []events.Type{events.Created, events.Requeued, events.Started, events.Completed, events.Failed, events.Deleted}
+
+// finish is a download that completed or failed
+type finish struct {
+	at     time.Time
+	failed bool
+}
+
+// wait is how long a download waited in the queue before it started
+type wait struct {
+	at      time.Time
+	seconds float64
+}
+
+// Tracker follows the bus to measure the failure rate and queue wait of the
+// downloads of the last Window. It is safe for concurrent use.
+type Tracker struct {
+	Window time.Duration
+
+	mu       sync.Mutex
+	queued   map[string]time.Time
+	finishes []finish
+	waits    []wait
+	// finished counts every download that completed or failed
+	finished int64
+}
+
+// NewTracker creates a tracker over DefaultWindow; subscribe its Handle to
+// TrackedEvents
+func NewTracker() *Tracker {
+	return &Tracker{Window: DefaultWindow, queued: map[string]time.Time{}}
+}
+
+// Handle records an event
+func (t *Tracker) Handle(e events.Event) {
+	at := e.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e.Type {
+	case events.Created, events.Requeued:
+		t.queued[e.DownloadID] = at
+	case events.Started:
+		if since, ok := t.queued[e.DownloadID]; ok {
+			delete(t.queued, e.DownloadID)
+			t.waits = append(t.waits, wait{at, at.Sub(since).Seconds()})
+		}
+	case events.Completed, events.Failed:
+		delete(t.queued, e.DownloadID)
+		t.finishes = append(t.finishes, finish{at, e.Type == events.Failed})
+		t.finished++
+	case events.Deleted:
+		delete(t.queued, e.DownloadID)
+	}
+}
+
+// Metrics returns the failure rate and queue wait over the window ending at
+// now. Either is left out while no download finished or started within it.
+func (t *Tracker) Metrics(now time.Time) map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := now.Add(-t.Window)
+	metrics := map[string]float64{}
+
+	kept := t.finishes[:0]
+	failed := 0
+	for _, f := range t.finishes {
+		if f.at.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, f)
+		if f.failed {
+			failed++
+		}
+	}
+	t.finishes = kept
+	if len(kept) > 0 {
+		metrics[FailureRate] = 100 * float64(failed) / float64(len(kept))
+	}
+
+	keptWaits := t.waits[:0]
+	var total float64
+	for _, w := range t.waits {
+		if w.at.Before(cutoff) {
+			continue
+		}
+		keptWaits = append(keptWaits, w)
+		total += w.seconds
+	}
+	t.waits = keptWaits
+	if len(keptWaits) > 0 {
+		metrics[QueueWait] = total / float64(len(keptWaits))
+	}
+	return metrics
+}
+
+// Finished returns how many downloads completed or failed since the
+// tracker was created
+func (t *Tracker) Finished() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.finished
+}
+
+// AddSpeeds adds the average and total speed of the running downloads to
+// metrics; the average is left out while none runs
+func AddSpeeds(metrics map[string]float64, running []events.Stats) {
+	var total float64
+	for _, stats := range running {
+		total += stats.Speed
+	}
+	metrics[TotalSpeed] = total
+	if len(running) > 0 {
+		metrics[AverageSpeed] = total / float64(len(running))
+	}
+}
@@ -0,0 +1,255 @@
+package alerts
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+// Metrics a Watchdog adds for rules to watch
+const (
+	// QueueGrowth is how many jobs the queue depth grew by over the
+	// watchdog's window, negative while it shrinks; it has no value until
+	// the watchdog watched for a whole window
+	QueueGrowth = "queue_growth"
+	// QueueStalled is 1 while the watchdog finds the queue stalled and 0
+	// otherwise
+	QueueStalled = "queue_stalled"
+	// DesiredWorkers is how many worker nodes the watchdog suggests
+	DesiredWorkers = "desired_workers"
+)
+
+// DefaultStallWindow is how long the queue must grow with idle workers
+// before it counts as stalled, when QUEUE_STALL_WINDOW is not set
+const DefaultStallWindow = 10 * time.Minute
+
+// DefaultIdleSpeed is the total speed in bytes per second at or under which
+// the workers count as idle, when QUEUE_IDLE_SPEED is not set
+const DefaultIdleSpeed = 64 * 1024
+
+// StalledRuleName names the rule StalledRule returns
+const StalledRuleName = "queue-stalled"
+
+// Sample is what a server measured of its queue and workers at Time
+type Sample struct {
+	Time       time.Time
+	QueueDepth int64
+	Running    int64
+	// Speed is the bytes per second of all running jobs together
+	Speed float64
+	// Finished counts the jobs that completed or failed so far; only its
+	// change over the window matters
+	Finished int64
+	// Nodes are the registered worker nodes and Slots the jobs they run at
+	// once together
+	Nodes int
+	Slots int
+}
+
+// Hint is the watchdog's verdict on a sample
+type Hint struct {
+	Sample
+	// Covered is set once the watchdog watched for a whole window; Growth
+	// and Finished are the changes over it
+	Covered  bool
+	Growth   int64
+	Finished int64
+	// Stalled is set while the queue grew over the window and the workers
+	// moved no more than IdleSpeed and finished nothing
+	Stalled      bool
+	StalledSince time.Time
+	// DesiredNodes is how many worker nodes would run every queued and
+	// running job at once
+	DesiredNodes int
+}
+
+// Watchdog tells a queue that waits on stuck or missing workers from one
+// that is merely busy, and suggests how many worker nodes an autoscaler
+// should run. It is safe for concurrent use.
+type Watchdog struct {
+	Window    time.Duration
+	IdleSpeed float64
+	// MinNodes and MaxNodes bound DesiredNodes; MaxNodes 0 is no bound
+	MinNodes int
+	MaxNodes int
+	// SlotsPerNode is the jobs a node is expected to run at once while
+	// none is registered
+	SlotsPerNode int
+
+	mu      sync.Mutex
+	samples []Sample
+	since   time.Time
+	last    *Hint
+}
+
+// NewWatchdog creates a watchdog calling the queue stalled after window
+func NewWatchdog(window time.Duration, idleSpeed float64) *Watchdog {
+	return &Watchdog{Window: window, IdleSpeed: idleSpeed, MinNodes: 1, SlotsPerNode: 1}
+}
+
+// Observe records s and returns the verdict on it
+func (w *Watchdog) Observe(s Sample) Hint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// The newest sample at or before the start of the window is the
+	// baseline the changes are measured from
+	cutoff := s.Time.Add(-w.Window)
+	w.samples = append(w.samples, s)
+	first := 0
+	for first+1 < len(w.samples) && !w.samples[first+1].Time.After(cutoff) {
+		first++
+	}
+	w.samples = append(w.samples[:0], w.samples[first:]...)
+	base := w.samples[0]
+
+	hint := Hint{Sample: s, Covered: !base.Time.After(cutoff)}
+	if hint.Covered {
+		hint.Growth = s.QueueDepth - base.QueueDepth
+		hint.Finished = s.Finished - base.Finished
+		idle := hint.Finished == 0
+		for _, sample := range w.samples[1:] {
+			if sample.Speed > w.IdleSpeed {
+				idle = false
+			}
+		}
+		hint.Stalled = idle && hint.Growth > 0
+	}
+	if !hint.Stalled {
+		w.since = time.Time{}
+	} else if w.since.IsZero() {
+		w.since = s.Time
+	}
+	hint.StalledSince = w.since
+	hint.DesiredNodes = w.desiredNodes(s, hint.Stalled)
+	w.last = &hint
+	return hint
+}
+
+// desiredNodes is how many nodes of the size of the registered ones run
+// every job of s at once. A stalled queue asks for one more node than
+// there are, as the ones there are do not take its jobs.
+func (w *Watchdog) desiredNodes(s Sample, stalled bool) int {
+	perNode := w.SlotsPerNode
+	if s.Nodes > 0 && s.Slots >= s.Nodes {
+		perNode = s.Slots / s.Nodes
+	}
+	if perNode < 1 {
+		perNode = 1
+	}
+	jobs := s.QueueDepth + s.Running
+	desired := int((jobs + int64(perNode) - 1) / int64(perNode))
+	if stalled && desired <= s.Nodes {
+		desired = s.Nodes + 1
+	}
+	if desired < w.MinNodes {
+		desired = w.MinNodes
+	}
+	if w.MaxNodes > 0 && desired > w.MaxNodes {
+		desired = w.MaxNodes
+	}
+	return desired
+}
+
+// Last returns the verdict on the last sample, or false before the first
+func (w *Watchdog) Last() (Hint, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.last == nil {
+		return Hint{}, false
+	}
+	return *w.last, true
+}
+
+// AddMetrics adds QueueGrowth, QueueStalled and DesiredWorkers to metrics
+func (h Hint) AddMetrics(metrics map[string]float64) {
+	if h.Covered {
+		metrics[QueueGrowth] = float64(h.Growth)
+	}
+	metrics[QueueStalled] = 0
+	if h.Stalled {
+		metrics[QueueStalled] = 1
+	}
+	metrics[DesiredWorkers] = float64(h.DesiredNodes)
+}
+
+// StalledRule is the rule a server with a watchdog evaluates besides its
+// own; it fires as soon as the watchdog finds the queue stalled
+func (w *Watchdog) StalledRule() Rule {
+	return Rule{
+		Name:        StalledRuleName,
+		Metric:      QueueStalled,
+		Op:          ">=",
+		Threshold:   1,
+		Severity:    "critical",
+		Description: fmt.Sprintf("The queue grew for %s while the workers finished no job and moved no more than %g bytes/s", w.Window, w.IdleSpeed),
+	}
+}
+
+// API describes the verdict on the last sample as the API reports it
+func (w *Watchdog) API() openapi.ScalingHint {
+	hint, ok := w.Last()
+	out := openapi.ScalingHint{
+		WindowSeconds:  int64(w.Window / time.Second),
+		IdleSpeed:      w.IdleSpeed,
+		DesiredWorkers: w.MinNodes,
+	}
+	if !ok {
+		return out
+	}
+	out.ObservedAt = hint.Time.UTC().Format(time.RFC3339)
+	out.QueueDepth = hint.QueueDepth
+	out.RunningDownloads = hint.Running
+	out.TotalSpeed = hint.Speed
+	out.WorkerNodes = hint.Nodes
+	out.WorkerSlots = hint.Slots
+	if hint.Covered {
+		growth, finished := hint.Growth, hint.Finished
+		out.QueueGrowth, out.Finished = &growth, &finished
+	}
+	out.Stalled = hint.Stalled
+	if hint.Stalled {
+		out.StalledSince = hint.StalledSince.UTC().Format(time.RFC3339)
+	}
+	out.DesiredWorkers = hint.DesiredNodes
+	return out
+}
+
+// WritePrometheus writes the verdict on the last sample as gauges in the
+// Prometheus text format, for the custom metrics adapters of autoscalers
+func (w *Watchdog) WritePrometheus(out io.Writer) error {
+	hint, ok := w.Last()
+	if !ok {
+		hint.DesiredNodes = w.MinNodes
+	}
+	stalled := 0
+	if hint.Stalled {
+		stalled = 1
+	}
+	gauges := []struct {
+		name, help string
+		value      float64
+		show       bool
+	}{
+		{"mtdl_queue_depth", "Jobs in the main queue", float64(hint.QueueDepth), ok},
+		{"mtdl_running_downloads", "Jobs being processed", float64(hint.Running), ok},
+		{"mtdl_total_speed_bytes", "Bytes per second of all running jobs together", hint.Speed, ok},
+		{"mtdl_worker_nodes", "Registered worker nodes", float64(hint.Nodes), ok},
+		{"mtdl_worker_slots", "Jobs the registered worker nodes run at once together", float64(hint.Slots), ok},
+		{"mtdl_queue_growth", "Jobs the queue grew by over the stall window", float64(hint.Growth), hint.Covered},
+		{"mtdl_queue_stalled", "1 while the queue grows and the workers are idle", float64(stalled), ok},
+		{"mtdl_desired_workers", "Worker nodes that would run every queued and running job at once", float64(hint.DesiredNodes), true},
+	}
+	for _, g := range gauges {
+		if !g.show {
+			continue
+		}
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
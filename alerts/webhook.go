@@ -0,0 +1,124 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+// Webhook posts notifications as JSON to URL. The body carries a "text"
+// line as well, so Slack and Mattermost incoming webhooks take it as is.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// webhookBody is what Webhook posts
+type webhookBody struct {
+	Text        string  `json:"text"`
+	Status      string  `json:"status"`
+	Name        string  `json:"name"`
+	Metric      string  `json:"metric"`
+	Op          string  `json:"op"`
+	Threshold   float64 `json:"threshold"`
+	ForSeconds  int64   `json:"for_seconds"`
+	Value       float64 `json:"value"`
+	Severity    string  `json:"severity,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Node        string  `json:"node,omitempty"`
+	Time        string  `json:"time"`
+}
+
+// Text describes n in one line, e.g.
+// [FIRING] queue_backlog: queue_depth > 100 for 10m0s (value 140)
+func Text(n Notification) string {
+	text := fmt.Sprintf("[%s] %s: %s (value %g)", strings.ToUpper(string(n.State)), n.Rule.Name, n.Rule, n.Value)
+	if n.Node != "" {
+		text += " on " + n.Node
+	}
+	if n.Rule.Description != "" {
+		text += " - " + n.Rule.Description
+	}
+	return text
+}
+
+// scaleBody is what Webhook posts when the desired worker nodes change
+type scaleBody struct {
+	Text            string              `json:"text"`
+	Status          string              `json:"status"`
+	PreviousWorkers int                 `json:"previous_workers"`
+	Node            string              `json:"node,omitempty"`
+	Scaling         openapi.ScalingHint `json:"scaling"`
+}
+
+// ScaleText describes a change of the desired worker nodes in one line, e.g.
+// [SCALE] desired workers 2 -> 5: 140 queued, 8 running, stalled
+func ScaleText(previous int, hint openapi.ScalingHint) string {
+	text := fmt.Sprintf("[SCALE] desired workers %d -> %d: %d queued, %d running", previous, hint.DesiredWorkers, hint.QueueDepth, hint.RunningDownloads)
+	if hint.Stalled {
+		text += ", stalled"
+	}
+	return text
+}
+
+// Notify posts n to the webhook; any status but 2xx is an error
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	return w.post(ctx, webhookBody{
+		Text:        Text(n),
+		Status:      string(n.State),
+		Name:        n.Rule.Name,
+		Metric:      n.Rule.Metric,
+		Op:          n.Rule.Op,
+		Threshold:   n.Rule.Threshold,
+		ForSeconds:  int64(n.Rule.For / time.Second),
+		Value:       n.Value,
+		Severity:    n.Rule.Severity,
+		Description: n.Rule.Description,
+		Node:        n.Node,
+		Time:        n.Time.UTC().Format(time.RFC3339),
+	})
+}
+
+// Scale posts the scaling hint of the server named node, whose desired
+// worker nodes changed from previous, to the webhook
+func (w *Webhook) Scale(ctx context.Context, node string, previous int, hint openapi.ScalingHint) error {
+	return w.post(ctx, scaleBody{
+		Text:            ScaleText(previous, hint),
+		Status:          "scale",
+		PreviousWorkers: previous,
+		Node:            node,
+		Scaling:         hint,
+	})
+}
+
+// post posts v as JSON; any status but 2xx is an error
+func (w *Webhook) post(ctx context.Context, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("alert webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert webhook: %s", resp.Status)
+	}
+	return nil
+}
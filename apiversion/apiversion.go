@@ -0,0 +1,177 @@
+// Package apiversion negotiates the REST API version of a request and mounts
+// every route once per version it exists in. Routes are served under
+// /api/v1 and /api/v2; the unversioned legacy routes still work but answer
+// with Deprecation and Sunset headers pointing clients at /api/v1.
+package apiversion
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Version identifies a major version of the REST API
+type Version string
+
+const (
+	// V1 is the stable API; its payloads no longer change
+	V1 Version = "v1"
+	// V2 adds the queue-based fields and routes introduced after v1
+	V2 Version = "v2"
+
+	// Latest is the newest version a server speaks
+	Latest = V2
+
+	// Header is the request header clients use to pick a version on legacy
+	// routes; responses always carry the version that was used
+	Header = "API-Version"
+)
+
+// Supported lists every version in ascending order
+var Supported = []Version{V1, V2}
+
+// Parse checks that s names a supported version
+func Parse(s string) (Version, error) {
+	for _, v := range Supported {
+		if strings.EqualFold(s, string(v)) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported API version %q (supported: %s)", s, supportedList())
+}
+
+// AtLeast reports whether v is the same as or newer than other
+func (v Version) AtLeast(other Version) bool {
+	return v.index() >= other.index()
+}
+
+// Prefix returns the path prefix routes of this version are mounted under
+func (v Version) Prefix() string {
+	return "/api/" + string(v)
+}
+
+func (v Version) index() int {
+	for i, s := range Supported {
+		if s == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func supportedList() string {
+	names := make([]string, len(Supported))
+	for i, v := range Supported {
+		names[i] = string(v)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Policy describes the retirement of the unversioned legacy routes
+type Policy struct {
+	// Deprecated is when the legacy routes were deprecated
+	Deprecated time.Time
+	// Sunset is when the legacy routes will stop being served
+	Sunset time.Time
+}
+
+// DefaultPolicy retires the legacy routes six months after they were deprecated
+var DefaultPolicy = Policy{
+	Deprecated: time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC),
+	Sunset:     time.Date(2027, time.April, 16, 0, 0, 0, 0, time.UTC),
+}
+
+// Route is an endpoint of a server and the first version that offers it
+type Route struct {
+	Method string
+	Path   string
+	// Since is the first version the route exists in; empty means V1
+	Since Version
+}
+
+func (r Route) since() Version {
+	if r.Since == "" {
+		return V1
+	}
+	return r.Since
+}
+
+// Mount is one registration of a route: under a version prefix, or at the
+// unversioned legacy path
+type Mount struct {
+	// Route is the index of the route in the table passed to Mounts
+	Route  int
+	Method string
+	Path   string
+	// Version is fixed for versioned mounts and empty for legacy ones,
+	// whose version is negotiated per request
+	Version Version
+	Legacy  bool
+}
+
+// Mounts expands a route table into the registrations a server needs: every
+// route under each version it exists in, and v1 routes at their legacy path
+func Mounts(routes []Route) []Mount {
+	var mounts []Mount
+	for _, v := range Supported {
+		for i, route := range routes {
+			if v.AtLeast(route.since()) {
+				mounts = append(mounts, Mount{Route: i, Method: route.Method, Path: v.Prefix() + route.Path, Version: v})
+			}
+		}
+	}
+	for i, route := range routes {
+		if route.since() == V1 {
+			mounts = append(mounts, Mount{Route: i, Method: route.Method, Path: route.Path, Legacy: true})
+		}
+	}
+	return mounts
+}
+
+// Negotiate picks the version a request is served with and sets the
+// response headers that go with it. Versioned mounts use their own version
+// and reject a conflicting API-Version header; legacy mounts default to V1
+// and are marked deprecated.
+func (m Mount) Negotiate(w http.Header, r *http.Request, policy Policy) (Version, error) {
+	version := m.Version
+	if requested := r.Header.Get(Header); requested != "" {
+		v, err := Parse(requested)
+		if err != nil {
+			return "", err
+		}
+		if !m.Legacy && v != m.Version {
+			return "", fmt.Errorf("%s header %s conflicts with the %s path", Header, v, m.Version.Prefix())
+		}
+		version = v
+	}
+	if version == "" {
+		version = V1
+	}
+
+	w.Set(Header, string(version))
+	if m.Legacy {
+		SetDeprecationHeaders(w, r.URL.Path, policy)
+	}
+	return version, nil
+}
+
+// SetDeprecationHeaders marks a response from a legacy route as deprecated
+// (RFC 9745), announces its sunset (RFC 8594) and links the v1 successor
+func SetDeprecationHeaders(w http.Header, path string, policy Policy) {
+	w.Set("Deprecation", fmt.Sprintf("@%d", policy.Deprecated.Unix()))
+	w.Set("Sunset", policy.Sunset.UTC().Format(http.TimeFormat))
+	w.Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", V1.Prefix(), path))
+}
+
+// Split separates the version prefix from a request path for servers that
+// route by hand. legacy is true when the path has no version prefix.
+func Split(path string) (version Version, rest string, legacy bool) {
+	for _, v := range Supported {
+		prefix := v.Prefix()
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return v, strings.TrimPrefix(path, prefix), false
+		}
+	}
+	return "", path, true
+}
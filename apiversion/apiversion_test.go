@@ -0,0 +1,125 @@
+package apiversion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMounts(t *testing.T) {
+	routes := []Route{
+		{Method: "GET", Path: "/downloads"},
+		{Method: "POST", Path: "/groups", Since: V2},
+	}
+
+	var got []string
+	for _, m := range Mounts(routes) {
+		got = append(got, m.Method+" "+m.Path)
+	}
+	want := []string{
+		"GET /api/v1/downloads",
+		"GET /api/v2/downloads",
+		"POST /api/v2/groups",
+		"GET /downloads",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Mounts() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	versioned := Mount{Method: "GET", Path: "/api/v2/downloads", Version: V2}
+	legacy := Mount{Method: "GET", Path: "/downloads", Legacy: true}
+
+	tests := []struct {
+		name       string
+		mount      Mount
+		header     string
+		want       Version
+		wantErr    bool
+		deprecated bool
+	}{
+		{name: "versioned path", mount: versioned, want: V2},
+		{name: "matching header", mount: versioned, header: "v2", want: V2},
+		{name: "conflicting header", mount: versioned, header: "v1", wantErr: true},
+		{name: "unknown header", mount: versioned, header: "v7", wantErr: true},
+		{name: "legacy default", mount: legacy, want: V1, deprecated: true},
+		{name: "legacy header", mount: legacy, header: "V2", want: V2, deprecated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/downloads", nil)
+			if tt.header != "" {
+				r.Header.Set(Header, tt.header)
+			}
+			w := make(http.Header)
+
+			got, err := tt.mount.Negotiate(w, r, DefaultPolicy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Negotiate() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Negotiate() = %s, %v, want %s", got, err, tt.want)
+			}
+			if w.Get(Header) != string(tt.want) {
+				t.Errorf("%s response header = %q, want %q", Header, w.Get(Header), tt.want)
+			}
+			if deprecated := w.Get("Deprecation") != ""; deprecated != tt.deprecated {
+				t.Errorf("Deprecation header set = %v, want %v", deprecated, tt.deprecated)
+			}
+		})
+	}
+}
+
+func TestSetDeprecationHeaders(t *testing.T) {
+	w := make(http.Header)
+	SetDeprecationHeaders(w, "/downloads", DefaultPolicy)
+
+	if got := w.Get("Deprecation"); got != "@1792108800" {
+		t.Errorf("Deprecation = %q", got)
+	}
+	if got := w.Get("Sunset"); got != "Fri, 16 Apr 2027 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", got)
+	}
+	if got := w.Get("Link"); got != `</api/v1/downloads>; rel="successor-version"` {
+		t.Errorf("Link = %q", got)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		path    string
+		version Version
+		rest    string
+		legacy  bool
+	}{
+		{path: "/api/v1/downloads", version: V1, rest: "/downloads"},
+		{path: "/api/v2/downloads/x/status", version: V2, rest: "/downloads/x/status"},
+		{path: "/api/v2", version: V2, rest: ""},
+		{path: "/api/v10/downloads", rest: "/api/v10/downloads", legacy: true},
+		{path: "/health", rest: "/health", legacy: true},
+	}
+	for _, tt := range tests {
+		version, rest, legacy := Split(tt.path)
+		if version != tt.version || rest != tt.rest || legacy != tt.legacy {
+			t.Errorf("Split(%q) = %q, %q, %v, want %q, %q, %v", tt.path, version, rest, legacy, tt.version, tt.rest, tt.legacy)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if v, err := Parse("V1"); err != nil || v != V1 {
+		t.Fatalf("Parse(V1) = %s, %v", v, err)
+	}
+	if _, err := Parse("v3"); err == nil || !strings.Contains(err.Error(), "supported: v1, v2") {
+		t.Fatalf("Parse(v3) error = %v", err)
+	}
+	if !V2.AtLeast(V1) || V1.AtLeast(V2) {
+		t.Fatal("AtLeast() ordering is wrong")
+	}
+}
@@ -0,0 +1,277 @@
+// Package backup writes and restores server backups: one gzipped tar
+// archive holding a snapshot of the database and the progress files of the
+// state directory, so a server lost with its host can be rebuilt on another
+// one. Runtime settings live in the database and travel with it.
+//
+// The archive starts with backup.json describing it, then database.gob,
+// then one state/<name> entry per progress file.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the archive format this package writes and reads
+const Version = 1
+
+const (
+	manifestName = "backup.json"
+	databaseName = "database.gob"
+	statePrefix  = "state/"
+)
+
+// stateReadAttempts is how often a progress file that is being rewritten
+// while the backup runs is read again before it is left out
+const stateReadAttempts = 3
+
+// MaxEntrySize limits a single entry read back from an archive
+const MaxEntrySize = 1 << 30
+
+// Manifest describes a backup
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Rows counts the rows of each table in the database snapshot
+	Rows map[string]int `json:"rows"`
+	// StateFiles counts the progress files included, Skipped names those
+	// left out because they never read back complete
+	StateFiles int      `json:"state_files"`
+	Skipped    []string `json:"skipped,omitempty"`
+}
+
+// Snapshot is what a backup holds before it is archived
+type Snapshot struct {
+	// Database is the encoded database snapshot and Rows its row counts
+	Database []byte
+	Rows     map[string]int
+	// StateDir is the directory whose progress files are included
+	StateDir string
+}
+
+// Write archives snap to w and returns the manifest it wrote
+func Write(w io.Writer, snap Snapshot, now time.Time) (Manifest, error) {
+	manifest := Manifest{Version: Version, CreatedAt: now.UTC(), Rows: snap.Rows}
+	states, skipped, err := readStateDir(snap.StateDir)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.StateFiles = len(states)
+	manifest.Skipped = skipped
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeEntry(tw, manifestName, encoded, now); err != nil {
+		return manifest, err
+	}
+	if err := writeEntry(tw, databaseName, snap.Database, now); err != nil {
+		return manifest, err
+	}
+	for _, name := range sortedNames(states) {
+		if err := writeEntry(tw, statePrefix+name, states[name], now); err != nil {
+			return manifest, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, now time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readStateDir reads the progress files in dir. Progress files are
+// rewritten in place while downloads run, so a file that is not valid JSON
+// is read again and left out if it stays incomplete; the download then
+// starts over after a restore, as it would after a crash.
+func readStateDir(dir string) (map[string][]byte, []string, error) {
+	states := make(map[string][]byte)
+	if dir == "" {
+		return states, nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	var skipped []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		var data []byte
+		for attempt := 0; attempt < stateReadAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			data, err = os.ReadFile(filepath.Join(dir, entry.Name()))
+			if errors.Is(err, os.ErrNotExist) {
+				// The download finished and removed its progress file
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+			}
+			if json.Valid(data) {
+				states[entry.Name()] = data
+				break
+			}
+		}
+		if _, ok := states[entry.Name()]; !ok && err == nil {
+			skipped = append(skipped, entry.Name())
+		}
+	}
+	return states, skipped, nil
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Archive is a backup read back into memory
+type Archive struct {
+	Manifest Manifest
+	Database []byte
+	// States maps progress file names to their content
+	States map[string][]byte
+}
+
+// Read reads and checks a whole archive without writing anything, so a
+// damaged backup is rejected before a restore changes the server
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	archive := &Archive{States: make(map[string][]byte)}
+	seenManifest, seenDatabase := false, false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("damaged backup archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %q in backup archive", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, MaxEntrySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("damaged backup archive: %w", err)
+		}
+		if len(data) > MaxEntrySize {
+			return nil, fmt.Errorf("backup entry %q exceeds %d bytes", header.Name, MaxEntrySize)
+		}
+
+		switch {
+		case header.Name == manifestName:
+			if err := json.Unmarshal(data, &archive.Manifest); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", manifestName, err)
+			}
+			if archive.Manifest.Version != Version {
+				return nil, fmt.Errorf("backup format version %d is not supported, want %d", archive.Manifest.Version, Version)
+			}
+			seenManifest = true
+		case header.Name == databaseName:
+			archive.Database = data
+			seenDatabase = true
+		case strings.HasPrefix(header.Name, statePrefix):
+			name := strings.TrimPrefix(header.Name, statePrefix)
+			if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+				return nil, fmt.Errorf("unsafe state file name %q in backup archive", header.Name)
+			}
+			archive.States[name] = data
+		default:
+			return nil, fmt.Errorf("unexpected entry %q in backup archive", header.Name)
+		}
+	}
+	if !seenManifest || !seenDatabase {
+		return nil, fmt.Errorf("backup archive is missing %s or %s", manifestName, databaseName)
+	}
+	if len(archive.States) != archive.Manifest.StateFiles {
+		return nil, fmt.Errorf("backup archive holds %d state files, its manifest lists %d", len(archive.States), archive.Manifest.StateFiles)
+	}
+	return archive, nil
+}
+
+// RestoreStates writes the archive's progress files into dir and removes
+// the progress files in dir the archive does not hold, which belong to
+// downloads the restored database does not know
+func (a *Archive) RestoreStates(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	existing, skipped, err := readStateDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range append(sortedNames(existing), skipped...) {
+		if _, ok := a.States[name]; !ok {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range sortedNames(a.States) {
+		target := filepath.Join(dir, name)
+		tmp := target + ".restore"
+		if err := os.WriteFile(tmp, a.States[name], 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Summary describes the archive in one line for logs
+func (a *Archive) Summary() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "backup of %s:", a.Manifest.CreatedAt.Format(time.RFC3339))
+	tables := make([]string, 0, len(a.Manifest.Rows))
+	for table := range a.Manifest.Rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Fprintf(&buf, " %d %s,", a.Manifest.Rows[table], table)
+	}
+	fmt.Fprintf(&buf, " %d state files", len(a.States))
+	return buf.String()
+}
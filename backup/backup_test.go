@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteAndRestore(t *testing.T) {
+	source := t.TempDir()
+	writeFile(t, filepath.Join(source, "a.json"), `{"parts":[1,2]}`)
+	writeFile(t, filepath.Join(source, "b.json"), `{"parts":[`) // being rewritten
+	writeFile(t, filepath.Join(source, "notes.txt"), "not a progress file")
+
+	now := time.Date(2026, 6, 1, 8, 30, 0, 0, time.UTC)
+	rows := map[string]int{"downloads": 2, "settings": 1}
+	var buf bytes.Buffer
+	written, err := Write(&buf, Snapshot{Database: []byte("db snapshot"), Rows: rows, StateDir: source}, now)
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if written.StateFiles != 1 || !reflect.DeepEqual(written.Skipped, []string{"b.json"}) {
+		t.Errorf("Write() manifest = %+v, want a.json kept and b.json skipped", written)
+	}
+
+	archive, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(archive.Database) != "db snapshot" || !reflect.DeepEqual(archive.Manifest.Rows, rows) || !archive.Manifest.CreatedAt.Equal(now) {
+		t.Errorf("Read() = %+v", archive)
+	}
+
+	target := t.TempDir()
+	writeFile(t, filepath.Join(target, "stale.json"), `{}`)
+	writeFile(t, filepath.Join(target, "keep.txt"), "unrelated")
+	if err := archive.RestoreStates(target); err != nil {
+		t.Fatalf("RestoreStates() error = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(target, "a.json")); err != nil || string(data) != `{"parts":[1,2]}` {
+		t.Errorf("restored a.json = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "stale.json")); !os.IsNotExist(err) {
+		t.Error("RestoreStates() kept a progress file the backup does not hold")
+	}
+	if _, err := os.Stat(filepath.Join(target, "keep.txt")); err != nil {
+		t.Errorf("RestoreStates() removed a file that is not a progress file: %v", err)
+	}
+}
+
+func TestWriteWithoutStateDir(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Write(&buf, Snapshot{Database: []byte("x"), StateDir: filepath.Join(t.TempDir(), "missing")}, time.Now()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	archive, err := Read(&buf)
+	if err != nil || len(archive.States) != 0 {
+		t.Fatalf("Read() = %+v, %v", archive, err)
+	}
+}
+
+// rawArchive builds an archive from name/content pairs
+func rawArchive(t *testing.T, entries ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(entries); i += 2 {
+		tw.WriteHeader(&tar.Header{Name: entries[i], Mode: 0600, Size: int64(len(entries[i+1])), Typeflag: tar.TypeReg})
+		tw.Write([]byte(entries[i+1]))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestReadRejects(t *testing.T) {
+	manifest := `{"version": 1, "state_files": 1}`
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "not gzip", data: []byte("plain text"), want: "not a backup archive"},
+		{name: "no database", data: rawArchive(t, manifestName, `{"version": 1}`), want: "missing"},
+		{name: "newer version", data: rawArchive(t, manifestName, `{"version": 2}`, databaseName, "x"), want: "version 2"},
+		{name: "path traversal", data: rawArchive(t, manifestName, manifest, databaseName, "x", "state/../../etc/cron.json", "{}"), want: "unsafe"},
+		{name: "hidden file", data: rawArchive(t, manifestName, manifest, databaseName, "x", "state/.json", "{}"), want: "unsafe"},
+		{name: "unknown entry", data: rawArchive(t, manifestName, manifest, databaseName, "x", "extra.sh", "rm -rf /"), want: "unexpected entry"},
+		{name: "missing state file", data: rawArchive(t, manifestName, manifest, databaseName, "x"), want: "holds 0 state files"},
+	}
+	for _, tt := range tests {
+		if _, err := Read(bytes.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Read() error = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
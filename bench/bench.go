@@ -0,0 +1,496 @@
+// Package bench load-tests the queue pipeline: it enqueues synthetic jobs
+// on a queued server whose workers run in sandbox mode, follows them to the
+// end and reports how long they spent being enqueued, waiting in the queue
+// and running, together with the latency of the server's Redis and database
+// checks while they ran. It validates capacity before a rollout without
+// fetching anything from the internet.
+//
+// Jobs are timed by the events the server records for them, so the workers
+// and the server need the database the queue stack runs with, and clocks in
+// sync across nodes.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+const (
+	// Tag marks the jobs of a run in bandwidth reports
+	Tag = "mtdl-bench"
+	// DefaultConcurrency is how many requests a run has in flight
+	DefaultConcurrency = 16
+	// DefaultPollInterval is how often unfinished jobs are looked at
+	DefaultPollInterval = 500 * time.Millisecond
+	// host is the host of the synthetic URLs; it cannot resolve, so workers
+	// that are not in sandbox mode fail the jobs instead of fetching them
+	host = "bench.invalid"
+)
+
+// Config is a run
+type Config struct {
+	// Server is the base URL of the queued server, e.g. http://localhost:8080
+	Server string
+	// Jobs is how many jobs are enqueued
+	Jobs int
+	// Concurrency bounds the requests in flight; 0 is DefaultConcurrency
+	Concurrency int
+	// Threads every job downloads with; 0 leaves it to the server
+	Threads int
+	// Profile is the sandbox query of every file, e.g. size=10m&speed=5m
+	Profile url.Values
+	// Timeout bounds the whole run; 0 waits until every job finished
+	Timeout time.Duration
+	// PollInterval is how often unfinished jobs are looked at; 0 is
+	// DefaultPollInterval
+	PollInterval time.Duration
+	// Client sends the requests; nil is a client with a 30 second timeout
+	Client *http.Client
+}
+
+// Stats sums up durations in milliseconds
+type Stats struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+// Report is what a run measured
+type Report struct {
+	// RunID names the run's directory of files, mtdl-bench/<run id>
+	RunID     string `json:"run_id"`
+	Jobs      int    `json:"jobs"`
+	Completed int    `json:"completed"`
+	Failed    int    `json:"failed"`
+	// Unfinished jobs were still queued or running when the run timed out
+	Unfinished int `json:"unfinished"`
+	// EnqueueErrors counts jobs the server refused or did not answer for
+	EnqueueErrors int     `json:"enqueue_errors"`
+	DurationMs    float64 `json:"duration_ms"`
+	// Bytes is the size of the completed jobs' files
+	Bytes          int64   `json:"bytes"`
+	JobsPerSecond  float64 `json:"jobs_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// Enqueue is the round trip of POST /downloads
+	Enqueue Stats `json:"enqueue"`
+	// QueueWait is from a job's creation until a worker started it
+	QueueWait Stats `json:"queue_wait"`
+	// Run is from a worker starting a job until it finished
+	Run Stats `json:"run"`
+	// EndToEnd is the enqueue round trip plus creation until finish
+	EndToEnd Stats `json:"end_to_end"`
+	// Dependencies are the latencies of the server's health checks, e.g.
+	// of redis and the database, sampled every second of the run
+	Dependencies map[string]Stats `json:"dependencies,omitempty"`
+	// Errors are the distinct errors of failed and refused jobs, with how
+	// often each occurred
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+// job is one enqueued job
+type job struct {
+	id      string
+	enqueue time.Duration
+	status  string
+	bytes   int64
+	err     string
+	events  []openapi.DownloadEvent
+}
+
+// runner carries out a run
+type runner struct {
+	cfg    Config
+	client *http.Client
+	base   string
+}
+
+// Run enqueues cfg.Jobs jobs and follows them until they finished, ctx is
+// done or cfg.Timeout passed; what was measured by then is reported
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Jobs <= 0 {
+		return nil, errors.New("jobs must be at least 1")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	r := &runner{cfg: cfg, client: cfg.Client, base: strings.TrimRight(cfg.Server, "/") + "/api/v2"}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	// Refuse to start against a server that is not there
+	var health openapi.HealthResponse
+	if err := r.get(ctx, "/health", &health); err != nil {
+		return nil, fmt.Errorf("server is not reachable: %w", err)
+	}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+	report := &Report{RunID: hex.EncodeToString(id), Jobs: cfg.Jobs, Errors: map[string]int{}}
+
+	sampleCtx, stopSampling := context.WithCancel(ctx)
+	samples := make(chan map[string][]float64, 1)
+	go func() { samples <- r.sample(sampleCtx) }()
+
+	start := time.Now()
+	jobs := r.enqueue(ctx, report.RunID)
+	r.follow(ctx, jobs)
+	elapsed := time.Since(start)
+	stopSampling()
+	dependencies := <-samples
+
+	var enqueue, wait, run, endToEnd []float64
+	for _, j := range jobs {
+		switch {
+		case j.id == "":
+			report.EnqueueErrors++
+			report.Errors[j.err]++
+			continue
+		case j.status == "completed" || j.status == "not_modified":
+			report.Completed++
+			report.Bytes += j.bytes
+		case j.status == "failed":
+			report.Failed++
+			report.Errors[j.err]++
+		default:
+			report.Unfinished++
+		}
+		enqueue = append(enqueue, ms(j.enqueue))
+		created, started, finished := timeline(j.events)
+		if !created.IsZero() && !started.IsZero() {
+			wait = append(wait, ms(started.Sub(created)))
+		}
+		if !started.IsZero() && !finished.IsZero() {
+			run = append(run, ms(finished.Sub(started)))
+		}
+		if !created.IsZero() && !finished.IsZero() {
+			endToEnd = append(endToEnd, ms(j.enqueue+finished.Sub(created)))
+		}
+	}
+
+	report.DurationMs = ms(elapsed)
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		report.JobsPerSecond = float64(report.Completed+report.Failed) / seconds
+		report.BytesPerSecond = float64(report.Bytes) / seconds
+	}
+	report.Enqueue = Summarize(enqueue)
+	report.QueueWait = Summarize(wait)
+	report.Run = Summarize(run)
+	report.EndToEnd = Summarize(endToEnd)
+	if len(dependencies) > 0 {
+		report.Dependencies = map[string]Stats{}
+		for name, latencies := range dependencies {
+			report.Dependencies[name] = Summarize(latencies)
+		}
+	}
+	if len(report.Errors) == 0 {
+		report.Errors = nil
+	}
+	return report, nil
+}
+
+// enqueue posts the jobs of run, cfg.Concurrency at a time
+func (r *runner) enqueue(ctx context.Context, run string) []*job {
+	jobs := make([]*job, r.cfg.Jobs)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < r.cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				jobs[i] = r.post(ctx, run, i)
+			}
+		}()
+	}
+	for i := range jobs {
+		if ctx.Err() != nil {
+			jobs[i] = &job{err: "not enqueued before the run timed out"}
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return jobs
+}
+
+// post enqueues job i of run
+func (r *runner) post(ctx context.Context, run string, i int) *job {
+	name := fmt.Sprintf("%s/%s/%d.bin", Tag, run, i)
+	fileURL := url.URL{Scheme: "https", Host: host, Path: "/" + name, RawQuery: r.cfg.Profile.Encode()}
+	body, _ := json.Marshal(openapi.QueuedDownloadRequest{
+		URL:     fileURL.String(),
+		Output:  name,
+		Threads: r.cfg.Threads,
+		Tags:    []string{Tag},
+	})
+
+	j := &job{}
+	start := time.Now()
+	var resp openapi.QueuedDownloadResponse
+	err := r.do(ctx, http.MethodPost, "/downloads", bytes.NewReader(body), &resp)
+	j.enqueue = time.Since(start)
+	if err != nil {
+		j.err = err.Error()
+		return j
+	}
+	j.id = resp.JobID
+	j.status = resp.Status
+	return j
+}
+
+// follow looks at the unfinished jobs every poll interval until all of
+// them finished or ctx is done, then reads the events of every job
+func (r *runner) follow(ctx context.Context, jobs []*job) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+poll:
+	for {
+		var pending []*job
+		for _, j := range jobs {
+			if j.id != "" && !finished(j.status) {
+				pending = append(pending, j)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		r.each(ctx, pending, func(j *job) {
+			var status openapi.QueuedDownloadStatus
+			if err := r.get(ctx, "/downloads/"+url.PathEscape(j.id)+"/status", &status); err == nil {
+				j.status, j.bytes, j.err = status.Status, status.TotalBytes, status.ErrorMessage
+			}
+		})
+		select {
+		case <-ctx.Done():
+			break poll
+		case <-ticker.C:
+		}
+	}
+
+	// The events are read even after a timeout, to time what got started
+	eventsCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	r.each(eventsCtx, jobs, func(j *job) {
+		if j.id == "" {
+			return
+		}
+		var events openapi.DownloadEvents
+		if err := r.get(eventsCtx, "/downloads/"+url.PathEscape(j.id)+"/events", &events); err == nil {
+			j.events = events.Events
+		}
+	})
+}
+
+// each calls fn for every job, cfg.Concurrency at a time
+func (r *runner) each(ctx context.Context, jobs []*job, fn func(*job)) {
+	slots := make(chan struct{}, r.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(j)
+		}(j)
+	}
+	wg.Wait()
+}
+
+// sample reads the dependency latencies of the server's health every
+// second until ctx is done
+func (r *runner) sample(ctx context.Context) map[string][]float64 {
+	latencies := map[string][]float64{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		var health openapi.HealthResponse
+		if err := r.get(ctx, "/health", &health); err == nil {
+			for name, check := range health.Dependencies {
+				latencies[name] = append(latencies[name], check.LatencyMs)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return latencies
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *runner) get(ctx context.Context, path string, out interface{}) error {
+	return r.do(ctx, http.MethodGet, path, nil, out)
+}
+
+// do sends a request to the API and decodes its answer into out
+func (r *runner) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.base+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	// The health check answers 503 with its body when a dependency is down
+	if resp.StatusCode >= 300 && !(path == "/health" && resp.StatusCode == http.StatusServiceUnavailable) {
+		var apiErr openapi.ErrorResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// finished reports whether a job in status is done for good
+func finished(status string) bool {
+	return status == "completed" || status == "not_modified" || status == "failed"
+}
+
+// timeline returns when a job was created, when a worker last started it
+// and when it completed or failed; times the events lack are zero
+func timeline(events []openapi.DownloadEvent) (created, started, finished time.Time) {
+	for _, e := range events {
+		t, err := time.Parse(time.RFC3339Nano, e.Time)
+		if err != nil {
+			continue
+		}
+		switch e.Type {
+		case "created":
+			created = t
+		case "started":
+			started = t
+		case "completed", "failed":
+			finished = t
+		}
+	}
+	return created, started, finished
+}
+
+// Summarize returns the percentiles of durations in milliseconds
+func Summarize(durations []float64) Stats {
+	if len(durations) == 0 {
+		return Stats{}
+	}
+	sorted := append([]float64(nil), durations...)
+	sort.Float64s(sorted)
+	return Stats{
+		Count: len(sorted),
+		P50Ms: percentile(sorted, 50),
+		P95Ms: percentile(sorted, 95),
+		P99Ms: percentile(sorted, 99),
+		MaxMs: sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Write prints the report as a table
+func (r *Report) Write(w io.Writer) error {
+	fmt.Fprintf(w, "Run %s: %d jobs, %d completed, %d failed", r.RunID, r.Jobs, r.Completed, r.Failed)
+	if r.Unfinished > 0 {
+		fmt.Fprintf(w, ", %d unfinished", r.Unfinished)
+	}
+	if r.EnqueueErrors > 0 {
+		fmt.Fprintf(w, ", %d not enqueued", r.EnqueueErrors)
+	}
+	fmt.Fprintf(w, " in %.1fs\n", r.DurationMs/1000)
+	fmt.Fprintf(w, "Throughput: %.2f jobs/s, %.2f MB/s\n\n", r.JobsPerSecond, r.BytesPerSecond/1e6)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tcount\tp50\tp95\tp99\tmax\t")
+	row := func(name string, s Stats) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n", name, s.Count, duration(s.P50Ms), duration(s.P95Ms), duration(s.P99Ms), duration(s.MaxMs))
+	}
+	row("enqueue", r.Enqueue)
+	row("queue wait", r.QueueWait)
+	row("run", r.Run)
+	row("end-to-end", r.EndToEnd)
+	names := make([]string, 0, len(r.Dependencies))
+	for name := range r.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		row(name+" check", r.Dependencies[name])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, "\nErrors:")
+		messages := make([]string, 0, len(r.Errors))
+		for message := range r.Errors {
+			messages = append(messages, message)
+		}
+		sort.Strings(messages)
+		for _, message := range messages {
+			fmt.Fprintf(w, "  %5d  %s\n", r.Errors[message], message)
+		}
+	}
+	return nil
+}
+
+// duration formats milliseconds for the table
+func duration(ms float64) string {
+	switch {
+	case ms >= 1000:
+		return fmt.Sprintf("%.1fs", ms/1000)
+	case ms >= 100:
+		return fmt.Sprintf("%.0fms", ms)
+	default:
+		return fmt.Sprintf("%.1fms", ms)
+	}
+}
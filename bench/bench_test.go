@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+// fakeQueue is a queued server whose jobs complete on the second look, one
+// second after they were created, having waited 200ms for a worker
+type fakeQueue struct {
+	mu    sync.Mutex
+	urls  map[string]string
+	looks map[string]int
+}
+
+func (q *fakeQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2")
+	created := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	switch {
+	case path == "/health":
+		json.NewEncoder(w).Encode(openapi.HealthResponse{Status: "healthy", Dependencies: map[string]openapi.DependencyCheck{
+			"redis":    {Healthy: true, LatencyMs: 2},
+			"database": {Healthy: true, LatencyMs: 5},
+		}})
+	case path == "/downloads" && r.Method == http.MethodPost:
+		var req openapi.QueuedDownloadRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if strings.HasSuffix(req.Output, "/3.bin") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(openapi.ErrorResponse{Error: "Queue is full"})
+			return
+		}
+		id := fmt.Sprintf("job-%d", len(q.urls))
+		q.urls[id] = req.URL
+		json.NewEncoder(w).Encode(openapi.QueuedDownloadResponse{JobID: id, Status: "queued"})
+	case strings.HasSuffix(path, "/status"):
+		id := strings.Split(path, "/")[2]
+		q.looks[id]++
+		status := openapi.QueuedDownloadStatus{JobID: id, Status: "downloading"}
+		if q.looks[id] > 1 {
+			status.Status, status.TotalBytes = "completed", 1000
+		}
+		json.NewEncoder(w).Encode(status)
+	case strings.HasSuffix(path, "/events"):
+		json.NewEncoder(w).Encode(openapi.DownloadEvents{Events: []openapi.DownloadEvent{
+			{Type: "created", Time: created.Format(time.RFC3339Nano)},
+			{Type: "started", Time: created.Add(200 * time.Millisecond).Format(time.RFC3339Nano)},
+			{Type: "completed", Time: created.Add(time.Second).Format(time.RFC3339Nano)},
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRun(t *testing.T) {
+	queue := &fakeQueue{urls: map[string]string{}, looks: map[string]int{}}
+	server := httptest.NewServer(queue)
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{
+		Server:       server.URL,
+		Jobs:         5,
+		Concurrency:  2,
+		Profile:      url.Values{"size": {"1000"}},
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Completed != 4 || report.EnqueueErrors != 1 || report.Bytes != 4000 || report.Errors["429 Too Many Requests: Queue is full"] != 1 {
+		t.Errorf("Run() = %+v", report)
+	}
+	if report.QueueWait.Count != 4 || report.QueueWait.P50Ms != 200 || report.Run.MaxMs != 800 {
+		t.Errorf("queue wait %+v, run %+v", report.QueueWait, report.Run)
+	}
+	if report.EndToEnd.P50Ms < 1000 || report.Dependencies["database"].MaxMs != 5 {
+		t.Errorf("end-to-end %+v, dependencies %+v", report.EndToEnd, report.Dependencies)
+	}
+	for _, rawURL := range queue.urls {
+		u, _ := url.Parse(rawURL)
+		if u.Host != host || !strings.HasPrefix(u.Path, "/"+Tag+"/"+report.RunID+"/") || u.Query().Get("size") != "1000" {
+			t.Errorf("enqueued %s", rawURL)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := report.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "queue wait") || !strings.Contains(out.String(), "redis check") {
+		t.Errorf("Write() printed:\n%s", out.String())
+	}
+}
+
+func TestRunUnreachableServer(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if _, err := Run(context.Background(), Config{Server: server.URL, Jobs: 1}); err == nil {
+		t.Error("Run() against a stopped server succeeded")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var durations []float64
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, float64(i))
+	}
+	want := Stats{Count: 100, P50Ms: 50, P95Ms: 95, P99Ms: 99, MaxMs: 100}
+	if got := Summarize(durations); got != want {
+		t.Errorf("Summarize() = %+v, want %+v", got, want)
+	}
+	if got := Summarize(nil); got != (Stats{}) {
+		t.Errorf("Summarize(nil) = %+v", got)
+	}
+}
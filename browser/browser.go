@@ -0,0 +1,222 @@
+// Package browser makes the API servers safe to use from web pages: CORS
+// headers for the origins allowed to call them, and, in CSRF mode, a token
+// every request from a browser that changes something must carry, so a page
+// on another site cannot make a visitor's browser start or delete downloads.
+//
+// The token is a double-submit cookie: the server sets CSRFCookie on its
+// responses and pages send its value back in the CSRFHeader header. Other
+// sites can neither read the cookie nor, as it is SameSite=Strict, have the
+// browser send it. Clients that are not browsers send no Origin, Cookie or
+// Sec-Fetch-Site header and are not asked for a token.
+package browser
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"multithreaded-downloader/apiversion"
+)
+
+const (
+	// CSRFCookie holds the token pages send back in CSRFHeader
+	CSRFCookie = "mtdl_csrf"
+	// CSRFHeader carries the token of CSRFCookie on requests that change
+	// something
+	CSRFHeader = "X-CSRF-Token"
+	// AnyOrigin allows pages of every origin, without credentials
+	AnyOrigin = "*"
+)
+
+var (
+	// ErrOriginNotAllowed is returned for requests that change something
+	// from a page of an origin that is not allowed
+	ErrOriginNotAllowed = errors.New("origin is not allowed to use the API")
+	// ErrCSRFToken is returned for requests from a browser that change
+	// something without the token of the CSRF cookie
+	ErrCSRFToken = errors.New("missing or wrong CSRF token")
+)
+
+// Headers of the API that CORS lets pages send and read
+var (
+	allowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	allowHeaders  = "Content-Type, Authorization, " + apiversion.Header + ", " + CSRFHeader
+	exposeHeaders = apiversion.Header + ", Deprecation, Sunset, Link"
+)
+
+// Policy is how a server answers browsers. The zero Policy allows no
+// cross-origin pages; DefaultPolicy allows every origin as the servers
+// always did.
+type Policy struct {
+	// AllowedOrigins are the origins, such as https://ui.example.com, of
+	// the pages that may call the API; AnyOrigin allows every page
+	AllowedOrigins []string
+	// AllowCredentials lets pages of AllowedOrigins send cookies and HTTP
+	// authentication; it cannot be combined with AnyOrigin
+	AllowCredentials bool
+	// CSRF requires requests from browsers that change something to carry
+	// the token of the CSRF cookie
+	CSRF bool
+	// SecureCookie sets the CSRF cookie for HTTPS only
+	SecureCookie bool
+}
+
+// DefaultPolicy allows pages of every origin and asks for no CSRF token
+var DefaultPolicy = Policy{AllowedOrigins: []string{AnyOrigin}}
+
+// ParseOrigins reads a comma-separated list of origins such as
+// "https://ui.example.com, http://localhost:3000", or "*" for every origin
+func ParseOrigins(raw string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin == AnyOrigin {
+			origins = append(origins, origin)
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid origin %q: want scheme://host[:port]", origin)
+		}
+		origins = append(origins, strings.ToLower(origin))
+	}
+	return origins, nil
+}
+
+// PolicyFromEnv reads the policy of a server: CORS_ALLOWED_ORIGINS lists
+// the allowed origins (* by default), CORS_ALLOW_CREDENTIALS=true lets them
+// send credentials, CSRF_PROTECTION=true turns on CSRF tokens and
+// CSRF_COOKIE_SECURE=true keeps the cookie to HTTPS
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+	if raw, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		origins, err := ParseOrigins(raw)
+		if err != nil {
+			return policy, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS: %w", err)
+		}
+		policy.AllowedOrigins = origins
+	}
+	policy.AllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+	policy.CSRF = os.Getenv("CSRF_PROTECTION") == "true"
+	policy.SecureCookie = os.Getenv("CSRF_COOKIE_SECURE") == "true"
+	if policy.AllowCredentials && policy.anyOrigin() {
+		return policy, errors.New("CORS_ALLOW_CREDENTIALS needs CORS_ALLOWED_ORIGINS to list origins instead of *")
+	}
+	return policy, nil
+}
+
+// anyOrigin reports whether pages of every origin are allowed
+func (p Policy) anyOrigin() bool {
+	for _, origin := range p.AllowedOrigins {
+		if origin == AnyOrigin {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether pages of origin may call the API
+func (p Policy) allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == AnyOrigin || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply sets the CORS headers of the response to r and the CSRF cookie
+// when the browser has none. For a request that changes something it
+// returns ErrOriginNotAllowed when it comes from a page of an origin that
+// is not allowed, and in CSRF mode ErrCSRFToken when a browser sent it
+// without the token.
+func (p Policy) Apply(w http.ResponseWriter, r *http.Request) error {
+	header := w.Header()
+	origin := r.Header.Get("Origin")
+	if !p.anyOrigin() {
+		header.Add("Vary", "Origin")
+	}
+	switch {
+	case p.anyOrigin():
+		header.Set("Access-Control-Allow-Origin", AnyOrigin)
+	case origin != "" && p.allows(origin):
+		header.Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if header.Get("Access-Control-Allow-Origin") != "" {
+		header.Set("Access-Control-Allow-Methods", allowMethods)
+		header.Set("Access-Control-Allow-Headers", allowHeaders)
+		header.Set("Access-Control-Expose-Headers", exposeHeaders)
+	}
+
+	var token string
+	if cookie, err := r.Cookie(CSRFCookie); err == nil && cookie.Value != "" {
+		token = cookie.Value
+	} else if p.CSRF {
+		if err := p.setToken(w); err != nil {
+			return err
+		}
+	}
+
+	if safeMethod(r.Method) {
+		return nil
+	}
+	if origin != "" && !sameHost(origin, r) && !p.allows(origin) {
+		return fmt.Errorf("%w: %s", ErrOriginNotAllowed, origin)
+	}
+	if p.CSRF && fromBrowser(r) {
+		sent := r.Header.Get(CSRFHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			return ErrCSRFToken
+		}
+	}
+	return nil
+}
+
+// setToken gives the browser a new CSRF cookie. Page scripts read it, so
+// it is not HttpOnly.
+func (p Policy) setToken(w http.ResponseWriter) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to make a CSRF token: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    hex.EncodeToString(buf),
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   p.SecureCookie,
+	})
+	return nil
+}
+
+// safeMethod reports whether requests with method only read
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// fromBrowser reports whether r looks like it was sent by a browser, which
+// adds an Origin or Sec-Fetch-Site header, or cookies, on its own
+func fromBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" || r.Header.Get("Cookie") != ""
+}
+
+// sameHost reports whether origin is the server the request was sent to,
+// so pages the server serves itself, such as /docs, are always allowed. The
+// scheme is not compared, as a proxy may have ended TLS.
+func sameHost(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,118 @@
+package browser
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseOrigins(t *testing.T) {
+	origins, err := ParseOrigins(" https://UI.example.com, http://localhost:3000 ,")
+	if err != nil || len(origins) != 2 || origins[0] != "https://ui.example.com" {
+		t.Errorf("ParseOrigins = %q, %v", origins, err)
+	}
+	for _, raw := range []string{"ui.example.com", "https://ui.example.com/app", "ftp://x", "https://"} {
+		if _, err := ParseOrigins(raw); err == nil {
+			t.Errorf("ParseOrigins(%q) accepted", raw)
+		}
+	}
+}
+
+func TestApplyCORS(t *testing.T) {
+	policy := Policy{AllowedOrigins: []string{"https://ui.example.com"}, AllowCredentials: true}
+
+	apply := func(method, origin string) (http.Header, error) {
+		r := httptest.NewRequest(method, "http://api.example.com/api/v2/downloads", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		err := policy.Apply(w, r)
+		return w.Header(), err
+	}
+
+	header, err := apply("GET", "https://ui.example.com")
+	if err != nil || header.Get("Access-Control-Allow-Origin") != "https://ui.example.com" || header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("allowed origin: %v, headers %v", err, header)
+	}
+	header, err = apply("GET", "https://evil.example")
+	if err != nil || header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin reading: %v, headers %v", err, header)
+	}
+	if _, err := apply("POST", "https://evil.example"); !errors.Is(err, ErrOriginNotAllowed) {
+		t.Errorf("other origin posting: %v, want ErrOriginNotAllowed", err)
+	}
+	if _, err := apply("POST", "http://api.example.com"); err != nil {
+		t.Errorf("page of the server itself posting: %v", err)
+	}
+	if _, err := apply("DELETE", ""); err != nil {
+		t.Errorf("client without an origin: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	DefaultPolicy.Apply(w, httptest.NewRequest("GET", "/api/v2/health", nil))
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("default policy headers %v", w.Header())
+	}
+}
+
+func TestApplyCSRF(t *testing.T) {
+	policy := DefaultPolicy
+	policy.CSRF = true
+
+	// The page gets a token with its first response
+	w := httptest.NewRecorder()
+	if err := policy.Apply(w, httptest.NewRequest("GET", "http://localhost:8080/docs", nil)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || cookies[0].SameSite != http.SameSiteStrictMode || cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	token := cookies[0].Value
+
+	post := func(cookie, sent string, browser bool) error {
+		r := httptest.NewRequest("POST", "http://localhost:8080/api/v2/downloads", nil)
+		if browser {
+			r.Header.Set("Origin", "http://localhost:8080")
+		}
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: cookie})
+		}
+		if sent != "" {
+			r.Header.Set(CSRFHeader, sent)
+		}
+		return policy.Apply(httptest.NewRecorder(), r)
+	}
+	if err := post(token, token, true); err != nil {
+		t.Errorf("browser with the token: %v", err)
+	}
+	if err := post(token, "", true); !errors.Is(err, ErrCSRFToken) {
+		t.Errorf("browser without the header: %v, want ErrCSRFToken", err)
+	}
+	if err := post("", "forged", true); !errors.Is(err, ErrCSRFToken) {
+		t.Errorf("browser without the cookie: %v, want ErrCSRFToken", err)
+	}
+	if err := post(token, "other", true); !errors.Is(err, ErrCSRFToken) {
+		t.Errorf("browser with another token: %v, want ErrCSRFToken", err)
+	}
+	if err := post("", "", false); err != nil {
+		t.Errorf("API client: %v", err)
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://ui.example.com")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CSRF_PROTECTION", "true")
+	policy, err := PolicyFromEnv()
+	if err != nil || !policy.CSRF || !policy.AllowCredentials || len(policy.AllowedOrigins) != 1 {
+		t.Errorf("PolicyFromEnv = %+v, %v", policy, err)
+	}
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	if _, err := PolicyFromEnv(); err == nil {
+		t.Error("PolicyFromEnv allowed credentials for every origin")
+	}
+}
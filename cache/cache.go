@@ -0,0 +1,294 @@
+// Package cache keeps finished downloads in a local directory so that later
+// downloads of the same file, by other users or jobs, are hard linked or
+// copied from it instead of fetched again. Entries are found by keys such
+// as the URL with its ETag or a checksum of the content, and the least
+// recently used ones are evicted to keep the directory under a size limit.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is the size limit of a cache opened without one
+const DefaultMaxSize = 10 << 30
+
+// Cache is a directory of finished downloads. Several processes may share
+// one directory; entries are written to temporary files and renamed into
+// place, so a reader never sees half an entry.
+type Cache struct {
+	dir string
+	// MaxSize is the most bytes the entries may take before the least
+	// recently used are evicted
+	MaxSize int64
+	// Copy gives every download its own copy of an entry instead of a hard
+	// link to it, for outputs that are changed in place afterwards
+	Copy bool
+
+	mu sync.Mutex
+}
+
+// Open opens the cache in dir, creating it if needed. A maxSize of 0 means
+// DefaultMaxSize.
+func Open(dir string, maxSize int64) (*Cache, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("invalid cache size %d", maxSize)
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	for _, sub := range []string{"objects", "keys"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("error creating cache directory: %w", err)
+		}
+	}
+	return &Cache{dir: dir, MaxSize: maxSize}, nil
+}
+
+// Dir returns the directory of the cache
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// hashKey names the file of a key; keys may hold URLs with credentials, so
+// they are never written to disk as they are
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) keyPath(key string) string {
+	return filepath.Join(c.dir, "keys", hashKey(key))
+}
+
+func (c *Cache) objectPath(name string) string {
+	return filepath.Join(c.dir, "objects", name)
+}
+
+// lookup returns the object the first of keys that is in the cache points
+// to. Keys whose object was evicted are removed.
+func (c *Cache) lookup(keys []string) (string, bool) {
+	for _, key := range keys {
+		data, err := os.ReadFile(c.keyPath(key))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(data))
+		if _, err := os.Stat(c.objectPath(name)); err != nil {
+			os.Remove(c.keyPath(key))
+			continue
+		}
+		return name, true
+	}
+	return "", false
+}
+
+// Fetch places the entry found under any of keys at dest and reports
+// whether there was one. An entry of another size than size is stale and
+// is dropped.
+func (c *Cache) Fetch(keys []string, size int64, dest string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, ok := c.lookup(keys)
+	if !ok {
+		return false, nil
+	}
+	object := c.objectPath(name)
+	info, err := os.Stat(object)
+	if err != nil {
+		return false, nil
+	}
+	if info.Size() != size {
+		os.Remove(object)
+		return false, nil
+	}
+
+	tmp := dest + ".cache-tmp"
+	os.Remove(tmp)
+	if c.Copy || os.Link(object, tmp) != nil {
+		// Hard links do not cross file systems
+		if err := copyFile(object, tmp); err != nil {
+			os.Remove(tmp)
+			return false, fmt.Errorf("error copying from cache: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("error copying from cache: %w", err)
+	}
+	// The modification time orders entries for eviction
+	now := time.Now()
+	os.Chtimes(object, now, now)
+	return true, nil
+}
+
+// Open opens the entry found under any of keys for reading, e.g. to serve
+// it to another node; it returns an error satisfying os.IsNotExist when
+// there is none
+func (c *Cache) Open(keys []string) (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, ok := c.lookup(keys)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	object := c.objectPath(name)
+	file, err := os.Open(object)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(object, now, now)
+	return file, nil
+}
+
+// Store adds the finished file at src to the cache under keys and evicts
+// the least recently used entries until the cache fits its size limit. A
+// file larger than the limit is not stored.
+func (c *Cache) Store(keys []string, src string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.MaxSize {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, ok := c.lookup(keys)
+	if !ok {
+		name = hashKey(keys[0])
+		object := c.objectPath(name)
+		tmp := object + ".tmp"
+		os.Remove(tmp)
+		if c.Copy || os.Link(src, tmp) != nil {
+			if err := copyFile(src, tmp); err != nil {
+				os.Remove(tmp)
+				return fmt.Errorf("error copying to cache: %w", err)
+			}
+		}
+		if err := os.Rename(tmp, object); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("error copying to cache: %w", err)
+		}
+		now := time.Now()
+		os.Chtimes(object, now, now)
+	}
+	for _, key := range keys {
+		if err := writeFileAtomic(c.keyPath(key), []byte(name+"\n")); err != nil {
+			return fmt.Errorf("error writing cache key: %w", err)
+		}
+	}
+	return c.evict()
+}
+
+// Entry is one file in the cache
+type Entry struct {
+	Name     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// Entries lists the files in the cache, least recently used first
+func (c *Cache) Entries() ([]Entry, error) {
+	files, err := os.ReadDir(filepath.Join(c.dir, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			// Evicted by another process
+			continue
+		}
+		entries = append(entries, Entry{Name: file.Name(), Size: info.Size(), LastUsed: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Size returns how many bytes the entries take
+func (c *Cache) Size() (int64, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	return total, nil
+}
+
+// evict removes the least recently used entries until the rest fit
+// MaxSize. Their keys are removed when they are next looked up.
+func (c *Cache) evict() error {
+	entries, err := c.Entries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	for _, entry := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+		if err := os.Remove(c.objectPath(entry.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error evicting cache entry: %w", err)
+		}
+		total -= entry.Size
+	}
+	return nil
+}
+
+// copyFile copies src to a new file at dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeFileAtomic writes data to path through a temporary file so that
+// readers in other processes never see part of it
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
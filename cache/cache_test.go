@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStoreAndFetch(t *testing.T) {
+	for _, copy := range []bool{false, true} {
+		c, err := Open(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Copy = copy
+		data := []byte("cached content")
+		if err := c.Store([]string{"sha-256:abc", "url:a\netag:1"}, writeTemp(t, "src", data)); err != nil {
+			t.Fatal(err)
+		}
+
+		dest := filepath.Join(t.TempDir(), "dest")
+		// Any of the keys finds the entry
+		hit, err := c.Fetch([]string{"url:b\netag:2", "sha-256:abc"}, int64(len(data)), dest)
+		if err != nil || !hit {
+			t.Fatalf("Fetch() = %v, %v, want a hit", hit, err)
+		}
+		if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+			t.Errorf("fetched %q, want %q", got, data)
+		}
+		if hit, _ := c.Fetch([]string{"url:c"}, int64(len(data)), dest); hit {
+			t.Error("Fetch() hit for an unknown key")
+		}
+	}
+}
+
+func TestFetchDropsEntryOfOtherSize(t *testing.T) {
+	c, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store([]string{"k"}, writeTemp(t, "src", []byte("12345"))); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "dest")
+	if hit, _ := c.Fetch([]string{"k"}, 6, dest); hit {
+		t.Error("Fetch() hit an entry of the wrong size")
+	}
+	if size, _ := c.Size(); size != 0 {
+		t.Errorf("stale entry was kept: cache holds %d bytes", size)
+	}
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := Open(t.TempDir(), 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ten := bytes.Repeat([]byte("x"), 10)
+	for _, key := range []string{"a", "b"} {
+		if err := c.Store([]string{key}, writeTemp(t, key, ten)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Use a so that b is the least recently used
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(c.objectPath(hashKey("a")), old, old)
+	os.Chtimes(c.objectPath(hashKey("b")), old.Add(-time.Minute), old.Add(-time.Minute))
+	if hit, _ := c.Fetch([]string{"a"}, 10, filepath.Join(t.TempDir(), "a")); !hit {
+		t.Fatal("Fetch(a) missed")
+	}
+
+	if err := c.Store([]string{"c"}, writeTemp(t, "c", ten)); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "dest")
+	if hit, _ := c.Fetch([]string{"b"}, 10, dest); hit {
+		t.Error("b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if hit, _ := c.Fetch([]string{key}, 10, dest); !hit {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if err := c.Store([]string{"big"}, writeTemp(t, "big", bytes.Repeat([]byte("x"), 26))); err != nil {
+		t.Fatal(err)
+	}
+	if hit, _ := c.Fetch([]string{"big"}, 26, dest); hit {
+		t.Error("a file larger than the cache was stored")
+	}
+}
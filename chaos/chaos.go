@@ -0,0 +1,217 @@
+// Package chaos injects faults into a worker to show that the queue
+// recovers its jobs: the process killed in the middle of a job, slow Redis
+// commands and a full disk. Workers enable it with CHAOS_ENABLED=true; it is
+// meant for test deployments and must never run in production.
+//
+// A killed worker exits at once, without failing or requeueing its job, as
+// if it were sent SIGKILL. The job stays in the processing queue until the
+// stale-job cleanup requeues it.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DefaultKillAfter bounds when a kill happens after its job started
+	DefaultKillAfter = 30 * time.Second
+	// KillExitCode is what a killed worker exits with, as for SIGKILL
+	KillExitCode = 137
+)
+
+// Faults
+const (
+	Kill         = "kill"
+	RedisLatency = "redis_latency"
+	DiskFull     = "disk_full"
+)
+
+// Config sets which faults are injected and how often
+type Config struct {
+	// KillRate is the share of jobs during which the process exits
+	KillRate float64
+	// KillAfter bounds the random time into a job at which it is killed
+	KillAfter time.Duration
+	// RedisLatency is the most a Redis command is delayed by; every
+	// command waits a random time up to it
+	RedisLatency time.Duration
+	// DiskFullRate is the share of writes to output files that fail with
+	// ENOSPC
+	DiskFullRate float64
+	// Seed makes the faults repeatable; 0 seeds from the clock
+	Seed int64
+}
+
+// ConfigFromEnv reads CHAOS_KILL_RATE, CHAOS_KILL_AFTER,
+// CHAOS_REDIS_LATENCY, CHAOS_DISK_FULL_RATE and CHAOS_SEED
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{KillAfter: DefaultKillAfter}
+	var err error
+	if cfg.KillRate, err = rateEnv("CHAOS_KILL_RATE"); err != nil {
+		return cfg, err
+	}
+	if cfg.DiskFullRate, err = rateEnv("CHAOS_DISK_FULL_RATE"); err != nil {
+		return cfg, err
+	}
+	if raw := os.Getenv("CHAOS_KILL_AFTER"); raw != "" {
+		if cfg.KillAfter, err = time.ParseDuration(raw); err != nil || cfg.KillAfter <= 0 {
+			return cfg, fmt.Errorf("CHAOS_KILL_AFTER must be a positive duration, got %q", raw)
+		}
+	}
+	if raw := os.Getenv("CHAOS_REDIS_LATENCY"); raw != "" {
+		if cfg.RedisLatency, err = time.ParseDuration(raw); err != nil || cfg.RedisLatency < 0 {
+			return cfg, fmt.Errorf("CHAOS_REDIS_LATENCY must be a duration, got %q", raw)
+		}
+	}
+	if raw := os.Getenv("CHAOS_SEED"); raw != "" {
+		if cfg.Seed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return cfg, fmt.Errorf("CHAOS_SEED must be an integer, got %q", raw)
+		}
+	}
+	return cfg, nil
+}
+
+// rateEnv reads a share from 0 to 1 from the environment variable name
+func rateEnv(name string) (float64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%s must be a number from 0 to 1, got %q", name, raw)
+	}
+	return rate, nil
+}
+
+// Monkey injects the faults of a Config. It is safe for concurrent use.
+type Monkey struct {
+	cfg Config
+	// Exit ends the process of a kill; tests replace it to stop a
+	// simulated worker instead
+	Exit func(code int)
+	// OnFault, when set, is told about every fault before it happens
+	OnFault func(fault, jobID string)
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New creates a monkey injecting the faults of cfg
+func New(cfg Config) *Monkey {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if cfg.KillAfter <= 0 {
+		cfg.KillAfter = DefaultKillAfter
+	}
+	return &Monkey{cfg: cfg, Exit: os.Exit, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Config returns the faults m injects
+func (m *Monkey) Config() Config {
+	return m.cfg
+}
+
+// chance reports true with probability rate
+func (m *Monkey) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rand.Float64() < rate
+}
+
+// upTo returns a random duration from 0 to max
+func (m *Monkey) upTo(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Duration(m.rand.Int63n(int64(max) + 1))
+}
+
+func (m *Monkey) fault(fault, jobID string) {
+	if m.OnFault != nil {
+		m.OnFault(fault, jobID)
+	}
+}
+
+// Job is called as a worker starts jobID; with the kill rate's chance the
+// process exits at a random time before KillAfter. The job calls the
+// returned function when it ends, which calls the kill off. A nil Monkey
+// never kills.
+func (m *Monkey) Job(jobID string) (done func()) {
+	if m == nil || !m.chance(m.cfg.KillRate) {
+		return func() {}
+	}
+	timer := time.AfterFunc(m.upTo(m.cfg.KillAfter), func() {
+		m.fault(Kill, jobID)
+		m.Exit(KillExitCode)
+	})
+	return func() { timer.Stop() }
+}
+
+// WriteFault fails a write to an output file with ENOSPC at the disk full
+// rate; it fits downloader.Downloader.WriteFault
+func (m *Monkey) WriteFault(n int) error {
+	if !m.chance(m.cfg.DiskFullRate) {
+		return nil
+	}
+	m.fault(DiskFull, "")
+	return &os.PathError{Op: "write", Path: "chaos", Err: syscall.ENOSPC}
+}
+
+// RedisHook returns a go-redis hook delaying every command and pipeline
+// by up to the Redis latency
+func (m *Monkey) RedisHook() redis.Hook {
+	return latencyHook{m}
+}
+
+// latencyHook delays Redis commands before they are sent
+type latencyHook struct {
+	m *Monkey
+}
+
+func (h latencyHook) delay(ctx context.Context) error {
+	d := h.m.upTo(h.m.cfg.RedisLatency)
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (h latencyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.delay(ctx)
+}
+
+func (h latencyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h latencyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, h.delay(ctx)
+}
+
+func (h latencyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
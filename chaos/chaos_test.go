@@ -0,0 +1,109 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestJobKills(t *testing.T) {
+	killed := make(chan int, 1)
+	m := New(Config{KillRate: 1, KillAfter: 10 * time.Millisecond, Seed: 1})
+	m.Exit = func(code int) { killed <- code }
+	var faults []string
+	m.OnFault = func(fault, jobID string) { faults = append(faults, fault+":"+jobID) }
+
+	defer m.Job("a")()
+	select {
+	case code := <-killed:
+		if code != KillExitCode || len(faults) != 1 || faults[0] != "kill:a" {
+			t.Errorf("killed with %d, faults %v", code, faults)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job was not killed")
+	}
+}
+
+func TestJobDoneCallsKillOff(t *testing.T) {
+	m := New(Config{KillRate: 1, KillAfter: 50 * time.Millisecond, Seed: 1})
+	m.Exit = func(code int) { t.Error("job was killed after it ended") }
+	m.Job("a")()
+	time.Sleep(100 * time.Millisecond)
+
+	// No monkey, no kills
+	var none *Monkey
+	none.Job("b")()
+}
+
+func TestWriteFault(t *testing.T) {
+	if err := New(Config{Seed: 1}).WriteFault(10); err != nil {
+		t.Errorf("WriteFault() without a disk full rate = %v", err)
+	}
+	err := New(Config{DiskFullRate: 1, Seed: 1}).WriteFault(10)
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("WriteFault() = %v, want ENOSPC", err)
+	}
+
+	m := New(Config{DiskFullRate: 0.5, Seed: 1})
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		if m.WriteFault(10) != nil {
+			failed++
+		}
+	}
+	if failed < 400 || failed > 600 {
+		t.Errorf("%d of 1000 writes failed at a rate of 0.5", failed)
+	}
+}
+
+func TestRedisHookDelays(t *testing.T) {
+	hook := New(Config{RedisLatency: 20 * time.Millisecond, Seed: 1}).RedisHook()
+	cmd := redis.NewStatusCmd(context.Background(), "ping")
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := hook.BeforeProcess(context.Background(), cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("10 commands took %v with up to 20ms each", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := New(Config{RedisLatency: time.Hour, Seed: 1}).RedisHook()
+	if _, err := slow.BeforeProcess(ctx, cmd); !errors.Is(err, context.Canceled) {
+		t.Errorf("BeforeProcess() with a cancelled context = %v", err)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	for name, value := range map[string]string{
+		"CHAOS_KILL_RATE":      "0.05",
+		"CHAOS_KILL_AFTER":     "2m",
+		"CHAOS_REDIS_LATENCY":  "50ms",
+		"CHAOS_DISK_FULL_RATE": "0.001",
+		"CHAOS_SEED":           "42",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{KillRate: 0.05, KillAfter: 2 * time.Minute, RedisLatency: 50 * time.Millisecond, DiskFullRate: 0.001, Seed: 42}
+	if cfg != want {
+		t.Errorf("ConfigFromEnv() = %+v, want %+v", cfg, want)
+	}
+
+	os.Setenv("CHAOS_KILL_RATE", "2")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("ConfigFromEnv() accepted a kill rate of 2")
+	}
+}
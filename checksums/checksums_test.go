@@ -0,0 +1,101 @@
+package checksums
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	sumA = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	sumB = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func TestFormat(t *testing.T) {
+	dir := filepath.FromSlash("/data/group")
+	got, err := Format(dir, []Entry{
+		{filepath.Join(dir, "b", "two.bin"), sumB},
+		{filepath.Join(dir, "one.bin"), strings.ToUpper(sumA)},
+		{filepath.Join(dir, "one.bin"), sumA},
+		{filepath.Join(dir, "odd\nname"), sumB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sumB + "  b/two.bin\n" + `\` + sumB + `  odd\nname` + "\n" + sumA + "  one.bin\n"
+	if string(got) != want {
+		t.Errorf("Format() =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := Format(dir, []Entry{{filepath.FromSlash("/data/other/x"), sumA}}); err == nil {
+		t.Error("a file outside the directory was listed")
+	}
+	if _, err := Format(dir, []Entry{{filepath.Join(dir, "x"), "abc"}}); err == nil {
+		t.Error("a malformed sum was listed")
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	paths := []string{
+		filepath.FromSlash("/data/group/a/one.bin"),
+		filepath.FromSlash("/data/group/b/c/two.bin"),
+		filepath.FromSlash("/data/group/three.bin"),
+	}
+	if got := CommonDir(paths); got != filepath.FromSlash("/data/group") {
+		t.Errorf("CommonDir() = %q", got)
+	}
+	if got := CommonDir(paths[:1]); got != filepath.FromSlash("/data/group/a") {
+		t.Errorf("CommonDir() of one file = %q", got)
+	}
+}
+
+func TestWriteAndSign(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := []byte(sumA + "  one.bin\n")
+	signature := Sign(loaded, sums)
+	if err := Write(dir, sums, signature); err != nil {
+		t.Fatal(err)
+	}
+	written, _ := os.ReadFile(filepath.Join(dir, FileName))
+	sig, _ := os.ReadFile(filepath.Join(dir, FileName+SignatureSuffix))
+	public := key.Public().(ed25519.PublicKey)
+	if !Verify(public, written, sig) {
+		t.Error("written signature does not verify")
+	}
+	if Verify(public, append(written, 'x'), sig) {
+		t.Error("signature verifies changed sums")
+	}
+	if pub, err := PublicKeyPEM(loaded); err != nil || !strings.HasPrefix(string(pub), "-----BEGIN PUBLIC KEY-----") {
+		t.Errorf("PublicKeyPEM() = %q, %v", pub, err)
+	}
+
+	// Unsigned sums replace the old ones and drop their signature
+	if err := Write(dir, sums, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, FileName+SignatureSuffix)); !os.IsNotExist(err) {
+		t.Errorf("stale signature kept: %v", err)
+	}
+}
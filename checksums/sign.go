@@ -0,0 +1,52 @@
+package checksums
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadKey reads an Ed25519 private key in PKCS#8 PEM form, as
+// openssl genpkey -algorithm ed25519 writes it
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s holds no PKCS#8 private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s: %w", path, err)
+	}
+	signer, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s holds a %T, not an Ed25519 key", path, key)
+	}
+	return signer, nil
+}
+
+// Sign returns the raw Ed25519 signature of sums, which
+// openssl pkeyutl -verify -pubin -inkey key.pub -rawin checks
+func Sign(key ed25519.PrivateKey, sums []byte) []byte {
+	return ed25519.Sign(key, sums)
+}
+
+// Verify reports whether signature is key's signature of sums
+func Verify(key ed25519.PublicKey, sums, signature []byte) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, sums, signature)
+}
+
+// PublicKeyPEM returns the public half of key in PKIX PEM form, for
+// publishing next to the signatures it checks
+func PublicKeyPEM(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
@@ -0,0 +1,114 @@
+// Package checksums writes the SHA256SUMS file of a set of downloaded
+// files, in the format sha256sum -c reads, and signs it, so whoever copies
+// the files elsewhere can check they arrived whole and came from us.
+package checksums
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileName is the name of the checksum file written next to the files
+const FileName = "SHA256SUMS"
+
+// SignatureSuffix is added to FileName for its detached signature
+const SignatureSuffix = ".sig"
+
+// Entry is one file and its hex SHA-256
+type Entry struct {
+	Path   string
+	SHA256 string
+}
+
+// Format returns the lines of a SHA256SUMS file listing entries by their
+// path relative to dir, sorted so the same files always give the same
+// bytes. A path outside dir or a malformed sum is an error. Names holding
+// a backslash or a newline are escaped the way GNU coreutils does.
+func Format(dir string, entries []Entry) ([]byte, error) {
+	sums := make(map[string]string, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		sum := strings.ToLower(entry.SHA256)
+		if raw, err := hex.DecodeString(sum); err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("invalid SHA-256 %q for %s", entry.SHA256, entry.Path)
+		}
+		rel, err := filepath.Rel(dir, entry.Path)
+		if err != nil || rel == "." || !inside(dir, entry.Path) {
+			return nil, fmt.Errorf("%s is not inside %s", entry.Path, dir)
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := sums[rel]; !ok {
+			names = append(names, rel)
+		}
+		sums[rel] = sum
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		sum := sums[name]
+		if strings.ContainsAny(name, "\\\n") {
+			b.WriteString(`\`)
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, name)
+	}
+	return []byte(b.String()), nil
+}
+
+// CommonDir returns the deepest directory holding every one of paths
+func CommonDir(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	dir := filepath.Dir(paths[0])
+	for _, path := range paths[1:] {
+		for !inside(dir, filepath.Dir(path)) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return dir
+}
+
+// inside reports whether path is dir or lies below it
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Write stores sums as FileName in dir and, when given, signature next to
+// it, each through a temporary file renamed into place so a reader never
+// sees half of one. A stale signature is removed when sums is unsigned.
+func Write(dir string, sums, signature []byte) error {
+	path := filepath.Join(dir, FileName)
+	if err := writeFile(path, sums); err != nil {
+		return err
+	}
+	if signature == nil {
+		if err := os.Remove(path + SignatureSuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale signature: %w", err)
+		}
+		return nil
+	}
+	return writeFile(path+SignatureSuffix, signature)
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
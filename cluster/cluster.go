@@ -0,0 +1,79 @@
+// Package cluster lets several API replicas share one database of downloads.
+// The replica running a download owns it through a lease stored with the
+// download record and renewed while the replica is alive. Requests that change
+// a download are forwarded to the replica that owns it, and downloads whose
+// owner stopped renewing its lease may be claimed by any other replica.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// ForwardedHeader marks a request one replica forwarded to another. It holds
+// the forwarding replica's ID; forwarded requests are never forwarded again.
+const ForwardedHeader = "X-Forwarded-By-Node"
+
+// ErrNoOwnerURL is returned when forwarding to a replica that did not
+// advertise where it can be reached
+var ErrNoOwnerURL = errors.New("owner did not advertise a URL")
+
+// Node is an API replica
+type Node struct {
+	// ID names the replica and is stored as the owner of its downloads
+	ID string
+	// URL is where other replicas reach this one; replicas without one are
+	// never forwarded to
+	URL string
+}
+
+// Lease is a replica's claim on a download
+type Lease struct {
+	Owner    string
+	OwnerURL string
+	Expires  time.Time
+}
+
+// Live reports whether some replica holds the lease at now
+func (l Lease) Live(now time.Time) bool {
+	return l.Owner != "" && now.Before(l.Expires)
+}
+
+// CanClaim reports whether n may take over a download under lease l: it
+// already owns it, or nobody holds the lease any more
+func (n Node) CanClaim(l Lease, now time.Time) bool {
+	return l.Owner == n.ID || !l.Live(now)
+}
+
+// ShouldForward reports whether r must go to the live replica holding l
+// instead of being handled by n
+func (n Node) ShouldForward(l Lease, r *http.Request, now time.Time) bool {
+	return !n.CanClaim(l, now) && l.OwnerURL != "" && r.Header.Get(ForwardedHeader) == ""
+}
+
+// Forward proxies r to the replica holding l and copies its response to w.
+// Nothing is written to w when the owner cannot be reached, so the caller can
+// answer with its own error.
+func (n Node) Forward(w http.ResponseWriter, r *http.Request, l Lease) error {
+	if l.OwnerURL == "" {
+		return ErrNoOwnerURL
+	}
+	target, err := url.Parse(l.OwnerURL)
+	if err != nil {
+		return fmt.Errorf("invalid owner URL %q: %w", l.OwnerURL, err)
+	}
+
+	var proxyErr error
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		proxyErr = fmt.Errorf("failed to reach %s: %w", l.Owner, err)
+	}
+
+	r.Header.Set(ForwardedHeader, n.ID)
+	proxy.ServeHTTP(w, r)
+	return proxyErr
+}
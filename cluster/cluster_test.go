@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaimAndForwardDecisions(t *testing.T) {
+	now := time.Now()
+	self := Node{ID: "api-1", URL: "http://api-1:8080"}
+
+	tests := []struct {
+		name      string
+		lease     Lease
+		forwarded bool
+		claim     bool
+		forward   bool
+	}{
+		{name: "unowned", lease: Lease{}, claim: true},
+		{name: "own lease", lease: Lease{Owner: "api-1", Expires: now.Add(time.Minute)}, claim: true},
+		{name: "own expired lease", lease: Lease{Owner: "api-1", Expires: now.Add(-time.Minute)}, claim: true},
+		{name: "live owner", lease: Lease{Owner: "api-2", OwnerURL: "http://api-2:8080", Expires: now.Add(time.Minute)}, forward: true},
+		{name: "live owner without URL", lease: Lease{Owner: "api-2", Expires: now.Add(time.Minute)}},
+		{name: "already forwarded", lease: Lease{Owner: "api-2", OwnerURL: "http://api-2:8080", Expires: now.Add(time.Minute)}, forwarded: true},
+		{name: "expired owner", lease: Lease{Owner: "api-2", OwnerURL: "http://api-2:8080", Expires: now.Add(-time.Second)}, claim: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/downloads/d1/pause", nil)
+			if tt.forwarded {
+				r.Header.Set(ForwardedHeader, "api-3")
+			}
+			if got := self.CanClaim(tt.lease, now); got != tt.claim {
+				t.Errorf("CanClaim() = %v, want %v", got, tt.claim)
+			}
+			if got := self.ShouldForward(tt.lease, r, now); got != tt.forward {
+				t.Errorf("ShouldForward() = %v, want %v", got, tt.forward)
+			}
+		})
+	}
+}
+
+func TestForwardProxiesToOwner(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-From", r.Header.Get(ForwardedHeader))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	}))
+	defer owner.Close()
+
+	self := Node{ID: "api-1"}
+	r := httptest.NewRequest("POST", "/api/v2/downloads/d1/resume", strings.NewReader("payload"))
+	w := httptest.NewRecorder()
+	if err := self.Forward(w, r, Lease{Owner: "api-2", OwnerURL: owner.URL}); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+
+	if w.Code != http.StatusAccepted || w.Body.String() != "payload" {
+		t.Fatalf("response = %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Path"); got != "/api/v2/downloads/d1/resume" {
+		t.Errorf("owner saw path %q", got)
+	}
+	if got := w.Header().Get("X-From"); got != "api-1" {
+		t.Errorf("owner saw %s = %q", ForwardedHeader, got)
+	}
+}
+
+func TestForwardReportsUnreachableOwner(t *testing.T) {
+	owner := httptest.NewServer(http.NotFoundHandler())
+	ownerURL := owner.URL
+	owner.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/downloads/d1/pause", nil)
+	err := Node{ID: "api-1"}.Forward(w, r, Lease{Owner: "api-2", OwnerURL: ownerURL})
+	if err == nil {
+		t.Fatal("Forward() to a stopped replica succeeded")
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Forward() wrote %q on failure", w.Body.String())
+	}
+
+	if err := (Node{}).Forward(w, r, Lease{Owner: "api-2"}); !errors.Is(err, ErrNoOwnerURL) {
+		t.Errorf("Forward() without an owner URL error = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package cron
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	start := time.Date(2024, time.March, 15, 10, 30, 20, 0, time.UTC) // a Friday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.March, 15, 10, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.March, 16, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.March, 15, 10, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, time.March, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, time.March, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon", time.Date(2024, time.March, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,20 * *", time.Date(2024, time.March, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches
+		{"0 0 1 * fri", time.Date(2024, time.March, 22, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", time.Date(2024, time.March, 15, 12, 0, 20, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(start); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("February 30th: Next = %s, want zero", got)
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	s, err := Parse("30 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clocks go back at 2:00 on November 3rd 2024, so 1:30 happens twice
+	first := time.Date(2024, time.November, 3, 1, 30, 0, 0, loc)
+	second := s.Next(first)
+	if got := second.Sub(first); got != time.Hour {
+		t.Errorf("after %s: Next = %s, %s later; want an hour later", first, second, got)
+	}
+	if next := s.Next(second); next.Sub(second) != time.Hour || next.Hour() != 2 {
+		t.Errorf("after %s: Next = %s, want 2:30", second, next)
+	}
+
+	// And forward at 2:00 on March 10th, so 2:30 never happens
+	daily, _ := Parse("30 2 * * *")
+	got := daily.Next(time.Date(2024, time.March, 9, 12, 0, 0, 0, loc))
+	if want := time.Date(2024, time.March, 11, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("2:30 daily over the gap: Next = %s, want %s", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		"@sometimes",
+		"@every 10s",
+		"@every soon",
+	} {
+		if _, err := Parse(spec); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) = %v, want ErrInvalid", spec, err)
+		}
+	}
+}
@@ -1,14 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
+	"multithreaded-downloader/cluster"
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/events"
+	"multithreaded-downloader/leader"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/resilience"
+	"multithreaded-downloader/usage"
 )
 
 // Download represents a download record in the database
@@ -17,18 +39,294 @@ type Download struct {
 	URL             string    `gorm:"not null" json:"url"`
 	OutputPath      string    `gorm:"not null" json:"output_path"`
 	Threads         int       `gorm:"not null;default:4" json:"threads"`
-	Status          string    `gorm:"not null;default:'downloading'" json:"status"`
+	// RequestedThreads is the thread count asked for when Threads had to
+	// be lowered, e.g. for a file too small to split that many ways
+	RequestedThreads int      `gorm:"default:0" json:"requested_threads,omitempty"`
+	Status          lifecycle.Status `gorm:"type:text;not null;default:'downloading'" json:"status"`
 	BytesDownloaded int64     `gorm:"default:0" json:"bytes_downloaded"`
 	TotalBytes      int64     `gorm:"default:0" json:"total_bytes"`
 	StartTime       time.Time `gorm:"not null" json:"start_time"`
 	UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
 	Error           string    `gorm:"type:text" json:"error,omitempty"`
+	UserAgent       string    `gorm:"type:text" json:"user_agent,omitempty"`
+	Referer         string    `gorm:"type:text" json:"referer,omitempty"`
+	// Method, RequestBody and BodyType replace the plain GET for endpoints
+	// that hand out the file only to e.g. a POST; the body is never listed
+	Method          string    `gorm:"type:text" json:"method,omitempty"`
+	RequestBody     []byte    `json:"-"`
+	BodyType        string    `gorm:"type:text" json:"body_type,omitempty"`
+	// RefreshURL is asked for a new link when the server refuses the
+	// download's link as expired; it may carry a token and is never listed
+	RefreshURL      string    `gorm:"type:text" json:"-"`
+	// PartURLs are the pieces joined into the output, one per line, for a
+	// file published split into several URLs
+	PartURLs        string    `gorm:"type:text" json:"-"`
+	// CreatedBy is who asked for the download, the client address of the
+	// request, and Tags label it, one per line; the bytes it transfers are
+	// accounted to both
+	CreatedBy       string    `gorm:"type:text" json:"created_by,omitempty"`
+	Tags            string    `gorm:"type:text" json:"-"`
+	// ChecksumStatus is the result of checking the finished file against
+	// a checksum the server sent, ChecksumAlgorithm and ChecksumSource
+	// the checksum checked; all are empty when the server sent none
+	ChecksumStatus    string  `gorm:"type:text" json:"checksum_status,omitempty"`
+	ChecksumAlgorithm string  `gorm:"type:text" json:"checksum_algorithm,omitempty"`
+	ChecksumSource    string  `gorm:"type:text" json:"checksum_source,omitempty"`
+	// Environment is the JSON of the download's downloader.Environment,
+	// served by the environment route rather than listed
+	Environment       string  `gorm:"type:text" json:"-"`
+	// Seq is the sequence number of the last progress or status write
+	Seq             int64     `gorm:"not null;default:0" json:"-"`
+	// Owner is the API replica running the download and OwnerURL where the
+	// other replicas reach it; the claim lapses at LeaseExpiresAt
+	Owner           string    `gorm:"type:text;index" json:"owner,omitempty"`
+	OwnerURL        string    `gorm:"type:text" json:"-"`
+	LeaseExpiresAt  time.Time `json:"-"`
 }
 
+// Pieces returns the URLs joined into the download's output, if any
+func (d *Download) Pieces() []string {
+	if d.PartURLs == "" {
+		return nil
+	}
+	return strings.Split(d.PartURLs, "\n")
+}
+
+// TagList returns the tags of the download
+func (d *Download) TagList() []string {
+	if d.Tags == "" {
+		return nil
+	}
+	return strings.Split(d.Tags, "\n")
+}
+
+// Attribution returns who and what the download's bytes are accounted to
+func (d *Download) Attribution() usage.Attribution {
+	return usage.Attribution{User: d.CreatedBy, Domain: usage.DomainOf(d.URL), Tags: d.TagList(), Bytes: d.BytesDownloaded}
+}
+
+// Lease returns the replica's claim on the download
+func (d *Download) Lease() cluster.Lease {
+	return cluster.Lease{Owner: d.Owner, OwnerURL: d.OwnerURL, Expires: d.LeaseExpiresAt}
+}
+
+// LeaderLease records which replica leads a maintenance task
+type LeaderLease struct {
+	Name      string    `gorm:"primaryKey;type:text"`
+	Holder    string    `gorm:"type:text;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// Setting is one runtime setting, stored by name
+type Setting struct {
+	Name      string    `gorm:"primaryKey;type:text"`
+	Value     string    `gorm:"type:text;not null"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// AuditEntry records one change an operator made through the API
+type AuditEntry struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	Time     time.Time `gorm:"not null;index" json:"time"`
+	// Actor is who made the change, e.g. the client address
+	Actor    string    `gorm:"type:text" json:"actor"`
+	// Action names what changed, e.g. "settings.update", and Target the
+	// object it changed
+	Action   string    `gorm:"type:text;not null;index" json:"action"`
+	Target   string    `gorm:"type:text" json:"target"`
+	OldValue string    `gorm:"type:text" json:"old_value"`
+	NewValue string    `gorm:"type:text" json:"new_value"`
+}
+
+// DownloadEvent records one state change of a download, so users can see
+// when it stalled, was requeued or failed
+type DownloadEvent struct {
+	ID              uint      `gorm:"primaryKey"`
+	DownloadID      string    `gorm:"type:text;not null;index"`
+	Type            string    `gorm:"type:text;not null"`
+	Status          string    `gorm:"type:text"`
+	Time            time.Time `gorm:"not null"`
+	// Node is the process the change happened on and Actor who caused it
+	// there, if anyone
+	Node            string    `gorm:"type:text"`
+	Actor           string    `gorm:"type:text"`
+	Reason          string    `gorm:"type:text"`
+	Error           string    `gorm:"type:text"`
+	BytesDownloaded int64
+}
+
+// API describes the event as the API reports it
+func (e DownloadEvent) API() openapi.DownloadEvent {
+	return openapi.DownloadEvent{
+		ID:              int(e.ID),
+		Type:            e.Type,
+		Status:          e.Status,
+		Time:            e.Time.Format(time.RFC3339Nano),
+		Node:            e.Node,
+		Actor:           e.Actor,
+		Reason:          e.Reason,
+		Error:           e.Error,
+		BytesDownloaded: e.BytesDownloaded,
+	}
+}
+
+// TransferUsage is the bytes transferred on one day for one user, tag or
+// domain
+type TransferUsage struct {
+	ID        uint      `gorm:"primaryKey"`
+	Day       time.Time `gorm:"not null;uniqueIndex:idx_usage_key"`
+	Dimension string    `gorm:"type:text;not null;uniqueIndex:idx_usage_key"`
+	Key       string    `gorm:"type:text;not null;uniqueIndex:idx_usage_key"`
+	Bytes     int64     `gorm:"not null;default:0"`
+}
+
+// Artifact records a file a download produced and where it is stored
+type Artifact struct {
+	ID                uint      `gorm:"primaryKey" json:"-"`
+	DownloadID        string    `gorm:"type:text;not null;uniqueIndex:idx_artifact_path" json:"-"`
+	// Path is absolute on the node that wrote the file
+	Path              string    `gorm:"type:text;not null;uniqueIndex:idx_artifact_path" json:"path"`
+	Size              int64     `gorm:"not null" json:"size_bytes"`
+	// Checksum is the hex digest of the file as stored, so of the
+	// ciphertext when Encrypted is set
+	Checksum          string    `gorm:"type:text;not null" json:"checksum"`
+	ChecksumAlgorithm string    `gorm:"type:text;not null" json:"checksum_algorithm"`
+	// Backend is where the file was written; only the node's local
+	// filesystem for now
+	Backend           string    `gorm:"type:text;not null" json:"backend"`
+	Node              string    `gorm:"type:text" json:"node,omitempty"`
+	Encrypted         bool      `gorm:"not null;default:false" json:"encrypted"`
+	CreatedAt         time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ArtifactBackendLocal is the backend of files on a worker's filesystem
+const ArtifactBackendLocal = "local"
+
+// GroupChecksums records the SHA256SUMS file written once every job of a
+// download group finished
+type GroupChecksums struct {
+	GroupID   string    `gorm:"primaryKey;type:text"`
+	// Sums is the content of the file, which lists the files by their path
+	// relative to Dir
+	Sums      string    `gorm:"type:text;not null"`
+	// Signature and PublicKey are empty when no signing key is configured
+	Signature []byte
+	PublicKey string    `gorm:"type:text"`
+	// Dir is where the files and SHA256SUMS are stored on Node
+	Dir       string    `gorm:"type:text;not null"`
+	Node      string    `gorm:"type:text"`
+	Files     int       `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// Schedule enqueues a download again and again on a cron schedule
+type Schedule struct {
+	ID        string    `gorm:"primaryKey;type:text"`
+	Name      string    `gorm:"type:text"`
+	Cron      string    `gorm:"type:text;not null"`
+	Timezone  string    `gorm:"type:text;not null"`
+	// Job is the DownloadJob every run enqueues under a new ID, as JSON
+	// with its credentials sealed
+	Job       string    `gorm:"type:text;not null"`
+	Paused    bool      `gorm:"not null;default:false"`
+	// NextRun is when the schedule is due; runs claim it by moving it on
+	NextRun   time.Time `gorm:"not null;index"`
+	LastRun   time.Time
+	// LastJobID is the job the latest run enqueued
+	LastJobID string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// ScheduleRun records one time a schedule fired
+type ScheduleRun struct {
+	ID         uint      `gorm:"primaryKey"`
+	ScheduleID string    `gorm:"type:text;not null;index"`
+	Time       time.Time `gorm:"not null"`
+	// Result is ScheduleEnqueued, ScheduleSkipped or ScheduleFailed
+	Result     string    `gorm:"type:text;not null"`
+	JobID      string    `gorm:"type:text"`
+	Details    string    `gorm:"type:text"`
+}
+
+// Results of a schedule run
+const (
+	ScheduleEnqueued = "enqueued"
+	ScheduleSkipped  = "skipped"
+	ScheduleFailed   = "failed"
+)
+
+// maxScheduleRuns is how many runs are kept per schedule
+const maxScheduleRuns = 1000
+
+// ErrScheduleNotFound is returned for a schedule ID that does not exist
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Probe caches what a range check learned about a URL, so downloads of the
+// same URL soon after skip the check
+type Probe struct {
+	// URLHash is the SHA-256 of the URL, which may carry credentials
+	URLHash        string    `gorm:"column:url_hash;primaryKey;type:text"`
+	SupportsRanges bool      `gorm:"not null"`
+	Size           int64     `gorm:"not null"`
+	ETag           string    `gorm:"column:etag;type:text"`
+	LastModified   string    `gorm:"type:text"`
+	// Digests are the checksums the server sent, as JSON
+	Digests        string    `gorm:"type:text"`
+	ProbedAt       time.Time `gorm:"not null;index"`
+}
+
+// DefaultProbeCacheTTL is how long a range check is reused unless
+// PROBE_CACHE_TTL says otherwise
+const DefaultProbeCacheTTL = 10 * time.Minute
+
 // DatabaseManager handles all database operations
 type DatabaseManager struct {
 	db *gorm.DB
+	// breaker fails writes fast while the database is down, and pending
+	// holds the lifecycle events recorded meanwhile until it is back
+	breaker *resilience.Breaker
+	pending *resilience.Outbox
+	stop    context.CancelFunc
+	stopped sync.WaitGroup
+}
+
+// dbRetry retries writes that lost their connection for about a second
+// before the outbox or the caller takes over
+var dbRetry = resilience.Policy{
+	Attempts:  4,
+	Initial:   100 * time.Millisecond,
+	Max:       time.Second,
+	Transient: dbUnavailable,
+}
+
+const (
+	// dbBreakerThreshold writes failing in a row open the breaker for
+	// dbBreakerCooldown
+	dbBreakerThreshold = 5
+	dbBreakerCooldown  = 5 * time.Second
+	// dbFlushInterval is how often events held during an outage are retried
+	dbFlushInterval = 2 * time.Second
+	// maxPendingEvents caps the events held during an outage; progress is
+	// held once per download, so this counts downloads and status changes
+	maxPendingEvents = 10000
+)
+
+// dbUnavailable reports whether err means the database could not be
+// reached, as opposed to an error the database answered with
+func dbUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, resilience.ErrOpen) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// retry runs a database write with dbRetry behind the breaker
+func (dm *DatabaseManager) retry(write func() error) error {
+	return resilience.Retry(context.Background(), dbRetry, dm.breaker, write)
 }
 
 var dbManager *DatabaseManager
@@ -76,16 +374,48 @@ func InitPostgreSQLDatabase(databaseURL string) error {
 	}
 
 	// Auto-migrate the schema
-	if err := db.AutoMigrate(&Download{}); err != nil {
+	if err := db.AutoMigrate(&Download{}, &LeaderLease{}, &Setting{}, &AuditEntry{}, &Artifact{}, &Schedule{}, &ScheduleRun{}, &Probe{}, &DownloadEvent{}, &TransferUsage{}, &GroupChecksums{}); err != nil {
 		return fmt.Errorf("failed to migrate database schema: %w", err)
 	}
 
-	dbManager = &DatabaseManager{db: db}
+	dbManager = newDatabaseManager(db)
 	
 	fmt.Println("PostgreSQL database initialized successfully")
 	return nil
 }
 
+// newDatabaseManager wraps db and starts sending held events once it is back
+func newDatabaseManager(db *gorm.DB) *DatabaseManager {
+	dm := &DatabaseManager{
+		db:      db,
+		breaker: resilience.NewBreaker("database", dbBreakerThreshold, dbBreakerCooldown),
+		pending: resilience.NewOutbox(maxPendingEvents, dbUnavailable),
+	}
+	dm.breaker.OnChange = func(name string, open bool) {
+		if open {
+			fmt.Printf("Database unavailable, holding download updates until it is back\n")
+		} else {
+			fmt.Printf("Database available again\n")
+		}
+	}
+	dm.pending.OnDrop = func(key string) {
+		fmt.Printf("Too many download updates held for the database, dropping one for %s\n", key)
+	}
+	
+	ctx, stop := context.WithCancel(context.Background())
+	dm.stop = stop
+	dm.stopped.Add(1)
+	go func() {
+		defer dm.stopped.Done()
+		dm.pending.Run(ctx, dbFlushInterval, func(flushed int, err error) {
+			if flushed > 0 {
+				fmt.Printf("Recorded %d held download updates, %d still held\n", flushed, dm.pending.Len())
+			}
+		})
+	}()
+	return dm
+}
+
 // GetDB returns the database instance
 func GetDB() *gorm.DB {
 	if dbManager == nil {
@@ -101,42 +431,54 @@ func (dm *DatabaseManager) CreateDownload(id, url, outputPath string, threads in
 		URL:        url,
 		OutputPath: outputPath,
 		Threads:    threads,
-		Status:     "downloading",
+		Status:     lifecycle.Downloading,
 		StartTime:  time.Now(),
+		Seq:        lifecycle.NextSeq(),
 	}
 
-	if err := dm.db.Create(download).Error; err != nil {
+	if err := dm.retry(func() error { return dm.db.Create(download).Error }); err != nil {
 		return nil, fmt.Errorf("failed to create download record: %w", err)
 	}
 
 	return download, nil
 }
 
-// UpdateDownloadProgress updates the progress of a download
-func (dm *DatabaseManager) UpdateDownloadProgress(id string, bytesDownloaded, totalBytes int64, status string) error {
+// UpdateDownloadProgress updates the progress of a download taken at
+// sequence number seq. The update is skipped with lifecycle.ErrStale when a
+// newer write was already stored, and the status only changes if the current
+// status may move to it.
+func (dm *DatabaseManager) UpdateDownloadProgress(id string, bytesDownloaded, totalBytes int64, status lifecycle.Status, seq int64) error {
 	updates := map[string]interface{}{
 		"bytes_downloaded": bytesDownloaded,
 		"total_bytes":      totalBytes,
 		"status":           status,
+		"seq":              seq,
 		"updated_at":       time.Now(),
 	}
 
-	result := dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update download progress: %w", result.Error)
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ? AND status IN ? AND seq < ?", id, lifecycle.Sources(status), seq).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download progress: %w", err)
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("download with id %s not found", id)
+		return dm.conditionalUpdateError(id, status, seq)
 	}
 
 	return nil
 }
 
-// UpdateDownloadStatus updates the status and error message of a download
-func (dm *DatabaseManager) UpdateDownloadStatus(id, status, errorMsg string) error {
+// UpdateDownloadStatus updates the status and error message of a download if
+// no newer write than seq was stored and the current status may move to the
+// new one
+func (dm *DatabaseManager) UpdateDownloadStatus(id string, status lifecycle.Status, errorMsg string, seq int64) error {
 	updates := map[string]interface{}{
 		"status":     status,
+		"seq":        seq,
 		"updated_at": time.Now(),
 	}
 
@@ -144,9 +486,483 @@ func (dm *DatabaseManager) UpdateDownloadStatus(id, status, errorMsg string) err
 		updates["error"] = errorMsg
 	}
 
-	result := dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ? AND status IN ? AND seq < ?", id, lifecycle.Sources(status), seq).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download status: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return dm.conditionalUpdateError(id, status, seq)
+	}
+
+	return nil
+}
+
+// conditionalUpdateError explains why a conditional update matched no row:
+// the download is missing, a newer write than seq was stored, or its current
+// status cannot move to next
+func (dm *DatabaseManager) conditionalUpdateError(id string, next lifecycle.Status, seq int64) error {
+	download, err := dm.GetDownload(id)
+	if err != nil {
+		return err
+	}
+	if download.Seq >= seq {
+		return fmt.Errorf("download %s: %w", id, lifecycle.ErrStale)
+	}
+	if _, err := download.Status.Transition(next); err != nil {
+		return fmt.Errorf("download %s: %w", id, err)
+	}
+	return fmt.Errorf("download %s: status changed to %s during the update", id, download.Status)
+}
+
+// ApplyEvent records a lifecycle event in the database. It is subscribed to
+// the event bus, so handlers and workers publish changes instead of writing
+// them here themselves. Records are created synchronously with
+// CreateDownload, so created events are ignored, and events older than the
+// stored state are dropped silently. While the database is unavailable the
+// event is held and recorded once it is back, so an outage does not fail
+// the downloads that were running; the newest progress of a download
+// replaces the held one.
+func (dm *DatabaseManager) ApplyEvent(e events.Event) {
+	var key string
+	var write func(ctx context.Context) error
+	switch {
+	case e.Type == events.Created:
+		return
+	case e.Type == events.Deleted:
+		write = func(ctx context.Context) error { return dm.DeleteDownload(e.DownloadID) }
+	case e.Status == "":
+		return
+	case e.TotalBytes > 0 && e.Error == "":
+		key = "progress:" + e.DownloadID
+		write = func(ctx context.Context) error {
+			return dm.UpdateDownloadProgress(e.DownloadID, e.BytesDownloaded, e.TotalBytes, e.Status, e.Seq)
+		}
+	default:
+		write = func(ctx context.Context) error {
+			return dm.UpdateDownloadStatus(e.DownloadID, e.Status, e.Error, e.Seq)
+		}
+	}
+
+	// The write reports its own errors, also when it is replayed later
+	dm.pending.Do(context.Background(), key, func(ctx context.Context) error {
+		err := write(ctx)
+		if err != nil && !dbUnavailable(err) && !errors.Is(err, lifecycle.ErrStale) {
+			fmt.Printf("Error recording %s event for download %s: %v\n", e.Type, e.DownloadID, err)
+		}
+		return err
+	})
+}
+
+// RecordEvent adds a state change to its download's history. It is
+// subscribed to the event bus for the Transitions of events published on
+// the node; like ApplyEvent, it holds events while the database is
+// unavailable.
+func (dm *DatabaseManager) RecordEvent(e events.Event) {
+	row := DownloadEvent{
+		DownloadID:      e.DownloadID,
+		Type:            string(e.Type),
+		Status:          string(e.Status),
+		Time:            e.Time,
+		Node:            e.Node,
+		Actor:           e.Actor,
+		Reason:          e.Reason,
+		Error:           e.Error,
+		BytesDownloaded: e.BytesDownloaded,
+	}
+	dm.pending.Do(context.Background(), "", func(ctx context.Context) error {
+		err := dm.retry(func() error { return dm.db.Create(&row).Error })
+		if err != nil && !dbUnavailable(err) {
+			fmt.Printf("Error recording %s event for download %s: %v\n", e.Type, e.DownloadID, err)
+		}
+		return err
+	})
+}
+
+// GetDownloadEvents returns the newest limit events of a download, oldest
+// first
+func (dm *DatabaseManager) GetDownloadEvents(downloadID string, limit int) ([]DownloadEvent, error) {
+	var rows []DownloadEvent
+	result := dm.db.Where("download_id = ?", downloadID).Order("time DESC, id DESC").Limit(limit).Find(&rows)
 	if result.Error != nil {
-		return fmt.Errorf("failed to update download status: %w", result.Error)
+		return nil, fmt.Errorf("failed to get download events: %w", result.Error)
+	}
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	return rows, nil
+}
+
+// AddUsage adds transferred bytes to those recorded for the same day,
+// dimension and key; it is the store of the usage meter
+func (dm *DatabaseManager) AddUsage(records []usage.Record) error {
+	rows := make([]TransferUsage, len(records))
+	for i, r := range records {
+		rows[i] = TransferUsage{Day: r.Day, Dimension: r.Dimension, Key: r.Key, Bytes: r.Bytes}
+	}
+	err := dm.retry(func() error {
+		return dm.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "day"}, {Name: "dimension"}, {Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"bytes": gorm.Expr("transfer_usages.bytes + excluded.bytes")}),
+		}).CreateInBatches(rows, 100).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record transfer usage: %w", err)
+	}
+	return nil
+}
+
+// GetUsage returns the bytes recorded for a dimension on the days from
+// from up to but not including to
+func (dm *DatabaseManager) GetUsage(dimension string, from, to time.Time) ([]usage.Record, error) {
+	var rows []TransferUsage
+	result := dm.db.Where("dimension = ? AND day >= ? AND day < ?", dimension, from, to).Order("day").Find(&rows)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get transfer usage: %w", result.Error)
+	}
+	records := make([]usage.Record, len(rows))
+	for i, row := range rows {
+		records[i] = usage.Record{Day: row.Day, Dimension: row.Dimension, Key: row.Key, Bytes: row.Bytes}
+	}
+	return records, nil
+}
+
+// UsageAttribution returns who and what a download's bytes are accounted
+// to; it is the lookup of the usage meter
+func (dm *DatabaseManager) UsageAttribution(id string) (usage.Attribution, error) {
+	download, err := dm.GetDownload(id)
+	if err != nil {
+		return usage.Attribution{}, err
+	}
+	return download.Attribution(), nil
+}
+
+// dbProgressBatch sets how often progress is written to the database: every
+// few seconds, and less often once a batch holds more downloads than the
+// database should update in that time
+var dbProgressBatch = events.BatchPolicy{Min: 3 * time.Second, Max: 30 * time.Second, PerSecond: 100}
+
+// progressBatchRows is the most downloads one batched progress update
+// writes, well within PostgreSQL's limit on bind parameters
+const progressBatchRows = 1000
+
+// ApplyProgressBatch records a batch of progress events in as few statements
+// as possible: one multi-row update per status, each skipping downloads that
+// already stored a newer write or cannot move to the status. Events without
+// known progress are applied one by one with ApplyEvent, and so is the whole
+// batch while events are held for an unavailable database, so it is held
+// behind them.
+func (dm *DatabaseManager) ApplyProgressBatch(batch []events.Event) {
+	byStatus := make(map[lifecycle.Status][]events.Event)
+	for _, e := range batch {
+		if e.Status == "" || e.TotalBytes <= 0 || e.Error != "" || dm.pending.Len() > 0 {
+			dm.ApplyEvent(e)
+			continue
+		}
+		byStatus[e.Status] = append(byStatus[e.Status], e)
+	}
+	
+	for status, rows := range byStatus {
+		for len(rows) > 0 {
+			n := len(rows)
+			if n > progressBatchRows {
+				n = progressBatchRows
+			}
+			err := dm.updateProgressRows(status, rows[:n])
+			if dbUnavailable(err) {
+				for _, e := range rows[:n] {
+					dm.ApplyEvent(e)
+				}
+			} else if err != nil {
+				fmt.Printf("Error recording progress of %d downloads: %v\n", n, err)
+			}
+			rows = rows[n:]
+		}
+	}
+}
+
+// updateProgressRows writes the progress of rows, which all move to status,
+// in a single UPDATE ... FROM (VALUES ...) statement
+func (dm *DatabaseManager) updateProgressRows(status lifecycle.Status, rows []events.Event) error {
+	values := make([]string, len(rows))
+	args := []interface{}{status, time.Now()}
+	for i, e := range rows {
+		values[i] = "(?, ?::bigint, ?::bigint, ?::bigint)"
+		args = append(args, e.DownloadID, e.BytesDownloaded, e.TotalBytes, e.Seq)
+	}
+	args = append(args, lifecycle.Sources(status))
+	
+	query := `UPDATE downloads AS d
+SET bytes_downloaded = v.bytes_downloaded, total_bytes = v.total_bytes, status = ?, seq = v.seq, updated_at = ?
+FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(id, bytes_downloaded, total_bytes, seq)
+WHERE d.id = v.id AND d.status IN ? AND d.seq < v.seq`
+	
+	if err := dm.retry(func() error { return dm.db.Exec(query, args...).Error }); err != nil {
+		return fmt.Errorf("failed to update download progress: %w", err)
+	}
+	return nil
+}
+
+// ClaimDownload makes node the owner of a download for ttl, unless another
+// replica still holds a live lease on it. It reports whether node owns the
+// download afterwards.
+func (dm *DatabaseManager) ClaimDownload(id string, node cluster.Node, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"owner":            node.ID,
+		"owner_url":        node.URL,
+		"lease_expires_at": now.Add(ttl),
+	}
+
+	result := dm.db.Model(&Download{}).
+		Where("id = ? AND (owner = ? OR owner IS NULL OR owner = '' OR lease_expires_at < ?)", id, node.ID, now).
+		Updates(updates)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim download: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// databaseLeaderStore keeps leader leases in the leader_leases table, for
+// replicas that share a database but no Redis
+type databaseLeaderStore struct {
+	db *gorm.DB
+}
+
+// LeaderStore returns the store replicas sharing this database elect their
+// maintenance leaders in
+func (dm *DatabaseManager) LeaderStore() leader.Store {
+	return &databaseLeaderStore{db: dm.db}
+}
+
+// Acquire takes or renews the lease name for id
+func (s *databaseLeaderStore) Acquire(ctx context.Context, name, id string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	lease := LeaderLease{Name: name, Holder: id, ExpiresAt: now.Add(ttl)}
+	
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&lease)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to acquire leader lease %s: %w", name, result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+	
+	result = s.db.WithContext(ctx).Model(&LeaderLease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", name, id, now).
+		Updates(map[string]interface{}{"holder": id, "expires_at": lease.ExpiresAt})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to acquire leader lease %s: %w", name, result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Release gives up the lease name if id holds it
+func (s *databaseLeaderStore) Release(ctx context.Context, name, id string) error {
+	result := s.db.WithContext(ctx).Where("name = ? AND holder = ?", name, id).Delete(&LeaderLease{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to release leader lease %s: %w", name, result.Error)
+	}
+	return nil
+}
+
+// ReleaseDownload gives up node's claim on a download so whoever runs it next
+// can claim it
+func (dm *DatabaseManager) ReleaseDownload(id string, node cluster.Node) error {
+	result := dm.db.Model(&Download{}).
+		Where("id = ? AND owner = ?", id, node.ID).
+		Updates(map[string]interface{}{"owner": "", "owner_url": "", "lease_expires_at": time.Time{}})
+	if result.Error != nil {
+		return fmt.Errorf("failed to release download: %w", result.Error)
+	}
+	return nil
+}
+
+// RenewLeases extends node's lease on every unfinished download it owns
+func (dm *DatabaseManager) RenewLeases(node cluster.Node, ttl time.Duration) error {
+	updates := map[string]interface{}{
+		"owner_url":        node.URL,
+		"lease_expires_at": time.Now().Add(ttl),
+	}
+
+	result := dm.db.Model(&Download{}).
+		Where("owner = ? AND status IN ?", node.ID, []lifecycle.Status{lifecycle.Downloading, lifecycle.Paused}).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to renew download leases: %w", result.Error)
+	}
+	return nil
+}
+
+// UpdateDownloadRequest records the method and body a download requests its file with
+func (dm *DatabaseManager) UpdateDownloadRequest(id, method string, body []byte, bodyType string) error {
+	updates := map[string]interface{}{
+		"method":       method,
+		"request_body": body,
+		"body_type":    bodyType,
+		"updated_at":   time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download request: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// UpdateDownloadRefreshURL records the endpoint that refreshes a download's
+// expired link
+func (dm *DatabaseManager) UpdateDownloadRefreshURL(id, refreshURL string) error {
+	updates := map[string]interface{}{
+		"refresh_url": refreshURL,
+		"updated_at":  time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download refresh URL: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// UpdateDownloadPartURLs records the pieces joined into a download's output
+func (dm *DatabaseManager) UpdateDownloadPartURLs(id string, partURLs []string) error {
+	updates := map[string]interface{}{
+		"part_urls":  strings.Join(partURLs, "\n"),
+		"updated_at": time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download part urls: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// UpdateDownloadAttribution records who asked for a download and its tags
+func (dm *DatabaseManager) UpdateDownloadAttribution(id, createdBy string, tags []string) error {
+	updates := map[string]interface{}{
+		"created_by": createdBy,
+		"tags":       strings.Join(tags, "\n"),
+		"updated_at": time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download attribution: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// UpdateDownloadChecksum records the result of checking a download against
+// the checksum its server sent
+func (dm *DatabaseManager) UpdateDownloadChecksum(id, status, algorithm, source string) error {
+	updates := map[string]interface{}{
+		"checksum_status":    status,
+		"checksum_algorithm": algorithm,
+		"checksum_source":    source,
+		"updated_at":         time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download checksum: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// UpdateDownloadEnvironment records the environment a download ran in,
+// replacing what was recorded when it started with the completed record
+func (dm *DatabaseManager) UpdateDownloadEnvironment(id string, env *downloader.Environment) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("failed to encode download environment: %w", err)
+	}
+
+	var result *gorm.DB
+	err = dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Update("environment", string(data))
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download environment: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// UpdateDownloadThreads records how many parts a download was split into
+// and, when that differs, how many threads it asked for
+func (dm *DatabaseManager) UpdateDownloadThreads(id string, threads, requested int) error {
+	if requested == threads {
+		requested = 0
+	}
+	updates := map[string]interface{}{
+		"threads":           threads,
+		"requested_threads": requested,
+		"updated_at":        time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download threads: %w", err)
 	}
 
 	if result.RowsAffected == 0 {
@@ -156,6 +972,39 @@ func (dm *DatabaseManager) UpdateDownloadStatus(id, status, errorMsg string) err
 	return nil
 }
 
+// UpdateDownloadHeaders records the User-Agent and Referer a download uses
+func (dm *DatabaseManager) UpdateDownloadHeaders(id, userAgent, referer string) error {
+	updates := map[string]interface{}{
+		"user_agent": userAgent,
+		"referer":    referer,
+		"updated_at": time.Now(),
+	}
+
+	var result *gorm.DB
+	err := dm.retry(func() error {
+		result = dm.db.Model(&Download{}).Where("id = ?", id).Updates(updates)
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update download headers: %w", err)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("download with id %s not found", id)
+	}
+
+	return nil
+}
+
+// Ping checks that the database answers
+func (dm *DatabaseManager) Ping(ctx context.Context) error {
+	sqlDB, err := dm.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 // GetDownload retrieves a download by ID
 func (dm *DatabaseManager) GetDownload(id string) (*Download, error) {
 	var download Download
@@ -168,6 +1017,23 @@ func (dm *DatabaseManager) GetDownload(id string) (*Download, error) {
 	return &download, nil
 }
 
+// GetDownloadEnvironment returns the environment recorded for a download,
+// or nil when it recorded none
+func (dm *DatabaseManager) GetDownloadEnvironment(id string) (*downloader.Environment, error) {
+	download, err := dm.GetDownload(id)
+	if err != nil {
+		return nil, err
+	}
+	if download.Environment == "" {
+		return nil, nil
+	}
+	var env downloader.Environment
+	if err := json.Unmarshal([]byte(download.Environment), &env); err != nil {
+		return nil, fmt.Errorf("invalid download environment: %w", err)
+	}
+	return &env, nil
+}
+
 // GetAllDownloads retrieves all downloads
 func (dm *DatabaseManager) GetAllDownloads() ([]Download, error) {
 	var downloads []Download
@@ -180,13 +1046,63 @@ func (dm *DatabaseManager) GetAllDownloads() ([]Download, error) {
 // GetIncompleteDownloads retrieves downloads that are not completed or failed
 func (dm *DatabaseManager) GetIncompleteDownloads() ([]Download, error) {
 	var downloads []Download
-	if err := dm.db.Where("status IN ?", []string{"downloading", "paused"}).Find(&downloads).Error; err != nil {
+	if err := dm.db.Where("status IN ?", []lifecycle.Status{lifecycle.Downloading, lifecycle.Paused}).Find(&downloads).Error; err != nil {
 		return nil, fmt.Errorf("failed to get incomplete downloads: %w", err)
 	}
 	return downloads, nil
 }
 
-// DeleteDownload removes a download record from the database
+// RecordArtifact saves a file a download produced, replacing what was
+// recorded for the same path when the download ran before
+func (dm *DatabaseManager) RecordArtifact(artifact *Artifact) error {
+	err := dm.retry(func() error {
+		return dm.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "download_id"}, {Name: "path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"size", "checksum", "checksum_algorithm", "backend", "node", "encrypted", "created_at"}),
+		}).Create(artifact).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record artifact: %w", err)
+	}
+	return nil
+}
+
+// GetArtifacts returns the files a download produced
+func (dm *DatabaseManager) GetArtifacts(downloadID string) ([]Artifact, error) {
+	var artifacts []Artifact
+	result := dm.db.Where("download_id = ?", downloadID).Order("id").Find(&artifacts)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get artifacts: %w", result.Error)
+	}
+	return artifacts, nil
+}
+
+// SaveGroupChecksums records the checksum file of a group, replacing one
+// written before
+func (dm *DatabaseManager) SaveGroupChecksums(sums *GroupChecksums) error {
+	err := dm.retry(func() error {
+		return dm.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(sums).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record group checksums: %w", err)
+	}
+	return nil
+}
+
+// GetGroupChecksums returns the checksum file of a group, or nil while none
+// was written
+func (dm *DatabaseManager) GetGroupChecksums(groupID string) (*GroupChecksums, error) {
+	var sums GroupChecksums
+	if err := dm.db.Where("group_id = ?", groupID).First(&sums).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get group checksums: %w", err)
+	}
+	return &sums, nil
+}
+
+// DeleteDownload removes a download record and its artifacts from the database
 func (dm *DatabaseManager) DeleteDownload(id string) error {
 	result := dm.db.Where("id = ?", id).Delete(&Download{})
 	if result.Error != nil {
@@ -197,25 +1113,285 @@ func (dm *DatabaseManager) DeleteDownload(id string) error {
 		return fmt.Errorf("download with id %s not found", id)
 	}
 
+	if err := dm.db.Where("download_id = ?", id).Delete(&Artifact{}).Error; err != nil {
+		return fmt.Errorf("failed to delete artifacts: %w", err)
+	}
+	if err := dm.db.Where("download_id = ?", id).Delete(&DownloadEvent{}).Error; err != nil {
+		return fmt.Errorf("failed to delete download events: %w", err)
+	}
+
 	return nil
 }
 
 // CleanupCompletedDownloads removes completed downloads older than the specified duration
 func (dm *DatabaseManager) CleanupCompletedDownloads(olderThan time.Duration) error {
 	cutoff := time.Now().Add(-olderThan)
-	result := dm.db.Where("status = ? AND updated_at < ?", "completed", cutoff).Delete(&Download{})
+	result := dm.db.Where("status IN ? AND updated_at < ?", []lifecycle.Status{lifecycle.Completed, lifecycle.NotModified}, cutoff).Delete(&Download{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to cleanup completed downloads: %w", result.Error)
 	}
 
 	if result.RowsAffected > 0 {
 		fmt.Printf("Cleaned up %d completed downloads older than %v\n", result.RowsAffected, olderThan)
+		orphans := dm.db.Where("download_id NOT IN (?)", dm.db.Model(&Download{}).Select("id"))
+		if err := orphans.Delete(&Artifact{}).Error; err != nil {
+			return fmt.Errorf("failed to cleanup artifacts: %w", err)
+		}
+		orphans = dm.db.Where("download_id NOT IN (?)", dm.db.Model(&Download{}).Select("id"))
+		if err := orphans.Delete(&DownloadEvent{}).Error; err != nil {
+			return fmt.Errorf("failed to cleanup download events: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// LoadSettings returns every stored setting by name
+func (dm *DatabaseManager) LoadSettings() (map[string]string, error) {
+	var rows []Setting
+	if err := dm.db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	values := make(map[string]string, len(rows))
+	for _, row := range rows {
+		values[row.Name] = row.Value
 	}
+	return values, nil
+}
 
+// SaveSettings stores the settings in values and records audit in the same
+// transaction, so a change is never saved without its audit entry
+func (dm *DatabaseManager) SaveSettings(values map[string]string, audit []AuditEntry) error {
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		for name, value := range values {
+			row := Setting{Name: name, Value: value, UpdatedAt: time.Now()}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+			}).Create(&row).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(audit) > 0 {
+			if err := tx.Create(&audit).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
 	return nil
 }
 
-// GetDownloadStats returns statistics about downloads
+// RecordAudit stores audit entries for changes saved elsewhere, such as
+// the domain rules file
+func (dm *DatabaseManager) RecordAudit(entries []AuditEntry) error {
+	if err := dm.db.Create(&entries).Error; err != nil {
+		return fmt.Errorf("failed to record audit entries: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries returns the newest audit entries first, at most limit
+func (dm *DatabaseManager) GetAuditEntries(limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := dm.db.Order("time DESC, id DESC").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+// CreateSchedule stores a new schedule
+func (dm *DatabaseManager) CreateSchedule(schedule *Schedule) error {
+	if err := dm.db.Create(schedule).Error; err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return nil
+}
+
+// GetSchedule retrieves a schedule by ID
+func (dm *DatabaseManager) GetSchedule(id string) (*Schedule, error) {
+	var schedule Schedule
+	if err := dm.db.Where("id = ?", id).First(&schedule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// GetSchedules returns every schedule, the oldest first
+func (dm *DatabaseManager) GetSchedules() ([]Schedule, error) {
+	var schedules []Schedule
+	if err := dm.db.Order("created_at, id").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to get schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// GetDueSchedules returns the schedules that are not paused and due by now
+func (dm *DatabaseManager) GetDueSchedules(now time.Time) ([]Schedule, error) {
+	var schedules []Schedule
+	if err := dm.db.Where("paused = ? AND next_run <= ?", false, now).Order("next_run").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to get due schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// SetSchedulePaused pauses or resumes a schedule; a resumed schedule is
+// next due at nextRun
+func (dm *DatabaseManager) SetSchedulePaused(id string, paused bool, nextRun time.Time) error {
+	updates := map[string]interface{}{"paused": paused}
+	if !paused {
+		updates["next_run"] = nextRun
+	}
+	result := dm.db.Model(&Schedule{}).Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update schedule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// ClaimScheduleRun moves a schedule that is due at due on to nextRun and
+// reports whether this call did, so that a run happens once even when two
+// servers find it due
+func (dm *DatabaseManager) ClaimScheduleRun(id string, due, nextRun, now time.Time) (bool, error) {
+	result := dm.db.Model(&Schedule{}).
+		Where("id = ? AND next_run = ? AND paused = ?", id, due, false).
+		Updates(map[string]interface{}{"next_run": nextRun, "last_run": now})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim schedule run: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RecordScheduleRun stores a run of a schedule, remembers the job it
+// enqueued and drops the oldest runs beyond maxScheduleRuns
+func (dm *DatabaseManager) RecordScheduleRun(run *ScheduleRun) error {
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		if run.Result == ScheduleEnqueued {
+			if err := tx.Model(&Schedule{}).Where("id = ?", run.ScheduleID).Update("last_job_id", run.JobID).Error; err != nil {
+				return err
+			}
+		}
+		kept := tx.Model(&ScheduleRun{}).Select("id").Where("schedule_id = ?", run.ScheduleID).Order("id DESC").Limit(maxScheduleRuns)
+		return tx.Where("schedule_id = ? AND id NOT IN (?)", run.ScheduleID, kept).Delete(&ScheduleRun{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record schedule run: %w", err)
+	}
+	return nil
+}
+
+// GetScheduleRuns returns the newest runs of a schedule first, at most limit
+func (dm *DatabaseManager) GetScheduleRuns(id string, limit int) ([]ScheduleRun, error) {
+	var runs []ScheduleRun
+	if err := dm.db.Where("schedule_id = ?", id).Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get schedule runs: %w", err)
+	}
+	return runs, nil
+}
+
+// DeleteSchedule removes a schedule and its runs
+func (dm *DatabaseManager) DeleteSchedule(id string) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&Schedule{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete schedule: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrScheduleNotFound
+		}
+		if err := tx.Where("schedule_id = ?", id).Delete(&ScheduleRun{}).Error; err != nil {
+			return fmt.Errorf("failed to delete schedule runs: %w", err)
+		}
+		return nil
+	})
+}
+
+// databaseProbeCache keeps range checks in the probes table for ttl
+type databaseProbeCache struct {
+	dm  *DatabaseManager
+	ttl time.Duration
+}
+
+// ProbeCache returns a cache of range checks shared by every replica,
+// whose entries are reused for ttl
+func (dm *DatabaseManager) ProbeCache(ttl time.Duration) downloader.ProbeCache {
+	return &databaseProbeCache{dm: dm, ttl: ttl}
+}
+
+func probeKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadProbe returns the range check of url made within the TTL
+func (c *databaseProbeCache) LoadProbe(url string) (downloader.ProbeResult, bool) {
+	var row Probe
+	err := c.dm.db.Where("url_hash = ? AND probed_at > ?", probeKey(url), time.Now().Add(-c.ttl)).First(&row).Error
+	if err != nil {
+		return downloader.ProbeResult{}, false
+	}
+	probe := downloader.ProbeResult{
+		SupportsRanges: row.SupportsRanges,
+		Size:           row.Size,
+		ETag:           row.ETag,
+		LastModified:   row.LastModified,
+		ProbedAt:       row.ProbedAt,
+	}
+	if row.Digests != "" {
+		if err := json.Unmarshal([]byte(row.Digests), &probe.Digests); err != nil {
+			return downloader.ProbeResult{}, false
+		}
+	}
+	return probe, true
+}
+
+// StoreProbe keeps the range check of url and drops the expired ones. A
+// probe that cannot be stored is only made again next time.
+func (c *databaseProbeCache) StoreProbe(url string, probe downloader.ProbeResult) {
+	row := Probe{
+		URLHash:        probeKey(url),
+		SupportsRanges: probe.SupportsRanges,
+		Size:           probe.Size,
+		ETag:           probe.ETag,
+		LastModified:   probe.LastModified,
+		ProbedAt:       probe.ProbedAt,
+	}
+	if len(probe.Digests) > 0 {
+		digests, err := json.Marshal(probe.Digests)
+		if err != nil {
+			return
+		}
+		row.Digests = string(digests)
+	}
+	err := c.dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url_hash"}},
+		DoUpdates: clause.AssignmentColumns([]string{"supports_ranges", "size", "etag", "last_modified", "digests", "probed_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		fmt.Printf("Warning: failed to store probe: %v\n", err)
+		return
+	}
+	c.dm.db.Where("probed_at <= ?", time.Now().Add(-c.ttl)).Delete(&Probe{})
+}
+
+// ForgetProbe drops the range check of url
+func (c *databaseProbeCache) ForgetProbe(url string) {
+	c.dm.db.Where("url_hash = ?", probeKey(url)).Delete(&Probe{})
+}
+
 func (dm *DatabaseManager) GetDownloadStats() (map[string]int64, error) {
 	stats := make(map[string]int64)
 
@@ -243,8 +1419,179 @@ func (dm *DatabaseManager) GetDownloadStats() (map[string]int64, error) {
 	return stats, nil
 }
 
-// Close closes the database connection
+// databaseBackup is the database snapshot stored in a backup. Leader
+// leases are left out; they expire and are elected again anyway.
+type databaseBackup struct {
+	Downloads    []Download
+	Settings     []Setting
+	AuditEntries []AuditEntry
+	Artifacts    []Artifact
+	Schedules    []Schedule
+	ScheduleRuns []ScheduleRun
+	Events       []DownloadEvent
+	Usage        []TransferUsage
+	Checksums    []GroupChecksums
+}
+
+// rows counts the rows of each table in the snapshot
+func (b *databaseBackup) rows() map[string]int {
+	return map[string]int{
+		"downloads":       len(b.Downloads),
+		"settings":        len(b.Settings),
+		"audit_entries":   len(b.AuditEntries),
+		"artifacts":       len(b.Artifacts),
+		"schedules":       len(b.Schedules),
+		"schedule_runs":   len(b.ScheduleRuns),
+		"download_events": len(b.Events),
+		"transfer_usages": len(b.Usage),
+		"group_checksums": len(b.Checksums),
+	}
+}
+
+// BackupDatabase encodes a snapshot of the database and counts its rows.
+// The tables are read in one read-only repeatable read transaction, so the
+// snapshot is consistent while downloads keep writing progress.
+func (dm *DatabaseManager) BackupDatabase() ([]byte, map[string]int, error) {
+	var snapshot databaseBackup
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Find(&snapshot.Downloads).Error; err != nil {
+			return err
+		}
+		if err := tx.Find(&snapshot.Settings).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id").Find(&snapshot.AuditEntries).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id").Find(&snapshot.Artifacts).Error; err != nil {
+			return err
+		}
+		if err := tx.Find(&snapshot.Schedules).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id").Find(&snapshot.ScheduleRuns).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id").Find(&snapshot.Events).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id").Find(&snapshot.Usage).Error; err != nil {
+			return err
+		}
+		return tx.Find(&snapshot.Checksums).Error
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read database snapshot: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snapshot); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode database snapshot: %w", err)
+	}
+	return buf.Bytes(), snapshot.rows(), nil
+}
+
+// RestoreDatabase replaces the contents of the database with a snapshot
+// from BackupDatabase in one transaction. It refuses to overwrite a database
+// that already holds downloads unless replace is set. The restored
+// downloads lose their owner, so the server restoring them adopts them.
+func (dm *DatabaseManager) RestoreDatabase(data []byte, replace bool) (map[string]int, error) {
+	var snapshot databaseBackup
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid database snapshot: %w", err)
+	}
+	for i := range snapshot.Downloads {
+		snapshot.Downloads[i].Owner = ""
+		snapshot.Downloads[i].OwnerURL = ""
+		snapshot.Downloads[i].LeaseExpiresAt = time.Time{}
+	}
+
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&Download{}).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 && !replace {
+			return fmt.Errorf("database already holds %d downloads", existing)
+		}
+
+		all := tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+		for _, model := range []interface{}{&Download{}, &Setting{}, &AuditEntry{}, &Artifact{}, &Schedule{}, &ScheduleRun{}, &DownloadEvent{}, &TransferUsage{}, &GroupChecksums{}, &LeaderLease{}} {
+			if err := all.Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Downloads) > 0 {
+			if err := tx.CreateInBatches(snapshot.Downloads, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Settings) > 0 {
+			if err := tx.CreateInBatches(snapshot.Settings, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.AuditEntries) > 0 {
+			if err := tx.CreateInBatches(snapshot.AuditEntries, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Artifacts) > 0 {
+			if err := tx.CreateInBatches(snapshot.Artifacts, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Schedules) > 0 {
+			if err := tx.CreateInBatches(snapshot.Schedules, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.ScheduleRuns) > 0 {
+			if err := tx.CreateInBatches(snapshot.ScheduleRuns, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Events) > 0 {
+			if err := tx.CreateInBatches(snapshot.Events, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Usage) > 0 {
+			if err := tx.CreateInBatches(snapshot.Usage, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshot.Checksums) > 0 {
+			if err := tx.CreateInBatches(snapshot.Checksums, 100).Error; err != nil {
+				return err
+			}
+		}
+
+		// Rows were inserted with their IDs; move the sequences past them
+		for _, table := range []string{"audit_entries", "artifacts", "schedule_runs", "download_events", "transfer_usages"} {
+			err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)", table, table)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore database: %w", err)
+	}
+	return snapshot.rows(), nil
+}
+
+// Close records the events still held, as far as the database takes them,
+// and closes the database connection
 func (dm *DatabaseManager) Close() error {
+	if dm.stop != nil {
+		dm.stop()
+		dm.stopped.Wait()
+		if held := dm.pending.Len(); held > 0 {
+			fmt.Printf("Closing the database with %d download updates not recorded\n", held)
+		}
+	}
 	if dm.db != nil {
 		sqlDB, err := dm.db.DB()
 		if err != nil {
@@ -266,19 +1613,83 @@ func SaveDownload(id, url, outputPath string, threads int) (*Download, error) {
 }
 
 // UpdateProgress updates download progress in the database
-func UpdateProgress(id string, bytesDownloaded, totalBytes int64, status string) error {
+func UpdateProgress(id string, bytesDownloaded, totalBytes int64, status lifecycle.Status) error {
 	if dbManager == nil {
 		return fmt.Errorf("database not initialized")
 	}
-	return dbManager.UpdateDownloadProgress(id, bytesDownloaded, totalBytes, status)
+	return dbManager.UpdateDownloadProgress(id, bytesDownloaded, totalBytes, status, lifecycle.NextSeq())
 }
 
 // UpdateStatus updates download status in the database
-func UpdateStatus(id, status, errorMsg string) error {
+func UpdateStatus(id string, status lifecycle.Status, errorMsg string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadStatus(id, status, errorMsg, lifecycle.NextSeq())
+}
+
+// UpdateThreads records the parts a download was split into in the database
+func UpdateThreads(id string, threads, requested int) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadThreads(id, threads, requested)
+}
+
+// UpdateHeaders updates the download's User-Agent and Referer in the database
+func UpdateHeaders(id, userAgent, referer string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadHeaders(id, userAgent, referer)
+}
+
+// UpdateRequest updates the download's method and request body in the database
+func UpdateRequest(id, method string, body []byte, bodyType string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadRequest(id, method, body, bodyType)
+}
+
+// UpdateRefreshURL updates the download's link refresh endpoint in the database
+func UpdateRefreshURL(id, refreshURL string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadRefreshURL(id, refreshURL)
+}
+
+// UpdatePartURLs updates the pieces joined into the download's output in the database
+func UpdatePartURLs(id string, partURLs []string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadPartURLs(id, partURLs)
+}
+
+// UpdateAttribution updates who asked for the download and its tags in the database
+func UpdateAttribution(id, createdBy string, tags []string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadAttribution(id, createdBy, tags)
+}
+
+// UpdateChecksum updates the download's checksum result in the database
+func UpdateChecksum(id, status, algorithm, source string) error {
+	if dbManager == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbManager.UpdateDownloadChecksum(id, status, algorithm, source)
+}
+
+// UpdateEnvironment records the environment a download ran in
+func UpdateEnvironment(id string, env *downloader.Environment) error {
 	if dbManager == nil {
 		return fmt.Errorf("database not initialized")
 	}
-	return dbManager.UpdateDownloadStatus(id, status, errorMsg)
+	return dbManager.UpdateDownloadEnvironment(id, env)
 }
 
 // GetDownloadByID retrieves a download by ID
@@ -0,0 +1,99 @@
+// Package diag serves runtime diagnostics for operators: the CPU, heap and
+// other profiles of net/http/pprof, the expvar variables, and a JSON dump of
+// the downloads a process is running for support cases.
+//
+// The servers mount Handler on their admin router, behind the admin token
+// and allowlist; workers serve it on DEBUG_ADDR. Importing the package also
+// registers pprof and expvar on http.DefaultServeMux, which no server here
+// listens with.
+package diag
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/health"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/secrets"
+)
+
+func init() {
+	expvar.Publish("build", expvar.Func(func() interface{} { return health.Build() }))
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} { return int64(health.Uptime() / time.Second) }))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+}
+
+// Handler serves the diagnostics below /debug:
+//
+//	/debug/pprof/          index of the profiles, e.g. /debug/pprof/heap
+//	/debug/pprof/profile   CPU profile, ?seconds=30 by default
+//	/debug/pprof/trace     execution trace, ?seconds=1 by default
+//	/debug/vars            expvar variables, with memstats and the build
+//	/debug/downloads       dump() as indented JSON
+func Handler(dump func() interface{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/downloads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(dump())
+	})
+	return mux
+}
+
+// Download is the internal state of a running download. URLs are redacted;
+// request headers and cookies are left out.
+type Download struct {
+	ID               string                   `json:"id"`
+	URL              string                   `json:"url"`
+	Output           string                   `json:"output"`
+	ProgressFile     string                   `json:"progress_file,omitempty"`
+	Threads          int                      `json:"threads"`
+	RequestedThreads int                      `json:"requested_threads"`
+	ActiveThreads    int                      `json:"active_threads"`
+	RateLimit        int64                    `json:"rate_limit"`
+	ThrottledUntil   *time.Time               `json:"throttled_until,omitempty"`
+	BytesDownloaded  int64                    `json:"bytes_downloaded"`
+	TotalSize        int64                    `json:"total_size"`
+	SingleStream     bool                     `json:"single_stream"`
+	Encrypted        bool                     `json:"encrypted"`
+	ETag             string                   `json:"etag,omitempty"`
+	Parts            []openapi.PartConnection `json:"parts,omitempty"`
+}
+
+// DescribeDownload returns the state of d, the download id
+func DescribeDownload(id string, d *downloader.Downloader) Download {
+	state := Download{
+		ID:               id,
+		URL:              secrets.RedactURL(d.URL),
+		Output:           d.Filename,
+		ProgressFile:     d.ProgressFile,
+		Threads:          d.Threads(),
+		RequestedThreads: d.RequestedThreads(),
+		ActiveThreads:    d.ActiveThreads(),
+		RateLimit:        d.RateLimit(),
+		Parts:            openapi.NewDownloadParts(id, d.PartConnections()).Parts,
+	}
+	if until := d.ThrottledUntil(); !until.IsZero() {
+		state.ThrottledUntil = &until
+	}
+	if d.Progress != nil {
+		state.BytesDownloaded = d.Progress.GetTotalDownloaded()
+		state.TotalSize = d.Progress.TotalSize
+		state.SingleStream = d.Progress.SingleStream
+		state.Encrypted = d.Progress.Encrypted
+		state.ETag = d.Progress.ETag
+	}
+	return state
+}
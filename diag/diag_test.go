@@ -0,0 +1,39 @@
+package diag
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	handler := Handler(func() interface{} {
+		return map[string]interface{}{"downloads": []Download{{ID: "abc", URL: "https://example.com/f"}}}
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/debug/downloads")
+	var dump struct {
+		Downloads []Download `json:"downloads"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &dump); err != nil || len(dump.Downloads) != 1 || dump.Downloads[0].ID != "abc" {
+		t.Errorf("/debug/downloads = %d %s (%v)", w.Code, w.Body.String(), err)
+	}
+
+	w = get("/debug/vars")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"build"`) || !strings.Contains(w.Body.String(), `"memstats"`) {
+		t.Errorf("/debug/vars = %d, without the build or memstats", w.Code)
+	}
+
+	if w = get("/debug/pprof/"); w.Code != 200 || !strings.Contains(w.Body.String(), "heap") {
+		t.Errorf("/debug/pprof/ = %d", w.Code)
+	}
+	if w = get("/debug/pprof/goroutine?debug=1"); w.Code != 200 || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("/debug/pprof/goroutine = %d", w.Code)
+	}
+}
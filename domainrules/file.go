@@ -0,0 +1,150 @@
+package domainrules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/registry"
+)
+
+// FileName is the name of the command line's rules file in the mtdl home
+// directory
+const FileName = "rules.yaml"
+
+// DefaultFile returns the command line's rules file: $MTDL_HOME/rules.yaml
+// when set and ~/.mtdl/rules.yaml otherwise, next to the job registry
+func DefaultFile() (string, error) {
+	dir, err := registry.DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dir), FileName), nil
+}
+
+// Decode reads rules written as JSON or, for editing by hand, YAML with the
+// same field names:
+//
+//	rules:
+//	  - match: "*.internal.corp"
+//	    threads: 2
+//	    headers:
+//	      X-Token: secret
+func Decode(data []byte) ([]openapi.DomainRule, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	if trimmed[0] != '{' {
+		var generic interface{}
+		if err := yaml.Unmarshal(trimmed, &generic); err != nil {
+			return nil, fmt.Errorf("rules are neither JSON nor YAML: %w", err)
+		}
+		converted, err := jsonCompatible(generic)
+		if err != nil {
+			return nil, err
+		}
+		if trimmed, err = json.Marshal(converted); err != nil {
+			return nil, err
+		}
+	}
+
+	var doc openapi.DomainRules
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	if err := Validate(doc.Rules); err != nil {
+		return nil, err
+	}
+	return doc.Rules, nil
+}
+
+// Load reads the rules file at path. A file that does not exist holds no
+// rules.
+func Load(path string) ([]openapi.DomainRule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	rules, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Save writes rules to path, as JSON for a .json file and YAML otherwise.
+// The file is replaced atomically and, as rules may hold tokens, is private
+// to the user.
+func Save(path string, rules []openapi.DomainRule) error {
+	if rules == nil {
+		rules = []openapi.DomainRule{}
+	}
+	data, err := json.MarshalIndent(openapi.DomainRules{Rules: rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		// Go through JSON so YAML keys are the JSON field names
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		if data, err = yaml.Marshal(generic); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save rules: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save rules: %w", err)
+	}
+	return nil
+}
+
+// jsonCompatible turns the map[interface{}]interface{} values YAML decodes
+// into map[string]interface{} so they can be encoded as JSON
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("rules key %v is not a string", key)
+			}
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = converted
+		}
+		return out, nil
+	case []interface{}:
+		for i, item := range v {
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,360 @@
+// Package domainrules applies per-domain download defaults. A rule keyed by
+// a domain pattern such as *.internal.corp gives every download from a
+// matching host its threads, rate limit, headers, cookies, retry policy,
+// rotating User-Agents, request jitter and the interface it connects from,
+// unless the download asks for its own. Rules are kept in a YAML or JSON
+// file and can be replaced through the API.
+package domainrules
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/secrets"
+)
+
+// Validate checks every rule's fields, pattern and retry delay. A pattern
+// may only be used by one rule.
+func Validate(rules []openapi.DomainRule) error {
+	seen := make(map[string]bool, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		pattern := strings.ToLower(rule.Match)
+		if err := checkPattern(pattern); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if seen[pattern] {
+			return fmt.Errorf("rule %d: %s is matched by an earlier rule", i+1, rule.Match)
+		}
+		seen[pattern] = true
+		if rule.RetryDelay != "" {
+			if delay, err := time.ParseDuration(rule.RetryDelay); err != nil || delay < 0 {
+				return fmt.Errorf("rule %d: retry_delay must be a duration such as 5s, got %q", i+1, rule.RetryDelay)
+			}
+		}
+		if rule.Jitter != "" {
+			if jitter, err := time.ParseDuration(rule.Jitter); err != nil || jitter < 0 || jitter > MaxJitter {
+				return fmt.Errorf("rule %d: jitter must be a duration up to %s such as 500ms, got %q", i+1, MaxJitter, rule.Jitter)
+			}
+		}
+		if len(rule.UserAgents) > MaxUserAgents {
+			return fmt.Errorf("rule %d: at most %d user_agents are allowed, got %d", i+1, MaxUserAgents, len(rule.UserAgents))
+		}
+		for _, userAgent := range rule.UserAgents {
+			if userAgent == "" || strings.IndexFunc(userAgent, unicode.IsControl) >= 0 {
+				return fmt.Errorf("rule %d: user agent %q must be a profile name or a User-Agent without control characters", i+1, userAgent)
+			}
+		}
+		if strings.IndexFunc(rule.Bind, unicode.IsSpace) >= 0 || strings.IndexFunc(rule.Bind, unicode.IsControl) >= 0 {
+			return fmt.Errorf("rule %d: bind must be an interface name or IP address, got %q", i+1, rule.Bind)
+		}
+	}
+	return nil
+}
+
+const (
+	// MaxJitter bounds the jitter of a rule, well below the 30 second
+	// timeout of the requests it delays
+	MaxJitter = 10 * time.Second
+	// MaxUserAgents bounds the User-Agents a rule rotates among
+	MaxUserAgents = 50
+)
+
+// checkPattern accepts a host, *.host or *
+func checkPattern(pattern string) error {
+	host := strings.TrimPrefix(pattern, "*.")
+	if pattern == "*" {
+		return nil
+	}
+	if host == "" || strings.ContainsAny(host, "*/:@ \t") {
+		return fmt.Errorf("match must be a host, *.host or *, got %q", pattern)
+	}
+	return nil
+}
+
+// Match reports whether host matches pattern: a pattern naming a host
+// matches only that host, *.example.com matches the subdomains of
+// example.com and * matches every host
+func Match(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// specificity orders patterns so that more specific ones override others:
+// * first, then wildcards, then hosts, each by length
+func specificity(pattern string) int {
+	switch {
+	case pattern == "*":
+		return 0
+	case strings.HasPrefix(pattern, "*."):
+		return 1000 + len(pattern)
+	}
+	return 2000 + len(pattern)
+}
+
+// Profile is what the rules matching a URL give a download
+type Profile struct {
+	// Matched are the patterns of the matching rules, least specific first
+	Matched     []string
+	Threads     int
+	RateLimit   int64
+	Headers     map[string]string
+	CookiesFile string
+	Retry       downloader.RetryPolicy
+	// UserAgents are sent in turn, with profile names resolved
+	UserAgents []string
+	Jitter     time.Duration
+	// Bind is the interface or address downloads connect from, sharing
+	// BindRateLimit with the others bound to it
+	Bind          string
+	BindRateLimit int64
+}
+
+// Resolve merges the rules matching rawURL's host. Where several set the
+// same field the most specific one wins.
+func Resolve(rules []openapi.DomainRule, rawURL string) Profile {
+	var profile Profile
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return profile
+	}
+	host := parsed.Hostname()
+
+	var matching []openapi.DomainRule
+	for _, rule := range rules {
+		if Match(rule.Match, host) {
+			matching = append(matching, rule)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return specificity(matching[i].Match) < specificity(matching[j].Match)
+	})
+
+	for _, rule := range matching {
+		profile.Matched = append(profile.Matched, rule.Match)
+		if rule.Threads > 0 {
+			profile.Threads = rule.Threads
+		}
+		if rule.RateLimit > 0 {
+			profile.RateLimit = rule.RateLimit
+		}
+		for name, value := range rule.Headers {
+			if profile.Headers == nil {
+				profile.Headers = make(map[string]string)
+			}
+			profile.Headers[http.CanonicalHeaderKey(name)] = value
+		}
+		if rule.CookiesFile != "" {
+			profile.CookiesFile = rule.CookiesFile
+		}
+		if rule.MaxAttempts > 0 {
+			profile.Retry.MaxAttempts = rule.MaxAttempts
+		}
+		// Validate has checked the delay
+		if delay, err := time.ParseDuration(rule.RetryDelay); err == nil && delay > 0 {
+			profile.Retry.Delay = delay
+		}
+		if len(rule.UserAgents) > 0 {
+			profile.UserAgents = make([]string, len(rule.UserAgents))
+			for i, userAgent := range rule.UserAgents {
+				if preset, ok := downloader.UserAgentProfiles[userAgent]; ok {
+					userAgent = preset
+				}
+				profile.UserAgents[i] = userAgent
+			}
+		}
+		if jitter, err := time.ParseDuration(rule.Jitter); err == nil && jitter > 0 {
+			profile.Jitter = jitter
+		}
+		if rule.Bind != "" {
+			profile.Bind = rule.Bind
+		}
+		if rule.BindRateLimit > 0 {
+			profile.BindRateLimit = rule.BindRateLimit
+		}
+	}
+	return profile
+}
+
+// Apply gives dl the profile's headers, rate limit, cookies, retry policy,
+// User-Agents, jitter and bind where the download has not set its own; a
+// download keeping the default User-Agent has none of its own. Threads are
+// left to the caller, which knows whether a count was asked for.
+func (p Profile) Apply(dl *downloader.Downloader) error {
+	dl.Headers = MergeHeaders(dl.Headers, p.Headers)
+	if p.RateLimit > 0 {
+		dl.SetRateLimit(p.RateLimit)
+	}
+	if p.CookiesFile != "" {
+		jar, err := downloader.LoadCookiesFile(p.CookiesFile)
+		if err != nil {
+			return fmt.Errorf("domain rule cookies: %w", err)
+		}
+		if dl.CookieJar != nil {
+			jar = layeredJar{own: dl.CookieJar, rule: jar}
+		}
+		dl.CookieJar = jar
+	}
+	if dl.Retry == (downloader.RetryPolicy{}) {
+		dl.Retry = p.Retry
+	}
+	if len(p.UserAgents) > 0 && len(dl.UserAgents) == 0 && (dl.UserAgent == "" || dl.UserAgent == downloader.DefaultUserAgent) {
+		dl.UserAgents = p.UserAgents
+	}
+	if dl.Jitter == 0 {
+		dl.Jitter = p.Jitter
+	}
+	if dl.Bind == "" {
+		dl.Bind = p.Bind
+	}
+	// The rule's limit is for its own interface, not one the download chose
+	if dl.BindRateLimit == 0 && (p.Bind == "" || dl.Bind == p.Bind) {
+		dl.BindRateLimit = p.BindRateLimit
+	}
+	return nil
+}
+
+// MergeHeaders adds the defaults to headers that headers does not set in
+// any case, and returns the result
+func MergeHeaders(headers, defaults map[string]string) map[string]string {
+	for name, value := range defaults {
+		if hasHeader(headers, name) {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string, len(defaults))
+		}
+		headers[name] = value
+	}
+	return headers
+}
+
+// hasHeader reports whether headers sets name in any case
+func hasHeader(headers map[string]string, name string) bool {
+	for existing := range headers {
+		if strings.EqualFold(existing, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Response describes the profile of rawURL in the shape of the API, with
+// header values redacted
+func (p Profile) Response(rawURL string) openapi.DomainRuleMatch {
+	match := openapi.DomainRuleMatch{
+		URL:           secrets.RedactURL(rawURL),
+		Matched:       p.Matched,
+		Threads:       p.Threads,
+		RateLimit:     p.RateLimit,
+		Headers:       secrets.RedactHeaders(p.Headers),
+		CookiesFile:   p.CookiesFile,
+		MaxAttempts:   p.Retry.MaxAttempts,
+		UserAgents:    p.UserAgents,
+		Bind:          p.Bind,
+		BindRateLimit: p.BindRateLimit,
+	}
+	if match.Matched == nil {
+		match.Matched = []string{}
+	}
+	if p.Retry.Delay > 0 {
+		match.RetryDelay = p.Retry.Delay.String()
+	}
+	if p.Jitter > 0 {
+		match.Jitter = p.Jitter.String()
+	}
+	return match
+}
+
+// Redact returns a copy of rules with every header value redacted; rule
+// headers exist to carry tokens
+func Redact(rules []openapi.DomainRule) []openapi.DomainRule {
+	redacted := make([]openapi.DomainRule, len(rules))
+	for i, rule := range rules {
+		rule.Headers = secrets.RedactHeaders(rule.Headers)
+		redacted[i] = rule
+	}
+	return redacted
+}
+
+// KeepRedacted fills in the header values of rules that were sent back
+// redacted from the rule with the same pattern in previous, so listed rules
+// can be edited and saved again
+func KeepRedacted(rules, previous []openapi.DomainRule) ([]openapi.DomainRule, error) {
+	kept := make([]openapi.DomainRule, len(rules))
+	for i, rule := range rules {
+		var headers map[string]string
+		for name, value := range rule.Headers {
+			if value == secrets.Redacted {
+				old, ok := previousHeader(previous, rule.Match, name)
+				if !ok {
+					return nil, fmt.Errorf("rule %s: header %s is redacted but has no value to keep", rule.Match, name)
+				}
+				value = old
+			}
+			if headers == nil {
+				headers = make(map[string]string, len(rule.Headers))
+			}
+			headers[name] = value
+		}
+		rule.Headers = headers
+		kept[i] = rule
+	}
+	return kept, nil
+}
+
+// previousHeader returns the value of header name in the rule for pattern
+func previousHeader(rules []openapi.DomainRule, pattern, name string) (string, bool) {
+	for _, rule := range rules {
+		if !strings.EqualFold(rule.Match, pattern) {
+			continue
+		}
+		for existing, value := range rule.Headers {
+			if strings.EqualFold(existing, name) {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// layeredJar sends the download's own cookies and those of a rule, the
+// download's winning where both have a cookie of the same name
+type layeredJar struct {
+	own  http.CookieJar
+	rule http.CookieJar
+}
+
+func (j layeredJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.own.SetCookies(u, cookies)
+}
+
+func (j layeredJar) Cookies(u *url.URL) []*http.Cookie {
+	cookies := j.own.Cookies(u)
+	names := make(map[string]bool, len(cookies))
+	for _, c := range cookies {
+		names[c.Name] = true
+	}
+	for _, c := range j.rule.Cookies(u) {
+		if !names[c.Name] {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
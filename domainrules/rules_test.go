@@ -0,0 +1,226 @@
+package domainrules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/secrets"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"*", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "cdn.example.com", false},
+		{"*.internal.corp", "files.internal.corp", true},
+		{"*.internal.corp", "a.b.internal.corp", true},
+		{"*.internal.corp", "internal.corp", false},
+		{"*.internal.corp", "evilinternal.corp", false},
+	} {
+		if got := Match(tc.pattern, tc.host); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeAndResolve(t *testing.T) {
+	rules, err := Decode([]byte(`
+rules:
+  - match: "files.internal.corp"
+    threads: 8
+    headers:
+      x-token: files
+  - match: "*.internal.corp"
+    threads: 2
+    max_attempts: 5
+    retry_delay: 3s
+    headers:
+      X-Token: corp
+      X-Team: data
+  - match: "*"
+    rate_limit: 1000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := Resolve(rules, "https://files.internal.corp:8443/big.iso")
+	if len(profile.Matched) != 3 || profile.Matched[0] != "*" || profile.Matched[2] != "files.internal.corp" {
+		t.Errorf("Matched = %v", profile.Matched)
+	}
+	if profile.Threads != 8 || profile.RateLimit != 1000 {
+		t.Errorf("Threads, RateLimit = %d, %d", profile.Threads, profile.RateLimit)
+	}
+	if profile.Headers["X-Token"] != "files" || profile.Headers["X-Team"] != "data" {
+		t.Errorf("Headers = %v", profile.Headers)
+	}
+	if profile.Retry != (downloader.RetryPolicy{MaxAttempts: 5, Delay: 3 * time.Second}) {
+		t.Errorf("Retry = %+v", profile.Retry)
+	}
+
+	if other := Resolve(rules, "https://example.com/x"); other.Threads != 0 || len(other.Matched) != 1 {
+		t.Errorf("profile of another host = %+v", other)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"match": "a.com"}, {"match": "A.com"}]}`,
+		`{"rules": [{"match": "files.*.corp"}]}`,
+		`{"rules": [{"match": "a.com", "retry_delay": "soon"}]}`,
+		`{"rules": [{"match": "a.com", "threads": 40}]}`,
+		`{"rules": [{"match": "a.com", "retries": 3}]}`,
+	} {
+		if _, err := Decode([]byte(bad)); err == nil {
+			t.Errorf("Decode(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestApplyKeepsDownloadSettings(t *testing.T) {
+	dl := downloader.NewDownloader("https://files.internal.corp/x", "x", 4)
+	dl.Headers = map[string]string{"x-token": "mine"}
+	profile := Profile{
+		Headers: map[string]string{"X-Token": "rule", "X-Team": "data"},
+		Retry:   downloader.RetryPolicy{MaxAttempts: 2},
+	}
+	if err := profile.Apply(dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Headers["x-token"] != "mine" || dl.Headers["X-Team"] != "data" || len(dl.Headers) != 2 {
+		t.Errorf("Headers = %v", dl.Headers)
+	}
+	if dl.Retry.MaxAttempts != 2 {
+		t.Errorf("Retry = %+v", dl.Retry)
+	}
+}
+
+func TestUserAgentsAndJitter(t *testing.T) {
+	rules, err := Decode([]byte(`
+rules:
+  - match: "*.shop.example"
+    user_agents: [chrome, firefox, "MyCrawler/2.0"]
+    jitter: 500ms
+  - match: "*"
+    user_agents: [curl]
+    jitter: 2s
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := Resolve(rules, "https://cdn.shop.example/a.jpg")
+	want := []string{downloader.UserAgentProfiles["chrome"], downloader.UserAgentProfiles["firefox"], "MyCrawler/2.0"}
+	if len(profile.UserAgents) != 3 || profile.UserAgents[0] != want[0] || profile.UserAgents[2] != want[2] || profile.Jitter != 500*time.Millisecond {
+		t.Errorf("UserAgents, Jitter = %q, %v", profile.UserAgents, profile.Jitter)
+	}
+	if match := profile.Response("https://cdn.shop.example/a.jpg"); match.Jitter != "500ms" || len(match.UserAgents) != 3 {
+		t.Errorf("Response() = %+v", match)
+	}
+
+	// A download asking for its own User-Agent keeps it
+	own := downloader.NewDownloader("https://cdn.shop.example/a.jpg", "a.jpg", 1)
+	own.UserAgent = "Mine/1.0"
+	profile.Apply(own)
+	if len(own.UserAgents) != 0 || own.Jitter != 500*time.Millisecond {
+		t.Errorf("UserAgents, Jitter of a download with its own agent = %q, %v", own.UserAgents, own.Jitter)
+	}
+	plain := downloader.NewDownloader("https://cdn.shop.example/a.jpg", "a.jpg", 1)
+	profile.Apply(plain)
+	if len(plain.UserAgents) != 3 {
+		t.Errorf("UserAgents = %q", plain.UserAgents)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"match": "a.com", "jitter": "1h"}]}`,
+		`{"rules": [{"match": "a.com", "jitter": "-1s"}]}`,
+		`{"rules": [{"match": "a.com", "user_agents": [""]}]}`,
+		`{"rules": [{"match": "a.com", "user_agents": ["Bot\r\nX-Evil: 1"]}]}`,
+	} {
+		if _, err := Decode([]byte(bad)); err == nil {
+			t.Errorf("Decode(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestStoreKeepsRedactedHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Replace([]openapi.DomainRule{{Match: "*.internal.corp", Headers: map[string]string{"X-Token": "secret"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A listed rule sent back unchanged keeps its token
+	listed := Redact(store.Rules())
+	if listed[0].Headers["X-Token"] != secrets.Redacted {
+		t.Fatalf("listed headers = %v", listed[0].Headers)
+	}
+	listed[0].Threads = 2
+	if _, err := store.Replace(listed); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := reopened.Rules(); len(rules) != 1 || rules[0].Threads != 2 || rules[0].Headers["X-Token"] != "secret" {
+		t.Errorf("saved rules = %+v", rules)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("rules file mode = %v, %v", info.Mode(), err)
+	}
+
+	if _, err := store.Replace([]openapi.DomainRule{{Match: "new.corp", Headers: map[string]string{"X-Token": secrets.Redacted}}}); err == nil {
+		t.Error("Replace() kept a redacted header of a new rule")
+	}
+}
+
+func TestBind(t *testing.T) {
+	rules, err := Decode([]byte(`
+rules:
+  - match: "*.mirror.example"
+    bind: eth1
+    bind_rate_limit: 5000000
+  - match: "*"
+    bind: 192.0.2.10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := Resolve(rules, "https://fast.mirror.example/disk.img")
+	if profile.Bind != "eth1" || profile.BindRateLimit != 5000000 {
+		t.Errorf("Bind, BindRateLimit = %q, %d", profile.Bind, profile.BindRateLimit)
+	}
+	if match := profile.Response("https://fast.mirror.example/disk.img"); match.Bind != "eth1" || match.BindRateLimit != 5000000 {
+		t.Errorf("Response() = %+v", match)
+	}
+
+	// A download naming its own interface keeps it, without the rule's
+	// limit for another one
+	dl := downloader.NewDownloader("https://fast.mirror.example/disk.img", "disk.img", 4)
+	dl.Bind = "wlan0"
+	if err := profile.Apply(dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Bind != "wlan0" || dl.BindRateLimit != 0 {
+		t.Errorf("Bind, BindRateLimit = %q, %d", dl.Bind, dl.BindRateLimit)
+	}
+	dl = downloader.NewDownloader("https://fast.mirror.example/disk.img", "disk.img", 4)
+	if err := profile.Apply(dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Bind != "eth1" || dl.BindRateLimit != 5000000 {
+		t.Errorf("Bind, BindRateLimit = %q, %d", dl.Bind, dl.BindRateLimit)
+	}
+
+	if _, err := Decode([]byte(`{"rules": [{"match": "a.com", "bind": "eth 1"}]}`)); err == nil {
+		t.Error("Decode() accepted a bind with a space")
+	}
+}
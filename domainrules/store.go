@@ -0,0 +1,99 @@
+package domainrules
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+// Store holds the rules of a server. Rules replaced through the API are
+// written back to the file, and the file is read again when it changes, so
+// servers sharing it see each other's changes.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	rules   []openapi.DomainRule
+	modTime time.Time
+}
+
+// NewStore loads the rules in path. Without a path the rules only live in
+// memory; a path without a file starts with no rules.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if path == "" {
+		return s, nil
+	}
+	if info, err := os.Stat(path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	rules, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	s.rules = rules
+	return s, nil
+}
+
+// Path returns the file the rules are kept in, if any
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Rules returns the rules in effect
+func (s *Store) Rules() []openapi.DomainRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	return append([]openapi.DomainRule(nil), s.rules...)
+}
+
+// reload reads the file again if it changed since it was last read. A file
+// edited into an invalid state keeps the rules read before.
+func (s *Store) reload() {
+	if s.path == "" {
+		return
+	}
+	info, err := os.Stat(s.path)
+	if err != nil || info.ModTime().Equal(s.modTime) {
+		return
+	}
+	rules, err := Load(s.path)
+	if err != nil {
+		return
+	}
+	s.rules, s.modTime = rules, info.ModTime()
+}
+
+// Replace makes rules the rules in effect and saves them to the file.
+// Header values sent back redacted keep their previous value.
+func (s *Store) Replace(rules []openapi.DomainRule) ([]openapi.DomainRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+
+	rules, err := KeepRedacted(rules, s.rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(rules); err != nil {
+		return nil, err
+	}
+	if s.path != "" {
+		if err := Save(s.path, rules); err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(s.path); err == nil {
+			s.modTime = info.ModTime()
+		}
+	}
+	s.rules = rules
+	return append([]openapi.DomainRule(nil), rules...), nil
+}
+
+// Resolve returns what the rules in effect give a download from rawURL
+func (s *Store) Resolve(rawURL string) Profile {
+	return Resolve(s.Rules(), rawURL)
+}
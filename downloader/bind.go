@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bindLimiters are the rate limiters shared by the downloads bound to each
+// interface or address in this process
+var bindLimiters = struct {
+	sync.Mutex
+	m map[string]*RateLimiter
+}{m: make(map[string]*RateLimiter)}
+
+// BindLimiter returns the rate limiter every download bound to bind shares,
+// set to bytesPerSecond. The last download to start sets the rate.
+func BindLimiter(bind string, bytesPerSecond int64) *RateLimiter {
+	bindLimiters.Lock()
+	defer bindLimiters.Unlock()
+	limiter, ok := bindLimiters.m[bind]
+	if !ok {
+		limiter = NewRateLimiter(bytesPerSecond)
+		bindLimiters.m[bind] = limiter
+	} else if limiter.Rate() != bytesPerSecond {
+		limiter.SetRate(bytesPerSecond)
+	}
+	return limiter
+}
+
+// BindAddrs returns the local addresses connections bound to bind are made
+// from: bind itself when it is an IP address, else the addresses of the
+// network interface it names, IPv4 first. Link-local IPv6 addresses are
+// left out, as they cannot reach other networks.
+func BindAddrs(bind string) ([]net.IP, error) {
+	if ip := net.ParseIP(bind); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	iface, err := net.InterfaceByName(bind)
+	if err != nil {
+		return nil, fmt.Errorf("cannot bind to %s: %w", bind, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("cannot bind to %s: the interface is down", bind)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot bind to %s: %w", bind, err)
+	}
+	var v4, v6 []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		switch {
+		case ipNet.IP.To4() != nil:
+			v4 = append(v4, ipNet.IP)
+		case !ipNet.IP.IsLinkLocalUnicast():
+			v6 = append(v6, ipNet.IP)
+		}
+	}
+	if len(v4)+len(v6) == 0 {
+		return nil, fmt.Errorf("cannot bind to %s: the interface has no address", bind)
+	}
+	return append(v4, v6...), nil
+}
+
+// bindDialer returns a DialContext making connections from the addresses
+// of bind. A host is dialled from the first address of its family, so a
+// dual-stack interface reaches IPv4 and IPv6 hosts alike.
+func bindDialer(bind string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		local, err := BindAddrs(bind)
+		if err != nil {
+			return nil, err
+		}
+		families := []string{"4", "6"}
+		if network != "tcp" {
+			// tcp4 or tcp6 asks for one family
+			families, network = []string{network[len(network)-1:]}, network[:len(network)-1]
+		}
+		var lastErr error
+		for _, family := range families {
+			var ip net.IP
+			for _, candidate := range local {
+				if (candidate.To4() != nil) == (family == "4") {
+					ip = candidate
+					break
+				}
+			}
+			if ip == nil {
+				continue
+			}
+			dialer := &net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				LocalAddr: &net.TCPAddr{IP: ip},
+			}
+			conn, err := dialer.DialContext(ctx, network+family, addr)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
+			return nil, fmt.Errorf("cannot reach %s from %s: no address of its family", addr, bind)
+		}
+		return nil, fmt.Errorf("failed to connect from %s: %w", bind, lastErr)
+	}
+}
+
+// withBind makes the connections of client from the addresses of Bind,
+// when it is set. Transports other than *http.Transport are left alone.
+func (d *Downloader) withBind(client *http.Client) *http.Client {
+	if d.Bind == "" {
+		return client
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport), true
+	}
+	if !ok {
+		return client
+	}
+	transport = transport.Clone()
+	transport.DialContext = bindDialer(d.Bind)
+	client.Transport = transport
+	return client
+}
+
+// waitRate waits until the rate limits of the download allow n more bytes:
+// its own, the one shared with other downloads and the one of its Bind
+func (d *Downloader) waitRate(ctx context.Context, n int) error {
+	if err := d.limiter.Wait(ctx, n); err != nil {
+		return err
+	}
+	if d.SharedLimiter != nil {
+		if err := d.SharedLimiter.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	if limiter := d.bindLimiter(); limiter != nil {
+		return limiter.Wait(ctx, n)
+	}
+	return nil
+}
+
+// bindLimiter returns the limiter of Bind when BindRateLimit is set
+func (d *Downloader) bindLimiter() *RateLimiter {
+	if d.Bind == "" || d.BindRateLimit <= 0 {
+		return nil
+	}
+	d.bindOnce.Do(func() {
+		d.bindShared = BindLimiter(d.Bind, d.BindRateLimit)
+	})
+	return d.bindShared
+}
@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBindConnectsFromAddress(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux answers on all of 127.0.0.0/8")
+	}
+	data := testPayload(300 * 1024)
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		mu.Lock()
+		remotes[host] = true
+		mu.Unlock()
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL, 3)
+	dl.Bind = "127.0.0.2"
+	dl.BindRateLimit = 10 << 20
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match")
+	}
+	if len(remotes) != 1 || !remotes["127.0.0.2"] {
+		t.Errorf("server saw connections from %v, want only 127.0.0.2", remotes)
+	}
+	if BindLimiter("127.0.0.2", 5<<20) != dl.bindLimiter() || dl.bindLimiter().Rate() != 5<<20 {
+		t.Error("downloads bound to one address do not share its rate limiter")
+	}
+
+	// An address this host does not have cannot be connected from
+	dl = newTestDownloader(t, server.URL, 1)
+	dl.Bind = "192.0.2.1"
+	if _, _, err := dl.SupportsRange(); err == nil {
+		t.Error("binding to an address the host does not have succeeded")
+	}
+}
+
+func TestBindAddrsOfInterface(t *testing.T) {
+	if _, err := BindAddrs("no-such-interface0"); err == nil {
+		t.Error("BindAddrs() of a missing interface succeeded")
+	}
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := BindAddrs(iface.Name)
+		if err != nil || len(addrs) == 0 {
+			t.Fatalf("BindAddrs(%s) = %v, %v", iface.Name, addrs, err)
+		}
+		for i := 1; i < len(addrs); i++ {
+			if addrs[i].To4() != nil && addrs[i-1].To4() == nil {
+				t.Errorf("BindAddrs(%s) = %v, want IPv4 addresses first", iface.Name, addrs)
+			}
+		}
+		return
+	}
+	t.Skip("no loopback interface")
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Cache keeps finished downloads so that later downloads of the same file
+// are placed from it instead of fetched; see package cache
+type Cache interface {
+	// Fetch places the entry found under any of keys at dest if it has
+	// size bytes and reports whether it did
+	Fetch(keys []string, size int64, dest string) (bool, error)
+	// Store adds the finished file at src under keys
+	Store(keys []string, src string) error
+}
+
+// cacheKeys returns the keys the file is cached under: every checksum the
+// server sent, which names the content wherever it is downloaded from, and
+// the URL with its ETag. A file with neither has no keys, since the URL
+// alone does not tell its versions apart.
+func (d *Downloader) cacheKeys() []string {
+	if d.Cache == nil || d.Progress == nil || d.EncryptionKey != nil || d.customRequest() || d.joined() {
+		return nil
+	}
+	var keys []string
+	for _, digest := range d.Progress.Digests {
+		keys = append(keys, digest.Algorithm+":"+hex.EncodeToString(digest.Value))
+	}
+	if d.Progress.ETag != "" {
+		keys = append(keys, "url:"+d.URL+"\netag:"+d.Progress.ETag)
+	}
+	return keys
+}
+
+// fetchCached places the file from the cache before a download starts and
+// reports whether it did; every part is then done. A cache that fails is
+// only a missed chance, so the download goes ahead.
+func (d *Downloader) fetchCached() bool {
+	keys := d.cacheKeys()
+	if len(keys) == 0 || d.Progress.GetTotalDownloaded() > 0 {
+		return false
+	}
+	hit, err := d.Cache.Fetch(keys, d.Progress.TotalSize, d.Filename)
+	if err != nil {
+		fmt.Printf("Warning: cache lookup failed: %v\n", err)
+		return false
+	}
+	if !hit {
+		return false
+	}
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		part.SetDownloaded(part.Size())
+		part.SetDone(true)
+	}
+	d.cached = true
+	fmt.Printf("Found %s in the cache, skipping the download\n", d.Filename)
+	return true
+}
+
+// storeCached adds the verified file to the cache
+func (d *Downloader) storeCached() {
+	if d.cached {
+		return
+	}
+	keys := d.cacheKeys()
+	if len(keys) == 0 {
+		return
+	}
+	if err := d.Cache.Store(keys, d.Filename); err != nil {
+		fmt.Printf("Warning: could not add the file to the cache: %v\n", err)
+	}
+}
+
+// Cached reports whether the file was placed from the cache instead of
+// downloaded
+func (d *Downloader) Cached() bool {
+	return d.cached
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/cache"
+)
+
+func TestDownloadServedFromCache(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	store, err := cache.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := newTestDownloader(t, server.URL, 4)
+	first.Cache = store
+	if err := runDownload(t, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	fetched := atomic.LoadInt32(&gets)
+
+	second := newTestDownloader(t, server.URL, 4)
+	second.Cache = store
+	if err := runDownload(t, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	if !second.Cached() {
+		t.Error("second download was not served from the cache")
+	}
+	if got := atomic.LoadInt32(&gets); got != fetched {
+		t.Errorf("second download made %d GET requests, want none", got-fetched)
+	}
+	if got, _ := os.ReadFile(second.Filename); !bytes.Equal(got, data) {
+		t.Error("cached file does not match")
+	}
+}
+
+func TestDownloadWithoutValidatorIsNotCached(t *testing.T) {
+	data := testPayload(SmallFileSize / 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	store, err := cache.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := newTestDownloader(t, server.URL, 1)
+	dl.Cache = store
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := store.Size(); size != 0 {
+		t.Errorf("cache holds %d bytes of a file without an ETag or checksum", size)
+	}
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrNotModified is returned by CheckModified when the existing output is
+// the current version of the remote file
+var ErrNotModified = errors.New("remote file not modified")
+
+// Validators identify the version of a downloaded file. They are saved
+// next to the output so a later download can ask for the file only if it
+// changed.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// ValidatorsFile returns where the validators of output are saved
+func ValidatorsFile(output string) string {
+	return output + ".validators"
+}
+
+// LoadValidators reads the validators saved for output
+func LoadValidators(output string) (Validators, error) {
+	var v Validators
+	data, err := os.ReadFile(ValidatorsFile(output))
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("invalid validators file: %w", err)
+	}
+	return v, nil
+}
+
+// CheckModified asks the server whether the file changed since the existing
+// output was downloaded, with If-None-Match for the saved ETag and
+// If-Modified-Since for the saved Last-Modified date, or the output's
+// modification time. It returns ErrNotModified when it did not, and nil
+// when the file must be downloaded: it changed, there is no output yet, or
+// a download of it is in progress. A server that ignores the conditions is
+// judged by the ETag, or by the date and size like wget -N.
+//
+// It does nothing unless OnlyIfModified is set, and for downloads fetched
+// with a custom request or joined from pieces.
+func (d *Downloader) CheckModified(ctx context.Context) error {
+	if !d.OnlyIfModified || d.customRequest() || d.joined() {
+		return nil
+	}
+	stat, err := os.Stat(LongPath(d.Filename))
+	if err != nil || !stat.Mode().IsRegular() || d.HasProgress() {
+		return nil
+	}
+	saved, err := LoadValidators(d.Filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	req, err := d.newRequest(ctx, http.MethodHead)
+	if err != nil {
+		return err
+	}
+	if saved.ETag != "" {
+		req.Header.Set("If-None-Match", saved.ETag)
+	}
+	since := saved.LastModified
+	if since == "" {
+		since = stat.ModTime().UTC().Format(http.TimeFormat)
+	}
+	req.Header.Set("If-Modified-Since", since)
+
+	client := d.withTransport(&http.Client{Timeout: 30 * time.Second})
+	waitForHost(ctx, req.URL.Host)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to check for a newer version: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return ErrNotModified
+	case http.StatusOK:
+	default:
+		// The download reports the error
+		return nil
+	}
+	if etag := strongETag(resp.Header.Get("ETag")); etag != "" || saved.ETag != "" {
+		if etag != "" && etag == saved.ETag {
+			return ErrNotModified
+		}
+		d.replace = true
+		return nil
+	}
+	if !d.sameSize(stat.Size(), resp.ContentLength) {
+		d.replace = true
+		return nil
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err == nil && !modified.After(stat.ModTime()) {
+		return ErrNotModified
+	}
+	d.replace = true
+	return nil
+}
+
+// sameSize reports whether an output of size bytes holds a remote file of
+// length bytes; encrypted outputs are larger than the file
+func (d *Downloader) sameSize(size, length int64) bool {
+	if length < 0 {
+		return true
+	}
+	if d.EncryptionKey != nil {
+		return size == EncryptedSize(length)
+	}
+	return size == length
+}
+
+// saveValidators records the validators of a verified download next to
+// it and dates the output with the file's Last-Modified, so the next
+// CheckModified can ask for the file only if it changed
+func (d *Downloader) saveValidators() {
+	if !d.OnlyIfModified || d.Progress == nil {
+		return
+	}
+	v := Validators{ETag: d.Progress.ETag, LastModified: d.Progress.LastModified}
+	if modified, err := http.ParseTime(v.LastModified); err == nil {
+		os.Chtimes(LongPath(d.Filename), modified, modified)
+	}
+	path := ValidatorsFile(d.Filename)
+	if v.ETag == "" && v.LastModified == "" {
+		os.Remove(path)
+		return
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Warning: could not save validators: %v\n", err)
+	}
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnlyIfModified(t *testing.T) {
+	var mu sync.Mutex
+	data, etag := testPayload(3*SmallFileSize), `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		body, tag := data, etag
+		mu.Unlock()
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(body))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL, 4)
+	dl.OnlyIfModified = true
+	if err := dl.CheckModified(context.Background()); err != nil {
+		t.Fatalf("CheckModified() without an output = %v", err)
+	}
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := LoadValidators(dl.Filename); err != nil || v.ETag != `"v1"` {
+		t.Fatalf("LoadValidators() = %+v, %v", v, err)
+	}
+
+	again := NewDownloader(server.URL, dl.Filename, 4)
+	again.ProgressFile = dl.ProgressFile
+	again.OnlyIfModified = true
+	if err := again.CheckModified(context.Background()); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("CheckModified() = %v, want ErrNotModified", err)
+	}
+
+	// A new, shorter version replaces the output
+	mu.Lock()
+	data, etag = testPayload(SmallFileSize*2 + 5)[5:], `"v2"`
+	mu.Unlock()
+	updated := NewDownloader(server.URL, dl.Filename, 4)
+	updated.ProgressFile = dl.ProgressFile
+	updated.MinPartSize = 0
+	updated.OnlyIfModified = true
+	if err := updated.CheckModified(context.Background()); err != nil {
+		t.Fatalf("CheckModified() after a change = %v", err)
+	}
+	if err := runDownload(t, updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := updated.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Error("output was not replaced with the new version")
+	}
+}
+
+func TestOnlyIfModifiedWithoutConditionalSupport(t *testing.T) {
+	data := testPayload(SmallFileSize / 2)
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// The server answers every request in full
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("If-Modified-Since")
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL, 1)
+	dl.OnlyIfModified = true
+	if err := os.WriteFile(dl.Filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(dl.Filename, modified, modified)
+	if err := dl.CheckModified(context.Background()); !errors.Is(err, ErrNotModified) {
+		t.Errorf("CheckModified() = %v, want ErrNotModified for the same date and size", err)
+	}
+
+	os.WriteFile(dl.Filename, data[1:], 0644)
+	os.Chtimes(dl.Filename, modified, modified)
+	if err := dl.CheckModified(context.Background()); err != nil {
+		t.Errorf("CheckModified() = %v for an output of another size", err)
+	}
+}
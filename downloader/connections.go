@@ -0,0 +1,235 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPartNotRunning is returned when restarting a part that has no request
+// in flight
+var ErrPartNotRunning = errors.New("part is not transferring")
+
+// PartState says what a part's worker is doing
+type PartState string
+
+const (
+	// PartPending parts wait for a thread or have not started
+	PartPending PartState = "pending"
+	// PartConnecting parts have sent their request and wait for the answer
+	PartConnecting PartState = "connecting"
+	// PartTransferring parts are reading the response body
+	PartTransferring PartState = "transferring"
+	// PartRetrying parts failed and are about to try again
+	PartRetrying PartState = "retrying"
+	// PartDone parts are complete
+	PartDone PartState = "done"
+)
+
+// PartConnection describes a part and the connection it is downloading
+// over, to find connections that stalled on a slow mirror or a bad route
+type PartConnection struct {
+	Index      int
+	Start      int64
+	End        int64
+	Downloaded int64
+	State      PartState
+	// RemoteAddr is the address of the server the part's connection goes to
+	RemoteAddr string
+	// Bytes counts what the current attempt received and Speed its average
+	// in bytes per second since the response arrived
+	Bytes        int64
+	Speed        float64
+	StartedAt    time.Time
+	LastActivity time.Time
+	// Attempts counts the requests sent for the part in this run
+	Attempts  int
+	LastError string
+}
+
+// partConn is the live state of one part's attempt
+type partConn struct {
+	bytes        int64 // atomic
+	lastActivity int64 // atomic, UnixNano
+
+	mu          sync.Mutex
+	state       PartState
+	remoteAddr  string
+	startedAt   time.Time
+	attempts    int
+	lastError   string
+	cancel      context.CancelFunc
+	transferred time.Time
+	// restarted is set when RestartPart ended the attempt
+	restarted bool
+}
+
+// received counts n bytes of the part's response
+func (c *partConn) received(n int) {
+	atomic.AddInt64(&c.bytes, int64(n))
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+func (c *partConn) setState(state PartState) {
+	c.mu.Lock()
+	c.state = state
+	if state == PartTransferring {
+		c.transferred = time.Now()
+	}
+	c.mu.Unlock()
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+// failed records why an attempt failed
+func (c *partConn) failed(err error) {
+	c.mu.Lock()
+	c.state = PartRetrying
+	if !c.restarted {
+		c.lastError = err.Error()
+	}
+	c.mu.Unlock()
+}
+
+// trace records the address of the connection the attempt's request uses
+func (c *partConn) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.mu.Lock()
+			c.remoteAddr = info.Conn.RemoteAddr().String()
+			c.mu.Unlock()
+		},
+	})
+}
+
+// connTracker holds the attempts of a download's parts by index
+type connTracker struct {
+	mu    sync.Mutex
+	parts map[int]*partConn
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{parts: make(map[int]*partConn)}
+}
+
+// begin starts a new attempt of a part; cancel stops it
+func (t *connTracker) begin(index int, cancel context.CancelFunc) *partConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.parts[index]
+	if !ok {
+		c = &partConn{}
+		t.parts[index] = c
+	}
+	now := time.Now()
+	atomic.StoreInt64(&c.bytes, 0)
+	atomic.StoreInt64(&c.lastActivity, now.UnixNano())
+	c.mu.Lock()
+	c.state = PartConnecting
+	c.remoteAddr = ""
+	c.startedAt = now
+	c.transferred = time.Time{}
+	c.attempts++
+	c.cancel = cancel
+	c.restarted = false
+	c.mu.Unlock()
+	return c
+}
+
+// end records that a part's worker stopped, done or not
+func (t *connTracker) end(index int, done bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.parts[index]
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.cancel = nil
+	if done {
+		c.state = PartDone
+	} else {
+		c.state = PartPending
+	}
+	c.mu.Unlock()
+}
+
+// PartConnections reports every part of the download with the connection
+// it is using, in part order. It is empty before the download started.
+func (d *Downloader) PartConnections() []PartConnection {
+	if d.Progress == nil || d.conns == nil {
+		return nil
+	}
+	now := time.Now()
+	parts := make([]PartConnection, len(d.Progress.Parts))
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		info := PartConnection{
+			Index:      part.Index,
+			Start:      part.Start,
+			End:        part.End,
+			Downloaded: part.Downloaded(),
+			State:      PartPending,
+		}
+
+		d.conns.mu.Lock()
+		c := d.conns.parts[part.Index]
+		d.conns.mu.Unlock()
+		if c != nil {
+			info.Bytes = atomic.LoadInt64(&c.bytes)
+			info.LastActivity = time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+			c.mu.Lock()
+			info.State = c.state
+			info.RemoteAddr = c.remoteAddr
+			info.StartedAt = c.startedAt
+			info.Attempts = c.attempts
+			info.LastError = c.lastError
+			if c.state == PartTransferring {
+				if elapsed := now.Sub(c.transferred).Seconds(); elapsed > 0 {
+					info.Speed = float64(info.Bytes) / elapsed
+				}
+			}
+			c.mu.Unlock()
+		}
+		if part.Done() {
+			info.State = PartDone
+			info.Speed = 0
+		}
+		parts[i] = info
+	}
+	return parts
+}
+
+// RestartPart drops the connection of a part that seems stuck; the part
+// sends a new request from where it got to
+func (d *Downloader) RestartPart(index int) error {
+	if d.Progress == nil || index < 0 || index >= len(d.Progress.Parts) {
+		return fmt.Errorf("no part %d", index)
+	}
+	if d.conns == nil {
+		return ErrPartNotRunning
+	}
+	d.conns.mu.Lock()
+	c := d.conns.parts[index]
+	d.conns.mu.Unlock()
+	if c == nil {
+		return ErrPartNotRunning
+	}
+
+	c.mu.Lock()
+	cancel := c.cancel
+	active := c.state == PartConnecting || c.state == PartTransferring
+	if cancel != nil && active {
+		c.lastError = "restarted by request"
+		c.restarted = true
+	}
+	c.mu.Unlock()
+	if cancel == nil || !active {
+		return ErrPartNotRunning
+	}
+	cancel()
+	return nil
+}
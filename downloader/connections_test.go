@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRestartPartRecoversStalledConnection(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	// The first request for part 0 sends some bytes, then stalls until the
+	// client drops it
+	var mu sync.Mutex
+	stalled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		stall := r.Method == http.MethodGet && strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") && !stalled
+		stalled = stalled || stall
+		mu.Unlock()
+		if !stall {
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 0-"+strconv.Itoa(SmallFileSize*2-1)+"/"+strconv.Itoa(len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(SmallFileSize*2))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[:1024])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 2)
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.RestartPart(0); !errors.Is(err, ErrPartNotRunning) {
+		t.Errorf("RestartPart() before the download = %v, want ErrPartNotRunning", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- dl.DownloadContext(ctx) }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		parts := dl.PartConnections()
+		if len(parts) == 2 && parts[0].State == PartTransferring && parts[0].Bytes == 1024 && parts[1].State == PartDone {
+			if parts[0].RemoteAddr == "" || parts[0].Attempts != 1 {
+				t.Errorf("stalled part = %+v", parts[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("part 0 never stalled: %+v", parts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := dl.RestartPart(1); !errors.Is(err, ErrPartNotRunning) {
+		t.Errorf("RestartPart() of a finished part = %v, want ErrPartNotRunning", err)
+	}
+	if err := dl.RestartPart(5); err == nil {
+		t.Error("RestartPart() of a missing part succeeded")
+	}
+	if err := dl.RestartPart(0); err != nil {
+		t.Fatalf("RestartPart() = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("DownloadContext() = %v", err)
+	}
+
+	part := dl.PartConnections()[0]
+	if part.State != PartDone || part.Attempts != 2 || part.LastError != "restarted by request" {
+		t.Errorf("restarted part = %+v", part)
+	}
+	got, err := os.ReadFile(dl.Filename)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("downloaded file differs from the payload (err %v)", err)
+	}
+}
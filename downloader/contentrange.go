@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentRange is a parsed "Content-Range: bytes start-end/total" header.
+// Total is -1 when the server reports it as unknown ("*").
+type ContentRange struct {
+	Start int64
+	End   int64
+	Total int64
+}
+
+// Length returns the number of bytes covered by the range
+func (cr ContentRange) Length() int64 {
+	return cr.End - cr.Start + 1
+}
+
+// ParseContentRange parses a Content-Range header of a 206 response
+func ParseContentRange(value string) (ContentRange, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range %q", value)
+	}
+
+	spec := strings.TrimSpace(strings.TrimPrefix(value, "bytes "))
+	slash := strings.IndexByte(spec, '/')
+	if slash < 0 {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	rangePart, totalPart := spec[:slash], spec[slash+1:]
+
+	dash := strings.IndexByte(rangePart, '-')
+	if dash < 0 {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range %q", value)
+	}
+
+	start, err := strconv.ParseInt(rangePart[:dash], 10, 64)
+	if err != nil {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range start in %q", value)
+	}
+	end, err := strconv.ParseInt(rangePart[dash+1:], 10, 64)
+	if err != nil {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range end in %q", value)
+	}
+	if start < 0 || end < start {
+		return ContentRange{}, fmt.Errorf("invalid Content-Range bounds in %q", value)
+	}
+
+	total := int64(-1)
+	if totalPart != "*" {
+		total, err = strconv.ParseInt(totalPart, 10, 64)
+		if err != nil || total <= end {
+			return ContentRange{}, fmt.Errorf("invalid Content-Range total in %q", value)
+		}
+	}
+
+	return ContentRange{Start: start, End: end, Total: total}, nil
+}
+
+// unsatisfiedRangeTotal returns the file size of a 416 response, which
+// servers send for any range of an empty file ("Content-Range: bytes */0")
+func unsatisfiedRangeTotal(resp *http.Response) (int64, bool) {
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Content-Range"))
+	if !strings.HasPrefix(value, "bytes */") {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(strings.TrimPrefix(value, "bytes */"), 10, 64)
+	if err != nil || total < 0 {
+		return 0, false
+	}
+	return total, true
+}
+
+// validatePartResponse checks that a response to "Range: bytes=start-end"
+// actually carries that range and returns how many bytes of the body belong
+// to it. Servers may send less than requested but never a different offset,
+// more bytes, or a different file size.
+func (d *Downloader) validatePartResponse(resp *http.Response, start, end int64) (int64, error) {
+	total := int64(-1)
+	if d.Progress != nil {
+		total = d.Progress.TotalSize
+	}
+	return validateRangeResponse(resp, start, end, total)
+}
+
+// validateRangeResponse is validatePartResponse for a file of total bytes,
+// or of unknown size for a negative total
+func validateRangeResponse(resp *http.Response, start, end, total int64) (int64, error) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, err
+		}
+		if cr.Start != start || cr.End > end {
+			return 0, fmt.Errorf("server returned range %d-%d, requested %d-%d", cr.Start, cr.End, start, end)
+		}
+		if total >= 0 && cr.Total >= 0 && cr.Total != total {
+			return 0, fmt.Errorf("server reported file size %d, expected %d", cr.Total, total)
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength != cr.Length() {
+			return 0, fmt.Errorf("Content-Length %d does not match Content-Range %d-%d", resp.ContentLength, cr.Start, cr.End)
+		}
+		return cr.Length(), nil
+
+	case http.StatusOK:
+		// A full response is only usable when the part starts at the beginning
+		// of the file; the body is cut off at the end of the part
+		if start != 0 {
+			return 0, fmt.Errorf("server ignored range request for bytes %d-%d", start, end)
+		}
+		return end - start + 1, nil
+	}
+
+	return 0, fmt.Errorf("unexpected status: %s", resp.Status)
+}
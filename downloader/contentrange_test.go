@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ContentRange
+	}{
+		{"bytes 0-99/1000", ContentRange{Start: 0, End: 99, Total: 1000}},
+		{"bytes 500-999/1000", ContentRange{Start: 500, End: 999, Total: 1000}},
+		{"  bytes 7-7/*  ", ContentRange{Start: 7, End: 7, Total: -1}},
+	}
+	for _, tt := range tests {
+		got, err := ParseContentRange(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseContentRange(%q) = %+v, %v, want %+v", tt.value, got, err, tt.want)
+		}
+	}
+	if got, _ := ParseContentRange("bytes 10-19/100"); got.Length() != 10 {
+		t.Errorf("Length() = %d, want 10", got.Length())
+	}
+
+	for _, value := range []string{
+		"",
+		"0-99/1000",
+		"items 0-99/1000",
+		"bytes 0-99",
+		"bytes 99/1000",
+		"bytes x-99/1000",
+		"bytes 0-y/1000",
+		"bytes 50-10/1000",
+		"bytes -5-10/1000",
+		"bytes 0-99/99",
+		"bytes 0-99/lots",
+		"bytes */1000",
+	} {
+		if cr, err := ParseContentRange(value); err == nil {
+			t.Errorf("ParseContentRange(%q) = %+v, want an error", value, cr)
+		}
+	}
+}
+
+func TestValidatePartResponse(t *testing.T) {
+	d := &Downloader{Progress: &Progress{TotalSize: 1000}}
+	response := func(status int, contentRange string, length int64) *http.Response {
+		resp := &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}, ContentLength: length}
+		if contentRange != "" {
+			resp.Header.Set("Content-Range", contentRange)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		resp       *http.Response
+		start, end int64
+		want       int64
+		ok         bool
+	}{
+		{"exact range", response(206, "bytes 100-199/1000", 100), 100, 199, 100, true},
+		{"shorter range", response(206, "bytes 100-149/1000", 50), 100, 199, 50, true},
+		{"unknown total and length", response(206, "bytes 100-199/*", -1), 100, 199, 100, true},
+		{"wrong offset", response(206, "bytes 0-99/1000", 100), 100, 199, 0, false},
+		{"later offset", response(206, "bytes 150-199/1000", 50), 100, 199, 0, false},
+		{"over-long range", response(206, "bytes 100-299/1000", 200), 100, 199, 0, false},
+		{"mismatched total", response(206, "bytes 100-199/2000", 100), 100, 199, 0, false},
+		{"Content-Length mismatch", response(206, "bytes 100-199/1000", 80), 100, 199, 0, false},
+		{"missing Content-Range", response(206, "", 100), 100, 199, 0, false},
+		{"full response for the first part", response(200, "", 1000), 0, 249, 250, true},
+		{"full response for a later part", response(200, "", 1000), 250, 499, 0, false},
+		{"range not satisfiable", response(416, "bytes */1000", 0), 100, 199, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.validatePartResponse(tt.resp, tt.start, tt.end)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("validatePartResponse() = %d, %v, want %d (ok %v)", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
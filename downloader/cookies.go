@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in files exported by browsers
+const httpOnlyPrefix = "#HttpOnly_"
+
+// ParseCookiesFile parses cookies in the Netscape cookies.txt format used by
+// browsers, curl and wget. Domains starting with a dot (include subdomains)
+// keep the dot; expired cookies are skipped and a missing value is empty.
+func ParseCookiesFile(r io.Reader) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	now := time.Now()
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		// Some exporters drop the tab before an empty value
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNum, len(fields))
+		}
+
+		domain, includeSubdomains, path, secure, expiry, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+		expires, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", lineNum, expiry)
+		}
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HttpOnly: httpOnly,
+		}
+
+		// Session cookies have an expiry of zero
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if cookie.Expires.Before(now) {
+				continue
+			}
+		}
+
+		domain = strings.TrimPrefix(domain, ".")
+		if strings.EqualFold(includeSubdomains, "TRUE") {
+			domain = "." + domain
+		}
+		cookie.Domain = domain
+
+		cookies = append(cookies, cookie)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cookies: %w", err)
+	}
+
+	return cookies, nil
+}
+
+// NewCookieJar creates an empty cookie jar
+func NewCookieJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
+
+// ImportCookies adds cookies parsed by ParseCookiesFile to a jar so they are
+// sent to matching domains only
+func ImportCookies(jar http.CookieJar, cookies []*http.Cookie) {
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host == "" {
+			continue
+		}
+
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: host, Path: c.Path}
+
+		// Host-only cookies are stored without a Domain attribute
+		cookie := *c
+		if !strings.HasPrefix(c.Domain, ".") {
+			cookie.Domain = ""
+		}
+		jar.SetCookies(u, []*http.Cookie{&cookie})
+	}
+}
+
+// LoadCookiesFile reads a cookies.txt file into a new cookie jar
+func LoadCookiesFile(path string) (http.CookieJar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookies file: %w", err)
+	}
+	defer file.Close()
+
+	cookies, err := ParseCookiesFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	jar := NewCookieJar()
+	ImportCookies(jar, cookies)
+	return jar, nil
+}
+
+// MergeCookies adds cookies to existing ones. A cookie replaces the earlier
+// one with the same domain, path and name, so importing a file again updates
+// it instead of adding duplicates.
+func MergeCookies(existing, added []*http.Cookie) []*http.Cookie {
+	type cookieKey struct{ domain, path, name string }
+	index := make(map[cookieKey]int)
+	var merged []*http.Cookie
+	for _, c := range append(append([]*http.Cookie(nil), existing...), added...) {
+		key := cookieKey{c.Domain, c.Path, c.Name}
+		if i, ok := index[key]; ok {
+			merged[i] = c
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// FormatCookiesFile writes cookies in the Netscape cookies.txt format that
+// ParseCookiesFile reads
+func FormatCookiesFile(cookies []*http.Cookie) string {
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range cookies {
+		if c.HttpOnly {
+			b.WriteString(httpOnlyPrefix)
+		}
+		includeSubdomains := "FALSE"
+		if strings.HasPrefix(c.Domain, ".") {
+			includeSubdomains = "TRUE"
+		}
+		secure := "FALSE"
+		if c.Secure {
+			secure = "TRUE"
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", c.Domain, includeSubdomains, c.Path, secure, expires, c.Name, c.Value)
+	}
+	return b.String()
+}
+
+// CookieDomains returns the distinct domains covered by a set of cookies
+func CookieDomains(cookies []*http.Cookie) []string {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, c := range cookies {
+		domain := strings.TrimPrefix(c.Domain, ".")
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCookiesFile(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	file := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tTRUE\t" + strconv.FormatInt(future, 10) + "\tsid\tabc123",
+		"#HttpOnly_shop.example.org\tFALSE\t/cart\tFALSE\t0\tcart\t42\r",
+		"old.example.com\tFALSE\t/\tFALSE\t1000\texpired\tx",
+		"empty.example.com\tFALSE\t/\tFALSE\t0\tflag",
+	}, "\n")
+
+	cookies, err := ParseCookiesFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 3 {
+		t.Fatalf("parsed %d cookies, want 3: %v", len(cookies), cookies)
+	}
+
+	sid := cookies[0]
+	if sid.Domain != ".example.com" || sid.Name != "sid" || sid.Value != "abc123" || !sid.Secure || sid.HttpOnly || sid.Expires.Unix() != future {
+		t.Errorf("subdomain cookie = %+v", sid)
+	}
+	cart := cookies[1]
+	if cart.Domain != "shop.example.org" || cart.Path != "/cart" || !cart.HttpOnly || cart.Secure || !cart.Expires.IsZero() || cart.Value != "42" {
+		t.Errorf("host-only session cookie = %+v", cart)
+	}
+	if flag := cookies[2]; flag.Name != "flag" || flag.Value != "" {
+		t.Errorf("cookie without a value = %+v", flag)
+	}
+
+	if got := CookieDomains(cookies); strings.Join(got, ",") != "empty.example.com,example.com,shop.example.org" {
+		t.Errorf("CookieDomains() = %v", got)
+	}
+}
+
+func TestParseCookiesFileRejectsMalformedLines(t *testing.T) {
+	for _, line := range []string{
+		"example.com\tFALSE\t/\tFALSE\t0",
+		"example.com\tFALSE\t/\tFALSE\tsoon\tname\tvalue",
+		"example.com\tFALSE\t/\tFALSE\t0\tname\tvalue\textra",
+	} {
+		if _, err := ParseCookiesFile(strings.NewReader(line)); err == nil {
+			t.Errorf("ParseCookiesFile(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestImportCookiesScopesDomains(t *testing.T) {
+	file := ".example.com\tTRUE\t/\tFALSE\t0\twide\t1\n" +
+		"host.example.net\tFALSE\t/\tFALSE\t0\tnarrow\t2\n" +
+		"secure.example.org\tFALSE\t/\tTRUE\t0\tsecret\t3\n"
+	cookies, err := ParseCookiesFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar := NewCookieJar()
+	ImportCookies(jar, cookies)
+
+	tests := map[string]string{
+		"http://example.com/":          "wide",
+		"http://cdn.example.com/x":     "wide",
+		"http://host.example.net/":     "narrow",
+		"http://sub.host.example.net/": "",
+		"http://secure.example.org/":   "",
+		"https://secure.example.org/":  "secret",
+		"http://other.com/":            "",
+	}
+	for rawURL, want := range tests {
+		u, _ := url.Parse(rawURL)
+		var names []string
+		for _, c := range jar.Cookies(u) {
+			names = append(names, c.Name)
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("cookies for %s = %q, want %q", rawURL, got, want)
+		}
+	}
+}
+
+func TestMergeCookiesRoundTrip(t *testing.T) {
+	first := "#HttpOnly_.example.com\tTRUE\t/\tTRUE\t0\tsid\told\n" +
+		"example.com\tFALSE\t/\tFALSE\t0\ttheme\tdark\n"
+	second := ".example.com\tTRUE\t/\tTRUE\t0\tsid\tnew\n" +
+		".example.com\tTRUE\t/app\tFALSE\t0\tsid\tapp\n"
+	existing, err := ParseCookiesFile(strings.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := ParseCookiesFile(strings.NewReader(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := MergeCookies(existing, added)
+	merged = MergeCookies(merged, added)
+	reparsed, err := ParseCookiesFile(strings.NewReader(FormatCookiesFile(merged)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range reparsed {
+		got = append(got, c.Domain+c.Path+" "+c.Name+"="+c.Value)
+	}
+	want := ".example.com/ sid=new,example.com/ theme=dark,.example.com/app sid=app"
+	if strings.Join(got, ",") != want {
+		t.Errorf("merged cookies = %v, want %s", got, want)
+	}
+	if reparsed[0].HttpOnly || !reparsed[0].Secure {
+		t.Errorf("replaced cookie kept the old attributes: %+v", reparsed[0])
+	}
+}
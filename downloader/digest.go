@@ -0,0 +1,325 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when the downloaded file does not match a
+// checksum the server sent with it
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Results of checking a file against the checksums the server sent
+const (
+	ChecksumVerified = "verified"
+	ChecksumMismatch = "mismatch"
+)
+
+// Digest is a checksum of the file, or of one part of it, sent by the server
+// or taken as the bytes arrived
+type Digest struct {
+	// Algorithm is md5, sha-1, sha-256 or sha-512
+	Algorithm string `json:"algorithm"`
+	Value     []byte `json:"value"`
+	// Source is the header or trailer the digest was read from
+	Source string `json:"source"`
+}
+
+// Checksum is the result of checking a finished download against the
+// digests its server sent
+type Checksum struct {
+	Status    string `json:"status"`
+	Algorithm string `json:"algorithm"`
+	Source    string `json:"source"`
+}
+
+// digestAlgorithms lists the supported algorithms from strongest to weakest
+var digestAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha-512", sha512.New},
+	{"sha-256", sha256.New},
+	{"sha-1", sha1.New},
+	{"md5", md5.New},
+}
+
+// digestAlgorithm returns the canonical name of a digest algorithm token,
+// or "" if it is not supported. RFC 3230 calls SHA-1 "SHA".
+func digestAlgorithm(token string) string {
+	name := strings.ToLower(strings.TrimSpace(token))
+	if name == "sha" {
+		name = "sha-1"
+	}
+	for _, a := range digestAlgorithms {
+		if a.name == name {
+			return name
+		}
+	}
+	return ""
+}
+
+// NewDigest returns the digest of the named algorithm whose value is given
+// in base64 or hex, for checksums published apart from the file's server
+func NewDigest(algorithm, value, source string) (Digest, error) {
+	name := digestAlgorithm(algorithm)
+	if name == "" {
+		return Digest{}, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	decoded, ok := decodeDigest(name, strings.TrimSpace(value))
+	if !ok {
+		return Digest{}, fmt.Errorf("invalid %s digest %q", name, value)
+	}
+	return Digest{Algorithm: name, Value: decoded, Source: source}, nil
+}
+
+// newDigestHash returns a hash for a canonical algorithm name
+func newDigestHash(algorithm string) hash.Hash {
+	for _, a := range digestAlgorithms {
+		if a.name == algorithm {
+			return a.new()
+		}
+	}
+	return nil
+}
+
+// decodeDigest decodes a digest value, base64 as the RFCs say or hex as some
+// servers send it, and checks its length for the algorithm
+func decodeDigest(algorithm, value string) ([]byte, bool) {
+	size := newDigestHash(algorithm).Size()
+	if b, err := base64.StdEncoding.DecodeString(value); err == nil && len(b) == size {
+		return b, true
+	}
+	if b, err := hex.DecodeString(value); err == nil && len(b) == size {
+		return b, true
+	}
+	return nil, false
+}
+
+// responseDigests returns the whole-file digests a response carries in its
+// headers, or in its trailers once the body has been read. Content-MD5 and
+// Content-Digest describe the bytes of this response, so they only count
+// for a complete 200 response; Digest (RFC 3230) and Repr-Digest (RFC 9530)
+// describe the whole file even on a 206. Compressed responses carry digests
+// of the compressed bytes and are skipped.
+func responseDigests(resp *http.Response, trailer bool) []Digest {
+	if !identityEncoded(resp) {
+		return nil
+	}
+
+	fields, suffix := digestFields(resp, trailer)
+	var digests []Digest
+	if resp.StatusCode == http.StatusOK {
+		digests = append(digests, contentDigests(fields, suffix)...)
+	}
+	digests = append(digests, parseDigestFields(fields.Values("Repr-Digest"), true, "Repr-Digest"+suffix)...)
+	digests = append(digests, parseDigestFields(fields.Values("Digest"), false, "Digest"+suffix)...)
+	return digests
+}
+
+// rangeDigest returns the strongest digest of the bytes of this response
+// alone, which servers send as Content-Digest or Content-MD5 with ranges too
+func rangeDigest(resp *http.Response, trailer bool) *Digest {
+	if !identityEncoded(resp) {
+		return nil
+	}
+	fields, suffix := digestFields(resp, trailer)
+	return strongestDigest(contentDigests(fields, suffix))
+}
+
+// identityEncoded reports whether the body is sent as is, so digests of the
+// transferred bytes are digests of the file
+func identityEncoded(resp *http.Response) bool {
+	if resp.Uncompressed {
+		return false
+	}
+	encoding := resp.Header.Get("Content-Encoding")
+	return encoding == "" || strings.EqualFold(encoding, "identity")
+}
+
+// digestFields returns the headers or the trailers of a response and the
+// suffix that tells them apart in a digest's source
+func digestFields(resp *http.Response, trailer bool) (http.Header, string) {
+	if trailer {
+		return resp.Trailer, " trailer"
+	}
+	return resp.Header, ""
+}
+
+// contentDigests returns the Content-MD5 and Content-Digest digests of the
+// bytes of a response
+func contentDigests(fields http.Header, suffix string) []Digest {
+	var digests []Digest
+	if value := fields.Get("Content-MD5"); value != "" {
+		if b, ok := decodeDigest("md5", strings.TrimSpace(value)); ok {
+			digests = append(digests, Digest{Algorithm: "md5", Value: b, Source: "Content-MD5" + suffix})
+		}
+	}
+	return append(digests, parseDigestFields(fields.Values("Content-Digest"), true, "Content-Digest"+suffix)...)
+}
+
+// strongestDigest returns the digest with the strongest algorithm, or nil
+func strongestDigest(digests []Digest) *Digest {
+	for _, a := range digestAlgorithms {
+		for i := range digests {
+			if digests[i].Algorithm == a.name {
+				return &digests[i]
+			}
+		}
+	}
+	return nil
+}
+
+// parseDigestFields parses Digest header values, either RFC 3230 style
+// (SHA-256=base64) or RFC 9530 style (sha-256=:base64:). Unknown algorithms
+// and malformed values are ignored.
+func parseDigestFields(values []string, structured bool, source string) []Digest {
+	var digests []Digest
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			// Parameters after ';' carry nothing a checksum needs
+			member = strings.TrimSpace(strings.SplitN(member, ";", 2)[0])
+			eq := strings.IndexByte(member, '=')
+			if eq < 0 {
+				continue
+			}
+			algorithm := digestAlgorithm(member[:eq])
+			encoded := strings.TrimSpace(member[eq+1:])
+			if structured {
+				if len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+					continue
+				}
+				encoded = encoded[1 : len(encoded)-1]
+			}
+			if algorithm == "" {
+				continue
+			}
+			if b, ok := decodeDigest(algorithm, encoded); ok {
+				digests = append(digests, Digest{Algorithm: algorithm, Value: b, Source: source})
+			}
+		}
+	}
+	return digests
+}
+
+// addDigests records digests not seen yet and reports whether any were new
+func (p *Progress) addDigests(found []Digest) bool {
+	added := false
+	for _, digest := range found {
+		known := false
+		for _, have := range p.Digests {
+			if have.Algorithm == digest.Algorithm && bytes.Equal(have.Value, digest.Value) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			p.Digests = append(p.Digests, digest)
+			added = true
+		}
+	}
+	return added
+}
+
+// readTrailers finishes a response whose body has been read up to its
+// expected length so its trailers arrive, and reports whether it has any
+func readTrailers(resp *http.Response) bool {
+	if len(resp.Trailer) == 0 {
+		return false
+	}
+	// The trailers are only filled in once the body reports EOF
+	n, _ := io.Copy(io.Discard, io.LimitReader(resp.Body, 1))
+	return n == 0
+}
+
+// verifyDigests checks the finished file against Pieces, if set, and every
+// digest the server sent and records the outcome in the progress. Files the
+// server sent no digest for are not checked.
+func (d *Downloader) verifyDigests() error {
+	if err := d.verifyPieces(); err != nil {
+		return err
+	}
+	if len(d.Progress.Digests) == 0 {
+		return nil
+	}
+	if checksum := d.Progress.Checksum; checksum != nil && checksum.Status == ChecksumVerified {
+		return nil
+	}
+
+	file, err := os.Open(LongPath(d.Progress.Filename))
+	if err != nil {
+		return fmt.Errorf("error opening file for checksum: %w", err)
+	}
+	defer file.Close()
+
+	var content io.Reader = file
+	if d.Progress.Encrypted {
+		reader, err := NewDecryptingReader(file, d.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("error decrypting file for checksum: %w", err)
+		}
+		content = reader
+	}
+
+	// Hash once per algorithm, however many headers named it
+	hashes := make(map[string]hash.Hash)
+	var writers []io.Writer
+	for _, digest := range d.Progress.Digests {
+		if _, ok := hashes[digest.Algorithm]; !ok {
+			h := newDigestHash(digest.Algorithm)
+			if h == nil {
+				continue
+			}
+			hashes[digest.Algorithm] = h
+			writers = append(writers, h)
+		}
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), content); err != nil {
+		return fmt.Errorf("error reading file for checksum: %w", err)
+	}
+
+	sums := make(map[string][]byte, len(hashes))
+	for algorithm, h := range hashes {
+		sums[algorithm] = h.Sum(nil)
+	}
+	return d.checkSums(sums)
+}
+
+// checkSums compares the file's sums by algorithm with the digests the
+// server sent and records the outcome in the progress. Digests of an
+// algorithm without a sum are not checked.
+func (d *Downloader) checkSums(sums map[string][]byte) error {
+	// Report the strongest digest checked, or the first that did not match
+	var result *Checksum
+	for _, a := range digestAlgorithms {
+		for _, digest := range d.Progress.Digests {
+			if _, ok := sums[digest.Algorithm]; !ok || digest.Algorithm != a.name {
+				continue
+			}
+			if !bytes.Equal(sums[digest.Algorithm], digest.Value) {
+				d.Progress.Checksum = &Checksum{Status: ChecksumMismatch, Algorithm: digest.Algorithm, Source: digest.Source}
+				return fmt.Errorf("%w: %s from %s is %x, file has %x", ErrChecksumMismatch, digest.Algorithm, digest.Source, digest.Value, sums[digest.Algorithm])
+			}
+			if result == nil {
+				result = &Checksum{Status: ChecksumVerified, Algorithm: digest.Algorithm, Source: digest.Source}
+			}
+		}
+	}
+	d.Progress.Checksum = result
+	if result != nil {
+		fmt.Printf("Checksum verified: %s from %s\n", result.Algorithm, result.Source)
+	}
+	return nil
+}
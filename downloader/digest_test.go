@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newDigestServer serves data with ranges and the given headers on every response
+func newDigestServer(t *testing.T, data []byte, headers map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadVerifiesServerDigests(t *testing.T) {
+	data := testPayload(256 * 1024)
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	sha512Sum := sha512.Sum512(data)
+	b64 := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		algorithm string
+		source    string
+	}{
+		{"content-md5", map[string]string{"Content-MD5": b64(md5Sum[:])}, "md5", "Content-MD5"},
+		{"rfc 3230", map[string]string{"Digest": "MD5=" + b64(md5Sum[:]) + ", SHA-256=" + b64(sha256Sum[:])}, "sha-256", "Digest"},
+		{"rfc 9530", map[string]string{"Repr-Digest": "sha-512=:" + b64(sha512Sum[:]) + ":, unknown=:AAAA:"}, "sha-512", "Repr-Digest"},
+	}
+	for _, tt := range tests {
+		server := newDigestServer(t, data, tt.headers)
+		dl := newTestDownloader(t, server.URL, 4)
+
+		if err := runDownload(t, dl); err != nil {
+			t.Fatalf("%s: download failed: %v", tt.name, err)
+		}
+		if err := dl.VerifyDownload(); err != nil {
+			t.Fatalf("%s: VerifyDownload() error = %v", tt.name, err)
+		}
+		want := Checksum{Status: ChecksumVerified, Algorithm: tt.algorithm, Source: tt.source}
+		if dl.Progress.Checksum == nil || *dl.Progress.Checksum != want {
+			t.Errorf("%s: Checksum = %+v, want %+v", tt.name, dl.Progress.Checksum, want)
+		}
+	}
+}
+
+func TestDownloadRejectsDigestMismatch(t *testing.T) {
+	data := testPayload(256 * 1024)
+	wrong := sha256.Sum256(append([]byte{1}, data...))
+	server := newDigestServer(t, data, map[string]string{"Digest": "SHA-256=" + base64.StdEncoding.EncodeToString(wrong[:])})
+	dl := newTestDownloader(t, server.URL, 4)
+	dl.ChecksumRetries = 1
+
+	if err := runDownload(t, dl); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("download error = %v, want ErrChecksumMismatch", err)
+	}
+	if dl.Progress.Checksum == nil || dl.Progress.Checksum.Status != ChecksumMismatch {
+		t.Errorf("Checksum = %+v, want a mismatch", dl.Progress.Checksum)
+	}
+	// The next attempt has to start over
+	if _, err := os.Stat(dl.ProgressFile); !os.IsNotExist(err) {
+		t.Errorf("progress file kept after a checksum mismatch: %v", err)
+	}
+}
+
+func TestDownloadChecksExpectedDigest(t *testing.T) {
+	data := testPayload(256 * 1024)
+	server := newDigestServer(t, data, nil)
+	sum := sha256.Sum256(data)
+	wrong := sha256.Sum256(data[1:])
+
+	for _, tt := range []struct {
+		name string
+		sum  []byte
+		size int64
+		want error
+	}{
+		{"match", sum[:], int64(len(data)), nil},
+		{"wrong digest", wrong[:], int64(len(data)), ErrChecksumMismatch},
+		{"wrong size", sum[:], int64(len(data)) + 1, ErrChecksumMismatch},
+	} {
+		expected, err := NewDigest("SHA-256", hex.EncodeToString(tt.sum), "LFS oid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dl := newTestDownloader(t, server.URL, 4)
+		dl.ChecksumRetries = 0
+		dl.ExpectedSize = tt.size
+		dl.ExpectedDigests = []Digest{expected}
+
+		err = runDownload(t, dl)
+		if err == nil {
+			err = dl.VerifyDownload()
+		}
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: download error = %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.want == nil && (dl.Progress.Checksum == nil || dl.Progress.Checksum.Source != "LFS oid") {
+			t.Errorf("%s: Checksum = %+v", tt.name, dl.Progress.Checksum)
+		}
+	}
+
+	if _, err := NewDigest("crc32", "00000000", ""); err == nil {
+		t.Error("NewDigest() accepted an unsupported algorithm")
+	}
+	if _, err := NewDigest("sha-256", "abcd", ""); err == nil {
+		t.Error("NewDigest() accepted a short digest")
+	}
+}
+
+func TestDownloadVerifiesTrailerDigest(t *testing.T) {
+	data := testPayload(128 * 1024)
+	sum := sha256.Sum256(data)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+		// The checksum is only known once the body has been streamed
+		w.Header().Set("Trailer", "Repr-Digest")
+		w.Write(data)
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	}))
+	t.Cleanup(server.Close)
+	dl := newTestDownloader(t, server.URL, 4)
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatalf("VerifyDownload() error = %v", err)
+	}
+	want := Checksum{Status: ChecksumVerified, Algorithm: "sha-256", Source: "Repr-Digest trailer"}
+	if dl.Progress.Checksum == nil || *dl.Progress.Checksum != want {
+		t.Errorf("Checksum = %+v, want %+v", dl.Progress.Checksum, want)
+	}
+}
+
+func TestDownloadWithoutDigests(t *testing.T) {
+	data := testPayload(10 * 1024)
+	server := newDigestServer(t, data, nil)
+	dl := newTestDownloader(t, server.URL, 2)
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatalf("VerifyDownload() error = %v", err)
+	}
+	if dl.Progress.Checksum != nil {
+		t.Errorf("Checksum = %+v without server digests", dl.Progress.Checksum)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match")
+	}
+}
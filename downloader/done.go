@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"multithreaded-downloader/secrets"
+)
+
+// DoneMarker describes a finished download. It is written next to the
+// output once the file is whole and checked, so pipelines watching the
+// directory can wait for it instead of guessing when the output stopped
+// growing.
+type DoneMarker struct {
+	// Filename is the output's name, in the marker's directory
+	Filename string `json:"filename"`
+	// URL is the file's URL with credentials redacted
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex SHA-256 of the output as it is on disk, encrypted
+	// when Encrypted is set
+	SHA256    string `json:"sha256"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	// Checksum is the check against the digests the server sent, if any
+	Checksum     *Checksum `json:"checksum,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+
+// DoneFile returns where the done marker of output is written
+func DoneFile(output string) string {
+	return output + ".done"
+}
+
+// LoadDoneMarker reads the done marker of output
+func LoadDoneMarker(output string) (DoneMarker, error) {
+	var m DoneMarker
+	data, err := os.ReadFile(LongPath(DoneFile(output)))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid done marker: %w", err)
+	}
+	return m, nil
+}
+
+// removeDoneMarker drops the marker of an earlier download before the
+// output is written again, so it never stands next to a partial file
+func (d *Downloader) removeDoneMarker() error {
+	if !d.WriteDoneMarker {
+		return nil
+	}
+	if err := os.Remove(LongPath(DoneFile(d.Filename))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove done marker: %w", err)
+	}
+	return nil
+}
+
+// writeDoneMarker writes the marker of the verified output through a
+// temporary file renamed into place, so a watcher seeing it can read it
+// whole
+func (d *Downloader) writeDoneMarker() error {
+	if !d.WriteDoneMarker {
+		return nil
+	}
+	sum, err := d.outputSHA256()
+	if err != nil {
+		return fmt.Errorf("failed to hash %s for its done marker: %w", d.Filename, err)
+	}
+	stat, err := os.Stat(LongPath(d.Filename))
+	if err != nil {
+		return err
+	}
+	marker := DoneMarker{
+		Filename:     filepath.Base(d.Filename),
+		URL:          secrets.RedactURL(d.Progress.URL),
+		Size:         stat.Size(),
+		SHA256:       sum,
+		Encrypted:    d.Progress.Encrypted,
+		Checksum:     d.Progress.Checksum,
+		ETag:         d.Progress.ETag,
+		LastModified: d.Progress.LastModified,
+		CompletedAt:  time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := DoneFile(d.Filename)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(LongPath(tmp), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write done marker: %w", err)
+	}
+	if err := os.Rename(LongPath(tmp), LongPath(path)); err != nil {
+		os.Remove(LongPath(tmp))
+		return fmt.Errorf("failed to write done marker: %w", err)
+	}
+	return nil
+}
+
+// outputSHA256 returns the hex SHA-256 of the output, reusing the digest
+// the server sent when the file was just checked against it
+func (d *Downloader) outputSHA256() (string, error) {
+	if checksum := d.Progress.Checksum; checksum != nil && checksum.Status == ChecksumVerified && !d.Progress.Encrypted {
+		for _, digest := range d.Progress.Digests {
+			if digest.Algorithm == "sha-256" && len(digest.Value) == sha256.Size {
+				return hex.EncodeToString(digest.Value), nil
+			}
+		}
+	}
+	file, err := os.Open(LongPath(d.Filename))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+func TestDoneMarkerWrittenAfterVerify(t *testing.T) {
+	data := testPayload(200 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 3)
+	dl.WriteDoneMarker = true
+
+	// A marker of an earlier download goes as soon as the output is
+	// written again
+	if err := os.WriteFile(DoneFile(dl.Filename), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if _, err := os.Stat(DoneFile(dl.Filename)); !os.IsNotExist(err) {
+		t.Fatal("the old done marker is still there before the download is verified")
+	}
+
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	marker, err := LoadDoneMarker(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if marker.Filename != "out.bin" || marker.Size != int64(len(data)) || marker.SHA256 != hex.EncodeToString(sum[:]) || marker.ETag != `"v1"` || marker.CompletedAt.IsZero() {
+		t.Errorf("done marker = %+v", marker)
+	}
+	if _, err := os.Stat(DoneFile(dl.Filename) + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary marker was left behind")
+	}
+}
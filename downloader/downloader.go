@@ -2,133 +2,334 @@ package downloader
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"multithreaded-downloader/secrets"
 )
 
+// RequestMutator adjusts the request for a single part before it is sent,
+// e.g. to add a per-range token or signature. The Range header is already set.
+type RequestMutator func(req *http.Request, part Part) error
+
 // Downloader handles the multithreaded download process
 type Downloader struct {
 	URL         string
 	Filename    string
 	NumThreads  int
+	// MinPartSize is the smallest part a file is split into; small files
+	// use fewer threads than asked for. Zero or less splits into NumThreads
+	// parts whatever the size.
+	MinPartSize int64
 	ProgressFile string
 	Progress    *Progress
+	// UserAgent and Referer are sent with every request; an empty
+	// UserAgent falls back to DefaultUserAgent
+	UserAgent   string
+	Referer     string
+	// UserAgents, when set, are sent in turn, one per request, in place
+	// of UserAgent
+	UserAgents  []string
+	// Jitter, when set, delays every request by a random time up to it
+	Jitter      time.Duration
+	// Headers are extra request headers, e.g. Authorization, sent with every request
+	Headers     map[string]string
+	// WrapTransport, when set, wraps the transport of every request, for
+	// authorization a header cannot carry, such as registry tokens that
+	// are exchanged again when they expire during the download
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+	// CookieJar, when set, supplies cookies for the download URL's domain
+	CookieJar   http.CookieJar
+	// SharedLimiter, when set, is a rate limit shared with other downloads,
+	// such as a server-wide cap
+	SharedLimiter *RateLimiter
+	// Bind, when set, is the network interface or local IP address every
+	// connection is made from, for hosts with several uplinks;
+	// BindRateLimit caps the bytes per second of all the downloads bound
+	// to it in this process together. See bind.go.
+	Bind          string
+	BindRateLimit int64
+	// PartRequestMutator, when set, is called for every range request
+	PartRequestMutator RequestMutator
+	// DisableHTTP2 forces HTTP/1.1 with one connection per part
+	DisableHTTP2 bool
+	// EncryptionKey, when set, encrypts the output file with AES-256-GCM as it is written
+	EncryptionKey []byte
+	// Resources caps the threads, memory and disk flushes of the download
+	Resources Resources
+	// ChecksumRetries is how often the corrupt parts of a file that fails
+	// the server's checksum are downloaded again before giving up
+	ChecksumRetries int
+	// Retry is how parts retry failed requests; the zero policy retries
+	// every second until the download is stopped
+	Retry RetryPolicy
+	// PartURLs, when set, are the pieces a file is published as, e.g.
+	// file.z01 and file.z02; they are fetched in parallel into one file.
+	// URL is then the first of them. See NewJoinedDownloader.
+	PartURLs []string
+	// RefreshLink, when set, is asked for a new link when the server
+	// refuses the current one as expired; the download continues with it
+	RefreshLink LinkRefresher
+	// Cache, when set, places files downloaded before from it and keeps
+	// verified downloads in it
+	Cache Cache
+	// OnlyIfModified downloads the file only when it changed since the
+	// existing output was downloaded; see CheckModified
+	OnlyIfModified bool
+	// Plan, when set, is the split into parts a new download uses instead
+	// of its own, with the servers the parts are pinned to; a resume keeps
+	// the parts it saved. See plan.go.
+	Plan *Plan
+	// WriteDoneMarker writes a DoneMarker to DoneFile(Filename) once the
+	// download is verified, and removes an old one when it starts
+	WriteDoneMarker bool
+	// CaptureEnvironment records the request, settings, resolver answers
+	// and server response of the download for Environment. See
+	// environment.go.
+	CaptureEnvironment bool
+	// Probes, when set, keeps range checks so that a URL downloaded again
+	// while its entry is fresh is not probed again
+	Probes ProbeCache
+	// Continue takes an output file that exists without saved progress as
+	// the start of the download and only fetches the rest, like wget -c
+	Continue bool
+	// Sequential downloads the file mostly in order: it is split into
+	// small parts that are fetched lowest first, NumThreads at a time, so
+	// a video can be played while it downloads
+	Sequential bool
+	// PreviewBytes, when set, fetches the first and last PreviewBytes of
+	// the file before the rest: enough for a zip's central directory or an
+	// MP4's moov atom, so the archive can be listed or the media probed
+	// early with ServeDownloaded
+	PreviewBytes int64
+	// Method and Body, when set, replace the plain GET for endpoints that
+	// only hand out the file to e.g. a POST; ContentType describes Body.
+	// Such downloads are probed with the request itself and fetched as a
+	// single stream.
+	Method      string
+	Body        []byte
+	ContentType string
+	// Mirrors are other URLs serving the same file as URL; more are found
+	// in the Link headers of its probe. Each is measured with a small range
+	// request, and parts are spread over the fastest MirrorCount of them
+	// and URL, measured again every MirrorReprobe. See mirrors.go.
+	Mirrors       []string
+	MirrorCount   int
+	MirrorReprobe time.Duration
+	// Pieces, when set, are the piece hashes of a torrent describing the
+	// file; the finished file is checked against them. See pieces.go.
+	Pieces *PieceHashes
+	// ExpectedSize and ExpectedDigests, when set, are the size and
+	// checksums a listing such as a Git LFS batch response gave for the
+	// file; a server sending another size is refused and the finished
+	// file is checked against the digests with those the server sent
+	ExpectedSize    int64
+	ExpectedDigests []Digest
+	// WriteFault, when set, is called before every write to the output
+	// file, which fails with the error it returns; chaos tests use it to
+	// simulate a full disk
+	WriteFault func(n int) error
+
+	client  *http.Client
+	etag    string
+	// lastModified is the Last-Modified header the range check found
+	lastModified string
+	// replace is set when CheckModified found a newer version of an
+	// existing output, which is removed once the transfer starts
+	replace bool
+	// digests are the checksums the range check found
+	digests []Digest
+	// pending is the probe response of a custom request, kept for its body
+	pending   *http.Response
+	pendingMu sync.Mutex
+	cipher  *fileCipher
+	resumed bool
+	// cached is set when the file was placed from Cache
+	cached  bool
+	// small is set for files fetched in a single request
+	small   bool
+	// limiter and threads throttle a running download; both can be
+	// adjusted while it runs
+	limiter *RateLimiter
+	threads *Gate
+	// conns tracks the connection of every part for PartConnections
+	conns   *connTracker
+	// agentTurn counts the requests that took one of UserAgents
+	agentTurn uint32
+	// link is the refreshed link requests go to instead of URL, which
+	// stays the download's identity in its progress
+	link      string
+	linkMu    sync.Mutex
+	refreshMu sync.Mutex
+	// discovered are the mirrors the probe's Link headers announced
+	discovered []string
+	// mirrors spreads the parts of a running download over its mirrors;
+	// nil when it only uses URL
+	mirrors   *mirrorSet
+	mirrorsMu sync.Mutex
+	// badPieces are the Pieces the last check found corrupt
+	badPieces      []int
+	piecesVerified bool
+	// verified are the digests the bytes of each part were last found to
+	// match by VerifiedFrom
+	verified   map[int]*Digest
+	verifiedMu sync.Mutex
+	// bindShared is the limiter of Bind, looked up once
+	bindShared *RateLimiter
+	bindOnce   sync.Once
+	// held is the lock on the output while LoadOrCreateProgress,
+	// DownloadContext or VerifyDownload runs; holds counts the nested calls
+	held   *os.File
+	holds  int
+	holdMu sync.Mutex
+	// env is what CaptureEnvironment learned so far
+	env     *environmentState
+	envOnce sync.Once
 }
 
+// DefaultMinPartSize is the smallest part of a new downloader
+const DefaultMinPartSize = 1024 * 1024
+
+// DefaultProgressFile is where a downloader saves its progress unless told
+// otherwise
+const DefaultProgressFile = "download_state.json"
+
 // NewDownloader creates a new downloader instance
 func NewDownloader(url, filename string, numThreads int) *Downloader {
 	return &Downloader{
-		URL:          url,
-		Filename:     filename,
-		NumThreads:   numThreads,
-		ProgressFile: "download_state.json",
+		URL:             NormalizeURL(url),
+		Filename:        filename,
+		NumThreads:      numThreads,
+		MinPartSize:     DefaultMinPartSize,
+		ChecksumRetries: DefaultChecksumRetries,
+		ProgressFile:    DefaultProgressFile,
+		UserAgent:       DefaultUserAgent,
+		limiter:         NewRateLimiter(0),
+		threads:         NewGate(0),
+		conns:           newConnTracker(),
 	}
 }
 
-// SupportsRange checks if the server supports HTTP range requests
-func (d *Downloader) SupportsRange() (bool, int64, error) {
-	fmt.Printf("Checking if server supports range requests for: %s\n", d.URL)
-	
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
+// newRequest builds a request for the download URL with the configured headers
+func (d *Downloader) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if method == http.MethodGet {
+		// Fetching the file may take another method and a body
+		req, err = d.newFileRequest(ctx)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, d.requestURL(), nil)
+	}
+	if err != nil {
+		return nil, err
 	}
+	d.applyHeaders(req)
+	return req, nil
+}
 
-	var supportsRanges bool
-	var length int64
+// applyHeaders sets the per-download identification headers on a request
+func (d *Downloader) applyHeaders(req *http.Request) {
+	d.applyIdentity(req)
 
-	// First try HEAD request
-	resp, err := client.Head(d.URL)
-	if err != nil {
-		fmt.Printf("HEAD request failed (%v), trying GET request...\n", err)
-		
-		// Fallback: Try a small range GET request to test range support
-		req, err := http.NewRequest("GET", d.URL, nil)
-		if err != nil {
-			return false, 0, fmt.Errorf("failed to create GET request: %w", err)
-		}
-		req.Header.Set("Range", "bytes=0-1023") // Request first 1KB
-		req.Header.Set("User-Agent", "Go-Downloader/1.0")
-		
-		resp, err = client.Do(req)
-		if err != nil {
-			return false, 0, fmt.Errorf("failed to make GET request: %w", err)
-		}
-		defer resp.Body.Close()
-
-		// Check if we got partial content (range support)
-		if resp.StatusCode == http.StatusPartialContent {
-			supportsRanges = true
-			// Parse Content-Range to get total size
-			contentRange := resp.Header.Get("Content-Range")
-			if contentRange != "" {
-				fmt.Printf("Content-Range: %s\n", contentRange)
-				var start, end, total int64
-				if n, _ := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); n == 3 {
-					length = total
-				}
-			}
-		} else if resp.StatusCode == http.StatusOK {
-			// Server doesn't support ranges, but we can still download
-			supportsRanges = false
-			length = resp.ContentLength
-		} else {
-			return false, 0, fmt.Errorf("server returned status: %s", resp.Status)
-		}
+	for name, value := range d.Headers {
+		req.Header.Set(name, value)
+	}
 
-		// If we still don't have the length, make a full HEAD/GET request
-		if length <= 0 {
-			fmt.Println("Getting file size with full request...")
-			fullResp, err := client.Get(d.URL)
-			if err != nil {
-				return false, 0, fmt.Errorf("failed to get file size: %w", err)
-			}
-			defer fullResp.Body.Close()
-			
-			if fullResp.StatusCode == http.StatusOK {
-				length = fullResp.ContentLength
-			}
-		}
-	} else {
-		// HEAD request succeeded
-		defer resp.Body.Close()
-		
-		if resp.StatusCode != http.StatusOK {
-			return false, 0, fmt.Errorf("server returned status: %s", resp.Status)
-		}
+	d.applyCookies(req)
+}
 
-		length = resp.ContentLength
-		supportsRanges = resp.Header.Get("Accept-Ranges") == "bytes"
+// applyIdentity sets the User-Agent and Referer of the download
+func (d *Downloader) applyIdentity(req *http.Request) {
+	userAgent := d.UserAgent
+	if len(d.UserAgents) > 0 {
+		turn := atomic.AddUint32(&d.agentTurn, 1) - 1
+		userAgent = d.UserAgents[turn%uint32(len(d.UserAgents))]
 	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	if d.Referer != "" {
+		req.Header.Set("Referer", d.Referer)
+	}
+}
 
-	if length <= 0 {
-		return false, 0, fmt.Errorf("server did not provide content length")
+// applyCookies adds the cookies the jar holds for the request's URL
+func (d *Downloader) applyCookies(req *http.Request) {
+	if d.CookieJar != nil {
+		for _, cookie := range d.CookieJar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
 	}
+}
 
-	fmt.Printf("Server supports range requests: %v\n", supportsRanges)
-	fmt.Printf("File size: %d bytes (%.2f MB)\n", length, float64(length)/(1024*1024))
+// PrepareRequest sets the headers and cookies of the download on a request
+// made outside it, such as for a file's zsync control file
+func (d *Downloader) PrepareRequest(req *http.Request) {
+	d.applyHeaders(req)
+}
 
-	return supportsRanges, length, nil
+// SupportsRange checks if the server supports HTTP range requests and
+// returns the file's size. A fresh probe in Probes is used instead of asking
+// the server again.
+func (d *Downloader) SupportsRange() (bool, int64, error) {
+	if probe, ok := d.cachedProbe(); ok {
+		return probe.SupportsRanges, probe.Size, nil
+	}
+	probe, err := d.Probe(context.Background())
+	if err != nil {
+		return false, 0, err
+	}
+	return probe.SupportsRanges, probe.Size, nil
 }
 
 // LoadOrCreateProgress loads existing progress or creates new one
 func (d *Downloader) LoadOrCreateProgress() error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.release()
+
 	// Try to load existing progress
 	if existingProgress, err := LoadProgress(d.ProgressFile); err == nil {
-		if existingProgress.URL == d.URL && existingProgress.Filename == d.Filename {
+		if existingProgress.URL == d.URL && existingProgress.Filename == d.Filename &&
+			!existingProgress.sameSources(d.PartURLs) {
+			fmt.Println("Previous download joined different pieces. Starting new download...")
+		} else if existingProgress.URL == d.URL && existingProgress.Filename == d.Filename &&
+			existingProgress.Encrypted != (d.EncryptionKey != nil) {
+			fmt.Println("Previous download used different encryption settings. Starting new download...")
+		} else if existingProgress.URL == d.URL && existingProgress.Filename == d.Filename {
 			fmt.Println("Found existing download progress. Resuming...")
+			for _, repair := range existingProgress.Repairs() {
+				fmt.Printf("Repaired progress file: %s\n", repair)
+			}
 			d.Progress = existingProgress
+			d.Progress.Mirrors = d.mirrorURLs()
+			d.Progress.addDigests(d.ExpectedDigests)
+			if existingProgress.NumThreads > 0 {
+				d.NumThreads = existingProgress.NumThreads
+			}
+			d.resumed = true
 			return nil
 		} else {
 			fmt.Println("Previous download was for different URL/file. Starting new download...")
 		}
+	} else if errors.Is(err, ErrInvalidProgress) {
+		// Resuming from a corrupt file could silently corrupt the output
+		fmt.Printf("Refusing to resume: %v. Starting new download...\n", err)
+	}
+
+	if d.joined() {
+		return d.createJoinedProgress()
 	}
 
 	// Create new progress
@@ -136,20 +337,79 @@ func (d *Downloader) LoadOrCreateProgress() error {
 	if err != nil {
 		return fmt.Errorf("error checking server capabilities: %w", err)
 	}
+	if d.Pieces != nil && totalSize != d.Pieces.Size {
+		return fmt.Errorf("server has a %d byte file, the %s describes %d bytes", totalSize, d.Pieces.Source, d.Pieces.Size)
+	}
+	if d.ExpectedSize > 0 && totalSize != d.ExpectedSize {
+		return fmt.Errorf("%w: server has a %d byte file, %d bytes were expected", ErrChecksumMismatch, totalSize, d.ExpectedSize)
+	}
 
-	if !supportsRanges {
+	requested := d.NumThreads
+	small := totalSize <= SmallFileSize
+	if small {
+		fmt.Println("Small file, downloading in a single request...")
+		d.NumThreads = 1
+	} else if !supportsRanges {
 		fmt.Println("Server does not support range requests. Falling back to single-threaded download...")
 		d.NumThreads = 1
+	} else if threads := EffectiveThreads(totalSize, d.NumThreads, d.MinPartSize); threads != d.NumThreads {
+		fmt.Printf("Using %d threads instead of %d so every part is at least %d bytes\n", threads, d.NumThreads, d.MinPartSize)
+		d.NumThreads = threads
 	}
 
 	d.Progress = CreateNewProgress(d.URL, d.Filename, totalSize, d.NumThreads)
+	d.Progress.ETag = d.etag
+	d.Progress.LastModified = d.lastModified
+	d.Progress.Digests = d.digests
+	d.Progress.addDigests(d.ExpectedDigests)
+	d.Progress.SingleStream = !supportsRanges && !small
+	d.Progress.Mirrors = d.mirrorURLs()
+	if d.Sequential && supportsRanges && !small {
+		d.Progress.splitSequential(SequentialPartSize)
+	}
+	if d.EncryptionKey != nil {
+		// Each part must seal whole chunks, so parts start on chunk
+		// boundaries; the single part of a small file starts at zero.
+		// Sequential parts already do.
+		if !small && !d.Progress.Sequential {
+			d.Progress.AlignParts(EncryptedChunkSize)
+		}
+		d.Progress.Encrypted = true
+		d.NumThreads = d.Progress.NumThreads
+	}
+	// After aligning, which would undo the cuts
+	if d.PreviewBytes > 0 && supportsRanges && !small {
+		d.Progress.splitPreview(d.PreviewBytes)
+	}
+	if d.Plan != nil {
+		if err := d.applyPlan(supportsRanges); err != nil {
+			return err
+		}
+		// A small file split by the plan is fetched in its parts
+		small = small && len(d.Progress.Parts) == 1
+	}
+	if d.NumThreads != requested {
+		d.Progress.RequestedThreads = requested
+	}
+	// Small files are fetched whole in any case
+	if d.Continue && !small {
+		if err := d.continueExisting(); err != nil {
+			return err
+		}
+	}
+	d.resumed = false
+	d.small = small
+	if small {
+		// Nothing worth resuming, so no progress file
+		return nil
+	}
 	return SaveProgress(d.ProgressFile, d.Progress)
 }
 
 // PrintProgress displays the current download progress
 func (d *Downloader) PrintProgress() {
 	fmt.Print("\033[H\033[2J") // Clear screen
-	fmt.Printf("Downloading: %s\n", d.Progress.URL)
+	fmt.Printf("Downloading: %s\n", secrets.RedactURL(d.Progress.URL))
 	fmt.Printf("Output file: %s\n", d.Progress.Filename)
 	fmt.Printf("Total size: %.2f MB\n\n", float64(d.Progress.TotalSize)/(1024*1024))
 
@@ -162,9 +422,22 @@ func (d *Downloader) PrintProgress() {
 		float64(d.Progress.TotalSize)/(1024*1024))
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
-	for _, part := range d.Progress.Parts {
-		partSize := part.End - part.Start + 1
-		percent := float64(part.Downloaded) / float64(partSize) * 100
+	if until := d.ThrottledUntil(); !until.IsZero() {
+		fmt.Printf("Server requested a back-off, resuming in %s\n", time.Until(until).Round(time.Second))
+	}
+
+	if d.Progress.Sequential {
+		fmt.Printf("Playable: first %.2f MB\n", float64(d.Progress.ContiguousBytes())/(1024*1024))
+	}
+
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		// A sequential download has too many parts to list; show the
+		// ones in flight
+		if d.Progress.Sequential && (part.Done() || part.Downloaded() == 0) {
+			continue
+		}
+		percent := float64(part.Downloaded()) / float64(part.Size()) * 100
 		
 		barLength := 40
 		filled := int(percent * float64(barLength) / 100)
@@ -179,7 +452,7 @@ func (d *Downloader) PrintProgress() {
 		}
 
 		status := "Downloading"
-		if part.Done {
+		if part.Done() {
 			status = "Complete"
 		}
 
@@ -189,14 +462,76 @@ func (d *Downloader) PrintProgress() {
 }
 
 // downloadPart downloads a specific part of the file
-func (d *Downloader) downloadPart(ctx context.Context, part *Part, progressMutex *sync.Mutex, wg *sync.WaitGroup) {
+// Errors that retrying cannot fix are reported through fail, which stops the
+// whole download.
+func (d *Downloader) downloadPart(ctx context.Context, part *Part, progressMutex *sync.Mutex, wg *sync.WaitGroup, fail func(error)) {
 	defer wg.Done()
 
-	if part.Done {
+	if part.Done() {
+		return
+	}
+
+	// Only as many parts as the thread limit and the shared connections
+	// allow transfer at once
+	held := d.acquireSlot(ctx)
+	if !held {
 		return
 	}
+	defer func() {
+		if held {
+			d.releaseSlot()
+		}
+	}()
+
+	client := d.client
+	if client == nil {
+		client = d.newPartClient()
+	}
+
+	// Every attempt gets its own context, so RestartPart can drop a stuck
+	// connection without stopping the part
+	conns := d.conns
+	if conns == nil {
+		conns = newConnTracker()
+	}
+	cancelAttempt := context.CancelFunc(func() {})
+	defer func() {
+		cancelAttempt()
+		conns.end(part.Index, part.Done())
+	}()
+
+	// retry counts a failed attempt and waits before the next one, failing
+	// the download once the retry policy is used up
+	failures := 0
+	retry := func(err error) bool {
+		failures++
+		if d.Retry.exhausted(failures) {
+			fail(fmt.Errorf("%w: part %d failed %d times: %v", ErrRetriesExhausted, part.Index, failures, err))
+			return false
+		}
+		return d.Retry.wait(ctx)
+	}
+
+	// A part of a joined file is fetched from the piece holding it
+	source := d.Progress.sourceOf(part)
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	// Other parts come from the fastest mirrors; via is the one the
+	// current attempt uses, told how it went when the attempt ends
+	var mirrors *mirrorSet
+	if source == nil {
+		mirrors = d.mirrorSet()
+	}
+	var via *mirror
+	var viaBytes int64
+	var viaErr error
+	var viaMismatch bool
+	releaseMirror := func() {
+		if via != nil {
+			mirrors.done(via, part.Index, viaBytes, viaErr, viaMismatch)
+			via, viaBytes, viaErr, viaMismatch = nil, 0, nil, false
+		}
+	}
+	defer releaseMirror()
 	
 	for {
 		select {
@@ -205,92 +540,303 @@ func (d *Downloader) downloadPart(ctx context.Context, part *Part, progressMutex
 		default:
 		}
 
+		// Wait for a slot again after retiring for a lower thread limit
+		if !held {
+			if held = d.acquireSlot(ctx); !held {
+				return
+			}
+		}
+
 		// Calculate current position
-		currentStart := part.Start + part.Downloaded
+		currentStart := part.Start + part.Downloaded()
 		if currentStart > part.End {
-			part.Done = true
+			part.SetDone(true)
 			return
 		}
 
-		// Create request with range header
-		req, err := http.NewRequestWithContext(ctx, "GET", d.URL, nil)
-		if err != nil {
-			fmt.Printf("Error creating request for part %d: %v\n", part.Index, err)
-			time.Sleep(time.Second)
-			continue
+		cancelAttempt()
+		var attemptCtx context.Context
+		attemptCtx, cancelAttempt = context.WithCancel(ctx)
+		conn := conns.begin(part.Index, cancelAttempt)
+		link := d.requestURL()
+		releaseMirror()
+		if mirrors != nil {
+			via = mirrors.pick(part.Index, d.Progress.pinnedSource(part.Index), cancelAttempt)
 		}
 
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", currentStart, part.End))
-		req.Header.Set("User-Agent", "Go-Downloader/1.0")
+		// The probe of a custom request already holds the start of the file
+		resp := d.takePending(currentStart)
+		if resp != nil {
+			// Its body is not bound to the attempt, so close it on a restart
+			go func(resp *http.Response) {
+				<-attemptCtx.Done()
+				resp.Body.Close()
+			}(resp)
+		} else {
+			// Create request with range header
+			req, err := d.newRequest(conn.trace(attemptCtx), "GET")
+			if err != nil {
+				conn.failed(err)
+				fmt.Printf("Error creating request for part %d: %v\n", part.Index, err)
+				if !retry(err) {
+					return
+				}
+				continue
+			}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error downloading part %d: %v\n", part.Index, err)
-			time.Sleep(time.Second)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", currentStart, part.End))
+			req.Header.Set("Priority", partPriority(part.Index, len(d.Progress.Parts)))
+			// Ask for a checksum of the range so a corrupt part can be found
+			req.Header.Set("Want-Content-Digest", "sha-256=10, sha-512=5")
+			if d.Progress.ETag != "" {
+				// Ask for the whole new file instead of a range if it has changed
+				req.Header.Set("If-Range", d.Progress.ETag)
+			}
+			if source != nil {
+				if err := prepareSourceRequest(req, source, currentStart, part.End); err != nil {
+					fail(fmt.Errorf("piece of part %d: %w", part.Index, err))
+					return
+				}
+			}
+			if via != nil {
+				if req, err = d.mirrorRequest(req, via); err != nil {
+					fail(fmt.Errorf("mirror of part %d: %w", part.Index, err))
+					return
+				}
+			}
+
+			// Let integrators sign or otherwise adjust each range request
+			if d.PartRequestMutator != nil {
+				if err := d.PartRequestMutator(req, part.Snapshot()); err != nil {
+					conn.failed(err)
+					fmt.Printf("Error preparing request for part %d: %v\n", part.Index, err)
+					if !retry(err) {
+						return
+					}
+					continue
+				}
+			}
+
+			// Hold off while the server has asked this host to back off
+			if !waitForHost(ctx, req.URL.Host) {
+				return
+			}
+
+			resp, err = client.Do(req)
+			if err != nil {
+				viaErr = err
+				conn.failed(err)
+				fmt.Printf("Error downloading part %d: %v\n", part.Index, err)
+				if !retry(err) {
+					return
+				}
+				continue
+			}
+		}
+
+		// Throttled attempts count against the retry policy like failed
+		// ones, so a server that always throttles runs the part out of them
+		if delay, ok := throttleFromResponse(resp.Request.URL.Host, resp); ok {
+			resp.Body.Close()
+			err := fmt.Errorf("throttled by server: %s, retry after %s", resp.Status, delay)
+			conn.failed(err)
+			if !retry(err) {
+				return
+			}
 			continue
 		}
 
-		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		// Pre-signed links expire; get a new one and continue the same progress
+		if linkExpired(resp) && d.RefreshLink != nil && source == nil && (via == nil || via.Primary) {
 			resp.Body.Close()
-			fmt.Printf("Unexpected status for part %d: %s\n", part.Index, resp.Status)
-			time.Sleep(time.Second)
+			err := statusError(resp)
+			conn.failed(err)
+			if refreshErr := d.refreshLink(ctx, link); refreshErr != nil {
+				fail(refreshErr)
+				return
+			}
+			if !retry(err) {
+				return
+			}
 			continue
 		}
 
-		// Open file for writing
-		file, err := os.OpenFile(d.Filename, os.O_WRONLY|os.O_CREATE, 0644)
+		unchanged := d.checkRemoteUnchanged(resp)
+		if source != nil {
+			unchanged = checkSourceUnchanged(source, resp)
+		} else if via != nil && !via.Primary {
+			// A mirror has validators of its own; its size is checked below
+			unchanged = nil
+		}
+		if unchanged != nil {
+			resp.Body.Close()
+			fail(unchanged)
+			return
+		}
+
+		// Servers that do not advertise ranges may still honour them; if
+		// this one ignored the range, start over with the whole file it sent
+		if (d.Progress.SingleStream || (source != nil && !source.Ranges)) && currentStart > part.Start {
+			if resp.StatusCode == http.StatusOK {
+				fmt.Printf("Server ignored the range request, restarting part %d from the beginning\n", part.Index)
+				part.Reset()
+				currentStart = part.Start
+			} else if resp.StatusCode == http.StatusPartialContent {
+				fmt.Printf("Resuming part %d from byte %d\n", part.Index, currentStart)
+			}
+		}
+
+		// Make sure the server sent the range we asked for before writing anything
+		var expected int64
+		var err error
+		if source != nil {
+			expected, err = validateRangeResponse(resp, currentStart-source.Start, part.End-source.Start, source.Size)
+		} else {
+			expected, err = d.validatePartResponse(resp, currentStart, part.End)
+		}
 		if err != nil {
 			resp.Body.Close()
-			fmt.Printf("Error opening file for part %d: %v\n", part.Index, err)
-			time.Sleep(time.Second)
+			viaErr, viaMismatch = err, true
+			conn.failed(err)
+			fmt.Printf("Invalid response for part %d: %v\n", part.Index, err)
+			if !retry(err) {
+				return
+			}
 			continue
 		}
 
-		// Seek to correct position
-		_, err = file.Seek(currentStart, 0)
+		// Any response may announce a checksum of the whole file, but
+		// that of a piece only covers the piece
+		if found := responseDigests(resp, false); len(found) > 0 && source == nil {
+			progressMutex.Lock()
+			d.Progress.addDigests(found)
+			progressMutex.Unlock()
+		}
+
+		// A response carrying the whole part can vouch for it on its own:
+		// with the server's checksum of the range, or else with one taken
+		// as the bytes arrive
+		var partSum hash.Hash
+		serverDigest := rangeDigest(resp, false)
+		if currentStart != part.Start {
+			serverDigest = nil
+		} else if serverDigest == nil {
+			partSum = sha256.New()
+		}
+
+		// Open the output file at the part's current position
+		writer, err := d.openPartWriter(currentStart)
 		if err != nil {
-			file.Close()
 			resp.Body.Close()
-			fmt.Printf("Error seeking in file for part %d: %v\n", part.Index, err)
-			time.Sleep(time.Second)
+			conn.failed(err)
+			fmt.Printf("Error opening file for part %d: %v\n", part.Index, err)
+			if !retry(err) {
+				return
+			}
 			continue
 		}
 
-		// Download with progress tracking
-		buffer := make([]byte, 32*1024) // 32KB buffer
+		// Download with progress tracking, never reading past the validated range
+		conn.setState(PartTransferring)
+		body := io.LimitReader(resp.Body, expected)
+		var received, unsynced int64
+		buffer := make([]byte, d.bufferSize())
 		for {
 			select {
 			case <-ctx.Done():
-				file.Close()
+				writer.Close()
 				resp.Body.Close()
 				return
 			default:
 			}
 
-			n, err := resp.Body.Read(buffer)
+			n, err := body.Read(buffer)
 			if n > 0 {
-				written, writeErr := file.Write(buffer[:n])
+				received += int64(n)
+				conn.received(n)
+				if partSum != nil {
+					partSum.Write(buffer[:n])
+				}
+				committed, writeErr := writer.write(buffer[:n])
+				part.AddDownloaded(committed)
 				if writeErr != nil {
 					fmt.Printf("Error writing to file for part %d: %v\n", part.Index, writeErr)
+					// Retrying cannot free space on the disk
+					if IsDiskFull(writeErr) {
+						writer.Close()
+						resp.Body.Close()
+						fail(fmt.Errorf("%w: part %d: %v", ErrDiskFull, part.Index, writeErr))
+						return
+					}
 					break
 				}
-				atomic.AddInt64(&part.Downloaded, int64(written))
+				if unsynced += committed; d.Resources.SyncEvery > 0 && unsynced >= d.Resources.SyncEvery {
+					if err := d.syncPart(ctx, writer); err != nil && ctx.Err() == nil {
+						fmt.Printf("Error flushing file for part %d: %v\n", part.Index, err)
+					}
+					unsynced = 0
+				}
+				if d.waitRate(ctx, n) != nil {
+					writer.Close()
+					resp.Body.Close()
+					return
+				}
+			}
+
+			// Stop here if the thread limit was lowered; the part resumes
+			// from what was written once a slot is free again
+			if err == nil && d.retireSlot() {
+				held = false
+				break
 			}
 
 			if err != nil {
-				if err == io.EOF {
-					// Download completed successfully
-					part.Done = true
+				if err == io.EOF && received < expected {
+					// The body ended early; the next attempt resumes from what was written
+					fmt.Printf("Short response for part %d: got %d of %d bytes\n", part.Index, received, expected)
+					conn.failed(fmt.Errorf("short response: got %d of %d bytes", received, expected))
+				} else if err != io.EOF && ctx.Err() == nil {
+					conn.failed(err)
 				}
 				break
 			}
 		}
 
-		file.Close()
+		viaBytes = received
+		if received < expected && ctx.Err() == nil {
+			viaErr = fmt.Errorf("got %d of %d bytes", received, expected)
+		}
+		if unsynced > 0 && d.Resources.syncing() {
+			if err := d.syncPart(ctx, writer); err != nil && ctx.Err() == nil {
+				fmt.Printf("Error flushing file for part %d: %v\n", part.Index, err)
+			}
+		}
+		// Checksums computed while streaming follow the body as trailers
+		if received == expected && readTrailers(resp) {
+			if found := responseDigests(resp, true); len(found) > 0 && source == nil {
+				progressMutex.Lock()
+				d.Progress.addDigests(found)
+				progressMutex.Unlock()
+			}
+			if digest := rangeDigest(resp, true); digest != nil && currentStart == part.Start {
+				serverDigest, partSum = digest, nil
+			}
+		}
+		writer.Close()
 		resp.Body.Close()
+		if received > 0 {
+			failures = 0
+		}
 
-		if part.Done || part.Downloaded >= (part.End-part.Start+1) {
-			part.Done = true
+		if part.Downloaded() >= part.Size() {
+			if received == expected && currentStart == part.Start {
+				if serverDigest != nil {
+					part.SetDigest(serverDigest)
+				} else if partSum != nil {
+					part.SetDigest(&Digest{Algorithm: "sha-256", Value: partSum.Sum(nil), Source: ReceivedDigest})
+				}
+			}
+			part.SetDone(true)
 			break
 		}
 	}
@@ -298,13 +844,80 @@ func (d *Downloader) downloadPart(ctx context.Context, part *Part, progressMutex
 
 // Download starts the multithreaded download process
 func (d *Downloader) Download() error {
+	return d.DownloadContext(context.Background())
+}
+
+// DownloadContext is like Download but stops when ctx is cancelled, leaving
+// the saved progress in place for a later resume
+func (d *Downloader) DownloadContext(parent context.Context) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.release()
+	if err := d.removeDoneMarker(); err != nil {
+		return err
+	}
+	if d.fetchCached() {
+		return nil
+	}
+	for retry := 1; ; retry++ {
+		if err := d.downloadParts(parent); err != nil {
+			return err
+		}
+
+		// Check the file against the checksums the server sent and fetch
+		// only the corrupt parts again if it fails
+		err := d.verifyDigests()
+		if !errors.Is(err, ErrChecksumMismatch) {
+			return err
+		}
+		if retry > d.ChecksumRetries {
+			os.Remove(LongPath(d.ProgressFile))
+			return err
+		}
+		if err := d.resetCorruptParts(err, retry); err != nil {
+			os.Remove(LongPath(d.ProgressFile))
+			return err
+		}
+	}
+}
+
+// downloadParts downloads every part that is not done yet
+func (d *Downloader) downloadParts(parent context.Context) error {
 	// Create context for cancellation
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
+	// Drop the probe response if no part started from its body
+	defer d.takePending(-1)
+
+	if d.replace {
+		if err := os.Remove(LongPath(d.Filename)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace %s: %w", d.Filename, err)
+		}
+		d.replace = false
+	}
 
-	// Create the output file if it doesn't exist
-	if _, err := os.Stat(d.Filename); os.IsNotExist(err) {
-		file, err := os.Create(d.Filename)
+	if d.small {
+		return d.downloadSmall(ctx)
+	}
+
+	// The first unrecoverable part error stops every other part
+	var fatalErr error
+	var fatalOnce sync.Once
+	fail := func(err error) {
+		fatalOnce.Do(func() {
+			fatalErr = err
+			cancel()
+		})
+	}
+
+	if d.EncryptionKey != nil {
+		if err := d.prepareEncryptedFile(); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(LongPath(d.Filename)); os.IsNotExist(err) {
+		// Create the output file if it doesn't exist
+		file, err := os.Create(LongPath(d.Filename))
 		if err != nil {
 			return fmt.Errorf("error creating output file: %w", err)
 		}
@@ -313,7 +926,9 @@ func (d *Downloader) Download() error {
 
 	// Start progress display goroutine
 	progressMutex := &sync.Mutex{}
+	displayDone := make(chan struct{})
 	go func() {
+		defer close(displayDone)
 		ticker := time.NewTicker(500 * time.Millisecond)
 		defer ticker.Stop()
 		
@@ -331,42 +946,88 @@ func (d *Downloader) Download() error {
 		}
 	}()
 
+	// Hold the thread limit to what the resource caps allow
+	d.SetThreads(d.threads.Limit())
+
+	// Share one client so HTTP/2 origins multiplex every part over one connection
+	d.client = d.newPartClient()
+	defer d.client.CloseIdleConnections()
+	d.warmUpConnection(ctx)
+	d.startMirrors(ctx)
+
 	// Start download goroutines
 	var wg sync.WaitGroup
 	fmt.Printf("Starting download with %d threads...\n", d.Progress.NumThreads)
 	
-	for i := range d.Progress.Parts {
-		if !d.Progress.Parts[i].Done {
-			wg.Add(1)
-			go d.downloadPart(ctx, &d.Progress.Parts[i], progressMutex, &wg)
+	if d.Progress.Sequential {
+		d.downloadInOrder(ctx, progressMutex, &wg, fail)
+	} else {
+		if d.Progress.PreviewBytes > 0 {
+			d.downloadPreview(ctx, progressMutex, fail)
+		}
+		for i := range d.Progress.Parts {
+			if !d.Progress.Parts[i].Done() {
+				wg.Add(1)
+				go d.downloadPart(ctx, &d.Progress.Parts[i], progressMutex, &wg, fail)
+			}
 		}
 	}
 
 	// Wait for all downloads to complete
 	wg.Wait()
 	cancel() // Stop progress display
+	<-displayDone
 
 	// Final progress save
 	SaveProgress(d.ProgressFile, d.Progress)
 
-	return nil
+	if fatalErr != nil {
+		if errors.Is(fatalErr, ErrRemoteFileChanged) {
+			// The saved parts belong to the old file; start over next time
+			os.Remove(LongPath(d.ProgressFile))
+			d.forgetProbe()
+		}
+		return fatalErr
+	}
+	return parent.Err()
 }
 
 // VerifyDownload checks if the download completed successfully
 func (d *Downloader) VerifyDownload() error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.release()
 	if d.Progress.IsComplete() {
 		fmt.Printf("\n✅ Download completed successfully!\n")
 		fmt.Printf("File saved as: %s\n", d.Progress.Filename)
 		
 		// Verify file size
-		if stat, err := os.Stat(d.Progress.Filename); err == nil {
-			if stat.Size() == d.Progress.TotalSize {
+		if stat, err := os.Stat(LongPath(d.Progress.Filename)); err == nil {
+			expectedSize := d.Progress.TotalSize
+			if d.Progress.Encrypted {
+				expectedSize = EncryptedSize(d.Progress.TotalSize)
+			}
+			if stat.Size() == expectedSize {
 				fmt.Printf("File size verified: %d bytes\n", stat.Size())
+				if err := d.verifyDigests(); err != nil {
+					// Every part is done, so only a fresh download can fix the file
+					os.Remove(LongPath(d.ProgressFile))
+					return err
+				}
+				d.storeCached()
+				d.saveValidators()
+				d.noteCompleted()
+				// Keep the progress until the marker is there, so a
+				// failure to write it is retried by resuming
+				if err := d.writeDoneMarker(); err != nil {
+					return err
+				}
 				// Clean up progress file on successful completion
-				os.Remove(d.ProgressFile)
+				os.Remove(LongPath(d.ProgressFile))
 				return nil
 			} else {
-				return fmt.Errorf("file size mismatch! Expected: %d, Got: %d", d.Progress.TotalSize, stat.Size())
+				return fmt.Errorf("file size mismatch! Expected: %d, Got: %d", expectedSize, stat.Size())
 			}
 		}
 	} else {
@@ -0,0 +1,237 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPayload returns size bytes of non-repeating test data
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*31 + i/251)
+	}
+	return data
+}
+
+// newTestDownloader creates a downloader writing into a temporary directory
+func newTestDownloader(t *testing.T, url string, threads int) *Downloader {
+	t.Helper()
+
+	dir := t.TempDir()
+	dl := NewDownloader(url, filepath.Join(dir, "out.bin"), threads)
+	dl.ProgressFile = filepath.Join(dir, "state.json")
+	// Test payloads are far below the default part minimum
+	dl.MinPartSize = 0
+	return dl
+}
+
+// runDownload runs a download with a deadline so a stuck retry loop fails the test
+func runDownload(t *testing.T, dl *Downloader) error {
+	t.Helper()
+
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	return dl.DownloadContext(ctx)
+}
+
+func TestDownloadWithInjectedFaults(t *testing.T) {
+	data := testPayload(256 * 1024)
+
+	tests := []struct {
+		name    string
+		fault   fault
+		wantErr error
+	}{
+		{name: "no faults", fault: faultNone},
+		{name: "connection resets", fault: faultReset},
+		{name: "truncated bodies", fault: faultTruncate},
+		{name: "slow drip", fault: faultSlowDrip},
+		{name: "flapping range support", fault: faultFlapRanges},
+		{name: "stale etag", fault: faultStaleETag, wantErr: ErrRemoteFileChanged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFaultServer(t, data, tt.fault)
+			dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+
+			err := runDownload(t, dl)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Download() error = %v, want %v", err, tt.wantErr)
+				}
+				if _, statErr := os.Stat(dl.ProgressFile); !os.IsNotExist(statErr) {
+					t.Errorf("progress file was kept after the remote file changed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+
+			if err := dl.VerifyDownload(); err != nil {
+				t.Fatalf("VerifyDownload() error = %v", err)
+			}
+			got, err := os.ReadFile(dl.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("downloaded content does not match the served file")
+			}
+		})
+	}
+}
+
+func TestSmallFileUsesFewerThreads(t *testing.T) {
+	data := testPayload(200 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL, 16)
+	dl.MinPartSize = 64 * 1024
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if len(dl.Progress.Parts) != 3 || dl.Progress.NumThreads != 3 || dl.NumThreads != 3 {
+		t.Errorf("split into %d parts, NumThreads = %d, want 3 parts of at least 64KB", len(dl.Progress.Parts), dl.Progress.NumThreads)
+	}
+	if dl.RequestedThreads() != 16 || dl.Progress.RequestedThreads != 16 {
+		t.Errorf("RequestedThreads() = %d, want the 16 asked for", dl.RequestedThreads())
+	}
+	got, err := os.ReadFile(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match")
+	}
+}
+
+func TestDownloadResumesFromSavedProgress(t *testing.T) {
+	data := testPayload(128 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+
+	// Simulate an interrupted run: each part has its first half on disk
+	progress := CreateNewProgress(dl.URL, dl.Filename, int64(len(data)), 4)
+	progress.ETag = `"v1"`
+	partial := make([]byte, len(data))
+	var alreadyDownloaded int64
+	for i := range progress.Parts {
+		part := &progress.Parts[i]
+		part.SetDownloaded(part.Size() / 2)
+		copy(partial[part.Start:], data[part.Start:part.Start+part.Downloaded()])
+		alreadyDownloaded += part.Downloaded()
+	}
+	if err := os.WriteFile(dl.Filename, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveProgress(dl.ProgressFile, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatalf("VerifyDownload() error = %v", err)
+	}
+
+	got, err := os.ReadFile(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed content does not match the served file")
+	}
+	if served, want := server.bytesServed(), int64(len(data))-alreadyDownloaded; served != want {
+		t.Errorf("server sent %d bytes, want only the %d missing bytes", served, want)
+	}
+}
+
+func TestSingleStreamResume(t *testing.T) {
+	tests := []struct {
+		name string
+		f    fault
+		// full is set when the server ignores the range and the file starts over
+		full bool
+	}{
+		{"honours ranges", faultHiddenRanges, false},
+		{"ignores ranges", faultNoRanges, true},
+	}
+	for _, tt := range tests {
+		data := testPayload(256 * 1024)
+		server := newFaultServer(t, data, tt.f)
+		dl := newTestDownloader(t, server.URL, 4)
+
+		// Interrupt a single-stream download halfway through
+		if err := dl.LoadOrCreateProgress(); err != nil {
+			t.Fatal(err)
+		}
+		if !dl.Progress.SingleStream || len(dl.Progress.Parts) != 1 {
+			t.Fatalf("%s: SingleStream = %v with %d parts", tt.name, dl.Progress.SingleStream, len(dl.Progress.Parts))
+		}
+		half := int64(len(data) / 2)
+		if err := os.WriteFile(dl.Filename, data[:half], 0644); err != nil {
+			t.Fatal(err)
+		}
+		dl.Progress.Parts[0].SetDownloaded(half)
+		if err := SaveProgress(dl.ProgressFile, dl.Progress); err != nil {
+			t.Fatal(err)
+		}
+
+		resumed := newTestDownloader(t, server.URL, 4)
+		resumed.Filename, resumed.ProgressFile = dl.Filename, dl.ProgressFile
+		if err := runDownload(t, resumed); err != nil {
+			t.Fatalf("%s: resume failed: %v", tt.name, err)
+		}
+		got, err := os.ReadFile(resumed.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: resumed file does not match", tt.name)
+		}
+
+		want := int64(len(data)) - half
+		if tt.full {
+			want = int64(len(data))
+		}
+		if served := server.bytesServed(); served != want {
+			t.Errorf("%s: server sent %d bytes, want %d", tt.name, served, want)
+		}
+	}
+}
+
+func TestDownloadStopsWhenContextCancelled(t *testing.T) {
+	// Large enough to be split into parts with a progress file
+	data := testPayload(2 * SmallFileSize)
+	server := newFaultServer(t, data, faultNone)
+	// Nothing listens on the closed server, so every part keeps retrying
+	dl := newTestDownloader(t, server.URL+"/file.bin", 2)
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	err := dl.DownloadContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DownloadContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, statErr := os.Stat(dl.ProgressFile); statErr != nil {
+		t.Errorf("progress file missing after cancellation: %v", statErr)
+	}
+}
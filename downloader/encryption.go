@@ -0,0 +1,471 @@
+package downloader
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Encrypted files start with a header (magic, plaintext size, chunk size)
+// followed by the plaintext split into EncryptedChunkSize chunks. Each chunk
+// is sealed with AES-256-GCM under its own random nonce, stored in front of
+// it, and authenticated together with the header, its index and whether it is
+// the last chunk, so chunks cannot be reordered, truncated or moved between
+// files. Chunks are independent, which lets parts encrypt their own ranges
+// concurrently and lets readers decrypt any byte range.
+const (
+	// EncryptedChunkSize is the plaintext size of each sealed chunk
+	EncryptedChunkSize = 64 * 1024
+
+	// EncryptionKeySize is the length of an AES-256 key
+	EncryptionKeySize = 32
+
+	encryptedMagic      = "MTDENC01"
+	encryptedHeaderSize = len(encryptedMagic) + 8 + 4
+	chunkNonceSize      = 12
+	chunkTagSize        = 16
+	chunkOverhead       = chunkNonceSize + chunkTagSize
+	maxEncryptedChunks  = 1 << 32
+)
+
+var (
+	// ErrNotEncrypted is returned when a file does not carry the encrypted file header
+	ErrNotEncrypted = errors.New("file is not encrypted")
+	// ErrDecryptionFailed is returned for a wrong key or a tampered or truncated file
+	ErrDecryptionFailed = errors.New("decryption failed: wrong key or corrupted file")
+)
+
+// ParseEncryptionKey decodes a 32 byte key given as hex or base64
+func ParseEncryptionKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+
+	if key, err := hex.DecodeString(value); err == nil && len(key) == EncryptionKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == EncryptionKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption key must be %d bytes encoded as hex or base64", EncryptionKeySize)
+}
+
+// LoadEncryptionKey reads a hex or base64 encoded key from a file
+func LoadEncryptionKey(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	return ParseEncryptionKey(string(data))
+}
+
+// GenerateEncryptionKey returns a new random key encoded as hex
+func GenerateEncryptionKey() (string, error) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// EncryptedSize returns the on-disk size of an encrypted file holding plainSize bytes
+func EncryptedSize(plainSize int64) int64 {
+	return int64(encryptedHeaderSize) + encryptedChunkCount(plainSize)*chunkOverhead + plainSize
+}
+
+// encryptedChunkCount returns how many chunks a file has; empty files still
+// get one empty chunk so the header is authenticated
+func encryptedChunkCount(plainSize int64) int64 {
+	if plainSize == 0 {
+		return 1
+	}
+	return (plainSize + EncryptedChunkSize - 1) / EncryptedChunkSize
+}
+
+// fileCipher seals and opens the chunks of one encrypted file
+type fileCipher struct {
+	aead   cipher.AEAD
+	header []byte
+	size   int64
+}
+
+// newFileCipher creates the cipher and header for a new file of size plaintext bytes
+func newFileCipher(key []byte, size int64) (*fileCipher, error) {
+	if size < 0 || encryptedChunkCount(size) > maxEncryptedChunks {
+		return nil, fmt.Errorf("cannot encrypt a %d byte file", size)
+	}
+
+	header := make([]byte, encryptedHeaderSize)
+	copy(header, encryptedMagic)
+	binary.BigEndian.PutUint64(header[len(encryptedMagic):], uint64(size))
+	binary.BigEndian.PutUint32(header[len(encryptedMagic)+8:], EncryptedChunkSize)
+
+	return buildFileCipher(key, header, size)
+}
+
+// readFileCipher reads and checks the header of an existing encrypted file
+func readFileCipher(key []byte, r io.ReaderAt) (*fileCipher, error) {
+	header := make([]byte, encryptedHeaderSize)
+	if n, _ := r.ReadAt(header, 0); n < encryptedHeaderSize || string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, ErrNotEncrypted
+	}
+
+	size := int64(binary.BigEndian.Uint64(header[len(encryptedMagic):]))
+	chunkSize := binary.BigEndian.Uint32(header[len(encryptedMagic)+8:])
+	if size < 0 || chunkSize != EncryptedChunkSize {
+		return nil, fmt.Errorf("%w: unsupported header", ErrDecryptionFailed)
+	}
+
+	return buildFileCipher(key, header, size)
+}
+
+func buildFileCipher(key, header []byte, size int64) (*fileCipher, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileCipher{aead: aead, header: header, size: size}, nil
+}
+
+// chunkLen returns the plaintext length of a chunk
+func (c *fileCipher) chunkLen(index int64) int64 {
+	if rest := c.size - index*EncryptedChunkSize; rest < EncryptedChunkSize {
+		return rest
+	}
+	return EncryptedChunkSize
+}
+
+// chunkOffset returns where a chunk starts in the encrypted file
+func (c *fileCipher) chunkOffset(index int64) int64 {
+	return int64(encryptedHeaderSize) + index*(EncryptedChunkSize+chunkOverhead)
+}
+
+// additionalData binds a chunk to the file header, its position and whether it ends the file
+func (c *fileCipher) additionalData(index int64) []byte {
+	ad := make([]byte, len(c.header)+9)
+	copy(ad, c.header)
+	binary.BigEndian.PutUint64(ad[len(c.header):], uint64(index))
+	if index == encryptedChunkCount(c.size)-1 {
+		ad[len(ad)-1] = 1
+	}
+	return ad
+}
+
+// seal encrypts a chunk, returning nonce and ciphertext as stored on disk
+func (c *fileCipher) seal(index int64, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, chunkNonceSize, chunkNonceSize+len(plaintext)+chunkTagSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, c.additionalData(index)), nil
+}
+
+// open decrypts a chunk as stored on disk
+func (c *fileCipher) open(index int64, sealed []byte) ([]byte, error) {
+	if len(sealed) < chunkOverhead {
+		return nil, ErrDecryptionFailed
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:chunkNonceSize], sealed[chunkNonceSize:], c.additionalData(index))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// DecryptingReader reads the plaintext of an encrypted file, decrypting one
+// chunk at a time. It supports seeking so it can back http.ServeContent.
+type DecryptingReader struct {
+	r      io.ReaderAt
+	cipher *fileCipher
+	offset int64
+
+	chunkIndex int64
+	chunk      []byte
+}
+
+// NewDecryptingReader opens an encrypted file for reading
+func NewDecryptingReader(r io.ReaderAt, key []byte) (*DecryptingReader, error) {
+	c, err := readFileCipher(key, r)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptingReader{r: r, cipher: c, chunkIndex: -1}, nil
+}
+
+// Size returns the plaintext size of the file
+func (dr *DecryptingReader) Size() int64 {
+	return dr.cipher.size
+}
+
+// Read implements io.Reader
+func (dr *DecryptingReader) Read(p []byte) (int, error) {
+	if dr.offset >= dr.cipher.size {
+		// Still authenticate the empty final chunk of an empty file
+		if dr.cipher.size == 0 && dr.chunkIndex < 0 {
+			if err := dr.loadChunk(0); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+
+	index := dr.offset / EncryptedChunkSize
+	if index != dr.chunkIndex {
+		if err := dr.loadChunk(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.chunk[dr.offset-index*EncryptedChunkSize:])
+	dr.offset += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker over the plaintext
+func (dr *DecryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += dr.offset
+	case io.SeekEnd:
+		offset += dr.cipher.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	dr.offset = offset
+	return offset, nil
+}
+
+func (dr *DecryptingReader) loadChunk(index int64) error {
+	sealed := make([]byte, dr.cipher.chunkLen(index)+chunkOverhead)
+	if n, err := dr.r.ReadAt(sealed, dr.cipher.chunkOffset(index)); n < len(sealed) {
+		if err == nil || err == io.EOF {
+			return fmt.Errorf("%w: file is truncated", ErrDecryptionFailed)
+		}
+		return err
+	}
+
+	plaintext, err := dr.cipher.open(index, sealed)
+	if err != nil {
+		return err
+	}
+	dr.chunkIndex = index
+	dr.chunk = plaintext
+	return nil
+}
+
+// IsEncryptedFile reports whether a file starts with the encrypted file header
+func IsEncryptedFile(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return string(magic) == encryptedMagic
+}
+
+// DecryptFile writes the plaintext of an encrypted file to output
+func DecryptFile(key []byte, input, output string) error {
+	in, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer in.Close()
+
+	reader, err := NewDecryptingReader(in, key)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		os.Remove(output)
+		return err
+	}
+	return out.Close()
+}
+
+// ServeOptions limits what NewDecryptingFileServer serves
+type ServeOptions struct {
+	// AllowPlaintext serves files that are not encrypted as they are;
+	// without it only encrypted files are served
+	AllowPlaintext bool
+	// Exclude are files never served even when they lie under the root,
+	// such as the key file
+	Exclude []string
+}
+
+// stateSuffixes end the names of the files the downloader keeps next to
+// its outputs
+var stateSuffixes = []string{".lock", ".done", ".validators", ".environment.json", ".tmp"}
+
+// NewDecryptingFileServer serves the files under root over HTTP, decrypting
+// encrypted files on the fly. Range requests are supported; directory
+// listings are not. Dotfiles, the progress and other state files of the
+// downloader and the files opts excludes are never served, and plaintext
+// files only when opts allows them.
+func NewDecryptingFileServer(root string, key []byte, opts ServeOptions) http.Handler {
+	var excluded []os.FileInfo
+	for _, name := range opts.Exclude {
+		if stat, err := os.Stat(name); err == nil {
+			excluded = append(excluded, stat)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if !servable(name) {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := http.Dir(root).Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil || stat.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		for _, other := range excluded {
+			if os.SameFile(stat, other) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		readerAt, ok := file.(io.ReaderAt)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		reader, err := NewDecryptingReader(readerAt, key)
+		if errors.Is(err, ErrNotEncrypted) {
+			if !opts.AllowPlaintext {
+				http.Error(w, "file is not encrypted", http.StatusForbidden)
+				return
+			}
+			http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.ServeContent(w, r, stat.Name(), stat.ModTime(), reader)
+	})
+}
+
+// servable reports whether the cleaned request path name may be served: no
+// segment is a dotfile and the file is not one the downloader keeps state in
+func servable(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	base := path.Base(name)
+	if base == DefaultProgressFile {
+		return false
+	}
+	for _, suffix := range stateSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareEncryptedFile sets up the encrypted output file. A fresh download
+// starts a new file; a resumed one must find the header it wrote before.
+func (d *Downloader) prepareEncryptedFile() error {
+	if !d.Progress.Encrypted {
+		return fmt.Errorf("progress for %s was saved without encryption", d.Filename)
+	}
+
+	if d.resumed {
+		file, err := os.Open(LongPath(d.Filename))
+		if err == nil {
+			defer file.Close()
+			c, err := readFileCipher(d.EncryptionKey, file)
+			if err != nil {
+				return fmt.Errorf("cannot resume encrypted download: %w", err)
+			}
+			if c.size != d.Progress.TotalSize {
+				return fmt.Errorf("cannot resume encrypted download: file is for %d bytes, expected %d", c.size, d.Progress.TotalSize)
+			}
+			d.cipher = c
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("error opening output file: %w", err)
+		}
+		// The output file is gone, so nothing that was downloaded survives
+		for i := range d.Progress.Parts {
+			d.Progress.Parts[i].Reset()
+		}
+	}
+
+	c, err := newFileCipher(d.EncryptionKey, d.Progress.TotalSize)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(LongPath(d.Filename))
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(c.header); err != nil {
+		return fmt.Errorf("error writing encryption header: %w", err)
+	}
+
+	// An empty file has no parts to write its single empty chunk
+	if d.Progress.TotalSize == 0 {
+		sealed, err := c.seal(0, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(sealed); err != nil {
+			return fmt.Errorf("error writing encrypted file: %w", err)
+		}
+	}
+
+	d.cipher = c
+	return nil
+}
@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEncryptionKey(t *testing.T) []byte {
+	t.Helper()
+
+	encoded, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseEncryptionKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptedDownloadRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, EncryptedChunkSize, 3*EncryptedChunkSize + 123}
+	faults := []fault{faultNone, faultTruncate}
+
+	for _, size := range sizes {
+		for _, f := range faults {
+			data := testPayload(size)
+			key := testEncryptionKey(t)
+			server := newFaultServer(t, data, f)
+
+			dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+			dl.EncryptionKey = key
+			if err := runDownload(t, dl); err != nil {
+				t.Fatalf("size %d fault %d: Download() error = %v", size, f, err)
+			}
+			if err := dl.VerifyDownload(); err != nil {
+				t.Fatalf("size %d fault %d: VerifyDownload() error = %v", size, f, err)
+			}
+
+			raw, err := os.ReadFile(dl.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > 16 && bytes.Contains(raw, data[:16]) {
+				t.Fatalf("size %d: plaintext found in encrypted file", size)
+			}
+
+			plainPath := filepath.Join(t.TempDir(), "plain.bin")
+			if err := DecryptFile(key, dl.Filename, plainPath); err != nil {
+				t.Fatalf("size %d: DecryptFile() error = %v", size, err)
+			}
+			got, err := os.ReadFile(plainPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("size %d fault %d: decrypted content does not match", size, f)
+			}
+		}
+	}
+}
+
+func TestDecryptRejectsTamperingAndWrongKey(t *testing.T) {
+	data := testPayload(2*EncryptedChunkSize + 10)
+	key := testEncryptionKey(t)
+	server := newFaultServer(t, data, faultNone)
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 2)
+	dl.EncryptionKey = key
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+		key  []byte
+	}{
+		{name: "wrong key", file: raw, key: testEncryptionKey(t)},
+		{name: "flipped byte", file: flipByte(raw, len(raw)/2), key: key},
+		{name: "truncated", file: raw[:len(raw)-EncryptedChunkSize/2], key: key},
+		{name: "size changed in header", file: flipByte(raw, len(encryptedMagic)+7), key: key},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewDecryptingReader(bytes.NewReader(tt.file), tt.key)
+			if err == nil {
+				_, err = io.Copy(io.Discard, reader)
+			}
+			if !errors.Is(err, ErrDecryptionFailed) {
+				t.Fatalf("decrypting error = %v, want ErrDecryptionFailed", err)
+			}
+		})
+	}
+}
+
+func flipByte(data []byte, i int) []byte {
+	changed := append([]byte(nil), data...)
+	changed[i] ^= 0x01
+	return changed
+}
+
+func TestDecryptingFileServerServesRanges(t *testing.T) {
+	data := testPayload(3*EncryptedChunkSize + 500)
+	key := testEncryptionKey(t)
+	server := newFaultServer(t, data, faultNone)
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 3)
+	dl.EncryptionKey = key
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+
+	files := httptest.NewServer(NewDecryptingFileServer(filepath.Dir(dl.Filename), key, ServeOptions{}))
+	defer files.Close()
+
+	req, err := http.NewRequest("GET", files.URL+"/"+filepath.Base(dl.Filename), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := EncryptedChunkSize-10, 2*EncryptedChunkSize+10
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %s, want 206", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data[start:end+1]) {
+		t.Fatalf("served range does not match the plaintext")
+	}
+}
+
+func TestDecryptingFileServerRefuses(t *testing.T) {
+	key := testEncryptionKey(t)
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("download.key", []byte(hex.EncodeToString(key)))
+	write("plain.txt", []byte("not encrypted"))
+	write(DefaultProgressFile, []byte("{}"))
+	write("file.bin.lock", nil)
+	write(".secret", []byte("hidden"))
+	write(".git/config", []byte("hidden"))
+
+	get := func(opts ServeOptions, name string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		NewDecryptingFileServer(dir, key, opts).ServeHTTP(rec, httptest.NewRequest("GET", "/"+name, nil))
+		return rec.Code
+	}
+	keyFile := ServeOptions{AllowPlaintext: true, Exclude: []string{filepath.Join(dir, "download.key")}}
+	tests := []struct {
+		name string
+		opts ServeOptions
+		want int
+	}{
+		{"plain.txt", ServeOptions{}, http.StatusForbidden},
+		{"plain.txt", keyFile, http.StatusOK},
+		{"download.key", ServeOptions{}, http.StatusForbidden},
+		{"download.key", keyFile, http.StatusNotFound},
+		{DefaultProgressFile, keyFile, http.StatusNotFound},
+		{"file.bin.lock", keyFile, http.StatusNotFound},
+		{".secret", keyFile, http.StatusNotFound},
+		{".git/config", keyFile, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if got := get(tt.opts, tt.name); got != tt.want {
+			t.Errorf("GET /%s with %+v = %d, want %d", tt.name, tt.opts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/secrets"
+)
+
+// EnvironmentVersion is the version of the environment record format
+const EnvironmentVersion = 1
+
+// Environment records everything that decided how a download ran: the
+// request it sent, its effective settings, the addresses its hosts
+// resolved to, the redirects it followed and what the server answered.
+// Credentials are redacted, so the record can be attached to a bug report
+// or kept with the file to repeat the download later.
+type Environment struct {
+	Version    int       `json:"version"`
+	CapturedAt time.Time `json:"captured_at"`
+	// CompletedAt is set once the download is verified
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Go and Platform are the runtime the download ran on
+	Go       string `json:"go"`
+	Platform string `json:"platform"`
+	// URL is the file's URL; Redirects are the URLs the probe went
+	// through before FinalURL
+	URL       string   `json:"url"`
+	Redirects []string `json:"redirects,omitempty"`
+	FinalURL  string   `json:"final_url,omitempty"`
+	Output    string   `json:"output"`
+	// Resumed is set when the download continued saved progress, which
+	// skips the probe: Server and Resolved are then empty
+	Resumed      bool               `json:"resumed,omitempty"`
+	Request      EnvironmentRequest `json:"request"`
+	Config       EnvironmentConfig  `json:"config"`
+	Resolved     []ResolvedHost     `json:"resolved,omitempty"`
+	Server       *EnvironmentServer `json:"server,omitempty"`
+	TotalSize    int64              `json:"total_size"`
+	Parts        int                `json:"parts"`
+	ETag         string             `json:"etag,omitempty"`
+	LastModified string             `json:"last_modified,omitempty"`
+	// PartAddresses are the server addresses the parts were fetched from;
+	// a small file fetched in a single request only has Resolved
+	PartAddresses []string `json:"part_addresses,omitempty"`
+}
+
+// EnvironmentRequest is the request a download sends, sensitive header
+// values redacted
+type EnvironmentRequest struct {
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	UserAgent   string            `json:"user_agent"`
+	UserAgents  []string          `json:"user_agents,omitempty"`
+	Referer     string            `json:"referer,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	BodyBytes   int               `json:"body_bytes,omitempty"`
+	Cookies     bool              `json:"cookies,omitempty"`
+}
+
+// EnvironmentConfig are the effective settings of a download
+type EnvironmentConfig struct {
+	Threads          int      `json:"threads"`
+	RequestedThreads int      `json:"requested_threads"`
+	MinPartSize      int64    `json:"min_part_size"`
+	RateLimit        int64    `json:"rate_limit"`
+	SharedRateLimit  int64    `json:"shared_rate_limit,omitempty"`
+	Bind             string   `json:"bind,omitempty"`
+	BindRateLimit    int64    `json:"bind_rate_limit,omitempty"`
+	HTTP2            bool     `json:"http2"`
+	Encrypted        bool     `json:"encrypted,omitempty"`
+	Sequential       bool     `json:"sequential,omitempty"`
+	PreviewBytes     int64    `json:"preview_bytes,omitempty"`
+	Jitter           string   `json:"jitter,omitempty"`
+	Mirrors          []string `json:"mirrors,omitempty"`
+	MirrorCount      int      `json:"mirror_count,omitempty"`
+	PartURLs         []string `json:"part_urls,omitempty"`
+	RetryAttempts    int      `json:"retry_attempts"`
+	RetryDelay       string   `json:"retry_delay"`
+	ChecksumRetries  int      `json:"checksum_retries"`
+	ExpectedSize     int64    `json:"expected_size,omitempty"`
+	MaxThreads       int      `json:"max_threads,omitempty"`
+	MaxBufferMemory  int64    `json:"max_buffer_memory,omitempty"`
+	Plan             bool     `json:"plan,omitempty"`
+	Continue         bool     `json:"continue_file,omitempty"`
+	OnlyIfModified   bool     `json:"only_if_modified,omitempty"`
+	Cache            bool     `json:"cache,omitempty"`
+}
+
+// ResolvedHost is what the resolver answered for a host the probe
+// connected to, and the address it connected to
+type ResolvedHost struct {
+	Host      string   `json:"host"`
+	Addresses []string `json:"addresses,omitempty"`
+	Connected string   `json:"connected,omitempty"`
+}
+
+// EnvironmentServer is the server's answer to the probe, sensitive header
+// values such as Set-Cookie redacted
+type EnvironmentServer struct {
+	Status         string            `json:"status,omitempty"`
+	Proto          string            `json:"proto,omitempty"`
+	SupportsRanges bool              `json:"supports_ranges"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ProbedAt       time.Time         `json:"probed_at"`
+	// Cached is set when the answer came from ProbeCache, which keeps no
+	// headers
+	Cached bool `json:"cached,omitempty"`
+}
+
+// environmentState is what a download learns for its Environment while it
+// runs
+type environmentState struct {
+	mu          sync.Mutex
+	capturedAt  time.Time
+	completedAt time.Time
+	probe       *ProbeResult
+	cached      bool
+	resolved    []ResolvedHost
+	// connecting is the host the next DNS answer and connection belong to
+	connecting string
+}
+
+// EnvironmentFile returns where the CLI keeps the environment of output
+func EnvironmentFile(output string) string {
+	return output + ".environment.json"
+}
+
+// SaveEnvironment writes env to path as indented JSON
+func SaveEnvironment(path string, env *Environment) error {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(LongPath(path), append(data, '\n'), 0644)
+}
+
+// LoadEnvironment reads an environment written by SaveEnvironment
+func LoadEnvironment(path string) (*Environment, error) {
+	data, err := os.ReadFile(LongPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var env Environment
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid environment record: %w", err)
+	}
+	return &env, nil
+}
+
+// environmentState returns the state of CaptureEnvironment, or nil when the
+// download does not capture its environment
+func (d *Downloader) environmentState() *environmentState {
+	if !d.CaptureEnvironment {
+		return nil
+	}
+	d.envOnce.Do(func() {
+		d.env = &environmentState{capturedAt: time.Now().UTC()}
+	})
+	return d.env
+}
+
+// noteProbe keeps the probe the download was set up from
+func (d *Downloader) noteProbe(probe ProbeResult, cached bool) {
+	if env := d.environmentState(); env != nil {
+		env.mu.Lock()
+		env.probe, env.cached = &probe, cached
+		env.mu.Unlock()
+	}
+}
+
+// noteCompleted stamps the environment once the download is verified
+func (d *Downloader) noteCompleted() {
+	if env := d.environmentState(); env != nil {
+		env.mu.Lock()
+		env.completedAt = time.Now().UTC()
+		env.mu.Unlock()
+	}
+}
+
+// traceResolver records the addresses the probe's hosts resolve to and
+// the connections it makes, when the environment is captured
+func (d *Downloader) traceResolver(ctx context.Context) context.Context {
+	env := d.environmentState()
+	if env == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			env.mu.Lock()
+			env.connecting = hostPort
+			env.mu.Unlock()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			env.mu.Lock()
+			host := env.host(env.connecting)
+			host.Addresses = nil
+			for _, addr := range info.Addrs {
+				host.Addresses = append(host.Addresses, addr.String())
+			}
+			env.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			env.mu.Lock()
+			env.host(env.connecting).Connected = info.Conn.RemoteAddr().String()
+			env.mu.Unlock()
+		},
+	})
+}
+
+// host returns the entry of hostPort in resolved, adding it if needed
+func (e *environmentState) host(hostPort string) *ResolvedHost {
+	for i := range e.resolved {
+		if e.resolved[i].Host == hostPort {
+			return &e.resolved[i]
+		}
+	}
+	e.resolved = append(e.resolved, ResolvedHost{Host: hostPort})
+	return &e.resolved[len(e.resolved)-1]
+}
+
+// Environment returns the download's environment as it stands, or nil
+// unless CaptureEnvironment is set. It is complete once
+// LoadOrCreateProgress has run, and gains CompletedAt and PartAddresses
+// once VerifyDownload succeeds.
+func (d *Downloader) Environment() *Environment {
+	env := d.environmentState()
+	if env == nil {
+		return nil
+	}
+	method := d.requestMethod()
+	e := &Environment{
+		Version:  EnvironmentVersion,
+		Go:       runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		URL:      secrets.RedactURL(d.URL),
+		Output:   filepath.Base(d.Filename),
+		Resumed:  d.resumed,
+		Request: EnvironmentRequest{
+			Method:      method,
+			Headers:     secrets.RedactSensitiveHeaders(d.Headers),
+			UserAgent:   d.UserAgent,
+			UserAgents:  d.UserAgents,
+			Referer:     secrets.RedactURL(d.Referer),
+			ContentType: d.ContentType,
+			BodyBytes:   len(d.Body),
+			Cookies:     d.CookieJar != nil,
+		},
+		Config: EnvironmentConfig{
+			Threads:          d.NumThreads,
+			RequestedThreads: d.RequestedThreads(),
+			MinPartSize:      d.MinPartSize,
+			RateLimit:        d.RateLimit(),
+			Bind:             d.Bind,
+			BindRateLimit:    d.BindRateLimit,
+			HTTP2:            !d.DisableHTTP2,
+			Encrypted:        d.EncryptionKey != nil,
+			Sequential:       d.Sequential,
+			PreviewBytes:     d.PreviewBytes,
+			MirrorCount:      d.MirrorCount,
+			RetryAttempts:    d.Retry.MaxAttempts,
+			RetryDelay:       d.Retry.Delay.String(),
+			ChecksumRetries:  d.ChecksumRetries,
+			ExpectedSize:     d.ExpectedSize,
+			MaxThreads:       d.Resources.MaxThreads,
+			MaxBufferMemory:  d.Resources.MaxBufferMemory,
+			Plan:             d.Plan != nil,
+			Continue:         d.Continue,
+			OnlyIfModified:   d.OnlyIfModified,
+			Cache:            d.Cache != nil,
+		},
+	}
+	if e.Request.UserAgent == "" {
+		e.Request.UserAgent = DefaultUserAgent
+	}
+	if d.SharedLimiter != nil {
+		e.Config.SharedRateLimit = d.SharedLimiter.Rate()
+	}
+	if d.Jitter > 0 {
+		e.Config.Jitter = d.Jitter.String()
+	}
+	if d.Retry.Delay == 0 {
+		e.Config.RetryDelay = DefaultRetryDelay.String()
+	}
+	e.Config.Mirrors = redactURLs(d.mirrorURLs())
+	e.Config.PartURLs = redactURLs(d.PartURLs)
+	if p := d.Progress; p != nil {
+		e.TotalSize, e.Parts = p.TotalSize, len(p.Parts)
+		e.ETag, e.LastModified = p.ETag, p.LastModified
+		e.Config.Sequential = p.Sequential
+		e.Config.PreviewBytes = p.PreviewBytes
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	e.CapturedAt = env.capturedAt
+	if !env.completedAt.IsZero() {
+		completed := env.completedAt
+		e.CompletedAt = &completed
+		e.PartAddresses = d.partAddresses()
+	}
+	e.Resolved = append([]ResolvedHost(nil), env.resolved...)
+	if probe := env.probe; probe != nil {
+		e.Redirects = redactURLs(probe.Redirects)
+		if probe.FinalURL != "" {
+			e.FinalURL = secrets.RedactURL(probe.FinalURL)
+		}
+		e.Server = &EnvironmentServer{
+			Status:         probe.Status,
+			Proto:          probe.Proto,
+			SupportsRanges: probe.SupportsRanges,
+			Headers:        responseHeaders(probe.Header),
+			ProbedAt:       probe.ProbedAt.UTC(),
+			Cached:         env.cached,
+		}
+	}
+	return e
+}
+
+// Inherit fills in what a resumed download did not learn itself from prev,
+// the environment recorded when it started: the probe's redirects, the
+// resolver's answers and the server's reply
+func (e *Environment) Inherit(prev *Environment) {
+	if prev == nil || e.Server != nil {
+		return
+	}
+	e.CapturedAt = prev.CapturedAt
+	e.Redirects, e.FinalURL = prev.Redirects, prev.FinalURL
+	e.Server = prev.Server
+	if len(e.Resolved) == 0 {
+		e.Resolved = prev.Resolved
+	}
+}
+
+// partAddresses returns the distinct server addresses of the parts, sorted
+func (d *Downloader) partAddresses() []string {
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, conn := range d.PartConnections() {
+		if conn.RemoteAddr != "" && !seen[conn.RemoteAddr] {
+			seen[conn.RemoteAddr] = true
+			addrs = append(addrs, conn.RemoteAddr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// responseHeaders flattens the headers of a response, joining repeated
+// ones, and redacts the sensitive ones and the URL in Location
+func responseHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	flat := make(map[string]string, len(header))
+	for name, values := range header {
+		flat[name] = strings.Join(values, ", ")
+	}
+	if location, ok := flat["Location"]; ok {
+		flat["Location"] = secrets.RedactURL(location)
+	}
+	return secrets.RedactSensitiveHeaders(flat)
+}
+
+func redactURLs(urls []string) []string {
+	if len(urls) == 0 {
+		return nil
+	}
+	redacted := make([]string, len(urls))
+	for i, u := range urls {
+		redacted[i] = secrets.RedactURL(u)
+	}
+	return redacted
+}
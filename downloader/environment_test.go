@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/secrets"
+)
+
+func TestEnvironmentRecordsDownload(t *testing.T) {
+	data := testPayload(256 * 1024)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file.bin?token=abc", http.StatusFound)
+	})
+	mux.HandleFunc("/file.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test/1.0")
+		w.Header().Set("Set-Cookie", "session=s3cret")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// A host name, so the resolver is asked
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/start"
+	dl := newTestDownloader(t, url, 2)
+	dl.Headers = map[string]string{"Authorization": "Bearer s3cret", "X-Trace": "42"}
+	if dl.Environment() != nil {
+		t.Fatal("Environment() without CaptureEnvironment is not nil")
+	}
+	dl.CaptureEnvironment = true
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+
+	env := dl.Environment()
+	if env.CompletedAt != nil {
+		t.Error("environment completed before the download was verified")
+	}
+	if len(env.Redirects) != 1 || env.Redirects[0] != url || !strings.HasSuffix(env.FinalURL, "/file.bin?token="+secrets.Redacted) {
+		t.Errorf("redirects %v, final URL %q", env.Redirects, env.FinalURL)
+	}
+	if env.Request.Headers["Authorization"] != secrets.Redacted || env.Request.Headers["X-Trace"] != "42" {
+		t.Errorf("request headers = %v", env.Request.Headers)
+	}
+	if env.Server == nil || env.Server.Headers["Server"] != "test/1.0" || env.Server.Headers["Set-Cookie"] != secrets.Redacted || !env.Server.SupportsRanges {
+		t.Fatalf("server = %+v", env.Server)
+	}
+	if env.Config.Threads != 2 || env.Config.RetryDelay != DefaultRetryDelay.String() || env.TotalSize != int64(len(data)) || env.Parts != 2 || env.Output != "out.bin" {
+		t.Errorf("environment = %+v", env)
+	}
+	host := server.URL[len("http://127.0.0.1"):]
+	if len(env.Resolved) != 1 || env.Resolved[0].Host != "localhost"+host || len(env.Resolved[0].Addresses) == 0 || !strings.HasSuffix(env.Resolved[0].Connected, host) {
+		t.Errorf("resolved = %+v", env.Resolved)
+	}
+
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	env = dl.Environment()
+	if env.CompletedAt == nil || len(env.PartAddresses) == 0 {
+		t.Errorf("completed environment = %+v", env)
+	}
+
+	path := filepath.Join(t.TempDir(), "env.json")
+	if err := SaveEnvironment(path, env); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadEnvironment(path)
+	if err != nil || loaded.FinalURL != env.FinalURL || loaded.Server.Headers["Server"] != "test/1.0" {
+		t.Errorf("LoadEnvironment() = %+v, %v", loaded, err)
+	}
+}
+
+func TestEnvironmentInherit(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	prev := &Environment{
+		CapturedAt: started,
+		Redirects:  []string{"https://example.com/start"},
+		FinalURL:   "https://cdn.example.com/file.bin",
+		Resolved:   []ResolvedHost{{Host: "example.com:443", Connected: "192.0.2.1:443"}},
+		Server:     &EnvironmentServer{Status: "200 OK", SupportsRanges: true},
+	}
+	resumed := &Environment{CapturedAt: started.Add(time.Hour), Resumed: true}
+	resumed.Inherit(prev)
+	if !resumed.CapturedAt.Equal(started) || resumed.FinalURL != prev.FinalURL || resumed.Server != prev.Server || len(resumed.Resolved) != 1 || !resumed.Resumed {
+		t.Errorf("inherited environment = %+v", resumed)
+	}
+
+	probed := &Environment{Server: &EnvironmentServer{Status: "206 Partial Content"}}
+	probed.Inherit(prev)
+	if probed.Server.Status != "206 Partial Content" || probed.FinalURL != "" {
+		t.Errorf("probed environment took the earlier one: %+v", probed)
+	}
+}
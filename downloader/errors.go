@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrServerStatus is returned when the server answers with an error status
+var ErrServerStatus = errors.New("server returned status")
+
+// ErrDiskFull is returned when the output file cannot be written because its
+// disk is full. Parts retry other write errors, but a full disk stops the
+// download with its progress saved.
+var ErrDiskFull = errors.New("disk full")
+
+// ErrTooManyThreads is returned for a thread count above the number of parts
+// a running download was split into
+var ErrTooManyThreads = errors.New("more threads than parts")
+
+// IsNetworkError reports whether err comes from talking to the server: a
+// failed connection, a timeout, a body cut short or an error status
+func IsNetworkError(err error) bool {
+	// Not net.Error: syscall errors such as a full disk implement it too
+	var urlErr *url.Error
+	var opErr *net.OpError
+	return errors.As(err, &urlErr) || errors.As(err, &opErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrServerStatus)
+}
+
+// IsDiskFull reports whether err comes from a full disk
+func IsDiskFull(err error) bool {
+	return errors.Is(err, ErrDiskFull) || errors.Is(err, syscall.ENOSPC)
+}
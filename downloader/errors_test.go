@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestErrorClasses(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	_, connErr := http.Get(server.URL)
+
+	tests := []struct {
+		err      error
+		network  bool
+		diskFull bool
+	}{
+		{fmt.Errorf("failed to make GET request: %w", connErr), true, false},
+		{fmt.Errorf("%w: 404 Not Found", ErrServerStatus), true, false},
+		{fmt.Errorf("error reading response: %w", io.ErrUnexpectedEOF), true, false},
+		{fmt.Errorf("error writing to file: %w", &os.PathError{Op: "write", Path: "f", Err: syscall.ENOSPC}), false, true},
+		{ErrChecksumMismatch, false, false},
+	}
+	for _, tt := range tests {
+		if got := IsNetworkError(tt.err); got != tt.network {
+			t.Errorf("IsNetworkError(%v) = %v", tt.err, got)
+		}
+		if got := IsDiskFull(tt.err); got != tt.diskFull {
+			t.Errorf("IsDiskFull(%v) = %v", tt.err, got)
+		}
+	}
+}
+
+func TestDiskFullStopsDownload(t *testing.T) {
+	// Writes to /dev/full fail with ENOSPC
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full on this system")
+	}
+	data := testPayload(256 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+	dl.Filename = "/dev/full"
+
+	if err := runDownload(t, dl); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("download error = %v, want ErrDiskFull", err)
+	}
+}
+
+func TestWriteFaultStopsDownload(t *testing.T) {
+	data := testPayload(256 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+	var writes int32
+	dl.WriteFault = func(n int) error {
+		// Parts write at once; the fourth write finds the disk full
+		if atomic.AddInt32(&writes, 1) > 3 {
+			return &os.PathError{Op: "write", Path: dl.Filename, Err: syscall.ENOSPC}
+		}
+		return nil
+	}
+
+	if err := runDownload(t, dl); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("download error = %v, want ErrDiskFull", err)
+	}
+}
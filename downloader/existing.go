@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrCannotContinue is returned when an existing output file cannot be the
+// start of the download
+var ErrCannotContinue = errors.New("cannot continue the existing file")
+
+// HasProgress reports whether the progress file holds a download of the
+// same URL to the same file that LoadOrCreateProgress would resume
+func (d *Downloader) HasProgress() bool {
+	progress, err := LoadProgress(d.ProgressFile)
+	if err != nil {
+		return false
+	}
+	return progress.URL == d.URL && progress.Filename == d.Filename &&
+		progress.Encrypted == (d.EncryptionKey != nil)
+}
+
+// continueExisting records the bytes already in the output file as the
+// start of a new download, so only the rest is fetched
+func (d *Downloader) continueExisting() error {
+	stat, err := os.Stat(LongPath(d.Filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking existing file: %w", err)
+	}
+
+	size := stat.Size()
+	if d.Progress.Encrypted {
+		// Without the progress nothing tells which chunks were sealed
+		return fmt.Errorf("%w: %s is encrypted and has no saved progress", ErrCannotContinue, d.Filename)
+	}
+	if size > d.Progress.TotalSize {
+		return fmt.Errorf("%w: %s has %d bytes, more than the %d bytes of the remote file", ErrCannotContinue, d.Filename, size, d.Progress.TotalSize)
+	}
+
+	d.Progress.markPrefix(size)
+	fmt.Printf("Continuing %s from byte %d\n", d.Filename, size)
+	return nil
+}
+
+// markPrefix records the first n bytes of the file as downloaded
+func (p *Progress) markPrefix(n int64) {
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		downloaded := n - part.Start
+		if downloaded < 0 {
+			downloaded = 0
+		} else if downloaded > part.Size() {
+			downloaded = part.Size()
+		}
+		part.SetDownloaded(downloaded)
+		part.SetDone(downloaded == part.Size())
+	}
+}
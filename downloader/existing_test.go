@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestContinueExistingFile(t *testing.T) {
+	data := testPayload(256 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+	dl.Continue = true
+
+	// A file from another tool that stopped partway into the second part
+	prefix := int64(len(data))*3/8 + 100
+	if err := os.WriteFile(dl.Filename, data[:prefix], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dl.HasProgress() {
+		t.Fatal("HasProgress() without a progress file")
+	}
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatalf("VerifyDownload() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Fatal("continued file does not match")
+	}
+	if served, want := server.bytesServed(), int64(len(data))-prefix; served != want {
+		t.Errorf("server sent %d bytes, want only the %d missing bytes", served, want)
+	}
+}
+
+func TestContinueRejectsLargerFile(t *testing.T) {
+	data := testPayload(128 * 1024)
+	server := newFaultServer(t, data, faultNone)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+	dl.Continue = true
+	if err := os.WriteFile(dl.Filename, append(data, 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dl.LoadOrCreateProgress(); !errors.Is(err, ErrCannotContinue) {
+		t.Fatalf("LoadOrCreateProgress() error = %v, want ErrCannotContinue", err)
+	}
+}
+
+func TestHasProgress(t *testing.T) {
+	dl := newTestDownloader(t, "http://example.com/file.bin", 2)
+	if err := SaveProgress(dl.ProgressFile, CreateNewProgress(dl.URL, dl.Filename, 1024, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if !dl.HasProgress() {
+		t.Error("HasProgress() = false for matching progress")
+	}
+
+	other := newTestDownloader(t, "http://example.com/other.bin", 2)
+	other.ProgressFile = dl.ProgressFile
+	if other.HasProgress() {
+		t.Error("HasProgress() = true for another URL")
+	}
+}
@@ -0,0 +1,169 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fault selects how faultServer misbehaves on range requests
+type fault int
+
+const (
+	faultNone fault = iota
+	// faultReset drops the connection before responding to the first request for each range
+	faultReset
+	// faultTruncate sends half of the first response for each range, then drops the connection
+	faultTruncate
+	// faultSlowDrip sends every body in small chunks with pauses in between
+	faultSlowDrip
+	// faultStaleETag changes the file's ETag after the first range request
+	faultStaleETag
+	// faultFlapRanges ignores the Range header on every other request for a range
+	faultFlapRanges
+	// faultHiddenRanges honours Range headers without advertising Accept-Ranges
+	faultHiddenRanges
+	// faultNoRanges neither advertises nor honours Range headers
+	faultNoRanges
+)
+
+// faultServer serves a fixed payload over HTTP, injecting a fault into GET requests
+type faultServer struct {
+	*httptest.Server
+
+	data  []byte
+	fault fault
+
+	mu       sync.Mutex
+	etag     string
+	attempts map[string]int
+	served   int64
+}
+
+// newFaultServer starts a server for data that injects f
+func newFaultServer(t *testing.T, data []byte, f fault) *faultServer {
+	t.Helper()
+
+	s := &faultServer{
+		data:     data,
+		fault:    f,
+		etag:     `"v1"`,
+		attempts: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// bytesServed returns how many body bytes GET requests have been sent
+func (s *faultServer) bytesServed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.served
+}
+
+func (s *faultServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	etag := s.etag
+	attempt := 0
+	if r.Method == http.MethodGet {
+		attempt = s.attempts[r.Header.Get("Range")]
+		s.attempts[r.Header.Get("Range")]++
+		if s.fault == faultStaleETag {
+			s.etag = `"v2"`
+		}
+	}
+	s.mu.Unlock()
+
+	if s.fault != faultHiddenRanges && s.fault != faultNoRanges {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	w.Header().Set("ETag", etag)
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		return
+	}
+
+	if s.fault == faultReset && attempt == 0 {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	start, end, ok := parseRangeHeader(r.Header.Get("Range"), len(s.data))
+	ignoreRange := (s.fault == faultFlapRanges && attempt%2 == 0) || s.fault == faultNoRanges
+	ifRange := r.Header.Get("If-Range")
+	if !ok || ignoreRange || (ifRange != "" && ifRange != etag) {
+		start, end = 0, int64(len(s.data))-1
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.data)))
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	body := s.data[start : end+1]
+	if s.fault == faultTruncate && attempt == 0 {
+		// Returning early with a short body makes the server close the connection
+		body = body[:len(body)/2]
+	}
+
+	chunk := len(body)
+	if s.fault == faultSlowDrip {
+		chunk = 4 * 1024
+	}
+	for len(body) > 0 {
+		n := chunk
+		if n > len(body) {
+			n = len(body)
+		}
+		written, err := w.Write(body[:n])
+
+		s.mu.Lock()
+		s.served += int64(written)
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+		body = body[n:]
+
+		if s.fault == faultSlowDrip {
+			w.(http.Flusher).Flush()
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+}
+
+// parseRangeHeader parses a single "bytes=start-end" range
+func parseRangeHeader(value string, size int) (int64, int64, bool) {
+	if !strings.HasPrefix(value, "bytes=") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || end >= int64(size) {
+		end = int64(size) - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
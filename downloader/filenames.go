@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"runtime"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxFilenameLength is the longest file name SafeFilename returns: bytes
+// on Unix file systems, UTF-16 code units on Windows
+const MaxFilenameLength = 255
+
+// maxKeptExtension is the longest extension kept when a name is shortened
+const maxKeptExtension = 16
+
+// windowsReserved are the device names Windows refuses as file names, with
+// or without an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SafeFilename makes a name from a server, such as a URL path segment or a
+// Content-Disposition filename, safe to create on this system. It returns
+// "download" when nothing usable is left.
+func SafeFilename(name string) string {
+	if name = safeName(name, runtime.GOOS); name == "" {
+		return "download"
+	}
+	return name
+}
+
+// safeName is SafeFilename for the rules of goos, returning "" for a name
+// with nothing usable. Invalid UTF-8 is replaced, the name is put in NFC
+// form so the same name from macOS and Linux compares equal, separators,
+// control and bidirectional override characters become "_" and the name is
+// shortened to MaxFilenameLength, keeping its extension. Windows also
+// loses the characters it reserves, trailing dots and spaces, and device
+// names such as CON or LPT1.txt get a "_" prefix.
+func safeName(name, goos string) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, "\uFFFD"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, r == 0x7f, r == '/', r == '\\':
+			return '_'
+		case r >= 0x202a && r <= 0x202e, r >= 0x2066 && r <= 0x2069:
+			// Overrides can make evil.exe display as exe.live
+			return '_'
+		case goos == "windows" && strings.ContainsRune(`:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if goos == "windows" {
+		name = strings.TrimRight(name, ". ")
+		base := strings.TrimRight(strings.SplitN(name, ".", 2)[0], " ")
+		if windowsReserved[strings.ToUpper(base)] {
+			name = "_" + name
+		}
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return shortenName(name, goos)
+}
+
+// shortenName cuts name to MaxFilenameLength, keeping a short extension
+func shortenName(name, goos string) string {
+	if nameLength(name, goos) <= MaxFilenameLength {
+		return name
+	}
+	ext := path.Ext(name)
+	if nameLength(ext, goos) > maxKeptExtension {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	limit := MaxFilenameLength - nameLength(ext, goos)
+	for nameLength(stem, goos) > limit {
+		_, size := utf8.DecodeLastRuneInString(stem)
+		stem = stem[:len(stem)-size]
+	}
+	if goos == "windows" {
+		stem = strings.TrimRight(stem, ". ")
+	}
+	return stem + ext
+}
+
+// nameLength is how long the file system of goos counts name to be
+func nameLength(name, goos string) int {
+	if goos == "windows" {
+		return len(utf16.Encode([]rune(name)))
+	}
+	return len(name)
+}
+
+// ContentDispositionFilename returns the file name a Content-Disposition
+// header suggests, without any directories, or "" if it has none. An RFC
+// 5987 filename* in UTF-8 or ISO-8859-1 wins over the plain filename, and
+// a plain filename that is percent-encoded UTF-8, as some servers send it,
+// is decoded. The name still has to pass SafeFilename before it is used.
+func ContentDispositionFilename(header string) string {
+	// The mime package leaves out filename* in other charsets than UTF-8
+	// and gives up on the whole header over one malformed parameter
+	name := ""
+	extended, hasExtended := dispositionParam(header, "filename*")
+	if hasExtended {
+		name, _ = decodeExtValue(extended)
+	}
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(header); err == nil && !hasExtended {
+			name = params["filename"]
+		} else {
+			name, _ = dispositionParam(header, "filename")
+		}
+		if decoded, err := url.PathUnescape(name); err == nil && utf8.ValidString(decoded) && !isASCII(decoded) && isASCII(name) {
+			name = decoded
+		}
+	}
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// dispositionParam finds the value of parameter key in a header, tolerating
+// the unquoted spaces and stray characters servers send
+func dispositionParam(header, key string) (string, bool) {
+	for _, param := range splitParams(header) {
+		name, value, found := cutString(param, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), key) {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(value[1 : len(value)-1])
+		}
+		return value, true
+	}
+	return "", false
+}
+
+// splitParams splits a header at the semicolons outside quoted strings
+func splitParams(header string) []string {
+	var params []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(header); i++ {
+		switch c := header[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			params = append(params, header[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, header[start:])
+}
+
+// decodeExtValue decodes an RFC 5987 value: a charset, a language and the
+// percent-encoded name, such as UTF-8'en'%E2%82%AC.txt
+func decodeExtValue(value string) (string, bool) {
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	raw, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8", "us-ascii":
+		if !utf8.ValidString(raw) {
+			return "", false
+		}
+		return raw, true
+	case "iso-8859-1":
+		decoded, err := charmap.ISO8859_1.NewDecoder().String(raw)
+		return decoded, err == nil
+	}
+	return "", false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func TestContentDispositionFilename(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename="fallback.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A.pdf`, "报告.pdf"},
+		{`attachment; filename*=UTF-8''na%C3%AFve%20file.txt; filename="naive file.txt"`, "naïve file.txt"},
+		{`attachment; filename*=iso-8859-1'de'M%FCller.csv`, "Müller.csv"},
+		{`attachment; filename="%D0%BF%D1%80%D0%B8%D0%B2%D0%B5%D1%82.zip"`, "привет.zip"},
+		{`attachment; filename=plain name.tar.gz`, "plain name.tar.gz"},
+		{`attachment; filename="a;b.txt"`, "a;b.txt"},
+		{`attachment; filename="..\..\windows\evil.dll"`, "evil.dll"},
+		{`attachment; filename*=UTF-8''%FF%FE.bin; filename="ok.bin"`, "ok.bin"},
+		{`inline`, ""},
+	}
+	for _, tt := range tests {
+		if got := ContentDispositionFilename(tt.header); got != tt.want {
+			t.Errorf("ContentDispositionFilename(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name, goos, want string
+	}{
+		{"r\u00e9sum\u00e9.pdf", "linux", "r\u00e9sum\u00e9.pdf"},
+		// NFD from macOS becomes the NFC Linux and Windows use
+		{"re\u0301sume\u0301.pdf", "linux", "r\u00e9sum\u00e9.pdf"},
+		{"a/b\\c.txt", "linux", "a_b_c.txt"},
+		{"what?.txt", "linux", "what?.txt"},
+		{"what?.txt", "windows", "what_.txt"},
+		{"photo\u202egnp.exe", "linux", "photo_gnp.exe"},
+		{"bad\xffbyte.bin", "linux", "bad\ufffdbyte.bin"},
+		{"CON", "windows", "_CON"},
+		{"con.txt", "windows", "_con.txt"},
+		{"Lpt1.tar.gz", "windows", "_Lpt1.tar.gz"},
+		{"CON", "linux", "CON"},
+		{"console.txt", "windows", "console.txt"},
+		{"trailing. . ", "windows", "trailing"},
+		{"..", "linux", ""},
+		{"  ", "windows", ""},
+	}
+	for _, tt := range tests {
+		if got := safeName(tt.name, tt.goos); got != tt.want {
+			t.Errorf("safeName(%q, %s) = %q, want %q", tt.name, tt.goos, got, tt.want)
+		}
+	}
+	if SafeFilename("") != "download" {
+		t.Errorf("SafeFilename(\"\") = %q", SafeFilename(""))
+	}
+}
+
+func TestSafeNameShortensLongNames(t *testing.T) {
+	long := strings.Repeat("日本語", 100) + ".tar.gz"
+
+	unix := safeName(long, "linux")
+	if len(unix) > MaxFilenameLength || !strings.HasSuffix(unix, ".gz") || !strings.HasPrefix(unix, "日本語") {
+		t.Errorf("linux name is %d bytes: %q", len(unix), unix)
+	}
+	windows := safeName(long, "windows")
+	if units := len(utf16.Encode([]rune(windows))); units != MaxFilenameLength || !strings.HasSuffix(windows, ".gz") {
+		t.Errorf("windows name is %d UTF-16 units: %q", units, windows)
+	}
+	// Windows counts UTF-16 units, so it keeps more of a CJK name
+	if len(windows) <= len(unix) {
+		t.Errorf("windows name %d bytes, linux %d", len(windows), len(unix))
+	}
+
+	noExt := safeName(strings.Repeat("x", 300)+"."+strings.Repeat("y", 40), "linux")
+	if len(noExt) != MaxFilenameLength || strings.Contains(noExt, "y") {
+		t.Errorf("overlong extension kept: %q", noExt)
+	}
+}
+
+func TestFilenameFromURL(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/files/%E6%96%87%E4%BB%B6.txt": "文件.txt",
+		"https://example.com/files/a%2Fb.txt":              "a_b.txt",
+		"https://example.com/files/%2E%2E":                 "download",
+		"https://example.com/a/..":                         "download",
+		"https://example.com/a/.":                          "download",
+		"https://example.com/dir/":                         "dir",
+		"https://example.com/café.mp3?x=1":                 "café.mp3",
+	}
+	for rawURL, want := range tests {
+		if got := FilenameFromURL(rawURL); got != want {
+			t.Errorf("FilenameFromURL(%q) = %q, want %q", rawURL, got, want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := map[string]string{
+		"https://bücher.example/ä ö.zip?q=grüße": "https://xn--bcher-kva.example/%C3%A4%20%C3%B6.zip?q=gr%C3%BC%C3%9Fe",
+		"http://user:pw@例え.jp:8080/a":            "http://user:pw@xn--r8jz45g.jp:8080/a",
+		"https://example.com/a%20b?c=%E2%82%AC":  "https://example.com/a%20b?c=%E2%82%AC",
+		"ftp://[::1]/plain":                      "ftp://[::1]/plain",
+	}
+	for raw, want := range tests {
+		if got := NormalizeURL(raw); got != want {
+			t.Errorf("NormalizeURL(%q) = %q, want %q", raw, got, want)
+		}
+	}
+	if d := NewDownloader("https://bücher.example/x", "x", 1); d.URL != "https://xn--bcher-kva.example/x" {
+		t.Errorf("downloader URL = %q", d.URL)
+	}
+}
+
+func TestOutputVarsInternational(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Disposition", `attachment; filename="x"; filename*=UTF-8''%E6%8A%A5%E5%91%8A%3F.pdf`)
+	vars := NewOutputVars("https://www.xn--bcher-kva.example/get", header, nil, time.Now())
+	if vars["domain"] != "bücher.example" || vars["filename"] != "报告?.pdf" {
+		t.Fatalf("vars = %v", vars)
+	}
+	got, err := ExpandOutputTemplate("{domain}/{filename}", vars)
+	if err != nil || got != filepath.FromSlash("bücher.example/报告_.pdf") {
+		t.Errorf("ExpandOutputTemplate() = %q, %v", got, err)
+	}
+	if got, _ := ExpandOutputTemplate("{filename}", OutputVars{"filename": "nul.txt"}); got != "_nul.txt" {
+		t.Errorf("reserved name expanded to %q", got)
+	}
+}
@@ -0,0 +1,39 @@
+package downloader
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// jitterTransport waits a random time up to max before every request, so
+// the requests of a download do not reach the server at regular intervals
+type jitterTransport struct {
+	next http.RoundTripper
+	max  time.Duration
+}
+
+func (t *jitterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(t.max) + 1)))
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-timer.C:
+	}
+	return t.next.RoundTrip(req)
+}
+
+// withJitter puts the requests of client behind the download's Jitter when
+// it is set
+func (d *Downloader) withJitter(client *http.Client) *http.Client {
+	if d.Jitter <= 0 {
+		return client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &jitterTransport{next: next, max: d.Jitter}
+	return client
+}
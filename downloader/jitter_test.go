@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserAgentsRotateAndJitterDelays(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	var mu sync.Mutex
+	agents := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.UserAgent()]++
+		mu.Unlock()
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+	dl.UserAgents = []string{"Agent/1", "Agent/2", "Agent/3"}
+	dl.Jitter = 20 * time.Millisecond
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Fatal("downloaded file differs from the payload")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(agents) != 3 || agents[DefaultUserAgent] != 0 {
+		t.Errorf("requests by User-Agent = %v, want all three agents in turn", agents)
+	}
+}
+
+func TestJitterTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dl := NewDownloader(server.URL, "", 1)
+	dl.Jitter = 30 * time.Millisecond
+	client := dl.withJitter(&http.Client{})
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// Ten waits of up to 30ms each add up to far more than nothing
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("10 requests with up to 30ms of jitter took %v", elapsed)
+	}
+	if plain := NewDownloader(server.URL, "", 1).withJitter(&http.Client{}); plain.Transport != nil {
+		t.Error("a download without jitter wrapped its transport")
+	}
+}
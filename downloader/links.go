@@ -0,0 +1,200 @@
+package downloader
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxExtractedLinks caps how many links a single page may contribute
+	MaxExtractedLinks = 10000
+
+	// maxPageSize limits how much of a page or sitemap is read
+	maxPageSize = 10 * 1024 * 1024
+)
+
+var (
+	linkAttrPattern = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'<>]+))`)
+	baseHrefPattern = regexp.MustCompile(`(?i)<base\b[^>]*\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'<>]+))`)
+)
+
+// sitemap covers both <urlset> and <sitemapindex> documents
+type sitemap struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// ExtractLinks fetches an HTML page or sitemap.xml and returns the absolute
+// links it contains that match pattern (a regular expression, empty matches
+// everything). Links are de-duplicated and returned in document order.
+func ExtractLinks(pageURL, pattern string) ([]string, error) {
+	var matcher *regexp.Regexp
+	if pattern != "" {
+		var err error
+		matcher, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link pattern: %w", err)
+		}
+	}
+
+	body, contentType, err := fetchPage(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page URL: %w", err)
+	}
+
+	var links []string
+	if isSitemap(body, contentType) {
+		links, err = sitemapLinks(body, true)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		links = htmlLinks(body, base)
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+	for _, link := range links {
+		if seen[link] || (matcher != nil && !matcher.MatchString(link)) {
+			continue
+		}
+		seen[link] = true
+		result = append(result, link)
+
+		if len(result) > MaxExtractedLinks {
+			return nil, fmt.Errorf("page contains more than %d matching links", MaxExtractedLinks)
+		}
+	}
+
+	return result, nil
+}
+
+// fetchPage downloads a page body, returning it with its content type
+func fetchPage(pageURL string) (string, string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%w: %s", ErrServerStatus, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read page: %w", err)
+	}
+
+	return string(data), resp.Header.Get("Content-Type"), nil
+}
+
+// isSitemap guesses whether a document is a sitemap rather than HTML
+func isSitemap(body, contentType string) bool {
+	if strings.Contains(contentType, "html") {
+		return false
+	}
+	return strings.Contains(body, "<urlset") || strings.Contains(body, "<sitemapindex")
+}
+
+// sitemapLinks returns the page URLs of a sitemap. For a sitemap index the
+// child sitemaps are fetched one level deep when followIndex is set.
+func sitemapLinks(body string, followIndex bool) ([]string, error) {
+	var doc sitemap
+	if err := xml.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap: %w", err)
+	}
+
+	var links []string
+	for _, u := range doc.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			links = append(links, loc)
+		}
+	}
+
+	if followIndex {
+		for _, sm := range doc.Sitemaps {
+			loc := strings.TrimSpace(sm.Loc)
+			if loc == "" {
+				continue
+			}
+
+			childBody, _, err := fetchPage(loc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch child sitemap %s: %w", loc, err)
+			}
+
+			childLinks, err := sitemapLinks(childBody, false)
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, childLinks...)
+		}
+	}
+
+	return links, nil
+}
+
+// htmlLinks returns every href/src attribute value resolved against the page URL
+func htmlLinks(body string, base *url.URL) []string {
+	if m := baseHrefPattern.FindStringSubmatch(body); m != nil {
+		if baseRef, err := url.Parse(html.UnescapeString(firstNonEmpty(m[1:]...))); err == nil {
+			base = base.ResolveReference(baseRef)
+		}
+	}
+
+	var links []string
+	for _, m := range linkAttrPattern.FindAllStringSubmatch(body, -1) {
+		raw := strings.TrimSpace(html.UnescapeString(firstNonEmpty(m[1:]...)))
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			continue
+		}
+
+		ref, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+
+		resolved := base.ResolveReference(ref)
+		if resolved.Scheme != "http" && resolved.Scheme != "https" {
+			continue
+		}
+		resolved.Fragment = ""
+		links = append(links, resolved.String())
+	}
+	return links
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when another process, or another download in this
+// one, is working on the same output file
+var ErrLocked = errors.New("download is in use by another process")
+
+// errLockHeld is what lockFile returns when the lock is taken
+var errLockHeld = errors.New("lock held")
+
+// LockFile returns the advisory lock file of an output file. It holds the
+// ID of the process working on the output and is removed when it is done.
+func LockFile(output string) string {
+	return output + ".lock"
+}
+
+// acquire takes the lock on the output, or counts one more nested hold of
+// it. Outputs that are not files, such as "-" for stdout, are not locked.
+func (d *Downloader) acquire() error {
+	d.holdMu.Lock()
+	defer d.holdMu.Unlock()
+	if d.holds > 0 {
+		d.holds++
+		return nil
+	}
+	if d.Filename != "" && d.Filename != "-" {
+		file, err := acquireLock(LockFile(d.Filename))
+		// Nothing can be writing an output whose directory is missing
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		d.held = file
+	}
+	d.holds = 1
+	return nil
+}
+
+// release gives up one hold of the lock, unlocking the output after the last
+func (d *Downloader) release() {
+	d.holdMu.Lock()
+	defer d.holdMu.Unlock()
+	if d.holds == 0 {
+		return
+	}
+	if d.holds--; d.holds == 0 && d.held != nil {
+		releaseLock(d.held)
+		d.held = nil
+	}
+}
+
+// acquireLock creates and locks path. A lock file removed by its holder
+// between being opened and locked here is opened again, so two processes
+// never both lock a file that only one of them can see.
+func acquireLock(path string) (*os.File, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(LongPath(path), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			if errors.Is(err, errLockHeld) {
+				return nil, lockedError(path)
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		opened, err1 := file.Stat()
+		current, err2 := os.Stat(LongPath(path))
+		if err1 == nil && err2 == nil && os.SameFile(opened, current) {
+			file.Truncate(0)
+			file.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			return file, nil
+		}
+		unlockFile(file)
+		file.Close()
+	}
+	return nil, lockedError(path)
+}
+
+// releaseLock removes and unlocks a lock file taken by acquireLock
+func releaseLock(file *os.File) {
+	if removeWhileLocked {
+		os.Remove(file.Name())
+	}
+	unlockFile(file)
+	file.Close()
+	if !removeWhileLocked {
+		// Fails while another process has the file open, which is
+		// what keeps its lock valid
+		os.Remove(file.Name())
+	}
+}
+
+// lockedError names the process holding path when the file says
+func lockedError(path string) error {
+	data, _ := os.ReadFile(LongPath(path))
+	if pid := strings.TrimSpace(string(data)); pid != "" {
+		return fmt.Errorf("%w (process %s holds %s)", ErrLocked, pid, path)
+	}
+	return fmt.Errorf("%w (%s is locked)", ErrLocked, path)
+}
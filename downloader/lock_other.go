@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package downloader
+
+import "os"
+
+// removeWhileLocked is set as on Unix; the lock file only records the
+// process here
+const removeWhileLocked = true
+
+// lockFile does nothing where the system has no advisory locks
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// skipWithoutLocks skips a test on systems lockFile cannot lock on
+func skipWithoutLocks(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "windows":
+	default:
+		t.Skipf("no advisory locks on %s", runtime.GOOS)
+	}
+}
+
+func TestLockKeepsSecondDownloaderOut(t *testing.T) {
+	skipWithoutLocks(t)
+	output := filepath.Join(t.TempDir(), "file.bin")
+	first := NewDownloader("http://127.0.0.1:1/file.bin", output, 1)
+	if err := first.acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewDownloader("http://127.0.0.1:1/file.bin", output, 1)
+	second.ProgressFile = output + ".progress"
+	if err := second.LoadOrCreateProgress(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("LoadOrCreateProgress() while locked = %v, want ErrLocked", err)
+	}
+	if err := second.VerifyDownload(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("VerifyDownload() while locked = %v, want ErrLocked", err)
+	}
+
+	// Nested holds keep the lock until the last is released
+	first.acquire()
+	first.release()
+	if err := second.acquire(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("lock released by a nested hold: %v", err)
+	}
+	first.release()
+	if _, err := os.Stat(LockFile(output)); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+	if err := second.acquire(); err != nil {
+		t.Fatalf("acquire() after release = %v", err)
+	}
+	second.release()
+}
+
+func TestLockAcrossProcesses(t *testing.T) {
+	skipWithoutLocks(t)
+	output := filepath.Join(t.TempDir(), "file.bin")
+	cmd := exec.Command(os.Args[0], "-test.run=TestLockHelperProcess")
+	cmd.Env = append(os.Environ(), "MTDL_LOCK_OUTPUT="+output)
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "locked") {
+		t.Fatalf("helper said %q, %v", line, err)
+	}
+
+	d := NewDownloader("http://127.0.0.1:1/file.bin", output, 1)
+	err = d.acquire()
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("acquire() while another process holds the lock = %v", err)
+	}
+	if !strings.Contains(err.Error(), "process "+strings.TrimSpace(strings.TrimPrefix(line, "locked"))) {
+		t.Errorf("error %q does not name the holder", err)
+	}
+
+	// The helper exits, so the system drops its lock
+	stdin.Close()
+	cmd.Wait()
+	if err := d.acquire(); err != nil {
+		t.Fatalf("acquire() after the holder exited = %v", err)
+	}
+	d.release()
+}
+
+// TestLockHelperProcess holds the lock on MTDL_LOCK_OUTPUT for
+// TestLockAcrossProcesses until its stdin is closed
+func TestLockHelperProcess(t *testing.T) {
+	output := os.Getenv("MTDL_LOCK_OUTPUT")
+	if output == "" {
+		t.Skip("helper process")
+	}
+	d := NewDownloader("http://127.0.0.1:1/file.bin", output, 1)
+	if err := d.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("locked " + strconv.Itoa(os.Getpid()) + "\n")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	// Exit without releasing, as a killed download would
+	os.Exit(0)
+}
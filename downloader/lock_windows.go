@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+package downloader
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// removeWhileLocked is unset on Windows, which refuses to remove an open
+// file; the holder removes it after closing it
+const removeWhileLocked = false
+
+// lockRange is the byte range locked in a lock file, far past the process
+// ID written at its start so reading that is not blocked
+var lockRange = windows.Overlapped{Offset: 0xffffffff, OffsetHigh: 0x7fffffff}
+
+// lockFile takes an exclusive LockFileEx lock on file without waiting
+func lockFile(file *os.File) error {
+	ol := lockRange
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	ol := lockRange
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &ol)
+}
@@ -0,0 +1,21 @@
+package downloader
+
+import "strings"
+
+// maxShortPath is the longest Windows path that works without the \\?\
+// prefix: MAX_PATH minus room for an 8.3 file name in a new directory
+const maxShortPath = 247
+
+// extendedPath adds the \\?\ prefix to an absolute Windows path too long for
+// the classic file APIs, or \\?\UNC\ for a path on a share. The prefix turns
+// off the system's own cleaning, so slashes become backslashes here.
+func extendedPath(abs string) string {
+	if len(abs) <= maxShortPath || strings.HasPrefix(abs, `\\?\`) || strings.HasPrefix(abs, `\??\`) {
+		return abs
+	}
+	abs = strings.ReplaceAll(abs, "/", `\`)
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
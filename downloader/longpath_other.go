@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package downloader
+
+// LongPath returns path unchanged; only Windows limits the length of paths
+// its file APIs accept
+func LongPath(path string) string {
+	return path
+}
@@ -0,0 +1,22 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtendedPath(t *testing.T) {
+	long := strings.Repeat("d", 120)
+	tests := map[string]string{
+		`C:\short\file.bin`:                         `C:\short\file.bin`,
+		`C:\` + long + `\` + long + `\file.bin`:     `\\?\C:\` + long + `\` + long + `\file.bin`,
+		`C:/` + long + `/` + long + `/file.bin`:     `\\?\C:\` + long + `\` + long + `\file.bin`,
+		`\\server\share\` + long + `\` + long:       `\\?\UNC\server\share\` + long + `\` + long,
+		`\\?\C:\` + long + `\` + long + `\file.bin`: `\\?\C:\` + long + `\` + long + `\file.bin`,
+	}
+	for path, want := range tests {
+		if got := extendedPath(path); got != want {
+			t.Errorf("extendedPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package downloader
+
+import "path/filepath"
+
+// LongPath returns path in a form Windows opens at any length: absolute,
+// with the \\?\ prefix once it is longer than MAX_PATH allows
+func LongPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return extendedPath(abs)
+}
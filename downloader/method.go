@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Encodings of a request body, as accepted by BodyContentType
+const (
+	BodyForm = "form"
+	BodyJSON = "json"
+)
+
+// BodyContentType returns the Content-Type of a body encoding. An empty
+// encoding is a form, as with curl --data.
+func BodyContentType(encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "", BodyForm:
+		return "application/x-www-form-urlencoded", nil
+	case BodyJSON:
+		return "application/json", nil
+	}
+	return "", fmt.Errorf("unknown body type %q, want %s or %s", encoding, BodyForm, BodyJSON)
+}
+
+// ValidateMethod checks that method can fetch a file; HEAD, CONNECT, OPTIONS
+// and TRACE return no file body
+func ValidateMethod(method string) error {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return nil
+	}
+	return fmt.Errorf("unsupported method %q, want GET, POST, PUT, PATCH or DELETE", method)
+}
+
+// SetRequest makes the download request the file with method and body, the
+// body encoded as BodyForm or BodyJSON. A body without a method is sent with
+// POST, as curl does; neither keeps the plain GET.
+func (d *Downloader) SetRequest(method string, body []byte, encoding string) error {
+	if err := ValidateMethod(method); err != nil {
+		return err
+	}
+	d.Method = strings.ToUpper(method)
+	d.Body = nil
+	d.ContentType = ""
+	if len(body) == 0 {
+		return nil
+	}
+
+	contentType, err := BodyContentType(encoding)
+	if err != nil {
+		return err
+	}
+	if d.Method == "" {
+		d.Method = http.MethodPost
+	}
+	d.Body = body
+	d.ContentType = contentType
+	return nil
+}
+
+// customRequest reports whether the file is fetched with something other
+// than a plain GET. HEAD says nothing about what such a request returns, so
+// the file is probed with the request itself.
+func (d *Downloader) customRequest() bool {
+	return (d.Method != "" && !strings.EqualFold(d.Method, http.MethodGet)) || d.Body != nil
+}
+
+// newFileRequest builds the request that fetches the file: a GET, or the
+// configured method with its body
+func (d *Downloader) newFileRequest(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if d.Body != nil {
+		body = bytes.NewReader(d.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, d.requestMethod(), d.requestURL(), body)
+	if err != nil {
+		return nil, err
+	}
+	if d.Body != nil && d.ContentType != "" {
+		req.Header.Set("Content-Type", d.ContentType)
+	}
+	return req, nil
+}
+
+// probeRequest sends the custom request once to learn the size of the file.
+// The response is kept so its body becomes the start of the download instead
+// of asking an export endpoint to produce the file twice. Ranges are not
+// assumed; a resume still tries one from the saved offset.
+func (d *Downloader) probeRequest() (ProbeResult, error) {
+	fmt.Printf("Requesting %s with %s\n", d.URL, d.requestMethod())
+
+	req, err := d.newRequest(context.Background(), http.MethodGet)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to create %s request: %w", d.requestMethod(), err)
+	}
+	waitForHost(context.Background(), req.URL.Host)
+	resp, err := d.newPartClient().Do(req)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to make %s request: %w", d.requestMethod(), err)
+	}
+
+	if delay, ok := throttleFromResponse(req.URL.Host, resp); ok {
+		resp.Body.Close()
+		return ProbeResult{}, fmt.Errorf("server throttled requests (%s), retry after %s", resp.Status, delay)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return ProbeResult{}, statusError(resp)
+	}
+	if resp.ContentLength < 0 {
+		resp.Body.Close()
+		return ProbeResult{}, fmt.Errorf("server did not provide content length")
+	}
+
+	probe := probeFromResponse(resp)
+	probe.Size = resp.ContentLength
+	d.pending = resp
+	fmt.Printf("File size: %d bytes (%.2f MB)\n", resp.ContentLength, float64(resp.ContentLength)/(1024*1024))
+	return probe, nil
+}
+
+// requestMethod names the method the file is fetched with
+func (d *Downloader) requestMethod() string {
+	if d.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(d.Method)
+}
+
+// takePending returns the response kept by probeRequest if the download
+// continues from offset 0, where its body starts. Otherwise the response is
+// of no use and is closed.
+func (d *Downloader) takePending(offset int64) *http.Response {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+	resp := d.pending
+	d.pending = nil
+	if resp != nil && offset != 0 {
+		resp.Body.Close()
+		return nil
+	}
+	return resp
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newExportServer serves data only to a POST carrying the expected JSON body,
+// the way report export endpoints do. Ranges are honoured but not advertised.
+func newExportServer(t *testing.T, data []byte, body string) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		got, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(got) != body || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "export needs a JSON POST", http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestDownloadWithPostBody(t *testing.T) {
+	const body = `{"report":42}`
+	for _, size := range []int{10 * 1024, 256 * 1024} {
+		data := testPayload(size)
+		server, requests := newExportServer(t, data, body)
+		dl := newTestDownloader(t, server.URL, 4)
+		dl.Method = "post"
+		dl.Body = []byte(body)
+		dl.ContentType, _ = BodyContentType(BodyJSON)
+
+		if err := runDownload(t, dl); err != nil {
+			t.Fatalf("%d bytes: download failed: %v", size, err)
+		}
+		got, err := os.ReadFile(dl.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: downloaded file does not match", size)
+		}
+		// The probe's response is the download
+		if n := atomic.LoadInt32(requests); n != 1 {
+			t.Errorf("%d bytes: server got %d requests, want 1", size, n)
+		}
+		if len(dl.Progress.Parts) != 1 || (!dl.Small() && !dl.Progress.SingleStream) {
+			t.Errorf("%d bytes: %d parts, SingleStream = %v", size, len(dl.Progress.Parts), dl.Progress.SingleStream)
+		}
+	}
+}
+
+func TestPostDownloadResumes(t *testing.T) {
+	const body = `{"report":42}`
+	data := testPayload(256 * 1024)
+	server, requests := newExportServer(t, data, body)
+
+	// A download interrupted halfway through
+	half := int64(len(data) / 2)
+	dl := newTestDownloader(t, server.URL, 4)
+	dl.Method, dl.Body, dl.ContentType = http.MethodPost, []byte(body), "application/json"
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	dl.takePending(-1)
+	if err := os.WriteFile(dl.Filename, data[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl.Progress.Parts[0].SetDownloaded(half)
+	if err := SaveProgress(dl.ProgressFile, dl.Progress); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newTestDownloader(t, server.URL, 4)
+	resumed.Filename, resumed.ProgressFile = dl.Filename, dl.ProgressFile
+	resumed.Method, resumed.Body, resumed.ContentType = dl.Method, dl.Body, dl.ContentType
+	if err := runDownload(t, resumed); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	got, err := os.ReadFile(resumed.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("resumed file does not match")
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("server got %d requests, want the probe and one resume", n)
+	}
+}
+
+func TestBodyContentTypeAndMethod(t *testing.T) {
+	for encoding, want := range map[string]string{"": "application/x-www-form-urlencoded", "form": "application/x-www-form-urlencoded", "JSON": "application/json"} {
+		if got, err := BodyContentType(encoding); err != nil || got != want {
+			t.Errorf("BodyContentType(%q) = %q, %v; want %q", encoding, got, err, want)
+		}
+	}
+	if _, err := BodyContentType("xml"); err == nil {
+		t.Error("BodyContentType accepted an unknown encoding")
+	}
+	if err := ValidateMethod("post"); err != nil {
+		t.Errorf("ValidateMethod(post) = %v", err)
+	}
+	if err := ValidateMethod("HEAD"); err == nil {
+		t.Error("ValidateMethod accepted HEAD")
+	}
+
+	// A body alone is posted, as with curl --data
+	dl := NewDownloader("http://example.com/export", "out", 1)
+	if err := dl.SetRequest("", []byte("id=7"), ""); err != nil || dl.Method != http.MethodPost || !dl.customRequest() {
+		t.Errorf("SetRequest with a body: method %q, error %v", dl.Method, err)
+	}
+	if err := dl.SetRequest("get", nil, ""); err != nil || dl.customRequest() {
+		t.Errorf("SetRequest(get) = %v, custom request %v", err, dl.customRequest())
+	}
+}
@@ -0,0 +1,538 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/secrets"
+)
+
+const (
+	// MaxMirrors is the most mirrors one download may list
+	MaxMirrors = 20
+	// DefaultMirrorCount is how many of the fastest mirrors parts are
+	// spread over when MirrorCount is not set
+	DefaultMirrorCount = 3
+	// DefaultMirrorReprobe is how often mirrors are measured again while a
+	// download runs when MirrorReprobe is not set
+	DefaultMirrorReprobe = time.Minute
+	// MirrorProbeBytes is how much of the file a mirror probe fetches
+	MirrorProbeBytes = 64 * 1024
+	// MirrorProbeTimeout bounds one mirror probe
+	MirrorProbeTimeout = 10 * time.Second
+	// MirrorDemoteRatio demotes mirrors whose probe is slower than this
+	// share of the fastest one's
+	MirrorDemoteRatio = 0.25
+	// mirrorMaxFailures is how many attempts in a row may fail on a mirror
+	// before it is left out until the next probe
+	mirrorMaxFailures = 3
+)
+
+// MirrorStatus describes one of the servers a download's parts come from
+type MirrorStatus struct {
+	URL string
+	// Primary is the download's own URL
+	Primary bool
+	// Latency is how long the last probe waited for the first byte, and
+	// Speed how fast it received MirrorProbeBytes, in bytes per second
+	Latency time.Duration
+	Speed   float64
+	// Active mirrors are among the fastest MirrorCount and get parts
+	Active bool
+	// Demoted mirrors were much slower than the fastest one or failed
+	// repeatedly; they are measured again at the next probe
+	Demoted bool
+	// Unusable mirrors serve another file or no ranges and are dropped
+	Unusable bool
+	// Parts is how many parts are transferring from the mirror, and Bytes
+	// what it sent this run
+	Parts     int
+	Bytes     int64
+	LastError string
+}
+
+// mirror is the state of one server of a mirrorSet
+type mirror struct {
+	MirrorStatus
+	failures int
+	// attempts cancels the part attempts in flight on the mirror by part
+	// index, to move them when it is demoted
+	attempts map[int]context.CancelFunc
+}
+
+// mirrorSet spreads the parts of a download over its fastest servers. It
+// is safe for concurrent use.
+type mirrorSet struct {
+	count int
+
+	mu      sync.Mutex
+	mirrors []*mirror
+}
+
+// ValidateMirrors checks the mirrors an API request lists for url: at most
+// MaxMirrors http or https URLs, none of them url itself
+func ValidateMirrors(url string, mirrors []string) error {
+	if len(mirrors) > MaxMirrors {
+		return fmt.Errorf("mirrors may list at most %d URLs, got %d", MaxMirrors, len(mirrors))
+	}
+	for i, mirror := range mirrors {
+		if !isHTTPURL(mirror) {
+			return fmt.Errorf("mirror %d is not an http or https URL", i+1)
+		}
+		if mirror == url {
+			return fmt.Errorf("mirror %d is the download URL itself", i+1)
+		}
+	}
+	return nil
+}
+
+// duplicateLinks returns the mirrors a response announces in Link headers
+// with rel=duplicate (RFC 6249, Metalink/HTTP), by their pri parameter,
+// lowest first
+func duplicateLinks(resp *http.Response) []string {
+	type link struct {
+		url string
+		pri int
+	}
+	var links []link
+	for _, header := range resp.Header.Values("Link") {
+		for header != "" {
+			open := strings.Index(header, "<")
+			end := strings.Index(header, ">")
+			if open < 0 || end < open {
+				break
+			}
+			target := header[open+1 : end]
+			header = header[end+1:]
+			params := header
+			if next := strings.Index(header, "<"); next >= 0 {
+				params, header = header[:next], header[next:]
+			} else {
+				header = ""
+			}
+
+			l := link{pri: 999999}
+			duplicate := false
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := cutString(strings.Trim(strings.TrimSpace(param), ","), "=")
+				value = strings.Trim(strings.TrimSpace(value), `"`)
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "rel":
+					for _, rel := range strings.Fields(value) {
+						duplicate = duplicate || strings.EqualFold(rel, "duplicate")
+					}
+				case "pri":
+					if pri, err := strconv.Atoi(value); err == nil {
+						l.pri = pri
+					}
+				}
+			}
+			if !duplicate {
+				continue
+			}
+			// Targets may be relative to the response's URL
+			if ref, err := url.Parse(target); err == nil && resp.Request != nil {
+				target = resp.Request.URL.ResolveReference(ref).String()
+			}
+			if isHTTPURL(target) {
+				l.url = target
+				links = append(links, l)
+			}
+		}
+	}
+	sort.SliceStable(links, func(i, j int) bool { return links[i].pri < links[j].pri })
+	urls := make([]string, len(links))
+	for i, l := range links {
+		urls[i] = l.url
+	}
+	return urls
+}
+
+// cutString is strings.Cut, which Go 1.17 does not have
+func cutString(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// mirrorURLs returns the mirrors the download uses: those configured, those
+// a resumed download saved and those the probe found, without URL
+func (d *Downloader) mirrorURLs() []string {
+	lists := [][]string{d.Mirrors, d.discovered}
+	if d.Progress != nil {
+		lists = append(lists, d.Progress.Mirrors)
+	}
+	seen := map[string]bool{d.URL: true}
+	var urls []string
+	for _, list := range lists {
+		for _, link := range list {
+			if !seen[link] {
+				seen[link] = true
+				urls = append(urls, link)
+			}
+		}
+	}
+	if len(urls) > MaxMirrors {
+		urls = urls[:MaxMirrors]
+	}
+	return urls
+}
+
+// usesMirrors reports whether the parts can come from other servers: a
+// file fetched with plain range requests from a single URL
+func (d *Downloader) usesMirrors() bool {
+	return d.Progress != nil && len(d.Progress.Mirrors) > 0 && !d.joined() &&
+		!d.customRequest() && !d.Progress.SingleStream
+}
+
+// startMirrors measures the mirrors once, then again every MirrorReprobe
+// until ctx ends. Without mirrors every part uses URL.
+func (d *Downloader) startMirrors(ctx context.Context) {
+	d.setMirrors(nil)
+	if !d.usesMirrors() {
+		return
+	}
+	count := d.MirrorCount
+	if count <= 0 {
+		count = DefaultMirrorCount
+	}
+	set := &mirrorSet{count: count}
+	set.mirrors = append(set.mirrors, &mirror{MirrorStatus: MirrorStatus{URL: d.URL, Primary: true}})
+	for _, link := range d.Progress.Mirrors {
+		set.mirrors = append(set.mirrors, &mirror{MirrorStatus: MirrorStatus{URL: link}})
+	}
+	d.probeMirrors(ctx, set)
+	d.setMirrors(set)
+
+	interval := d.MirrorReprobe
+	if interval <= 0 {
+		interval = DefaultMirrorReprobe
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.probeMirrors(ctx, set)
+			}
+		}
+	}()
+}
+
+// probeMirrors fetches MirrorProbeBytes from every usable mirror at once
+// and ranks them by how fast that went
+func (d *Downloader) probeMirrors(ctx context.Context, set *mirrorSet) {
+	set.mu.Lock()
+	var candidates []*mirror
+	for _, m := range set.mirrors {
+		if !m.Unusable {
+			candidates = append(candidates, m)
+		}
+	}
+	set.mu.Unlock()
+
+	type result struct {
+		latency time.Duration
+		speed   float64
+		err     error
+	}
+	results := make([]result, len(candidates))
+	var wg sync.WaitGroup
+	for i, m := range candidates {
+		wg.Add(1)
+		go func(i int, link string, primary bool) {
+			defer wg.Done()
+			if primary {
+				link = d.requestURL()
+			}
+			latency, speed, err := d.probeMirror(ctx, link)
+			results[i] = result{latency, speed, err}
+		}(i, m.URL, m.Primary)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	set.mu.Lock()
+	for i, m := range candidates {
+		r := results[i]
+		m.failures = 0
+		if r.err != nil {
+			m.LastError = r.err.Error()
+			m.Speed = 0
+			// The primary defines the file; a failed probe only demotes it
+			m.Unusable = !m.Primary && errors.Is(r.err, errMirrorMismatch)
+			fmt.Printf("Mirror %s: %v\n", secrets.RedactURL(m.URL), r.err)
+			continue
+		}
+		m.Latency, m.Speed, m.LastError = r.latency, r.speed, ""
+	}
+	cancels := set.rank()
+	summary := set.summary()
+	set.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	fmt.Printf("Mirrors: %s\n", summary)
+}
+
+// errMirrorMismatch marks a mirror that does not serve the file in ranges
+var errMirrorMismatch = errors.New("mirror does not serve the same file")
+
+// probeMirror fetches the start of the file from link, returning the time
+// to the first byte and the speed of the whole request
+func (d *Downloader) probeMirror(ctx context.Context, link string) (time.Duration, float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, MirrorProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	d.applyMirrorHeaders(req)
+	end := int64(MirrorProbeBytes)
+	if d.Progress.TotalSize < end {
+		end = d.Progress.TotalSize
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", end-1))
+
+	client := d.client
+	if client == nil {
+		client = d.newPartClient()
+	}
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	latency := time.Since(started)
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, fmt.Errorf("%w: %s to a range request", errMirrorMismatch, resp.Status)
+	}
+	if _, err := validateRangeResponse(resp, 0, end-1, d.Progress.TotalSize); err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", errMirrorMismatch, err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	elapsed := time.Since(started).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1e-6
+	}
+	return latency, float64(n) / elapsed, nil
+}
+
+// rank makes the fastest count mirrors active, demoting those slower than
+// MirrorDemoteRatio of the fastest, and returns the cancel functions of the
+// attempts on mirrors that lost their place. The caller holds mu.
+func (s *mirrorSet) rank() []context.CancelFunc {
+	var usable []*mirror
+	for _, m := range s.mirrors {
+		m.Active = false
+		m.Demoted = !m.Unusable && m.Speed <= 0
+		if !m.Unusable && m.Speed > 0 {
+			usable = append(usable, m)
+		}
+	}
+	sort.SliceStable(usable, func(i, j int) bool { return usable[i].Speed > usable[j].Speed })
+	for i, m := range usable {
+		switch {
+		case m.Speed < usable[0].Speed*MirrorDemoteRatio:
+			m.Demoted = true
+		case i < s.count:
+			m.Active = true
+		}
+	}
+	// Never leave the download without a server
+	if len(usable) == 0 {
+		for _, m := range s.mirrors {
+			if m.Primary {
+				m.Active, m.Demoted = true, false
+			}
+		}
+	}
+
+	var cancels []context.CancelFunc
+	for _, m := range s.mirrors {
+		if !m.Active {
+			for _, cancel := range m.attempts {
+				cancels = append(cancels, cancel)
+			}
+		}
+	}
+	return cancels
+}
+
+// summary lists the active mirrors and their speeds for the log. The
+// caller holds mu.
+func (s *mirrorSet) summary() string {
+	var active []string
+	demoted := 0
+	for _, m := range s.mirrors {
+		if m.Active {
+			active = append(active, fmt.Sprintf("%s (%.0f KB/s, %s)", secrets.RedactURL(m.URL), m.Speed/1024, m.Latency.Round(time.Millisecond)))
+		} else {
+			demoted++
+		}
+	}
+	return fmt.Sprintf("using %s; %d left out", strings.Join(active, ", "), demoted)
+}
+
+// pick chooses the mirror for an attempt of part: the one a plan pinned it
+// to unless that one was dropped, else the active one with the least parts
+// per speed, so faster mirrors get more of them
+func (s *mirrorSet) pick(part int, pinned string, cancel context.CancelFunc) *mirror {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *mirror
+	var bestLoad float64
+	for _, m := range s.mirrors {
+		if pinned != "" && m.URL == pinned && !m.Unusable {
+			best = m
+			break
+		}
+	}
+	if best == nil {
+		for _, m := range s.mirrors {
+			if !m.Active {
+				continue
+			}
+			speed := m.Speed
+			if speed <= 0 {
+				speed = 1
+			}
+			if load := float64(m.Parts+1) / speed; best == nil || load < bestLoad {
+				best, bestLoad = m, load
+			}
+		}
+	}
+	if best == nil {
+		best = s.mirrors[0]
+	}
+	best.Parts++
+	if best.attempts == nil {
+		best.attempts = map[int]context.CancelFunc{}
+	}
+	best.attempts[part] = cancel
+	return best
+}
+
+// done ends an attempt of part on m that received bytes. err is the reason
+// it failed, if it did; mismatch is set when the mirror sent something
+// other than the range of the file.
+func (s *mirrorSet) done(m *mirror, part int, bytes int64, err error, mismatch bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.Parts--
+	delete(m.attempts, part)
+	m.Bytes += bytes
+	switch {
+	case err == nil || bytes > 0:
+		m.failures = 0
+	case mismatch && !m.Primary:
+		m.Unusable, m.Active, m.LastError = true, false, err.Error()
+		fmt.Printf("Dropping mirror %s: %v\n", secrets.RedactURL(m.URL), err)
+		s.rank()
+	default:
+		m.failures++
+		m.LastError = err.Error()
+		if m.failures >= mirrorMaxFailures && m.Active {
+			m.Speed = 0
+			fmt.Printf("Demoting mirror %s after %d failed attempts: %v\n", secrets.RedactURL(m.URL), m.failures, err)
+			s.rank()
+		}
+	}
+}
+
+// serving returns the server each part with a request in flight is
+// fetched from, by part index; nil without mirrors
+func (s *mirrorSet) serving() map[int]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	serving := make(map[int]string)
+	for _, m := range s.mirrors {
+		for part := range m.attempts {
+			serving[part] = m.URL
+		}
+	}
+	return serving
+}
+
+// MirrorStatuses returns the servers the running download's parts come from,
+// starting with its own URL; nil when it uses only that
+func (d *Downloader) MirrorStatuses() []MirrorStatus {
+	set := d.mirrorSet()
+	if set == nil {
+		return nil
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	statuses := make([]MirrorStatus, len(set.mirrors))
+	for i, m := range set.mirrors {
+		statuses[i] = m.MirrorStatus
+	}
+	return statuses
+}
+
+// mirrorSet returns the mirrors of the running download, or nil
+func (d *Downloader) mirrorSet() *mirrorSet {
+	d.mirrorsMu.Lock()
+	defer d.mirrorsMu.Unlock()
+	return d.mirrors
+}
+
+func (d *Downloader) setMirrors(set *mirrorSet) {
+	d.mirrorsMu.Lock()
+	d.mirrors = set
+	d.mirrorsMu.Unlock()
+}
+
+// applyMirrorHeaders sets the headers of the download on a request to a
+// mirror. The extra Headers, which may hold credentials, only go to the
+// host of URL; cookies go where the jar sends them.
+func (d *Downloader) applyMirrorHeaders(req *http.Request) {
+	if primary, err := url.Parse(d.requestURL()); err == nil && strings.EqualFold(primary.Host, req.URL.Host) {
+		d.applyHeaders(req)
+		return
+	}
+	d.applyIdentity(req)
+	d.applyCookies(req)
+}
+
+// mirrorRequest returns the part request req, built for URL, pointed at m.
+// Validators of URL do not hold for another server, so If-Range is dropped.
+func (d *Downloader) mirrorRequest(req *http.Request, m *mirror) (*http.Request, error) {
+	if m.Primary {
+		return req, nil
+	}
+	mirrored, err := http.NewRequestWithContext(req.Context(), req.Method, m.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.applyMirrorHeaders(mirrored)
+	for _, name := range []string{"Range", "Priority", "Want-Content-Digest"} {
+		if value := req.Header.Get(name); value != "" {
+			mirrored.Header.Set(name, value)
+		}
+	}
+	return mirrored, nil
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirroredDownload(t *testing.T) {
+	payload := testPayload(8 * SmallFileSize)
+	var primaryParts, mirrorParts, otherParts int64
+	serve := func(counter *int64, content []byte, mirror bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if mirror && r.Header.Get("Authorization") != "" {
+				t.Errorf("credentials sent to mirror %s", r.Host)
+			}
+			if r.Method == http.MethodGet && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+				atomic.AddInt64(counter, 1)
+			}
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+		}
+	}
+	mirror := httptest.NewServer(serve(&mirrorParts, payload, true))
+	defer mirror.Close()
+	// A mirror holding another version of the file is dropped
+	other := httptest.NewServer(serve(&otherParts, payload[:len(payload)-1], true))
+	defer other.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "<"+mirror.URL+"/file.bin>; rel=duplicate; pri=1")
+		w.Header().Add("Link", `</file.bin.meta4>; rel=describedby; type="application/metalink4+xml"`)
+		serve(&primaryParts, payload, false)(w, r)
+	}))
+	defer primary.Close()
+
+	dl := newTestDownloader(t, primary.URL+"/file.bin", 8)
+	dl.MinPartSize = 0
+	dl.Headers = map[string]string{"Authorization": "Bearer secret"}
+	dl.Mirrors = []string{other.URL + "/file.bin"}
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{other.URL + "/file.bin", mirror.URL + "/file.bin"}; !reflect.DeepEqual(dl.Progress.Mirrors, want) {
+		t.Errorf("Progress.Mirrors = %v, want %v", dl.Progress.Mirrors, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dl.DownloadContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, payload) {
+		t.Error("mirrored file does not match")
+	}
+	if atomic.LoadInt64(&mirrorParts) == 0 {
+		t.Error("no part came from the mirror")
+	}
+	if atomic.LoadInt64(&otherParts) != 0 {
+		t.Error("parts came from a mirror of another file")
+	}
+	statuses := dl.MirrorStatuses()
+	if len(statuses) != 3 || !statuses[0].Primary || !statuses[1].Unusable || statuses[2].Bytes == 0 {
+		t.Errorf("MirrorStatuses() = %+v", statuses)
+	}
+}
+
+func TestMirrorRanking(t *testing.T) {
+	set := &mirrorSet{count: 2}
+	for _, speed := range []float64{100, 1000, 900, 10} {
+		set.mirrors = append(set.mirrors, &mirror{MirrorStatus: MirrorStatus{Speed: speed}})
+	}
+	set.mirrors[0].Primary = true
+	set.rank()
+	var active, demoted []bool
+	for _, m := range set.mirrors {
+		active = append(active, m.Active)
+		demoted = append(demoted, m.Demoted)
+	}
+	if !reflect.DeepEqual(active, []bool{false, true, true, false}) || !reflect.DeepEqual(demoted, []bool{true, false, false, true}) {
+		t.Errorf("active %v, demoted %v", active, demoted)
+	}
+
+	// Faster mirrors take more parts
+	picked := map[*mirror]int{}
+	for i := 0; i < 19; i++ {
+		picked[set.pick(i, "", func() {})]++
+	}
+	if picked[set.mirrors[1]] != 10 || picked[set.mirrors[2]] != 9 {
+		t.Errorf("picked %d and %d", picked[set.mirrors[1]], picked[set.mirrors[2]])
+	}
+
+	// A failing mirror is demoted; the primary is still too slow to move up
+	for i := 0; i < mirrorMaxFailures; i++ {
+		set.done(set.mirrors[1], i, 0, context.DeadlineExceeded, false)
+	}
+	if set.mirrors[1].Active || !set.mirrors[2].Active || set.mirrors[0].Active {
+		t.Errorf("after failures: %+v %+v %+v", set.mirrors[0].MirrorStatus, set.mirrors[1].MirrorStatus, set.mirrors[2].MirrorStatus)
+	}
+
+	// Attempts on a mirror that lost its place are cancelled
+	cancelled := false
+	set.mirrors[2].attempts[200] = func() { cancelled = true }
+	set.mirrors[2].Speed = 1
+	for _, cancel := range set.rank() {
+		cancel()
+	}
+	if !cancelled {
+		t.Error("attempt on a demoted mirror kept running")
+	}
+}
+
+func TestDuplicateLinks(t *testing.T) {
+	req := httptest.NewRequest(http.MethodHead, "https://example.com/dir/file.iso", nil)
+	resp := &http.Response{Header: http.Header{}, Request: req}
+	resp.Header.Add("Link", `<https://b.example.net/file.iso>; rel=duplicate; pri=2; geo=de, <https://a.example.org/file.iso>; rel="duplicate"; pri=1`)
+	resp.Header.Add("Link", `</mirror/file.iso>; rel=duplicate, <https://example.com/file.iso.meta4>; rel=describedby`)
+	want := []string{"https://a.example.org/file.iso", "https://b.example.net/file.iso", "https://example.com/mirror/file.iso"}
+	if got := duplicateLinks(resp); !reflect.DeepEqual(got, want) {
+		t.Errorf("duplicateLinks() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateMirrors(t *testing.T) {
+	if err := ValidateMirrors("https://example.com/a", []string{"https://mirror.example.com/a"}); err != nil {
+		t.Error(err)
+	}
+	for _, mirrors := range [][]string{{"ftp://example.com/a"}, {"https://example.com/a"}} {
+		if err := ValidateMirrors("https://example.com/a", mirrors); err == nil {
+			t.Errorf("accepted %v", mirrors)
+		}
+	}
+}
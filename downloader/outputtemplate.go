@@ -0,0 +1,260 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrUnknownTemplateVar is returned for an output template variable that has
+// no value
+var ErrUnknownTemplateVar = errors.New("unknown output template variable")
+
+// OutputVars are the values {name} variables in an output template expand to
+type OutputVars map[string]string
+
+// HasOutputTemplate reports whether an output path contains {name} variables
+func HasOutputTemplate(output string) bool {
+	for _, v := range outputTemplateVars(output) {
+		if v.name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// NewOutputVars collects the variables for a download of rawURL started at
+// now. header is the response to the download URL, if it was fetched; meta
+// adds request metadata such as the download ID and may override the rest.
+//
+//	{date} {year} {month} {day} {time}  start time, e.g. 2024-05-01 and 153000
+//	{domain}   host without port or leading "www."; {host} keeps "www."
+//	           Punycode hosts are shown in Unicode.
+//	{path}     directory of the URL path
+//	{filename} Content-Disposition filename, else the last URL path segment
+//	{name} {ext}  filename without and only its extension
+//	{type}     top-level Content-Type, e.g. video
+func NewOutputVars(rawURL string, header http.Header, meta map[string]string, now time.Time) OutputVars {
+	vars := OutputVars{
+		"date":  now.Format("2006-01-02"),
+		"year":  now.Format("2006"),
+		"month": now.Format("01"),
+		"day":   now.Format("02"),
+		"time":  now.Format("150405"),
+		"type":  "unknown",
+	}
+
+	if parsed, err := url.Parse(rawURL); err == nil {
+		host := DisplayHost(parsed.Hostname())
+		vars["host"] = host
+		vars["domain"] = strings.TrimPrefix(host, "www.")
+		vars["path"] = strings.Trim(path.Dir(parsed.Path), "/")
+	}
+
+	filename := FilenameFromURL(rawURL)
+	if header != nil {
+		if name := ContentDispositionFilename(header.Get("Content-Disposition")); name != "" {
+			filename = name
+		}
+		if mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+			vars["type"] = strings.SplitN(mediaType, "/", 2)[0]
+		}
+	}
+	vars["filename"] = filename
+	ext := path.Ext(filename)
+	vars["name"] = strings.TrimSuffix(filename, ext)
+	vars["ext"] = strings.TrimPrefix(ext, ".")
+
+	for name, value := range meta {
+		vars[name] = value
+	}
+	return vars
+}
+
+// ExpandOutputTemplate replaces every {name} in template with its value.
+// Values cannot add directories except {path}, whose segments are cleaned
+// one by one, so a server's response can never move the file outside the
+// directories the template names.
+func ExpandOutputTemplate(template string, vars OutputVars) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, v := range outputTemplateVars(template) {
+		if v.name == "" {
+			continue
+		}
+		value, ok := vars[v.name]
+		if !ok {
+			return "", fmt.Errorf("%w {%s}", ErrUnknownTemplateVar, v.name)
+		}
+		if v.name == "path" {
+			value = cleanPathValue(value)
+		} else {
+			value = cleanPathSegment(value)
+		}
+		b.WriteString(template[last:v.start])
+		b.WriteString(value)
+		last = v.end
+	}
+	b.WriteString(template[last:])
+	return filepath.Clean(b.String()), nil
+}
+
+// IsLocalPath reports whether p is a relative path that stays inside the
+// directory it is resolved against
+func IsLocalPath(p string) bool {
+	if p == "" || filepath.IsAbs(p) || strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) {
+		return false
+	}
+	for _, segment := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveOutputTemplate expands a template in the downloader's Filename. The
+// response headers are fetched only when the template needs them; if that
+// fails, the variables fall back to what the URL provides. meta adds request
+// metadata such as the download ID. The caller creates the directories the
+// expanded path names.
+func (d *Downloader) ResolveOutputTemplate(meta map[string]string) error {
+	if !HasOutputTemplate(d.Filename) {
+		return nil
+	}
+
+	var header http.Header
+	if needsResponseHeader(d.Filename) {
+		header, _ = d.ResponseHeader()
+	}
+	output, err := ExpandOutputTemplate(d.Filename, NewOutputVars(d.URL, header, meta, time.Now()))
+	if err != nil {
+		return err
+	}
+	d.Filename = output
+	return nil
+}
+
+// ResponseHeader returns the headers the server sends for the download URL
+func (d *Downloader) ResponseHeader() (http.Header, error) {
+	client := d.withTransport(&http.Client{Timeout: 30 * time.Second})
+
+	req, err := d.newRequest(context.Background(), "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HEAD request: %w", err)
+	}
+	waitForHost(context.Background(), req.URL.Host)
+	resp, err := client.Do(req)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		resp.Body.Close()
+		return resp.Header, nil
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	// Some servers refuse HEAD; ask for a single byte instead
+	req, err = d.newRequest(context.Background(), "GET")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch response headers: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("%w: %s", ErrServerStatus, resp.Status)
+	}
+	return resp.Header, nil
+}
+
+// outputVar is one {...} group in an output template; name is empty for
+// groups that are not a variable name
+type outputVar struct {
+	start, end int
+	name       string
+}
+
+func outputTemplateVars(template string) []outputVar {
+	var vars []outputVar
+	for pos := 0; pos < len(template); {
+		open := strings.IndexByte(template[pos:], '{')
+		if open < 0 {
+			break
+		}
+		open += pos
+		close := strings.IndexByte(template[open:], '}')
+		if close < 0 {
+			break
+		}
+		close += open
+
+		v := outputVar{start: open, end: close + 1}
+		if body := template[open+1 : close]; isTemplateVarName(body) {
+			v.name = body
+		}
+		vars = append(vars, v)
+		pos = close + 1
+	}
+	return vars
+}
+
+func isTemplateVarName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// needsResponseHeader reports whether the template uses a variable taken
+// from the response
+func needsResponseHeader(template string) bool {
+	for _, v := range outputTemplateVars(template) {
+		switch v.name {
+		case "filename", "name", "ext", "type":
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPathSegment makes value safe to use as a single file or directory
+// name. It follows the Windows rules everywhere, so a template expands to
+// the same path on every system.
+func cleanPathSegment(value string) string {
+	value = safeName(strings.TrimLeft(strings.TrimSpace(value), "."), "windows")
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// cleanPathValue cleans every segment of a slash separated path, dropping
+// empty, "." and ".." segments
+func cleanPathValue(value string) string {
+	var segments []string
+	for _, segment := range strings.Split(value, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, cleanPathSegment(segment))
+	}
+	if len(segments) == 0 {
+		return "."
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandOutputTemplate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)
+	header := http.Header{}
+	header.Set("Content-Disposition", `attachment; filename="Report Q1.pdf"`)
+	header.Set("Content-Type", "application/pdf")
+	vars := NewOutputVars("https://www.example.com:8443/files/2024/get?id=7", header, map[string]string{"id": "abc"}, now)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{date}/{domain}/{filename}", "2024-05-01/example.com/Report Q1.pdf"},
+		{"{host}/{path}/{name}-{id}.{ext}", "www.example.com/files/2024/Report Q1-abc.pdf"},
+		{"{year}/{month}/{day}/{time}_{type}", "2024/05/01/153000_application"},
+		{"downloads/{a,b}/{filename}", "downloads/{a,b}/Report Q1.pdf"},
+		{"plain.bin", "plain.bin"},
+	}
+	for _, tt := range tests {
+		got, err := ExpandOutputTemplate(tt.template, vars)
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("ExpandOutputTemplate(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+		}
+	}
+
+	if _, err := ExpandOutputTemplate("{nope}/{filename}", vars); !errors.Is(err, ErrUnknownTemplateVar) {
+		t.Errorf("unknown variable error = %v", err)
+	}
+}
+
+func TestOutputTemplateValuesStayInPlace(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Disposition", `attachment; filename="../../etc/passwd"`)
+	vars := NewOutputVars("https://example.com/a/../../b/file", header, nil, time.Now())
+
+	got, err := ExpandOutputTemplate("out/{path}/{filename}", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLocalPath(got) {
+		t.Fatalf("expanded to %q, outside the output directory", got)
+	}
+
+	for p, want := range map[string]bool{
+		"a/b.txt":       true,
+		"a/../b.txt":    false,
+		"/etc/passwd":   false,
+		"../escape.txt": false,
+		"":              false,
+	} {
+		if IsLocalPath(p) != want {
+			t.Errorf("IsLocalPath(%q) = %v, want %v", p, !want, want)
+		}
+	}
+}
+
+func TestResolveOutputTemplate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="video.mp4"`)
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(server.URL+"/watch?v=1", filepath.Join(dir, "{type}", "{id}_{filename}"), 1)
+	if err := d.ResolveOutputTemplate(map[string]string{"id": "job1"}); err != nil {
+		t.Fatalf("ResolveOutputTemplate() error = %v", err)
+	}
+	if want := filepath.Join(dir, "video", "job1_video.mp4"); d.Filename != want {
+		t.Fatalf("Filename = %q, want %q", d.Filename, want)
+	}
+	if !HasOutputTemplate("{date}/x") || HasOutputTemplate("img_{1..3}.jpg") {
+		t.Error("HasOutputTemplate misdetected a template")
+	}
+}
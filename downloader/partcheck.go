@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultChecksumRetries is how often a new downloader fetches the corrupt
+// parts of a file that failed its checksum again
+const DefaultChecksumRetries = 2
+
+// ReceivedDigest is the source of a part digest taken as the bytes arrived
+// rather than sent by the server
+const ReceivedDigest = "received"
+
+// resetCorruptParts marks the parts to blame for a checksum mismatch as not
+// downloaded, so that only they are fetched again. It returns an error when
+// no part can be blamed.
+func (d *Downloader) resetCorruptParts(mismatch error, retry int) error {
+	parts, err := d.corruptParts()
+	if err != nil {
+		return fmt.Errorf("%v; failed to check parts: %w", mismatch, err)
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w; every part matches the checksum the server sent for its range", mismatch)
+	}
+
+	fmt.Printf("Checksum mismatch, downloading %d of %d parts again (retry %d of %d)\n",
+		len(parts), len(d.Progress.Parts), retry, d.ChecksumRetries)
+	for _, i := range parts {
+		d.Progress.Parts[i].Reset()
+	}
+	d.Progress.Checksum = nil
+	if !d.small {
+		SaveProgress(d.ProgressFile, d.Progress)
+	}
+	return nil
+}
+
+// corruptParts returns the indexes of the parts to download again after the
+// file failed its checksum. A part whose bytes on disk no longer match its
+// digest is corrupt. When every part still matches, the ones the server did
+// not vouch for with a checksum of their range are suspect instead: their
+// bytes are as they arrived, but nothing shows they left the server that
+// way. Parts vouched for by the server are never fetched again. After a
+// piece check, the parts under the failed pieces are corrupt.
+func (d *Downloader) corruptParts() ([]int, error) {
+	if len(d.badPieces) > 0 {
+		return d.pieceParts(), nil
+	}
+	var corrupt, suspect []int
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		digest := part.Digest()
+		if digest == nil {
+			suspect = append(suspect, i)
+			continue
+		}
+
+		sum, err := d.hashPart(part, digest.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(sum, digest.Value) {
+			fmt.Printf("Part %d does not match its %s checksum from %s\n", part.Index, digest.Algorithm, digest.Source)
+			corrupt = append(corrupt, i)
+		} else if digest.Source == ReceivedDigest {
+			suspect = append(suspect, i)
+		}
+	}
+
+	if len(corrupt) > 0 {
+		return corrupt, nil
+	}
+	return suspect, nil
+}
+
+// hashPart hashes the bytes of a part as they are in the output file
+func (d *Downloader) hashPart(part *Part, algorithm string) ([]byte, error) {
+	h := newDigestHash(algorithm)
+	if h == nil {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	file, err := os.Open(LongPath(d.Progress.Filename))
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for checksum: %w", err)
+	}
+	defer file.Close()
+
+	var content io.ReadSeeker = file
+	if d.Progress.Encrypted {
+		reader, err := NewDecryptingReader(file, d.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting file for checksum: %w", err)
+		}
+		content = reader
+	}
+
+	if _, err := content.Seek(part.Start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error reading part %d: %w", part.Index, err)
+	}
+	if _, err := io.Copy(h, io.LimitReader(content, part.Size())); err != nil {
+		return nil, fmt.Errorf("error reading part %d: %w", part.Index, err)
+	}
+	return h.Sum(nil), nil
+}
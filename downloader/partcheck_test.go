@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newCorruptingServer serves data with a Repr-Digest of the whole file and a
+// Content-Digest of every range, but flips a byte of the first response for
+// the range starting at corruptAt, as a faulty cache in between would
+func newCorruptingServer(t *testing.T, data []byte, corruptAt int64) (*httptest.Server, *int64) {
+	t.Helper()
+
+	whole := sha256.Sum256(data)
+	var served int64
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(whole[:])+":")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+
+		start, end, ok := parseRangeHeader(r.Header.Get("Range"), len(data))
+		if !ok {
+			http.Error(w, "range required", http.StatusBadRequest)
+			return
+		}
+		body := data[start : end+1]
+		sum := sha256.Sum256(body)
+		if start == corruptAt {
+			once.Do(func() {
+				body = append([]byte(nil), body...)
+				body[len(body)/2] ^= 0xff
+			})
+		}
+
+		w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		n, _ := w.Write(body)
+		atomic.AddInt64(&served, int64(n))
+	}))
+	t.Cleanup(server.Close)
+	return server, &served
+}
+
+func TestChecksumMismatchRefetchesCorruptPart(t *testing.T) {
+	data := testPayload(256 * 1024)
+	partSize := int64(len(data) / 4)
+	server, served := newCorruptingServer(t, data, 2*partSize)
+	dl := newTestDownloader(t, server.URL, 4)
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatalf("VerifyDownload() error = %v", err)
+	}
+	if dl.Progress.Checksum == nil || dl.Progress.Checksum.Status != ChecksumVerified {
+		t.Errorf("Checksum = %+v, want verified", dl.Progress.Checksum)
+	}
+	// Only the corrupt part was sent twice
+	if got, want := atomic.LoadInt64(served), int64(len(data))+partSize; got != want {
+		t.Errorf("server sent %d bytes, want %d", got, want)
+	}
+}
+
+func TestCorruptPartsFindsChangedPart(t *testing.T) {
+	data := testPayload(256 * 1024)
+	dl := newTestDownloader(t, newFaultServer(t, data, faultNone).URL, 4)
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	for i := range dl.Progress.Parts {
+		if digest := dl.Progress.Parts[i].Digest(); digest == nil || digest.Source != ReceivedDigest {
+			t.Fatalf("part %d digest = %+v, want one taken on arrival", i, digest)
+		}
+	}
+
+	// Damage the file on disk inside the second part
+	file, err := os.OpenFile(dl.Filename, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0}, dl.Progress.Parts[1].Start+10); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	parts, err := dl.corruptParts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0] != 1 {
+		t.Errorf("corruptParts() = %v, want [1]", parts)
+	}
+}
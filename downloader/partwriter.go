@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+)
+
+// partWriter stores the bytes of one part. write reports how many bytes are
+// safely on disk, which may lag behind what was passed in while data is
+// buffered; only those bytes may be counted as downloaded.
+type partWriter interface {
+	write(p []byte) (int64, error)
+	// Sync flushes the bytes written so far to disk
+	Sync() error
+	Close() error
+}
+
+// openPartWriter opens the output file for writing a part from plaintext offset start
+func (d *Downloader) openPartWriter(start int64) (partWriter, error) {
+	file, err := os.OpenFile(LongPath(d.Filename), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.cipher != nil {
+		if start%EncryptedChunkSize != 0 {
+			file.Close()
+			return nil, fmt.Errorf("offset %d is not aligned to the encryption chunk size", start)
+		}
+		return d.withWriteFault(&encryptedPartWriter{file: file, cipher: d.cipher, next: start}), nil
+	}
+
+	if _, err := file.Seek(start, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return d.withWriteFault(&filePartWriter{file: file}), nil
+}
+
+// withWriteFault puts w behind WriteFault when it is set
+func (d *Downloader) withWriteFault(w partWriter) partWriter {
+	if d.WriteFault == nil {
+		return w
+	}
+	return &faultyPartWriter{partWriter: w, fault: d.WriteFault}
+}
+
+// faultyPartWriter fails the writes its fault refuses
+type faultyPartWriter struct {
+	partWriter
+	fault func(n int) error
+}
+
+func (w *faultyPartWriter) write(p []byte) (int64, error) {
+	if err := w.fault(len(p)); err != nil {
+		return 0, err
+	}
+	return w.partWriter.write(p)
+}
+
+// filePartWriter writes plaintext straight to the output file
+type filePartWriter struct {
+	file *os.File
+}
+
+func (w *filePartWriter) write(p []byte) (int64, error) {
+	n, err := w.file.Write(p)
+	return int64(n), err
+}
+
+func (w *filePartWriter) Sync() error {
+	return w.file.Sync()
+}
+
+func (w *filePartWriter) Close() error {
+	return w.file.Close()
+}
+
+// encryptedPartWriter buffers plaintext until a whole chunk is available,
+// then seals it and writes it at the chunk's position in the encrypted file.
+// A partly filled chunk is dropped on Close and downloaded again on retry.
+type encryptedPartWriter struct {
+	file   *os.File
+	cipher *fileCipher
+	next   int64 // plaintext offset of the first buffered byte
+	buf    []byte
+}
+
+func (w *encryptedPartWriter) write(p []byte) (int64, error) {
+	w.buf = append(w.buf, p...)
+
+	var committed int64
+	for {
+		index := w.next / EncryptedChunkSize
+		chunkLen := w.cipher.chunkLen(index)
+		if chunkLen <= 0 || int64(len(w.buf)) < chunkLen {
+			return committed, nil
+		}
+
+		sealed, err := w.cipher.seal(index, w.buf[:chunkLen])
+		if err != nil {
+			return committed, err
+		}
+		if _, err := w.file.WriteAt(sealed, w.cipher.chunkOffset(index)); err != nil {
+			return committed, err
+		}
+
+		w.buf = append(w.buf[:0], w.buf[chunkLen:]...)
+		w.next += chunkLen
+		committed += chunkLen
+	}
+}
+
+func (w *encryptedPartWriter) Sync() error {
+	return w.file.Sync()
+}
+
+func (w *encryptedPartWriter) Close() error {
+	w.buf = nil
+	return w.file.Close()
+}
@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PieceHashes are the SHA-1 hashes of the consecutive pieces of a file, as
+// a torrent lists them. A file downloaded with them is checked piece by
+// piece, and only the parts under pieces that fail are fetched again.
+type PieceHashes struct {
+	// Length is the size of every piece but the last, which may be shorter
+	Length int64
+	// Size is the size of the whole file
+	Size int64
+	SHA1 [][]byte
+	// Source names where the hashes came from, e.g. "torrent"
+	Source string
+
+	// Ends, when set, are where each piece ends, for pieces of different
+	// sizes; Length is then unused
+	Ends []int64
+	// Match, when set, checks piece i in place of its SHA-1, for pieces
+	// hashed in another way, and Algorithm names that way
+	Match     func(i int, piece []byte) bool
+	Algorithm string
+}
+
+// Count returns the number of pieces
+func (p *PieceHashes) Count() int {
+	if p.Ends != nil {
+		return len(p.Ends)
+	}
+	return len(p.SHA1)
+}
+
+// bounds returns where piece i starts and ends, exclusive
+func (p *PieceHashes) bounds(i int) (int64, int64) {
+	if p.Ends != nil {
+		var start int64
+		if i > 0 {
+			start = p.Ends[i-1]
+		}
+		return start, p.Ends[i]
+	}
+	start, end := int64(i)*p.Length, int64(i+1)*p.Length
+	if end > p.Size {
+		end = p.Size
+	}
+	return start, end
+}
+
+// algorithm names how the pieces are hashed
+func (p *PieceHashes) algorithm() string {
+	if p.Match != nil && p.Algorithm != "" {
+		return p.Algorithm
+	}
+	return "sha-1"
+}
+
+// ReadPieces checks the pieces of the file read from r and returns the
+// indexes of those that do not match
+func (p *PieceHashes) ReadPieces(r io.Reader) ([]int, error) {
+	var bad []int
+	var buf []byte
+	for i := 0; i < p.Count(); i++ {
+		start, end := p.bounds(i)
+		if size := int(end - start); cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		piece := buf[:end-start]
+		if _, err := io.ReadFull(r, piece); err != nil {
+			return nil, fmt.Errorf("error reading piece %d: %w", i, err)
+		}
+		if p.Match != nil {
+			if !p.Match(i, piece) {
+				bad = append(bad, i)
+			}
+			continue
+		}
+		if sum := sha1.Sum(piece); !bytes.Equal(sum[:], p.SHA1[i]) {
+			bad = append(bad, i)
+		}
+	}
+	return bad, nil
+}
+
+// verifyPieces checks the finished file against Pieces. The parts under
+// the pieces that fail are kept for corruptParts to fetch again.
+func (d *Downloader) verifyPieces() error {
+	d.badPieces = nil
+	if d.Pieces == nil || d.piecesVerified {
+		return nil
+	}
+
+	file, err := os.Open(LongPath(d.Progress.Filename))
+	if err != nil {
+		return fmt.Errorf("error opening file for checksum: %w", err)
+	}
+	defer file.Close()
+
+	var content io.Reader = file
+	if d.Progress.Encrypted {
+		reader, err := NewDecryptingReader(file, d.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("error decrypting file for checksum: %w", err)
+		}
+		content = reader
+	}
+
+	bad, err := d.Pieces.ReadPieces(content)
+	if err != nil {
+		return err
+	}
+	if len(bad) > 0 {
+		d.badPieces = bad
+		d.Progress.Checksum = &Checksum{Status: ChecksumMismatch, Algorithm: d.Pieces.algorithm(), Source: d.Pieces.Source}
+		return fmt.Errorf("%w: %d of %d pieces from the %s do not match", ErrChecksumMismatch, len(bad), d.Pieces.Count(), d.Pieces.Source)
+	}
+	d.piecesVerified = true
+	fmt.Printf("Pieces verified: %d %s hashes from the %s\n", d.Pieces.Count(), strings.ToUpper(d.Pieces.algorithm()), d.Pieces.Source)
+	if len(d.Progress.Digests) == 0 {
+		// The server's own digests, if any, are reported instead
+		d.Progress.Checksum = &Checksum{Status: ChecksumVerified, Algorithm: d.Pieces.algorithm(), Source: d.Pieces.Source}
+	}
+	return nil
+}
+
+// pieceParts returns the indexes of the parts overlapping the pieces that
+// failed verifyPieces
+func (d *Downloader) pieceParts() []int {
+	var parts []int
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		for _, piece := range d.badPieces {
+			start, end := d.Pieces.bounds(piece)
+			if part.Start < end && start <= part.End {
+				parts = append(parts, i)
+				break
+			}
+		}
+	}
+	return parts
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPieces(data []byte, length int64) *PieceHashes {
+	pieces := &PieceHashes{Length: length, Size: int64(len(data)), Source: "torrent"}
+	for start := int64(0); start < int64(len(data)); start += length {
+		end := start + length
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		sum := sha1.Sum(data[start:end])
+		pieces.SHA1 = append(pieces.SHA1, sum[:])
+	}
+	return pieces
+}
+
+func TestPiecesRefetchCorruptParts(t *testing.T) {
+	data := testPayload(256 * 1024)
+	partSize := len(data) / 4
+	var once sync.Once
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+		start, end, ok := parseRangeHeader(r.Header.Get("Range"), len(data))
+		if !ok {
+			http.Error(w, "range required", http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt64(&requests, 1)
+		body := data[start : end+1]
+		// No digest gives the flipped byte away; only the pieces do
+		if start == int64(partSize) {
+			once.Do(func() {
+				body = append([]byte(nil), body...)
+				body[10] ^= 0xff
+			})
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL, 4)
+	dl.Pieces = testPieces(data, 16*1024)
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Error("repaired file does not match")
+	}
+	// Four parts, then the one under the corrupt piece again
+	if n := atomic.LoadInt64(&requests); n != 5 {
+		t.Errorf("%d part requests, want 5", n)
+	}
+	if c := dl.Progress.Checksum; c == nil || c.Status != ChecksumVerified || c.Algorithm != "sha-1" {
+		t.Errorf("Checksum = %+v", c)
+	}
+}
+
+func TestPiecesSizeMismatch(t *testing.T) {
+	data := testPayload(64 * 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL, 2)
+	dl.Pieces = testPieces(data[:len(data)-1], 16*1024)
+	if err := dl.LoadOrCreateProgress(); err == nil || !strings.Contains(err.Error(), "torrent describes") {
+		t.Errorf("LoadOrCreateProgress() = %v, want a size mismatch", err)
+	}
+}
+
+func TestUnevenPiecesWithMatch(t *testing.T) {
+	data := testPayload(10000)
+	ends := []int64{1000, 4500, 4600, 10000}
+	pieces := &PieceHashes{
+		Size:      int64(len(data)),
+		Ends:      ends,
+		Algorithm: "sha-256",
+		Source:    "IPFS CID",
+		Match: func(i int, piece []byte) bool {
+			start := int64(0)
+			if i > 0 {
+				start = ends[i-1]
+			}
+			return bytes.Equal(piece, data[start:ends[i]])
+		},
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[4550] ^= 1
+	bad, err := pieces.ReadPieces(bytes.NewReader(corrupt))
+	if err != nil || len(bad) != 1 || bad[0] != 2 || pieces.Count() != 4 {
+		t.Errorf("ReadPieces() = %v, %v", bad, err)
+	}
+	if start, end := pieces.bounds(2); start != 4500 || end != 4600 || pieces.algorithm() != "sha-256" {
+		t.Errorf("bounds(2) = %d, %d", start, end)
+	}
+}
@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"multithreaded-downloader/secrets"
+)
+
+// PlanVersion is the version of the part plan format
+const PlanVersion = 1
+
+// Plan is the exact split of a download into parts and the server each
+// part is fetched from. Exported from one download and given to another as
+// its Plan, it makes the second request the same ranges from the same
+// servers, so a transfer can be repeated byte for byte and a server that
+// mishandles some ranges can be pinned down.
+type Plan struct {
+	Version int `json:"version"`
+	// URL is the file's URL with credentials redacted
+	URL       string     `json:"url"`
+	TotalSize int64      `json:"total_size"`
+	ETag      string     `json:"etag,omitempty"`
+	Parts     []PlanPart `json:"parts"`
+}
+
+// PlanPart is one part of a Plan: the inclusive byte range it covers and
+// the URL it comes from, with credentials redacted
+type PlanPart struct {
+	Index int   `json:"index"`
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Source is the piece of a joined file holding the part, or the server
+	// the part is pinned to. Without one, a download with mirrors gives the
+	// part to the fastest of them.
+	Source string `json:"source,omitempty"`
+}
+
+// LoadPlan reads a plan written by SavePlan
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid part plan: %w", err)
+	}
+	if plan.Version < 1 || plan.Version > PlanVersion {
+		return nil, fmt.Errorf("unsupported part plan version %d (this build reads up to %d)", plan.Version, PlanVersion)
+	}
+	return &plan, nil
+}
+
+// SavePlan writes plan to path as indented JSON
+func SavePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ExportPlan returns the parts of the download as a Plan. A part's source
+// is its piece, the server it is pinned to or, while the download runs
+// with mirrors, the server its current request went to.
+func (d *Downloader) ExportPlan() *Plan {
+	plan := &Plan{
+		Version:   PlanVersion,
+		URL:       secrets.RedactURL(d.Progress.URL),
+		TotalSize: d.Progress.TotalSize,
+		ETag:      d.Progress.ETag,
+		Parts:     make([]PlanPart, len(d.Progress.Parts)),
+	}
+	serving := d.mirrorSet().serving()
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		source := d.Progress.pinnedSource(part.Index)
+		if piece := d.Progress.sourceOf(part); piece != nil {
+			source = piece.URL
+		} else if source == "" {
+			source = serving[part.Index]
+		}
+		if source != "" {
+			source = secrets.RedactURL(source)
+		}
+		plan.Parts[i] = PlanPart{Index: part.Index, Start: part.Start, End: part.End, Source: source}
+	}
+	return plan
+}
+
+// applyPlan replaces the parts of a new download with those of Plan. The
+// plan must cover the file the server has, keep encrypted parts on chunk
+// boundaries and name only the download's own URLs as sources.
+func (d *Downloader) applyPlan(ranges bool) error {
+	plan := d.Plan
+	if plan.TotalSize != d.Progress.TotalSize {
+		return fmt.Errorf("part plan is for a %d byte file, the server has %d bytes", plan.TotalSize, d.Progress.TotalSize)
+	}
+	if plan.ETag != "" && d.Progress.ETag != "" && plan.ETag != d.Progress.ETag {
+		fmt.Printf("Part plan was made for version %s of the file, the server has %s\n", plan.ETag, d.Progress.ETag)
+	}
+	if len(plan.Parts) > 1 && !ranges {
+		return fmt.Errorf("part plan has %d parts, but the server does not support range requests", len(plan.Parts))
+	}
+	if d.Progress.PreviewBytes > 0 {
+		return fmt.Errorf("a part plan cannot be combined with preview bytes")
+	}
+
+	parts := make([]Part, len(plan.Parts))
+	pins := make([]string, len(plan.Parts))
+	pinned := false
+	for i, p := range plan.Parts {
+		if p.Index != i {
+			return fmt.Errorf("part plan lists part %d at position %d", p.Index, i)
+		}
+		parts[i] = Part{Index: i, Start: p.Start, End: p.End}
+		if d.Progress.Encrypted && p.Start%EncryptedChunkSize != 0 {
+			return fmt.Errorf("part %d starts at %d, which is not a multiple of the %d byte encryption chunk", i, p.Start, EncryptedChunkSize)
+		}
+	}
+	check := *d.Progress
+	check.Parts = parts
+	if err := check.Validate(); err != nil {
+		return fmt.Errorf("invalid part plan: %w", err)
+	}
+	for i, p := range plan.Parts {
+		if p.Source == "" {
+			continue
+		}
+		if piece := d.Progress.sourceOf(&parts[i]); piece != nil {
+			if !sameURL(p.Source, piece.URL) {
+				return fmt.Errorf("part %d lies in piece %s, not %s", i, secrets.RedactURL(piece.URL), p.Source)
+			}
+			continue
+		}
+		link := ""
+		for _, candidate := range append([]string{d.URL}, d.Progress.Mirrors...) {
+			if sameURL(p.Source, candidate) {
+				link = candidate
+				break
+			}
+		}
+		if link == "" {
+			return fmt.Errorf("part %d is pinned to %s, which is neither the URL nor a mirror of the download", i, p.Source)
+		}
+		pins[i], pinned = link, true
+	}
+
+	d.Progress.Parts = parts
+	d.Progress.Pins = nil
+	if pinned {
+		d.Progress.Pins = pins
+	}
+	// Sequential downloads fetch many small parts a few at a time
+	if !d.Progress.Sequential && d.NumThreads > len(parts) {
+		d.NumThreads = len(parts)
+	}
+	d.Progress.NumThreads = d.NumThreads
+	fmt.Printf("Using the part plan: %d parts\n", len(parts))
+	return nil
+}
+
+// sameURL reports whether link, as a plan lists it, is candidate, which
+// the plan may list with its credentials redacted
+func sameURL(link, candidate string) bool {
+	return link == candidate || link == secrets.RedactURL(candidate)
+}
+
+// pinnedSource returns the URL the part with index is pinned to by a plan,
+// or "" when its server is picked by speed
+func (p *Progress) pinnedSource(index int) string {
+	if index < 0 || index >= len(p.Pins) {
+		return ""
+	}
+	return p.Pins[index]
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// asked returns which of the Range headers in want server was sent
+func asked(server *rangeServer, want ...string) map[string]bool {
+	got := make(map[string]bool)
+	for _, header := range server.requests() {
+		for _, w := range want {
+			if header == w {
+				got[w] = true
+			}
+		}
+	}
+	return got
+}
+
+func TestPlanRepeatsPartsAndSources(t *testing.T) {
+	data := testPayload(400 * 1024)
+	primary := newRangeServer(t, data)
+	other := newRangeServer(t, data)
+
+	dl := newTestDownloader(t, primary.URL+"/file.bin", 3)
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	plan := dl.ExportPlan()
+	if plan.TotalSize != int64(len(data)) || len(plan.Parts) != 3 || plan.Parts[2].End != int64(len(data))-1 {
+		t.Fatalf("ExportPlan() = %+v", plan)
+	}
+
+	// An uneven split, one part pinned to the mirror and one to the URL
+	plan.Parts = []PlanPart{
+		{Index: 0, Start: 0, End: 99999, Source: primary.URL + "/file.bin"},
+		{Index: 1, Start: 100000, End: 299999, Source: other.URL + "/file.bin"},
+		{Index: 2, Start: 300000, End: int64(len(data)) - 1},
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := SavePlan(path, plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := LoadPlan(path); err != nil {
+		t.Fatal(err)
+	} else {
+		dl = newTestDownloader(t, primary.URL+"/file.bin", 2)
+		dl.Plan = plan
+	}
+	dl.Mirrors = []string{other.URL + "/file.bin"}
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match")
+	}
+	fromPrimary := asked(primary, "bytes=0-99999", "bytes=100000-299999", "bytes=300000-409599")
+	fromMirror := asked(other, "bytes=0-99999", "bytes=100000-299999", "bytes=300000-409599")
+	if !fromPrimary["bytes=0-99999"] || !fromMirror["bytes=100000-299999"] || fromPrimary["bytes=100000-299999"] || fromMirror["bytes=0-99999"] {
+		t.Errorf("ranges asked: primary %v, mirror %v", fromPrimary, fromMirror)
+	}
+	if !fromPrimary["bytes=300000-409599"] && !fromMirror["bytes=300000-409599"] {
+		t.Error("the unpinned part was not requested as planned")
+	}
+	if exported := dl.ExportPlan(); exported.Parts[1].Source != other.URL+"/file.bin" || dl.NumThreads != 2 {
+		t.Errorf("ExportPlan() after the import = %+v, %d threads", exported, dl.NumThreads)
+	}
+
+	for name, parts := range map[string][]PlanPart{
+		"gap":      {{Index: 0, Start: 0, End: 9}, {Index: 1, Start: 20, End: int64(len(data)) - 1}},
+		"short":    {{Index: 0, Start: 0, End: 9}},
+		"stranger": {{Index: 0, Start: 0, End: int64(len(data)) - 1, Source: "https://elsewhere.example/file.bin"}},
+	} {
+		dl := newTestDownloader(t, primary.URL+"/file.bin", 2)
+		dl.Plan = &Plan{Version: PlanVersion, TotalSize: int64(len(data)), Parts: parts}
+		if err := dl.LoadOrCreateProgress(); err == nil {
+			t.Errorf("%s plan was accepted", name)
+		}
+	}
+}
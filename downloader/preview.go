@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotDownloaded is returned for bytes of the file that have not been
+// downloaded yet
+var ErrNotDownloaded = errors.New("bytes not downloaded yet")
+
+// ErrInvalidRange is returned for Range headers that cannot be served
+var ErrInvalidRange = errors.New("invalid byte range")
+
+// splitPreview cuts the parts of a new download at the end of the first and
+// the start of the last n bytes, which are then downloaded before the rest.
+// The cuts fall on encryption chunk boundaries so encrypted parts stay
+// aligned. Files too small to have a middle are left alone.
+func (p *Progress) splitPreview(n int64) {
+	if n <= 0 {
+		return
+	}
+	p.PreviewBytes = n
+	head, tail := p.previewBounds()
+	if head >= tail {
+		p.PreviewBytes = 0
+		return
+	}
+	p.splitAt(head)
+	p.splitAt(tail)
+}
+
+// previewBounds returns where the head of a preview download ends and its
+// tail starts
+func (p *Progress) previewBounds() (head, tail int64) {
+	head = (p.PreviewBytes + EncryptedChunkSize - 1) / EncryptedChunkSize * EncryptedChunkSize
+	tail = (p.TotalSize - p.PreviewBytes) / EncryptedChunkSize * EncryptedChunkSize
+	return head, tail
+}
+
+// splitAt splits the part holding offset so a part starts there. Only the
+// parts of a new download, with nothing downloaded, are split.
+func (p *Progress) splitAt(offset int64) {
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		if offset <= part.Start || offset > part.End {
+			continue
+		}
+		second := Part{Start: offset, End: part.End}
+		part.End = offset - 1
+		p.Parts = append(p.Parts[:i+1], append([]Part{second}, p.Parts[i+1:]...)...)
+		break
+	}
+	for i := range p.Parts {
+		p.Parts[i].Index = i
+	}
+}
+
+// isPreview reports whether part holds the head or tail of a preview download
+func (p *Progress) isPreview(part *Part) bool {
+	if p.PreviewBytes <= 0 {
+		return false
+	}
+	head, tail := p.previewBounds()
+	return part.End < head || part.Start >= tail
+}
+
+// PreviewReady reports whether the head and tail of a preview download are
+// downloaded; for other downloads, whether the whole file is
+func (p *Progress) PreviewReady() bool {
+	if p.PreviewBytes <= 0 {
+		return p.IsComplete()
+	}
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		if p.isPreview(part) && !part.Done() {
+			return false
+		}
+	}
+	return true
+}
+
+// partOrder returns the indexes of the parts in the order they are started:
+// the head and tail of a preview download first, then the rest
+func (p *Progress) partOrder() []int {
+	order := make([]int, 0, len(p.Parts))
+	for i := range p.Parts {
+		if p.isPreview(&p.Parts[i]) {
+			order = append(order, i)
+		}
+	}
+	for i := range p.Parts {
+		if !p.isPreview(&p.Parts[i]) {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// downloadPreview downloads the head and tail parts of a preview download
+// and waits for them before the rest of the file starts
+func (d *Downloader) downloadPreview(ctx context.Context, progressMutex *sync.Mutex, fail func(error)) {
+	var wg sync.WaitGroup
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		if d.Progress.isPreview(part) && !part.Done() {
+			wg.Add(1)
+			go d.downloadPart(ctx, part, progressMutex, &wg, fail)
+		}
+	}
+	wg.Wait()
+}
+
+// AvailableFrom returns how many bytes from offset on are downloaded
+// without a gap
+func (p *Progress) AvailableFrom(offset int64) int64 {
+	var n int64
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		if part.End < offset+n {
+			continue
+		}
+		if part.Start > offset+n {
+			break
+		}
+		n = part.Start + part.Downloaded() - offset
+		if n < 0 {
+			return 0
+		}
+		if !part.Done() {
+			break
+		}
+	}
+	return n
+}
+
+// ClipRange narrows the Range header of a request for the file to the
+// bytes downloaded from the start of the range on, so a file still
+// downloading can be served with http.ServeContent: clients such as media
+// probes and zip readers take the shorter answer and ask again for the rest.
+// A finished file is served as asked. Before that, only a single range can
+// be served, and ErrNotDownloaded is returned when its first byte is missing.
+func (p *Progress) ClipRange(header string) (string, error) {
+	if p.IsComplete() {
+		return header, nil
+	}
+	if header == "" {
+		return "", fmt.Errorf("%w: ask for a byte range until the download completes", ErrNotDownloaded)
+	}
+
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return "", fmt.Errorf("%w: only a single range can be served before the download completes, got %q", ErrInvalidRange, header)
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	dash := strings.IndexByte(spec, '-')
+	if dash < 0 {
+		return "", fmt.Errorf("%w %q", ErrInvalidRange, header)
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	var start, end int64
+	switch {
+	case first == "":
+		// The last n bytes, as zip readers ask for the central directory
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("%w %q", ErrInvalidRange, header)
+		}
+		start, end = p.TotalSize-n, p.TotalSize-1
+		if start < 0 {
+			start = 0
+		}
+	default:
+		var err error
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+			return "", fmt.Errorf("%w %q", ErrInvalidRange, header)
+		}
+		end = p.TotalSize - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return "", fmt.Errorf("%w %q", ErrInvalidRange, header)
+			}
+		}
+		if end >= p.TotalSize {
+			end = p.TotalSize - 1
+		}
+	}
+	if start >= p.TotalSize {
+		return "", fmt.Errorf("%w: %q starts past the end of the %d byte file", ErrInvalidRange, header, p.TotalSize)
+	}
+
+	available := p.AvailableFrom(start)
+	if available == 0 {
+		return "", fmt.Errorf("%w: byte %d", ErrNotDownloaded, start)
+	}
+	if end > start+available-1 {
+		end = start + available - 1
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end), nil
+}
+
+// ServeDownloaded serves the file downloaded so far, decrypting it if it is
+// encrypted. Range requests are narrowed with ClipRange; when that fails the
+// error is returned and nothing is written, so the caller can answer in its
+// own format.
+func (d *Downloader) ServeDownloaded(w http.ResponseWriter, r *http.Request) error {
+	if d.Progress == nil {
+		return fmt.Errorf("%w: the download has not started", ErrNotDownloaded)
+	}
+	clipped, err := d.Progress.ClipRange(r.Header.Get("Range"))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(LongPath(d.Filename))
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file: %w", err)
+	}
+	defer file.Close()
+
+	// The file on disk may not have reached its full size yet
+	var content io.ReadSeeker = io.NewSectionReader(file, 0, d.Progress.TotalSize)
+	if d.Progress.Encrypted {
+		reader, err := NewDecryptingReader(file, d.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		content = reader
+	}
+
+	if clipped != "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("Range", clipped)
+		// The file changes as it downloads, so validators would only
+		// turn range requests into full responses
+		r.Header.Del("If-Range")
+	}
+	http.ServeContent(w, r, filepath.Base(d.Filename), time.Time{}, content)
+	return nil
+}
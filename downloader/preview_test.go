@@ -0,0 +1,162 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitPreview(t *testing.T) {
+	progress := CreateNewProgress("https://example.com/a.zip", "a.zip", 10*1024*1024+5, 2)
+	progress.splitPreview(1000)
+	if err := progress.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress.Parts) != 4 {
+		t.Fatalf("splitPreview() = %d parts, want 4", len(progress.Parts))
+	}
+
+	head, tail := &progress.Parts[0], &progress.Parts[3]
+	if head.End != EncryptedChunkSize-1 || tail.Start%EncryptedChunkSize != 0 || tail.Size() < 1000 {
+		t.Errorf("head %d-%d, tail %d-%d", head.Start, head.End, tail.Start, tail.End)
+	}
+	if order := progress.partOrder(); fmt.Sprint(order) != "[0 3 1 2]" {
+		t.Errorf("partOrder() = %v, want head and tail first", order)
+	}
+
+	// Nothing to put first when the head and tail cover the file
+	small := CreateNewProgress("https://example.com/b.zip", "b.zip", 100*1024, 2)
+	small.splitPreview(64 * 1024)
+	if small.PreviewBytes != 0 || len(small.Parts) != 2 {
+		t.Errorf("small file: PreviewBytes = %d, %d parts", small.PreviewBytes, len(small.Parts))
+	}
+}
+
+func TestClipRange(t *testing.T) {
+	progress := CreateNewProgress("https://example.com/a", "a", 1000, 4)
+	// Parts 0-249 done, 250-499 half way, 750-999 done
+	progress.Parts[0].SetDownloaded(250)
+	progress.Parts[0].SetDone(true)
+	progress.Parts[1].SetDownloaded(100)
+	progress.Parts[3].SetDownloaded(250)
+	progress.Parts[3].SetDone(true)
+
+	tests := []struct {
+		header  string
+		want    string
+		wantErr error
+	}{
+		{header: "bytes=0-", want: "bytes=0-349"},
+		{header: "bytes=100-199", want: "bytes=100-199"},
+		{header: "bytes=-100", want: "bytes=900-999"},
+		{header: "bytes=-5000", want: "bytes=0-349"},
+		{header: "bytes=800-2000", want: "bytes=800-999"},
+		{header: "bytes=400-", wantErr: ErrNotDownloaded},
+		{header: "", wantErr: ErrNotDownloaded},
+		{header: "bytes=0-10,20-30", wantErr: ErrInvalidRange},
+		{header: "bytes=1000-", wantErr: ErrInvalidRange},
+		{header: "bytes=9-3", wantErr: ErrInvalidRange},
+	}
+	for _, tt := range tests {
+		got, err := progress.ClipRange(tt.header)
+		if tt.want != "" {
+			if err != nil || got != tt.want {
+				t.Errorf("ClipRange(%q) = %q, %v; want %q", tt.header, got, err, tt.want)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("ClipRange(%q) error = %v, want %v", tt.header, err, tt.wantErr)
+		}
+	}
+
+	for i := range progress.Parts {
+		progress.Parts[i].SetDone(true)
+	}
+	if got, err := progress.ClipRange("bytes=0-10,20-30"); err != nil || got != "bytes=0-10,20-30" {
+		t.Errorf("finished file: ClipRange() = %q, %v", got, err)
+	}
+}
+
+func TestServeDownloadedPartialFile(t *testing.T) {
+	data := testPayload(1000)
+	filename := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(filename, data[:250], 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl := NewDownloader("https://example.com/a.bin", filename, 4)
+	dl.Progress = CreateNewProgress(dl.URL, filename, int64(len(data)), 4)
+	dl.Progress.Parts[0].SetDownloaded(250)
+	dl.Progress.Parts[0].SetDone(true)
+
+	req := httptest.NewRequest(http.MethodGet, "/preview", nil)
+	req.Header.Set("Range", "bytes=200-")
+	rec := httptest.NewRecorder()
+	if err := dl.ServeDownloaded(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Range") != "bytes 200-249/1000" {
+		t.Fatalf("got %d with Content-Range %q", rec.Code, rec.Header().Get("Content-Range"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data[200:250]) {
+		t.Error("served bytes do not match the file")
+	}
+
+	req.Header.Set("Range", "bytes=-10")
+	rec = httptest.NewRecorder()
+	if err := dl.ServeDownloaded(rec, req); !errors.Is(err, ErrNotDownloaded) {
+		t.Fatalf("tail: error = %v, want ErrNotDownloaded", err)
+	}
+	if rec.Body.Len() != 0 {
+		t.Error("a response was written for bytes not downloaded")
+	}
+}
+
+func TestPreviewDownloadFetchesHeadAndTailFirst(t *testing.T) {
+	data := testPayload(2*1024*1024 + 77)
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+
+	dl := newTestDownloader(t, server.URL+"/a.zip", 4)
+	dl.PreviewBytes = 100 * 1024
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	got, err := os.ReadFile(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match")
+	}
+
+	parts := dl.Progress.Parts
+	head := fmt.Sprintf("bytes=%d-%d", parts[0].Start, parts[0].End)
+	last := parts[len(parts)-1]
+	tail := fmt.Sprintf("bytes=%d-%d", last.Start, last.End)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != len(parts) {
+		t.Fatalf("got %d range requests for %d parts: %v", len(ranges), len(parts), ranges)
+	}
+	first := map[string]bool{ranges[0]: true, ranges[1]: true}
+	if !first[head] || !first[tail] {
+		t.Errorf("first requests %v, want the head %s and tail %s", ranges[:2], head, tail)
+	}
+}
@@ -0,0 +1,274 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"multithreaded-downloader/secrets"
+)
+
+// ProbeResult is what a range check learned about the file at a URL
+type ProbeResult struct {
+	SupportsRanges bool
+	// Size is the file's length in bytes
+	Size         int64
+	ETag         string
+	LastModified string
+	// Digests are the checksums the server sent with the probe
+	Digests []Digest
+	// FinalURL is where redirects led the probe
+	FinalURL string
+	// AcceptRangesRaw is the Accept-Ranges header as the server sent it
+	AcceptRangesRaw string
+	// Server is the Server header, telling which software answered
+	Server string
+	// Mirrors are the other URLs of the file the server announced in
+	// Link headers with rel=duplicate; they are not kept in ProbeCache
+	Mirrors  []string
+	ProbedAt time.Time
+	// Redirects are the URLs the probe went through before FinalURL, and
+	// Status, Proto and Header the final answer; like Mirrors they are not
+	// kept in ProbeCache
+	Redirects []string
+	Status    string
+	Proto     string
+	Header    http.Header
+}
+
+// ProbeCache keeps range checks, so a URL downloaded again soon after is
+// not probed again with a HEAD and, for servers that refuse it, a ranged GET
+type ProbeCache interface {
+	// LoadProbe returns the probe stored for url while it is fresh
+	LoadProbe(url string) (ProbeResult, bool)
+	// StoreProbe keeps the probe of url
+	StoreProbe(url string, probe ProbeResult)
+	// ForgetProbe drops the probe of url, e.g. once the file changed
+	ForgetProbe(url string)
+}
+
+// Probe asks the server what it offers for the file: whether it serves
+// byte ranges, the size, the validators and where redirects lead. Hosts
+// that refuse HEAD are asked with a ranged GET. A link refused as expired
+// is refreshed once with RefreshLink. Unlike SupportsRange it always asks
+// the server, and keeps the answer in Probes.
+func (d *Downloader) Probe(ctx context.Context) (ProbeResult, error) {
+	probe, err := d.probe(ctx)
+	if errors.Is(err, errLinkRefused) && d.RefreshLink != nil {
+		if err := d.refreshLink(ctx, d.requestURL()); err != nil {
+			return ProbeResult{}, err
+		}
+		probe, err = d.probe(ctx)
+	}
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	d.etag = probe.ETag
+	d.lastModified = probe.LastModified
+	d.digests = probe.Digests
+	d.discovered = probe.Mirrors
+	d.noteProbe(probe, false)
+	if len(probe.Mirrors) > 0 {
+		fmt.Printf("Server announced %d mirrors\n", len(probe.Mirrors))
+	}
+	d.storeProbe(probe)
+	return probe, nil
+}
+
+func (d *Downloader) probe(ctx context.Context) (ProbeResult, error) {
+	ctx = d.traceResolver(ctx)
+	if d.customRequest() {
+		return d.probeRequest()
+	}
+	fmt.Printf("Checking if server supports range requests for: %s\n", secrets.RedactURL(d.URL))
+
+	client := d.withTransport(&http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			ForceAttemptHTTP2: !d.DisableHTTP2,
+		},
+	})
+
+	probe, err := d.probeHead(ctx, client)
+	if errors.Is(err, errHeadUnusable) {
+		fmt.Printf("%v, trying GET request...\n", err)
+		probe, err = d.probeRangedGet(ctx, client)
+	}
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	fmt.Printf("Server supports range requests: %v\n", probe.SupportsRanges)
+	fmt.Printf("File size: %d bytes (%.2f MB)\n", probe.Size, float64(probe.Size)/(1024*1024))
+	return probe, nil
+}
+
+// errHeadUnusable marks a HEAD request that told nothing, so the probe is
+// made with a GET instead
+var errHeadUnusable = errors.New("HEAD request unusable")
+
+// probeHead probes with a HEAD request. Hosts that do not implement HEAD
+// answer it with an error status such as 403 or 405, or without a length;
+// those and failed requests return errHeadUnusable.
+func (d *Downloader) probeHead(ctx context.Context, client *http.Client) (ProbeResult, error) {
+	req, err := d.newRequest(ctx, http.MethodHead)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to create HEAD request: %w", err)
+	}
+	waitForHost(ctx, req.URL.Host)
+	resp, err := client.Do(req)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("%w: %v", errHeadUnusable, err)
+	}
+	resp.Body.Close()
+
+	if delay, ok := throttleFromResponse(req.URL.Host, resp); ok {
+		return ProbeResult{}, fmt.Errorf("server throttled requests (%s), retry after %s", resp.Status, delay)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ProbeResult{}, fmt.Errorf("%w: %s", errHeadUnusable, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return ProbeResult{}, fmt.Errorf("%w: no content length", errHeadUnusable)
+	}
+
+	probe := probeFromResponse(resp)
+	probe.SupportsRanges = acceptsByteRanges(probe.AcceptRangesRaw)
+	probe.Size = resp.ContentLength
+	return probe, nil
+}
+
+// probeRangedGet probes with a GET for the first 1KB. A 206 answer shows
+// range support whatever Accept-Ranges says.
+func (d *Downloader) probeRangedGet(ctx context.Context, client *http.Client) (ProbeResult, error) {
+	req, err := d.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	req.Header.Set("Range", "bytes=0-1023")
+	resp, err := client.Do(req)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to make GET request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	probe := probeFromResponse(resp)
+	// Size stays -1 while it is unknown; empty files are 0
+	probe.Size = -1
+	if resp.StatusCode == http.StatusPartialContent {
+		probe.SupportsRanges = true
+		if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+			fmt.Printf("Content-Range: %s\n", contentRange)
+			if cr, err := ParseContentRange(contentRange); err == nil && cr.Total > 0 {
+				probe.Size = cr.Total
+			}
+		}
+	} else if resp.StatusCode == http.StatusOK {
+		// Server doesn't support ranges, but we can still download
+		probe.Size = resp.ContentLength
+	} else if total, ok := unsatisfiedRangeTotal(resp); ok {
+		// No byte of an empty file can be asked for
+		probe.Size = total
+	} else {
+		return ProbeResult{}, statusError(resp)
+	}
+
+	// If we still don't have the length, make a full GET request
+	if probe.Size < 0 {
+		fmt.Println("Getting file size with full request...")
+		fullReq, err := d.newRequest(ctx, http.MethodGet)
+		if err != nil {
+			return ProbeResult{}, fmt.Errorf("failed to create GET request: %w", err)
+		}
+		fullResp, err := client.Do(fullReq)
+		if err != nil {
+			return ProbeResult{}, fmt.Errorf("failed to get file size: %w", err)
+		}
+		fullResp.Body.Close()
+		if fullResp.StatusCode == http.StatusOK {
+			probe.Size = fullResp.ContentLength
+		}
+	}
+	if probe.Size < 0 {
+		return ProbeResult{}, fmt.Errorf("server did not provide content length")
+	}
+	return probe, nil
+}
+
+// probeFromResponse reads the headers every probe keeps
+func probeFromResponse(resp *http.Response) ProbeResult {
+	var redirects []string
+	for prev := resp.Request.Response; prev != nil; prev = prev.Request.Response {
+		redirects = append([]string{prev.Request.URL.String()}, redirects...)
+	}
+	return ProbeResult{
+		Redirects:       redirects,
+		Status:          resp.Status,
+		Proto:           resp.Proto,
+		Header:          resp.Header,
+		ETag:            strongETag(resp.Header.Get("ETag")),
+		LastModified:    resp.Header.Get("Last-Modified"),
+		Digests:         responseDigests(resp, false),
+		FinalURL:        resp.Request.URL.String(),
+		AcceptRangesRaw: resp.Header.Get("Accept-Ranges"),
+		Server:          resp.Header.Get("Server"),
+		Mirrors:         duplicateLinks(resp),
+		ProbedAt:        time.Now(),
+	}
+}
+
+// acceptsByteRanges reads an Accept-Ranges header, a list of range units
+// (RFC 9110, section 14.3). Only "bytes" is of use; "none" and values that
+// are not units, such as "yes" or "bytes=0-", mean ranges cannot be relied on.
+func acceptsByteRanges(raw string) bool {
+	for _, unit := range strings.Split(raw, ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+	return false
+}
+
+// usesProbeCache reports whether range checks of this download are cached.
+// Custom requests are probed with the request itself, whose answer
+// depends on its body.
+func (d *Downloader) usesProbeCache() bool {
+	return d.Probes != nil && !d.customRequest()
+}
+
+// cachedProbe takes the range check from Probes instead of the server
+func (d *Downloader) cachedProbe() (ProbeResult, bool) {
+	if !d.usesProbeCache() {
+		return ProbeResult{}, false
+	}
+	probe, ok := d.Probes.LoadProbe(d.URL)
+	if !ok {
+		return ProbeResult{}, false
+	}
+	d.etag = probe.ETag
+	d.lastModified = probe.LastModified
+	d.digests = probe.Digests
+	d.noteProbe(probe, true)
+	fmt.Printf("Using server capabilities of %s checked at %s\n", secrets.RedactURL(d.URL), probe.ProbedAt.Format(time.RFC3339))
+	return probe, true
+}
+
+// storeProbe keeps the range check that just ran in Probes
+func (d *Downloader) storeProbe(probe ProbeResult) {
+	if d.usesProbeCache() {
+		d.Probes.StoreProbe(d.URL, probe)
+	}
+}
+
+// forgetProbe drops the cached range check once it turned out stale, so the
+// next attempt probes the server again
+func (d *Downloader) forgetProbe() {
+	if d.usesProbeCache() {
+		d.Probes.ForgetProbe(d.URL)
+	}
+}
@@ -0,0 +1,219 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memProbes is a ProbeCache in memory
+type memProbes struct {
+	mu     sync.Mutex
+	probes map[string]ProbeResult
+}
+
+func (m *memProbes) LoadProbe(url string) (ProbeResult, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	probe, ok := m.probes[url]
+	return probe, ok
+}
+
+func (m *memProbes) StoreProbe(url string, probe ProbeResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.probes == nil {
+		m.probes = make(map[string]ProbeResult)
+	}
+	m.probes[url] = probe
+}
+
+func (m *memProbes) ForgetProbe(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.probes, url)
+}
+
+func TestProbeCacheSkipsSecondProbe(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	var heads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	probes := &memProbes{}
+	for i := 0; i < 2; i++ {
+		dl := newTestDownloader(t, server.URL, 4)
+		dl.Probes = probes
+		before := atomic.LoadInt32(&heads)
+		if err := dl.LoadOrCreateProgress(); err != nil {
+			t.Fatal(err)
+		}
+		// Only the first download probes the server
+		if got, want := atomic.LoadInt32(&heads)-before, int32(1-i); got != want {
+			t.Errorf("download %d sent %d HEAD requests to probe, want %d", i+1, got, want)
+		}
+		if err := dl.DownloadContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := dl.VerifyDownload(); err != nil {
+			t.Fatal(err)
+		}
+		if dl.Progress.ETag != `"v1"` || dl.Progress.NumThreads != 4 {
+			t.Errorf("download %d: ETag %s with %d threads, want \"v1\" with 4", i+1, dl.Progress.ETag, dl.Progress.NumThreads)
+		}
+		if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+			t.Errorf("download %d: file does not match", i+1)
+		}
+	}
+	if probe, ok := probes.LoadProbe(server.URL); !ok || probe.Size != int64(len(data)) || !probe.SupportsRanges {
+		t.Errorf("stored probe = %+v, %v", probe, ok)
+	}
+}
+
+func TestStaleProbeIsForgotten(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	probes := &memProbes{}
+	probes.StoreProbe(server.URL, ProbeResult{SupportsRanges: true, Size: int64(len(data)), ETag: `"v1"`, ProbedAt: time.Now()})
+
+	dl := newTestDownloader(t, server.URL, 4)
+	dl.Probes = probes
+	if err := runDownload(t, dl); !errors.Is(err, ErrRemoteFileChanged) {
+		t.Fatalf("download with a stale probe: err = %v, want ErrRemoteFileChanged", err)
+	}
+	if _, ok := probes.LoadProbe(server.URL); ok {
+		t.Error("stale probe was kept")
+	}
+
+	// The next attempt probes again and succeeds
+	dl = newTestDownloader(t, server.URL, 4)
+	dl.Probes = probes
+	if err := runDownload(t, dl); err != nil {
+		t.Fatal(err)
+	}
+	if probe, _ := probes.LoadProbe(server.URL); probe.ETag != `"v2"` {
+		t.Errorf("probe ETag = %s, want \"v2\"", probe.ETag)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	data := testPayload(5000)
+	// serve answers like http.ServeContent with the given headers
+	serve := func(header map[string]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range header {
+				w.Header().Set(name, value)
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}
+	}
+	// refuseHead answers HEAD with status and GET like serve
+	refuseHead := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(status)
+				return
+			}
+			serve(map[string]string{"ETag": `"get"`})(w, r)
+		}
+	}
+	// noRanges ignores Range and sends the whole file, whatever it claims
+	noRanges := func(acceptRanges string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Accept-Ranges", acceptRanges)
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			if r.Method != http.MethodHead {
+				w.Write(data)
+			}
+		}
+	}
+
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		wantRanges   bool
+		wantETag     string
+		acceptRanges string
+	}{
+		{"HEAD", serve(map[string]string{"ETag": `"v1"`, "Server": "test"}), true, `"v1"`, "bytes"},
+		{"HEAD not allowed", refuseHead(http.StatusMethodNotAllowed), true, `"get"`, "bytes"},
+		{"HEAD not implemented", refuseHead(http.StatusNotImplemented), true, `"get"`, "bytes"},
+		{"HEAD forbidden", refuseHead(http.StatusForbidden), true, `"get"`, "bytes"},
+		{"HEAD without length", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.Header().Set("Transfer-Encoding", "chunked")
+				w.(http.Flusher).Flush()
+				return
+			}
+			serve(nil)(w, r)
+		}, true, "", "bytes"},
+		{"no ranges", noRanges(""), false, "", ""},
+		{"Accept-Ranges none", noRanges("none"), false, "", "none"},
+		{"Accept-Ranges yes", noRanges("yes"), false, "", "yes"},
+		{"Accept-Ranges with a range", noRanges("bytes=0-"), false, "", "bytes=0-"},
+		{"Accept-Ranges in capitals", noRanges("Bytes"), true, "", "Bytes"},
+		{"Accept-Ranges list", noRanges("pages, bytes"), true, "", "pages, bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/file", tt.handler)
+			mux.Handle("/old", http.RedirectHandler("/file", http.StatusFound))
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			dl := newTestDownloader(t, server.URL+"/old", 4)
+			probe, err := dl.Probe(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if probe.SupportsRanges != tt.wantRanges {
+				t.Errorf("SupportsRanges = %v, want %v", probe.SupportsRanges, tt.wantRanges)
+			}
+			if probe.Size != int64(len(data)) {
+				t.Errorf("Size = %d, want %d", probe.Size, len(data))
+			}
+			if probe.ETag != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", probe.ETag, tt.wantETag)
+			}
+			if probe.AcceptRangesRaw != tt.acceptRanges {
+				t.Errorf("AcceptRangesRaw = %q, want %q", probe.AcceptRangesRaw, tt.acceptRanges)
+			}
+			if want := server.URL + "/file"; probe.FinalURL != want {
+				t.Errorf("FinalURL = %s, want %s", probe.FinalURL, want)
+			}
+			if tt.name == "HEAD" && probe.Server != "test" {
+				t.Errorf("Server = %q, want test", probe.Server)
+			}
+		})
+	}
+}
+
+func TestProbeMissingFile(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL, 4)
+	if _, err := dl.Probe(context.Background()); !errors.Is(err, ErrServerStatus) {
+		t.Errorf("Probe of a missing file: err = %v, want ErrServerStatus", err)
+	}
+}
@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultUserAgent is sent when no profile or custom agent is configured
+const DefaultUserAgent = "Go-Downloader/1.0"
+
+// UserAgentProfiles are named User-Agent presets for hosts that block unknown clients
+var UserAgentProfiles = map[string]string{
+	"default": DefaultUserAgent,
+	"chrome":  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"firefox": "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+	"safari":  "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+	"edge":    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+	"curl":    "curl/8.7.1",
+	"wget":    "Wget/1.24.5",
+}
+
+// SpoofingPolicy controls which User-Agent and Referer values a server accepts
+type SpoofingPolicy string
+
+const (
+	// SpoofingAllowAny accepts presets, custom agents and referers
+	SpoofingAllowAny SpoofingPolicy = "any"
+	// SpoofingPresetsOnly accepts named presets and referers but no custom agents
+	SpoofingPresetsOnly SpoofingPolicy = "presets"
+	// SpoofingDisabled always uses the default agent and rejects referers
+	SpoofingDisabled SpoofingPolicy = "none"
+)
+
+// ParseSpoofingPolicy parses a policy name, defaulting to SpoofingAllowAny when empty
+func ParseSpoofingPolicy(value string) (SpoofingPolicy, error) {
+	switch SpoofingPolicy(value) {
+	case "":
+		return SpoofingAllowAny, nil
+	case SpoofingAllowAny, SpoofingPresetsOnly, SpoofingDisabled:
+		return SpoofingPolicy(value), nil
+	}
+	return "", fmt.Errorf("unknown spoofing policy %q (expected any, presets or none)", value)
+}
+
+// ProfileNames returns the available User-Agent preset names in sorted order
+func ProfileNames() []string {
+	names := make([]string, 0, len(UserAgentProfiles))
+	for name := range UserAgentProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveUserAgent picks the User-Agent for a download from a preset name or a
+// custom value. A custom value takes precedence over the profile.
+func ResolveUserAgent(profile, custom string) (string, error) {
+	if custom != "" {
+		return custom, nil
+	}
+	if profile == "" {
+		return DefaultUserAgent, nil
+	}
+	if userAgent, ok := UserAgentProfiles[profile]; ok {
+		return userAgent, nil
+	}
+	return "", fmt.Errorf("unknown user agent profile %q", profile)
+}
+
+// Resolve validates a requested profile, custom agent and referer against the
+// policy and returns the User-Agent and Referer to use
+func (p SpoofingPolicy) Resolve(profile, custom, referer string) (string, string, error) {
+	switch p {
+	case SpoofingDisabled:
+		if (profile != "" && profile != "default") || custom != "" || referer != "" {
+			return "", "", fmt.Errorf("custom user agents and referers are disabled on this server")
+		}
+	case SpoofingPresetsOnly:
+		if custom != "" {
+			return "", "", fmt.Errorf("only user agent profiles are allowed on this server: %v", ProfileNames())
+		}
+	}
+
+	userAgent, err := ResolveUserAgent(profile, custom)
+	if err != nil {
+		return "", "", err
+	}
+	return userAgent, referer, nil
+}
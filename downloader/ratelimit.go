@@ -0,0 +1,263 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting how many bytes per second a
+// download reads. Its rate can change while parts are waiting on it; a rate
+// of zero or less means unlimited.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+	// changed is closed and replaced whenever the rate changes, waking
+	// waiters so they recompute their delay
+	changed chan struct{}
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSecond
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond, last: time.Now(), changed: make(chan struct{})}
+}
+
+// Rate returns the current limit in bytes per second, zero when unlimited
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate < 0 {
+		return 0
+	}
+	return l.rate
+}
+
+// SetRate changes the limit; waiting parts pick it up at once
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.rate > 0 {
+		l.refill(now)
+	} else {
+		// Nothing was saved up while the download was unlimited
+		l.tokens = 0
+	}
+	l.rate = bytesPerSecond
+	l.last = now
+	if l.rate > 0 && l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// refill adds the tokens earned since the last call, up to one second's worth
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+}
+
+// Wait blocks until n more bytes may be read or ctx is done. A read larger
+// than the bucket is let through and paid back by the next callers, so the
+// average rate holds whatever the buffer size.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		l.refill(time.Now())
+		if l.tokens >= 0 {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		changed := l.changed
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Gate limits how many tasks run at once, such as the parts of a download or
+// the downloads of a server. The limit can change while tasks run: raising it
+// lets waiting tasks start, lowering it makes tasks over the limit retire at
+// their next check. A limit of zero or less means unlimited.
+type Gate struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	// changed is closed and replaced whenever a slot may have opened up
+	changed chan struct{}
+}
+
+// NewGate creates a gate letting limit tasks run at once
+func NewGate(limit int) *Gate {
+	return &Gate{limit: limit, changed: make(chan struct{})}
+}
+
+func (g *Gate) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Limit returns how many tasks may run at once, zero when unlimited
+func (g *Gate) Limit() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit < 0 {
+		return 0
+	}
+	return g.limit
+}
+
+// SetLimit changes how many tasks may run at once
+func (g *Gate) SetLimit(limit int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit = limit
+	g.notify()
+}
+
+// TryAcquire takes a free slot without waiting and reports whether it did
+func (g *Gate) TryAcquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit > 0 && g.active >= g.limit {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// Acquire waits for a free slot and reports false if ctx ended first
+func (g *Gate) Acquire(ctx context.Context) bool {
+	for {
+		g.mu.Lock()
+		if g.limit <= 0 || g.active < g.limit {
+			g.active++
+			g.mu.Unlock()
+			return true
+		}
+		changed := g.changed
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// Release gives back a slot taken by Acquire or TryAcquire
+func (g *Gate) Release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	g.notify()
+}
+
+// Retire gives up the caller's slot if more tasks are running than the
+// limit allows, and reports whether it did
+func (g *Gate) Retire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit <= 0 || g.active <= g.limit {
+		return false
+	}
+	g.active--
+	g.notify()
+	return true
+}
+
+// Active returns how many tasks hold a slot
+func (g *Gate) Active() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
+
+// SetRateLimit limits the download to bytesPerSecond across all its parts,
+// or lifts the limit when it is zero. It may be called while the download runs.
+func (d *Downloader) SetRateLimit(bytesPerSecond int64) {
+	d.limiter.SetRate(bytesPerSecond)
+}
+
+// RateLimit returns the download's limit in bytes per second, zero when unlimited
+func (d *Downloader) RateLimit() int64 {
+	return d.limiter.Rate()
+}
+
+// SetThreads changes how many parts transfer at once. It may be called while
+// the download runs: extra parts stop after their current read and continue
+// when a slot opens up. A download never runs more parts at once than it was
+// split into when it started (see CheckThreads), nor more than its Resources
+// allow. A sequential download, split into many small parts, runs NumThreads
+// parts at once until the limit is changed.
+func (d *Downloader) SetThreads(n int) {
+	d.threads.SetLimit(d.capThreads(n))
+}
+
+// CheckThreads reports an error wrapping ErrTooManyThreads when n is more
+// than the parts the download was split into, which SetThreads would
+// silently cap. Sequential downloads, split into many parts, take any count.
+func (d *Downloader) CheckThreads(n int) error {
+	if d.Progress == nil || d.Progress.Sequential {
+		return nil
+	}
+	if parts := len(d.Progress.Parts); n > parts {
+		return fmt.Errorf("%w: download is split into %d parts, so it runs at most %d threads", ErrTooManyThreads, parts, parts)
+	}
+	return nil
+}
+
+// Threads returns how many parts may transfer at once
+func (d *Downloader) Threads() int {
+	threads := d.NumThreads
+	if d.Progress != nil && d.Progress.Sequential {
+		if limit := d.threads.Limit(); limit > 0 {
+			return limit
+		}
+		return threads
+	}
+	if d.Progress != nil {
+		threads = len(d.Progress.Parts)
+	}
+	if limit := d.threads.Limit(); limit > 0 && limit < threads {
+		threads = limit
+	}
+	return threads
+}
+
+// RequestedThreads returns the thread count the download asked for, which
+// may be more than it was split into
+func (d *Downloader) RequestedThreads() int {
+	if d.Progress != nil && d.Progress.RequestedThreads > 0 {
+		return d.Progress.RequestedThreads
+	}
+	return d.NumThreads
+}
+
+// ActiveThreads returns how many parts are transferring right now
+func (d *Downloader) ActiveThreads() int {
+	return d.threads.Active()
+}
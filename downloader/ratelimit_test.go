@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterHoldsRate(t *testing.T) {
+	l := NewRateLimiter(64 * 1024)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		if err := l.Wait(ctx, 16*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 128KB at 64KB/s; the first read is free, the rest are paid for
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("128KB went through in %s at 64KB/s", elapsed)
+	}
+}
+
+func TestRateLimiterChangesApplyToWaiters(t *testing.T) {
+	l := NewRateLimiter(1024)
+	ctx := context.Background()
+	if err := l.Wait(ctx, 64*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx, 1) }()
+	time.Sleep(20 * time.Millisecond)
+
+	// At 1KB/s the waiter would owe a minute; lifting the limit frees it
+	l.SetRate(0)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter did not see the limit being lifted")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	l.SetRate(1)
+	l.Wait(cancelled, 1024)
+	cancel()
+	if err := l.Wait(cancelled, 1); err == nil {
+		t.Fatal("Wait() ignored a cancelled context")
+	}
+}
+
+func TestGateRetiresExtraTasks(t *testing.T) {
+	g := NewGate(2)
+	ctx := context.Background()
+	g.Acquire(ctx)
+	g.Acquire(ctx)
+
+	waiting := make(chan bool, 1)
+	go func() { waiting <- g.Acquire(ctx) }()
+	select {
+	case <-waiting:
+		t.Fatal("third task started with a limit of two")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	g.SetLimit(1)
+	if !g.Retire() {
+		t.Fatal("no task retired after the limit was lowered")
+	}
+	if g.Retire() {
+		t.Fatal("a second task retired although one is within the limit")
+	}
+
+	if g.TryAcquire() {
+		t.Fatal("TryAcquire() took a slot over the limit")
+	}
+	g.SetLimit(2)
+	select {
+	case ok := <-waiting:
+		if !ok {
+			t.Fatal("Acquire() failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit did not start the waiting task")
+	}
+	if g.Active() != 2 {
+		t.Fatalf("Active() = %d, want 2", g.Active())
+	}
+}
+
+func TestAdjustRunningDownload(t *testing.T) {
+	data := testPayload(256 * 1024)
+	server := newFaultServer(t, data, faultSlowDrip)
+	dl := newTestDownloader(t, server.URL, 4)
+	dl.SetRateLimit(64 * 1024)
+
+	done := make(chan error, 1)
+	go func() { done <- runDownload(t, dl) }()
+
+	// Once parts are running, drop to one thread and lift the rate limit
+	deadline := time.Now().Add(5 * time.Second)
+	for dl.ActiveThreads() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	dl.SetThreads(1)
+	dl.SetRateLimit(0)
+	for dl.ActiveThreads() > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if active := dl.ActiveThreads(); active > 1 {
+		t.Errorf("%d parts still running after lowering threads to 1", active)
+	}
+	if dl.Threads() != 1 || dl.RateLimit() != 0 {
+		t.Errorf("Threads() = %d, RateLimit() = %d", dl.Threads(), dl.RateLimit())
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	got, err := os.ReadFile(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match after adjusting the download")
+	}
+}
+
+func TestCheckThreads(t *testing.T) {
+	dl := &Downloader{Progress: &Progress{Parts: make([]Part, 4)}}
+	if err := dl.CheckThreads(4); err != nil {
+		t.Errorf("CheckThreads(4) = %v", err)
+	}
+	if err := dl.CheckThreads(5); !errors.Is(err, ErrTooManyThreads) {
+		t.Errorf("CheckThreads(5) = %v, want ErrTooManyThreads", err)
+	}
+
+	dl.Progress.Sequential = true
+	if err := dl.CheckThreads(16); err != nil {
+		t.Errorf("sequential CheckThreads(16) = %v", err)
+	}
+}
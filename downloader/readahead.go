@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultReadAheadBlockSize is the block size of a ReadAhead that sets none
+const DefaultReadAheadBlockSize = 1024 * 1024
+
+// ReadAhead makes a RemoteFile fetch whole blocks, keep the recently used
+// ones and prefetch the blocks after each read, so reading a mounted archive
+// member by member does not wait for a request per read
+type ReadAhead struct {
+	// BlockSize is the unit reads are fetched and cached in; 0 means
+	// DefaultReadAheadBlockSize
+	BlockSize int64
+	// Blocks is how many blocks after the last one read are fetched in the
+	// background; 0 only caches what was read
+	Blocks int
+	// CacheBlocks caps the blocks kept, the least recently used dropped
+	// first. It is raised to make room for the prefetched blocks and a read
+	// of the size of a block.
+	CacheBlocks int
+}
+
+// SetReadAhead reads the file through a cache of blocks from now on, with
+// the blocks after each read prefetched; a zero ReadAhead turns it off
+func (f *RemoteFile) SetReadAhead(ra ReadAhead) {
+	f.blocksMu.Lock()
+	defer f.blocksMu.Unlock()
+	if ra == (ReadAhead{}) {
+		f.blocks = nil
+		return
+	}
+	if ra.BlockSize <= 0 {
+		ra.BlockSize = DefaultReadAheadBlockSize
+	}
+	if ra.Blocks < 0 {
+		ra.Blocks = 0
+	}
+	if ra.CacheBlocks < ra.Blocks+2 {
+		ra.CacheBlocks = ra.Blocks + 2
+	}
+	f.blocks = newBlockCache(ra, f.d.NumThreads)
+}
+
+// readAhead returns the block cache reads go through, nil when there is none
+func (f *RemoteFile) readAhead() *blockCache {
+	f.blocksMu.Lock()
+	defer f.blocksMu.Unlock()
+	return f.blocks
+}
+
+// readCached is ReadAt through the block cache c
+func (f *RemoteFile) readCached(c *blockCache, p []byte, off int64) (int, error) {
+	end := min64(off+int64(len(p)), f.size)
+	first, last := off/c.blockSize, (end-1)/c.blockSize
+
+	// Start every missing block at once, then the ones after them
+	fetches := make([]*blockFetch, 0, last-first+1)
+	for index := first; index <= last; index++ {
+		fetches = append(fetches, c.fetch(f, index))
+	}
+	for index := last + 1; index <= last+int64(c.readAhead) && index*c.blockSize < f.size; index++ {
+		c.fetch(f, index)
+	}
+
+	n := 0
+	for i, fetch := range fetches {
+		<-fetch.done
+		if fetch.err != nil {
+			return n, fetch.err
+		}
+		blockStart := (first + int64(i)) * c.blockSize
+		from := int64(0)
+		if off > blockStart {
+			from = off - blockStart
+		}
+		n += copy(p[n:], fetch.data[from:])
+	}
+	return n, nil
+}
+
+// blockCache keeps the most recently used blocks of a RemoteFile, and the
+// fetches of blocks still on their way so no block is requested twice
+type blockCache struct {
+	blockSize int64
+	readAhead int
+	max       int
+	// slots holds a token per block being fetched, so reads and prefetches
+	// together use no more connections than the file's NumThreads
+	slots chan struct{}
+
+	mu       sync.Mutex
+	blocks   map[int64]*list.Element
+	lru      *list.List
+	inflight map[int64]*blockFetch
+}
+
+// cachedBlock is an entry of the LRU list
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// blockFetch is a block being fetched; done is closed once data or err is set
+type blockFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newBlockCache(ra ReadAhead, threads int) *blockCache {
+	if threads < 1 {
+		threads = 1
+	}
+	return &blockCache{
+		blockSize: ra.BlockSize,
+		readAhead: ra.Blocks,
+		max:       ra.CacheBlocks,
+		slots:     make(chan struct{}, threads),
+		blocks:    make(map[int64]*list.Element),
+		lru:       list.New(),
+		inflight:  make(map[int64]*blockFetch),
+	}
+}
+
+// fetch returns the block index of f: done already when it is cached,
+// otherwise the fetch on its way, started now if there is none
+func (c *blockCache) fetch(f *RemoteFile, index int64) *blockFetch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.blocks[index]; ok {
+		c.lru.MoveToFront(elem)
+		done := make(chan struct{})
+		close(done)
+		return &blockFetch{done: done, data: elem.Value.(*cachedBlock).data}
+	}
+	if fetch, ok := c.inflight[index]; ok {
+		return fetch
+	}
+
+	fetch := &blockFetch{done: make(chan struct{})}
+	c.inflight[index] = fetch
+	go func() {
+		select {
+		case c.slots <- struct{}{}:
+			start := index * c.blockSize
+			end := min64(start+c.blockSize, f.size) - 1
+			fetch.data, fetch.err = f.fetchRange(f.ctx, start, end)
+			<-c.slots
+		case <-f.ctx.Done():
+			fetch.err = f.ctx.Err()
+		}
+		c.store(index, fetch)
+		close(fetch.done)
+	}()
+	return fetch
+}
+
+// store keeps a fetched block, dropping the least recently used ones past
+// the limit. Failed fetches are forgotten so the next read asks again.
+func (c *blockCache) store(index int64, fetch *blockFetch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, index)
+	if fetch.err != nil {
+		return
+	}
+	c.blocks[index] = c.lru.PushFront(&cachedBlock{index: index, data: fetch.data})
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.blocks, oldest.Value.(*cachedBlock).index)
+	}
+}
+
+// fetchRange fetches bytes start-end of the file, forgetting the probe once
+// the file turns out changed
+func (f *RemoteFile) fetchRange(ctx context.Context, start, end int64) ([]byte, error) {
+	data, err := f.d.fetchChunk(ctx, f.client, start, end)
+	if errors.Is(err, ErrRemoteFileChanged) {
+		f.d.forgetProbe()
+	}
+	return data, err
+}
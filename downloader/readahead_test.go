@@ -0,0 +1,141 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves data and records the Range header of every GET
+type rangeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newRangeServer(t *testing.T, data []byte) *rangeServer {
+	s := &rangeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.mu.Lock()
+			s.ranges = append(s.ranges, r.Header.Get("Range"))
+			s.mu.Unlock()
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requests returns the Range headers of the GETs since the last call
+func (s *rangeServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ranges := s.ranges
+	s.ranges = nil
+	return ranges
+}
+
+// openRemote opens the file of server after its probe requests
+func openRemote(t *testing.T, server *rangeServer, ra ReadAhead) *RemoteFile {
+	t.Helper()
+	f, err := newTestDownloader(t, server.URL, 4).OpenRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	f.SetReadAhead(ra)
+	server.requests()
+	return f
+}
+
+func TestReadAheadSequential(t *testing.T) {
+	data := testPayload(256 * 1024)
+	server := newRangeServer(t, data)
+	f := openRemote(t, server, ReadAhead{BlockSize: 16 * 1024, Blocks: 2})
+
+	// Small reads are served from whole blocks, each fetched once
+	got, err := io.ReadAll(io.LimitReader(f, int64(len(data))))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAll = %d bytes, %v", len(got), err)
+	}
+	if requests := server.requests(); len(requests) != 16 {
+		t.Errorf("%d requests for 16 blocks: %v", len(requests), requests)
+	}
+}
+
+func TestReadAheadPrefetches(t *testing.T) {
+	data := testPayload(64 * 1024)
+	server := newRangeServer(t, data)
+	f := openRemote(t, server, ReadAhead{BlockSize: 8 * 1024, Blocks: 2})
+
+	buf := make([]byte, 10)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The two blocks after the read arrive in the background
+	deadline := time.Now().Add(5 * time.Second)
+	var requests []string
+	for len(requests) < 3 && time.Now().Before(deadline) {
+		requests = append(requests, server.requests()...)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("requests after reading block 0: %v", requests)
+	}
+
+	// Block 2 is read from the cache, and blocks 3 and 4 are prefetched
+	if _, err := f.ReadAt(buf, 2*8*1024+5); err != nil || !bytes.Equal(buf, data[2*8*1024+5:2*8*1024+15]) {
+		t.Fatalf("ReadAt in a prefetched block: %v", err)
+	}
+	f.Close()
+	for _, r := range server.requests() {
+		if r == "bytes=16384-24575" {
+			t.Errorf("prefetched block 2 was fetched again")
+		}
+	}
+}
+
+func TestReadAheadEvictsLeastRecentlyUsed(t *testing.T) {
+	data := testPayload(32 * 1024)
+	server := newRangeServer(t, data)
+	f := openRemote(t, server, ReadAhead{BlockSize: 8 * 1024, CacheBlocks: 2})
+
+	buf := make([]byte, 100)
+	for _, block := range []int64{0, 1, 2, 1, 0} {
+		off := block*8*1024 + 50
+		if _, err := f.ReadAt(buf, off); err != nil || !bytes.Equal(buf, data[off:off+100]) {
+			t.Fatalf("ReadAt in block %d: %v", block, err)
+		}
+	}
+	// Block 1 stayed cached, block 0 was dropped for block 2
+	want := []string{"bytes=0-8191", "bytes=8192-16383", "bytes=16384-24575", "bytes=0-8191"}
+	if got := server.requests(); len(got) != len(want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("requests = %v, want %v", got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestReadAheadAcrossBlocks(t *testing.T) {
+	data := testPayload(40 * 1024)
+	server := newRangeServer(t, data)
+	f := openRemote(t, server, ReadAhead{BlockSize: 8 * 1024})
+
+	// A read over several blocks, ending past the end of the file
+	buf := make([]byte, 30*1024)
+	n, err := f.ReadAt(buf, 20*1024)
+	if n != 20*1024 || err != io.EOF || !bytes.Equal(buf[:n], data[20*1024:]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"multithreaded-downloader/secrets"
+)
+
+// ErrLinkExpired is returned when the server refuses an expired link and no
+// new one could be obtained
+var ErrLinkExpired = errors.New("link expired")
+
+// errLinkRefused marks a status error for a response linkExpired accepts;
+// it reads like ErrServerStatus and matches it
+var errLinkRefused = fmt.Errorf("%w", ErrServerStatus)
+
+// RefreshTimeout caps how long a LinkRefresher may take
+const RefreshTimeout = 30 * time.Second
+
+// LinkRefresher returns a new link to the same file when the server refuses
+// the expired one, e.g. a pre-signed S3 or CDN URL past its expiry. The
+// download continues from its saved progress with the new link.
+type LinkRefresher func(ctx context.Context, expired string) (string, error)
+
+// linkExpired reports whether resp refuses a link that may have expired.
+// Pre-signed URLs answer 403 Forbidden once they are past their expiry.
+func linkExpired(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusForbidden
+}
+
+// statusError describes a response with a status the download cannot use
+func statusError(resp *http.Response) error {
+	if linkExpired(resp) {
+		return fmt.Errorf("%w: %s", errLinkRefused, resp.Status)
+	}
+	return fmt.Errorf("%w: %s", ErrServerStatus, resp.Status)
+}
+
+// requestURL returns the link requests go to: the last refreshed one, or URL
+func (d *Downloader) requestURL() string {
+	d.linkMu.Lock()
+	defer d.linkMu.Unlock()
+	if d.link != "" {
+		return d.link
+	}
+	return d.URL
+}
+
+// refreshLink asks RefreshLink for a new link after used was refused. Parts
+// that were refused the same link wait for one refresh instead of each
+// asking for their own.
+func (d *Downloader) refreshLink(ctx context.Context, used string) error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	if current := d.requestURL(); current != used {
+		// Another part already refreshed it
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, RefreshTimeout)
+	defer cancel()
+	fresh, err := d.RefreshLink(ctx, used)
+	if err != nil {
+		return fmt.Errorf("%w: refresh failed: %v", ErrLinkExpired, err)
+	}
+	fresh = strings.TrimSpace(fresh)
+	if !isHTTPURL(fresh) {
+		return fmt.Errorf("%w: refresh returned %q, not an http or https URL", ErrLinkExpired, secrets.RedactURL(fresh))
+	}
+	if fresh == used {
+		return fmt.Errorf("%w: refresh returned the expired link", ErrLinkExpired)
+	}
+
+	d.linkMu.Lock()
+	d.link = fresh
+	d.linkMu.Unlock()
+	fmt.Printf("Link expired, continuing with a refreshed link: %s\n", secrets.RedactURL(fresh))
+	return nil
+}
+
+// refreshExpired returns the status error of resp, a response to link the
+// download cannot use. When the server refused link as expired it is
+// refreshed first, so retrying the request goes to the new link; a failed
+// refresh is returned instead.
+func (d *Downloader) refreshExpired(ctx context.Context, resp *http.Response, link string) error {
+	err := statusError(resp)
+	if linkExpired(resp) && d.RefreshLink != nil {
+		if refreshErr := d.refreshLink(ctx, link); refreshErr != nil {
+			return refreshErr
+		}
+	}
+	return err
+}
+
+// CommandRefresher runs command through the shell to refresh a link. The
+// expired link is in the MTDL_EXPIRED_URL environment variable and the
+// first line the command prints is taken as the new one.
+func CommandRefresher(command string) LinkRefresher {
+	return func(ctx context.Context, expired string) (string, error) {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		cmd.Env = append(os.Environ(), "MTDL_EXPIRED_URL="+expired)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("refresh command: %w", err)
+		}
+		line, _ := bufio.NewReader(bytes.NewReader(out)).ReadString('\n')
+		return strings.TrimSpace(line), nil
+	}
+}
+
+// ValidateRefreshURL accepts an empty refresh endpoint or an http or https
+// URL
+func ValidateRefreshURL(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	if !isHTTPURL(endpoint) {
+		return fmt.Errorf("refresh URL must be an http or https URL, got %q", secrets.RedactURL(endpoint))
+	}
+	return nil
+}
+
+// maxRefreshResponse limits the answer of a refresh endpoint
+const maxRefreshResponse = 64 << 10
+
+// EndpointRefresher refreshes a link by POSTing {"url": <expired link>} to
+// endpoint. The new link is the "url" field of a JSON answer, or else the
+// body of the answer as text.
+func EndpointRefresher(endpoint string) LinkRefresher {
+	return func(ctx context.Context, expired string) (string, error) {
+		body, err := json.Marshal(map[string]string{"url": expired})
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("refresh endpoint: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("refresh endpoint answered %s", resp.Status)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxRefreshResponse))
+		if err != nil {
+			return "", fmt.Errorf("refresh endpoint: %w", err)
+		}
+		var answer struct {
+			URL string `json:"url"`
+		}
+		if json.Unmarshal(data, &answer) == nil && answer.URL != "" {
+			return answer.URL, nil
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+}
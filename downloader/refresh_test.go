@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpiredLinkIsRefreshed(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	// The first link only answers the probe, as if it expired right after
+	var mu sync.Mutex
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old.bin" && r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+			http.Error(w, "Request has expired", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/old.bin", 4)
+	dl.Retry = RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}
+	dl.RefreshLink = func(ctx context.Context, expired string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refreshes++
+		return server.URL + "/new.bin", nil
+	}
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dl.DownloadContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, data) {
+		t.Error("downloaded file does not match")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1 for all parts", refreshes)
+	}
+	if dl.Progress.URL != server.URL+"/old.bin" {
+		t.Errorf("progress URL = %s, want the original link", dl.Progress.URL)
+	}
+}
+
+func TestFailedRefreshStopsDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Request has expired", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 2)
+	dl.RefreshLink = CommandRefresher("echo not-a-link")
+	if _, _, err := dl.SupportsRange(); !errors.Is(err, ErrLinkExpired) {
+		t.Fatalf("SupportsRange() = %v, want ErrLinkExpired", err)
+	}
+}
@@ -0,0 +1,55 @@
+package downloader
+
+import "bytes"
+
+// VerifiedFrom returns how many bytes from offset on can be relayed to
+// other clients while the file downloads: those of the parts that are done
+// and whose bytes on disk match the digest the server sent for their range
+// or that was taken as they arrived. Each part is read back once for every
+// digest it gets, so parts downloaded again after a checksum mismatch are
+// checked again. Parts without a digest, such as those resumed half way,
+// only count once the whole download is verified.
+func (d *Downloader) VerifiedFrom(offset int64) (int64, error) {
+	var n int64
+	for i := range d.Progress.Parts {
+		part := &d.Progress.Parts[i]
+		if part.End < offset+n {
+			continue
+		}
+		if part.Start > offset+n {
+			break
+		}
+		ok, err := d.partVerified(part)
+		if err != nil || !ok {
+			return n, err
+		}
+		n = part.End + 1 - offset
+	}
+	return n, nil
+}
+
+// partVerified reports whether part is done and its bytes on disk match
+// its digest
+func (d *Downloader) partVerified(part *Part) (bool, error) {
+	digest := part.Digest()
+	if !part.Done() || digest == nil {
+		return false, nil
+	}
+	d.verifiedMu.Lock()
+	defer d.verifiedMu.Unlock()
+	if d.verified[part.Index] == digest {
+		return true, nil
+	}
+	sum, err := d.hashPart(part, digest.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(sum, digest.Value) {
+		return false, nil
+	}
+	if d.verified == nil {
+		d.verified = make(map[int]*Digest)
+	}
+	d.verified[part.Index] = digest
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifiedFromChecksPartsOnDisk(t *testing.T) {
+	data := testPayload(1000)
+	filename := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl := NewDownloader("https://example.com/a.bin", filename, 4)
+	dl.Progress = CreateNewProgress(dl.URL, filename, int64(len(data)), 4)
+	for i := range dl.Progress.Parts[:3] {
+		part := &dl.Progress.Parts[i]
+		sum := sha256.Sum256(data[part.Start : part.End+1])
+		part.SetDownloaded(part.Size())
+		part.SetDone(true)
+		part.SetDigest(&Digest{Algorithm: "sha-256", Value: sum[:], Source: ReceivedDigest})
+	}
+	// The last part is done but has no digest, as when it was resumed
+	dl.Progress.Parts[3].SetDownloaded(250)
+	dl.Progress.Parts[3].SetDone(true)
+
+	if n, err := dl.VerifiedFrom(100); err != nil || n != 650 {
+		t.Fatalf("VerifiedFrom(100) = %d, %v, want 650", n, err)
+	}
+
+	// A part whose bytes changed on disk is no longer served
+	data[600] ^= 0xff
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl.verified = nil
+	if n, err := dl.VerifiedFrom(0); err != nil || n != 500 {
+		t.Errorf("VerifiedFrom(0) after corruption = %d, %v, want 500", n, err)
+	}
+	if n, _ := dl.VerifiedFrom(600); n != 0 {
+		t.Errorf("VerifiedFrom(600) = %d, want 0", n)
+	}
+}
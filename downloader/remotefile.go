@@ -0,0 +1,235 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRangesUnsupported is returned when a file must be read at offsets but
+// its server does not serve byte ranges
+var ErrRangesUnsupported = errors.New("server does not support range requests")
+
+// DefaultRemoteThreads is the thread count, and so the number of pooled
+// connections, of the files RemoteFS opens
+const DefaultRemoteThreads = 4
+
+// RemoteFile reads sections of a remote file with ranged requests, so a Go
+// program can read the index at the end of a huge zip without downloading
+// the rest. Requests go through the Downloader it was opened with: its
+// headers, cookies, link refresh, rate limits and a connection pool of
+// NumThreads connections. Reads fail with ErrRemoteFileChanged once the
+// file's ETag changes.
+//
+// A RemoteFile implements io.ReaderAt, io.ReadSeeker and fs.File. ReadAt
+// may be called from several goroutines at once; Read and Seek share an
+// offset like an os.File. Each read is a request of its own unless
+// SetReadAhead adds a block cache.
+type RemoteFile struct {
+	d      *Downloader
+	client *http.Client
+	// ctx bounds every request, including prefetches; io.ReaderAt has no
+	// place for one. Close cancels it.
+	ctx    context.Context
+	cancel context.CancelFunc
+	name   string
+	size   int64
+
+	mu     sync.Mutex
+	offset int64
+
+	blocksMu sync.Mutex
+	blocks   *blockCache
+}
+
+// OpenRemote probes the file of d and returns a RemoteFile reading it. Reads
+// stop when ctx is cancelled. Files whose server ignores ranges, custom
+// requests, encrypted and joined downloads cannot be opened.
+func (d *Downloader) OpenRemote(ctx context.Context) (*RemoteFile, error) {
+	if d.EncryptionKey != nil {
+		return nil, errors.New("encrypted downloads cannot be read remotely")
+	}
+	if d.joined() {
+		return nil, fmt.Errorf("remote reading is %w", ErrJoinUnsupported)
+	}
+	if d.customRequest() {
+		return nil, fmt.Errorf("%w with %s requests", ErrRangesUnsupported, d.requestMethod())
+	}
+
+	supportsRanges, size, err := d.SupportsRange()
+	if err != nil {
+		return nil, fmt.Errorf("error checking server capabilities: %w", err)
+	}
+	if !supportsRanges {
+		return nil, ErrRangesUnsupported
+	}
+	// Every read checks the response against the probed size and ETag
+	d.Progress = CreateNewProgress(d.URL, d.Filename, size, 1)
+	d.Progress.ETag = d.etag
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &RemoteFile{
+		d:      d,
+		client: d.newPartClient(),
+		ctx:    ctx,
+		cancel: cancel,
+		name:   remoteName(d.URL),
+		size:   size,
+	}, nil
+}
+
+// remoteName is the last element of the URL's path, the name fs.FileInfo
+// reports for a remote file
+func remoteName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "/" && name != "." {
+			return name
+		}
+	}
+	return "file"
+}
+
+// Size returns the length of the file in bytes
+func (f *RemoteFile) Size() int64 {
+	return f.size
+}
+
+// ReadAt reads len(p) bytes from offset off with one ranged request, or from
+// the blocks of the read-ahead cache. Like io.ReaderAt requires, fewer bytes
+// come only with an error: io.EOF at the end of the file.
+func (f *RemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: negative offset %d", ErrInvalidRange, off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var n int
+	if c := f.readAhead(); c != nil {
+		var err error
+		if n, err = f.readCached(c, p, off); err != nil {
+			return n, err
+		}
+	} else {
+		end := min64(off+int64(len(p)), f.size) - 1
+		data, err := f.fetchRange(f.ctx, off, end)
+		if err != nil {
+			return 0, err
+		}
+		n = copy(p, data)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads from the current offset and moves it past the bytes read
+func (f *RemoteFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		// The next Read reports the end
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read, as io.Seeker describes
+func (f *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("%w: bad whence %d", ErrInvalidRange, whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%w: negative offset %d", ErrInvalidRange, offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Stat describes the file for fs.File; its modification time is the
+// server's Last-Modified
+func (f *RemoteFile) Stat() (fs.FileInfo, error) {
+	modTime, _ := http.ParseTime(f.d.lastModified)
+	return remoteFileInfo{name: f.name, size: f.size, modTime: modTime}, nil
+}
+
+// Close stops the file's prefetches and lets go of its idle connections
+func (f *RemoteFile) Close() error {
+	f.cancel()
+	f.client.CloseIdleConnections()
+	return nil
+}
+
+// remoteFileInfo is the fs.FileInfo of a RemoteFile
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i remoteFileInfo) Name() string       { return i.name }
+func (i remoteFileInfo) Size() int64        { return i.size }
+func (i remoteFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i remoteFileInfo) ModTime() time.Time { return i.modTime }
+func (i remoteFileInfo) IsDir() bool        { return false }
+func (i remoteFileInfo) Sys() interface{}   { return nil }
+
+// RemoteFS is a read-only fs.FS of the files under a base URL: Open("a/b.zip")
+// opens Base + "/a/b.zip" as a RemoteFile. Directories cannot be listed.
+type RemoteFS struct {
+	// Base is the URL the names are relative to
+	Base string
+	// Context bounds the requests of every opened file; nil means
+	// context.Background()
+	Context context.Context
+	// Configure, when set, prepares the Downloader of each opened file,
+	// e.g. with headers, a rate limit or a thread count
+	Configure func(*Downloader)
+	// ReadAhead is the block cache of every opened file; zero has none
+	ReadAhead ReadAhead
+}
+
+// Open probes and opens the file name
+func (rfs RemoteFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	rawURL := strings.TrimSuffix(rfs.Base, "/") + "/" + (&url.URL{Path: name}).EscapedPath()
+	d := NewDownloader(rawURL, "", DefaultRemoteThreads)
+	if rfs.Configure != nil {
+		rfs.Configure(d)
+	}
+	ctx := rfs.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	f, err := d.OpenRemote(ctx)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.SetReadAhead(rfs.ReadAhead)
+	return f, nil
+}
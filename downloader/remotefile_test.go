@@ -0,0 +1,175 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteFileReadsZip(t *testing.T) {
+	// A large stored member the zip index comes after
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	big, _ := zw.CreateHeader(&zip.FileHeader{Name: "big.bin", Method: zip.Store})
+	big.Write(testPayload(1 << 20))
+	small, _ := zw.Create("notes.txt")
+	small.Write([]byte("hello from the end of the archive"))
+	zw.Close()
+
+	var served int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"zip"`)
+		cw := &countingWriter{ResponseWriter: w}
+		http.ServeContent(cw, r, "", time.Time{}, bytes.NewReader(archive.Bytes()))
+		atomic.AddInt64(&served, cw.n)
+	}))
+	defer server.Close()
+
+	f, err := newTestDownloader(t, server.URL, 4).OpenRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zip.NewReader(f, f.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range zr.File {
+		if member.Name != "notes.txt" {
+			continue
+		}
+		rc, err := member.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != "hello from the end of the archive" {
+			t.Errorf("notes.txt = %q", got)
+		}
+	}
+	// Only the index and the small member were fetched
+	if n := atomic.LoadInt64(&served); n >= int64(archive.Len())/2 {
+		t.Errorf("fetched %d bytes of a %d byte archive", n, archive.Len())
+	}
+}
+
+// countingWriter counts the body bytes a handler writes
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func TestRemoteFileReadSeek(t *testing.T) {
+	data := testPayload(10000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	f, err := newTestDownloader(t, server.URL, 2).OpenRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(got, data[len(data)-100:]) {
+		t.Errorf("read the last 100 bytes: %d bytes, %v", len(got), err)
+	}
+
+	// ReadAt past the end returns what there is with io.EOF
+	buf := make([]byte, 50)
+	if n, err := f.ReadAt(buf, int64(len(data)-20)); n != 20 || err != io.EOF || !bytes.Equal(buf[:n], data[len(data)-20:]) {
+		t.Errorf("ReadAt across the end = %d, %v", n, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Seek before the start: err = %v", err)
+	}
+}
+
+func TestRemoteFileNeedsRanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5000")
+		io.WriteString(w, strings.Repeat("x", 5000))
+	}))
+	defer server.Close()
+
+	if _, err := newTestDownloader(t, server.URL, 2).OpenRemote(context.Background()); !errors.Is(err, ErrRangesUnsupported) {
+		t.Errorf("OpenRemote without ranges: err = %v, want ErrRangesUnsupported", err)
+	}
+}
+
+func TestRemoteFileChanged(t *testing.T) {
+	data := testPayload(10000)
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag.Load().(string))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	f, err := newTestDownloader(t, server.URL, 2).OpenRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag.Store(`"v2"`)
+	if _, err := f.ReadAt(make([]byte, 10), 100); !errors.Is(err, ErrRemoteFileChanged) {
+		t.Errorf("ReadAt after the file changed: err = %v, want ErrRemoteFileChanged", err)
+	}
+}
+
+func TestRemoteFS(t *testing.T) {
+	data := testPayload(3000)
+	modified := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/dir/a b.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "", modified, bytes.NewReader(data))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fsys := RemoteFS{
+		Base:      server.URL + "/files/",
+		Configure: func(d *Downloader) { d.Headers = map[string]string{"X-Token": "secret"} },
+	}
+	got, err := fs.ReadFile(fsys, "dir/a b.bin")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadFile = %d bytes, %v", len(got), err)
+	}
+	info, err := fs.Stat(fsys, "dir/a b.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "a b.bin" || info.Size() != int64(len(data)) || !info.ModTime().Equal(modified) {
+		t.Errorf("Stat = %s, %d bytes, modified %s", info.Name(), info.Size(), info.ModTime())
+	}
+
+	if _, err := fsys.Open("missing.bin"); !errors.Is(err, ErrServerStatus) {
+		t.Errorf("Open of a missing file: err = %v, want ErrServerStatus", err)
+	}
+	if _, err := fsys.Open("../escape"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open outside the base: err = %v, want fs.ErrInvalid", err)
+	}
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import "context"
+
+const (
+	// DefaultBufferSize is the read buffer of each part when memory is not capped
+	DefaultBufferSize = 32 * 1024
+	// MinBufferSize is the smallest read buffer a part is given under a
+	// memory cap; below it the cap runs fewer parts at once instead
+	MinBufferSize = 4 * 1024
+)
+
+// Resources caps what one download may use on a host it shares with other
+// work. The zero value leaves the download unlimited.
+type Resources struct {
+	// MaxThreads caps how many parts transfer at once, whatever the
+	// download asks for
+	MaxThreads int
+	// MaxBufferMemory caps the bytes buffered by all parts of the download
+	// together: read buffers and, when encrypting, the chunk being sealed
+	MaxBufferMemory int64
+	// SyncEvery flushes a part's writes to disk after this many bytes, so a
+	// crash loses little and dirty pages do not pile up; zero only flushes
+	// when a part stops transferring, if Syncs is set
+	SyncEvery int64
+	// Syncs, when set, limits how many flushes run at once. Share one Gate
+	// between downloads to cap flushes across a host.
+	Syncs *Gate
+	// Connections, when set, limits how many parts transfer at once on top
+	// of the download's own thread limit. Share one Gate between downloads
+	// to cap the connections of a host however many downloads run.
+	Connections *Gate
+}
+
+// syncing reports whether parts flush their writes to disk
+func (r Resources) syncing() bool {
+	return r.SyncEvery > 0 || r.Syncs != nil
+}
+
+// partMemory returns the bytes one running part holds besides its read buffer
+func (d *Downloader) partMemory() int64 {
+	if d.EncryptionKey != nil {
+		return EncryptedChunkSize + chunkOverhead
+	}
+	return 0
+}
+
+// memoryThreads returns how many parts fit under the memory cap at once,
+// or zero when memory is not capped
+func (d *Downloader) memoryThreads() int {
+	if d.Resources.MaxBufferMemory <= 0 {
+		return 0
+	}
+	threads := int(d.Resources.MaxBufferMemory / (MinBufferSize + d.partMemory()))
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
+}
+
+// bufferSize returns the read buffer of each part, sharing the memory cap
+// between the parts that may run at once
+func (d *Downloader) bufferSize() int {
+	if d.Resources.MaxBufferMemory <= 0 {
+		return DefaultBufferSize
+	}
+	size := d.Resources.MaxBufferMemory/int64(d.Threads()) - d.partMemory()
+	if size > DefaultBufferSize {
+		return DefaultBufferSize
+	}
+	if size < MinBufferSize {
+		return MinBufferSize
+	}
+	return int(size)
+}
+
+// capThreads lowers a thread limit to what the resource caps allow
+func (d *Downloader) capThreads(n int) int {
+	for _, limit := range []int{d.Resources.MaxThreads, d.memoryThreads()} {
+		if limit > 0 && (n <= 0 || n > limit) {
+			n = limit
+		}
+	}
+	return n
+}
+
+// acquireSlot waits for a thread slot of the download and then for a shared
+// connection, and reports false if ctx ended first
+func (d *Downloader) acquireSlot(ctx context.Context) bool {
+	if !d.threads.Acquire(ctx) {
+		return false
+	}
+	if d.Resources.Connections != nil && !d.Resources.Connections.Acquire(ctx) {
+		d.threads.Release()
+		return false
+	}
+	return true
+}
+
+// releaseSlot gives back the shared connection and the thread slot taken
+// by acquireSlot
+func (d *Downloader) releaseSlot() {
+	if d.Resources.Connections != nil {
+		d.Resources.Connections.Release()
+	}
+	d.threads.Release()
+}
+
+// retireSlot gives up a slot taken by acquireSlot if the download runs more
+// parts than its thread limit allows, and reports whether it did
+func (d *Downloader) retireSlot() bool {
+	if !d.threads.Retire() {
+		return false
+	}
+	if d.Resources.Connections != nil {
+		d.Resources.Connections.Release()
+	}
+	return true
+}
+
+// syncPart flushes a part's writes to disk, waiting for a free flush slot
+func (d *Downloader) syncPart(ctx context.Context, writer partWriter) error {
+	if d.Resources.Syncs != nil {
+		if !d.Resources.Syncs.Acquire(ctx) {
+			return ctx.Err()
+		}
+		defer d.Resources.Syncs.Release()
+	}
+	return writer.Sync()
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResourcesCapThreadsAndBuffers(t *testing.T) {
+	dl := NewDownloader("http://example.com/file", "out.bin", 64)
+	dl.Progress = CreateNewProgress(dl.URL, dl.Filename, 64*1024*1024, 64)
+
+	dl.Resources = Resources{MaxThreads: 8, MaxBufferMemory: 128 * 1024}
+	dl.SetThreads(0)
+	if dl.Threads() != 8 {
+		t.Errorf("Threads() = %d, want the MaxThreads cap of 8", dl.Threads())
+	}
+	if size := dl.bufferSize(); size != 16*1024 {
+		t.Errorf("bufferSize() = %d, want 128KB shared by 8 parts", size)
+	}
+
+	// A cap too small for the minimum buffer runs fewer parts instead
+	dl.Resources = Resources{MaxBufferMemory: 16 * 1024}
+	dl.SetThreads(32)
+	if dl.Threads() != 4 || dl.bufferSize() != MinBufferSize {
+		t.Errorf("Threads() = %d, bufferSize() = %d under a 16KB cap", dl.Threads(), dl.bufferSize())
+	}
+
+	// Encrypting parts also hold a chunk each
+	dl.EncryptionKey = make([]byte, EncryptionKeySize)
+	dl.SetThreads(32)
+	if dl.Threads() != 1 {
+		t.Errorf("Threads() = %d, want 1 when a single chunk exceeds the cap", dl.Threads())
+	}
+}
+
+func TestDownloadWithinResourceLimits(t *testing.T) {
+	data := testPayload(512 * 1024)
+	server := newFaultServer(t, data, faultSlowDrip)
+	dl := newTestDownloader(t, server.URL, 8)
+	syncs := NewGate(1)
+	dl.Resources = Resources{MaxThreads: 2, MaxBufferMemory: 16 * 1024, SyncEvery: 64 * 1024, Syncs: syncs}
+
+	done := make(chan error, 1)
+	go func() { done <- runDownload(t, dl) }()
+
+	var peak int
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			if peak > 2 {
+				t.Errorf("%d parts ran at once under a cap of 2", peak)
+			}
+			if syncs.Active() != 0 {
+				t.Errorf("%d flush slots still held", syncs.Active())
+			}
+			got, err := os.ReadFile(dl.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("downloaded file does not match under resource limits")
+			}
+			return
+		case <-time.After(time.Millisecond):
+			if active := dl.ActiveThreads(); active > peak {
+				peak = active
+			}
+		}
+	}
+}
+
+func TestDownloadsShareConnections(t *testing.T) {
+	data := testPayload(256 * 1024)
+	server := newFaultServer(t, data, faultSlowDrip)
+	connections := NewGate(3)
+
+	downloads := make([]*Downloader, 3)
+	done := make(chan error, len(downloads))
+	for i := range downloads {
+		dl := newTestDownloader(t, server.URL, 4)
+		dl.Resources = Resources{Connections: connections}
+		downloads[i] = dl
+		go func() { done <- runDownload(t, dl) }()
+	}
+
+	var peak int
+	for finished := 0; finished < len(downloads); {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			finished++
+		case <-time.After(time.Millisecond):
+			if active := connections.Active(); active > peak {
+				peak = active
+			}
+		}
+	}
+	// Each download alone would have run 4 parts
+	if peak != 3 {
+		t.Errorf("at most %d parts held a shared connection, want the budget of 3", peak)
+	}
+	if connections.Active() != 0 {
+		t.Errorf("%d connection slots still held", connections.Active())
+	}
+	for _, dl := range downloads {
+		got, err := os.ReadFile(dl.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("downloaded file does not match with shared connections")
+		}
+	}
+}
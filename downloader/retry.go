@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultRetryDelay is the pause before a failed part request is tried again
+const DefaultRetryDelay = time.Second
+
+// ErrRetriesExhausted is returned when a part keeps failing after the
+// attempts its retry policy allows
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// RetryPolicy is how a part retries failed requests
+type RetryPolicy struct {
+	// MaxAttempts is how many attempts in a row may fail before the
+	// download gives up; zero retries until the download is stopped
+	MaxAttempts int
+	// Delay is the pause before the next attempt; zero waits DefaultRetryDelay
+	Delay time.Duration
+}
+
+// exhausted reports whether failures attempts in a row use up the policy
+func (p RetryPolicy) exhausted(failures int) bool {
+	return p.MaxAttempts > 0 && failures >= p.MaxAttempts
+}
+
+// wait pauses before the next attempt and reports false if ctx ends first
+func (p RetryPolicy) wait(ctx context.Context) bool {
+	delay := p.Delay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	// Probes succeed, but every range request fails
+	var mu sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+			mu.Lock()
+			attempts++
+			mu.Unlock()
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 1)
+	dl.Retry = RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dl.DownloadContext(ctx); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("DownloadContext() = %v, want ErrRetriesExhausted", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("range requests = %d, want 3", attempts)
+	}
+}
+
+func TestRetryPolicyCountsThrottling(t *testing.T) {
+	data := testPayload(4 * SmallFileSize)
+	// Every range request is throttled without asking for any pause
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/file.bin", 1)
+	dl.Retry = RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dl.DownloadContext(ctx); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("DownloadContext() = %v, want ErrRetriesExhausted", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 3 {
+		t.Fatalf("range requests = %d, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < MinRetryAfter-50*time.Millisecond {
+			t.Errorf("request %d came %s after the previous one, want at least %s", i, gap, MinRetryAfter)
+		}
+	}
+}
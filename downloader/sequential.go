@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SequentialPartSize is the part size of a sequential download. It is a
+// whole number of encryption chunks so encrypted parts need no aligning.
+const SequentialPartSize = 16 * EncryptedChunkSize
+
+// splitSequential re-splits the file into parts of partSize bytes, to be
+// downloaded lowest first. NumThreads stays the number of parts fetched at
+// once.
+func (p *Progress) splitSequential(partSize int64) {
+	var parts []Part
+	for start := int64(0); start < p.TotalSize; start += partSize {
+		end := start + partSize - 1
+		if end >= p.TotalSize {
+			end = p.TotalSize - 1
+		}
+		parts = append(parts, Part{Index: len(parts), Start: start, End: end})
+	}
+	p.Parts = parts
+	p.Sequential = true
+}
+
+// ContiguousBytes returns how many bytes from the start of the file are
+// downloaded without a gap, which is how much of a sequential download a
+// player can already read
+func (p *Progress) ContiguousBytes() int64 {
+	return p.AvailableFrom(0)
+}
+
+// downloadInOrder starts the parts of a sequential download lowest first,
+// after the head and tail of a preview download, never more at once than
+// the thread limit, so the download moves through the file as a window of
+// parallel requests near its start
+func (d *Downloader) downloadInOrder(ctx context.Context, progressMutex *sync.Mutex, wg *sync.WaitGroup, fail func(error)) {
+	finished := make(chan struct{}, len(d.Progress.Parts))
+	running := 0
+	for _, i := range d.Progress.partOrder() {
+		part := &d.Progress.Parts[i]
+		if part.Done() {
+			continue
+		}
+		// The limit can be raised while the download runs, so look again
+		// every so often rather than only when a part finishes
+		for running >= d.Threads() {
+			select {
+			case <-finished:
+				running--
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		running++
+		wg.Add(1)
+		go func() {
+			defer func() { finished <- struct{}{} }()
+			d.downloadPart(ctx, part, progressMutex, wg, fail)
+		}()
+	}
+}
@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitSequential(t *testing.T) {
+	progress := CreateNewProgress("https://example.com/a", "a", 2*SequentialPartSize+10, 4)
+	progress.splitSequential(SequentialPartSize)
+	if err := progress.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress.Parts) != 3 || progress.NumThreads != 4 || progress.Parts[2].Size() != 10 {
+		t.Fatalf("splitSequential() = %d parts for %d threads", len(progress.Parts), progress.NumThreads)
+	}
+
+	progress.Parts[0].SetDownloaded(SequentialPartSize)
+	progress.Parts[0].SetDone(true)
+	progress.Parts[1].SetDownloaded(100)
+	progress.Parts[2].SetDownloaded(10)
+	progress.Parts[2].SetDone(true)
+	if got := progress.ContiguousBytes(); got != SequentialPartSize+100 {
+		t.Errorf("ContiguousBytes() = %d, want %d", got, SequentialPartSize+100)
+	}
+}
+
+func TestSequentialDownloadFetchesInOrder(t *testing.T) {
+	data := testPayload(6*SequentialPartSize + 123)
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+
+	dl := newTestDownloader(t, server.URL+"/video.mp4", 1)
+	dl.Sequential = true
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	got, err := os.ReadFile(dl.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match")
+	}
+	// With one thread every part starts after the one before it
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 7 {
+		t.Fatalf("got %d range requests, want 7: %v", len(ranges), ranges)
+	}
+	for i, r := range ranges {
+		if want := &dl.Progress.Parts[i]; r != fmt.Sprintf("bytes=%d-%d", want.Start, want.End) {
+			t.Errorf("request %d asked for %s, want part %d", i, r, i)
+		}
+	}
+}
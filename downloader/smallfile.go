@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SmallFileSize is the largest file downloaded in a single request, without
+// splitting it into parts or saving a progress file; there is too little to
+// resume for the bookkeeping to pay off
+const SmallFileSize = 64 * 1024
+
+// smallFileAttempts is how often a small file is requested before giving up
+const smallFileAttempts = 3
+
+// Small reports whether the download is fetched in a single request
+func (d *Downloader) Small() bool {
+	return d.small
+}
+
+// downloadSmall fetches the whole file with one request. Empty files are
+// complete once the output file is created.
+func (d *Downloader) downloadSmall(ctx context.Context) error {
+	if d.EncryptionKey != nil {
+		if err := d.prepareEncryptedFile(); err != nil {
+			return err
+		}
+	} else {
+		file, err := os.Create(LongPath(d.Filename))
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		file.Close()
+	}
+
+	if d.Progress.TotalSize == 0 {
+		for i := range d.Progress.Parts {
+			d.Progress.Parts[i].SetDone(true)
+		}
+		return nil
+	}
+
+	part := &d.Progress.Parts[0]
+	client := d.newPartClient()
+	defer client.CloseIdleConnections()
+
+	var err error
+	for attempt := 1; attempt <= smallFileAttempts; attempt++ {
+		if err = d.fetchSmall(ctx, client, part); err == nil || ctx.Err() != nil || errors.Is(err, ErrLinkExpired) {
+			break
+		}
+		fmt.Printf("Attempt %d of %d failed: %v\n", attempt, smallFileAttempts, err)
+		if attempt < smallFileAttempts {
+			time.Sleep(time.Second)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	part.SetDone(true)
+	return nil
+}
+
+// fetchSmall makes one attempt at downloading a small file into part
+func (d *Downloader) fetchSmall(ctx context.Context, client *http.Client, part *Part) error {
+	part.Reset()
+
+	// The probe of a custom request already holds the file
+	link := d.requestURL()
+	resp := d.takePending(0)
+	if resp == nil {
+		req, err := d.newRequest(ctx, "GET")
+		if err != nil {
+			return fmt.Errorf("failed to create %s request: %w", d.requestMethod(), err)
+		}
+		if !waitForHost(ctx, req.URL.Host) {
+			return ctx.Err()
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to make %s request: %w", d.requestMethod(), err)
+		}
+	}
+	defer resp.Body.Close()
+
+	if delay, ok := throttleFromResponse(resp.Request.URL.Host, resp); ok {
+		return fmt.Errorf("server throttled requests (%s), retry after %s", resp.Status, delay)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return d.refreshExpired(ctx, resp, link)
+	}
+	size := d.Progress.TotalSize
+	if resp.ContentLength >= 0 && resp.ContentLength != size {
+		return fmt.Errorf("server sent %d bytes, expected %d", resp.ContentLength, size)
+	}
+
+	d.Progress.addDigests(responseDigests(resp, false))
+
+	writer, err := d.openPartWriter(0)
+	if err != nil {
+		return fmt.Errorf("error opening output file: %w", err)
+	}
+	defer writer.Close()
+
+	// Read one byte past the expected size to notice a longer body
+	body := io.LimitReader(resp.Body, size+1)
+	buffer := make([]byte, d.bufferSize())
+	var received int64
+	for {
+		n, readErr := body.Read(buffer)
+		if n > 0 {
+			if received += int64(n); received > size {
+				return fmt.Errorf("server sent more than the expected %d bytes", size)
+			}
+			committed, err := writer.write(buffer[:n])
+			part.AddDownloaded(committed)
+			if err != nil {
+				return fmt.Errorf("error writing to file: %w", err)
+			}
+			if d.waitRate(ctx, n) != nil {
+				return ctx.Err()
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("error reading response: %w", readErr)
+		}
+	}
+	if received < size {
+		return fmt.Errorf("short response: got %d of %d bytes", received, size)
+	}
+	if readTrailers(resp) {
+		d.Progress.addDigests(responseDigests(resp, true))
+	}
+
+	if d.Resources.syncing() {
+		if err := d.syncPart(ctx, writer); err != nil {
+			return fmt.Errorf("error flushing file: %w", err)
+		}
+	}
+	return nil
+}
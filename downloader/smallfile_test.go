@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSmallFileSingleRequest(t *testing.T) {
+	for _, f := range []fault{faultNone, faultTruncate} {
+		data := testPayload(10 * 1024)
+		server := newFaultServer(t, data, f)
+		dl := newTestDownloader(t, server.URL, 8)
+
+		if err := runDownload(t, dl); err != nil {
+			t.Fatalf("fault %d: download failed: %v", f, err)
+		}
+		if !dl.Small() || len(dl.Progress.Parts) != 1 || dl.RequestedThreads() != 8 {
+			t.Errorf("fault %d: Small() = %v with %d parts, RequestedThreads() = %d", f, dl.Small(), len(dl.Progress.Parts), dl.RequestedThreads())
+		}
+		if _, err := os.Stat(dl.ProgressFile); !os.IsNotExist(err) {
+			t.Errorf("fault %d: small download wrote a progress file: %v", f, err)
+		}
+		if err := dl.VerifyDownload(); err != nil {
+			t.Fatalf("fault %d: VerifyDownload() error = %v", f, err)
+		}
+		got, err := os.ReadFile(dl.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("fault %d: downloaded file does not match", f)
+		}
+	}
+}
+
+func TestZeroByteFile(t *testing.T) {
+	server := newFaultServer(t, nil, faultNone)
+	dl := newTestDownloader(t, server.URL, 4)
+	// A leftover file from an earlier run must not survive
+	if err := os.WriteFile(dl.Filename, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runDownload(t, dl); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if !dl.Progress.IsComplete() || dl.Progress.GetOverallPercent() != 100 {
+		t.Errorf("empty download is %.0f%% complete", dl.Progress.GetOverallPercent())
+	}
+	if served := server.bytesServed(); served != 0 {
+		t.Errorf("server sent %d bytes for an empty file", served)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatalf("VerifyDownload() error = %v", err)
+	}
+	if info, err := os.Stat(dl.Filename); err != nil || info.Size() != 0 {
+		t.Fatalf("output file = %v, %v; want an empty file", info, err)
+	}
+}
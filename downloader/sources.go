@@ -0,0 +1,311 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"multithreaded-downloader/secrets"
+)
+
+// ErrJoinUnsupported is returned for settings a download joined from pieces
+// cannot be combined with
+var ErrJoinUnsupported = errors.New("not supported for a file joined from pieces")
+
+// Source is one of the pre-split pieces a file is published as, such as
+// file.z01 and file.z02; the file is its pieces joined in order
+type Source struct {
+	URL string `json:"url"`
+	// Start is where the piece begins in the joined file
+	Start int64 `json:"start"`
+	Size  int64 `json:"size"`
+	// Ranges is set when the piece's server answers range requests; a
+	// piece without is fetched as a single part
+	Ranges bool `json:"ranges,omitempty"`
+	// ETag identifies the version of the piece the parts were fetched from
+	ETag string `json:"etag,omitempty"`
+}
+
+// End returns the last byte of the piece in the joined file
+func (s Source) End() int64 {
+	return s.Start + s.Size - 1
+}
+
+// NewJoinedDownloader creates a downloader that fetches the pieces at urls
+// in parallel and writes them one after another into filename, as one
+// download with one progress
+func NewJoinedDownloader(urls []string, filename string, numThreads int) *Downloader {
+	d := NewDownloader(urls[0], filename, numThreads)
+	d.PartURLs = make([]string, len(urls))
+	for i, u := range urls {
+		d.PartURLs[i] = NormalizeURL(u)
+	}
+	return d
+}
+
+// MaxPartURLs is the most pieces a file may be joined from
+const MaxPartURLs = 1000
+
+// ValidatePartURLs checks the pieces an API request joins into one file:
+// none, or two or more http or https URLs starting with url, which names
+// the download
+func ValidatePartURLs(url string, parts []string) error {
+	if len(parts) == 0 {
+		return nil
+	}
+	if len(parts) < 2 || len(parts) > MaxPartURLs {
+		return fmt.Errorf("part_urls must list 2 to %d pieces, got %d", MaxPartURLs, len(parts))
+	}
+	if parts[0] != url {
+		return errors.New("url must be the first of part_urls")
+	}
+	for i, part := range parts {
+		if !isHTTPURL(part) {
+			return fmt.Errorf("piece %d is not an http or https URL", i+1)
+		}
+	}
+	return nil
+}
+
+// joined reports whether the download is joined from pieces
+func (d *Downloader) joined() bool {
+	return len(d.PartURLs) > 0
+}
+
+// checkJoinable rejects settings that need the file from a single URL
+func (d *Downloader) checkJoinable() error {
+	switch {
+	case d.customRequest():
+		return fmt.Errorf("a custom request method or body is %w", ErrJoinUnsupported)
+	case d.EncryptionKey != nil:
+		return fmt.Errorf("encryption is %w", ErrJoinUnsupported)
+	case d.Sequential:
+		return fmt.Errorf("sequential downloading is %w", ErrJoinUnsupported)
+	}
+	return nil
+}
+
+// probeSources asks the server of every piece for its size and range
+// support and lays the pieces out one after another
+func (d *Downloader) probeSources(ctx context.Context) ([]Source, int64, error) {
+	if err := d.checkJoinable(); err != nil {
+		return nil, 0, err
+	}
+	client := d.newPartClient()
+	defer client.CloseIdleConnections()
+
+	sources := make([]Source, len(d.PartURLs))
+	var total int64
+	for i, link := range d.PartURLs {
+		source, err := d.probeSource(ctx, client, link)
+		if err != nil {
+			return nil, 0, fmt.Errorf("piece %d (%s): %w", i+1, secrets.RedactURL(link), err)
+		}
+		source.Start = total
+		sources[i] = source
+		total += source.Size
+	}
+	fmt.Printf("Joining %d pieces into %d bytes (%.2f MB)\n", len(sources), total, float64(total)/(1024*1024))
+	return sources, total, nil
+}
+
+// probeSource asks for the first byte of the piece at link, which tells its
+// size and whether its server answers range requests
+func (d *Downloader) probeSource(ctx context.Context, client *http.Client, link string) (Source, error) {
+	source := Source{URL: link}
+	req, err := d.newSourceRequest(ctx, link)
+	if err != nil {
+		return source, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	if !waitForHost(ctx, req.URL.Host) {
+		return source, ctx.Err()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return source, err
+	}
+	defer resp.Body.Close()
+
+	if delay, ok := throttleFromResponse(resp.Request.URL.Host, resp); ok {
+		return source, fmt.Errorf("server throttled requests (%s), retry after %s", resp.Status, delay)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return source, err
+		}
+		if cr.Total < 0 {
+			return source, errors.New("server did not report the size of the piece")
+		}
+		source.Size, source.Ranges = cr.Total, true
+	case http.StatusOK:
+		if resp.ContentLength < 0 {
+			return source, errors.New("server did not report the size of the piece")
+		}
+		source.Size = resp.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Only an empty piece has no first byte
+		source.Ranges = true
+	default:
+		return source, statusError(resp)
+	}
+	source.ETag = strongETag(resp.Header.Get("ETag"))
+	return source, nil
+}
+
+// newSourceRequest builds a GET request for the piece at link with the
+// download's headers
+func (d *Downloader) newSourceRequest(ctx context.Context, link string) (*http.Request, error) {
+	req, err := d.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL, err = url.Parse(link); err != nil {
+		return nil, err
+	}
+	req.Host = req.URL.Host
+	return req, nil
+}
+
+// createJoinedProgress starts the progress of a download joined from
+// pieces, which are split into parts by piece
+func (d *Downloader) createJoinedProgress() error {
+	sources, totalSize, err := d.probeSources(context.Background())
+	if err != nil {
+		return fmt.Errorf("error checking server capabilities: %w", err)
+	}
+
+	requested := d.NumThreads
+	d.Progress = CreateNewProgress(d.URL, d.Filename, totalSize, 1)
+	d.Progress.Sources = sources
+	d.Progress.Parts = sourceParts(sources, totalSize, d.NumThreads, d.MinPartSize)
+	if len(d.Progress.Parts) < d.NumThreads {
+		d.NumThreads = len(d.Progress.Parts)
+	}
+	d.Progress.NumThreads = d.NumThreads
+	if d.NumThreads != requested {
+		d.Progress.RequestedThreads = requested
+	}
+	if d.Continue {
+		if err := d.continueExisting(); err != nil {
+			return err
+		}
+	}
+	d.resumed = false
+	d.small = false
+	return SaveProgress(d.ProgressFile, d.Progress)
+}
+
+// sourceParts splits the pieces into parts, giving each piece a share of the
+// threads by its size. Parts never cross from one piece into the next, and
+// a piece whose server ignores ranges is a single part.
+func sourceParts(sources []Source, totalSize int64, threads int, minPartSize int64) []Part {
+	var parts []Part
+	for _, source := range sources {
+		n := 1
+		if source.Ranges && totalSize > 0 {
+			n = int((int64(threads)*source.Size + totalSize - 1) / totalSize)
+			n = EffectiveThreads(source.Size, n, minPartSize)
+		}
+		if source.Size == 0 {
+			// An empty piece adds nothing to the file
+			continue
+		}
+		size := source.Size / int64(n)
+		for i := 0; i < n; i++ {
+			start := source.Start + int64(i)*size
+			end := start + size - 1
+			if i == n-1 {
+				end = source.End()
+			}
+			parts = append(parts, Part{Index: len(parts), Start: start, End: end})
+		}
+	}
+	if len(parts) == 0 {
+		parts = []Part{{Index: 0, Start: 0, End: -1}}
+	}
+	return parts
+}
+
+// sourceOf returns the piece holding part, or nil for a download from a
+// single URL
+func (p *Progress) sourceOf(part *Part) *Source {
+	for i := range p.Sources {
+		source := &p.Sources[i]
+		if part.Start >= source.Start && part.Start <= source.End() {
+			return source
+		}
+	}
+	return nil
+}
+
+// sameSources reports whether the progress was saved for pieces at urls
+func (p *Progress) sameSources(urls []string) bool {
+	if len(p.Sources) != len(urls) {
+		return false
+	}
+	for i, source := range p.Sources {
+		if source.URL != urls[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSources checks that the pieces cover the file in order and that
+// no part crosses from one piece into the next
+func (p *Progress) validateSources() error {
+	var next int64
+	for i, source := range p.Sources {
+		if source.Start != next || source.Size < 0 {
+			return fmt.Errorf("piece %d covers %d-%d, expected to start at %d", i+1, source.Start, source.End(), next)
+		}
+		next = source.End() + 1
+	}
+	if next != p.TotalSize {
+		return fmt.Errorf("pieces end at byte %d of a %d byte file", next, p.TotalSize)
+	}
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		if part.Size() == 0 {
+			continue
+		}
+		if source := p.sourceOf(part); source == nil || part.End > source.End() {
+			return fmt.Errorf("part %d (%d-%d) crosses the end of a piece", i, part.Start, part.End)
+		}
+	}
+	return nil
+}
+
+// prepareSourceRequest points a part's range request at the piece holding
+// the part, with the range counted from the start of the piece
+func prepareSourceRequest(req *http.Request, source *Source, start, end int64) error {
+	link, err := url.Parse(source.URL)
+	if err != nil {
+		return err
+	}
+	req.URL, req.Host = link, link.Host
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start-source.Start, end-source.Start))
+	if source.ETag != "" {
+		req.Header.Set("If-Range", source.ETag)
+	} else {
+		req.Header.Del("If-Range")
+	}
+	return nil
+}
+
+// checkSourceUnchanged fails a response carrying a different version of the
+// piece than the parts were fetched from
+func checkSourceUnchanged(source *Source, resp *http.Response) error {
+	if source.ETag == "" {
+		return nil
+	}
+	if etag := strongETag(resp.Header.Get("ETag")); etag != "" && etag != source.ETag {
+		return fmt.Errorf("%w: piece %s got ETag %s, expected %s", ErrRemoteFileChanged, secrets.RedactURL(source.URL), etag, source.ETag)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJoinedDownload(t *testing.T) {
+	pieces := [][]byte{
+		testPayload(3 * SmallFileSize),
+		testPayload(2*SmallFileSize + 17),
+		testPayload(SmallFileSize / 3),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file.z01", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.z01", time.Time{}, bytes.NewReader(pieces[0]))
+	})
+	mux.HandleFunc("/file.z02", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.z02", time.Time{}, bytes.NewReader(pieces[1]))
+	})
+	// The last piece comes from a server that ignores ranges
+	mux.HandleFunc("/file.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(pieces[2])))
+		w.Write(pieces[2])
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dl := newTestDownloader(t, server.URL+"/file.z01", 4)
+	dl.PartURLs = []string{server.URL + "/file.z01", server.URL + "/file.z02", server.URL + "/file.zip"}
+	dl.MinPartSize = 0
+	if err := dl.LoadOrCreateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.Progress.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	last := dl.Progress.Parts[len(dl.Progress.Parts)-1]
+	if last.Start != int64(len(pieces[0])+len(pieces[1])) {
+		t.Errorf("last part starts at %d, want the start of the last piece", last.Start)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dl.DownloadContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.VerifyDownload(); err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Join(pieces, nil)
+	if got, _ := os.ReadFile(dl.Filename); !bytes.Equal(got, want) {
+		t.Error("joined file does not match the pieces")
+	}
+}
+
+func TestJoinedProgressRejectsPartsAcrossPieces(t *testing.T) {
+	progress := CreateNewProgress("https://example.com/file.z01", "file", 20, 2)
+	progress.Sources = []Source{
+		{URL: "https://example.com/file.z01", Start: 0, Size: 8},
+		{URL: "https://example.com/file.z02", Start: 8, Size: 12},
+	}
+	if err := progress.Validate(); err == nil {
+		t.Error("Validate() accepted a part covering two pieces")
+	}
+	progress.splitAt(8)
+	if err := progress.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestJoinedDownloadRejectsStreaming(t *testing.T) {
+	dl := NewJoinedDownloader([]string{"https://example.com/a", "https://example.com/b"}, "file", 2)
+	if err := dl.Stream(context.Background(), &bytes.Buffer{}, 0); !errors.Is(err, ErrJoinUnsupported) {
+		t.Errorf("Stream() = %v, want ErrJoinUnsupported", err)
+	}
+}
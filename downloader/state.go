@@ -2,46 +2,295 @@ package downloader
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"sync/atomic"
 )
 
-// Part represents a single download part/chunk
+// CurrentProgressVersion is the schema version written to new progress files.
+// Files without a version predate versioning and are upgraded on load.
+const CurrentProgressVersion = 1
+
+// ErrInvalidProgress is returned for progress files that cannot be resumed safely
+var ErrInvalidProgress = errors.New("invalid progress file")
+
+// Part represents a single download part/chunk. Its range never changes once
+// the download starts; the progress is written by the part's goroutine while
+// the display, the progress file and the servers read it, so it is only
+// reached through the atomic accessors below.
 type Part struct {
+	// downloaded is first so it stays 64-bit aligned for atomic access
+	downloaded int64
+	done       int32
+
+	Index int
+	Start int64
+	End   int64
+
+	// digest holds the part's *Digest, see Digest
+	digest atomic.Value
+}
+
+// partJSON is the progress file form of a Part
+type partJSON struct {
 	Index      int   `json:"index"`
 	Start      int64 `json:"start"`
 	End        int64 `json:"end"`
 	Downloaded int64 `json:"downloaded"`
 	Done       bool  `json:"done"`
+	Digest     *Digest `json:"digest,omitempty"`
+}
+
+// Size returns the number of bytes in the part's range
+func (p *Part) Size() int64 {
+	return p.End - p.Start + 1
+}
+
+// Downloaded returns how many bytes of the part were written
+func (p *Part) Downloaded() int64 {
+	return atomic.LoadInt64(&p.downloaded)
+}
+
+// AddDownloaded records n more written bytes and returns the new total
+func (p *Part) AddDownloaded(n int64) int64 {
+	return atomic.AddInt64(&p.downloaded, n)
+}
+
+// SetDownloaded sets how many bytes of the part were written
+func (p *Part) SetDownloaded(n int64) {
+	atomic.StoreInt64(&p.downloaded, n)
+}
+
+// Done reports whether the whole part was written
+func (p *Part) Done() bool {
+	return atomic.LoadInt32(&p.done) != 0
+}
+
+// SetDone marks the part as finished or not
+func (p *Part) SetDone(done bool) {
+	var v int32
+	if done {
+		v = 1
+	}
+	atomic.StoreInt32(&p.done, v)
+}
+
+// Digest returns the checksum of the part's bytes: the one the server sent
+// with its range, or the one taken as the bytes arrived. It is nil for
+// parts assembled from more than one response.
+func (p *Part) Digest() *Digest {
+	digest, _ := p.digest.Load().(*Digest)
+	return digest
+}
+
+// SetDigest records the checksum of the part's bytes
+func (p *Part) SetDigest(digest *Digest) {
+	p.digest.Store(digest)
+}
+
+// Reset forgets everything written for the part
+func (p *Part) Reset() {
+	p.SetDownloaded(0)
+	p.SetDone(false)
+	p.SetDigest(nil)
+}
+
+// Snapshot returns a copy of the part that is safe to hand out while the
+// part is still downloading
+func (p *Part) Snapshot() Part {
+	s := Part{Index: p.Index, Start: p.Start, End: p.End}
+	s.SetDownloaded(p.Downloaded())
+	s.SetDone(p.Done())
+	s.SetDigest(p.Digest())
+	return s
+}
+
+// MarshalJSON writes the part in the progress file format
+func (p *Part) MarshalJSON() ([]byte, error) {
+	return json.Marshal(partJSON{
+		Index:      p.Index,
+		Start:      p.Start,
+		End:        p.End,
+		Downloaded: p.Downloaded(),
+		Done:       p.Done(),
+		Digest:     p.Digest(),
+	})
+}
+
+// UnmarshalJSON reads a part from the progress file format
+func (p *Part) UnmarshalJSON(data []byte) error {
+	var v partJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p.Index, p.Start, p.End = v.Index, v.Start, v.End
+	p.SetDownloaded(v.Downloaded)
+	p.SetDone(v.Done)
+	p.SetDigest(v.Digest)
+	return nil
 }
 
 // Progress represents the overall download state
 type Progress struct {
+	Version    int    `json:"version"`
 	URL        string `json:"url"`
 	Filename   string `json:"filename"`
 	TotalSize  int64  `json:"total_size"`
 	Parts      []Part `json:"parts"`
 	NumThreads int    `json:"num_threads"`
+	// RequestedThreads is the thread count asked for, when NumThreads had
+	// to be lowered for a small file or a server without range support
+	RequestedThreads int `json:"requested_threads,omitempty"`
+	// ETag identifies the remote file version the parts were downloaded from
+	ETag       string `json:"etag,omitempty"`
+	// LastModified is the remote file's Last-Modified header
+	LastModified string `json:"last_modified,omitempty"`
+	// Encrypted is set when the output file is written encrypted
+	Encrypted  bool   `json:"encrypted,omitempty"`
+	// SingleStream is set when the server did not advertise range support
+	// and the file is fetched over one connection. A resume still asks for
+	// the rest of the file from the saved offset and only starts over when
+	// the server ignores the range.
+	SingleStream bool `json:"single_stream,omitempty"`
+	// Sequential is set when the file is split into small parts that are
+	// downloaded lowest first, so it can be played while it downloads
+	Sequential bool `json:"sequential,omitempty"`
+	// PreviewBytes is how much of the start and end of the file is
+	// downloaded before the rest, for listing archives and probing media
+	PreviewBytes int64 `json:"preview_bytes,omitempty"`
+	// Digests are checksums of the whole file the server sent in its
+	// headers or trailers; Checksum is the result of checking them once
+	// the download finished
+	Digests    []Digest  `json:"digests,omitempty"`
+	Checksum   *Checksum `json:"checksum,omitempty"`
+	// Sources are the pieces of a file joined from several URLs, in order
+	Sources []Source `json:"sources,omitempty"`
+	// Mirrors are other URLs serving the file, configured or announced by
+	// the server, which a resume keeps using
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Pins are the URLs a part plan pinned the parts to, by part index;
+	// "" leaves a part to the fastest server. See plan.go.
+	Pins []string `json:"pins,omitempty"`
+
+	// repairs lists the fixes applied when the file was loaded
+	repairs []string
 }
 
 // SaveProgress saves the current progress to a JSON file
 func SaveProgress(filename string, progress *Progress) error {
+	progress.Version = CurrentProgressVersion
 	data, err := json.MarshalIndent(progress, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	return os.WriteFile(LongPath(filename), data, 0644)
 }
 
-// LoadProgress loads progress from a JSON file
+// LoadProgress loads progress from a JSON file. Harmless inconsistencies are
+// repaired (see Repairs); files that could resume into a corrupt output file
+// are refused with an error wrapping ErrInvalidProgress.
 func LoadProgress(filename string) (*Progress, error) {
-	data, err := os.ReadFile(filename)
+	data, err := os.ReadFile(LongPath(filename))
 	if err != nil {
 		return nil, err
 	}
-	
+
 	var progress Progress
-	err = json.Unmarshal(data, &progress)
-	return &progress, err
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidProgress, filename, err)
+	}
+
+	progress.repairs = progress.repair()
+	if err := progress.Validate(); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidProgress, filename, err)
+	}
+	return &progress, nil
+}
+
+// Repairs returns the fixes LoadProgress applied to the file, if any
+func (p *Progress) Repairs() []string {
+	return p.repairs
+}
+
+// repair fixes inconsistencies that cannot corrupt the output file and
+// returns a description of each fix
+func (p *Progress) repair() []string {
+	var repairs []string
+
+	if p.Version == 0 {
+		p.Version = CurrentProgressVersion
+		repairs = append(repairs, fmt.Sprintf("upgraded unversioned file to version %d", CurrentProgressVersion))
+	}
+
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		if part.Index != i {
+			repairs = append(repairs, fmt.Sprintf("renumbered part %d as %d", part.Index, i))
+			part.Index = i
+		}
+		if part.Downloaded() < 0 {
+			repairs = append(repairs, fmt.Sprintf("reset negative progress of part %d", i))
+			part.SetDownloaded(0)
+		}
+		if part.Done() && part.Downloaded() < part.Size() {
+			repairs = append(repairs, fmt.Sprintf("marked incomplete part %d as not done", i))
+			part.SetDone(false)
+		}
+	}
+
+	return repairs
+}
+
+// Validate checks the invariants a resumable download relies on: a known
+// schema version, parts that cover [0, TotalSize) in order without gaps or
+// overlaps, and no part reporting more data than its range holds
+func (p *Progress) Validate() error {
+	if p.Version < 1 || p.Version > CurrentProgressVersion {
+		return fmt.Errorf("unsupported version %d (this build reads up to %d)", p.Version, CurrentProgressVersion)
+	}
+	if p.TotalSize < 0 {
+		return fmt.Errorf("negative total size %d", p.TotalSize)
+	}
+	if p.TotalSize > 0 && len(p.Parts) == 0 {
+		return fmt.Errorf("no parts for a %d byte file", p.TotalSize)
+	}
+
+	var next int64
+	for i := range p.Parts {
+		part := &p.Parts[i]
+		if part.Start != next {
+			if part.Start < next {
+				return fmt.Errorf("part %d starts at %d, overlapping the previous part ending at %d", i, part.Start, next-1)
+			}
+			return fmt.Errorf("part %d starts at %d, leaving bytes %d-%d uncovered", i, part.Start, next, part.Start-1)
+		}
+		if part.End < part.Start-1 || part.End >= p.TotalSize {
+			return fmt.Errorf("part %d has invalid range %d-%d for a %d byte file", i, part.Start, part.End, p.TotalSize)
+		}
+
+		size := part.Size()
+		downloaded := part.Downloaded()
+		if downloaded < 0 || downloaded > size {
+			return fmt.Errorf("part %d reports %d downloaded bytes for a %d byte range", i, downloaded, size)
+		}
+		// Encrypted parts only ever commit whole chunks
+		if p.Encrypted && size > 0 && downloaded < size && (part.Start+downloaded)%EncryptedChunkSize != 0 {
+			return fmt.Errorf("encrypted part %d stops at %d, inside an encryption chunk", i, part.Start+downloaded)
+		}
+		next = part.End + 1
+	}
+
+	if next != p.TotalSize {
+		return fmt.Errorf("parts end at byte %d of a %d byte file", next, p.TotalSize)
+	}
+	if len(p.Pins) > 0 && len(p.Pins) != len(p.Parts) {
+		return fmt.Errorf("%d pinned servers for %d parts", len(p.Pins), len(p.Parts))
+	}
+	if len(p.Sources) > 0 {
+		return p.validateSources()
+	}
+	return nil
 }
 
 // CreateNewProgress creates a new progress structure for a fresh download
@@ -57,15 +306,14 @@ func CreateNewProgress(url, filename string, totalSize int64, numThreads int) *P
 		}
 
 		parts[i] = Part{
-			Index:      i,
-			Start:      start,
-			End:        end,
-			Downloaded: 0,
-			Done:       false,
+			Index: i,
+			Start: start,
+			End:   end,
 		}
 	}
 
 	return &Progress{
+		Version:    CurrentProgressVersion,
 		URL:        url,
 		Filename:   filename,
 		TotalSize:  totalSize,
@@ -74,21 +322,73 @@ func CreateNewProgress(url, filename string, totalSize int64, numThreads int) *P
 	}
 }
 
+// EffectiveThreads returns how many of threads to split a file of totalSize
+// bytes into, so that every part is at least minPartSize bytes. A file
+// smaller than minPartSize is downloaded in one part; a minPartSize of zero
+// or less keeps every thread.
+func EffectiveThreads(totalSize int64, threads int, minPartSize int64) int {
+	if threads < 1 {
+		threads = 1
+	}
+	if minPartSize <= 0 {
+		return threads
+	}
+	max := totalSize / minPartSize
+	if max < 1 {
+		return 1
+	}
+	if int64(threads) > max {
+		return int(max)
+	}
+	return threads
+}
+
+// AlignParts re-splits the file so that every part starts on a multiple of
+// align. Small files may end up with fewer parts than threads.
+func (p *Progress) AlignParts(align int64) {
+	threads := int64(p.NumThreads)
+	if threads < 1 {
+		threads = 1
+	}
+
+	partSize := (p.TotalSize + threads - 1) / threads
+	partSize = (partSize + align - 1) / align * align
+	if partSize == 0 {
+		partSize = align
+	}
+
+	var parts []Part
+	for start := int64(0); start < p.TotalSize; start += partSize {
+		end := start + partSize - 1
+		if end >= p.TotalSize {
+			end = p.TotalSize - 1
+		}
+		parts = append(parts, Part{Index: len(parts), Start: start, End: end})
+	}
+	if len(parts) == 0 {
+		parts = []Part{{Index: 0, Start: 0, End: -1}}
+	}
+
+	p.Parts = parts
+	p.NumThreads = len(parts)
+}
+
 // IsComplete checks if all parts are downloaded
 func (p *Progress) IsComplete() bool {
-	for _, part := range p.Parts {
-		if !part.Done {
+	for i := range p.Parts {
+		if !p.Parts[i].Done() {
 			return false
 		}
 	}
 	return true
 }
 
-// GetTotalDownloaded returns the total bytes downloaded across all parts
+// GetTotalDownloaded returns the total bytes downloaded across all parts. It
+// is safe to call while the download is running.
 func (p *Progress) GetTotalDownloaded() int64 {
 	var total int64
-	for _, part := range p.Parts {
-		total += part.Downloaded
+	for i := range p.Parts {
+		total += p.Parts[i].Downloaded()
 	}
 	return total
 }
@@ -96,6 +396,10 @@ func (p *Progress) GetTotalDownloaded() int64 {
 // GetOverallPercent returns the overall download percentage
 func (p *Progress) GetOverallPercent() float64 {
 	if p.TotalSize == 0 {
+		// An empty file is either not started or done
+		if len(p.Parts) > 0 && p.IsComplete() {
+			return 100
+		}
 		return 0
 	}
 	return float64(p.GetTotalDownloaded()) / float64(p.TotalSize) * 100
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzLoadProgress(f *testing.F) {
+	valid, err := json.Marshal(CreateNewProgress("http://example.com/file", "file", 1000, 4))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(valid)
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`{"total_size":-1}`))
+	f.Add([]byte(`{"total_size":10,"parts":[{"start":0,"end":9,"downloaded":-5}]}`))
+	f.Add([]byte(`{"total_size":10,"parts":[{"start":5,"end":2,"downloaded":0}]}`))
+	f.Add([]byte(`{"total_size":9223372036854775807,"parts":[{"start":0,"end":9223372036854775806}]}`))
+	f.Add([]byte(`{"version":1,"total_size":10,"parts":[{"start":0,"end":6,"downloaded":7},{"start":4,"end":9,"downloaded":6}]}`))
+	f.Add([]byte(`{"version":99,"total_size":10,"parts":[{"start":0,"end":9}]}`))
+
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(dir, "state.json")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		progress, err := LoadProgress(path)
+		if err != nil {
+			return
+		}
+
+		if progress.TotalSize < 0 {
+			t.Fatalf("loaded negative total size %d", progress.TotalSize)
+		}
+		for i := range progress.Parts {
+			part := &progress.Parts[i]
+			if part.Downloaded() < 0 {
+				t.Fatalf("loaded part %d with negative progress %d", part.Index, part.Downloaded())
+			}
+			if part.Downloaded() > part.Size() {
+				t.Fatalf("loaded part %d with more data than its range", part.Index)
+			}
+		}
+		if percent := progress.GetOverallPercent(); percent < 0 || percent > 100 {
+			t.Fatalf("loaded progress reports %.2f%%", percent)
+		}
+	})
+}
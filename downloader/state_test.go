@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadProgressValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		wantErr     bool
+		wantRepairs int
+	}{
+		{
+			name: "valid file",
+			json: `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":4,"downloaded":5,"done":true},{"index":1,"start":5,"end":9,"downloaded":2}]}`,
+		},
+		{
+			name:        "unversioned file is upgraded",
+			json:        `{"total_size":10,"parts":[{"index":0,"start":0,"end":9,"downloaded":3}]}`,
+			wantRepairs: 1,
+		},
+		{
+			name:        "done flag on incomplete part is cleared",
+			json:        `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":9,"downloaded":3,"done":true}]}`,
+			wantRepairs: 1,
+		},
+		{
+			name:        "negative progress is reset",
+			json:        `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":9,"downloaded":-4}]}`,
+			wantRepairs: 1,
+		},
+		{
+			name:    "newer version is refused",
+			json:    `{"version":99,"total_size":10,"parts":[{"index":0,"start":0,"end":9}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "overlapping parts are refused",
+			json:    `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":6},{"index":1,"start":4,"end":9}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "gap between parts is refused",
+			json:    `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":3},{"index":1,"start":5,"end":9}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "parts shorter than the file are refused",
+			json:    `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":8}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "oversized progress is refused",
+			json:    `{"version":1,"total_size":10,"parts":[{"index":0,"start":0,"end":9,"downloaded":11}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json is refused",
+			json:    `{"version":1,`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			progress, err := LoadProgress(path)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidProgress) {
+					t.Fatalf("LoadProgress() error = %v, want ErrInvalidProgress", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadProgress() error = %v", err)
+			}
+			if got := len(progress.Repairs()); got != tt.wantRepairs {
+				t.Errorf("LoadProgress() made %d repairs %v, want %d", got, progress.Repairs(), tt.wantRepairs)
+			}
+		})
+	}
+}
+
+func TestPartJSONRoundTrip(t *testing.T) {
+	progress := CreateNewProgress("http://example.com/f", "f", 10, 2)
+	progress.Parts[0].SetDownloaded(5)
+	progress.Parts[0].SetDone(true)
+	progress.Parts[1].SetDownloaded(2)
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Progress
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range progress.Parts {
+		want, got := progress.Parts[i].Snapshot(), decoded.Parts[i].Snapshot()
+		if got.Index != want.Index || got.Start != want.Start || got.End != want.End ||
+			got.Downloaded() != want.Downloaded() || got.Done() != want.Done() {
+			t.Errorf("part %d decoded as %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+// TestProgressReadsDuringDownload is meant to run under -race: the display,
+// the progress file and the servers read parts while they are written
+func TestProgressReadsDuringDownload(t *testing.T) {
+	progress := CreateNewProgress("http://example.com/f", "f", 4000, 4)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	var writers sync.WaitGroup
+	for i := range progress.Parts {
+		writers.Add(1)
+		go func(part *Part) {
+			defer writers.Done()
+			for part.Downloaded() < part.Size() {
+				part.AddDownloaded(1)
+			}
+			part.SetDone(true)
+		}(&progress.Parts[i])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		writers.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		if total := progress.GetTotalDownloaded(); total < 0 || total > progress.TotalSize {
+			t.Fatalf("GetTotalDownloaded() = %d", total)
+		}
+		progress.IsComplete()
+		if err := SaveProgress(path, progress); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !progress.IsComplete() || progress.GetTotalDownloaded() != progress.TotalSize {
+		t.Fatalf("progress after download: complete=%v total=%d", progress.IsComplete(), progress.GetTotalDownloaded())
+	}
+}
+
+func TestEffectiveThreads(t *testing.T) {
+	tests := []struct {
+		size    int64
+		threads int
+		min     int64
+		want    int
+	}{
+		{10 * 1024, 16, 1 << 20, 1},
+		{0, 4, 1 << 20, 1},
+		{5 << 20, 16, 1 << 20, 5},
+		{100 << 20, 8, 1 << 20, 8},
+		{10 * 1024, 16, 0, 16},
+		{10 * 1024, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := EffectiveThreads(tt.size, tt.threads, tt.min); got != tt.want {
+			t.Errorf("EffectiveThreads(%d, %d, %d) = %d, want %d", tt.size, tt.threads, tt.min, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStreamWindow is how many bytes a stream may fetch ahead of what it
+// has written
+const DefaultStreamWindow = 32 * 1024 * 1024
+
+// minStreamChunk is the smallest range a stream requests at once
+const minStreamChunk = 64 * 1024
+
+// streamChunkAttempts is how often a range of a stream is requested before
+// the stream fails; unlike a part, a chunk has nowhere to resume from
+const streamChunkAttempts = 5
+
+// Stream downloads the file and writes it to w in order, for pipes that
+// cannot seek. NumThreads ranges are fetched at once, fewer when window has
+// no room for them, and chunks that arrive ahead of the writer wait in
+// memory, never more than window bytes of them.
+// Servers without range support, small files and custom requests are
+// streamed from a single response. Nothing is saved, so an interrupted
+// stream starts over. Once written, the bytes are checked against the
+// checksums the server announced up front.
+func (d *Downloader) Stream(ctx context.Context, w io.Writer, window int64) error {
+	if d.EncryptionKey != nil {
+		return errors.New("encrypted downloads cannot be streamed")
+	}
+	if d.joined() {
+		return fmt.Errorf("streaming is %w", ErrJoinUnsupported)
+	}
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	// Drop the probe response if the stream did not start from its body
+	defer d.takePending(-1)
+
+	supportsRanges, size, err := d.SupportsRange()
+	if err != nil {
+		return fmt.Errorf("error checking server capabilities: %w", err)
+	}
+	d.Progress = CreateNewProgress(d.URL, d.Filename, size, 1)
+	d.Progress.ETag = d.etag
+	d.Progress.Digests = d.digests
+
+	// Hash the stream with every algorithm the server has a digest for
+	hashes := make(map[string]hash.Hash)
+	writers := []io.Writer{w}
+	for _, digest := range d.Progress.Digests {
+		if _, ok := hashes[digest.Algorithm]; !ok {
+			h := newDigestHash(digest.Algorithm)
+			hashes[digest.Algorithm] = h
+			writers = append(writers, h)
+		}
+	}
+	out := io.MultiWriter(writers...)
+
+	client := d.newPartClient()
+	defer client.CloseIdleConnections()
+
+	if !supportsRanges || size <= SmallFileSize || d.customRequest() {
+		err = d.streamWhole(ctx, client, out)
+	} else {
+		err = d.streamRanges(ctx, client, out, window)
+	}
+	if err != nil {
+		if errors.Is(err, ErrRemoteFileChanged) {
+			d.forgetProbe()
+		}
+		return err
+	}
+	d.Progress.Parts[0].SetDone(true)
+
+	if len(hashes) == 0 {
+		return nil
+	}
+	sums := make(map[string][]byte, len(hashes))
+	for algorithm, h := range hashes {
+		sums[algorithm] = h.Sum(nil)
+	}
+	return d.checkSums(sums)
+}
+
+// streamWhole copies the file from a single response
+func (d *Downloader) streamWhole(ctx context.Context, client *http.Client, out io.Writer) error {
+	part := &d.Progress.Parts[0]
+	if d.Progress.TotalSize == 0 {
+		return nil
+	}
+
+	link := d.requestURL()
+	resp := d.takePending(0)
+	if resp == nil {
+		req, err := d.newRequest(ctx, "GET")
+		if err != nil {
+			return fmt.Errorf("failed to create %s request: %w", d.requestMethod(), err)
+		}
+		if !waitForHost(ctx, req.URL.Host) {
+			return ctx.Err()
+		}
+		if resp, err = client.Do(req); err != nil {
+			return fmt.Errorf("failed to make %s request: %w", d.requestMethod(), err)
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return d.refreshExpired(ctx, resp, link)
+	}
+	n, err := d.copyLimited(ctx, out, io.LimitReader(resp.Body, d.Progress.TotalSize), part)
+	if err != nil {
+		return err
+	}
+	if n < d.Progress.TotalSize {
+		return fmt.Errorf("short response: got %d of %d bytes: %w", n, d.Progress.TotalSize, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// streamRanges fetches the file in chunks over NumThreads connections and
+// writes them in order. A worker only starts a chunk once it holds one of
+// the window's slots, which the writer hands back as it writes chunks out.
+// Chunks are handed out in order, so the next chunk to write always holds
+// a slot and the stream cannot stall.
+func (d *Downloader) streamRanges(ctx context.Context, client *http.Client, out io.Writer, window int64) error {
+	size := d.Progress.TotalSize
+	threads := d.NumThreads
+	if threads < 1 {
+		threads = 1
+	}
+	chunk := window / int64(2*threads)
+	if chunk < minStreamChunk {
+		// A small window holds fewer chunks than there are threads, so run
+		// only as many threads as it has room for
+		chunk = minStreamChunk
+		if window < chunk {
+			chunk = window
+		}
+		if threads > int(window/chunk) {
+			threads = int(window / chunk)
+		}
+	}
+	slots := int(window / chunk)
+	count := int((size + chunk - 1) / chunk)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var fatalErr error
+	var fatalOnce sync.Once
+	fail := func(err error) {
+		fatalOnce.Do(func() {
+			fatalErr = err
+			cancel()
+		})
+	}
+
+	ready := make([]chan []byte, count)
+	for i := range ready {
+		ready[i] = make(chan []byte, 1)
+	}
+	free := make(chan struct{}, slots)
+	var next int64
+	var wg sync.WaitGroup
+	for t := 0; t < threads; t++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case free <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= count {
+					return
+				}
+				start := int64(i) * chunk
+				end := start + chunk - 1
+				if end >= size {
+					end = size - 1
+				}
+				data, err := d.fetchChunk(ctx, client, start, end)
+				if err != nil {
+					fail(err)
+					return
+				}
+				ready[i] <- data
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	part := &d.Progress.Parts[0]
+	for i := 0; i < count; i++ {
+		select {
+		case data := <-ready[i]:
+			if _, err := out.Write(data); err != nil {
+				fail(fmt.Errorf("error writing stream: %w", err))
+				return fatalErr
+			}
+			part.AddDownloaded(int64(len(data)))
+			<-free
+		case <-ctx.Done():
+			wg.Wait()
+			if fatalErr != nil {
+				return fatalErr
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// fetchChunk downloads bytes start-end of the file into memory, retrying
+// failed requests a few times
+func (d *Downloader) fetchChunk(ctx context.Context, client *http.Client, start, end int64) ([]byte, error) {
+	var lastErr error
+	for attempt := 1; attempt <= streamChunkAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		link := d.requestURL()
+		req, err := d.newRequest(ctx, "GET")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if d.Progress.ETag != "" {
+			req.Header.Set("If-Range", d.Progress.ETag)
+		}
+		if !waitForHost(ctx, req.URL.Host) {
+			return nil, ctx.Err()
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if linkExpired(resp) && d.RefreshLink != nil {
+			resp.Body.Close()
+			if lastErr = d.refreshExpired(ctx, resp, link); errors.Is(lastErr, ErrLinkExpired) {
+				return nil, lastErr
+			}
+			continue
+		}
+		data, err := d.readChunk(ctx, resp, start, end)
+		resp.Body.Close()
+		if err == nil || errors.Is(err, ErrRemoteFileChanged) || ctx.Err() != nil {
+			return data, err
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to fetch bytes %d-%d after %d attempts: %w", start, end, streamChunkAttempts, lastErr)
+}
+
+// readChunk checks a range response and reads all of its body
+func (d *Downloader) readChunk(ctx context.Context, resp *http.Response, start, end int64) ([]byte, error) {
+	if delay, ok := throttleFromResponse(resp.Request.URL.Host, resp); ok {
+		return nil, fmt.Errorf("server throttled requests (%s), retry after %s", resp.Status, delay)
+	}
+	if err := d.checkRemoteUnchanged(resp); err != nil {
+		return nil, err
+	}
+	expected, err := d.validatePartResponse(resp, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if expected != end-start+1 {
+		return nil, fmt.Errorf("server returned %d of the %d bytes requested", expected, end-start+1)
+	}
+
+	data := make([]byte, expected)
+	var got int64
+	for got < expected {
+		n, err := resp.Body.Read(data[got:min64(got+int64(d.bufferSize()), expected)])
+		got += int64(n)
+		if n > 0 && d.waitRate(ctx, n) != nil {
+			return nil, ctx.Err()
+		}
+		if err == io.EOF && got < expected {
+			return nil, fmt.Errorf("short response: got %d of %d bytes: %w", got, expected, io.ErrUnexpectedEOF)
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// copyLimited copies src to dst within the rate limits, counting the bytes
+// as downloaded for part
+func (d *Downloader) copyLimited(ctx context.Context, dst io.Writer, src io.Reader, part *Part) (int64, error) {
+	buffer := make([]byte, d.bufferSize())
+	var total int64
+	for {
+		n, err := src.Read(buffer)
+		if n > 0 {
+			if _, werr := dst.Write(buffer[:n]); werr != nil {
+				return total, fmt.Errorf("error writing stream: %w", werr)
+			}
+			total += int64(n)
+			part.AddDownloaded(int64(n))
+			if d.waitRate(ctx, n) != nil {
+				return total, ctx.Err()
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, fmt.Errorf("error reading response: %w", err)
+		}
+	}
+}
+
+// min64 returns the smaller of a and b
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamWritesFileInOrder(t *testing.T) {
+	data := testPayload(1024*1024 + 123)
+	for _, f := range []fault{faultNone, faultSlowDrip, faultTruncate, faultNoRanges} {
+		server := newFaultServer(t, data, f)
+		dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+
+		var out bytes.Buffer
+		// A window of a few chunks makes early chunks wait for the writer
+		if err := dl.Stream(context.Background(), &out, 4*minStreamChunk); err != nil {
+			t.Fatalf("fault %d: Stream() error = %v", f, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("fault %d: streamed %d bytes that do not match the file", f, out.Len())
+		}
+		if got := dl.Progress.GetTotalDownloaded(); got != int64(len(data)) || !dl.Progress.IsComplete() {
+			t.Errorf("fault %d: progress reports %d bytes", f, got)
+		}
+	}
+}
+
+func TestStreamChecksServerDigest(t *testing.T) {
+	data := testPayload(512 * 1024)
+	sum := sha256.Sum256(data)
+	server := newDigestServer(t, data, map[string]string{"Repr-Digest": "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"})
+	dl := newTestDownloader(t, server.URL, 4)
+
+	var out bytes.Buffer
+	if err := dl.Stream(context.Background(), &out, 0); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if dl.Progress.Checksum == nil || dl.Progress.Checksum.Status != ChecksumVerified {
+		t.Errorf("Checksum = %+v, want verified", dl.Progress.Checksum)
+	}
+
+	wrong := sha256.Sum256(data[1:])
+	server = newDigestServer(t, data, map[string]string{"Repr-Digest": "sha-256=:" + base64.StdEncoding.EncodeToString(wrong[:]) + ":"})
+	dl = newTestDownloader(t, server.URL, 4)
+	out.Reset()
+	if err := dl.Stream(context.Background(), &out, 0); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Stream() error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestStreamStopsWhenRemoteChanges(t *testing.T) {
+	data := testPayload(1024 * 1024)
+	server := newFaultServer(t, data, faultStaleETag)
+	dl := newTestDownloader(t, server.URL+"/file.bin", 4)
+
+	var out bytes.Buffer
+	if err := dl.Stream(context.Background(), &out, 4*minStreamChunk); !errors.Is(err, ErrRemoteFileChanged) {
+		t.Fatalf("Stream() error = %v, want ErrRemoteFileChanged", err)
+	}
+}
+
+func TestStreamKeepsSmallWindows(t *testing.T) {
+	data := testPayload(512 * 1024)
+	var inFlight, peak int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			n := atomic.AddInt64(&inFlight, 1)
+			defer atomic.AddInt64(&inFlight, -1)
+			for p := atomic.LoadInt64(&peak); n > p && !atomic.CompareAndSwapInt64(&peak, p, n); p = atomic.LoadInt64(&peak) {
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	// Windows with room for fewer chunks than threads, down to part of one
+	for _, window := range []int64{2 * minStreamChunk, minStreamChunk + 100, 1000} {
+		atomic.StoreInt64(&peak, 0)
+		dl := newTestDownloader(t, server.URL+"/file.bin", 8)
+		var out bytes.Buffer
+		if err := dl.Stream(context.Background(), &out, window); err != nil {
+			t.Fatalf("window %d: Stream() error = %v", window, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("window %d: streamed %d bytes that do not match the file", window, out.Len())
+		}
+		want := window / minStreamChunk
+		if want < 1 {
+			want = 1
+		}
+		if got := atomic.LoadInt64(&peak); got > want {
+			t.Errorf("window %d: %d ranges in flight, want at most %d", window, got, want)
+		}
+	}
+}
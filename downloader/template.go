@@ -0,0 +1,195 @@
+package downloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxTemplateURLs caps how many URLs a single template may expand into
+const MaxTemplateURLs = 10000
+
+// templateGroup is one {...} expression inside a URL template
+type templateGroup struct {
+	start  int
+	end    int
+	values []string
+}
+
+// HasURLTemplate reports whether the URL contains any expandable {...} groups
+func HasURLTemplate(url string) bool {
+	groups, err := parseTemplateGroups(url)
+	return err == nil && len(groups) > 0
+}
+
+// ExpandURLTemplate expands a URL template into the list of concrete URLs.
+// Supported groups are numeric ranges ({1..10}, {001..120}), letter ranges
+// ({a..f}) and comma lists ({a,b,c}). Multiple groups expand to every
+// combination, with the left-most group changing slowest.
+func ExpandURLTemplate(template string) ([]string, error) {
+	groups, err := parseTemplateGroups(template)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		return []string{template}, nil
+	}
+
+	total := 1
+	for _, g := range groups {
+		total *= len(g.values)
+		if total > MaxTemplateURLs {
+			return nil, fmt.Errorf("template expands to more than %d URLs", MaxTemplateURLs)
+		}
+	}
+
+	urls := make([]string, 0, total)
+	indexes := make([]int, len(groups))
+	for {
+		var b strings.Builder
+		last := 0
+		for i, g := range groups {
+			b.WriteString(template[last:g.start])
+			b.WriteString(g.values[indexes[i]])
+			last = g.end
+		}
+		b.WriteString(template[last:])
+		urls = append(urls, b.String())
+
+		// Advance the right-most group, carrying to the left
+		i := len(groups) - 1
+		for ; i >= 0; i-- {
+			indexes[i]++
+			if indexes[i] < len(groups[i].values) {
+				break
+			}
+			indexes[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+
+	return urls, nil
+}
+
+// parseTemplateGroups finds and expands every {...} group in the template.
+// Braces whose content is neither a range nor a list are left untouched.
+func parseTemplateGroups(template string) ([]templateGroup, error) {
+	var groups []templateGroup
+
+	for pos := 0; pos < len(template); {
+		open := strings.IndexByte(template[pos:], '{')
+		if open < 0 {
+			break
+		}
+		open += pos
+
+		close := strings.IndexByte(template[open:], '}')
+		if close < 0 {
+			break
+		}
+		close += open
+
+		body := template[open+1 : close]
+		values, err := expandTemplateBody(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template group {%s}: %w", body, err)
+		}
+
+		if values != nil {
+			groups = append(groups, templateGroup{
+				start:  open,
+				end:    close + 1,
+				values: values,
+			})
+		}
+		pos = close + 1
+	}
+
+	return groups, nil
+}
+
+// expandTemplateBody expands the content of a single {...} group.
+// It returns nil values when the content is not a template expression.
+func expandTemplateBody(body string) ([]string, error) {
+	if strings.Contains(body, "..") {
+		bounds := strings.Split(body, "..")
+		if len(bounds) != 2 || bounds[0] == "" || bounds[1] == "" {
+			return nil, fmt.Errorf("range must be of the form start..end")
+		}
+		return expandRange(bounds[0], bounds[1])
+	}
+
+	if strings.Contains(body, ",") {
+		return strings.Split(body, ","), nil
+	}
+
+	return nil, nil
+}
+
+// expandRange expands a numeric or single-letter range, keeping zero padding
+func expandRange(from, to string) ([]string, error) {
+	start, startErr := strconv.Atoi(from)
+	end, endErr := strconv.Atoi(to)
+
+	if startErr == nil && endErr == nil {
+		if start < 0 || end < 0 {
+			return nil, fmt.Errorf("negative ranges are not supported")
+		}
+		if abs(end-start)+1 > MaxTemplateURLs {
+			return nil, fmt.Errorf("range expands to more than %d values", MaxTemplateURLs)
+		}
+
+		// A leading zero on either bound means the values are zero padded
+		width := 0
+		if (len(from) > 1 && from[0] == '0') || (len(to) > 1 && to[0] == '0') {
+			width = len(from)
+			if len(to) > width {
+				width = len(to)
+			}
+		}
+
+		var values []string
+		step := 1
+		if end < start {
+			step = -1
+		}
+		for n := start; ; n += step {
+			values = append(values, fmt.Sprintf("%0*d", width, n))
+			if n == end {
+				break
+			}
+		}
+		return values, nil
+	}
+
+	if len(from) == 1 && len(to) == 1 && isASCIILetter(from[0]) && isASCIILetter(to[0]) {
+		var values []string
+		step := 1
+		if to[0] < from[0] {
+			step = -1
+		}
+		for c := int(from[0]); ; c += step {
+			values = append(values, string(rune(c)))
+			if c == int(to[0]) {
+				break
+			}
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("range bounds must both be numbers or single letters")
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandURLTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{"http://x/f{1..3}.bin", []string{"http://x/f1.bin", "http://x/f2.bin", "http://x/f3.bin"}},
+		{"http://x/f{08..10}.bin", []string{"http://x/f08.bin", "http://x/f09.bin", "http://x/f10.bin"}},
+		{"http://x/f{9..011}", []string{"http://x/f009", "http://x/f010", "http://x/f011"}},
+		{"http://x/f{3..1}", []string{"http://x/f3", "http://x/f2", "http://x/f1"}},
+		{"http://x/{a..c}", []string{"http://x/a", "http://x/b", "http://x/c"}},
+		{"http://x/{C..A}", []string{"http://x/C", "http://x/B", "http://x/A"}},
+		{"http://x/{img,doc}/1", []string{"http://x/img/1", "http://x/doc/1"}},
+		{"http://x/{a,b}/{1..2}", []string{"http://x/a/1", "http://x/a/2", "http://x/b/1", "http://x/b/2"}},
+		{"http://x/{5..5}", []string{"http://x/5"}},
+		{"http://x/{id}/f{1..2}", []string{"http://x/{id}/f1", "http://x/{id}/f2"}},
+		{"http://x/{unclosed", []string{"http://x/{unclosed"}},
+		{"http://x/plain", []string{"http://x/plain"}},
+	}
+	for _, tt := range tests {
+		got, err := ExpandURLTemplate(tt.template)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExpandURLTemplate(%q) = %v, %v, want %v", tt.template, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandURLTemplateRejects(t *testing.T) {
+	templates := []string{
+		"http://x/{1..}",
+		"http://x/{..3}",
+		"http://x/{1..2..3}",
+		"http://x/{a..3}",
+		"http://x/{aa..c}",
+		"http://x/{0..10000}",
+		"http://x/{1..100}/{1..100}/{1..2}",
+	}
+	for _, template := range templates {
+		if urls, err := ExpandURLTemplate(template); err == nil {
+			t.Errorf("ExpandURLTemplate(%q) = %d URLs, want an error", template, len(urls))
+		}
+	}
+}
+
+func TestExpandURLTemplateCap(t *testing.T) {
+	urls, err := ExpandURLTemplate("http://x/{1..100}/{1..100}")
+	if err != nil || len(urls) != MaxTemplateURLs {
+		t.Fatalf("ExpandURLTemplate() = %d URLs, %v, want %d", len(urls), err, MaxTemplateURLs)
+	}
+	if urls[0] != "http://x/1/1" || urls[len(urls)-1] != "http://x/100/100" {
+		t.Errorf("first and last URLs = %q, %q", urls[0], urls[len(urls)-1])
+	}
+}
+
+func TestHasURLTemplate(t *testing.T) {
+	tests := map[string]bool{
+		"http://x/f{1..3}":  true,
+		"http://x/{a,b}":    true,
+		"http://x/{id}":     false,
+		"http://x/plain":    false,
+		"http://x/{1..}":    false,
+		"http://x/{a..b":    false,
+		"http://x/{}/{a,b}": true,
+	}
+	for url, want := range tests {
+		if got := HasURLTemplate(url); got != want {
+			t.Errorf("HasURLTemplate(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestGroupOutputPaths(t *testing.T) {
+	urls := []string{
+		"http://a/file.bin",
+		"http://b/other.bin",
+		"http://c/file.bin",
+		"http://d/",
+		"http://e/dir/file.bin?x=1",
+	}
+	want := []string{"0001_file.bin", "other.bin", "0003_file.bin", "download", "0005_file.bin"}
+
+	got := GroupOutputPaths("out", urls)
+	if len(got) != len(want) {
+		t.Fatalf("GroupOutputPaths() = %v", got)
+	}
+	for i := range want {
+		if got[i] != filepath.Join("out", want[i]) {
+			t.Errorf("path %d = %q, want %q", i, got[i], filepath.Join("out", want[i]))
+		}
+	}
+
+	// Dot segments name no file and must not leave the directory
+	for _, p := range GroupOutputPaths("out", []string{"http://f/a/..", "http://g/a/%2E%2E", "http://h/a/."}) {
+		if filepath.Dir(p) != "out" || strings.Contains(p, "..") {
+			t.Errorf("dot segment gave %q", p)
+		}
+	}
+
+	for _, p := range GroupOutputPaths("", []string{"http://x/a", "http://x/b"}) {
+		if strings.Contains(p, "_") {
+			t.Errorf("unique name %q was prefixed", p)
+		}
+	}
+}
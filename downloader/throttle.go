@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxRetryAfter caps how long a single Retry-After header may pause a host
+const MaxRetryAfter = 10 * time.Minute
+
+// MinRetryAfter is the shortest pause a throttling response imposes, so a
+// server answering Retry-After: 0 or a past date is not asked again at once
+const MinRetryAfter = DefaultRetryDelay
+
+// hostThrottles records, per host, when requests may be sent again. It is
+// shared by every download in the process so one throttled download also
+// holds back other downloads from the same host.
+var hostThrottles = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+// ParseRetryAfter parses a Retry-After header given either as a number of
+// seconds or as an HTTP date. The delay is capped at MaxRetryAfter.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+
+	if delay > MaxRetryAfter {
+		delay = MaxRetryAfter
+	}
+	return delay, true
+}
+
+// ThrottleHost pauses requests to a host until the given time. An earlier
+// deadline never shortens an existing one.
+func ThrottleHost(host string, until time.Time) {
+	hostThrottles.Lock()
+	defer hostThrottles.Unlock()
+
+	if until.After(hostThrottles.until[host]) {
+		hostThrottles.until[host] = until
+	}
+}
+
+// HostThrottledUntil returns when requests to a host may resume, or the zero
+// time if the host is not throttled
+func HostThrottledUntil(host string) time.Time {
+	hostThrottles.Lock()
+	defer hostThrottles.Unlock()
+
+	until, ok := hostThrottles.until[host]
+	if !ok {
+		return time.Time{}
+	}
+	if !until.After(time.Now()) {
+		delete(hostThrottles.until, host)
+		return time.Time{}
+	}
+	return until
+}
+
+// waitForHost blocks until the host is no longer throttled. It returns false
+// if the context is cancelled first.
+func waitForHost(ctx context.Context, host string) bool {
+	for {
+		until := HostThrottledUntil(host)
+		if until.IsZero() {
+			return true
+		}
+
+		timer := time.NewTimer(time.Until(until))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// throttleFromResponse records a server-requested back-off for 429 and 503
+// responses carrying a Retry-After header, of at least MinRetryAfter. It
+// reports whether one was applied.
+func throttleFromResponse(host string, resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	delay, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return 0, false
+	}
+	if delay < MinRetryAfter {
+		delay = MinRetryAfter
+	}
+
+	ThrottleHost(host, time.Now().Add(delay))
+	return delay, true
+}
+
+// ThrottledUntil returns when the server allows requests for this download to
+// resume, or the zero time if it is not being throttled
+func (d *Downloader) ThrottledUntil() time.Time {
+	parsed, err := url.Parse(d.URL)
+	if err != nil {
+		return time.Time{}
+	}
+	return HostThrottledUntil(parsed.Host)
+}
+
+// ThrottledByServer reports whether the server has asked this download's host
+// to back off via Retry-After
+func (d *Downloader) ThrottledByServer() bool {
+	return !d.ThrottledUntil().IsZero()
+}
@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// newPartClient creates the client shared by every part of a download. With
+// HTTP/2 origins the parts are multiplexed as streams over a single
+// connection; HTTP/1.1 origins get one kept-alive connection per part.
+func (d *Downloader) newPartClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = d.NumThreads
+	if d.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	} else {
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	return d.withTransport(&http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	})
+}
+
+// withTransport makes the connections of client from Bind and puts its
+// requests behind WrapTransport, when they are set, and the download's
+// Jitter
+func (d *Downloader) withTransport(client *http.Client) *http.Client {
+	client = d.withBind(client)
+	if d.WrapTransport != nil {
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = d.WrapTransport(next)
+	}
+	return d.withJitter(client)
+}
+
+// warmUpConnection opens the shared connection before the parts start so that
+// an HTTP/2 origin is reached over one connection instead of one per part.
+// Failures are ignored; the parts simply dial their own connections.
+func (d *Downloader) warmUpConnection(ctx context.Context) {
+	if d.customRequest() {
+		// The probe request has already connected
+		return
+	}
+	req, err := d.newRequest(ctx, "HEAD")
+	if err != nil {
+		return
+	}
+
+	if !waitForHost(ctx, req.URL.Host) {
+		return
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor == 2 {
+		fmt.Printf("Multiplexing %d parts over a single HTTP/2 connection\n", len(d.Progress.Parts))
+	}
+}
+
+// partPriority returns an RFC 9218 Priority header value for a part. Earlier
+// parts are more urgent so the start of the file arrives first when the
+// server schedules multiplexed streams.
+func partPriority(index, numParts int) string {
+	if numParts < 1 {
+		numParts = 1
+	}
+	// Urgencies 1-6 leave 0 and 7 free for more and less urgent requests
+	urgency := 1 + index*6/numParts
+	if urgency > 6 {
+		urgency = 6
+	}
+	return fmt.Sprintf("u=%d", urgency)
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPartPriority(t *testing.T) {
+	tests := []struct {
+		index, numParts int
+		want            string
+	}{
+		{0, 1, "u=1"},
+		{0, 6, "u=1"},
+		{1, 6, "u=2"},
+		{5, 6, "u=6"},
+		{0, 12, "u=1"},
+		{11, 12, "u=6"},
+		{3, 4, "u=5"},
+		{0, 0, "u=1"},
+		{9, 4, "u=6"},
+	}
+	for _, tt := range tests {
+		if got := partPriority(tt.index, tt.numParts); got != tt.want {
+			t.Errorf("partPriority(%d, %d) = %q, want %q", tt.index, tt.numParts, got, tt.want)
+		}
+	}
+}
+
+func TestPartClientMultiplexesOverHTTP2(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("part"))
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	for _, disableHTTP2 := range []bool{false, true} {
+		atomic.StoreInt32(&conns, 0)
+		d := &Downloader{NumThreads: 4, DisableHTTP2: disableHTTP2}
+		client := d.newPartClient()
+		client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+
+		get := func() int {
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return 0
+			}
+			resp.Body.Close()
+			return resp.ProtoMajor
+		}
+
+		// The first request stands in for warmUpConnection
+		if proto := get(); (proto == 2) == disableHTTP2 {
+			t.Errorf("DisableHTTP2 %v: first response over HTTP/%d", disableHTTP2, proto)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < d.NumThreads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				get()
+			}()
+		}
+		wg.Wait()
+
+		if got := atomic.LoadInt32(&conns); !disableHTTP2 && got != 1 {
+			t.Errorf("HTTP/2 parts used %d connections, want 1", got)
+		}
+		client.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// FilenameFromURL derives a local filename from the last path segment of a
+// URL. The segment is percent-decoded, so an encoded UTF-8 name keeps its
+// characters, and made safe with SafeFilename. A segment that names no file,
+// such as the ".." of /a/.., gives the default name.
+func FilenameFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "download"
+	}
+
+	// The escaped path keeps an encoded slash inside the last segment
+	name := path.Base(parsed.EscapedPath())
+	if decoded, err := url.PathUnescape(name); err == nil {
+		name = decoded
+	}
+	if name == "." || name == ".." || name == "/" || name == "" {
+		return "download"
+	}
+	return SafeFilename(name)
+}
+
+// NormalizeURL turns an IRI, a URL with characters outside ASCII, into the
+// URI servers expect: the host in punycode and the path and query
+// percent-encoded as UTF-8. ASCII URLs and URLs that do not parse are
+// returned unchanged.
+func NormalizeURL(rawURL string) string {
+	if isASCII(rawURL) && !strings.Contains(rawURL, " ") {
+		return rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if host := parsed.Hostname(); !isASCII(host) {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return rawURL
+		}
+		if port := parsed.Port(); port != "" {
+			ascii = net.JoinHostPort(ascii, port)
+		}
+		parsed.Host = ascii
+	}
+	parsed.RawQuery = escapeNonASCII(parsed.RawQuery)
+	return parsed.String()
+}
+
+// DisplayHost returns host with punycode labels in Unicode, as a browser
+// shows it, or host itself if it does not decode
+func DisplayHost(host string) string {
+	if !strings.Contains(host, "xn--") {
+		return host
+	}
+	unicode, err := idna.Display.ToUnicode(host)
+	if err != nil {
+		return host
+	}
+	return unicode
+}
+
+// escapeNonASCII percent-encodes the bytes of s outside printable ASCII,
+// leaving the escapes already in it alone
+func escapeNonASCII(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c >= 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// GroupOutputPaths returns one output path per URL inside dir. Names that
+// would collide are prefixed with the URL's position in the group.
+func GroupOutputPaths(dir string, urls []string) []string {
+	counts := make(map[string]int)
+	names := make([]string, len(urls))
+	for i, u := range urls {
+		names[i] = FilenameFromURL(u)
+		counts[nameKey(names[i])]++
+	}
+
+	paths := make([]string, len(urls))
+	for i, name := range names {
+		if counts[nameKey(name)] > 1 {
+			name = fmt.Sprintf("%04d_%s", i+1, name)
+		}
+		paths[i] = filepath.Join(dir, name)
+	}
+	return paths
+}
+
+// nameKey is the name two files collide under: Windows and macOS file
+// systems ignore case
+func nameKey(name string) string {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// isHTTPURL reports whether rawURL is an absolute http or https URL
+func isHTTPURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrRemoteFileChanged is returned when the file on the server changes while
+// it is being downloaded, so the parts already on disk no longer belong to it
+var ErrRemoteFileChanged = errors.New("remote file changed since the download started")
+
+// strongETag returns the ETag if it can be sent in If-Range; weak validators
+// ("W/...") are not allowed there and are ignored
+func strongETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	if strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+	return etag
+}
+
+// checkRemoteUnchanged compares the response's ETag with the one recorded when
+// the download started
+func (d *Downloader) checkRemoteUnchanged(resp *http.Response) error {
+	if d.Progress == nil || d.Progress.ETag == "" {
+		return nil
+	}
+
+	etag := strongETag(resp.Header.Get("ETag"))
+	if etag != "" && etag != d.Progress.ETag {
+		return fmt.Errorf("%w: got ETag %s, expected %s", ErrRemoteFileChanged, etag, d.Progress.ETag)
+	}
+	return nil
+}
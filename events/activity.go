@@ -0,0 +1,179 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+const (
+	// ProgressInterval is how often running downloads publish progress events
+	ProgressInterval = time.Second
+	// SpeedWindow is how far back the speed of a download is averaged
+	SpeedWindow = 5 * time.Second
+	// IdleAfter is how long a running download may go without progress
+	// events before it counts as idle, e.g. because its node stopped
+	IdleAfter = 5 * ProgressInterval
+)
+
+// Stats are the live counters of one download
+type Stats struct {
+	// Speed is the recent transfer rate in bytes per second
+	Speed float64
+	// Connections is how many parts were transferring at the last event
+	Connections int
+	// LastByteAt is when the download was last seen receiving data; zero
+	// if it has not received any since this process started watching it
+	LastByteAt time.Time
+}
+
+// sample is the byte count of a download at one point in time
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+type activity struct {
+	samples     []sample
+	connections int
+	lastByteAt  time.Time
+	updated     time.Time
+	running     bool
+}
+
+// Activity keeps live counters for every download from the events on a bus,
+// so listings reflect a transfer within a progress tick instead of the last
+// database write. Subscribe its Handle method to a bus.
+type Activity struct {
+	mu        sync.Mutex
+	downloads map[string]*activity
+}
+
+// NewActivity creates an empty tracker
+func NewActivity() *Activity {
+	return &Activity{downloads: make(map[string]*activity)}
+}
+
+// Handle records an event
+func (a *Activity) Handle(e Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e.Type == Deleted {
+		delete(a.downloads, e.DownloadID)
+		return
+	}
+	d, ok := a.downloads[e.DownloadID]
+	if !ok {
+		d = &activity{}
+		a.downloads[e.DownloadID] = d
+	}
+	d.updated = e.Time
+
+	if (e.Type != Started && e.Type != Progress) || (e.Status != "" && e.Status != lifecycle.Downloading) {
+		// Paused, finished or not started yet; keep only when it last
+		// received data
+		d.running = false
+		d.samples = nil
+		d.connections = 0
+		return
+	}
+
+	d.running = true
+	d.connections = e.Connections
+	if n := len(d.samples); n > 0 {
+		last := d.samples[n-1]
+		if e.BytesDownloaded < last.bytes {
+			// Restarted from scratch; earlier samples no longer compare
+			d.samples = nil
+		} else if e.BytesDownloaded > last.bytes {
+			d.lastByteAt = e.Time
+		}
+	}
+	d.samples = append(d.samples, sample{at: e.Time, bytes: e.BytesDownloaded})
+
+	// Keep the newest sample at or before the start of the window, so the
+	// average covers the whole window
+	cutoff := e.Time.Add(-SpeedWindow)
+	for len(d.samples) > 2 && !d.samples[1].at.After(cutoff) {
+		d.samples = d.samples[1:]
+	}
+}
+
+// Get returns the counters of download id at now, and false if no event
+// about it was seen
+func (a *Activity) Get(id string, now time.Time) (Stats, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	d, ok := a.downloads[id]
+	if !ok {
+		return Stats{}, false
+	}
+	stats := Stats{LastByteAt: d.lastByteAt}
+	if !d.running || now.Sub(d.updated) > IdleAfter {
+		return stats, true
+	}
+
+	stats.Connections = d.connections
+	if n := len(d.samples); n > 1 {
+		first, last := d.samples[0], d.samples[n-1]
+		if elapsed := last.at.Sub(first.at).Seconds(); elapsed > 0 {
+			stats.Speed = float64(last.bytes-first.bytes) / elapsed
+		}
+	}
+	return stats, true
+}
+
+// Running returns the counters of the downloads transferring at now
+func (a *Activity) Running(now time.Time) []Stats {
+	a.mu.Lock()
+	ids := make([]string, 0, len(a.downloads))
+	for id, d := range a.downloads {
+		if d.running && now.Sub(d.updated) <= IdleAfter {
+			ids = append(ids, id)
+		}
+	}
+	a.mu.Unlock()
+
+	running := make([]Stats, 0, len(ids))
+	for _, id := range ids {
+		if stats, ok := a.Get(id, now); ok {
+			running = append(running, stats)
+		}
+	}
+	return running
+}
+
+// MoreActive reports whether a should be listed before b: the download that
+// received data most recently comes first, and among those that received
+// data at the same time, the faster one
+func MoreActive(a, b Stats) bool {
+	if !a.LastByteAt.Equal(b.LastByteAt) {
+		return a.LastByteAt.After(b.LastByteAt)
+	}
+	return a.Speed > b.Speed
+}
+
+// Throttle wraps handler so it sees at most one progress event per download
+// every interval. Other events always pass and reset the interval, so the
+// next progress event after them is delivered.
+func Throttle(handler Handler, interval time.Duration) Handler {
+	var mu sync.Mutex
+	last := make(map[string]time.Time)
+	return func(e Event) {
+		mu.Lock()
+		if e.Type == Progress {
+			if at, ok := last[e.DownloadID]; ok && e.Time.Sub(at) < interval {
+				mu.Unlock()
+				return
+			}
+			last[e.DownloadID] = e.Time
+		} else {
+			delete(last, e.DownloadID)
+		}
+		mu.Unlock()
+		handler(e)
+	}
+}
@@ -0,0 +1,133 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+func progress(id string, at time.Time, bytes int64, connections int) Event {
+	return Event{Type: Progress, DownloadID: id, Status: lifecycle.Downloading, BytesDownloaded: bytes, Connections: connections, Time: at}
+}
+
+func TestActivityMeasuresRecentSpeed(t *testing.T) {
+	a := NewActivity()
+	start := time.Now().Add(-10 * time.Second)
+
+	a.Handle(Event{Type: Started, DownloadID: "d1", Status: lifecycle.Downloading, BytesDownloaded: 1000, Time: start})
+	// 1KB/s for the first seconds, then 4KB/s for the last five
+	bytes := int64(1000)
+	for i := 1; i <= 10; i++ {
+		if i <= 5 {
+			bytes += 1024
+		} else {
+			bytes += 4096
+		}
+		a.Handle(progress("d1", start.Add(time.Duration(i)*time.Second), bytes, 4))
+	}
+
+	stats, ok := a.Get("d1", start.Add(10*time.Second))
+	if !ok {
+		t.Fatal("Get() found no activity")
+	}
+	if stats.Speed != 4096 {
+		t.Errorf("Speed = %v, want 4096 over the last %s", stats.Speed, SpeedWindow)
+	}
+	if stats.Connections != 4 {
+		t.Errorf("Connections = %d, want 4", stats.Connections)
+	}
+	if want := start.Add(10 * time.Second); !stats.LastByteAt.Equal(want) {
+		t.Errorf("LastByteAt = %s, want %s", stats.LastByteAt, want)
+	}
+
+	// A stalled transfer keeps when it last received data and slows down
+	a.Handle(progress("d1", start.Add(11*time.Second), bytes, 4))
+	a.Handle(progress("d1", start.Add(16*time.Second), bytes, 4))
+	stats, _ = a.Get("d1", start.Add(16*time.Second))
+	if stats.Speed != 0 || !stats.LastByteAt.Equal(start.Add(10*time.Second)) {
+		t.Errorf("stalled download = %+v", stats)
+	}
+
+	// Without events the download is idle
+	stats, _ = a.Get("d1", start.Add(16*time.Second+IdleAfter+time.Second))
+	if stats.Speed != 0 || stats.Connections != 0 {
+		t.Errorf("idle download = %+v", stats)
+	}
+}
+
+func TestActivityStopsWithTheDownload(t *testing.T) {
+	a := NewActivity()
+	now := time.Now()
+	a.Handle(progress("d1", now.Add(-2*time.Second), 0, 2))
+	a.Handle(progress("d1", now.Add(-time.Second), 5000, 2))
+	a.Handle(Event{Type: Paused, DownloadID: "d1", Status: lifecycle.Paused, Time: now})
+
+	stats, ok := a.Get("d1", now)
+	if !ok || stats.Speed != 0 || stats.Connections != 0 || stats.LastByteAt.IsZero() {
+		t.Errorf("paused download = %+v, %v", stats, ok)
+	}
+
+	a.Handle(Event{Type: Deleted, DownloadID: "d1", Time: now})
+	if _, ok := a.Get("d1", now); ok {
+		t.Error("deleted download still has activity")
+	}
+}
+
+func TestActivityRunning(t *testing.T) {
+	a := NewActivity()
+	now := time.Now()
+	a.Handle(progress("fast", now.Add(-2*time.Second), 0, 4))
+	a.Handle(progress("fast", now.Add(-time.Second), 8000, 4))
+	a.Handle(progress("paused", now.Add(-time.Second), 100, 1))
+	a.Handle(Event{Type: Paused, DownloadID: "paused", Status: lifecycle.Paused, Time: now})
+	a.Handle(progress("gone", now.Add(-time.Minute), 100, 1))
+
+	running := a.Running(now)
+	if len(running) != 1 || running[0].Speed != 8000 {
+		t.Errorf("Running() = %+v, want only the transferring download", running)
+	}
+}
+
+func TestMoreActive(t *testing.T) {
+	now := time.Now()
+	recent := Stats{LastByteAt: now, Speed: 10}
+	older := Stats{LastByteAt: now.Add(-time.Minute), Speed: 1000}
+	faster := Stats{LastByteAt: now, Speed: 20}
+
+	if !MoreActive(recent, older) || MoreActive(older, recent) {
+		t.Error("the download that received data last is not listed first")
+	}
+	if !MoreActive(faster, recent) {
+		t.Error("the faster of two equally recent downloads is not listed first")
+	}
+	if MoreActive(Stats{}, Stats{}) {
+		t.Error("idle downloads are not equal")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var got []Event
+	handler := Throttle(func(e Event) { got = append(got, e) }, 3*time.Second)
+	start := time.Now()
+
+	for i := 0; i < 7; i++ {
+		handler(progress("d1", start.Add(time.Duration(i)*time.Second), int64(i), 1))
+	}
+	handler(progress("d2", start, 0, 1))
+	handler(Event{Type: Completed, DownloadID: "d1", Time: start.Add(7 * time.Second)})
+	handler(progress("d1", start.Add(7*time.Second), 7, 1))
+
+	var d1 []int64
+	for _, e := range got {
+		if e.DownloadID == "d1" && e.Type == Progress {
+			d1 = append(d1, e.BytesDownloaded)
+		}
+	}
+	if len(d1) != 4 || d1[0] != 0 || d1[1] != 3 || d1[2] != 6 || d1[3] != 7 {
+		t.Errorf("d1 progress delivered = %v, want [0 3 6 7]", d1)
+	}
+	if len(got) != 6 {
+		t.Errorf("delivered %d events, want 6", len(got))
+	}
+}
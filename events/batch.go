@@ -0,0 +1,139 @@
+package events
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// BatchPolicy sets how often a Batcher flushes. The interval grows with the
+// number of downloads in the last batch, so a store sees about PerSecond
+// rows a second however many downloads run, within Min and Max.
+type BatchPolicy struct {
+	Min time.Duration
+	Max time.Duration
+	// PerSecond is the most progress rows a second flushes should average;
+	// zero keeps the interval at Min
+	PerSecond int
+}
+
+// Interval returns how long to wait after a batch of n downloads
+func (p BatchPolicy) Interval(n int) time.Duration {
+	interval := p.Min
+	if p.PerSecond > 0 {
+		if spread := time.Duration(n) * time.Second / time.Duration(p.PerSecond); spread > interval {
+			interval = spread
+		}
+	}
+	if p.Max > 0 && interval > p.Max {
+		interval = p.Max
+	}
+	return interval
+}
+
+// Batcher coalesces progress events for a store: it keeps the latest
+// progress event of each download and hands them to flush together, so the
+// store takes one write per batch instead of one per download and tick.
+// Other events go to handler right away and drop the pending progress of
+// their download, which they supersede.
+type Batcher struct {
+	policy  BatchPolicy
+	flush   func([]Event)
+	handler Handler
+
+	mu      sync.Mutex
+	pending map[string]Event
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewBatcher starts a batcher that calls flush with the pending progress
+// events as often as policy allows. handler may be nil when the store only
+// records progress.
+func NewBatcher(policy BatchPolicy, flush func([]Event), handler Handler) *Batcher {
+	b := &Batcher{
+		policy:  policy,
+		flush:   flush,
+		handler: handler,
+		pending: make(map[string]Event),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Handle is the Handler to subscribe the batcher with
+func (b *Batcher) Handle(e Event) {
+	b.mu.Lock()
+	if e.Type == Progress {
+		if current, ok := b.pending[e.DownloadID]; !ok || e.Seq > current.Seq {
+			b.pending[e.DownloadID] = e
+		}
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, e.DownloadID)
+	b.mu.Unlock()
+
+	if b.handler != nil {
+		b.handler(e)
+	}
+}
+
+// Flush hands the pending progress events to flush now, ordered by
+// download, and returns how many there were
+func (b *Batcher) Flush() int {
+	b.mu.Lock()
+	batch := make([]Event, 0, len(b.pending))
+	for _, e := range b.pending {
+		batch = append(batch, e)
+	}
+	b.pending = make(map[string]Event, len(batch))
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return 0
+	}
+	// A stable order keeps concurrent batches from locking rows in
+	// opposite orders
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].DownloadID < batch[j].DownloadID
+	})
+	b.deliver(batch)
+	return len(batch)
+}
+
+// deliver runs flush so a panicking store cannot stop the batcher
+func (b *Batcher) deliver(batch []Event) {
+	defer func() {
+		recover()
+	}()
+	b.flush(batch)
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	timer := time.NewTimer(b.policy.Interval(0))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(b.policy.Interval(b.Flush()))
+		case <-b.stop:
+			b.Flush()
+			return
+		}
+	}
+}
+
+// Close flushes what is pending and stops the batcher. Close the bus the
+// batcher is subscribed to first, so no event arrives after the last flush.
+func (b *Batcher) Close() {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+}
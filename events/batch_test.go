@@ -0,0 +1,80 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchPolicyInterval(t *testing.T) {
+	policy := BatchPolicy{Min: 3 * time.Second, Max: 30 * time.Second, PerSecond: 100}
+	tests := []struct {
+		downloads int
+		want      time.Duration
+	}{
+		{downloads: 0, want: 3 * time.Second},
+		{downloads: 50, want: 3 * time.Second},
+		{downloads: 1000, want: 10 * time.Second},
+		{downloads: 100000, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.Interval(tt.downloads); got != tt.want {
+			t.Errorf("Interval(%d) = %v, want %v", tt.downloads, got, tt.want)
+		}
+	}
+}
+
+func TestBatcherCoalescesProgress(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]Event
+	var passed []Event
+	b := NewBatcher(BatchPolicy{Min: time.Hour}, func(batch []Event) {
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}, func(e Event) {
+		passed = append(passed, e)
+	})
+	defer b.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		e := progress("d2", start.Add(time.Duration(i)*time.Second), int64(i), 1)
+		e.Seq = int64(i + 1)
+		b.Handle(e)
+	}
+	late := progress("d2", start, 99, 1)
+	late.Seq = 2
+	b.Handle(late)
+	b.Handle(progress("d1", start, 10, 1))
+	b.Handle(progress("d3", start, 30, 1))
+	b.Handle(Event{Type: Completed, DownloadID: "d3"})
+
+	if n := b.Flush(); n != 2 {
+		t.Fatalf("Flush() = %d, want 2", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	batch := batches[0]
+	if batch[0].DownloadID != "d1" || batch[1].DownloadID != "d2" || batch[1].BytesDownloaded != 4 {
+		t.Errorf("batch = %+v, want d1 and the latest d2", batch)
+	}
+	if len(passed) != 1 || passed[0].Type != Completed {
+		t.Errorf("passed = %+v, want only the completed event", passed)
+	}
+}
+
+func TestBatcherFlushesOnClose(t *testing.T) {
+	flushed := 0
+	b := NewBatcher(BatchPolicy{Min: time.Hour}, func(batch []Event) {
+		flushed += len(batch)
+	}, nil)
+	b.Handle(progress("d1", time.Now(), 1, 1))
+	b.Handle(Event{Type: Paused, DownloadID: "d2"})
+	b.Close()
+	b.Close()
+
+	if flushed != 1 {
+		t.Errorf("flushed %d events on close, want 1", flushed)
+	}
+}
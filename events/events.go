@@ -0,0 +1,329 @@
+// Package events is an in-process publish/subscribe bus for download
+// lifecycle events. Handlers and workers publish what happened to a download;
+// database updaters, loggers and other listeners subscribe instead of every
+// handler repeating the same update logic. A Transport can bridge the bus to
+// other nodes, e.g. over Redis pub/sub.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+// Type is the kind of lifecycle event
+type Type string
+
+const (
+	Created   Type = "created"
+	Started   Type = "started"
+	Progress  Type = "progress"
+	Paused    Type = "paused"
+	Completed Type = "completed"
+	Failed    Type = "failed"
+	Deleted   Type = "deleted"
+	// Requeued jobs went back to the queue, e.g. because their worker
+	// stopped or they stalled
+	Requeued Type = "requeued"
+)
+
+// Transitions are the events that move a download between states, which
+// its history records
+var Transitions = []Type{Created, Started, Paused, Completed, Failed, Requeued}
+
+// ForStatus returns the event announcing a move to status, if there is one
+func ForStatus(status lifecycle.Status) (Type, bool) {
+	switch status {
+	case lifecycle.Downloading:
+		return Started, true
+	case lifecycle.Paused:
+		return Paused, true
+	case lifecycle.Completed, lifecycle.NotModified:
+		return Completed, true
+	case lifecycle.Failed:
+		return Failed, true
+	}
+	return "", false
+}
+
+// Event is something that happened to a download
+type Event struct {
+	Type       Type             `json:"type"`
+	DownloadID string           `json:"download_id"`
+	Status     lifecycle.Status `json:"status,omitempty"`
+	// BytesDownloaded and TotalBytes are set when the progress is known
+	BytesDownloaded int64 `json:"bytes_downloaded,omitempty"`
+	TotalBytes      int64 `json:"total_bytes,omitempty"`
+	// Connections is how many parts were transferring, set on progress
+	Connections int `json:"connections,omitempty"`
+	// Throttled is set on progress while the origin has asked the
+	// download to back off via Retry-After
+	Throttled bool `json:"throttled,omitempty"`
+	// Cached is set on completion when the file was placed from a cache
+	// rather than downloaded
+	Cached bool   `json:"cached,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Reason says why a download was requeued
+	Reason string `json:"reason,omitempty"`
+	// Node is the process that published the event
+	Node string `json:"node,omitempty"`
+	// Actor is who caused the event within Node: the client address of the
+	// request, the worker or the schedule. It is empty when the node acted
+	// on its own.
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+	// Seq orders events about the same download. Publishers take it with
+	// lifecycle.NextSeq when they read the state the event describes, so
+	// stores can reject an older snapshot that arrives late.
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// actorKey is the context key of WithActor
+type actorKey struct{}
+
+// WithActor returns a context whose events are caused by actor, e.g. the
+// client address of a request
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set with WithActor, or ""
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Handler receives events from a subscription
+type Handler func(Event)
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before progress events to it are dropped
+const subscriberBuffer = 256
+
+type subscriber struct {
+	name    string
+	types   map[Type]bool
+	handler Handler
+	queue   chan Event
+	done    chan struct{}
+	dropped int64
+}
+
+func (s *subscriber) wants(t Type) bool {
+	return len(s.types) == 0 || s.types[t]
+}
+
+func (s *subscriber) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		s.handle(e)
+	}
+}
+
+// handle runs the handler so a panicking subscriber cannot take the bus down
+func (s *subscriber) handle(e Event) {
+	defer func() {
+		recover()
+	}()
+	s.handler(e)
+}
+
+// Bus delivers published events to every subscriber. Each subscriber has its
+// own queue and goroutine, so a slow subscriber never blocks the publisher;
+// when its queue is full, progress events to it are dropped while lifecycle
+// events wait for room.
+type Bus struct {
+	node   string
+	mu     sync.RWMutex
+	subs   []*subscriber
+	closed bool
+}
+
+// NewBus creates a bus for the process identified by node
+func NewBus(node string) *Bus {
+	return &Bus{node: node}
+}
+
+// Node returns the name events published on this bus carry
+func (b *Bus) Node() string {
+	return b.node
+}
+
+// Subscribe registers handler for the given event types, or for every type
+// if none are given. The returned function cancels the subscription after
+// the events already queued for it were handled.
+func (b *Bus) Subscribe(name string, handler Handler, types ...Type) func() {
+	s := &subscriber{
+		name:    name,
+		handler: handler,
+		queue:   make(chan Event, subscriberBuffer),
+		done:    make(chan struct{}),
+	}
+	if len(types) > 0 {
+		s.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	go s.run()
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(s.queue)
+		return func() {}
+	}
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if b.remove(s) {
+				close(s.queue)
+			}
+			<-s.done
+		})
+	}
+}
+
+func (b *Bus) remove(s *subscriber) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Local wraps handler so it only sees events published on this node. Use it
+// for subscribers that persist events, so a bridged event is written once by
+// the node it happened on rather than by every node.
+func (b *Bus) Local(handler Handler) Handler {
+	return func(e Event) {
+		if e.Node == b.node {
+			handler(e)
+		}
+	}
+}
+
+// Publish stamps e with this node, the current time and the next sequence
+// number, unless already set, and queues it for every interested subscriber
+func (b *Bus) Publish(e Event) {
+	if e.Node == "" {
+		e.Node = b.node
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Seq == 0 {
+		e.Seq = lifecycle.NextSeq()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, s := range b.subs {
+		if !s.wants(e.Type) {
+			continue
+		}
+		if e.Type == Progress {
+			select {
+			case s.queue <- e:
+			default:
+				atomic.AddInt64(&s.dropped, 1)
+			}
+			continue
+		}
+		s.queue <- e
+	}
+}
+
+// Dropped returns how many progress events each subscriber missed because
+// it fell behind
+func (b *Bus) Dropped() map[string]int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	dropped := make(map[string]int64, len(b.subs))
+	for _, s := range b.subs {
+		dropped[s.name] += atomic.LoadInt64(&s.dropped)
+	}
+	return dropped
+}
+
+// Close stops accepting events and waits until every subscriber handled the
+// events already queued for it
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	subs := b.subs
+	b.subs = nil
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		close(s.queue)
+	}
+	for _, s := range subs {
+		<-s.done
+	}
+}
+
+// Transport carries encoded events between nodes
+type Transport interface {
+	// Send broadcasts an encoded event to every node
+	Send(ctx context.Context, data []byte) error
+	// Receive calls deliver for every event broadcast by any node, including
+	// this one, until ctx is cancelled
+	Receive(ctx context.Context, deliver func(data []byte)) error
+}
+
+// Bridge forwards events published on this node to t and publishes events
+// received from other nodes on this bus. It blocks until ctx is cancelled or
+// the transport fails; onError is told about events that could not be sent
+// or decoded.
+func (b *Bus) Bridge(ctx context.Context, t Transport, onError func(error)) error {
+	if onError == nil {
+		onError = func(error) {}
+	}
+
+	unsubscribe := b.Subscribe("bridge", func(e Event) {
+		if e.Node != b.node {
+			return
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			onError(fmt.Errorf("failed to encode %s event: %w", e.Type, err))
+			return
+		}
+		if err := t.Send(ctx, data); err != nil {
+			onError(fmt.Errorf("failed to send %s event: %w", e.Type, err))
+		}
+	})
+	defer unsubscribe()
+
+	return t.Receive(ctx, func(data []byte) {
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			onError(fmt.Errorf("failed to decode event: %w", err))
+			return
+		}
+		if e.Node == b.node {
+			return
+		}
+		b.Publish(e)
+	})
+}
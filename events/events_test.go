@@ -0,0 +1,193 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+func TestPublishDeliversToMatchingSubscribers(t *testing.T) {
+	bus := NewBus("node-a")
+
+	var mu sync.Mutex
+	var all, failures []Event
+	bus.Subscribe("all", func(e Event) {
+		mu.Lock()
+		all = append(all, e)
+		mu.Unlock()
+	})
+	bus.Subscribe("failures", func(e Event) {
+		mu.Lock()
+		failures = append(failures, e)
+		mu.Unlock()
+	}, Failed)
+
+	bus.Publish(Event{Type: Created, DownloadID: "d1"})
+	bus.Publish(Event{Type: Failed, DownloadID: "d1", Error: "boom"})
+	bus.Close()
+
+	if len(all) != 2 || all[0].Type != Created || all[1].Type != Failed {
+		t.Fatalf("all subscriber got %+v", all)
+	}
+	if len(failures) != 1 || failures[0].Error != "boom" {
+		t.Fatalf("failures subscriber got %+v", failures)
+	}
+	if all[0].Node != "node-a" || all[0].Time.IsZero() || all[0].Seq == 0 || all[1].Seq <= all[0].Seq {
+		t.Fatalf("event was not stamped: %+v", all[0])
+	}
+}
+
+func TestSlowSubscriberOnlyLosesProgress(t *testing.T) {
+	bus := NewBus("node-a")
+
+	release := make(chan struct{})
+	var got []Event
+	bus.Subscribe("slow", func(e Event) {
+		<-release
+		got = append(got, e)
+	})
+
+	for i := 0; i < subscriberBuffer*2; i++ {
+		bus.Publish(Event{Type: Progress, DownloadID: "d1", BytesDownloaded: int64(i)})
+	}
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Type: Completed, DownloadID: "d1"})
+		close(done)
+	}()
+
+	if dropped := bus.Dropped()["slow"]; dropped == 0 {
+		t.Fatal("no progress events were dropped for a stalled subscriber")
+	}
+	close(release)
+	<-done
+	bus.Close()
+
+	if last := got[len(got)-1]; last.Type != Completed {
+		t.Fatalf("last event = %s, want completed", last.Type)
+	}
+}
+
+func TestPanickingSubscriberKeepsReceiving(t *testing.T) {
+	bus := NewBus("node-a")
+	count := 0
+	bus.Subscribe("panics", func(e Event) {
+		count++
+		panic("subscriber bug")
+	})
+	bus.Publish(Event{Type: Created})
+	bus.Publish(Event{Type: Deleted})
+	bus.Close()
+
+	if count != 2 {
+		t.Fatalf("handler ran %d times, want 2", count)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewBus("node-a")
+	count := 0
+	cancel := bus.Subscribe("once", func(e Event) { count++ })
+	bus.Publish(Event{Type: Created})
+	cancel()
+	bus.Publish(Event{Type: Deleted})
+	bus.Close()
+
+	if count != 1 {
+		t.Fatalf("handler ran %d times after unsubscribing, want 1", count)
+	}
+}
+
+func TestForStatus(t *testing.T) {
+	if typ, ok := ForStatus(lifecycle.Downloading); !ok || typ != Started {
+		t.Fatalf("ForStatus(downloading) = %s, %v", typ, ok)
+	}
+	if _, ok := ForStatus(lifecycle.Queued); ok {
+		t.Fatal("ForStatus(queued) reported an event")
+	}
+}
+
+func TestActor(t *testing.T) {
+	if actor := ActorFrom(context.Background()); actor != "" {
+		t.Errorf("actor of a plain context = %q", actor)
+	}
+	if actor := ActorFrom(WithActor(context.Background(), "192.0.2.7")); actor != "192.0.2.7" {
+		t.Errorf("actor = %q", actor)
+	}
+}
+
+// memoryTransport is a broadcast channel shared by several buses
+type memoryTransport struct {
+	mu        sync.Mutex
+	receivers []chan []byte
+}
+
+func (m *memoryTransport) Send(ctx context.Context, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.receivers {
+		r <- data
+	}
+	return nil
+}
+
+func (m *memoryTransport) Receive(ctx context.Context, deliver func([]byte)) error {
+	ch := make(chan []byte, 16)
+	m.mu.Lock()
+	m.receivers = append(m.receivers, ch)
+	m.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case data := <-ch:
+			deliver(data)
+		}
+	}
+}
+
+func TestBridgeForwardsBetweenNodes(t *testing.T) {
+	transport := &memoryTransport{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := NewBus("node-a"), NewBus("node-b")
+	received := make(chan Event, 4)
+	local := make(chan Event, 4)
+	a.Subscribe("a", func(e Event) { received <- e })
+	a.Subscribe("a-local", a.Local(func(e Event) { local <- e }))
+
+	go a.Bridge(ctx, transport, nil)
+	go b.Bridge(ctx, transport, nil)
+	for {
+		transport.mu.Lock()
+		n := len(transport.receivers)
+		transport.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	b.Publish(Event{Type: Completed, DownloadID: "d1", Status: lifecycle.Completed})
+
+	select {
+	case e := <-received:
+		if e.Node != "node-b" || e.Status != lifecycle.Completed {
+			t.Fatalf("received %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event from node-b never reached node-a")
+	}
+
+	select {
+	case e := <-received:
+		t.Fatalf("event was delivered twice: %+v", e)
+	case e := <-local:
+		t.Fatalf("local subscriber saw a bridged event: %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,202 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+const (
+	// MaxPollWait is the longest a status request may long-poll
+	MaxPollWait = 60 * time.Second
+	// DefaultMinProgress is how many percentage points of progress end a
+	// long-poll when the request does not say
+	DefaultMinProgress = 1.0
+	// PollInterval is how often PollSnapshot should re-read state kept in
+	// memory or in the queue
+	PollInterval = 250 * time.Millisecond
+	// StorePollInterval is how often PollSnapshot should re-read a database
+	// record, slower than clients used to poll so waiting costs fewer reads
+	StorePollInterval = 2 * time.Second
+)
+
+// Poll is what a long-polling status request waits for
+type Poll struct {
+	// Wait is how long to hold the request; zero answers at once
+	Wait time.Duration
+	// MinProgress is how many percentage points the download must move to
+	// end the wait; any move counts while the total size is unknown
+	MinProgress float64
+}
+
+// ParsePoll reads the wait and min_progress query parameters, e.g.
+// wait=30s&min_progress=5. Empty values leave the defaults.
+func ParsePoll(wait, minProgress string) (Poll, error) {
+	poll := Poll{MinProgress: DefaultMinProgress}
+	if wait != "" {
+		d, err := time.ParseDuration(wait)
+		if err != nil {
+			return poll, fmt.Errorf("invalid wait %q: %w", wait, err)
+		}
+		if d < 0 || d > MaxPollWait {
+			return poll, fmt.Errorf("wait must be between 0s and %v", MaxPollWait)
+		}
+		poll.Wait = d
+	}
+	if minProgress != "" {
+		p, err := strconv.ParseFloat(minProgress, 64)
+		if err != nil || p < 0 || p > 100 {
+			return poll, fmt.Errorf("min_progress must be a percentage between 0 and 100")
+		}
+		poll.MinProgress = p
+	}
+	return poll, nil
+}
+
+// Snapshot is the state of a download a long-poll compares against
+type Snapshot struct {
+	Status          lifecycle.Status
+	BytesDownloaded int64
+	TotalBytes      int64
+}
+
+// Moved reports whether next differs from s enough to end a poll: a new
+// status, or at least minProgress percentage points of progress
+func (s Snapshot) Moved(next Snapshot, minProgress float64) bool {
+	if next.Status != s.Status {
+		return true
+	}
+	if next.BytesDownloaded == s.BytesDownloaded {
+		return false
+	}
+	if next.TotalBytes <= 0 {
+		return true
+	}
+	delta := float64(next.BytesDownloaded-s.BytesDownloaded) * 100 / float64(next.TotalBytes)
+	return delta >= minProgress || delta < 0
+}
+
+// waiter is one request waiting on a download
+type waiter struct {
+	base        *Snapshot
+	minProgress float64
+	woken       chan struct{}
+}
+
+// Watcher wakes long-polling status requests when their download moves on,
+// so clients that cannot use a stream need not poll in a tight loop.
+// Subscribe its Handle method to a bus.
+type Watcher struct {
+	mu      sync.Mutex
+	waiters map[string]map[*waiter]struct{}
+}
+
+// NewWatcher creates a watcher with no waiting requests
+func NewWatcher() *Watcher {
+	return &Watcher{waiters: make(map[string]map[*waiter]struct{})}
+}
+
+// Handle wakes the requests waiting on the download of an event
+func (w *Watcher) Handle(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for wt := range w.waiters[e.DownloadID] {
+		moved := e.Type != Progress
+		if !moved {
+			if wt.base == nil {
+				// Still reading its snapshot; a status change that races
+				// it ends the wait, progress waits for the next event
+				continue
+			}
+			next := Snapshot{Status: e.Status, BytesDownloaded: e.BytesDownloaded, TotalBytes: e.TotalBytes}
+			if next.Status == "" {
+				next.Status = wt.base.Status
+			}
+			moved = wt.base.Moved(next, wt.minProgress)
+		}
+		if moved {
+			close(wt.woken)
+			delete(w.waiters[e.DownloadID], wt)
+		}
+	}
+}
+
+// Wait holds a status request until download id moves on from the state
+// snapshot reads, poll.Wait passes or ctx ends. snapshot reports false when
+// the download does not exist, which ends the wait. Downloads in a terminal
+// state return at once.
+func (w *Watcher) Wait(ctx context.Context, id string, poll Poll, snapshot func() (Snapshot, bool)) {
+	if poll.Wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(poll.Wait)
+	defer timer.Stop()
+
+	// Register before reading the snapshot, so no event falls in between
+	wt := &waiter{minProgress: poll.MinProgress, woken: make(chan struct{})}
+	w.mu.Lock()
+	if w.waiters[id] == nil {
+		w.waiters[id] = make(map[*waiter]struct{})
+	}
+	w.waiters[id][wt] = struct{}{}
+	w.mu.Unlock()
+	defer w.remove(id, wt)
+
+	base, ok := snapshot()
+	if !ok || base.Status.Terminal() {
+		return
+	}
+	w.mu.Lock()
+	wt.base = &base
+	w.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-wt.woken:
+	}
+}
+
+// PollSnapshot is Wait for servers without a bus: it re-reads snapshot
+// every interval until the download moves on, poll.Wait passes or ctx ends
+func PollSnapshot(ctx context.Context, poll Poll, interval time.Duration, snapshot func() (Snapshot, bool)) {
+	if poll.Wait <= 0 {
+		return
+	}
+	base, ok := snapshot()
+	if !ok || base.Status.Terminal() {
+		return
+	}
+	timer := time.NewTimer(poll.Wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-ticker.C:
+			if next, ok := snapshot(); !ok || base.Moved(next, poll.MinProgress) {
+				return
+			}
+		}
+	}
+}
+
+// remove stops waking a request
+func (w *Watcher) remove(id string, wt *waiter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.waiters[id], wt)
+	if len(w.waiters[id]) == 0 {
+		delete(w.waiters, id)
+	}
+}
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+func TestParsePoll(t *testing.T) {
+	poll, err := ParsePoll("30s", "")
+	if err != nil || poll.Wait != 30*time.Second || poll.MinProgress != DefaultMinProgress {
+		t.Errorf("ParsePoll(30s) = %+v, %v", poll, err)
+	}
+	for _, bad := range [][2]string{{"soon", ""}, {"2m", ""}, {"-1s", ""}, {"", "101"}, {"", "x"}} {
+		if _, err := ParsePoll(bad[0], bad[1]); err == nil {
+			t.Errorf("ParsePoll(%q, %q) accepted", bad[0], bad[1])
+		}
+	}
+}
+
+func TestSnapshotMoved(t *testing.T) {
+	base := Snapshot{Status: lifecycle.Downloading, BytesDownloaded: 100, TotalBytes: 1000}
+	tests := []struct {
+		next Snapshot
+		want bool
+	}{
+		{Snapshot{Status: lifecycle.Downloading, BytesDownloaded: 105, TotalBytes: 1000}, false},
+		{Snapshot{Status: lifecycle.Downloading, BytesDownloaded: 110, TotalBytes: 1000}, true},
+		{Snapshot{Status: lifecycle.Paused, BytesDownloaded: 100, TotalBytes: 1000}, true},
+		{Snapshot{Status: lifecycle.Downloading, BytesDownloaded: 101}, true},
+		{Snapshot{Status: lifecycle.Downloading, BytesDownloaded: 0, TotalBytes: 1000}, true},
+	}
+	for _, tt := range tests {
+		if got := base.Moved(tt.next, 1); got != tt.want {
+			t.Errorf("Moved(%+v) = %v, want %v", tt.next, got, tt.want)
+		}
+	}
+}
+
+// waitAsync runs Wait in the background and reports when it returns
+func waitAsync(w *Watcher, poll Poll, snapshot Snapshot) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Wait(context.Background(), "d1", poll, func() (Snapshot, bool) { return snapshot, true })
+	}()
+	return done
+}
+
+// registered waits until n requests wait on the watcher with a snapshot
+func registered(t *testing.T, w *Watcher, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		ready := 0
+		for wt := range w.waiters["d1"] {
+			if wt.base != nil {
+				ready++
+			}
+		}
+		w.mu.Unlock()
+		if ready == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d requests never started waiting", n)
+}
+
+func TestWatcherWakesOnProgressAndStatus(t *testing.T) {
+	w := NewWatcher()
+	base := Snapshot{Status: lifecycle.Downloading, BytesDownloaded: 0, TotalBytes: 1000}
+	poll := Poll{Wait: time.Minute, MinProgress: 5}
+	done := waitAsync(w, poll, base)
+	registered(t, w, 1)
+
+	w.Handle(Event{Type: Progress, DownloadID: "d1", Status: lifecycle.Downloading, BytesDownloaded: 20, TotalBytes: 1000})
+	w.Handle(Event{Type: Progress, DownloadID: "d2", Status: lifecycle.Downloading, BytesDownloaded: 900, TotalBytes: 1000})
+	select {
+	case <-done:
+		t.Fatal("Wait returned below the progress threshold")
+	case <-time.After(20 * time.Millisecond):
+	}
+	w.Handle(Event{Type: Progress, DownloadID: "d1", Status: lifecycle.Downloading, BytesDownloaded: 60, TotalBytes: 1000})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after 6% of progress")
+	}
+
+	done = waitAsync(w, poll, base)
+	registered(t, w, 1)
+	w.Handle(Event{Type: Paused, DownloadID: "d1", Status: lifecycle.Paused})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return on a status change")
+	}
+	if len(w.waiters) != 0 {
+		t.Errorf("waiters left behind: %v", w.waiters)
+	}
+}
+
+func TestWaitReturnsAtOnceForFinishedDownloads(t *testing.T) {
+	start := time.Now()
+	finished := func() (Snapshot, bool) { return Snapshot{Status: lifecycle.Completed}, true }
+	NewWatcher().Wait(context.Background(), "d1", Poll{Wait: time.Minute}, finished)
+	PollSnapshot(context.Background(), Poll{Wait: time.Minute}, PollInterval, finished)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait on a completed download took %v", elapsed)
+	}
+}
+
+func TestPollSnapshot(t *testing.T) {
+	var bytes int64
+	snapshot := func() (Snapshot, bool) {
+		return Snapshot{Status: lifecycle.Downloading, BytesDownloaded: atomic.LoadInt64(&bytes)}, true
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		PollSnapshot(context.Background(), Poll{Wait: time.Minute, MinProgress: 1}, PollInterval, snapshot)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt64(&bytes, 1)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("PollSnapshot did not notice progress")
+	}
+}
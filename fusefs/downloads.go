@@ -0,0 +1,283 @@
+// Package fusefs shows a downloads directory as a read-only FUSE file
+// system. Files still downloading appear at their full size, but only the
+// bytes their progress file records as downloaded can be read, so tools can
+// start on the start of a file, or the index at the end of an archive,
+// before the rest arrives.
+//
+// Downloads is the directory as an fs.FS; Serve answers the kernel's FUSE
+// requests for any fs.FS whose files implement io.ReaderAt, and Mount
+// attaches a FUSE device to a mount point (Linux only).
+package fusefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+// ErrUnsupported is returned where FUSE file systems cannot be mounted
+var ErrUnsupported = errors.New("FUSE mounts are not supported here")
+
+// rescanInterval is how long the progress files Downloads knows of are used
+// before Progress is asked again
+const rescanInterval = time.Second
+
+// Downloads is a read-only fs.FS of a downloads directory. A file with a
+// progress file that is not complete reports the size of the whole
+// download; reading bytes not downloaded yet fails with
+// downloader.ErrNotDownloaded. Other files are read as they are on disk.
+type Downloads struct {
+	// Dir is the directory shown
+	Dir string
+	// Progress returns the progress file of every known download by its
+	// absolute output path. Downloads that finished may be left in; their
+	// progress file is gone or complete. It is called again at most once a
+	// second, so downloads started after mounting show up.
+	Progress func() (map[string]string, error)
+	// Hide lists directories left out of the listing, such as a state
+	// directory inside Dir
+	Hide []string
+
+	mu       sync.Mutex
+	files    map[string]string
+	scanned  time.Time
+	scanErr  error
+	hideAbs  []string
+	hideOnce sync.Once
+}
+
+// Open opens the file or directory name, a slash-separated path in Dir
+func (d *Downloads) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	full := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if d.hidden(full) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{File: f, d: d, dir: full}, nil
+	}
+
+	progressFile, err := d.progressFile(full)
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if progressFile == "" {
+		return f, nil
+	}
+	progress, err := downloader.LoadProgress(progressFile)
+	if errors.Is(err, os.ErrNotExist) {
+		// The progress is gone with the finished download
+		return f, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if progress.IsComplete() {
+		return f, nil
+	}
+	p := &partialFile{file: f, info: info, progressFile: progressFile, progress: progress}
+	if st, err := os.Stat(progressFile); err == nil {
+		p.loaded = st.ModTime()
+	}
+	return p, nil
+}
+
+// progressFile returns the progress file of the download writing path, or
+// "" when there is none
+func (d *Downloads) progressFile(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.Progress != nil && time.Since(d.scanned) >= rescanInterval {
+		d.files, d.scanErr = d.Progress()
+		d.scanned = time.Now()
+	}
+	if d.scanErr != nil {
+		return "", fmt.Errorf("failed to find progress files: %w", d.scanErr)
+	}
+	return d.files[abs], nil
+}
+
+// hidden reports whether path is one of the Hide directories or inside one
+func (d *Downloads) hidden(path string) bool {
+	d.hideOnce.Do(func() {
+		for _, dir := range d.Hide {
+			if abs, err := filepath.Abs(dir); err == nil {
+				d.hideAbs = append(d.hideAbs, abs)
+			}
+		}
+	})
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range d.hideAbs {
+		if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirFile is a directory of Downloads, listed without its hidden entries
+type dirFile struct {
+	*os.File
+	d   *Downloads
+	dir string
+}
+
+// ReadDir lists the directory sorted by name, as fs.ReadDirFile describes
+func (f *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	var out []fs.DirEntry
+	for {
+		entries, err := f.File.ReadDir(n)
+		for _, entry := range entries {
+			if !f.d.hidden(filepath.Join(f.dir, entry.Name())) {
+				out = append(out, entry)
+			}
+		}
+		if err != nil || n <= 0 || len(out) > 0 {
+			sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+			if n <= 0 && err == io.EOF {
+				err = nil
+			}
+			return out, err
+		}
+	}
+}
+
+// partialFile is a file still downloading; only the bytes its progress
+// records can be read
+type partialFile struct {
+	file         *os.File
+	info         fs.FileInfo
+	progressFile string
+
+	mu       sync.Mutex
+	progress *downloader.Progress
+	// loaded is the modification time of the progress file when it was read
+	loaded time.Time
+	offset int64
+}
+
+// growing marks files whose content changes while they are open
+func (p *partialFile) growing() bool { return true }
+
+// Stat reports the size of the whole download
+func (p *partialFile) Stat() (fs.FileInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return partialInfo{FileInfo: p.info, size: p.progress.TotalSize}, nil
+}
+
+// current returns the progress, read again if the file changed on disk
+func (p *partialFile) current() *downloader.Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st, err := os.Stat(p.progressFile); err == nil && !st.ModTime().Equal(p.loaded) {
+		if progress, err := downloader.LoadProgress(p.progressFile); err == nil {
+			p.progress, p.loaded = progress, st.ModTime()
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		// The download finished and removed its progress
+		p.progress.Parts = []downloader.Part{{Start: 0, End: p.progress.TotalSize - 1}}
+		p.progress.Parts[0].SetDownloaded(p.progress.TotalSize)
+		p.progress.Parts[0].SetDone(true)
+	}
+	return p.progress
+}
+
+// ReadAt reads downloaded bytes. It stops with downloader.ErrNotDownloaded
+// at the first byte not downloaded yet, and with io.EOF at the end.
+func (p *partialFile) ReadAt(b []byte, off int64) (int, error) {
+	progress := p.current()
+	if off >= progress.TotalSize {
+		return 0, io.EOF
+	}
+	if progress.Encrypted {
+		// The file on disk is not laid out like the download until it is
+		// complete and can be decrypted
+		return 0, fmt.Errorf("%w: encrypted downloads can be read once complete", downloader.ErrNotDownloaded)
+	}
+
+	want := int64(len(b))
+	if off+want > progress.TotalSize {
+		want = progress.TotalSize - off
+	}
+	available := progress.AvailableFrom(off)
+	if available > want {
+		available = want
+	}
+	if available <= 0 {
+		return 0, fmt.Errorf("%w: byte %d", downloader.ErrNotDownloaded, off)
+	}
+	n, err := p.file.ReadAt(b[:available], off)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	switch {
+	case int64(n) < available:
+		return n, fmt.Errorf("%w: byte %d", downloader.ErrNotDownloaded, off+int64(n))
+	case n < len(b) && off+int64(n) >= progress.TotalSize:
+		return n, io.EOF
+	case n < len(b):
+		return n, fmt.Errorf("%w: byte %d", downloader.ErrNotDownloaded, off+int64(n))
+	}
+	return n, nil
+}
+
+// Read reads from the current offset, stopping at bytes not downloaded yet
+func (p *partialFile) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	off := p.offset
+	p.mu.Unlock()
+	n, err := p.ReadAt(b, off)
+	p.mu.Lock()
+	p.offset += int64(n)
+	p.mu.Unlock()
+	if n > 0 && errors.Is(err, downloader.ErrNotDownloaded) {
+		err = nil
+	}
+	return n, err
+}
+
+// Close closes the file on disk
+func (p *partialFile) Close() error {
+	return p.file.Close()
+}
+
+// partialInfo is the FileInfo of a file still downloading: its size is
+// that of the whole download and it cannot be written
+type partialInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i partialInfo) Size() int64       { return i.size }
+func (i partialInfo) Mode() fs.FileMode { return 0444 }
@@ -0,0 +1,157 @@
+package fusefs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+// testPayload returns n bytes that differ from offset to offset
+func testPayload(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+// newPartial writes a 2000 byte download in two parts, of which the first
+// 600 bytes and the whole second part are downloaded, and returns the
+// Downloads showing it and the progress file
+func newPartial(t *testing.T) (*Downloads, string, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, ".state")
+	if err := os.Mkdir(stateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := testPayload(2000)
+	output := filepath.Join(dir, "partial.bin")
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	progress := downloader.CreateNewProgress("http://example.com/partial.bin", output, 2000, 2)
+	progress.Parts[0].SetDownloaded(600)
+	progress.Parts[1].SetDownloaded(1000)
+	progress.Parts[1].SetDone(true)
+	progressFile := filepath.Join(stateDir, "partial.json")
+	if err := downloader.SaveProgress(progressFile, progress); err != nil {
+		t.Fatal(err)
+	}
+	return &Downloads{
+		Dir:      dir,
+		Progress: func() (map[string]string, error) { return map[string]string{output: progressFile}, nil },
+		Hide:     []string{stateDir},
+	}, progressFile, data
+}
+
+func TestDownloadsFS(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "done.bin"), []byte("complete"), 0644)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "x.txt"), []byte("nested"), 0644)
+	os.MkdirAll(filepath.Join(dir, ".state"), 0755)
+	os.WriteFile(filepath.Join(dir, ".state", "job.json"), []byte("{}"), 0644)
+
+	fsys := &Downloads{Dir: dir, Hide: []string{filepath.Join(dir, ".state")}}
+	if err := fstest.TestFS(fsys, "done.bin", "sub/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open(".state/job.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of a hidden file: err = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestDownloadsPartialFile(t *testing.T) {
+	fsys, _, data := newPartial(t)
+	f, err := fsys.Open("partial.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 2000 || info.Mode() != 0444 {
+		t.Errorf("Stat = %d bytes, mode %v", info.Size(), info.Mode())
+	}
+
+	r := f.(io.ReaderAt)
+	buf := make([]byte, 1000)
+	if n, err := r.ReadAt(buf, 0); n != 600 || !errors.Is(err, downloader.ErrNotDownloaded) || !bytes.Equal(buf[:n], data[:600]) {
+		t.Errorf("ReadAt(0) = %d, %v; want the 600 downloaded bytes", n, err)
+	}
+	if n, err := r.ReadAt(buf, 600); n != 0 || !errors.Is(err, downloader.ErrNotDownloaded) {
+		t.Errorf("ReadAt(600) = %d, %v; want ErrNotDownloaded", n, err)
+	}
+	if n, err := r.ReadAt(buf, 1500); n != 500 || err != io.EOF || !bytes.Equal(buf[:n], data[1500:]) {
+		t.Errorf("ReadAt(1500) = %d, %v; want the last 500 bytes and io.EOF", n, err)
+	}
+
+	// Read stops short of the missing bytes without an error
+	got, err := io.ReadAll(io.LimitReader(f, 2000))
+	if !errors.Is(err, downloader.ErrNotDownloaded) || len(got) != 600 {
+		t.Errorf("ReadAll = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestDownloadsPartialFileGrows(t *testing.T) {
+	fsys, progressFile, data := newPartial(t)
+	f, err := fsys.Open("partial.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := f.(io.ReaderAt)
+
+	progress, err := downloader.LoadProgress(progressFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress.Parts[0].SetDownloaded(1000)
+	progress.Parts[0].SetDone(true)
+	if err := downloader.SaveProgress(progressFile, progress); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change is seen on file systems with coarse times
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(progressFile, later, later)
+
+	buf := make([]byte, 2000)
+	if n, err := r.ReadAt(buf, 0); n != 2000 || err != nil || !bytes.Equal(buf, data) {
+		t.Errorf("ReadAt after the download went on = %d, %v", n, err)
+	}
+
+	// Once the progress file is removed the download is complete
+	os.Remove(progressFile)
+	if n, err := r.ReadAt(buf[:100], 1900); n != 100 || err != nil {
+		t.Errorf("ReadAt after the download finished = %d, %v", n, err)
+	}
+}
+
+func TestDownloadsEncryptedPartial(t *testing.T) {
+	fsys, progressFile, _ := newPartial(t)
+	progress, _ := downloader.LoadProgress(progressFile)
+	progress.Encrypted = true
+	// Encrypted parts only stop at whole chunks
+	progress.Parts[0].SetDownloaded(0)
+	downloader.SaveProgress(progressFile, progress)
+
+	f, err := fsys.Open("partial.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.(io.ReaderAt).ReadAt(make([]byte, 10), 0); !errors.Is(err, downloader.ErrNotDownloaded) {
+		t.Errorf("ReadAt of an encrypted download: err = %v, want ErrNotDownloaded", err)
+	}
+}
@@ -0,0 +1,110 @@
+//go:build linux
+// +build linux
+
+package fusefs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountOptions are passed to fusermount for every mount
+const mountOptions = "ro,nosuid,nodev,fsname=mtdl,subtype=mtdl"
+
+// Mount attaches a FUSE file system to dir and returns the device to Serve
+// it on. Root mounts it directly; other users need fusermount3 or
+// fusermount, as installed with FUSE.
+func Mount(dir string) (*os.File, error) {
+	if os.Geteuid() == 0 {
+		return mountDirect(dir)
+	}
+	return mountFusermount(dir)
+}
+
+// mountDirect opens /dev/fuse and mounts it with the mount system call
+func mountDirect(dir string) (*os.File, error) {
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/fuse: %w", err)
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d", fd, os.Getuid(), os.Getgid())
+	if err := unix.Mount("mtdl", dir, "fuse.mtdl", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, data); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to mount %s: %w", dir, err)
+	}
+	return os.NewFile(uintptr(fd), "/dev/fuse"), nil
+}
+
+// mountFusermount has the setuid fusermount helper mount dir; it passes the
+// device back over a socket
+func mountFusermount(dir string) (*os.File, error) {
+	helper, err := fusermount()
+	if err != nil {
+		return nil, err
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket for %s: %w", helper, err)
+	}
+	theirs := os.NewFile(uintptr(fds[0]), "fusermount socket")
+	ours := os.NewFile(uintptr(fds[1]), "fusermount socket")
+	defer theirs.Close()
+	defer ours.Close()
+
+	cmd := exec.Command(helper, "-o", mountOptions, "--", dir)
+	// The socket is the helper's fd 3
+	cmd.ExtraFiles = []*os.File{theirs}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed to mount %s: %v: %s", helper, dir, err, strings.TrimSpace(string(output)))
+	}
+
+	buf := make([]byte, 4)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(int(ours.Fd()), buf, oob, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive the FUSE device from %s: %w", helper, err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("%s sent no FUSE device", helper)
+	}
+	devFDs, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(devFDs) != 1 {
+		return nil, fmt.Errorf("%s sent no FUSE device", helper)
+	}
+	return os.NewFile(uintptr(devFDs[0]), "/dev/fuse"), nil
+}
+
+// Unmount detaches the file system at dir, making Serve return
+func Unmount(dir string) error {
+	if os.Geteuid() == 0 {
+		if err := unix.Unmount(dir, 0); err != nil {
+			// Busy mounts go once they are no longer used
+			return unix.Unmount(dir, unix.MNT_DETACH)
+		}
+		return nil
+	}
+	helper, err := fusermount()
+	if err != nil {
+		return err
+	}
+	if output, err := exec.Command(helper, "-u", "-z", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed to unmount %s: %v: %s", helper, dir, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// fusermount finds the FUSE mount helper, fusermount3 before fusermount
+func fusermount() (string, error) {
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if helper, err := exec.LookPath(name); err == nil {
+			return helper, nil
+		}
+	}
+	return "", fmt.Errorf("%w: fusermount3 or fusermount not found; install FUSE or mount as root", ErrUnsupported)
+}
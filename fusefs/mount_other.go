@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package fusefs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Mount is only supported on Linux
+func Mount(dir string) (*os.File, error) {
+	return nil, ErrUnsupported
+}
+
+// Unmount is only supported on Linux
+func Unmount(dir string) error {
+	return ErrUnsupported
+}
+
+// Serve is only supported on Linux
+func Serve(dev io.ReadWriter, fsys fs.FS) error {
+	return ErrUnsupported
+}
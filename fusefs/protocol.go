@@ -0,0 +1,223 @@
+//go:build linux
+// +build linux
+
+package fusefs
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// The parts of the kernel's FUSE protocol (include/uapi/linux/fuse.h) a
+// read-only file system needs. Messages are in the machine's byte order,
+// which is little endian on every platform this is built for.
+
+const (
+	kernelVersion = 7
+	// minorVersion is the protocol revision spoken; the kernel uses the
+	// lower of its own and this one
+	minorVersion = 31
+	// rootID is the node of the mount point
+	rootID = 1
+	// maxRead caps the bytes a READ asks for; the request buffer holds that
+	// much with room to spare
+	maxRead   = 128 * 1024
+	bufferLen = maxRead + 64*1024
+)
+
+// Opcodes of the requests served; others are answered with ENOSYS
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opOpen        = 14
+	opRead        = 15
+	opStatfs      = 17
+	opRelease     = 18
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+)
+
+// Flags of an open reply
+const (
+	// fopenDirectIO sends every read to the server instead of the page
+	// cache, for files that change while open
+	fopenDirectIO = 1 << 0
+)
+
+// inHeader starts every request
+type inHeader struct {
+	Len     uint32
+	Opcode  uint32
+	Unique  uint64
+	NodeID  uint64
+	UID     uint32
+	GID     uint32
+	PID     uint32
+	Padding uint32
+}
+
+const inHeaderLen = 40
+
+// outHeader starts every reply; Error is a negated errno
+type outHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+const outHeaderLen = 16
+
+type initIn struct {
+	Major        uint32
+	Minor        uint32
+	MaxReadahead uint32
+	Flags        uint32
+}
+
+type initOut struct {
+	Major               uint32
+	Minor               uint32
+	MaxReadahead        uint32
+	Flags               uint32
+	MaxBackground       uint16
+	CongestionThreshold uint16
+	MaxWrite            uint32
+	TimeGran            uint32
+	MaxPages            uint16
+	MapAlignment        uint16
+	Flags2              uint32
+	Unused              [7]uint32
+}
+
+type attr struct {
+	Ino       uint64
+	Size      uint64
+	Blocks    uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	AtimeNsec uint32
+	MtimeNsec uint32
+	CtimeNsec uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	Rdev      uint32
+	Blksize   uint32
+	Flags     uint32
+}
+
+type entryOut struct {
+	NodeID         uint64
+	Generation     uint64
+	EntryValid     uint64
+	AttrValid      uint64
+	EntryValidNsec uint32
+	AttrValidNsec  uint32
+	Attr           attr
+}
+
+type attrOut struct {
+	AttrValid     uint64
+	AttrValidNsec uint32
+	Dummy         uint32
+	Attr          attr
+}
+
+type openIn struct {
+	Flags     uint32
+	OpenFlags uint32
+}
+
+type openOut struct {
+	Fh        uint64
+	OpenFlags uint32
+	Padding   uint32
+}
+
+type readIn struct {
+	Fh        uint64
+	Offset    uint64
+	Size      uint32
+	ReadFlags uint32
+	LockOwner uint64
+	Flags     uint32
+	Padding   uint32
+}
+
+type releaseIn struct {
+	Fh           uint64
+	Flags        uint32
+	ReleaseFlags uint32
+	LockOwner    uint64
+}
+
+type statfsOut struct {
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Bsize   uint32
+	NameLen uint32
+	Frsize  uint32
+	Padding uint32
+	Spare   [6]uint32
+}
+
+// direntLen is the size of a directory entry before its name
+const direntLen = 24
+
+// appendDirent adds a READDIR entry to buf; off is where the next entry
+// starts, and entries are padded to 8 bytes
+func appendDirent(buf []byte, ino, off uint64, typ uint32, name string) []byte {
+	var head [direntLen]byte
+	binary.LittleEndian.PutUint64(head[0:], ino)
+	binary.LittleEndian.PutUint64(head[8:], off)
+	binary.LittleEndian.PutUint32(head[16:], uint32(len(name)))
+	binary.LittleEndian.PutUint32(head[20:], typ)
+	buf = append(buf, head[:]...)
+	buf = append(buf, name...)
+	for len(buf)%8 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// direntSize is how many bytes appendDirent adds for name
+func direntSize(name string) int {
+	return (direntLen + len(name) + 7) &^ 7
+}
+
+// decode reads a request body into v, leaving the fields the kernel did
+// not send zero
+func decode(body []byte, v interface{}) {
+	size := binary.Size(v)
+	if len(body) < size {
+		padded := make([]byte, size)
+		copy(padded, body)
+		body = padded
+	}
+	binary.Read(bytes.NewReader(body[:size]), binary.LittleEndian, v)
+}
+
+// encode appends the reply body v to buf
+func encode(buf *bytes.Buffer, v interface{}) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+// cString returns the NUL-terminated string at the start of body
+func cString(body []byte) string {
+	if i := bytes.IndexByte(body, 0); i >= 0 {
+		return string(body[:i])
+	}
+	return string(body)
+}
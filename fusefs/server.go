@@ -0,0 +1,388 @@
+//go:build linux
+// +build linux
+
+package fusefs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+// attrValid is how long the kernel may keep names and attributes before
+// asking again; short, since files grow as they download
+const attrValid = time.Second
+
+// server answers the FUSE requests for one mount. Requests are handled one
+// at a time, so its tables need no locks.
+type server struct {
+	dev  io.ReadWriter
+	fsys fs.FS
+	uid  uint32
+	gid  uint32
+
+	// nodes maps node IDs to slash-separated names in fsys, "." for the
+	// root; IDs are kept for the life of the mount
+	nodes   map[uint64]string
+	nodeIDs map[string]uint64
+	next    uint64
+
+	handles    map[uint64]*handle
+	nextHandle uint64
+}
+
+// handle is an open file or directory
+type handle struct {
+	file fs.File
+	// entries are a directory's listing, read when it is opened
+	entries []dirEntry
+}
+
+// dirEntry is a READDIR entry
+type dirEntry struct {
+	name string
+	ino  uint64
+	typ  uint32
+}
+
+// Serve answers the requests the kernel sends on dev, a FUSE device from
+// Mount, with the files of fsys until the file system is unmounted. Files
+// are read with io.ReaderAt. Reading bytes not downloaded yet fails with
+// ENODATA. Every read of a partly downloaded file goes to fsys.
+func Serve(dev io.ReadWriter, fsys fs.FS) error {
+	s := &server{
+		dev:     dev,
+		fsys:    fsys,
+		uid:     uint32(os.Getuid()),
+		gid:     uint32(os.Getgid()),
+		nodes:   map[uint64]string{rootID: "."},
+		nodeIDs: map[string]uint64{".": rootID},
+		next:    rootID + 1,
+		handles: make(map[uint64]*handle),
+	}
+	defer s.closeAll()
+
+	buf := make([]byte, bufferLen)
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ENODEV) || err == io.EOF {
+				// Unmounted
+				return nil
+			}
+			if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOENT) {
+				// Interrupted, or a request withdrawn before it was read
+				continue
+			}
+			return fmt.Errorf("failed to read FUSE request: %w", err)
+		}
+		if n < inHeaderLen {
+			return fmt.Errorf("short FUSE request of %d bytes", n)
+		}
+		var hdr inHeader
+		decode(buf[:inHeaderLen], &hdr)
+		if hdr.Opcode == opDestroy {
+			s.reply(hdr, 0, nil)
+			return nil
+		}
+		if err := s.handle(hdr, buf[inHeaderLen:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers one request
+func (s *server) handle(hdr inHeader, body []byte) error {
+	var out bytes.Buffer
+	var errno syscall.Errno
+	switch hdr.Opcode {
+	case opInit:
+		var in initIn
+		decode(body, &in)
+		if in.Major != kernelVersion {
+			return fmt.Errorf("unsupported FUSE protocol %d.%d", in.Major, in.Minor)
+		}
+		encode(&out, initOut{
+			Major:        kernelVersion,
+			Minor:        minorVersion,
+			MaxReadahead: in.MaxReadahead,
+			MaxWrite:     maxRead,
+			TimeGran:     1,
+		})
+
+	case opForget, opBatchForget, opInterrupt:
+		// No reply is expected; node IDs live as long as the mount
+		return nil
+
+	case opLookup:
+		errno = s.lookup(hdr.NodeID, cString(body), &out)
+
+	case opGetattr:
+		name, ok := s.nodes[hdr.NodeID]
+		if !ok {
+			errno = syscall.ENOENT
+			break
+		}
+		info, err := fs.Stat(s.fsys, name)
+		if err != nil {
+			errno = toErrno(err)
+			break
+		}
+		sec, nsec := validity()
+		encode(&out, attrOut{AttrValid: sec, AttrValidNsec: nsec, Attr: s.attr(hdr.NodeID, info)})
+
+	case opOpen:
+		var in openIn
+		decode(body, &in)
+		errno = s.open(hdr.NodeID, in, &out)
+
+	case opOpendir:
+		errno = s.opendir(hdr.NodeID, &out)
+
+	case opRead:
+		var in readIn
+		decode(body, &in)
+		errno = s.read(in, &out)
+
+	case opReaddir:
+		var in readIn
+		decode(body, &in)
+		errno = s.readdir(in, &out)
+
+	case opRelease, opReleasedir:
+		var in releaseIn
+		decode(body, &in)
+		if h, ok := s.handles[in.Fh]; ok {
+			h.file.Close()
+			delete(s.handles, in.Fh)
+		}
+
+	case opFlush:
+
+	case opStatfs:
+		encode(&out, statfsOut{Bsize: 4096, Frsize: 4096, NameLen: 255})
+
+	default:
+		errno = syscall.ENOSYS
+	}
+	return s.reply(hdr, errno, out.Bytes())
+}
+
+// reply sends the answer to a request in one write, as the device requires
+func (s *server) reply(hdr inHeader, errno syscall.Errno, body []byte) error {
+	if errno != 0 {
+		body = nil
+	}
+	var msg bytes.Buffer
+	encode(&msg, outHeader{Len: uint32(outHeaderLen + len(body)), Error: -int32(errno), Unique: hdr.Unique})
+	msg.Write(body)
+	if _, err := s.dev.Write(msg.Bytes()); err != nil && !errors.Is(err, syscall.ENOENT) {
+		// ENOENT means the request was interrupted and needs no answer
+		return fmt.Errorf("failed to write FUSE reply: %w", err)
+	}
+	return nil
+}
+
+// lookup finds name in the directory parent
+func (s *server) lookup(parent uint64, name string, out *bytes.Buffer) syscall.Errno {
+	dir, ok := s.nodes[parent]
+	if !ok {
+		return syscall.ENOENT
+	}
+	child := path.Join(dir, name)
+	info, err := fs.Stat(s.fsys, child)
+	if err != nil {
+		return toErrno(err)
+	}
+	id := s.nodeID(child)
+	sec, nsec := validity()
+	encode(out, entryOut{
+		NodeID:         id,
+		EntryValid:     sec,
+		AttrValid:      sec,
+		EntryValidNsec: nsec,
+		AttrValidNsec:  nsec,
+		Attr:           s.attr(id, info),
+	})
+	return 0
+}
+
+// nodeID returns the node of name, numbering names the first time they
+// are seen
+func (s *server) nodeID(name string) uint64 {
+	if id, ok := s.nodeIDs[name]; ok {
+		return id
+	}
+	id := s.next
+	s.next++
+	s.nodes[id] = name
+	s.nodeIDs[name] = id
+	return id
+}
+
+// open opens a file for reading; the file system cannot be written
+func (s *server) open(node uint64, in openIn, out *bytes.Buffer) syscall.Errno {
+	name, ok := s.nodes[node]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if in.Flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return syscall.EROFS
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return toErrno(err)
+	}
+	if _, ok := f.(io.ReaderAt); !ok {
+		f.Close()
+		return syscall.EIO
+	}
+	var flags uint32
+	if g, ok := f.(interface{ growing() bool }); ok && g.growing() {
+		flags |= fopenDirectIO
+	}
+	encode(out, openOut{Fh: s.addHandle(&handle{file: f}), OpenFlags: flags})
+	return 0
+}
+
+// opendir reads the listing of a directory for the READDIRs that follow
+func (s *server) opendir(node uint64, out *bytes.Buffer) syscall.Errno {
+	name, ok := s.nodes[node]
+	if !ok {
+		return syscall.ENOENT
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return toErrno(err)
+	}
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		f.Close()
+		return toErrno(err)
+	}
+	h := &handle{file: f, entries: []dirEntry{
+		{name: ".", ino: node, typ: syscall.DT_DIR},
+		{name: "..", ino: rootID, typ: syscall.DT_DIR},
+	}}
+	for _, entry := range entries {
+		typ := uint32(syscall.DT_REG)
+		if entry.IsDir() {
+			typ = syscall.DT_DIR
+		}
+		h.entries = append(h.entries, dirEntry{
+			name: entry.Name(),
+			ino:  s.nodeID(path.Join(name, entry.Name())),
+			typ:  typ,
+		})
+	}
+	encode(out, openOut{Fh: s.addHandle(h)})
+	return 0
+}
+
+func (s *server) addHandle(h *handle) uint64 {
+	s.nextHandle++
+	s.handles[s.nextHandle] = h
+	return s.nextHandle
+}
+
+// read answers with the bytes at the offset. A short answer stops at the
+// first byte not downloaded yet; the read after it fails with ENODATA.
+func (s *server) read(in readIn, out *bytes.Buffer) syscall.Errno {
+	h, ok := s.handles[in.Fh]
+	if !ok {
+		return syscall.EBADF
+	}
+	size := in.Size
+	if size > maxRead {
+		size = maxRead
+	}
+	buf := make([]byte, size)
+	n, err := h.file.(io.ReaderAt).ReadAt(buf, int64(in.Offset))
+	if err != nil && err != io.EOF && n == 0 {
+		return toErrno(err)
+	}
+	out.Write(buf[:n])
+	return 0
+}
+
+// readdir answers with the entries from the offset on that fit in the
+// size asked for
+func (s *server) readdir(in readIn, out *bytes.Buffer) syscall.Errno {
+	h, ok := s.handles[in.Fh]
+	if !ok || h.entries == nil {
+		return syscall.EBADF
+	}
+	var buf []byte
+	for i := int(in.Offset); i < len(h.entries); i++ {
+		entry := h.entries[i]
+		if len(buf)+direntSize(entry.name) > int(in.Size) {
+			break
+		}
+		buf = appendDirent(buf, entry.ino, uint64(i+1), entry.typ, entry.name)
+	}
+	out.Write(buf)
+	return 0
+}
+
+// attr describes a file or directory to the kernel; nothing can be written
+func (s *server) attr(node uint64, info fs.FileInfo) attr {
+	a := attr{
+		Ino:       node,
+		Size:      uint64(info.Size()),
+		Blocks:    (uint64(info.Size()) + 511) / 512,
+		Mtime:     uint64(info.ModTime().Unix()),
+		MtimeNsec: uint32(info.ModTime().Nanosecond()),
+		Nlink:     1,
+		UID:       s.uid,
+		GID:       s.gid,
+		Blksize:   4096,
+		Mode:      syscall.S_IFREG | 0444,
+	}
+	a.Atime, a.AtimeNsec = a.Mtime, a.MtimeNsec
+	a.Ctime, a.CtimeNsec = a.Mtime, a.MtimeNsec
+	if info.IsDir() {
+		a.Mode = syscall.S_IFDIR | 0555
+		a.Nlink = 2
+		a.Size, a.Blocks = 0, 0
+	}
+	return a
+}
+
+// closeAll closes the handles the kernel did not release before unmounting
+func (s *server) closeAll() {
+	for fh, h := range s.handles {
+		h.file.Close()
+		delete(s.handles, fh)
+	}
+}
+
+// validity splits attrValid into the seconds and nanoseconds of a reply
+func validity() (uint64, uint32) {
+	return uint64(attrValid / time.Second), uint32(attrValid % time.Second)
+}
+
+// toErrno maps an error of the file system to the errno of a reply
+func toErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	switch {
+	case errors.Is(err, downloader.ErrNotDownloaded):
+		return syscall.ENODATA
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return syscall.EINVAL
+	case errors.As(err, &errno):
+		return errno
+	}
+	return syscall.EIO
+}
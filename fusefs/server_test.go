@@ -0,0 +1,160 @@
+//go:build linux
+// +build linux
+
+package fusefs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// fakeDevice stands in for /dev/fuse: Read hands out queued requests and
+// Write collects the replies
+type fakeDevice struct {
+	requests chan []byte
+	replies  chan []byte
+}
+
+func (d *fakeDevice) Read(p []byte) (int, error) {
+	req, ok := <-d.requests
+	if !ok {
+		return 0, syscall.ENODEV
+	}
+	return copy(p, req), nil
+}
+
+func (d *fakeDevice) Write(p []byte) (int, error) {
+	d.replies <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// testMount runs Serve on a fake device and sends requests to it
+type testMount struct {
+	t      *testing.T
+	dev    *fakeDevice
+	unique uint64
+	done   chan error
+}
+
+func newTestMount(t *testing.T, fsys *Downloads) *testMount {
+	m := &testMount{
+		t:    t,
+		dev:  &fakeDevice{requests: make(chan []byte), replies: make(chan []byte, 1)},
+		done: make(chan error, 1),
+	}
+	go func() { m.done <- Serve(m.dev, fsys) }()
+	return m
+}
+
+// call sends a request and returns the errno and body of its reply
+func (m *testMount) call(opcode uint32, node uint64, body interface{}) (syscall.Errno, []byte) {
+	m.t.Helper()
+	var payload bytes.Buffer
+	switch v := body.(type) {
+	case nil:
+	case string:
+		payload.WriteString(v)
+		payload.WriteByte(0)
+	default:
+		encode(&payload, v)
+	}
+	m.unique++
+	var msg bytes.Buffer
+	encode(&msg, inHeader{Len: uint32(inHeaderLen + payload.Len()), Opcode: opcode, Unique: m.unique, NodeID: node})
+	msg.Write(payload.Bytes())
+	m.dev.requests <- msg.Bytes()
+
+	reply := <-m.dev.replies
+	var hdr outHeader
+	decode(reply, &hdr)
+	if hdr.Unique != m.unique || int(hdr.Len) != len(reply) {
+		m.t.Fatalf("reply %+v of %d bytes to request %d", hdr, len(reply), m.unique)
+	}
+	return syscall.Errno(-hdr.Error), reply[outHeaderLen:]
+}
+
+func TestServe(t *testing.T) {
+	fsys, _, data := newPartial(t)
+	m := newTestMount(t, fsys)
+
+	if errno, body := m.call(opInit, 0, initIn{Major: kernelVersion, Minor: 36, MaxReadahead: 1 << 17}); errno != 0 {
+		t.Fatalf("INIT: %v", errno)
+	} else {
+		var out initOut
+		decode(body, &out)
+		if out.Major != kernelVersion || out.Minor != minorVersion {
+			t.Errorf("INIT answered protocol %d.%d", out.Major, out.Minor)
+		}
+	}
+
+	errno, body := m.call(opLookup, rootID, "partial.bin")
+	if errno != 0 {
+		t.Fatalf("LOOKUP: %v", errno)
+	}
+	var entry entryOut
+	decode(body, &entry)
+	if entry.Attr.Size != 2000 || entry.Attr.Mode != syscall.S_IFREG|0444 {
+		t.Errorf("LOOKUP attributes: size %d, mode %o", entry.Attr.Size, entry.Attr.Mode)
+	}
+	if errno, _ := m.call(opLookup, rootID, ".state"); errno != syscall.ENOENT {
+		t.Errorf("LOOKUP of the hidden state directory: %v, want ENOENT", errno)
+	}
+	if errno, _ := m.call(opGetattr, entry.NodeID, nil); errno != 0 {
+		t.Errorf("GETATTR: %v", errno)
+	}
+
+	if errno, _ := m.call(opOpen, entry.NodeID, openIn{Flags: syscall.O_RDWR}); errno != syscall.EROFS {
+		t.Errorf("OPEN for writing: %v, want EROFS", errno)
+	}
+	errno, body = m.call(opOpen, entry.NodeID, openIn{Flags: syscall.O_RDONLY})
+	if errno != 0 {
+		t.Fatalf("OPEN: %v", errno)
+	}
+	var opened openOut
+	decode(body, &opened)
+	if opened.OpenFlags&fopenDirectIO == 0 {
+		t.Error("a file still downloading was opened without direct I/O")
+	}
+
+	// A read stops short at the missing bytes, and the read after it fails
+	if errno, body := m.call(opRead, 0, readIn{Fh: opened.Fh, Size: 4096}); errno != 0 || !bytes.Equal(body, data[:600]) {
+		t.Errorf("READ at 0: %v, %d bytes", errno, len(body))
+	}
+	if errno, _ := m.call(opRead, 0, readIn{Fh: opened.Fh, Offset: 600, Size: 4096}); errno != syscall.ENODATA {
+		t.Errorf("READ at 600: %v, want ENODATA", errno)
+	}
+	if errno, body := m.call(opRead, 0, readIn{Fh: opened.Fh, Offset: 1000, Size: 4096}); errno != 0 || !bytes.Equal(body, data[1000:]) {
+		t.Errorf("READ at 1000: %v, %d bytes", errno, len(body))
+	}
+	if errno, _ := m.call(opRelease, 0, releaseIn{Fh: opened.Fh}); errno != 0 {
+		t.Errorf("RELEASE: %v", errno)
+	}
+
+	errno, body = m.call(opOpendir, rootID, openIn{})
+	if errno != 0 {
+		t.Fatalf("OPENDIR: %v", errno)
+	}
+	decode(body, &opened)
+	_, body = m.call(opReaddir, rootID, readIn{Fh: opened.Fh, Size: 4096})
+	var names []string
+	for len(body) >= direntLen {
+		nameLen := int(binary.LittleEndian.Uint32(body[16:]))
+		names = append(names, string(body[direntLen:direntLen+nameLen]))
+		body = body[direntSize(string(body[direntLen:direntLen+nameLen])):]
+	}
+	if len(names) != 3 || names[0] != "." || names[1] != ".." || names[2] != "partial.bin" {
+		t.Errorf("READDIR listed %q", names)
+	}
+
+	if errno, _ := m.call(9999, rootID, nil); errno != syscall.ENOSYS {
+		t.Errorf("unknown request: %v, want ENOSYS", errno)
+	}
+	if errno, _ := m.call(opDestroy, 0, nil); errno != 0 {
+		t.Errorf("DESTROY: %v", errno)
+	}
+	if err := <-m.done; err != nil {
+		t.Errorf("Serve = %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package health
+
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, ErrUnsupported
+}
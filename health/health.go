@@ -0,0 +1,117 @@
+// Package health gathers what the servers report on GET /health: how long
+// each dependency takes to answer, the space left where downloads are
+// written, and the build, uptime and goroutines of the process.
+//
+// The build is described by variables set at link time:
+//
+//	go build -ldflags "-X multithreaded-downloader/health.Version=v1.4.0 \
+//	    -X multithreaded-downloader/health.Commit=$(git rev-parse --short HEAD) \
+//	    -X multithreaded-downloader/health.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ...
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+// Build information, set with -ldflags -X
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = ""
+)
+
+// Timeout bounds each dependency check
+const Timeout = 2 * time.Second
+
+// ErrUnsupported is returned where disk space cannot be read
+var ErrUnsupported = errors.New("disk space is not available on this platform")
+
+// started is when the process started, near enough
+var started = time.Now()
+
+// Check runs check with Timeout and reports how it went and how long it took
+func Check(ctx context.Context, check func(context.Context) error) openapi.DependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	start := time.Now()
+	err := check(ctx)
+	result := openapi.DependencyCheck{
+		Healthy:   err == nil,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Filesystem returns a check that creates, writes and removes a small file
+// in dir, as downloads do
+func Filesystem(dir string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		defer os.Remove(name)
+		if _, err := f.Write([]byte("ok")); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+}
+
+// Build describes the running binary
+func Build() openapi.BuildInfo {
+	return openapi.BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Uptime is how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(started)
+}
+
+// Disk returns the space of the file system holding dir
+func Disk(dir string) (*openapi.DiskSpace, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	free, total, err := diskSpace(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the disk space of %s: %w", abs, err)
+	}
+	return &openapi.DiskSpace{Path: abs, FreeBytes: int64(free), TotalBytes: int64(total)}, nil
+}
+
+// Fill adds the build, uptime and goroutines to resp, and the disk space of
+// dir when dir is set and its space can be read
+func Fill(resp *openapi.HealthResponse, dir string) {
+	build := Build()
+	resp.Build = &build
+	resp.UptimeSeconds = int64(Uptime() / time.Second)
+	resp.Goroutines = runtime.NumGoroutine()
+	if dir != "" {
+		if disk, err := Disk(dir); err == nil {
+			resp.Disk = disk
+		}
+	}
+}
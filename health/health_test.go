@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/openapi"
+)
+
+func TestCheck(t *testing.T) {
+	ok := Check(context.Background(), func(ctx context.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	if !ok.Healthy || ok.Error != "" || ok.LatencyMs < 5 {
+		t.Errorf("passing check = %+v", ok)
+	}
+
+	failed := Check(context.Background(), func(ctx context.Context) error { return errors.New("connection refused") })
+	if failed.Healthy || failed.Error != "connection refused" {
+		t.Errorf("failing check = %+v", failed)
+	}
+
+	// Checks run with a deadline, so one that hangs is stopped
+	var deadline time.Time
+	var hasDeadline bool
+	Check(context.Background(), func(ctx context.Context) error {
+		deadline, hasDeadline = ctx.Deadline()
+		return nil
+	})
+	if !hasDeadline || time.Until(deadline) > Timeout {
+		t.Error("check ran without the timeout")
+	}
+}
+
+func TestFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	if err := Filesystem(dir)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("check left %d files behind", len(entries))
+	}
+	if err := Filesystem(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Error("check of a missing directory passed")
+	}
+}
+
+func TestFill(t *testing.T) {
+	Version, Commit = "v9.9.9", "abc1234"
+	defer func() { Version, Commit = "dev", "unknown" }()
+
+	var resp openapi.HealthResponse
+	dir := t.TempDir()
+	Fill(&resp, dir)
+	if resp.Build == nil || resp.Build.Version != "v9.9.9" || resp.Build.Commit != "abc1234" || resp.Build.GoVersion != runtime.Version() {
+		t.Errorf("build = %+v", resp.Build)
+	}
+	if resp.Goroutines < 1 || resp.UptimeSeconds < 0 {
+		t.Errorf("goroutines %d, uptime %d", resp.Goroutines, resp.UptimeSeconds)
+	}
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		if resp.Disk == nil || resp.Disk.Path != dir || resp.Disk.TotalBytes <= 0 || resp.Disk.FreeBytes > resp.Disk.TotalBytes {
+			t.Errorf("disk = %+v", resp.Disk)
+		}
+	}
+
+	var without openapi.HealthResponse
+	Fill(&without, "")
+	if without.Disk != nil {
+		t.Errorf("disk reported without a directory: %+v", without.Disk)
+	}
+}
@@ -0,0 +1,216 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Codecs of the blocks a file is made of
+const (
+	// CodecRaw blocks are file data as it is
+	CodecRaw = 0x55
+	// CodecDagPB blocks are UnixFS nodes
+	CodecDagPB = 0x70
+)
+
+// SHA256 is the multihash code of SHA-256, the only hash checked here
+const SHA256 = 0x12
+
+// ErrInvalidCID is returned for a string or bytes that are not a CID
+var ErrInvalidCID = errors.New("invalid CID")
+
+// CID is a content identifier: the hash of a block and how to decode it
+type CID struct {
+	Version int
+	Codec   uint64
+	// Hash is the multihash code of Digest
+	Hash   uint64
+	Digest []byte
+}
+
+var (
+	base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+	base32Upper = base32.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ234567").WithPadding(base32.NoPadding)
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// ParseCID reads a CIDv0 (Qm...) or a CIDv1 in base32 (b...), base58btc
+// (z...) or base16 (f...)
+func ParseCID(s string) (CID, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		mh, err := decodeBase58(s)
+		if err != nil {
+			return CID{}, err
+		}
+		c, err := fromMultihash(mh)
+		if err != nil {
+			return CID{}, err
+		}
+		c.Codec = CodecDagPB
+		return c, nil
+	}
+	if len(s) < 2 {
+		return CID{}, fmt.Errorf("%w: %q", ErrInvalidCID, s)
+	}
+
+	var data []byte
+	var err error
+	switch s[0] {
+	case 'b':
+		data, err = base32Lower.DecodeString(s[1:])
+	case 'B':
+		data, err = base32Upper.DecodeString(s[1:])
+	case 'z':
+		data, err = decodeBase58(s[1:])
+	case 'f', 'F':
+		data, err = hex.DecodeString(s[1:])
+	default:
+		return CID{}, fmt.Errorf("%w: unsupported multibase %q", ErrInvalidCID, s[:1])
+	}
+	if err != nil {
+		return CID{}, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	c, n, err := readCID(data)
+	if err != nil {
+		return CID{}, err
+	}
+	if n != len(data) {
+		return CID{}, fmt.Errorf("%w: trailing bytes", ErrInvalidCID)
+	}
+	return c, nil
+}
+
+// readCID reads the binary CID at the start of data, as dag-pb links hold
+// it, and returns how many bytes it took
+func readCID(data []byte) (CID, int, error) {
+	// A CIDv0 is a bare SHA-256 multihash
+	if len(data) >= 2 && data[0] == SHA256 && data[1] == sha256.Size {
+		if len(data) < 2+sha256.Size {
+			return CID{}, 0, fmt.Errorf("%w: short multihash", ErrInvalidCID)
+		}
+		c, err := fromMultihash(data[:2+sha256.Size])
+		c.Codec = CodecDagPB
+		return c, 2 + sha256.Size, err
+	}
+
+	version, n := binary.Uvarint(data)
+	if n <= 0 || version != 1 {
+		return CID{}, 0, fmt.Errorf("%w: unsupported version", ErrInvalidCID)
+	}
+	codec, m := binary.Uvarint(data[n:])
+	if m <= 0 {
+		return CID{}, 0, fmt.Errorf("%w: bad codec", ErrInvalidCID)
+	}
+	read := n + m
+	hash, k := binary.Uvarint(data[read:])
+	if k <= 0 {
+		return CID{}, 0, fmt.Errorf("%w: bad multihash", ErrInvalidCID)
+	}
+	size, l := binary.Uvarint(data[read+k:])
+	if l <= 0 || uint64(len(data)-read-k-l) < size {
+		return CID{}, 0, fmt.Errorf("%w: bad multihash", ErrInvalidCID)
+	}
+	start := read + k + l
+	digest := append([]byte(nil), data[start:start+int(size)]...)
+	return CID{Version: 1, Codec: codec, Hash: hash, Digest: digest}, start + int(size), nil
+}
+
+// fromMultihash makes a CIDv0 of a SHA-256 multihash
+func fromMultihash(mh []byte) (CID, error) {
+	if len(mh) != 2+sha256.Size || mh[0] != SHA256 || mh[1] != sha256.Size {
+		return CID{}, fmt.Errorf("%w: a CIDv0 must be a SHA-256 multihash", ErrInvalidCID)
+	}
+	return CID{Version: 0, Codec: CodecDagPB, Hash: SHA256, Digest: append([]byte(nil), mh[2:]...)}, nil
+}
+
+// multihash returns the hash code, length and digest of c
+func (c CID) multihash() []byte {
+	mh := appendUvarint(nil, c.Hash)
+	mh = appendUvarint(mh, uint64(len(c.Digest)))
+	return append(mh, c.Digest...)
+}
+
+// Bytes returns the binary form of c
+func (c CID) Bytes() []byte {
+	if c.Version == 0 {
+		return c.multihash()
+	}
+	b := appendUvarint([]byte{1}, c.Codec)
+	return append(b, c.multihash()...)
+}
+
+// String returns c as Qm... for a CIDv0 and in base32 as b... for a CIDv1
+func (c CID) String() string {
+	if c.Version == 0 {
+		return encodeBase58(c.multihash())
+	}
+	return "b" + base32Lower.EncodeToString(c.Bytes())
+}
+
+// Matches reports whether block is the block c names. Only SHA-256 CIDs
+// can be checked.
+func (c CID) Matches(block []byte) bool {
+	if c.Hash != SHA256 {
+		return false
+	}
+	sum := sha256.Sum256(block)
+	return bytes.Equal(sum[:], c.Digest)
+}
+
+// NewCID returns the CIDv1 of a block with the given codec
+func NewCID(codec uint64, block []byte) CID {
+	sum := sha256.Sum256(block)
+	return CID{Version: 1, Codec: codec, Hash: SHA256, Digest: sum[:]}
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		i := strings.IndexRune(base58Alphabet, r)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %q is not base58", ErrInvalidCID, r)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func encodeBase58(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// appendUvarint appends x to b as a varint
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
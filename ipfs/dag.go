@@ -0,0 +1,197 @@
+package ipfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// UnixFS node types
+const (
+	TypeRaw       = 0
+	TypeDirectory = 1
+	TypeFile      = 2
+	TypeMetadata  = 3
+	TypeSymlink   = 4
+	TypeHAMTShard = 5
+)
+
+// ErrInvalidNode is returned for a dag-pb block that cannot be decoded
+var ErrInvalidNode = errors.New("invalid dag-pb node")
+
+// Link is a dag-pb link to a child block
+type Link struct {
+	CID  CID
+	Name string
+	// Tsize is the size of the child block and every block under it
+	Tsize uint64
+}
+
+// Node is a decoded dag-pb block with its UnixFS data
+type Node struct {
+	Links []Link
+	Type  int
+	// Data is the file data the node holds itself
+	Data     []byte
+	FileSize uint64
+	// BlockSizes are the file bytes under each link
+	BlockSizes []uint64
+}
+
+// field is one protobuf field: a varint, or bytes for length-delimited ones
+type field struct {
+	num    int
+	varint uint64
+	bytes  []byte
+	wire   int
+}
+
+// fields splits a protobuf message into its fields
+func fields(msg []byte) ([]field, error) {
+	var out []field
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, ErrInvalidNode
+		}
+		msg = msg[n:]
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.varint, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, ErrInvalidNode
+			}
+			msg = msg[n:]
+		case 1, 5:
+			size := 8
+			if f.wire == 5 {
+				size = 4
+			}
+			if len(msg) < size {
+				return nil, ErrInvalidNode
+			}
+			msg = msg[size:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return nil, ErrInvalidNode
+			}
+			f.bytes = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		default:
+			return nil, fmt.Errorf("%w: wire type %d", ErrInvalidNode, f.wire)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// DecodeNode decodes a dag-pb block and the UnixFS data in it
+func DecodeNode(block []byte) (*Node, error) {
+	pb, err := fields(block)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{}
+	var unixfs []byte
+	for _, f := range pb {
+		switch {
+		case f.num == 1 && f.wire == 2:
+			unixfs = f.bytes
+		case f.num == 2 && f.wire == 2:
+			link, err := decodeLink(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			node.Links = append(node.Links, link)
+		}
+	}
+
+	data, err := fields(unixfs)
+	if err != nil {
+		return nil, err
+	}
+	node.Type = -1
+	for _, f := range data {
+		switch {
+		case f.num == 1 && f.wire == 0:
+			node.Type = int(f.varint)
+		case f.num == 2 && f.wire == 2:
+			node.Data = f.bytes
+		case f.num == 3 && f.wire == 0:
+			node.FileSize = f.varint
+		case f.num == 4 && f.wire == 0:
+			node.BlockSizes = append(node.BlockSizes, f.varint)
+		case f.num == 4 && f.wire == 2:
+			// Packed block sizes
+			for packed := f.bytes; len(packed) > 0; {
+				size, n := binary.Uvarint(packed)
+				if n <= 0 {
+					return nil, ErrInvalidNode
+				}
+				node.BlockSizes = append(node.BlockSizes, size)
+				packed = packed[n:]
+			}
+		}
+	}
+	if node.Type < 0 {
+		return nil, fmt.Errorf("%w: no UnixFS type", ErrInvalidNode)
+	}
+	return node, nil
+}
+
+func decodeLink(msg []byte) (Link, error) {
+	fs, err := fields(msg)
+	if err != nil {
+		return Link{}, err
+	}
+	var link Link
+	hasHash := false
+	for _, f := range fs {
+		switch {
+		case f.num == 1 && f.wire == 2:
+			c, n, err := readCID(f.bytes)
+			if err != nil {
+				return Link{}, err
+			}
+			if n != len(f.bytes) {
+				return Link{}, fmt.Errorf("%w: trailing bytes in link hash", ErrInvalidNode)
+			}
+			link.CID, hasHash = c, true
+		case f.num == 2 && f.wire == 2:
+			link.Name = string(f.bytes)
+		case f.num == 3 && f.wire == 0:
+			link.Tsize = f.varint
+		}
+	}
+	if !hasHash {
+		return Link{}, fmt.Errorf("%w: link without a hash", ErrInvalidNode)
+	}
+	return link, nil
+}
+
+// appendBytesField appends a length-delimited protobuf field
+func appendBytesField(b []byte, num int, value []byte) []byte {
+	b = appendUvarint(b, uint64(num<<3|2))
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendVarintField appends a varint protobuf field
+func appendVarintField(b []byte, num int, value uint64) []byte {
+	b = appendUvarint(b, uint64(num<<3))
+	return appendUvarint(b, value)
+}
+
+// EncodeLeaf returns the dag-pb block of a UnixFS leaf of the given type
+// holding data, as go-ipfs and kubo write leaves that are not raw blocks
+func EncodeLeaf(unixfsType int, data []byte) []byte {
+	var unixfs []byte
+	unixfs = appendVarintField(unixfs, 1, uint64(unixfsType))
+	if len(data) > 0 {
+		unixfs = appendBytesField(unixfs, 2, data)
+	}
+	unixfs = appendVarintField(unixfs, 3, uint64(len(data)))
+	return appendBytesField(nil, 1, unixfs)
+}
@@ -0,0 +1,369 @@
+// Package ipfs resolves ipfs:// references into files that can be
+// downloaded from HTTP gateways. The blocks describing a file are fetched
+// from every gateway at once, the first block whose hash matches its CID
+// is used, and the file's leaf blocks become the pieces its content is
+// checked against, so the CID is the checksum of the download. A local
+// IPFS node is used by giving its gateway, such as http://127.0.0.1:8080.
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxBlockSize is the largest block fetched; IPFS blocks are at most 2 MiB
+	MaxBlockSize = 2 << 20
+	// DefaultParallel is how many blocks are fetched at a time
+	DefaultParallel = 8
+	// leafOverhead is the most a dag-pb leaf block is larger than the data
+	// it holds; a child whose Tsize is further above its size has children
+	leafOverhead = 32
+)
+
+// DefaultGateways are the public gateways used when IPFS_GATEWAY is unset
+var DefaultGateways = []string{"https://ipfs.io", "https://dweb.link", "https://w3s.link"}
+
+// ErrUnsupported is returned for content this package cannot check or
+// walk, such as CIDs not hashed with SHA-256 or sharded directories
+var ErrUnsupported = errors.New("unsupported IPFS content")
+
+// Gateways returns the gateways in the comma-separated IPFS_GATEWAY, or
+// DefaultGateways
+func Gateways() []string {
+	var gateways []string
+	for _, gateway := range strings.Split(os.Getenv("IPFS_GATEWAY"), ",") {
+		if gateway = strings.TrimSpace(gateway); gateway != "" {
+			gateways = append(gateways, gateway)
+		}
+	}
+	if len(gateways) == 0 {
+		return DefaultGateways
+	}
+	return gateways
+}
+
+// Ref is an ipfs:// reference: a CID and the path below it
+type Ref struct {
+	CID  CID
+	Path []string
+}
+
+// IsRef reports whether raw is an ipfs:// reference
+func IsRef(raw string) bool {
+	return strings.HasPrefix(strings.ToLower(raw), "ipfs://")
+}
+
+// ParseRef reads ipfs://<cid>[/path]
+func ParseRef(raw string) (Ref, error) {
+	if !IsRef(raw) {
+		return Ref{}, fmt.Errorf("%q is not an ipfs:// reference", raw)
+	}
+	rest := raw[len("ipfs://"):]
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	c, err := ParseCID(segments[0])
+	if err != nil {
+		return Ref{}, err
+	}
+	ref := Ref{CID: c}
+	for _, segment := range segments[1:] {
+		if segment == "" {
+			continue
+		}
+		name, err := url.PathUnescape(segment)
+		if err != nil {
+			return Ref{}, fmt.Errorf("invalid path in %q: %w", raw, err)
+		}
+		ref.Path = append(ref.Path, name)
+	}
+	return ref, nil
+}
+
+// String returns the ipfs:// form of r
+func (r Ref) String() string {
+	s := "ipfs://" + r.CID.String()
+	for _, name := range r.Path {
+		s += "/" + url.PathEscape(name)
+	}
+	return s
+}
+
+// Leaf is a block holding a run of a file's bytes
+type Leaf struct {
+	CID  CID
+	Size int64
+	// digest, when set, is the SHA-256 of the bytes themselves, for leaves
+	// whose block was fetched while walking the file
+	digest []byte
+}
+
+// File is a file resolved from an ipfs:// reference
+type File struct {
+	// CID is the file's own CID, below any path of the reference
+	CID  CID
+	Name string
+	Size int64
+	// Leaves hold the file's bytes in order
+	Leaves []Leaf
+}
+
+// Ends returns where each leaf ends in the file
+func (f *File) Ends() []int64 {
+	ends := make([]int64, len(f.Leaves))
+	var end int64
+	for i, leaf := range f.Leaves {
+		end += leaf.Size
+		ends[i] = end
+	}
+	return ends
+}
+
+// Check reports whether piece is the content of leaf i
+func (f *File) Check(i int, piece []byte) bool {
+	leaf := f.Leaves[i]
+	switch {
+	case leaf.digest != nil:
+		sum := sha256.Sum256(piece)
+		return bytes.Equal(sum[:], leaf.digest)
+	case leaf.CID.Codec == CodecRaw:
+		return leaf.CID.Matches(piece)
+	}
+	// Leaves that are not raw blocks wrap the bytes in a UnixFS node, typed
+	// file by newer importers and raw by older ones
+	return leaf.CID.Matches(EncodeLeaf(TypeFile, piece)) || leaf.CID.Matches(EncodeLeaf(TypeRaw, piece))
+}
+
+// GatewayURL returns the URL gateway serves f at
+func GatewayURL(gateway string, f *File) string {
+	u := strings.TrimRight(gateway, "/") + "/ipfs/" + f.CID.String()
+	if f.Name != "" && f.Name != f.CID.String() {
+		u += "?filename=" + url.QueryEscape(f.Name)
+	}
+	return u
+}
+
+// GatewayOf returns the gateway of a URL made by GatewayURL
+func GatewayOf(rawURL string) (string, bool) {
+	i := strings.Index(rawURL, "/ipfs/")
+	if i < 0 {
+		return "", false
+	}
+	return rawURL[:i], true
+}
+
+// Resolver fetches blocks from gateways. It is safe for concurrent use.
+type Resolver struct {
+	Gateways []string
+	Client   *http.Client
+	sem      chan struct{}
+}
+
+// NewResolver creates a resolver fetching from gateways
+func NewResolver(gateways []string) *Resolver {
+	return &Resolver{
+		Gateways: gateways,
+		Client:   &http.Client{Timeout: 60 * time.Second},
+		sem:      make(chan struct{}, DefaultParallel),
+	}
+}
+
+// Resolve walks the path of ref and the file at its end, and returns the
+// file with its leaves
+func (r *Resolver) Resolve(ctx context.Context, ref Ref) (*File, error) {
+	c, name := ref.CID, ref.CID.String()
+	for _, segment := range ref.Path {
+		block, err := r.Block(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if c.Codec != CodecDagPB {
+			return nil, fmt.Errorf("%s is not a directory", name)
+		}
+		node, err := DecodeNode(block)
+		if err != nil {
+			return nil, err
+		}
+		switch node.Type {
+		case TypeHAMTShard:
+			return nil, fmt.Errorf("%w: %s is a sharded directory", ErrUnsupported, name)
+		case TypeDirectory:
+		default:
+			return nil, fmt.Errorf("%s is not a directory", name)
+		}
+		found := false
+		for _, link := range node.Links {
+			if link.Name == segment {
+				c, found = link.CID, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s has no entry %q", name, segment)
+		}
+		name = segment
+	}
+	return r.file(ctx, c, name)
+}
+
+// file resolves the file c
+func (r *Resolver) file(ctx context.Context, c CID, name string) (*File, error) {
+	block, err := r.Block(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{CID: c, Name: name}
+	switch c.Codec {
+	case CodecRaw:
+		f.Leaves = []Leaf{{CID: c, Size: int64(len(block))}}
+	case CodecDagPB:
+		node, err := DecodeNode(block)
+		if err != nil {
+			return nil, err
+		}
+		if node.Type != TypeFile && node.Type != TypeRaw {
+			return nil, fmt.Errorf("%s is not a file (UnixFS type %d)", name, node.Type)
+		}
+		if f.Leaves, err = r.leaves(ctx, node); err != nil {
+			return nil, err
+		}
+		var size int64
+		for _, leaf := range f.Leaves {
+			size += leaf.Size
+		}
+		if node.FileSize != 0 && uint64(size) != node.FileSize {
+			return nil, fmt.Errorf("%w: %s has %d bytes in its blocks but a size of %d", ErrInvalidNode, name, size, node.FileSize)
+		}
+	default:
+		return nil, fmt.Errorf("%w: codec 0x%x", ErrUnsupported, c.Codec)
+	}
+	for _, leaf := range f.Leaves {
+		f.Size += leaf.Size
+	}
+	return f, nil
+}
+
+// leaves returns the leaves under a file node, fetching the nodes between
+// them a few at a time
+func (r *Resolver) leaves(ctx context.Context, node *Node) ([]Leaf, error) {
+	if len(node.Links) == 0 {
+		sum := sha256.Sum256(node.Data)
+		return []Leaf{{Size: int64(len(node.Data)), digest: sum[:]}}, nil
+	}
+	if len(node.Data) > 0 {
+		return nil, fmt.Errorf("%w: file node with data and links", ErrUnsupported)
+	}
+	if len(node.BlockSizes) != len(node.Links) {
+		return nil, fmt.Errorf("%w: %d block sizes for %d links", ErrInvalidNode, len(node.BlockSizes), len(node.Links))
+	}
+
+	results := make([][]Leaf, len(node.Links))
+	errs := make([]error, len(node.Links))
+	var wg sync.WaitGroup
+	for i, link := range node.Links {
+		size := node.BlockSizes[i]
+		if link.CID.Codec == CodecRaw || (link.CID.Codec == CodecDagPB && link.Tsize < size+leafOverhead) {
+			results[i] = []Leaf{{CID: link.CID, Size: int64(size)}}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c CID) {
+			defer wg.Done()
+			r.sem <- struct{}{}
+			block, err := r.Block(ctx, c)
+			<-r.sem
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			child, err := DecodeNode(block)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i], errs[i] = r.leaves(ctx, child)
+		}(i, link.CID)
+	}
+	wg.Wait()
+
+	var leaves []Leaf
+	for i := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		leaves = append(leaves, results[i]...)
+	}
+	return leaves, nil
+}
+
+// Block fetches the block c from every gateway at once and returns the
+// first one whose hash matches c
+func (r *Resolver) Block(ctx context.Context, c CID) ([]byte, error) {
+	if c.Hash != SHA256 {
+		return nil, fmt.Errorf("%w: %s is not hashed with SHA-256", ErrUnsupported, c)
+	}
+	if len(r.Gateways) == 0 {
+		return nil, errors.New("no IPFS gateways")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		block []byte
+		err   error
+	}
+	results := make(chan result, len(r.Gateways))
+	for _, gateway := range r.Gateways {
+		go func(gateway string) {
+			block, err := r.fetchBlock(ctx, gateway, c)
+			results <- result{block, err}
+		}(gateway)
+	}
+	var errs []string
+	for range r.Gateways {
+		res := <-results
+		if res.err == nil {
+			return res.block, nil
+		}
+		errs = append(errs, res.err.Error())
+	}
+	return nil, fmt.Errorf("no gateway served block %s: %s", c, strings.Join(errs, "; "))
+}
+
+// fetchBlock fetches the raw block c from one gateway
+func (r *Resolver) fetchBlock(ctx context.Context, gateway string, c CID) ([]byte, error) {
+	blockURL := strings.TrimRight(gateway, "/") + "/ipfs/" + c.String() + "?format=raw"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, blockURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipfs.raw")
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", gateway, resp.Status)
+	}
+	block, err := io.ReadAll(io.LimitReader(resp.Body, MaxBlockSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", gateway, err)
+	}
+	if len(block) > MaxBlockSize || !c.Matches(block) {
+		return nil, fmt.Errorf("%s sent a block that does not match its CID", gateway)
+	}
+	return block, nil
+}
@@ -0,0 +1,183 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// encodeNode returns the dag-pb block of a UnixFS node linking to children
+func encodeNode(unixfsType int, links []Link, blockSizes []uint64) []byte {
+	var block []byte
+	for _, link := range links {
+		var l []byte
+		l = appendBytesField(l, 1, link.CID.Bytes())
+		l = appendBytesField(l, 2, []byte(link.Name))
+		l = appendVarintField(l, 3, link.Tsize)
+		block = appendBytesField(block, 2, l)
+	}
+	unixfs := appendVarintField(nil, 1, uint64(unixfsType))
+	if unixfsType == TypeFile {
+		var size uint64
+		for _, s := range blockSizes {
+			size += s
+		}
+		unixfs = appendVarintField(unixfs, 3, size)
+		for _, s := range blockSizes {
+			unixfs = appendVarintField(unixfs, 4, s)
+		}
+	}
+	return appendBytesField(block, 1, unixfs)
+}
+
+// gateway serves the blocks it holds as a trustless gateway does
+type gateway struct {
+	mu      sync.Mutex
+	blocks  map[string][]byte
+	fetched map[string]int
+	// corrupt gateways send every block with a byte changed
+	corrupt bool
+}
+
+func newGateway() *gateway {
+	return &gateway{blocks: map[string][]byte{}, fetched: map[string]int{}}
+}
+
+func (g *gateway) add(codec uint64, block []byte) CID {
+	c := NewCID(codec, block)
+	g.blocks[c.String()] = block
+	return c
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c, err := ParseCID(strings.TrimPrefix(r.URL.Path, "/ipfs/"))
+	block, ok := g.blocks[c.String()]
+	if err != nil || !ok || r.URL.Query().Get("format") != "raw" {
+		http.NotFound(w, r)
+		return
+	}
+	g.fetched[c.String()]++
+	if g.corrupt {
+		block = append([]byte{block[0] ^ 1}, block[1:]...)
+	}
+	w.Write(block)
+}
+
+func TestParseCID(t *testing.T) {
+	for _, s := range []string{
+		"QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o",
+		"bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4",
+	} {
+		c, err := ParseCID(s)
+		if err != nil {
+			t.Fatalf("ParseCID(%s) = %v", s, err)
+		}
+		if c.String() != s {
+			t.Errorf("ParseCID(%s).String() = %s", s, c.String())
+		}
+	}
+	for _, s := range []string{"", "Qm", "x123", "bafy!!"} {
+		if _, err := ParseCID(s); err == nil {
+			t.Errorf("ParseCID(%q) succeeded", s)
+		}
+	}
+
+	ref, err := ParseRef("ipfs://QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o/docs/read%20me.txt")
+	if err != nil || len(ref.Path) != 2 || ref.Path[1] != "read me.txt" {
+		t.Errorf("ParseRef() = %+v, %v", ref, err)
+	}
+}
+
+func TestCheckKnownLeaves(t *testing.T) {
+	// echo "hello world" | ipfs add, as a dag-pb leaf and as a raw block
+	hello := []byte("hello world\n")
+	for _, s := range []string{
+		"QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o",
+		"bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4",
+	} {
+		c, _ := ParseCID(s)
+		f := &File{Leaves: []Leaf{{CID: c, Size: int64(len(hello))}}}
+		if !f.Check(0, hello) {
+			t.Errorf("%s does not check hello world", s)
+		}
+		if f.Check(0, []byte("hello World\n")) {
+			t.Errorf("%s checks the wrong bytes", s)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	good, bad := newGateway(), newGateway()
+	bad.corrupt = true
+
+	// A file of a raw leaf, a dag-pb leaf and an inner node with two raw leaves
+	chunks := [][]byte{[]byte("first chunk;"), []byte("second chunk;"), []byte("third;"), []byte("fourth")}
+	var leafCIDs []CID
+	for i, chunk := range chunks {
+		if i == 1 {
+			leafCIDs = append(leafCIDs, good.add(CodecDagPB, EncodeLeaf(TypeFile, chunk)))
+			continue
+		}
+		leafCIDs = append(leafCIDs, good.add(CodecRaw, chunk))
+	}
+	innerSizes := []uint64{uint64(len(chunks[2])), uint64(len(chunks[3]))}
+	inner := encodeNode(TypeFile, []Link{
+		{CID: leafCIDs[2], Tsize: innerSizes[0]},
+		{CID: leafCIDs[3], Tsize: innerSizes[1]},
+	}, innerSizes)
+	innerCID := good.add(CodecDagPB, inner)
+	rootSizes := []uint64{uint64(len(chunks[0])), uint64(len(chunks[1])), innerSizes[0] + innerSizes[1]}
+	root := good.add(CodecDagPB, encodeNode(TypeFile, []Link{
+		{CID: leafCIDs[0], Tsize: rootSizes[0]},
+		{CID: leafCIDs[1], Tsize: uint64(len(EncodeLeaf(TypeFile, chunks[1])))},
+		{CID: innerCID, Tsize: uint64(len(inner)) + rootSizes[2] + 200},
+	}, rootSizes))
+	dir := good.add(CodecDagPB, encodeNode(TypeDirectory, []Link{{CID: root, Name: "data.bin", Tsize: 1000}}, nil))
+	for key, block := range good.blocks {
+		bad.blocks[key] = block
+	}
+
+	goodServer, badServer := httptest.NewServer(good), httptest.NewServer(bad)
+	defer goodServer.Close()
+	defer badServer.Close()
+	ref, err := ParseRef("ipfs://" + dir.String() + "/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewResolver([]string{badServer.URL, goodServer.URL}).Resolve(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Join(chunks, nil)
+	if f.Size != int64(len(content)) || f.Name != "data.bin" || len(f.Leaves) != 4 || f.CID.String() != root.String() {
+		t.Fatalf("Resolve() = %+v", f)
+	}
+	var start int64
+	for i, end := range f.Ends() {
+		if !f.Check(i, content[start:end]) {
+			t.Errorf("leaf %d does not check its bytes", i)
+		}
+		start = end
+	}
+	if f.Check(0, []byte("First chunk;")) {
+		t.Error("a changed leaf passed its check")
+	}
+	// Leaves are not fetched, only the nodes above them
+	if good.fetched[leafCIDs[0].String()] != 0 || good.fetched[innerCID.String()] != 1 {
+		t.Errorf("fetched %v", good.fetched)
+	}
+	if u := GatewayURL("https://ipfs.io/", f); u != "https://ipfs.io/ipfs/"+root.String()+"?filename=data.bin" {
+		t.Errorf("GatewayURL() = %s", u)
+	}
+
+	if _, err := NewResolver([]string{badServer.URL}).Resolve(context.Background(), ref); err == nil {
+		t.Error("Resolve() accepted blocks that do not match their CIDs")
+	}
+}
@@ -0,0 +1,108 @@
+// Package leader elects one process among replicas to run periodic
+// maintenance, such as requeueing stale jobs or removing old downloads, so
+// the work happens once cluster-wide instead of once per replica. Leadership
+// is a lease in a shared Store that the leader keeps renewing; when it stops,
+// another replica takes over after the lease expires.
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store holds leases shared by every replica
+type Store interface {
+	// Acquire takes the lease key for id, or extends it when id already
+	// holds it, and reports whether id holds it afterwards
+	Acquire(ctx context.Context, key, id string, ttl time.Duration) (bool, error)
+	// Release gives up the lease key if id holds it
+	Release(ctx context.Context, key, id string) error
+}
+
+// Elector campaigns for one lease on behalf of one replica
+type Elector struct {
+	store Store
+	key   string
+	id    string
+	ttl   time.Duration
+
+	// OnError is told when the store could not be reached
+	OnError func(error)
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// NewElector creates an elector for the lease key held for ttl at a time
+func NewElector(store Store, key, id string, ttl time.Duration) *Elector {
+	return &Elector{store: store, key: key, id: id, ttl: ttl}
+}
+
+// ID returns the name this replica campaigns under
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// IsLeader reports whether this replica holds the lease. It turns false by
+// itself once the lease ran out without being renewed, so a replica cut off
+// from the store stops acting as leader before another one takes over.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.until)
+}
+
+// Campaign tries once to take or renew the lease and reports whether this
+// replica is the leader afterwards
+func (e *Elector) Campaign(ctx context.Context) bool {
+	start := time.Now()
+	held, err := e.store.Acquire(ctx, e.key, e.id, e.ttl)
+	if err != nil && e.OnError != nil {
+		e.OnError(err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if held {
+		// Count the lease from before the request so it ends here first
+		e.until = start.Add(e.ttl)
+	} else if err == nil {
+		e.until = time.Time{}
+	}
+	return held
+}
+
+// Run campaigns every third of the lease until ctx is cancelled, then
+// releases the lease so another replica can take over at once
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	e.Campaign(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return
+		case <-ticker.C:
+			e.Campaign(ctx)
+		}
+	}
+}
+
+func (e *Elector) resign() {
+	e.mu.Lock()
+	wasLeader := time.Now().Before(e.until)
+	e.until = time.Time{}
+	e.mu.Unlock()
+
+	if !wasLeader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.store.Release(ctx, e.key, e.id); err != nil && e.OnError != nil {
+		e.OnError(err)
+	}
+}
@@ -0,0 +1,119 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store shared by electors in one process
+type memoryStore struct {
+	mu      sync.Mutex
+	holder  map[string]string
+	expires map[string]time.Time
+	err     error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{holder: map[string]string{}, expires: map[string]time.Time{}}
+}
+
+func (m *memoryStore) Acquire(ctx context.Context, key, id string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return false, m.err
+	}
+	now := time.Now()
+	if holder := m.holder[key]; holder != "" && holder != id && now.Before(m.expires[key]) {
+		return false, nil
+	}
+	m.holder[key] = id
+	m.expires[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (m *memoryStore) Release(ctx context.Context, key, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holder[key] == id {
+		delete(m.holder, key)
+		delete(m.expires, key)
+	}
+	return nil
+}
+
+func TestOneLeaderAtATime(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	a := NewElector(store, "maintenance", "a", time.Minute)
+	b := NewElector(store, "maintenance", "b", time.Minute)
+
+	if !a.Campaign(ctx) || !a.IsLeader() {
+		t.Fatal("first replica did not become leader")
+	}
+	if b.Campaign(ctx) || b.IsLeader() {
+		t.Fatal("second replica became leader while the lease was held")
+	}
+	if !a.Campaign(ctx) {
+		t.Fatal("leader could not renew its lease")
+	}
+
+	other := NewElector(store, "scheduler", "b", time.Minute)
+	if !other.Campaign(ctx) {
+		t.Fatal("leases for different keys conflicted")
+	}
+}
+
+func TestLeadershipMovesWhenLeaderStops(t *testing.T) {
+	store := newMemoryStore()
+	a := NewElector(store, "maintenance", "a", 30*time.Millisecond)
+	b := NewElector(store, "maintenance", "b", 30*time.Millisecond)
+
+	ctx, stopA := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	for !a.IsLeader() {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopA()
+	<-done
+	if a.IsLeader() {
+		t.Fatal("stopped replica still reports leadership")
+	}
+	if !b.Campaign(context.Background()) {
+		t.Fatal("lease was not released when the leader stopped")
+	}
+}
+
+func TestLeadershipLapsesWithoutStore(t *testing.T) {
+	store := newMemoryStore()
+	a := NewElector(store, "maintenance", "a", 20*time.Millisecond)
+	var errs []error
+	a.OnError = func(err error) { errs = append(errs, err) }
+
+	if !a.Campaign(context.Background()) {
+		t.Fatal("replica did not become leader")
+	}
+	store.mu.Lock()
+	store.err = errors.New("connection refused")
+	store.mu.Unlock()
+
+	a.Campaign(context.Background())
+	if len(errs) != 1 {
+		t.Fatalf("OnError called %d times, want 1", len(errs))
+	}
+	if !a.IsLeader() {
+		t.Fatal("leader gave up before its lease expired")
+	}
+	time.Sleep(25 * time.Millisecond)
+	if a.IsLeader() {
+		t.Fatal("leader cut off from the store kept leading after its lease expired")
+	}
+}
@@ -0,0 +1,242 @@
+package lfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultHubEndpoint is the Hugging Face Hub used when HF_ENDPOINT is unset
+const DefaultHubEndpoint = "https://huggingface.co"
+
+// Repo is a Hugging Face repository named by an hf:// URL:
+// hf://[datasets/|spaces/]org/name[@revision][/path]
+type Repo struct {
+	// Kind is models, datasets or spaces
+	Kind     string
+	ID       string
+	Revision string
+	// Path, when set, limits the repo to a file or directory in it
+	Path string
+}
+
+// IsRepo reports whether raw is an hf:// URL
+func IsRepo(raw string) bool {
+	return strings.HasPrefix(strings.ToLower(raw), "hf://")
+}
+
+// ParseRepo reads an hf:// URL. The revision defaults to main; one with a
+// slash, such as refs/pr/1, is written escaped as refs%2Fpr%2F1.
+func ParseRepo(raw string) (Repo, error) {
+	if !IsRepo(raw) {
+		return Repo{}, fmt.Errorf("%q is not an hf:// URL", raw)
+	}
+	segments := strings.Split(strings.Trim(raw[len("hf://"):], "/"), "/")
+	repo := Repo{Kind: "models", Revision: "main"}
+	switch segments[0] {
+	case "datasets", "spaces":
+		repo.Kind, segments = segments[0], segments[1:]
+	case "models":
+		segments = segments[1:]
+	}
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return Repo{}, fmt.Errorf("%q does not name an org/name repository", raw)
+	}
+	name := segments[1]
+	if at := strings.IndexByte(name, '@'); at >= 0 {
+		revision, err := url.PathUnescape(name[at+1:])
+		if err != nil || revision == "" {
+			return Repo{}, fmt.Errorf("invalid revision in %q", raw)
+		}
+		name, repo.Revision = name[:at], revision
+	}
+	repo.ID = segments[0] + "/" + name
+	if len(segments) > 2 {
+		p, err := url.PathUnescape(strings.Join(segments[2:], "/"))
+		if err != nil {
+			return Repo{}, fmt.Errorf("invalid path in %q: %w", raw, err)
+		}
+		repo.Path = p
+	}
+	return repo, nil
+}
+
+// Name is the repository's name without its org, the directory a clone
+// would be made in
+func (r Repo) Name() string {
+	return r.ID[strings.IndexByte(r.ID, '/')+1:]
+}
+
+// Hub lists Hugging Face repositories
+type Hub struct {
+	Endpoint string
+	// Token, when set, is sent as a bearer token for gated and private repos
+	Token  string
+	Client *http.Client
+}
+
+// NewHub returns a Hub for HF_ENDPOINT, or the public Hub, with the
+// token in HF_TOKEN
+func NewHub() *Hub {
+	endpoint := os.Getenv("HF_ENDPOINT")
+	if endpoint == "" {
+		endpoint = DefaultHubEndpoint
+	}
+	return &Hub{
+		Endpoint: strings.TrimRight(endpoint, "/"),
+		Token:    os.Getenv("HF_TOKEN"),
+		Client:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// treeEntry is a file or directory of the tree API
+type treeEntry struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	LFS  *struct {
+		OID  string `json:"oid"`
+		Size int64  `json:"size"`
+	} `json:"lfs"`
+}
+
+// Objects lists the files of repo with the URL each is downloaded from.
+// Files stored in LFS carry their SHA-256; others only their size.
+func (h *Hub) Objects(ctx context.Context, repo Repo) ([]Object, error) {
+	var entries []treeEntry
+	if repo.Path == "" {
+		var err error
+		if entries, err = h.tree(ctx, repo, "", true); err != nil {
+			return nil, err
+		}
+	} else {
+		// The path is a file or a directory, which its parent tells
+		parent := ""
+		if i := strings.LastIndexByte(repo.Path, '/'); i >= 0 {
+			parent = repo.Path[:i]
+		}
+		siblings, err := h.tree(ctx, repo, parent, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range siblings {
+			if entry.Path != repo.Path {
+				continue
+			}
+			if entry.Type == "directory" {
+				if entries, err = h.tree(ctx, repo, repo.Path, true); err != nil {
+					return nil, err
+				}
+			} else {
+				entries = []treeEntry{entry}
+			}
+		}
+		if entries == nil {
+			return nil, fmt.Errorf("%s has no %s at %s", repo.ID, repo.Path, repo.Revision)
+		}
+	}
+
+	var objects []Object
+	for _, entry := range entries {
+		if entry.Type != "file" {
+			continue
+		}
+		p, err := CleanPath(entry.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s lists an %w", repo.ID, err)
+		}
+		obj := Object{Path: p, URL: h.resolveURL(repo, p), Size: entry.Size}
+		if entry.LFS != nil {
+			if !validSHA256(entry.LFS.OID) {
+				return nil, fmt.Errorf("%s lists %s with an invalid LFS oid %q", repo.ID, p, entry.LFS.OID)
+			}
+			obj.SHA256, obj.Size = entry.LFS.OID, entry.LFS.Size
+		}
+		if h.Token != "" {
+			obj.Header = map[string]string{"Authorization": "Bearer " + h.Token}
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// resolveURL returns the URL the Hub serves a file of repo at
+func (h *Hub) resolveURL(repo Repo, p string) string {
+	prefix := ""
+	if repo.Kind != "models" {
+		prefix = repo.Kind + "/"
+	}
+	return h.Endpoint + "/" + prefix + repo.ID + "/resolve/" + url.PathEscape(repo.Revision) + "/" + escapePath(p)
+}
+
+// tree lists the directory dir of repo, following the pages of the answer
+func (h *Hub) tree(ctx context.Context, repo Repo, dir string, recursive bool) ([]treeEntry, error) {
+	next := h.Endpoint + "/api/" + repo.Kind + "/" + repo.ID + "/tree/" + url.PathEscape(repo.Revision)
+	if dir != "" {
+		next += "/" + escapePath(dir)
+	}
+	if recursive {
+		next += "?recursive=true"
+	}
+
+	var entries []treeEntry
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		if h.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+h.Token)
+		}
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", repo.ID, err)
+		}
+		var page []treeEntry
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		} else {
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			err = fmt.Errorf("answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", repo.ID, err)
+		}
+		entries = append(entries, page...)
+		next = nextPage(resp.Header.Get("Link"), req.URL)
+	}
+	return entries, nil
+}
+
+var nextLink = regexp.MustCompile(`<([^>]*)>\s*;[^,]*rel="?next"?`)
+
+// nextPage returns the rel="next" URL of a Link header, resolved against
+// the page that sent it
+func nextPage(link string, base *url.URL) string {
+	m := nextLink.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	u, err := base.Parse(m[1])
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// escapePath escapes each segment of a slash-separated path
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,304 @@
+// Package lfs expands Git LFS pointer files and Hugging Face repositories
+// into the large objects they stand for: a URL to download each one from,
+// with the size and SHA-256 the file must have. Pointers are exchanged for
+// URLs through the LFS batch API of the repository; Hugging Face repos are
+// listed through the Hub's tree API, whose LFS files carry the same oid.
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PointerVersion is the first line of every pointer file
+	PointerVersion = "https://git-lfs.github.com/spec/v1"
+	// MaxPointerSize is the largest file read as a pointer; the spec
+	// keeps pointers under 1024 bytes
+	MaxPointerSize = 1024
+	// BatchSize is how many objects one batch request asks for
+	BatchSize = 100
+	// MediaType is the content type of the batch API
+	MediaType = "application/vnd.git-lfs+json"
+)
+
+// ErrNotPointer is returned for a file that is not a Git LFS pointer
+var ErrNotPointer = errors.New("not a Git LFS pointer")
+
+// Object is a large file of a repository
+type Object struct {
+	// Path is where the file is in the repository, slash-separated
+	Path string `json:"path"`
+	// URL is where the file is downloaded from, empty until resolved
+	URL  string `json:"url,omitempty"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex SHA-256 of the file, empty when it is not known
+	SHA256 string `json:"sha256,omitempty"`
+	// Header holds headers the download must send, such as the
+	// authorization the batch API handed out with URL
+	Header map[string]string `json:"header,omitempty"`
+}
+
+// ParsePointer reads a pointer file: the version line, then
+// "oid sha256:<hex>" and "size <bytes>" among other sorted keys
+func ParsePointer(data []byte) (Object, error) {
+	if len(data) > MaxPointerSize {
+		return Object{}, ErrNotPointer
+	}
+	var obj Object
+	hasSize := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 0; scanner.Scan(); line++ {
+		text := scanner.Text()
+		sp := strings.IndexByte(text, ' ')
+		if sp < 0 {
+			return Object{}, ErrNotPointer
+		}
+		key, value := text[:sp], text[sp+1:]
+		if line == 0 {
+			if key != "version" || value != PointerVersion {
+				return Object{}, ErrNotPointer
+			}
+			continue
+		}
+		switch key {
+		case "oid":
+			if !strings.HasPrefix(value, "sha256:") || !validSHA256(value[len("sha256:"):]) {
+				return Object{}, fmt.Errorf("%w: oid %q", ErrNotPointer, value)
+			}
+			obj.SHA256 = value[len("sha256:"):]
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return Object{}, fmt.Errorf("%w: size %q", ErrNotPointer, value)
+			}
+			obj.Size, hasSize = size, true
+		}
+	}
+	if obj.SHA256 == "" || !hasSize {
+		return Object{}, ErrNotPointer
+	}
+	return obj, nil
+}
+
+// NewObject returns the object of a pointer at p whose oid and size were
+// read elsewhere, checking them as ParsePointer does
+func NewObject(p, oid string, size int64) (Object, error) {
+	clean, err := CleanPath(p)
+	if err != nil {
+		return Object{}, err
+	}
+	if !validSHA256(oid) || size < 0 {
+		return Object{}, fmt.Errorf("%w: %s has oid %q and size %d", ErrNotPointer, p, oid, size)
+	}
+	return Object{Path: clean, Size: size, SHA256: oid}, nil
+}
+
+// validSHA256 reports whether s is a lowercase hex SHA-256
+func validSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32 && strings.ToLower(s) == s
+}
+
+// ReadPointers returns the objects of the pointer files at root, a pointer
+// file or a directory searched for them. Paths are relative to root, or
+// the file's name when root is one. Other files are skipped, and so is .git.
+func ReadPointers(root string) ([]Object, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(root)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := ParsePointer(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", root, err)
+		}
+		obj.Path = filepath.Base(root)
+		return []Object{obj}, nil
+	}
+
+	var objects []Object
+	err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > MaxPointerSize {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		obj, err := ParsePointer(data)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		obj.Path = filepath.ToSlash(rel)
+		objects = append(objects, obj)
+		return nil
+	})
+	return objects, err
+}
+
+// CleanPath checks that p, a path from a listing, stays inside the
+// directory it is saved under and returns it cleaned
+func CleanPath(p string) (string, error) {
+	clean := path.Clean("/" + p)[1:]
+	if clean == "" || clean != strings.TrimPrefix(p, "./") || strings.Contains(p, "\\") {
+		return "", fmt.Errorf("unsafe path %q", p)
+	}
+	return clean, nil
+}
+
+// Endpoint returns the LFS server of a Git repository URL, which is the
+// repository's .git/info/lfs unless the URL already names an LFS server
+func Endpoint(repoURL string) string {
+	repoURL = strings.TrimRight(repoURL, "/")
+	if strings.HasSuffix(repoURL, "/info/lfs") {
+		return repoURL
+	}
+	return strings.TrimSuffix(repoURL, ".git") + ".git/info/lfs"
+}
+
+// batchObject is an object of a batch request or response
+type batchObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions *struct {
+		Download *struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		} `json:"download"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Batch asks the LFS server of repoURL where to download the objects
+// from, BatchSize at a time, and returns them with their URL and headers.
+// header is sent with every request, for the repository's credentials.
+// Objects the server cannot serve make it fail naming each of them.
+func Batch(ctx context.Context, client *http.Client, repoURL string, header map[string]string, objects []Object) ([]Object, error) {
+	endpoint := Endpoint(repoURL) + "/objects/batch"
+	resolved := make([]Object, len(objects))
+	copy(resolved, objects)
+
+	var failed []string
+	for start := 0; start < len(resolved); start += BatchSize {
+		end := start + BatchSize
+		if end > len(resolved) {
+			end = len(resolved)
+		}
+		actions, err := batch(ctx, client, endpoint, header, resolved[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i := start; i < end; i++ {
+			obj := &resolved[i]
+			action, ok := actions[obj.SHA256]
+			switch {
+			case !ok:
+				failed = append(failed, fmt.Sprintf("%s: not in the batch response", obj.Path))
+			case action.Error != nil:
+				failed = append(failed, fmt.Sprintf("%s: %d %s", obj.Path, action.Error.Code, action.Error.Message))
+			case action.Actions == nil || action.Actions.Download == nil:
+				failed = append(failed, fmt.Sprintf("%s: no download action", obj.Path))
+			default:
+				obj.URL = action.Actions.Download.Href
+				obj.Header = action.Actions.Download.Header
+			}
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return nil, fmt.Errorf("LFS server cannot serve %d object(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+	return resolved, nil
+}
+
+// batch sends one batch request and returns the response's objects by oid
+func batch(ctx context.Context, client *http.Client, endpoint string, header map[string]string, objects []Object) (map[string]batchObject, error) {
+	request := struct {
+		Operation string        `json:"operation"`
+		Transfers []string      `json:"transfers"`
+		Objects   []batchObject `json:"objects"`
+	}{Operation: "download", Transfers: []string{"basic"}}
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		// Files with the same content are one object
+		if !seen[obj.SHA256] {
+			seen[obj.SHA256] = true
+			request.Objects = append(request.Objects, batchObject{OID: obj.SHA256, Size: obj.Size})
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Accept", MediaType)
+	req.Header.Set("Content-Type", MediaType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("LFS batch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("LFS server answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var response struct {
+		Transfer string        `json:"transfer"`
+		Objects  []batchObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid LFS batch response: %w", err)
+	}
+	if response.Transfer != "" && response.Transfer != "basic" {
+		return nil, fmt.Errorf("LFS server chose the unsupported %q transfer", response.Transfer)
+	}
+	actions := make(map[string]batchObject, len(response.Objects))
+	for _, obj := range response.Objects {
+		actions[obj.OID] = obj
+	}
+	return actions, nil
+}
@@ -0,0 +1,180 @@
+package lfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const oid = "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+
+const pointer = "version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12345\n"
+
+func TestReadPointers(t *testing.T) {
+	obj, err := ParsePointer([]byte(pointer))
+	if err != nil || obj.SHA256 != oid || obj.Size != 12345 {
+		t.Fatalf("ParsePointer() = %+v, %v", obj, err)
+	}
+	for _, bad := range []string{
+		"",
+		"hello world\n",
+		"version https://git-lfs.github.com/spec/v1\nsize 1\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha1:" + oid[:40] + "\nsize 1\n",
+		strings.Replace(pointer, "12345", "-1", 1),
+	} {
+		if _, err := ParsePointer([]byte(bad)); err == nil {
+			t.Errorf("ParsePointer(%q) succeeded", bad)
+		}
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"models/weights.bin": pointer,
+		"README.md":          "# not a pointer\n",
+		".git/lfs/objects":   pointer,
+		"data/big.csv":       strings.Replace(pointer, "12345", "7", 1),
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(content), 0644)
+	}
+	objects, err := ReadPointers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Path != "data/big.csv" || objects[0].Size != 7 || objects[1].Path != "models/weights.bin" {
+		t.Errorf("ReadPointers() = %+v", objects)
+	}
+	if objects, err := ReadPointers(filepath.Join(dir, "models", "weights.bin")); err != nil || len(objects) != 1 || objects[0].Path != "weights.bin" {
+		t.Errorf("ReadPointers(file) = %+v, %v", objects, err)
+	}
+
+	for _, p := range []string{"../etc/passwd", "/etc/passwd", "a/../../b", "a\\..\\b", ""} {
+		if _, err := CleanPath(p); err == nil {
+			t.Errorf("CleanPath(%q) succeeded", p)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	other := strings.Repeat("ab", 32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/org/repo.git/info/lfs/objects/batch" || r.Header.Get("Content-Type") != MediaType || r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Operation string        `json:"operation"`
+			Objects   []batchObject `json:"objects"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		// The two paths of one object are asked for once
+		if req.Operation != "download" || len(req.Objects) == 0 || req.Objects[0].OID != oid || len(req.Objects) > 2 {
+			t.Errorf("batch request %+v", req)
+		}
+		w.Header().Set("Content-Type", MediaType)
+		fmt.Fprintf(w, `{"transfer":"basic","objects":[
+			{"oid":%q,"size":12345,"actions":{"download":{"href":"https://cdn.example/%s","header":{"Authorization":"RemoteAuth x"}}}},
+			{"oid":%q,"size":3,"error":{"code":404,"message":"Object does not exist"}}]}`, oid, oid, other)
+	}))
+	defer server.Close()
+
+	objects := []Object{
+		{Path: "a.bin", Size: 12345, SHA256: oid},
+		{Path: "copy/a.bin", Size: 12345, SHA256: oid},
+	}
+	header := map[string]string{"Authorization": "token secret"}
+	resolved, err := Batch(context.Background(), server.Client(), server.URL+"/org/repo", header, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range resolved {
+		if obj.URL != "https://cdn.example/"+oid || obj.Header["Authorization"] != "RemoteAuth x" {
+			t.Errorf("resolved %+v", obj)
+		}
+	}
+
+	objects = append(objects, Object{Path: "gone.bin", Size: 3, SHA256: other})
+	if _, err := Batch(context.Background(), server.Client(), server.URL+"/org/repo.git", header, objects); err == nil || !strings.Contains(err.Error(), "gone.bin: 404") {
+		t.Errorf("Batch() with a missing object = %v", err)
+	}
+}
+
+func TestHubObjects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hf_token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.RequestURI() {
+		case "/api/datasets/org/corpus/tree/v1.0?recursive=true":
+			w.Header().Set("Link", `<`+server.URL+`/api/datasets/org/corpus/tree/v1.0?recursive=true&cursor=2>; rel="next"`)
+			fmt.Fprint(w, `[{"type":"file","path":"README.md","size":10},{"type":"directory","path":"data","size":0}]`)
+		case "/api/datasets/org/corpus/tree/v1.0?recursive=true&cursor=2":
+			fmt.Fprintf(w, `[{"type":"file","path":"data/train 1.parquet","size":134,"lfs":{"oid":%q,"size":12345}}]`, oid)
+		case "/api/datasets/org/corpus/tree/v1.0/data?recursive=true":
+			fmt.Fprintf(w, `[{"type":"file","path":"data/train 1.parquet","size":134,"lfs":{"oid":%q,"size":12345}}]`, oid)
+		case "/api/datasets/org/corpus/tree/v1.0":
+			fmt.Fprint(w, `[{"type":"file","path":"README.md","size":10},{"type":"directory","path":"data","size":0}]`)
+		case "/api/models/org/evil/tree/main?recursive=true":
+			fmt.Fprint(w, `[{"type":"file","path":"../../.bashrc","size":10}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	hub := &Hub{Endpoint: server.URL, Token: "hf_token", Client: server.Client()}
+
+	repo, err := ParseRepo("hf://datasets/org/corpus@v1.0")
+	if err != nil || repo.Kind != "datasets" || repo.ID != "org/corpus" || repo.Revision != "v1.0" || repo.Name() != "corpus" {
+		t.Fatalf("ParseRepo() = %+v, %v", repo, err)
+	}
+	objects, err := hub.Objects(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].SHA256 != "" || objects[0].Size != 10 {
+		t.Fatalf("Objects() = %+v", objects)
+	}
+	want := Object{
+		Path:   "data/train 1.parquet",
+		URL:    server.URL + "/datasets/org/corpus/resolve/v1.0/data/train%201.parquet",
+		Size:   12345,
+		SHA256: oid,
+	}
+	if got := objects[1]; got.Path != want.Path || got.URL != want.URL || got.Size != want.Size || got.SHA256 != want.SHA256 || got.Header["Authorization"] != "Bearer hf_token" {
+		t.Errorf("Objects()[1] = %+v, want %+v", got, want)
+	}
+
+	// A path limits the listing to a directory or file
+	for _, raw := range []string{"hf://datasets/org/corpus@v1.0/data", "hf://datasets/org/corpus@v1.0/README.md"} {
+		repo, _ := ParseRepo(raw)
+		if objects, err := hub.Objects(context.Background(), repo); err != nil || len(objects) != 1 {
+			t.Errorf("Objects(%s) = %+v, %v", raw, objects, err)
+		}
+	}
+	repo, _ = ParseRepo("hf://datasets/org/corpus@v1.0/missing")
+	if _, err := hub.Objects(context.Background(), repo); err == nil {
+		t.Error("Objects() of a missing path succeeded")
+	}
+	repo, _ = ParseRepo("hf://org/evil")
+	if _, err := hub.Objects(context.Background(), repo); err == nil {
+		t.Error("Objects() accepted a path outside the repository")
+	}
+
+	for _, raw := range []string{"hf://gpt2", "hf://datasets/org", "https://huggingface.co/org/model"} {
+		if _, err := ParseRepo(raw); err == nil {
+			t.Errorf("ParseRepo(%q) succeeded", raw)
+		}
+	}
+	if repo, err := ParseRepo("hf://org/model@refs%2Fpr%2F1/config.json"); err != nil || repo.Revision != "refs/pr/1" || repo.Path != "config.json" || repo.Kind != "models" {
+		t.Errorf("ParseRepo() with a PR revision = %+v, %v", repo, err)
+	}
+}
@@ -0,0 +1,212 @@
+// Package lifecycle defines the status of a download and the transitions
+// between statuses. The CLI, the servers, the queue and the database all use
+// the same Status values, and every status change is checked against a small
+// state machine so a late progress update cannot revive a finished download.
+// Writes also carry sequence numbers so an older snapshot cannot overwrite a
+// newer one.
+package lifecycle
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the state of a download or queued job
+type Status string
+
+const (
+	// Waiting jobs are held until the jobs they depend on complete
+	Waiting Status = "waiting"
+	// Queued jobs wait for a free worker
+	Queued Status = "queued"
+	// Downloading jobs are transferring data
+	Downloading Status = "downloading"
+	// WaitingNetwork downloads were stopped because the network went
+	// offline or metered and resume on their own once it is back
+	WaitingNetwork Status = "waiting_network"
+	// Paused downloads keep their progress and can be resumed
+	Paused Status = "paused"
+	// Completed downloads finished and were verified
+	Completed Status = "completed"
+	// NotModified downloads were skipped because the existing output is
+	// the current version of the remote file
+	NotModified Status = "not_modified"
+	// Failed downloads stopped with an error
+	Failed Status = "failed"
+)
+
+// All lists every status in lifecycle order
+var All = []Status{Waiting, Queued, Downloading, WaitingNetwork, Paused, Completed, NotModified, Failed}
+
+// ErrInvalidStatus is returned for names that are not a known status
+var ErrInvalidStatus = errors.New("invalid status")
+
+// ErrInvalidTransition is returned when a status change is not allowed
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// ErrStale is returned when a write carries a sequence number that is not
+// newer than the one already stored
+var ErrStale = errors.New("stale update")
+
+// aliases maps names written by older versions to their current status.
+// The queue used to call running jobs "processing".
+var aliases = map[string]Status{
+	"processing": Downloading,
+}
+
+// transitions lists the statuses each status may move to. Downloading jobs
+// may go back to Queued when their worker disappears; Completed, NotModified
+// and Failed are final.
+var transitions = map[Status][]Status{
+	Waiting:        {Queued, Failed},
+	Queued:         {Downloading, NotModified, Failed},
+	Downloading:    {WaitingNetwork, Paused, Completed, NotModified, Failed, Queued},
+	WaitingNetwork: {Downloading, Paused, Failed},
+	Paused:         {Downloading, Failed},
+	Completed:      nil,
+	NotModified:    nil,
+	Failed:         nil,
+}
+
+// Parse returns the status named s, accepting the names older versions wrote
+func Parse(s string) (Status, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if status := Status(name); status.Valid() {
+		return status, nil
+	}
+	if status, ok := aliases[name]; ok {
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidStatus, s)
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Succeeded reports whether s ended without error: the file was
+// downloaded, or the existing output was already current
+func (s Status) Succeeded() bool {
+	return s == Completed || s == NotModified
+}
+
+// Terminal reports whether s is final
+func (s Status) Terminal() bool {
+	return s.Valid() && len(transitions[s]) == 0
+}
+
+// CanTransition reports whether a download in status s may move to next.
+// Staying in the same status is always allowed so progress updates can
+// repeat it.
+func (s Status) CanTransition(next Status) bool {
+	if s == next {
+		return s.Valid()
+	}
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition returns next if s may move to it and an error wrapping
+// ErrInvalidTransition otherwise
+func (s Status) Transition(next Status) (Status, error) {
+	if !s.CanTransition(next) {
+		return s, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, s, next)
+	}
+	return next, nil
+}
+
+// Sources returns the statuses that may move to next, including next itself.
+// Stores use it to make a status change conditional on the current status.
+func Sources(next Status) []Status {
+	var sources []Status
+	for _, s := range All {
+		if s.CanTransition(next) {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// lastSeq is the largest sequence number this process returned or observed
+var lastSeq int64
+
+// NextSeq returns a sequence number larger than every number this process
+// returned or observed before. Numbers follow the wall clock in nanoseconds,
+// so writers in different processes stay roughly in order, and Observe keeps
+// a process with a slow clock ahead of the writes it has read.
+func NextSeq() int64 {
+	for {
+		last := atomic.LoadInt64(&lastSeq)
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastSeq, last, next) {
+			return next
+		}
+	}
+}
+
+// Observe records a sequence number read from a store so that later NextSeq
+// calls return larger numbers
+func Observe(seq int64) {
+	for {
+		last := atomic.LoadInt64(&lastSeq)
+		if seq <= last || atomic.CompareAndSwapInt64(&lastSeq, last, seq) {
+			return
+		}
+	}
+}
+
+// String returns the status name
+func (s Status) String() string {
+	return string(s)
+}
+
+// MarshalText rejects unknown statuses so they are never written out
+func (s Status) MarshalText() ([]byte, error) {
+	if !s.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, string(s))
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText parses a status, accepting the names older versions wrote
+func (s *Status) UnmarshalText(text []byte) error {
+	status, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// Value stores the status as its name
+func (s Status) Value() (driver.Value, error) {
+	if !s.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, string(s))
+	}
+	return string(s), nil
+}
+
+// Scan reads a status stored by Value, accepting the names older versions wrote
+func (s *Status) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return s.UnmarshalText([]byte(v))
+	case []byte:
+		return s.UnmarshalText(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidStatus, src)
+	}
+}
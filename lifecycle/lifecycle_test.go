@@ -0,0 +1,157 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]Status{
+		"queued":      Queued,
+		" Completed ": Completed,
+		"processing":  Downloading,
+		"downloading": Downloading,
+		"WAITING":     Waiting,
+	}
+	for in, want := range tests {
+		got, err := Parse(in)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	if _, err := Parse("running"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("Parse(running) error = %v, want ErrInvalidStatus", err)
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		want     bool
+	}{
+		{Waiting, Queued, true},
+		{Waiting, Downloading, false},
+		{Queued, Downloading, true},
+		{Downloading, Downloading, true},
+		{Downloading, Paused, true},
+		{Downloading, Queued, true},
+		{Paused, Downloading, true},
+		{Downloading, WaitingNetwork, true},
+		{WaitingNetwork, Downloading, true},
+		{WaitingNetwork, Completed, false},
+		{Paused, Completed, false},
+		{Completed, Downloading, false},
+		{Completed, Completed, true},
+		{Failed, Queued, false},
+		{Status("bogus"), Status("bogus"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransition(tt.to); got != tt.want {
+			t.Errorf("%s.CanTransition(%s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if _, err := Completed.Transition(Downloading); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Completed.Transition(Downloading) error = %v, want ErrInvalidTransition", err)
+	}
+	if next, err := Queued.Transition(Downloading); err != nil || next != Downloading {
+		t.Errorf("Queued.Transition(Downloading) = %s, %v", next, err)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	for _, s := range All {
+		want := s == Completed || s == NotModified || s == Failed
+		if s.Terminal() != want {
+			t.Errorf("%s.Terminal() = %v, want %v", s, s.Terminal(), want)
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	got := Sources(Completed)
+	want := []Status{Downloading, Completed}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Sources(Completed) = %v, want %v", got, want)
+	}
+}
+
+func TestNextSeqIsUnique(t *testing.T) {
+	const goroutines, perGoroutine = 8, 1000
+	seen := make(chan int64, goroutines*perGoroutine)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prev := int64(0)
+			for j := 0; j < perGoroutine; j++ {
+				seq := NextSeq()
+				if seq <= prev {
+					t.Errorf("NextSeq() = %d after %d", seq, prev)
+				}
+				prev = seq
+				seen <- seq
+			}
+		}()
+	}
+	wg.Wait()
+	close(seen)
+
+	unique := make(map[int64]bool)
+	for seq := range seen {
+		if unique[seq] {
+			t.Fatalf("NextSeq() returned %d twice", seq)
+		}
+		unique[seq] = true
+	}
+}
+
+func TestObserveAdvancesNextSeq(t *testing.T) {
+	future := time.Now().Add(time.Hour).UnixNano()
+	Observe(future)
+	if seq := NextSeq(); seq <= future {
+		t.Fatalf("NextSeq() = %d, want more than the observed %d", seq, future)
+	}
+	Observe(1)
+	if seq := NextSeq(); seq <= future {
+		t.Fatalf("observing an old number moved NextSeq() back to %d", seq)
+	}
+}
+
+func TestJSONAndSQL(t *testing.T) {
+	var decoded struct {
+		Status Status `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(`{"status":"processing"}`), &decoded); err != nil || decoded.Status != Downloading {
+		t.Fatalf("Unmarshal(processing) = %q, %v", decoded.Status, err)
+	}
+	if err := json.Unmarshal([]byte(`{"status":"running"}`), &decoded); err == nil {
+		t.Fatal("Unmarshal(running) succeeded")
+	}
+
+	data, err := json.Marshal(struct {
+		Status Status `json:"status"`
+	}{Paused})
+	if err != nil || string(data) != `{"status":"paused"}` {
+		t.Fatalf("Marshal(Paused) = %s, %v", data, err)
+	}
+	if _, err := json.Marshal(Status("bogus")); err == nil {
+		t.Fatal("Marshal(bogus) succeeded")
+	}
+
+	var scanned Status
+	if err := scanned.Scan([]byte("failed")); err != nil || scanned != Failed {
+		t.Fatalf("Scan(failed) = %q, %v", scanned, err)
+	}
+	if err := scanned.Scan(42); err == nil {
+		t.Fatal("Scan(42) succeeded")
+	}
+	if v, err := Queued.Value(); err != nil || v != "queued" {
+		t.Fatalf("Value() = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package listener opens the socket a server accepts connections on: a TCP
+// address, a Unix socket for deployments only used from the same host, or a
+// socket systemd passes in through socket activation so the server is only
+// started by its first connection.
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnixPrefix marks an address as the path of a Unix socket
+const UnixPrefix = "unix:"
+
+// DefaultSocketMode lets the owner and group of a Unix socket connect to it
+const DefaultSocketMode os.FileMode = 0660
+
+// firstActivatedFD is the descriptor of the first socket systemd passes
+const firstActivatedFD = 3
+
+// Activated returns the sockets systemd passed to the process, in the order
+// of the unit's Listen lines, or nil when it was not socket activated. The
+// LISTEN_* variables are cleared so processes started from here do not take
+// the sockets for theirs.
+func Activated() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	nameList := strings.Split(names, ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := firstActivatedFD + i
+		name := fmt.Sprintf("fd %d", fd)
+		if i < len(nameList) && nameList[i] != "" {
+			name = nameList[i]
+		}
+		// FileListener works on a duplicate, so the inherited descriptor
+		// is closed and not passed on to child processes
+		file := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to use socket %s passed by systemd: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Unix listens on the Unix socket path and gives it mode. A socket left
+// behind by a server that did not shut down cleanly is replaced; one that
+// another server still answers on is not. The socket is removed when the
+// listener is closed.
+func Unix(path string, mode os.FileMode) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another server is listening on %s", path)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// Open returns the listener of a server: the first socket systemd passed
+// when it was socket activated, otherwise a Unix socket with mode for an
+// address of the form unix:/path, otherwise the TCP address addr
+func Open(addr string, mode os.FileMode) (net.Listener, error) {
+	activated, err := Activated()
+	if err != nil {
+		return nil, err
+	}
+	if len(activated) > 0 {
+		for _, extra := range activated[1:] {
+			extra.Close()
+		}
+		return activated[0], nil
+	}
+	if strings.HasPrefix(addr, UnixPrefix) {
+		return Unix(strings.TrimPrefix(addr, UnixPrefix), mode)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return l, nil
+}
+
+// FromEnv opens the listener of a server configured through the
+// environment: UNIX_SOCKET is the path of a Unix socket to listen on instead
+// of the TCP address tcpAddr, and UNIX_SOCKET_MODE its octal permissions,
+// DefaultSocketMode by default. A socket passed by systemd wins over both.
+func FromEnv(tcpAddr string) (net.Listener, error) {
+	mode := DefaultSocketMode
+	if value := os.Getenv("UNIX_SOCKET_MODE"); value != "" {
+		parsed, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || parsed > 0777 {
+			return nil, fmt.Errorf("invalid UNIX_SOCKET_MODE %q: want octal permissions such as 0660", value)
+		}
+		mode = os.FileMode(parsed)
+	}
+	addr := tcpAddr
+	if path := os.Getenv("UNIX_SOCKET"); path != "" {
+		addr = UnixPrefix + path
+	}
+	return Open(addr, mode)
+}
+
+// Describe returns where l accepts connections, for startup messages
+func Describe(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return UnixPrefix + l.Addr().String()
+	}
+	return l.Addr().String()
+}
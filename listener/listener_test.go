@@ -0,0 +1,106 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	l, err := Unix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want 0600", info.Mode().Perm())
+	}
+	if got := Describe(l); got != "unix:"+path {
+		t.Errorf("Describe() = %q", got)
+	}
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	if _, err := Unix(path, 0600); err == nil {
+		t.Error("Unix() took over a socket another server answers on")
+	}
+	l.Close()
+	<-accepted
+
+	// A socket left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	replaced, err := Unix(path, 0660)
+	if err != nil {
+		t.Fatalf("Unix() over a stale socket: %v", err)
+	}
+	replaced.Close()
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unix(file, 0600); err == nil {
+		t.Error("Unix() replaced a regular file")
+	}
+}
+
+func TestActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := Activated(); err != nil || listeners != nil {
+		t.Errorf("Activated() for another process = %v, %v", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS not cleared")
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "none")
+	if _, err := Activated(); err == nil {
+		t.Error("Activated() accepted an invalid LISTEN_FDS")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	t.Setenv("UNIX_SOCKET", path)
+	t.Setenv("UNIX_SOCKET_MODE", "0640")
+	l, err := FromEnv(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("socket %v, %v; want mode 0640", info, err)
+	}
+
+	t.Setenv("UNIX_SOCKET", "")
+	tcp, err := FromEnv("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp.Close()
+	if tcp.Addr().Network() != "tcp" {
+		t.Errorf("FromEnv() without UNIX_SOCKET listened on %s", tcp.Addr().Network())
+	}
+
+	t.Setenv("UNIX_SOCKET_MODE", "rw")
+	if _, err := FromEnv("127.0.0.1:0"); err == nil {
+		t.Error("FromEnv() accepted an invalid UNIX_SOCKET_MODE")
+	}
+}
@@ -0,0 +1,262 @@
+// Package logging builds the zap loggers of the queue server and workers
+// from the environment or a config file: the level, JSON or console lines,
+// stdout or a rotating file, and levels for single components.
+//
+// Components are the "component" field the servers add to their loggers
+// (server, worker, worker_manager), so
+//
+//	LOG_LEVEL=warn LOG_LEVELS=worker=debug
+//
+// shows every line of the workers and only warnings of the rest.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes a logger. The zero Config logs info and above as JSON to
+// stdout, as zap.NewProduction does.
+type Config struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is json or console
+	Format string `yaml:"format"`
+	// File is written instead of stdout when set; "stderr" writes there
+	File string `yaml:"file"`
+	// MaxSizeMB is the size in megabytes File rotates at
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is how many rotated files are kept; zero keeps them all
+	MaxBackups int `yaml:"max_backups"`
+	// MaxAgeDays is how many days rotated files are kept; zero keeps them
+	// forever
+	MaxAgeDays int `yaml:"max_age_days"`
+	// Compress gzips rotated files
+	Compress bool `yaml:"compress"`
+	// Components sets the level of single components, by name
+	Components map[string]string `yaml:"components"`
+}
+
+// DefaultMaxSizeMB is the size log files rotate at when none is set
+const DefaultMaxSizeMB = 100
+
+// FromEnv reads the config file named by LOG_CONFIG, if any, and then the
+// LOG_* variables, which override it
+func FromEnv() (Config, error) {
+	var cfg Config
+	if path := os.Getenv("LOG_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read LOG_CONFIG: %w", err)
+		}
+		// JSON is YAML too
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid LOG_CONFIG %s: %w", path, err)
+		}
+	}
+
+	strs := []struct {
+		key   string
+		value *string
+	}{
+		{"LOG_LEVEL", &cfg.Level},
+		{"LOG_FORMAT", &cfg.Format},
+		{"LOG_FILE", &cfg.File},
+	}
+	for _, env := range strs {
+		if raw := os.Getenv(env.key); raw != "" {
+			*env.value = raw
+		}
+	}
+	ints := []struct {
+		key   string
+		value *int
+	}{
+		{"LOG_MAX_SIZE_MB", &cfg.MaxSizeMB},
+		{"LOG_MAX_BACKUPS", &cfg.MaxBackups},
+		{"LOG_MAX_AGE_DAYS", &cfg.MaxAgeDays},
+	}
+	for _, env := range ints {
+		if raw := os.Getenv(env.key); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s must be a non-negative number, got %q", env.key, raw)
+			}
+			*env.value = n
+		}
+	}
+	if raw := os.Getenv("LOG_COMPRESS"); raw != "" {
+		compress, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("LOG_COMPRESS must be true or false, got %q", raw)
+		}
+		cfg.Compress = compress
+	}
+	if raw := os.Getenv("LOG_LEVELS"); raw != "" {
+		components, err := ParseLevels(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LOG_LEVELS: %w", err)
+		}
+		if cfg.Components == nil {
+			cfg.Components = map[string]string{}
+		}
+		for name, level := range components {
+			cfg.Components[name] = level
+		}
+	}
+	return cfg, nil
+}
+
+// ParseLevels parses component levels written as worker=debug,server=warn
+func ParseLevels(raw string) (map[string]string, error) {
+	levels := map[string]string{}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, level, ok := cut(item, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not component=level", item)
+		}
+		if _, err := parseLevel(level); err != nil {
+			return nil, err
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
+// New builds the logger cfg describes. The returned close function syncs
+// and closes the log file; call it before exiting.
+func New(cfg Config) (*zap.Logger, func() error, error) {
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+	components := map[string]zapcore.Level{}
+	lowest := level
+	for name, raw := range cfg.Components {
+		componentLevel, err := parseLevel(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("component %s: %w", name, err)
+		}
+		components[name] = componentLevel
+		if componentLevel < lowest {
+			lowest = componentLevel
+		}
+	}
+
+	var encoder zapcore.Encoder
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	case "console":
+		encoderConfig := zap.NewDevelopmentEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, nil, fmt.Errorf("log format must be json or console, got %q", cfg.Format)
+	}
+
+	var sink zapcore.WriteSyncer
+	closeSink := func() error { return nil }
+	switch cfg.File {
+	case "", "stdout":
+		sink = zapcore.Lock(os.Stdout)
+	case "stderr":
+		sink = zapcore.Lock(os.Stderr)
+	default:
+		maxSize := cfg.MaxSizeMB
+		if maxSize == 0 {
+			maxSize = DefaultMaxSizeMB
+		}
+		file := &RotatingFile{
+			Path:       cfg.File,
+			MaxSize:    int64(maxSize) << 20,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+			Compress:   cfg.Compress,
+		}
+		// Fail now rather than on the first line
+		if _, err := file.Write(nil); err != nil {
+			return nil, nil, err
+		}
+		sink, closeSink = file, file.Close
+	}
+
+	core := zapcore.NewSamplerWithOptions(zapcore.NewCore(encoder, sink, lowest), time.Second, 100, 100)
+	logger := zap.New(&componentCore{Core: core, level: level, components: components},
+		zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	return logger, func() error {
+		logger.Sync()
+		return closeSink()
+	}, nil
+}
+
+// NewFromEnv builds the logger the environment describes
+func NewFromEnv() (*zap.Logger, func() error, error) {
+	cfg, err := FromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	return New(cfg)
+}
+
+// componentCore filters entries by the level of the component its logger
+// was given with a "component" field, or by the default level
+type componentCore struct {
+	zapcore.Core
+	level      zapcore.Level
+	components map[string]zapcore.Level
+}
+
+func (c *componentCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *componentCore) With(fields []zapcore.Field) zapcore.Core {
+	level := c.level
+	for _, field := range fields {
+		if field.Key == "component" && field.Type == zapcore.StringType {
+			if componentLevel, ok := c.components[field.String]; ok {
+				level = componentLevel
+			}
+		}
+	}
+	return &componentCore{Core: c.Core.With(fields), level: level, components: c.components}
+}
+
+func (c *componentCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// parseLevel parses a level name; empty is info
+func parseLevel(raw string) (zapcore.Level, error) {
+	if raw == "" {
+		return zapcore.InfoLevel, nil
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(strings.ToLower(raw))); err != nil {
+		return level, fmt.Errorf("log level must be debug, info, warn or error, got %q", raw)
+	}
+	return level, nil
+}
+
+// cut is strings.Cut, which Go 1.17 does not have
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestComponentLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	logger, closeLog, err := New(Config{Level: "warn", File: path, Components: map[string]string{"worker": "debug"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("server info")
+	logger.Warn("server warning")
+	worker := logger.With(zap.String("component", "worker"))
+	worker.Debug("worker debug")
+	logger.With(zap.String("component", "server")).Info("other component info")
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line struct {
+			Msg string `json:"msg"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		messages = append(messages, line.Msg)
+	}
+	if got := strings.Join(messages, ","); got != "server warning,worker debug" {
+		t.Errorf("logged %s", got)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logging.yaml")
+	config := "level: info\nformat: console\ncomponents:\n  worker: debug\n  server: error\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOG_CONFIG", path)
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_LEVELS", "server=info, queue=debug")
+	t.Setenv("LOG_MAX_BACKUPS", "3")
+	cfg, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Level != "warn" || cfg.Format != "console" || cfg.MaxBackups != 3 {
+		t.Errorf("cfg = %+v", cfg)
+	}
+	if cfg.Components["worker"] != "debug" || cfg.Components["server"] != "info" || cfg.Components["queue"] != "debug" {
+		t.Errorf("components = %v", cfg.Components)
+	}
+
+	t.Setenv("LOG_LEVELS", "worker")
+	if _, err := FromEnv(); err == nil {
+		t.Error("LOG_LEVELS without a level accepted")
+	}
+	t.Setenv("LOG_LEVELS", "")
+	t.Setenv("LOG_LEVEL", "loud")
+	if _, _, err := NewFromEnv(); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	file := &RotatingFile{Path: filepath.Join(dir, "worker.log"), MaxSize: 10, MaxBackups: 2, Compress: true}
+	for i := 0; i < 4; i++ {
+		if _, err := file.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+		// Backups are named to the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var backups []string
+	for _, entry := range entries {
+		if entry.Name() != "worker.log" {
+			backups = append(backups, entry.Name())
+		}
+	}
+	if len(backups) != 2 {
+		t.Fatalf("kept %v, want two backups", backups)
+	}
+	for _, name := range backups {
+		if !strings.HasPrefix(name, "worker-") || !strings.HasSuffix(name, ".log.gz") {
+			t.Errorf("backup %s", name)
+		}
+	}
+	if data, _ := os.ReadFile(file.Path); string(data) != "0123456789" {
+		t.Errorf("current file holds %q", data)
+	}
+}
+
+func TestPruneByAge(t *testing.T) {
+	dir := t.TempDir()
+	file := &RotatingFile{Path: filepath.Join(dir, "server.log"), MaxAge: 24 * time.Hour}
+	now := time.Now()
+	old := file.backupName(now.Add(-48 * time.Hour))
+	recent := file.backupName(now.Add(-time.Hour))
+	for _, name := range []string{old, recent, filepath.Join(dir, "server-notes.log")} {
+		os.WriteFile(name, []byte("x"), 0644)
+	}
+	file.prune(now)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("old backup kept")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("recent backup removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "server-notes.log")); err != nil {
+		t.Error("file that is not a backup removed")
+	}
+}
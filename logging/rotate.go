@@ -0,0 +1,211 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files; it sorts by time and has no colons,
+// which Windows does not allow in names
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is a log file that is renamed aside once it reaches MaxSize,
+// keeping at most MaxBackups old files for at most MaxAge, optionally
+// gzipped. It is safe for concurrent use.
+type RotatingFile struct {
+	// Path of the current file; backups are named after it, e.g.
+	// worker-2026-10-16T09-00-00.000.log for worker.log
+	Path string
+	// MaxSize is the size in bytes the file rotates at; zero never rotates
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept; zero keeps them all
+	MaxBackups int
+	// MaxAge is how long rotated files are kept; zero keeps them forever
+	MaxAge time.Duration
+	// Compress gzips rotated files
+	Compress bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// mill compresses and removes backups after a rotation, one at a time
+	mill sync.Mutex
+	wg   sync.WaitGroup
+}
+
+// Write appends p to the file, rotating it first if p would take it past
+// MaxSize
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the file to disk
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Rotate renames the current file aside and starts a new one
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+// Close closes the file and waits for backups being compressed
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+// open opens the file for appending, creating it and its directory
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the file to its backup name and opens a new one; it is
+// called with mu held
+func (f *RotatingFile) rotate() error {
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+	backup := f.backupName(time.Now())
+	if err := os.Rename(f.Path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.mill.Lock()
+		defer f.mill.Unlock()
+		if f.Compress {
+			compress(backup)
+		}
+		f.prune(time.Now())
+	}()
+	return nil
+}
+
+// backupName returns the name the file gets when rotated at t
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.Path)
+	return strings.TrimSuffix(f.Path, ext) + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// prune removes the backups past MaxBackups or older than MaxAge
+func (f *RotatingFile) prune(now time.Time) {
+	if f.MaxBackups <= 0 && f.MaxAge <= 0 {
+		return
+	}
+	ext := filepath.Ext(f.Path)
+	prefix := filepath.Base(strings.TrimSuffix(f.Path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.Path))
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		name string
+		at   time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if entry.IsDir() || !strings.HasPrefix(stamp, prefix) {
+			continue
+		}
+		at, err := time.Parse(backupTimeFormat, strings.TrimPrefix(stamp, prefix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{name, at})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].at.After(backups[j].at) })
+
+	for i, b := range backups {
+		tooMany := f.MaxBackups > 0 && i >= f.MaxBackups
+		tooOld := f.MaxAge > 0 && now.Sub(b.at) > f.MaxAge
+		if tooMany || tooOld {
+			os.Remove(filepath.Join(filepath.Dir(f.Path), b.name))
+		}
+	}
+}
+
+// compress replaces path with path.gz; path is kept if that fails
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -1,21 +1,154 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"multithreaded-downloader/agent"
+	"multithreaded-downloader/bench"
+	"multithreaded-downloader/cache"
+	"multithreaded-downloader/domainrules"
 	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/fusefs"
+	"multithreaded-downloader/ipfs"
+	"multithreaded-downloader/lfs"
+	"multithreaded-downloader/oci"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/listener"
+	"multithreaded-downloader/netwatch"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/reconcile"
+	"multithreaded-downloader/registry"
+	"multithreaded-downloader/relay"
+	"multithreaded-downloader/robots"
+	"multithreaded-downloader/secrets"
+	"multithreaded-downloader/service"
+	"multithreaded-downloader/torrent"
+	"multithreaded-downloader/zsync"
 )
 
 func main() {
+	// Subcommands that work with encrypted downloads or the job registry
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "list":
+			runList()
+			return
+		case "resume":
+			runResume(os.Args[2:])
+			return
+		case "cancel":
+			runCancel(os.Args[2:])
+			return
+		case "clean":
+			runClean()
+			return
+		case "keygen":
+			runKeygen()
+			return
+		case "decrypt":
+			runDecrypt(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "mount":
+			runMount(os.Args[2:])
+			return
+		case "relay":
+			runRelay(os.Args[2:])
+			return
+		case "agent":
+			runAgent(os.Args[2:])
+			return
+		case "add":
+			runAdd(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "install":
+			runInstall(os.Args[2:])
+			return
+		case "uninstall":
+			runUninstall(os.Args[2:])
+			return
+		case service.WrapperCommand:
+			runServiceWrapper(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command-line flags
 	var (
 		url        = flag.String("url", "", "URL to download")
-		output     = flag.String("output", "", "Output filename")
+		output     = flag.String("output", "", "Output filename, or - to stream to stdout")
 		threads    = flag.Int("threads", 4, "Number of download threads")
+		minPart    = flag.Int64("min-part-size", downloader.DefaultMinPartSize, "Smallest part in bytes; small files use fewer threads (0 for no minimum)")
+		sequential = flag.Bool("sequential", false, "Download mostly in order so a video can be played while it downloads")
+		previewN   = flag.Int64("preview-bytes", 0, "Fetch the first and last n bytes before the rest, e.g. for a zip's central directory")
+		retries    = flag.Int("checksum-retries", downloader.DefaultChecksumRetries, "How often corrupt parts are downloaded again when the file fails the server's checksum")
+		preview    = flag.Bool("preview", false, "Print the URLs a template or page expands to and exit")
+		links      = flag.Bool("links", false, "Treat --url as an HTML page or sitemap and download the links on it")
+		pattern    = flag.String("pattern", "", "Regular expression links must match in --links mode")
+		join       = flag.Bool("join", false, "Treat the URLs a template or page expands to as pieces of one file and join them into --output")
+		polite     = flag.Bool("polite", false, "In --links mode, skip links robots.txt disallows and wait out each site's Crawl-delay between requests")
+		noRobots   = flag.Bool("ignore-robots", false, "With --polite, download links robots.txt disallows but keep to the Crawl-delay")
+		crawlDelay = flag.Duration("crawl-delay", 0, "With --polite, the least time between requests to a site (default the site's Crawl-delay)")
+		userAgent  = flag.String("user-agent", "", "Custom User-Agent header")
+		uaProfile  = flag.String("ua-profile", "", "Named User-Agent preset (chrome, firefox, safari, edge, curl, wget)")
+		referer    = flag.String("referer", "", "Referer header to send")
+		cookies    = flag.String("cookies", "", "Netscape cookies.txt file to send cookies from")
+		keyFile    = flag.String("encrypt-key-file", "", "Encrypt the output with the AES-256 key in this file (a local key file only; no KMS or age recipients)")
+		method     = flag.String("method", "", "HTTP method to request the file with (default GET, or POST with --data)")
+		data       = flag.String("data", "", "Request body to send, or @file to read it from a file")
+		dataType   = flag.String("data-type", "form", "Encoding of --data: form or json")
+		force      = flag.Bool("force", false, "Overwrite an output file that exists without saved progress")
+		cont       = flag.Bool("continue", false, "Resume an output file that exists without saved progress from its end")
+		skip       = flag.Bool("skip-existing", false, "Leave output files that already exist alone")
+		update     = flag.Bool("update", false, "Download only when the remote file changed since the existing output (If-None-Match/If-Modified-Since)")
+		resultJSON = flag.String("result-json", "", "Write a JSON summary of the downloads to this file on exit")
+		window     = flag.Int64("stream-window", downloader.DefaultStreamWindow, "Bytes fetched ahead of stdout with --output -")
+		noWait     = flag.Bool("no-network-wait", false, "Fail when the network goes away instead of waiting for it")
+		metered    = flag.Bool("allow-metered", false, "Keep downloading on a metered network instead of waiting")
+		rulesFile  = flag.String("rules", "", "Domain rules file with per-host defaults (default ~/.mtdl/rules.yaml)")
+		refreshCmd = flag.String("refresh-command", "", "Shell command printing a new link when the server refuses the link as expired")
+		refreshURL = flag.String("refresh-url", "", "Endpoint POSTed the expired link that answers with a new one")
+		zsyncURL   = flag.String("zsync", "", "zsync control file to update the output from a local copy with, or auto for --url plus .zsync")
+		seedFile   = flag.String("seed", "", "Older version of the file whose blocks --zsync reuses (default the output)")
+		cacheDir   = flag.String("cache", "", "Directory of finished downloads to link files downloaded before from, and to add new ones to")
+		cacheSize  = flag.Int64("cache-size", cache.DefaultMaxSize, "Bytes --cache may hold before the least recently used files are evicted")
+		cacheCopy  = flag.Bool("cache-copy", false, "Copy files out of --cache instead of hard linking them")
+		mirrorN    = flag.Int("mirrors", downloader.DefaultMirrorCount, "How many of the fastest mirrors parts are spread over")
+		torrentSrc = flag.String("torrent", "", "Torrent file or URL whose web seeds serve the file and whose piece hashes check it")
+		lfsPointer = flag.String("lfs-pointers", "", "Git LFS pointer file or directory of them whose objects are downloaded from the repository at --url")
+		platform   = flag.String("platform", "", "os/arch[/variant] of the image an oci:// or docker:// index is narrowed to (default linux and this machine's architecture)")
+		bind       = flag.String("bind", "", "Network interface or local IP address to connect from, e.g. eth1 or 192.168.2.10")
+		bindRate   = flag.Int64("bind-rate-limit", 0, "Bytes per second all downloads bound to --bind share (default unlimited)")
+		doneMarker = flag.Bool("done-marker", false, "Write <output>.done with the file's size and SHA-256 once it is verified")
+		planFile   = flag.String("plan", "", "Part plan from --export-plan or the API to split the file by, with the server of every part")
+		exportPlan = flag.String("export-plan", "", "Write the download's part plan (ranges and servers) to this file once it is split")
+		captureEnv = flag.Bool("capture-env", false, "Write <output>.environment.json with the request, settings, resolver answers and server reply")
 		showHelp   = flag.Bool("help", false, "Show help message")
 	)
+	var mirrors repeatedFlag
+	flag.Var(&mirrors, "mirror", "Another URL serving the same file; may be given several times")
+	var gateways repeatedFlag
+	flag.Var(&gateways, "ipfs-gateway", "IPFS gateway an ipfs:// URL is fetched from; may be given several times (default $IPFS_GATEWAY or public gateways)")
 
 	// Custom usage function
 	flag.Usage = func() {
@@ -27,24 +160,119 @@ func main() {
 		fmt.Println()
 		fmt.Println("Flags:")
 		fmt.Println("  --url string       URL to download (required)")
-		fmt.Println("  --output string    Output filename (required)")
+		fmt.Println("  --output string    Output filename or template like {date}/{domain}/{filename}, or - for stdout (required)")
 		fmt.Println("  --threads int      Number of download threads (default 4)")
+		fmt.Println("  --min-part-size n  Smallest part in bytes, small files use fewer threads (default 1MB)")
+		fmt.Println("  --sequential       Download mostly in order so a video can be played while it downloads")
+		fmt.Println("  --preview-bytes n  Fetch the first and last n bytes before the rest (zip directory, MP4 moov atom)")
+		fmt.Println("  --checksum-retries Times corrupt parts are downloaded again on a checksum mismatch (default 2)")
+		fmt.Println("  --preview          Print the URLs a template or page expands to and exit")
+		fmt.Println("  --links            Download the links found on an HTML page or sitemap")
+		fmt.Println("  --pattern string   Regular expression links must match in --links mode")
+		fmt.Println("  --join             Download the URLs a template or page expands to as pieces of one file, e.g. file.z{01..05}")
+		fmt.Println("  --polite           In --links mode, skip links robots.txt disallows and keep to each site's Crawl-delay")
+		fmt.Println("  --ignore-robots    With --polite, download disallowed links too but keep to the Crawl-delay")
+		fmt.Println("  --crawl-delay d    With --polite, the least time between requests to a site, e.g. 2s")
+		fmt.Println("  --user-agent str   Custom User-Agent header")
+		fmt.Println("  --ua-profile str   Named User-Agent preset (chrome, firefox, safari, edge, curl, wget)")
+		fmt.Println("  --referer string   Referer header to send")
+		fmt.Println("  --cookies file     Netscape cookies.txt file (e.g. exported from a browser)")
+		fmt.Println("  --encrypt-key-file Encrypt the output with the AES-256 key in this file (local key files only, see keygen)")
+		fmt.Println("  --method string    HTTP method for the file, e.g. POST (default GET, or POST with --data)")
+		fmt.Println("  --data string      Request body, or @file to read it from a file")
+		fmt.Println("  --data-type type   Encoding of --data: form or json (default form)")
+		fmt.Println("  --force            Overwrite an output file that exists without saved progress")
+		fmt.Println("  --continue         Resume an output file that exists without saved progress from its end")
+		fmt.Println("  --skip-existing    Leave output files that already exist alone")
+		fmt.Println("  --update           Download only when the remote file changed since the existing output, replacing it")
+		fmt.Println("  --result-json file Write a JSON summary (bytes, duration, speed, checksum) on exit")
+		fmt.Println("  --stream-window n  Bytes fetched ahead of stdout with --output - (default 32MB)")
+		fmt.Println("  --no-network-wait  Fail when the network goes away instead of waiting for it to return")
+		fmt.Println("  --allow-metered    Keep downloading on a metered network instead of waiting")
+		fmt.Println("  --rules file       Domain rules with per-host threads, headers, cookies and retries (default ~/.mtdl/rules.yaml)")
+		fmt.Println("  --refresh-command  Command printing a new link for an expired one (in $MTDL_EXPIRED_URL), e.g. for pre-signed URLs")
+		fmt.Println("  --refresh-url url  Endpoint POSTed {\"url\": <expired link>} that answers with a new link")
+		fmt.Println("  --zsync url|auto   Fetch only the blocks that changed since a local copy, using the .zsync control file")
+		fmt.Println("  --seed file        Older version of the file --zsync reuses blocks from (default the output)")
+		fmt.Println("  --cache dir        Link files downloaded before (same URL and ETag, or checksum) from this cache and add new ones")
+		fmt.Println("  --cache-size n     Bytes the cache may hold before the least recently used files are evicted (default 10GB)")
+		fmt.Println("  --cache-copy       Copy files out of the cache instead of hard linking them")
+		fmt.Println("  --mirror url       Another URL of the same file; repeat for more. Parts come from the fastest ones")
+		fmt.Println("  --mirrors n        How many of the fastest mirrors parts are spread over (default 3)")
+		fmt.Println("  --torrent file|url Download a single-file torrent from its web seeds, checking every piece (--url optional)")
+		fmt.Println("  --ipfs-gateway url Gateway for ipfs:// URLs; repeat to race several (default $IPFS_GATEWAY or public gateways)")
+		fmt.Println("  --lfs-pointers p   Download the objects of the Git LFS pointers in file or directory p from the repo at --url")
+		fmt.Println("  --platform p       os/arch[/variant] pulled from a multi-platform oci:// or docker:// image (default linux/<this arch>)")
+		fmt.Println("  --bind iface|ip    Connect from this network interface or local address, e.g. eth1 on a host with several networks")
+		fmt.Println("  --bind-rate-limit  Bytes per second shared by the downloads bound to the same interface (default unlimited)")
+		fmt.Println("  --done-marker      Write <output>.done (JSON: size, SHA-256, validators) once the file is verified, for watchers")
+		fmt.Println("  --plan file        Split the file exactly as this part plan says, each part from the server it names")
+		fmt.Println("  --export-plan file Write the part plan (offsets, sizes, servers) once the file is split, to repeat the transfer")
+		fmt.Println("  --capture-env      Write <output>.environment.json: headers (secrets redacted), settings, DNS, redirects, server reply")
 		fmt.Println("  --help             Show this help message")
 		fmt.Println()
+		fmt.Println("Subcommands:")
+		fmt.Printf("  %s list                                         List downloads started here and their status\n", os.Args[0])
+		fmt.Printf("  %s resume <id>                                  Resume an interrupted download\n", os.Args[0])
+		fmt.Printf("  %s cancel <id>                                  Stop a download and delete its partial file\n", os.Args[0])
+		fmt.Printf("  %s clean                                        Forget completed, failed and cancelled downloads\n", os.Args[0])
+		fmt.Printf("  %s keygen                                       Print a new encryption key\n", os.Args[0])
+		fmt.Printf("  %s decrypt --input f --output f --key-file k    Decrypt an encrypted download\n", os.Args[0])
+		fmt.Printf("  %s serve --dir d --key-file k [--addr :8090]    Serve encrypted downloads, decrypting on the fly\n", os.Args[0])
+		fmt.Printf("  %s mount --dir d [--state-dir s] <mountpoint>   Mount downloads read-only, readable while they download (Linux)\n", os.Args[0])
+		fmt.Printf("  %s relay --dir d --allow hosts [--addr :8091]   Download files once and relay them to the LAN while they download\n", os.Args[0])
+		fmt.Printf("  %s agent [--concurrency n] [--allow-metered]    Run downloads handed over with add while online\n", os.Args[0])
+		fmt.Printf("  %s add --url u --output f [--threads n]         Queue a download with the agent, also offline\n", os.Args[0])
+		fmt.Printf("  %s bench --server u [--jobs n] [--size 10m]     Load-test a queued server whose workers run in sandbox mode\n", os.Args[0])
+		fmt.Printf("  %s install agent|server [--system] [-- args]    Run the agent or a server as a service that starts on its own\n", os.Args[0])
+		fmt.Printf("  %s uninstall agent|server [--system]            Stop and remove the service\n", os.Args[0])
+		fmt.Println()
 		fmt.Println("Examples:")
 		fmt.Printf("  %s --url https://example.com/file.zip --output download.zip\n", os.Args[0])
 		fmt.Printf("  %s --url https://example.com/file.zip --output download.zip --threads 8\n", os.Args[0])
+		fmt.Printf("  %s --url 'https://example.com/img_{001..120}.jpg' --output images/\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/papers.html --links --pattern '\\.pdf$' --output papers/\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/sitemap.xml --links --polite --crawl-delay 1s --output mirror/\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/get?id=7 --output '{date}/{domain}/{filename}'\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/export --data '{\"report\":42}' --data-type json --output report.csv\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/backup.tar.gz --output - | tar xz\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/talk.mp4 --output talk.mp4 --sequential\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/distro.iso --output distro.iso --zsync auto\n", os.Args[0])
+		fmt.Printf("  %s --url https://example.com/dataset.csv --output dataset.csv --update\n", os.Args[0])
+		fmt.Printf("  %s --url https://eu.example.com/distro.iso --mirror https://us.example.com/distro.iso --output distro.iso\n", os.Args[0])
+		fmt.Printf("  %s --torrent distro.iso.torrent --output distro.iso\n", os.Args[0])
+		fmt.Printf("  %s --url ipfs://bafybei.../dataset.tar --output dataset.tar\n", os.Args[0])
+		fmt.Printf("  %s --url hf://datasets/org/corpus@main/data --output corpus/\n", os.Args[0])
+		fmt.Printf("  %s --url https://github.com/org/repo --lfs-pointers checkout/models --output models/\n", os.Args[0])
+		fmt.Printf("  %s --url oci://ghcr.io/org/image:v1 --platform linux/arm64 --output images/\n", os.Args[0])
 		fmt.Println()
 		fmt.Println("Features:")
 		fmt.Println("- Multithreaded downloading with configurable thread count")
 		fmt.Println("- Resume support with progress tracking")
 		fmt.Println("- Real-time progress display")
 		fmt.Println("- Automatic HTTP range support detection")
-		fmt.Println("- Progress saved as download_state.json")
+		fmt.Println("- POST and other methods with a form or JSON body for export endpoints")
+		fmt.Println("- URL templates ({001..120}, {a..z}, {a,b,c}) expand into a group saved under --output")
+		fmt.Println("- Link extraction from HTML pages and sitemaps with --links")
+		fmt.Println("- Hugging Face repos (hf://) and Git LFS pointers download as groups checked by size and SHA-256")
+		fmt.Println("- Output templates: {date} {year} {month} {day} {time} {domain} {host} {path} {filename} {name} {ext} {type}")
+		fmt.Println("- Progress saved under ~/.mtdl/jobs (or $MTDL_HOME/jobs), resumable by job ID")
+		fmt.Println("- Downloads wait while the network is offline or metered and continue when it is back")
+		fmt.Println()
+		fmt.Println("Exit codes:")
+		fmt.Println("  0 success, 1 other error, 2 network error, 3 checksum mismatch, 4 disk full, 130 interrupted or cancelled")
 	}
 
 	// Parse command-line flags
 	flag.Parse()
+	resultPath = *resultJSON
+
+	// Only the file may reach stdout when streaming, so every message
+	// goes to stderr
+	stdout := os.Stdout
+	if *output == "-" {
+		os.Stdout = os.Stderr
+	}
 
 	// Show help if requested or if no arguments provided
 	if *showHelp || len(os.Args) == 1 {
@@ -52,6 +280,122 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Polite crawling keeps the links of a page to what robots.txt allows
+	var crawler *robots.Checker
+	if *polite {
+		if !*links || *join {
+			fmt.Println("Error: --polite crawls the links of a page, so it needs --links and cannot be used with --join")
+			os.Exit(1)
+		}
+		agent, err := downloader.ResolveUserAgent(*uaProfile, *userAgent)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		crawler = robots.NewChecker(agent)
+		crawler.IgnoreRules = *noRobots
+		crawler.MinDelay = *crawlDelay
+	} else if *noRobots || *crawlDelay != 0 {
+		fmt.Println("Error: --ignore-robots and --crawl-delay only apply with --polite")
+		os.Exit(1)
+	}
+
+	// Preview template expansion or link extraction without downloading anything
+	if *preview && (lfs.IsRepo(*url) || *lfsPointer != "") {
+		rules, err := loadRules(*rulesFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		objects, err := lfsObjects(*url, *lfsPointer, rules)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, obj := range objects {
+			fmt.Printf("%s (%d bytes) %s\n", obj.Path, obj.Size, obj.URL)
+		}
+		fmt.Printf("\n%d file(s)\n", len(objects))
+		os.Exit(0)
+	}
+	if *preview && oci.IsReference(*url) {
+		img, registry, _, err := pullManifest(*url, *platform)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		var total int64
+		for _, blob := range img.Blobs {
+			fmt.Printf("%s (%d bytes) %s\n", blob.Digest, blob.Size, registry.BlobURL(blob))
+			total += blob.Size
+		}
+		fmt.Printf("\n%d blob(s), %d bytes\n", len(img.Blobs), total)
+		os.Exit(0)
+	}
+	if *preview {
+		urls, err := groupURLs(*url, *links, *pattern, crawler)
+		if err != nil {
+			fmt.Printf("Error expanding URL: %v\n", err)
+			os.Exit(1)
+		}
+		for _, u := range urls {
+			fmt.Println(u)
+		}
+		fmt.Printf("\n%d URL(s)\n", len(urls))
+		os.Exit(0)
+	}
+
+	// A torrent's web seeds serve its file, so --url is optional with one
+	var pieces *downloader.PieceHashes
+	if *torrentSrc != "" {
+		seeds, hashes, err := loadTorrent(*torrentSrc)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *url == "" && len(seeds) == 0 {
+			fmt.Println("Error: the torrent lists no http(s) web seeds; give the file's --url")
+			os.Exit(1)
+		}
+		if *url == "" {
+			*url, seeds = seeds[0], seeds[1:]
+		}
+		for _, seed := range seeds {
+			if seed != *url && len(mirrors) < downloader.MaxMirrors {
+				mirrors = append(mirrors, seed)
+			}
+		}
+		pieces = hashes
+	}
+
+	// An ipfs:// URL is fetched from gateways, and its CID checks the file
+	ipfsRef := ""
+	if ipfs.IsRef(*url) {
+		if *torrentSrc != "" || *join || *links || *zsyncURL != "" || *output == "-" {
+			fmt.Println("Error: an ipfs:// URL is a single checked file, so it cannot be used with --torrent, --join, --links, --zsync or --output -")
+			os.Exit(1)
+		}
+		if len(gateways) == 0 {
+			gateways = ipfs.Gateways()
+		}
+		gatewayURLs, hashes, err := resolveIPFS(*url, gateways)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		ipfsRef = *url
+		*url = gatewayURLs[0]
+		for _, u := range gatewayURLs[1:] {
+			if len(mirrors) < downloader.MaxMirrors {
+				mirrors = append(mirrors, u)
+			}
+		}
+		pieces = hashes
+	} else if len(gateways) > 0 {
+		fmt.Println("Error: --ipfs-gateway needs an ipfs:// URL")
+		os.Exit(1)
+	}
+
 	// Validate required flags
 	if *url == "" || *output == "" {
 		fmt.Println("Error: Both --url and --output are required")
@@ -66,28 +410,2257 @@ func main() {
 		os.Exit(1)
 	}
 
+	resolvedUA, err := downloader.ResolveUserAgent(*uaProfile, *userAgent)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *minPart < 0 {
+		fmt.Println("Error: Minimum part size cannot be negative")
+		os.Exit(1)
+	}
+
+	if *retries < 0 {
+		fmt.Println("Error: Checksum retries cannot be negative")
+		os.Exit(1)
+	}
+
+	if *previewN < 0 {
+		fmt.Println("Error: Preview bytes cannot be negative")
+		os.Exit(1)
+	}
+
+	if *window < 1 {
+		fmt.Println("Error: Stream window must be at least 1 byte")
+		os.Exit(1)
+	}
+
+	existing, err := existingFlag(*force, *cont, *skip)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *update {
+		if existing != askExisting {
+			fmt.Println("Error: --update cannot be used with --force, --continue or --skip-existing")
+			os.Exit(1)
+		}
+		// A newer version replaces the output
+		existing = overwriteExisting
+	}
+
+	opts := downloadOptions{
+		threads:         *threads,
+		minPartSize:     *minPart,
+		sequential:      *sequential,
+		previewBytes:    *previewN,
+		checksumRetries: *retries,
+		userAgent:       resolvedUA,
+		referer:         *referer,
+		existing:        existing,
+		noNetworkWait:   *noWait,
+		allowMetered:    *metered,
+		threadsDefault:  !flagGiven(flag.CommandLine, "threads"),
+	}
+
+	if *refreshCmd != "" && *refreshURL != "" {
+		fmt.Println("Error: Only one of --refresh-command and --refresh-url can be given")
+		os.Exit(1)
+	}
+	if err := downloader.ValidateRefreshURL(*refreshURL); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.refreshCommand = *refreshCmd
+	opts.refreshURL = *refreshURL
+
+	if *bindRate < 0 {
+		fmt.Println("Error: --bind-rate-limit must be at least 0")
+		os.Exit(1)
+	}
+	if *bind != "" {
+		if _, err := downloader.BindAddrs(*bind); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	opts.bind = *bind
+	opts.bindRateLimit = *bindRate
+	opts.doneMarker = *doneMarker
+
+	if *planFile != "" || *exportPlan != "" {
+		if *links || (downloader.HasURLTemplate(*url) && !*join) || *output == "-" || *zsyncURL != "" || lfs.IsRepo(*url) || *lfsPointer != "" || oci.IsReference(*url) {
+			fmt.Println("Error: --plan and --export-plan describe the parts of a single file, not a group, a stream or a --zsync update")
+			os.Exit(1)
+		}
+	}
+	if *planFile != "" {
+		if opts.plan, err = downloader.LoadPlan(*planFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		// Resumes may run from another directory
+		opts.planFile, _ = filepath.Abs(*planFile)
+	}
+	opts.exportPlan = *exportPlan
+	opts.captureEnv = *captureEnv
+
+	if err := downloader.ValidateMirrors(*url, mirrors); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *mirrorN < 1 {
+		fmt.Println("Error: --mirrors must be at least 1")
+		os.Exit(1)
+	}
+	opts.mirrors = mirrors
+	opts.mirrorCount = *mirrorN
+	opts.pieces = pieces
+	opts.ipfs = ipfsRef
+	opts.crawler = crawler
+	if *torrentSrc != "" {
+		// Resumes may run from another directory
+		opts.torrent = *torrentSrc
+		if !torrent.IsURL(*torrentSrc) {
+			opts.torrent, _ = filepath.Abs(*torrentSrc)
+		}
+	}
+
+	if *cacheDir != "" {
+		// Resumes may run from another directory
+		opts.cacheDir, _ = filepath.Abs(*cacheDir)
+		opts.cacheSize = *cacheSize
+		opts.cacheCopy = *cacheCopy
+		if opts.cache, err = openCache(opts.cacheDir, opts.cacheSize, opts.cacheCopy); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *rulesFile != "" {
+		// Resumes may run from another directory
+		opts.rulesFile, _ = filepath.Abs(*rulesFile)
+	}
+	if opts.rules, err = loadRules(opts.rulesFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	body, err := readRequestBody(*method, *data, *dataType)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *update && (*data != "" || (*method != "" && !strings.EqualFold(*method, http.MethodGet))) {
+		fmt.Println("Error: --update only works with GET downloads")
+		os.Exit(1)
+	}
+	opts.onlyIfModified = *update
+	opts.method = *method
+	opts.body = body
+	opts.bodyType = *dataType
+
+	if *cookies != "" {
+		jar, err := downloader.LoadCookiesFile(*cookies)
+		if err != nil {
+			fmt.Printf("Error loading cookies: %v\n", err)
+			os.Exit(1)
+		}
+		opts.cookieJar = jar
+		// Resumes may run from another directory
+		opts.cookiesFile, _ = filepath.Abs(*cookies)
+	}
+
+	if *keyFile != "" {
+		key, err := downloader.LoadEncryptionKey(*keyFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.encryptionKey = key
+		opts.keyFile, _ = filepath.Abs(*keyFile)
+	}
+
 	fmt.Println("Multithreaded Downloader v1.0")
 	fmt.Println("═══════════════════════════════")
 
+	if *update && (*join || *zsyncURL != "" || *output == "-") {
+		fmt.Println("Error: --update cannot be used with --join, --zsync or --output -")
+		os.Exit(1)
+	}
+
+	if len(opts.mirrors) > 0 && (*join || *links || downloader.HasURLTemplate(*url)) {
+		fmt.Println("Error: --mirror serves a single file, not a group or joined pieces")
+		os.Exit(1)
+	}
+	if opts.pieces != nil && (*join || *links || downloader.HasURLTemplate(*url) || *zsyncURL != "" || *output == "-") {
+		fmt.Println("Error: --torrent checks a single downloaded file, so it cannot be used with --join, --links, templates, --zsync or --output -")
+		os.Exit(1)
+	}
+
+	// Hugging Face repos and LFS pointers are groups of files whose sizes
+	// and hashes are known before they are downloaded
+	if lfs.IsRepo(*url) || *lfsPointer != "" {
+		if *join || *links || *zsyncURL != "" || *output == "-" || len(opts.mirrors) > 0 || opts.pieces != nil || opts.ipfs != "" {
+			fmt.Println("Error: hf:// URLs and --lfs-pointers download a group of checked files, so they cannot be used with --join, --links, --zsync, --output -, --mirror, --torrent or ipfs://")
+			os.Exit(1)
+		}
+		if *lfsPointer != "" && lfs.IsRepo(*url) {
+			fmt.Println("Error: --lfs-pointers needs the Git repository URL as --url, not an hf:// URL")
+			os.Exit(1)
+		}
+		objects, err := lfsObjects(*url, *lfsPointer, opts.rules)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exit(exitCode(err))
+		}
+		exit(downloadObjects(objects, *output, opts))
+	}
+
+	// An oci:// or docker:// reference pulls an image or artifact into an
+	// OCI image layout, its blobs checked against their digests
+	if oci.IsReference(*url) {
+		if *join || *links || *zsyncURL != "" || *output == "-" || len(opts.mirrors) > 0 || opts.pieces != nil {
+			fmt.Println("Error: oci:// and docker:// references download a group of checked blobs, so they cannot be used with --join, --links, --zsync, --output -, --mirror or --torrent")
+			os.Exit(1)
+		}
+		exit(downloadImage(*url, *platform, *output, opts))
+	}
+	if *platform != "" {
+		fmt.Println("Error: --platform needs an oci:// or docker:// reference as --url")
+		os.Exit(1)
+	}
+
+	if *join && !*links && !downloader.HasURLTemplate(*url) {
+		fmt.Println("Error: --join needs a URL template such as file.z{01..05} or --links")
+		os.Exit(1)
+	}
+
+	if *output == "-" {
+		if *links || downloader.HasURLTemplate(*url) {
+			fmt.Println("Error: --output - streams a single file, not a group")
+			os.Exit(1)
+		}
+		if opts.encryptionKey != nil {
+			fmt.Println("Error: --encrypt-key-file cannot be used with --output -")
+			os.Exit(1)
+		}
+		exit(exitCode(streamFile(*url, stdout, *window, opts)))
+	}
+
+	if *zsyncURL != "" {
+		if *join || *links || downloader.HasURLTemplate(*url) {
+			fmt.Println("Error: --zsync updates a single file, not a group or joined pieces")
+			os.Exit(1)
+		}
+		if opts.encryptionKey != nil {
+			fmt.Println("Error: --encrypt-key-file cannot be used with --zsync")
+			os.Exit(1)
+		}
+		exit(exitCode(updateFile(*url, *zsyncURL, *seedFile, *output, opts)))
+	}
+
+	// Joined pieces make one file, not a group
+	if *join {
+		exit(exitCode(joinFile(*url, *links, *pattern, *output, opts)))
+	}
+
+	// Templates and link pages download a whole group into the output directory
+	if *links || downloader.HasURLTemplate(*url) {
+		exit(downloadGroup(*url, *links, *pattern, *output, opts))
+	}
+
+	exit(exitCode(downloadFile(*url, *output, opts)))
+}
+
+// Exit codes of a download, so scripts can tell failures apart
+const (
+	exitOK        = 0
+	exitFailure   = 1
+	exitNetwork   = 2
+	exitChecksum  = 3
+	exitDiskFull  = 4
+	exitCancelled = 130
+)
+
+// exitCode returns the exit code for the error a download ended with
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.Is(err, downloader.ErrChecksumMismatch):
+		return exitChecksum
+	case downloader.IsDiskFull(err):
+		return exitDiskFull
+	case downloader.IsNetworkError(err):
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// fileResult is the outcome of one download in the --result-json summary
+type fileResult struct {
+	URL    string `json:"url"`
+	Output string `json:"output"`
+	JobID  string `json:"job_id,omitempty"`
+	// Status is completed, not_modified, skipped, failed or cancelled
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	// Size is the size of the file and Bytes how much of it this run fetched
+	Size            int64                `json:"size"`
+	Bytes           int64                `json:"bytes"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	BytesPerSecond  float64              `json:"average_bytes_per_second"`
+	Checksum        *downloader.Checksum `json:"checksum,omitempty"`
+	// ReusedBytes is how much of the file --zsync copied from the local copy
+	ReusedBytes int64 `json:"reused_bytes,omitempty"`
+	// Cached is set when the file was placed from --cache
+	Cached bool `json:"cached,omitempty"`
+
+	started time.Time
+}
+
+// resultSummary is written to --result-json when the CLI exits
+type resultSummary struct {
+	ExitCode        int          `json:"exit_code"`
+	Bytes           int64        `json:"bytes"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	BytesPerSecond  float64      `json:"average_bytes_per_second"`
+	Files           []fileResult `json:"files"`
+}
+
+var (
+	// resultPath is where the summary is written, if anywhere
+	resultPath string
+	// results collects the outcome of every download for the summary
+	results []fileResult
+	// started is when the CLI started
+	started = time.Now()
+)
+
+// newResult starts the result of downloading url to output
+func newResult(url, output string) *fileResult {
+	return &fileResult{URL: secrets.RedactURL(url), Output: output, started: time.Now()}
+}
+
+// recordResult finishes a download's result with the error it ended with
+// and adds it to the summary
+func recordResult(res *fileResult, err error) {
+	elapsed := time.Since(res.started)
+	res.DurationSeconds = elapsed.Seconds()
+	if elapsed > 0 {
+		res.BytesPerSecond = float64(res.Bytes) / elapsed.Seconds()
+	}
+	res.ExitCode = exitCode(err)
+	switch {
+	case errors.Is(err, context.Canceled):
+		res.Status = "cancelled"
+	case err != nil:
+		res.Status = "failed"
+	case res.Status == "":
+		res.Status = "completed"
+	}
+	if err != nil {
+		res.Error = secrets.RedactText(err.Error())
+	}
+	results = append(results, *res)
+}
+
+// exit writes the --result-json summary, if asked for, and exits with code
+func exit(code int) {
+	if resultPath != "" {
+		if err := writeResults(resultPath, code); err != nil {
+			fmt.Printf("Error writing result summary: %v\n", err)
+			if code == exitOK {
+				code = exitFailure
+			}
+		}
+	}
+	os.Exit(code)
+}
+
+// writeResults writes the summary of every download to path
+func writeResults(path string, code int) error {
+	summary := resultSummary{ExitCode: code, Files: results}
+	if summary.Files == nil {
+		summary.Files = []fileResult{}
+	}
+	for _, res := range results {
+		summary.Bytes += res.Bytes
+	}
+	elapsed := time.Since(started).Seconds()
+	summary.DurationSeconds = elapsed
+	if elapsed > 0 {
+		summary.BytesPerSecond = float64(summary.Bytes) / elapsed
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// downloadOptions carries the per-download settings given on the command line
+type downloadOptions struct {
+	threads   int
+	minPartSize int64
+	// sequential fetches the file mostly in order for playing it early
+	sequential bool
+	// previewBytes of the start and end of the file are fetched first
+	previewBytes int64
+	checksumRetries int
+	userAgent string
+	referer   string
+	cookieJar http.CookieJar
+	encryptionKey []byte
+	// cookiesFile and keyFile are recorded in the registry so a resume can
+	// load them again
+	cookiesFile string
+	keyFile     string
+	// method, body and bodyType replace the plain GET when set
+	method   string
+	body     []byte
+	bodyType string
+	// existing is what to do with an output file that exists without
+	// saved progress
+	existing existingAction
+	// noNetworkWait fails downloads when the network goes away instead of
+	// waiting; allowMetered keeps them running on metered networks
+	noNetworkWait bool
+	allowMetered  bool
+	// rules are the domain rules read from rulesFile, or the default file
+	// when it is empty; threadsDefault lets them change the thread count
+	rules          []openapi.DomainRule
+	rulesFile      string
+	threadsDefault bool
+	// refreshCommand or refreshURL give a new link when the server refuses
+	// the current one as expired
+	refreshCommand string
+	refreshURL     string
+	// partURLs are the pieces joined into the output, when it is joined
+	partURLs []string
+	// onlyIfModified skips the download when the existing output is current
+	onlyIfModified bool
+	// cache, opened from cacheDir, holds files downloaded before
+	cache     *cache.Cache
+	cacheDir  string
+	cacheSize int64
+	cacheCopy bool
+	// mirrors are other URLs of the file; parts come from the fastest
+	// mirrorCount of them
+	mirrors     []string
+	mirrorCount int
+	// pieces, loaded from the torrent at torrent, check the finished file
+	pieces  *downloader.PieceHashes
+	torrent string
+	// ipfs is the ipfs:// URL whose CID made pieces instead
+	ipfs string
+	// expectedSize and expectedSHA256, of a file listed by a Hugging Face
+	// repo or a Git LFS pointer, check the file; headers, such as the
+	// authorization an LFS server handed out with its URL, are sent with
+	// it but never saved
+	expectedSize   int64
+	expectedSHA256 string
+	headers        map[string]string
+	// oci is the image reference a blob was pulled for; transport
+	// authorizes the requests to its registry
+	oci       string
+	transport func(http.RoundTripper) http.RoundTripper
+	// crawler, in --polite mode, spaces the downloads of a group by the
+	// Crawl-delay of their sites
+	crawler *robots.Checker
+	// bind is the interface or address connections are made from, sharing
+	// bindRateLimit with the other downloads bound to it
+	bind          string
+	bindRateLimit int64
+	// doneMarker writes <output>.done once a file is verified
+	doneMarker bool
+	// plan, loaded from planFile, splits the file into parts; exportPlan
+	// is where the parts are written once the file is split
+	plan       *downloader.Plan
+	planFile   string
+	exportPlan string
+	// captureEnv writes <output>.environment.json once the file is set up
+	// and again once it is verified
+	captureEnv bool
+}
+
+// existingAction is what to do with an output file that already exists
+// when there is no saved progress to resume it
+type existingAction int
+
+const (
+	// askExisting prompts on a terminal and fails otherwise
+	askExisting existingAction = iota
+	overwriteExisting
+	continueExisting
+	skipExisting
+	// refuseExisting fails without asking, for downloads nobody watches
+	refuseExisting
+)
+
+// existingFlag returns the action chosen with --force, --continue or
+// --skip-existing, of which only one may be given
+func existingFlag(force, cont, skip bool) (existingAction, error) {
+	action := askExisting
+	chosen := 0
+	if force {
+		action = overwriteExisting
+		chosen++
+	}
+	if cont {
+		action = continueExisting
+		chosen++
+	}
+	if skip {
+		action = skipExisting
+		chosen++
+	}
+	if chosen > 1 {
+		return askExisting, fmt.Errorf("only one of --force, --continue and --skip-existing can be given")
+	}
+	return action, nil
+}
+
+// readRequestBody checks the request flags and returns the body they give,
+// read from a file for @file
+func readRequestBody(method, data, dataType string) ([]byte, error) {
+	if err := downloader.ValidateMethod(method); err != nil {
+		return nil, err
+	}
+	if _, err := downloader.BodyContentType(dataType); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(data, "@") {
+		return []byte(data), nil
+	}
+	body, err := os.ReadFile(data[1:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return body, nil
+}
+
+// jobOptions returns the options a registered job is resumed with
+func (o downloadOptions) jobOptions() registry.Options {
+	return registry.Options{
+		Threads:         o.threads,
+		MinPartSize:     o.minPartSize,
+		Sequential:      o.sequential,
+		PreviewBytes:    o.previewBytes,
+		ChecksumRetries: o.checksumRetries,
+		UserAgent:       o.userAgent,
+		Referer:         o.referer,
+		CookiesFile:     o.cookiesFile,
+		KeyFile:         o.keyFile,
+		Method:          o.method,
+		Body:            o.body,
+		BodyType:        o.bodyType,
+		NoNetworkWait:   o.noNetworkWait,
+		AllowMetered:    o.allowMetered,
+		RulesFile:       o.rulesFile,
+		ThreadsDefault:  o.threadsDefault,
+		RefreshCommand:  o.refreshCommand,
+		RefreshURL:      o.refreshURL,
+		PartURLs:        o.partURLs,
+		CacheDir:        o.cacheDir,
+		CacheSize:       o.cacheSize,
+		CacheCopy:       o.cacheCopy,
+		OnlyIfModified:  o.onlyIfModified,
+		Mirrors:         o.mirrors,
+		MirrorCount:     o.mirrorCount,
+		Torrent:         o.torrent,
+		IPFS:            o.ipfs,
+		ExpectedSize:    o.expectedSize,
+		ExpectedSHA256:  o.expectedSHA256,
+		OCI:             o.oci,
+		Bind:            o.bind,
+		BindRateLimit:   o.bindRateLimit,
+		DoneMarker:      o.doneMarker,
+		PlanFile:        o.planFile,
+		CaptureEnv:      o.captureEnv,
+	}
+}
+
+// optionsFromJob rebuilds the download options of a registered job,
+// loading its cookies and encryption key from their files again
+func optionsFromJob(job *registry.Job) (downloadOptions, error) {
+	o := job.Options
+	opts := downloadOptions{
+		threads:         o.Threads,
+		minPartSize:     o.MinPartSize,
+		sequential:      o.Sequential,
+		previewBytes:    o.PreviewBytes,
+		checksumRetries: o.ChecksumRetries,
+		userAgent:       o.UserAgent,
+		referer:         o.Referer,
+		cookiesFile:     o.CookiesFile,
+		keyFile:         o.KeyFile,
+		method:          o.Method,
+		body:            o.Body,
+		bodyType:        o.BodyType,
+		noNetworkWait:   o.NoNetworkWait,
+		allowMetered:    o.AllowMetered,
+		rulesFile:       o.RulesFile,
+		threadsDefault:  o.ThreadsDefault,
+		refreshCommand:  o.RefreshCommand,
+		refreshURL:      o.RefreshURL,
+		partURLs:        o.PartURLs,
+		cacheDir:        o.CacheDir,
+		cacheSize:       o.CacheSize,
+		cacheCopy:       o.CacheCopy,
+		onlyIfModified:  o.OnlyIfModified,
+		mirrors:         o.Mirrors,
+		mirrorCount:     o.MirrorCount,
+		torrent:         o.Torrent,
+		ipfs:            o.IPFS,
+		expectedSize:    o.ExpectedSize,
+		expectedSHA256:  o.ExpectedSHA256,
+		oci:             o.OCI,
+		bind:            o.Bind,
+		bindRateLimit:   o.BindRateLimit,
+		doneMarker:      o.DoneMarker,
+		planFile:        o.PlanFile,
+		captureEnv:      o.CaptureEnv,
+	}
+	rules, err := loadRules(o.RulesFile)
+	if err != nil {
+		return opts, err
+	}
+	opts.rules = rules
+	if o.PlanFile != "" {
+		if opts.plan, err = downloader.LoadPlan(o.PlanFile); err != nil {
+			return opts, err
+		}
+	}
+	if o.CookiesFile != "" {
+		jar, err := downloader.LoadCookiesFile(o.CookiesFile)
+		if err != nil {
+			return opts, fmt.Errorf("failed to load cookies: %w", err)
+		}
+		opts.cookieJar = jar
+	}
+	if o.KeyFile != "" {
+		key, err := downloader.LoadEncryptionKey(o.KeyFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.encryptionKey = key
+	}
+	if o.CacheDir != "" {
+		if opts.cache, err = openCache(o.CacheDir, o.CacheSize, o.CacheCopy); err != nil {
+			return opts, err
+		}
+	}
+	if o.Torrent != "" {
+		if _, opts.pieces, err = loadTorrent(o.Torrent); err != nil {
+			return opts, err
+		}
+	}
+	if o.IPFS != "" {
+		// The gateways are those the download started with
+		var gateways []string
+		for _, u := range append([]string{job.URL}, o.Mirrors...) {
+			if gateway, ok := ipfs.GatewayOf(u); ok {
+				gateways = append(gateways, gateway)
+			}
+		}
+		if _, opts.pieces, err = resolveIPFS(o.IPFS, gateways); err != nil {
+			return opts, err
+		}
+	}
+	if o.OCI != "" {
+		// Tokens are exchanged again, as the old ones have likely expired
+		ref, err := oci.ParseReference(o.OCI)
+		if err != nil {
+			return opts, err
+		}
+		transport, err := oci.NewTransport(ref, http.DefaultTransport)
+		if err != nil {
+			return opts, err
+		}
+		opts.transport = transport.Wrap
+	}
+	return opts, nil
+}
+
+// resolveIPFS resolves an ipfs:// URL through gateways and returns the
+// URLs each gateway serves the file at and the pieces its CID checks
+func resolveIPFS(rawURL string, gateways []string) ([]string, *downloader.PieceHashes, error) {
+	ref, err := ipfs.ParseRef(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	file, err := ipfs.NewResolver(gateways).Resolve(ctx, ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve %s: %w", rawURL, err)
+	}
+	fmt.Printf("IPFS %s: %d bytes in %d blocks, %d gateways\n", file.CID, file.Size, len(file.Leaves), len(gateways))
+	urls := make([]string, len(gateways))
+	for i, gateway := range gateways {
+		urls[i] = ipfs.GatewayURL(gateway, file)
+	}
+	pieces := &downloader.PieceHashes{
+		Size:      file.Size,
+		Ends:      file.Ends(),
+		Match:     file.Check,
+		Algorithm: "sha-256",
+		Source:    "IPFS CID",
+	}
+	return urls, pieces, nil
+}
+
+// loadTorrent reads the torrent at source and returns the URLs its web
+// seeds serve the file at and its piece hashes. Only single-file torrents
+// can be downloaded.
+func loadTorrent(source string) ([]string, *downloader.PieceHashes, error) {
+	meta, err := torrent.Load(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !meta.SingleFile() {
+		return nil, nil, fmt.Errorf("torrent %s holds %d files; only single-file torrents can be downloaded", meta.Name, len(meta.Files))
+	}
+	seeds := meta.WebSeedURLs()
+	fmt.Printf("Torrent %s (%x): %d bytes in %d pieces, %d web seeds\n", meta.Name, meta.InfoHash, meta.Length, len(meta.Pieces), len(seeds))
+	pieces := &downloader.PieceHashes{
+		Length: meta.PieceLength,
+		Size:   meta.Length,
+		SHA1:   meta.Pieces,
+		Source: "torrent",
+	}
+	return seeds, pieces, nil
+}
+
+// openCache opens the download cache in dir
+func openCache(dir string, size int64, copy bool) (*cache.Cache, error) {
+	c, err := cache.Open(dir, size)
+	if err != nil {
+		return nil, err
+	}
+	c.Copy = copy
+	return c, nil
+}
+
+// newDownloader creates a downloader configured from the options and the
+// domain rules matching url
+func newDownloader(url, output string, opts downloadOptions) (*downloader.Downloader, error) {
+	profile := domainrules.Resolve(opts.rules, url)
+	threads := opts.threads
+	if opts.threadsDefault && profile.Threads > 0 {
+		threads = profile.Threads
+	}
+	var dl *downloader.Downloader
+	if len(opts.partURLs) > 0 {
+		dl = downloader.NewJoinedDownloader(opts.partURLs, output, threads)
+	} else {
+		dl = downloader.NewDownloader(url, output, threads)
+	}
+	dl.MinPartSize = opts.minPartSize
+	dl.Sequential = opts.sequential
+	dl.PreviewBytes = opts.previewBytes
+	dl.ChecksumRetries = opts.checksumRetries
+	dl.UserAgent = opts.userAgent
+	dl.Referer = opts.referer
+	dl.CookieJar = opts.cookieJar
+	dl.EncryptionKey = opts.encryptionKey
+	if opts.cache != nil {
+		dl.Cache = opts.cache
+	}
+	dl.OnlyIfModified = opts.onlyIfModified
+	dl.Mirrors = opts.mirrors
+	dl.MirrorCount = opts.mirrorCount
+	dl.Pieces = opts.pieces
+	dl.ExpectedSize = opts.expectedSize
+	if opts.expectedSHA256 != "" {
+		source := "LFS oid"
+		if opts.oci != "" {
+			source = "image digest"
+		}
+		digest, err := downloader.NewDigest("sha-256", opts.expectedSHA256, source)
+		if err != nil {
+			return nil, err
+		}
+		dl.ExpectedDigests = []downloader.Digest{digest}
+	}
+	dl.WrapTransport = opts.transport
+	dl.Bind = opts.bind
+	dl.BindRateLimit = opts.bindRateLimit
+	dl.WriteDoneMarker = opts.doneMarker
+	dl.CaptureEnvironment = opts.captureEnv
+	dl.Plan = opts.plan
+	// Domain rules only add the headers the file does not set
+	for name, value := range opts.headers {
+		if dl.Headers == nil {
+			dl.Headers = make(map[string]string)
+		}
+		dl.Headers[name] = value
+	}
+	if err := dl.SetRequest(opts.method, opts.body, opts.bodyType); err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.refreshCommand != "":
+		dl.RefreshLink = downloader.CommandRefresher(opts.refreshCommand)
+	case opts.refreshURL != "":
+		dl.RefreshLink = downloader.EndpointRefresher(opts.refreshURL)
+	}
+	if len(profile.Matched) > 0 {
+		if err := profile.Apply(dl); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Applying domain rules: %s\n", strings.Join(profile.Matched, ", "))
+	}
+	return dl, nil
+}
+
+// loadRules reads the domain rules in path, or in the default rules file
+// when path is empty
+func loadRules(path string) ([]openapi.DomainRule, error) {
+	if path == "" {
+		var err error
+		if path, err = domainrules.DefaultFile(); err != nil {
+			return nil, err
+		}
+	}
+	return domainrules.Load(path)
+}
+
+// flagGiven reports whether the flag name was set on the command line
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// downloadFile runs a single download from start to verification,
+// reporting any failure to the user before returning it
+func downloadFile(url, output string, opts downloadOptions) error {
+	res := newResult(url, output)
+	err := fetchFile(url, output, opts, res)
+	recordResult(res, err)
+	return err
+}
+
+// fetchFile resolves the output and job of a download and runs it
+func fetchFile(url, output string, opts downloadOptions, res *fileResult) error {
 	// Create downloader instance
-	dl := downloader.NewDownloader(*url, *output, *threads)
+	dl, err := newDownloader(url, output, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+
+	// Expand {date}/{domain}/{filename} style output templates
+	if err := dl.ResolveOutputTemplate(nil); err != nil {
+		fmt.Printf("Error resolving output template: %v\n", err)
+		return err
+	}
+	if err := os.MkdirAll(downloader.LongPath(filepath.Dir(dl.Filename)), 0755); err != nil {
+		fmt.Printf("Error creating output directory: %v\n", err)
+		return err
+	}
+
+	// Pick up the job of an earlier run of the same download
+	reg := openRegistry()
+	job, err := registerJob(reg, url, dl.Filename, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+
+	return runDownload(context.Background(), dl, reg, job, opts, res)
+}
+
+// streamFile downloads url to w in order. Streams are not registered as
+// jobs: nothing is saved, so there is nothing to resume.
+func streamFile(url string, w io.Writer, window int64, opts downloadOptions) error {
+	res := newResult(url, "-")
+	err := runStream(url, w, window, opts, res)
+	recordResult(res, err)
+	return err
+}
+
+// runStream streams a download and notes what was fetched in res
+func runStream(url string, w io.Writer, window int64, opts downloadOptions, res *fileResult) error {
+	dl, err := newDownloader(url, "-", opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = dl.Stream(ctx, w, window)
+	if dl.Progress != nil {
+		res.Size = dl.Progress.TotalSize
+		res.Bytes = dl.Progress.GetTotalDownloaded()
+		res.Checksum = dl.Progress.Checksum
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("Stream interrupted")
+		} else {
+			fmt.Printf("Error during download: %v\n", err)
+		}
+		return err
+	}
+	fmt.Printf("Streamed %d bytes\n", res.Bytes)
+	return nil
+}
+
+// openRegistry opens the local job registry. Downloads still work when it
+// cannot be opened, they just cannot be resumed by ID.
+func openRegistry() *registry.Registry {
+	dir, err := registry.DefaultDir()
+	if err == nil {
+		var reg *registry.Registry
+		if reg, err = registry.Open(dir); err == nil {
+			return reg
+		}
+	}
+	fmt.Printf("Warning: job registry unavailable, progress is saved in the current directory: %v\n", err)
+	return nil
+}
+
+// registerJob returns the unfinished job for the same URL and output, or a
+// new one. It refuses to start a download another process is running.
+func registerJob(reg *registry.Registry, url, output string, opts downloadOptions) (*registry.Job, error) {
+	if reg == nil {
+		return nil, nil
+	}
+	job, err := reg.Find(url, output)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return reg.New(url, output, opts.jobOptions())
+	}
+	if job.Running() {
+		return nil, fmt.Errorf("%s is already being downloaded by process %d (job %s)", output, job.PID, job.ShortID())
+	}
+	job.Options = opts.jobOptions()
+	return job, nil
+}
+
+// runDownload downloads and verifies a file, recording the outcome in its
+// job. An interrupt, or the end of ctx, stops the download with its progress
+// saved.
+func runDownload(ctx context.Context, dl *downloader.Downloader, reg *registry.Registry, job *registry.Job, opts downloadOptions, res *fileResult) error {
+	res.Output = dl.Filename
+	if job != nil {
+		dl.ProgressFile = job.ProgressFile
+		res.JobID = job.ShortID()
+	}
+	// An output that is the current version needs no download
+	if err := dl.CheckModified(ctx); errors.Is(err, downloader.ErrNotModified) {
+		fmt.Printf("%s is up to date, skipping the download\n", dl.Filename)
+		res.Status = string(lifecycle.NotModified)
+		if job != nil {
+			if err := updateJob(reg, job, lifecycle.NotModified, nil); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+		return nil
+	} else if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+
+	skip, err := prepareOutput(dl, opts.existing)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+	if skip {
+		fmt.Printf("Skipping %s, it already exists\n", dl.Filename)
+		res.Status = "skipped"
+		return nil
+	}
+
+	if job != nil {
+		job.PID = os.Getpid()
+		if err := updateJob(reg, job, lifecycle.Downloading, nil); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		fmt.Printf("Job ID: %s\n", job.ShortID())
+	}
 
+	// Show downloads waiting for the network in the job list
+	setStatus := func(status lifecycle.Status) {
+		if job != nil {
+			if err := updateJob(reg, job, status, nil); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+	err = transferFile(ctx, dl, res, opts, setStatus)
+	if job != nil {
+		status := lifecycle.Completed
+		if err != nil {
+			// Only downloads with saved progress can be resumed
+			status = lifecycle.Failed
+			if _, statErr := os.Stat(dl.ProgressFile); statErr == nil {
+				status = lifecycle.Paused
+			}
+		}
+		reason := err
+		if errors.Is(err, context.Canceled) {
+			reason = errors.New("interrupted")
+		}
+		job.PID = 0
+		if updateErr := updateJob(reg, job, status, reason); updateErr != nil {
+			fmt.Printf("Warning: %v\n", updateErr)
+		}
+		if status == lifecycle.Paused {
+			fmt.Printf("Resume the download with: %s resume %s\n", os.Args[0], job.ShortID())
+		}
+	}
+	return err
+}
+
+// prepareOutput applies the action for an output file that exists without
+// saved progress to resume it, asking for one on a terminal. It reports
+// whether the file is skipped.
+func prepareOutput(dl *downloader.Downloader, action existingAction) (bool, error) {
+	stat, err := os.Stat(dl.Filename)
+	if err != nil || !stat.Mode().IsRegular() || dl.HasProgress() {
+		return false, nil
+	}
+
+	if action == askExisting || action == refuseExisting {
+		if action == refuseExisting || !isTerminal(os.Stdin) {
+			return false, fmt.Errorf("%s already exists; use --force to overwrite it, --continue to resume it or --skip-existing to keep it", dl.Filename)
+		}
+		if action, err = askExistingAction(dl.Filename, stat.Size()); err != nil {
+			return false, err
+		}
+	}
+
+	switch action {
+	case skipExisting:
+		return true, nil
+	case continueExisting:
+		dl.Continue = true
+	case overwriteExisting:
+		if err := os.Remove(dl.Filename); err != nil {
+			return false, fmt.Errorf("failed to remove %s: %w", dl.Filename, err)
+		}
+	}
+	return false, nil
+}
+
+// stdin reads the answers to prompts
+var stdin = bufio.NewReader(os.Stdin)
+
+// askExistingAction asks whether to overwrite, resume or skip an existing file
+func askExistingAction(filename string, size int64) (existingAction, error) {
+	fmt.Printf("%s already exists (%d bytes) and has no saved progress.\n", filename, size)
+	for {
+		fmt.Print("[o]verwrite, [r]esume from its end or [s]kip? ")
+		answer, err := stdin.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o", "overwrite":
+			return overwriteExisting, nil
+		case "r", "resume", "c", "continue":
+			return continueExisting, nil
+		case "s", "skip":
+			return skipExisting, nil
+		}
+		if err != nil {
+			fmt.Println()
+			return askExisting, fmt.Errorf("no answer for %s (%v); use --force, --continue or --skip-existing", filename, err)
+		}
+	}
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// transferFile loads or creates the progress, downloads and verifies the
+// file, noting what was fetched in res. setStatus is told when the download
+// waits for the network and when it continues.
+func transferFile(ctx context.Context, dl *downloader.Downloader, res *fileResult, opts downloadOptions, setStatus func(lifecycle.Status)) error {
 	// Load or create progress
 	if err := dl.LoadOrCreateProgress(); err != nil {
 		fmt.Printf("Error initializing download: %v\n", err)
-		os.Exit(1)
+		return err
 	}
+	if opts.exportPlan != "" {
+		if err := downloader.SavePlan(opts.exportPlan, dl.ExportPlan()); err != nil {
+			fmt.Printf("Error writing part plan: %v\n", err)
+			return err
+		}
+		fmt.Printf("Part plan written to %s\n", opts.exportPlan)
+	}
+	writeEnvironment(dl)
+	before := dl.Progress.GetTotalDownloaded()
+	res.Size = dl.Progress.TotalSize
+	defer func() {
+		res.Bytes = dl.Progress.GetTotalDownloaded() - before
+		res.Checksum = dl.Progress.Checksum
+		if dl.Cached() {
+			// Nothing was fetched
+			res.Bytes = 0
+			res.Cached = true
+		}
+	}()
+
+	// Stop on Ctrl-C or a cancel with the progress saved
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start the download
-	if err := dl.Download(); err != nil {
-		fmt.Printf("Error during download: %v\n", err)
-		os.Exit(1)
+	if err := downloadWhileOnline(ctx, dl, opts, setStatus); err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("\nDownload interrupted, progress saved.")
+		} else {
+			fmt.Printf("Error during download: %v\n", err)
+		}
+		return err
 	}
 
 	// Verify download completion
 	if err := dl.VerifyDownload(); err != nil {
 		fmt.Printf("⚠️  %v\n", err)
-		fmt.Println("Run the same command again to resume the download.")
+		return err
+	}
+	writeEnvironment(dl)
+
+	return nil
+}
+
+// writeEnvironment writes the environment of a download that captures it
+// next to the output. A resumed download keeps what the run that started
+// it learned from its probe.
+func writeEnvironment(dl *downloader.Downloader) {
+	env := dl.Environment()
+	if env == nil || dl.Filename == "" || dl.Filename == "-" {
+		return
+	}
+	path := downloader.EnvironmentFile(dl.Filename)
+	if env.Resumed {
+		if prev, err := downloader.LoadEnvironment(path); err == nil {
+			env.Inherit(prev)
+		}
+	}
+	if err := downloader.SaveEnvironment(path, env); err != nil {
+		fmt.Printf("Warning: could not write %s: %v\n", path, err)
+	}
+}
+
+// downloadWhileOnline runs the download, stopping it with its progress
+// saved while the network is offline, or metered unless that is allowed,
+// and continuing it once the network is usable again. A download that fails
+// is also continued when the network turns out to have gone away.
+func downloadWhileOnline(ctx context.Context, dl *downloader.Downloader, opts downloadOptions, setStatus func(lifecycle.Status)) error {
+	if opts.noNetworkWait {
+		return dl.DownloadContext(ctx)
+	}
+	usable := func(state netwatch.State) bool {
+		return state.Usable(opts.allowMetered)
+	}
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	host := ""
+	if parsed, err := neturl.Parse(dl.URL); err == nil {
+		host = parsed.Hostname()
+	}
+	states := netwatch.Watch(watchCtx, netwatch.DefaultInterval, netwatch.Probe(host))
+	state := <-states
+
+	for {
+		if !usable(state) {
+			fmt.Printf("\nNetwork %s, waiting for it to continue the download...\n", state)
+			setStatus(lifecycle.WaitingNetwork)
+			for !usable(state) {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case state = <-states:
+				}
+			}
+			fmt.Println("Network back, continuing the download")
+			setStatus(lifecycle.Downloading)
+		}
+
+		runCtx, stop := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- dl.DownloadContext(runCtx) }()
+		var err error
+		for running := true; running; {
+			select {
+			case err = <-done:
+				running = false
+			case state = <-states:
+				if !usable(state) {
+					stop()
+				}
+			}
+		}
+		stop()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		// A failure can come before the next check notices the network is
+		// gone, so give the check one more round
+		if usable(state) {
+			select {
+			case state = <-states:
+			case <-time.After(netwatch.DefaultInterval + time.Second):
+			}
+			if usable(state) {
+				return err
+			}
+		}
+	}
+}
+
+// updateJob records a job's status and the error that ended it
+func updateJob(reg *registry.Registry, job *registry.Job, status lifecycle.Status, err error) error {
+	job.Status = status
+	job.Error = ""
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if err := reg.Save(job); err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ShortID(), err)
+	}
+	return nil
+}
+
+// groupURLs returns the URLs a group download covers, either by extracting
+// links from a page or by expanding a URL template. With a crawler the page
+// and its links are checked against robots.txt.
+func groupURLs(url string, links bool, pattern string, crawler *robots.Checker) ([]string, error) {
+	if !links {
+		return downloader.ExpandURLTemplate(url)
+	}
+	if crawler == nil {
+		return downloader.ExtractLinks(url, pattern)
+	}
+	return politeLinks(url, pattern, crawler)
+}
+
+// politeLinks extracts the links of a page that robots.txt lets the crawler
+// fetch, leaving out the rest
+func politeLinks(url, pattern string, crawler *robots.Checker) ([]string, error) {
+	ctx := context.Background()
+	if ok, err := crawler.Allowed(ctx, url); !ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("robots.txt disallows the page; --ignore-robots overrides it")
+	}
+	if err := crawler.Wait(ctx, url); err != nil {
+		return nil, err
+	}
+	links, err := downloader.ExtractLinks(url, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []string
+	for _, link := range links {
+		ok, err := crawler.Allowed(ctx, link)
+		if !ok {
+			reason := "disallowed by robots.txt"
+			if err != nil {
+				reason = err.Error()
+			}
+			fmt.Printf("Skipping %s: %s\n", link, reason)
+			continue
+		}
+		allowed = append(allowed, link)
+	}
+	if skipped := len(links) - len(allowed); skipped > 0 {
+		fmt.Printf("Skipped %d of %d links for robots.txt\n", skipped, len(links))
+	}
+	return allowed, nil
+}
+
+// updateFile updates output from a local copy of the file with the zsync
+// control file at controlURL, fetching only the blocks that changed. A
+// server that ignores ranges gets the whole file downloaded instead.
+func updateFile(url, controlURL, seed, output string, opts downloadOptions) error {
+	res := newResult(url, output)
+	err := runUpdate(url, controlURL, seed, output, opts, res)
+	if errors.Is(err, zsync.ErrNoRanges) {
+		fmt.Println("Server does not support range requests, downloading the whole file")
+		// The local copy is the old version being replaced
+		opts.existing = overwriteExisting
+		return downloadFile(url, output, opts)
+	}
+	recordResult(res, err)
+	return err
+}
+
+// runUpdate runs a zsync update and notes what was reused and fetched in res
+func runUpdate(url, controlURL, seed, output string, opts downloadOptions, res *fileResult) error {
+	if controlURL == "auto" {
+		controlURL = url + ".zsync"
+	}
+	if seed == "" {
+		seed = output
+	}
+	dl, err := newDownloader(url, output, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	zopts := zsync.Options{Prepare: dl.PrepareRequest, Threads: dl.NumThreads}
+	control, err := zsync.FetchControl(ctx, controlURL, zopts)
+	if err != nil {
+		fmt.Printf("Error fetching zsync control file: %v\n", err)
+		return err
+	}
+	fileURL, err := control.FileURL(controlURL)
+	if err != nil {
+		// Without a URL header the file is where --url points
+		fileURL = url
+	}
+	fmt.Printf("Updating %s from %s (%d blocks of %d bytes)\n", output, seed, len(control.Blocks), control.BlockSize)
+	result, err := zsync.Update(ctx, control, fileURL, seed, output, zopts)
+	res.Size = result.Length
+	res.Bytes = result.Downloaded
+	res.ReusedBytes = result.Reused
+	if err != nil {
+		if !errors.Is(err, zsync.ErrNoRanges) {
+			fmt.Printf("Error updating file: %v\n", err)
+		}
+		return err
+	}
+	fmt.Printf("Reused %d bytes (%d of %d blocks), downloaded %d bytes\n", result.Reused, result.BlocksReused, result.Blocks, result.Downloaded)
+	fmt.Printf("Updated %s\n", output)
+	return nil
+}
+
+// joinFile resolves the URLs of a template or page and downloads them as
+// the pieces of one file at output
+func joinFile(url string, links bool, pattern, output string, opts downloadOptions) error {
+	urls, err := groupURLs(url, links, pattern, nil)
+	if err != nil {
+		fmt.Printf("Error expanding URL: %v\n", err)
+		return err
+	}
+	if len(urls) == 0 {
+		err := errors.New("no pieces to join")
+		fmt.Printf("Error: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("Joining %d pieces into %s\n", len(urls), output)
+	opts.partURLs = urls
+	return downloadFile(urls[0], output, opts)
+}
+
+// downloadGroup resolves the group's URLs and downloads each file in turn.
+// It returns the exit code of the first failure.
+func downloadGroup(url string, links bool, pattern, outputDir string, opts downloadOptions) int {
+	urls, err := groupURLs(url, links, pattern, opts.crawler)
+	if err != nil {
+		fmt.Printf("Error expanding URL: %v\n", err)
+		return exitCode(err)
+	}
+
+	if len(urls) == 0 {
+		fmt.Println("No URLs to download")
+		return exitOK
+	}
+
+	// Templated directories are created per file once they are expanded
+	if !downloader.HasOutputTemplate(outputDir) {
+		if err := os.MkdirAll(downloader.LongPath(outputDir), 0755); err != nil {
+			fmt.Printf("Error creating output directory: %v\n", err)
+			return exitCode(err)
+		}
+	}
+
+	outputs := downloader.GroupOutputPaths(outputDir, urls)
+	files := make([]groupFile, len(urls))
+	for i, u := range urls {
+		files[i] = groupFile{url: u, output: outputs[i], opts: opts}
+	}
+	return downloadFiles(files)
+}
+
+// groupFile is a file of a group download and the options it is
+// downloaded with
+type groupFile struct {
+	url    string
+	output string
+	opts   downloadOptions
+}
+
+// downloadFiles downloads the files of a group in turn and returns the
+// exit code of the first failure
+func downloadFiles(files []groupFile) int {
+	attempted, failed := 0, 0
+	code := exitOK
+	for i, file := range files {
+		fmt.Printf("\n[%d/%d] %s -> %s\n", i+1, len(files), file.url, file.output)
+		attempted++
+		// Polite crawls space their requests by each site's Crawl-delay
+		if file.opts.crawler != nil {
+			file.opts.crawler.Wait(context.Background(), file.url)
+		}
+		if err := downloadFile(file.url, file.output, file.opts); err != nil {
+			failed++
+			if code == exitOK {
+				code = exitCode(err)
+			}
+			// An interrupt stops the whole group
+			if errors.Is(err, context.Canceled) {
+				code = exitCancelled
+				break
+			}
+		}
+	}
+
+	fmt.Printf("\nGroup finished: %d succeeded, %d failed\n", attempted-failed, failed)
+	if attempted < len(files) {
+		fmt.Printf("%d not started\n", len(files)-attempted)
+	}
+	return code
+}
+
+// lfsObjects lists the files of the Hugging Face repo at an hf:// URL, or
+// asks the LFS server of the Git repository at repoURL for the objects of
+// the pointers at pointers. The batch request sends the headers of the
+// domain rules matching repoURL, for private repositories.
+func lfsObjects(repoURL, pointers string, rules []openapi.DomainRule) ([]lfs.Object, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if lfs.IsRepo(repoURL) {
+		repo, err := lfs.ParseRepo(repoURL)
+		if err != nil {
+			return nil, err
+		}
+		objects, err := lfs.NewHub().Objects(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Hugging Face %s %s@%s: %d files\n", strings.TrimSuffix(repo.Kind, "s"), repo.ID, repo.Revision, len(objects))
+		return objects, nil
+	}
+
+	objects, err := lfs.ReadPointers(pointers)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no Git LFS pointers in %s", pointers)
+	}
+	header := domainrules.Resolve(rules, repoURL).Headers
+	client := &http.Client{Timeout: 60 * time.Second}
+	if objects, err = lfs.Batch(ctx, client, repoURL, header, objects); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Git LFS %s: %d objects\n", lfs.Endpoint(repoURL), len(objects))
+	return objects, nil
+}
+
+// downloadObjects downloads the objects of a repo into outputDir at their
+// paths in it, checking each against its size and SHA-256. It returns the
+// exit code of the first failure.
+func downloadObjects(objects []lfs.Object, outputDir string, opts downloadOptions) int {
+	if len(objects) == 0 {
+		fmt.Println("No files to download")
+		return exitOK
+	}
+	files := make([]groupFile, len(objects))
+	for i, obj := range objects {
+		output := filepath.Join(outputDir, filepath.FromSlash(obj.Path))
+		if err := os.MkdirAll(downloader.LongPath(filepath.Dir(output)), 0755); err != nil {
+			fmt.Printf("Error creating output directory: %v\n", err)
+			return exitCode(err)
+		}
+		files[i] = groupFile{url: obj.URL, output: output, opts: opts}
+		files[i].opts.expectedSize = obj.Size
+		files[i].opts.expectedSHA256 = obj.SHA256
+		files[i].opts.headers = obj.Header
+	}
+	return downloadFiles(files)
+}
+
+// pullManifest resolves an image reference to the manifest of platform,
+// authorized by a transport with the docker login saved for its registry
+func pullManifest(rawRef, platform string) (*oci.Image, *oci.Registry, *oci.Transport, error) {
+	ref, err := oci.ParseReference(rawRef)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p, err := oci.ParsePlatform(platform)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	transport, err := oci.NewTransport(ref, http.DefaultTransport)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	registry := oci.NewRegistry(ref, transport)
+	img, err := registry.Pull(ctx, p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fmt.Printf("%s: %s, %d blobs\n", ref, img.Manifest.Digest, len(img.Blobs))
+	return img, registry, transport, nil
+}
+
+// downloadImage pulls the image at rawRef into the OCI image layout at
+// outputDir. Blobs the layout already holds are skipped, the others are
+// downloaded in ranges through the registry's token exchange and checked
+// against their digests. The image is added to index.json once all its
+// blobs are there. It returns the exit code of the first failure.
+func downloadImage(rawRef, platform, outputDir string, opts downloadOptions) int {
+	img, registry, transport, err := pullManifest(rawRef, platform)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitCode(err)
+	}
+	var files []groupFile
+	for _, blob := range img.Blobs {
+		if blob.Algorithm() != "sha256" {
+			fmt.Printf("Error: %s cannot be checked, only sha256 blobs are supported\n", blob.Digest)
+			return exitFailure
+		}
+		if oci.HasBlob(outputDir, blob) {
+			fmt.Printf("Already have %s\n", blob.Digest)
+			continue
+		}
+		output := oci.BlobPath(outputDir, blob)
+		if err := os.MkdirAll(downloader.LongPath(filepath.Dir(output)), 0755); err != nil {
+			fmt.Printf("Error creating output directory: %v\n", err)
+			return exitCode(err)
+		}
+		file := groupFile{url: registry.BlobURL(blob), output: output, opts: opts}
+		file.opts.expectedSize = blob.Size
+		file.opts.expectedSHA256 = blob.Hex()
+		file.opts.oci = img.Ref.String()
+		file.opts.transport = transport.Wrap
+		files = append(files, file)
+	}
+	if len(files) > 0 {
+		if code := downloadFiles(files); code != exitOK {
+			return code
+		}
+	}
+	if err := oci.WriteLayout(outputDir, img); err != nil {
+		fmt.Printf("Error writing the image layout: %v\n", err)
+		return exitCode(err)
+	}
+	fmt.Printf("%s written to the image layout in %s\n", img.Ref, outputDir)
+	return exitOK
+}
+
+// runKeygen prints a new random encryption key
+func runKeygen() {
+	key, err := downloader.GenerateEncryptionKey()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(key)
+}
+
+// runDecrypt decrypts a file downloaded with --encrypt-key-file
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	input := fs.String("input", "", "Encrypted file to read")
+	output := fs.String("output", "", "Where to write the decrypted file")
+	keyFile := fs.String("key-file", "", "File containing the encryption key")
+	fs.Parse(args)
+
+	if *input == "" || *output == "" || *keyFile == "" {
+		fmt.Println("Error: --input, --output and --key-file are required")
+		os.Exit(1)
+	}
+
+	key, err := downloader.LoadEncryptionKey(*keyFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := downloader.DecryptFile(key, *input, *output); err != nil {
+		fmt.Printf("Error decrypting %s: %v\n", *input, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Decrypted %s to %s\n", *input, *output)
+}
+
+// runServe serves a download directory over HTTP, decrypting encrypted files on the fly
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory to serve")
+	addr := fs.String("addr", ":8090", "Address to listen on, or unix:/path for a Unix socket only you can use")
+	keyFile := fs.String("key-file", "", "File containing the encryption key; keep it outside --dir")
+	allowPlaintext := fs.Bool("allow-plaintext", false, "Also serve files that are not encrypted")
+	fs.Parse(args)
+
+	if *keyFile == "" {
+		fmt.Println("Error: --key-file is required")
+		os.Exit(1)
+	}
+
+	key, err := downloader.LoadEncryptionKey(*keyFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ln, err := listener.Open(*addr, 0600)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Serving %s on %s (encrypted files are decrypted on the fly)\n", *dir, listener.Describe(ln))
+	if err := http.Serve(ln, downloader.NewDecryptingFileServer(*dir, key, downloader.ServeOptions{
+		AllowPlaintext: *allowPlaintext,
+		Exclude:        []string{*keyFile},
+	})); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runRelay downloads files for the machines of a LAN and relays them the
+// verified parts while they download, until interrupted
+func runRelay(args []string) {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory the relayed files are downloaded into and kept in")
+	addr := fs.String("addr", ":8091", "Address to listen on, or unix:/path for a Unix socket only you can use")
+	allow := fs.String("allow", "", "Comma-separated hosts whose files are relayed; .example.com for subdomains, * for any")
+	threads := fs.Int("threads", 4, "Number of download threads")
+	rulesFile := fs.String("rules", "", "Domain rules file with per-host defaults (default ~/.mtdl/rules.yaml)")
+	fs.Parse(args)
+
+	if *allow == "" {
+		fmt.Println("Error: --allow is required, so the relay does not fetch whatever its clients ask for")
+		os.Exit(1)
+	}
+	rules, err := loadRules(*rulesFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts := downloadOptions{
+		threads:         *threads,
+		minPartSize:     downloader.DefaultMinPartSize,
+		checksumRetries: downloader.DefaultChecksumRetries,
+		rules:           rules,
+		rulesFile:       *rulesFile,
+		threadsDefault:  !flagGiven(fs, "threads"),
+	}
+	var hosts []string
+	for _, host := range strings.Split(*allow, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	ln, err := listener.Open(*addr, 0600)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	r := relay.New(*dir, relay.Options{
+		Allow: hosts,
+		NewDownloader: func(url, output string) (*downloader.Downloader, error) {
+			return newDownloader(url, output, opts)
+		},
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+		},
+	})
+	server := &http.Server{Handler: r}
+	go server.Serve(ln)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Relaying files of %s from %s on %s, as %s?url=<url>\n", strings.Join(hosts, ", "), *dir, listener.Describe(ln), relay.FetchPath)
+	<-ctx.Done()
+	server.Close()
+	r.Close()
+	fmt.Println("Relay stopped, unfinished downloads resume when it starts again")
+}
+
+// runMount mounts a download directory read-only with FUSE until it is
+// unmounted or interrupted. Files still downloading can be read up to the
+// first byte not downloaded yet.
+func runMount(args []string) {
+	fs := flag.NewFlagSet("mount", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory to mount")
+	stateDir := fs.String("state-dir", "", "Progress files of a server's downloads (its STATE_DIR), besides the ones started here")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Printf("Usage: %s mount --dir d [--state-dir s] <mountpoint>\n", os.Args[0])
+		os.Exit(1)
+	}
+	mountpoint := fs.Arg(0)
+	reg := mustOpenRegistry()
+
+	downloads := &fusefs.Downloads{
+		Dir:      *dir,
+		Progress: func() (map[string]string, error) { return progressFiles(reg, *stateDir) },
+		Hide:     []string{reg.Dir()},
+	}
+	if *stateDir != "" {
+		downloads.Hide = append(downloads.Hide, *stateDir)
+	}
+
+	dev, err := fusefs.Mount(mountpoint)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer dev.Close()
+
+	// Unmounting ends Serve, which then returns
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		if err := fusefs.Unmount(mountpoint); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}()
+
+	fmt.Printf("Mounted %s on %s (read-only; press Ctrl+C to unmount)\n", *dir, mountpoint)
+	if err := fusefs.Serve(dev, downloads); err != nil {
+		fusefs.Unmount(mountpoint)
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// progressFiles maps the absolute output path of every download registered
+// here, and of every progress file in stateDir, to its progress file
+func progressFiles(reg *registry.Registry, stateDir string) (map[string]string, error) {
+	files := make(map[string]string)
+	if stateDir != "" {
+		states, err := reconcile.ScanDir(stateDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range states {
+			if state.Progress == nil {
+				continue
+			}
+			if abs, err := filepath.Abs(state.Progress.Filename); err == nil {
+				files[abs] = state.Path
+			}
+		}
+	}
+	jobs, err := reg.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		files[job.Output] = job.ProgressFile
+	}
+	return files, nil
+}
+
+// mustOpenRegistry opens the job registry for the registry subcommands
+func mustOpenRegistry() *registry.Registry {
+	dir, err := registry.DefaultDir()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	reg, err := registry.Open(dir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	return reg
+}
+
+// mustGetJob returns the job named by the only argument of a subcommand
+func mustGetJob(reg *registry.Registry, command string, args []string) *registry.Job {
+	if len(args) != 1 {
+		fmt.Printf("Usage: %s %s <id>\n", os.Args[0], command)
+		os.Exit(1)
+	}
+	job, err := reg.Get(args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	return job
+}
+
+// runList prints every registered download with its status and progress
+func runList() {
+	reg := mustOpenRegistry()
+	jobs, err := reg.List()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No downloads")
+		return
+	}
+
+	fmt.Printf("%-8s  %-11s  %8s  %-40s  %s\n", "ID", "STATUS", "PROGRESS", "OUTPUT", "URL")
+	for _, job := range jobs {
+		progress := "-"
+		if job.Status.Succeeded() {
+			progress = "100.0%"
+		} else if p, err := downloader.LoadProgress(job.ProgressFile); err == nil {
+			progress = fmt.Sprintf("%.1f%%", p.GetOverallPercent())
+		}
+		fmt.Printf("%-8s  %-11s  %8s  %-40s  %s\n", job.ShortID(), job.State(), progress, job.Output, secrets.RedactURL(job.URL))
+		if job.Error != "" && job.State() != lifecycle.Downloading {
+			fmt.Printf("%-8s  %s\n", "", secrets.RedactText(job.Error))
+		}
+	}
+}
+
+// runResume continues a registered download with the options it was
+// started with
+func runResume(args []string) {
+	reg := mustOpenRegistry()
+	job := mustGetJob(reg, "resume", args)
+
+	switch {
+	case job.Running():
+		fmt.Printf("Error: job %s is still downloading in process %d\n", job.ShortID(), job.PID)
+		os.Exit(1)
+	case job.Status.Succeeded():
+		fmt.Printf("Job %s already completed: %s\n", job.ShortID(), job.Output)
+		return
+	case job.Status == lifecycle.Failed:
+		fmt.Printf("Error: job %s failed and cannot be resumed: %s\n", job.ShortID(), job.Error)
+		fmt.Println("Start it again with the original command.")
+		os.Exit(1)
+	}
+
+	opts, err := optionsFromJob(job)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	dl, err := newDownloader(job.URL, job.Output, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Multithreaded Downloader v1.0")
+	fmt.Println("═══════════════════════════════")
+	fmt.Printf("Resuming %s -> %s\n", secrets.RedactURL(job.URL), job.Output)
+	res := newResult(job.URL, job.Output)
+	err = runDownload(context.Background(), dl, reg, job, opts, res)
+	recordResult(res, err)
+	exit(exitCode(err))
+}
+
+// cancelTimeout is how long cancel waits for a running download to stop
+const cancelTimeout = 10 * time.Second
+
+// runCancel stops a registered download if it is running and deletes its
+// progress and partial file
+func runCancel(args []string) {
+	reg := mustOpenRegistry()
+	job := mustGetJob(reg, "cancel", args)
+	if job.Status.Terminal() {
+		fmt.Printf("Error: job %s already %s\n", job.ShortID(), job.Status)
+		os.Exit(1)
+	}
+
+	if job.Running() {
+		fmt.Printf("Stopping process %d...\n", job.PID)
+		if err := stopJob(job); err != nil {
+			fmt.Printf("Error: failed to stop job %s: %v\n", job.ShortID(), err)
+			os.Exit(1)
+		}
+		// Wait for the download to save its progress and record the stop
+		deadline := time.Now().Add(cancelTimeout)
+		for job.Running() && time.Now().Before(deadline) {
+			time.Sleep(200 * time.Millisecond)
+			if current, err := reg.Get(job.ID); err == nil {
+				job = current
+			}
+		}
+		if job.Running() {
+			fmt.Printf("Error: job %s did not stop within %s\n", job.ShortID(), cancelTimeout)
+			os.Exit(1)
+		}
+	}
+
+	os.Remove(job.ProgressFile)
+	if err := os.Remove(job.Output); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to delete partial file: %v\n", err)
+	}
+	job.PID = 0
+	if err := updateJob(reg, job, lifecycle.Failed, errors.New("cancelled")); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Cancelled job %s and deleted %s\n", job.ShortID(), job.Output)
+}
+
+// stopJob asks the process running a job to stop it: the agent over its
+// socket, since an interrupt would stop all of its downloads, and a download
+// started from the command line with an interrupt
+func stopJob(job *registry.Job) error {
+	if !job.Agent {
+		return job.Interrupt()
+	}
+	socket, err := agent.DefaultSocket()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return agent.NewClient(socket).Stop(ctx, job.ID)
+}
+
+// runClean forgets completed, failed and cancelled downloads. Downloaded
+// files are kept.
+func runClean() {
+	reg := mustOpenRegistry()
+	jobs, err := reg.List()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	removed := 0
+	for _, job := range jobs {
+		if !job.Status.Terminal() {
+			continue
+		}
+		if err := reg.Remove(job); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		removed++
+	}
+	fmt.Printf("Removed %d finished job(s)\n", removed)
+}
+
+// runAgent runs the agent until it is interrupted: it queues the downloads
+// handed over by add, also while offline, and runs them when the network
+// is usable
+func runAgent(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	socket := fs.String("socket", "", "Unix socket to listen on (default ~/.mtdl/agent.sock)")
+	concurrency := fs.Int("concurrency", 1, "Downloads to run at once")
+	allowMetered := fs.Bool("allow-metered", false, "Run downloads on metered networks")
+	fs.Parse(args)
+
+	if *socket == "" {
+		path, err := agent.DefaultSocket()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		*socket = path
+	}
+	reg := mustOpenRegistry()
+	ln, err := agent.Listen(*socket)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.Remove(*socket)
+
+	run := func(ctx context.Context, job *registry.Job) error {
+		return runAgentJob(ctx, reg, job)
+	}
+	a := agent.New(reg, run, agent.Options{
+		Concurrency:  *concurrency,
+		AllowMetered: *allowMetered,
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+		},
+	})
+	server := &http.Server{Handler: a.Handler()}
+	go server.Serve(ln)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Agent listening on %s, jobs in %s\n", *socket, reg.Dir())
+	if err := a.Run(ctx); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	server.Close()
+	fmt.Println("Agent stopped, running downloads were saved and continue when it starts again")
+}
+
+// runAgentJob runs one of the agent's downloads with the options it was
+// queued with. Nobody can be asked about an output file that exists, so
+// such downloads fail.
+func runAgentJob(ctx context.Context, reg *registry.Registry, job *registry.Job) error {
+	opts, err := optionsFromJob(job)
+	if err != nil {
+		updateJob(reg, job, lifecycle.Failed, err)
+		return err
+	}
+	opts.existing = refuseExisting
+	dl, err := newDownloader(job.URL, job.Output, opts)
+	if err == nil {
+		err = os.MkdirAll(downloader.LongPath(filepath.Dir(job.Output)), 0755)
+	}
+	if err != nil {
+		updateJob(reg, job, lifecycle.Failed, err)
+		return err
+	}
+	res := newResult(job.URL, job.Output)
+	return runDownload(ctx, dl, reg, job, opts, res)
+}
+
+// runAdd hands a download to the agent, which queues it even while the
+// network is down
+func runAdd(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	socket := fs.String("socket", "", "Unix socket of the agent (default ~/.mtdl/agent.sock)")
+	url := fs.String("url", "", "URL to download")
+	output := fs.String("output", "", "Output filename")
+	threads := fs.Int("threads", 4, "Number of download threads")
+	userAgent := fs.String("user-agent", "", "Custom User-Agent header")
+	referer := fs.String("referer", "", "Referer header to send")
+	allowMetered := fs.Bool("allow-metered", false, "Run this download on a metered network")
+	fs.Parse(args)
+
+	if *url == "" || *output == "" {
+		fmt.Println("Error: Both --url and --output are required")
+		os.Exit(1)
+	}
+	abs, err := filepath.Abs(*output)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *socket == "" {
+		if *socket, err = agent.DefaultSocket(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Without --threads the agent picks the count, from a domain rule if one matches
+	requested := 0
+	if flagGiven(fs, "threads") {
+		requested = *threads
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := agent.NewClient(*socket)
+	job, err := client.Submit(ctx, agent.Request{
+		URL:          *url,
+		Output:       abs,
+		Threads:      requested,
+		UserAgent:    *userAgent,
+		Referer:      *referer,
+		AllowMetered: *allowMetered,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Queued job %s: %s\n", job.ShortID(), job.Output)
+	if status, err := client.Status(ctx); err == nil && status.Network != netwatch.Online {
+		fmt.Printf("Network %s, the download starts once the agent may use it\n", status.Network)
+	}
+}
+
+// runBench enqueues synthetic jobs on a queued server whose workers run in
+// sandbox mode and reports their latencies and throughput
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	server := fs.String("server", "http://localhost:8080", "Base URL of the queued server")
+	jobs := fs.Int("jobs", 100, "Number of jobs to enqueue")
+	concurrency := fs.Int("concurrency", bench.DefaultConcurrency, "Requests to the server in flight")
+	threads := fs.Int("threads", 0, "Threads of every job (default the server's)")
+	size := fs.String("size", "10m", "Size of every file, in bytes with an optional k, m or g suffix")
+	speed := fs.String("speed", "", "Rate the sandbox serves every response at, e.g. 5m (default unlimited)")
+	profile := fs.String("profile", "", "More sandbox parameters as a query, e.g. 'latency=50ms&error_rate=0.01'")
+	timeout := fs.Duration("timeout", 10*time.Minute, "Stop following jobs after this long and report what finished")
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	query, err := neturl.ParseQuery(*profile)
+	if err != nil {
+		fmt.Printf("Error: invalid --profile: %v\n", err)
+		os.Exit(1)
+	}
+	query.Set("size", *size)
+	if *speed != "" {
+		query.Set("speed", *speed)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if !*jsonOutput {
+		fmt.Printf("Enqueueing %d jobs on %s...\n", *jobs, *server)
+	}
+	report, err := bench.Run(ctx, bench.Config{
+		Server:      *server,
+		Jobs:        *jobs,
+		Concurrency: *concurrency,
+		Threads:     *threads,
+		Profile:     query,
+		Timeout:     *timeout,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		report.Write(os.Stdout)
+	}
+	if report.Completed < report.Jobs {
+		os.Exit(1)
+	}
+}
+
+// repeatedFlag collects a flag given several times, such as --env
+// KEY=VALUE or --mirror
+type repeatedFlag []string
+
+func (e *repeatedFlag) String() string { return strings.Join(*e, ",") }
+
+func (e *repeatedFlag) Set(value string) error {
+	*e = append(*e, value)
+	return nil
+}
+
+// serviceKinds are the programs install can register, with their default
+// service names and descriptions
+var serviceKinds = map[string][2]string{
+	"agent":  {"mtdl-agent", "Multithreaded Downloader agent"},
+	"server": {"mtdl-server", "Multithreaded Downloader API server"},
+}
+
+// serviceFlags parses the flags install and uninstall share. kind is the
+// first argument, agent or server, and rest the arguments after the flags.
+func serviceFlags(command string, args []string, extra func(fs *flag.FlagSet)) (kind string, cfg service.Config, rest []string) {
+	if len(args) == 0 || serviceKinds[args[0]][0] == "" {
+		fmt.Printf("Error: usage: %s %s agent|server [flags]\n", os.Args[0], command)
+		os.Exit(1)
+	}
+	kind = args[0]
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	name := fs.String("name", serviceKinds[kind][0], "Service name")
+	system := fs.Bool("system", false, "Install for the whole machine, started at boot, instead of your session (needs root)")
+	if extra != nil {
+		extra(fs)
+	}
+	fs.Parse(args[1:])
+	return kind, service.Config{Name: *name, Description: serviceKinds[kind][1], System: *system}, fs.Args()
+}
+
+// runInstall registers the agent or a server binary as a service that
+// starts with the machine or session and restarts when it fails
+func runInstall(args []string) {
+	var program, logFile string
+	var env repeatedFlag
+	kind, cfg, rest := serviceFlags("install", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&program, "exec", "", "Server binary to run, required for server")
+		fs.StringVar(&logFile, "log-file", "", "File the service's output is appended to (default: journal on Linux, a log file elsewhere)")
+		fs.Var(&env, "env", "KEY=VALUE environment variable for the service, may be repeated")
+	})
+
+	if kind == "agent" {
+		self, err := os.Executable()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		program = self
+		cfg.Args = append([]string{"agent"}, rest...)
+		// Keep the agent on the registry this shell uses
+		if home := os.Getenv("MTDL_HOME"); home != "" {
+			env = append(repeatedFlag{"MTDL_HOME=" + home}, env...)
+		}
+	} else {
+		if program == "" {
+			fmt.Println("Error: --exec is required for server, e.g. --exec /opt/mtdl/server")
+			os.Exit(1)
+		}
+		cfg.Args = rest
+	}
+	abs, err := filepath.Abs(program)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.Exec, cfg.Env = abs, env
+	if logFile != "" {
+		if cfg.LogFile, err = filepath.Abs(logFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	where, err := service.Install(cfg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Installed and started service %s (%s)\n", cfg.Name, where)
+	if runtime.GOOS == "linux" && !cfg.System {
+		fmt.Println("It starts when you log in; run 'loginctl enable-linger' to start it at boot instead")
+	}
+}
+
+// runUninstall stops and removes a service added with install
+func runUninstall(args []string) {
+	_, cfg, _ := serviceFlags("uninstall", args, nil)
+	if err := service.Uninstall(cfg); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed service %s\n", cfg.Name)
+}
+
+// runServiceWrapper runs the program of a Windows service for the service
+// manager; install registers it as the service's command
+func runServiceWrapper(args []string) {
+	var env repeatedFlag
+	fs := flag.NewFlagSet(service.WrapperCommand, flag.ExitOnError)
+	name := fs.String("name", "", "Service name")
+	logFile := fs.String("log-file", "", "File the program's output is appended to")
+	fs.Var(&env, "env", "KEY=VALUE environment variable for the program")
+	fs.Parse(args)
+
+	if err := service.RunWrapped(*name, *logFile, env, fs.Args()); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
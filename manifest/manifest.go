@@ -0,0 +1,165 @@
+// Package manifest reads and writes download manifests: portable lists of
+// download definitions exported from one server and imported into another,
+// e.g. when moving to a new host. A manifest is JSON or, for editing by
+// hand, YAML with the same field names.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/openapi"
+)
+
+// Version is the manifest format this package writes and reads
+const Version = 1
+
+// Encodings of a manifest
+const (
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// New returns an empty manifest stamped with the time it was exported
+func New(exportedAt time.Time) openapi.Manifest {
+	return openapi.Manifest{
+		Version:    Version,
+		ExportedAt: exportedAt.UTC().Format(time.RFC3339),
+		Downloads:  []openapi.ManifestEntry{},
+	}
+}
+
+// ContentType returns the media type of a manifest in format
+func ContentType(format string) string {
+	if format == YAML {
+		return "application/yaml"
+	}
+	return "application/json"
+}
+
+// Encode writes m to w in format, json or yaml
+func Encode(w io.Writer, m openapi.Manifest, format string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case JSON, "":
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case YAML:
+		// Go through JSON so YAML keys are the JSON field names
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		data, err = yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("unknown manifest format %q", format)
+}
+
+// Decode reads a JSON or YAML manifest and checks its version and every
+// entry's request, so a bad manifest is rejected before anything starts
+func Decode(data []byte) (openapi.Manifest, error) {
+	var m openapi.Manifest
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return m, fmt.Errorf("manifest is empty")
+	}
+	if trimmed[0] != '{' {
+		var generic interface{}
+		if err := yaml.Unmarshal(trimmed, &generic); err != nil {
+			return m, fmt.Errorf("manifest is neither JSON nor YAML: %w", err)
+		}
+		converted, err := jsonCompatible(generic)
+		if err != nil {
+			return m, err
+		}
+		if trimmed, err = json.Marshal(converted); err != nil {
+			return m, err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return m, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version == 0 {
+		return m, fmt.Errorf("manifest has no version")
+	}
+	if err := m.Validate(); err != nil {
+		return m, fmt.Errorf("unsupported manifest: %w", err)
+	}
+	for i := range m.Downloads {
+		if err := m.Downloads[i].Request.Validate(); err != nil {
+			return m, fmt.Errorf("download %d: %w", i+1, err)
+		}
+	}
+	return m, nil
+}
+
+// jsonCompatible turns the map[interface{}]interface{} values YAML decodes
+// into map[string]interface{} so they can be encoded as JSON
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("manifest key %v is not a string", key)
+			}
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = converted
+		}
+		return out, nil
+	case []interface{}:
+		for i, item := range v {
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// ParseStatuses reads a comma separated status filter. An empty filter
+// selects every status but completed and not_modified, the downloads
+// worth moving.
+func ParseStatuses(raw string) (map[lifecycle.Status]bool, error) {
+	selected := make(map[lifecycle.Status]bool)
+	for _, name := range strings.Split(raw, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		status, err := lifecycle.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		selected[status] = true
+	}
+	if len(selected) == 0 {
+		for _, status := range lifecycle.All {
+			selected[status] = !status.Succeeded()
+		}
+	}
+	return selected, nil
+}
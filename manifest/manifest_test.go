@@ -0,0 +1,93 @@
+package manifest
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/openapi"
+)
+
+func sample() openapi.Manifest {
+	m := New(time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC))
+	m.Downloads = append(m.Downloads,
+		openapi.ManifestEntry{
+			Request: openapi.DownloadRequest{URL: "https://example.com/a.iso", Output: "a.iso", Threads: 8, UserAgent: "mtdl"},
+			ID:      "0b5e6f3a-1111-2222-3333-444455556666",
+			Status:  "failed",
+			Error:   "connection reset",
+		},
+		openapi.ManifestEntry{
+			Request:           openapi.DownloadRequest{URL: "https://example.com/export", Output: "reports/report.csv", Method: "POST", Body: `{"report":42}`, BodyType: "json"},
+			Status:            "paused",
+			ChecksumAlgorithm: "sha256",
+			ChecksumSource:    "Digest",
+		},
+	)
+	return m
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{JSON, YAML} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, sample(), format); err != nil {
+			t.Fatalf("Encode(%s) error = %v", format, err)
+		}
+		if format == YAML && !strings.Contains(buf.String(), "user_agent: mtdl") {
+			t.Errorf("YAML does not use the JSON field names:\n%s", buf.String())
+		}
+		got, err := Decode(buf.Bytes())
+		if err != nil {
+			t.Fatalf("Decode(%s) error = %v\n%s", format, err, buf.String())
+		}
+		if !reflect.DeepEqual(got, sample()) {
+			t.Errorf("%s round trip = %+v, want %+v", format, got, sample())
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "empty", data: " \n", want: "empty"},
+		{name: "no version", data: `{"exported_at": "", "downloads": []}`, want: "no version"},
+		{name: "newer version", data: `{"version": 2, "exported_at": "", "downloads": []}`, want: "at most 1"},
+		{name: "unknown field", data: `{"version": 1, "downloads": [], "tags": ["x"]}`, want: "unknown field"},
+		{name: "invalid entry", data: "version: 1\ndownloads:\n  - request: {url: https://example.com/a}\n", want: "download 1: output is required"},
+		{name: "not yaml", data: "version: [1", want: "neither JSON nor YAML"},
+	}
+	for _, tt := range tests {
+		if _, err := Decode([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Decode() error = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestParseStatuses(t *testing.T) {
+	all, err := ParseStatuses("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all[lifecycle.Completed] || !all[lifecycle.Failed] || !all[lifecycle.Paused] || !all[lifecycle.Queued] {
+		t.Errorf("default filter = %v, want every status but completed", all)
+	}
+
+	got, err := ParseStatuses("failed, processing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[lifecycle.Status]bool{lifecycle.Failed: true, lifecycle.Downloading: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseStatuses() = %v, want %v", got, want)
+	}
+
+	if _, err := ParseStatuses("failed,lost"); err == nil {
+		t.Error("ParseStatuses() accepted an unknown status")
+	}
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package netwatch
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// metered asks NetworkManager whether the interface of the default route
+// is metered, falling back to its name when NetworkManager is not running:
+// mobile broadband and dial-up links are taken as metered
+func metered(ctx context.Context) bool {
+	iface := defaultRouteInterface()
+	if iface == "" {
+		return false
+	}
+	out, err := exec.CommandContext(ctx, "nmcli", "-t", "-g", "GENERAL.METERED", "device", "show", iface).Output()
+	if err == nil {
+		// "yes" or "yes (guessed)", "no" or "unknown"
+		return strings.HasPrefix(strings.TrimSpace(string(out)), "yes")
+	}
+	return meteredName(iface)
+}
+
+// defaultRouteInterface returns the interface of the IPv4 default route
+func defaultRouteInterface() string {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[1] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package netwatch
+
+import "context"
+
+// metered reports false: other systems do not tell which links are metered
+// in a way a command line tool can read
+func metered(ctx context.Context) bool {
+	return false
+}
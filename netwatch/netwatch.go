@@ -0,0 +1,134 @@
+// Package netwatch watches the host's network so a long download can wait
+// out a lost connection, or a switch to a metered one, instead of failing
+// and needing to be resumed by hand. Detection is a best effort: a host
+// counts as offline when no interface besides loopback has an address or
+// the download's server cannot be resolved, and as metered when its default
+// route runs over a link the system marks as metered.
+package netwatch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// State is the condition of the network
+type State string
+
+const (
+	// Online networks can be used freely
+	Online State = "online"
+	// Offline hosts have no working network
+	Offline State = "offline"
+	// Metered networks work but may cost per byte, e.g. a phone hotspot
+	Metered State = "metered"
+)
+
+// DefaultInterval is how often Watch checks the network by default
+const DefaultInterval = 5 * time.Second
+
+// resolveTimeout bounds the name lookup a check makes
+const resolveTimeout = 3 * time.Second
+
+// Checker reports the current state of the network
+type Checker func(ctx context.Context) State
+
+// Probe returns a checker for downloads from host: the network is offline
+// when no interface besides loopback has a usable address or looking up
+// host fails for any reason other than the name not existing, and metered
+// when the default route is a metered link. An empty host skips the lookup.
+func Probe(host string) Checker {
+	return func(ctx context.Context) State {
+		if !hasUsableInterface() {
+			return Offline
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+			_, err := net.DefaultResolver.LookupHost(lookupCtx, host)
+			cancel()
+			var dnsErr *net.DNSError
+			if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) && ctx.Err() == nil {
+				return Offline
+			}
+		}
+		if metered(ctx) {
+			return Metered
+		}
+		return Online
+	}
+}
+
+// hasUsableInterface reports whether an interface other than loopback is
+// up with an address that is not link-local
+func hasUsableInterface() bool {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		// Without a list, let the downloads find out themselves
+		return true
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && ipNet.IP.IsGlobalUnicast() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Usable reports whether downloads may run in state s; metered networks
+// are only used when allowMetered is set
+func (s State) Usable(allowMetered bool) bool {
+	return s == Online || (s == Metered && allowMetered)
+}
+
+// Watch checks the network every interval until ctx ends and sends every
+// change of state on the returned channel, starting with the state found
+// first. The channel is closed when ctx ends.
+func Watch(ctx context.Context, interval time.Duration, check Checker) <-chan State {
+	changes := make(chan State, 1)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last State
+		for {
+			if state := check(ctx); state != last && ctx.Err() == nil {
+				last = state
+				select {
+				case changes <- state:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return changes
+}
+
+// meteredName reports whether an interface name is one of a mobile
+// broadband or dial-up link
+func meteredName(iface string) bool {
+	for _, prefix := range []string{"wwan", "wwp", "ppp", "rmnet"} {
+		if strings.HasPrefix(iface, prefix) {
+			return true
+		}
+	}
+	return false
+}
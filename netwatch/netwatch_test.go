@@ -0,0 +1,68 @@
+package netwatch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchSendsChanges(t *testing.T) {
+	var mu sync.Mutex
+	states := []State{Online, Online, Offline, Offline, Metered, Online}
+	check := func(context.Context) State {
+		mu.Lock()
+		defer mu.Unlock()
+		state := states[0]
+		if len(states) > 1 {
+			states = states[1:]
+		}
+		return state
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := Watch(ctx, time.Millisecond, check)
+
+	want := []State{Online, Offline, Metered, Online}
+	for i, w := range want {
+		select {
+		case got := <-changes:
+			if got != w {
+				t.Fatalf("change %d = %s, want %s", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change %d, want %s", i, w)
+		}
+	}
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestUsable(t *testing.T) {
+	tests := []struct {
+		state        State
+		allowMetered bool
+		want         bool
+	}{
+		{Online, false, true},
+		{Metered, false, false},
+		{Metered, true, true},
+		{Offline, true, false},
+	}
+	for _, tt := range tests {
+		if got := tt.state.Usable(tt.allowMetered); got != tt.want {
+			t.Errorf("%s.Usable(%v) = %v, want %v", tt.state, tt.allowMetered, got, tt.want)
+		}
+	}
+}
+
+func TestMeteredName(t *testing.T) {
+	for iface, want := range map[string]bool{"wwan0": true, "ppp0": true, "wwp0s20u4": true, "eth0": false, "wlan0": false} {
+		if got := meteredName(iface); got != want {
+			t.Errorf("meteredName(%q) = %v, want %v", iface, got, want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials log in to a registry; the zero value pulls anonymously
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// LoadCredentials returns the credentials docker login saved for
+// registry in $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+// Credential helpers are not run, so only logins stored in the file count.
+func LoadCredentials(registry string) (Credentials, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return Credentials{}, nil
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Credentials{}, fmt.Errorf("invalid docker config: %w", err)
+	}
+
+	keys := []string{registry, "https://" + registry, "https://" + registry + "/v1/", "https://" + registry + "/v2/"}
+	if registry == DockerHub {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io")
+	}
+	for _, key := range keys {
+		entry, ok := config.Auths[key]
+		if !ok || entry.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("invalid docker login for %s: %w", registry, err)
+		}
+		user, password := string(decoded), ""
+		if i := strings.IndexByte(user, ':'); i >= 0 {
+			user, password = user[:i], user[i+1:]
+		}
+		return Credentials{Username: user, Password: password}, nil
+	}
+	return Credentials{}, nil
+}
+
+// Transport authorizes the requests to one registry. A request the
+// registry refuses with 401 is sent again once with the token its
+// challenge leads to, so tokens that expire during a long download are
+// exchanged again. Requests to other hosts, such as the storage a blob is
+// redirected to, pass through untouched.
+type Transport struct {
+	// Host is the registry API's host
+	Host string
+	// Repository is pulled when a challenge names no scope
+	Repository  string
+	Credentials Credentials
+	Next        http.RoundTripper
+
+	mu sync.Mutex
+	// authorization is the Authorization header of the last token or the
+	// basic credentials the registry asked for
+	authorization string
+}
+
+// NewTransport returns a Transport for the registry of ref with the
+// credentials docker login saved for it
+func NewTransport(ref Reference, next http.RoundTripper) (*Transport, error) {
+	creds, err := LoadCredentials(ref.Registry)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(ref.APIBase())
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{Host: u.Host, Repository: ref.Repository, Credentials: creds, Next: next}, nil
+}
+
+// RoundTrip sends req with the current authorization, and again with a
+// new one when the registry refuses it
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.send(req, t.Next)
+}
+
+// Wrap returns a transport sending through next with the authorization of
+// t, for clients that bring their own transport
+func (t *Transport) Wrap(next http.RoundTripper) http.RoundTripper {
+	return &wrapped{t: t, next: next}
+}
+
+type wrapped struct {
+	t    *Transport
+	next http.RoundTripper
+}
+
+func (w *wrapped) RoundTrip(req *http.Request) (*http.Response, error) {
+	return w.t.send(req, w.next)
+}
+
+// send sends req through next, authorized
+func (t *Transport) send(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	if req.URL.Host != t.Host {
+		return next.RoundTrip(req)
+	}
+	used := t.current()
+	resp, err := next.RoundTrip(t.authorize(req, used))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// Only requests without a body can be sent again
+	if req.Body != nil && req.Body != http.NoBody {
+		return resp, nil
+	}
+	if err := t.refresh(req.Context(), resp.Header.Get("WWW-Authenticate"), used); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	return next.RoundTrip(t.authorize(req, t.current()))
+}
+
+func (t *Transport) current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.authorization
+}
+
+// authorize returns a copy of req carrying authorization
+func (t *Transport) authorize(req *http.Request, authorization string) *http.Request {
+	if authorization == "" {
+		return req
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", authorization)
+	return r
+}
+
+// refresh answers the challenge of a 401 to a request sent with used.
+// Parts refused at once wait for one exchange instead of each making
+// their own.
+func (t *Transport) refresh(ctx context.Context, challenge, used string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.authorization != used {
+		return nil
+	}
+
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if t.Credentials.Username == "" {
+			return fmt.Errorf("%s needs a login; run docker login %s", t.Host, t.Host)
+		}
+		t.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(t.Credentials.Username+":"+t.Credentials.Password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("%s refused the request with an unsupported challenge %q", t.Host, challenge)
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("%s sent a bearer challenge without a realm", t.Host)
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return fmt.Errorf("invalid token realm %q: %w", realm, err)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + t.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if t.Credentials.Username != "" {
+		req.SetBasicAuth(t.Credentials.Username, t.Credentials.Password)
+	}
+	resp, err := (&http.Client{Transport: t.Next, Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("token request to %s failed: %w", tokenURL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("token server %s answered %s: %s", tokenURL.Host, resp.Status, strings.TrimSpace(string(message)))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("token server %s sent no token", tokenURL.Host)
+	}
+	t.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate challenge into its scheme and
+// parameters; quoted values may hold commas, as scopes do
+func parseChallenge(challenge string) (string, map[string]string) {
+	challenge = strings.TrimSpace(challenge)
+	sp := strings.IndexByte(challenge, ' ')
+	if sp < 0 {
+		return challenge, nil
+	}
+	scheme, rest := challenge[:sp], challenge[sp+1:]
+	params := make(map[string]string)
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma+1:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,110 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Annotations naming an image in an index.json
+const (
+	// AnnotationRefName is the tag skopeo, podman and umoci look up
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// AnnotationImageName is the full reference containerd imports as
+	AnnotationImageName = "io.containerd.image.name"
+)
+
+// BlobPath returns where the blob d is kept in the image layout at dir
+func BlobPath(dir string, d Descriptor) string {
+	return filepath.Join(dir, "blobs", d.Algorithm(), d.Hex())
+}
+
+// HasBlob reports whether the layout at dir already holds the blob d whole,
+// as it does when another image with the same layer was pulled into it
+func HasBlob(dir string, d Descriptor) bool {
+	f, err := os.Open(BlobPath(dir, d))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() != d.Size {
+		return false
+	}
+	h := newHash(d.Algorithm())
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return fmt.Sprintf("%s:%x", d.Algorithm(), h.Sum(nil)) == d.Digest
+}
+
+// layoutIndex is the index.json of an image layout
+type layoutIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// WriteLayout adds img to the OCI image layout at dir once its blobs are
+// there: the manifest is written as a blob and listed in index.json under
+// the reference's tag, replacing an image listed under the same reference.
+// Images already in the layout stay, so one layout can hold many.
+func WriteLayout(dir string, img *Image) error {
+	if err := os.MkdirAll(filepath.Dir(BlobPath(dir, img.Manifest)), 0755); err != nil {
+		return err
+	}
+	if err := writeFile(BlobPath(dir, img.Manifest), img.Raw); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+
+	index := layoutIndex{SchemaVersion: 2, MediaType: MediaTypeImageIndex}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("invalid index.json in %s: %w", dir, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	name := img.Ref.String()
+	entry := Descriptor{
+		MediaType:   img.Manifest.MediaType,
+		Digest:      img.Manifest.Digest,
+		Size:        img.Manifest.Size,
+		Platform:    img.Manifest.Platform,
+		Annotations: map[string]string{AnnotationImageName: name},
+	}
+	if img.Ref.Tag != "" {
+		entry.Annotations[AnnotationRefName] = img.Ref.Tag
+	}
+	manifests := index.Manifests[:0]
+	for _, m := range index.Manifests {
+		if m.Annotations[AnnotationImageName] != name {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, entry)
+
+	data, err = json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "index.json"), append(data, '\n'))
+}
+
+// writeFile replaces path with data through a temporary file, so readers
+// never see half of it
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,208 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	for _, tc := range []struct {
+		raw  string
+		want Reference
+		api  string
+	}{
+		{"alpine", Reference{DockerHub, "library/alpine", "latest", ""}, "https://registry-1.docker.io/v2/library/alpine"},
+		{"docker://bitnami/redis:7.2", Reference{DockerHub, "bitnami/redis", "7.2", ""}, "https://registry-1.docker.io/v2/bitnami/redis"},
+		{"oci://ghcr.io/org/tools/cli@" + digest, Reference{"ghcr.io", "org/tools/cli", "", digest}, "https://ghcr.io/v2/org/tools/cli"},
+		{"localhost:5000/app:v1@" + digest, Reference{"localhost:5000", "app", "v1", digest}, "http://localhost:5000/v2/app"},
+	} {
+		ref, err := ParseReference(tc.raw)
+		if err != nil || ref != tc.want || ref.APIBase() != tc.api {
+			t.Errorf("ParseReference(%q) = %+v (%s), %v", tc.raw, ref, ref.APIBase(), err)
+		}
+	}
+	for _, raw := range []string{"", "ghcr.io/", "Org/App", "app:", "app@sha256:1234", "app@md5:" + strings.Repeat("0", 32)} {
+		if _, err := ParseReference(raw); err == nil {
+			t.Errorf("ParseReference(%q) succeeded", raw)
+		}
+	}
+
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example/token",service="registry.example",scope="repository:a/b:pull,push"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example/token" || params["scope"] != "repository:a/b:pull,push" || params["service"] != "registry.example" {
+		t.Errorf("parseChallenge() = %s %v", scheme, params)
+	}
+}
+
+// registry serves one repository behind token authentication, as
+// Docker Hub and ghcr.io do
+type registry struct {
+	mu        sync.Mutex
+	server    *httptest.Server
+	blobs     map[string][]byte
+	manifests map[string]string
+	// token is the only token accepted; tokens counts those handed out
+	token  string
+	tokens int
+}
+
+func newRegistry(t *testing.T) *registry {
+	r := &registry{blobs: map[string][]byte{}, manifests: map[string]string{}}
+	r.server = httptest.NewServer(r)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *registry) add(mediaType string, content []byte) Descriptor {
+	sum := sha256.Sum256(content)
+	d := Descriptor{MediaType: mediaType, Digest: fmt.Sprintf("sha256:%x", sum), Size: int64(len(content))}
+	r.blobs[d.Digest] = content
+	r.manifests[d.Digest] = mediaType
+	return d
+}
+
+// expire makes the registry refuse the token it handed out
+func (r *registry) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = ""
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("scope") != "repository:org/app:pull" {
+			http.Error(w, "bad scope", http.StatusForbidden)
+			return
+		}
+		r.tokens++
+		r.token = fmt.Sprintf("token-%d", r.tokens)
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+	if r.token == "" || req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="test",scope="repository:org/app:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := req.URL.Path[strings.LastIndexByte(req.URL.Path, '/')+1:]
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v2/org/app/manifests/"):
+		if digest, ok := r.manifests[name]; ok && !strings.HasPrefix(name, "sha256:") {
+			name = digest
+		}
+		content, ok := r.blobs[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", r.manifests[name])
+		w.Header().Set("Docker-Content-Digest", name)
+		w.Write(content)
+	case strings.HasPrefix(req.URL.Path, "/v2/org/app/blobs/"):
+		content, ok := r.blobs[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(content)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestPullIndexWithTokens(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	reg := newRegistry(t)
+	config := reg.add("application/vnd.oci.image.config.v1+json", []byte(`{"architecture":"arm64"}`))
+	layer := reg.add("application/vnd.oci.image.layer.v1.tar+gzip", []byte("layer bytes"))
+	manifestJSON, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeImageManifest,
+		"config":        config,
+		"layers":        []Descriptor{layer, layer},
+	})
+	arm := reg.add(MediaTypeImageManifest, manifestJSON)
+	amd := reg.add(MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+	arm.Platform = &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	amd.Platform = &Platform{OS: "linux", Architecture: "amd64"}
+	indexJSON, _ := json.Marshal(map[string]interface{}{"schemaVersion": 2, "manifests": []Descriptor{amd, arm}})
+	index := reg.add(MediaTypeImageIndex, indexJSON)
+	reg.manifests["v1"] = index.Digest
+
+	ref, err := ParseReference(strings.TrimPrefix(reg.server.URL, "http://") + "/org/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// httptest serves on 127.0.0.1, which is reached over plain HTTP
+	transport, err := NewTransport(ref, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(ref, transport)
+	img, err := registry.Pull(context.Background(), Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Manifest.Digest != arm.Digest || img.Manifest.Platform.Variant != "v8" || len(img.Blobs) != 2 || img.Blobs[0].Digest != config.Digest {
+		t.Fatalf("Pull() = %+v", img)
+	}
+	if _, err := registry.Pull(context.Background(), Platform{OS: "linux", Architecture: "s390x"}); err == nil || !strings.Contains(err.Error(), "linux/arm64/v8") {
+		t.Errorf("Pull() of a missing platform = %v", err)
+	}
+
+	// An expired token is exchanged again without failing the request,
+	// also for clients wrapping their own transport
+	reg.expire()
+	client := &http.Client{Transport: transport.Wrap(&http.Transport{})}
+	resp, err := client.Get(registry.BlobURL(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "layer bytes" || reg.tokens != 2 {
+		t.Errorf("blob after expiry = %q with %d tokens", body, reg.tokens)
+	}
+
+	// A registry sending another manifest than its digest names is refused
+	reg.blobs[arm.Digest] = []byte(`{"schemaVersion":2,"layers":[]}`)
+	if _, err := registry.Pull(context.Background(), Platform{OS: "linux", Architecture: "arm64"}); err == nil {
+		t.Error("Pull() accepted a manifest that does not match its digest")
+	}
+
+	dir := t.TempDir()
+	for _, blob := range img.Blobs {
+		os.MkdirAll(filepath.Dir(BlobPath(dir, blob)), 0755)
+		os.WriteFile(BlobPath(dir, blob), reg.blobs[blob.Digest], 0644)
+	}
+	os.WriteFile(BlobPath(dir, img.Blobs[1]), []byte("layer bytez"), 0644)
+	if !HasBlob(dir, img.Blobs[0]) || HasBlob(dir, img.Blobs[1]) {
+		t.Error("HasBlob() did not check the blobs' content")
+	}
+	for i := 0; i < 2; i++ {
+		if err := WriteLayout(dir, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var layout layoutIndex
+	data, _ := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err := json.Unmarshal(data, &layout); err != nil || len(layout.Manifests) != 1 || layout.Manifests[0].Annotations[AnnotationRefName] != "v1" {
+		t.Errorf("index.json = %s, %v", data, err)
+	}
+	if content, _ := os.ReadFile(BlobPath(dir, img.Manifest)); string(content) != string(manifestJSON) {
+		t.Error("WriteLayout() did not write the manifest blob")
+	}
+}
@@ -0,0 +1,151 @@
+// Package oci pulls images and artifacts from OCI and Docker registries:
+// it resolves a reference to its manifest, picking the platform from an
+// index, authorizes requests with the registry's token exchange and writes
+// what it pulls as an OCI image layout. The blobs themselves are large and
+// are left to the downloader, checked against their digests.
+package oci
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+const (
+	// DockerHub is the registry of references that name none
+	DockerHub = "docker.io"
+	// dockerHubAPI is where Docker Hub's registry API is served
+	dockerHubAPI = "registry-1.docker.io"
+)
+
+// Reference names an image or artifact: registry/repository[:tag][@digest]
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// IsReference reports whether raw is an oci:// or docker:// URL
+func IsReference(raw string) bool {
+	lower := strings.ToLower(raw)
+	return strings.HasPrefix(lower, "oci://") || strings.HasPrefix(lower, "docker://")
+}
+
+// ParseReference reads a reference as docker pull takes it, with an
+// optional oci:// or docker:// scheme. Without a registry it is on Docker
+// Hub, where single names are official images under library/; without a
+// tag or digest the tag is latest.
+func ParseReference(raw string) (Reference, error) {
+	s := raw
+	if i := strings.Index(s, "://"); i >= 0 && IsReference(s) {
+		s = s[i+3:]
+	}
+	var ref Reference
+	if at := strings.IndexByte(s, '@'); at >= 0 {
+		s, ref.Digest = s[:at], s[at+1:]
+		if _, _, err := splitDigest(ref.Digest); err != nil {
+			return Reference{}, err
+		}
+	}
+	if colon := strings.LastIndexByte(s, ':'); colon > strings.LastIndexByte(s, '/') {
+		s, ref.Tag = s[:colon], s[colon+1:]
+		if ref.Tag == "" {
+			return Reference{}, fmt.Errorf("empty tag in %q", raw)
+		}
+	}
+
+	ref.Registry, ref.Repository = DockerHub, s
+	if slash := strings.IndexByte(s, '/'); slash >= 0 {
+		first := s[:slash]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry, ref.Repository = first, s[slash+1:]
+		}
+	}
+	if ref.Repository == "" || strings.Contains(ref.Repository, "//") || strings.HasSuffix(ref.Repository, "/") {
+		return Reference{}, fmt.Errorf("%q does not name a repository", raw)
+	}
+	if ref.Repository != strings.ToLower(ref.Repository) {
+		return Reference{}, fmt.Errorf("repository names are lowercase, got %q", ref.Repository)
+	}
+	if ref.Registry == DockerHub && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// String returns the full form of r
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// APIBase returns the URL the registry's API is served under. Registries
+// on localhost are reached over plain HTTP, as docker does.
+func (r Reference) APIBase() string {
+	host := r.Registry
+	if host == DockerHub {
+		host = dockerHubAPI
+	}
+	scheme := "https"
+	if h := strings.Split(host, ":")[0]; h == "localhost" || h == "127.0.0.1" {
+		scheme = "http"
+	}
+	return scheme + "://" + host + "/v2/" + r.Repository
+}
+
+// manifestRef is the tag or digest the manifest is fetched by
+func (r Reference) manifestRef() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// splitDigest returns the algorithm and hex value of a digest such as
+// sha256:<hex>, checking its length
+func splitDigest(digest string) (string, string, error) {
+	colon := strings.IndexByte(digest, ':')
+	if colon < 0 {
+		return "", "", fmt.Errorf("invalid digest %q", digest)
+	}
+	algorithm, value := digest[:colon], digest[colon+1:]
+	h := newHash(algorithm)
+	if h == nil {
+		return "", "", fmt.Errorf("unsupported digest algorithm in %q", digest)
+	}
+	if b, err := hex.DecodeString(value); err != nil || len(b) != h.Size() || strings.ToLower(value) != value {
+		return "", "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return algorithm, value, nil
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// digestOf returns the digest of data with the algorithm of like
+func digestOf(like string, data []byte) string {
+	algorithm := like[:strings.IndexByte(like, ':')]
+	h := newHash(algorithm)
+	h.Write(data)
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
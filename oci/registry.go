@@ -0,0 +1,252 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Media types of manifests and indexes
+const (
+	MediaTypeImageIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// MaxManifestSize is the largest manifest or index read
+const MaxManifestSize = 4 << 20
+
+// ErrUnsupported is returned for manifests this package cannot pull, such
+// as Docker schema 1 manifests
+var ErrUnsupported = errors.New("unsupported manifest")
+
+// Descriptor points at a blob or manifest by its digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Algorithm returns the algorithm of the descriptor's digest, such as sha256
+func (d Descriptor) Algorithm() string {
+	return d.Digest[:strings.IndexByte(d.Digest, ':')]
+}
+
+// Hex returns the hex value of the descriptor's digest
+func (d Descriptor) Hex() string {
+	return d.Digest[strings.IndexByte(d.Digest, ':')+1:]
+}
+
+// Platform is the os/architecture[/variant] an image of an index runs on
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform reads os/architecture[/variant]; empty is linux on the
+// architecture this program runs on
+func ParsePlatform(s string) (Platform, error) {
+	if s == "" {
+		return Platform{OS: "linux", Architecture: runtime.GOARCH}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, want os/architecture[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// matches reports whether an index entry for other runs on p; a platform
+// without a variant takes any
+func (p Platform) matches(other *Platform) bool {
+	return other != nil && other.OS == p.OS && other.Architecture == p.Architecture &&
+		(p.Variant == "" || other.Variant == p.Variant)
+}
+
+// manifest holds the fields of an image manifest or an index
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        *Descriptor  `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Image is a pulled manifest and the blobs it needs
+type Image struct {
+	Ref Reference
+	// Manifest describes the manifest itself, and Raw is its content
+	Manifest Descriptor
+	Raw      []byte
+	// Blobs are the config and layers, each once
+	Blobs []Descriptor
+}
+
+// Registry reads manifests from the repository of a reference
+type Registry struct {
+	Ref    Reference
+	Client *http.Client
+}
+
+// NewRegistry returns a Registry for ref whose client authorizes itself
+// with transport
+func NewRegistry(ref Reference, transport http.RoundTripper) *Registry {
+	return &Registry{Ref: ref, Client: &http.Client{Transport: transport, Timeout: 60 * time.Second}}
+}
+
+// BlobURL returns the URL a blob is downloaded from. Registries answer it
+// themselves or redirect to their storage; both serve ranges more often
+// than not.
+func (r *Registry) BlobURL(d Descriptor) string {
+	return r.Ref.APIBase() + "/blobs/" + d.Digest
+}
+
+// Pull resolves the reference to its manifest. An index is narrowed to the
+// manifest of platform. The manifest is checked against the reference's
+// digest, when it has one, and the digest the index gives.
+func (r *Registry) Pull(ctx context.Context, platform Platform) (*Image, error) {
+	desc, raw, m, err := r.manifest(ctx, r.Ref.manifestRef(), r.Ref.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType == MediaTypeImageIndex || desc.MediaType == MediaTypeDockerList {
+		var chosen *Descriptor
+		var available []string
+		for i := range m.Manifests {
+			entry := &m.Manifests[i]
+			if entry.Platform != nil {
+				available = append(available, entry.Platform.String())
+			}
+			if chosen == nil && platform.matches(entry.Platform) {
+				chosen = entry
+			}
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("%s has no %s image, only %s", r.Ref, platform, strings.Join(available, ", "))
+		}
+		if _, _, err := splitDigest(chosen.Digest); err != nil {
+			return nil, fmt.Errorf("%s lists a manifest with an %w", r.Ref, err)
+		}
+		if desc, raw, m, err = r.manifest(ctx, chosen.Digest, chosen.Digest); err != nil {
+			return nil, err
+		}
+		desc.Platform = chosen.Platform
+	}
+	if desc.MediaType != MediaTypeImageManifest && desc.MediaType != MediaTypeDockerManifest {
+		return nil, fmt.Errorf("%w: %s is a %s", ErrUnsupported, r.Ref, desc.MediaType)
+	}
+
+	img := &Image{Ref: r.Ref, Manifest: desc, Raw: raw}
+	seen := make(map[string]bool)
+	blobs := m.Layers
+	if m.Config != nil {
+		blobs = append([]Descriptor{*m.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		if _, _, err := splitDigest(blob.Digest); err != nil {
+			return nil, fmt.Errorf("%s lists a blob with an %w", r.Ref, err)
+		}
+		if blob.Size < 0 {
+			return nil, fmt.Errorf("%s lists %s with a size of %d", r.Ref, blob.Digest, blob.Size)
+		}
+		if !seen[blob.Digest] {
+			seen[blob.Digest] = true
+			img.Blobs = append(img.Blobs, blob)
+		}
+	}
+	return img, nil
+}
+
+// manifest fetches the manifest ref names and checks it against digest,
+// when it is known
+func (r *Registry) manifest(ctx context.Context, ref, digest string) (Descriptor, []byte, *manifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.Ref.APIBase()+"/manifests/"+ref, nil)
+	if err != nil {
+		return Descriptor{}, nil, nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{MediaTypeImageIndex, MediaTypeImageManifest, MediaTypeDockerList, MediaTypeDockerManifest}, ", "))
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return Descriptor{}, nil, nil, fmt.Errorf("failed to fetch the manifest of %s: %w", r.Ref, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Descriptor{}, nil, nil, fmt.Errorf("registry answered %s for the manifest of %s: %s", resp.Status, r.Ref, strings.TrimSpace(string(message)))
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, MaxManifestSize+1))
+	if err != nil {
+		return Descriptor{}, nil, nil, fmt.Errorf("failed to read the manifest of %s: %w", r.Ref, err)
+	}
+	if len(raw) > MaxManifestSize {
+		return Descriptor{}, nil, nil, fmt.Errorf("the manifest of %s is over %d bytes", r.Ref, MaxManifestSize)
+	}
+
+	// A manifest fetched by tag is checked against the digest the
+	// registry gives for it
+	if header := resp.Header.Get("Docker-Content-Digest"); digest == "" && header != "" {
+		if _, _, err := splitDigest(header); err == nil {
+			digest = header
+		}
+	}
+	if digest != "" {
+		if got := digestOf(digest, raw); got != digest {
+			return Descriptor{}, nil, nil, fmt.Errorf("the manifest of %s is %s, not %s", r.Ref, got, digest)
+		}
+	} else {
+		digest = digestOf("sha256:", raw)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return Descriptor{}, nil, nil, fmt.Errorf("invalid manifest of %s: %w", r.Ref, err)
+	}
+	mediaType := m.MediaType
+	if contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && knownMediaType(contentType) {
+		mediaType = contentType
+	}
+	if m.SchemaVersion != 2 {
+		return Descriptor{}, nil, nil, fmt.Errorf("%w: %s has a schema %d manifest", ErrUnsupported, r.Ref, m.SchemaVersion)
+	}
+	if mediaType == "" {
+		// OCI manifests may leave their media type out
+		mediaType = MediaTypeImageManifest
+		if m.Manifests != nil {
+			mediaType = MediaTypeImageIndex
+		}
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(raw))}, raw, &m, nil
+}
+
+// knownMediaType reports whether mediaType is a manifest or index type
+// this package reads
+func knownMediaType(mediaType string) bool {
+	switch mediaType {
+	case MediaTypeImageIndex, MediaTypeImageManifest, MediaTypeDockerList, MediaTypeDockerManifest:
+		return true
+	}
+	return false
+}
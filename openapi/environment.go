@@ -0,0 +1,84 @@
+package openapi
+
+import (
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+// NewDownloadEnvironment describes the recorded environment of download id
+// for the environment route
+func NewDownloadEnvironment(id string, env *downloader.Environment) DownloadEnvironment {
+	out := DownloadEnvironment{
+		DownloadID:   id,
+		Version:      env.Version,
+		CapturedAt:   env.CapturedAt.Format(time.RFC3339),
+		Go:           env.Go,
+		Platform:     env.Platform,
+		URL:          env.URL,
+		Redirects:    env.Redirects,
+		FinalURL:     env.FinalURL,
+		Output:       env.Output,
+		Resumed:      env.Resumed,
+		TotalSize:    env.TotalSize,
+		Parts:        env.Parts,
+		Etag:         env.ETag,
+		LastModified: env.LastModified,
+		Request: EnvironmentRequest{
+			Method:      env.Request.Method,
+			Headers:     env.Request.Headers,
+			UserAgent:   env.Request.UserAgent,
+			UserAgents:  env.Request.UserAgents,
+			Referer:     env.Request.Referer,
+			ContentType: env.Request.ContentType,
+			BodyBytes:   env.Request.BodyBytes,
+			Cookies:     env.Request.Cookies,
+		},
+		PartAddresses: env.PartAddresses,
+	}
+	if env.CompletedAt != nil {
+		out.CompletedAt = env.CompletedAt.Format(time.RFC3339)
+	}
+	c := env.Config
+	out.Config = EnvironmentConfig{
+		Threads:          c.Threads,
+		RequestedThreads: c.RequestedThreads,
+		MinPartSize:      c.MinPartSize,
+		RateLimit:        c.RateLimit,
+		SharedRateLimit:  c.SharedRateLimit,
+		Bind:             c.Bind,
+		BindRateLimit:    c.BindRateLimit,
+		Http2:            c.HTTP2,
+		Encrypted:        c.Encrypted,
+		Sequential:       c.Sequential,
+		PreviewBytes:     c.PreviewBytes,
+		Jitter:           c.Jitter,
+		Mirrors:          c.Mirrors,
+		MirrorCount:      c.MirrorCount,
+		PartURLs:         c.PartURLs,
+		RetryAttempts:    c.RetryAttempts,
+		RetryDelay:       c.RetryDelay,
+		ChecksumRetries:  c.ChecksumRetries,
+		ExpectedSize:     c.ExpectedSize,
+		MaxThreads:       c.MaxThreads,
+		MaxBufferMemory:  c.MaxBufferMemory,
+		Plan:             c.Plan,
+		ContinueFile:     c.Continue,
+		OnlyIfModified:   c.OnlyIfModified,
+		Cache:            c.Cache,
+	}
+	for _, host := range env.Resolved {
+		out.Resolved = append(out.Resolved, ResolvedHost{Host: host.Host, Addresses: host.Addresses, Connected: host.Connected})
+	}
+	if s := env.Server; s != nil {
+		out.Server = &EnvironmentServer{
+			Status:         s.Status,
+			Proto:          s.Proto,
+			SupportsRanges: s.SupportsRanges,
+			Headers:        s.Headers,
+			ProbedAt:       s.ProbedAt.Format(time.RFC3339),
+			Cached:         s.Cached,
+		}
+	}
+	return out
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// operation is one API call as the client generators see it
+type operation struct {
+	ID      string
+	Method  string
+	Path    string
+	Summary string
+	Servers []string
+	Since   string
+	// PathParams are filled into Path in order, QueryParams are optional
+	PathParams  []parameter
+	QueryParams []parameter
+	// Body is nil for calls without a request body
+	Body *requestBody
+	// Results are the success responses, the lowest status first
+	Results []result
+}
+
+type parameter struct {
+	Name        string
+	Description string
+	Schema      *schema
+}
+
+// requestBody is the one content type a client sends: JSON when the
+// operation accepts it, plain text otherwise
+type requestBody struct {
+	ContentType string
+	Schema      *schema
+}
+
+// result is a success response; Schema is nil for binary content
+type result struct {
+	Status string
+	Schema *schema
+}
+
+type rawParameter struct {
+	Ref         string  `json:"$ref"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Schema      *schema `json:"schema"`
+}
+
+type rawContent map[string]struct {
+	Schema *schema `json:"schema"`
+}
+
+type rawResponse struct {
+	Ref     string     `json:"$ref"`
+	Content rawContent `json:"content"`
+}
+
+type rawOperation struct {
+	OperationID string         `json:"operationId"`
+	Summary     string         `json:"summary"`
+	Servers     []string       `json:"x-servers"`
+	Path        string         `json:"x-path"`
+	Since       string         `json:"x-since"`
+	Parameters  []rawParameter `json:"parameters"`
+	RequestBody *struct {
+		Content rawContent `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]rawResponse `json:"responses"`
+}
+
+type clientDocument struct {
+	Paths      json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas    properties              `json:"schemas"`
+		Parameters map[string]rawParameter `json:"parameters"`
+		Responses  map[string]rawResponse  `json:"responses"`
+	} `json:"components"`
+}
+
+// orderedKeys returns the keys of a JSON object in document order
+func orderedKeys(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// isBinary reports whether a response is a file, e.g. application/octet-stream
+// or application/gzip, which clients return as raw bytes
+func isBinary(content rawContent) bool {
+	for _, c := range content {
+		if c.Schema != nil && c.Schema.Type == "string" && c.Schema.Format == "binary" {
+			return true
+		}
+	}
+	return false
+}
+
+// lastSegment returns the name a $ref points to
+func lastSegment(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// parseOperations reads every operation of spec in document order, with
+// parameter and response references resolved
+func parseOperations(spec []byte) ([]operation, properties, error) {
+	var doc clientDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+	var paths map[string]json.RawMessage
+	if err := json.Unmarshal(doc.Paths, &paths); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse paths: %w", err)
+	}
+	pathKeys, err := orderedKeys(doc.Paths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("paths: %w", err)
+	}
+
+	resolveParameter := func(p rawParameter) (rawParameter, error) {
+		if p.Ref == "" {
+			return p, nil
+		}
+		resolved, ok := doc.Components.Parameters[lastSegment(p.Ref)]
+		if !ok {
+			return p, fmt.Errorf("unknown parameter %s", p.Ref)
+		}
+		return resolved, nil
+	}
+
+	var ops []operation
+	for _, path := range pathKeys {
+		item := paths[path]
+		var shared struct {
+			Parameters []rawParameter `json:"parameters"`
+		}
+		if err := json.Unmarshal(item, &shared); err != nil {
+			return nil, nil, fmt.Errorf("path %s: %w", path, err)
+		}
+		var methods map[string]json.RawMessage
+		if err := json.Unmarshal(item, &methods); err != nil {
+			return nil, nil, fmt.Errorf("path %s: %w", path, err)
+		}
+		methodKeys, err := orderedKeys(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("path %s: %w", path, err)
+		}
+
+		for _, method := range methodKeys {
+			if method == "parameters" {
+				continue
+			}
+			var raw rawOperation
+			if err := json.Unmarshal(methods[method], &raw); err != nil {
+				return nil, nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			if raw.OperationID == "" {
+				return nil, nil, fmt.Errorf("%s %s has no operationId", method, path)
+			}
+			op := operation{
+				ID:      raw.OperationID,
+				Method:  strings.ToUpper(method),
+				Path:    path,
+				Summary: raw.Summary,
+				Servers: raw.Servers,
+				Since:   raw.Since,
+			}
+			if raw.Path != "" {
+				op.Path = raw.Path
+			}
+
+			for _, p := range append(raw.Parameters, shared.Parameters...) {
+				p, err := resolveParameter(p)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", op.ID, err)
+				}
+				param := parameter{Name: p.Name, Description: p.Description, Schema: p.Schema}
+				switch p.In {
+				case "path":
+					op.PathParams = append(op.PathParams, param)
+				case "query":
+					op.QueryParams = append(op.QueryParams, param)
+				default:
+					return nil, nil, fmt.Errorf("%s: %s parameters are not supported", op.ID, p.In)
+				}
+			}
+			// Path parameters follow the order they appear in the path
+			sort.SliceStable(op.PathParams, func(i, j int) bool {
+				return strings.Index(op.Path, "{"+op.PathParams[i].Name+"}") < strings.Index(op.Path, "{"+op.PathParams[j].Name+"}")
+			})
+
+			if raw.RequestBody != nil {
+				if c, ok := raw.RequestBody.Content["application/json"]; ok {
+					op.Body = &requestBody{ContentType: "application/json", Schema: c.Schema}
+				} else if c, ok := raw.RequestBody.Content["text/plain"]; ok {
+					op.Body = &requestBody{ContentType: "text/plain", Schema: c.Schema}
+				} else {
+					return nil, nil, fmt.Errorf("%s: no JSON or text request body", op.ID)
+				}
+			}
+
+			statuses := make([]string, 0, len(raw.Responses))
+			for status := range raw.Responses {
+				if strings.HasPrefix(status, "2") {
+					statuses = append(statuses, status)
+				}
+			}
+			sort.Strings(statuses)
+			for _, status := range statuses {
+				resp := raw.Responses[status]
+				if resp.Ref != "" {
+					resolved, ok := doc.Components.Responses[lastSegment(resp.Ref)]
+					if !ok {
+						return nil, nil, fmt.Errorf("%s: unknown response %s", op.ID, resp.Ref)
+					}
+					resp = resolved
+				}
+				if c, ok := resp.Content["application/json"]; ok {
+					op.Results = append(op.Results, result{Status: status, Schema: c.Schema})
+				} else if isBinary(resp.Content) {
+					op.Results = append(op.Results, result{Status: status})
+				} else {
+					return nil, nil, fmt.Errorf("%s: response %s is neither JSON nor binary", op.ID, status)
+				}
+			}
+			if len(op.Results) == 0 {
+				return nil, nil, fmt.Errorf("%s has no success response", op.ID)
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops, doc.Components.Schemas, nil
+}
+
+// binary reports whether the operation answers with raw bytes
+func (op *operation) binary() bool {
+	return op.Results[0].Schema == nil
+}
+
+// snakeCase turns an operationId such as getDownloadStatus into
+// get_download_status
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// serverNames are how the doc comments refer to each x-servers entry
+var serverNames = map[string]string{
+	"server": "direct",
+	"simple": "simple",
+	"queue":  "queued",
+}
+
+// docLines describes an operation for the generated clients' doc comments;
+// an empty line separates paragraphs
+func docLines(op *operation) []string {
+	var lines []string
+	if op.Summary != "" {
+		lines = append(lines, op.Summary+".", "")
+	}
+	names := make([]string, len(op.Servers))
+	for i, server := range op.Servers {
+		names[i] = serverNames[server]
+		if names[i] == "" {
+			names[i] = server
+		}
+	}
+	served := "Served by the " + names[0]
+	if n := len(names); n > 1 {
+		served = "Served by the " + strings.Join(names[:n-1], ", ") + " and " + names[n-1]
+	}
+	served += " server"
+	if len(names) > 1 {
+		served += "s"
+	}
+	if op.Since != "" {
+		served += fmt.Sprintf(" from API %s", op.Since)
+	}
+	return append(lines, served+".")
+}
+
+// pathTemplate splits a path into literal text and parameter names, e.g.
+// /downloads/{id}/status into ["/downloads/", "id", "/status"]; odd
+// entries are parameters
+func pathTemplate(path string) []string {
+	var parts []string
+	for {
+		start := strings.Index(path, "{")
+		if start < 0 {
+			return append(parts, path)
+		}
+		end := strings.Index(path[start:], "}") + start
+		parts = append(parts, path[:start], path[start+1:end])
+		path = path[end+1:]
+	}
+}
@@ -0,0 +1,514 @@
+// Command gen generates Go request/response types and validation from the
+// component schemas of an OpenAPI document, and the Python and TypeScript
+// clients in sdk/ from its schemas and operations. Run it with go generate
+// in the openapi package.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	specPath := flag.String("spec", "openapi.json", "OpenAPI document to read")
+	outPath := flag.String("out", "types_gen.go", "File to write")
+	pkg := flag.String("package", "openapi", "Package name of the generated file")
+	lang := flag.String("lang", "go", "Language to generate: go, python or typescript")
+	flag.Parse()
+
+	spec, err := os.ReadFile(*specPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading spec: %v\n", err)
+		os.Exit(1)
+	}
+
+	var code []byte
+	switch *lang {
+	case "go":
+		code, err = generate(spec, *pkg)
+	case "python":
+		code, err = generatePython(spec)
+	case "typescript":
+		code, err = generateTypeScript(spec)
+	default:
+		err = fmt.Errorf("unknown language %q", *lang)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating types: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(*outPath, code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *outPath, err)
+		os.Exit(1)
+	}
+}
+
+// schema is the subset of an OpenAPI schema object the generator understands
+type schema struct {
+	Type                 string          `json:"type"`
+	Format               string          `json:"format"`
+	Description          string          `json:"description"`
+	Ref                  string          `json:"$ref"`
+	Properties           properties      `json:"properties"`
+	Required             []string        `json:"required"`
+	Items                *schema         `json:"items"`
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	Enum                 []string        `json:"enum"`
+	MinLength            *int            `json:"minLength"`
+	MaxLength            *int            `json:"maxLength"`
+	Minimum              *float64        `json:"minimum"`
+	Maximum              *float64        `json:"maximum"`
+	Default              json.RawMessage `json:"default"`
+	// Nullable scalars and references become pointers so an omitted field
+	// can be told apart from its zero value
+	Nullable bool `json:"nullable"`
+	// Since is the API version that introduced the property (x-since)
+	Since string `json:"x-since"`
+}
+
+// property is a named schema; properties keep the order of the document so
+// the generated fields match the order the schema was written in
+type property struct {
+	Name   string
+	Schema *schema
+}
+
+type properties []property
+
+func (p *properties) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("properties must be an object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := tok.(string)
+		var s schema
+		if err := dec.Decode(&s); err != nil {
+			return fmt.Errorf("property %s: %w", name, err)
+		}
+		*p = append(*p, property{Name: name, Schema: &s})
+	}
+	_, err := dec.Token()
+	return err
+}
+
+type document struct {
+	Components struct {
+		Schemas properties `json:"schemas"`
+	} `json:"components"`
+}
+
+// generate returns formatted Go source for every component schema in spec
+func generate(spec []byte, pkg string) ([]byte, error) {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+
+	for _, named := range doc.Components.Schemas {
+		if named.Schema.Type != "object" || len(named.Schema.Properties) == 0 {
+			return nil, fmt.Errorf("schema %s: only objects with properties are supported", named.Name)
+		}
+		for _, prop := range named.Schema.Properties {
+			if prop.Schema.Since != "" && prop.Schema.Since != newestVersion {
+				return nil, fmt.Errorf("schema %s property %s: unsupported x-since %q", named.Name, prop.Name, prop.Schema.Since)
+			}
+		}
+	}
+	versioned := versionedSchemas(doc.Components.Schemas)
+
+	var body bytes.Buffer
+	usesFmt := false
+	for _, named := range doc.Components.Schemas {
+		if err := writeStruct(&body, named.Name, named.Schema); err != nil {
+			return nil, err
+		}
+		if writeValidate(&body, named.Name, named.Schema) {
+			usesFmt = true
+		}
+		writeDefaults(&body, named.Name, named.Schema)
+		if versioned[named.Name] {
+			if err := writeV1(&body, named.Name, named.Schema, versioned); err != nil {
+				return nil, err
+			}
+		}
+		if writeCheckVersion(&body, named.Name, named.Schema) {
+			usesFmt = true
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by openapi/gen from openapi.json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", pkg)
+	if usesFmt {
+		fmt.Fprintf(&out, "import \"fmt\"\n\n")
+	}
+	out.Write(body.Bytes())
+
+	return format.Source(out.Bytes())
+}
+
+func writeStruct(w *bytes.Buffer, name string, s *schema) error {
+	if s.Description != "" {
+		fmt.Fprintf(w, "// %s %s\n", name, s.Description)
+	}
+	fmt.Fprintf(w, "type %s struct {\n", name)
+
+	required := requiredSet(s)
+	for _, prop := range s.Properties {
+		goType, err := goTypeOf(prop.Schema)
+		if err != nil {
+			return fmt.Errorf("schema %s property %s: %w", name, prop.Name, err)
+		}
+		if prop.Schema.Description != "" {
+			fmt.Fprintf(w, "\t// %s\n", prop.Schema.Description)
+		}
+		tag := prop.Name
+		if !required[prop.Name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(w, "\t%s %s `json:%q`\n", fieldName(prop.Name), goType, tag)
+	}
+
+	fmt.Fprintf(w, "}\n\n")
+	return nil
+}
+
+// writeValidate emits a Validate method for the constraints on the schema's
+// properties and reports whether one was written
+func writeValidate(w *bytes.Buffer, name string, s *schema) bool {
+	required := requiredSet(s)
+	var checks bytes.Buffer
+	for _, prop := range s.Properties {
+		field := "v." + fieldName(prop.Name)
+		p := prop.Schema
+		// value is the field as a comparable value; guard skips an omitted
+		// nullable field
+		value, guard := field, ""
+		if p.Nullable {
+			value, guard = "*"+field, field+" != nil && "
+		}
+
+		if p.MinLength != nil && *p.MinLength > 0 {
+			if *p.MinLength == 1 {
+				fmt.Fprintf(&checks, "\tif len(%s) == 0 {\n\t\terrs.add(%q, \"%s is required\")\n\t}\n", field, prop.Name, prop.Name)
+			} else {
+				fmt.Fprintf(&checks, "\tif len(%s) < %d {\n\t\terrs.add(%q, \"%s must be at least %d characters\")\n\t}\n", field, *p.MinLength, prop.Name, prop.Name, *p.MinLength)
+			}
+		}
+		if p.MaxLength != nil {
+			fmt.Fprintf(&checks, "\tif len(%s) > %d {\n\t\terrs.add(%q, \"%s must be at most %d characters, got %%d\", len(%s))\n\t}\n", field, *p.MaxLength, prop.Name, prop.Name, *p.MaxLength, field)
+		}
+		if check := formatCheck(p.Format); check != "" && p.Type == "string" && !p.Nullable {
+			fmt.Fprintf(&checks, "\tif %s != \"\" {\n\t\tif err := %s(%s); err != nil {\n\t\t\terrs.add(%q, \"%s %%v\", err)\n\t\t}\n\t}\n", field, check, field, prop.Name, prop.Name)
+		}
+		if check := formatCheck(itemFormat(p)); check != "" {
+			fmt.Fprintf(&checks, "\tfor i, item := range %s {\n\t\tif err := %s(item); err != nil {\n\t\t\terrs.add(fmt.Sprintf(\"%s[%%d]\", i), \"%s[%%d] %%v\", i, err)\n\t\t}\n\t}\n", field, check, prop.Name, prop.Name)
+		}
+		if p.Minimum != nil {
+			min := formatNumber(*p.Minimum)
+			fmt.Fprintf(&checks, "\tif %s%s < %s {\n\t\terrs.add(%q, \"%s must be at least %s, got %%v\", %s)\n\t}\n", guard, value, min, prop.Name, prop.Name, min, value)
+		}
+		if p.Maximum != nil {
+			max := formatNumber(*p.Maximum)
+			fmt.Fprintf(&checks, "\tif %s%s > %s {\n\t\terrs.add(%q, \"%s must be at most %s, got %%v\", %s)\n\t}\n", guard, value, max, prop.Name, prop.Name, max, value)
+		}
+		if len(p.Enum) > 0 {
+			cases := make([]string, len(p.Enum))
+			for i, value := range p.Enum {
+				cases[i] = strconv.Quote(value)
+			}
+			if !required[prop.Name] {
+				cases = append([]string{`""`}, cases...)
+			}
+			fmt.Fprintf(&checks, "\tswitch %s {\n\tcase %s:\n\tdefault:\n\t\terrs.add(%q, \"%s must be one of %s, got %%q\", %s)\n\t}\n",
+				field, strings.Join(cases, ", "), prop.Name, prop.Name, strings.Join(p.Enum, ", "), field)
+		}
+	}
+
+	if checks.Len() == 0 {
+		return false
+	}
+	fmt.Fprintf(w, "// Validate checks %s against the constraints in the OpenAPI document,\n", name)
+	fmt.Fprintf(w, "// returning every field that breaks one as ValidationErrors\n")
+	fmt.Fprintf(w, "func (v *%s) Validate() error {\n", name)
+	fmt.Fprintf(w, "\tvar errs ValidationErrors\n")
+	w.Write(checks.Bytes())
+	fmt.Fprintf(w, "\treturn errs.err()\n}\n\n")
+	return true
+}
+
+// formatCheck names the function in package openapi that checks a string
+// format, or returns "" for formats that are not checked
+func formatCheck(format string) string {
+	switch format {
+	case "uri":
+		return "CheckURL"
+	case "output-path":
+		return "CheckOutputPath"
+	case "output-dir":
+		return "CheckDirPath"
+	}
+	return ""
+}
+
+// itemFormat returns the format of the strings of an array schema
+func itemFormat(s *schema) string {
+	if s.Type != "array" || s.Items == nil || s.Items.Type != "string" {
+		return ""
+	}
+	return s.Items.Format
+}
+
+// writeDefaults emits an ApplyDefaults method that fills zero-valued fields
+// with the defaults from the document
+func writeDefaults(w *bytes.Buffer, name string, s *schema) {
+	var sets bytes.Buffer
+	for _, prop := range s.Properties {
+		if len(prop.Schema.Default) == 0 {
+			continue
+		}
+		field := "v." + fieldName(prop.Name)
+		switch prop.Schema.Type {
+		case "string", "integer", "number":
+			var zero string
+			if prop.Schema.Type == "string" {
+				zero = `""`
+			} else {
+				zero = "0"
+			}
+			fmt.Fprintf(&sets, "\tif %s == %s {\n\t\t%s = %s\n\t}\n", field, zero, field, string(prop.Schema.Default))
+		}
+	}
+
+	if sets.Len() == 0 {
+		return
+	}
+	fmt.Fprintf(w, "// ApplyDefaults fills unset fields of %s with their documented defaults\n", name)
+	fmt.Fprintf(w, "func (v *%s) ApplyDefaults() {\n", name)
+	w.Write(sets.Bytes())
+	fmt.Fprintf(w, "}\n\n")
+}
+
+// newestVersion is the only x-since the generator supports; every schema
+// with newer properties also gets a V1 shape without them
+const newestVersion = "v2"
+
+// versionedSchemas finds the schemas whose v1 shape differs: those with
+// x-since properties and those that embed such a schema
+func versionedSchemas(schemas properties) map[string]bool {
+	versioned := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, named := range schemas {
+			if versioned[named.Name] {
+				continue
+			}
+			for _, prop := range named.Schema.Properties {
+				if prop.Schema.Since != "" || versioned[refName(prop.Schema)] {
+					versioned[named.Name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return versioned
+}
+
+// refName returns the schema a property refers to, directly or as the items
+// of an array, or "" if it refers to none
+func refName(s *schema) string {
+	if s.Type == "array" && s.Items != nil {
+		s = s.Items
+	}
+	if s.Ref == "" {
+		return ""
+	}
+	return s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+}
+
+// writeV1 emits the v1 shape of a versioned schema and a V1 method that
+// converts to it, dropping the properties added in later versions
+func writeV1(w *bytes.Buffer, name string, s *schema, versioned map[string]bool) error {
+	fmt.Fprintf(w, "// %sV1 is %s as served by API version v1\n", name, name)
+	fmt.Fprintf(w, "type %sV1 struct {\n", name)
+
+	var copies, converts bytes.Buffer
+	required := requiredSet(s)
+	for _, prop := range s.Properties {
+		if prop.Schema.Since != "" {
+			continue
+		}
+		goType, err := goTypeOf(prop.Schema)
+		if err != nil {
+			return fmt.Errorf("schema %s property %s: %w", name, prop.Name, err)
+		}
+		field := fieldName(prop.Name)
+		if ref := refName(prop.Schema); versioned[ref] {
+			if prop.Schema.Nullable {
+				return fmt.Errorf("schema %s property %s: nullable reference to versioned schema %s is not supported", name, prop.Name, ref)
+			}
+			goType += "V1"
+			if prop.Schema.Type == "array" {
+				fmt.Fprintf(&converts, "	if v.%s != nil {\n\t\tout.%s = make(%s, len(v.%s))\n\t\tfor i := range v.%s {\n\t\t\tout.%s[i] = v.%s[i].V1()\n\t\t}\n\t}\n",
+					field, field, goType, field, field, field, field)
+			} else {
+				fmt.Fprintf(&copies, "\t\t%s: v.%s.V1(),\n", field, field)
+			}
+		} else {
+			fmt.Fprintf(&copies, "\t\t%s: v.%s,\n", field, field)
+		}
+
+		tag := prop.Name
+		if !required[prop.Name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(w, "\t%s %s `json:%q`\n", field, goType, tag)
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// V1 converts %s to its v1 shape\n", name)
+	fmt.Fprintf(w, "func (v *%s) V1() %sV1 {\n", name, name)
+	fmt.Fprintf(w, "\tout := %sV1{\n", name)
+	w.Write(copies.Bytes())
+	fmt.Fprintf(w, "\t}\n")
+	w.Write(converts.Bytes())
+	fmt.Fprintf(w, "\treturn out\n}\n\n")
+	return nil
+}
+
+// writeCheckVersion emits a CheckVersion method that rejects properties the
+// requested API version does not have, and reports whether one was written
+func writeCheckVersion(w *bytes.Buffer, name string, s *schema) bool {
+	var checks bytes.Buffer
+	for _, prop := range s.Properties {
+		if prop.Schema.Since == "" {
+			continue
+		}
+		field := "v." + fieldName(prop.Name)
+		var set string
+		switch {
+		case prop.Schema.Nullable:
+			set = field + " != nil"
+		case prop.Schema.Type == "string":
+			set = field + ` != ""`
+		case prop.Schema.Type == "integer" || prop.Schema.Type == "number":
+			set = field + " != 0"
+		case prop.Schema.Type == "boolean":
+			set = field
+		default:
+			set = "len(" + field + ") > 0"
+		}
+		fmt.Fprintf(&checks, "\tif %s && versionBefore(version, %q) {\n\t\treturn fmt.Errorf(\"%s requires API version %s\")\n\t}\n",
+			set, prop.Schema.Since, prop.Name, prop.Schema.Since)
+	}
+
+	if checks.Len() == 0 {
+		return false
+	}
+	fmt.Fprintf(w, "// CheckVersion rejects fields of %s that the given API version does not have\n", name)
+	fmt.Fprintf(w, "func (v *%s) CheckVersion(version string) error {\n", name)
+	w.Write(checks.Bytes())
+	fmt.Fprintf(w, "\treturn nil\n}\n\n")
+	return true
+}
+
+func requiredSet(s *schema) map[string]bool {
+	required := make(map[string]bool, len(s.Required))
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	return required
+}
+
+// goTypeOf maps a property schema to a Go type
+func goTypeOf(s *schema) (string, error) {
+	if s.Ref != "" {
+		name := s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		if s.Nullable {
+			return "*" + name, nil
+		}
+		return name, nil
+	}
+	if s.Nullable {
+		switch s.Type {
+		case "integer", "number", "boolean":
+			base := *s
+			base.Nullable = false
+			goType, err := goTypeOf(&base)
+			return "*" + goType, err
+		}
+		return "", fmt.Errorf("nullable %s is not supported", s.Type)
+	}
+
+	switch s.Type {
+	case "string":
+		return "string", nil
+	case "integer":
+		if s.Format == "int64" {
+			return "int64", nil
+		}
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		elem, err := goTypeOf(s.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elem, nil
+	case "object":
+		var values schema
+		if len(s.AdditionalProperties) == 0 || json.Unmarshal(s.AdditionalProperties, &values) != nil {
+			return "map[string]interface{}", nil
+		}
+		elem, err := goTypeOf(&values)
+		if err != nil {
+			return "", err
+		}
+		return "map[string]" + elem, nil
+	}
+	return "", fmt.Errorf("unsupported type %q", s.Type)
+}
+
+// initialisms are written in upper case in Go field names
+var initialisms = map[string]string{
+	"id":   "ID",
+	"ids":  "IDs",
+	"url":  "URL",
+	"urls": "URLs",
+}
+
+// fieldName turns a snake_case property name into a Go field name
+func fieldName(name string) string {
+	words := strings.Split(name, "_")
+	for i, word := range words {
+		if upper, ok := initialisms[word]; ok {
+			words[i] = upper
+		} else if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestGeneratedTypesAreCurrent(t *testing.T) {
+	spec, err := os.ReadFile("../openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := generate(spec, "openapi")
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+
+	got, err := os.ReadFile("../types_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("types_gen.go is out of date; run go generate ./openapi")
+	}
+}
+
+func TestGeneratedClientsAreCurrent(t *testing.T) {
+	spec, err := os.ReadFile("../openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]func([]byte) ([]byte, error){
+		"../../sdk/python/mtdownloader/_generated.py": generatePython,
+		"../../sdk/typescript/src/generated.ts":       generateTypeScript,
+	}
+	for path, gen := range clients {
+		want, err := gen(spec)
+		if err != nil {
+			t.Fatalf("generating %s: %v", path, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date; run go generate ./openapi", path)
+		}
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := map[string]string{
+		"url":                "URL",
+		"job_ids":            "JobIDs",
+		"download_id":        "DownloadID",
+		"user_agent_profile": "UserAgentProfile",
+		"page_url":           "PageURL",
+	}
+	for in, want := range tests {
+		if got := fieldName(in); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGenerateRejectsUnsupportedSchemas(t *testing.T) {
+	specs := []string{
+		`{"components":{"schemas":{"Name":{"type":"string"}}}}`,
+		`{"components":{"schemas":{"Thing":{"type":"object","properties":{"x":{"type":"array"}}}}}}`,
+		`{"components":{"schemas":{"Thing":{"type":"object","properties":{"x":{"type":"tuple"}}}}}}`,
+	}
+	for _, spec := range specs {
+		if _, err := generate([]byte(spec), "openapi"); err == nil {
+			t.Errorf("generate(%s) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestGenerateRejectsUnknownSince(t *testing.T) {
+	spec := `{"components":{"schemas":{"Thing":{"type":"object","properties":{"x":{"type":"string","x-since":"v3"}}}}}}`
+	if _, err := generate([]byte(spec), "openapi"); err == nil {
+		t.Error("generate() accepted x-since v3")
+	}
+}
+
+func TestNullablePropertiesArePointers(t *testing.T) {
+	spec := `{"components":{"schemas":{"Thing":{"type":"object","properties":{"x":{"type":"integer","minimum":1,"nullable":true}}}}}}`
+	out, err := generate([]byte(spec), "openapi")
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+	for _, want := range []string{"X *int `json:\"x,omitempty\"`", "if v.X != nil && *v.X < 1 {"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("generated code lacks %q:\n%s", want, out)
+		}
+	}
+
+	spec = `{"components":{"schemas":{"Thing":{"type":"object","properties":{"x":{"type":"string","nullable":true}}}}}}`
+	if _, err := generate([]byte(spec), "openapi"); err == nil {
+		t.Error("generate() accepted a nullable string")
+	}
+
+	spec = `{"components":{"schemas":{"Part":{"type":"object","properties":{"n":{"type":"integer"}}},` +
+		`"Thing":{"type":"object","properties":{"part":{"$ref":"#/components/schemas/Part","nullable":true,"x-since":"v2"}}}}}}`
+	out, err = generate([]byte(spec), "openapi")
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+	for _, want := range []string{"Part *Part `json:\"part,omitempty\"`", "if v.Part != nil && versionBefore(version, \"v2\")"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("generated code lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pythonKeywords cannot be used as TypedDict keys in class syntax or as
+// argument names
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true,
+	"def": true, "del": true, "elif": true, "else": true, "except": true,
+	"finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true,
+	"not": true, "or": true, "pass": true, "raise": true, "return": true,
+	"try": true, "while": true, "with": true, "yield": true,
+}
+
+// generatePython returns a Python module with a TypedDict per component
+// schema and a client base class with a method per operation. The
+// hand-written client in sdk/python supplies the transport.
+func generatePython(spec []byte) ([]byte, error) {
+	ops, schemas, err := parseOperations(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "# Code generated by openapi/gen from openapi.json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&w, "from __future__ import annotations\n\n")
+	fmt.Fprintf(&w, "from typing import Any, Dict, List, Literal, Optional, TypedDict, Union\n")
+	fmt.Fprintf(&w, "from urllib.parse import quote\n")
+
+	for _, named := range schemas {
+		if err := writePythonType(&w, named.Name, named.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(&w, "\n\nclass GeneratedClient:\n")
+	fmt.Fprintf(&w, "    \"\"\"One method per API operation; subclasses implement _request.\"\"\"\n\n")
+	fmt.Fprintf(&w, "    def _request(self, method: str, path: str, *, query: Optional[Dict[str, Any]] = None,\n")
+	fmt.Fprintf(&w, "                 json: Any = None, text: Optional[str] = None, binary: bool = False,\n")
+	fmt.Fprintf(&w, "                 headers: Optional[Dict[str, str]] = None) -> Any:\n")
+	fmt.Fprintf(&w, "        raise NotImplementedError\n")
+	for i := range ops {
+		if err := writePythonMethod(&w, &ops[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(&w, "\n\n__all__ = [\n")
+	for _, named := range schemas {
+		fmt.Fprintf(&w, "    %q,\n", named.Name)
+	}
+	fmt.Fprintf(&w, "    \"GeneratedClient\",\n]\n")
+	return w.Bytes(), nil
+}
+
+func writePythonType(w *bytes.Buffer, name string, s *schema) error {
+	required := requiredSet(s)
+	var mandatory, optional []property
+	for _, prop := range s.Properties {
+		if pythonKeywords[prop.Name] {
+			return fmt.Errorf("schema %s property %s: Python keyword", name, prop.Name)
+		}
+		if required[prop.Name] {
+			mandatory = append(mandatory, prop)
+		} else {
+			optional = append(optional, prop)
+		}
+	}
+
+	writeFields := func(props []property) error {
+		for _, prop := range props {
+			pyType, err := pythonTypeOf(prop.Schema)
+			if err != nil {
+				return fmt.Errorf("schema %s property %s: %w", name, prop.Name, err)
+			}
+			if prop.Schema.Description != "" {
+				fmt.Fprintf(w, "    # %s\n", prop.Schema.Description)
+			}
+			fmt.Fprintf(w, "    %s: %s\n", prop.Name, pyType)
+		}
+		return nil
+	}
+
+	// Required keys go in a base class, since TypedDict totality is per class
+	base := "TypedDict"
+	if len(mandatory) > 0 && len(optional) > 0 {
+		base = "_" + name + "Required"
+		fmt.Fprintf(w, "\n\nclass %s(TypedDict):\n", base)
+		if err := writeFields(mandatory); err != nil {
+			return err
+		}
+		mandatory = nil
+	}
+
+	fmt.Fprintf(w, "\n\n")
+	if len(mandatory) > 0 {
+		fmt.Fprintf(w, "class %s(%s):\n", name, base)
+	} else {
+		fmt.Fprintf(w, "class %s(%s, total=False):\n", name, base)
+	}
+	if s.Description != "" {
+		fmt.Fprintf(w, "    \"\"\"%s %s.\"\"\"\n\n", name, strings.TrimSuffix(s.Description, "."))
+	}
+	if err := writeFields(mandatory); err != nil {
+		return err
+	}
+	return writeFields(optional)
+}
+
+// pythonTypeOf maps a schema to a type annotation
+func pythonTypeOf(s *schema) (string, error) {
+	if s.Ref != "" {
+		return lastSegment(s.Ref), nil
+	}
+	var pyType string
+	switch s.Type {
+	case "string":
+		if s.Format == "binary" {
+			pyType = "bytes"
+		} else if len(s.Enum) > 0 {
+			quoted := make([]string, len(s.Enum))
+			for i, value := range s.Enum {
+				quoted[i] = strconv.Quote(value)
+			}
+			pyType = "Literal[" + strings.Join(quoted, ", ") + "]"
+		} else {
+			pyType = "str"
+		}
+	case "integer":
+		pyType = "int"
+	case "number":
+		pyType = "float"
+	case "boolean":
+		pyType = "bool"
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		elem, err := pythonTypeOf(s.Items)
+		if err != nil {
+			return "", err
+		}
+		pyType = "List[" + elem + "]"
+	case "object":
+		var values schema
+		if len(s.AdditionalProperties) == 0 || json.Unmarshal(s.AdditionalProperties, &values) != nil {
+			pyType = "Dict[str, Any]"
+		} else {
+			elem, err := pythonTypeOf(&values)
+			if err != nil {
+				return "", err
+			}
+			pyType = "Dict[str, " + elem + "]"
+		}
+	default:
+		return "", fmt.Errorf("unsupported type %q", s.Type)
+	}
+	if s.Nullable {
+		pyType = "Optional[" + pyType + "]"
+	}
+	return pyType, nil
+}
+
+func writePythonMethod(w *bytes.Buffer, op *operation) error {
+	args := []string{"self"}
+	for _, p := range op.PathParams {
+		if pythonKeywords[p.Name] {
+			return fmt.Errorf("%s parameter %s: Python keyword", op.ID, p.Name)
+		}
+		args = append(args, p.Name+": str")
+	}
+	if op.Body != nil {
+		bodyType, err := pythonTypeOf(op.Body.Schema)
+		if err != nil {
+			return fmt.Errorf("%s request body: %w", op.ID, err)
+		}
+		args = append(args, "body: "+bodyType)
+	}
+	args = append(args, "*")
+	for _, p := range op.QueryParams {
+		if pythonKeywords[p.Name] {
+			return fmt.Errorf("%s parameter %s: Python keyword", op.ID, p.Name)
+		}
+		pyType, err := pythonTypeOf(p.Schema)
+		if err != nil {
+			return fmt.Errorf("%s parameter %s: %w", op.ID, p.Name, err)
+		}
+		args = append(args, fmt.Sprintf("%s: Optional[%s] = None", p.Name, pyType))
+	}
+	args = append(args, "headers: Optional[Dict[str, str]] = None")
+
+	returns, err := pythonResultType(op)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n    def %s(%s) -> %s:\n", snakeCase(op.ID), strings.Join(args, ", "), returns)
+	doc := docLines(op)
+	for _, p := range op.QueryParams {
+		if p.Description != "" {
+			doc = append(doc, "", p.Name+": "+p.Description)
+		}
+	}
+	fmt.Fprintf(w, "        \"\"\"%s", doc[0])
+	for _, line := range doc[1:] {
+		if line == "" {
+			fmt.Fprintf(w, "\n")
+		} else {
+			fmt.Fprintf(w, "\n        %s", line)
+		}
+	}
+	fmt.Fprintf(w, "\n        \"\"\"\n")
+
+	var path strings.Builder
+	path.WriteString("f\"")
+	for i, part := range pathTemplate(op.Path) {
+		if i%2 == 1 {
+			fmt.Fprintf(&path, "{quote(%s, safe='')}", part)
+		} else {
+			path.WriteString(part)
+		}
+	}
+	path.WriteString("\"")
+	if len(op.PathParams) == 0 {
+		path.Reset()
+		path.WriteString(strconv.Quote(op.Path))
+	}
+
+	call := []string{strconv.Quote(op.Method), path.String()}
+	if len(op.QueryParams) > 0 {
+		pairs := make([]string, len(op.QueryParams))
+		for i, p := range op.QueryParams {
+			pairs[i] = fmt.Sprintf("%q: %s", p.Name, p.Name)
+		}
+		call = append(call, "query={"+strings.Join(pairs, ", ")+"}")
+	}
+	if op.Body != nil {
+		if op.Body.ContentType == "application/json" {
+			call = append(call, "json=body")
+		} else {
+			call = append(call, "text=body")
+		}
+	}
+	if op.binary() {
+		call = append(call, "binary=True")
+	}
+	call = append(call, "headers=headers")
+	fmt.Fprintf(w, "        return self._request(%s)\n", strings.Join(call, ", "))
+	return nil
+}
+
+// pythonResultType is the return annotation of an operation's method
+func pythonResultType(op *operation) (string, error) {
+	if op.binary() {
+		return "bytes", nil
+	}
+	var types []string
+	seen := make(map[string]bool)
+	for _, r := range op.Results {
+		pyType, err := pythonTypeOf(r.Schema)
+		if err != nil {
+			return "", fmt.Errorf("%s response %s: %w", op.ID, r.Status, err)
+		}
+		if !seen[pyType] {
+			seen[pyType] = true
+			types = append(types, pyType)
+		}
+	}
+	if len(types) == 1 {
+		return types[0], nil
+	}
+	return "Union[" + strings.Join(types, ", ") + "]", nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// generateTypeScript returns a TypeScript module with an interface per
+// component schema and an abstract client with a method per operation. The
+// hand-written client in sdk/typescript supplies the transport.
+func generateTypeScript(spec []byte) ([]byte, error) {
+	ops, schemas, err := parseOperations(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "// Code generated by openapi/gen from openapi.json. DO NOT EDIT.\n")
+
+	for _, named := range schemas {
+		if err := writeTypeScriptInterface(&w, named.Name, named.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(&w, "\n/** Per-call options */\n")
+	fmt.Fprintf(&w, "export interface RequestOptions {\n")
+	fmt.Fprintf(&w, "  headers?: Record<string, string>;\n")
+	fmt.Fprintf(&w, "  signal?: AbortSignal;\n")
+	fmt.Fprintf(&w, "}\n")
+	fmt.Fprintf(&w, "\n/** A call as the generated methods describe it to the transport */\n")
+	fmt.Fprintf(&w, "export interface ApiRequest {\n")
+	fmt.Fprintf(&w, "  method: string;\n")
+	fmt.Fprintf(&w, "  path: string;\n")
+	fmt.Fprintf(&w, "  query?: Record<string, string | number | boolean | undefined>;\n")
+	fmt.Fprintf(&w, "  json?: unknown;\n")
+	fmt.Fprintf(&w, "  text?: string;\n")
+	fmt.Fprintf(&w, "  binary?: boolean;\n")
+	fmt.Fprintf(&w, "  options?: RequestOptions;\n")
+	fmt.Fprintf(&w, "}\n")
+
+	fmt.Fprintf(&w, "\n/** One method per API operation; subclasses implement request. */\n")
+	fmt.Fprintf(&w, "export abstract class GeneratedClient {\n")
+	fmt.Fprintf(&w, "  protected abstract request<T>(req: ApiRequest): Promise<T>;\n")
+	for i := range ops {
+		if err := writeTypeScriptMethod(&w, &ops[i]); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintf(&w, "}\n")
+	return w.Bytes(), nil
+}
+
+func writeTypeScriptInterface(w *bytes.Buffer, name string, s *schema) error {
+	fmt.Fprintf(w, "\n")
+	if s.Description != "" {
+		fmt.Fprintf(w, "/** %s %s */\n", name, s.Description)
+	}
+	fmt.Fprintf(w, "export interface %s {\n", name)
+	required := requiredSet(s)
+	for _, prop := range s.Properties {
+		tsType, err := typeScriptTypeOf(prop.Schema)
+		if err != nil {
+			return fmt.Errorf("schema %s property %s: %w", name, prop.Name, err)
+		}
+		if prop.Schema.Description != "" {
+			fmt.Fprintf(w, "  /** %s */\n", prop.Schema.Description)
+		}
+		optional := "?"
+		if required[prop.Name] {
+			optional = ""
+		}
+		fmt.Fprintf(w, "  %s%s: %s;\n", prop.Name, optional, tsType)
+	}
+	fmt.Fprintf(w, "}\n")
+	return nil
+}
+
+// typeScriptTypeOf maps a schema to a type
+func typeScriptTypeOf(s *schema) (string, error) {
+	if s.Ref != "" {
+		return lastSegment(s.Ref), nil
+	}
+	var tsType string
+	switch s.Type {
+	case "string":
+		if s.Format == "binary" {
+			tsType = "ArrayBuffer"
+		} else if len(s.Enum) > 0 {
+			quoted := make([]string, len(s.Enum))
+			for i, value := range s.Enum {
+				quoted[i] = strconv.Quote(value)
+			}
+			tsType = strings.Join(quoted, " | ")
+		} else {
+			tsType = "string"
+		}
+	case "integer", "number":
+		tsType = "number"
+	case "boolean":
+		tsType = "boolean"
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		elem, err := typeScriptTypeOf(s.Items)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		tsType = elem + "[]"
+	case "object":
+		if len(s.Properties) > 0 {
+			required := requiredSet(s)
+			fields := make([]string, len(s.Properties))
+			for i, prop := range s.Properties {
+				fieldType, err := typeScriptTypeOf(prop.Schema)
+				if err != nil {
+					return "", fmt.Errorf("property %s: %w", prop.Name, err)
+				}
+				optional := "?"
+				if required[prop.Name] {
+					optional = ""
+				}
+				fields[i] = fmt.Sprintf("%s%s: %s", prop.Name, optional, fieldType)
+			}
+			tsType = "{ " + strings.Join(fields, "; ") + " }"
+			break
+		}
+		var values schema
+		if len(s.AdditionalProperties) == 0 || json.Unmarshal(s.AdditionalProperties, &values) != nil {
+			tsType = "Record<string, unknown>"
+		} else {
+			elem, err := typeScriptTypeOf(&values)
+			if err != nil {
+				return "", err
+			}
+			tsType = "Record<string, " + elem + ">"
+		}
+	default:
+		return "", fmt.Errorf("unsupported type %q", s.Type)
+	}
+	if s.Nullable {
+		tsType += " | null"
+	}
+	return tsType, nil
+}
+
+func writeTypeScriptMethod(w *bytes.Buffer, op *operation) error {
+	var args []string
+	for _, p := range op.PathParams {
+		args = append(args, p.Name+": string")
+	}
+	if op.Body != nil {
+		bodyType, err := typeScriptTypeOf(op.Body.Schema)
+		if err != nil {
+			return fmt.Errorf("%s request body: %w", op.ID, err)
+		}
+		args = append(args, "body: "+bodyType)
+	}
+	if len(op.QueryParams) > 0 {
+		fields := make([]string, len(op.QueryParams))
+		for i, p := range op.QueryParams {
+			tsType, err := typeScriptTypeOf(p.Schema)
+			if err != nil {
+				return fmt.Errorf("%s parameter %s: %w", op.ID, p.Name, err)
+			}
+			fields[i] = fmt.Sprintf("%s?: %s", p.Name, tsType)
+		}
+		args = append(args, "query: { "+strings.Join(fields, "; ")+" } = {}")
+	}
+	args = append(args, "options?: RequestOptions")
+
+	returns, err := typeScriptResultType(op)
+	if err != nil {
+		return err
+	}
+
+	doc := docLines(op)
+	for i, p := range op.QueryParams {
+		if i == 0 {
+			doc = append(doc, "")
+		}
+		doc = append(doc, strings.TrimSpace(fmt.Sprintf("@param query.%s %s", p.Name, p.Description)))
+	}
+	fmt.Fprintf(w, "\n  /**\n")
+	for _, line := range doc {
+		if line == "" {
+			fmt.Fprintf(w, "   *\n")
+		} else {
+			fmt.Fprintf(w, "   * %s\n", line)
+		}
+	}
+	fmt.Fprintf(w, "   */\n")
+
+	var path strings.Builder
+	path.WriteString("`")
+	for i, part := range pathTemplate(op.Path) {
+		if i%2 == 1 {
+			fmt.Fprintf(&path, "${encodeURIComponent(%s)}", part)
+		} else {
+			path.WriteString(part)
+		}
+	}
+	path.WriteString("`")
+
+	fields := []string{"method: " + strconv.Quote(op.Method), "path: " + path.String()}
+	if len(op.QueryParams) > 0 {
+		fields = append(fields, "query")
+	}
+	if op.Body != nil {
+		if op.Body.ContentType == "application/json" {
+			fields = append(fields, "json: body")
+		} else {
+			fields = append(fields, "text: body")
+		}
+	}
+	if op.binary() {
+		fields = append(fields, "binary: true")
+	}
+	fields = append(fields, "options")
+
+	fmt.Fprintf(w, "  %s(%s): Promise<%s> {\n", op.ID, strings.Join(args, ", "), returns)
+	fmt.Fprintf(w, "    return this.request<%s>({ %s });\n", returns, strings.Join(fields, ", "))
+	fmt.Fprintf(w, "  }\n")
+	return nil
+}
+
+// typeScriptResultType is the type an operation's method resolves to
+func typeScriptResultType(op *operation) (string, error) {
+	if op.binary() {
+		return "ArrayBuffer", nil
+	}
+	var types []string
+	seen := make(map[string]bool)
+	for _, r := range op.Results {
+		tsType, err := typeScriptTypeOf(r.Schema)
+		if err != nil {
+			return "", fmt.Errorf("%s response %s: %w", op.ID, r.Status, err)
+		}
+		if !seen[tsType] {
+			seen[tsType] = true
+			types = append(types, tsType)
+		}
+	}
+	return strings.Join(types, " | "), nil
+}
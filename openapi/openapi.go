@@ -0,0 +1,214 @@
+// Package openapi holds the OpenAPI description of the REST API and the
+// request/response types generated from it. The direct, simple and queued
+// servers all use these types, so their payloads cannot drift apart.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"multithreaded-downloader/apiversion"
+)
+
+//go:generate go run ./gen -spec openapi.json -out types_gen.go
+//go:generate go run ./gen -spec openapi.json -lang python -out ../sdk/python/mtdownloader/_generated.py
+//go:generate go run ./gen -spec openapi.json -lang typescript -out ../sdk/typescript/src/generated.ts
+
+// Servers that implement a subset of the API, as listed in each operation's x-servers
+const (
+	ServerDirect = "server"
+	ServerSimple = "simple"
+	ServerQueue  = "queue"
+)
+
+// document is the full API description shared by every server
+//
+//go:embed openapi.json
+var document []byte
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Document returns the full OpenAPI document covering every server
+func Document() []byte {
+	return document
+}
+
+// Spec returns the OpenAPI document for one server and API version: only the
+// operations the server implements in that version are kept, operations with
+// an x-path are moved to that path, and properties added in later versions
+// are removed from the schemas.
+func Spec(server, version string) ([]byte, error) {
+	v, err := apiversion.Parse(version)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	filtered := make(map[string]interface{})
+	for path, rawItem := range paths {
+		item, _ := rawItem.(map[string]interface{})
+		for _, method := range httpMethods {
+			op, ok := item[method].(map[string]interface{})
+			if !ok || !implementedBy(op, server) || versionBefore(string(v), since(op)) {
+				continue
+			}
+
+			target := path
+			if xPath, ok := op["x-path"].(string); ok {
+				target = xPath
+			}
+			targetItem, ok := filtered[target].(map[string]interface{})
+			if !ok {
+				targetItem = make(map[string]interface{})
+				if params, ok := item["parameters"]; ok {
+					targetItem["parameters"] = params
+				}
+				filtered[target] = targetItem
+			}
+			targetItem[method] = op
+		}
+	}
+
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("unknown server %q", server)
+	}
+	doc["paths"] = filtered
+
+	components, _ := doc["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+	for _, rawSchema := range schemas {
+		dropNewerProperties(rawSchema, v)
+	}
+
+	servers := []interface{}{map[string]interface{}{"url": v.Prefix()}}
+	if v == apiversion.V1 {
+		servers = append(servers, map[string]interface{}{
+			"url":         "/",
+			"description": "Deprecated legacy routes without the version prefix",
+		})
+	}
+	doc["servers"] = servers
+	if info, ok := doc["info"].(map[string]interface{}); ok {
+		info["version"] = string(v)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// dropNewerProperties removes the properties of a schema that were added
+// after version, along with their required entries
+func dropNewerProperties(rawSchema interface{}, version apiversion.Version) {
+	s, _ := rawSchema.(map[string]interface{})
+	props, _ := s["properties"].(map[string]interface{})
+	dropped := make(map[string]bool)
+	for name, rawProp := range props {
+		prop, _ := rawProp.(map[string]interface{})
+		if versionBefore(string(version), since(prop)) {
+			delete(props, name)
+			dropped[name] = true
+		}
+	}
+	if required, ok := s["required"].([]interface{}); ok && len(dropped) > 0 {
+		kept := required[:0]
+		for _, name := range required {
+			if !dropped[name.(string)] {
+				kept = append(kept, name)
+			}
+		}
+		s["required"] = kept
+	}
+}
+
+// since returns the x-since of an operation or property, V1 when unset
+func since(obj map[string]interface{}) string {
+	if v, ok := obj["x-since"].(string); ok {
+		return v
+	}
+	return string(apiversion.V1)
+}
+
+// versionBefore reports whether version predates since. Unknown versions
+// count as the oldest so they never see newer fields.
+func versionBefore(version, since string) bool {
+	v, err := apiversion.Parse(version)
+	if err != nil {
+		return true
+	}
+	s, err := apiversion.Parse(since)
+	if err != nil {
+		return false
+	}
+	return !v.AtLeast(s)
+}
+
+// implementedBy reports whether an operation lists server in its x-servers
+func implementedBy(op map[string]interface{}, server string) bool {
+	servers, _ := op["x-servers"].([]interface{})
+	for _, s := range servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSpec writes the OpenAPI document for one server and the API version
+// the request was negotiated to
+func WriteSpec(w http.ResponseWriter, server, version string) {
+	spec, err := Spec(server, version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at the document
+// next to the page, so every version's /docs shows that version's document.
+// Requests tried from the page carry the token of the CSRF cookie (see
+// package browser), so they work when the server asks for one.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Multithreaded Downloader API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    function csrfToken() {
+      var match = document.cookie.match(/(?:^|; )mtdl_csrf=([^;]*)/);
+      return match ? decodeURIComponent(match[1]) : "";
+    }
+    window.ui = SwaggerUIBundle({
+      url: "openapi.json",
+      dom_id: "#swagger-ui",
+      requestInterceptor: function (req) {
+        var token = csrfToken();
+        if (token) {
+          req.headers["X-CSRF-Token"] = token;
+        }
+        return req;
+      }
+    });
+  </script>
+</body>
+</html>
+`
+
+// SwaggerUIHandler serves a Swagger UI page for the openapi.json next to it
+func SwaggerUIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	})
+}
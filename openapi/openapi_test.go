@@ -0,0 +1,280 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"multithreaded-downloader/browser"
+	"multithreaded-downloader/lifecycle"
+)
+
+type specDoc struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]interface{} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Required   []string               `json:"required"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func spec(t *testing.T, server, version string) specDoc {
+	t.Helper()
+
+	data, err := Spec(server, version)
+	if err != nil {
+		t.Fatalf("Spec(%q, %q) error = %v", server, version, err)
+	}
+	var doc specDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func specPaths(t *testing.T, server string) map[string]map[string]interface{} {
+	t.Helper()
+	return spec(t, server, "v2").Paths
+}
+
+func operations(paths map[string]map[string]interface{}) []string {
+	var ops []string
+	for path, item := range paths {
+		for method := range item {
+			if method != "parameters" {
+				ops = append(ops, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func TestSpecListsOnlyTheServersOperations(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    []string
+		wantNot []string
+	}{
+		{
+			server:  ServerSimple,
+			want:    []string{"GET /downloads", "POST /downloads", "GET /downloads/{id}/status", "GET /health"},
+			wantNot: []string{"POST /downloads/{id}/pause", "POST /cookies"},
+		},
+		{
+			server:  ServerDirect,
+			want:    []string{"POST /downloads", "POST /downloads/{id}/pause", "DELETE /downloads/{id}", "PATCH /downloads/{id}", "GET /downloads/{id}/parts", "POST /cookies", "PATCH /settings", "GET /audit", "PUT /domain-rules"},
+			wantNot: []string{"POST /groups"},
+		},
+		{
+			server:  ServerQueue,
+			want:    []string{"POST /downloads", "GET /downloads/{id}/status", "POST /groups", "GET /queue/stats", "GET /queue/completed", "GET /queue/failed", "PATCH /downloads/{id}", "POST /downloads/{id}/parts/{index}/restart", "GET /domain-rules/match"},
+			wantNot: []string{"POST /jobs", "POST /downloads/{id}/pause", "DELETE /downloads/{id}", "GET /settings"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			ops := strings.Join(operations(specPaths(t, tt.server)), "\n") + "\n"
+			for _, op := range tt.want {
+				if !strings.Contains(ops, op+"\n") {
+					t.Errorf("spec for %s is missing %s", tt.server, op)
+				}
+			}
+			for _, op := range tt.wantNot {
+				if strings.Contains(ops, op+"\n") {
+					t.Errorf("spec for %s contains %s", tt.server, op)
+				}
+			}
+		})
+	}
+
+	if _, err := Spec("unknown", "v2"); err == nil {
+		t.Error("Spec(unknown) succeeded, want an error")
+	}
+	if _, err := Spec(ServerQueue, "v9"); err == nil {
+		t.Error("Spec(queue, v9) succeeded, want an error")
+	}
+}
+
+func TestSpecDropsNewerOperationsAndProperties(t *testing.T) {
+	v1 := spec(t, ServerQueue, "v1")
+	if _, ok := v1.Paths["/groups"]; ok {
+		t.Error("v1 spec contains the v2-only /groups")
+	}
+	status := v1.Components.Schemas["QueuedDownloadStatus"]
+	if _, ok := status.Properties["throttled_by_server"]; ok {
+		t.Error("v1 QueuedDownloadStatus has throttled_by_server")
+	}
+	for _, name := range status.Required {
+		if name == "throttled_by_server" {
+			t.Error("v1 QueuedDownloadStatus still requires throttled_by_server")
+		}
+	}
+	if len(v1.Servers) != 2 || v1.Servers[0].URL != "/api/v1" || v1.Servers[1].URL != "/" {
+		t.Errorf("v1 servers = %+v, want /api/v1 and the legacy /", v1.Servers)
+	}
+
+	v2 := spec(t, ServerQueue, "v2")
+	if _, ok := v2.Paths["/groups"]; !ok {
+		t.Error("v2 spec is missing /groups")
+	}
+	if _, ok := v2.Components.Schemas["QueuedDownloadRequest"].Properties["depends_on"]; !ok {
+		t.Error("v2 QueuedDownloadRequest is missing depends_on")
+	}
+	if len(v2.Servers) != 1 || v2.Servers[0].URL != "/api/v2" {
+		t.Errorf("v2 servers = %+v, want only /api/v2", v2.Servers)
+	}
+}
+
+func TestGeneratedVersionShapes(t *testing.T) {
+	req := QueuedDownloadRequest{URL: "u", Output: "f", DependsOn: []string{"a"}}
+	if err := req.CheckVersion("v1"); err == nil || !strings.Contains(err.Error(), "depends_on requires API version v2") {
+		t.Fatalf("CheckVersion(v1) error = %v, want depends_on rejected", err)
+	}
+	if err := req.CheckVersion("v2"); err != nil {
+		t.Fatalf("CheckVersion(v2) error = %v", err)
+	}
+
+	list := QueuedDownloadList{
+		Downloads: []QueuedDownloadStatus{{JobID: "a", DependsOn: []string{"b"}, ThrottledByServer: true}},
+		Count:     1,
+	}
+	data, err := json.Marshal(list.V1())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "depends_on") || strings.Contains(string(data), "throttled_by_server") {
+		t.Fatalf("v1 list leaks v2 fields: %s", data)
+	}
+	if !strings.Contains(string(data), `"job_id":"a"`) {
+		t.Fatalf("v1 list lost the job: %s", data)
+	}
+}
+
+func TestQueueSpecUsesQueuedSchemas(t *testing.T) {
+	paths := specPaths(t, ServerQueue)
+	post, _ := json.Marshal(paths["/downloads"]["post"])
+	if !strings.Contains(string(post), "QueuedDownloadRequest") {
+		t.Fatalf("queued POST /downloads does not use QueuedDownloadRequest: %s", post)
+	}
+	if _, ok := paths["/downloads/{id}/status"]["parameters"]; !ok {
+		t.Fatal("path parameters were not carried over to the x-path")
+	}
+}
+
+func TestDocumentReferencesResolve(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(Document(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	components := doc["components"].(map[string]interface{})
+
+	refs := regexp.MustCompile(`"\$ref":\s*"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(Document()), -1)
+	if len(refs) == 0 {
+		t.Fatal("no references found")
+	}
+	for _, ref := range refs {
+		section, _ := components[ref[1]].(map[string]interface{})
+		if _, ok := section[ref[2]]; !ok {
+			t.Errorf("unresolved reference #/components/%s/%s", ref[1], ref[2])
+		}
+	}
+}
+
+func TestGeneratedValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     DownloadRequest
+		wantErr string
+	}{
+		{name: "valid", req: DownloadRequest{URL: "http://example.com/f", Output: "f"}},
+		{name: "missing url", req: DownloadRequest{Output: "f"}, wantErr: "url is required"},
+		{name: "missing output", req: DownloadRequest{URL: "http://example.com/f"}, wantErr: "output is required"},
+		{name: "too many threads", req: DownloadRequest{URL: "u", Output: "f", Threads: 17}, wantErr: "threads must be at most 16"},
+		{name: "negative threads", req: DownloadRequest{URL: "u", Output: "f", Threads: -1}, wantErr: "threads must be at least 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	req := DownloadRequest{URL: "u", Output: "f"}
+	req.ApplyDefaults()
+	if req.Threads != 4 {
+		t.Fatalf("ApplyDefaults() threads = %d, want 4", req.Threads)
+	}
+
+	status := QueuedDownloadStatus{Status: "running"}
+	if err := status.Validate(); err == nil {
+		t.Fatal("Validate() accepted an undocumented status")
+	}
+}
+
+func TestWriteSpecServesJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteSpec(rec, ServerQueue, "v2")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("WriteSpec() = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Fatal("WriteSpec() served invalid JSON")
+	}
+
+	rec = httptest.NewRecorder()
+	WriteSpec(rec, ServerQueue, "v0")
+	if rec.Code != 500 {
+		t.Fatalf("WriteSpec(v0) = %d, want 500", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	SwaggerUIHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	if !strings.Contains(rec.Body.String(), `url: "openapi.json"`) {
+		t.Fatal("Swagger UI page does not load the spec next to it")
+	}
+	if !strings.Contains(rec.Body.String(), browser.CSRFCookie+"=") || !strings.Contains(rec.Body.String(), browser.CSRFHeader) {
+		t.Fatal("Swagger UI page does not send the CSRF token")
+	}
+}
+
+func TestStatusEnumsCoverLifecycle(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Enum []string `json:"enum"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(Document(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"DownloadStatus", "QueuedDownloadStatus"} {
+		enum := strings.Join(doc.Components.Schemas[name].Properties["status"].Enum, ",") + ","
+		for _, status := range lifecycle.All {
+			if !strings.Contains(enum, string(status)+",") {
+				t.Errorf("%s.status enum is missing %s", name, status)
+			}
+		}
+	}
+}
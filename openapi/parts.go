@@ -0,0 +1,38 @@
+package openapi
+
+import (
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+// NewDownloadParts describes the parts of the download id for the parts
+// route of every server
+func NewDownloadParts(id string, parts []downloader.PartConnection) DownloadParts {
+	out := DownloadParts{DownloadID: id, Parts: make([]PartConnection, len(parts))}
+	for i, part := range parts {
+		out.Parts[i] = PartConnection{
+			Index:        part.Index,
+			Start:        part.Start,
+			End:          part.End,
+			Downloaded:   part.Downloaded,
+			State:        string(part.State),
+			RemoteAddr:   part.RemoteAddr,
+			Bytes:        part.Bytes,
+			Speed:        part.Speed,
+			StartedAt:    timestamp(part.StartedAt),
+			LastActivity: timestamp(part.LastActivity),
+			Attempts:     part.Attempts,
+			LastError:    part.LastError,
+		}
+	}
+	return out
+}
+
+// timestamp formats t as RFC 3339, leaving zero times out
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
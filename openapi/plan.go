@@ -0,0 +1,48 @@
+package openapi
+
+import (
+	"sort"
+
+	"multithreaded-downloader/downloader"
+)
+
+// NewPartPlan describes the part plan of a download for the plan route
+func NewPartPlan(plan *downloader.Plan) PartPlan {
+	out := PartPlan{Version: plan.Version, URL: plan.URL, TotalSize: plan.TotalSize, Etag: plan.ETag, Parts: make([]PlannedPart, len(plan.Parts))}
+	for i, part := range plan.Parts {
+		out.Parts[i] = PlannedPart{Index: part.Index, Start: part.Start, End: part.End, Source: part.Source}
+	}
+	return out
+}
+
+// PartPlanFromParts makes a plan of the parts a worker reported for the
+// download of url, which say nothing of their sources
+func PartPlanFromParts(url string, parts DownloadParts) PartPlan {
+	sorted := append([]PartConnection(nil), parts.Parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Index < sorted[j].Index })
+	out := PartPlan{Version: downloader.PlanVersion, URL: url, Parts: make([]PlannedPart, len(sorted))}
+	for i, part := range sorted {
+		out.Parts[i] = PlannedPart{Index: part.Index, Start: part.Start, End: part.End}
+		if part.End+1 > out.TotalSize {
+			out.TotalSize = part.End + 1
+		}
+	}
+	return out
+}
+
+// DownloaderPlan checks the plan against the OpenAPI document and returns
+// it for a downloader, which checks it against the file
+func (v *PartPlan) DownloaderPlan() (*downloader.Plan, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+	plan := &downloader.Plan{Version: v.Version, URL: v.URL, TotalSize: v.TotalSize, ETag: v.Etag, Parts: make([]downloader.PlanPart, len(v.Parts))}
+	for i := range v.Parts {
+		part := &v.Parts[i]
+		if err := part.Validate(); err != nil {
+			return nil, err
+		}
+		plan.Parts[i] = downloader.PlanPart{Index: part.Index, Start: part.Start, End: part.End, Source: part.Source}
+	}
+	return plan, nil
+}
@@ -0,0 +1,33 @@
+package openapi
+
+import (
+	"time"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/secrets"
+)
+
+// NewProbe describes a range check for the probe route of every server
+func NewProbe(probe downloader.ProbeResult) Probe {
+	return Probe{
+		SupportsRanges: probe.SupportsRanges,
+		SizeBytes:      probe.Size,
+		Etag:           probe.ETag,
+		LastModified:   probe.LastModified,
+		FinalURL:       secrets.RedactURL(probe.FinalURL),
+		AcceptRanges:   probe.AcceptRangesRaw,
+		Server:         probe.Server,
+		ProbedAt:       probe.ProbedAt.Format(time.RFC3339),
+	}
+}
+
+// NewCachedProbe describes a range check a queued job will start from
+func NewCachedProbe(probe downloader.ProbeResult) *CachedProbe {
+	return &CachedProbe{
+		SupportsRanges: probe.SupportsRanges,
+		SizeBytes:      probe.Size,
+		Etag:           probe.ETag,
+		LastModified:   probe.LastModified,
+		ProbedAt:       probe.ProbedAt.Format(time.RFC3339),
+	}
+}
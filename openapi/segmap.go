@@ -0,0 +1,84 @@
+package openapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"multithreaded-downloader/downloader"
+)
+
+// Bounds of the pieces query parameter of the map route
+const (
+	DefaultMapPieces = 256
+	MaxMapPieces     = 8192
+)
+
+// ParseMapPieces reads the pieces query parameter of the map route; empty
+// means DefaultMapPieces
+func ParseMapPieces(raw string) (int, error) {
+	if raw == "" {
+		return DefaultMapPieces, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > MaxMapPieces {
+		return 0, fmt.Errorf("pieces must be a number from 1 to %d, got %q", MaxMapPieces, raw)
+	}
+	return n, nil
+}
+
+// NewSegmentMap summarizes which bytes of a download its parts completed,
+// with the file cut into at most pieces pieces for the bitmap
+func NewSegmentMap(parts DownloadParts, pieces int) SegmentMap {
+	sorted := append([]PartConnection(nil), parts.Parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	out := SegmentMap{DownloadID: parts.DownloadID, Segments: []ByteRange{}, Active: []ByteRange{}}
+	for _, part := range sorted {
+		if part.End+1 > out.TotalSize {
+			out.TotalSize = part.End + 1
+		}
+		if part.Downloaded > 0 {
+			out.DownloadedBytes += part.Downloaded
+			end := part.Start + part.Downloaded - 1
+			if last := len(out.Segments) - 1; last >= 0 && out.Segments[last].End+1 == part.Start {
+				out.Segments[last].End = end
+			} else {
+				out.Segments = append(out.Segments, ByteRange{Start: part.Start, End: end})
+			}
+		}
+		switch downloader.PartState(part.State) {
+		case downloader.PartConnecting, downloader.PartTransferring, downloader.PartRetrying:
+			if part.Start+part.Downloaded <= part.End {
+				out.Active = append(out.Active, ByteRange{Start: part.Start + part.Downloaded, End: part.End})
+			}
+		}
+	}
+	if len(out.Segments) > 0 && out.Segments[0].Start == 0 {
+		out.ContiguousBytes = out.Segments[0].End + 1
+	}
+
+	if out.TotalSize == 0 || pieces < 1 {
+		return out
+	}
+	out.PieceSize = (out.TotalSize + int64(pieces) - 1) / int64(pieces)
+	out.Pieces = int((out.TotalSize + out.PieceSize - 1) / out.PieceSize)
+	bitmap := make([]byte, (out.Pieces+7)/8)
+	segment := 0
+	for i := 0; i < out.Pieces; i++ {
+		start := int64(i) * out.PieceSize
+		end := start + out.PieceSize - 1
+		if end >= out.TotalSize {
+			end = out.TotalSize - 1
+		}
+		for segment < len(out.Segments) && out.Segments[segment].End < start {
+			segment++
+		}
+		if segment < len(out.Segments) && out.Segments[segment].Start <= start && out.Segments[segment].End >= end {
+			bitmap[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	out.Bitmap = base64.StdEncoding.EncodeToString(bitmap)
+	return out
+}
@@ -0,0 +1,54 @@
+package openapi
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestNewSegmentMap(t *testing.T) {
+	// A sequential download: the first two parts done, the third halfway
+	// and the fourth connecting
+	parts := DownloadParts{DownloadID: "d1", Parts: []PartConnection{
+		{Index: 0, Start: 0, End: 99, Downloaded: 100, State: "done"},
+		{Index: 1, Start: 100, End: 199, Downloaded: 100, State: "done"},
+		{Index: 2, Start: 200, End: 299, Downloaded: 50, State: "transferring"},
+		{Index: 3, Start: 300, End: 399, State: "connecting"},
+		{Index: 4, Start: 400, End: 409, State: "pending"},
+	}}
+	m := NewSegmentMap(parts, 8)
+
+	if m.TotalSize != 410 || m.PieceSize != 52 || m.Pieces != 8 {
+		t.Errorf("total %d, piece size %d, pieces %d", m.TotalSize, m.PieceSize, m.Pieces)
+	}
+	if len(m.Segments) != 1 || m.Segments[0] != (ByteRange{Start: 0, End: 249}) {
+		t.Errorf("segments = %+v, want 0-249", m.Segments)
+	}
+	if m.DownloadedBytes != 250 || m.ContiguousBytes != 250 {
+		t.Errorf("downloaded %d, contiguous %d", m.DownloadedBytes, m.ContiguousBytes)
+	}
+	if len(m.Active) != 2 || m.Active[0] != (ByteRange{Start: 250, End: 299}) || m.Active[1] != (ByteRange{Start: 300, End: 399}) {
+		t.Errorf("active = %+v", m.Active)
+	}
+	// Pieces 0-3 end by byte 207; piece 4 (208-259) is not complete
+	bitmap, err := base64.StdEncoding.DecodeString(m.Bitmap)
+	if err != nil || len(bitmap) != 1 || bitmap[0] != 0xf0 {
+		t.Errorf("bitmap = %08b, %v", bitmap, err)
+	}
+
+	// A file smaller than the pieces asked for has a piece per byte
+	small := NewSegmentMap(DownloadParts{Parts: []PartConnection{{Start: 0, End: 2, Downloaded: 1}}}, 256)
+	if small.Pieces != 3 || small.PieceSize != 1 || small.Bitmap != base64.StdEncoding.EncodeToString([]byte{0x80}) {
+		t.Errorf("small file: %d pieces of %d bytes, bitmap %q", small.Pieces, small.PieceSize, small.Bitmap)
+	}
+}
+
+func TestParseMapPieces(t *testing.T) {
+	if n, err := ParseMapPieces(""); n != DefaultMapPieces || err != nil {
+		t.Errorf("ParseMapPieces(\"\") = %d, %v", n, err)
+	}
+	for _, raw := range []string{"0", "-1", "x", "8193"} {
+		if _, err := ParseMapPieces(raw); err == nil {
+			t.Errorf("ParseMapPieces(%q) accepted", raw)
+		}
+	}
+}
@@ -0,0 +1,1871 @@
+// Code generated by openapi/gen from openapi.json. DO NOT EDIT.
+
+package openapi
+
+import "fmt"
+
+// ErrorResponse is returned with every 4xx and 5xx response
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+	// Fields of a request body that are not valid, each with what is wrong with it; set only with 400 Invalid request body
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is a request field that breaks a constraint of the document
+type FieldError struct {
+	// JSON name of the field, with an index for array items such as part_urls[1]
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// MessageResponse acknowledges an action that returns no other data
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// HealthResponse reports whether a server and its dependencies are up
+type HealthResponse struct {
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+	Version   string `json:"version"`
+	// Health of each dependency, reported by the queued server
+	Checks map[string]bool `json:"checks,omitempty"`
+	// Result and latency of each dependency check: redis and database on the queued server, filesystem where downloads are written
+	Dependencies  map[string]DependencyCheck `json:"dependencies,omitempty"`
+	Build         *BuildInfo                 `json:"build,omitempty"`
+	UptimeSeconds int64                      `json:"uptime_seconds,omitempty"`
+	Goroutines    int                        `json:"goroutines,omitempty"`
+	// Space left where downloads are written, on servers that write them
+	Disk *DiskSpace `json:"disk,omitempty"`
+}
+
+// Validate checks HealthResponse against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *HealthResponse) Validate() error {
+	var errs ValidationErrors
+	switch v.Status {
+	case "healthy", "unhealthy":
+	default:
+		errs.add("status", "status must be one of healthy, unhealthy, got %q", v.Status)
+	}
+	return errs.err()
+}
+
+// HealthResponseV1 is HealthResponse as served by API version v1
+type HealthResponseV1 struct {
+	Status    string          `json:"status"`
+	Timestamp string          `json:"timestamp"`
+	Version   string          `json:"version"`
+	Checks    map[string]bool `json:"checks,omitempty"`
+}
+
+// V1 converts HealthResponse to its v1 shape
+func (v *HealthResponse) V1() HealthResponseV1 {
+	out := HealthResponseV1{
+		Status:    v.Status,
+		Timestamp: v.Timestamp,
+		Version:   v.Version,
+		Checks:    v.Checks,
+	}
+	return out
+}
+
+// CheckVersion rejects fields of HealthResponse that the given API version does not have
+func (v *HealthResponse) CheckVersion(version string) error {
+	if len(v.Dependencies) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("dependencies requires API version v2")
+	}
+	if v.Build != nil && versionBefore(version, "v2") {
+		return fmt.Errorf("build requires API version v2")
+	}
+	if v.UptimeSeconds != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("uptime_seconds requires API version v2")
+	}
+	if v.Goroutines != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("goroutines requires API version v2")
+	}
+	if v.Disk != nil && versionBefore(version, "v2") {
+		return fmt.Errorf("disk requires API version v2")
+	}
+	return nil
+}
+
+// DependencyCheck is the result of checking one dependency of a server
+type DependencyCheck struct {
+	Healthy bool `json:"healthy"`
+	// How long the check took, in milliseconds
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// BuildInfo describes the build of a server
+type BuildInfo struct {
+	// Release the binary was built from, set with -ldflags; dev otherwise
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// DiskSpace is the space of the file system holding a directory
+type DiskSpace struct {
+	Path string `json:"path"`
+	// Bytes available to the server's user
+	FreeBytes  int64 `json:"free_bytes"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// DownloadRequest represents the JSON request body for starting a download
+type DownloadRequest struct {
+	// Absolute http or https URL of the file
+	URL string `json:"url"`
+	// Output path; may use template variables such as {date}/{domain}/{filename}. It must be UTF-8 without control characters, at most 4096 bytes with names of at most 255, name a file rather than a directory, and be relative to the download directory without ".." names
+	Output           string `json:"output"`
+	Threads          int    `json:"threads,omitempty"`
+	UserAgent        string `json:"user_agent,omitempty"`
+	UserAgentProfile string `json:"user_agent_profile,omitempty"`
+	Referer          string `json:"referer,omitempty"`
+	// HTTP method the file is requested with; defaults to GET, or POST when a body is given. Downloads other than a plain GET run as a single stream
+	Method string `json:"method,omitempty"`
+	// Request body sent with method, for endpoints that export files only to a POST
+	Body string `json:"body,omitempty"`
+	// Encoding of body; defaults to form
+	BodyType string `json:"body_type,omitempty"`
+	// Pieces of a file published split into several URLs, such as file.z01 and file.z02, in order; they are downloaded in parallel and joined into output as one download. url must be the first of them
+	PartURLs []string `json:"part_urls,omitempty"`
+	// Other URLs serving the same file. Each is probed with a small range request, and parts are spread over the fastest mirror_count of them, re-probed during long downloads; mirrors announced with Link rel=duplicate headers are added. Headers are sent to url's host only
+	Mirrors []string `json:"mirrors,omitempty"`
+	// How many of the fastest mirrors parts are spread over; 0 picks 3
+	MirrorCount int `json:"mirror_count,omitempty"`
+	// Labels the bytes the download transfers are accounted to in GET /usage, e.g. a team or project; letters, digits and . _ : -, starting with a letter or digit
+	Tags []string `json:"tags,omitempty"`
+	// Endpoint POSTed {"url": <expired link>} when the server refuses the link as expired, e.g. a pre-signed S3 URL; it answers with a new link, as JSON {"url": ...} or text, and the download continues with it
+	RefreshURL string `json:"refresh_url,omitempty"`
+	// Download the first and last this many bytes before the rest, e.g. for a zip's central directory or an MP4's moov atom; read them from GET /downloads/{id}/preview
+	PreviewBytes int64 `json:"preview_bytes,omitempty"`
+	// Network interface such as eth1, or local IP address, the download connects from, on hosts with several networks
+	Bind string `json:"bind,omitempty"`
+	// Bytes per second shared by all downloads bound to the same interface or address; 0 is unlimited
+	BindRateLimit int64 `json:"bind_rate_limit,omitempty"`
+	// Write <output>.done, a JSON marker with the file's size, SHA-256 and validators, through a rename once the download is verified, so pipelines watching the directory can tell a finished file from one still growing; an older marker is removed when the download starts
+	DoneMarker bool `json:"done_marker,omitempty"`
+	// Split the file into exactly these parts, as GET /downloads/{id}/plan exported them, fetching each from the server it names, instead of splitting it by threads; the plan must cover the file the server has. Not with preview_bytes
+	Plan *PartPlan `json:"plan,omitempty"`
+}
+
+// Validate checks DownloadRequest against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *DownloadRequest) Validate() error {
+	var errs ValidationErrors
+	if len(v.URL) == 0 {
+		errs.add("url", "url is required")
+	}
+	if len(v.URL) > 8192 {
+		errs.add("url", "url must be at most 8192 characters, got %d", len(v.URL))
+	}
+	if v.URL != "" {
+		if err := CheckURL(v.URL); err != nil {
+			errs.add("url", "url %v", err)
+		}
+	}
+	if len(v.Output) == 0 {
+		errs.add("output", "output is required")
+	}
+	if v.Output != "" {
+		if err := CheckOutputPath(v.Output); err != nil {
+			errs.add("output", "output %v", err)
+		}
+	}
+	if v.Threads < 0 {
+		errs.add("threads", "threads must be at least 0, got %v", v.Threads)
+	}
+	if v.Threads > 16 {
+		errs.add("threads", "threads must be at most 16, got %v", v.Threads)
+	}
+	switch v.Method {
+	case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		errs.add("method", "method must be one of GET, POST, PUT, PATCH, DELETE, got %q", v.Method)
+	}
+	switch v.BodyType {
+	case "", "form", "json":
+	default:
+		errs.add("body_type", "body_type must be one of form, json, got %q", v.BodyType)
+	}
+	for i, item := range v.PartURLs {
+		if err := CheckURL(item); err != nil {
+			errs.add(fmt.Sprintf("part_urls[%d]", i), "part_urls[%d] %v", i, err)
+		}
+	}
+	for i, item := range v.Mirrors {
+		if err := CheckURL(item); err != nil {
+			errs.add(fmt.Sprintf("mirrors[%d]", i), "mirrors[%d] %v", i, err)
+		}
+	}
+	if v.MirrorCount < 0 {
+		errs.add("mirror_count", "mirror_count must be at least 0, got %v", v.MirrorCount)
+	}
+	if v.MirrorCount > 20 {
+		errs.add("mirror_count", "mirror_count must be at most 20, got %v", v.MirrorCount)
+	}
+	if v.RefreshURL != "" {
+		if err := CheckURL(v.RefreshURL); err != nil {
+			errs.add("refresh_url", "refresh_url %v", err)
+		}
+	}
+	if v.PreviewBytes < 0 {
+		errs.add("preview_bytes", "preview_bytes must be at least 0, got %v", v.PreviewBytes)
+	}
+	if v.BindRateLimit < 0 {
+		errs.add("bind_rate_limit", "bind_rate_limit must be at least 0, got %v", v.BindRateLimit)
+	}
+	return errs.err()
+}
+
+// ApplyDefaults fills unset fields of DownloadRequest with their documented defaults
+func (v *DownloadRequest) ApplyDefaults() {
+	if v.Threads == 0 {
+		v.Threads = 4
+	}
+}
+
+// DownloadRequestV1 is DownloadRequest as served by API version v1
+type DownloadRequestV1 struct {
+	URL              string `json:"url"`
+	Output           string `json:"output"`
+	Threads          int    `json:"threads,omitempty"`
+	UserAgent        string `json:"user_agent,omitempty"`
+	UserAgentProfile string `json:"user_agent_profile,omitempty"`
+	Referer          string `json:"referer,omitempty"`
+}
+
+// V1 converts DownloadRequest to its v1 shape
+func (v *DownloadRequest) V1() DownloadRequestV1 {
+	out := DownloadRequestV1{
+		URL:              v.URL,
+		Output:           v.Output,
+		Threads:          v.Threads,
+		UserAgent:        v.UserAgent,
+		UserAgentProfile: v.UserAgentProfile,
+		Referer:          v.Referer,
+	}
+	return out
+}
+
+// CheckVersion rejects fields of DownloadRequest that the given API version does not have
+func (v *DownloadRequest) CheckVersion(version string) error {
+	if v.Method != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("method requires API version v2")
+	}
+	if v.Body != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("body requires API version v2")
+	}
+	if v.BodyType != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("body_type requires API version v2")
+	}
+	if len(v.PartURLs) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("part_urls requires API version v2")
+	}
+	if len(v.Mirrors) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("mirrors requires API version v2")
+	}
+	if v.MirrorCount != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("mirror_count requires API version v2")
+	}
+	if len(v.Tags) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("tags requires API version v2")
+	}
+	if v.RefreshURL != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("refresh_url requires API version v2")
+	}
+	if v.PreviewBytes != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("preview_bytes requires API version v2")
+	}
+	if v.Bind != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("bind requires API version v2")
+	}
+	if v.BindRateLimit != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("bind_rate_limit requires API version v2")
+	}
+	if v.DoneMarker && versionBefore(version, "v2") {
+		return fmt.Errorf("done_marker requires API version v2")
+	}
+	if v.Plan != nil && versionBefore(version, "v2") {
+		return fmt.Errorf("plan requires API version v2")
+	}
+	return nil
+}
+
+// DownloadResponse represents the response when starting a download
+type DownloadResponse struct {
+	DownloadID string `json:"download_id"`
+	Message    string `json:"message"`
+}
+
+// DownloadStatus represents the current status of a download
+type DownloadStatus struct {
+	DownloadID       string  `json:"download_id"`
+	URL              string  `json:"url"`
+	Filename         string  `json:"filename"`
+	Status           string  `json:"status"`
+	PercentCompleted float64 `json:"percent_completed"`
+	BytesDownloaded  int64   `json:"bytes_downloaded"`
+	TotalSize        int64   `json:"total_size"`
+	// Parts the file was split into; fewer than asked for when parts would fall below the minimum part size
+	ThreadsUsed int `json:"threads_used"`
+	// Threads the download asked for
+	ThreadsRequested int `json:"threads_requested,omitempty"`
+	// Result of checking the finished file against a checksum the server sent in Content-MD5, Digest, Repr-Digest or Content-Digest; absent when it sent none
+	ChecksumStatus string `json:"checksum_status,omitempty"`
+	// Algorithm of the checksum checked: md5, sha-1, sha-256 or sha-512
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	// Header or trailer the checksum was read from
+	ChecksumSource string `json:"checksum_source,omitempty"`
+	StartTime      string `json:"start_time"`
+	Error          string `json:"error,omitempty"`
+	// ThrottledByServer is set while the origin has asked us to back off via Retry-After
+	ThrottledByServer bool   `json:"throttled_by_server"`
+	ThrottledUntil    string `json:"throttled_until,omitempty"`
+	// Bytes per second over the last few seconds, from the live progress events
+	CurrentSpeed float64 `json:"current_speed"`
+	// Parts transferring right now
+	ActiveConnections int `json:"active_connections"`
+	// Set once the head and tail asked for with preview_bytes are downloaded, or the whole file without them
+	PreviewReady bool `json:"preview_ready,omitempty"`
+	// When the download was last seen receiving data
+	LastByteAt string `json:"last_byte_at,omitempty"`
+}
+
+// Validate checks DownloadStatus against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *DownloadStatus) Validate() error {
+	var errs ValidationErrors
+	switch v.Status {
+	case "waiting", "queued", "downloading", "waiting_network", "paused", "completed", "not_modified", "failed":
+	default:
+		errs.add("status", "status must be one of waiting, queued, downloading, waiting_network, paused, completed, not_modified, failed, got %q", v.Status)
+	}
+	switch v.ChecksumStatus {
+	case "", "verified", "mismatch":
+	default:
+		errs.add("checksum_status", "checksum_status must be one of verified, mismatch, got %q", v.ChecksumStatus)
+	}
+	return errs.err()
+}
+
+// DownloadStatusV1 is DownloadStatus as served by API version v1
+type DownloadStatusV1 struct {
+	DownloadID        string  `json:"download_id"`
+	URL               string  `json:"url"`
+	Filename          string  `json:"filename"`
+	Status            string  `json:"status"`
+	PercentCompleted  float64 `json:"percent_completed"`
+	BytesDownloaded   int64   `json:"bytes_downloaded"`
+	TotalSize         int64   `json:"total_size"`
+	ThreadsUsed       int     `json:"threads_used"`
+	StartTime         string  `json:"start_time"`
+	Error             string  `json:"error,omitempty"`
+	ThrottledByServer bool    `json:"throttled_by_server"`
+	ThrottledUntil    string  `json:"throttled_until,omitempty"`
+}
+
+// V1 converts DownloadStatus to its v1 shape
+func (v *DownloadStatus) V1() DownloadStatusV1 {
+	out := DownloadStatusV1{
+		DownloadID:        v.DownloadID,
+		URL:               v.URL,
+		Filename:          v.Filename,
+		Status:            v.Status,
+		PercentCompleted:  v.PercentCompleted,
+		BytesDownloaded:   v.BytesDownloaded,
+		TotalSize:         v.TotalSize,
+		ThreadsUsed:       v.ThreadsUsed,
+		StartTime:         v.StartTime,
+		Error:             v.Error,
+		ThrottledByServer: v.ThrottledByServer,
+		ThrottledUntil:    v.ThrottledUntil,
+	}
+	return out
+}
+
+// CheckVersion rejects fields of DownloadStatus that the given API version does not have
+func (v *DownloadStatus) CheckVersion(version string) error {
+	if v.ThreadsRequested != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("threads_requested requires API version v2")
+	}
+	if v.ChecksumStatus != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("checksum_status requires API version v2")
+	}
+	if v.ChecksumAlgorithm != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("checksum_algorithm requires API version v2")
+	}
+	if v.ChecksumSource != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("checksum_source requires API version v2")
+	}
+	if v.CurrentSpeed != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("current_speed requires API version v2")
+	}
+	if v.ActiveConnections != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("active_connections requires API version v2")
+	}
+	if v.PreviewReady && versionBefore(version, "v2") {
+		return fmt.Errorf("preview_ready requires API version v2")
+	}
+	if v.LastByteAt != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("last_byte_at requires API version v2")
+	}
+	return nil
+}
+
+// DownloadAdjustment changes the limits of a running download; omitted fields keep their value
+type DownloadAdjustment struct {
+	// Bytes per second across all threads; 0 lifts the limit
+	RateLimit *int64 `json:"rate_limit,omitempty"`
+	// Threads transferring at once, at most the number the download started with
+	Threads *int `json:"threads,omitempty"`
+}
+
+// Validate checks DownloadAdjustment against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *DownloadAdjustment) Validate() error {
+	var errs ValidationErrors
+	if v.RateLimit != nil && *v.RateLimit < 0 {
+		errs.add("rate_limit", "rate_limit must be at least 0, got %v", *v.RateLimit)
+	}
+	if v.Threads != nil && *v.Threads < 1 {
+		errs.add("threads", "threads must be at least 1, got %v", *v.Threads)
+	}
+	if v.Threads != nil && *v.Threads > 16 {
+		errs.add("threads", "threads must be at most 16, got %v", *v.Threads)
+	}
+	return errs.err()
+}
+
+// DownloadLimits reports the limits a running download uses
+type DownloadLimits struct {
+	DownloadID    string `json:"download_id"`
+	RateLimit     int64  `json:"rate_limit"`
+	Threads       int    `json:"threads"`
+	ActiveThreads int    `json:"active_threads"`
+}
+
+// PartConnection describes a part of a download and the connection it is downloading over
+type PartConnection struct {
+	Index int   `json:"index"`
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Bytes of the part written so far
+	Downloaded int64  `json:"downloaded"`
+	State      string `json:"state"`
+	// Address of the server the part's connection goes to
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// Bytes received over the current connection
+	Bytes int64 `json:"bytes"`
+	// Average bytes per second over the current connection
+	Speed float64 `json:"speed"`
+	// When the current request was sent
+	StartedAt string `json:"started_at,omitempty"`
+	// When the part last received bytes or changed state
+	LastActivity string `json:"last_activity,omitempty"`
+	// Requests sent for the part since the download started or resumed
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Validate checks PartConnection against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *PartConnection) Validate() error {
+	var errs ValidationErrors
+	switch v.State {
+	case "pending", "connecting", "transferring", "retrying", "done":
+	default:
+		errs.add("state", "state must be one of pending, connecting, transferring, retrying, done, got %q", v.State)
+	}
+	return errs.err()
+}
+
+// DownloadParts lists the parts of a running download with their connections
+type DownloadParts struct {
+	DownloadID string           `json:"download_id"`
+	Parts      []PartConnection `json:"parts"`
+}
+
+// SegmentMap shows which byte ranges of a download are complete
+type SegmentMap struct {
+	DownloadID string `json:"download_id"`
+	TotalSize  int64  `json:"total_size"`
+	// Bytes of each piece; the last one may be shorter
+	PieceSize int64 `json:"piece_size"`
+	Pieces    int   `json:"pieces"`
+	// Base64 of one bit per piece, set once every byte of the piece is downloaded; the first piece is the high bit of the first byte, as in a BitTorrent bitfield
+	Bitmap string `json:"bitmap"`
+	// Downloaded byte ranges in order, neighbouring ranges merged
+	Segments []ByteRange `json:"segments"`
+	// Byte ranges parts are requesting or transferring now
+	Active          []ByteRange `json:"active"`
+	DownloadedBytes int64       `json:"downloaded_bytes"`
+	// Bytes downloaded from the start of the file without a gap, how much of a sequential download can be played
+	ContiguousBytes int64 `json:"contiguous_bytes"`
+}
+
+// PartPlan is the exact split of a download into parts and the server each part is fetched from
+type PartPlan struct {
+	Version int `json:"version"`
+	// URL of the file, credentials redacted
+	URL       string `json:"url"`
+	TotalSize int64  `json:"total_size"`
+	// Version of the file the plan was made for; a download of another version uses the plan with a warning
+	Etag  string        `json:"etag,omitempty"`
+	Parts []PlannedPart `json:"parts"`
+}
+
+// Validate checks PartPlan against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *PartPlan) Validate() error {
+	var errs ValidationErrors
+	if v.Version < 1 {
+		errs.add("version", "version must be at least 1, got %v", v.Version)
+	}
+	if v.Version > 1 {
+		errs.add("version", "version must be at most 1, got %v", v.Version)
+	}
+	if v.TotalSize < 0 {
+		errs.add("total_size", "total_size must be at least 0, got %v", v.TotalSize)
+	}
+	return errs.err()
+}
+
+// PlannedPart is one part of a plan: the inclusive byte range it covers and where it comes from
+type PlannedPart struct {
+	Index int   `json:"index"`
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// The piece of a joined file holding the part, or the URL or mirror the part is pinned to; without one a download with mirrors gives the part to the fastest
+	Source string `json:"source,omitempty"`
+}
+
+// Validate checks PlannedPart against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *PlannedPart) Validate() error {
+	var errs ValidationErrors
+	if v.Index < 0 {
+		errs.add("index", "index must be at least 0, got %v", v.Index)
+	}
+	if v.Start < 0 {
+		errs.add("start", "start must be at least 0, got %v", v.Start)
+	}
+	return errs.err()
+}
+
+// DownloadEnvironment records everything that decided how a download ran, credentials redacted
+type DownloadEnvironment struct {
+	DownloadID string `json:"download_id"`
+	Version    int    `json:"version"`
+	CapturedAt string `json:"captured_at"`
+	// When the download was verified; unset before
+	CompletedAt string `json:"completed_at,omitempty"`
+	// Go runtime of the process that ran the download
+	Go string `json:"go"`
+	// os/arch of the process that ran the download
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+	// URLs the probe was redirected from, in order, before final_url
+	Redirects []string `json:"redirects,omitempty"`
+	FinalURL  string   `json:"final_url,omitempty"`
+	// File name of the output
+	Output string `json:"output"`
+	// The download continued saved progress without a new probe
+	Resumed      bool               `json:"resumed,omitempty"`
+	Request      EnvironmentRequest `json:"request"`
+	Config       EnvironmentConfig  `json:"config"`
+	Resolved     []ResolvedHost     `json:"resolved,omitempty"`
+	Server       *EnvironmentServer `json:"server,omitempty"`
+	TotalSize    int64              `json:"total_size"`
+	Parts        int                `json:"parts"`
+	Etag         string             `json:"etag,omitempty"`
+	LastModified string             `json:"last_modified,omitempty"`
+	// Server addresses the parts were fetched from, once the download is verified
+	PartAddresses []string `json:"part_addresses,omitempty"`
+}
+
+// Validate checks DownloadEnvironment against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *DownloadEnvironment) Validate() error {
+	var errs ValidationErrors
+	if v.Version < 1 {
+		errs.add("version", "version must be at least 1, got %v", v.Version)
+	}
+	return errs.err()
+}
+
+// EnvironmentRequest is the request a download sends, sensitive header values redacted
+type EnvironmentRequest struct {
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	UserAgent string            `json:"user_agent"`
+	// User-Agents sent in turn instead of user_agent
+	UserAgents  []string `json:"user_agents,omitempty"`
+	Referer     string   `json:"referer,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	BodyBytes   int      `json:"body_bytes,omitempty"`
+	// Cookies from a cookie jar were sent
+	Cookies bool `json:"cookies,omitempty"`
+}
+
+// EnvironmentConfig are the effective settings of a download
+type EnvironmentConfig struct {
+	Threads          int   `json:"threads"`
+	RequestedThreads int   `json:"requested_threads"`
+	MinPartSize      int64 `json:"min_part_size"`
+	// Bytes per second of the download; 0 is unlimited
+	RateLimit int64 `json:"rate_limit"`
+	// Bytes per second the download shared with others, such as the server-wide cap
+	SharedRateLimit int64    `json:"shared_rate_limit,omitempty"`
+	Bind            string   `json:"bind,omitempty"`
+	BindRateLimit   int64    `json:"bind_rate_limit,omitempty"`
+	Http2           bool     `json:"http2"`
+	Encrypted       bool     `json:"encrypted,omitempty"`
+	Sequential      bool     `json:"sequential,omitempty"`
+	PreviewBytes    int64    `json:"preview_bytes,omitempty"`
+	Jitter          string   `json:"jitter,omitempty"`
+	Mirrors         []string `json:"mirrors,omitempty"`
+	MirrorCount     int      `json:"mirror_count,omitempty"`
+	PartURLs        []string `json:"part_urls,omitempty"`
+	// Failed attempts in a row before a part gives up; 0 retries until stopped
+	RetryAttempts   int    `json:"retry_attempts"`
+	RetryDelay      string `json:"retry_delay"`
+	ChecksumRetries int    `json:"checksum_retries"`
+	ExpectedSize    int64  `json:"expected_size,omitempty"`
+	MaxThreads      int    `json:"max_threads,omitempty"`
+	MaxBufferMemory int64  `json:"max_buffer_memory,omitempty"`
+	// The parts came from an imported part plan
+	Plan bool `json:"plan,omitempty"`
+	// An existing output file without saved progress was continued
+	ContinueFile   bool `json:"continue_file,omitempty"`
+	OnlyIfModified bool `json:"only_if_modified,omitempty"`
+	Cache          bool `json:"cache,omitempty"`
+}
+
+// ResolvedHost is what the resolver answered for a host the probe connected to, and the address it connected to
+type ResolvedHost struct {
+	// host:port
+	Host string `json:"host"`
+	// Addresses the resolver answered; none for IP literals and reused connections
+	Addresses []string `json:"addresses,omitempty"`
+	Connected string   `json:"connected,omitempty"`
+}
+
+// EnvironmentServer is the server's answer to a download's probe, sensitive header values redacted
+type EnvironmentServer struct {
+	Status         string            `json:"status,omitempty"`
+	Proto          string            `json:"proto,omitempty"`
+	SupportsRanges bool              `json:"supports_ranges"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ProbedAt       string            `json:"probed_at"`
+	// The answer came from the probe cache, which keeps no headers
+	Cached bool `json:"cached,omitempty"`
+}
+
+// ByteRange is the bytes from start to end of a file, both included
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Settings are the runtime settings of a server
+type Settings struct {
+	// Bytes per second of all downloads together; 0 is unlimited
+	GlobalRateLimit int64 `json:"global_rate_limit"`
+	// Downloads transferring at once, more wait as queued; 0 is unlimited
+	MaxConcurrentDownloads int `json:"max_concurrent_downloads"`
+	// Threads for downloads that do not ask for a count
+	DefaultThreads int `json:"default_threads"`
+	// Days completed downloads stay in the database
+	RetentionDays int `json:"retention_days"`
+	// Smallest part in bytes a file is split into, so small files use fewer threads; 0 splits into as many parts as asked for
+	MinPartSize int64 `json:"min_part_size"`
+}
+
+// SettingsUpdate changes runtime settings; omitted fields keep their value
+type SettingsUpdate struct {
+	GlobalRateLimit        *int64 `json:"global_rate_limit,omitempty"`
+	MaxConcurrentDownloads *int   `json:"max_concurrent_downloads,omitempty"`
+	DefaultThreads         *int   `json:"default_threads,omitempty"`
+	RetentionDays          *int   `json:"retention_days,omitempty"`
+	MinPartSize            *int64 `json:"min_part_size,omitempty"`
+}
+
+// Validate checks SettingsUpdate against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *SettingsUpdate) Validate() error {
+	var errs ValidationErrors
+	if v.GlobalRateLimit != nil && *v.GlobalRateLimit < 0 {
+		errs.add("global_rate_limit", "global_rate_limit must be at least 0, got %v", *v.GlobalRateLimit)
+	}
+	if v.MaxConcurrentDownloads != nil && *v.MaxConcurrentDownloads < 0 {
+		errs.add("max_concurrent_downloads", "max_concurrent_downloads must be at least 0, got %v", *v.MaxConcurrentDownloads)
+	}
+	if v.MaxConcurrentDownloads != nil && *v.MaxConcurrentDownloads > 1000 {
+		errs.add("max_concurrent_downloads", "max_concurrent_downloads must be at most 1000, got %v", *v.MaxConcurrentDownloads)
+	}
+	if v.DefaultThreads != nil && *v.DefaultThreads < 1 {
+		errs.add("default_threads", "default_threads must be at least 1, got %v", *v.DefaultThreads)
+	}
+	if v.DefaultThreads != nil && *v.DefaultThreads > 16 {
+		errs.add("default_threads", "default_threads must be at most 16, got %v", *v.DefaultThreads)
+	}
+	if v.RetentionDays != nil && *v.RetentionDays < 1 {
+		errs.add("retention_days", "retention_days must be at least 1, got %v", *v.RetentionDays)
+	}
+	if v.RetentionDays != nil && *v.RetentionDays > 3650 {
+		errs.add("retention_days", "retention_days must be at most 3650, got %v", *v.RetentionDays)
+	}
+	if v.MinPartSize != nil && *v.MinPartSize < 0 {
+		errs.add("min_part_size", "min_part_size must be at least 0, got %v", *v.MinPartSize)
+	}
+	return errs.err()
+}
+
+// DomainRule sets defaults for downloads from hosts matching a domain pattern; whatever a download asks for itself wins
+type DomainRule struct {
+	// Hosts the rule applies to: example.com for that host, *.example.com for its subdomains, * for every host
+	Match string `json:"match"`
+	// Threads for downloads that do not ask for a count
+	Threads int `json:"threads,omitempty"`
+	// Bytes per second of each download; 0 is unlimited
+	RateLimit int64 `json:"rate_limit,omitempty"`
+	// Request headers such as an access token; values are redacted when rules are listed
+	Headers map[string]string `json:"headers,omitempty"`
+	// Netscape cookies.txt file on the host running the download
+	CookiesFile string `json:"cookies_file,omitempty"`
+	// Failed attempts in a row after which a part gives up and the download fails; 0 retries until it is stopped
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Pause before a failed attempt is retried, e.g. 5s; defaults to 1s
+	RetryDelay string `json:"retry_delay,omitempty"`
+	// User-Agents sent in turn, one per request, by downloads that do not ask for their own; each is a profile name such as chrome or a full User-Agent
+	UserAgents []string `json:"user_agents,omitempty"`
+	// Most a random delay before every request may be, e.g. 500ms, so requests do not arrive at regular intervals; at most 10s
+	Jitter string `json:"jitter,omitempty"`
+	// Network interface such as eth1, or local IP address, that downloads which do not name their own connect from
+	Bind string `json:"bind,omitempty"`
+	// Bytes per second shared by all downloads bound to the same interface or address; 0 is unlimited
+	BindRateLimit int64 `json:"bind_rate_limit,omitempty"`
+}
+
+// Validate checks DomainRule against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *DomainRule) Validate() error {
+	var errs ValidationErrors
+	if len(v.Match) == 0 {
+		errs.add("match", "match is required")
+	}
+	if v.Threads < 0 {
+		errs.add("threads", "threads must be at least 0, got %v", v.Threads)
+	}
+	if v.Threads > 16 {
+		errs.add("threads", "threads must be at most 16, got %v", v.Threads)
+	}
+	if v.RateLimit < 0 {
+		errs.add("rate_limit", "rate_limit must be at least 0, got %v", v.RateLimit)
+	}
+	if v.MaxAttempts < 0 {
+		errs.add("max_attempts", "max_attempts must be at least 0, got %v", v.MaxAttempts)
+	}
+	if v.BindRateLimit < 0 {
+		errs.add("bind_rate_limit", "bind_rate_limit must be at least 0, got %v", v.BindRateLimit)
+	}
+	return errs.err()
+}
+
+// DomainRules lists the per-domain download defaults; more specific patterns override less specific ones
+type DomainRules struct {
+	Rules []DomainRule `json:"rules"`
+}
+
+// DomainRuleMatch is what the domain rules give a download from a URL
+type DomainRuleMatch struct {
+	URL string `json:"url"`
+	// Patterns of the matching rules, least specific first
+	Matched     []string          `json:"matched"`
+	Threads     int               `json:"threads,omitempty"`
+	RateLimit   int64             `json:"rate_limit,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	CookiesFile string            `json:"cookies_file,omitempty"`
+	MaxAttempts int               `json:"max_attempts,omitempty"`
+	RetryDelay  string            `json:"retry_delay,omitempty"`
+	// User-Agents sent in turn, with profile names resolved
+	UserAgents    []string `json:"user_agents,omitempty"`
+	Jitter        string   `json:"jitter,omitempty"`
+	Bind          string   `json:"bind,omitempty"`
+	BindRateLimit int64    `json:"bind_rate_limit,omitempty"`
+}
+
+// AuditEntry is one change made through the API
+type AuditEntry struct {
+	ID   int    `json:"id"`
+	Time string `json:"time"`
+	// Client address the change came from
+	Actor string `json:"actor"`
+	// What changed, e.g. settings.update
+	Action string `json:"action"`
+	// The object that changed, e.g. a setting name
+	Target   string `json:"target"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// AuditLog lists recent audit entries, newest first
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+	Count   int          `json:"count"`
+}
+
+// DownloadEvent is one state change of a download
+type DownloadEvent struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	// Status the download moved to
+	Status string `json:"status,omitempty"`
+	Time   string `json:"time"`
+	// Server or worker process the change happened on
+	Node string `json:"node"`
+	// Who caused the change on the node: the client address of the request, the worker or the schedule; empty when the node acted on its own
+	Actor string `json:"actor,omitempty"`
+	// Why the download was requeued
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Bytes downloaded by then, when known
+	BytesDownloaded int64 `json:"bytes_downloaded,omitempty"`
+}
+
+// Validate checks DownloadEvent against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *DownloadEvent) Validate() error {
+	var errs ValidationErrors
+	switch v.Type {
+	case "created", "started", "paused", "completed", "failed", "requeued":
+	default:
+		errs.add("type", "type must be one of created, started, paused, completed, failed, requeued, got %q", v.Type)
+	}
+	return errs.err()
+}
+
+// DownloadEvents lists the state changes of a download, oldest first
+type DownloadEvents struct {
+	DownloadID string          `json:"download_id"`
+	Events     []DownloadEvent `json:"events"`
+	Count      int             `json:"count"`
+}
+
+// UsageRow is the bytes charged to one key over one period
+type UsageRow struct {
+	// The day as 2006-01-02 or the month as 2006-01
+	Period string `json:"period"`
+	// The user, tag or domain; empty for downloads nobody started through the API, e.g. scheduled ones
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+	// bytes at cost_per_gb, when it is set
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+}
+
+// UsageReport sums the bytes transferred by period and key
+type UsageReport struct {
+	By     string `json:"by"`
+	Period string `json:"period"`
+	Since  string `json:"since"`
+	Until  string `json:"until"`
+	// Ordered by period, then by bytes, most first
+	Rows []UsageRow `json:"rows"`
+	// The bytes of all rows; by tag, bytes of a download with several tags count once per tag
+	TotalBytes int64 `json:"total_bytes"`
+	// USAGE_COST_PER_GB, the cost of 10^9 bytes, when it is set
+	CostPerGb float64 `json:"cost_per_gb,omitempty"`
+	// total_bytes at cost_per_gb, when it is set
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+}
+
+// Validate checks UsageReport against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *UsageReport) Validate() error {
+	var errs ValidationErrors
+	switch v.By {
+	case "user", "tag", "domain":
+	default:
+		errs.add("by", "by must be one of user, tag, domain, got %q", v.By)
+	}
+	switch v.Period {
+	case "daily", "monthly":
+	default:
+		errs.add("period", "period must be one of daily, monthly, got %q", v.Period)
+	}
+	return errs.err()
+}
+
+// AlertStatus is an alert rule and where it stands
+type AlertStatus struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	// How long the condition must hold before the rule fires
+	ForSeconds  int64  `json:"for_seconds"`
+	Severity    string `json:"severity,omitempty"`
+	Description string `json:"description,omitempty"`
+	State       string `json:"state"`
+	// The metric at the last evaluation; absent when it had no value, e.g. the average speed while nothing runs
+	Value *float64 `json:"value,omitempty"`
+	// When the condition started holding
+	Since   string `json:"since,omitempty"`
+	FiredAt string `json:"fired_at,omitempty"`
+}
+
+// Validate checks AlertStatus against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *AlertStatus) Validate() error {
+	var errs ValidationErrors
+	switch v.Metric {
+	case "queue_depth", "running_downloads", "average_speed", "total_speed", "failure_rate", "queue_wait_seconds":
+	default:
+		errs.add("metric", "metric must be one of queue_depth, running_downloads, average_speed, total_speed, failure_rate, queue_wait_seconds, got %q", v.Metric)
+	}
+	switch v.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		errs.add("op", "op must be one of >, >=, <, <=, got %q", v.Op)
+	}
+	switch v.State {
+	case "ok", "pending", "firing":
+	default:
+		errs.add("state", "state must be one of ok, pending, firing, got %q", v.State)
+	}
+	return errs.err()
+}
+
+// Alerts lists the alert rules of a server
+type Alerts struct {
+	Alerts []AlertStatus `json:"alerts"`
+	// How many rules are firing
+	Firing int `json:"firing"`
+	// Absent until the rules were first evaluated
+	EvaluatedAt string `json:"evaluated_at,omitempty"`
+}
+
+// ScalingHint is the stalled-queue watchdog's last sample of the queue and the worker nodes it suggests
+type ScalingHint struct {
+	// Absent until the watchdog took its first sample
+	ObservedAt string `json:"observed_at,omitempty"`
+	// How long the queue must grow with idle workers to count as stalled
+	WindowSeconds int64 `json:"window_seconds"`
+	// Bytes per second of all jobs together at or under which the workers count as idle
+	IdleSpeed float64 `json:"idle_speed"`
+	// Jobs in the main queue
+	QueueDepth       int64 `json:"queue_depth"`
+	RunningDownloads int64 `json:"running_downloads"`
+	// Bytes per second of all running jobs together
+	TotalSpeed float64 `json:"total_speed"`
+	// Registered worker nodes
+	WorkerNodes int `json:"worker_nodes"`
+	// Jobs the registered worker nodes run at once together
+	WorkerSlots int `json:"worker_slots"`
+	// How much the queue grew over the window; absent until the watchdog has watched for a whole window
+	QueueGrowth *int64 `json:"queue_growth,omitempty"`
+	// Jobs that completed or failed within the window; absent like queue_growth
+	Finished     *int64 `json:"finished,omitempty"`
+	Stalled      bool   `json:"stalled"`
+	StalledSince string `json:"stalled_since,omitempty"`
+	// Worker nodes that would run every queued and running job at once, between SCALE_MIN_WORKERS and SCALE_MAX_WORKERS; one more than there are while the queue is stalled
+	DesiredWorkers int `json:"desired_workers"`
+}
+
+// Manifest is a portable list of download definitions exported from one server to be imported into another
+type Manifest struct {
+	// Manifest format version
+	Version    int             `json:"version"`
+	ExportedAt string          `json:"exported_at"`
+	Downloads  []ManifestEntry `json:"downloads"`
+}
+
+// Validate checks Manifest against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *Manifest) Validate() error {
+	var errs ValidationErrors
+	if v.Version < 1 {
+		errs.add("version", "version must be at least 1, got %v", v.Version)
+	}
+	if v.Version > 1 {
+		errs.add("version", "version must be at most 1, got %v", v.Version)
+	}
+	return errs.err()
+}
+
+// ManifestV1 is Manifest as served by API version v1
+type ManifestV1 struct {
+	Version    int               `json:"version"`
+	ExportedAt string            `json:"exported_at"`
+	Downloads  []ManifestEntryV1 `json:"downloads"`
+}
+
+// V1 converts Manifest to its v1 shape
+func (v *Manifest) V1() ManifestV1 {
+	out := ManifestV1{
+		Version:    v.Version,
+		ExportedAt: v.ExportedAt,
+	}
+	if v.Downloads != nil {
+		out.Downloads = make([]ManifestEntryV1, len(v.Downloads))
+		for i := range v.Downloads {
+			out.Downloads[i] = v.Downloads[i].V1()
+		}
+	}
+	return out
+}
+
+// ManifestEntry is one exported download: the request that starts it again and what happened to it on the exporting server
+type ManifestEntry struct {
+	Request DownloadRequest `json:"request"`
+	// ID on the exporting server
+	ID string `json:"id,omitempty"`
+	// Status on the exporting server
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Algorithm of the checksum the origin sent, if it sent one
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	// Header the checksum came from
+	ChecksumSource string `json:"checksum_source,omitempty"`
+}
+
+// ManifestEntryV1 is ManifestEntry as served by API version v1
+type ManifestEntryV1 struct {
+	Request           DownloadRequestV1 `json:"request"`
+	ID                string            `json:"id,omitempty"`
+	Status            string            `json:"status,omitempty"`
+	Error             string            `json:"error,omitempty"`
+	ChecksumAlgorithm string            `json:"checksum_algorithm,omitempty"`
+	ChecksumSource    string            `json:"checksum_source,omitempty"`
+}
+
+// V1 converts ManifestEntry to its v1 shape
+func (v *ManifestEntry) V1() ManifestEntryV1 {
+	out := ManifestEntryV1{
+		Request:           v.Request.V1(),
+		ID:                v.ID,
+		Status:            v.Status,
+		Error:             v.Error,
+		ChecksumAlgorithm: v.ChecksumAlgorithm,
+		ChecksumSource:    v.ChecksumSource,
+	}
+	return out
+}
+
+// ManifestImport reports what became of every entry of an imported manifest, in manifest order
+type ManifestImport struct {
+	Results  []ManifestImportResult `json:"results"`
+	Imported int                    `json:"imported"`
+	Skipped  int                    `json:"skipped"`
+	Failed   int                    `json:"failed"`
+}
+
+// ManifestImportResult is what became of one manifest entry
+type ManifestImportResult struct {
+	// URL of the entry, with credentials redacted
+	URL string `json:"url"`
+	// skipped entries are already waiting or running on this server
+	Result string `json:"result"`
+	// The new download, or the one already running for skipped entries
+	DownloadID string `json:"download_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Validate checks ManifestImportResult against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *ManifestImportResult) Validate() error {
+	var errs ValidationErrors
+	switch v.Result {
+	case "imported", "skipped", "failed":
+	default:
+		errs.add("result", "result must be one of imported, skipped, failed, got %q", v.Result)
+	}
+	return errs.err()
+}
+
+// DownloadList lists every download known to a server, the most recently active first
+type DownloadList struct {
+	Downloads []DownloadStatus `json:"downloads"`
+	Count     int              `json:"count"`
+}
+
+// DownloadListV1 is DownloadList as served by API version v1
+type DownloadListV1 struct {
+	Downloads []DownloadStatusV1 `json:"downloads"`
+	Count     int                `json:"count"`
+}
+
+// V1 converts DownloadList to its v1 shape
+func (v *DownloadList) V1() DownloadListV1 {
+	out := DownloadListV1{
+		Count: v.Count,
+	}
+	if v.Downloads != nil {
+		out.Downloads = make([]DownloadStatusV1, len(v.Downloads))
+		for i := range v.Downloads {
+			out.Downloads[i] = v.Downloads[i].V1()
+		}
+	}
+	return out
+}
+
+// QueuedDownloadRequest represents the JSON request body for starting a queued download
+type QueuedDownloadRequest struct {
+	// Absolute http or https URL of the file
+	URL string `json:"url"`
+	// Output path; may use template variables such as {date}/{domain}/{filename}. It must be UTF-8 without control characters, at most 4096 bytes with names of at most 255, name a file rather than a directory, and be relative to the download directory without ".." names
+	Output           string   `json:"output"`
+	Threads          int      `json:"threads,omitempty"`
+	DependsOn        []string `json:"depends_on,omitempty"`
+	UserAgent        string   `json:"user_agent,omitempty"`
+	UserAgentProfile string   `json:"user_agent_profile,omitempty"`
+	Referer          string   `json:"referer,omitempty"`
+	// Headers are extra request headers, e.g. Authorization; they are sealed before storage
+	Headers map[string]string `json:"headers,omitempty"`
+	// HTTP method the file is requested with; defaults to GET, or POST when a body is given. Downloads other than a plain GET run as a single stream
+	Method string `json:"method,omitempty"`
+	// Request body sent with method, for endpoints that export files only to a POST
+	Body string `json:"body,omitempty"`
+	// Encoding of body; defaults to form
+	BodyType string `json:"body_type,omitempty"`
+	// Pieces of a file published split into several URLs, such as file.z01 and file.z02, in order; they are downloaded in parallel and joined into output as one download. url must be the first of them
+	PartURLs []string `json:"part_urls,omitempty"`
+	// Other URLs serving the same file. Each is probed with a small range request, and parts are spread over the fastest mirror_count of them, re-probed during long downloads; mirrors announced with Link rel=duplicate headers are added. Headers are sent to url's host only
+	Mirrors []string `json:"mirrors,omitempty"`
+	// How many of the fastest mirrors parts are spread over; 0 picks 3
+	MirrorCount int `json:"mirror_count,omitempty"`
+	// Labels the bytes the download transfers are accounted to in GET /usage, e.g. a team or project; letters, digits and . _ : -, starting with a letter or digit
+	Tags []string `json:"tags,omitempty"`
+	// Endpoint POSTed {"url": <expired link>} when the server refuses the link as expired, e.g. a pre-signed S3 URL; it answers with a new link, as JSON {"url": ...} or text, and the download continues with it
+	RefreshURL string `json:"refresh_url,omitempty"`
+	// Download only when the remote file changed since the file at output_path was downloaded, asking with If-None-Match and If-Modified-Since; otherwise the job ends with status not_modified
+	OnlyIfModified bool `json:"only_if_modified,omitempty"`
+	// Network interface such as eth1, or local IP address, of the worker host the job connects from
+	Bind string `json:"bind,omitempty"`
+	// Bytes per second shared by all jobs a worker binds to the same interface or address; 0 is unlimited
+	BindRateLimit int64 `json:"bind_rate_limit,omitempty"`
+	// Write <output>.done, a JSON marker with the file's size, SHA-256 and validators, through a rename once the download is verified, so pipelines watching the directory can tell a finished file from one still growing; an older marker is removed when the download starts
+	DoneMarker bool `json:"done_marker,omitempty"`
+	// Split the file into exactly these parts, as GET /downloads/{id}/plan exported them, fetching each from the server it names, instead of splitting it by threads; the plan must cover the file the server has
+	Plan *PartPlan `json:"plan,omitempty"`
+}
+
+// Validate checks QueuedDownloadRequest against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *QueuedDownloadRequest) Validate() error {
+	var errs ValidationErrors
+	if len(v.URL) == 0 {
+		errs.add("url", "url is required")
+	}
+	if len(v.URL) > 8192 {
+		errs.add("url", "url must be at most 8192 characters, got %d", len(v.URL))
+	}
+	if v.URL != "" {
+		if err := CheckURL(v.URL); err != nil {
+			errs.add("url", "url %v", err)
+		}
+	}
+	if len(v.Output) == 0 {
+		errs.add("output", "output is required")
+	}
+	if v.Output != "" {
+		if err := CheckOutputPath(v.Output); err != nil {
+			errs.add("output", "output %v", err)
+		}
+	}
+	if v.Threads < 0 {
+		errs.add("threads", "threads must be at least 0, got %v", v.Threads)
+	}
+	if v.Threads > 16 {
+		errs.add("threads", "threads must be at most 16, got %v", v.Threads)
+	}
+	switch v.Method {
+	case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		errs.add("method", "method must be one of GET, POST, PUT, PATCH, DELETE, got %q", v.Method)
+	}
+	switch v.BodyType {
+	case "", "form", "json":
+	default:
+		errs.add("body_type", "body_type must be one of form, json, got %q", v.BodyType)
+	}
+	for i, item := range v.PartURLs {
+		if err := CheckURL(item); err != nil {
+			errs.add(fmt.Sprintf("part_urls[%d]", i), "part_urls[%d] %v", i, err)
+		}
+	}
+	for i, item := range v.Mirrors {
+		if err := CheckURL(item); err != nil {
+			errs.add(fmt.Sprintf("mirrors[%d]", i), "mirrors[%d] %v", i, err)
+		}
+	}
+	if v.MirrorCount < 0 {
+		errs.add("mirror_count", "mirror_count must be at least 0, got %v", v.MirrorCount)
+	}
+	if v.MirrorCount > 20 {
+		errs.add("mirror_count", "mirror_count must be at most 20, got %v", v.MirrorCount)
+	}
+	if v.RefreshURL != "" {
+		if err := CheckURL(v.RefreshURL); err != nil {
+			errs.add("refresh_url", "refresh_url %v", err)
+		}
+	}
+	if v.BindRateLimit < 0 {
+		errs.add("bind_rate_limit", "bind_rate_limit must be at least 0, got %v", v.BindRateLimit)
+	}
+	return errs.err()
+}
+
+// ApplyDefaults fills unset fields of QueuedDownloadRequest with their documented defaults
+func (v *QueuedDownloadRequest) ApplyDefaults() {
+	if v.Threads == 0 {
+		v.Threads = 4
+	}
+}
+
+// QueuedDownloadRequestV1 is QueuedDownloadRequest as served by API version v1
+type QueuedDownloadRequestV1 struct {
+	URL              string `json:"url"`
+	Output           string `json:"output"`
+	Threads          int    `json:"threads,omitempty"`
+	UserAgent        string `json:"user_agent,omitempty"`
+	UserAgentProfile string `json:"user_agent_profile,omitempty"`
+	Referer          string `json:"referer,omitempty"`
+}
+
+// V1 converts QueuedDownloadRequest to its v1 shape
+func (v *QueuedDownloadRequest) V1() QueuedDownloadRequestV1 {
+	out := QueuedDownloadRequestV1{
+		URL:              v.URL,
+		Output:           v.Output,
+		Threads:          v.Threads,
+		UserAgent:        v.UserAgent,
+		UserAgentProfile: v.UserAgentProfile,
+		Referer:          v.Referer,
+	}
+	return out
+}
+
+// CheckVersion rejects fields of QueuedDownloadRequest that the given API version does not have
+func (v *QueuedDownloadRequest) CheckVersion(version string) error {
+	if len(v.DependsOn) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("depends_on requires API version v2")
+	}
+	if len(v.Headers) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("headers requires API version v2")
+	}
+	if v.Method != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("method requires API version v2")
+	}
+	if v.Body != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("body requires API version v2")
+	}
+	if v.BodyType != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("body_type requires API version v2")
+	}
+	if len(v.PartURLs) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("part_urls requires API version v2")
+	}
+	if len(v.Mirrors) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("mirrors requires API version v2")
+	}
+	if v.MirrorCount != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("mirror_count requires API version v2")
+	}
+	if len(v.Tags) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("tags requires API version v2")
+	}
+	if v.RefreshURL != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("refresh_url requires API version v2")
+	}
+	if v.OnlyIfModified && versionBefore(version, "v2") {
+		return fmt.Errorf("only_if_modified requires API version v2")
+	}
+	if v.Bind != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("bind requires API version v2")
+	}
+	if v.BindRateLimit != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("bind_rate_limit requires API version v2")
+	}
+	if v.DoneMarker && versionBefore(version, "v2") {
+		return fmt.Errorf("done_marker requires API version v2")
+	}
+	if v.Plan != nil && versionBefore(version, "v2") {
+		return fmt.Errorf("plan requires API version v2")
+	}
+	return nil
+}
+
+// QueuedDownloadResponse represents the response when enqueueing a download
+type QueuedDownloadResponse struct {
+	JobID   string `json:"job_id"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	// What an earlier download of the same URL learned about the file, when it is recent enough that the worker reuses it instead of probing the server
+	Probe *CachedProbe `json:"probe,omitempty"`
+}
+
+// Validate checks QueuedDownloadResponse against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *QueuedDownloadResponse) Validate() error {
+	var errs ValidationErrors
+	switch v.Status {
+	case "waiting", "queued":
+	default:
+		errs.add("status", "status must be one of waiting, queued, got %q", v.Status)
+	}
+	return errs.err()
+}
+
+// QueuedDownloadResponseV1 is QueuedDownloadResponse as served by API version v1
+type QueuedDownloadResponseV1 struct {
+	JobID   string `json:"job_id"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// V1 converts QueuedDownloadResponse to its v1 shape
+func (v *QueuedDownloadResponse) V1() QueuedDownloadResponseV1 {
+	out := QueuedDownloadResponseV1{
+		JobID:   v.JobID,
+		Message: v.Message,
+		Status:  v.Status,
+	}
+	return out
+}
+
+// CheckVersion rejects fields of QueuedDownloadResponse that the given API version does not have
+func (v *QueuedDownloadResponse) CheckVersion(version string) error {
+	if v.Probe != nil && versionBefore(version, "v2") {
+		return fmt.Errorf("probe requires API version v2")
+	}
+	return nil
+}
+
+// CachedProbe is a range check of a URL kept for PROBE_CACHE_TTL
+type CachedProbe struct {
+	SupportsRanges bool   `json:"supports_ranges"`
+	SizeBytes      int64  `json:"size_bytes"`
+	Etag           string `json:"etag,omitempty"`
+	LastModified   string `json:"last_modified,omitempty"`
+	ProbedAt       string `json:"probed_at"`
+}
+
+// Probe is what a range check learned about the file at a URL
+type Probe struct {
+	SupportsRanges bool   `json:"supports_ranges"`
+	SizeBytes      int64  `json:"size_bytes"`
+	Etag           string `json:"etag,omitempty"`
+	LastModified   string `json:"last_modified,omitempty"`
+	// Where redirects led, credentials redacted
+	FinalURL string `json:"final_url"`
+	// The Accept-Ranges header as the server sent it
+	AcceptRanges string `json:"accept_ranges,omitempty"`
+	// The Server header
+	Server   string `json:"server,omitempty"`
+	ProbedAt string `json:"probed_at"`
+}
+
+// QueuedDownloadStatus represents the current status of a queued download
+type QueuedDownloadStatus struct {
+	JobID      string `json:"job_id"`
+	URL        string `json:"url"`
+	OutputPath string `json:"output_path"`
+	// API v1 reports downloading jobs as processing
+	Status          string  `json:"status"`
+	Progress        float64 `json:"progress"`
+	BytesDownloaded int64   `json:"bytes_downloaded"`
+	TotalBytes      int64   `json:"total_bytes"`
+	// Parts the file was split into; fewer than asked for when parts would fall below the minimum part size
+	ThreadsUsed int `json:"threads_used"`
+	// Threads the job asked for
+	ThreadsRequested int `json:"threads_requested,omitempty"`
+	// Result of checking the finished file against a checksum the server sent in Content-MD5, Digest, Repr-Digest or Content-Digest; absent when it sent none
+	ChecksumStatus string `json:"checksum_status,omitempty"`
+	// Algorithm of the checksum checked: md5, sha-1, sha-256 or sha-512
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	// Header or trailer the checksum was read from
+	ChecksumSource    string   `json:"checksum_source,omitempty"`
+	CreatedAt         string   `json:"created_at"`
+	StartedAt         string   `json:"started_at,omitempty"`
+	CompletedAt       string   `json:"completed_at,omitempty"`
+	WorkerID          string   `json:"worker_id,omitempty"`
+	ErrorMessage      string   `json:"error_message,omitempty"`
+	DependsOn         []string `json:"depends_on,omitempty"`
+	ThrottledByServer bool     `json:"throttled_by_server"`
+	// Bytes per second over the last few seconds, from the live progress events
+	CurrentSpeed float64 `json:"current_speed"`
+	// Parts transferring right now
+	ActiveConnections int `json:"active_connections"`
+	// When the download was last seen receiving data
+	LastByteAt string `json:"last_byte_at,omitempty"`
+	// Files the job produced; empty until it completes
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// Validate checks QueuedDownloadStatus against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *QueuedDownloadStatus) Validate() error {
+	var errs ValidationErrors
+	switch v.Status {
+	case "waiting", "queued", "downloading", "waiting_network", "paused", "completed", "not_modified", "failed", "processing":
+	default:
+		errs.add("status", "status must be one of waiting, queued, downloading, waiting_network, paused, completed, not_modified, failed, processing, got %q", v.Status)
+	}
+	switch v.ChecksumStatus {
+	case "", "verified", "mismatch":
+	default:
+		errs.add("checksum_status", "checksum_status must be one of verified, mismatch, got %q", v.ChecksumStatus)
+	}
+	return errs.err()
+}
+
+// QueuedDownloadStatusV1 is QueuedDownloadStatus as served by API version v1
+type QueuedDownloadStatusV1 struct {
+	JobID           string  `json:"job_id"`
+	URL             string  `json:"url"`
+	OutputPath      string  `json:"output_path"`
+	Status          string  `json:"status"`
+	Progress        float64 `json:"progress"`
+	BytesDownloaded int64   `json:"bytes_downloaded"`
+	TotalBytes      int64   `json:"total_bytes"`
+	ThreadsUsed     int     `json:"threads_used"`
+	CreatedAt       string  `json:"created_at"`
+	StartedAt       string  `json:"started_at,omitempty"`
+	CompletedAt     string  `json:"completed_at,omitempty"`
+	WorkerID        string  `json:"worker_id,omitempty"`
+	ErrorMessage    string  `json:"error_message,omitempty"`
+}
+
+// V1 converts QueuedDownloadStatus to its v1 shape
+func (v *QueuedDownloadStatus) V1() QueuedDownloadStatusV1 {
+	out := QueuedDownloadStatusV1{
+		JobID:           v.JobID,
+		URL:             v.URL,
+		OutputPath:      v.OutputPath,
+		Status:          v.Status,
+		Progress:        v.Progress,
+		BytesDownloaded: v.BytesDownloaded,
+		TotalBytes:      v.TotalBytes,
+		ThreadsUsed:     v.ThreadsUsed,
+		CreatedAt:       v.CreatedAt,
+		StartedAt:       v.StartedAt,
+		CompletedAt:     v.CompletedAt,
+		WorkerID:        v.WorkerID,
+		ErrorMessage:    v.ErrorMessage,
+	}
+	return out
+}
+
+// CheckVersion rejects fields of QueuedDownloadStatus that the given API version does not have
+func (v *QueuedDownloadStatus) CheckVersion(version string) error {
+	if v.ThreadsRequested != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("threads_requested requires API version v2")
+	}
+	if v.ChecksumStatus != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("checksum_status requires API version v2")
+	}
+	if v.ChecksumAlgorithm != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("checksum_algorithm requires API version v2")
+	}
+	if v.ChecksumSource != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("checksum_source requires API version v2")
+	}
+	if len(v.DependsOn) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("depends_on requires API version v2")
+	}
+	if v.ThrottledByServer && versionBefore(version, "v2") {
+		return fmt.Errorf("throttled_by_server requires API version v2")
+	}
+	if v.CurrentSpeed != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("current_speed requires API version v2")
+	}
+	if v.ActiveConnections != 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("active_connections requires API version v2")
+	}
+	if v.LastByteAt != "" && versionBefore(version, "v2") {
+		return fmt.Errorf("last_byte_at requires API version v2")
+	}
+	if len(v.Artifacts) > 0 && versionBefore(version, "v2") {
+		return fmt.Errorf("artifacts requires API version v2")
+	}
+	return nil
+}
+
+// Artifact describes a file a job produced and where it is stored
+type Artifact struct {
+	// Absolute path of the file on the node that stored it
+	Path string `json:"path"`
+	// Size of the file as stored
+	SizeBytes int64 `json:"size_bytes"`
+	// Hex digest of the file as stored
+	Checksum          string `json:"checksum"`
+	ChecksumAlgorithm string `json:"checksum_algorithm"`
+	// Storage the file was written to; local is the worker's filesystem
+	Backend string `json:"backend"`
+	// Worker node that wrote the file
+	Node string `json:"node,omitempty"`
+	// The file is stored encrypted with the workers' encryption key, so checksum and size are of the ciphertext
+	Encrypted bool   `json:"encrypted"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Validate checks Artifact against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *Artifact) Validate() error {
+	var errs ValidationErrors
+	switch v.ChecksumAlgorithm {
+	case "sha-256":
+	default:
+		errs.add("checksum_algorithm", "checksum_algorithm must be one of sha-256, got %q", v.ChecksumAlgorithm)
+	}
+	switch v.Backend {
+	case "local":
+	default:
+		errs.add("backend", "backend must be one of local, got %q", v.Backend)
+	}
+	return errs.err()
+}
+
+// QueuedDownloadList lists every job recorded by the queued server, the most recently active first
+type QueuedDownloadList struct {
+	Downloads []QueuedDownloadStatus `json:"downloads"`
+	Count     int                    `json:"count"`
+}
+
+// QueuedDownloadListV1 is QueuedDownloadList as served by API version v1
+type QueuedDownloadListV1 struct {
+	Downloads []QueuedDownloadStatusV1 `json:"downloads"`
+	Count     int                      `json:"count"`
+}
+
+// V1 converts QueuedDownloadList to its v1 shape
+func (v *QueuedDownloadList) V1() QueuedDownloadListV1 {
+	out := QueuedDownloadListV1{
+		Count: v.Count,
+	}
+	if v.Downloads != nil {
+		out.Downloads = make([]QueuedDownloadStatusV1, len(v.Downloads))
+		for i := range v.Downloads {
+			out.Downloads[i] = v.Downloads[i].V1()
+		}
+	}
+	return out
+}
+
+// GroupDownloadRequest represents the JSON request body for creating a download group
+type GroupDownloadRequest struct {
+	// Exactly one of url_template, page_url or repo_url must be set
+	URLTemplate string `json:"url_template,omitempty"`
+	PageURL     string `json:"page_url,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	// Leave out the links of page_url that its site's robots.txt disallows for the group's User-Agent
+	Polite bool `json:"polite,omitempty"`
+	// An hf://[datasets/|spaces/]org/name[@revision][/path] Hugging Face repo whose files are downloaded, or with lfs_objects the Git repository whose LFS server serves them
+	RepoURL string `json:"repo_url,omitempty"`
+	// The objects of Git LFS pointer files, saved at their paths under output_dir
+	LfsObjects []LFSObject `json:"lfs_objects,omitempty"`
+	// Directory the files are saved in, relative to the download directory and without ".." names
+	OutputDir        string            `json:"output_dir,omitempty"`
+	Threads          int               `json:"threads,omitempty"`
+	UserAgent        string            `json:"user_agent,omitempty"`
+	UserAgentProfile string            `json:"user_agent_profile,omitempty"`
+	Referer          string            `json:"referer,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+}
+
+// Validate checks GroupDownloadRequest against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *GroupDownloadRequest) Validate() error {
+	var errs ValidationErrors
+	if v.PageURL != "" {
+		if err := CheckURL(v.PageURL); err != nil {
+			errs.add("page_url", "page_url %v", err)
+		}
+	}
+	if len(v.RepoURL) > 8192 {
+		errs.add("repo_url", "repo_url must be at most 8192 characters, got %d", len(v.RepoURL))
+	}
+	if v.OutputDir != "" {
+		if err := CheckDirPath(v.OutputDir); err != nil {
+			errs.add("output_dir", "output_dir %v", err)
+		}
+	}
+	if v.Threads < 0 {
+		errs.add("threads", "threads must be at least 0, got %v", v.Threads)
+	}
+	if v.Threads > 16 {
+		errs.add("threads", "threads must be at most 16, got %v", v.Threads)
+	}
+	return errs.err()
+}
+
+// ApplyDefaults fills unset fields of GroupDownloadRequest with their documented defaults
+func (v *GroupDownloadRequest) ApplyDefaults() {
+	if v.Threads == 0 {
+		v.Threads = 4
+	}
+}
+
+// GroupDownloadResponse represents the response when enqueueing a download group
+type GroupDownloadResponse struct {
+	GroupID string   `json:"group_id"`
+	JobIDs  []string `json:"job_ids"`
+	Count   int      `json:"count"`
+	Message string   `json:"message"`
+}
+
+// GroupEntry is a single URL and the path it will be saved to
+type GroupEntry struct {
+	URL        string `json:"url"`
+	OutputPath string `json:"output_path"`
+	// Bytes the file must have, when the group's listing gave them
+	Size int64 `json:"size,omitempty"`
+	// Hex SHA-256 the file must have, when the group's listing gave it
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// LFSObject is the object a Git LFS pointer file stands for
+type LFSObject struct {
+	// Slash-separated path of the pointer in the repository
+	Path string `json:"path"`
+	// Hex SHA-256 of the object, from the pointer's oid sha256: line
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// Validate checks LFSObject against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *LFSObject) Validate() error {
+	var errs ValidationErrors
+	if len(v.Path) == 0 {
+		errs.add("path", "path is required")
+	}
+	if len(v.Oid) < 64 {
+		errs.add("oid", "oid must be at least 64 characters")
+	}
+	if len(v.Oid) > 64 {
+		errs.add("oid", "oid must be at most 64 characters, got %d", len(v.Oid))
+	}
+	if v.Size < 0 {
+		errs.add("size", "size must be at least 0, got %v", v.Size)
+	}
+	return errs.err()
+}
+
+// GroupPreview lists the entries a group request resolves to
+type GroupPreview struct {
+	Downloads []GroupEntry `json:"downloads"`
+	Count     int          `json:"count"`
+}
+
+// GroupStatus reports the status of every job in a group
+type GroupStatus struct {
+	GroupID   string                 `json:"group_id"`
+	Downloads []QueuedDownloadStatus `json:"downloads"`
+	// Number of jobs in each status
+	Summary map[string]int `json:"summary"`
+	Count   int            `json:"count"`
+	// Set once the group finished and its SHA256SUMS file was written
+	Checksums *GroupChecksums `json:"checksums,omitempty"`
+}
+
+// GroupStatusV1 is GroupStatus as served by API version v1
+type GroupStatusV1 struct {
+	GroupID   string                   `json:"group_id"`
+	Downloads []QueuedDownloadStatusV1 `json:"downloads"`
+	Summary   map[string]int           `json:"summary"`
+	Count     int                      `json:"count"`
+}
+
+// V1 converts GroupStatus to its v1 shape
+func (v *GroupStatus) V1() GroupStatusV1 {
+	out := GroupStatusV1{
+		GroupID: v.GroupID,
+		Summary: v.Summary,
+		Count:   v.Count,
+	}
+	if v.Downloads != nil {
+		out.Downloads = make([]QueuedDownloadStatusV1, len(v.Downloads))
+		for i := range v.Downloads {
+			out.Downloads[i] = v.Downloads[i].V1()
+		}
+	}
+	return out
+}
+
+// CheckVersion rejects fields of GroupStatus that the given API version does not have
+func (v *GroupStatus) CheckVersion(version string) error {
+	if v.Checksums != nil && versionBefore(version, "v2") {
+		return fmt.Errorf("checksums requires API version v2")
+	}
+	return nil
+}
+
+// GroupChecksums describes the SHA256SUMS file written once a group finished
+type GroupChecksums struct {
+	// Number of files listed
+	Files  int  `json:"files"`
+	Signed bool `json:"signed"`
+	// PEM public key that checks the signature
+	PublicKey string `json:"public_key,omitempty"`
+	// Directory on node holding the files and SHA256SUMS; empty when the files are spread over nodes
+	Dir         string `json:"dir,omitempty"`
+	Node        string `json:"node,omitempty"`
+	GeneratedAt string `json:"generated_at"`
+}
+
+// QueuedJob describes a job waiting in the main queue or in the queue of a worker node
+type QueuedJob struct {
+	// Place in its queue; 1 is the job a worker takes next
+	Position int `json:"position"`
+	// Worker node whose queue holds the job, as a requeued job waits for the node with its partial file; empty for the main queue
+	Node       string `json:"node,omitempty"`
+	ID         string `json:"id"`
+	URL        string `json:"url"`
+	OutputPath string `json:"output_path"`
+	Threads    int    `json:"threads"`
+	Method     string `json:"method,omitempty"`
+	GroupID    string `json:"group_id,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// QueuedJobList lists the jobs waiting in the main queue in the order workers take them
+type QueuedJobList struct {
+	Jobs  []QueuedJob `json:"jobs"`
+	Count int         `json:"count"`
+}
+
+// ArchivedJob describes a job in the completed or failed queue
+type ArchivedJob struct {
+	ID              string `json:"id"`
+	URL             string `json:"url,omitempty"`
+	OutputPath      string `json:"output_path,omitempty"`
+	GroupID         string `json:"group_id,omitempty"`
+	Status          string `json:"status"`
+	WorkerID        string `json:"worker_id,omitempty"`
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+	CreatedAt       string `json:"created_at"`
+	StartedAt       string `json:"started_at,omitempty"`
+	CompletedAt     string `json:"completed_at"`
+	// How long the job ran; 0 if it never started
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Validate checks ArchivedJob against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *ArchivedJob) Validate() error {
+	var errs ValidationErrors
+	switch v.Status {
+	case "completed", "failed":
+	default:
+		errs.add("status", "status must be one of completed, failed, got %q", v.Status)
+	}
+	return errs.err()
+}
+
+// ArchivedJobList lists recently finished jobs, the most recent first
+type ArchivedJobList struct {
+	Jobs  []ArchivedJob `json:"jobs"`
+	Count int           `json:"count"`
+}
+
+// ScheduleRequest creates a schedule that enqueues a download
+type ScheduleRequest struct {
+	Name string `json:"name,omitempty"`
+	// When to run: five cron fields (minute hour day-of-month month day-of-week) such as "0 3 * * *", a descriptor such as @daily, or "@every 6h"
+	Cron string `json:"cron"`
+	// IANA time zone the cron fields are read in, e.g. Europe/Berlin; defaults to UTC
+	Timezone string `json:"timezone,omitempty"`
+	// Create the schedule paused
+	Paused   bool                  `json:"paused,omitempty"`
+	Download QueuedDownloadRequest `json:"download"`
+}
+
+// Validate checks ScheduleRequest against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *ScheduleRequest) Validate() error {
+	var errs ValidationErrors
+	if len(v.Cron) == 0 {
+		errs.add("cron", "cron is required")
+	}
+	return errs.err()
+}
+
+// ScheduleRequestV1 is ScheduleRequest as served by API version v1
+type ScheduleRequestV1 struct {
+	Name     string                  `json:"name,omitempty"`
+	Cron     string                  `json:"cron"`
+	Timezone string                  `json:"timezone,omitempty"`
+	Paused   bool                    `json:"paused,omitempty"`
+	Download QueuedDownloadRequestV1 `json:"download"`
+}
+
+// V1 converts ScheduleRequest to its v1 shape
+func (v *ScheduleRequest) V1() ScheduleRequestV1 {
+	out := ScheduleRequestV1{
+		Name:     v.Name,
+		Cron:     v.Cron,
+		Timezone: v.Timezone,
+		Paused:   v.Paused,
+		Download: v.Download.V1(),
+	}
+	return out
+}
+
+// Schedule describes a recurring download
+type Schedule struct {
+	ID             string `json:"id"`
+	Name           string `json:"name,omitempty"`
+	Cron           string `json:"cron"`
+	Timezone       string `json:"timezone"`
+	Paused         bool   `json:"paused"`
+	URL            string `json:"url"`
+	OutputPath     string `json:"output_path"`
+	OnlyIfModified bool   `json:"only_if_modified,omitempty"`
+	// When the schedule next enqueues the download; omitted while paused
+	NextRun   string `json:"next_run,omitempty"`
+	LastRun   string `json:"last_run,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ScheduleList lists every schedule
+type ScheduleList struct {
+	Schedules []Schedule `json:"schedules"`
+	Count     int        `json:"count"`
+}
+
+// ScheduleRun records one time a schedule fired
+type ScheduleRun struct {
+	Time string `json:"time"`
+	// enqueued a job, skipped because the previous run's job had not finished, or failed to enqueue
+	Result string `json:"result"`
+	// The job enqueued, or the unfinished one a skipped run waited for
+	JobID   string `json:"job_id,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+// Validate checks ScheduleRun against the constraints in the OpenAPI document,
+// returning every field that breaks one as ValidationErrors
+func (v *ScheduleRun) Validate() error {
+	var errs ValidationErrors
+	switch v.Result {
+	case "enqueued", "skipped", "failed":
+	default:
+		errs.add("result", "result must be one of enqueued, skipped, failed, got %q", v.Result)
+	}
+	return errs.err()
+}
+
+// ScheduleRunList lists the past runs of a schedule, the most recent first
+type ScheduleRunList struct {
+	Runs  []ScheduleRun `json:"runs"`
+	Count int           `json:"count"`
+}
@@ -0,0 +1,198 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxBodyBytes is the largest request body the servers read when
+// MAX_REQUEST_BODY_BYTES is not set; it leaves room for cookie files and
+// imported manifests
+const DefaultMaxBodyBytes = 10 << 20
+
+// MaxOutputPathLength and MaxOutputNameLength bound an output path and each
+// of its names, as most file systems do
+const (
+	MaxOutputPathLength = 4096
+	MaxOutputNameLength = 255
+)
+
+// ErrBodyTooLarge is returned for a request body past the server's limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Error returns the message, which names the field
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors lists every field of a request that is not valid, in the
+// order of the document
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add records a field error
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the errors found, or nil when there are none
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// FieldErrors returns the fields a request body was refused for: those of a
+// Validate error, or the field of a JSON value of the wrong type. It returns
+// nil for other errors.
+func FieldErrors(err error) []FieldError {
+	var validation ValidationErrors
+	if errors.As(err, &validation) {
+		return validation
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("%s must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind().String()), typeErr.Value),
+		}}
+	}
+	return nil
+}
+
+// jsonTypeName names a Go kind the way the document names the JSON type
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"):
+		return "an integer"
+	case strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "slice", kind == "array":
+		return "an array"
+	}
+	return "an object"
+}
+
+// CheckURL checks a value of format uri: an absolute http or https URL
+func CheckURL(raw string) error {
+	if strings.IndexFunc(raw, unicode.IsSpace) >= 0 || strings.IndexFunc(raw, unicode.IsControl) >= 0 {
+		return errors.New("must not contain spaces or control characters")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("is not a valid URL")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("must be an http or https URL, got scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return errors.New("must name a host")
+	}
+	return nil
+}
+
+// CheckOutputPath checks a value of format output-path: a file path in UTF-8
+// without control characters, whose names and whole length fit a file
+// system, and which names a file rather than a directory. The path must be
+// relative and stay inside the directory the server downloads to, so it
+// may not be absolute or have ".." names.
+func CheckOutputPath(p string) error {
+	if !utf8.ValidString(p) {
+		return errors.New("must be valid UTF-8")
+	}
+	if i := strings.IndexFunc(p, unicode.IsControl); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(p[i:])
+		return fmt.Errorf("must not contain control characters, found %U at byte %d", r, i)
+	}
+	if len(p) > MaxOutputPathLength {
+		return fmt.Errorf("must be at most %d bytes, got %d", MaxOutputPathLength, len(p))
+	}
+	if isAbsPath(p) {
+		return errors.New("must be relative to the download directory, not absolute")
+	}
+	for _, name := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if len(name) > MaxOutputNameLength {
+			return fmt.Errorf("must not have names longer than %d bytes, got %.20q...", MaxOutputNameLength, name)
+		}
+		if name == ".." {
+			return errors.New("must not leave the download directory with \"..\"")
+		}
+	}
+	if strings.HasSuffix(p, "/") || strings.HasSuffix(p, `\`) {
+		return errors.New("must name a file, not a directory")
+	}
+	return nil
+}
+
+// isAbsPath reports whether p is absolute on Unix or Windows: it starts
+// with a separator or a drive letter such as C:
+func isAbsPath(p string) bool {
+	if strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) {
+		return true
+	}
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	drive := p[0] | 0x20
+	return 'a' <= drive && drive <= 'z'
+}
+
+// CheckDirPath checks a directory given with format output-dir, which is
+// checked like an output path but may end in a separator
+func CheckDirPath(p string) error {
+	return CheckOutputPath(strings.TrimRight(p, `/\`))
+}
+
+// MaxBodyBytesFromEnv returns the request body limit set with
+// MAX_REQUEST_BODY_BYTES, or DefaultMaxBodyBytes
+func MaxBodyBytesFromEnv() (int64, error) {
+	raw := os.Getenv("MAX_REQUEST_BODY_BYTES")
+	if raw == "" {
+		return DefaultMaxBodyBytes, nil
+	}
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("MAX_REQUEST_BODY_BYTES must be a positive number of bytes, got %q", raw)
+	}
+	return limit, nil
+}
+
+// BodyTooLarge reports whether err is a body refused by LimitBody, or one
+// whose reading stopped at the limit
+func BodyTooLarge(err error) bool {
+	// http.MaxBytesReader has no error value to match before Go 1.19
+	return errors.Is(err, ErrBodyTooLarge) || (err != nil && strings.Contains(err.Error(), "http: request body too large"))
+}
+
+// LimitBody refuses a request whose Content-Length is past limit with
+// ErrBodyTooLarge, and otherwise stops reading its body at limit bytes, so
+// a body sent without a length fails to decode instead of filling memory
+func LimitBody(w http.ResponseWriter, r *http.Request, limit int64) error {
+	if r.ContentLength > limit {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrBodyTooLarge, r.ContentLength, limit)
+	}
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateListsEveryField(t *testing.T) {
+	req := DownloadRequest{
+		URL:      "ftp://example.com/file.bin",
+		Output:   "downloads/a\x00b.bin",
+		Threads:  20,
+		PartURLs: []string{"https://example.com/file.z01", "file.z02"},
+	}
+	err := req.Validate()
+	fields := FieldErrors(err)
+	var names []string
+	for _, field := range fields {
+		names = append(names, field.Field)
+	}
+	if got := strings.Join(names, ","); got != "url,output,threads,part_urls[1]" {
+		t.Fatalf("fields = %s (%v)", got, err)
+	}
+	if !strings.Contains(fields[0].Message, `url must be an http or https URL, got scheme "ftp"`) {
+		t.Errorf("url message = %q", fields[0].Message)
+	}
+	if !strings.Contains(err.Error(), "threads must be at most 16") || !strings.Contains(err.Error(), "; ") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	ok := DownloadRequest{URL: "https://example.com/file{1..3}.bin", Output: "downloads/{date}/ファイル.bin"}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("valid request: %v", err)
+	}
+}
+
+func TestFieldErrorsOfWrongJSONType(t *testing.T) {
+	var req DownloadRequest
+	err := json.Unmarshal([]byte(`{"url": "https://example.com/f", "output": "f", "threads": "4"}`), &req)
+	fields := FieldErrors(err)
+	if len(fields) != 1 || fields[0].Field != "threads" || fields[0].Message != "threads must be an integer, got string" {
+		t.Errorf("fields = %+v", fields)
+	}
+	if FieldErrors(errors.New("unexpected EOF")) != nil {
+		t.Error("fields of an error without any")
+	}
+}
+
+func TestCheckOutputPath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		ok   bool
+	}{
+		{"file.bin", true},
+		{"downloads/{domain}/{filename}", true},
+		{"a..b/..c.bin", true},
+		{"/data/downloads/{domain}/{filename}", false},
+		{`\\server\share\file.bin`, false},
+		{`C:\data\file.bin`, false},
+		{"c:file.bin", false},
+		{"../file.bin", false},
+		{"downloads/../../etc/passwd", false},
+		{`downloads\..\file.bin`, false},
+		{"café/naïve.txt", true},
+		{"bad\nname.bin", false},
+		{"bad\x7fname.bin", false},
+		{"latin1-\xe9.bin", false},
+		{"downloads/", false},
+		{strings.Repeat("a", 256) + ".bin", false},
+		{strings.Repeat("a/", 2049), false},
+	} {
+		if err := CheckOutputPath(tc.path); (err == nil) != tc.ok {
+			t.Errorf("CheckOutputPath(%.30q) = %v", tc.path, err)
+		}
+	}
+	if err := CheckDirPath("downloads/"); err != nil {
+		t.Errorf("CheckDirPath with a trailing slash: %v", err)
+	}
+	for _, dir := range []string{"/tmp/", "../"} {
+		if err := CheckDirPath(dir); err == nil {
+			t.Errorf("CheckDirPath(%q) accepted a directory outside the download directory", dir)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, tc := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/file.iso", true},
+		{"http://127.0.0.1:8080/a?b=c", true},
+		{"example.com/file.iso", false},
+		{"https:///file.iso", false},
+		{"https://example.com/a file.iso", false},
+		{"javascript:alert(1)", false},
+	} {
+		if err := CheckURL(tc.url); (err == nil) != tc.ok {
+			t.Errorf("CheckURL(%q) = %v", tc.url, err)
+		}
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	// A declared length past the limit is refused before reading
+	r := httptest.NewRequest("POST", "/downloads", strings.NewReader(strings.Repeat("x", 100)))
+	if err := LimitBody(httptest.NewRecorder(), r, 10); !errors.Is(err, ErrBodyTooLarge) || !BodyTooLarge(err) {
+		t.Errorf("LimitBody with a long Content-Length: err = %v", err)
+	}
+
+	// A body without a length stops at the limit
+	r = httptest.NewRequest("POST", "/downloads", strings.NewReader(strings.Repeat("x", 100)))
+	r.ContentLength = -1
+	if err := LimitBody(httptest.NewRecorder(), r, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r.Body); !BodyTooLarge(err) {
+		t.Errorf("reading past the limit: err = %v", err)
+	}
+}
+
+func TestMaxBodyBytesFromEnv(t *testing.T) {
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "")
+	if limit, err := MaxBodyBytesFromEnv(); err != nil || limit != DefaultMaxBodyBytes {
+		t.Errorf("default limit = %d, %v", limit, err)
+	}
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "65536")
+	if limit, err := MaxBodyBytesFromEnv(); err != nil || limit != 65536 {
+		t.Errorf("limit = %d, %v", limit, err)
+	}
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "-1")
+	if _, err := MaxBodyBytesFromEnv(); err == nil {
+		t.Error("negative limit accepted")
+	}
+}
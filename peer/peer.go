@@ -0,0 +1,322 @@
+// Package peer lets worker nodes fetch finished files from each other over
+// the internal network instead of from the origin. Every node keeps its
+// finished downloads in its local cache and announces them in a shared
+// directory under their cache keys and SHA-256 checksum; a node about to
+// download a file another node holds asks that node's transfer endpoint for
+// it, authenticated with a token signed with a secret the nodes share, and
+// keeps it only if it has the announced size and checksum.
+package peer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-downloader/cache"
+)
+
+const (
+	// ObjectPath is where the transfer endpoint serves files, followed by
+	// their SHA-256 checksum in hex
+	ObjectPath = "/peer/objects/"
+	// TokenTTL is how long a signed transfer token is accepted
+	TokenTTL = time.Minute
+	// HoldingTTL is how long an announcement lasts unless the file is
+	// announced again
+	HoldingTTL = 24 * time.Hour
+	// DirectoryTimeout bounds announcing and looking up holdings
+	DirectoryTimeout = 5 * time.Second
+)
+
+// ErrUnauthorized is returned for transfer requests without a valid token
+var ErrUnauthorized = errors.New("missing, wrong or expired peer token")
+
+// Holding is a file a node holds in its cache
+type Holding struct {
+	Node string `json:"node"`
+	// Addr is the base URL of the node's transfer endpoint
+	Addr string `json:"addr"`
+	// Checksum is the SHA-256 of the file in hex
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// Directory records which nodes hold which files. Keys reach it as the
+// hex SHA-256 of the cache keys, so URLs carrying credentials never do.
+type Directory interface {
+	// Announce records that h.Node holds the file under each of ids
+	Announce(ctx context.Context, ids []string, h Holding) error
+	// Lookup returns the holdings of the nodes still running recorded
+	// under id
+	Lookup(ctx context.Context, id string) ([]Holding, error)
+}
+
+// ContentKey is the cache key of a file with the given SHA-256 checksum,
+// the same a server's sha-256 digest gives it
+func ContentKey(checksum string) string {
+	return "sha-256:" + checksum
+}
+
+// keyID names a cache key in the directory
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache is a node's local cache that falls back on the files other nodes
+// hold. It implements downloader.Cache.
+type Cache struct {
+	Local     *cache.Cache
+	Directory Directory
+	// Node names this node; its own announcements are skipped
+	Node string
+	// Addr is the base URL other nodes reach this node's Handler at; files
+	// are not announced without one
+	Addr string
+	// Secret signs and checks transfer tokens; every node needs the same
+	Secret []byte
+	Client *http.Client
+	// OnError, when set, is told about announcements and transfers that
+	// failed; the download goes to the origin either way
+	OnError func(error)
+}
+
+// Fetch places the file found under any of keys at dest, from the local
+// cache or else from a node that announced it, and reports whether it did
+func (c *Cache) Fetch(keys []string, size int64, dest string) (bool, error) {
+	if hit, err := c.Local.Fetch(keys, size, dest); hit || err != nil {
+		return hit, err
+	}
+	if c.Directory == nil {
+		return false, nil
+	}
+	for _, h := range c.holdings(keys, size) {
+		if err := c.transfer(h, dest); err != nil {
+			c.report(fmt.Errorf("transfer from peer %s failed: %w", h.Node, err))
+			continue
+		}
+		// Keep and announce the copy, so the next node has two to ask
+		if err := c.Store(withKey(keys, ContentKey(h.Checksum)), dest); err != nil {
+			c.report(err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Store adds the finished file at src to the local cache under keys and
+// its checksum, and announces it to the other nodes
+func (c *Cache) Store(keys []string, src string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	checksum, size, err := fileChecksum(src)
+	if err != nil {
+		return err
+	}
+	keys = withKey(keys, ContentKey(checksum))
+	if err := c.Local.Store(keys, src); err != nil {
+		return err
+	}
+	if c.Directory == nil || c.Addr == "" || size > c.Local.MaxSize {
+		return nil
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = keyID(key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DirectoryTimeout)
+	defer cancel()
+	h := Holding{Node: c.Node, Addr: c.Addr, Checksum: checksum, Size: size}
+	if err := c.Directory.Announce(ctx, ids, h); err != nil {
+		c.report(fmt.Errorf("failed to announce file to peers: %w", err))
+	}
+	return nil
+}
+
+// holdings returns the files of size bytes other nodes announced under
+// keys, each once
+func (c *Cache) holdings(keys []string, size int64) []Holding {
+	ctx, cancel := context.WithTimeout(context.Background(), DirectoryTimeout)
+	defer cancel()
+	seen := map[Holding]bool{}
+	var found []Holding
+	for _, key := range keys {
+		holdings, err := c.Directory.Lookup(ctx, keyID(key))
+		if err != nil {
+			c.report(fmt.Errorf("failed to look up peers: %w", err))
+			return found
+		}
+		for _, h := range holdings {
+			if h.Node == c.Node || h.Size != size || !validChecksum(h.Checksum) || seen[h] {
+				continue
+			}
+			seen[h] = true
+			found = append(found, h)
+		}
+	}
+	return found
+}
+
+// transfer downloads the file of h to dest, checking its size and checksum
+// before it replaces dest
+func (c *Cache) transfer(h Holding, dest string) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(h.Addr, "/")+ObjectPath+h.Checksum, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+Sign(c.Secret, h.Checksum, time.Now().Add(TokenTTL)))
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer answered %s", resp.Status)
+	}
+
+	tmp := dest + ".peer-tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, sum), io.LimitReader(resp.Body, h.Size+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+	case n != h.Size:
+		err = fmt.Errorf("got %d bytes, announced %d", n, h.Size)
+	case hex.EncodeToString(sum.Sum(nil)) != h.Checksum:
+		err = errors.New("checksum mismatch")
+	default:
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (c *Cache) report(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// Handler serves the files of the local cache to the other nodes at
+// ObjectPath, to requests with a token signed for the file
+func (c *Cache) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		checksum := strings.TrimPrefix(r.URL.Path, ObjectPath)
+		if !strings.HasPrefix(r.URL.Path, ObjectPath) || !validChecksum(checksum) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := Verify(c.Secret, checksum, r.Header.Get("Authorization"), time.Now()); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="peer"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		file, err := c.Local.Open([]string{ContentKey(checksum)})
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, file)
+	})
+}
+
+// Sign returns a token that lets its bearer fetch the file with checksum
+// until expires
+func Sign(secret []byte, checksum string, expires time.Time) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return unix + "." + mac(secret, checksum, unix)
+}
+
+// Verify checks an Authorization header holding a token from Sign for the
+// file with checksum; it returns ErrUnauthorized for any other
+func Verify(secret []byte, checksum, authorization string, now time.Time) error {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	dot := strings.IndexByte(token, '.')
+	if token == authorization || dot < 0 {
+		return ErrUnauthorized
+	}
+	unix, signature := token[:dot], token[dot+1:]
+	expires, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return ErrUnauthorized
+	}
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, checksum, unix))) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func mac(secret []byte, checksum, expires string) string {
+	h := hmac.New(sha256.New, secret)
+	io.WriteString(h, checksum+"\n"+expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validChecksum reports whether s is a SHA-256 checksum in lower case hex
+func validChecksum(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// fileChecksum returns the SHA-256 and size of the file at path
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	sum := sha256.New()
+	size, err := io.Copy(sum, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), size, nil
+}
+
+// withKey returns keys with key added unless it is there already
+func withKey(keys []string, key string) []string {
+	for _, k := range keys {
+		if k == key {
+			return keys
+		}
+	}
+	return append(keys[:len(keys):len(keys)], key)
+}
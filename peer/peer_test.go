@@ -0,0 +1,151 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/cache"
+)
+
+// memoryDirectory is a Directory in memory
+type memoryDirectory struct {
+	mu       sync.Mutex
+	holdings map[string][]Holding
+}
+
+func (d *memoryDirectory) Announce(ctx context.Context, ids []string, h Holding) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		d.holdings[id] = append(d.holdings[id], h)
+	}
+	return nil
+}
+
+func (d *memoryDirectory) Lookup(ctx context.Context, id string) ([]Holding, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.holdings[id], nil
+}
+
+func newNode(t *testing.T, name string, dir Directory) (*Cache, *httptest.Server) {
+	t.Helper()
+	local, err := cache.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &Cache{Local: local, Directory: dir, Node: name, Secret: []byte("shared secret")}
+	server := httptest.NewServer(node.Handler())
+	t.Cleanup(server.Close)
+	node.Addr = server.URL
+	return node, server
+}
+
+func TestPeerTransfer(t *testing.T) {
+	dir := &memoryDirectory{holdings: map[string][]Holding{}}
+	a, _ := newNode(t, "worker-a", dir)
+	b, _ := newNode(t, "worker-b", dir)
+	var errs []error
+	b.OnError = func(err error) { errs = append(errs, err) }
+
+	data := bytes.Repeat([]byte("peer content "), 1000)
+	src := filepath.Join(t.TempDir(), "src")
+	os.WriteFile(src, data, 0644)
+	keys := []string{"url:https://example.com/file\netag:\"1\""}
+	if err := a.Store(keys, src); err != nil {
+		t.Fatal(err)
+	}
+
+	// b never downloaded the file but fetches it from a
+	dest := filepath.Join(t.TempDir(), "dest")
+	hit, err := b.Fetch(keys, int64(len(data)), dest)
+	if err != nil || !hit {
+		t.Fatalf("Fetch() = %v, %v, errors %v", hit, err, errs)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Error("transferred file does not match")
+	}
+	// and announces its own copy
+	if holdings, _ := dir.Lookup(context.Background(), keyID(keys[0])); len(holdings) != 2 {
+		t.Errorf("holdings after the transfer = %+v", holdings)
+	}
+
+	// A file of another size is another version
+	if hit, _ := b.Fetch([]string{"url:https://example.com/other"}, 1, dest); hit {
+		t.Error("fetched a file nobody announced")
+	}
+}
+
+func TestPeerTransferChecksum(t *testing.T) {
+	dir := &memoryDirectory{holdings: map[string][]Holding{}}
+	a, _ := newNode(t, "worker-a", dir)
+	b, _ := newNode(t, "worker-b", dir)
+	var errs []error
+	b.OnError = func(err error) { errs = append(errs, err) }
+
+	src := filepath.Join(t.TempDir(), "src")
+	os.WriteFile(src, []byte("original"), 0644)
+	keys := []string{"url:https://example.com/file\netag:\"1\""}
+	if err := a.Store(keys, src); err != nil {
+		t.Fatal(err)
+	}
+	// The copy a holds changes behind the cache's back
+	checksum, _, _ := fileChecksum(src)
+	file, err := a.Local.Open([]string{ContentKey(checksum)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	os.WriteFile(file.Name(), []byte("tampered"), 0644)
+
+	dest := filepath.Join(t.TempDir(), "dest")
+	if hit, _ := b.Fetch(keys, int64(len("original")), dest); hit || len(errs) != 1 {
+		t.Errorf("hit %v with errors %v", hit, errs)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("a file failing its checksum was kept")
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	dir := &memoryDirectory{holdings: map[string][]Holding{}}
+	a, server := newNode(t, "worker-a", dir)
+	src := filepath.Join(t.TempDir(), "src")
+	os.WriteFile(src, []byte("secret file"), 0644)
+	if err := a.Store([]string{"url:x"}, src); err != nil {
+		t.Fatal(err)
+	}
+	checksum, _, _ := fileChecksum(src)
+
+	now := time.Now()
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{Sign(a.Secret, checksum, now.Add(TokenTTL)), http.StatusOK},
+		{Sign([]byte("other secret"), checksum, now.Add(TokenTTL)), http.StatusUnauthorized},
+		{Sign(a.Secret, checksum, now.Add(-time.Minute)), http.StatusUnauthorized},
+		{Sign(a.Secret, ContentKey(checksum), now.Add(TokenTTL)), http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+ObjectPath+checksum, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("token %q: status %d, want %d", tc.token, resp.StatusCode, tc.want)
+		}
+	}
+}
@@ -3,11 +3,26 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/events"
+	"multithreaded-downloader/leader"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/peer"
+	"multithreaded-downloader/resilience"
+	"multithreaded-downloader/secrets"
 )
 
 const (
@@ -16,12 +31,65 @@ const (
 	ProcessingJobsQueue  = "processing_jobs"
 	CompletedJobsQueue   = "completed_jobs"
 	FailedJobsQueue      = "failed_jobs"
+	WaitingJobsHash      = "waiting_jobs"
+	CookiesKey           = "cookies_txt"
+	
+	// EventsChannel is the pub/sub channel lifecycle events are bridged over
+	EventsChannel        = "download_events"
+	
+	// ControlChannel carries live adjustments to the worker running a job
+	ControlChannel       = "download_control"
+	
+	// LeaderKeyPrefix prefixes the leases replicas elect maintenance leaders with
+	LeaderKeyPrefix      = "leader:"
+	
+	// NodeKeyPrefix prefixes the registrations of running worker processes
+	NodeKeyPrefix        = "worker_node:"
+	// NodeJobsQueuePrefix prefixes the queue of jobs waiting for the node
+	// that holds their partial file; NodeJobsQueues lists those queues
+	NodeJobsQueuePrefix  = "download_jobs:node:"
+	NodeJobsQueues       = "node_job_queues"
+	// NodeTTL is how long a registration lasts without being renewed
+	NodeTTL              = 30 * time.Second
+	
+	// PartsKeyPrefix prefixes the part diagnostics workers report for the
+	// jobs they run; they expire PartsTTL after the last report
+	PartsKeyPrefix       = "download_parts:"
+	PartsTTL             = 15 * time.Second
+	
+	// PeerKeyPrefix prefixes the hashes of the files worker nodes hold,
+	// by node, for other nodes to fetch instead of the origin
+	PeerKeyPrefix        = "peer_files:"
 	
 	// Job timeouts
 	JobProcessingTimeout = 30 * time.Minute
 	QueuePollTimeout     = 10 * time.Second
+	
+	// Job status retention
+	JobStatusTTL = 30 * 24 * time.Hour
+	
+	// MaxJobThreads is the most threads a single job may use
+	MaxJobThreads = 16
+	
+	// MaxArchivedJobs caps the completed and failed queues; older jobs are
+	// trimmed as new ones finish
+	MaxArchivedJobs = 1000
+	
+	// statusWriteAttempts is how often a job status write is retried when
+	// another writer changes the status concurrently
+	statusWriteAttempts = 5
+)
+
+// Dependency errors returned by EnqueueJob
+var (
+	ErrDependencyNotFound = errors.New("dependency job not found")
+	ErrDependencyFailed   = errors.New("dependency job failed")
+	ErrDependencyCycle    = errors.New("dependency cycle detected")
 )
 
+// ErrJobNotQueued is returned when a job is not waiting in the main queue
+var ErrJobNotQueued = errors.New("job is not in the queue")
+
 // DownloadJob represents a job in the queue
 type DownloadJob struct {
 	ID         string    `json:"id"`
@@ -31,26 +99,464 @@ type DownloadJob struct {
 	CreatedAt  time.Time `json:"created_at"`
 	StartedAt  time.Time `json:"started_at,omitempty"`
 	WorkerID   string    `json:"worker_id,omitempty"`
+	DependsOn  []string  `json:"depends_on,omitempty"`
+	GroupID    string    `json:"group_id,omitempty"`
+	// Node is the worker node holding the job's partial file, which it is
+	// dispatched to while that node is registered
+	Node       string    `json:"node,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+	// Headers are extra request headers such as Authorization; values are sealed with the master key
+	Headers    map[string]string `json:"headers,omitempty"`
+	// SealedURL holds the sealed full URL when it carries credentials; URL is then redacted
+	SealedURL  string    `json:"sealed_url,omitempty"`
+	// Method, Body and BodyType replace the plain GET; Body is sealed when
+	// the server has a master key
+	Method     string    `json:"method,omitempty"`
+	Body       string    `json:"body,omitempty"`
+	BodyType   string    `json:"body_type,omitempty"`
+	// RateLimit, CookiesFile and the retry policy come from the API
+	// server's domain rules; the cookies file is read on the worker
+	RateLimit   int64         `json:"rate_limit,omitempty"`
+	CookiesFile string        `json:"cookies_file,omitempty"`
+	MaxAttempts int           `json:"max_attempts,omitempty"`
+	RetryDelay  time.Duration `json:"retry_delay,omitempty"`
+	// UserAgents are sent in turn and Jitter delays every request, also
+	// from the domain rules
+	UserAgents  []string      `json:"user_agents,omitempty"`
+	Jitter      time.Duration `json:"jitter,omitempty"`
+	// Bind is the interface or address of the worker host the job connects
+	// from, sharing BindRateLimit with the worker's other jobs bound to it
+	Bind          string      `json:"bind,omitempty"`
+	BindRateLimit int64       `json:"bind_rate_limit,omitempty"`
+	// DoneMarker writes <output>.done once the file is verified
+	DoneMarker    bool        `json:"done_marker,omitempty"`
+	// Plan is the split into parts the job's download uses, when the
+	// request gave one
+	Plan          *downloader.Plan `json:"plan,omitempty"`
+	// RefreshURL is asked for a new link when the server refuses the job's
+	// link as expired; it is sealed when it carries credentials
+	RefreshURL  string        `json:"refresh_url,omitempty"`
+	// PartURLs are the pieces joined into the output, starting with URL,
+	// for a file published split; pieces carrying credentials are sealed
+	PartURLs    []string      `json:"part_urls,omitempty"`
+	// Mirrors serve the same file as URL; parts are spread over the
+	// fastest MirrorCount of them. Mirrors carrying credentials are sealed
+	Mirrors     []string      `json:"mirrors,omitempty"`
+	MirrorCount int           `json:"mirror_count,omitempty"`
+	// OnlyIfModified skips the download, ending the job as NotModified,
+	// when the file at OutputPath is the current version
+	OnlyIfModified bool       `json:"only_if_modified,omitempty"`
+	// ExpectedSize and ExpectedSHA256 check the file against the size and
+	// hex SHA-256 a group's listing gave for it, such as an LFS object's
+	ExpectedSize   int64      `json:"expected_size,omitempty"`
+	ExpectedSHA256 string     `json:"expected_sha256,omitempty"`
+	// User enqueued the job, from the client address of the request, and
+	// Tags label it; the bytes it transfers are accounted to both
+	User        string        `json:"user,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
 }
 
 // JobStatus represents the status of a job
 type JobStatus struct {
-	ID              string    `json:"id"`
-	Status          string    `json:"status"` // "queued", "processing", "completed", "failed"
-	Progress        float64   `json:"progress"`
-	BytesDownloaded int64     `json:"bytes_downloaded"`
-	TotalBytes      int64     `json:"total_bytes"`
-	ErrorMessage    string    `json:"error_message,omitempty"`
-	CreatedAt       time.Time `json:"created_at"`
-	StartedAt       time.Time `json:"started_at,omitempty"`
-	CompletedAt     time.Time `json:"completed_at,omitempty"`
-	WorkerID        string    `json:"worker_id,omitempty"`
+	ID              string           `json:"id"`
+	Status          lifecycle.Status `json:"status"`
+	Progress        float64          `json:"progress"`
+	BytesDownloaded int64            `json:"bytes_downloaded"`
+	TotalBytes      int64            `json:"total_bytes"`
+	ErrorMessage    string           `json:"error_message,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	StartedAt       time.Time        `json:"started_at,omitempty"`
+	CompletedAt     time.Time        `json:"completed_at,omitempty"`
+	WorkerID        string           `json:"worker_id,omitempty"`
+	// Node is the worker node that last ran the job and holds its partial file
+	Node            string           `json:"node,omitempty"`
+	// ThrottledByServer is set while the origin has asked the worker to back off via Retry-After
+	ThrottledByServer bool    `json:"throttled_by_server"`
+	// Seq is the sequence number of the last write; older writes are rejected
+	Seq             int64            `json:"seq,omitempty"`
+}
+
+// ArchivedJob records a job that finished in the completed or failed queue
+type ArchivedJob struct {
+	ID              string           `json:"id"`
+	URL             string           `json:"url,omitempty"`
+	OutputPath      string           `json:"output_path,omitempty"`
+	GroupID         string           `json:"group_id,omitempty"`
+	Status          lifecycle.Status `json:"status"`
+	WorkerID        string           `json:"worker_id,omitempty"`
+	BytesDownloaded int64            `json:"bytes_downloaded"`
+	ErrorMessage    string           `json:"error_message,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	StartedAt       time.Time        `json:"started_at,omitempty"`
+	CompletedAt     time.Time        `json:"completed_at"`
+}
+
+// Duration returns how long the job ran, or zero if it never started
+func (a *ArchivedJob) Duration() time.Duration {
+	if a.StartedAt.IsZero() || a.CompletedAt.Before(a.StartedAt) {
+		return 0
+	}
+	return a.CompletedAt.Sub(a.StartedAt)
+}
+
+// DecodeDownloadJob decodes a job payload read from Redis and rejects payloads
+// a worker could not process
+func DecodeDownloadJob(data []byte) (*DownloadJob, error) {
+	var job DownloadJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+	
+	if err := job.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid job payload: %w", err)
+	}
+	
+	return &job, nil
+}
+
+// Validate checks that a job has everything a worker needs to run it
+func (j *DownloadJob) Validate() error {
+	if j.ID == "" {
+		return fmt.Errorf("missing job id")
+	}
+	if j.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	if j.OutputPath == "" {
+		return fmt.Errorf("missing output path")
+	}
+	if j.Threads < 1 || j.Threads > MaxJobThreads {
+		return fmt.Errorf("threads must be between 1 and %d, got %d", MaxJobThreads, j.Threads)
+	}
+	return nil
+}
+
+// SealSecrets seals the job's headers and, if it carries credentials, its URL
+// so that no secret is stored in Redis or the database in plaintext
+func (j *DownloadJob) SealSecrets(box *secrets.Box) error {
+	headers, err := box.SealHeaders(j.Headers)
+	if err != nil {
+		return err
+	}
+	
+	if secrets.URLHasSecrets(j.URL) {
+		sealedURL, err := box.Seal(j.URL)
+		if err != nil {
+			return fmt.Errorf("failed to seal url: %w", err)
+		}
+		j.SealedURL = sealedURL
+		j.URL = secrets.RedactURL(j.URL)
+	}
+	if secrets.URLHasSecrets(j.RefreshURL) {
+		refreshURL, err := box.Seal(j.RefreshURL)
+		if err != nil {
+			return fmt.Errorf("failed to seal refresh url: %w", err)
+		}
+		j.RefreshURL = refreshURL
+	}
+	// URL stays in the clear for listings, redacted if need be
+	if j.PartURLs, err = sealURLs(box, j.PartURLs); err != nil {
+		return fmt.Errorf("failed to seal part url: %w", err)
+	}
+	if j.Mirrors, err = sealURLs(box, j.Mirrors); err != nil {
+		return fmt.Errorf("failed to seal mirror: %w", err)
+	}
+	
+	// Bodies may hold form logins, but most are plain export queries that
+	// should not need a master key
+	if box != nil {
+		body, err := box.Seal(j.Body)
+		if err != nil {
+			return fmt.Errorf("failed to seal body: %w", err)
+		}
+		j.Body = body
+	}
+	
+	j.Headers = headers
+	return nil
+}
+
+// OpenSecrets returns the job's full URL and plaintext headers for the worker
+func (j *DownloadJob) OpenSecrets(box *secrets.Box) (string, map[string]string, error) {
+	fullURL := j.URL
+	if j.SealedURL != "" {
+		opened, err := box.Open(j.SealedURL)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open url: %w", err)
+		}
+		fullURL = opened
+	}
+	
+	headers, err := box.OpenHeaders(j.Headers)
+	if err != nil {
+		return "", nil, err
+	}
+	return fullURL, headers, nil
+}
+
+// OpenPartURLs returns the job's plaintext pieces for the worker
+func (j *DownloadJob) OpenPartURLs(box *secrets.Box) ([]string, error) {
+	pieces, err := openURLs(box, j.PartURLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open part url: %w", err)
+	}
+	return pieces, nil
+}
+
+// OpenMirrors returns the job's plaintext mirrors for the worker
+func (j *DownloadJob) OpenMirrors(box *secrets.Box) ([]string, error) {
+	mirrors, err := openURLs(box, j.Mirrors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mirror: %w", err)
+	}
+	return mirrors, nil
+}
+
+// sealURLs seals the URLs that carry credentials
+func sealURLs(box *secrets.Box, urls []string) ([]string, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	sealed := make([]string, len(urls))
+	for i, u := range urls {
+		if sealed[i] = u; secrets.URLHasSecrets(u) {
+			var err error
+			if sealed[i], err = box.Seal(u); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sealed, nil
+}
+
+// openURLs opens URLs sealed by sealURLs
+func openURLs(box *secrets.Box, urls []string) ([]string, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	opened := make([]string, len(urls))
+	for i, u := range urls {
+		var err error
+		if opened[i], err = box.Open(u); err != nil {
+			return nil, err
+		}
+	}
+	return opened, nil
+}
+
+// OpenBody returns the job's plaintext request body for the worker
+func (j *DownloadJob) OpenBody(box *secrets.Box) ([]byte, error) {
+	body, err := box.Open(j.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open body: %w", err)
+	}
+	return []byte(body), nil
+}
+
+// DecodeJobStatus decodes a job status written as JSON, as older versions
+// stored it in Redis. Out-of-range progress values are clamped so a
+// corrupted entry never reports negative progress, and statuses written by
+// older versions ("processing") are read as their current name.
+func DecodeJobStatus(data []byte) (*JobStatus, error) {
+	var status JobStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal status: %w", err)
+	}
+	
+	return status.normalize()
+}
+
+// normalize rejects a decoded status without a job id or status and clamps
+// out-of-range progress values
+func (s *JobStatus) normalize() (*JobStatus, error) {
+	if s.ID == "" {
+		return nil, fmt.Errorf("invalid job status: missing job id")
+	}
+	if !s.Status.Valid() {
+		return nil, fmt.Errorf("invalid job status: missing status")
+	}
+	
+	if s.Progress < 0 {
+		s.Progress = 0
+	} else if s.Progress > 100 {
+		s.Progress = 100
+	}
+	if s.TotalBytes < 0 {
+		s.TotalBytes = 0
+	}
+	if s.BytesDownloaded < 0 {
+		s.BytesDownloaded = 0
+	} else if s.TotalBytes > 0 && s.BytesDownloaded > s.TotalBytes {
+		s.BytesDownloaded = s.TotalBytes
+	}
+	
+	return s, nil
+}
+
+// fields returns the hash fields a job status is stored as, named like its
+// JSON keys. Empty strings and unset times are left out.
+func (s *JobStatus) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"id":                  s.ID,
+		"status":              string(s.Status),
+		"progress":            strconv.FormatFloat(s.Progress, 'f', -1, 64),
+		"bytes_downloaded":    strconv.FormatInt(s.BytesDownloaded, 10),
+		"total_bytes":         strconv.FormatInt(s.TotalBytes, 10),
+		"throttled_by_server": strconv.FormatBool(s.ThrottledByServer),
+		"seq":                 strconv.FormatInt(s.Seq, 10),
+	}
+	if s.ErrorMessage != "" {
+		fields["error_message"] = s.ErrorMessage
+	}
+	if s.WorkerID != "" {
+		fields["worker_id"] = s.WorkerID
+	}
+	if s.Node != "" {
+		fields["node"] = s.Node
+	}
+	for name, at := range map[string]time.Time{"created_at": s.CreatedAt, "started_at": s.StartedAt, "completed_at": s.CompletedAt} {
+		if !at.IsZero() {
+			fields[name] = at.Format(time.RFC3339Nano)
+		}
+	}
+	return fields
+}
+
+// decodeJobStatusHash decodes a job status stored as a hash, checking it
+// like DecodeJobStatus
+func decodeJobStatusHash(values map[string]string) (*JobStatus, error) {
+	status := JobStatus{
+		ID:           values["id"],
+		ErrorMessage: values["error_message"],
+		WorkerID:     values["worker_id"],
+		Node:         values["node"],
+	}
+	
+	var err error
+	if status.Status, err = lifecycle.Parse(values["status"]); err != nil {
+		return nil, fmt.Errorf("invalid job status: %w", err)
+	}
+	parse := func(name string, parse func(string) error) {
+		if raw, ok := values[name]; ok && err == nil {
+			if perr := parse(raw); perr != nil {
+				err = fmt.Errorf("invalid job status: field %s: %w", name, perr)
+			}
+		}
+	}
+	parseInt := func(name string, v *int64) {
+		parse(name, func(raw string) (err error) {
+			*v, err = strconv.ParseInt(raw, 10, 64)
+			return err
+		})
+	}
+	parseTime := func(name string, v *time.Time) {
+		parse(name, func(raw string) (err error) {
+			*v, err = time.Parse(time.RFC3339Nano, raw)
+			return err
+		})
+	}
+	parse("progress", func(raw string) (err error) {
+		status.Progress, err = strconv.ParseFloat(raw, 64)
+		return err
+	})
+	parse("throttled_by_server", func(raw string) (err error) {
+		status.ThrottledByServer, err = strconv.ParseBool(raw)
+		return err
+	})
+	parseInt("bytes_downloaded", &status.BytesDownloaded)
+	parseInt("total_bytes", &status.TotalBytes)
+	parseInt("seq", &status.Seq)
+	parseTime("created_at", &status.CreatedAt)
+	parseTime("started_at", &status.StartedAt)
+	parseTime("completed_at", &status.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(status.Progress) {
+		status.Progress = 0
+	}
+	
+	return status.normalize()
 }
 
 // QueueManager handles Redis queue operations
 type QueueManager struct {
 	client *redis.Client
 	logger *zap.Logger
+	// breaker fails commands fast while Redis is down, and writes holds
+	// the job updates made meanwhile until it is back
+	breaker *resilience.Breaker
+	writes  *resilience.Outbox
+	// staleAfter is how long a job may be processing before
+	// CleanupStaleJobs requeues it
+	staleAfter time.Duration
+}
+
+const (
+	// redisRetries is how often go-redis repeats a command that lost its
+	// connection before the error counts against the breaker
+	redisRetries = 3
+	// redisBreakerThreshold commands failing in a row open the breaker for
+	// redisBreakerCooldown
+	redisBreakerThreshold = 5
+	redisBreakerCooldown  = 5 * time.Second
+	// maxBufferedQueueWrites caps the job updates held during an outage
+	maxBufferedQueueWrites = 10000
+)
+
+// redisUnavailable reports whether err means Redis could not be reached, as
+// opposed to a reply such as a missing key or a rejected command
+func redisUnavailable(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, resilience.ErrOpen) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrClosed) ||
+		errors.As(err, &netErr)
+}
+
+// breakerHook puts every Redis command behind a circuit breaker
+type breakerHook struct {
+	breaker *resilience.Breaker
+}
+
+func (h breakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.breaker.Allow()
+}
+
+func (h breakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.record(cmd.Err())
+	return nil
+}
+
+func (h breakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, h.breaker.Allow()
+}
+
+func (h breakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if redisUnavailable(cmd.Err()) {
+			err = cmd.Err()
+			break
+		}
+	}
+	h.record(err)
+	return nil
+}
+
+// record tells the breaker how a command it let through went; commands it
+// refused report nothing
+func (h breakerHook) record(err error) {
+	if errors.Is(err, resilience.ErrOpen) {
+		return
+	}
+	if !redisUnavailable(err) {
+		err = nil
+	}
+	h.breaker.Record(err)
 }
 
 // NewQueueManager creates a new queue manager
@@ -60,7 +566,21 @@ func NewQueueManager(redisURL string, logger *zap.Logger) (*QueueManager, error)
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
 	
+	opts.MaxRetries = redisRetries
 	client := redis.NewClient(opts)
+	breaker := resilience.NewBreaker("redis", redisBreakerThreshold, redisBreakerCooldown)
+	breaker.OnChange = func(name string, open bool) {
+		if open {
+			logger.Warn("Redis unavailable, failing queue commands fast", zap.Duration("retry_in", redisBreakerCooldown))
+		} else {
+			logger.Info("Redis available again")
+		}
+	}
+	client.AddHook(breakerHook{breaker: breaker})
+	writes := resilience.NewOutbox(maxBufferedQueueWrites, redisUnavailable)
+	writes.OnDrop = func(key string) {
+		logger.Error("Too many queue writes buffered, dropping one", zap.String("key", key))
+	}
 	
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -73,15 +593,46 @@ func NewQueueManager(redisURL string, logger *zap.Logger) (*QueueManager, error)
 	logger.Info("Connected to Redis successfully", zap.String("addr", opts.Addr))
 	
 	return &QueueManager{
-		client: client,
-		logger: logger,
+		client:     client,
+		logger:     logger,
+		breaker:    breaker,
+		writes:     writes,
+		staleAfter: JobProcessingTimeout,
 	}, nil
 }
 
+// Buffered runs a job update now, or holds it while Redis is unavailable
+// and after earlier held updates, so a short outage does not lose the
+// outcome of a finished download. A newer update with the same key replaces
+// a held one. Other errors are returned.
+func (qm *QueueManager) Buffered(ctx context.Context, key string, write func(ctx context.Context) error) error {
+	return qm.writes.Do(ctx, key, write)
+}
+
+// FlushWrites sends the held job updates; the workers call it periodically
+// and before they exit
+func (qm *QueueManager) FlushWrites(ctx context.Context) error {
+	flushed, err := qm.writes.Flush(ctx)
+	if flushed > 0 {
+		qm.logger.Info("Sent buffered queue writes", zap.Int("writes", flushed), zap.Int("pending", qm.writes.Len()))
+	}
+	return err
+}
+
+// PendingWrites returns how many job updates wait for Redis
+func (qm *QueueManager) PendingWrites() int {
+	return qm.writes.Len()
+}
+
 // EnqueueJob adds a new download job to the queue
 func (qm *QueueManager) EnqueueJob(ctx context.Context, job *DownloadJob) error {
 	job.CreatedAt = time.Now()
 	
+	// Jobs with dependencies are held back until every dependency has completed
+	if len(job.DependsOn) > 0 {
+		return qm.enqueueWithDependencies(ctx, job)
+	}
+	
 	jobData, err := json.Marshal(job)
 	if err != nil {
 		return fmt.Errorf("failed to marshal job: %w", err)
@@ -95,7 +646,7 @@ func (qm *QueueManager) EnqueueJob(ctx context.Context, job *DownloadJob) error
 	// Set initial status
 	status := &JobStatus{
 		ID:        job.ID,
-		Status:    "queued",
+		Status:    lifecycle.Queued,
 		CreatedAt: job.CreatedAt,
 	}
 	
@@ -113,11 +664,19 @@ func (qm *QueueManager) EnqueueJob(ctx context.Context, job *DownloadJob) error
 	return nil
 }
 
-// DequeueJob retrieves and removes a job from the queue (blocking operation)
-func (qm *QueueManager) DequeueJob(ctx context.Context, workerID string) (*DownloadJob, error) {
-	// Use BRPOPLPUSH for reliable queue processing
-	// This atomically moves the job from the main queue to a processing queue
-	result, err := qm.client.BRPopLPush(ctx, DownloadJobsQueue, ProcessingJobsQueue, QueuePollTimeout).Result()
+// DequeueJob retrieves and removes a job from the queue (blocking operation).
+// Jobs waiting for node, the node of the worker, are taken first.
+func (qm *QueueManager) DequeueJob(ctx context.Context, workerID string, node string) (*DownloadJob, error) {
+	// Use (B)RPOPLPUSH for reliable queue processing
+	// This atomically moves the job from its queue to a processing queue
+	var result string
+	var err error = redis.Nil
+	if node != "" {
+		result, err = qm.client.RPopLPush(ctx, nodeJobsQueue(node), ProcessingJobsQueue).Result()
+	}
+	if err == redis.Nil {
+		result, err = qm.client.BRPopLPush(ctx, DownloadJobsQueue, ProcessingJobsQueue, QueuePollTimeout).Result()
+	}
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil // No jobs available
@@ -125,28 +684,39 @@ func (qm *QueueManager) DequeueJob(ctx context.Context, workerID string) (*Downl
 		return nil, fmt.Errorf("failed to dequeue job: %w", err)
 	}
 	
-	var job DownloadJob
-	if err := json.Unmarshal([]byte(result), &job); err != nil {
-		// If we can't unmarshal, move to failed queue
-		qm.client.LPush(ctx, FailedJobsQueue, result)
-		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	job, err := DecodeDownloadJob([]byte(result))
+	if err != nil {
+		// If we can't decode it, move it to the failed queue with whatever
+		// could be read from it
+		qm.client.LRem(ctx, ProcessingJobsQueue, 1, result)
+		var partial DownloadJob
+		json.Unmarshal([]byte(result), &partial)
+		qm.archiveJob(ctx, FailedJobsQueue, &partial, &JobStatus{
+			ID:           partial.ID,
+			Status:       lifecycle.Failed,
+			CreatedAt:    partial.CreatedAt,
+			CompletedAt:  time.Now(),
+			ErrorMessage: err.Error(),
+		})
+		return nil, err
 	}
 	
 	// Update job with worker info
 	job.StartedAt = time.Now()
 	job.WorkerID = workerID
 	
-	// Update status to processing
+	// Update status to downloading, recording where the partial file will be
 	status := &JobStatus{
 		ID:        job.ID,
-		Status:    "processing",
+		Status:    lifecycle.Downloading,
 		CreatedAt: job.CreatedAt,
 		StartedAt: job.StartedAt,
 		WorkerID:  workerID,
+		Node:      node,
 	}
 	
 	if err := qm.SetJobStatus(ctx, status); err != nil {
-		qm.logger.Warn("Failed to set processing job status", 
+		qm.logger.Warn("Failed to set downloading job status", 
 			zap.String("job_id", job.ID),
 			zap.String("worker_id", workerID),
 			zap.Error(err))
@@ -157,34 +727,59 @@ func (qm *QueueManager) DequeueJob(ctx context.Context, workerID string) (*Downl
 		zap.String("worker_id", workerID),
 		zap.String("url", job.URL))
 	
-	return &job, nil
+	return job, nil
 }
 
 // CompleteJob marks a job as completed and moves it to completed queue
 func (qm *QueueManager) CompleteJob(ctx context.Context, jobID string, workerID string) error {
+	return qm.finishJob(ctx, jobID, workerID, lifecycle.Completed)
+}
+
+// NotModifiedJob ends a job whose output was already the current version
+// of the remote file; it counts as completed
+func (qm *QueueManager) NotModifiedJob(ctx context.Context, jobID string, workerID string) error {
+	return qm.finishJob(ctx, jobID, workerID, lifecycle.NotModified)
+}
+
+// finishJob marks a job as done with final and moves it to completed queue
+func (qm *QueueManager) finishJob(ctx context.Context, jobID string, workerID string, final lifecycle.Status) error {
 	// Remove from processing queue
-	if err := qm.removeFromProcessingQueue(ctx, jobID); err != nil {
+	job, err := qm.removeFromProcessingQueue(ctx, jobID)
+	if err != nil {
 		qm.logger.Warn("Failed to remove job from processing queue", 
 			zap.String("job_id", jobID),
 			zap.Error(err))
 	}
 	
-	// Update status
-	status := &JobStatus{
-		ID:          jobID,
-		Status:      "completed",
-		CompletedAt: time.Now(),
-		WorkerID:    workerID,
-		Progress:    100.0,
-	}
-	
-	if err := qm.SetJobStatus(ctx, status); err != nil {
+	// Update status, keeping the recorded progress and timestamps
+	var status *JobStatus
+	err = qm.updateJobStatus(ctx, jobID, 0, func(current *JobStatus) (*JobStatus, error) {
+		status = &JobStatus{ID: jobID}
+		if current != nil {
+			status = current
+		}
+		status.Status = final
+		status.CompletedAt = time.Now()
+		status.WorkerID = workerID
+		status.Progress = 100.0
+		if status.TotalBytes > 0 {
+			status.BytesDownloaded = status.TotalBytes
+		}
+		status.ThrottledByServer = false
+		return status, nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to set completed status: %w", err)
 	}
+	qm.archiveJob(ctx, CompletedJobsQueue, job, status)
 	
 	qm.logger.Info("Job completed successfully", 
 		zap.String("job_id", jobID),
-		zap.String("worker_id", workerID))
+		zap.String("worker_id", workerID),
+		zap.String("status", final.String()))
+	
+	// Release any jobs that were waiting on this one
+	qm.releaseDependents(ctx, jobID)
 	
 	return nil
 }
@@ -192,141 +787,773 @@ func (qm *QueueManager) CompleteJob(ctx context.Context, jobID string, workerID
 // FailJob marks a job as failed and moves it to failed queue
 func (qm *QueueManager) FailJob(ctx context.Context, jobID string, workerID string, errorMsg string) error {
 	// Remove from processing queue
-	if err := qm.removeFromProcessingQueue(ctx, jobID); err != nil {
+	job, err := qm.removeFromProcessingQueue(ctx, jobID)
+	if err != nil {
 		qm.logger.Warn("Failed to remove job from processing queue", 
 			zap.String("job_id", jobID),
 			zap.Error(err))
 	}
 	
-	// Update status
-	status := &JobStatus{
-		ID:           jobID,
-		Status:       "failed",
-		CompletedAt:  time.Now(),
-		WorkerID:     workerID,
-		ErrorMessage: errorMsg,
-	}
-	
-	if err := qm.SetJobStatus(ctx, status); err != nil {
+	// Update status, keeping the progress made before the failure
+	var status *JobStatus
+	err = qm.updateJobStatus(ctx, jobID, 0, func(current *JobStatus) (*JobStatus, error) {
+		status = &JobStatus{ID: jobID}
+		if current != nil {
+			status = current
+		}
+		status.Status = lifecycle.Failed
+		status.CompletedAt = time.Now()
+		status.WorkerID = workerID
+		status.ErrorMessage = errorMsg
+		status.ThrottledByServer = false
+		return status, nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to set failed status: %w", err)
 	}
+	qm.archiveJob(ctx, FailedJobsQueue, job, status)
 	
 	qm.logger.Error("Job failed", 
 		zap.String("job_id", jobID),
 		zap.String("worker_id", workerID),
 		zap.String("error", errorMsg))
 	
+	// Jobs that depend on this one can never run
+	qm.cascadeFailure(ctx, jobID)
+	
 	return nil
 }
 
-// UpdateJobProgress updates the progress of a job
-func (qm *QueueManager) UpdateJobProgress(ctx context.Context, jobID string, progress float64, bytesDownloaded, totalBytes int64) error {
+// progressScript sets the progress fields of a job status hash in place,
+// unless the job finished or a write newer than the snapshot was stored.
+// Sequence numbers exceed what Lua numbers hold exactly, so they are
+// compared as decimal strings. It returns 1 when written, 0 for a stale
+// snapshot and -1 for a status still stored as JSON by an older version.
+//
+// KEYS[1] is the status key; ARGV holds the job id, seq, bytes downloaded,
+// total bytes, progress, throttled_by_server, the TTL in seconds, the status
+// of a job without one, then the final statuses.
+var progressScript = redis.NewScript(`
+local kind = redis.call("TYPE", KEYS[1]).ok
+if kind == "string" then
+	return -1
+end
+if kind == "hash" then
+	local stored = redis.call("HMGET", KEYS[1], "status", "seq")
+	for i = 9, #ARGV do
+		if stored[1] == ARGV[i] then
+			return 0
+		end
+	end
+	local seq = stored[2] or ""
+	if #seq > #ARGV[2] or (#seq == #ARGV[2] and seq >= ARGV[2]) then
+		return 0
+	end
+else
+	redis.call("HSET", KEYS[1], "id", ARGV[1], "status", ARGV[8])
+end
+redis.call("HSET", KEYS[1], "seq", ARGV[2], "bytes_downloaded", ARGV[3], "total_bytes", ARGV[4], "progress", ARGV[5], "throttled_by_server", ARGV[6])
+redis.call("EXPIRE", KEYS[1], ARGV[7])
+return 1
+`)
+
+// finalStatuses are the statuses progress may no longer change
+var finalStatuses = func() []interface{} {
+	var final []interface{}
+	for _, status := range lifecycle.All {
+		if status.Terminal() {
+			final = append(final, string(status))
+		}
+	}
+	return final
+}()
+
+// progressArgs returns the progressScript arguments for a progress snapshot
+func progressArgs(jobID string, progress float64, bytesDownloaded, totalBytes int64, throttled bool, seq int64) []interface{} {
+	args := []interface{}{
+		jobID,
+		strconv.FormatInt(seq, 10),
+		strconv.FormatInt(bytesDownloaded, 10),
+		strconv.FormatInt(totalBytes, 10),
+		strconv.FormatFloat(progress, 'f', -1, 64),
+		strconv.FormatBool(throttled),
+		int64(JobStatusTTL / time.Second),
+		string(lifecycle.Downloading),
+	}
+	return append(args, finalStatuses...)
+}
+
+// UpdateJobProgress updates the progress of a job read at sequence number
+// seq (see lifecycle.NextSeq). Progress taken before the last stored write,
+// or reported after the job finished, is rejected with lifecycle.ErrStale.
+// Only the progress fields of the status are written, in one atomic step.
+func (qm *QueueManager) UpdateJobProgress(ctx context.Context, jobID string, progress float64, bytesDownloaded, totalBytes int64, throttled bool, seq int64) error {
+	if seq == 0 {
+		seq = lifecycle.NextSeq()
+	}
 	statusKey := fmt.Sprintf("job_status:%s", jobID)
 	
-	// Get current status
-	statusData, err := qm.client.Get(ctx, statusKey).Result()
-	if err != nil && err != redis.Nil {
-		return fmt.Errorf("failed to get current status: %w", err)
+	written, err := progressScript.Run(ctx, qm.client, []string{statusKey}, progressArgs(jobID, progress, bytesDownloaded, totalBytes, throttled, seq)...).Int()
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
 	}
-	
-	var status JobStatus
-	if err == redis.Nil {
-		// Status doesn't exist, create a basic one
-		status = JobStatus{
-			ID:     jobID,
-			Status: "processing",
+	switch written {
+	case 0:
+		return fmt.Errorf("job %s finished or has a newer write: %w", jobID, lifecycle.ErrStale)
+	case -1:
+		return qm.updateLegacyJobProgress(ctx, jobID, progress, bytesDownloaded, totalBytes, throttled, seq)
+	}
+	return nil
+}
+
+// updateLegacyJobProgress updates the progress of a job whose status an
+// older version stored as JSON, rewriting it as a hash
+func (qm *QueueManager) updateLegacyJobProgress(ctx context.Context, jobID string, progress float64, bytesDownloaded, totalBytes int64, throttled bool, seq int64) error {
+	return qm.updateJobStatus(ctx, jobID, seq, func(current *JobStatus) (*JobStatus, error) {
+		status := current
+		if status == nil {
+			// Status doesn't exist, create a basic one
+			status = &JobStatus{
+				ID:     jobID,
+				Status: lifecycle.Downloading,
+			}
 		}
-	} else {
-		if err := json.Unmarshal([]byte(statusData), &status); err != nil {
-			return fmt.Errorf("failed to unmarshal status: %w", err)
+		if status.Status.Terminal() {
+			return nil, fmt.Errorf("job %s is %s: %w", jobID, status.Status, lifecycle.ErrStale)
 		}
+		
+		// Update progress fields
+		status.Progress = progress
+		status.BytesDownloaded = bytesDownloaded
+		status.TotalBytes = totalBytes
+		status.ThrottledByServer = throttled
+		return status, nil
+	})
+}
+
+// UpdateJobsProgress records a batch of progress events in one round trip
+// however many jobs it holds, pipelining a progressScript call per job.
+// Snapshots older than the stored status, and progress for jobs that already
+// finished, are skipped.
+func (qm *QueueManager) UpdateJobsProgress(ctx context.Context, batch []events.Event) error {
+	if len(batch) == 0 {
+		return nil
 	}
 	
-	// Update progress fields
-	status.Progress = progress
-	status.BytesDownloaded = bytesDownloaded
-	status.TotalBytes = totalBytes
+	cmds := make([]*redis.Cmd, len(batch))
+	_, err := qm.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, e := range batch {
+			statusKey := fmt.Sprintf("job_status:%s", e.DownloadID)
+			cmds[i] = progressScript.Eval(ctx, pipe, []string{statusKey}, progressArgs(e.DownloadID, percent(e.BytesDownloaded, e.TotalBytes), e.BytesDownloaded, e.TotalBytes, e.Throttled, e.Seq)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
 	
-	return qm.SetJobStatus(ctx, &status)
+	for i, cmd := range cmds {
+		if written, _ := cmd.Int(); written == -1 {
+			e := batch[i]
+			err := qm.updateLegacyJobProgress(ctx, e.DownloadID, percent(e.BytesDownloaded, e.TotalBytes), e.BytesDownloaded, e.TotalBytes, e.Throttled, e.Seq)
+			if err != nil && !errors.Is(err, lifecycle.ErrStale) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// percent returns how much of total done is, or 0 when total is unknown
+func percent(done, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(done) / float64(total) * 100
 }
 
-// SetJobStatus sets the status of a job
+// SetJobStatus sets the status of a job. A job that already has a status may
+// only move to a status the lifecycle allows from it. A status without a
+// sequence number gets the next one; a status carrying one that is not newer
+// than the stored one is rejected with lifecycle.ErrStale.
 func (qm *QueueManager) SetJobStatus(ctx context.Context, status *JobStatus) error {
-	statusKey := fmt.Sprintf("job_status:%s", status.ID)
+	return qm.updateJobStatus(ctx, status.ID, status.Seq, func(current *JobStatus) (*JobStatus, error) {
+		if current != nil {
+			if _, err := current.Status.Transition(status.Status); err != nil {
+				return nil, fmt.Errorf("job %s: %w", status.ID, err)
+			}
+		}
+		return status, nil
+	})
+}
+
+// updateJobStatus replaces the status of a job with what update returns for
+// the stored status, or for nil if there is none. The read and the write are
+// one optimistic transaction, retried when another writer changes the status
+// in between, so concurrent writers cannot overwrite each other's newer
+// state. A seq of 0 stamps the write with the next sequence number.
+func (qm *QueueManager) updateJobStatus(ctx context.Context, jobID string, seq int64, update func(current *JobStatus) (*JobStatus, error)) error {
+	statusKey := fmt.Sprintf("job_status:%s", jobID)
 	
-	statusData, err := json.Marshal(status)
-	if err != nil {
-		return fmt.Errorf("failed to marshal status: %w", err)
+	write := func(tx *redis.Tx) error {
+		current, err := readJobStatus(ctx, tx, statusKey)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			lifecycle.Observe(current.Seq)
+		}
+		
+		if seq != 0 && current != nil && seq <= current.Seq {
+			return fmt.Errorf("job %s: %w", jobID, lifecycle.ErrStale)
+		}
+		
+		next, err := update(current)
+		if err != nil {
+			return err
+		}
+		next.Seq = seq
+		if next.Seq == 0 {
+			next.Seq = lifecycle.NextSeq()
+		}
+		
+		// Replace the status with expiration (30 days) unless it changed
+		// since it was read; deleting first drops cleared fields and
+		// converts a status stored as JSON
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, statusKey)
+			pipe.HSet(ctx, statusKey, next.fields())
+			pipe.Expire(ctx, statusKey, JobStatusTTL)
+			return nil
+		})
+		return err
 	}
 	
-	// Set status with expiration (30 days)
-	if err := qm.client.Set(ctx, statusKey, statusData, 30*24*time.Hour).Err(); err != nil {
-		return fmt.Errorf("failed to set job status: %w", err)
+	for attempt := 0; attempt < statusWriteAttempts; attempt++ {
+		err := qm.client.Watch(ctx, write, statusKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
 	}
-	
-	return nil
+	return fmt.Errorf("failed to set job status: %s kept changing during %d attempts", jobID, statusWriteAttempts)
 }
 
 // GetJobStatus retrieves the status of a job
 func (qm *QueueManager) GetJobStatus(ctx context.Context, jobID string) (*JobStatus, error) {
-	statusKey := fmt.Sprintf("job_status:%s", jobID)
-	
-	statusData, err := qm.client.Get(ctx, statusKey).Result()
+	status, err := readJobStatus(ctx, qm.client, fmt.Sprintf("job_status:%s", jobID))
 	if err != nil {
-		if err == redis.Nil {
-			return nil, fmt.Errorf("job not found")
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("job not found")
+	}
+	return status, nil
+}
+
+// statusReader is what readJobStatus needs from a client or transaction
+type statusReader interface {
+	HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+}
+
+// readJobStatus reads the status stored at key, or nil if there is none. A
+// status an older version stored as JSON is decoded as such until its next
+// write turns it into a hash.
+func readJobStatus(ctx context.Context, c statusReader, key string) (*JobStatus, error) {
+	values, err := c.HGetAll(ctx, key).Result()
+	if err == nil {
+		if len(values) == 0 {
+			return nil, nil
 		}
+		return decodeJobStatusHash(values)
+	}
+	if !strings.HasPrefix(err.Error(), "WRONGTYPE") {
 		return nil, fmt.Errorf("failed to get job status: %w", err)
 	}
 	
-	var status JobStatus
-	if err := json.Unmarshal([]byte(statusData), &status); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal status: %w", err)
+	data, err := c.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, nil
 	}
-	
-	return &status, nil
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job status: %w", err)
+	}
+	return DecodeJobStatus([]byte(data))
 }
 
-// GetQueueStats returns statistics about the queues
+// GetQueueStats returns statistics about the queues. The lengths are read
+// in one transaction, so they describe the same moment.
 func (qm *QueueManager) GetQueueStats(ctx context.Context) (map[string]int64, error) {
-	stats := make(map[string]int64)
-	
-	// Get queue lengths
-	queuedJobs, err := qm.client.LLen(ctx, DownloadJobsQueue).Result()
+	var queued, processing, completed, failed, waiting *redis.IntCmd
+	_, err := qm.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		queued = pipe.LLen(ctx, DownloadJobsQueue)
+		processing = pipe.LLen(ctx, ProcessingJobsQueue)
+		completed = pipe.LLen(ctx, CompletedJobsQueue)
+		failed = pipe.LLen(ctx, FailedJobsQueue)
+		waiting = pipe.HLen(ctx, WaitingJobsHash)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get queued jobs count: %w", err)
+		return nil, fmt.Errorf("failed to get queue lengths: %w", err)
 	}
-	stats["queued"] = queuedJobs
 	
-	processingJobs, err := qm.client.LLen(ctx, ProcessingJobsQueue).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get processing jobs count: %w", err)
-	}
-	stats["processing"] = processingJobs
-	
-	completedJobs, err := qm.client.LLen(ctx, CompletedJobsQueue).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get completed jobs count: %w", err)
+	stats := map[string]int64{
+		"queued":     queued.Val(),
+		"processing": processing.Val(),
+		"completed":  completed.Val(),
+		"failed":     failed.Val(),
+		"waiting":    waiting.Val(),
 	}
-	stats["completed"] = completedJobs
+	stats["total"] = stats["queued"] + stats["processing"] + stats["completed"] + stats["failed"] + stats["waiting"]
 	
-	failedJobs, err := qm.client.LLen(ctx, FailedJobsQueue).Result()
+	return stats, nil
+}
+
+// QueuedJob is a job waiting in the main queue or in the queue of a node
+type QueuedJob struct {
+	*DownloadJob
+	// Position is the job's place in its queue; 1 is the job taken next
+	Position int
+	// Queue is the node whose queue holds the job, or "" for the main queue
+	Queue string
+}
+
+// ListQueuedJobs returns the jobs waiting in the main queue in the order
+// workers take them, followed by the jobs waiting for a node in the queue
+// of each node, which its workers take from before the main one. Jobs held
+// back by their dependencies are in neither.
+func (qm *QueueManager) ListQueuedJobs(ctx context.Context) ([]QueuedJob, error) {
+	jobs, err := qm.listQueue(ctx, DownloadJobsQueue, "")
+	if err != nil {
+		return nil, err
+	}
+	
+	queues, err := qm.client.SMembers(ctx, NodeJobsQueues).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node queues: %w", err)
+	}
+	sort.Strings(queues)
+	for _, queue := range queues {
+		nodeJobs, err := qm.listQueue(ctx, queue, strings.TrimPrefix(queue, NodeJobsQueuePrefix))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, nodeJobs...)
+	}
+	return jobs, nil
+}
+
+// listQueue returns the jobs of one queue, the next one first
+func (qm *QueueManager) listQueue(ctx context.Context, queue, node string) ([]QueuedJob, error) {
+	entries, err := qm.client.LRange(ctx, queue, 0, -1).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get failed jobs count: %w", err)
+		return nil, fmt.Errorf("failed to get queued jobs: %w", err)
 	}
-	stats["failed"] = failedJobs
 	
-	stats["total"] = queuedJobs + processingJobs + completedJobs + failedJobs
+	// Workers pop from the right, so the next job is the last entry
+	jobs := make([]QueuedJob, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		var job DownloadJob
+		if err := json.Unmarshal([]byte(entries[i]), &job); err != nil {
+			continue
+		}
+		jobs = append(jobs, QueuedJob{DownloadJob: &job, Position: len(jobs) + 1, Queue: node})
+	}
+	return jobs, nil
+}
+
+// moveToFrontScript moves a job payload to the end of the main queue that
+// workers pop next, unless a worker took it in the meantime
+var moveToFrontScript = redis.NewScript(`
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call("RPUSH", KEYS[1], ARGV[1])
+return 1
+`)
+
+// MoveJobToFront makes a queued job the next one a worker takes
+func (qm *QueueManager) MoveJobToFront(ctx context.Context, jobID string) error {
+	entries, err := qm.client.LRange(ctx, DownloadJobsQueue, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get queued jobs: %w", err)
+	}
 	
-	return stats, nil
+	for _, jobData := range entries {
+		var job DownloadJob
+		if err := json.Unmarshal([]byte(jobData), &job); err != nil || job.ID != jobID {
+			continue
+		}
+		
+		moved, err := moveToFrontScript.Run(ctx, qm.client, []string{DownloadJobsQueue}, jobData).Int()
+		if err != nil {
+			return fmt.Errorf("failed to move job to the front: %w", err)
+		}
+		if moved == 0 {
+			return fmt.Errorf("%w: %s was taken by a worker", ErrJobNotQueued, jobID)
+		}
+		
+		qm.logger.Info("Job moved to the front of the queue", zap.String("job_id", jobID))
+		return nil
+	}
+	
+	return fmt.Errorf("%w: %s", ErrJobNotQueued, jobID)
+}
+
+// EnqueueGroup enqueues a set of jobs that belong together and records the membership
+func (qm *QueueManager) EnqueueGroup(ctx context.Context, groupID string, jobs []*DownloadJob) error {
+	groupKey := fmt.Sprintf("group_jobs:%s", groupID)
+	
+	for _, job := range jobs {
+		job.GroupID = groupID
+		if err := qm.EnqueueJob(ctx, job); err != nil {
+			return fmt.Errorf("failed to enqueue job %s of group %s: %w", job.ID, groupID, err)
+		}
+		
+		if err := qm.client.RPush(ctx, groupKey, job.ID).Err(); err != nil {
+			return fmt.Errorf("failed to record group membership: %w", err)
+		}
+	}
+	qm.client.Expire(ctx, groupKey, JobStatusTTL)
+	
+	qm.logger.Info("Job group enqueued", 
+		zap.String("group_id", groupID),
+		zap.Int("jobs", len(jobs)))
+	
+	return nil
+}
+
+// GetGroupJobs returns the job IDs that belong to a group, in enqueue order
+func (qm *QueueManager) GetGroupJobs(ctx context.Context, groupID string) ([]string, error) {
+	jobIDs, err := qm.client.LRange(ctx, fmt.Sprintf("group_jobs:%s", groupID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group jobs: %w", err)
+	}
+	if len(jobIDs) == 0 {
+		return nil, fmt.Errorf("group not found")
+	}
+	return jobIDs, nil
+}
+
+// groupChecksumsLockTTL bounds how long a crashed worker keeps others from
+// writing the checksum file of a group
+const groupChecksumsLockTTL = time.Minute
+
+// LockGroupChecksums reports whether the caller may write the checksum file
+// of a finished group now; the workers finishing its last jobs may all try
+// at once. The lock is given back with UnlockGroupChecksums.
+func (qm *QueueManager) LockGroupChecksums(ctx context.Context, groupID string) (bool, error) {
+	locked, err := qm.client.SetNX(ctx, fmt.Sprintf("group_checksums_lock:%s", groupID), time.Now().Unix(), groupChecksumsLockTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to lock group checksums: %w", err)
+	}
+	return locked, nil
+}
+
+// UnlockGroupChecksums gives back the lock of LockGroupChecksums
+func (qm *QueueManager) UnlockGroupChecksums(ctx context.Context, groupID string) error {
+	return qm.client.Del(ctx, fmt.Sprintf("group_checksums_lock:%s", groupID)).Err()
+}
+
+// enqueueWithDependencies registers a job that must wait for other jobs to complete
+func (qm *QueueManager) enqueueWithDependencies(ctx context.Context, job *DownloadJob) error {
+	if err := findDependencyCycle(job.ID, job.DependsOn, func(id string) ([]string, error) {
+		return qm.GetJobDependencies(ctx, id)
+	}); err != nil {
+		return err
+	}
+	
+	// Verify every dependency exists and has not already failed
+	state, depID := resolveDependencies(job.DependsOn, qm.dependencyStatus(ctx))
+	switch state {
+	case dependencyMissing:
+		return fmt.Errorf("%w: %s", ErrDependencyNotFound, depID)
+	case dependencyFailed:
+		return fmt.Errorf("%w: %s", ErrDependencyFailed, depID)
+	}
+	
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	
+	// Record the dependency edges so later jobs can be checked for cycles
+	depsKey := fmt.Sprintf("job_deps:%s", job.ID)
+	if err := qm.client.SAdd(ctx, depsKey, stringsToInterfaces(job.DependsOn)...).Err(); err != nil {
+		return fmt.Errorf("failed to store job dependencies: %w", err)
+	}
+	qm.client.Expire(ctx, depsKey, JobStatusTTL)
+	
+	if state == dependenciesCompleted {
+		// Everything is already done, queue straight away
+		if err := qm.client.LPush(ctx, DownloadJobsQueue, jobData).Err(); err != nil {
+			return fmt.Errorf("failed to enqueue job: %w", err)
+		}
+		
+		status := &JobStatus{
+			ID:        job.ID,
+			Status:    lifecycle.Queued,
+			CreatedAt: job.CreatedAt,
+		}
+		if err := qm.SetJobStatus(ctx, status); err != nil {
+			qm.logger.Warn("Failed to set initial job status", 
+				zap.String("job_id", job.ID),
+				zap.Error(err))
+		}
+		
+		qm.logger.Info("Job enqueued successfully", 
+			zap.String("job_id", job.ID),
+			zap.String("url", job.URL),
+			zap.Strings("depends_on", job.DependsOn))
+		return nil
+	}
+	
+	// Park the job until its dependencies complete
+	if err := qm.client.HSet(ctx, WaitingJobsHash, job.ID, jobData).Err(); err != nil {
+		return fmt.Errorf("failed to store waiting job: %w", err)
+	}
+	
+	for _, depID := range job.DependsOn {
+		dependentsKey := fmt.Sprintf("job_dependents:%s", depID)
+		if err := qm.client.SAdd(ctx, dependentsKey, job.ID).Err(); err != nil {
+			return fmt.Errorf("failed to register dependency on %s: %w", depID, err)
+		}
+		qm.client.Expire(ctx, dependentsKey, JobStatusTTL)
+	}
+	
+	status := &JobStatus{
+		ID:        job.ID,
+		Status:    lifecycle.Waiting,
+		CreatedAt: job.CreatedAt,
+	}
+	if err := qm.SetJobStatus(ctx, status); err != nil {
+		qm.logger.Warn("Failed to set initial job status", 
+			zap.String("job_id", job.ID),
+			zap.Error(err))
+	}
+	
+	qm.logger.Info("Job waiting on dependencies", 
+		zap.String("job_id", job.ID),
+		zap.String("url", job.URL),
+		zap.Strings("depends_on", job.DependsOn))
+	
+	// A dependency may have completed or failed while we were registering,
+	// before its completion or cascade could see this job
+	qm.releaseIfReady(ctx, job.ID)
+	
+	return nil
+}
+
+// dependencyState is what the statuses of a job's dependencies mean for it
+type dependencyState int
+
+const (
+	dependenciesPending dependencyState = iota
+	dependenciesCompleted
+	dependencyFailed
+	dependencyMissing
+)
+
+// resolveDependencies reads the status of every dependency: a missing or
+// failed one decides the outcome and is returned, otherwise the job is
+// ready once all of them completed. status reports false for unknown jobs.
+func resolveDependencies(dependsOn []string, status func(id string) (lifecycle.Status, bool)) (dependencyState, string) {
+	state := dependenciesCompleted
+	for _, depID := range dependsOn {
+		depStatus, ok := status(depID)
+		switch {
+		case !ok:
+			return dependencyMissing, depID
+		case depStatus == lifecycle.Failed:
+			return dependencyFailed, depID
+		case !depStatus.Succeeded():
+			state = dependenciesPending
+		}
+	}
+	return state, ""
+}
+
+// dependencyStatus looks up job statuses for resolveDependencies
+func (qm *QueueManager) dependencyStatus(ctx context.Context) func(id string) (lifecycle.Status, bool) {
+	return func(id string) (lifecycle.Status, bool) {
+		status, err := qm.GetJobStatus(ctx, id)
+		if err != nil {
+			return "", false
+		}
+		return status.Status, true
+	}
+}
+
+// findDependencyCycle walks the dependency graph, as deps reports it, looking
+// for a path from dependsOn back to jobID
+func findDependencyCycle(jobID string, dependsOn []string, deps func(id string) ([]string, error)) error {
+	visited := make(map[string]bool)
+	stack := append([]string(nil), dependsOn...)
+	
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		
+		if current == jobID {
+			return fmt.Errorf("%w: job %s depends on itself", ErrDependencyCycle, jobID)
+		}
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+		
+		next, err := deps(current)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, next...)
+	}
+	
+	return nil
+}
+
+// GetJobDependencies returns the IDs of the jobs a job depends on
+func (qm *QueueManager) GetJobDependencies(ctx context.Context, jobID string) ([]string, error) {
+	deps, err := qm.client.SMembers(ctx, fmt.Sprintf("job_deps:%s", jobID)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get job dependencies: %w", err)
+	}
+	return deps, nil
+}
+
+// releaseIfReady moves a waiting job onto the main queue once all its
+// dependencies have completed, or fails it if one of them failed
+func (qm *QueueManager) releaseIfReady(ctx context.Context, jobID string) {
+	jobData, err := qm.client.HGet(ctx, WaitingJobsHash, jobID).Result()
+	if err != nil {
+		if err != redis.Nil {
+			qm.logger.Warn("Failed to load waiting job", zap.String("job_id", jobID), zap.Error(err))
+		}
+		return
+	}
+	
+	job, err := DecodeDownloadJob([]byte(jobData))
+	if err != nil {
+		qm.logger.Warn("Failed to decode waiting job", zap.String("job_id", jobID), zap.Error(err))
+		return
+	}
+	
+	switch state, depID := resolveDependencies(job.DependsOn, qm.dependencyStatus(ctx)); state {
+	case dependencyFailed:
+		qm.failWaitingJob(ctx, jobID, depID)
+		return
+	case dependenciesCompleted:
+	default:
+		return
+	}
+	
+	// Only the caller that actually removes the entry gets to enqueue it
+	removed, err := qm.client.HDel(ctx, WaitingJobsHash, jobID).Result()
+	if err != nil || removed == 0 {
+		return
+	}
+	
+	if err := qm.client.LPush(ctx, DownloadJobsQueue, jobData).Err(); err != nil {
+		qm.logger.Error("Failed to enqueue released job", zap.String("job_id", jobID), zap.Error(err))
+		return
+	}
+	
+	status := &JobStatus{
+		ID:        job.ID,
+		Status:    lifecycle.Queued,
+		CreatedAt: job.CreatedAt,
+	}
+	if err := qm.SetJobStatus(ctx, status); err != nil {
+		qm.logger.Warn("Failed to set released job status", zap.String("job_id", jobID), zap.Error(err))
+	}
+	
+	qm.logger.Info("Dependencies satisfied, job enqueued", zap.String("job_id", jobID))
+}
+
+// releaseDependents checks every job waiting on jobID and enqueues those that are now ready
+func (qm *QueueManager) releaseDependents(ctx context.Context, jobID string) {
+	dependentsKey := fmt.Sprintf("job_dependents:%s", jobID)
+	dependents, err := qm.client.SMembers(ctx, dependentsKey).Result()
+	if err != nil {
+		qm.logger.Warn("Failed to get dependent jobs", zap.String("job_id", jobID), zap.Error(err))
+		return
+	}
+	
+	for _, dependentID := range dependents {
+		qm.releaseIfReady(ctx, dependentID)
+	}
+	
+	qm.client.Del(ctx, dependentsKey)
+}
+
+// cascadeFailure fails every job that (transitively) depends on a failed job
+func (qm *QueueManager) cascadeFailure(ctx context.Context, jobID string) {
+	dependentsKey := fmt.Sprintf("job_dependents:%s", jobID)
+	dependents, err := qm.client.SMembers(ctx, dependentsKey).Result()
+	if err != nil {
+		qm.logger.Warn("Failed to get dependent jobs", zap.String("job_id", jobID), zap.Error(err))
+		return
+	}
+	qm.client.Del(ctx, dependentsKey)
+	
+	for _, dependentID := range dependents {
+		qm.failWaitingJob(ctx, dependentID, jobID)
+	}
+}
+
+// failWaitingJob fails a job parked in the waiting hash because its
+// dependency depID failed, then cascades to the jobs waiting on it
+func (qm *QueueManager) failWaitingJob(ctx context.Context, jobID string, depID string) {
+	jobData, err := qm.client.HGet(ctx, WaitingJobsHash, jobID).Result()
+	if err != nil {
+		return
+	}
+	
+	// Skip if another caller already released or failed this job
+	removed, err := qm.client.HDel(ctx, WaitingJobsHash, jobID).Result()
+	if err != nil || removed == 0 {
+		return
+	}
+	
+	var job DownloadJob
+	json.Unmarshal([]byte(jobData), &job)
+	
+	status := &JobStatus{
+		ID:           jobID,
+		Status:       lifecycle.Failed,
+		CreatedAt:    job.CreatedAt,
+		CompletedAt:  time.Now(),
+		ErrorMessage: fmt.Sprintf("dependency %s failed", depID),
+	}
+	if err := qm.SetJobStatus(ctx, status); err != nil {
+		qm.logger.Warn("Failed to set cascaded failure status", zap.String("job_id", jobID), zap.Error(err))
+	} else {
+		qm.archiveJob(ctx, FailedJobsQueue, &job, status)
+	}
+	
+	qm.logger.Warn("Job failed because a dependency failed", 
+		zap.String("job_id", jobID),
+		zap.String("dependency_id", depID))
+	
+	qm.cascadeFailure(ctx, jobID)
+}
+
+// stringsToInterfaces converts a string slice for variadic Redis commands
+func stringsToInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
 }
 
 // removeFromProcessingQueue removes a job from the processing queue by job ID
-func (qm *QueueManager) removeFromProcessingQueue(ctx context.Context, jobID string) error {
+// and returns it
+func (qm *QueueManager) removeFromProcessingQueue(ctx context.Context, jobID string) (*DownloadJob, error) {
 	// Get all jobs in processing queue
 	jobs, err := qm.client.LRange(ctx, ProcessingJobsQueue, 0, -1).Result()
 	if err != nil {
-		return fmt.Errorf("failed to get processing jobs: %w", err)
+		return nil, fmt.Errorf("failed to get processing jobs: %w", err)
 	}
 	
 	// Find and remove the job
@@ -339,69 +1566,609 @@ func (qm *QueueManager) removeFromProcessingQueue(ctx context.Context, jobID str
 		if job.ID == jobID {
 			// Remove this specific job
 			if err := qm.client.LRem(ctx, ProcessingJobsQueue, 1, jobData).Err(); err != nil {
-				return fmt.Errorf("failed to remove job from processing queue: %w", err)
+				return nil, fmt.Errorf("failed to remove job from processing queue: %w", err)
 			}
-			return nil
+			return &job, nil
 		}
 	}
 	
-	return fmt.Errorf("job not found in processing queue")
+	return nil, fmt.Errorf("job not found in processing queue")
+}
+
+// archiveJob records a finished job at the head of the completed or failed
+// queue and trims the queue to MaxArchivedJobs. job may be nil when the job
+// was no longer in the processing queue; the record then has only what the
+// status holds.
+func (qm *QueueManager) archiveJob(ctx context.Context, queue string, job *DownloadJob, status *JobStatus) {
+	record := ArchivedJob{
+		ID:              status.ID,
+		Status:          status.Status,
+		WorkerID:        status.WorkerID,
+		BytesDownloaded: status.BytesDownloaded,
+		ErrorMessage:    status.ErrorMessage,
+		CreatedAt:       status.CreatedAt,
+		StartedAt:       status.StartedAt,
+		CompletedAt:     status.CompletedAt,
+	}
+	if job != nil {
+		record.URL = job.URL
+		record.OutputPath = job.OutputPath
+		record.GroupID = job.GroupID
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = job.CreatedAt
+		}
+	}
+	
+	data, err := json.Marshal(record)
+	if err != nil {
+		qm.logger.Warn("Failed to marshal archived job", zap.String("job_id", status.ID), zap.Error(err))
+		return
+	}
+	_, err = qm.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, queue, data)
+		pipe.LTrim(ctx, queue, 0, MaxArchivedJobs-1)
+		return nil
+	})
+	if err != nil {
+		qm.logger.Warn("Failed to archive job",
+			zap.String("job_id", status.ID),
+			zap.String("queue", queue),
+			zap.Error(err))
+	}
 }
 
-// CleanupStaleJobs removes jobs that have been processing for too long
-func (qm *QueueManager) CleanupStaleJobs(ctx context.Context) error {
+// ListArchivedJobs returns up to limit jobs from the completed or failed
+// queue, the most recently finished first. Entries that cannot be decoded,
+// such as payloads archived before jobs were recorded this way, are skipped.
+func (qm *QueueManager) ListArchivedJobs(ctx context.Context, queue string, limit int) ([]*ArchivedJob, error) {
+	entries, err := qm.client.LRange(ctx, queue, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived jobs: %w", err)
+	}
+	
+	jobs := make([]*ArchivedJob, 0, len(entries))
+	for _, entry := range entries {
+		var job ArchivedJob
+		if err := json.Unmarshal([]byte(entry), &job); err != nil || job.Status == "" {
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// CleanupStaleJobs requeues jobs that have been processing for too long and
+// returns their IDs
+func (qm *QueueManager) CleanupStaleJobs(ctx context.Context) ([]string, error) {
 	jobs, err := qm.client.LRange(ctx, ProcessingJobsQueue, 0, -1).Result()
 	if err != nil {
-		return fmt.Errorf("failed to get processing jobs: %w", err)
+		return nil, fmt.Errorf("failed to get processing jobs: %w", err)
 	}
 	
-	staleCount := 0
+	var requeued []string
 	for _, jobData := range jobs {
 		var job DownloadJob
 		if err := json.Unmarshal([]byte(jobData), &job); err != nil {
 			continue
 		}
+		status, err := readJobStatus(ctx, qm.client, fmt.Sprintf("job_status:%s", job.ID))
+		if err != nil {
+			qm.logger.Warn("Failed to read status of processing job", zap.String("job_id", job.ID), zap.Error(err))
+			continue
+		}
+		
+		// A job that finished but could not leave the processing queue must
+		// not run again
+		if status != nil && status.Status.Terminal() {
+			qm.client.LRem(ctx, ProcessingJobsQueue, 1, jobData)
+			qm.logger.Info("Removed finished job from the processing queue", zap.String("job_id", job.ID))
+			continue
+		}
 		
 		// Check if job is stale
-		if time.Since(job.StartedAt) > JobProcessingTimeout {
-			// Move back to main queue for retry
-			if err := qm.client.LRem(ctx, ProcessingJobsQueue, 1, jobData).Err(); err != nil {
-				qm.logger.Warn("Failed to remove stale job", zap.String("job_id", job.ID), zap.Error(err))
-				continue
-			}
-			
-			// Reset job timing
-			job.StartedAt = time.Time{}
-			job.WorkerID = ""
-			
-			jobDataReset, err := json.Marshal(job)
-			if err != nil {
-				qm.logger.Warn("Failed to marshal reset job", zap.String("job_id", job.ID), zap.Error(err))
-				continue
-			}
-			
-			if err := qm.client.LPush(ctx, DownloadJobsQueue, jobDataReset).Err(); err != nil {
+		if processingAge(job, status, time.Now()) > qm.staleAfter {
+			if err := qm.requeue(ctx, jobData, job); err != nil {
 				qm.logger.Warn("Failed to requeue stale job", zap.String("job_id", job.ID), zap.Error(err))
 				continue
 			}
 			
-			// Update status back to queued
-			status := &JobStatus{
-				ID:        job.ID,
-				Status:    "queued",
-				CreatedAt: job.CreatedAt,
-			}
-			qm.SetJobStatus(ctx, status)
-			
-			staleCount++
+			requeued = append(requeued, job.ID)
 			qm.logger.Info("Requeued stale job", zap.String("job_id", job.ID))
 		}
 	}
 	
-	if staleCount > 0 {
-		qm.logger.Info("Cleaned up stale jobs", zap.Int("count", staleCount))
+	if len(requeued) > 0 {
+		qm.logger.Info("Cleaned up stale jobs", zap.Int("count", len(requeued)))
+	}
+	
+	return requeued, nil
+}
+
+// processingAge returns how long a processing job has been running. The
+// processing queue holds the job as it was enqueued, without its start, so
+// the start comes from its status, or its creation when that has none.
+func processingAge(job DownloadJob, status *JobStatus, now time.Time) time.Duration {
+	started := job.StartedAt
+	if status != nil && !status.StartedAt.IsZero() {
+		started = status.StartedAt
+	}
+	if started.IsZero() {
+		started = job.CreatedAt
+	}
+	return now.Sub(started)
+}
+
+// RequeueJob moves a job whose worker stopped from the processing queue back
+// to the main queue. It reports false if the job is not being processed.
+func (qm *QueueManager) RequeueJob(ctx context.Context, jobID string) (bool, error) {
+	jobs, err := qm.client.LRange(ctx, ProcessingJobsQueue, 0, -1).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to get processing jobs: %w", err)
+	}
+	
+	for _, jobData := range jobs {
+		var job DownloadJob
+		if err := json.Unmarshal([]byte(jobData), &job); err != nil || job.ID != jobID {
+			continue
+		}
+		if err := qm.requeue(ctx, jobData, job); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	
+	return false, nil
+}
+
+// ProcessingJob returns the job jobID as a worker took it, or nil when it
+// is not being processed
+func (qm *QueueManager) ProcessingJob(ctx context.Context, jobID string) (*DownloadJob, error) {
+	jobs, err := qm.client.LRange(ctx, ProcessingJobsQueue, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processing jobs: %w", err)
+	}
+	for _, jobData := range jobs {
+		var job DownloadJob
+		if err := json.Unmarshal([]byte(jobData), &job); err == nil && job.ID == jobID {
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+// requeue moves the processing job stored as jobData back to the main queue
+func (qm *QueueManager) requeue(ctx context.Context, jobData string, job DownloadJob) error {
+	// Only one caller may take the job off the processing queue
+	removed, err := qm.client.LRem(ctx, ProcessingJobsQueue, 1, jobData).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove job from processing queue: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("job %s already left the processing queue", job.ID)
+	}
+	
+	// Reset job timing
+	job.StartedAt = time.Time{}
+	job.WorkerID = ""
+	
+	// Resume on the node holding the partial file while it is around
+	job.Node = ""
+	queue := DownloadJobsQueue
+	if current, err := qm.GetJobStatus(ctx, job.ID); err == nil && current.Node != "" {
+		registered, err := qm.NodeRegistered(ctx, current.Node)
+		if err != nil {
+			qm.logger.Warn("Failed to look up job node", zap.String("job_id", job.ID), zap.Error(err))
+		}
+		if registered {
+			job.Node = current.Node
+			queue = nodeJobsQueue(current.Node)
+		}
+	}
+	
+	jobDataReset, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reset job: %w", err)
+	}
+	
+	if job.Node != "" {
+		if err := qm.client.SAdd(ctx, NodeJobsQueues, queue).Err(); err != nil {
+			return fmt.Errorf("failed to record node queue: %w", err)
+		}
+	}
+	if err := qm.client.LPush(ctx, queue, jobDataReset).Err(); err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	
+	// Update status back to queued, keeping the node for the next requeue
+	status := &JobStatus{
+		ID:        job.ID,
+		Status:    lifecycle.Queued,
+		CreatedAt: job.CreatedAt,
+		Node:      job.Node,
+	}
+	qm.SetJobStatus(ctx, status)
+	
+	if job.Node != "" {
+		qm.logger.Info("Job requeued for the node holding its partial file",
+			zap.String("job_id", job.ID),
+			zap.String("node", job.Node))
+	}
+	
+	return nil
+}
+
+// WorkerNode is the registration of a running worker process
+type WorkerNode struct {
+	ID        string    `json:"id"`
+	Hostname  string    `json:"hostname,omitempty"`
+	// Workers is how many jobs the node runs at once
+	Workers   int       `json:"workers"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// nodeJobsQueue returns the queue of jobs waiting for node
+func nodeJobsQueue(node string) string {
+	return NodeJobsQueuePrefix + node
+}
+
+// RegisterNode records that node is running, for NodeTTL. Nodes renew their
+// registration well within that.
+func (qm *QueueManager) RegisterNode(ctx context.Context, node WorkerNode) error {
+	node.LastSeen = time.Now()
+	data, err := json.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node: %w", err)
+	}
+	if err := qm.client.Set(ctx, NodeKeyPrefix+node.ID, data, NodeTTL).Err(); err != nil {
+		return fmt.Errorf("failed to register node: %w", err)
+	}
+	return nil
+}
+
+// UnregisterNode removes the registration of a node that is stopping, so
+// its jobs go elsewhere at once
+func (qm *QueueManager) UnregisterNode(ctx context.Context, id string) error {
+	if err := qm.client.Del(ctx, NodeKeyPrefix+id).Err(); err != nil {
+		return fmt.Errorf("failed to unregister node: %w", err)
+	}
+	return nil
+}
+
+// NodeRegistered reports whether the node named id is registered
+func (qm *QueueManager) NodeRegistered(ctx context.Context, id string) (bool, error) {
+	n, err := qm.client.Exists(ctx, NodeKeyPrefix+id).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up node: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListNodes returns the registered worker nodes
+func (qm *QueueManager) ListNodes(ctx context.Context) ([]WorkerNode, error) {
+	var keys []string
+	iter := qm.client.Scan(ctx, 0, NodeKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	
+	values, err := qm.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+	nodes := make([]WorkerNode, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired since the scan
+			continue
+		}
+		var node WorkerNode
+		if err := json.Unmarshal([]byte(data), &node); err != nil {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// redisPeerDirectory records the files worker nodes hold in Redis hashes
+type redisPeerDirectory struct {
+	qm *QueueManager
+}
+
+// PeerDirectory returns the directory worker nodes announce their files in
+func (qm *QueueManager) PeerDirectory() peer.Directory {
+	return &redisPeerDirectory{qm: qm}
+}
+
+// Announce records h under every id for peer.HoldingTTL
+func (d *redisPeerDirectory) Announce(ctx context.Context, ids []string, h peer.Holding) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("failed to marshal holding: %w", err)
+	}
+	_, err = d.qm.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.HSet(ctx, PeerKeyPrefix+id, h.Node, data)
+			pipe.Expire(ctx, PeerKeyPrefix+id, peer.HoldingTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to announce file: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the holdings recorded under id by nodes still registered;
+// those of departed nodes are dropped
+func (d *redisPeerDirectory) Lookup(ctx context.Context, id string) ([]peer.Holding, error) {
+	values, err := d.qm.client.HGetAll(ctx, PeerKeyPrefix+id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up file: %w", err)
+	}
+	var holdings []peer.Holding
+	for node, data := range values {
+		if registered, err := d.qm.NodeRegistered(ctx, node); err != nil {
+			return nil, err
+		} else if !registered {
+			d.qm.client.HDel(ctx, PeerKeyPrefix+id, node)
+			continue
+		}
+		var h peer.Holding
+		if err := json.Unmarshal([]byte(data), &h); err != nil {
+			continue
+		}
+		holdings = append(holdings, h)
+	}
+	return holdings, nil
+}
+
+// ReleaseNodeJobs moves the jobs waiting for nodes that are no longer
+// registered to the main queue, where any worker takes them: it resumes a
+// job if it can reach the partial file and downloads it again otherwise.
+// It returns how many jobs it moved.
+func (qm *QueueManager) ReleaseNodeJobs(ctx context.Context) (int, error) {
+	queues, err := qm.client.SMembers(ctx, NodeJobsQueues).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get node queues: %w", err)
+	}
+	
+	moved := 0
+	for _, queue := range queues {
+		node := strings.TrimPrefix(queue, NodeJobsQueuePrefix)
+		registered, err := qm.NodeRegistered(ctx, node)
+		if err != nil {
+			return moved, err
+		}
+		if registered {
+			continue
+		}
+		
+		// Oldest first, so the jobs keep their order
+		for {
+			err := qm.client.RPopLPush(ctx, queue, DownloadJobsQueue).Err()
+			if err == redis.Nil {
+				break
+			}
+			if err != nil {
+				return moved, fmt.Errorf("failed to release node job: %w", err)
+			}
+			moved++
+		}
+		qm.client.SRem(ctx, NodeJobsQueues, queue)
+		qm.logger.Info("Released the jobs of a node that is gone", zap.String("node", node))
+	}
+	return moved, nil
+}
+
+// StoreCookies replaces the shared cookie store used by all workers with
+// cookies.txt content, sealed by the caller
+func (qm *QueueManager) StoreCookies(ctx context.Context, sealed string) error {
+	if err := qm.client.Set(ctx, CookiesKey, sealed, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store cookies: %w", err)
+	}
+	return nil
+}
+
+// GetCookies returns the shared cookies.txt content as stored, sealed unless
+// it was imported before cookies were sealed, or an empty string if none was
+// imported
+func (qm *QueueManager) GetCookies(ctx context.Context) (string, error) {
+	content, err := qm.client.Get(ctx, CookiesKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get cookies: %w", err)
 	}
+	return content, nil
+}
+
+// ClearCookies removes every imported cookie
+func (qm *QueueManager) ClearCookies(ctx context.Context) error {
+	if err := qm.client.Del(ctx, CookiesKey).Err(); err != nil {
+		return fmt.Errorf("failed to clear cookies: %w", err)
+	}
+	return nil
+}
+
+// redisEventTransport bridges event buses over Redis pub/sub
+type redisEventTransport struct {
+	client *redis.Client
+}
+
+// EventTransport returns a transport that shares lifecycle events with every
+// node connected to the same Redis
+func (qm *QueueManager) EventTransport() events.Transport {
+	return &redisEventTransport{client: qm.client}
+}
+
+// Send publishes an encoded event on EventsChannel
+func (t *redisEventTransport) Send(ctx context.Context, data []byte) error {
+	if err := t.client.Publish(ctx, EventsChannel, data).Err(); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Receive delivers events published on EventsChannel until ctx is cancelled
+func (t *redisEventTransport) Receive(ctx context.Context, deliver func(data []byte)) error {
+	sub := t.client.Subscribe(ctx, EventsChannel)
+	defer sub.Close()
+	
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("event subscription closed")
+			}
+			deliver([]byte(msg.Payload))
+		}
+	}
+}
+
+// BridgeEvents shares lifecycle events between bus and every other node
+// connected to the same Redis until ctx is cancelled
+func (qm *QueueManager) BridgeEvents(ctx context.Context, bus *events.Bus) {
+	err := bus.Bridge(ctx, qm.EventTransport(), func(err error) {
+		qm.logger.Warn("Event bridge error", zap.Error(err))
+	})
+	if err != nil && ctx.Err() == nil {
+		qm.logger.Error("Event bridge stopped", zap.Error(err))
+	}
+}
+
+// DownloadControl changes the limits of a running job; nil fields keep their
+// value. RestartPart drops the connection of the part with that index.
+type DownloadControl struct {
+	JobID       string `json:"job_id"`
+	RateLimit   *int64 `json:"rate_limit,omitempty"`
+	Threads     *int   `json:"threads,omitempty"`
+	RestartPart *int   `json:"restart_part,omitempty"`
+}
+
+// PublishControl sends an adjustment to every worker; the one running the
+// job applies it
+func (qm *QueueManager) PublishControl(ctx context.Context, control DownloadControl) error {
+	data, err := json.Marshal(control)
+	if err != nil {
+		return fmt.Errorf("failed to marshal control message: %w", err)
+	}
+	if err := qm.client.Publish(ctx, ControlChannel, data).Err(); err != nil {
+		return fmt.Errorf("failed to publish control message: %w", err)
+	}
+	return nil
+}
+
+// SubscribeControl delivers adjustments published on ControlChannel until
+// ctx is cancelled
+func (qm *QueueManager) SubscribeControl(ctx context.Context, apply func(DownloadControl)) error {
+	sub := qm.client.Subscribe(ctx, ControlChannel)
+	defer sub.Close()
 	
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("control subscription closed")
+			}
+			var control DownloadControl
+			if err := json.Unmarshal([]byte(msg.Payload), &control); err != nil {
+				qm.logger.Warn("Ignoring malformed control message", zap.Error(err))
+				continue
+			}
+			apply(control)
+		}
+	}
+}
+
+// SaveParts records the part diagnostics of a running job for the parts route
+func (qm *QueueManager) SaveParts(ctx context.Context, parts openapi.DownloadParts) error {
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal parts: %w", err)
+	}
+	if err := qm.client.Set(ctx, PartsKeyPrefix+parts.DownloadID, data, PartsTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save parts: %w", err)
+	}
+	return nil
+}
+
+// GetParts returns the part diagnostics last reported for a job, or nil
+// when no worker is reporting them
+func (qm *QueueManager) GetParts(ctx context.Context, jobID string) (*openapi.DownloadParts, error) {
+	data, err := qm.client.Get(ctx, PartsKeyPrefix+jobID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parts: %w", err)
+	}
+	var parts openapi.DownloadParts
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return nil, fmt.Errorf("failed to decode parts: %w", err)
+	}
+	return &parts, nil
+}
+
+// acquireLeaseScript takes a lease that is free or extends one held by the caller
+var acquireLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseLeaseScript deletes a lease only if the caller still holds it
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisLeaderStore keeps leader leases in Redis
+type redisLeaderStore struct {
+	client *redis.Client
+}
+
+// LeaderStore returns the store replicas sharing this Redis elect their
+// maintenance leaders in
+func (qm *QueueManager) LeaderStore() leader.Store {
+	return &redisLeaderStore{client: qm.client}
+}
+
+// Acquire takes or renews the lease key for id
+func (s *redisLeaderStore) Acquire(ctx context.Context, key, id string, ttl time.Duration) (bool, error) {
+	held, err := acquireLeaseScript.Run(ctx, s.client, []string{LeaderKeyPrefix + key}, id, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire leader lease %s: %w", key, err)
+	}
+	return held == 1, nil
+}
+
+// Release gives up the lease key if id holds it
+func (s *redisLeaderStore) Release(ctx context.Context, key, id string) error {
+	if err := releaseLeaseScript.Run(ctx, s.client, []string{LeaderKeyPrefix + key}, id).Err(); err != nil {
+		return fmt.Errorf("failed to release leader lease %s: %w", key, err)
+	}
 	return nil
 }
 
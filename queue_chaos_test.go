@@ -0,0 +1,218 @@
+//go:build chaos
+// +build chaos
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"multithreaded-downloader/chaos"
+)
+
+// Run against a Redis database the test may empty, e.g.
+// CHAOS_REDIS_URL=redis://localhost:6379/15 go test -tags chaos -v queue.go queue_chaos_test.go
+
+const (
+	chaosJobs    = 40
+	chaosWorkers = 4
+	// chaosStaleAfter is far above how long a job runs, so only jobs of
+	// killed workers are requeued
+	chaosStaleAfter = 2 * time.Second
+)
+
+// chaosLedger counts what the simulated workers did to every job
+type chaosLedger struct {
+	mu        sync.Mutex
+	completed map[string]int
+	failed    map[string]int
+	kills     int
+}
+
+func (l *chaosLedger) record(counts map[string]int, jobID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counts[jobID]++
+}
+
+func (l *chaosLedger) finished() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.completed) + len(l.failed)
+}
+
+// TestChaosJobsRecoveredExactlyOnce runs jobs on workers that are killed in
+// the middle of them, talk to a slow Redis and find the disk full, and
+// checks that the stale-job cleanup brings every job of a killed worker
+// back and that every job ends exactly once
+func TestChaosJobsRecoveredExactlyOnce(t *testing.T) {
+	redisURL := os.Getenv("CHAOS_REDIS_URL")
+	if redisURL == "" {
+		t.Skip("CHAOS_REDIS_URL is not set")
+	}
+	qm, err := NewQueueManager(redisURL, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.client.Close()
+	ctx := context.Background()
+	if err := qm.client.FlushDB(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	qm.staleAfter = chaosStaleAfter
+	// Seeded from the clock: a fixed seed would kill the first job of every
+	// restarted worker alike
+	cfg := chaos.Config{KillRate: 0.2, KillAfter: 40 * time.Millisecond, RedisLatency: 2 * time.Millisecond, DiskFullRate: 0.01}
+	qm.client.AddHook(chaos.New(cfg).RedisHook())
+
+	for i := 0; i < chaosJobs; i++ {
+		job := &DownloadJob{ID: fmt.Sprintf("chaos-%02d", i), URL: "https://example.com/file.bin", OutputPath: "file.bin", Threads: 1}
+		if err := qm.EnqueueJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ledger := &chaosLedger{completed: map[string]int{}, failed: map[string]int{}}
+	runCtx, stop := context.WithTimeout(ctx, time.Minute)
+	defer stop()
+	var wg sync.WaitGroup
+	for i := 0; i < chaosWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			superviseChaosWorker(runCtx, t, qm, cfg, fmt.Sprintf("worker-%d", i), ledger)
+		}(i)
+	}
+
+	// The leader's cleanup, run far more often than in a deployment
+	cleanups := time.NewTicker(200 * time.Millisecond)
+	defer cleanups.Stop()
+	for ledger.finished() < chaosJobs {
+		select {
+		case <-runCtx.Done():
+			t.Fatalf("%d of %d jobs finished before the timeout", ledger.finished(), chaosJobs)
+		case <-cleanups.C:
+			if _, err := qm.CleanupStaleJobs(runCtx); err != nil {
+				t.Errorf("CleanupStaleJobs() = %v", err)
+			}
+		}
+	}
+	stop()
+	wg.Wait()
+
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	if ledger.kills == 0 {
+		t.Error("no worker was killed; the test proves nothing")
+	}
+	for i := 0; i < chaosJobs; i++ {
+		id := fmt.Sprintf("chaos-%02d", i)
+		if runs := ledger.completed[id] + ledger.failed[id]; runs != 1 {
+			t.Errorf("job %s ended %d times", id, runs)
+		}
+		status, err := qm.GetJobStatus(ctx, id)
+		if err != nil || !status.Status.Terminal() {
+			t.Errorf("job %s has status %v, %v", id, status, err)
+		}
+	}
+	for _, queue := range []string{DownloadJobsQueue, ProcessingJobsQueue} {
+		if n := qm.client.LLen(ctx, queue).Val(); n != 0 {
+			t.Errorf("%s still holds %d jobs", queue, n)
+		}
+	}
+	archived := map[string]int{}
+	for _, queue := range []string{CompletedJobsQueue, FailedJobsQueue} {
+		for _, data := range qm.client.LRange(ctx, queue, 0, -1).Val() {
+			var job ArchivedJob
+			json.Unmarshal([]byte(data), &job)
+			archived[job.ID]++
+		}
+	}
+	for id, n := range archived {
+		if n != 1 {
+			t.Errorf("job %s was archived %d times", id, n)
+		}
+	}
+	t.Logf("%d jobs: %d completed, %d failed on a full disk, %d worker kills recovered", chaosJobs, len(ledger.completed), len(ledger.failed), ledger.kills)
+}
+
+// superviseChaosWorker runs a worker process and starts a new one each
+// time it is killed, like a container restart policy
+func superviseChaosWorker(ctx context.Context, t *testing.T, qm *QueueManager, cfg chaos.Config, id string, ledger *chaosLedger) {
+	for ctx.Err() == nil {
+		process, kill := context.WithCancel(ctx)
+		monkey := chaos.New(cfg)
+		killed := make(chan struct{})
+		var once sync.Once
+		monkey.Exit = func(int) {
+			once.Do(func() {
+				close(killed)
+				kill()
+			})
+		}
+		runChaosWorker(process, t, qm, monkey, id, killed, ledger)
+		kill()
+		select {
+		case <-killed:
+			ledger.mu.Lock()
+			ledger.kills++
+			ledger.mu.Unlock()
+		default:
+		}
+	}
+}
+
+// runChaosWorker takes jobs until its process is killed. A job writes ten
+// chunks of its file; a kill stops it where it is, without a word to the
+// queue, and a full disk fails it.
+func runChaosWorker(ctx context.Context, t *testing.T, qm *QueueManager, monkey *chaos.Monkey, id string, killed <-chan struct{}, ledger *chaosLedger) {
+	for {
+		job, err := qm.DequeueJob(ctx, id, "")
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil || job == nil {
+			continue
+		}
+
+		done := monkey.Job(job.ID)
+		var writeErr error
+		for chunk := 0; chunk < 10 && writeErr == nil; chunk++ {
+			select {
+			case <-killed:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			writeErr = monkey.WriteFault(1 << 20)
+		}
+		done()
+		select {
+		case <-killed:
+			return
+		default:
+		}
+
+		if writeErr != nil {
+			if !errors.Is(writeErr, syscall.ENOSPC) {
+				t.Errorf("write failed with %v", writeErr)
+			}
+			if err := qm.FailJob(context.Background(), job.ID, id, writeErr.Error()); err != nil {
+				t.Errorf("FailJob(%s) = %v", job.ID, err)
+			}
+			ledger.record(ledger.failed, job.ID)
+			continue
+		}
+		if err := qm.CompleteJob(context.Background(), job.ID, id); err != nil {
+			t.Errorf("CompleteJob(%s) = %v", job.ID, err)
+		}
+		ledger.record(ledger.completed, job.ID)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+// FuzzDecodeDownloadJob runs against the queue files only, e.g.
+// go test -run '^$' -fuzz FuzzDecodeDownloadJob queue.go queue_fuzz_test.go
+func FuzzDecodeDownloadJob(f *testing.F) {
+	valid, err := json.Marshal(DownloadJob{
+		ID:         "job-1",
+		URL:        "http://example.com/file.zip",
+		OutputPath: "file.zip",
+		Threads:    4,
+		CreatedAt:  time.Now(),
+		DependsOn:  []string{"job-0"},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(valid)
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`{"id":"x","url":"u","output_path":"o","threads":0}`))
+	f.Add([]byte(`{"id":"x","url":"u","output_path":"o","threads":-3}`))
+	f.Add([]byte(`{"id":"x","url":"u","output_path":"o","threads":4,"created_at":"not a time"}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		job, err := DecodeDownloadJob(data)
+		if err != nil {
+			return
+		}
+
+		if job.ID == "" || job.URL == "" || job.OutputPath == "" {
+			t.Fatalf("decoded job with missing fields: %+v", job)
+		}
+		if job.Threads < 1 || job.Threads > MaxJobThreads {
+			t.Fatalf("decoded job with %d threads", job.Threads)
+		}
+
+		// A decoded job must survive being re-queued
+		encoded, err := json.Marshal(job)
+		if err != nil {
+			t.Fatalf("failed to re-encode job: %v", err)
+		}
+		if _, err := DecodeDownloadJob(encoded); err != nil {
+			t.Fatalf("re-encoded job no longer decodes: %v", err)
+		}
+	})
+}
+
+func FuzzDecodeJobStatus(f *testing.F) {
+	valid, err := json.Marshal(JobStatus{
+		ID:              "job-1",
+		Status:          lifecycle.Downloading,
+		Progress:        42.5,
+		BytesDownloaded: 425,
+		TotalBytes:      1000,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(valid)
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"id":"x","status":"queued","progress":-10,"bytes_downloaded":-1,"total_bytes":-1}`))
+	f.Add([]byte(`{"id":"x","status":"processing","progress":1e308,"bytes_downloaded":5000,"total_bytes":10}`))
+	f.Add([]byte(`{"id":"x","status":"running"}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		status, err := DecodeJobStatus(data)
+		if err != nil {
+			return
+		}
+
+		if status.ID == "" {
+			t.Fatalf("decoded status without a job id")
+		}
+		if status.Progress < 0 || status.Progress > 100 {
+			t.Fatalf("decoded status with %.2f%% progress", status.Progress)
+		}
+		if status.BytesDownloaded < 0 || status.TotalBytes < 0 {
+			t.Fatalf("decoded status with negative byte counts: %d/%d", status.BytesDownloaded, status.TotalBytes)
+		}
+		if !status.Status.Valid() {
+			t.Fatalf("decoded status with unknown status %q", status.Status)
+		}
+	})
+}
+
+// FuzzJobStatusHash checks that every status DecodeJobStatus accepts is
+// stored as hash fields that decode to the same status
+func FuzzJobStatusHash(f *testing.F) {
+	f.Add([]byte(`{"id":"x","status":"downloading","progress":42.5,"bytes_downloaded":425,"total_bytes":1000,"seq":1700000000000000001}`))
+	f.Add([]byte(`{"id":"x","status":"failed","error_message":"boom","created_at":"2023-12-07T10:30:00.123456789+01:00","completed_at":"2023-12-07T10:31:00Z"}`))
+	f.Add([]byte(`{"id":"x","status":"processing","throttled_by_server":true,"worker_id":"w1"}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		status, err := DecodeJobStatus(data)
+		if err != nil {
+			return
+		}
+
+		values := make(map[string]string)
+		for name, value := range status.fields() {
+			values[name] = value.(string)
+		}
+		decoded, err := decodeJobStatusHash(values)
+		if err != nil {
+			t.Fatalf("stored status no longer decodes: %v", err)
+		}
+
+		want, _ := json.Marshal(status)
+		got, _ := json.Marshal(decoded)
+		if string(got) != string(want) {
+			t.Fatalf("hash round trip changed the status:\n got %s\nwant %s", got, want)
+		}
+	})
+}
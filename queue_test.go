@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/resilience"
+)
+
+// Run against the queue files only, e.g.
+// go test queue.go queue_test.go
+
+func TestFindDependencyCycle(t *testing.T) {
+	graph := map[string][]string{
+		"a": {"b"},
+		"b": {"c", "d"},
+		"c": nil,
+		"d": {"c"},
+	}
+	deps := func(id string) ([]string, error) { return graph[id], nil }
+
+	tests := []struct {
+		name      string
+		jobID     string
+		dependsOn []string
+		cycle     bool
+	}{
+		{"no dependencies", "x", nil, false},
+		{"chain", "x", []string{"a"}, false},
+		{"shared dependency", "x", []string{"b", "d"}, false},
+		{"depends on itself", "x", []string{"x"}, true},
+		{"closes a loop", "c", []string{"a"}, true},
+		{"closes a short loop", "d", []string{"b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := findDependencyCycle(tt.jobID, tt.dependsOn, deps)
+			if got := errors.Is(err, ErrDependencyCycle); got != tt.cycle {
+				t.Errorf("findDependencyCycle(%s, %v) = %v, want cycle %v", tt.jobID, tt.dependsOn, err, tt.cycle)
+			}
+		})
+	}
+}
+
+func TestFindDependencyCycleLookupError(t *testing.T) {
+	lookupErr := errors.New("redis down")
+	err := findDependencyCycle("x", []string{"a"}, func(string) ([]string, error) { return nil, lookupErr })
+	if !errors.Is(err, lookupErr) {
+		t.Errorf("findDependencyCycle() = %v, want the lookup error", err)
+	}
+}
+
+func TestResolveDependencies(t *testing.T) {
+	statuses := map[string]lifecycle.Status{
+		"done":    lifecycle.Completed,
+		"done2":   lifecycle.Completed,
+		"current": lifecycle.NotModified,
+		"running": lifecycle.Downloading,
+		"queued":  lifecycle.Queued,
+		"broken":  lifecycle.Failed,
+	}
+	status := func(id string) (lifecycle.Status, bool) {
+		s, ok := statuses[id]
+		return s, ok
+	}
+
+	tests := []struct {
+		name      string
+		dependsOn []string
+		state     dependencyState
+		depID     string
+	}{
+		{"no dependencies", nil, dependenciesCompleted, ""},
+		{"all completed", []string{"done", "done2"}, dependenciesCompleted, ""},
+		{"not modified counts as completed", []string{"done", "current"}, dependenciesCompleted, ""},
+		{"one still running", []string{"done", "running"}, dependenciesPending, ""},
+		{"one queued", []string{"queued"}, dependenciesPending, ""},
+		{"failed after pending", []string{"running", "broken"}, dependencyFailed, "broken"},
+		{"failed after completed", []string{"done", "broken"}, dependencyFailed, "broken"},
+		{"missing", []string{"done", "gone"}, dependencyMissing, "gone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, depID := resolveDependencies(tt.dependsOn, status)
+			if state != tt.state || depID != tt.depID {
+				t.Errorf("resolveDependencies(%v) = %v, %q, want %v, %q", tt.dependsOn, state, depID, tt.state, tt.depID)
+			}
+		})
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{redis.Nil, false},
+		{errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{context.Canceled, false},
+		{io.EOF, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("failed to set completed status: %w", resilience.ErrOpen), true},
+	}
+	for _, tt := range tests {
+		if got := redisUnavailable(tt.err); got != tt.want {
+			t.Errorf("redisUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestProcessingAge(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	created := now.Add(-2 * time.Hour)
+	job := DownloadJob{ID: "a", CreatedAt: created}
+	tests := []struct {
+		name   string
+		status *JobStatus
+		want   time.Duration
+	}{
+		// The processing queue holds the job without its start
+		{"started by a worker", &JobStatus{StartedAt: now.Add(-time.Minute)}, time.Minute},
+		{"no status", nil, 2 * time.Hour},
+		{"status without a start", &JobStatus{}, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := processingAge(job, tt.status, now); got != tt.want {
+			t.Errorf("%s: processingAge() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
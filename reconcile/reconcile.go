@@ -0,0 +1,137 @@
+// Package reconcile matches the progress files left in a state directory
+// against the download records in the database when a server or worker
+// starts. Downloads can outlive the process running them: after a container
+// restart the output file and its progress file are still on the persistent
+// volume, but the database row may say the download is running, may be
+// missing, or may already be final. Plan decides for every download whether
+// to resume it, start it over, adopt it or throw its progress file away.
+package reconcile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/lifecycle"
+)
+
+// stateExt is the extension of progress files in a state directory
+const stateExt = ".json"
+
+// StatePath returns where the progress of download id is kept in dir
+func StatePath(dir, id string) string {
+	return filepath.Join(dir, id+stateExt)
+}
+
+// State is a progress file found in the state directory
+type State struct {
+	// ID is the download the file belongs to, taken from its name
+	ID   string
+	Path string
+	// Progress is the loaded file, or nil when Err says why it could not be
+	// loaded
+	Progress *downloader.Progress
+	Err      error
+}
+
+// ScanDir loads every progress file in dir. A missing directory holds no
+// files; files that cannot be loaded are returned with Err set.
+func ScanDir(dir string) ([]State, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, stateExt) {
+			continue
+		}
+		state := State{ID: strings.TrimSuffix(name, stateExt), Path: filepath.Join(dir, name)}
+		state.Progress, state.Err = downloader.LoadProgress(state.Path)
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Record is what Plan needs to know about a download's database row
+type Record struct {
+	ID     string
+	Status lifecycle.Status
+}
+
+// Action is what to do with a download after a restart
+type Action string
+
+const (
+	// Resume continues a running download from its progress file
+	Resume Action = "resume"
+	// Restart starts a running download over because its progress file is
+	// missing or unusable
+	Restart Action = "restart"
+	// Adopt recreates the record of a download only its progress file knows
+	// about, then resumes it
+	Adopt Action = "adopt"
+	// Discard removes a progress file nothing can resume from
+	Discard Action = "discard"
+)
+
+// Decision is the action for one download
+type Decision struct {
+	ID     string
+	Action Action
+	// State is the download's progress file, if there is one
+	State  *State
+	Reason string
+}
+
+// Plan decides what to do with each download that has a progress file in
+// states or a record marked downloading in records, ordered by download ID.
+// Paused and queued downloads with a progress file are left alone; they
+// resume from it when they are next started.
+func Plan(records []Record, states []State) []Decision {
+	byID := make(map[string]*State, len(states))
+	for i := range states {
+		byID[states[i].ID] = &states[i]
+	}
+
+	var decisions []Decision
+	seen := make(map[string]bool, len(records))
+	for _, record := range records {
+		seen[record.ID] = true
+		state := byID[record.ID]
+
+		switch {
+		case record.Status == lifecycle.Downloading && state != nil && state.Err == nil:
+			decisions = append(decisions, Decision{ID: record.ID, Action: Resume, State: state, Reason: "download was running"})
+		case record.Status == lifecycle.Downloading && state != nil:
+			decisions = append(decisions, Decision{ID: record.ID, Action: Restart, State: state, Reason: "progress file is unusable: " + state.Err.Error()})
+		case record.Status == lifecycle.Downloading:
+			decisions = append(decisions, Decision{ID: record.ID, Action: Restart, Reason: "progress file is missing"})
+		case record.Status.Terminal() && state != nil:
+			decisions = append(decisions, Decision{ID: record.ID, Action: Discard, State: state, Reason: "download is " + string(record.Status)})
+		}
+	}
+
+	for i := range states {
+		state := &states[i]
+		if seen[state.ID] {
+			continue
+		}
+		if state.Err != nil {
+			decisions = append(decisions, Decision{ID: state.ID, Action: Discard, State: state, Reason: "orphaned progress file is unusable: " + state.Err.Error()})
+			continue
+		}
+		decisions = append(decisions, Decision{ID: state.ID, Action: Adopt, State: state, Reason: "download has no record"})
+	}
+
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].ID < decisions[j].ID })
+	return decisions
+}
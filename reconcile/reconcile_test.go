@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/lifecycle"
+)
+
+func writeState(t *testing.T, dir, id string) {
+	t.Helper()
+	progress := downloader.CreateNewProgress("https://example.com/"+id, filepath.Join(dir, id+".bin"), 1000, 2)
+	if err := downloader.SaveProgress(StatePath(dir, id), progress); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanDir(t *testing.T) {
+	dir := t.TempDir()
+	writeState(t, dir, "d1")
+	if err := os.WriteFile(StatePath(dir, "broken"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := ScanDir(dir)
+	if err != nil {
+		t.Fatalf("ScanDir() error = %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("ScanDir() found %d files, want 2", len(states))
+	}
+	for _, state := range states {
+		switch state.ID {
+		case "d1":
+			if state.Err != nil || state.Progress.URL != "https://example.com/d1" {
+				t.Errorf("d1 = %+v", state)
+			}
+		case "broken":
+			if state.Err == nil {
+				t.Error("broken progress file loaded without error")
+			}
+		default:
+			t.Errorf("unexpected state %q", state.ID)
+		}
+	}
+
+	if states, err := ScanDir(filepath.Join(dir, "missing")); err != nil || states != nil {
+		t.Fatalf("ScanDir(missing) = %v, %v", states, err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{"running", "done", "orphan", "paused"} {
+		writeState(t, dir, id)
+	}
+	if err := os.WriteFile(StatePath(dir, "corrupt"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(StatePath(dir, "junk"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	states, err := ScanDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []Record{
+		{ID: "running", Status: lifecycle.Downloading},
+		{ID: "corrupt", Status: lifecycle.Downloading},
+		{ID: "lost", Status: lifecycle.Downloading},
+		{ID: "done", Status: lifecycle.Completed},
+		{ID: "paused", Status: lifecycle.Paused},
+		{ID: "failed", Status: lifecycle.Failed},
+	}
+	want := map[string]Action{
+		"corrupt": Restart,
+		"done":    Discard,
+		"junk":    Discard,
+		"lost":    Restart,
+		"orphan":  Adopt,
+		"running": Resume,
+	}
+
+	decisions := Plan(records, states)
+	if len(decisions) != len(want) {
+		t.Fatalf("Plan() = %+v, want %d decisions", decisions, len(want))
+	}
+	for i, d := range decisions {
+		if i > 0 && decisions[i-1].ID >= d.ID {
+			t.Errorf("decisions are not ordered by ID: %s after %s", d.ID, decisions[i-1].ID)
+		}
+		if d.Action != want[d.ID] {
+			t.Errorf("%s: action = %s, want %s (%s)", d.ID, d.Action, want[d.ID], d.Reason)
+		}
+		if d.Action == Adopt && (d.State == nil || d.State.Progress == nil) {
+			t.Errorf("%s: adopted without its progress", d.ID)
+		}
+	}
+}
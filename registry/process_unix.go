@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package registry
+
+import (
+	"os"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given ID exists
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Signal 0 checks the process without disturbing it; EPERM means it
+	// exists but belongs to another user
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// interruptProcess sends the process an interrupt, which the CLI handles by
+// saving its progress and exiting
+func interruptProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(os.Interrupt)
+}
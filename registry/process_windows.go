@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package registry
+
+import "os"
+
+// processAlive reports whether a process with the given ID exists; on
+// Windows finding a process opens it, which fails once it has exited
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
+
+// interruptProcess stops the process. Windows cannot deliver an interrupt
+// to another console, so the download is killed and its last saved
+// progress is kept.
+func interruptProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
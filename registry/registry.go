@@ -0,0 +1,343 @@
+// Package registry keeps a record of the downloads started from the command
+// line, so they can be listed, resumed, cancelled and cleaned up by ID
+// instead of by repeating their URL and output. Each job is one JSON file in
+// the registry directory, next to the progress file of its download.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+// ErrNotFound is returned when no job has the given ID
+var ErrNotFound = errors.New("job not found")
+
+// ErrAmbiguous is returned when an ID prefix matches more than one job
+var ErrAmbiguous = errors.New("job ID is ambiguous")
+
+// ShortIDLength is how many characters of an ID are shown in listings
+const ShortIDLength = 8
+
+// jobSuffix and progressSuffix name the files of a job in the directory
+const (
+	jobSuffix      = ".json"
+	progressSuffix = ".state.json"
+)
+
+// Options are the command-line settings a job is started and resumed with.
+// Files are referred to by path so that keys and cookies are never copied
+// into the registry.
+type Options struct {
+	Threads         int    `json:"threads"`
+	MinPartSize     int64  `json:"min_part_size"`
+	Sequential      bool   `json:"sequential,omitempty"`
+	PreviewBytes    int64  `json:"preview_bytes,omitempty"`
+	ChecksumRetries int    `json:"checksum_retries"`
+	UserAgent       string `json:"user_agent,omitempty"`
+	Referer         string `json:"referer,omitempty"`
+	CookiesFile     string `json:"cookies_file,omitempty"`
+	KeyFile         string `json:"key_file,omitempty"`
+	Method          string `json:"method,omitempty"`
+	Body            []byte `json:"body,omitempty"`
+	BodyType        string `json:"body_type,omitempty"`
+	// NoNetworkWait fails the download when the network goes away instead
+	// of waiting for it; AllowMetered keeps downloading on metered networks
+	NoNetworkWait bool `json:"no_network_wait,omitempty"`
+	AllowMetered  bool `json:"allow_metered,omitempty"`
+	// RulesFile is the domain rules file the download was started with,
+	// empty for the default one; ThreadsDefault marks Threads as a default
+	// that a matching rule replaces
+	RulesFile      string `json:"rules_file,omitempty"`
+	ThreadsDefault bool   `json:"threads_default,omitempty"`
+	// RefreshCommand or RefreshURL give a new link when the server refuses
+	// the current one as expired
+	RefreshCommand string `json:"refresh_command,omitempty"`
+	RefreshURL     string `json:"refresh_url,omitempty"`
+	// PartURLs are the pieces joined into the output, in order, for a
+	// file published split into several URLs
+	PartURLs []string `json:"part_urls,omitempty"`
+	// CacheDir is the cache of finished downloads the file is linked from
+	// or added to, holding up to CacheSize bytes
+	CacheDir  string `json:"cache_dir,omitempty"`
+	CacheSize int64  `json:"cache_size,omitempty"`
+	CacheCopy bool   `json:"cache_copy,omitempty"`
+	// OnlyIfModified skips the download when the existing output is the
+	// current version of the remote file
+	OnlyIfModified bool `json:"only_if_modified,omitempty"`
+	// Mirrors are other URLs of the file, spread over by their speed up
+	// to MirrorCount at a time
+	Mirrors     []string `json:"mirrors,omitempty"`
+	MirrorCount int      `json:"mirror_count,omitempty"`
+	// Torrent is the torrent file or URL whose piece hashes check the file
+	Torrent string `json:"torrent,omitempty"`
+	// IPFS is the ipfs:// URL whose CID checks the file, which was fetched
+	// from its gateways
+	IPFS string `json:"ipfs,omitempty"`
+	// ExpectedSize and ExpectedSHA256 check a file whose size and hash
+	// were listed before it was downloaded, as LFS objects are
+	ExpectedSize   int64  `json:"expected_size,omitempty"`
+	ExpectedSHA256 string `json:"expected_sha256,omitempty"`
+	// OCI is the image reference a blob was pulled for, whose registry
+	// authorizes the resume again
+	OCI string `json:"oci,omitempty"`
+	// Bind is the interface or address the download connects from,
+	// sharing BindRateLimit with the others bound to it
+	Bind          string `json:"bind,omitempty"`
+	BindRateLimit int64  `json:"bind_rate_limit,omitempty"`
+	// DoneMarker writes <output>.done once the file is verified
+	DoneMarker bool `json:"done_marker,omitempty"`
+	// PlanFile is the part plan the download was split by
+	PlanFile string `json:"plan_file,omitempty"`
+	// CaptureEnv writes <output>.environment.json
+	CaptureEnv bool `json:"capture_env,omitempty"`
+}
+
+// Job is a download started from the command line
+type Job struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Output is the absolute path of the downloaded file
+	Output       string           `json:"output"`
+	ProgressFile string           `json:"progress_file"`
+	Status       lifecycle.Status `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	// PID is the process downloading the job while it is Downloading or
+	// WaitingNetwork
+	PID     int     `json:"pid,omitempty"`
+	Options Options `json:"options"`
+	// Agent marks jobs submitted to the agent, which runs them while it
+	// is up and takes back the ones it was running when it stopped
+	Agent     bool      `json:"agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ShortID returns the start of the ID shown in listings
+func (j *Job) ShortID() string {
+	if len(j.ID) > ShortIDLength {
+		return j.ID[:ShortIDLength]
+	}
+	return j.ID
+}
+
+// Running reports whether a process is still downloading the job, or
+// waiting for the network to continue it
+func (j *Job) Running() bool {
+	active := j.Status == lifecycle.Downloading || j.Status == lifecycle.WaitingNetwork
+	return active && j.PID > 0 && processAlive(j.PID)
+}
+
+// State returns the job's status, reporting a download whose process died
+// without recording how it ended as Paused
+func (j *Job) State() lifecycle.Status {
+	if (j.Status == lifecycle.Downloading || j.Status == lifecycle.WaitingNetwork) && !j.Running() {
+		return lifecycle.Paused
+	}
+	return j.Status
+}
+
+// Interrupt asks the process downloading the job to stop and save its
+// progress
+func (j *Job) Interrupt() error {
+	if !j.Running() {
+		return nil
+	}
+	return interruptProcess(j.PID)
+}
+
+// Registry is a directory of job files
+type Registry struct {
+	dir string
+}
+
+// DefaultDir returns the registry directory: $MTDL_HOME/jobs when set and
+// ~/.mtdl/jobs otherwise
+func DefaultDir() (string, error) {
+	if home := os.Getenv("MTDL_HOME"); home != "" {
+		return filepath.Join(home, "jobs"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".mtdl", "jobs"), nil
+}
+
+// Open opens the registry in dir, creating the directory if needed. Jobs may
+// hold request bodies, so the directory is private to the user.
+func Open(dir string) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	return &Registry{dir: dir}, nil
+}
+
+// Dir returns the registry directory
+func (r *Registry) Dir() string {
+	return r.dir
+}
+
+// New returns a job for downloading url to output with a new ID and its own
+// progress file. The job is not saved until Save is called.
+func (r *Registry) New(url, output string, opts Options) (*Job, error) {
+	abs, err := filepath.Abs(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output path: %w", err)
+	}
+	id := uuid.New().String()
+	now := time.Now()
+	return &Job{
+		ID:           id,
+		URL:          url,
+		Output:       abs,
+		ProgressFile: filepath.Join(r.dir, id+progressSuffix),
+		Status:       lifecycle.Downloading,
+		Options:      opts,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+// Save writes the job, replacing the file atomically so a concurrent List
+// never reads half of it
+func (r *Registry) Save(job *Job) error {
+	job.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	path := r.jobFile(job.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+// Get returns the job whose ID is id or starts with it
+func (r *Registry) Get(id string) (*Job, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	if strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if job, err := r.load(r.jobFile(id)); err == nil {
+		return job, nil
+	}
+
+	jobs, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	var found *Job
+	for _, job := range jobs {
+		if !strings.HasPrefix(job.ID, id) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%w: %s matches %s and %s", ErrAmbiguous, id, found.ShortID(), job.ShortID())
+		}
+		found = job
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return found, nil
+}
+
+// Find returns the latest job downloading url to output that has not
+// finished, or nil if there is none
+func (r *Registry) Find(url, output string) (*Job, error) {
+	abs, err := filepath.Abs(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output path: %w", err)
+	}
+	jobs, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		if job.URL == url && job.Output == abs && !job.Status.Terminal() {
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns every job, oldest first. Files that cannot be read are
+// skipped so one damaged job does not hide the others.
+func (r *Registry) List() ([]*Job, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	var jobs []*Job
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, jobSuffix) || strings.HasSuffix(name, progressSuffix) {
+			continue
+		}
+		job, err := r.load(filepath.Join(r.dir, name))
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// Remove deletes the job and its progress file. The downloaded file is left
+// alone.
+func (r *Registry) Remove(job *Job) error {
+	if err := os.Remove(job.ProgressFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove progress file: %w", err)
+	}
+	if err := os.Remove(r.jobFile(job.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove job: %w", err)
+	}
+	return nil
+}
+
+// jobFile returns the path of a job's file
+func (r *Registry) jobFile(id string) string {
+	return filepath.Join(r.dir, id+jobSuffix)
+}
+
+// load reads a job file
+func (r *Registry) load(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	if job.ID == "" {
+		return nil, fmt.Errorf("job in %s has no ID", path)
+	}
+	return &job, nil
+}
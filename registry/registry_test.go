@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"multithreaded-downloader/lifecycle"
+)
+
+func openTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r, err := Open(filepath.Join(t.TempDir(), "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func newSavedJob(t *testing.T, r *Registry, url, output string) *Job {
+	t.Helper()
+	job, err := r.New(url, output, Options{Threads: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestSaveAndGet(t *testing.T) {
+	r := openTestRegistry(t)
+	job, err := r.New("https://example.com/a.zip", "a.zip", Options{Threads: 8, Method: "POST", Body: []byte("id=7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(job.Output) || filepath.Dir(job.ProgressFile) != r.Dir() {
+		t.Fatalf("New() output %q, progress file %q", job.Output, job.ProgressFile)
+	}
+	if err := r.Save(job); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{job.ID, job.ShortID(), job.ID[:3]} {
+		got, err := r.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", id, err)
+		}
+		if got.ID != job.ID || got.Options.Threads != 8 || string(got.Options.Body) != "id=7" {
+			t.Errorf("Get(%q) = %+v", id, got)
+		}
+	}
+	if _, err := r.Get("not-a-job"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(unknown) error = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Get("../" + job.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(path) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetAmbiguousPrefix(t *testing.T) {
+	r := openTestRegistry(t)
+	for _, id := range []string{"abc123", "abd456"} {
+		if err := r.Save(&Job{ID: id, Status: lifecycle.Paused}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := r.Get("ab"); !errors.Is(err, ErrAmbiguous) {
+		t.Errorf("Get(ab) error = %v, want ErrAmbiguous", err)
+	}
+	if job, err := r.Get("abd"); err != nil || job.ID != "abd456" {
+		t.Errorf("Get(abd) = %v, %v", job, err)
+	}
+}
+
+func TestFindSkipsFinishedJobs(t *testing.T) {
+	r := openTestRegistry(t)
+	done := newSavedJob(t, r, "https://example.com/a", "a")
+	done.Status = lifecycle.Completed
+	if err := r.Save(done); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := r.Find("https://example.com/a", "a"); err != nil || job != nil {
+		t.Fatalf("Find() = %v, %v; want no job", job, err)
+	}
+
+	paused := newSavedJob(t, r, "https://example.com/a", "a")
+	paused.Status = lifecycle.Paused
+	if err := r.Save(paused); err != nil {
+		t.Fatal(err)
+	}
+	job, err := r.Find("https://example.com/a", "a")
+	if err != nil || job == nil || job.ID != paused.ID {
+		t.Fatalf("Find() = %v, %v; want the paused job", job, err)
+	}
+	if job, _ := r.Find("https://example.com/a", "b"); job != nil {
+		t.Errorf("Find() matched a different output: %+v", job)
+	}
+}
+
+func TestListAndRemove(t *testing.T) {
+	r := openTestRegistry(t)
+	first := newSavedJob(t, r, "https://example.com/a", "a")
+	second := newSavedJob(t, r, "https://example.com/b", "b")
+	if err := os.WriteFile(first.ProgressFile, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Damaged files and progress files are not jobs
+	if err := os.WriteFile(filepath.Join(r.Dir(), "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != first.ID || jobs[1].ID != second.ID {
+		t.Fatalf("List() = %d jobs, want the two saved in order", len(jobs))
+	}
+
+	if err := r.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first.ProgressFile); !os.IsNotExist(err) {
+		t.Errorf("progress file kept after Remove: %v", err)
+	}
+	if jobs, _ := r.List(); len(jobs) != 1 || jobs[0].ID != second.ID {
+		t.Errorf("List() after Remove = %d jobs", len(jobs))
+	}
+}
+
+func TestStateOfAbandonedJob(t *testing.T) {
+	job := &Job{Status: lifecycle.Downloading, PID: os.Getpid()}
+	if !job.Running() || job.State() != lifecycle.Downloading {
+		t.Errorf("job of this process: Running() = %v, State() = %s", job.Running(), job.State())
+	}
+
+	// A download whose process is gone can be resumed
+	job.PID = 0
+	if job.Running() || job.State() != lifecycle.Paused {
+		t.Errorf("abandoned job: Running() = %v, State() = %s", job.Running(), job.State())
+	}
+
+	// A process waiting for the network still owns its download
+	job = &Job{Status: lifecycle.WaitingNetwork, PID: os.Getpid()}
+	if !job.Running() || job.State() != lifecycle.WaitingNetwork {
+		t.Errorf("job waiting for the network: Running() = %v, State() = %s", job.Running(), job.State())
+	}
+	job.PID = 0
+	if job.Running() || job.State() != lifecycle.Paused {
+		t.Errorf("abandoned job waiting for the network: Running() = %v, State() = %s", job.Running(), job.State())
+	}
+}
@@ -0,0 +1,335 @@
+// Package relay re-serves downloads to other machines while they are still
+// downloading, as a caching relay for a LAN. The first request for a URL
+// starts downloading it into the relay's directory; that request and every
+// later one, from any client, is answered from the download as its parts
+// are verified, waiting for the bytes still to come. A large file is then
+// pulled from the internet once however many machines want it.
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+// FetchPath is where files are relayed, given their URL as the url
+// parameter: /fetch?url=https://example.com/file.iso
+const FetchPath = "/fetch"
+
+// PollInterval is how often a request waiting for bytes looks again
+const PollInterval = 100 * time.Millisecond
+
+// Options configure a Server
+type Options struct {
+	// Allow lists the hosts whose files are relayed: a host name, a name
+	// starting with a dot for its subdomains, or * for any host
+	Allow []string
+	// Threads is the thread count of downloads NewDownloader does not set
+	Threads int
+	// NewDownloader, when set, creates the download of url into output,
+	// e.g. with domain rules applied; it defaults to a plain downloader
+	NewDownloader func(url, output string) (*downloader.Downloader, error)
+	// Logf, when set, reports downloads starting and ending
+	Logf func(format string, args ...interface{})
+}
+
+// Server relays the files of the allowed hosts from its directory
+type Server struct {
+	dir  string
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	downloads map[string]*download
+}
+
+// download is a file the relay fetches or fetched
+type download struct {
+	dl     *downloader.Downloader
+	output string
+	// ready is closed once the file's size is known or starting failed;
+	// done once the download ended, with err
+	ready chan struct{}
+	done  chan struct{}
+	err   error
+	// size and digests describe the file from ready on
+	size    int64
+	digests []downloader.Digest
+}
+
+// New returns a Server keeping its downloads in dir
+func New(dir string, opts Options) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{dir: dir, opts: opts, ctx: ctx, cancel: cancel, downloads: make(map[string]*download)}
+}
+
+// Close stops the running downloads with their progress saved, so the
+// next relay in the same directory resumes them
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Allowed reports whether the files of host are relayed
+func (s *Server) Allowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allow := range s.opts.Allow {
+		allow = strings.ToLower(allow)
+		if allow == "*" || allow == host || (strings.HasPrefix(allow, ".") && strings.HasSuffix(host, allow)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Output returns where the file at rawURL is kept: under a directory named
+// after the URL, with the name it has there
+func (s *Server) Output(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:8]), downloader.FilenameFromURL(rawURL))
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf(format, args...)
+	}
+}
+
+// ServeHTTP relays the file the url parameter names. The response has the
+// file's full size from the start; its bytes are written as they are
+// verified, and a request for a range is answered the same way.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != FetchPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rawURL := r.URL.Query().Get("url")
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	if !s.Allowed(parsed.Hostname()) {
+		http.Error(w, fmt.Sprintf("files of %s are not relayed", parsed.Hostname()), http.StatusForbidden)
+		return
+	}
+
+	d, err := s.start(r.Context(), rawURL)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	file, err := os.Open(d.output)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// The type is not sniffed, which would wait for the first bytes
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": downloader.FilenameFromURL(rawURL)}))
+	// Clients check the whole file as the origin's checksums describe it
+	for _, digest := range d.digests {
+		w.Header().Add("Repr-Digest", fmt.Sprintf("%s=:%s:", digest.Algorithm, base64.StdEncoding.EncodeToString(digest.Value)))
+	}
+	// The file changes as it downloads, so validators would only turn
+	// range requests into full responses
+	r = r.Clone(r.Context())
+	r.Header.Del("If-Range")
+	http.ServeContent(w, r, "", time.Time{}, &reader{ctx: r.Context(), d: d, file: file})
+}
+
+// start returns the download of rawURL once its size is known, starting it
+// unless it runs or finished. A download that failed is started again.
+func (s *Server) start(ctx context.Context, rawURL string) (*download, error) {
+	s.mu.Lock()
+	d, ok := s.downloads[rawURL]
+	if ok {
+		select {
+		case <-d.done:
+			ok = d.err == nil
+		default:
+		}
+	}
+	if !ok {
+		d = &download{output: s.Output(rawURL), ready: make(chan struct{}), done: make(chan struct{})}
+		s.downloads[rawURL] = d
+		s.wg.Add(1)
+		go s.run(rawURL, d)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-d.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case <-d.done:
+		if d.err != nil {
+			return nil, d.err
+		}
+	default:
+	}
+	return d, nil
+}
+
+// run downloads the file of d in order, so the start of the file is
+// verified first, and skips it when the copy the relay kept is current
+func (s *Server) run(rawURL string, d *download) {
+	defer s.wg.Done()
+	defer close(d.done)
+	started := false
+	defer func() {
+		if !started {
+			close(d.ready)
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(d.output), 0755); err != nil {
+		d.err = err
+		return
+	}
+	var dl *downloader.Downloader
+	var err error
+	if s.opts.NewDownloader != nil {
+		dl, err = s.opts.NewDownloader(rawURL, d.output)
+	} else {
+		dl = downloader.NewDownloader(rawURL, d.output, s.opts.Threads)
+	}
+	if err != nil {
+		d.err = err
+		return
+	}
+	dl.ProgressFile = d.output + ".progress"
+	dl.Sequential = true
+	dl.OnlyIfModified = true
+
+	err = dl.CheckModified(s.ctx)
+	if errors.Is(err, downloader.ErrNotModified) {
+		stat, err := os.Stat(d.output)
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.size = stat.Size()
+		s.logf("relaying %s from its kept copy", rawURL)
+		return
+	}
+	if err == nil {
+		err = dl.LoadOrCreateProgress()
+	}
+	if err != nil {
+		d.err = err
+		s.logf("failed to start %s: %v", rawURL, err)
+		return
+	}
+	d.dl = dl
+	d.size = dl.Progress.TotalSize
+	d.digests = append(d.digests, dl.Progress.Digests...)
+	started = true
+	close(d.ready)
+
+	s.logf("downloading %s (%d bytes) into %s", rawURL, d.size, d.output)
+	err = dl.DownloadContext(s.ctx)
+	if err == nil {
+		err = dl.VerifyDownload()
+	}
+	d.err = err
+	if err != nil {
+		s.logf("download of %s failed: %v", rawURL, err)
+	} else {
+		s.logf("downloaded %s", rawURL)
+	}
+}
+
+// verifiedFrom returns how many bytes from offset on can be served: all of
+// a finished download, and the verified parts of a running one
+func (d *download) verifiedFrom(offset int64) (int64, error) {
+	select {
+	case <-d.done:
+		if d.err != nil {
+			return 0, fmt.Errorf("the download failed: %w", d.err)
+		}
+		return d.size - offset, nil
+	default:
+	}
+	return d.dl.VerifiedFrom(offset)
+}
+
+// reader reads a download as its bytes are verified, waiting for those
+// that are not yet
+type reader struct {
+	ctx    context.Context
+	d      *download
+	file   *os.File
+	offset int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.d.size {
+		return 0, io.EOF
+	}
+	for {
+		n, err := r.d.verifiedFrom(r.offset)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			if int64(len(p)) > n {
+				p = p[:n]
+			}
+			read, err := r.file.ReadAt(p, r.offset)
+			r.offset += int64(read)
+			if err == io.EOF && read > 0 {
+				err = nil
+			}
+			return read, err
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-r.d.done:
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.d.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	r.offset = offset
+	return offset, nil
+}
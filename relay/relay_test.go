@@ -0,0 +1,124 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowReader serves content in small, delayed reads, so relay clients
+// arrive while the download runs
+type slowReader struct {
+	*bytes.Reader
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	return r.Reader.Read(p)
+}
+
+func TestRelayServesWhileDownloading(t *testing.T) {
+	payload := make([]byte, 3<<20+12345)
+	for i := range payload {
+		payload[i] = byte(i*7 + i/1000)
+	}
+	sum := sha256.Sum256(payload)
+	modified := time.Now().Add(-time.Hour)
+	var served int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		counter := &countingWriter{ResponseWriter: w, n: &served}
+		http.ServeContent(counter, r, "disk.img", modified, slowReader{bytes.NewReader(payload)})
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	relay := New(dir, Options{Allow: []string{"127.0.0.1"}, Threads: 3})
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	fetch := server.URL + FetchPath + "?url=" + url.QueryEscape(origin.URL+"/disk.img")
+
+	// Two machines ask at once, one for the whole file, one for a range
+	var wg sync.WaitGroup
+	var whole, ranged []byte
+	var header http.Header
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		resp, err := http.Get(fetch)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		header = resp.Header
+		whole, _ = io.ReadAll(resp.Body)
+	}()
+	go func() {
+		defer wg.Done()
+		req, _ := http.NewRequest(http.MethodGet, fetch, nil)
+		req.Header.Set("Range", "bytes=2000000-2000099")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Errorf("range request answered %s", resp.Status)
+		}
+		ranged, _ = io.ReadAll(resp.Body)
+	}()
+	wg.Wait()
+	relay.Close()
+
+	if !bytes.Equal(whole, payload) || !bytes.Equal(ranged, payload[2000000:2000100]) {
+		t.Fatalf("relayed %d and %d bytes that do not match the file", len(whole), len(ranged))
+	}
+	if header.Get("Repr-Digest") == "" || header.Get("Content-Disposition") != `attachment; filename=disk.img` {
+		t.Errorf("relay headers = %v", header)
+	}
+	if n := atomic.LoadInt64(&served); n > int64(len(payload))+1024 {
+		t.Errorf("origin served %d bytes of a %d byte file", n, len(payload))
+	}
+
+	// A relay started again serves the kept copy once the origin says it
+	// is current
+	before := atomic.LoadInt64(&served)
+	relay = New(dir, Options{Allow: []string{".0.0.1"}, Threads: 3})
+	defer relay.Close()
+	rec := httptest.NewRecorder()
+	relay.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetch[len(server.URL):], nil))
+	if !bytes.Equal(rec.Body.Bytes(), payload) || atomic.LoadInt64(&served) != before {
+		t.Errorf("kept copy: %d bytes relayed, origin served %d more", rec.Body.Len(), atomic.LoadInt64(&served)-before)
+	}
+
+	rec = httptest.NewRecorder()
+	relay.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, FetchPath+"?url="+url.QueryEscape("https://example.com/a.iso"), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("host not allowed: got %d, want 403", rec.Code)
+	}
+}
+
+// countingWriter counts the body bytes written
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,314 @@
+// Package resilience keeps short database and Redis outages from turning
+// into failed downloads. Retry repeats a write with exponential backoff, a
+// Breaker stops hammering a backend that keeps failing so callers fail fast
+// until it recovers, and an Outbox holds writes made during an outage and
+// replays them in order once the backend answers again.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned instead of calling a backend whose breaker is open
+var ErrOpen = errors.New("backend unavailable: circuit breaker open")
+
+// Breaker opens after Threshold consecutive failures and fails every call
+// with ErrOpen for Cooldown. Then it lets one trial call through: success
+// closes it, failure opens it for another Cooldown.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	// OnChange is told when the breaker opens or closes
+	OnChange func(name string, open bool)
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+// NewBreaker creates a closed breaker for the backend name
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow returns ErrOpen while the breaker is open. After the cooldown it
+// admits a single trial call and keeps refusing the others until the trial
+// reports back.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	if b.trial || b.now().Sub(b.openedAt) < b.cooldown {
+		return ErrOpen
+	}
+	b.trial = true
+	return nil
+}
+
+// Record reports the outcome of a call Allow admitted; err is nil for
+// success and for errors that show the backend is up, such as a missing key
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	changed, open := false, b.open
+	if err == nil {
+		b.failures = 0
+		b.trial = false
+		if b.open {
+			b.open, changed, open = false, true, false
+		}
+	} else {
+		b.failures++
+		b.trial = false
+		if b.open || b.failures >= b.threshold {
+			// A failed trial starts another cooldown
+			changed = !b.open
+			b.open, open = true, true
+			b.openedAt = b.now()
+		}
+	}
+	onChange := b.OnChange
+	b.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(b.name, open)
+	}
+}
+
+// Open reports whether calls are currently refused
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// Policy sets how often and how patiently Retry repeats a call
+type Policy struct {
+	// Attempts is the most calls made, the first one included
+	Attempts int
+	// Initial is the delay before the first retry; it doubles up to Max
+	Initial time.Duration
+	Max     time.Duration
+	// Transient reports whether an error means the backend could not be
+	// reached, so trying again may help; other errors are returned at once
+	// and do not count against the breaker. Nil treats every error as
+	// transient.
+	Transient func(error) bool
+}
+
+func (p Policy) transient(err error) bool {
+	return err != nil && (p.Transient == nil || p.Transient(err))
+}
+
+// delay returns the wait before retry n (from 1), with up to 50% jitter so
+// replicas that failed together do not retry together
+func (p Policy) delay(n int) time.Duration {
+	d := p.Initial
+	for i := 1; i < n && (p.Max <= 0 || d < p.Max); i++ {
+		d *= 2
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Retry calls op until it succeeds, fails with an error that is not
+// transient, runs out of attempts or ctx ends. A non-nil breaker is asked
+// before every call and told every outcome; while it is open Retry returns
+// ErrOpen without calling op.
+func Retry(ctx context.Context, p Policy, b *Breaker, op func() error) error {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(p.delay(attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		if b != nil {
+			if openErr := b.Allow(); openErr != nil {
+				if err == nil {
+					err = openErr
+				}
+				return err
+			}
+		}
+		err = op()
+		transient := p.transient(err)
+		if b != nil {
+			if transient {
+				b.Record(err)
+			} else {
+				b.Record(nil)
+			}
+		}
+		if !transient {
+			return err
+		}
+	}
+	return err
+}
+
+// outboxEntry is one buffered write
+type outboxEntry struct {
+	key string
+	op  func(context.Context) error
+	// seq changes whenever the entry is written, so Flush can tell whether
+	// the write it replayed was superseded meanwhile
+	seq uint64
+}
+
+// Outbox buffers writes that failed because their backend was unavailable
+// and replays them in the order they were made. Writes with the same
+// non-empty key supersede each other: the newest replaces the buffered one
+// in its place, so an outage of any length buffers one progress update per
+// download.
+type Outbox struct {
+	limit     int
+	transient func(error) bool
+
+	// OnDrop is told about writes refused because the outbox is full
+	OnDrop func(key string)
+
+	mu      sync.Mutex
+	pending []outboxEntry
+	seq     uint64
+	// flushing serializes Flush so replays never overtake each other
+	flushing sync.Mutex
+}
+
+// NewOutbox creates an outbox holding at most limit writes; transient
+// decides which errors are buffered rather than returned
+func NewOutbox(limit int, transient func(error) bool) *Outbox {
+	return &Outbox{limit: limit, transient: transient}
+}
+
+// Do runs op now, or buffers it. It buffers op behind earlier writes that
+// are still waiting, so writes stay in order, and buffers it when it fails
+// with a transient error. Other errors are returned.
+func (o *Outbox) Do(ctx context.Context, key string, op func(context.Context) error) error {
+	if o.Len() == 0 {
+		err := op(ctx)
+		if err == nil || (o.transient != nil && !o.transient(err)) {
+			return err
+		}
+	}
+	o.add(key, op)
+	return nil
+}
+
+func (o *Outbox) add(key string, op func(context.Context) error) {
+	o.mu.Lock()
+	o.seq++
+	if key != "" {
+		for i := range o.pending {
+			if o.pending[i].key == key {
+				o.pending[i].op = op
+				o.pending[i].seq = o.seq
+				o.mu.Unlock()
+				return
+			}
+		}
+	}
+	if o.limit > 0 && len(o.pending) >= o.limit {
+		onDrop := o.OnDrop
+		o.mu.Unlock()
+		if onDrop != nil {
+			onDrop(key)
+		}
+		return
+	}
+	o.pending = append(o.pending, outboxEntry{key: key, op: op, seq: o.seq})
+	o.mu.Unlock()
+}
+
+// Len returns how many writes are waiting
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// Flush replays the buffered writes in order and returns how many
+// succeeded. It stops at the first transient failure, leaving that write
+// and the later ones for the next flush; writes failing for other reasons
+// are dropped, as they would have been without the outage.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+	o.flushing.Lock()
+	defer o.flushing.Unlock()
+
+	flushed := 0
+	for {
+		o.mu.Lock()
+		if len(o.pending) == 0 {
+			o.mu.Unlock()
+			return flushed, nil
+		}
+		entry := o.pending[0]
+		o.mu.Unlock()
+
+		err := entry.op(ctx)
+		if err != nil && (o.transient == nil || o.transient(err)) {
+			return flushed, err
+		}
+
+		o.mu.Lock()
+		// A newer write with the same key may have replaced the entry
+		// while it ran; keep that one
+		if len(o.pending) > 0 && o.pending[0].seq == entry.seq {
+			o.pending = o.pending[1:]
+		}
+		o.mu.Unlock()
+		if err == nil {
+			flushed++
+		}
+	}
+}
+
+// Run flushes every interval until ctx ends, then flushes once more
+func (o *Outbox) Run(ctx context.Context, interval time.Duration, onFlush func(flushed int, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushed, err := o.Flush(context.Background())
+			if onFlush != nil && (flushed > 0 || err != nil) {
+				onFlush(flushed, err)
+			}
+			return
+		case <-ticker.C:
+			if o.Len() == 0 {
+				continue
+			}
+			flushed, err := o.Flush(ctx)
+			if onFlush != nil {
+				onFlush(flushed, err)
+			}
+		}
+	}
+}
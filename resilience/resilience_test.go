@@ -0,0 +1,157 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("connection refused")
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBreaker("db", 2, time.Minute)
+	b.now = func() time.Time { return now }
+	var changes []bool
+	b.OnChange = func(_ string, open bool) { changes = append(changes, open) }
+
+	b.Record(errDown)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after one failure = %v", err)
+	}
+	b.Record(errDown)
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("Allow() after threshold = %v, want ErrOpen", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after cooldown = %v, want a trial", err)
+	}
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("second Allow() during the trial = %v, want ErrOpen", err)
+	}
+	b.Record(errDown)
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("Allow() after a failed trial = %v, want ErrOpen", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after the second cooldown = %v", err)
+	}
+	b.Record(nil)
+	if b.Open() {
+		t.Fatal("breaker still open after a successful trial")
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("OnChange saw %v, want [true false]", changes)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	permanent := errors.New("duplicate key")
+	policy := Policy{
+		Attempts:  3,
+		Initial:   time.Millisecond,
+		Max:       2 * time.Millisecond,
+		Transient: func(err error) bool { return err != permanent },
+	}
+
+	calls := 0
+	err := Retry(context.Background(), policy, nil, func() error {
+		calls++
+		if calls < 3 {
+			return errDown
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Retry() = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	b := NewBreaker("db", 1, time.Hour)
+	err = Retry(context.Background(), policy, b, func() error { calls++; return permanent })
+	if err != permanent || calls != 1 || b.Open() {
+		t.Errorf("Retry() = %v after %d calls, open %v; want the permanent error at once", err, calls, b.Open())
+	}
+
+	calls = 0
+	err = Retry(context.Background(), policy, b, func() error { calls++; return errDown })
+	if err != errDown || calls != 1 || !b.Open() {
+		t.Errorf("Retry() = %v after %d calls, open %v; want the breaker to stop it after 1", err, calls, b.Open())
+	}
+	err = Retry(context.Background(), policy, b, func() error { calls++; return nil })
+	if err != ErrOpen || calls != 1 {
+		t.Errorf("Retry() on an open breaker = %v after %d calls, want ErrOpen without calling", err, calls)
+	}
+}
+
+func TestOutboxBuffersAndFlushesInOrder(t *testing.T) {
+	down := true
+	var written []string
+	write := func(value string) func(context.Context) error {
+		return func(context.Context) error {
+			if down {
+				return errDown
+			}
+			written = append(written, value)
+			return nil
+		}
+	}
+	o := NewOutbox(10, func(err error) bool { return err == errDown })
+	ctx := context.Background()
+
+	for _, w := range []struct{ key, value string }{
+		{"a", "a:10%"}, {"b", "b:5%"}, {"a", "a:20%"}, {"", "log"},
+	} {
+		if err := o.Do(ctx, w.key, write(w.value)); err != nil {
+			t.Fatalf("Do(%s) = %v, want it buffered", w.value, err)
+		}
+	}
+	if o.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3 after coalescing", o.Len())
+	}
+
+	if n, err := o.Flush(ctx); n != 0 || err != errDown || o.Len() != 3 {
+		t.Fatalf("Flush() while down = %d, %v with %d left", n, err, o.Len())
+	}
+
+	down = false
+	// Written behind the buffered writes, not before them
+	if err := o.Do(ctx, "c", write("c:1%")); err != nil || len(written) != 0 {
+		t.Fatalf("Do() with writes pending = %v, wrote %v", err, written)
+	}
+	if n, err := o.Flush(ctx); n != 4 || err != nil {
+		t.Fatalf("Flush() = %d, %v", n, err)
+	}
+	want := []string{"a:20%", "b:5%", "log", "c:1%"}
+	if len(written) != len(want) {
+		t.Fatalf("wrote %v, want %v", written, want)
+	}
+	for i := range want {
+		if written[i] != want[i] {
+			t.Fatalf("wrote %v, want %v", written, want)
+		}
+	}
+
+	if err := o.Do(ctx, "d", func(context.Context) error { return errors.New("constraint") }); err == nil || o.Len() != 0 {
+		t.Errorf("Do() with a permanent error = %v, buffered %d", err, o.Len())
+	}
+}
+
+func TestOutboxLimit(t *testing.T) {
+	o := NewOutbox(1, nil)
+	var dropped []string
+	o.OnDrop = func(key string) { dropped = append(dropped, key) }
+	fail := func(context.Context) error { return errDown }
+
+	o.Do(context.Background(), "a", fail)
+	o.Do(context.Background(), "a", fail)
+	o.Do(context.Background(), "b", fail)
+	if o.Len() != 1 || len(dropped) != 1 || dropped[0] != "b" {
+		t.Errorf("Len() = %d, dropped %v; want 1 and [b]", o.Len(), dropped)
+	}
+}
@@ -0,0 +1,361 @@
+// Package robots reads a site's robots.txt so that link crawling stays off
+// the paths the site asks crawlers to leave alone and spaces its requests
+// by the site's Crawl-delay. Rules are matched as RFC 9309 describes: the
+// group of the crawler's product token, or else the * group, and the longest
+// matching Allow or Disallow pattern, with Allow winning a tie.
+package robots
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxCrawlDelay caps the Crawl-delay a robots.txt may ask for
+	MaxCrawlDelay = time.Minute
+	// maxSize is how much of a robots.txt is read; RFC 9309 asks for at least 500 KiB
+	maxSize = 512 * 1024
+)
+
+// ErrUnreachable is returned when a robots.txt cannot be fetched because of
+// a network error or a server error; the site is then treated as
+// disallowing everything
+var ErrUnreachable = errors.New("robots.txt unreachable")
+
+// rule is an Allow or Disallow line
+type rule struct {
+	pattern string
+	allow   bool
+}
+
+// Rules are the lines of a robots.txt that apply to one crawler
+type Rules struct {
+	rules []rule
+	// CrawlDelay is how long to wait between requests, 0 if not set
+	CrawlDelay time.Duration
+	// disallowAll is set for a site whose robots.txt could not be fetched
+	disallowAll bool
+}
+
+// group is a run of user-agent lines and the lines that follow them
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+// Parse reads a robots.txt and returns the rules for the crawler sending
+// userAgent. Lines it does not understand are skipped.
+func Parse(r io.Reader, userAgent string) *Rules {
+	var groups []*group
+	var current *group
+	// A user-agent line after other lines starts a new group
+	inAgents := false
+	scanner := bufio.NewScanner(io.LimitReader(r, maxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := cut(line, ':')
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			// An empty Disallow allows everything, like no line at all
+			if current != nil && value != "" {
+				current.rules = append(current.rules, rule{pattern: value, allow: key == "allow"})
+			}
+		case "crawl-delay":
+			inAgents = false
+			if seconds, err := strconv.ParseFloat(value, 64); current != nil && err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+				if current.crawlDelay > MaxCrawlDelay {
+					current.crawlDelay = MaxCrawlDelay
+				}
+			}
+		default:
+			// Sitemap and other lines do not end a run of user-agent lines
+		}
+	}
+
+	// The groups naming the crawler are merged; the * groups apply only
+	// when none does
+	token := ProductToken(userAgent)
+	rules, fallback := &Rules{}, &Rules{}
+	matched := false
+	for _, g := range groups {
+		var target *Rules
+		for _, agent := range g.agents {
+			if agent == token {
+				target, matched = rules, true
+				break
+			}
+			if agent == "*" {
+				target = fallback
+			}
+		}
+		if target == nil {
+			continue
+		}
+		target.rules = append(target.rules, g.rules...)
+		if g.crawlDelay > target.CrawlDelay {
+			target.CrawlDelay = g.crawlDelay
+		}
+	}
+	if !matched {
+		return fallback
+	}
+	return rules
+}
+
+// cut splits s around the first sep
+func cut(s string, sep byte) (string, string, bool) {
+	if i := strings.IndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// ProductToken returns the lowercased name a robots.txt knows a crawler by,
+// the part of its User-Agent before the version: go-downloader for
+// Go-Downloader/1.0
+func ProductToken(userAgent string) string {
+	token := strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return strings.ToLower(token)
+}
+
+// Allowed reports whether the rules let the crawler fetch the path and
+// query of u
+func (r *Rules) Allowed(u *url.URL) bool {
+	if r.disallowAll {
+		return false
+	}
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	best, allowed := -1, true
+	for _, rl := range r.rules {
+		if !match(rl.pattern, target) {
+			continue
+		}
+		if n := len(rl.pattern); n > best || (n == best && rl.allow) {
+			best, allowed = n, rl.allow
+		}
+	}
+	return allowed
+}
+
+// match reports whether a robots.txt path pattern matches the start of
+// target; * matches any run of characters and a trailing $ the end
+func match(pattern, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(target, parts[0]) {
+		return false
+	}
+	rest := target[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// Fetch gets the robots.txt of the site serving u and returns its rules for
+// userAgent. A missing robots.txt, or any other 4xx answer, allows
+// everything; a network or server error returns ErrUnreachable with rules
+// that allow nothing.
+func Fetch(ctx context.Context, client *http.Client, u *url.URL, userAgent string) (*Rules, error) {
+	robotsURL := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return &Rules{disallowAll: true}, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return Parse(resp.Body, userAgent), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &Rules{}, nil
+	}
+	return &Rules{disallowAll: true}, fmt.Errorf("%w: %s answered %s", ErrUnreachable, robotsURL, resp.Status)
+}
+
+// Checker fetches the robots.txt of every site a crawl visits once and
+// spaces the requests to each site by its Crawl-delay. It is safe for
+// concurrent use.
+type Checker struct {
+	// UserAgent is what the crawler sends and finds its rules by
+	UserAgent string
+	// IgnoreRules allows every path but keeps to the Crawl-delay, for
+	// crawls the site agreed to
+	IgnoreRules bool
+	// MinDelay spaces requests to a site that sets no Crawl-delay
+	MinDelay time.Duration
+	// Client fetches robots.txt files
+	Client *http.Client
+
+	mu    sync.Mutex
+	sites map[string]*site
+}
+
+// site is what a Checker knows about one scheme and host
+type site struct {
+	ready chan struct{}
+	rules *Rules
+	err   error
+	// next is the earliest time of the next request
+	next time.Time
+}
+
+// NewChecker creates a checker for a crawler sending userAgent
+func NewChecker(userAgent string) *Checker {
+	return &Checker{
+		UserAgent: userAgent,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		sites:     make(map[string]*site),
+	}
+}
+
+// rules returns the rules of the site serving u, fetching its robots.txt
+// the first time
+func (c *Checker) rules(ctx context.Context, u *url.URL) (*site, error) {
+	key := strings.ToLower(u.Scheme + "://" + u.Host)
+	c.mu.Lock()
+	s, ok := c.sites[key]
+	if !ok {
+		s = &site{ready: make(chan struct{})}
+		c.sites[key] = s
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		s.rules, s.err = Fetch(ctx, c.Client, u, c.UserAgent)
+		if s.rules == nil {
+			s.rules = &Rules{disallowAll: true}
+		}
+		close(s.ready)
+	}
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Allowed reports whether the crawler may fetch rawURL. The error says why
+// the site's robots.txt could not be read, in which case nothing on the
+// site is allowed.
+func (c *Checker) Allowed(ctx context.Context, rawURL string) (bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, err
+	}
+	if c.IgnoreRules {
+		return true, nil
+	}
+	s, err := c.rules(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	return s.rules.Allowed(u), s.err
+}
+
+// Delay returns the time to leave between requests to the site serving
+// rawURL
+func (c *Checker) Delay(ctx context.Context, rawURL string) time.Duration {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return c.MinDelay
+	}
+	s, err := c.rules(ctx, u)
+	if err != nil {
+		return c.MinDelay
+	}
+	return c.delay(s)
+}
+
+func (c *Checker) delay(s *site) time.Duration {
+	if s.rules.CrawlDelay < c.MinDelay {
+		return c.MinDelay
+	}
+	return s.rules.CrawlDelay
+}
+
+// Wait blocks until a request to the site serving rawURL keeps to its
+// Crawl-delay, and counts the request as made
+func (c *Checker) Wait(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	s, err := c.rules(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	s.next = at.Add(c.delay(s))
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
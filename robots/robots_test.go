@@ -0,0 +1,124 @@
+package robots
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const robotsTxt = `# Example
+User-agent: *
+Disallow: /private/
+Disallow: /*.tmp$
+Allow: /private/public/
+Crawl-delay: 0.5
+
+User-agent: BadBot
+User-agent: go-downloader
+Disallow: /archive
+Allow: /archive/open
+Crawl-delay: 2
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestParse(t *testing.T) {
+	others := Parse(strings.NewReader(robotsTxt), "Mozilla/5.0 (X11; Linux x86_64)")
+	ours := Parse(strings.NewReader(robotsTxt), "Go-Downloader/1.0")
+	if others.CrawlDelay != 500*time.Millisecond || ours.CrawlDelay != 2*time.Second {
+		t.Errorf("crawl delays %v and %v", others.CrawlDelay, ours.CrawlDelay)
+	}
+
+	for _, tc := range []struct {
+		rules *Rules
+		path  string
+		want  bool
+	}{
+		{others, "/", true},
+		{others, "/private/key.pem", false},
+		{others, "/private/public/logo.png", true},
+		{others, "/build/out.tmp", false},
+		{others, "/build/out.tmp.zip", true},
+		{others, "/archive/2020.zip", true},
+		// The product token's group replaces the * group
+		{ours, "/private/key.pem", true},
+		{ours, "/archive/2020.zip", false},
+		{ours, "/archive/open/2020.zip", true},
+		{ours, "/archive?page=2", false},
+	} {
+		u, _ := url.Parse("https://example.com" + tc.path)
+		if got := tc.rules.Allowed(u); got != tc.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+
+	if ProductToken("Go-Downloader/1.0") != "go-downloader" || ProductToken("curl/8.7.1") != "curl" {
+		t.Error("ProductToken() did not strip the version")
+	}
+}
+
+func TestFetchStatuses(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			t.Errorf("fetched %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/page.html")
+
+	rules, err := Fetch(context.Background(), server.Client(), u, "Go-Downloader/1.0")
+	if err != nil || !rules.Allowed(u) {
+		t.Errorf("Fetch() of a missing robots.txt = %v, %v", rules.Allowed(u), err)
+	}
+	status = http.StatusServiceUnavailable
+	rules, err = Fetch(context.Background(), server.Client(), u, "Go-Downloader/1.0")
+	if !errors.Is(err, ErrUnreachable) || rules.Allowed(u) {
+		t.Errorf("Fetch() of a failing robots.txt = %v, %v", rules.Allowed(u), err)
+	}
+}
+
+func TestCheckerCachesAndWaits(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte("User-agent: *\nDisallow: /private/\nCrawl-delay: 0.05\n"))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewChecker("Go-Downloader/1.0")
+	if ok, err := c.Allowed(ctx, server.URL+"/private/a.zip"); ok || err != nil {
+		t.Errorf("Allowed(/private/a.zip) = %v, %v", ok, err)
+	}
+	if ok, err := c.Allowed(ctx, server.URL+"/a.zip"); !ok || err != nil {
+		t.Errorf("Allowed(/a.zip) = %v, %v", ok, err)
+	}
+	if fetches != 1 {
+		t.Errorf("robots.txt fetched %d times", fetches)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := c.Wait(ctx, server.URL+"/a.zip"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("three requests with a 50ms crawl delay took %v", elapsed)
+	}
+
+	c.IgnoreRules = true
+	if ok, _ := c.Allowed(ctx, server.URL+"/private/a.zip"); !ok {
+		t.Error("IgnoreRules did not allow a disallowed path")
+	}
+	if c.Delay(ctx, server.URL) != 50*time.Millisecond {
+		t.Errorf("Delay() with IgnoreRules = %v", c.Delay(ctx, server.URL))
+	}
+}
@@ -0,0 +1,364 @@
+// Package sandbox serves generated files for the sandbox mode of the
+// servers and workers. In sandbox mode every download is fetched from a
+// local payload generator instead of its URL: the URL's path names the
+// file and its query may shape how it is served, so frontends and
+// orchestrations can be developed and load-tested without real bandwidth.
+//
+// A file's bytes depend only on its path and size, so resumed and
+// repeated downloads see the same content. The profile sets the size, how
+// fast each response is sent, how long it waits before answering, and
+// which share of requests fail or are cut off.
+package sandbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-downloader/downloader"
+)
+
+const (
+	// DefaultSize is the size of files whose profile sets none
+	DefaultSize = 100 << 20
+	// MaxSize bounds the files served
+	MaxSize = 1 << 40
+)
+
+// Profile shapes how the generated files are served
+type Profile struct {
+	// Size of every file in bytes
+	Size int64
+	// Speed limits every response to bytes per second; 0 is unlimited
+	Speed int64
+	// Latency passes before every response starts
+	Latency time.Duration
+	// ErrorRate is the share of requests answered 503 Service
+	// Unavailable with a Retry-After of one second
+	ErrorRate float64
+	// ResetRate is the share of responses whose connection is dropped
+	// partway through the body
+	ResetRate float64
+	// NoRanges makes the generator ignore Range, so files are fetched in
+	// one request
+	NoRanges bool
+	// Status, when set, answers every request with it, e.g. 404 or 403
+	Status int
+}
+
+// ProfileFromEnv reads the default profile from SANDBOX_SIZE,
+// SANDBOX_SPEED, SANDBOX_LATENCY, SANDBOX_ERROR_RATE and
+// SANDBOX_RESET_RATE. Sizes and speeds are bytes, optionally with a k, m
+// or g suffix for KiB, MiB or GiB.
+func ProfileFromEnv() (Profile, error) {
+	values := url.Values{}
+	for param, env := range map[string]string{
+		"size":       "SANDBOX_SIZE",
+		"speed":      "SANDBOX_SPEED",
+		"latency":    "SANDBOX_LATENCY",
+		"error_rate": "SANDBOX_ERROR_RATE",
+		"reset_rate": "SANDBOX_RESET_RATE",
+	} {
+		if raw := os.Getenv(env); raw != "" {
+			values.Set(param, raw)
+		}
+	}
+	return Profile{Size: DefaultSize}.With(values)
+}
+
+// With returns p changed by the query parameters size, speed, latency,
+// error_rate, reset_rate, ranges and status of a download URL
+func (p Profile) With(query url.Values) (Profile, error) {
+	var err error
+	if raw := query.Get("size"); raw != "" {
+		if p.Size, err = parseBytes(raw); err != nil || p.Size > MaxSize {
+			return p, fmt.Errorf("size must be a number of bytes up to %d, got %q", int64(MaxSize), raw)
+		}
+	}
+	if raw := query.Get("speed"); raw != "" {
+		if p.Speed, err = parseBytes(raw); err != nil {
+			return p, fmt.Errorf("speed must be a number of bytes per second, got %q", raw)
+		}
+	}
+	if raw := query.Get("latency"); raw != "" {
+		if p.Latency, err = time.ParseDuration(raw); err != nil || p.Latency < 0 || p.Latency > time.Minute {
+			return p, fmt.Errorf("latency must be a duration up to 1m, got %q", raw)
+		}
+	}
+	if raw := query.Get("error_rate"); raw != "" {
+		if p.ErrorRate, err = parseRate(raw); err != nil {
+			return p, fmt.Errorf("error_rate %w, got %q", err, raw)
+		}
+	}
+	if raw := query.Get("reset_rate"); raw != "" {
+		if p.ResetRate, err = parseRate(raw); err != nil {
+			return p, fmt.Errorf("reset_rate %w, got %q", err, raw)
+		}
+	}
+	if raw := query.Get("ranges"); raw != "" {
+		ranges, err := strconv.ParseBool(raw)
+		if err != nil {
+			return p, fmt.Errorf("ranges must be true or false, got %q", raw)
+		}
+		p.NoRanges = !ranges
+	}
+	if raw := query.Get("status"); raw != "" {
+		if p.Status, err = strconv.Atoi(raw); err != nil || p.Status < 200 || p.Status > 599 {
+			return p, fmt.Errorf("status must be an HTTP status code, got %q", raw)
+		}
+	}
+	return p, nil
+}
+
+// parseBytes reads a count of bytes with an optional k, m or g suffix
+func parseBytes(raw string) (int64, error) {
+	multiplier := int64(1)
+	switch strings.ToLower(raw[len(raw)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		raw = raw[:len(raw)-1]
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 || n > MaxSize/multiplier {
+		return 0, errors.New("invalid number of bytes")
+	}
+	return n * multiplier, nil
+}
+
+// parseRate reads a share from 0 to 1
+func parseRate(raw string) (float64, error) {
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, errors.New("must be a number from 0 to 1")
+	}
+	return rate, nil
+}
+
+// Payload is the content of a generated file
+type Payload struct {
+	seed [sha256.Size]byte
+	Size int64
+}
+
+// NewPayload returns the content of the file at path with size bytes
+func NewPayload(path string, size int64) *Payload {
+	return &Payload{seed: sha256.Sum256([]byte(fmt.Sprintf("%d:%s", size, path))), Size: size}
+}
+
+// ETag identifies the payload's content
+func (p *Payload) ETag() string {
+	return `"sandbox-` + hex.EncodeToString(p.seed[:8]) + `"`
+}
+
+// ReadAt fills b with the bytes at off. Every 32-byte block of the file is
+// the SHA-256 of the seed and the block's index.
+func (p *Payload) ReadAt(b []byte, off int64) (int, error) {
+	if off >= p.Size {
+		return 0, io.EOF
+	}
+	n := len(b)
+	if rest := p.Size - off; int64(n) > rest {
+		n = int(rest)
+	}
+	var input [sha256.Size + 8]byte
+	copy(input[:], p.seed[:])
+	for written := 0; written < n; {
+		pos := off + int64(written)
+		binary.BigEndian.PutUint64(input[sha256.Size:], uint64(pos/sha256.Size))
+		block := sha256.Sum256(input[:])
+		written += copy(b[written:n], block[pos%sha256.Size:])
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Handler serves the generated file at every path, shaped by profile and
+// the request's query
+func Handler(profile Profile) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := profile.With(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if p.Latency > 0 {
+			select {
+			case <-time.After(p.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if p.Status != 0 && p.Status != http.StatusOK {
+			http.Error(w, http.StatusText(p.Status), p.Status)
+			return
+		}
+		if p.ErrorRate > 0 && rand.Float64() < p.ErrorRate {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "sandbox: simulated outage", http.StatusServiceUnavailable)
+			return
+		}
+
+		payload := NewPayload(r.URL.Path, p.Size)
+		var body io.Writer = w
+		if p.Speed > 0 {
+			body = &throttledWriter{w: body, limiter: downloader.NewRateLimiter(p.Speed), ctx: r.Context()}
+		}
+		if p.ResetRate > 0 && rand.Float64() < p.ResetRate {
+			// Drop the connection within the first MiB or half of the file
+			limit := p.Size / 2
+			if limit > 1<<20 {
+				limit = 1 << 20
+			}
+			flusher, _ := w.(http.Flusher)
+			body = &resettingWriter{w: body, flusher: flusher, left: rand.Int63n(limit + 1)}
+		}
+		out := w
+		if body != io.Writer(w) {
+			out = &bodyWriter{ResponseWriter: w, body: body}
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if p.NoRanges {
+			w.Header().Set("Content-Length", strconv.FormatInt(p.Size, 10))
+			if r.Method != http.MethodHead {
+				io.Copy(out, io.NewSectionReader(payload, 0, p.Size))
+			}
+			return
+		}
+		w.Header().Set("ETag", payload.ETag())
+		http.ServeContent(out, r, "", time.Time{}, io.NewSectionReader(payload, 0, p.Size))
+	})
+}
+
+// bodyWriter is a ResponseWriter whose body goes through body
+type bodyWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// throttledWriter writes at the rate of limiter
+type throttledWriter struct {
+	w       io.Writer
+	limiter *downloader.RateLimiter
+	ctx     context.Context
+}
+
+func (t *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > 16<<10 {
+			chunk = chunk[:16<<10]
+		}
+		if err := t.limiter.Wait(t.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// resettingWriter drops the connection after left more bytes
+type resettingWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	left    int64
+}
+
+func (r *resettingWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > r.left {
+		// Send what came before, then abort the connection mid-body
+		r.w.Write(b[:r.left])
+		if r.flusher != nil {
+			r.flusher.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+	r.left -= int64(len(b))
+	return r.w.Write(b)
+}
+
+// Server is a payload generator listening on a local address
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Start serves the generated files on addr, e.g. 127.0.0.1:0 for any free
+// port
+func Start(addr string, profile Profile) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the sandbox: %w", err)
+	}
+	s := &Server{listener: listener, server: &http.Server{Handler: Handler(profile)}}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Addr returns the address the generator listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns where the generator serves the file of rawURL: its path and
+// query on the generator's address. A nil server, outside sandbox mode,
+// and URLs that do not parse leave rawURL unchanged.
+func (s *Server) URL(rawURL string) string {
+	if s == nil {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = "http"
+	u.Host = s.Addr()
+	u.User = nil
+	return u.String()
+}
+
+// URLs returns the generator's URLs of rawURLs
+func (s *Server) URLs(rawURLs []string) []string {
+	if s == nil || rawURLs == nil {
+		return rawURLs
+	}
+	urls := make([]string, len(rawURLs))
+	for i, rawURL := range rawURLs {
+		urls[i] = s.URL(rawURL)
+	}
+	return urls
+}
+
+// Close stops the generator
+func (s *Server) Close() error {
+	return s.server.Close()
+}
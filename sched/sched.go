@@ -0,0 +1,118 @@
+// Package sched applies operating system hints that make a worker process a
+// better neighbour on a shared host: a lower CPU priority, an I/O scheduling
+// class and membership of a cgroup whose limits the host enforces. Hints are
+// only supported on Linux; elsewhere Apply reports ErrUnsupported.
+package sched
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned when hints are requested on a platform that has none
+var ErrUnsupported = errors.New("scheduling hints are only supported on Linux")
+
+// IOClass is an I/O scheduling class, as set by ionice
+type IOClass int
+
+const (
+	// IODefault leaves the I/O class alone
+	IODefault IOClass = iota
+	// IORealtime is served before every other class and needs privileges
+	IORealtime
+	// IOBestEffort is the class of ordinary processes, with levels 0-7
+	IOBestEffort
+	// IOIdle is only served when no other process needs the disk
+	IOIdle
+)
+
+// MaxIOLevel is the lowest priority level within a class
+const MaxIOLevel = 7
+
+var ioClassNames = map[string]IOClass{
+	"realtime":    IORealtime,
+	"best-effort": IOBestEffort,
+	"idle":        IOIdle,
+}
+
+// IOPriority is an I/O class and a level within it; 0 is the highest level
+type IOPriority struct {
+	Class IOClass
+	Level int
+}
+
+// ParseIOPriority reads an ionice style priority such as "idle",
+// "best-effort" or "best-effort:7". An empty string leaves the priority alone.
+func ParseIOPriority(s string) (IOPriority, error) {
+	if s == "" {
+		return IOPriority{}, nil
+	}
+	name, level, hasLevel := s, "", false
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, level, hasLevel = s[:i], s[i+1:], true
+	}
+
+	class, ok := ioClassNames[strings.ToLower(name)]
+	if !ok {
+		return IOPriority{}, fmt.Errorf("unknown I/O class %q, want realtime, best-effort or idle", name)
+	}
+	p := IOPriority{Class: class}
+	if class == IOBestEffort {
+		// ionice's default for best-effort processes without a nice value
+		p.Level = 4
+	}
+	if hasLevel {
+		if class == IOIdle {
+			return IOPriority{}, fmt.Errorf("the idle I/O class has no levels")
+		}
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > MaxIOLevel {
+			return IOPriority{}, fmt.Errorf("invalid I/O level %q, want 0-%d", level, MaxIOLevel)
+		}
+		p.Level = n
+	}
+	return p, nil
+}
+
+// Hints are the scheduling hints for a process; the zero value changes nothing
+type Hints struct {
+	// Nice is the CPU niceness, from -20 (favoured) to 19 (yields to
+	// everything else); zero leaves it alone
+	Nice int
+	// IO is the I/O scheduling priority
+	IO IOPriority
+	// Cgroup is a cgroup v2 directory, e.g. /sys/fs/cgroup/downloads, the
+	// process moves into so the host's cpu.max, memory.max and io.max for
+	// it apply. It is created when missing.
+	Cgroup string
+}
+
+// Empty reports whether the hints change nothing
+func (h Hints) Empty() bool {
+	return h == Hints{}
+}
+
+// Validate checks the hints before they are applied
+func (h Hints) Validate() error {
+	if h.Nice < -20 || h.Nice > 19 {
+		return fmt.Errorf("invalid nice value %d, want -20 to 19", h.Nice)
+	}
+	if h.IO.Class < IODefault || h.IO.Class > IOIdle || h.IO.Level < 0 || h.IO.Level > MaxIOLevel {
+		return fmt.Errorf("invalid I/O priority %+v", h.IO)
+	}
+	return nil
+}
+
+// Apply applies the hints to the calling process. Threads the process
+// starts afterwards inherit them.
+func (h Hints) Apply() error {
+	if h.Empty() {
+		return nil
+	}
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	return apply(h)
+}
@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+package sched
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set arguments, from linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+func apply(h Hints) error {
+	if h.Cgroup != "" {
+		if err := joinCgroup(h.Cgroup); err != nil {
+			return err
+		}
+	}
+	if h.Nice == 0 && h.IO.Class == IODefault {
+		return nil
+	}
+
+	// Linux keeps the CPU and I/O priority per thread, so every thread the
+	// Go runtime has started so far needs them
+	tids, err := threads()
+	if err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		if h.Nice != 0 {
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, h.Nice); err != nil {
+				return fmt.Errorf("failed to set nice value %d: %w", h.Nice, err)
+			}
+		}
+		if h.IO.Class != IODefault {
+			prio := int(h.IO.Class)<<ioprioClassShift | h.IO.Level
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+				return fmt.Errorf("failed to set I/O priority: %w", errno)
+			}
+		}
+	}
+	return nil
+}
+
+// threads lists the thread ids of the calling process
+func threads() ([]int, error) {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list threads: %w", err)
+	}
+	tids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if tid, err := strconv.Atoi(entry.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// joinCgroup moves the calling process into the cgroup v2 directory dir
+func joinCgroup(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %w", dir, err)
+	}
+	pid := strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(pid), 0644); err != nil {
+		return fmt.Errorf("failed to join cgroup %s: %w", dir, err)
+	}
+	return nil
+}
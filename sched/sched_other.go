@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package sched
+
+func apply(h Hints) error {
+	return ErrUnsupported
+}
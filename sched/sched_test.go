@@ -0,0 +1,56 @@
+package sched
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParseIOPriority(t *testing.T) {
+	tests := []struct {
+		in   string
+		want IOPriority
+	}{
+		{"", IOPriority{}},
+		{"idle", IOPriority{Class: IOIdle}},
+		{"best-effort", IOPriority{Class: IOBestEffort, Level: 4}},
+		{"Best-Effort:7", IOPriority{Class: IOBestEffort, Level: 7}},
+		{"realtime:0", IOPriority{Class: IORealtime}},
+	}
+	for _, tt := range tests {
+		got, err := ParseIOPriority(tt.in)
+		if err != nil {
+			t.Errorf("ParseIOPriority(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseIOPriority(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"low", "best-effort:8", "best-effort:x", "idle:3"} {
+		if _, err := ParseIOPriority(in); err == nil {
+			t.Errorf("ParseIOPriority(%q) accepted an invalid priority", in)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	if err := (Hints{}).Apply(); err != nil {
+		t.Fatalf("empty hints: %v", err)
+	}
+	if err := (Hints{Nice: 20}).Apply(); err == nil {
+		t.Error("Apply() accepted a nice value out of range")
+	}
+	if runtime.GOOS != "linux" {
+		if err := (Hints{Nice: 1}).Apply(); err != ErrUnsupported {
+			t.Errorf("Apply() = %v, want ErrUnsupported", err)
+		}
+		return
+	}
+
+	// Lowering the priority needs no privileges; the test process keeps
+	// running, only with less CPU and disk time under contention
+	if err := (Hints{Nice: 1, IO: IOPriority{Class: IOBestEffort, Level: MaxIOLevel}}).Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+}
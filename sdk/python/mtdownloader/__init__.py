@@ -0,0 +1,10 @@
+"""Client for the Multithreaded Downloader REST API.
+
+The API methods and payload types are generated from openapi/openapi.json;
+see Client for the transport and the watch and wait_for progress helpers.
+"""
+
+from ._generated import *  # noqa: F401,F403
+from .client import FINAL_STATUSES, SETTLED_STATUSES, APIError, Client, DownloadFailed
+
+__version__ = "0.1.0"
@@ -0,0 +1,1543 @@
+# Code generated by openapi/gen from openapi.json. DO NOT EDIT.
+
+from __future__ import annotations
+
+from typing import Any, Dict, List, Literal, Optional, TypedDict, Union
+from urllib.parse import quote
+
+
+class _ErrorResponseRequired(TypedDict):
+    error: str
+
+
+class ErrorResponse(_ErrorResponseRequired, total=False):
+    """ErrorResponse is returned with every 4xx and 5xx response."""
+
+    details: str
+    # Fields of a request body that are not valid, each with what is wrong with it; set only with 400 Invalid request body
+    fields: List[FieldError]
+
+
+class FieldError(TypedDict):
+    """FieldError is a request field that breaks a constraint of the document."""
+
+    # JSON name of the field, with an index for array items such as part_urls[1]
+    field: str
+    message: str
+
+
+class MessageResponse(TypedDict):
+    """MessageResponse acknowledges an action that returns no other data."""
+
+    message: str
+
+
+class _HealthResponseRequired(TypedDict):
+    status: Literal["healthy", "unhealthy"]
+    timestamp: str
+    version: str
+
+
+class HealthResponse(_HealthResponseRequired, total=False):
+    """HealthResponse reports whether a server and its dependencies are up."""
+
+    # Health of each dependency, reported by the queued server
+    checks: Dict[str, bool]
+    # Result and latency of each dependency check: redis and database on the queued server, filesystem where downloads are written
+    dependencies: Dict[str, DependencyCheck]
+    build: BuildInfo
+    uptime_seconds: int
+    goroutines: int
+    # Space left where downloads are written, on servers that write them
+    disk: DiskSpace
+
+
+class _DependencyCheckRequired(TypedDict):
+    healthy: bool
+    # How long the check took, in milliseconds
+    latency_ms: float
+
+
+class DependencyCheck(_DependencyCheckRequired, total=False):
+    """DependencyCheck is the result of checking one dependency of a server."""
+
+    error: str
+
+
+class _BuildInfoRequired(TypedDict):
+    # Release the binary was built from, set with -ldflags; dev otherwise
+    version: str
+    commit: str
+    go_version: str
+
+
+class BuildInfo(_BuildInfoRequired, total=False):
+    """BuildInfo describes the build of a server."""
+
+    build_time: str
+
+
+class DiskSpace(TypedDict):
+    """DiskSpace is the space of the file system holding a directory."""
+
+    path: str
+    # Bytes available to the server's user
+    free_bytes: int
+    total_bytes: int
+
+
+class _DownloadRequestRequired(TypedDict):
+    # Absolute http or https URL of the file
+    url: str
+    # Output path; may use template variables such as {date}/{domain}/{filename}. It must be UTF-8 without control characters, at most 4096 bytes with names of at most 255, name a file rather than a directory, and be relative to the download directory without ".." names
+    output: str
+
+
+class DownloadRequest(_DownloadRequestRequired, total=False):
+    """DownloadRequest represents the JSON request body for starting a download."""
+
+    threads: int
+    user_agent: str
+    user_agent_profile: str
+    referer: str
+    # HTTP method the file is requested with; defaults to GET, or POST when a body is given. Downloads other than a plain GET run as a single stream
+    method: Literal["GET", "POST", "PUT", "PATCH", "DELETE"]
+    # Request body sent with method, for endpoints that export files only to a POST
+    body: str
+    # Encoding of body; defaults to form
+    body_type: Literal["form", "json"]
+    # Pieces of a file published split into several URLs, such as file.z01 and file.z02, in order; they are downloaded in parallel and joined into output as one download. url must be the first of them
+    part_urls: List[str]
+    # Other URLs serving the same file. Each is probed with a small range request, and parts are spread over the fastest mirror_count of them, re-probed during long downloads; mirrors announced with Link rel=duplicate headers are added. Headers are sent to url's host only
+    mirrors: List[str]
+    # How many of the fastest mirrors parts are spread over; 0 picks 3
+    mirror_count: int
+    # Labels the bytes the download transfers are accounted to in GET /usage, e.g. a team or project; letters, digits and . _ : -, starting with a letter or digit
+    tags: List[str]
+    # Endpoint POSTed {"url": <expired link>} when the server refuses the link as expired, e.g. a pre-signed S3 URL; it answers with a new link, as JSON {"url": ...} or text, and the download continues with it
+    refresh_url: str
+    # Download the first and last this many bytes before the rest, e.g. for a zip's central directory or an MP4's moov atom; read them from GET /downloads/{id}/preview
+    preview_bytes: int
+    # Network interface such as eth1, or local IP address, the download connects from, on hosts with several networks
+    bind: str
+    # Bytes per second shared by all downloads bound to the same interface or address; 0 is unlimited
+    bind_rate_limit: int
+    # Write <output>.done, a JSON marker with the file's size, SHA-256 and validators, through a rename once the download is verified, so pipelines watching the directory can tell a finished file from one still growing; an older marker is removed when the download starts
+    done_marker: bool
+    # Split the file into exactly these parts, as GET /downloads/{id}/plan exported them, fetching each from the server it names, instead of splitting it by threads; the plan must cover the file the server has. Not with preview_bytes
+    plan: PartPlan
+
+
+class DownloadResponse(TypedDict):
+    """DownloadResponse represents the response when starting a download."""
+
+    download_id: str
+    message: str
+
+
+class _DownloadStatusRequired(TypedDict):
+    download_id: str
+    url: str
+    filename: str
+    status: Literal["waiting", "queued", "downloading", "waiting_network", "paused", "completed", "not_modified", "failed"]
+    percent_completed: float
+    bytes_downloaded: int
+    total_size: int
+    # Parts the file was split into; fewer than asked for when parts would fall below the minimum part size
+    threads_used: int
+    start_time: str
+    # ThrottledByServer is set while the origin has asked us to back off via Retry-After
+    throttled_by_server: bool
+    # Bytes per second over the last few seconds, from the live progress events
+    current_speed: float
+    # Parts transferring right now
+    active_connections: int
+
+
+class DownloadStatus(_DownloadStatusRequired, total=False):
+    """DownloadStatus represents the current status of a download."""
+
+    # Threads the download asked for
+    threads_requested: int
+    # Result of checking the finished file against a checksum the server sent in Content-MD5, Digest, Repr-Digest or Content-Digest; absent when it sent none
+    checksum_status: Literal["verified", "mismatch"]
+    # Algorithm of the checksum checked: md5, sha-1, sha-256 or sha-512
+    checksum_algorithm: str
+    # Header or trailer the checksum was read from
+    checksum_source: str
+    error: str
+    throttled_until: str
+    # Set once the head and tail asked for with preview_bytes are downloaded, or the whole file without them
+    preview_ready: bool
+    # When the download was last seen receiving data
+    last_byte_at: str
+
+
+class DownloadAdjustment(TypedDict, total=False):
+    """DownloadAdjustment changes the limits of a running download; omitted fields keep their value."""
+
+    # Bytes per second across all threads; 0 lifts the limit
+    rate_limit: Optional[int]
+    # Threads transferring at once, at most the number the download started with
+    threads: Optional[int]
+
+
+class DownloadLimits(TypedDict):
+    """DownloadLimits reports the limits a running download uses."""
+
+    download_id: str
+    rate_limit: int
+    threads: int
+    active_threads: int
+
+
+class _PartConnectionRequired(TypedDict):
+    index: int
+    start: int
+    end: int
+    # Bytes of the part written so far
+    downloaded: int
+    state: Literal["pending", "connecting", "transferring", "retrying", "done"]
+    # Bytes received over the current connection
+    bytes: int
+    # Average bytes per second over the current connection
+    speed: float
+    # Requests sent for the part since the download started or resumed
+    attempts: int
+
+
+class PartConnection(_PartConnectionRequired, total=False):
+    """PartConnection describes a part of a download and the connection it is downloading over."""
+
+    # Address of the server the part's connection goes to
+    remote_addr: str
+    # When the current request was sent
+    started_at: str
+    # When the part last received bytes or changed state
+    last_activity: str
+    last_error: str
+
+
+class DownloadParts(TypedDict):
+    """DownloadParts lists the parts of a running download with their connections."""
+
+    download_id: str
+    parts: List[PartConnection]
+
+
+class SegmentMap(TypedDict):
+    """SegmentMap shows which byte ranges of a download are complete."""
+
+    download_id: str
+    total_size: int
+    # Bytes of each piece; the last one may be shorter
+    piece_size: int
+    pieces: int
+    # Base64 of one bit per piece, set once every byte of the piece is downloaded; the first piece is the high bit of the first byte, as in a BitTorrent bitfield
+    bitmap: str
+    # Downloaded byte ranges in order, neighbouring ranges merged
+    segments: List[ByteRange]
+    # Byte ranges parts are requesting or transferring now
+    active: List[ByteRange]
+    downloaded_bytes: int
+    # Bytes downloaded from the start of the file without a gap, how much of a sequential download can be played
+    contiguous_bytes: int
+
+
+class _PartPlanRequired(TypedDict):
+    version: int
+    # URL of the file, credentials redacted
+    url: str
+    total_size: int
+    parts: List[PlannedPart]
+
+
+class PartPlan(_PartPlanRequired, total=False):
+    """PartPlan is the exact split of a download into parts and the server each part is fetched from."""
+
+    # Version of the file the plan was made for; a download of another version uses the plan with a warning
+    etag: str
+
+
+class _PlannedPartRequired(TypedDict):
+    index: int
+    start: int
+    end: int
+
+
+class PlannedPart(_PlannedPartRequired, total=False):
+    """PlannedPart is one part of a plan: the inclusive byte range it covers and where it comes from."""
+
+    # The piece of a joined file holding the part, or the URL or mirror the part is pinned to; without one a download with mirrors gives the part to the fastest
+    source: str
+
+
+class _DownloadEnvironmentRequired(TypedDict):
+    download_id: str
+    version: int
+    captured_at: str
+    # Go runtime of the process that ran the download
+    go: str
+    # os/arch of the process that ran the download
+    platform: str
+    url: str
+    # File name of the output
+    output: str
+    request: EnvironmentRequest
+    config: EnvironmentConfig
+    total_size: int
+    parts: int
+
+
+class DownloadEnvironment(_DownloadEnvironmentRequired, total=False):
+    """DownloadEnvironment records everything that decided how a download ran, credentials redacted."""
+
+    # When the download was verified; unset before
+    completed_at: str
+    # URLs the probe was redirected from, in order, before final_url
+    redirects: List[str]
+    final_url: str
+    # The download continued saved progress without a new probe
+    resumed: bool
+    resolved: List[ResolvedHost]
+    server: EnvironmentServer
+    etag: str
+    last_modified: str
+    # Server addresses the parts were fetched from, once the download is verified
+    part_addresses: List[str]
+
+
+class _EnvironmentRequestRequired(TypedDict):
+    method: str
+    user_agent: str
+
+
+class EnvironmentRequest(_EnvironmentRequestRequired, total=False):
+    """EnvironmentRequest is the request a download sends, sensitive header values redacted."""
+
+    headers: Dict[str, str]
+    # User-Agents sent in turn instead of user_agent
+    user_agents: List[str]
+    referer: str
+    content_type: str
+    body_bytes: int
+    # Cookies from a cookie jar were sent
+    cookies: bool
+
+
+class _EnvironmentConfigRequired(TypedDict):
+    threads: int
+    requested_threads: int
+    min_part_size: int
+    # Bytes per second of the download; 0 is unlimited
+    rate_limit: int
+    http2: bool
+    # Failed attempts in a row before a part gives up; 0 retries until stopped
+    retry_attempts: int
+    retry_delay: str
+    checksum_retries: int
+
+
+class EnvironmentConfig(_EnvironmentConfigRequired, total=False):
+    """EnvironmentConfig are the effective settings of a download."""
+
+    # Bytes per second the download shared with others, such as the server-wide cap
+    shared_rate_limit: int
+    bind: str
+    bind_rate_limit: int
+    encrypted: bool
+    sequential: bool
+    preview_bytes: int
+    jitter: str
+    mirrors: List[str]
+    mirror_count: int
+    part_urls: List[str]
+    expected_size: int
+    max_threads: int
+    max_buffer_memory: int
+    # The parts came from an imported part plan
+    plan: bool
+    # An existing output file without saved progress was continued
+    continue_file: bool
+    only_if_modified: bool
+    cache: bool
+
+
+class _ResolvedHostRequired(TypedDict):
+    # host:port
+    host: str
+
+
+class ResolvedHost(_ResolvedHostRequired, total=False):
+    """ResolvedHost is what the resolver answered for a host the probe connected to, and the address it connected to."""
+
+    # Addresses the resolver answered; none for IP literals and reused connections
+    addresses: List[str]
+    connected: str
+
+
+class _EnvironmentServerRequired(TypedDict):
+    supports_ranges: bool
+    probed_at: str
+
+
+class EnvironmentServer(_EnvironmentServerRequired, total=False):
+    """EnvironmentServer is the server's answer to a download's probe, sensitive header values redacted."""
+
+    status: str
+    proto: str
+    headers: Dict[str, str]
+    # The answer came from the probe cache, which keeps no headers
+    cached: bool
+
+
+class ByteRange(TypedDict):
+    """ByteRange is the bytes from start to end of a file, both included."""
+
+    start: int
+    end: int
+
+
+class Settings(TypedDict):
+    """Settings are the runtime settings of a server."""
+
+    # Bytes per second of all downloads together; 0 is unlimited
+    global_rate_limit: int
+    # Downloads transferring at once, more wait as queued; 0 is unlimited
+    max_concurrent_downloads: int
+    # Threads for downloads that do not ask for a count
+    default_threads: int
+    # Days completed downloads stay in the database
+    retention_days: int
+    # Smallest part in bytes a file is split into, so small files use fewer threads; 0 splits into as many parts as asked for
+    min_part_size: int
+
+
+class SettingsUpdate(TypedDict, total=False):
+    """SettingsUpdate changes runtime settings; omitted fields keep their value."""
+
+    global_rate_limit: Optional[int]
+    max_concurrent_downloads: Optional[int]
+    default_threads: Optional[int]
+    retention_days: Optional[int]
+    min_part_size: Optional[int]
+
+
+class _DomainRuleRequired(TypedDict):
+    # Hosts the rule applies to: example.com for that host, *.example.com for its subdomains, * for every host
+    match: str
+
+
+class DomainRule(_DomainRuleRequired, total=False):
+    """DomainRule sets defaults for downloads from hosts matching a domain pattern; whatever a download asks for itself wins."""
+
+    # Threads for downloads that do not ask for a count
+    threads: int
+    # Bytes per second of each download; 0 is unlimited
+    rate_limit: int
+    # Request headers such as an access token; values are redacted when rules are listed
+    headers: Dict[str, str]
+    # Netscape cookies.txt file on the host running the download
+    cookies_file: str
+    # Failed attempts in a row after which a part gives up and the download fails; 0 retries until it is stopped
+    max_attempts: int
+    # Pause before a failed attempt is retried, e.g. 5s; defaults to 1s
+    retry_delay: str
+    # User-Agents sent in turn, one per request, by downloads that do not ask for their own; each is a profile name such as chrome or a full User-Agent
+    user_agents: List[str]
+    # Most a random delay before every request may be, e.g. 500ms, so requests do not arrive at regular intervals; at most 10s
+    jitter: str
+    # Network interface such as eth1, or local IP address, that downloads which do not name their own connect from
+    bind: str
+    # Bytes per second shared by all downloads bound to the same interface or address; 0 is unlimited
+    bind_rate_limit: int
+
+
+class DomainRules(TypedDict):
+    """DomainRules lists the per-domain download defaults; more specific patterns override less specific ones."""
+
+    rules: List[DomainRule]
+
+
+class _DomainRuleMatchRequired(TypedDict):
+    url: str
+    # Patterns of the matching rules, least specific first
+    matched: List[str]
+
+
+class DomainRuleMatch(_DomainRuleMatchRequired, total=False):
+    """DomainRuleMatch is what the domain rules give a download from a URL."""
+
+    threads: int
+    rate_limit: int
+    headers: Dict[str, str]
+    cookies_file: str
+    max_attempts: int
+    retry_delay: str
+    # User-Agents sent in turn, with profile names resolved
+    user_agents: List[str]
+    jitter: str
+    bind: str
+    bind_rate_limit: int
+
+
+class AuditEntry(TypedDict):
+    """AuditEntry is one change made through the API."""
+
+    id: int
+    time: str
+    # Client address the change came from
+    actor: str
+    # What changed, e.g. settings.update
+    action: str
+    # The object that changed, e.g. a setting name
+    target: str
+    old_value: str
+    new_value: str
+
+
+class AuditLog(TypedDict):
+    """AuditLog lists recent audit entries, newest first."""
+
+    entries: List[AuditEntry]
+    count: int
+
+
+class _DownloadEventRequired(TypedDict):
+    id: int
+    type: Literal["created", "started", "paused", "completed", "failed", "requeued"]
+    time: str
+    # Server or worker process the change happened on
+    node: str
+
+
+class DownloadEvent(_DownloadEventRequired, total=False):
+    """DownloadEvent is one state change of a download."""
+
+    # Status the download moved to
+    status: str
+    # Who caused the change on the node: the client address of the request, the worker or the schedule; empty when the node acted on its own
+    actor: str
+    # Why the download was requeued
+    reason: str
+    error: str
+    # Bytes downloaded by then, when known
+    bytes_downloaded: int
+
+
+class DownloadEvents(TypedDict):
+    """DownloadEvents lists the state changes of a download, oldest first."""
+
+    download_id: str
+    events: List[DownloadEvent]
+    count: int
+
+
+class _UsageRowRequired(TypedDict):
+    # The day as 2006-01-02 or the month as 2006-01
+    period: str
+    # The user, tag or domain; empty for downloads nobody started through the API, e.g. scheduled ones
+    key: str
+    bytes: int
+
+
+class UsageRow(_UsageRowRequired, total=False):
+    """UsageRow is the bytes charged to one key over one period."""
+
+    # bytes at cost_per_gb, when it is set
+    estimated_cost: float
+
+
+class _UsageReportRequired(TypedDict):
+    by: Literal["user", "tag", "domain"]
+    period: Literal["daily", "monthly"]
+    since: str
+    until: str
+    # Ordered by period, then by bytes, most first
+    rows: List[UsageRow]
+    # The bytes of all rows; by tag, bytes of a download with several tags count once per tag
+    total_bytes: int
+
+
+class UsageReport(_UsageReportRequired, total=False):
+    """UsageReport sums the bytes transferred by period and key."""
+
+    # USAGE_COST_PER_GB, the cost of 10^9 bytes, when it is set
+    cost_per_gb: float
+    # total_bytes at cost_per_gb, when it is set
+    estimated_cost: float
+
+
+class _AlertStatusRequired(TypedDict):
+    name: str
+    metric: Literal["queue_depth", "running_downloads", "average_speed", "total_speed", "failure_rate", "queue_wait_seconds"]
+    op: Literal[">", ">=", "<", "<="]
+    threshold: float
+    # How long the condition must hold before the rule fires
+    for_seconds: int
+    state: Literal["ok", "pending", "firing"]
+
+
+class AlertStatus(_AlertStatusRequired, total=False):
+    """AlertStatus is an alert rule and where it stands."""
+
+    severity: str
+    description: str
+    # The metric at the last evaluation; absent when it had no value, e.g. the average speed while nothing runs
+    value: Optional[float]
+    # When the condition started holding
+    since: str
+    fired_at: str
+
+
+class _AlertsRequired(TypedDict):
+    alerts: List[AlertStatus]
+    # How many rules are firing
+    firing: int
+
+
+class Alerts(_AlertsRequired, total=False):
+    """Alerts lists the alert rules of a server."""
+
+    # Absent until the rules were first evaluated
+    evaluated_at: str
+
+
+class _ScalingHintRequired(TypedDict):
+    # How long the queue must grow with idle workers to count as stalled
+    window_seconds: int
+    # Bytes per second of all jobs together at or under which the workers count as idle
+    idle_speed: float
+    # Jobs in the main queue
+    queue_depth: int
+    running_downloads: int
+    # Bytes per second of all running jobs together
+    total_speed: float
+    # Registered worker nodes
+    worker_nodes: int
+    # Jobs the registered worker nodes run at once together
+    worker_slots: int
+    stalled: bool
+    # Worker nodes that would run every queued and running job at once, between SCALE_MIN_WORKERS and SCALE_MAX_WORKERS; one more than there are while the queue is stalled
+    desired_workers: int
+
+
+class ScalingHint(_ScalingHintRequired, total=False):
+    """ScalingHint is the stalled-queue watchdog's last sample of the queue and the worker nodes it suggests."""
+
+    # Absent until the watchdog took its first sample
+    observed_at: str
+    # How much the queue grew over the window; absent until the watchdog has watched for a whole window
+    queue_growth: Optional[int]
+    # Jobs that completed or failed within the window; absent like queue_growth
+    finished: Optional[int]
+    stalled_since: str
+
+
+class Manifest(TypedDict):
+    """Manifest is a portable list of download definitions exported from one server to be imported into another."""
+
+    # Manifest format version
+    version: int
+    exported_at: str
+    downloads: List[ManifestEntry]
+
+
+class _ManifestEntryRequired(TypedDict):
+    request: DownloadRequest
+
+
+class ManifestEntry(_ManifestEntryRequired, total=False):
+    """ManifestEntry is one exported download: the request that starts it again and what happened to it on the exporting server."""
+
+    # ID on the exporting server
+    id: str
+    # Status on the exporting server
+    status: str
+    error: str
+    # Algorithm of the checksum the origin sent, if it sent one
+    checksum_algorithm: str
+    # Header the checksum came from
+    checksum_source: str
+
+
+class ManifestImport(TypedDict):
+    """ManifestImport reports what became of every entry of an imported manifest, in manifest order."""
+
+    results: List[ManifestImportResult]
+    imported: int
+    skipped: int
+    failed: int
+
+
+class _ManifestImportResultRequired(TypedDict):
+    # URL of the entry, with credentials redacted
+    url: str
+    # skipped entries are already waiting or running on this server
+    result: Literal["imported", "skipped", "failed"]
+
+
+class ManifestImportResult(_ManifestImportResultRequired, total=False):
+    """ManifestImportResult is what became of one manifest entry."""
+
+    # The new download, or the one already running for skipped entries
+    download_id: str
+    error: str
+
+
+class DownloadList(TypedDict):
+    """DownloadList lists every download known to a server, the most recently active first."""
+
+    downloads: List[DownloadStatus]
+    count: int
+
+
+class _QueuedDownloadRequestRequired(TypedDict):
+    # Absolute http or https URL of the file
+    url: str
+    # Output path; may use template variables such as {date}/{domain}/{filename}. It must be UTF-8 without control characters, at most 4096 bytes with names of at most 255, name a file rather than a directory, and be relative to the download directory without ".." names
+    output: str
+
+
+class QueuedDownloadRequest(_QueuedDownloadRequestRequired, total=False):
+    """QueuedDownloadRequest represents the JSON request body for starting a queued download."""
+
+    threads: int
+    depends_on: List[str]
+    user_agent: str
+    user_agent_profile: str
+    referer: str
+    # Headers are extra request headers, e.g. Authorization; they are sealed before storage
+    headers: Dict[str, str]
+    # HTTP method the file is requested with; defaults to GET, or POST when a body is given. Downloads other than a plain GET run as a single stream
+    method: Literal["GET", "POST", "PUT", "PATCH", "DELETE"]
+    # Request body sent with method, for endpoints that export files only to a POST
+    body: str
+    # Encoding of body; defaults to form
+    body_type: Literal["form", "json"]
+    # Pieces of a file published split into several URLs, such as file.z01 and file.z02, in order; they are downloaded in parallel and joined into output as one download. url must be the first of them
+    part_urls: List[str]
+    # Other URLs serving the same file. Each is probed with a small range request, and parts are spread over the fastest mirror_count of them, re-probed during long downloads; mirrors announced with Link rel=duplicate headers are added. Headers are sent to url's host only
+    mirrors: List[str]
+    # How many of the fastest mirrors parts are spread over; 0 picks 3
+    mirror_count: int
+    # Labels the bytes the download transfers are accounted to in GET /usage, e.g. a team or project; letters, digits and . _ : -, starting with a letter or digit
+    tags: List[str]
+    # Endpoint POSTed {"url": <expired link>} when the server refuses the link as expired, e.g. a pre-signed S3 URL; it answers with a new link, as JSON {"url": ...} or text, and the download continues with it
+    refresh_url: str
+    # Download only when the remote file changed since the file at output_path was downloaded, asking with If-None-Match and If-Modified-Since; otherwise the job ends with status not_modified
+    only_if_modified: bool
+    # Network interface such as eth1, or local IP address, of the worker host the job connects from
+    bind: str
+    # Bytes per second shared by all jobs a worker binds to the same interface or address; 0 is unlimited
+    bind_rate_limit: int
+    # Write <output>.done, a JSON marker with the file's size, SHA-256 and validators, through a rename once the download is verified, so pipelines watching the directory can tell a finished file from one still growing; an older marker is removed when the download starts
+    done_marker: bool
+    # Split the file into exactly these parts, as GET /downloads/{id}/plan exported them, fetching each from the server it names, instead of splitting it by threads; the plan must cover the file the server has
+    plan: PartPlan
+
+
+class _QueuedDownloadResponseRequired(TypedDict):
+    job_id: str
+    message: str
+    status: Literal["waiting", "queued"]
+
+
+class QueuedDownloadResponse(_QueuedDownloadResponseRequired, total=False):
+    """QueuedDownloadResponse represents the response when enqueueing a download."""
+
+    # What an earlier download of the same URL learned about the file, when it is recent enough that the worker reuses it instead of probing the server
+    probe: CachedProbe
+
+
+class _CachedProbeRequired(TypedDict):
+    supports_ranges: bool
+    size_bytes: int
+    probed_at: str
+
+
+class CachedProbe(_CachedProbeRequired, total=False):
+    """CachedProbe is a range check of a URL kept for PROBE_CACHE_TTL."""
+
+    etag: str
+    last_modified: str
+
+
+class _ProbeRequired(TypedDict):
+    supports_ranges: bool
+    size_bytes: int
+    # Where redirects led, credentials redacted
+    final_url: str
+    probed_at: str
+
+
+class Probe(_ProbeRequired, total=False):
+    """Probe is what a range check learned about the file at a URL."""
+
+    etag: str
+    last_modified: str
+    # The Accept-Ranges header as the server sent it
+    accept_ranges: str
+    # The Server header
+    server: str
+
+
+class _QueuedDownloadStatusRequired(TypedDict):
+    job_id: str
+    url: str
+    output_path: str
+    # API v1 reports downloading jobs as processing
+    status: Literal["waiting", "queued", "downloading", "waiting_network", "paused", "completed", "not_modified", "failed", "processing"]
+    progress: float
+    bytes_downloaded: int
+    total_bytes: int
+    # Parts the file was split into; fewer than asked for when parts would fall below the minimum part size
+    threads_used: int
+    created_at: str
+    throttled_by_server: bool
+    # Bytes per second over the last few seconds, from the live progress events
+    current_speed: float
+    # Parts transferring right now
+    active_connections: int
+
+
+class QueuedDownloadStatus(_QueuedDownloadStatusRequired, total=False):
+    """QueuedDownloadStatus represents the current status of a queued download."""
+
+    # Threads the job asked for
+    threads_requested: int
+    # Result of checking the finished file against a checksum the server sent in Content-MD5, Digest, Repr-Digest or Content-Digest; absent when it sent none
+    checksum_status: Literal["verified", "mismatch"]
+    # Algorithm of the checksum checked: md5, sha-1, sha-256 or sha-512
+    checksum_algorithm: str
+    # Header or trailer the checksum was read from
+    checksum_source: str
+    started_at: str
+    completed_at: str
+    worker_id: str
+    error_message: str
+    depends_on: List[str]
+    # When the download was last seen receiving data
+    last_byte_at: str
+    # Files the job produced; empty until it completes
+    artifacts: List[Artifact]
+
+
+class _ArtifactRequired(TypedDict):
+    # Absolute path of the file on the node that stored it
+    path: str
+    # Size of the file as stored
+    size_bytes: int
+    # Hex digest of the file as stored
+    checksum: str
+    checksum_algorithm: Literal["sha-256"]
+    # Storage the file was written to; local is the worker's filesystem
+    backend: Literal["local"]
+    # The file is stored encrypted with the workers' encryption key, so checksum and size are of the ciphertext
+    encrypted: bool
+    created_at: str
+
+
+class Artifact(_ArtifactRequired, total=False):
+    """Artifact describes a file a job produced and where it is stored."""
+
+    # Worker node that wrote the file
+    node: str
+
+
+class QueuedDownloadList(TypedDict):
+    """QueuedDownloadList lists every job recorded by the queued server, the most recently active first."""
+
+    downloads: List[QueuedDownloadStatus]
+    count: int
+
+
+class GroupDownloadRequest(TypedDict, total=False):
+    """GroupDownloadRequest represents the JSON request body for creating a download group."""
+
+    # Exactly one of url_template, page_url or repo_url must be set
+    url_template: str
+    page_url: str
+    pattern: str
+    # Leave out the links of page_url that its site's robots.txt disallows for the group's User-Agent
+    polite: bool
+    # An hf://[datasets/|spaces/]org/name[@revision][/path] Hugging Face repo whose files are downloaded, or with lfs_objects the Git repository whose LFS server serves them
+    repo_url: str
+    # The objects of Git LFS pointer files, saved at their paths under output_dir
+    lfs_objects: List[LFSObject]
+    # Directory the files are saved in, relative to the download directory and without ".." names
+    output_dir: str
+    threads: int
+    user_agent: str
+    user_agent_profile: str
+    referer: str
+    headers: Dict[str, str]
+
+
+class GroupDownloadResponse(TypedDict):
+    """GroupDownloadResponse represents the response when enqueueing a download group."""
+
+    group_id: str
+    job_ids: List[str]
+    count: int
+    message: str
+
+
+class _GroupEntryRequired(TypedDict):
+    url: str
+    output_path: str
+
+
+class GroupEntry(_GroupEntryRequired, total=False):
+    """GroupEntry is a single URL and the path it will be saved to."""
+
+    # Bytes the file must have, when the group's listing gave them
+    size: int
+    # Hex SHA-256 the file must have, when the group's listing gave it
+    sha256: str
+
+
+class LFSObject(TypedDict):
+    """LFSObject is the object a Git LFS pointer file stands for."""
+
+    # Slash-separated path of the pointer in the repository
+    path: str
+    # Hex SHA-256 of the object, from the pointer's oid sha256: line
+    oid: str
+    size: int
+
+
+class GroupPreview(TypedDict):
+    """GroupPreview lists the entries a group request resolves to."""
+
+    downloads: List[GroupEntry]
+    count: int
+
+
+class _GroupStatusRequired(TypedDict):
+    group_id: str
+    downloads: List[QueuedDownloadStatus]
+    # Number of jobs in each status
+    summary: Dict[str, int]
+    count: int
+
+
+class GroupStatus(_GroupStatusRequired, total=False):
+    """GroupStatus reports the status of every job in a group."""
+
+    # Set once the group finished and its SHA256SUMS file was written
+    checksums: GroupChecksums
+
+
+class _GroupChecksumsRequired(TypedDict):
+    # Number of files listed
+    files: int
+    signed: bool
+    generated_at: str
+
+
+class GroupChecksums(_GroupChecksumsRequired, total=False):
+    """GroupChecksums describes the SHA256SUMS file written once a group finished."""
+
+    # PEM public key that checks the signature
+    public_key: str
+    # Directory on node holding the files and SHA256SUMS; empty when the files are spread over nodes
+    dir: str
+    node: str
+
+
+class _QueuedJobRequired(TypedDict):
+    # Place in its queue; 1 is the job a worker takes next
+    position: int
+    id: str
+    url: str
+    output_path: str
+    threads: int
+    created_at: str
+
+
+class QueuedJob(_QueuedJobRequired, total=False):
+    """QueuedJob describes a job waiting in the main queue or in the queue of a worker node."""
+
+    # Worker node whose queue holds the job, as a requeued job waits for the node with its partial file; empty for the main queue
+    node: str
+    method: str
+    group_id: str
+
+
+class QueuedJobList(TypedDict):
+    """QueuedJobList lists the jobs waiting in the main queue in the order workers take them."""
+
+    jobs: List[QueuedJob]
+    count: int
+
+
+class _ArchivedJobRequired(TypedDict):
+    id: str
+    status: Literal["completed", "failed"]
+    bytes_downloaded: int
+    created_at: str
+    completed_at: str
+    # How long the job ran; 0 if it never started
+    duration_seconds: float
+
+
+class ArchivedJob(_ArchivedJobRequired, total=False):
+    """ArchivedJob describes a job in the completed or failed queue."""
+
+    url: str
+    output_path: str
+    group_id: str
+    worker_id: str
+    error_message: str
+    started_at: str
+
+
+class ArchivedJobList(TypedDict):
+    """ArchivedJobList lists recently finished jobs, the most recent first."""
+
+    jobs: List[ArchivedJob]
+    count: int
+
+
+class _ScheduleRequestRequired(TypedDict):
+    # When to run: five cron fields (minute hour day-of-month month day-of-week) such as "0 3 * * *", a descriptor such as @daily, or "@every 6h"
+    cron: str
+    download: QueuedDownloadRequest
+
+
+class ScheduleRequest(_ScheduleRequestRequired, total=False):
+    """ScheduleRequest creates a schedule that enqueues a download."""
+
+    name: str
+    # IANA time zone the cron fields are read in, e.g. Europe/Berlin; defaults to UTC
+    timezone: str
+    # Create the schedule paused
+    paused: bool
+
+
+class _ScheduleRequired(TypedDict):
+    id: str
+    cron: str
+    timezone: str
+    paused: bool
+    url: str
+    output_path: str
+    created_at: str
+
+
+class Schedule(_ScheduleRequired, total=False):
+    """Schedule describes a recurring download."""
+
+    name: str
+    only_if_modified: bool
+    # When the schedule next enqueues the download; omitted while paused
+    next_run: str
+    last_run: str
+
+
+class ScheduleList(TypedDict):
+    """ScheduleList lists every schedule."""
+
+    schedules: List[Schedule]
+    count: int
+
+
+class _ScheduleRunRequired(TypedDict):
+    time: str
+    # enqueued a job, skipped because the previous run's job had not finished, or failed to enqueue
+    result: Literal["enqueued", "skipped", "failed"]
+
+
+class ScheduleRun(_ScheduleRunRequired, total=False):
+    """ScheduleRun records one time a schedule fired."""
+
+    # The job enqueued, or the unfinished one a skipped run waited for
+    job_id: str
+    details: str
+
+
+class ScheduleRunList(TypedDict):
+    """ScheduleRunList lists the past runs of a schedule, the most recent first."""
+
+    runs: List[ScheduleRun]
+    count: int
+
+
+class GeneratedClient:
+    """One method per API operation; subclasses implement _request."""
+
+    def _request(self, method: str, path: str, *, query: Optional[Dict[str, Any]] = None,
+                 json: Any = None, text: Optional[str] = None, binary: bool = False,
+                 headers: Optional[Dict[str, str]] = None) -> Any:
+        raise NotImplementedError
+
+    def start_download(self, body: DownloadRequest, *, headers: Optional[Dict[str, str]] = None) -> DownloadResponse:
+        """Start a download.
+
+        Served by the direct and simple servers.
+        """
+        return self._request("POST", "/downloads", json=body, headers=headers)
+
+    def list_downloads(self, *, headers: Optional[Dict[str, str]] = None) -> DownloadList:
+        """List downloads.
+
+        Served by the direct and simple servers.
+        """
+        return self._request("GET", "/downloads", headers=headers)
+
+    def export_downloads(self, *, status: Optional[str] = None, format: Optional[Literal["json", "yaml"]] = None, headers: Optional[Dict[str, str]] = None) -> Manifest:
+        """Export download definitions as a manifest another server can import.
+
+        Served by the direct server from API v2.
+
+        status: Comma separated statuses to export; defaults to every download that has not completed
+
+        format: Manifest encoding
+        """
+        return self._request("GET", "/downloads/export", query={"status": status, "format": format}, headers=headers)
+
+    def import_downloads(self, body: Manifest, *, headers: Optional[Dict[str, str]] = None) -> ManifestImport:
+        """Start the downloads of an exported manifest.
+
+        Served by the direct server from API v2.
+        """
+        return self._request("POST", "/downloads/import", json=body, headers=headers)
+
+    def get_download_status(self, id: str, *, wait: Optional[str] = None, min_progress: Optional[float] = None, headers: Optional[Dict[str, str]] = None) -> DownloadStatus:
+        """Get the status of a download.
+
+        Served by the direct and simple servers.
+
+        wait: Hold the request until the status changes or progress moves by min_progress, for at most this long, e.g. 30s; up to 60s. Finished downloads answer at once.
+
+        min_progress: Percentage points of progress that end a wait; any progress counts while the size is unknown
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/status", query={"wait": wait, "min_progress": min_progress}, headers=headers)
+
+    def pause_download(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Pause a running download.
+
+        Served by the direct server.
+        """
+        return self._request("POST", f"/downloads/{quote(id, safe='')}/pause", headers=headers)
+
+    def resume_download(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Resume a paused download.
+
+        Served by the direct server.
+        """
+        return self._request("POST", f"/downloads/{quote(id, safe='')}/resume", headers=headers)
+
+    def preview_download(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> bytes:
+        """Read the bytes of a download fetched so far.
+
+        Served by the direct server from API v2.
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/preview", binary=True, headers=headers)
+
+    def list_download_parts(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> DownloadParts:
+        """Diagnostics of the connection of every part of a running download.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/parts", headers=headers)
+
+    def get_download_map(self, id: str, *, pieces: Optional[int] = None, headers: Optional[Dict[str, str]] = None) -> SegmentMap:
+        """Which byte ranges of a running download are complete, as a piece bitmap and a list of segments.
+
+        Served by the direct and queued servers from API v2.
+
+        pieces: Pieces to cut the file into; fewer for files smaller than that many bytes
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/map", query={"pieces": pieces}, headers=headers)
+
+    def get_download_plan(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> PartPlan:
+        """The exact split of a running download into parts, with the server each part comes from.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/plan", headers=headers)
+
+    def get_download_environment(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> DownloadEnvironment:
+        """Everything that decided how a download ran, to reproduce it or attach to a bug report.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/environment", headers=headers)
+
+    def list_download_events(self, id: str, *, limit: Optional[int] = None, headers: Optional[Dict[str, str]] = None) -> DownloadEvents:
+        """Every state change of a download, oldest first, with when it happened and who caused it.
+
+        Served by the direct and queued servers from API v2.
+
+        limit: Most events to return, the newest ones
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/events", query={"limit": limit}, headers=headers)
+
+    def restart_download_part(self, id: str, index: str, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Drop the connection of a part and request it again.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("POST", f"/downloads/{quote(id, safe='')}/parts/{quote(index, safe='')}/restart", headers=headers)
+
+    def delete_download(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Cancel and remove a download.
+
+        Served by the direct server.
+        """
+        return self._request("DELETE", f"/downloads/{quote(id, safe='')}", headers=headers)
+
+    def adjust_download(self, id: str, body: DownloadAdjustment, *, headers: Optional[Dict[str, str]] = None) -> Union[DownloadLimits, MessageResponse]:
+        """Change the rate limit or thread count of a running download.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("PATCH", f"/downloads/{quote(id, safe='')}", json=body, headers=headers)
+
+    def get_settings(self, *, headers: Optional[Dict[str, str]] = None) -> Settings:
+        """Runtime settings of the server.
+
+        Served by the direct server from API v2.
+        """
+        return self._request("GET", "/settings", headers=headers)
+
+    def update_settings(self, body: SettingsUpdate, *, headers: Optional[Dict[str, str]] = None) -> Settings:
+        """Change runtime settings; every change is recorded in the audit log.
+
+        Served by the direct server from API v2.
+        """
+        return self._request("PATCH", "/settings", json=body, headers=headers)
+
+    def list_audit_entries(self, *, limit: Optional[int] = None, headers: Optional[Dict[str, str]] = None) -> AuditLog:
+        """Recent changes made through the API, newest first.
+
+        Served by the direct server from API v2.
+
+        limit: Most entries to return
+        """
+        return self._request("GET", "/audit", query={"limit": limit}, headers=headers)
+
+    def backup(self, *, headers: Optional[Dict[str, str]] = None) -> bytes:
+        """Archive of the database and the progress files to restore the server from.
+
+        Served by the direct server from API v2.
+        """
+        return self._request("GET", "/backup", binary=True, headers=headers)
+
+    def get_stats(self, *, headers: Optional[Dict[str, str]] = None) -> Dict[str, Any]:
+        """Download statistics from the database.
+
+        Served by the direct server.
+        """
+        return self._request("GET", "/stats", headers=headers)
+
+    def enqueue_download(self, body: QueuedDownloadRequest, *, headers: Optional[Dict[str, str]] = None) -> QueuedDownloadResponse:
+        """Enqueue a download job.
+
+        Served by the queued server.
+        """
+        return self._request("POST", "/downloads", json=body, headers=headers)
+
+    def list_jobs(self, *, headers: Optional[Dict[str, str]] = None) -> QueuedDownloadList:
+        """List download jobs.
+
+        Served by the queued server.
+        """
+        return self._request("GET", "/downloads", headers=headers)
+
+    def get_job_status(self, id: str, *, wait: Optional[str] = None, min_progress: Optional[float] = None, headers: Optional[Dict[str, str]] = None) -> QueuedDownloadStatus:
+        """Get the status of a download job.
+
+        Served by the queued server.
+
+        wait: Hold the request until the status changes or progress moves by min_progress, for at most this long, e.g. 30s; up to 60s. Finished downloads answer at once.
+
+        min_progress: Percentage points of progress that end a wait; any progress counts while the size is unknown
+        """
+        return self._request("GET", f"/downloads/{quote(id, safe='')}/status", query={"wait": wait, "min_progress": min_progress}, headers=headers)
+
+    def enqueue_group(self, body: GroupDownloadRequest, *, headers: Optional[Dict[str, str]] = None) -> GroupDownloadResponse:
+        """Enqueue every URL of a template or page as one group.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("POST", "/groups", json=body, headers=headers)
+
+    def preview_group(self, body: GroupDownloadRequest, *, headers: Optional[Dict[str, str]] = None) -> GroupPreview:
+        """Show the URLs and output paths a group request resolves to.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("POST", "/groups/preview", json=body, headers=headers)
+
+    def get_group_status(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> GroupStatus:
+        """Status of every job in a group.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", f"/groups/{quote(id, safe='')}", headers=headers)
+
+    def get_group_checksums(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> bytes:
+        """SHA256SUMS file of a finished group.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", f"/groups/{quote(id, safe='')}/checksums", binary=True, headers=headers)
+
+    def get_group_checksums_signature(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> bytes:
+        """Ed25519 signature of the SHA256SUMS file of a finished group.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", f"/groups/{quote(id, safe='')}/checksums.sig", binary=True, headers=headers)
+
+    def create_schedule(self, body: ScheduleRequest, *, headers: Optional[Dict[str, str]] = None) -> Schedule:
+        """Enqueue a download again and again on a cron schedule.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("POST", "/schedules", json=body, headers=headers)
+
+    def list_schedules(self, *, headers: Optional[Dict[str, str]] = None) -> ScheduleList:
+        """List every schedule.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", "/schedules", headers=headers)
+
+    def get_schedule(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> Schedule:
+        """Get a schedule.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", f"/schedules/{quote(id, safe='')}", headers=headers)
+
+    def delete_schedule(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Delete a schedule and its history; jobs it enqueued are kept.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("DELETE", f"/schedules/{quote(id, safe='')}", headers=headers)
+
+    def pause_schedule(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> Schedule:
+        """Stop a schedule from enqueueing downloads.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("POST", f"/schedules/{quote(id, safe='')}/pause", headers=headers)
+
+    def resume_schedule(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> Schedule:
+        """Resume a paused schedule from its next time; runs missed while paused are skipped.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("POST", f"/schedules/{quote(id, safe='')}/resume", headers=headers)
+
+    def list_schedule_runs(self, id: str, *, limit: Optional[int] = None, headers: Optional[Dict[str, str]] = None) -> ScheduleRunList:
+        """Past runs of a schedule, the most recent first.
+
+        Served by the queued server from API v2.
+
+        limit: Most runs to return
+        """
+        return self._request("GET", f"/schedules/{quote(id, safe='')}/runs", query={"limit": limit}, headers=headers)
+
+    def import_cookies(self, body: str, *, headers: Optional[Dict[str, str]] = None) -> Dict[str, Any]:
+        """Import a Netscape cookies.txt file.
+
+        Served by the direct and queued servers.
+        """
+        return self._request("POST", "/cookies", text=body, headers=headers)
+
+    def clear_cookies(self, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Forget every imported cookie.
+
+        Served by the direct and queued servers.
+        """
+        return self._request("DELETE", "/cookies", headers=headers)
+
+    def list_domain_rules(self, *, headers: Optional[Dict[str, str]] = None) -> DomainRules:
+        """Per-domain download defaults, with header values redacted.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("GET", "/domain-rules", headers=headers)
+
+    def replace_domain_rules(self, body: DomainRules, *, headers: Optional[Dict[str, str]] = None) -> DomainRules:
+        """Replace the per-domain download defaults.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("PUT", "/domain-rules", json=body, headers=headers)
+
+    def match_domain_rules(self, *, url: Optional[str] = None, headers: Optional[Dict[str, str]] = None) -> DomainRuleMatch:
+        """The defaults the domain rules give a download from a URL.
+
+        Served by the direct and queued servers from API v2.
+
+        url: URL of the download
+        """
+        return self._request("GET", "/domain-rules/match", query={"url": url}, headers=headers)
+
+    def probe_url(self, *, url: Optional[str] = None, headers: Optional[Dict[str, str]] = None) -> Probe:
+        """Ask the server of a URL whether it serves byte ranges, and the file's size and validators.
+
+        Served by the direct and queued servers from API v2.
+
+        url: URL of the file
+        """
+        return self._request("GET", "/probe", query={"url": url}, headers=headers)
+
+    def get_queue_stats(self, *, headers: Optional[Dict[str, str]] = None) -> Dict[str, Any]:
+        """Number of jobs in each queue.
+
+        Served by the queued server.
+        """
+        return self._request("GET", "/queue/stats", headers=headers)
+
+    def list_queued_jobs(self, *, headers: Optional[Dict[str, str]] = None) -> QueuedJobList:
+        """Jobs waiting in the main queue, the next one first, then those waiting in the queue of each worker node.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", "/queue/jobs", headers=headers)
+
+    def move_queued_job_to_front(self, id: str, *, headers: Optional[Dict[str, str]] = None) -> MessageResponse:
+        """Make a queued job the next one a worker takes.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("POST", f"/queue/jobs/{quote(id, safe='')}/move-to-front", headers=headers)
+
+    def list_completed_jobs(self, *, limit: Optional[int] = None, headers: Optional[Dict[str, str]] = None) -> ArchivedJobList:
+        """Recently completed jobs, the most recent first.
+
+        Served by the queued server from API v2.
+
+        limit: Most jobs to return
+        """
+        return self._request("GET", "/queue/completed", query={"limit": limit}, headers=headers)
+
+    def list_failed_jobs(self, *, limit: Optional[int] = None, headers: Optional[Dict[str, str]] = None) -> ArchivedJobList:
+        """Recently failed jobs, the most recent first.
+
+        Served by the queued server from API v2.
+
+        limit: Most jobs to return
+        """
+        return self._request("GET", "/queue/failed", query={"limit": limit}, headers=headers)
+
+    def get_worker_stats(self, *, headers: Optional[Dict[str, str]] = None) -> Dict[str, Any]:
+        """Worker statistics.
+
+        Served by the queued server.
+        """
+        return self._request("GET", "/workers/stats", headers=headers)
+
+    def list_alerts(self, *, headers: Optional[Dict[str, str]] = None) -> Alerts:
+        """State of the alert rules.
+
+        Served by the direct and queued servers from API v2.
+        """
+        return self._request("GET", "/alerts", headers=headers)
+
+    def get_scaling_hint(self, *, headers: Optional[Dict[str, str]] = None) -> ScalingHint:
+        """Whether the queue is stalled, and how many worker nodes would drain it.
+
+        Served by the queued server from API v2.
+        """
+        return self._request("GET", "/scaling", headers=headers)
+
+    def get_usage(self, *, by: Optional[Literal["user", "tag", "domain"]] = None, period: Optional[Literal["daily", "monthly"]] = None, since: Optional[str] = None, until: Optional[str] = None, headers: Optional[Dict[str, str]] = None) -> UsageReport:
+        """Bytes transferred per user, tag or domain, by day or month.
+
+        Served by the direct and queued servers from API v2.
+
+        by: What the bytes are grouped by
+
+        period: Length of a row's period
+
+        since: First day reported, as 2006-01-02 in UTC; defaults to 30 days before until, or the start of the month eleven months before it
+
+        until: Last day reported, as 2006-01-02 in UTC; defaults to today
+        """
+        return self._request("GET", "/usage", query={"by": by, "period": period, "since": since, "until": until}, headers=headers)
+
+    def get_health(self, *, headers: Optional[Dict[str, str]] = None) -> HealthResponse:
+        """Health check.
+
+        Served by the direct, simple and queued servers.
+        """
+        return self._request("GET", "/health", headers=headers)
+
+
+__all__ = [
+    "ErrorResponse",
+    "FieldError",
+    "MessageResponse",
+    "HealthResponse",
+    "DependencyCheck",
+    "BuildInfo",
+    "DiskSpace",
+    "DownloadRequest",
+    "DownloadResponse",
+    "DownloadStatus",
+    "DownloadAdjustment",
+    "DownloadLimits",
+    "PartConnection",
+    "DownloadParts",
+    "SegmentMap",
+    "PartPlan",
+    "PlannedPart",
+    "DownloadEnvironment",
+    "EnvironmentRequest",
+    "EnvironmentConfig",
+    "ResolvedHost",
+    "EnvironmentServer",
+    "ByteRange",
+    "Settings",
+    "SettingsUpdate",
+    "DomainRule",
+    "DomainRules",
+    "DomainRuleMatch",
+    "AuditEntry",
+    "AuditLog",
+    "DownloadEvent",
+    "DownloadEvents",
+    "UsageRow",
+    "UsageReport",
+    "AlertStatus",
+    "Alerts",
+    "ScalingHint",
+    "Manifest",
+    "ManifestEntry",
+    "ManifestImport",
+    "ManifestImportResult",
+    "DownloadList",
+    "QueuedDownloadRequest",
+    "QueuedDownloadResponse",
+    "CachedProbe",
+    "Probe",
+    "QueuedDownloadStatus",
+    "Artifact",
+    "QueuedDownloadList",
+    "GroupDownloadRequest",
+    "GroupDownloadResponse",
+    "GroupEntry",
+    "LFSObject",
+    "GroupPreview",
+    "GroupStatus",
+    "GroupChecksums",
+    "QueuedJob",
+    "QueuedJobList",
+    "ArchivedJob",
+    "ArchivedJobList",
+    "ScheduleRequest",
+    "Schedule",
+    "ScheduleList",
+    "ScheduleRun",
+    "ScheduleRunList",
+    "GeneratedClient",
+]
@@ -0,0 +1,125 @@
+"""HTTP transport and progress helpers for the generated API methods."""
+
+from __future__ import annotations
+
+import json as jsonlib
+import time
+import urllib.error
+import urllib.request
+from typing import Any, Dict, Iterator, Optional, Union
+from urllib.parse import urlencode
+
+from ._generated import DownloadStatus, GeneratedClient, QueuedDownloadStatus
+
+# Statuses a download never leaves
+FINAL_STATUSES = ("completed", "failed")
+
+# Statuses watch stops at: a paused download only moves on once resumed
+SETTLED_STATUSES = FINAL_STATUSES + ("paused",)
+
+
+class APIError(Exception):
+    """An error response from the API."""
+
+    def __init__(self, status: int, error: str, details: str = "") -> None:
+        super().__init__(f"{status}: {error}" + (f" ({details})" if details else ""))
+        self.status = status
+        self.error = error
+        self.details = details
+
+
+class DownloadFailed(Exception):
+    """A download that wait_for watched ended in the failed status."""
+
+    def __init__(self, status: Union[DownloadStatus, QueuedDownloadStatus]) -> None:
+        error = status.get("error") or status.get("error_message") or "unknown error"
+        super().__init__(f"download failed: {error}")
+        self.status = status
+
+
+class Client(GeneratedClient):
+    """Client for the direct, simple and queued servers.
+
+    Every API operation is a method; the docstring of each names the servers
+    that implement it. Requests go to /api/<api_version> under base_url.
+    """
+
+    def __init__(self, base_url: str = "http://localhost:8080", *, api_version: str = "v2",
+                 headers: Optional[Dict[str, str]] = None, timeout: float = 90.0) -> None:
+        # The timeout must outlast the longest long-poll, 60 seconds
+        self.base_url = base_url.rstrip("/") + "/api/" + api_version
+        self.headers = dict(headers or {})
+        self.timeout = timeout
+
+    def _request(self, method: str, path: str, *, query: Optional[Dict[str, Any]] = None,
+                 json: Any = None, text: Optional[str] = None, binary: bool = False,
+                 headers: Optional[Dict[str, str]] = None) -> Any:
+        url = self.base_url + path
+        params = {name: value for name, value in (query or {}).items() if value is not None}
+        if params:
+            url += "?" + urlencode(params)
+
+        data = None
+        request_headers = {"Accept": "application/octet-stream" if binary else "application/json"}
+        request_headers.update(self.headers)
+        if json is not None:
+            data = jsonlib.dumps(json).encode()
+            request_headers["Content-Type"] = "application/json"
+        elif text is not None:
+            data = text.encode()
+            request_headers["Content-Type"] = "text/plain"
+        request_headers.update(headers or {})
+
+        request = urllib.request.Request(url, data=data, method=method, headers=request_headers)
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout) as response:
+                body = response.read()
+        except urllib.error.HTTPError as err:
+            raise _api_error(err.code, err.read()) from None
+
+        if binary:
+            return body
+        return jsonlib.loads(body) if body else None
+
+    def watch(self, download_id: str, *, wait: str = "30s", min_progress: float = 1.0,
+              interval: float = 1.0) -> Iterator[Union[DownloadStatus, QueuedDownloadStatus]]:
+        """Yield the status of a download each time it changes, until it completes, fails or is paused.
+
+        Each request long-polls for up to wait, returning once the status
+        changes or progress moves by min_progress percentage points. Requests
+        are at least interval seconds apart, so servers that answer at once
+        are not polled in a tight loop.
+        """
+        last = None
+        while True:
+            started = time.monotonic()
+            status = self.get_download_status(download_id, wait=wait, min_progress=min_progress)
+            seen = (status.get("status"), status.get("bytes_downloaded"))
+            if seen != last:
+                last = seen
+                yield status
+            if status.get("status") in SETTLED_STATUSES:
+                return
+            elapsed = time.monotonic() - started
+            if elapsed < interval:
+                time.sleep(interval - elapsed)
+
+    def wait_for(self, download_id: str, **watch_options: Any) -> Union[DownloadStatus, QueuedDownloadStatus]:
+        """Return the status a download settles in, raising DownloadFailed if it failed.
+
+        A paused download is returned as it is rather than waited on, since
+        it does not move on until resumed; check the status of the result.
+        """
+        for status in self.watch(download_id, **watch_options):
+            pass
+        if status.get("status") == "failed":
+            raise DownloadFailed(status)
+        return status
+
+
+def _api_error(status: int, body: bytes) -> APIError:
+    try:
+        payload = jsonlib.loads(body)
+        return APIError(status, payload.get("error", ""), payload.get("details", ""))
+    except (ValueError, AttributeError):
+        return APIError(status, body.decode(errors="replace"))
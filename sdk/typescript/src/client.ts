@@ -0,0 +1,159 @@
+import { ApiRequest, DownloadStatus, GeneratedClient, QueuedDownloadStatus } from "./generated";
+
+/** Statuses a download never leaves */
+export const FINAL_STATUSES = ["completed", "failed"];
+
+/** Statuses watch stops at: a paused download only moves on once resumed */
+export const SETTLED_STATUSES = [...FINAL_STATUSES, "paused"];
+
+/** An error response from the API */
+export class APIError extends Error {
+  constructor(
+    readonly status: number,
+    readonly error: string,
+    readonly details = "",
+  ) {
+    super(`${status}: ${error}${details ? ` (${details})` : ""}`);
+    this.name = "APIError";
+  }
+}
+
+/** A download that waitFor watched ended in the failed status */
+export class DownloadFailed extends Error {
+  constructor(readonly download: DownloadStatus | QueuedDownloadStatus) {
+    const error = ("error" in download && download.error) || ("error_message" in download && download.error_message);
+    super(`download failed: ${error || "unknown error"}`);
+    this.name = "DownloadFailed";
+  }
+}
+
+export interface ClientOptions {
+  /** API version the requests use; defaults to v2 */
+  apiVersion?: string;
+  /** Headers sent with every request */
+  headers?: Record<string, string>;
+  /** fetch implementation; defaults to the global one */
+  fetch?: typeof fetch;
+}
+
+export interface WatchOptions {
+  /** How long each request long-polls; defaults to 30s, up to 60s */
+  wait?: string;
+  /** Percentage points of progress that end a long-poll; defaults to 1 */
+  minProgress?: number;
+  /** Least milliseconds between requests, for servers that answer at once */
+  intervalMs?: number;
+  signal?: AbortSignal;
+}
+
+/**
+ * Client for the direct, simple and queued servers. Every API operation is a
+ * method; the doc comment of each names the servers that implement it.
+ */
+export class Client extends GeneratedClient {
+  private readonly baseURL: string;
+  private readonly headers: Record<string, string>;
+  private readonly fetch: typeof fetch;
+
+  constructor(baseURL = "http://localhost:8080", options: ClientOptions = {}) {
+    super();
+    this.baseURL = `${baseURL.replace(/\/+$/, "")}/api/${options.apiVersion ?? "v2"}`;
+    this.headers = { ...options.headers };
+    this.fetch = options.fetch ?? fetch;
+  }
+
+  protected async request<T>(req: ApiRequest): Promise<T> {
+    let url = this.baseURL + req.path;
+    const params = new URLSearchParams();
+    for (const [name, value] of Object.entries(req.query ?? {})) {
+      if (value !== undefined) {
+        params.set(name, String(value));
+      }
+    }
+    if (params.toString() !== "") {
+      url += `?${params}`;
+    }
+
+    const headers: Record<string, string> = {
+      Accept: req.binary ? "application/octet-stream" : "application/json",
+      ...this.headers,
+    };
+    let body: string | undefined;
+    if (req.json !== undefined) {
+      body = JSON.stringify(req.json);
+      headers["Content-Type"] = "application/json";
+    } else if (req.text !== undefined) {
+      body = req.text;
+      headers["Content-Type"] = "text/plain";
+    }
+    Object.assign(headers, req.options?.headers);
+
+    const response = await this.fetch(url, { method: req.method, headers, body, signal: req.options?.signal });
+    if (!response.ok) {
+      const text = await response.text();
+      let payload: { error?: string; details?: string } | undefined;
+      try {
+        payload = JSON.parse(text);
+      } catch {
+        payload = undefined;
+      }
+      if (payload === undefined || typeof payload !== "object") {
+        throw new APIError(response.status, text);
+      }
+      throw new APIError(response.status, payload.error ?? "", payload.details ?? "");
+    }
+    if (req.binary) {
+      return (await response.arrayBuffer()) as T;
+    }
+    const text = await response.text();
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+
+  /**
+   * Yields the status of a download each time it changes, until it completes,
+   * fails or is paused. Each request long-polls, returning once the status changes or
+   * progress moves by minProgress percentage points.
+   */
+  async *watch(id: string, options: WatchOptions = {}): AsyncGenerator<DownloadStatus | QueuedDownloadStatus> {
+    const query = { wait: options.wait ?? "30s", min_progress: options.minProgress ?? 1 };
+    const intervalMs = options.intervalMs ?? 1000;
+    let last = "";
+    for (;;) {
+      const started = Date.now();
+      const status: DownloadStatus | QueuedDownloadStatus = await this.getDownloadStatus(id, query, {
+        signal: options.signal,
+      });
+      const seen = `${status.status}/${status.bytes_downloaded}`;
+      if (seen !== last) {
+        last = seen;
+        yield status;
+      }
+      if (SETTLED_STATUSES.includes(status.status)) {
+        return;
+      }
+      const elapsed = Date.now() - started;
+      if (elapsed < intervalMs) {
+        await new Promise((resolve) => setTimeout(resolve, intervalMs - elapsed));
+      }
+    }
+  }
+
+  /**
+   * Resolves to the status a download settles in, rejecting with
+   * DownloadFailed if it failed. A paused download resolves as it is rather
+   * than being waited on, since it does not move on until resumed.
+   */
+  async waitFor(id: string, options: WatchOptions = {}): Promise<DownloadStatus | QueuedDownloadStatus> {
+    let final: DownloadStatus | QueuedDownloadStatus | undefined;
+    for await (const status of this.watch(id, options)) {
+      final = status;
+    }
+    if (final === undefined) {
+      throw new Error("download status was never read");
+    }
+    if (final.status === "failed") {
+      throw new DownloadFailed(final);
+    }
+    return final;
+  }
+}
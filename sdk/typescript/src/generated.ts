@@ -0,0 +1,1400 @@
+// Code generated by openapi/gen from openapi.json. DO NOT EDIT.
+
+/** ErrorResponse is returned with every 4xx and 5xx response */
+export interface ErrorResponse {
+  error: string;
+  details?: string;
+  /** Fields of a request body that are not valid, each with what is wrong with it; set only with 400 Invalid request body */
+  fields?: FieldError[];
+}
+
+/** FieldError is a request field that breaks a constraint of the document */
+export interface FieldError {
+  /** JSON name of the field, with an index for array items such as part_urls[1] */
+  field: string;
+  message: string;
+}
+
+/** MessageResponse acknowledges an action that returns no other data */
+export interface MessageResponse {
+  message: string;
+}
+
+/** HealthResponse reports whether a server and its dependencies are up */
+export interface HealthResponse {
+  status: "healthy" | "unhealthy";
+  timestamp: string;
+  version: string;
+  /** Health of each dependency, reported by the queued server */
+  checks?: Record<string, boolean>;
+  /** Result and latency of each dependency check: redis and database on the queued server, filesystem where downloads are written */
+  dependencies?: Record<string, DependencyCheck>;
+  build?: BuildInfo;
+  uptime_seconds?: number;
+  goroutines?: number;
+  /** Space left where downloads are written, on servers that write them */
+  disk?: DiskSpace;
+}
+
+/** DependencyCheck is the result of checking one dependency of a server */
+export interface DependencyCheck {
+  healthy: boolean;
+  /** How long the check took, in milliseconds */
+  latency_ms: number;
+  error?: string;
+}
+
+/** BuildInfo describes the build of a server */
+export interface BuildInfo {
+  /** Release the binary was built from, set with -ldflags; dev otherwise */
+  version: string;
+  commit: string;
+  build_time?: string;
+  go_version: string;
+}
+
+/** DiskSpace is the space of the file system holding a directory */
+export interface DiskSpace {
+  path: string;
+  /** Bytes available to the server's user */
+  free_bytes: number;
+  total_bytes: number;
+}
+
+/** DownloadRequest represents the JSON request body for starting a download */
+export interface DownloadRequest {
+  /** Absolute http or https URL of the file */
+  url: string;
+  /** Output path; may use template variables such as {date}/{domain}/{filename}. It must be UTF-8 without control characters, at most 4096 bytes with names of at most 255, name a file rather than a directory, and be relative to the download directory without ".." names */
+  output: string;
+  threads?: number;
+  user_agent?: string;
+  user_agent_profile?: string;
+  referer?: string;
+  /** HTTP method the file is requested with; defaults to GET, or POST when a body is given. Downloads other than a plain GET run as a single stream */
+  method?: "GET" | "POST" | "PUT" | "PATCH" | "DELETE";
+  /** Request body sent with method, for endpoints that export files only to a POST */
+  body?: string;
+  /** Encoding of body; defaults to form */
+  body_type?: "form" | "json";
+  /** Pieces of a file published split into several URLs, such as file.z01 and file.z02, in order; they are downloaded in parallel and joined into output as one download. url must be the first of them */
+  part_urls?: string[];
+  /** Other URLs serving the same file. Each is probed with a small range request, and parts are spread over the fastest mirror_count of them, re-probed during long downloads; mirrors announced with Link rel=duplicate headers are added. Headers are sent to url's host only */
+  mirrors?: string[];
+  /** How many of the fastest mirrors parts are spread over; 0 picks 3 */
+  mirror_count?: number;
+  /** Labels the bytes the download transfers are accounted to in GET /usage, e.g. a team or project; letters, digits and . _ : -, starting with a letter or digit */
+  tags?: string[];
+  /** Endpoint POSTed {"url": <expired link>} when the server refuses the link as expired, e.g. a pre-signed S3 URL; it answers with a new link, as JSON {"url": ...} or text, and the download continues with it */
+  refresh_url?: string;
+  /** Download the first and last this many bytes before the rest, e.g. for a zip's central directory or an MP4's moov atom; read them from GET /downloads/{id}/preview */
+  preview_bytes?: number;
+  /** Network interface such as eth1, or local IP address, the download connects from, on hosts with several networks */
+  bind?: string;
+  /** Bytes per second shared by all downloads bound to the same interface or address; 0 is unlimited */
+  bind_rate_limit?: number;
+  /** Write <output>.done, a JSON marker with the file's size, SHA-256 and validators, through a rename once the download is verified, so pipelines watching the directory can tell a finished file from one still growing; an older marker is removed when the download starts */
+  done_marker?: boolean;
+  /** Split the file into exactly these parts, as GET /downloads/{id}/plan exported them, fetching each from the server it names, instead of splitting it by threads; the plan must cover the file the server has. Not with preview_bytes */
+  plan?: PartPlan;
+}
+
+/** DownloadResponse represents the response when starting a download */
+export interface DownloadResponse {
+  download_id: string;
+  message: string;
+}
+
+/** DownloadStatus represents the current status of a download */
+export interface DownloadStatus {
+  download_id: string;
+  url: string;
+  filename: string;
+  status: "waiting" | "queued" | "downloading" | "waiting_network" | "paused" | "completed" | "not_modified" | "failed";
+  percent_completed: number;
+  bytes_downloaded: number;
+  total_size: number;
+  /** Parts the file was split into; fewer than asked for when parts would fall below the minimum part size */
+  threads_used: number;
+  /** Threads the download asked for */
+  threads_requested?: number;
+  /** Result of checking the finished file against a checksum the server sent in Content-MD5, Digest, Repr-Digest or Content-Digest; absent when it sent none */
+  checksum_status?: "verified" | "mismatch";
+  /** Algorithm of the checksum checked: md5, sha-1, sha-256 or sha-512 */
+  checksum_algorithm?: string;
+  /** Header or trailer the checksum was read from */
+  checksum_source?: string;
+  start_time: string;
+  error?: string;
+  /** ThrottledByServer is set while the origin has asked us to back off via Retry-After */
+  throttled_by_server: boolean;
+  throttled_until?: string;
+  /** Bytes per second over the last few seconds, from the live progress events */
+  current_speed: number;
+  /** Parts transferring right now */
+  active_connections: number;
+  /** Set once the head and tail asked for with preview_bytes are downloaded, or the whole file without them */
+  preview_ready?: boolean;
+  /** When the download was last seen receiving data */
+  last_byte_at?: string;
+}
+
+/** DownloadAdjustment changes the limits of a running download; omitted fields keep their value */
+export interface DownloadAdjustment {
+  /** Bytes per second across all threads; 0 lifts the limit */
+  rate_limit?: number | null;
+  /** Threads transferring at once, at most the number the download started with */
+  threads?: number | null;
+}
+
+/** DownloadLimits reports the limits a running download uses */
+export interface DownloadLimits {
+  download_id: string;
+  rate_limit: number;
+  threads: number;
+  active_threads: number;
+}
+
+/** PartConnection describes a part of a download and the connection it is downloading over */
+export interface PartConnection {
+  index: number;
+  start: number;
+  end: number;
+  /** Bytes of the part written so far */
+  downloaded: number;
+  state: "pending" | "connecting" | "transferring" | "retrying" | "done";
+  /** Address of the server the part's connection goes to */
+  remote_addr?: string;
+  /** Bytes received over the current connection */
+  bytes: number;
+  /** Average bytes per second over the current connection */
+  speed: number;
+  /** When the current request was sent */
+  started_at?: string;
+  /** When the part last received bytes or changed state */
+  last_activity?: string;
+  /** Requests sent for the part since the download started or resumed */
+  attempts: number;
+  last_error?: string;
+}
+
+/** DownloadParts lists the parts of a running download with their connections */
+export interface DownloadParts {
+  download_id: string;
+  parts: PartConnection[];
+}
+
+/** SegmentMap shows which byte ranges of a download are complete */
+export interface SegmentMap {
+  download_id: string;
+  total_size: number;
+  /** Bytes of each piece; the last one may be shorter */
+  piece_size: number;
+  pieces: number;
+  /** Base64 of one bit per piece, set once every byte of the piece is downloaded; the first piece is the high bit of the first byte, as in a BitTorrent bitfield */
+  bitmap: string;
+  /** Downloaded byte ranges in order, neighbouring ranges merged */
+  segments: ByteRange[];
+  /** Byte ranges parts are requesting or transferring now */
+  active: ByteRange[];
+  downloaded_bytes: number;
+  /** Bytes downloaded from the start of the file without a gap, how much of a sequential download can be played */
+  contiguous_bytes: number;
+}
+
+/** PartPlan is the exact split of a download into parts and the server each part is fetched from */
+export interface PartPlan {
+  version: number;
+  /** URL of the file, credentials redacted */
+  url: string;
+  total_size: number;
+  /** Version of the file the plan was made for; a download of another version uses the plan with a warning */
+  etag?: string;
+  parts: PlannedPart[];
+}
+
+/** PlannedPart is one part of a plan: the inclusive byte range it covers and where it comes from */
+export interface PlannedPart {
+  index: number;
+  start: number;
+  end: number;
+  /** The piece of a joined file holding the part, or the URL or mirror the part is pinned to; without one a download with mirrors gives the part to the fastest */
+  source?: string;
+}
+
+/** DownloadEnvironment records everything that decided how a download ran, credentials redacted */
+export interface DownloadEnvironment {
+  download_id: string;
+  version: number;
+  captured_at: string;
+  /** When the download was verified; unset before */
+  completed_at?: string;
+  /** Go runtime of the process that ran the download */
+  go: string;
+  /** os/arch of the process that ran the download */
+  platform: string;
+  url: string;
+  /** URLs the probe was redirected from, in order, before final_url */
+  redirects?: string[];
+  final_url?: string;
+  /** File name of the output */
+  output: string;
+  /** The download continued saved progress without a new probe */
+  resumed?: boolean;
+  request: EnvironmentRequest;
+  config: EnvironmentConfig;
+  resolved?: ResolvedHost[];
+  server?: EnvironmentServer;
+  total_size: number;
+  parts: number;
+  etag?: string;
+  last_modified?: string;
+  /** Server addresses the parts were fetched from, once the download is verified */
+  part_addresses?: string[];
+}
+
+/** EnvironmentRequest is the request a download sends, sensitive header values redacted */
+export interface EnvironmentRequest {
+  method: string;
+  headers?: Record<string, string>;
+  user_agent: string;
+  /** User-Agents sent in turn instead of user_agent */
+  user_agents?: string[];
+  referer?: string;
+  content_type?: string;
+  body_bytes?: number;
+  /** Cookies from a cookie jar were sent */
+  cookies?: boolean;
+}
+
+/** EnvironmentConfig are the effective settings of a download */
+export interface EnvironmentConfig {
+  threads: number;
+  requested_threads: number;
+  min_part_size: number;
+  /** Bytes per second of the download; 0 is unlimited */
+  rate_limit: number;
+  /** Bytes per second the download shared with others, such as the server-wide cap */
+  shared_rate_limit?: number;
+  bind?: string;
+  bind_rate_limit?: number;
+  http2: boolean;
+  encrypted?: boolean;
+  sequential?: boolean;
+  preview_bytes?: number;
+  jitter?: string;
+  mirrors?: string[];
+  mirror_count?: number;
+  part_urls?: string[];
+  /** Failed attempts in a row before a part gives up; 0 retries until stopped */
+  retry_attempts: number;
+  retry_delay: string;
+  checksum_retries: number;
+  expected_size?: number;
+  max_threads?: number;
+  max_buffer_memory?: number;
+  /** The parts came from an imported part plan */
+  plan?: boolean;
+  /** An existing output file without saved progress was continued */
+  continue_file?: boolean;
+  only_if_modified?: boolean;
+  cache?: boolean;
+}
+
+/** ResolvedHost is what the resolver answered for a host the probe connected to, and the address it connected to */
+export interface ResolvedHost {
+  /** host:port */
+  host: string;
+  /** Addresses the resolver answered; none for IP literals and reused connections */
+  addresses?: string[];
+  connected?: string;
+}
+
+/** EnvironmentServer is the server's answer to a download's probe, sensitive header values redacted */
+export interface EnvironmentServer {
+  status?: string;
+  proto?: string;
+  supports_ranges: boolean;
+  headers?: Record<string, string>;
+  probed_at: string;
+  /** The answer came from the probe cache, which keeps no headers */
+  cached?: boolean;
+}
+
+/** ByteRange is the bytes from start to end of a file, both included */
+export interface ByteRange {
+  start: number;
+  end: number;
+}
+
+/** Settings are the runtime settings of a server */
+export interface Settings {
+  /** Bytes per second of all downloads together; 0 is unlimited */
+  global_rate_limit: number;
+  /** Downloads transferring at once, more wait as queued; 0 is unlimited */
+  max_concurrent_downloads: number;
+  /** Threads for downloads that do not ask for a count */
+  default_threads: number;
+  /** Days completed downloads stay in the database */
+  retention_days: number;
+  /** Smallest part in bytes a file is split into, so small files use fewer threads; 0 splits into as many parts as asked for */
+  min_part_size: number;
+}
+
+/** SettingsUpdate changes runtime settings; omitted fields keep their value */
+export interface SettingsUpdate {
+  global_rate_limit?: number | null;
+  max_concurrent_downloads?: number | null;
+  default_threads?: number | null;
+  retention_days?: number | null;
+  min_part_size?: number | null;
+}
+
+/** DomainRule sets defaults for downloads from hosts matching a domain pattern; whatever a download asks for itself wins */
+export interface DomainRule {
+  /** Hosts the rule applies to: example.com for that host, *.example.com for its subdomains, * for every host */
+  match: string;
+  /** Threads for downloads that do not ask for a count */
+  threads?: number;
+  /** Bytes per second of each download; 0 is unlimited */
+  rate_limit?: number;
+  /** Request headers such as an access token; values are redacted when rules are listed */
+  headers?: Record<string, string>;
+  /** Netscape cookies.txt file on the host running the download */
+  cookies_file?: string;
+  /** Failed attempts in a row after which a part gives up and the download fails; 0 retries until it is stopped */
+  max_attempts?: number;
+  /** Pause before a failed attempt is retried, e.g. 5s; defaults to 1s */
+  retry_delay?: string;
+  /** User-Agents sent in turn, one per request, by downloads that do not ask for their own; each is a profile name such as chrome or a full User-Agent */
+  user_agents?: string[];
+  /** Most a random delay before every request may be, e.g. 500ms, so requests do not arrive at regular intervals; at most 10s */
+  jitter?: string;
+  /** Network interface such as eth1, or local IP address, that downloads which do not name their own connect from */
+  bind?: string;
+  /** Bytes per second shared by all downloads bound to the same interface or address; 0 is unlimited */
+  bind_rate_limit?: number;
+}
+
+/** DomainRules lists the per-domain download defaults; more specific patterns override less specific ones */
+export interface DomainRules {
+  rules: DomainRule[];
+}
+
+/** DomainRuleMatch is what the domain rules give a download from a URL */
+export interface DomainRuleMatch {
+  url: string;
+  /** Patterns of the matching rules, least specific first */
+  matched: string[];
+  threads?: number;
+  rate_limit?: number;
+  headers?: Record<string, string>;
+  cookies_file?: string;
+  max_attempts?: number;
+  retry_delay?: string;
+  /** User-Agents sent in turn, with profile names resolved */
+  user_agents?: string[];
+  jitter?: string;
+  bind?: string;
+  bind_rate_limit?: number;
+}
+
+/** AuditEntry is one change made through the API */
+export interface AuditEntry {
+  id: number;
+  time: string;
+  /** Client address the change came from */
+  actor: string;
+  /** What changed, e.g. settings.update */
+  action: string;
+  /** The object that changed, e.g. a setting name */
+  target: string;
+  old_value: string;
+  new_value: string;
+}
+
+/** AuditLog lists recent audit entries, newest first */
+export interface AuditLog {
+  entries: AuditEntry[];
+  count: number;
+}
+
+/** DownloadEvent is one state change of a download */
+export interface DownloadEvent {
+  id: number;
+  type: "created" | "started" | "paused" | "completed" | "failed" | "requeued";
+  /** Status the download moved to */
+  status?: string;
+  time: string;
+  /** Server or worker process the change happened on */
+  node: string;
+  /** Who caused the change on the node: the client address of the request, the worker or the schedule; empty when the node acted on its own */
+  actor?: string;
+  /** Why the download was requeued */
+  reason?: string;
+  error?: string;
+  /** Bytes downloaded by then, when known */
+  bytes_downloaded?: number;
+}
+
+/** DownloadEvents lists the state changes of a download, oldest first */
+export interface DownloadEvents {
+  download_id: string;
+  events: DownloadEvent[];
+  count: number;
+}
+
+/** UsageRow is the bytes charged to one key over one period */
+export interface UsageRow {
+  /** The day as 2006-01-02 or the month as 2006-01 */
+  period: string;
+  /** The user, tag or domain; empty for downloads nobody started through the API, e.g. scheduled ones */
+  key: string;
+  bytes: number;
+  /** bytes at cost_per_gb, when it is set */
+  estimated_cost?: number;
+}
+
+/** UsageReport sums the bytes transferred by period and key */
+export interface UsageReport {
+  by: "user" | "tag" | "domain";
+  period: "daily" | "monthly";
+  since: string;
+  until: string;
+  /** Ordered by period, then by bytes, most first */
+  rows: UsageRow[];
+  /** The bytes of all rows; by tag, bytes of a download with several tags count once per tag */
+  total_bytes: number;
+  /** USAGE_COST_PER_GB, the cost of 10^9 bytes, when it is set */
+  cost_per_gb?: number;
+  /** total_bytes at cost_per_gb, when it is set */
+  estimated_cost?: number;
+}
+
+/** AlertStatus is an alert rule and where it stands */
+export interface AlertStatus {
+  name: string;
+  metric: "queue_depth" | "running_downloads" | "average_speed" | "total_speed" | "failure_rate" | "queue_wait_seconds";
+  op: ">" | ">=" | "<" | "<=";
+  threshold: number;
+  /** How long the condition must hold before the rule fires */
+  for_seconds: number;
+  severity?: string;
+  description?: string;
+  state: "ok" | "pending" | "firing";
+  /** The metric at the last evaluation; absent when it had no value, e.g. the average speed while nothing runs */
+  value?: number | null;
+  /** When the condition started holding */
+  since?: string;
+  fired_at?: string;
+}
+
+/** Alerts lists the alert rules of a server */
+export interface Alerts {
+  alerts: AlertStatus[];
+  /** How many rules are firing */
+  firing: number;
+  /** Absent until the rules were first evaluated */
+  evaluated_at?: string;
+}
+
+/** ScalingHint is the stalled-queue watchdog's last sample of the queue and the worker nodes it suggests */
+export interface ScalingHint {
+  /** Absent until the watchdog took its first sample */
+  observed_at?: string;
+  /** How long the queue must grow with idle workers to count as stalled */
+  window_seconds: number;
+  /** Bytes per second of all jobs together at or under which the workers count as idle */
+  idle_speed: number;
+  /** Jobs in the main queue */
+  queue_depth: number;
+  running_downloads: number;
+  /** Bytes per second of all running jobs together */
+  total_speed: number;
+  /** Registered worker nodes */
+  worker_nodes: number;
+  /** Jobs the registered worker nodes run at once together */
+  worker_slots: number;
+  /** How much the queue grew over the window; absent until the watchdog has watched for a whole window */
+  queue_growth?: number | null;
+  /** Jobs that completed or failed within the window; absent like queue_growth */
+  finished?: number | null;
+  stalled: boolean;
+  stalled_since?: string;
+  /** Worker nodes that would run every queued and running job at once, between SCALE_MIN_WORKERS and SCALE_MAX_WORKERS; one more than there are while the queue is stalled */
+  desired_workers: number;
+}
+
+/** Manifest is a portable list of download definitions exported from one server to be imported into another */
+export interface Manifest {
+  /** Manifest format version */
+  version: number;
+  exported_at: string;
+  downloads: ManifestEntry[];
+}
+
+/** ManifestEntry is one exported download: the request that starts it again and what happened to it on the exporting server */
+export interface ManifestEntry {
+  request: DownloadRequest;
+  /** ID on the exporting server */
+  id?: string;
+  /** Status on the exporting server */
+  status?: string;
+  error?: string;
+  /** Algorithm of the checksum the origin sent, if it sent one */
+  checksum_algorithm?: string;
+  /** Header the checksum came from */
+  checksum_source?: string;
+}
+
+/** ManifestImport reports what became of every entry of an imported manifest, in manifest order */
+export interface ManifestImport {
+  results: ManifestImportResult[];
+  imported: number;
+  skipped: number;
+  failed: number;
+}
+
+/** ManifestImportResult is what became of one manifest entry */
+export interface ManifestImportResult {
+  /** URL of the entry, with credentials redacted */
+  url: string;
+  /** skipped entries are already waiting or running on this server */
+  result: "imported" | "skipped" | "failed";
+  /** The new download, or the one already running for skipped entries */
+  download_id?: string;
+  error?: string;
+}
+
+/** DownloadList lists every download known to a server, the most recently active first */
+export interface DownloadList {
+  downloads: DownloadStatus[];
+  count: number;
+}
+
+/** QueuedDownloadRequest represents the JSON request body for starting a queued download */
+export interface QueuedDownloadRequest {
+  /** Absolute http or https URL of the file */
+  url: string;
+  /** Output path; may use template variables such as {date}/{domain}/{filename}. It must be UTF-8 without control characters, at most 4096 bytes with names of at most 255, name a file rather than a directory, and be relative to the download directory without ".." names */
+  output: string;
+  threads?: number;
+  depends_on?: string[];
+  user_agent?: string;
+  user_agent_profile?: string;
+  referer?: string;
+  /** Headers are extra request headers, e.g. Authorization; they are sealed before storage */
+  headers?: Record<string, string>;
+  /** HTTP method the file is requested with; defaults to GET, or POST when a body is given. Downloads other than a plain GET run as a single stream */
+  method?: "GET" | "POST" | "PUT" | "PATCH" | "DELETE";
+  /** Request body sent with method, for endpoints that export files only to a POST */
+  body?: string;
+  /** Encoding of body; defaults to form */
+  body_type?: "form" | "json";
+  /** Pieces of a file published split into several URLs, such as file.z01 and file.z02, in order; they are downloaded in parallel and joined into output as one download. url must be the first of them */
+  part_urls?: string[];
+  /** Other URLs serving the same file. Each is probed with a small range request, and parts are spread over the fastest mirror_count of them, re-probed during long downloads; mirrors announced with Link rel=duplicate headers are added. Headers are sent to url's host only */
+  mirrors?: string[];
+  /** How many of the fastest mirrors parts are spread over; 0 picks 3 */
+  mirror_count?: number;
+  /** Labels the bytes the download transfers are accounted to in GET /usage, e.g. a team or project; letters, digits and . _ : -, starting with a letter or digit */
+  tags?: string[];
+  /** Endpoint POSTed {"url": <expired link>} when the server refuses the link as expired, e.g. a pre-signed S3 URL; it answers with a new link, as JSON {"url": ...} or text, and the download continues with it */
+  refresh_url?: string;
+  /** Download only when the remote file changed since the file at output_path was downloaded, asking with If-None-Match and If-Modified-Since; otherwise the job ends with status not_modified */
+  only_if_modified?: boolean;
+  /** Network interface such as eth1, or local IP address, of the worker host the job connects from */
+  bind?: string;
+  /** Bytes per second shared by all jobs a worker binds to the same interface or address; 0 is unlimited */
+  bind_rate_limit?: number;
+  /** Write <output>.done, a JSON marker with the file's size, SHA-256 and validators, through a rename once the download is verified, so pipelines watching the directory can tell a finished file from one still growing; an older marker is removed when the download starts */
+  done_marker?: boolean;
+  /** Split the file into exactly these parts, as GET /downloads/{id}/plan exported them, fetching each from the server it names, instead of splitting it by threads; the plan must cover the file the server has */
+  plan?: PartPlan;
+}
+
+/** QueuedDownloadResponse represents the response when enqueueing a download */
+export interface QueuedDownloadResponse {
+  job_id: string;
+  message: string;
+  status: "waiting" | "queued";
+  /** What an earlier download of the same URL learned about the file, when it is recent enough that the worker reuses it instead of probing the server */
+  probe?: CachedProbe;
+}
+
+/** CachedProbe is a range check of a URL kept for PROBE_CACHE_TTL */
+export interface CachedProbe {
+  supports_ranges: boolean;
+  size_bytes: number;
+  etag?: string;
+  last_modified?: string;
+  probed_at: string;
+}
+
+/** Probe is what a range check learned about the file at a URL */
+export interface Probe {
+  supports_ranges: boolean;
+  size_bytes: number;
+  etag?: string;
+  last_modified?: string;
+  /** Where redirects led, credentials redacted */
+  final_url: string;
+  /** The Accept-Ranges header as the server sent it */
+  accept_ranges?: string;
+  /** The Server header */
+  server?: string;
+  probed_at: string;
+}
+
+/** QueuedDownloadStatus represents the current status of a queued download */
+export interface QueuedDownloadStatus {
+  job_id: string;
+  url: string;
+  output_path: string;
+  /** API v1 reports downloading jobs as processing */
+  status: "waiting" | "queued" | "downloading" | "waiting_network" | "paused" | "completed" | "not_modified" | "failed" | "processing";
+  progress: number;
+  bytes_downloaded: number;
+  total_bytes: number;
+  /** Parts the file was split into; fewer than asked for when parts would fall below the minimum part size */
+  threads_used: number;
+  /** Threads the job asked for */
+  threads_requested?: number;
+  /** Result of checking the finished file against a checksum the server sent in Content-MD5, Digest, Repr-Digest or Content-Digest; absent when it sent none */
+  checksum_status?: "verified" | "mismatch";
+  /** Algorithm of the checksum checked: md5, sha-1, sha-256 or sha-512 */
+  checksum_algorithm?: string;
+  /** Header or trailer the checksum was read from */
+  checksum_source?: string;
+  created_at: string;
+  started_at?: string;
+  completed_at?: string;
+  worker_id?: string;
+  error_message?: string;
+  depends_on?: string[];
+  throttled_by_server: boolean;
+  /** Bytes per second over the last few seconds, from the live progress events */
+  current_speed: number;
+  /** Parts transferring right now */
+  active_connections: number;
+  /** When the download was last seen receiving data */
+  last_byte_at?: string;
+  /** Files the job produced; empty until it completes */
+  artifacts?: Artifact[];
+}
+
+/** Artifact describes a file a job produced and where it is stored */
+export interface Artifact {
+  /** Absolute path of the file on the node that stored it */
+  path: string;
+  /** Size of the file as stored */
+  size_bytes: number;
+  /** Hex digest of the file as stored */
+  checksum: string;
+  checksum_algorithm: "sha-256";
+  /** Storage the file was written to; local is the worker's filesystem */
+  backend: "local";
+  /** Worker node that wrote the file */
+  node?: string;
+  /** The file is stored encrypted with the workers' encryption key, so checksum and size are of the ciphertext */
+  encrypted: boolean;
+  created_at: string;
+}
+
+/** QueuedDownloadList lists every job recorded by the queued server, the most recently active first */
+export interface QueuedDownloadList {
+  downloads: QueuedDownloadStatus[];
+  count: number;
+}
+
+/** GroupDownloadRequest represents the JSON request body for creating a download group */
+export interface GroupDownloadRequest {
+  /** Exactly one of url_template, page_url or repo_url must be set */
+  url_template?: string;
+  page_url?: string;
+  pattern?: string;
+  /** Leave out the links of page_url that its site's robots.txt disallows for the group's User-Agent */
+  polite?: boolean;
+  /** An hf://[datasets/|spaces/]org/name[@revision][/path] Hugging Face repo whose files are downloaded, or with lfs_objects the Git repository whose LFS server serves them */
+  repo_url?: string;
+  /** The objects of Git LFS pointer files, saved at their paths under output_dir */
+  lfs_objects?: LFSObject[];
+  /** Directory the files are saved in, relative to the download directory and without ".." names */
+  output_dir?: string;
+  threads?: number;
+  user_agent?: string;
+  user_agent_profile?: string;
+  referer?: string;
+  headers?: Record<string, string>;
+}
+
+/** GroupDownloadResponse represents the response when enqueueing a download group */
+export interface GroupDownloadResponse {
+  group_id: string;
+  job_ids: string[];
+  count: number;
+  message: string;
+}
+
+/** GroupEntry is a single URL and the path it will be saved to */
+export interface GroupEntry {
+  url: string;
+  output_path: string;
+  /** Bytes the file must have, when the group's listing gave them */
+  size?: number;
+  /** Hex SHA-256 the file must have, when the group's listing gave it */
+  sha256?: string;
+}
+
+/** LFSObject is the object a Git LFS pointer file stands for */
+export interface LFSObject {
+  /** Slash-separated path of the pointer in the repository */
+  path: string;
+  /** Hex SHA-256 of the object, from the pointer's oid sha256: line */
+  oid: string;
+  size: number;
+}
+
+/** GroupPreview lists the entries a group request resolves to */
+export interface GroupPreview {
+  downloads: GroupEntry[];
+  count: number;
+}
+
+/** GroupStatus reports the status of every job in a group */
+export interface GroupStatus {
+  group_id: string;
+  downloads: QueuedDownloadStatus[];
+  /** Number of jobs in each status */
+  summary: Record<string, number>;
+  count: number;
+  /** Set once the group finished and its SHA256SUMS file was written */
+  checksums?: GroupChecksums;
+}
+
+/** GroupChecksums describes the SHA256SUMS file written once a group finished */
+export interface GroupChecksums {
+  /** Number of files listed */
+  files: number;
+  signed: boolean;
+  /** PEM public key that checks the signature */
+  public_key?: string;
+  /** Directory on node holding the files and SHA256SUMS; empty when the files are spread over nodes */
+  dir?: string;
+  node?: string;
+  generated_at: string;
+}
+
+/** QueuedJob describes a job waiting in the main queue or in the queue of a worker node */
+export interface QueuedJob {
+  /** Place in its queue; 1 is the job a worker takes next */
+  position: number;
+  /** Worker node whose queue holds the job, as a requeued job waits for the node with its partial file; empty for the main queue */
+  node?: string;
+  id: string;
+  url: string;
+  output_path: string;
+  threads: number;
+  method?: string;
+  group_id?: string;
+  created_at: string;
+}
+
+/** QueuedJobList lists the jobs waiting in the main queue in the order workers take them */
+export interface QueuedJobList {
+  jobs: QueuedJob[];
+  count: number;
+}
+
+/** ArchivedJob describes a job in the completed or failed queue */
+export interface ArchivedJob {
+  id: string;
+  url?: string;
+  output_path?: string;
+  group_id?: string;
+  status: "completed" | "failed";
+  worker_id?: string;
+  bytes_downloaded: number;
+  error_message?: string;
+  created_at: string;
+  started_at?: string;
+  completed_at: string;
+  /** How long the job ran; 0 if it never started */
+  duration_seconds: number;
+}
+
+/** ArchivedJobList lists recently finished jobs, the most recent first */
+export interface ArchivedJobList {
+  jobs: ArchivedJob[];
+  count: number;
+}
+
+/** ScheduleRequest creates a schedule that enqueues a download */
+export interface ScheduleRequest {
+  name?: string;
+  /** When to run: five cron fields (minute hour day-of-month month day-of-week) such as "0 3 * * *", a descriptor such as @daily, or "@every 6h" */
+  cron: string;
+  /** IANA time zone the cron fields are read in, e.g. Europe/Berlin; defaults to UTC */
+  timezone?: string;
+  /** Create the schedule paused */
+  paused?: boolean;
+  download: QueuedDownloadRequest;
+}
+
+/** Schedule describes a recurring download */
+export interface Schedule {
+  id: string;
+  name?: string;
+  cron: string;
+  timezone: string;
+  paused: boolean;
+  url: string;
+  output_path: string;
+  only_if_modified?: boolean;
+  /** When the schedule next enqueues the download; omitted while paused */
+  next_run?: string;
+  last_run?: string;
+  created_at: string;
+}
+
+/** ScheduleList lists every schedule */
+export interface ScheduleList {
+  schedules: Schedule[];
+  count: number;
+}
+
+/** ScheduleRun records one time a schedule fired */
+export interface ScheduleRun {
+  time: string;
+  /** enqueued a job, skipped because the previous run's job had not finished, or failed to enqueue */
+  result: "enqueued" | "skipped" | "failed";
+  /** The job enqueued, or the unfinished one a skipped run waited for */
+  job_id?: string;
+  details?: string;
+}
+
+/** ScheduleRunList lists the past runs of a schedule, the most recent first */
+export interface ScheduleRunList {
+  runs: ScheduleRun[];
+  count: number;
+}
+
+/** Per-call options */
+export interface RequestOptions {
+  headers?: Record<string, string>;
+  signal?: AbortSignal;
+}
+
+/** A call as the generated methods describe it to the transport */
+export interface ApiRequest {
+  method: string;
+  path: string;
+  query?: Record<string, string | number | boolean | undefined>;
+  json?: unknown;
+  text?: string;
+  binary?: boolean;
+  options?: RequestOptions;
+}
+
+/** One method per API operation; subclasses implement request. */
+export abstract class GeneratedClient {
+  protected abstract request<T>(req: ApiRequest): Promise<T>;
+
+  /**
+   * Start a download.
+   *
+   * Served by the direct and simple servers.
+   */
+  startDownload(body: DownloadRequest, options?: RequestOptions): Promise<DownloadResponse> {
+    return this.request<DownloadResponse>({ method: "POST", path: `/downloads`, json: body, options });
+  }
+
+  /**
+   * List downloads.
+   *
+   * Served by the direct and simple servers.
+   */
+  listDownloads(options?: RequestOptions): Promise<DownloadList> {
+    return this.request<DownloadList>({ method: "GET", path: `/downloads`, options });
+  }
+
+  /**
+   * Export download definitions as a manifest another server can import.
+   *
+   * Served by the direct server from API v2.
+   *
+   * @param query.status Comma separated statuses to export; defaults to every download that has not completed
+   * @param query.format Manifest encoding
+   */
+  exportDownloads(query: { status?: string; format?: "json" | "yaml" } = {}, options?: RequestOptions): Promise<Manifest> {
+    return this.request<Manifest>({ method: "GET", path: `/downloads/export`, query, options });
+  }
+
+  /**
+   * Start the downloads of an exported manifest.
+   *
+   * Served by the direct server from API v2.
+   */
+  importDownloads(body: Manifest, options?: RequestOptions): Promise<ManifestImport> {
+    return this.request<ManifestImport>({ method: "POST", path: `/downloads/import`, json: body, options });
+  }
+
+  /**
+   * Get the status of a download.
+   *
+   * Served by the direct and simple servers.
+   *
+   * @param query.wait Hold the request until the status changes or progress moves by min_progress, for at most this long, e.g. 30s; up to 60s. Finished downloads answer at once.
+   * @param query.min_progress Percentage points of progress that end a wait; any progress counts while the size is unknown
+   */
+  getDownloadStatus(id: string, query: { wait?: string; min_progress?: number } = {}, options?: RequestOptions): Promise<DownloadStatus> {
+    return this.request<DownloadStatus>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/status`, query, options });
+  }
+
+  /**
+   * Pause a running download.
+   *
+   * Served by the direct server.
+   */
+  pauseDownload(id: string, options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "POST", path: `/downloads/${encodeURIComponent(id)}/pause`, options });
+  }
+
+  /**
+   * Resume a paused download.
+   *
+   * Served by the direct server.
+   */
+  resumeDownload(id: string, options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "POST", path: `/downloads/${encodeURIComponent(id)}/resume`, options });
+  }
+
+  /**
+   * Read the bytes of a download fetched so far.
+   *
+   * Served by the direct server from API v2.
+   */
+  previewDownload(id: string, options?: RequestOptions): Promise<ArrayBuffer> {
+    return this.request<ArrayBuffer>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/preview`, binary: true, options });
+  }
+
+  /**
+   * Diagnostics of the connection of every part of a running download.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  listDownloadParts(id: string, options?: RequestOptions): Promise<DownloadParts> {
+    return this.request<DownloadParts>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/parts`, options });
+  }
+
+  /**
+   * Which byte ranges of a running download are complete, as a piece bitmap and a list of segments.
+   *
+   * Served by the direct and queued servers from API v2.
+   *
+   * @param query.pieces Pieces to cut the file into; fewer for files smaller than that many bytes
+   */
+  getDownloadMap(id: string, query: { pieces?: number } = {}, options?: RequestOptions): Promise<SegmentMap> {
+    return this.request<SegmentMap>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/map`, query, options });
+  }
+
+  /**
+   * The exact split of a running download into parts, with the server each part comes from.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  getDownloadPlan(id: string, options?: RequestOptions): Promise<PartPlan> {
+    return this.request<PartPlan>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/plan`, options });
+  }
+
+  /**
+   * Everything that decided how a download ran, to reproduce it or attach to a bug report.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  getDownloadEnvironment(id: string, options?: RequestOptions): Promise<DownloadEnvironment> {
+    return this.request<DownloadEnvironment>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/environment`, options });
+  }
+
+  /**
+   * Every state change of a download, oldest first, with when it happened and who caused it.
+   *
+   * Served by the direct and queued servers from API v2.
+   *
+   * @param query.limit Most events to return, the newest ones
+   */
+  listDownloadEvents(id: string, query: { limit?: number } = {}, options?: RequestOptions): Promise<DownloadEvents> {
+    return this.request<DownloadEvents>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/events`, query, options });
+  }
+
+  /**
+   * Drop the connection of a part and request it again.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  restartDownloadPart(id: string, index: string, options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "POST", path: `/downloads/${encodeURIComponent(id)}/parts/${encodeURIComponent(index)}/restart`, options });
+  }
+
+  /**
+   * Cancel and remove a download.
+   *
+   * Served by the direct server.
+   */
+  deleteDownload(id: string, options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "DELETE", path: `/downloads/${encodeURIComponent(id)}`, options });
+  }
+
+  /**
+   * Change the rate limit or thread count of a running download.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  adjustDownload(id: string, body: DownloadAdjustment, options?: RequestOptions): Promise<DownloadLimits | MessageResponse> {
+    return this.request<DownloadLimits | MessageResponse>({ method: "PATCH", path: `/downloads/${encodeURIComponent(id)}`, json: body, options });
+  }
+
+  /**
+   * Runtime settings of the server.
+   *
+   * Served by the direct server from API v2.
+   */
+  getSettings(options?: RequestOptions): Promise<Settings> {
+    return this.request<Settings>({ method: "GET", path: `/settings`, options });
+  }
+
+  /**
+   * Change runtime settings; every change is recorded in the audit log.
+   *
+   * Served by the direct server from API v2.
+   */
+  updateSettings(body: SettingsUpdate, options?: RequestOptions): Promise<Settings> {
+    return this.request<Settings>({ method: "PATCH", path: `/settings`, json: body, options });
+  }
+
+  /**
+   * Recent changes made through the API, newest first.
+   *
+   * Served by the direct server from API v2.
+   *
+   * @param query.limit Most entries to return
+   */
+  listAuditEntries(query: { limit?: number } = {}, options?: RequestOptions): Promise<AuditLog> {
+    return this.request<AuditLog>({ method: "GET", path: `/audit`, query, options });
+  }
+
+  /**
+   * Archive of the database and the progress files to restore the server from.
+   *
+   * Served by the direct server from API v2.
+   */
+  backup(options?: RequestOptions): Promise<ArrayBuffer> {
+    return this.request<ArrayBuffer>({ method: "GET", path: `/backup`, binary: true, options });
+  }
+
+  /**
+   * Download statistics from the database.
+   *
+   * Served by the direct server.
+   */
+  getStats(options?: RequestOptions): Promise<{ statistics?: Record<string, number>; timestamp?: string }> {
+    return this.request<{ statistics?: Record<string, number>; timestamp?: string }>({ method: "GET", path: `/stats`, options });
+  }
+
+  /**
+   * Enqueue a download job.
+   *
+   * Served by the queued server.
+   */
+  enqueueDownload(body: QueuedDownloadRequest, options?: RequestOptions): Promise<QueuedDownloadResponse> {
+    return this.request<QueuedDownloadResponse>({ method: "POST", path: `/downloads`, json: body, options });
+  }
+
+  /**
+   * List download jobs.
+   *
+   * Served by the queued server.
+   */
+  listJobs(options?: RequestOptions): Promise<QueuedDownloadList> {
+    return this.request<QueuedDownloadList>({ method: "GET", path: `/downloads`, options });
+  }
+
+  /**
+   * Get the status of a download job.
+   *
+   * Served by the queued server.
+   *
+   * @param query.wait Hold the request until the status changes or progress moves by min_progress, for at most this long, e.g. 30s; up to 60s. Finished downloads answer at once.
+   * @param query.min_progress Percentage points of progress that end a wait; any progress counts while the size is unknown
+   */
+  getJobStatus(id: string, query: { wait?: string; min_progress?: number } = {}, options?: RequestOptions): Promise<QueuedDownloadStatus> {
+    return this.request<QueuedDownloadStatus>({ method: "GET", path: `/downloads/${encodeURIComponent(id)}/status`, query, options });
+  }
+
+  /**
+   * Enqueue every URL of a template or page as one group.
+   *
+   * Served by the queued server from API v2.
+   */
+  enqueueGroup(body: GroupDownloadRequest, options?: RequestOptions): Promise<GroupDownloadResponse> {
+    return this.request<GroupDownloadResponse>({ method: "POST", path: `/groups`, json: body, options });
+  }
+
+  /**
+   * Show the URLs and output paths a group request resolves to.
+   *
+   * Served by the queued server from API v2.
+   */
+  previewGroup(body: GroupDownloadRequest, options?: RequestOptions): Promise<GroupPreview> {
+    return this.request<GroupPreview>({ method: "POST", path: `/groups/preview`, json: body, options });
+  }
+
+  /**
+   * Status of every job in a group.
+   *
+   * Served by the queued server from API v2.
+   */
+  getGroupStatus(id: string, options?: RequestOptions): Promise<GroupStatus> {
+    return this.request<GroupStatus>({ method: "GET", path: `/groups/${encodeURIComponent(id)}`, options });
+  }
+
+  /**
+   * SHA256SUMS file of a finished group.
+   *
+   * Served by the queued server from API v2.
+   */
+  getGroupChecksums(id: string, options?: RequestOptions): Promise<ArrayBuffer> {
+    return this.request<ArrayBuffer>({ method: "GET", path: `/groups/${encodeURIComponent(id)}/checksums`, binary: true, options });
+  }
+
+  /**
+   * Ed25519 signature of the SHA256SUMS file of a finished group.
+   *
+   * Served by the queued server from API v2.
+   */
+  getGroupChecksumsSignature(id: string, options?: RequestOptions): Promise<ArrayBuffer> {
+    return this.request<ArrayBuffer>({ method: "GET", path: `/groups/${encodeURIComponent(id)}/checksums.sig`, binary: true, options });
+  }
+
+  /**
+   * Enqueue a download again and again on a cron schedule.
+   *
+   * Served by the queued server from API v2.
+   */
+  createSchedule(body: ScheduleRequest, options?: RequestOptions): Promise<Schedule> {
+    return this.request<Schedule>({ method: "POST", path: `/schedules`, json: body, options });
+  }
+
+  /**
+   * List every schedule.
+   *
+   * Served by the queued server from API v2.
+   */
+  listSchedules(options?: RequestOptions): Promise<ScheduleList> {
+    return this.request<ScheduleList>({ method: "GET", path: `/schedules`, options });
+  }
+
+  /**
+   * Get a schedule.
+   *
+   * Served by the queued server from API v2.
+   */
+  getSchedule(id: string, options?: RequestOptions): Promise<Schedule> {
+    return this.request<Schedule>({ method: "GET", path: `/schedules/${encodeURIComponent(id)}`, options });
+  }
+
+  /**
+   * Delete a schedule and its history; jobs it enqueued are kept.
+   *
+   * Served by the queued server from API v2.
+   */
+  deleteSchedule(id: string, options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "DELETE", path: `/schedules/${encodeURIComponent(id)}`, options });
+  }
+
+  /**
+   * Stop a schedule from enqueueing downloads.
+   *
+   * Served by the queued server from API v2.
+   */
+  pauseSchedule(id: string, options?: RequestOptions): Promise<Schedule> {
+    return this.request<Schedule>({ method: "POST", path: `/schedules/${encodeURIComponent(id)}/pause`, options });
+  }
+
+  /**
+   * Resume a paused schedule from its next time; runs missed while paused are skipped.
+   *
+   * Served by the queued server from API v2.
+   */
+  resumeSchedule(id: string, options?: RequestOptions): Promise<Schedule> {
+    return this.request<Schedule>({ method: "POST", path: `/schedules/${encodeURIComponent(id)}/resume`, options });
+  }
+
+  /**
+   * Past runs of a schedule, the most recent first.
+   *
+   * Served by the queued server from API v2.
+   *
+   * @param query.limit Most runs to return
+   */
+  listScheduleRuns(id: string, query: { limit?: number } = {}, options?: RequestOptions): Promise<ScheduleRunList> {
+    return this.request<ScheduleRunList>({ method: "GET", path: `/schedules/${encodeURIComponent(id)}/runs`, query, options });
+  }
+
+  /**
+   * Import a Netscape cookies.txt file.
+   *
+   * Served by the direct and queued servers.
+   */
+  importCookies(body: string, options?: RequestOptions): Promise<{ message?: string; cookies_imported?: number; domains?: string[] }> {
+    return this.request<{ message?: string; cookies_imported?: number; domains?: string[] }>({ method: "POST", path: `/cookies`, text: body, options });
+  }
+
+  /**
+   * Forget every imported cookie.
+   *
+   * Served by the direct and queued servers.
+   */
+  clearCookies(options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "DELETE", path: `/cookies`, options });
+  }
+
+  /**
+   * Per-domain download defaults, with header values redacted.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  listDomainRules(options?: RequestOptions): Promise<DomainRules> {
+    return this.request<DomainRules>({ method: "GET", path: `/domain-rules`, options });
+  }
+
+  /**
+   * Replace the per-domain download defaults.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  replaceDomainRules(body: DomainRules, options?: RequestOptions): Promise<DomainRules> {
+    return this.request<DomainRules>({ method: "PUT", path: `/domain-rules`, json: body, options });
+  }
+
+  /**
+   * The defaults the domain rules give a download from a URL.
+   *
+   * Served by the direct and queued servers from API v2.
+   *
+   * @param query.url URL of the download
+   */
+  matchDomainRules(query: { url?: string } = {}, options?: RequestOptions): Promise<DomainRuleMatch> {
+    return this.request<DomainRuleMatch>({ method: "GET", path: `/domain-rules/match`, query, options });
+  }
+
+  /**
+   * Ask the server of a URL whether it serves byte ranges, and the file's size and validators.
+   *
+   * Served by the direct and queued servers from API v2.
+   *
+   * @param query.url URL of the file
+   */
+  probeUrl(query: { url?: string } = {}, options?: RequestOptions): Promise<Probe> {
+    return this.request<Probe>({ method: "GET", path: `/probe`, query, options });
+  }
+
+  /**
+   * Number of jobs in each queue.
+   *
+   * Served by the queued server.
+   */
+  getQueueStats(options?: RequestOptions): Promise<{ queue_stats?: Record<string, number>; timestamp?: string }> {
+    return this.request<{ queue_stats?: Record<string, number>; timestamp?: string }>({ method: "GET", path: `/queue/stats`, options });
+  }
+
+  /**
+   * Jobs waiting in the main queue, the next one first, then those waiting in the queue of each worker node.
+   *
+   * Served by the queued server from API v2.
+   */
+  listQueuedJobs(options?: RequestOptions): Promise<QueuedJobList> {
+    return this.request<QueuedJobList>({ method: "GET", path: `/queue/jobs`, options });
+  }
+
+  /**
+   * Make a queued job the next one a worker takes.
+   *
+   * Served by the queued server from API v2.
+   */
+  moveQueuedJobToFront(id: string, options?: RequestOptions): Promise<MessageResponse> {
+    return this.request<MessageResponse>({ method: "POST", path: `/queue/jobs/${encodeURIComponent(id)}/move-to-front`, options });
+  }
+
+  /**
+   * Recently completed jobs, the most recent first.
+   *
+   * Served by the queued server from API v2.
+   *
+   * @param query.limit Most jobs to return
+   */
+  listCompletedJobs(query: { limit?: number } = {}, options?: RequestOptions): Promise<ArchivedJobList> {
+    return this.request<ArchivedJobList>({ method: "GET", path: `/queue/completed`, query, options });
+  }
+
+  /**
+   * Recently failed jobs, the most recent first.
+   *
+   * Served by the queued server from API v2.
+   *
+   * @param query.limit Most jobs to return
+   */
+  listFailedJobs(query: { limit?: number } = {}, options?: RequestOptions): Promise<ArchivedJobList> {
+    return this.request<ArchivedJobList>({ method: "GET", path: `/queue/failed`, query, options });
+  }
+
+  /**
+   * Worker statistics.
+   *
+   * Served by the queued server.
+   */
+  getWorkerStats(options?: RequestOptions): Promise<Record<string, unknown>> {
+    return this.request<Record<string, unknown>>({ method: "GET", path: `/workers/stats`, options });
+  }
+
+  /**
+   * State of the alert rules.
+   *
+   * Served by the direct and queued servers from API v2.
+   */
+  listAlerts(options?: RequestOptions): Promise<Alerts> {
+    return this.request<Alerts>({ method: "GET", path: `/alerts`, options });
+  }
+
+  /**
+   * Whether the queue is stalled, and how many worker nodes would drain it.
+   *
+   * Served by the queued server from API v2.
+   */
+  getScalingHint(options?: RequestOptions): Promise<ScalingHint> {
+    return this.request<ScalingHint>({ method: "GET", path: `/scaling`, options });
+  }
+
+  /**
+   * Bytes transferred per user, tag or domain, by day or month.
+   *
+   * Served by the direct and queued servers from API v2.
+   *
+   * @param query.by What the bytes are grouped by
+   * @param query.period Length of a row's period
+   * @param query.since First day reported, as 2006-01-02 in UTC; defaults to 30 days before until, or the start of the month eleven months before it
+   * @param query.until Last day reported, as 2006-01-02 in UTC; defaults to today
+   */
+  getUsage(query: { by?: "user" | "tag" | "domain"; period?: "daily" | "monthly"; since?: string; until?: string } = {}, options?: RequestOptions): Promise<UsageReport> {
+    return this.request<UsageReport>({ method: "GET", path: `/usage`, query, options });
+  }
+
+  /**
+   * Health check.
+   *
+   * Served by the direct, simple and queued servers.
+   */
+  getHealth(options?: RequestOptions): Promise<HealthResponse> {
+    return this.request<HealthResponse>({ method: "GET", path: `/health`, options });
+  }
+}
@@ -0,0 +1,3 @@
+export * from "./generated";
+export { APIError, Client, DownloadFailed, FINAL_STATUSES, SETTLED_STATUSES } from "./client";
+export type { ClientOptions, WatchOptions } from "./client";
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secret values in responses and logs
+const Redacted = "REDACTED"
+
+// sensitiveParams are query parameters that carry credentials, compared in lower case
+var sensitiveParams = map[string]bool{
+	"token":                true,
+	"access_token":         true,
+	"refresh_token":        true,
+	"api_key":              true,
+	"apikey":               true,
+	"key":                  true,
+	"password":             true,
+	"passwd":               true,
+	"secret":               true,
+	"signature":            true,
+	"sig":                  true,
+	"auth":                 true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-goog-signature":     true,
+	"x-goog-credential":    true,
+}
+
+// urlPattern finds URLs embedded in free text such as error messages
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// URLHasSecrets reports whether a URL carries a password or a credential query parameter
+func URLHasSecrets(rawURL string) bool {
+	return RedactURL(rawURL) != rawURL
+}
+
+// RedactURL hides the userinfo password and credential query parameters of a URL.
+// Unparseable URLs are redacted entirely rather than risk leaking them.
+func RedactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Redacted
+	}
+
+	changed := false
+	if parsed.User != nil {
+		if _, hasPassword := parsed.User.Password(); hasPassword {
+			parsed.User = url.UserPassword(parsed.User.Username(), Redacted)
+			changed = true
+		}
+	}
+
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for name := range query {
+			if sensitiveParams[strings.ToLower(name)] {
+				query.Set(name, Redacted)
+				changed = true
+			}
+		}
+		if changed {
+			parsed.RawQuery = query.Encode()
+		}
+	}
+
+	if !changed {
+		return rawURL
+	}
+	return parsed.String()
+}
+
+// RedactHeaders returns a copy of headers with every value redacted. Job
+// headers exist to carry credentials, so only the names are shown.
+func RedactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(headers))
+	for name := range headers {
+		redacted[name] = Redacted
+	}
+	return redacted
+}
+
+// sensitiveHeaderWords mark header names whose values are credentials,
+// compared in lower case
+var sensitiveHeaderWords = []string{"auth", "cookie", "token", "secret", "password", "key", "session", "signature", "credential"}
+
+// SensitiveHeader reports whether a request or response header carries a
+// credential, e.g. Authorization, Cookie, Set-Cookie or X-Api-Key
+func SensitiveHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactSensitiveHeaders returns a copy of headers with the values of
+// sensitive ones redacted and the rest kept, for records meant to show
+// what was sent
+func RedactSensitiveHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if SensitiveHeader(name) {
+			value = Redacted
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// RedactText redacts every URL found in free text, e.g. an error message
+func RedactText(text string) string {
+	return urlPattern.ReplaceAllStringFunc(text, RedactURL)
+}
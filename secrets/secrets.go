@@ -0,0 +1,155 @@
+// Package secrets protects credentials stored with download jobs. Sensitive
+// values are sealed with a server master key before they are written to
+// Redis or the database, and redacted whenever jobs are shown or logged.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length of a master key in bytes (AES-256)
+const KeySize = 32
+
+// sealedPrefix marks a value produced by Seal; the version allows the format to change later
+const sealedPrefix = "sealed:v1:"
+
+var (
+	// ErrNoMasterKey is returned when a secret must be sealed or opened but no master key is configured
+	ErrNoMasterKey = errors.New("no secrets master key configured")
+	// ErrUnsealFailed is returned when a sealed value is corrupt or was sealed with another key
+	ErrUnsealFailed = errors.New("failed to unseal secret: wrong master key or corrupt value")
+)
+
+// Box seals and opens secret values with a master key. A nil Box has no key:
+// it opens plain values unchanged but refuses to seal or open sealed ones.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox creates a Box from a 32 byte master key
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// ParseKey decodes a master key written as hex or base64
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("master key must be %d bytes encoded as hex or base64", KeySize)
+}
+
+// LoadBox reads a master key file and creates a Box from it
+func LoadBox(keyFile string) (*Box, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return NewBox(key)
+}
+
+// IsSealed reports whether value was produced by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal encrypts value under a fresh random nonce. Empty values stay empty.
+func (b *Box) Seal(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if b == nil {
+		return "", ErrNoMasterKey
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), []byte(sealedPrefix))
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal. Values that were never sealed are
+// returned unchanged so jobs stored before secrets were enabled still run.
+func (b *Box) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if b == nil {
+		return "", ErrNoMasterKey
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrUnsealFailed
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plain, err := b.aead.Open(nil, nonce, ciphertext, []byte(sealedPrefix))
+	if err != nil {
+		return "", ErrUnsealFailed
+	}
+	return string(plain), nil
+}
+
+// SealHeaders returns a copy of headers with every value sealed
+func (b *Box) SealHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	sealed := make(map[string]string, len(headers))
+	for name, value := range headers {
+		s, err := b.Seal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal header %s: %w", name, err)
+		}
+		sealed[name] = s
+	}
+	return sealed, nil
+}
+
+// OpenHeaders returns a copy of headers with every sealed value decrypted
+func (b *Box) OpenHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	opened := make(map[string]string, len(headers))
+	for name, value := range headers {
+		o, err := b.Open(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open header %s: %w", name, err)
+		}
+		opened[name] = o
+	}
+	return opened, nil
+}
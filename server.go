@@ -1,51 +1,63 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"multithreaded-downloader/access"
+	"multithreaded-downloader/alerts"
+	"multithreaded-downloader/apiversion"
+	"multithreaded-downloader/browser"
+	"multithreaded-downloader/backup"
+	"multithreaded-downloader/cache"
+	"multithreaded-downloader/cluster"
+	"multithreaded-downloader/diag"
+	"multithreaded-downloader/domainrules"
 	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/events"
+	"multithreaded-downloader/health"
+	"multithreaded-downloader/leader"
+	"multithreaded-downloader/listener"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/manifest"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/reconcile"
+	"multithreaded-downloader/sandbox"
+	"multithreaded-downloader/secrets"
+	"multithreaded-downloader/settings"
+	"multithreaded-downloader/usage"
 )
 
-// DownloadRequest represents the JSON request body for starting a download
-type DownloadRequest struct {
-	URL     string `json:"url" binding:"required"`
-	Output  string `json:"output" binding:"required"`
-	Threads int    `json:"threads"`
-}
-
-// DownloadResponse represents the response when starting a download
-type DownloadResponse struct {
-	DownloadID string `json:"download_id"`
-	Message    string `json:"message"`
-}
-
-// DownloadStatus represents the current status of a download
-type DownloadStatus struct {
-	DownloadID       string  `json:"download_id"`
-	URL              string  `json:"url"`
-	Filename         string  `json:"filename"`
-	Status           string  `json:"status"` // "downloading", "paused", "completed", "failed"
-	PercentCompleted float64 `json:"percent_completed"`
-	BytesDownloaded  int64   `json:"bytes_downloaded"`
-	TotalSize        int64   `json:"total_size"`
-	ThreadsUsed      int     `json:"threads_used"`
-	StartTime        string  `json:"start_time"`
-	Error            string  `json:"error,omitempty"`
-}
+// Request and response payloads are generated from openapi/openapi.json so
+// that this server, the simple server and the queued server cannot drift apart
+type (
+	DownloadRequest  = openapi.DownloadRequest
+	DownloadResponse = openapi.DownloadResponse
+	DownloadStatus   = openapi.DownloadStatus
+)
 
 // ManagedDownload wraps a downloader with additional management info
 type ManagedDownload struct {
 	ID         string
 	Downloader *downloader.Downloader
-	Status     string
+	Status     lifecycle.Status
 	StartTime  time.Time
 	Context    context.Context
 	Cancel     context.CancelFunc
@@ -55,6 +67,48 @@ type ManagedDownload struct {
 	DBRecord   *Download
 }
 
+// setStatus moves the download to next and publishes the change. The caller
+// holds m.Mutex. Moves the lifecycle forbids, such as failing a download that
+// already completed, leave the status unchanged.
+func (m *ManagedDownload) setStatus(next lifecycle.Status, errorMsg string) error {
+	status, err := m.Status.Transition(next)
+	if err != nil {
+		return err
+	}
+	m.Status = status
+	
+	if eventType, ok := events.ForStatus(status); ok {
+		event := m.event(eventType)
+		event.Error = secrets.RedactText(errorMsg)
+		eventBus.Publish(event)
+	}
+	return nil
+}
+
+// event describes the download's current status and progress; the caller
+// holds m.Mutex. The sequence number is taken before the snapshot, so the
+// database keeps a newer state when an older event is delivered late.
+func (m *ManagedDownload) event(eventType events.Type) events.Event {
+	event := events.Event{
+		Type:       eventType,
+		DownloadID: m.ID,
+		Status:     m.Status,
+		Seq:        lifecycle.NextSeq(),
+	}
+	if m.Downloader.Progress != nil {
+		event.BytesDownloaded = m.Downloader.Progress.GetTotalDownloaded()
+		event.TotalBytes = m.Downloader.Progress.TotalSize
+		if m.Status == lifecycle.Completed {
+			event.BytesDownloaded = event.TotalBytes
+			event.Cached = m.Downloader.Cached()
+		}
+	}
+	if m.Status == lifecycle.Downloading {
+		event.Connections = m.Downloader.ActiveThreads()
+	}
+	return event
+}
+
 // DownloadManager manages multiple concurrent downloads
 type DownloadManager struct {
 	downloads map[string]*ManagedDownload
@@ -75,7 +129,7 @@ func (dm *DownloadManager) AddDownload(id string, dl *downloader.Downloader, dbR
 	managed := &ManagedDownload{
 		ID:         id,
 		Downloader: dl,
-		Status:     "downloading",
+		Status:     lifecycle.Downloading,
 		StartTime:  time.Now(),
 		Context:    ctx,
 		Cancel:     cancel,
@@ -124,67 +178,344 @@ func (dm *DownloadManager) GetAllDownloads() map[string]*ManagedDownload {
 // Global download manager instance
 var downloadManager = NewDownloadManager()
 
+// eventBus carries download lifecycle events to the database and other subscribers
+var eventBus = events.NewBus("server")
+
+// activity keeps the live speed and connections of every download from the
+// progress events on eventBus
+var activity = events.NewActivity()
+
+// statusWatcher wakes long-polling status requests for the downloads this
+// replica runs
+var statusWatcher = events.NewWatcher()
+
+// alertEngine evaluates the ALERT_RULES_FILE rules against this replica's
+// downloads; alertTracker measures their failure rate and queue wait
+var (
+	alertEngine  = alerts.NewEngine("server", nil)
+	alertTracker = alerts.NewTracker()
+)
+
+// usageCostPerGB is USAGE_COST_PER_GB, what usage reports estimate costs with
+var usageCostPerGB float64
+
+// sandboxServer generates the files of every download in sandbox mode; it
+// is nil otherwise
+var sandboxServer *sandbox.Server
+
+
+// node identifies this replica to the others sharing the database
+var node cluster.Node
+
+// leaseTTL is how long a replica keeps its downloads without renewing its
+// claim; after that another replica adopts them
+const leaseTTL = 30 * time.Second
+
+// browserPolicy sets the CORS headers and CSRF checks of web pages, from
+// CORS_ALLOWED_ORIGINS and CSRF_PROTECTION
+var browserPolicy = browser.DefaultPolicy
+
+// maxBodyBytes is the largest request body read, from MAX_REQUEST_BODY_BYTES
+var maxBodyBytes int64 = openapi.DefaultMaxBodyBytes
+
+// debugEndpoints serves pprof, expvar and /debug/downloads on the admin
+// router, set from DEBUG_ENDPOINTS
+var debugEndpoints bool
+
+// stateDir holds one progress file per download, set from STATE_DIR. Keep it
+// on a persistent volume so downloads resume after the container restarts.
+var stateDir = "state"
+
+// spoofingPolicy restricts which User-Agent and Referer values clients may request
+var spoofingPolicy = downloader.SpoofingAllowAny
+
+// encryptionKey, when set from ENCRYPTION_KEY_FILE, encrypts every downloaded file at rest
+var encryptionKey []byte
+
+// downloadCache, when set from CACHE_DIR, holds finished downloads that later
+// downloads of the same file are linked from
+var downloadCache *cache.Cache
+
+// probeCache, unless PROBE_CACHE_TTL is 0, keeps range checks so that a URL
+// downloaded again shortly after is not probed again
+var probeCache downloader.ProbeCache
+
+// cookieJar holds cookies imported through POST /cookies; they are sent to matching domains
+var (
+	cookieJar      = downloader.NewCookieJar()
+	cookieJarMutex sync.RWMutex
+)
+
+// maxCookiesUploadSize limits the size of an uploaded cookies.txt file
+const maxCookiesUploadSize = 1 << 20
+
+// maxManifestSize limits the size of an imported download manifest
+const maxManifestSize = 16 << 20
+
+// currentSettings are the runtime settings this replica applies, loaded from
+// the database and changed through PATCH /settings
+var (
+	currentSettings = settings.Defaults()
+	settingsMutex   sync.RWMutex
+)
+
+// domainRules give downloads per-host defaults, read from DOMAIN_RULES_FILE
+// and replaced through PUT /domain-rules
+var domainRules = &domainrules.Store{}
+
+// auditActionDomainRules is the audit log action for replaced domain rules
+const auditActionDomainRules = "domain_rules.update"
+
+// globalLimiter caps the bytes per second of every download on this replica
+var globalLimiter = downloader.NewRateLimiter(0)
+
+// downloadSlots caps how many downloads on this replica transfer at once
+var downloadSlots = downloader.NewGate(0)
+
+// auditActionSettings is the audit log action for a changed setting
+const auditActionSettings = "settings.update"
+
+// requestError is why a request failed, written as the API's error body
+type requestError struct {
+	status  int
+	message string
+	details string
+	// fields lists the fields of a request body that are not valid
+	fields []openapi.FieldError
+}
+
+func (e *requestError) Error() string {
+	if e.details == "" {
+		return e.message
+	}
+	return e.message + ": " + e.details
+}
+
+// write sends the error as the response
+func (e *requestError) write(c *gin.Context) {
+	body := gin.H{"error": e.message}
+	if e.details != "" {
+		body["details"] = e.details
+	}
+	if len(e.fields) > 0 {
+		body["fields"] = e.fields
+	}
+	c.JSON(e.status, body)
+}
+
+// invalidBody is the error for a request body that cannot be decoded or
+// breaks the OpenAPI document, listing the fields at fault
+func invalidBody(err error) *requestError {
+	if openapi.BodyTooLarge(err) {
+		return &requestError{status: http.StatusRequestEntityTooLarge, message: "Request body too large", details: err.Error()}
+	}
+	return &requestError{status: http.StatusBadRequest, message: "Invalid request body", details: err.Error(), fields: openapi.FieldErrors(err)}
+}
+
 // startDownloadHandler handles POST /downloads
 func startDownloadHandler(c *gin.Context) {
 	var req DownloadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).write(c)
+		return
+	}
+	if err := req.CheckVersion(apiVersion(c)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid request body",
+			"error":   "Field not available in this API version",
 			"details": err.Error(),
 		})
 		return
 	}
 	
-	// Set default threads if not specified
-	if req.Threads <= 0 {
-		req.Threads = 4
+	downloadID, reqErr := startDownload(c.Request.Context(), req)
+	if reqErr != nil {
+		reqErr.write(c)
+		return
+	}
+	c.JSON(http.StatusCreated, DownloadResponse{
+		DownloadID: downloadID,
+		Message:    "Download started successfully",
+	})
+}
+
+// startDownload checks req, records the download and starts it, returning
+// its ID. Both POST /downloads and manifest imports start downloads here;
+// ctx names who asked in the download's history.
+func startDownload(ctx context.Context, req DownloadRequest) (string, *requestError) {
+	// Check the request against the OpenAPI document and fill in defaults
+	if err := req.Validate(); err != nil {
+		return "", invalidBody(err)
+	}
+	// Domain rules fill in what the request leaves out, before the defaults
+	profile := domainRules.Resolve(req.URL)
+	if req.Threads == 0 {
+		req.Threads = profile.Threads
+	}
+	if req.Threads == 0 {
+		req.Threads = getSettings().DefaultThreads
 	}
+	req.ApplyDefaults()
 	
-	// Validate threads count
-	if req.Threads > 16 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Maximum 16 threads allowed",
-		})
-		return
+	// Apply the server's User-Agent/Referer policy
+	userAgent, referer, err := spoofingPolicy.Resolve(req.UserAgentProfile, req.UserAgent, req.Referer)
+	if err != nil {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid request headers", details: err.Error()}
 	}
 	
 	// Generate unique download ID
 	downloadID := uuid.New().String()
 	
-	// Create a unique filename to avoid conflicts
-	filename := fmt.Sprintf("%s_%s", downloadID[:8], filepath.Base(req.Output))
+	if err := downloader.ValidatePartURLs(req.URL, req.PartURLs); err != nil {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid part URLs", details: err.Error()}
+	}
+	if err := downloader.ValidateMirrors(req.URL, req.Mirrors); err != nil {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid mirrors", details: err.Error()}
+	}
+	if len(req.Mirrors) > 0 && len(req.PartURLs) > 0 {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid mirrors", details: "mirrors cannot be used with part_urls"}
+	}
+	if err := usage.ValidateTags(req.Tags); err != nil {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid tags", details: err.Error()}
+	}
 	
-	// Create downloader instance
-	dl := downloader.NewDownloader(req.URL, filename, req.Threads)
+	// Create downloader instance; pieces of a split file are joined into one
+	dl := downloader.NewDownloader(sandboxServer.URL(req.URL), req.Output, req.Threads)
+	if len(req.PartURLs) > 0 {
+		dl = downloader.NewJoinedDownloader(sandboxServer.URLs(req.PartURLs), req.Output, req.Threads)
+	}
+	dl.ProgressFile = reconcile.StatePath(stateDir, downloadID)
+	dl.UserAgent = userAgent
+	dl.Referer = referer
+	cookieJarMutex.RLock()
+	dl.CookieJar = cookieJar
+	cookieJarMutex.RUnlock()
+	dl.EncryptionKey = encryptionKey
+	if downloadCache != nil {
+		dl.Cache = downloadCache
+	}
+	dl.Probes = probeCache
+	dl.SharedLimiter = globalLimiter
+	dl.MinPartSize = getSettings().MinPartSize
+	dl.PreviewBytes = req.PreviewBytes
+	dl.Mirrors = sandboxServer.URLs(req.Mirrors)
+	dl.MirrorCount = req.MirrorCount
+	dl.Bind = req.Bind
+	dl.BindRateLimit = req.BindRateLimit
+	dl.WriteDoneMarker = req.DoneMarker
+	dl.CaptureEnvironment = true
+	if req.Plan != nil {
+		if req.PreviewBytes > 0 {
+			return "", &requestError{status: http.StatusBadRequest, message: "Invalid plan", details: "plan cannot be combined with preview_bytes"}
+		}
+		if dl.Plan, err = req.Plan.DownloaderPlan(); err != nil {
+			return "", &requestError{status: http.StatusBadRequest, message: "Invalid plan", details: err.Error()}
+		}
+	}
+	if err := dl.SetRequest(req.Method, []byte(req.Body), req.BodyType); err != nil {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid request method or body", details: err.Error()}
+	}
+	if err := profile.Apply(dl); err != nil {
+		return "", &requestError{status: http.StatusInternalServerError, message: "Failed to apply domain rules", details: err.Error()}
+	}
+	if err := downloader.ValidateRefreshURL(req.RefreshURL); err != nil {
+		return "", &requestError{status: http.StatusBadRequest, message: "Invalid refresh URL", details: err.Error()}
+	}
+	if req.RefreshURL != "" {
+		dl.RefreshLink = downloader.EndpointRefresher(req.RefreshURL)
+	}
+	
+	// Templates may sort downloads into directories below the working directory
+	outputDir := ""
+	if downloader.HasOutputTemplate(req.Output) {
+		if !downloader.IsLocalPath(req.Output) {
+			return "", &requestError{status: http.StatusBadRequest, message: "Invalid output template", details: "output must be a relative path inside the download directory"}
+		}
+		if err := dl.ResolveOutputTemplate(map[string]string{"id": downloadID}); err != nil {
+			return "", &requestError{status: http.StatusBadRequest, message: "Invalid output template", details: err.Error()}
+		}
+		outputDir = filepath.Dir(dl.Filename)
+		if err := os.MkdirAll(downloader.LongPath(outputDir), 0755); err != nil {
+			return "", &requestError{status: http.StatusInternalServerError, message: "Failed to create output directory", details: err.Error()}
+		}
+	}
+	
+	// Create a unique filename to avoid conflicts
+	filename := filepath.Join(outputDir, fmt.Sprintf("%s_%s", downloadID[:8], filepath.Base(dl.Filename)))
+	dl.Filename = filename
 	
 	// Save to database
 	dbRecord, err := SaveDownload(downloadID, req.URL, filename, req.Threads)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to save download to database",
-			"details": err.Error(),
-		})
-		return
+		return "", &requestError{status: http.StatusInternalServerError, message: "Failed to save download to database", details: err.Error()}
+	}
+	
+	// Remember the headers so the download can be resumed with them
+	if err := UpdateHeaders(downloadID, userAgent, referer); err != nil {
+		fmt.Printf("Error saving headers for download %s: %v\n", downloadID, err)
+	}
+	dbRecord.UserAgent = userAgent
+	dbRecord.Referer = referer
+	if dl.Method != "" {
+		if err := UpdateRequest(downloadID, dl.Method, dl.Body, req.BodyType); err != nil {
+			fmt.Printf("Error saving request body for download %s: %v\n", downloadID, err)
+		}
+		dbRecord.Method = dl.Method
+		dbRecord.RequestBody = dl.Body
+		dbRecord.BodyType = req.BodyType
+	}
+	if req.RefreshURL != "" {
+		if err := UpdateRefreshURL(downloadID, req.RefreshURL); err != nil {
+			fmt.Printf("Error saving refresh URL for download %s: %v\n", downloadID, err)
+		}
+		dbRecord.RefreshURL = req.RefreshURL
+	}
+	if len(req.PartURLs) > 0 {
+		if err := UpdatePartURLs(downloadID, req.PartURLs); err != nil {
+			fmt.Printf("Error saving part URLs for download %s: %v\n", downloadID, err)
+		}
+		dbRecord.PartURLs = strings.Join(req.PartURLs, "\n")
+	}
+	
+	// Account the bytes the download transfers to who asked for it
+	createdBy := events.ActorFrom(ctx)
+	if createdBy != "" || len(req.Tags) > 0 {
+		if err := UpdateAttribution(downloadID, createdBy, req.Tags); err != nil {
+			fmt.Printf("Error saving attribution for download %s: %v\n", downloadID, err)
+		}
+		dbRecord.CreatedBy = createdBy
+		dbRecord.Tags = strings.Join(req.Tags, "\n")
+	}
+	
+	// Own the download so other replicas route its commands here
+	if _, err := dbManager.ClaimDownload(downloadID, node, leaseTTL); err != nil {
+		fmt.Printf("Error claiming download %s: %v\n", downloadID, err)
 	}
 	
 	// Add to manager
 	managed := downloadManager.AddDownload(downloadID, dl, dbRecord)
+	created := managed.event(events.Created)
+	created.Actor = events.ActorFrom(ctx)
+	eventBus.Publish(created)
 	
 	// Start download in goroutine
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
 				managed.Mutex.Lock()
-				managed.Status = "failed"
 				managed.Error = fmt.Errorf("panic: %v", r)
-				// Update database
-				UpdateStatus(downloadID, "failed", managed.Error.Error())
+				managed.setStatus(lifecycle.Failed, managed.Error.Error())
 				managed.Mutex.Unlock()
 			}
 		}()
 		
-		// Start periodic progress updates to database
-		progressTicker := time.NewTicker(3 * time.Second)
+		// Wait while this replica runs as many downloads as it may
+		if !waitForSlot(managed) {
+			return
+		}
+		defer downloadSlots.Release()
+		
+		// Start periodic progress events
+		progressTicker := time.NewTicker(events.ProgressInterval)
 		defer progressTicker.Stop()
 		
 		go func() {
@@ -195,10 +526,7 @@ func startDownloadHandler(c *gin.Context) {
 				case <-progressTicker.C:
 					managed.Mutex.RLock()
 					if managed.Downloader.Progress != nil {
-						bytesDownloaded := managed.Downloader.Progress.GetTotalDownloaded()
-						totalBytes := managed.Downloader.Progress.TotalSize
-						status := managed.Status
-						UpdateProgress(downloadID, bytesDownloaded, totalBytes, status)
+						eventBus.Publish(managed.event(events.Progress))
 					}
 					managed.Mutex.RUnlock()
 				}
@@ -208,62 +536,68 @@ func startDownloadHandler(c *gin.Context) {
 		// Initialize progress
 		if err := dl.LoadOrCreateProgress(); err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("failed to initialize download: %w", err)
-			// Update database
-			UpdateStatus(downloadID, "failed", managed.Error.Error())
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
 			managed.Mutex.Unlock()
 			return
 		}
+		recordThreads(downloadID, dl)
+		recordEnvironment(downloadID, dl)
 		
 		// Start download
 		if err := dl.Download(); err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("download failed: %w", err)
-			// Update database
-			UpdateStatus(downloadID, "failed", managed.Error.Error())
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
 			managed.Mutex.Unlock()
 			return
 		}
 		
 		// Verify download
-		if err := dl.VerifyDownload(); err != nil {
+		err := dl.VerifyDownload()
+		recordChecksum(downloadID, dl)
+		if err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("verification failed: %w", err)
-			// Update database
-			UpdateStatus(downloadID, "failed", managed.Error.Error())
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
 			managed.Mutex.Unlock()
 			return
 		}
+		recordEnvironment(downloadID, dl)
 		
 		managed.Mutex.Lock()
-		managed.Status = "completed"
-		// Update database with completion
-		if managed.Downloader.Progress != nil {
-			UpdateProgress(downloadID, managed.Downloader.Progress.TotalSize, managed.Downloader.Progress.TotalSize, "completed")
-		} else {
-			UpdateStatus(downloadID, "completed", "")
-		}
+		managed.setStatus(lifecycle.Completed, "")
 		managed.Mutex.Unlock()
 	}()
 	
-	c.JSON(http.StatusCreated, DownloadResponse{
-		DownloadID: downloadID,
-		Message:    "Download started successfully",
-	})
+	return downloadID, nil
 }
 
 // getDownloadStatusHandler handles GET /downloads/:id/status
 func getDownloadStatusHandler(c *gin.Context) {
 	downloadID := c.Param("id")
 	
+	poll, err := events.ParsePoll(c.Query("wait"), c.Query("min_progress"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid long-poll parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+	waitForStatus(c.Request.Context(), downloadID, poll)
+	
 	managed, exists := downloadManager.GetDownload(downloadID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Download not found",
-		})
+		// Another replica runs it, or ran it; its progress is in the database
+		dbRecord, err := GetDownloadByID(downloadID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Download not found",
+			})
+			return
+		}
+		writeStatus(c, recordStatus(dbRecord))
 		return
 	}
 	
@@ -271,75 +605,169 @@ func getDownloadStatusHandler(c *gin.Context) {
 	defer managed.Mutex.RUnlock()
 	
 	status := DownloadStatus{
-		DownloadID:  downloadID,
-		URL:         managed.Downloader.URL,
-		Filename:    managed.Downloader.Filename,
-		Status:      managed.Status,
-		ThreadsUsed: managed.Downloader.NumThreads,
-		StartTime:   managed.StartTime.Format(time.RFC3339),
+		DownloadID:       downloadID,
+		URL:              secrets.RedactURL(managed.Downloader.URL),
+		Filename:         managed.Downloader.Filename,
+		Status:           string(managed.Status),
+		ThreadsUsed:      managed.Downloader.NumThreads,
+		ThreadsRequested: managed.Downloader.RequestedThreads(),
+		StartTime:        managed.StartTime.Format(time.RFC3339),
 	}
 	
 	if managed.Error != nil {
-		status.Error = managed.Error.Error()
+		status.Error = secrets.RedactText(managed.Error.Error())
 	}
 	
 	// Get progress information if available
+	if until := managed.Downloader.ThrottledUntil(); !until.IsZero() && managed.Status == lifecycle.Downloading {
+		status.ThrottledByServer = true
+		status.ThrottledUntil = until.Format(time.RFC3339)
+	}
+	
 	if managed.Downloader.Progress != nil {
 		status.PercentCompleted = managed.Downloader.Progress.GetOverallPercent()
 		status.BytesDownloaded = managed.Downloader.Progress.GetTotalDownloaded()
 		status.TotalSize = managed.Downloader.Progress.TotalSize
+		status.PreviewReady = managed.Downloader.Progress.PreviewReady()
+		addChecksum(&status, managed.Downloader.Progress.Checksum)
 	}
 	
-	c.JSON(http.StatusOK, status)
+	writeStatus(c, status)
 }
 
-// pauseDownloadHandler handles POST /downloads/:id/pause
-func pauseDownloadHandler(c *gin.Context) {
+// waitForStatus holds a long-polling status request until the download
+// moves on. Downloads other replicas run are not on eventBus, so their
+// database record is re-read instead, every few seconds to spare the database.
+func waitForStatus(ctx context.Context, downloadID string, poll events.Poll) {
+	if _, exists := downloadManager.GetDownload(downloadID); !exists {
+		events.PollSnapshot(ctx, poll, events.StorePollInterval, func() (events.Snapshot, bool) {
+			dbRecord, err := GetDownloadByID(downloadID)
+			if err != nil {
+				return events.Snapshot{}, false
+			}
+			return events.Snapshot{Status: dbRecord.Status, BytesDownloaded: dbRecord.BytesDownloaded, TotalBytes: dbRecord.TotalBytes}, true
+		})
+		return
+	}
+	
+	statusWatcher.Wait(ctx, downloadID, poll, func() (events.Snapshot, bool) {
+		managed, exists := downloadManager.GetDownload(downloadID)
+		if !exists {
+			return events.Snapshot{}, false
+		}
+		managed.Mutex.RLock()
+		defer managed.Mutex.RUnlock()
+		snapshot := events.Snapshot{Status: managed.Status}
+		if managed.Downloader.Progress != nil {
+			snapshot.BytesDownloaded = managed.Downloader.Progress.GetTotalDownloaded()
+			snapshot.TotalBytes = managed.Downloader.Progress.TotalSize
+		}
+		return snapshot, true
+	})
+}
+
+// previewDownloadHandler handles GET /downloads/:id/preview - serves the
+// bytes downloaded so far, such as the head and tail fetched first with
+// preview_bytes, so an archive can be listed or media probed early
+func previewDownloadHandler(c *gin.Context) {
 	downloadID := c.Param("id")
 	
 	managed, exists := downloadManager.GetDownload(downloadID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Download not found",
+		if _, err := GetDownloadByID(downloadID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Download not found",
+			})
+			return
+		}
+		// The file is on the disk of the replica that ran it
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Download is not running on this replica",
 		})
 		return
 	}
 	
-	managed.Mutex.Lock()
-	defer managed.Mutex.Unlock()
+	managed.Mutex.RLock()
+	dl := managed.Downloader
+	managed.Mutex.RUnlock()
 	
-	if managed.Status == "completed" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Cannot pause completed download",
+	err := dl.ServeDownloaded(c.Writer, c.Request)
+	if errors.Is(err, downloader.ErrNotDownloaded) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Bytes not downloaded yet",
+			"details": err.Error(),
 		})
 		return
 	}
-	
-	if managed.Status == "paused" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Download is already paused",
+	if errors.Is(err, downloader.ErrInvalidRange) {
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{
+			"error":   "Cannot serve the range",
+			"details": err.Error(),
 		})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to read the download",
+			"details": err.Error(),
+		})
+	}
+}
+
+// writeStatus answers with status, its live counters added, in the shape of
+// the negotiated API version
+func writeStatus(c *gin.Context, status DownloadStatus) {
+	if stats, ok := activity.Get(status.DownloadID, time.Now()); ok {
+		addActivity(&status, stats)
+	}
+	if apiVersion(c) == string(apiversion.V1) {
+		c.JSON(http.StatusOK, status.V1())
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// addActivity fills the live counters of status
+func addActivity(status *DownloadStatus, stats events.Stats) {
+	status.CurrentSpeed = stats.Speed
+	status.ActiveConnections = stats.Connections
+	if !stats.LastByteAt.IsZero() {
+		status.LastByteAt = stats.LastByteAt.Format(time.RFC3339)
+	}
+}
+
+// pauseDownloadHandler handles POST /downloads/:id/pause
+func pauseDownloadHandler(c *gin.Context) {
+	downloadID := c.Param("id")
 	
-	if managed.Status == "failed" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Cannot pause failed download",
+	managed, exists := downloadManager.GetDownload(downloadID)
+	if !exists {
+		dbRecord, ok := claimRemoteDownload(c, downloadID)
+		if !ok {
+			return
+		}
+		// Nobody runs it any more, so only the recorded status changes
+		if rejectPause(c, dbRecord.Status) {
+			return
+		}
+		eventBus.Publish(events.Event{Type: events.Paused, DownloadID: downloadID, Status: lifecycle.Paused, Actor: events.ActorFrom(c.Request.Context())})
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Download paused successfully",
 		})
 		return
 	}
 	
-	// Cancel the download context to pause it
-	managed.Cancel()
-	managed.Status = "paused"
+	managed.Mutex.Lock()
+	defer managed.Mutex.Unlock()
 	
-	// Update database
-	if managed.Downloader.Progress != nil {
-		UpdateProgress(downloadID, managed.Downloader.Progress.GetTotalDownloaded(), managed.Downloader.Progress.TotalSize, "paused")
-	} else {
-		UpdateStatus(downloadID, "paused", "")
+	if rejectPause(c, managed.Status) {
+		return
 	}
 	
+	// Cancel the download context to pause it
+	managed.Cancel()
+	managed.setStatus(lifecycle.Paused, "")
+	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Download paused successfully",
 	})
@@ -351,8 +779,20 @@ func resumeDownloadHandler(c *gin.Context) {
 	
 	managed, exists := downloadManager.GetDownload(downloadID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Download not found",
+		dbRecord, ok := claimRemoteDownload(c, downloadID)
+		if !ok {
+			return
+		}
+		if dbRecord.Status != lifecycle.Paused {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Download is not paused",
+			})
+			return
+		}
+		// Take over the download from the replica that paused it
+		resumeFromRecord(dbRecord)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Download resumed successfully",
 		})
 		return
 	}
@@ -360,7 +800,7 @@ func resumeDownloadHandler(c *gin.Context) {
 	managed.Mutex.Lock()
 	defer managed.Mutex.Unlock()
 	
-	if managed.Status != "paused" {
+	if managed.Status != lifecycle.Paused {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Download is not paused",
 		})
@@ -371,27 +811,28 @@ func resumeDownloadHandler(c *gin.Context) {
 	ctx, cancel := context.WithCancel(context.Background())
 	managed.Context = ctx
 	managed.Cancel = cancel
-	managed.Status = "downloading"
 	managed.Error = nil
-	
-	// Update database status
-	UpdateStatus(downloadID, "downloading", "")
+	managed.setStatus(lifecycle.Downloading, "")
 	
 	// Resume download in goroutine
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
 				managed.Mutex.Lock()
-				managed.Status = "failed"
 				managed.Error = fmt.Errorf("panic: %v", r)
-				// Update database
-				UpdateStatus(downloadID, "failed", managed.Error.Error())
+				managed.setStatus(lifecycle.Failed, managed.Error.Error())
 				managed.Mutex.Unlock()
 			}
 		}()
 		
-		// Restart periodic progress updates
-		progressTicker := time.NewTicker(3 * time.Second)
+		// Wait while this replica runs as many downloads as it may
+		if !waitForSlot(managed) {
+			return
+		}
+		defer downloadSlots.Release()
+		
+		// Restart periodic progress events
+		progressTicker := time.NewTicker(events.ProgressInterval)
 		defer progressTicker.Stop()
 		
 		go func() {
@@ -402,10 +843,7 @@ func resumeDownloadHandler(c *gin.Context) {
 				case <-progressTicker.C:
 					managed.Mutex.RLock()
 					if managed.Downloader.Progress != nil {
-						bytesDownloaded := managed.Downloader.Progress.GetTotalDownloaded()
-						totalBytes := managed.Downloader.Progress.TotalSize
-						status := managed.Status
-						UpdateProgress(downloadID, bytesDownloaded, totalBytes, status)
+						eventBus.Publish(managed.event(events.Progress))
 					}
 					managed.Mutex.RUnlock()
 				}
@@ -415,33 +853,26 @@ func resumeDownloadHandler(c *gin.Context) {
 		// Resume download
 		if err := managed.Downloader.Download(); err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("resume failed: %w", err)
-			// Update database
-			UpdateStatus(downloadID, "failed", managed.Error.Error())
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
 			managed.Mutex.Unlock()
 			return
 		}
 		
 		// Verify download
-		if err := managed.Downloader.VerifyDownload(); err != nil {
+		err := managed.Downloader.VerifyDownload()
+		recordChecksum(downloadID, managed.Downloader)
+		if err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("verification failed: %w", err)
-			// Update database
-			UpdateStatus(downloadID, "failed", managed.Error.Error())
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
 			managed.Mutex.Unlock()
 			return
 		}
+		recordEnvironment(downloadID, managed.Downloader)
 		
 		managed.Mutex.Lock()
-		managed.Status = "completed"
-		// Update database with completion
-		if managed.Downloader.Progress != nil {
-			UpdateProgress(downloadID, managed.Downloader.Progress.TotalSize, managed.Downloader.Progress.TotalSize, "completed")
-		} else {
-			UpdateStatus(downloadID, "completed", "")
-		}
+		managed.setStatus(lifecycle.Completed, "")
 		managed.Mutex.Unlock()
 	}()
 	
@@ -459,225 +890,1638 @@ func listDownloadsHandler(c *gin.Context) {
 		managed.Mutex.RLock()
 		
 		status := DownloadStatus{
-			DownloadID:  id,
-			URL:         managed.Downloader.URL,
-			Filename:    managed.Downloader.Filename,
-			Status:      managed.Status,
-			ThreadsUsed: managed.Downloader.NumThreads,
-			StartTime:   managed.StartTime.Format(time.RFC3339),
+			DownloadID:       id,
+			URL:              secrets.RedactURL(managed.Downloader.URL),
+			Filename:         managed.Downloader.Filename,
+			Status:           string(managed.Status),
+			ThreadsUsed:      managed.Downloader.NumThreads,
+			ThreadsRequested: managed.Downloader.RequestedThreads(),
+			StartTime:        managed.StartTime.Format(time.RFC3339),
 		}
 		
 		if managed.Error != nil {
-			status.Error = managed.Error.Error()
+			status.Error = secrets.RedactText(managed.Error.Error())
+		}
+		
+		if until := managed.Downloader.ThrottledUntil(); !until.IsZero() && managed.Status == lifecycle.Downloading {
+			status.ThrottledByServer = true
+			status.ThrottledUntil = until.Format(time.RFC3339)
 		}
 		
 		if managed.Downloader.Progress != nil {
 			status.PercentCompleted = managed.Downloader.Progress.GetOverallPercent()
 			status.BytesDownloaded = managed.Downloader.Progress.GetTotalDownloaded()
 			status.TotalSize = managed.Downloader.Progress.TotalSize
+			status.PreviewReady = managed.Downloader.Progress.PreviewReady()
+			addChecksum(&status, managed.Downloader.Progress.Checksum)
 		}
 		
 		statuses = append(statuses, status)
 		managed.Mutex.RUnlock()
 	}
 	
-	c.JSON(http.StatusOK, gin.H{
-		"downloads": statuses,
-		"count":     len(statuses),
-	})
-}
-
-// deleteDownloadHandler handles DELETE /downloads/:id (bonus endpoint)
-func deleteDownloadHandler(c *gin.Context) {
-	downloadID := c.Param("id")
-	
-	managed, exists := downloadManager.GetDownload(downloadID)
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Download not found",
-		})
-		return
+	// Add the downloads other replicas own
+	if dbRecords, err := GetAllDownloadsFromDB(); err == nil {
+		for i := range dbRecords {
+			dbRecord := &dbRecords[i]
+			if _, local := downloads[dbRecord.ID]; local || dbRecord.Owner == "" || dbRecord.Owner == node.ID {
+				continue
+			}
+			statuses = append(statuses, recordStatus(dbRecord))
+		}
 	}
 	
-	managed.Mutex.Lock()
-	defer managed.Mutex.Unlock()
-	
-	// Cancel the download if it's still running
-	if managed.Status == "downloading" {
-		managed.Cancel()
+	// List the most recently active first, then the newest
+	now := time.Now()
+	stats := make(map[string]events.Stats, len(statuses))
+	for i := range statuses {
+		if s, ok := activity.Get(statuses[i].DownloadID, now); ok {
+			stats[statuses[i].DownloadID] = s
+			addActivity(&statuses[i], s)
+		}
 	}
-	
-	// Remove from manager and database
-	downloadManager.RemoveDownload(downloadID)
-	RemoveDownload(downloadID)
-	
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Download removed successfully",
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := stats[statuses[i].DownloadID], stats[statuses[j].DownloadID]
+		if events.MoreActive(a, b) || events.MoreActive(b, a) {
+			return events.MoreActive(a, b)
+		}
+		return statuses[i].StartTime > statuses[j].StartTime
 	})
+	
+	list := openapi.DownloadList{
+		Downloads: statuses,
+		Count:     len(statuses),
+	}
+	if apiVersion(c) == string(apiversion.V1) {
+		c.JSON(http.StatusOK, list.V1())
+		return
+	}
+	c.JSON(http.StatusOK, list)
 }
 
-// healthHandler handles GET /health
-func healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "1.0.0",
-	})
-}
+// rejectPause answers 400 and returns true when a download in status cannot
+// be paused
+func rejectPause(c *gin.Context, status lifecycle.Status) bool {
+	switch status {
+	case lifecycle.Completed, lifecycle.NotModified:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Cannot pause completed download",
+		})
+	case lifecycle.Paused:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Download is already paused",
+		})
+	case lifecycle.Failed:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Cannot pause failed download",
+		})
+	case lifecycle.Queued:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Cannot pause queued download",
+			"details": "the download waits for max_concurrent_downloads; delete it instead",
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// recordThreads saves the thread count a download ended up with once its
+// file was split, when it differs from the one stored at creation
+func recordThreads(id string, dl *downloader.Downloader) {
+	if dl.NumThreads == dl.RequestedThreads() {
+		return
+	}
+	if err := UpdateThreads(id, dl.NumThreads, dl.RequestedThreads()); err != nil {
+		fmt.Printf("Error saving threads for download %s: %v\n", id, err)
+	}
+}
+
+// recordChecksum saves the result of checking the download against the
+// checksums its server sent, if it sent any
+func recordChecksum(id string, dl *downloader.Downloader) {
+	if dl.Progress == nil || dl.Progress.Checksum == nil {
+		return
+	}
+	checksum := dl.Progress.Checksum
+	if err := UpdateChecksum(id, checksum.Status, checksum.Algorithm, checksum.Source); err != nil {
+		fmt.Printf("Error saving checksum result for download %s: %v\n", id, err)
+	}
+}
+
+// recordEnvironment saves the environment the download runs in. A resumed
+// download keeps what the run that started it learned from its probe.
+func recordEnvironment(id string, dl *downloader.Downloader) {
+	env := dl.Environment()
+	if env == nil {
+		return
+	}
+	if env.Resumed {
+		if prev, err := dbManager.GetDownloadEnvironment(id); err == nil {
+			env.Inherit(prev)
+		}
+	}
+	if err := UpdateEnvironment(id, env); err != nil {
+		fmt.Printf("Error saving environment of download %s: %v\n", id, err)
+	}
+}
+
+// addChecksum fills in the checksum result of a download
+func addChecksum(status *DownloadStatus, checksum *downloader.Checksum) {
+	if checksum == nil {
+		return
+	}
+	status.ChecksumStatus = checksum.Status
+	status.ChecksumAlgorithm = checksum.Algorithm
+	status.ChecksumSource = checksum.Source
+}
+
+// recordStatus describes a download this replica does not run from its
+// database record
+func recordStatus(dbRecord *Download) DownloadStatus {
+	status := DownloadStatus{
+		DownloadID:       dbRecord.ID,
+		URL:              secrets.RedactURL(dbRecord.URL),
+		Filename:         dbRecord.OutputPath,
+		Status:           string(dbRecord.Status),
+		BytesDownloaded:  dbRecord.BytesDownloaded,
+		TotalSize:        dbRecord.TotalBytes,
+		ThreadsUsed:      dbRecord.Threads,
+		ThreadsRequested: dbRecord.Threads,
+		StartTime:        dbRecord.StartTime.Format(time.RFC3339),
+		Error:            dbRecord.Error,
+	}
+	if dbRecord.RequestedThreads > 0 {
+		status.ThreadsRequested = dbRecord.RequestedThreads
+	}
+	if dbRecord.ChecksumStatus != "" {
+		addChecksum(&status, &downloader.Checksum{Status: dbRecord.ChecksumStatus, Algorithm: dbRecord.ChecksumAlgorithm, Source: dbRecord.ChecksumSource})
+	}
+	if dbRecord.TotalBytes > 0 {
+		status.PercentCompleted = float64(dbRecord.BytesDownloaded) / float64(dbRecord.TotalBytes) * 100
+	}
+	return status
+}
+
+// claimRemoteDownload handles a command for a download this replica does not
+// run. When a live replica owns it the request is forwarded there; otherwise
+// this replica claims the download and gets its record to act on. It returns
+// false once the request has been answered.
+func claimRemoteDownload(c *gin.Context, downloadID string) (*Download, bool) {
+	dbRecord, err := GetDownloadByID(downloadID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Download not found",
+		})
+		return nil, false
+	}
+	
+	lease := dbRecord.Lease()
+	if node.ShouldForward(lease, c.Request, time.Now()) {
+		if err := node.Forward(c.Writer, c.Request, lease); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":   "Download owner is unreachable",
+				"details": err.Error(),
+			})
+		}
+		return nil, false
+	}
+	
+	claimed, err := dbManager.ClaimDownload(downloadID, node, leaseTTL)
+	if err != nil || !claimed {
+		details := fmt.Sprintf("download is owned by %s", dbRecord.Owner)
+		if err != nil {
+			details = err.Error()
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is owned by another replica",
+			"details": details,
+		})
+		return nil, false
+	}
+	return dbRecord, true
+}
+
+// adjustDownloadHandler handles PATCH /downloads/:id - changes the rate limit
+// or thread count of a download without restarting it
+func adjustDownloadHandler(c *gin.Context) {
+	downloadID := c.Param("id")
+	
+	managed, exists := downloadManager.GetDownload(downloadID)
+	if !exists {
+		dbRecord, err := GetDownloadByID(downloadID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Download not found",
+			})
+			return
+		}
+		// Only the replica running the download can change its limits; the
+		// owner reads and checks the body
+		lease := dbRecord.Lease()
+		if node.ShouldForward(lease, c.Request, time.Now()) {
+			if err := node.Forward(c.Writer, c.Request, lease); err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error":   "Download owner is unreachable",
+					"details": err.Error(),
+				})
+			}
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not running",
+			"details": fmt.Sprintf("download is %s", dbRecord.Status),
+		})
+		return
+	}
+	
+	var req openapi.DownloadAdjustment
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).write(c)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		invalidBody(err).write(c)
+		return
+	}
+	if req.RateLimit == nil && req.Threads == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": "rate_limit or threads is required",
+		})
+		return
+	}
+	
+	managed.Mutex.Lock()
+	defer managed.Mutex.Unlock()
+	
+	// A paused download keeps its downloader, so new limits apply on resume
+	if managed.Status != lifecycle.Downloading && managed.Status != lifecycle.Paused {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not running",
+			"details": fmt.Sprintf("download is %s", managed.Status),
+		})
+		return
+	}
+	
+	dl := managed.Downloader
+	if req.Threads != nil {
+		if err := dl.CheckThreads(*req.Threads); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	if req.RateLimit != nil {
+		dl.SetRateLimit(*req.RateLimit)
+	}
+	if req.Threads != nil {
+		dl.SetThreads(*req.Threads)
+	}
+	
+	c.JSON(http.StatusOK, openapi.DownloadLimits{
+		DownloadID:    downloadID,
+		RateLimit:     dl.RateLimit(),
+		Threads:       dl.Threads(),
+		ActiveThreads: dl.ActiveThreads(),
+	})
+}
+
+// runningDownload returns the download of the request's :id if it runs on
+// this replica. Requests for downloads another replica runs are forwarded to
+// it; otherwise the reply is written and ok is false.
+func runningDownload(c *gin.Context) (*ManagedDownload, bool) {
+	downloadID := c.Param("id")
+	
+	managed, exists := downloadManager.GetDownload(downloadID)
+	if !exists {
+		dbRecord, err := GetDownloadByID(downloadID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Download not found",
+			})
+			return nil, false
+		}
+		lease := dbRecord.Lease()
+		if node.ShouldForward(lease, c.Request, time.Now()) {
+			if err := node.Forward(c.Writer, c.Request, lease); err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error":   "Download owner is unreachable",
+					"details": err.Error(),
+				})
+			}
+			return nil, false
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not running",
+			"details": fmt.Sprintf("download is %s", dbRecord.Status),
+		})
+		return nil, false
+	}
+	
+	managed.Mutex.RLock()
+	status := managed.Status
+	managed.Mutex.RUnlock()
+	if status != lifecycle.Downloading {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not running",
+			"details": fmt.Sprintf("download is %s", status),
+		})
+		return nil, false
+	}
+	return managed, true
+}
+
+// downloadPartsHandler handles GET /downloads/:id/parts - reports the
+// connection of every part of a running download
+func downloadPartsHandler(c *gin.Context) {
+	managed, ok := runningDownload(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewDownloadParts(managed.ID, managed.Downloader.PartConnections()))
+}
+
+// downloadMapHandler handles GET /downloads/:id/map - reports which byte
+// ranges of a running download are complete, for availability bars
+func downloadMapHandler(c *gin.Context) {
+	pieces, err := openapi.ParseMapPieces(c.Query("pieces"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid pieces",
+			"details": err.Error(),
+		})
+		return
+	}
+	managed, ok := runningDownload(c)
+	if !ok {
+		return
+	}
+	parts := openapi.NewDownloadParts(managed.ID, managed.Downloader.PartConnections())
+	c.JSON(http.StatusOK, openapi.NewSegmentMap(parts, pieces))
+}
+
+// downloadPlanHandler handles GET /downloads/:id/plan - the exact parts of
+// a running download and where they come from, to repeat the transfer
+func downloadPlanHandler(c *gin.Context) {
+	managed, ok := runningDownload(c)
+	if !ok {
+		return
+	}
+	if managed.Downloader.Progress == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not planned yet",
+			"details": "the download has not split the file into parts yet; try again in a few seconds",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewPartPlan(managed.Downloader.ExportPlan()))
+}
+
+// downloadEnvironmentHandler handles GET /downloads/:id/environment - the
+// request, settings, resolver answers and server reply a download ran with
+func downloadEnvironmentHandler(c *gin.Context) {
+	downloadID := c.Param("id")
+	env, err := dbManager.GetDownloadEnvironment(downloadID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Download not found",
+			"details": err.Error(),
+		})
+		return
+	}
+	if env == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Environment not recorded",
+			"details": "the download has not been set up yet, or ran before environments were recorded",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewDownloadEnvironment(downloadID, env))
+}
+
+// downloadEventsHandler handles GET /downloads/:id/events - the state
+// changes of a download, oldest first
+func downloadEventsHandler(c *gin.Context) {
+	downloadID := c.Param("id")
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid limit",
+				"details": "limit must be a number from 1 to 1000",
+			})
+			return
+		}
+		limit = n
+	}
+	
+	rows, err := dbManager.GetDownloadEvents(downloadID, limit)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Failed to get download events",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(rows) == 0 {
+		if _, exists := downloadManager.GetDownload(downloadID); !exists {
+			if _, err := dbManager.GetDownload(downloadID); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "Download not found",
+				})
+				return
+			}
+		}
+	}
+	
+	response := openapi.DownloadEvents{DownloadID: downloadID, Events: make([]openapi.DownloadEvent, len(rows)), Count: len(rows)}
+	for i, row := range rows {
+		response.Events[i] = row.API()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// restartPartHandler handles POST /downloads/:id/parts/:index/restart -
+// drops the connection of a stuck part so it requests its bytes again
+func restartPartHandler(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid part index",
+			"details": fmt.Sprintf("%q is not a part index", c.Param("index")),
+		})
+		return
+	}
+	managed, ok := runningDownload(c)
+	if !ok {
+		return
+	}
+	
+	if err := managed.Downloader.RestartPart(index); err != nil {
+		if errors.Is(err, downloader.ErrPartNotRunning) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "Part is not transferring",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Part not found",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Part %d restarted", index),
+	})
+}
+
+// deleteDownloadHandler handles DELETE /downloads/:id (bonus endpoint)
+func deleteDownloadHandler(c *gin.Context) {
+	downloadID := c.Param("id")
+	
+	managed, exists := downloadManager.GetDownload(downloadID)
+	if !exists {
+		if _, ok := claimRemoteDownload(c, downloadID); !ok {
+			return
+		}
+		eventBus.Publish(events.Event{Type: events.Deleted, DownloadID: downloadID})
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Download removed successfully",
+		})
+		return
+	}
+	
+	managed.Mutex.Lock()
+	defer managed.Mutex.Unlock()
+	
+	// Cancel the download if it's still running
+	if managed.Status == lifecycle.Downloading {
+		managed.Cancel()
+	}
+	
+	// Remove from the manager; the database subscriber deletes the record
+	downloadManager.RemoveDownload(downloadID)
+	eventBus.Publish(events.Event{Type: events.Deleted, DownloadID: downloadID})
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Download removed successfully",
+	})
+}
+
+// manifestEntry describes a download as a manifest entry that starts it
+// again on another server
+func manifestEntry(d Download) openapi.ManifestEntry {
+	threads := d.Threads
+	if d.RequestedThreads > 0 {
+		threads = d.RequestedThreads
+	}
+	return openapi.ManifestEntry{
+		Request: DownloadRequest{
+			URL:       d.URL,
+			Output:    requestedOutput(d),
+			Threads:   threads,
+			UserAgent: d.UserAgent,
+			Referer:   d.Referer,
+			Method:    d.Method,
+			Body:      string(d.RequestBody),
+			BodyType:  d.BodyType,
+		},
+		ID:                d.ID,
+		Status:            string(d.Status),
+		Error:             d.Error,
+		ChecksumAlgorithm: d.ChecksumAlgorithm,
+		ChecksumSource:    d.ChecksumSource,
+	}
+}
+
+// requestedOutput strips the ID prefix startDownload gives every file name,
+// so the importing server adds its own instead of stacking them
+func requestedOutput(d Download) string {
+	dir, base := filepath.Split(d.OutputPath)
+	if len(d.ID) >= 8 {
+		base = strings.TrimPrefix(base, d.ID[:8]+"_")
+	}
+	return filepath.Join(dir, base)
+}
+
+// exportDownloadsHandler handles GET /downloads/export - writes the
+// downloads with the selected statuses as a manifest, oldest first
+func exportDownloadsHandler(c *gin.Context) {
+	selected, err := manifest.ParseStatuses(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid status filter",
+			"details": err.Error(),
+		})
+		return
+	}
+	format := c.DefaultQuery("format", manifest.JSON)
+	if format != manifest.JSON && format != manifest.YAML {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid format",
+			"details": "format must be json or yaml",
+		})
+		return
+	}
+	if dbManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database not available",
+		})
+		return
+	}
+	
+	records, err := GetAllDownloadsFromDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list downloads",
+			"details": err.Error(),
+		})
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	
+	m := manifest.New(time.Now())
+	for _, record := range records {
+		if selected[record.Status] {
+			m.Downloads = append(m.Downloads, manifestEntry(record))
+		}
+	}
+	var buf bytes.Buffer
+	if err := manifest.Encode(&buf, m, format); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to encode manifest",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=downloads.%s", format))
+	c.Data(http.StatusOK, manifest.ContentType(format), buf.Bytes())
+}
 
-func setupRoutes() *gin.Engine {
+// importDownloadsHandler handles POST /downloads/import - starts every
+// download of a manifest. Entries whose URL is already waiting or running
+// here are skipped, so an interrupted import can simply be repeated.
+func importDownloadsHandler(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxManifestSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid manifest",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(data) > maxManifestSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid manifest",
+			"details": fmt.Sprintf("manifest exceeds %d bytes", maxManifestSize),
+		})
+		return
+	}
+	m, err := manifest.Decode(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid manifest",
+			"details": err.Error(),
+		})
+		return
+	}
+	if dbManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database not available",
+		})
+		return
+	}
+	
+	records, err := GetAllDownloadsFromDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list downloads",
+			"details": err.Error(),
+		})
+		return
+	}
+	active := make(map[string]string)
+	for _, record := range records {
+		if !record.Status.Terminal() {
+			active[record.URL] = record.ID
+		}
+	}
+	
+	response := openapi.ManifestImport{Results: make([]openapi.ManifestImportResult, 0, len(m.Downloads))}
+	for _, entry := range m.Downloads {
+		result := openapi.ManifestImportResult{URL: secrets.RedactURL(entry.Request.URL)}
+		if id, ok := active[entry.Request.URL]; ok {
+			result.Result = "skipped"
+			result.DownloadID = id
+			response.Skipped++
+		} else if id, reqErr := startDownload(c.Request.Context(), entry.Request); reqErr != nil {
+			result.Result = "failed"
+			result.Error = secrets.RedactText(reqErr.Error())
+			response.Failed++
+		} else {
+			result.Result = "imported"
+			result.DownloadID = id
+			active[entry.Request.URL] = id
+			response.Imported++
+		}
+		response.Results = append(response.Results, result)
+	}
+	fmt.Printf("Imported %d downloads from a manifest (%d skipped, %d failed)\n", response.Imported, response.Skipped, response.Failed)
+	c.JSON(http.StatusOK, response)
+}
+
+// backupHandler handles GET /backup - an archive of the database and the
+// progress files to rebuild the server from, e.g. on another host
+func backupHandler(c *gin.Context) {
+	if dbManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database not available",
+		})
+		return
+	}
+	
+	database, rows, err := dbManager.BackupDatabase()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to back up the database",
+			"details": err.Error(),
+		})
+		return
+	}
+	now := time.Now()
+	var buf bytes.Buffer
+	written, err := backup.Write(&buf, backup.Snapshot{Database: database, Rows: rows, StateDir: stateDir}, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to write backup",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(written.Skipped) > 0 {
+		fmt.Printf("Backup left out progress files being rewritten: %s\n", strings.Join(written.Skipped, ", "))
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=mtdl-backup-%s.tar.gz", now.UTC().Format("20060102-150405")))
+	c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
+
+// runRestore restores a backup written by GET /backup into the database and
+// STATE_DIR, then exits; start the server afterwards to resume the restored
+// downloads. It refuses to overwrite a database holding downloads unless
+// --replace is given.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	replace := fs.Bool("replace", false, "Replace the downloads already in the database")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: server restore [--replace] <backup.tar.gz>")
+		os.Exit(2)
+	}
+	
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+	archive, err := backup.Read(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("Failed to read backup: %v", err)
+	}
+	fmt.Printf("Restoring %s\n", archive.Summary())
+	
+	postgresURL := os.Getenv("POSTGRES_URL")
+	if postgresURL == "" {
+		log.Fatalf("POSTGRES_URL must name the database to restore into")
+	}
+	if err := InitPostgreSQLDatabase(postgresURL); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer dbManager.Close()
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		stateDir = dir
+	}
+	
+	if _, err := dbManager.RestoreDatabase(archive.Database, *replace); err != nil {
+		log.Fatalf("%v (use --replace to overwrite it)", err)
+	}
+	if err := archive.RestoreStates(stateDir); err != nil {
+		log.Fatalf("Database restored, but the progress files were not: %v", err)
+	}
+	fmt.Printf("Restored the database and %d progress files into %s\n", len(archive.States), stateDir)
+}
+
+// readCookiesUpload returns cookies.txt content from a multipart "file" field or the raw request body
+func readCookiesUpload(c *gin.Context) (string, error) {
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return "", fmt.Errorf("missing \"file\" form field: %w", err)
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		reader = file
+	}
+	
+	data, err := io.ReadAll(io.LimitReader(reader, maxCookiesUploadSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxCookiesUploadSize {
+		return "", fmt.Errorf("cookies file exceeds %d bytes", maxCookiesUploadSize)
+	}
+	return string(data), nil
+}
+
+// importCookiesHandler handles POST /cookies - imports a Netscape cookies.txt file
+func importCookiesHandler(c *gin.Context) {
+	content, err := readCookiesUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid cookies upload",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	cookies, err := downloader.ParseCookiesFile(strings.NewReader(content))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid cookies file",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	cookieJarMutex.Lock()
+	downloader.ImportCookies(cookieJar, cookies)
+	cookieJarMutex.Unlock()
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Cookies imported successfully",
+		"cookies_imported": len(cookies),
+		"domains":          downloader.CookieDomains(cookies),
+	})
+}
+
+// clearCookiesHandler handles DELETE /cookies - forgets every imported cookie
+func clearCookiesHandler(c *gin.Context) {
+	cookieJarMutex.Lock()
+	cookieJar = downloader.NewCookieJar()
+	cookieJarMutex.Unlock()
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cookies cleared successfully",
+	})
+}
+
+// healthHandler handles GET /health - checks the database and that files
+// can be written in the working directory, where downloads go
+func healthHandler(c *gin.Context) {
+	resp := openapi.HealthResponse{
+		Status:       "healthy",
+		Timestamp:    time.Now().Format(time.RFC3339),
+		Version:      "1.0.0",
+		Dependencies: map[string]openapi.DependencyCheck{},
+	}
+	if dbManager != nil {
+		resp.Dependencies["database"] = health.Check(c.Request.Context(), dbManager.Ping)
+	}
+	resp.Dependencies["filesystem"] = health.Check(c.Request.Context(), health.Filesystem("."))
+	health.Fill(&resp, ".")
+	
+	httpStatus := http.StatusOK
+	for name, check := range resp.Dependencies {
+		if !check.Healthy {
+			log.Printf("Health check of %s failed: %s", name, check.Error)
+			resp.Status = "unhealthy"
+			httpStatus = http.StatusServiceUnavailable
+		}
+	}
+	if apiVersion(c) == string(apiversion.V1) {
+		c.JSON(httpStatus, resp.V1())
+		return
+	}
+	c.JSON(httpStatus, resp)
+}
+
+// setupRoutes returns the router of the download API and the router of the
+// admin API, whose routes only let in the requests policy allows. With
+// separateAdmin the admin routes get a router of their own for ADMIN_ADDR;
+// otherwise both are the same router.
+func setupRoutes(policy access.Policy, separateAdmin bool) (*gin.Engine, *gin.Engine) {
 	// Set Gin to release mode for production
 	gin.SetMode(gin.ReleaseMode)
 	
+	// Routes every router serves
+	shared := []versionedRoute{
+		{apiversion.Route{Method: "GET", Path: "/health"}, healthHandler},
+		{apiversion.Route{Method: "GET", Path: "/openapi.json"}, specHandler},
+		{apiversion.Route{Method: "GET", Path: "/docs"}, gin.WrapH(openapi.SwaggerUIHandler())},
+	}
+	
+	// API routes, mounted under every version prefix they exist in and at
+	// their deprecated unversioned path
+	router := newRouter()
+	mountVersionedRoutes(router, append(shared,
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/downloads"}, startDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads"}, listDownloadsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/status"}, getDownloadStatusHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/downloads/:id/pause"}, pauseDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/downloads/:id/resume"}, resumeDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/preview", Since: apiversion.V2}, previewDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "DELETE", Path: "/downloads/:id"}, deleteDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "PATCH", Path: "/downloads/:id", Since: apiversion.V2}, adjustDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/parts", Since: apiversion.V2}, downloadPartsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/map", Since: apiversion.V2}, downloadMapHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/plan", Since: apiversion.V2}, downloadPlanHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/environment", Since: apiversion.V2}, downloadEnvironmentHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/events", Since: apiversion.V2}, downloadEventsHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/downloads/:id/parts/:index/restart", Since: apiversion.V2}, restartPartHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/stats"}, statsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/probe", Since: apiversion.V2}, probeHandler},
+	))
+	
+	adminRouter := router
+	if separateAdmin {
+		adminRouter = newRouter()
+		mountVersionedRoutes(adminRouter, shared)
+	}
+	mountVersionedRoutes(adminRouter, []versionedRoute{
+		{apiversion.Route{Method: "GET", Path: "/settings", Since: apiversion.V2}, getSettingsHandler},
+		{apiversion.Route{Method: "PATCH", Path: "/settings", Since: apiversion.V2}, updateSettingsHandler},
+		{apiversion.Route{Method: "GET", Path: "/audit", Since: apiversion.V2}, listAuditEntriesHandler},
+		{apiversion.Route{Method: "GET", Path: "/domain-rules", Since: apiversion.V2}, listDomainRulesHandler},
+		{apiversion.Route{Method: "PUT", Path: "/domain-rules", Since: apiversion.V2}, replaceDomainRulesHandler},
+		{apiversion.Route{Method: "GET", Path: "/domain-rules/match", Since: apiversion.V2}, matchDomainRulesHandler},
+		{apiversion.Route{Method: "GET", Path: "/downloads/export", Since: apiversion.V2}, exportDownloadsHandler},
+		{apiversion.Route{Method: "POST", Path: "/downloads/import", Since: apiversion.V2}, importDownloadsHandler},
+		{apiversion.Route{Method: "GET", Path: "/backup", Since: apiversion.V2}, backupHandler},
+		{apiversion.Route{Method: "GET", Path: "/alerts", Since: apiversion.V2}, alertsHandler},
+		{apiversion.Route{Method: "GET", Path: "/usage", Since: apiversion.V2}, usageHandler},
+		{apiversion.Route{Method: "POST", Path: "/cookies"}, importCookiesHandler},
+		{apiversion.Route{Method: "DELETE", Path: "/cookies"}, clearCookiesHandler},
+	}, adminMiddleware(policy))
+	
+	// Profiles and internal state for support cases; not part of the API,
+	// so not versioned
+	if debugEndpoints {
+		adminRouter.Any("/debug/*path", adminMiddleware(policy), gin.WrapH(diag.Handler(debugDownloads)))
+	}
+	
+	return router, adminRouter
+}
+
+// debugDownloads dumps the downloads the manager holds for /debug/downloads
+func debugDownloads() interface{} {
+	type managedState struct {
+		diag.Download
+		Status    lifecycle.Status `json:"status"`
+		StartTime time.Time        `json:"start_time"`
+		Error     string           `json:"error,omitempty"`
+	}
+	states := []managedState{}
+	for id, managed := range downloadManager.GetAllDownloads() {
+		managed.Mutex.RLock()
+		state := managedState{
+			Download:  diag.DescribeDownload(id, managed.Downloader),
+			Status:    managed.Status,
+			StartTime: managed.StartTime,
+		}
+		if managed.Error != nil {
+			state.Error = secrets.RedactText(managed.Error.Error())
+		}
+		managed.Mutex.RUnlock()
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].StartTime.Before(states[j].StartTime) })
+	return gin.H{
+		"downloads":      states,
+		"download_slots": downloadSlots.Limit(),
+		"active_slots":   downloadSlots.Active(),
+		"global_rate":    globalLimiter.Rate(),
+		"node":           node.ID,
+	}
+}
+
+// alertsHandler handles GET /alerts - the state of the alert rules
+func alertsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, alertEngine.Alerts())
+}
+
+// usageHandler handles GET /usage - the bytes transferred per user, tag or
+// domain, by day or month
+func usageHandler(c *gin.Context) {
+	q, err := usage.ParseQuery(c.Query("by"), c.Query("period"), c.Query("since"), c.Query("until"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid usage query",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	records, err := dbManager.GetUsage(q.By, q.Since, q.Until)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Failed to get usage",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, usage.Report(q, records, usageCostPerGB))
+}
+
+// alertMetrics measures what alert rules watch on this replica
+func alertMetrics(ctx context.Context) (map[string]float64, error) {
+	now := time.Now()
+	metrics := alertTracker.Metrics(now)
+	var queued, running int
+	for _, managed := range downloadManager.GetAllDownloads() {
+		managed.Mutex.RLock()
+		switch managed.Status {
+		case lifecycle.Queued:
+			queued++
+		case lifecycle.Downloading:
+			running++
+		}
+		managed.Mutex.RUnlock()
+	}
+	metrics[alerts.QueueDepth] = float64(queued)
+	metrics[alerts.RunningDownloads] = float64(running)
+	alerts.AddSpeeds(metrics, activity.Running(now))
+	return metrics, nil
+}
+
+// startAlerts evaluates cfg's rules every interval, printing and posting
+// to the webhooks when they fire and resolve
+func startAlerts(cfg alerts.Config) {
+	notifiers := []alerts.Notifier{alerts.NotifierFunc(func(ctx context.Context, n alerts.Notification) error {
+		fmt.Println(alerts.Text(n))
+		return nil
+	})}
+	for _, url := range cfg.Webhooks {
+		notifiers = append(notifiers, &alerts.Webhook{URL: url})
+	}
+	alertEngine = alerts.NewEngine(node.ID, cfg.Rules, notifiers...)
+	alertEngine.OnError = func(n alerts.Notification, err error) {
+		fmt.Printf("Error sending alert %s: %v\n", n.Rule.Name, err)
+	}
+	go alertEngine.Run(context.Background(), cfg.Interval, alertMetrics, func(err error) {
+		fmt.Printf("Error measuring alert metrics: %v\n", err)
+	})
+}
+
+// newRouter creates a router with the middleware every route goes through
+func newRouter() *gin.Engine {
 	router := gin.New()
 	
 	// Add middleware
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 	
-	// Add CORS middleware for web clients
+	// Add CORS headers for the allowed web origins, and refuse browser
+	// requests that change something from other origins or, in CSRF mode,
+	// without the token
+	router.Use(func(c *gin.Context) {
+		if err := browserPolicy.Apply(c.Writer, c.Request); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Browser request refused",
+				"details": err.Error(),
+			})
+			return
+		}
+		
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		
+		c.Next()
+	})
+	
+	// Refuse request bodies past the limit before they are read
+	router.Use(func(c *gin.Context) {
+		if err := openapi.LimitBody(c.Writer, c.Request, maxBodyBytes); err != nil {
+			invalidBody(err).write(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+	
+	// Name the client in the history of the downloads it changes
 	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Request = c.Request.WithContext(events.WithActor(c.Request.Context(), c.ClientIP()))
+		c.Next()
+	})
+	
+	return router
+}
+
+// adminMiddleware lets only the requests policy allows through to an admin
+// route
+func adminMiddleware(policy access.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := policy.Check(c.Request)
+		if errors.Is(err, access.ErrUnauthorized) {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "Admin token required",
+				"details": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Admin API not allowed",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// versionedRoute pairs an API route with the handler that serves it
+type versionedRoute struct {
+	apiversion.Route
+	handler gin.HandlerFunc
+}
+
+// mountVersionedRoutes registers every route once per API version it exists
+// in and once at its legacy path, negotiating the version of each request
+// before middleware and the handler run
+func mountVersionedRoutes(router *gin.Engine, routes []versionedRoute, middleware ...gin.HandlerFunc) {
+	table := make([]apiversion.Route, len(routes))
+	for i, route := range routes {
+		table[i] = route.Route
+	}
+	
+	for _, mount := range apiversion.Mounts(table) {
+		handlers := append([]gin.HandlerFunc{versionMiddleware(mount)}, middleware...)
+		router.Handle(mount.Method, mount.Path, append(handlers, routes[mount.Route].handler)...)
+	}
+}
+
+// versionMiddleware negotiates the API version for a mount and stores it in
+// the request context for apiVersion
+func versionMiddleware(mount apiversion.Mount) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, err := mount.Negotiate(c.Writer.Header(), c.Request, apiversion.DefaultPolicy)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "Unsupported API version",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.Set("api_version", string(version))
+		c.Next()
+	}
+}
+
+// apiVersion returns the API version negotiated for the request
+func apiVersion(c *gin.Context) string {
+	if version := c.GetString("api_version"); version != "" {
+		return version
+	}
+	return string(apiversion.V1)
+}
+
+// specHandler handles GET /openapi.json - the document for the negotiated version
+func specHandler(c *gin.Context) {
+	openapi.WriteSpec(c.Writer, openapi.ServerDirect, apiVersion(c))
+}
+
+// reconcileStateDir compares the progress files in stateDir with the database
+// before incomplete downloads are resumed. Downloads only a progress file knows
+// about get their record back so they resume with the rest; progress files of
+// finished downloads are removed.
+func reconcileStateDir() {
+	states, err := reconcile.ScanDir(stateDir)
+	if err != nil {
+		fmt.Printf("Error scanning state directory: %v\n", err)
+		return
+	}
+	
+	dbRecords, err := GetAllDownloadsFromDB()
+	if err != nil {
+		fmt.Printf("Error loading downloads for reconciliation: %v\n", err)
+		return
+	}
+	records := make([]reconcile.Record, len(dbRecords))
+	for i, dbRecord := range dbRecords {
+		records[i] = reconcile.Record{ID: dbRecord.ID, Status: dbRecord.Status}
+	}
+	
+	for _, decision := range reconcile.Plan(records, states) {
+		switch decision.Action {
+		case reconcile.Adopt:
+			progress := decision.State.Progress
+			if _, err := SaveDownload(decision.ID, progress.URL, progress.Filename, progress.NumThreads); err != nil {
+				// Another replica sharing the directory may have adopted it first
+				fmt.Printf("Error adopting download %s: %v\n", decision.ID, err)
+				continue
+			}
+		case reconcile.Discard:
+			if err := os.Remove(decision.State.Path); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Error removing progress file %s: %v\n", decision.State.Path, err)
+				continue
+			}
+		}
+		fmt.Printf("Reconciled download %s: %s (%s)\n", decision.ID, decision.Action, decision.Reason)
+	}
+}
+
+// resumeIncompleteDownloads claims the incomplete downloads that no live
+// replica runs and resumes them here. At startup that includes paused
+// downloads; later runs only adopt downloads whose owner stopped renewing its
+// lease in the middle of downloading.
+func resumeIncompleteDownloads(startup bool) {
+	if startup {
+		fmt.Println("Checking for incomplete downloads to resume...")
+	}
+	
+	incompleteDownloads, err := GetIncompleteDownloadsFromDB()
+	if err != nil {
+		fmt.Printf("Error loading incomplete downloads: %v\n", err)
+		return
+	}
+	
+	var claimed []Download
+	for _, dbRecord := range incompleteDownloads {
+		if _, running := downloadManager.GetDownload(dbRecord.ID); running {
+			continue
+		}
+		if !startup && dbRecord.Status != lifecycle.Downloading {
+			continue
+		}
+		if !node.CanClaim(dbRecord.Lease(), time.Now()) {
+			continue
+		}
+		if ok, err := dbManager.ClaimDownload(dbRecord.ID, node, leaseTTL); err != nil || !ok {
+			continue
+		}
+		claimed = append(claimed, dbRecord)
+	}
+	
+	if len(claimed) == 0 {
+		if startup {
+			fmt.Println("No incomplete downloads found.")
+		}
+		return
+	}
+	
+	fmt.Printf("Found %d incomplete downloads. Resuming...\n", len(claimed))
+	
+	for i := range claimed {
+		resumeFromRecord(&claimed[i])
+	}
+}
+
+// resumeFromRecord starts a download this replica has claimed from its
+// database record, continuing from the progress file if there is one
+func resumeFromRecord(dbRecord *Download) *ManagedDownload {
+	// Create downloader instance
+	dl := downloader.NewDownloader(sandboxServer.URL(dbRecord.URL), dbRecord.OutputPath, dbRecord.Threads)
+	if pieces := dbRecord.Pieces(); len(pieces) > 0 {
+		dl = downloader.NewJoinedDownloader(sandboxServer.URLs(pieces), dbRecord.OutputPath, dbRecord.Threads)
+	}
+	dl.ProgressFile = reconcile.StatePath(stateDir, dbRecord.ID)
+	if dbRecord.UserAgent != "" {
+		dl.UserAgent = dbRecord.UserAgent
+	}
+	dl.Referer = dbRecord.Referer
+	dl.CookieJar = cookieJar
+	dl.EncryptionKey = encryptionKey
+	if downloadCache != nil {
+		dl.Cache = downloadCache
+	}
+	dl.SharedLimiter = globalLimiter
+	dl.MinPartSize = getSettings().MinPartSize
+	dl.CaptureEnvironment = true
+	if err := dl.SetRequest(dbRecord.Method, dbRecord.RequestBody, dbRecord.BodyType); err != nil {
+		fmt.Printf("Ignoring request body of download %s: %v\n", dbRecord.ID, err)
+	}
+	if err := domainRules.Resolve(dbRecord.URL).Apply(dl); err != nil {
+		fmt.Printf("Ignoring domain rules of download %s: %v\n", dbRecord.ID, err)
+	}
+	if dbRecord.RefreshURL != "" {
+		dl.RefreshLink = downloader.EndpointRefresher(dbRecord.RefreshURL)
+	}
+	
+	// Add to manager
+	managed := downloadManager.AddDownload(dbRecord.ID, dl, dbRecord)
+	eventBus.Publish(managed.event(events.Started))
+	
+	// Start download in goroutine
+	go func(downloadID string, managed *ManagedDownload) {
+		defer func() {
+			if r := recover(); r != nil {
+				managed.Mutex.Lock()
+				managed.Error = fmt.Errorf("panic during resume: %v", r)
+				managed.setStatus(lifecycle.Failed, managed.Error.Error())
+				managed.Mutex.Unlock()
+			}
+		}()
 		
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		// Wait while this replica runs as many downloads as it may
+		if !waitForSlot(managed) {
 			return
 		}
+		defer downloadSlots.Release()
 		
-		c.Next()
-	})
+		// Start periodic progress events
+		progressTicker := time.NewTicker(events.ProgressInterval)
+		defer progressTicker.Stop()
+		
+		go func() {
+			for {
+				select {
+				case <-managed.Context.Done():
+					return
+				case <-progressTicker.C:
+					managed.Mutex.RLock()
+					if managed.Downloader.Progress != nil {
+						eventBus.Publish(managed.event(events.Progress))
+					}
+					managed.Mutex.RUnlock()
+				}
+			}
+		}()
+		
+		// Load existing progress
+		if err := dl.LoadOrCreateProgress(); err != nil {
+			managed.Mutex.Lock()
+			managed.Error = fmt.Errorf("failed to load progress: %w", err)
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
+			managed.Mutex.Unlock()
+			return
+		}
+		recordThreads(dbRecord.ID, dl)
+		recordEnvironment(dbRecord.ID, dl)
+		
+		// Resume download
+		if err := dl.Download(); err != nil {
+			managed.Mutex.Lock()
+			managed.Error = fmt.Errorf("resume failed: %w", err)
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
+			managed.Mutex.Unlock()
+			return
+		}
+		
+		// Verify download
+		err := dl.VerifyDownload()
+		recordChecksum(downloadID, dl)
+		if err != nil {
+			managed.Mutex.Lock()
+			managed.Error = fmt.Errorf("verification failed: %w", err)
+			managed.setStatus(lifecycle.Failed, managed.Error.Error())
+			managed.Mutex.Unlock()
+			return
+		}
+		recordEnvironment(downloadID, dl)
+		
+		managed.Mutex.Lock()
+		managed.setStatus(lifecycle.Completed, "")
+		managed.Mutex.Unlock()
+		
+		fmt.Printf("Resumed download completed: %s\n", downloadID)
+	}(dbRecord.ID, managed)
 	
-	// API routes
-	api := router.Group("/api/v1")
-	{
-		api.GET("/health", healthHandler)
-		api.POST("/downloads", startDownloadHandler)
-		api.GET("/downloads", listDownloadsHandler)
-		api.GET("/downloads/:id/status", getDownloadStatusHandler)
-		api.POST("/downloads/:id/pause", pauseDownloadHandler)
-		api.POST("/downloads/:id/resume", resumeDownloadHandler)
-		api.DELETE("/downloads/:id", deleteDownloadHandler)
-		api.GET("/stats", statsHandler)
-	}
-	
-	// Legacy routes (without /api/v1 prefix) for backward compatibility
-	router.POST("/downloads", startDownloadHandler)
-	router.GET("/downloads", listDownloadsHandler)
-	router.GET("/downloads/:id/status", getDownloadStatusHandler)
-	router.POST("/downloads/:id/pause", pauseDownloadHandler)
-	router.POST("/downloads/:id/resume", resumeDownloadHandler)
-	router.DELETE("/downloads/:id", deleteDownloadHandler)
-	router.GET("/stats", statsHandler)
-	router.GET("/health", healthHandler)
+	fmt.Printf("Resumed download: %s (%s)\n", dbRecord.ID, secrets.RedactURL(dbRecord.URL))
 	
-	return router
+	return managed
 }
 
-// resumeIncompleteDownloads loads incomplete downloads from database and resumes them
-func resumeIncompleteDownloads() {
-	fmt.Println("Checking for incomplete downloads to resume...")
+// waitForSlot holds a download while max_concurrent_downloads others
+// transfer, showing it as queued meanwhile. It reports false when the
+// download was removed while it waited.
+func waitForSlot(managed *ManagedDownload) bool {
+	if downloadSlots.TryAcquire() {
+		return true
+	}
 	
-	incompleteDownloads, err := GetIncompleteDownloadsFromDB()
+	managed.Mutex.Lock()
+	managed.setStatus(lifecycle.Queued, "")
+	ctx := managed.Context
+	managed.Mutex.Unlock()
+	
+	if !downloadSlots.Acquire(ctx) {
+		return false
+	}
+	
+	managed.Mutex.Lock()
+	defer managed.Mutex.Unlock()
+	if err := managed.setStatus(lifecycle.Downloading, ""); err != nil {
+		downloadSlots.Release()
+		return false
+	}
+	return true
+}
+
+// getSettings returns the runtime settings in effect
+func getSettings() settings.Settings {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return currentSettings
+}
+
+// useSettings makes next the settings in effect; the caller holds settingsMutex
+func useSettings(next settings.Settings) {
+	if next.GlobalRateLimit != currentSettings.GlobalRateLimit {
+		globalLimiter.SetRate(next.GlobalRateLimit)
+	}
+	if next.MaxConcurrentDownloads != currentSettings.MaxConcurrentDownloads {
+		downloadSlots.SetLimit(next.MaxConcurrentDownloads)
+	}
+	currentSettings = next
+}
+
+// loadSettings applies the settings stored in the database, including those
+// another replica changed
+func loadSettings() error {
+	values, err := dbManager.LoadSettings()
 	if err != nil {
-		fmt.Printf("Error loading incomplete downloads: %v\n", err)
+		return err
+	}
+	loaded, err := settings.Parse(values)
+	if err != nil {
+		return err
+	}
+	
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	useSettings(loaded)
+	return nil
+}
+
+// settingsResponse describes s in the shape of the API
+func settingsResponse(s settings.Settings) openapi.Settings {
+	return openapi.Settings{
+		GlobalRateLimit:        s.GlobalRateLimit,
+		MaxConcurrentDownloads: s.MaxConcurrentDownloads,
+		DefaultThreads:         s.DefaultThreads,
+		RetentionDays:          s.RetentionDays,
+		MinPartSize:            s.MinPartSize,
+	}
+}
+
+// getSettingsHandler handles GET /settings
+func getSettingsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, settingsResponse(getSettings()))
+}
+
+// updateSettingsHandler handles PATCH /settings - changes runtime settings
+// and records every change in the audit log
+func updateSettingsHandler(c *gin.Context) {
+	var req openapi.SettingsUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).write(c)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		invalidBody(err).write(c)
+		return
+	}
+	if dbManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database not available",
+		})
 		return
 	}
 	
-	if len(incompleteDownloads) == 0 {
-		fmt.Println("No incomplete downloads found.")
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	
+	next := currentSettings
+	if req.GlobalRateLimit != nil {
+		next.GlobalRateLimit = *req.GlobalRateLimit
+	}
+	if req.MaxConcurrentDownloads != nil {
+		next.MaxConcurrentDownloads = *req.MaxConcurrentDownloads
+	}
+	if req.DefaultThreads != nil {
+		next.DefaultThreads = *req.DefaultThreads
+	}
+	if req.RetentionDays != nil {
+		next.RetentionDays = *req.RetentionDays
+	}
+	if req.MinPartSize != nil {
+		next.MinPartSize = *req.MinPartSize
+	}
+	
+	changes := settings.Diff(currentSettings, next)
+	if len(changes) == 0 {
+		c.JSON(http.StatusOK, settingsResponse(currentSettings))
 		return
 	}
 	
-	fmt.Printf("Found %d incomplete downloads. Resuming...\n", len(incompleteDownloads))
+	now := time.Now()
+	audit := make([]AuditEntry, len(changes))
+	for i, change := range changes {
+		audit[i] = AuditEntry{
+			Time:     now,
+			Actor:    c.ClientIP(),
+			Action:   auditActionSettings,
+			Target:   change.Name,
+			OldValue: change.Old,
+			NewValue: change.New,
+		}
+	}
+	if err := dbManager.SaveSettings(next.Values(), audit); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save settings",
+			"details": err.Error(),
+		})
+		return
+	}
 	
-	for _, dbRecord := range incompleteDownloads {
-		// Create downloader instance
-		dl := downloader.NewDownloader(dbRecord.URL, dbRecord.OutputPath, dbRecord.Threads)
-		
-		// Add to manager
-		managed := downloadManager.AddDownload(dbRecord.ID, dl, &dbRecord)
-		
-		// Start download in goroutine
-		go func(downloadID string, managed *ManagedDownload) {
-			defer func() {
-				if r := recover(); r != nil {
-					managed.Mutex.Lock()
-					managed.Status = "failed"
-					managed.Error = fmt.Errorf("panic during resume: %v", r)
-					UpdateStatus(downloadID, "failed", managed.Error.Error())
-					managed.Mutex.Unlock()
-				}
-			}()
-			
-			// Start periodic progress updates to database
-			progressTicker := time.NewTicker(3 * time.Second)
-			defer progressTicker.Stop()
-			
-			go func() {
-				for {
-					select {
-					case <-managed.Context.Done():
-						return
-					case <-progressTicker.C:
-						managed.Mutex.RLock()
-						if managed.Downloader.Progress != nil {
-							bytesDownloaded := managed.Downloader.Progress.GetTotalDownloaded()
-							totalBytes := managed.Downloader.Progress.TotalSize
-							status := managed.Status
-							UpdateProgress(downloadID, bytesDownloaded, totalBytes, status)
-						}
-						managed.Mutex.RUnlock()
-					}
-				}
-			}()
-			
-			// Load existing progress
-			if err := dl.LoadOrCreateProgress(); err != nil {
-				managed.Mutex.Lock()
-				managed.Status = "failed"
-				managed.Error = fmt.Errorf("failed to load progress: %w", err)
-				UpdateStatus(downloadID, "failed", managed.Error.Error())
-				managed.Mutex.Unlock()
-				return
-			}
-			
-			// Resume download
-			if err := dl.Download(); err != nil {
-				managed.Mutex.Lock()
-				managed.Status = "failed"
-				managed.Error = fmt.Errorf("resume failed: %w", err)
-				UpdateStatus(downloadID, "failed", managed.Error.Error())
-				managed.Mutex.Unlock()
-				return
-			}
-			
-			// Verify download
-			if err := dl.VerifyDownload(); err != nil {
-				managed.Mutex.Lock()
-				managed.Status = "failed"
-				managed.Error = fmt.Errorf("verification failed: %w", err)
-				UpdateStatus(downloadID, "failed", managed.Error.Error())
-				managed.Mutex.Unlock()
-				return
-			}
-			
-			managed.Mutex.Lock()
-			managed.Status = "completed"
-			// Update database with completion
-			if managed.Downloader.Progress != nil {
-				UpdateProgress(downloadID, managed.Downloader.Progress.TotalSize, managed.Downloader.Progress.TotalSize, "completed")
-			} else {
-				UpdateStatus(downloadID, "completed", "")
-			}
-			managed.Mutex.Unlock()
-			
-			fmt.Printf("Resumed download completed: %s\n", downloadID)
-		}(dbRecord.ID, managed)
-		
-		fmt.Printf("Resumed download: %s (%s)\n", dbRecord.ID, dbRecord.URL)
+	useSettings(next)
+	for _, change := range changes {
+		fmt.Printf("Setting %s changed from %s to %s by %s\n", change.Name, change.Old, change.New, c.ClientIP())
+	}
+	c.JSON(http.StatusOK, settingsResponse(next))
+}
+
+// listDomainRulesHandler handles GET /domain-rules - the per-host defaults,
+// with header values redacted
+func listDomainRulesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, openapi.DomainRules{Rules: domainrules.Redact(domainRules.Rules())})
+}
+
+// replaceDomainRulesHandler handles PUT /domain-rules - replaces the
+// per-host defaults and records the change in the audit log
+func replaceDomainRulesHandler(c *gin.Context) {
+	var req openapi.DomainRules
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).write(c)
+		return
+	}
+	
+	old := domainrules.Redact(domainRules.Rules())
+	rules, err := domainRules.Replace(req.Rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid domain rules",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	redacted := domainrules.Redact(rules)
+	if dbManager != nil {
+		oldValue, _ := json.Marshal(old)
+		newValue, _ := json.Marshal(redacted)
+		entry := AuditEntry{
+			Time:     time.Now(),
+			Actor:    c.ClientIP(),
+			Action:   auditActionDomainRules,
+			Target:   "domain_rules",
+			OldValue: string(oldValue),
+			NewValue: string(newValue),
+		}
+		if err := dbManager.RecordAudit([]AuditEntry{entry}); err != nil {
+			fmt.Printf("Error recording domain rules change: %v\n", err)
+		}
+	}
+	fmt.Printf("Domain rules replaced by %s: %d rules\n", c.ClientIP(), len(rules))
+	c.JSON(http.StatusOK, openapi.DomainRules{Rules: redacted})
+}
+
+// matchDomainRulesHandler handles GET /domain-rules/match - what the rules
+// give a download from a URL
+func matchDomainRulesHandler(c *gin.Context) {
+	rawURL := c.Query("url")
+	if parsed, err := url.Parse(rawURL); err != nil || parsed.Hostname() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid URL",
+			"details": "url must be an absolute URL with a host",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, domainRules.Resolve(rawURL).Response(rawURL))
+}
+
+// probeHandler handles GET /probe - asks the server of a URL what it offers
+// for the file, replacing the probe downloads reuse
+func probeHandler(c *gin.Context) {
+	rawURL := c.Query("url")
+	if parsed, err := url.Parse(rawURL); err != nil || parsed.Hostname() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid URL",
+			"details": "url must be an absolute URL with a host",
+		})
+		return
+	}
+	
+	dl := downloader.NewDownloader(sandboxServer.URL(rawURL), "", 1)
+	dl.Probes = probeCache
+	probe, err := dl.Probe(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":   "Failed to probe URL",
+			"details": secrets.RedactText(err.Error()),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewProbe(probe))
+}
+
+// listAuditEntriesHandler handles GET /audit - recent changes, newest first
+func listAuditEntriesHandler(c *gin.Context) {
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid limit",
+				"details": "limit must be a number from 1 to 1000",
+			})
+			return
+		}
+		limit = n
+	}
+	if dbManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database not available",
+		})
+		return
+	}
+	
+	entries, err := dbManager.GetAuditEntries(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to get audit log",
+			"details": err.Error(),
+		})
+		return
 	}
+	
+	response := openapi.AuditLog{Entries: make([]openapi.AuditEntry, len(entries)), Count: len(entries)}
+	for i, entry := range entries {
+		response.Entries[i] = openapi.AuditEntry{
+			ID:       int(entry.ID),
+			Time:     entry.Time.Format(time.RFC3339),
+			Actor:    entry.Actor,
+			Action:   entry.Action,
+			Target:   entry.Target,
+			OldValue: entry.OldValue,
+			NewValue: entry.NewValue,
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // statsHandler handles GET /stats (bonus endpoint)
@@ -704,22 +2548,199 @@ func statsHandler(c *gin.Context) {
 	})
 }
 
+// openCacheFromEnv opens the download cache in dir with the limit in
+// CACHE_MAX_SIZE and copies instead of links when CACHE_COPY is set
+func openCacheFromEnv(dir string) (*cache.Cache, error) {
+	var size int64
+	if raw := os.Getenv("CACHE_MAX_SIZE"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid CACHE_MAX_SIZE %q: want a number of bytes", raw)
+		}
+		size = n
+	}
+	c, err := cache.Open(dir, size)
+	if err != nil {
+		return nil, err
+	}
+	c.Copy = os.Getenv("CACHE_COPY") == "true"
+	return c, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore(os.Args[2:])
+		return
+	}
+	
 	fmt.Println("Multithreaded Downloader REST API Server")
 	fmt.Println("========================================")
 	
-	// Initialize database
-	if err := InitDatabase("downloads.db"); err != nil {
+	// Configure the User-Agent/Referer spoofing policy
+	policy, err := downloader.ParseSpoofingPolicy(os.Getenv("SPOOFING_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid SPOOFING_POLICY: %v", err)
+	}
+	spoofingPolicy = policy
+	
+	// Per-host defaults for downloads
+	if rulesFile := os.Getenv("DOMAIN_RULES_FILE"); rulesFile != "" {
+		store, err := domainrules.NewStore(rulesFile)
+		if err != nil {
+			log.Fatalf("Invalid DOMAIN_RULES_FILE: %v", err)
+		}
+		domainRules = store
+		fmt.Printf("Domain rules: %d from %s\n", len(store.Rules()), rulesFile)
+	}
+	
+	// Encrypt downloads at rest when a key is configured
+	if keyFile := os.Getenv("ENCRYPTION_KEY_FILE"); keyFile != "" {
+		key, err := downloader.LoadEncryptionKey(keyFile)
+		if err != nil {
+			log.Fatalf("Invalid ENCRYPTION_KEY_FILE: %v", err)
+		}
+		encryptionKey = key
+		fmt.Println("🔒 Downloads are encrypted at rest")
+	}
+	
+	// Link repeated downloads of the same file from a shared cache
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		c, err := openCacheFromEnv(dir)
+		if err != nil {
+			log.Fatalf("Invalid cache settings: %v", err)
+		}
+		downloadCache = c
+		fmt.Printf("Download cache: %s (up to %d bytes)\n", dir, c.MaxSize)
+	}
+	
+	// Sandbox mode fetches every download from a local payload generator
+	if os.Getenv("SANDBOX_MODE") == "true" {
+		profile, err := sandbox.ProfileFromEnv()
+		if err != nil {
+			log.Fatalf("Invalid sandbox settings: %v", err)
+		}
+		addr := os.Getenv("SANDBOX_ADDR")
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		sandboxServer, err = sandbox.Start(addr, profile)
+		if err != nil {
+			log.Fatalf("Failed to start sandbox: %v", err)
+		}
+		defer sandboxServer.Close()
+		fmt.Printf("🧪 Sandbox mode: files are generated on %s, %d bytes each unless the URL asks otherwise\n", sandboxServer.Addr(), profile.Size)
+	}
+	
+	// Initialize database; replicas share downloads through PostgreSQL
+	if postgresURL := os.Getenv("POSTGRES_URL"); postgresURL != "" {
+		if err := InitPostgreSQLDatabase(postgresURL); err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+	} else if err := InitDatabase("downloads.db"); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	
+	// Identify this replica; NODE_URL lets the others forward commands to it
+	node = cluster.Node{ID: os.Getenv("NODE_ID"), URL: os.Getenv("NODE_URL")}
+	if node.ID == "" {
+		if hostname, err := os.Hostname(); err == nil && hostname != "" {
+			node.ID = "api-" + hostname
+		} else {
+			node.ID = "api-" + uuid.New().String()[:8]
+		}
+	}
+	fmt.Printf("Replica %s\n", node.ID)
 	defer func() {
 		if dbManager != nil {
 			dbManager.Close()
 		}
 	}()
 	
-	// Resume incomplete downloads
-	resumeIncompleteDownloads()
+	// Reuse range checks of URLs downloaded shortly before by any replica
+	probeTTL := DefaultProbeCacheTTL
+	if raw := os.Getenv("PROBE_CACHE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl < 0 {
+			log.Fatalf("Invalid PROBE_CACHE_TTL %q: want a duration such as 10m, or 0 to probe every download", raw)
+		}
+		probeTTL = ttl
+	}
+	if probeTTL > 0 {
+		probeCache = dbManager.ProbeCache(probeTTL)
+	}
+	
+	// Record lifecycle events in the database, progress in batches, and
+	// keep the live counters listings read instead
+	database := events.NewBatcher(dbProgressBatch, dbManager.ApplyProgressBatch, dbManager.ApplyEvent)
+	defer database.Close()
+	eventBus.Subscribe("database", eventBus.Local(database.Handle))
+	eventBus.Subscribe("history", eventBus.Local(dbManager.RecordEvent), events.Transitions...)
+	eventBus.Subscribe("activity", activity.Handle)
+	eventBus.Subscribe("watcher", statusWatcher.Handle)
+	eventBus.Subscribe("alerts", alertTracker.Handle, alerts.TrackedEvents...)
+	
+	// Account the bytes this replica's downloads transfer
+	usageCostPerGB, err = usage.CostFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid usage settings: %v", err)
+	}
+	meter := usage.NewMeter(dbManager.UsageAttribution, dbManager)
+	meter.OnError = func(err error) {
+		fmt.Printf("Error accounting transfer usage: %v\n", err)
+	}
+	eventBus.Subscribe("usage", eventBus.Local(meter.Handle), usage.MeteredEvents...)
+	go meter.Run(context.Background(), usage.DefaultFlushInterval)
+	defer meter.Flush()
+	defer eventBus.Close()
+	
+	// Pick up downloads left behind by the previous run, then resume them
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		stateDir = dir
+	}
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		log.Fatalf("Failed to create state directory: %v", err)
+	}
+	// Apply the settings operators saved before resuming anything
+	if err := loadSettings(); err != nil {
+		fmt.Printf("Error loading settings, using defaults: %v\n", err)
+	}
+	
+	reconcileStateDir()
+	resumeIncompleteDownloads(true)
+	
+	// Keep this replica's leases alive and adopt downloads of replicas that stopped
+	go func() {
+		ticker := time.NewTicker(leaseTTL / 3)
+		defer ticker.Stop()
+		
+		for range ticker.C {
+			if err := dbManager.RenewLeases(node, leaseTTL); err != nil {
+				fmt.Printf("Error renewing download leases: %v\n", err)
+			}
+			// Pick up settings changed on other replicas
+			if err := loadSettings(); err != nil {
+				fmt.Printf("Error reloading settings: %v\n", err)
+			}
+			resumeIncompleteDownloads(false)
+		}
+	}()
+	
+	// Evaluate the alert rules
+	alertConfig, err := alerts.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid alert settings: %v", err)
+	}
+	if len(alertConfig.Rules) > 0 {
+		startAlerts(alertConfig)
+		fmt.Printf("Evaluating %d alert rules every %s\n", len(alertConfig.Rules), alertConfig.Interval)
+	}
+	
+	// Elect the replica that runs maintenance for the whole cluster
+	maintenance := leader.NewElector(dbManager.LeaderStore(), "maintenance", node.ID, leaseTTL)
+	maintenance.OnError = func(err error) {
+		fmt.Printf("Error electing maintenance leader: %v\n", err)
+	}
+	go maintenance.Run(context.Background())
 	
 	// Start cleanup routine for old completed downloads
 	go func() {
@@ -727,29 +2748,90 @@ func main() {
 		defer ticker.Stop()
 		
 		for range ticker.C {
-			if err := dbManager.CleanupCompletedDownloads(7 * 24 * time.Hour); err != nil {
+			if !maintenance.IsLeader() {
+				continue
+			}
+			if err := dbManager.CleanupCompletedDownloads(getSettings().Retention()); err != nil {
 				fmt.Printf("Error during cleanup: %v\n", err)
 			}
 		}
 	}()
 	
-	router := setupRoutes()
+	// Guard settings, backups and the other admin routes, and move them to
+	// a port of their own when ADMIN_ADDR is set
+	adminPolicy, err := access.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid admin access settings: %v", err)
+	}
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if browserPolicy, err = browser.PolicyFromEnv(); err != nil {
+		log.Fatalf("Invalid browser settings: %v", err)
+	}
+	if maxBodyBytes, err = openapi.MaxBodyBytesFromEnv(); err != nil {
+		log.Fatalf("Invalid request body limit: %v", err)
+	}
+	debugEndpoints = os.Getenv("DEBUG_ENDPOINTS") == "true"
+	router, adminRouter := setupRoutes(adminPolicy, adminAddr != "")
 	
-	// Start server
+	// Start server on port 8080, or the Unix socket in UNIX_SOCKET or the
+	// socket passed by systemd
 	port := "8080"
-	fmt.Printf("Server starting on port %s...\n", port)
-	fmt.Printf("API endpoints available at http://localhost:%s\n", port)
-	fmt.Println("\nAvailable endpoints:")
+	ln, err := listener.FromEnv(":" + port)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	fmt.Printf("Server listening on %s...\n", listener.Describe(ln))
+	adminAt := "on the same port"
+	if adminAddr != "" {
+		adminLn, err := listener.Open(adminAddr, 0600)
+		if err != nil {
+			log.Fatalf("Failed to start admin server: %v", err)
+		}
+		adminAt = "on " + listener.Describe(adminLn)
+		go func() {
+			if err := adminRouter.RunListener(adminLn); err != nil {
+				log.Fatalf("Failed to start admin server: %v", err)
+			}
+		}()
+	}
+	fmt.Println("\nAvailable endpoints (under /api/v1 and /api/v2; unversioned paths are deprecated v1 aliases):")
 	fmt.Println("  POST   /downloads           - Start a new download")
 	fmt.Println("  GET    /downloads           - List all downloads")
 	fmt.Println("  GET    /downloads/:id/status - Get download status")
 	fmt.Println("  POST   /downloads/:id/pause  - Pause a download")
 	fmt.Println("  POST   /downloads/:id/resume - Resume a download")
 	fmt.Println("  DELETE /downloads/:id        - Remove a download")
+	fmt.Println("  PATCH  /downloads/:id        - Change a download's rate limit or threads (v2)")
+	fmt.Println("  GET    /downloads/:id/parts  - Connection diagnostics of every part (v2)")
+	fmt.Println("  GET    /downloads/:id/map    - Bitmap and segments of the completed byte ranges (v2)")
+	fmt.Println("  GET    /downloads/:id/events - When the download was queued, started, requeued or finished (v2)")
+	fmt.Println("  POST   /downloads/:id/parts/:index/restart - Retry a stuck part's connection (v2)")
 	fmt.Println("  GET    /stats               - Download statistics")
+	fmt.Println("  GET    /probe?url=          - Range support, size and validators of a URL (v2)")
 	fmt.Println("  GET    /health              - Health check")
+	fmt.Println("  GET    /openapi.json        - OpenAPI document")
+	fmt.Println("  GET    /docs                - Swagger UI")
+	fmt.Printf("\nAdmin endpoints (%s):\n", adminAt)
+	fmt.Println("  GET    /settings            - Runtime settings (v2)")
+	fmt.Println("  PATCH  /settings            - Change runtime settings (v2)")
+	fmt.Println("  GET    /audit               - Recent changes to settings (v2)")
+	fmt.Println("  GET    /domain-rules        - Per-host download defaults (v2)")
+	fmt.Println("  PUT    /domain-rules        - Replace the per-host defaults (v2)")
+	fmt.Println("  GET    /domain-rules/match  - Defaults a URL gets from the rules (v2)")
+	fmt.Println("  GET    /downloads/export    - Export unfinished downloads as a manifest (v2)")
+	fmt.Println("  POST   /downloads/import    - Start the downloads of a manifest (v2)")
+	fmt.Println("  GET    /backup              - Archive of the database and progress files (v2)")
+	fmt.Println("  GET    /alerts              - State of the alert rules (v2)")
+	fmt.Println("  GET    /usage               - Bytes transferred per user, tag or domain (v2)")
+	fmt.Println("  POST   /cookies             - Import a cookies.txt file")
+	fmt.Println("  DELETE /cookies             - Clear imported cookies")
+	if debugEndpoints {
+		fmt.Println("  GET    /debug/pprof/        - CPU, heap and goroutine profiles")
+		fmt.Println("  GET    /debug/vars          - expvar variables")
+		fmt.Println("  GET    /debug/downloads     - Internal state of running downloads")
+	}
 	
-	if err := router.Run(":" + port); err != nil {
+	if err := router.RunListener(ln); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
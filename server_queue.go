@@ -2,84 +2,287 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"multithreaded-downloader/access"
+	"multithreaded-downloader/alerts"
+	"multithreaded-downloader/apiversion"
+	"multithreaded-downloader/browser"
+	"multithreaded-downloader/checksums"
+	"multithreaded-downloader/cron"
+	"multithreaded-downloader/diag"
+	"multithreaded-downloader/domainrules"
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/events"
+	"multithreaded-downloader/health"
+	"multithreaded-downloader/leader"
+	"multithreaded-downloader/lfs"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/listener"
+	"multithreaded-downloader/logging"
+	"multithreaded-downloader/openapi"
+	"multithreaded-downloader/robots"
+	"multithreaded-downloader/secrets"
+	"multithreaded-downloader/usage"
 )
 
-// QueuedDownloadRequest represents the JSON request body for starting a queued download
-type QueuedDownloadRequest struct {
-	URL     string `json:"url" binding:"required"`
-	Output  string `json:"output" binding:"required"`
-	Threads int    `json:"threads"`
-}
-
-// QueuedDownloadResponse represents the response when enqueueing a download
-type QueuedDownloadResponse struct {
-	JobID   string `json:"job_id"`
-	Message string `json:"message"`
-	Status  string `json:"status"`
-}
-
-// QueuedDownloadStatus represents the current status of a queued download
-type QueuedDownloadStatus struct {
-	JobID            string  `json:"job_id"`
-	URL              string  `json:"url"`
-	OutputPath       string  `json:"output_path"`
-	Status           string  `json:"status"` // "queued", "processing", "completed", "failed"
-	Progress         float64 `json:"progress"`
-	BytesDownloaded  int64   `json:"bytes_downloaded"`
-	TotalBytes       int64   `json:"total_bytes"`
-	ThreadsUsed      int     `json:"threads_used"`
-	CreatedAt        string  `json:"created_at"`
-	StartedAt        string  `json:"started_at,omitempty"`
-	CompletedAt      string  `json:"completed_at,omitempty"`
-	WorkerID         string  `json:"worker_id,omitempty"`
-	ErrorMessage     string  `json:"error_message,omitempty"`
-}
+// Request and response payloads are generated from openapi/openapi.json so
+// that this server, the direct server and the simple server cannot drift apart
+type (
+	QueuedDownloadRequest  = openapi.QueuedDownloadRequest
+	QueuedDownloadResponse = openapi.QueuedDownloadResponse
+	QueuedDownloadStatus   = openapi.QueuedDownloadStatus
+	GroupDownloadRequest   = openapi.GroupDownloadRequest
+	GroupDownloadResponse  = openapi.GroupDownloadResponse
+	GroupEntry             = openapi.GroupEntry
+)
 
 // QueuedDownloadServer represents the main server with queue integration
 type QueuedDownloadServer struct {
-	queueManager *QueueManager
-	dbManager    *DatabaseManager
-	logger       *zap.Logger
-	router       *gin.Engine
+	queueManager   *QueueManager
+	dbManager      *DatabaseManager
+	// events announces enqueued jobs and receives bridged worker events
+	events         *events.Bus
+	// activity keeps the live speed and connections of jobs from the
+	// bridged progress events
+	activity       *events.Activity
+	// watcher wakes long-polling status requests; nil when worker events
+	// are not bridged, so they re-read the queue instead
+	watcher        *events.Watcher
+	logger         *zap.Logger
+	router         *gin.Engine
+	// adminRouter serves the admin routes; the same as router unless they
+	// have a listener of their own
+	adminRouter    *gin.Engine
+	// adminPolicy decides who may use the admin routes
+	adminPolicy    access.Policy
+	// browserPolicy sets the CORS headers and CSRF checks of web pages
+	browserPolicy  browser.Policy
+	// maxBodyBytes is the largest request body read
+	maxBodyBytes   int64
+	// debugEndpoints serves pprof, expvar and /debug/downloads on the admin
+	// router
+	debugEndpoints bool
+	// downloadDir is where workers write downloads, when it is mounted on
+	// the server too; /health checks it and reports its free space
+	downloadDir    string
+	spoofingPolicy downloader.SpoofingPolicy
+	// secrets seals job credentials; nil when no master key is configured
+	secrets        *secrets.Box
+	// domainRules give jobs per-host defaults, read from DOMAIN_RULES_FILE
+	domainRules    *domainrules.Store
+	// probes, when set, holds the range checks workers reuse
+	probes         downloader.ProbeCache
+	// alerts evaluates the ALERT_RULES_FILE rules; alertTracker measures
+	// the failure rate and queue wait from the events they need
+	alerts         *alerts.Engine
+	alertTracker   *alerts.Tracker
+	// watchdog finds a queue that grows while the workers idle and
+	// suggests worker nodes to autoscalers; nil when QUEUE_STALL_WINDOW
+	// is 0. scaleWebhooks are told when its suggestion changes.
+	watchdog       *alerts.Watchdog
+	scaleWebhooks  []*alerts.Webhook
+	// scalingMetrics serves the watchdog's gauges on /metrics on the admin
+	// router
+	scalingMetrics bool
+	// usageCostPerGB is USAGE_COST_PER_GB, what usage reports estimate
+	// costs with
+	usageCostPerGB float64
 }
 
 // NewQueuedDownloadServer creates a new server instance
 func NewQueuedDownloadServer(queueManager *QueueManager, dbManager *DatabaseManager, logger *zap.Logger) *QueuedDownloadServer {
 	server := &QueuedDownloadServer{
-		queueManager: queueManager,
-		dbManager:    dbManager,
-		logger:       logger.With(zap.String("component", "server")),
+		queueManager:   queueManager,
+		dbManager:      dbManager,
+		events:         events.NewBus(serverNode()),
+		activity:       events.NewActivity(),
+		watcher:        events.NewWatcher(),
+		logger:         logger.With(zap.String("component", "server")),
+		spoofingPolicy: downloader.SpoofingAllowAny,
+		browserPolicy:  browser.DefaultPolicy,
+		maxBodyBytes:   openapi.DefaultMaxBodyBytes,
+		domainRules:    &domainrules.Store{},
+		alertTracker:   alerts.NewTracker(),
 	}
+	server.alerts = alerts.NewEngine(server.events.Node(), nil)
+	server.events.Subscribe("log", server.logEvent)
+	server.events.Subscribe("history", server.events.Local(dbManager.RecordEvent), events.Transitions...)
+	server.events.Subscribe("activity", server.activity.Handle)
+	server.events.Subscribe("watcher", server.watcher.Handle)
+	server.events.Subscribe("alerts", server.alertTracker.Handle, alerts.TrackedEvents...)
 	
-	server.setupRoutes()
 	return server
 }
 
-// setupRoutes configures the HTTP routes
-func (s *QueuedDownloadServer) setupRoutes() {
+// serverNode names this process on the event bus
+func serverNode() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return "api-" + hostname
+	}
+	return "api-" + uuid.New().String()[:8]
+}
+
+// logEvent logs lifecycle events from this and, when bridged, other nodes
+func (s *QueuedDownloadServer) logEvent(e events.Event) {
+	fields := []zap.Field{
+		zap.String("event", string(e.Type)),
+		zap.String("job_id", e.DownloadID),
+		zap.String("node", e.Node),
+	}
+	if e.Actor != "" {
+		fields = append(fields, zap.String("actor", e.Actor))
+	}
+	if e.Reason != "" {
+		fields = append(fields, zap.String("reason", e.Reason))
+	}
+	switch {
+	case e.Type == events.Progress:
+		s.logger.Debug("Download event", append(fields,
+			zap.Int64("bytes_downloaded", e.BytesDownloaded),
+			zap.Int64("total_bytes", e.TotalBytes))...)
+	case e.Error != "":
+		s.logger.Warn("Download event", append(fields, zap.String("error", e.Error))...)
+	default:
+		s.logger.Info("Download event", fields...)
+	}
+}
+
+// setupRoutes configures the HTTP routes. With separateAdmin the admin
+// routes get a router of their own; either way they only let in the
+// requests adminPolicy allows.
+func (s *QueuedDownloadServer) setupRoutes(separateAdmin bool) {
 	// Set Gin to release mode for production
 	gin.SetMode(gin.ReleaseMode)
 	
+	// Routes every router serves
+	shared := []versionedRoute{
+		{apiversion.Route{Method: "GET", Path: "/health"}, s.healthHandler},
+		{apiversion.Route{Method: "GET", Path: "/openapi.json"}, specHandler},
+		{apiversion.Route{Method: "GET", Path: "/docs"}, gin.WrapH(openapi.SwaggerUIHandler())},
+	}
+	
+	// API routes, mounted under every version prefix they exist in and at
+	// their deprecated unversioned path. Groups are part of v2 only.
+	router := s.newRouter()
+	mountVersionedRoutes(router, append(shared,
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/downloads"}, s.enqueueDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads"}, s.listDownloadsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/status"}, s.getDownloadStatusHandler},
+		versionedRoute{apiversion.Route{Method: "PATCH", Path: "/downloads/:id", Since: apiversion.V2}, s.adjustDownloadHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/parts", Since: apiversion.V2}, s.downloadPartsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/map", Since: apiversion.V2}, s.downloadMapHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/plan", Since: apiversion.V2}, s.downloadPlanHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/environment", Since: apiversion.V2}, s.downloadEnvironmentHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/downloads/:id/events", Since: apiversion.V2}, s.downloadEventsHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/downloads/:id/parts/:index/restart", Since: apiversion.V2}, s.restartPartHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/groups", Since: apiversion.V2}, s.enqueueGroupHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/groups/preview", Since: apiversion.V2}, s.previewGroupHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/groups/:id", Since: apiversion.V2}, s.getGroupStatusHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/groups/:id/checksums", Since: apiversion.V2}, s.groupChecksumsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/groups/:id/checksums.sig", Since: apiversion.V2}, s.groupSignatureHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/schedules", Since: apiversion.V2}, s.createScheduleHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/schedules", Since: apiversion.V2}, s.listSchedulesHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/schedules/:id", Since: apiversion.V2}, s.getScheduleHandler},
+		versionedRoute{apiversion.Route{Method: "DELETE", Path: "/schedules/:id", Since: apiversion.V2}, s.deleteScheduleHandler},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/schedules/:id/pause", Since: apiversion.V2}, s.pauseScheduleHandler(true)},
+		versionedRoute{apiversion.Route{Method: "POST", Path: "/schedules/:id/resume", Since: apiversion.V2}, s.pauseScheduleHandler(false)},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/schedules/:id/runs", Since: apiversion.V2}, s.scheduleRunsHandler},
+		versionedRoute{apiversion.Route{Method: "GET", Path: "/probe", Since: apiversion.V2}, s.probeHandler},
+	))
+	
+	adminRouter := router
+	if separateAdmin {
+		adminRouter = s.newRouter()
+		mountVersionedRoutes(adminRouter, shared)
+	}
+	mountVersionedRoutes(adminRouter, []versionedRoute{
+		{apiversion.Route{Method: "POST", Path: "/cookies"}, s.importCookiesHandler},
+		{apiversion.Route{Method: "DELETE", Path: "/cookies"}, s.clearCookiesHandler},
+		{apiversion.Route{Method: "GET", Path: "/domain-rules", Since: apiversion.V2}, s.listDomainRulesHandler},
+		{apiversion.Route{Method: "PUT", Path: "/domain-rules", Since: apiversion.V2}, s.replaceDomainRulesHandler},
+		{apiversion.Route{Method: "GET", Path: "/domain-rules/match", Since: apiversion.V2}, s.matchDomainRulesHandler},
+		{apiversion.Route{Method: "GET", Path: "/queue/stats"}, s.getQueueStatsHandler},
+		{apiversion.Route{Method: "GET", Path: "/queue/jobs", Since: apiversion.V2}, s.listQueuedJobsHandler},
+		{apiversion.Route{Method: "POST", Path: "/queue/jobs/:id/move-to-front", Since: apiversion.V2}, s.moveJobToFrontHandler},
+		{apiversion.Route{Method: "GET", Path: "/queue/completed", Since: apiversion.V2}, s.archivedJobsHandler(CompletedJobsQueue)},
+		{apiversion.Route{Method: "GET", Path: "/queue/failed", Since: apiversion.V2}, s.archivedJobsHandler(FailedJobsQueue)},
+		{apiversion.Route{Method: "GET", Path: "/workers/stats"}, s.getWorkerStatsHandler},
+		{apiversion.Route{Method: "GET", Path: "/alerts", Since: apiversion.V2}, s.alertsHandler},
+		{apiversion.Route{Method: "GET", Path: "/scaling", Since: apiversion.V2}, s.scalingHandler},
+		{apiversion.Route{Method: "GET", Path: "/usage", Since: apiversion.V2}, s.usageHandler},
+	}, s.adminMiddleware())
+	
+	// Profiles and internal state for support cases; not part of the API,
+	// so not versioned
+	if s.debugEndpoints {
+		adminRouter.Any("/debug/*path", s.adminMiddleware(), gin.WrapH(diag.Handler(s.debugState)))
+	}
+	// Gauges for the custom metrics adapters of autoscalers, which scrape
+	// a fixed path
+	if s.scalingMetrics {
+		adminRouter.GET("/metrics", s.adminMiddleware(), s.metricsHandler)
+	}
+	
+	s.router = router
+	s.adminRouter = adminRouter
+}
+
+// debugState dumps the queues and worker nodes for /debug/downloads. The
+// downloads themselves run in the workers, which serve their own dump on
+// DEBUG_ADDR.
+func (s *QueuedDownloadServer) debugState() interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), health.Timeout)
+	defer cancel()
+	state := gin.H{}
+	if stats, err := s.queueManager.GetQueueStats(ctx); err == nil {
+		state["queue_stats"] = stats
+	} else {
+		state["queue_stats_error"] = err.Error()
+	}
+	if nodes, err := s.queueManager.ListNodes(ctx); err == nil {
+		state["nodes"] = nodes
+	} else {
+		state["nodes_error"] = err.Error()
+	}
+	return state
+}
+
+// newRouter creates a router with the middleware every route goes through
+func (s *QueuedDownloadServer) newRouter() *gin.Engine {
 	router := gin.New()
 	
 	// Add middleware
 	router.Use(s.loggingMiddleware())
 	router.Use(gin.Recovery())
 	
-	// Add CORS middleware for web clients
+	// Add CORS headers for the allowed web origins, and refuse browser
+	// requests that change something from other origins or, in CSRF mode,
+	// without the token
 	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if err := s.browserPolicy.Apply(c.Writer, c.Request); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Browser request refused",
+				"details": err.Error(),
+			})
+			return
+		}
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -89,26 +292,105 @@ func (s *QueuedDownloadServer) setupRoutes() {
 		c.Next()
 	})
 	
-	// API routes
-	api := router.Group("/api/v1")
-	{
-		api.GET("/health", s.healthHandler)
-		api.POST("/downloads", s.enqueueDownloadHandler)
-		api.GET("/downloads", s.listDownloadsHandler)
-		api.GET("/downloads/:id/status", s.getDownloadStatusHandler)
-		api.GET("/queue/stats", s.getQueueStatsHandler)
-		api.GET("/workers/stats", s.getWorkerStatsHandler)
-	}
-	
-	// Legacy routes (without /api/v1 prefix) for backward compatibility
-	router.POST("/downloads", s.enqueueDownloadHandler)
-	router.GET("/downloads", s.listDownloadsHandler)
-	router.GET("/downloads/:id/status", s.getDownloadStatusHandler)
-	router.GET("/queue/stats", s.getQueueStatsHandler)
-	router.GET("/workers/stats", s.getWorkerStatsHandler)
-	router.GET("/health", s.healthHandler)
+	// Refuse request bodies past the limit before they are read
+	router.Use(func(c *gin.Context) {
+		if err := openapi.LimitBody(c.Writer, c.Request, s.maxBodyBytes); err != nil {
+			s.logger.Warn("Request body too large", zap.String("path", c.Request.URL.Path), zap.Error(err))
+			invalidBody(err).respond(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+	
+	// Name the client in the history of the downloads it changes
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(events.WithActor(c.Request.Context(), c.ClientIP()))
+		c.Next()
+	})
 	
-	s.router = router
+	return router
+}
+
+// adminMiddleware lets only the requests adminPolicy allows through to an
+// admin route, logging the ones it turns away
+func (s *QueuedDownloadServer) adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := s.adminPolicy.Check(c.Request)
+		if err != nil {
+			s.logger.Warn("Admin request refused",
+				zap.String("path", c.Request.URL.Path),
+				zap.String("remote_addr", c.Request.RemoteAddr),
+				zap.Error(err))
+		}
+		if errors.Is(err, access.ErrUnauthorized) {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "Admin token required",
+				"details": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Admin API not allowed",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// versionedRoute pairs an API route with the handler that serves it
+type versionedRoute struct {
+	apiversion.Route
+	handler gin.HandlerFunc
+}
+
+// mountVersionedRoutes registers every route once per API version it exists
+// in and once at its legacy path, negotiating the version of each request
+// before middleware and the handler run
+func mountVersionedRoutes(router *gin.Engine, routes []versionedRoute, middleware ...gin.HandlerFunc) {
+	table := make([]apiversion.Route, len(routes))
+	for i, route := range routes {
+		table[i] = route.Route
+	}
+	
+	for _, mount := range apiversion.Mounts(table) {
+		handlers := append([]gin.HandlerFunc{versionMiddleware(mount)}, middleware...)
+		router.Handle(mount.Method, mount.Path, append(handlers, routes[mount.Route].handler)...)
+	}
+}
+
+// versionMiddleware negotiates the API version for a mount and stores it in
+// the request context for apiVersion
+func versionMiddleware(mount apiversion.Mount) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, err := mount.Negotiate(c.Writer.Header(), c.Request, apiversion.DefaultPolicy)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "Unsupported API version",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.Set("api_version", string(version))
+		c.Next()
+	}
+}
+
+// apiVersion returns the API version negotiated for the request
+func apiVersion(c *gin.Context) string {
+	if version := c.GetString("api_version"); version != "" {
+		return version
+	}
+	return string(apiversion.V1)
+}
+
+// specHandler handles GET /openapi.json - the document for the negotiated version
+func specHandler(c *gin.Context) {
+	openapi.WriteSpec(c.Writer, openapi.ServerQueue, apiVersion(c))
 }
 
 // loggingMiddleware provides structured logging for HTTP requests
@@ -146,67 +428,237 @@ func (s *QueuedDownloadServer) enqueueDownloadHandler(c *gin.Context) {
 	var req QueuedDownloadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		s.logger.Warn("Invalid download request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid request body",
-			"details": err.Error(),
-		})
+		invalidBody(err).respond(c)
 		return
 	}
 	
-	// Set default threads if not specified
-	if req.Threads <= 0 {
-		req.Threads = 4
+	job, reqErr := s.newJob(&req, apiVersion(c), uuid.New().String())
+	if reqErr != nil {
+		reqErr.respond(c)
+		return
 	}
+	job.User = events.ActorFrom(c.Request.Context())
 	
-	// Validate threads count
-	if req.Threads > 16 {
-		s.logger.Warn("Too many threads requested", zap.Int("threads", req.Threads))
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Maximum 16 threads allowed",
-		})
+	jobStatus, reqErr := s.enqueueJob(c.Request.Context(), job)
+	if reqErr != nil {
+		reqErr.respond(c)
+		return
+	}
+	
+	s.logger.Info("Download job enqueued successfully",
+		zap.String("job_id", job.ID),
+		zap.String("url", job.URL),
+		zap.String("output", req.Output),
+		zap.Int("threads", req.Threads))
+	
+	resp := QueuedDownloadResponse{
+		JobID:   job.ID,
+		Message: "Download job enqueued successfully",
+		Status:  string(jobStatus),
+	}
+	// Tell what the worker will start from when it reuses an earlier probe
+	if s.probes != nil {
+		if probe, ok := s.probes.LoadProbe(req.URL); ok {
+			resp.Probe = openapi.NewCachedProbe(probe)
+		}
+	}
+	if apiVersion(c) == string(apiversion.V1) {
+		c.JSON(http.StatusCreated, resp.V1())
 		return
 	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// requestError is a request the server refuses, with the response status
+type requestError struct {
+	status  int
+	message string
+	details string
+	// fields lists the fields of a request body that are not valid
+	fields []openapi.FieldError
+}
+
+func (e *requestError) Error() string {
+	if e.details == "" {
+		return e.message
+	}
+	return e.message + ": " + e.details
+}
+
+// respond writes the error as the response
+func (e *requestError) respond(c *gin.Context) {
+	body := gin.H{
+		"error":   e.message,
+		"details": e.details,
+	}
+	if len(e.fields) > 0 {
+		body["fields"] = e.fields
+	}
+	c.JSON(e.status, body)
+}
+
+// invalidBody is the error for a request body that cannot be decoded or
+// breaks the OpenAPI document, listing the fields at fault
+func invalidBody(err error) *requestError {
+	if openapi.BodyTooLarge(err) {
+		return &requestError{status: http.StatusRequestEntityTooLarge, message: "Request body too large", details: err.Error()}
+	}
+	return &requestError{status: http.StatusBadRequest, message: "Invalid request body", details: err.Error(), fields: openapi.FieldErrors(err)}
+}
+
+// newJob checks a download request and turns it into a job with the given
+// ID, with the server's policies applied and its credentials sealed
+func (s *QueuedDownloadServer) newJob(req *QueuedDownloadRequest, version, jobID string) (*DownloadJob, *requestError) {
+	// Check the request against the OpenAPI document and fill in defaults
+	if err := req.Validate(); err != nil {
+		s.logger.Warn("Invalid download request", zap.Error(err))
+		return nil, invalidBody(err)
+	}
+	if err := req.CheckVersion(version); err != nil {
+		return nil, &requestError{status: http.StatusBadRequest, message: "Field not available in this API version", details: err.Error()}
+	}
+	if err := downloader.ValidateRefreshURL(req.RefreshURL); err != nil {
+		s.logger.Warn("Invalid download request", zap.Error(err))
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid refresh URL", details: err.Error()}
+	}
+	if err := downloader.ValidatePartURLs(req.URL, req.PartURLs); err != nil {
+		s.logger.Warn("Invalid download request", zap.Error(err))
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid part URLs", details: err.Error()}
+	}
+	if err := downloader.ValidateMirrors(req.URL, req.Mirrors); err != nil {
+		s.logger.Warn("Invalid download request", zap.Error(err))
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid mirrors", details: err.Error()}
+	}
+	if len(req.Mirrors) > 0 && len(req.PartURLs) > 0 {
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid mirrors", details: "mirrors cannot be used with part_urls"}
+	}
+	if err := usage.ValidateTags(req.Tags); err != nil {
+		s.logger.Warn("Invalid download request", zap.Error(err))
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid tags", details: err.Error()}
+	}
+	var plan *downloader.Plan
+	if req.Plan != nil {
+		var err error
+		if plan, err = req.Plan.DownloaderPlan(); err != nil {
+			return nil, &requestError{status: http.StatusBadRequest, message: "Invalid plan", details: err.Error()}
+		}
+	}
+	if req.OnlyIfModified && (len(req.PartURLs) > 0 || req.Body != "" || (req.Method != "" && !strings.EqualFold(req.Method, http.MethodGet))) {
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid download request", details: "only_if_modified needs a GET download of a single URL"}
+	}
+	// Domain rules may pick the threads of a request that does not
+	threads := req.Threads
+	req.ApplyDefaults()
+	
+	// Apply the server's User-Agent/Referer policy
+	userAgent, referer, err := s.spoofingPolicy.Resolve(req.UserAgentProfile, req.UserAgent, req.Referer)
+	if err != nil {
+		s.logger.Warn("Rejected request headers", zap.Error(err))
+		return nil, &requestError{status: http.StatusBadRequest, message: "Invalid request headers", details: err.Error()}
+	}
 	
-	// Generate unique job ID
-	jobID := uuid.New().String()
+	// Expand output templates now, so a requeued job keeps its path
+	output := req.Output
+	if downloader.HasOutputTemplate(output) {
+		dl := downloader.NewDownloader(req.URL, output, 1)
+		dl.UserAgent = userAgent
+		dl.Referer = referer
+		dl.Headers = req.Headers
+		// The method and body types were checked against the OpenAPI document
+		dl.SetRequest(req.Method, []byte(req.Body), req.BodyType)
+		if err := dl.ResolveOutputTemplate(map[string]string{"id": jobID}); err != nil {
+			return nil, &requestError{status: http.StatusBadRequest, message: "Invalid output template", details: err.Error()}
+		}
+		output = dl.Filename
+	}
 	
 	// Create download job
 	job := &DownloadJob{
 		ID:         jobID,
 		URL:        req.URL,
-		OutputPath: req.Output,
-		Threads:    req.Threads,
+		OutputPath: output,
+		Threads:    threads,
+		DependsOn:  req.DependsOn,
+		UserAgent:  userAgent,
+		Referer:    referer,
+		Headers:    req.Headers,
+		Method:     req.Method,
+		Body:       req.Body,
+		BodyType:   req.BodyType,
+		RefreshURL: req.RefreshURL,
+		PartURLs:   req.PartURLs,
+		Mirrors:    req.Mirrors,
+		MirrorCount: req.MirrorCount,
+		OnlyIfModified: req.OnlyIfModified,
+		Tags:       req.Tags,
+		Bind:       req.Bind,
+		BindRateLimit: req.BindRateLimit,
+		DoneMarker: req.DoneMarker,
+		Plan:       plan,
 	}
 	
-	// Enqueue the job
-	if err := s.queueManager.EnqueueJob(c.Request.Context(), job); err != nil {
+	s.applyDomainRules(job)
+	
+	// Never store credentials in plaintext
+	if reqErr := s.sealJob(job); reqErr != nil {
+		return nil, reqErr
+	}
+	return job, nil
+}
+
+// enqueueJob enqueues a job made by newJob, announces it and returns the
+// status it starts out in
+func (s *QueuedDownloadServer) enqueueJob(ctx context.Context, job *DownloadJob) (lifecycle.Status, *requestError) {
+	if err := s.queueManager.EnqueueJob(ctx, job); err != nil {
+		if errors.Is(err, ErrDependencyNotFound) || errors.Is(err, ErrDependencyFailed) || errors.Is(err, ErrDependencyCycle) {
+			s.logger.Warn("Rejected job dependencies", 
+				zap.String("job_id", job.ID),
+				zap.Error(err))
+			return "", &requestError{status: http.StatusBadRequest, message: "Invalid job dependencies", details: err.Error()}
+		}
+		
 		s.logger.Error("Failed to enqueue job", 
-			zap.String("job_id", jobID),
+			zap.String("job_id", job.ID),
 			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to enqueue download job",
-			"details": err.Error(),
-		})
-		return
+		return "", &requestError{status: http.StatusInternalServerError, message: "Failed to enqueue download job", details: err.Error()}
 	}
 	
-	s.logger.Info("Download job enqueued successfully",
-		zap.String("job_id", jobID),
-		zap.String("url", req.URL),
-		zap.String("output", req.Output),
-		zap.Int("threads", req.Threads))
-	
-	c.JSON(http.StatusCreated, QueuedDownloadResponse{
-		JobID:   jobID,
-		Message: "Download job enqueued successfully",
-		Status:  "queued",
-	})
+	// Jobs with pending dependencies start out waiting rather than queued
+	jobStatus := lifecycle.Queued
+	if len(job.DependsOn) > 0 {
+		if queueStatus, err := s.queueManager.GetJobStatus(ctx, job.ID); err == nil {
+			jobStatus = queueStatus.Status
+		}
+	}
+	s.events.Publish(events.Event{Type: events.Created, DownloadID: job.ID, Status: jobStatus, Actor: events.ActorFrom(ctx)})
+	return jobStatus, nil
 }
 
 // getDownloadStatusHandler handles GET /downloads/:id/status
 func (s *QueuedDownloadServer) getDownloadStatusHandler(c *gin.Context) {
 	jobID := c.Param("id")
 	
+	poll, err := events.ParsePoll(c.Query("wait"), c.Query("min_progress"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid long-poll parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+	snapshot := func() (events.Snapshot, bool) {
+		status, err := s.queueManager.GetJobStatus(c.Request.Context(), jobID)
+		if err != nil {
+			return events.Snapshot{}, false
+		}
+		return events.Snapshot{Status: status.Status, BytesDownloaded: status.BytesDownloaded, TotalBytes: status.TotalBytes}, true
+	}
+	if s.watcher != nil {
+		s.watcher.Wait(c.Request.Context(), jobID, poll, snapshot)
+	} else {
+		events.PollSnapshot(c.Request.Context(), poll, events.PollInterval, snapshot)
+	}
+	
 	// Get status from queue (Redis)
 	queueStatus, err := s.queueManager.GetJobStatus(c.Request.Context(), jobID)
 	if err != nil {
@@ -222,13 +674,14 @@ func (s *QueuedDownloadServer) getDownloadStatusHandler(c *gin.Context) {
 	// Convert to response format
 	status := QueuedDownloadStatus{
 		JobID:           queueStatus.ID,
-		Status:          queueStatus.Status,
+		Status:          string(queueStatus.Status),
 		Progress:        queueStatus.Progress,
 		BytesDownloaded: queueStatus.BytesDownloaded,
 		TotalBytes:      queueStatus.TotalBytes,
 		CreatedAt:       queueStatus.CreatedAt.Format(time.RFC3339),
 		WorkerID:        queueStatus.WorkerID,
-		ErrorMessage:    queueStatus.ErrorMessage,
+		ErrorMessage:    secrets.RedactText(queueStatus.ErrorMessage),
+		ThrottledByServer: queueStatus.ThrottledByServer,
 	}
 	
 	if !queueStatus.StartedAt.IsZero() {
@@ -239,175 +692,1879 @@ func (s *QueuedDownloadServer) getDownloadStatusHandler(c *gin.Context) {
 		status.CompletedAt = queueStatus.CompletedAt.Format(time.RFC3339)
 	}
 	
+	if deps, err := s.queueManager.GetJobDependencies(c.Request.Context(), jobID); err == nil && len(deps) > 0 {
+		status.DependsOn = deps
+	}
+	
 	// Try to get additional info from database
 	if dbRecord, err := s.dbManager.GetDownload(jobID); err == nil {
-		status.URL = dbRecord.URL
+		status.URL = secrets.RedactURL(dbRecord.URL)
 		status.OutputPath = dbRecord.OutputPath
 		status.ThreadsUsed = dbRecord.Threads
+		status.ThreadsRequested = requestedThreads(dbRecord)
+		addChecksum(&status, dbRecord)
+	}
+	if artifacts, err := s.dbManager.GetArtifacts(jobID); err == nil {
+		for _, artifact := range artifacts {
+			status.Artifacts = append(status.Artifacts, openapi.Artifact{
+				Path:              artifact.Path,
+				SizeBytes:         artifact.Size,
+				Checksum:          artifact.Checksum,
+				ChecksumAlgorithm: artifact.ChecksumAlgorithm,
+				Backend:           artifact.Backend,
+				Node:              artifact.Node,
+				Encrypted:         artifact.Encrypted,
+				CreatedAt:         artifact.CreatedAt.Format(time.RFC3339),
+			})
+		}
+	}
+	
+	if stats, ok := s.activity.Get(jobID, time.Now()); ok {
+		addActivity(&status, stats)
 	}
 	
+	if apiVersion(c) == string(apiversion.V1) {
+		v1 := status.V1()
+		v1.Status = v1StatusName(v1.Status)
+		c.JSON(http.StatusOK, v1)
+		return
+	}
 	c.JSON(http.StatusOK, status)
 }
 
-// listDownloadsHandler handles GET /downloads - lists all downloads
-func (s *QueuedDownloadServer) listDownloadsHandler(c *gin.Context) {
-	// Get downloads from database
-	downloads, err := s.dbManager.GetAllDownloads()
+// adjustDownloadHandler handles PATCH /downloads/:id - sends new limits to
+// the worker running the job
+func (s *QueuedDownloadServer) adjustDownloadHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	
+	var req openapi.DownloadAdjustment
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).respond(c)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		invalidBody(err).respond(c)
+		return
+	}
+	if req.RateLimit == nil && req.Threads == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": "rate_limit or threads is required",
+		})
+		return
+	}
+	
+	queueStatus, err := s.queueManager.GetJobStatus(c.Request.Context(), jobID)
 	if err != nil {
-		s.logger.Error("Failed to get downloads from database", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to retrieve downloads",
-			"details": err.Error(),
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Download not found",
+		})
+		return
+	}
+	if queueStatus.Status != lifecycle.Downloading {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not running",
+			"details": fmt.Sprintf("download is %s", queueStatus.Status),
 		})
 		return
 	}
 	
-	var statuses []QueuedDownloadStatus
-	for _, download := range downloads {
-		// Get queue status for each download
-		queueStatus, err := s.queueManager.GetJobStatus(c.Request.Context(), download.ID)
-		if err != nil {
-			// If not in queue, use database info
-			status := QueuedDownloadStatus{
-				JobID:           download.ID,
-				URL:             download.URL,
-				OutputPath:      download.OutputPath,
-				Status:          download.Status,
-				BytesDownloaded: download.BytesDownloaded,
-				TotalBytes:      download.TotalSize,
-				ThreadsUsed:     download.Threads,
-				CreatedAt:       download.CreatedAt.Format(time.RFC3339),
-				ErrorMessage:    download.Error,
-			}
-			
-			if download.TotalSize > 0 {
-				status.Progress = float64(download.BytesDownloaded) / float64(download.TotalSize) * 100
-			}
-			
-			statuses = append(statuses, status)
-		} else {
-			// Use queue status (more up-to-date)
-			status := QueuedDownloadStatus{
-				JobID:           queueStatus.ID,
-				URL:             download.URL,
-				OutputPath:      download.OutputPath,
-				Status:          queueStatus.Status,
-				Progress:        queueStatus.Progress,
-				BytesDownloaded: queueStatus.BytesDownloaded,
-				TotalBytes:      queueStatus.TotalBytes,
-				ThreadsUsed:     download.Threads,
-				CreatedAt:       queueStatus.CreatedAt.Format(time.RFC3339),
-				WorkerID:        queueStatus.WorkerID,
-				ErrorMessage:    queueStatus.ErrorMessage,
-			}
-			
-			if !queueStatus.StartedAt.IsZero() {
-				status.StartedAt = queueStatus.StartedAt.Format(time.RFC3339)
-			}
-			
-			if !queueStatus.CompletedAt.IsZero() {
-				status.CompletedAt = queueStatus.CompletedAt.Format(time.RFC3339)
-			}
-			
-			statuses = append(statuses, status)
+	// The worker records the parts it split the download into; it cannot
+	// run more threads than that
+	if req.Threads != nil {
+		if download, err := s.dbManager.GetDownload(jobID); err == nil && download.Threads > 0 && *req.Threads > download.Threads {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": fmt.Sprintf("%v: download is split into %d parts, so it runs at most %d threads", downloader.ErrTooManyThreads, download.Threads, download.Threads),
+			})
+			return
 		}
 	}
 	
-	c.JSON(http.StatusOK, gin.H{
-		"downloads": statuses,
-		"count":     len(statuses),
-	})
-}
-
-// getQueueStatsHandler handles GET /queue/stats
-func (s *QueuedDownloadServer) getQueueStatsHandler(c *gin.Context) {
-	stats, err := s.queueManager.GetQueueStats(c.Request.Context())
-	if err != nil {
-		s.logger.Error("Failed to get queue statistics", zap.Error(err))
+	control := DownloadControl{JobID: jobID, RateLimit: req.RateLimit, Threads: req.Threads}
+	if err := s.queueManager.PublishControl(c.Request.Context(), control); err != nil {
+		s.logger.Error("Failed to publish download adjustment", zap.String("job_id", jobID), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to get queue statistics",
+			"error":   "Failed to adjust download",
 			"details": err.Error(),
 		})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{
-		"queue_stats": stats,
-		"timestamp":   time.Now().Format(time.RFC3339),
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Adjustment sent to the worker running the download",
 	})
 }
 
-// getWorkerStatsHandler handles GET /workers/stats
-func (s *QueuedDownloadServer) getWorkerStatsHandler(c *gin.Context) {
-	// This would typically come from a worker manager instance
-	// For now, return basic info
-	c.JSON(http.StatusOK, gin.H{
-		"worker_stats": gin.H{
-			"message": "Worker stats available when running with worker manager",
-		},
-		"timestamp": time.Now().Format(time.RFC3339),
-	})
+// runningJob writes an error and returns false unless the job of the
+// request's :id is downloading
+func (s *QueuedDownloadServer) runningJob(c *gin.Context) bool {
+	queueStatus, err := s.queueManager.GetJobStatus(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Download not found",
+		})
+		return false
+	}
+	if queueStatus.Status != lifecycle.Downloading {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not running",
+			"details": fmt.Sprintf("download is %s", queueStatus.Status),
+		})
+		return false
+	}
+	return true
 }
 
-// healthHandler handles GET /health
-func (s *QueuedDownloadServer) healthHandler(c *gin.Context) {
-	// Check Redis connection
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
-	defer cancel()
-	
-	redisHealthy := true
-	if err := s.queueManager.client.Ping(ctx).Err(); err != nil {
-		redisHealthy = false
-		s.logger.Warn("Redis health check failed", zap.Error(err))
-	}
-	
-	// Check database connection
-	dbHealthy := true
-	if s.dbManager != nil {
-		if sqlDB, err := s.dbManager.db.DB(); err == nil {
-			if err := sqlDB.PingContext(ctx); err != nil {
-				dbHealthy = false
-				s.logger.Warn("Database health check failed", zap.Error(err))
-			}
-		} else {
-			dbHealthy = false
-			s.logger.Warn("Failed to get database connection", zap.Error(err))
-		}
+// downloadPartsHandler handles GET /downloads/:id/parts - the part
+// diagnostics the worker running the job last reported
+func (s *QueuedDownloadServer) downloadPartsHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	if !s.runningJob(c) {
+		return
 	}
 	
-	status := "healthy"
-	httpStatus := http.StatusOK
+	parts, err := s.queueManager.GetParts(c.Request.Context(), jobID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to read parts",
+			"details": err.Error(),
+		})
+		return
+	}
+	if parts == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Parts not reported yet",
+			"details": "the worker running the download has not reported its parts yet; try again in a few seconds",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, parts)
+}
+
+// downloadMapHandler handles GET /downloads/:id/map - the completed byte
+// ranges of the parts the worker running the job last reported
+func (s *QueuedDownloadServer) downloadMapHandler(c *gin.Context) {
+	pieces, err := openapi.ParseMapPieces(c.Query("pieces"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid pieces",
+			"details": err.Error(),
+		})
+		return
+	}
+	jobID := c.Param("id")
+	if !s.runningJob(c) {
+		return
+	}
+
+	parts, err := s.queueManager.GetParts(c.Request.Context(), jobID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to read parts",
+			"details": err.Error(),
+		})
+		return
+	}
+	if parts == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Parts not reported yet",
+			"details": "the worker running the download has not reported its parts yet; try again in a few seconds",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewSegmentMap(*parts, pieces))
+}
+
+// downloadPlanHandler handles GET /downloads/:id/plan - the part ranges the
+// worker running the job last reported, which do not name their sources
+func (s *QueuedDownloadServer) downloadPlanHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	if !s.runningJob(c) {
+		return
+	}
+
+	job, err := s.queueManager.ProcessingJob(c.Request.Context(), jobID)
+	if err == nil && job != nil {
+		var parts *openapi.DownloadParts
+		if parts, err = s.queueManager.GetParts(c.Request.Context(), jobID); err == nil && parts != nil {
+			c.JSON(http.StatusOK, openapi.PartPlanFromParts(secrets.RedactURL(job.URL), *parts))
+			return
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to read parts",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":   "Parts not reported yet",
+		"details": "the worker running the download has not reported its parts yet; try again in a few seconds",
+	})
+}
+
+// downloadEnvironmentHandler handles GET /downloads/:id/environment - the
+// request, settings, resolver answers and server reply the worker running
+// the job recorded
+func (s *QueuedDownloadServer) downloadEnvironmentHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	env, err := s.dbManager.GetDownloadEnvironment(jobID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Download not found",
+			"details": err.Error(),
+		})
+		return
+	}
+	if env == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Environment not recorded",
+			"details": "no worker has set the download up yet, or it ran before environments were recorded",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewDownloadEnvironment(jobID, env))
+}
+
+// downloadEventsHandler handles GET /downloads/:id/events - the state
+// changes of a job recorded by the API server and the workers, oldest first
+func (s *QueuedDownloadServer) downloadEventsHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid limit",
+				"details": "limit must be a number from 1 to 1000",
+			})
+			return
+		}
+		limit = n
+	}
+	
+	rows, err := s.dbManager.GetDownloadEvents(jobID, limit)
+	if err != nil {
+		s.logger.Error("Failed to get download events", zap.String("job_id", jobID), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Failed to get download events",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(rows) == 0 {
+		if _, err := s.queueManager.GetJobStatus(c.Request.Context(), jobID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Download not found",
+			})
+			return
+		}
+	}
+	
+	response := openapi.DownloadEvents{DownloadID: jobID, Events: make([]openapi.DownloadEvent, len(rows)), Count: len(rows)}
+	for i, row := range rows {
+		response.Events[i] = row.API()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// restartPartHandler handles POST /downloads/:id/parts/:index/restart -
+// asks the worker running the job to drop the connection of a part
+func (s *QueuedDownloadServer) restartPartHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid part index",
+			"details": fmt.Sprintf("%q is not a part index", c.Param("index")),
+		})
+		return
+	}
+	if !s.runningJob(c) {
+		return
+	}
+	
+	control := DownloadControl{JobID: jobID, RestartPart: &index}
+	if err := s.queueManager.PublishControl(c.Request.Context(), control); err != nil {
+		s.logger.Error("Failed to publish part restart", zap.String("job_id", jobID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to restart part",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": fmt.Sprintf("Restart of part %d sent to the worker running the download", index),
+	})
+}
+
+// listDownloadsHandler handles GET /downloads - lists all downloads
+func (s *QueuedDownloadServer) listDownloadsHandler(c *gin.Context) {
+	// Get downloads from database
+	downloads, err := s.dbManager.GetAllDownloads()
+	if err != nil {
+		s.logger.Error("Failed to get downloads from database", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to retrieve downloads",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	var statuses []QueuedDownloadStatus
+	for _, download := range downloads {
+		// Get queue status for each download
+		queueStatus, err := s.queueManager.GetJobStatus(c.Request.Context(), download.ID)
+		if err != nil {
+			// If not in queue, use database info
+			status := QueuedDownloadStatus{
+				JobID:            download.ID,
+				URL:              secrets.RedactURL(download.URL),
+				OutputPath:       download.OutputPath,
+				Status:           string(download.Status),
+				BytesDownloaded:  download.BytesDownloaded,
+				TotalBytes:       download.TotalSize,
+				ThreadsUsed:      download.Threads,
+				ThreadsRequested: requestedThreads(&download),
+				CreatedAt:        download.CreatedAt.Format(time.RFC3339),
+				ErrorMessage:     secrets.RedactText(download.Error),
+			}
+			
+			if download.TotalSize > 0 {
+				status.Progress = float64(download.BytesDownloaded) / float64(download.TotalSize) * 100
+			}
+			addChecksum(&status, &download)
+			
+			statuses = append(statuses, status)
+		} else {
+			// Use queue status (more up-to-date)
+			status := QueuedDownloadStatus{
+				JobID:             queueStatus.ID,
+				URL:               secrets.RedactURL(download.URL),
+				OutputPath:        download.OutputPath,
+				Status:            string(queueStatus.Status),
+				Progress:          queueStatus.Progress,
+				BytesDownloaded:   queueStatus.BytesDownloaded,
+				TotalBytes:        queueStatus.TotalBytes,
+				ThreadsUsed:       download.Threads,
+				ThreadsRequested:  requestedThreads(&download),
+				CreatedAt:         queueStatus.CreatedAt.Format(time.RFC3339),
+				WorkerID:          queueStatus.WorkerID,
+				ErrorMessage:      secrets.RedactText(queueStatus.ErrorMessage),
+				ThrottledByServer: queueStatus.ThrottledByServer,
+			}
+			
+			if !queueStatus.StartedAt.IsZero() {
+				status.StartedAt = queueStatus.StartedAt.Format(time.RFC3339)
+			}
+			
+			if !queueStatus.CompletedAt.IsZero() {
+				status.CompletedAt = queueStatus.CompletedAt.Format(time.RFC3339)
+			}
+			addChecksum(&status, &download)
+			
+			statuses = append(statuses, status)
+		}
+	}
+	
+	// List the most recently active first, then the newest
+	now := time.Now()
+	stats := make(map[string]events.Stats, len(statuses))
+	for i := range statuses {
+		if jobStats, ok := s.activity.Get(statuses[i].JobID, now); ok {
+			stats[statuses[i].JobID] = jobStats
+			addActivity(&statuses[i], jobStats)
+		}
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := stats[statuses[i].JobID], stats[statuses[j].JobID]
+		if events.MoreActive(a, b) || events.MoreActive(b, a) {
+			return events.MoreActive(a, b)
+		}
+		return statuses[i].CreatedAt > statuses[j].CreatedAt
+	})
+	
+	list := openapi.QueuedDownloadList{
+		Downloads: statuses,
+		Count:     len(statuses),
+	}
+	if apiVersion(c) == string(apiversion.V1) {
+		v1 := list.V1()
+		for i := range v1.Downloads {
+			v1.Downloads[i].Status = v1StatusName(v1.Downloads[i].Status)
+		}
+		c.JSON(http.StatusOK, v1)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// requestedThreads returns the thread count a job asked for
+func requestedThreads(download *Download) int {
+	if download.RequestedThreads > 0 {
+		return download.RequestedThreads
+	}
+	return download.Threads
+}
+
+// addChecksum fills in the result of checking the download against the
+// checksum its origin sent
+func addChecksum(status *QueuedDownloadStatus, download *Download) {
+	status.ChecksumStatus = download.ChecksumStatus
+	status.ChecksumAlgorithm = download.ChecksumAlgorithm
+	status.ChecksumSource = download.ChecksumSource
+}
+
+// addActivity fills the live counters of status
+func addActivity(status *QueuedDownloadStatus, stats events.Stats) {
+	status.CurrentSpeed = stats.Speed
+	status.ActiveConnections = stats.Connections
+	if !stats.LastByteAt.IsZero() {
+		status.LastByteAt = stats.LastByteAt.Format(time.RFC3339)
+	}
+}
+
+// v1StatusName keeps the status names v1 clients were promised: running jobs
+// were reported as "processing" before statuses were unified
+func v1StatusName(status string) string {
+	if status == string(lifecycle.Downloading) {
+		return "processing"
+	}
+	return status
+}
+
+// resolveGroupRequest binds and validates a group request and returns the URLs it covers,
+// with the headers only the download of each entry sends, when it has any
+func (s *QueuedDownloadServer) resolveGroupRequest(c *gin.Context) (*GroupDownloadRequest, []GroupEntry, []map[string]string, bool) {
+	var req GroupDownloadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.logger.Warn("Invalid group request", zap.Error(err))
+		invalidBody(err).respond(c)
+		return nil, nil, nil, false
+	}
+	
+	// Check the request against the OpenAPI document and fill in defaults
+	if err := req.Validate(); err != nil {
+		invalidBody(err).respond(c)
+		return nil, nil, nil, false
+	}
+	// Domain rules may pick the threads of each URL if the request does not
+	threads := req.Threads
+	req.ApplyDefaults()
+	req.Threads = threads
+	
+	sources := 0
+	for _, source := range []string{req.URLTemplate, req.PageURL, req.RepoURL} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Exactly one of url_template, page_url or repo_url is required",
+		})
+		return nil, nil, nil, false
+	}
+	
+	// Apply the server's User-Agent/Referer policy
+	userAgent, referer, err := s.spoofingPolicy.Resolve(req.UserAgentProfile, req.UserAgent, req.Referer)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request headers",
+			"details": err.Error(),
+		})
+		return nil, nil, nil, false
+	}
+	req.UserAgent = userAgent
+	req.Referer = referer
+	
+	if req.RepoURL != "" {
+		entries, headers, err := s.repoEntries(c.Request.Context(), &req)
+		if err != nil {
+			s.logger.Warn("Failed to resolve group repository", zap.String("error", secrets.RedactText(err.Error())))
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Failed to resolve group repository",
+				"details": secrets.RedactText(err.Error()),
+			})
+			return nil, nil, nil, false
+		}
+		return &req, entries, headers, true
+	}
+	if len(req.LfsObjects) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "lfs_objects needs the repository's repo_url",
+		})
+		return nil, nil, nil, false
+	}
+	
+	var urls []string
+	if req.PageURL != "" {
+		urls, err = downloader.ExtractLinks(req.PageURL, req.Pattern)
+		if err == nil && req.Polite {
+			urls, err = s.robotsAllowed(c.Request.Context(), req.PageURL, urls, req.UserAgent)
+		}
+	} else {
+		urls, err = downloader.ExpandURLTemplate(req.URLTemplate)
+	}
+	if err != nil {
+		s.logger.Warn("Failed to resolve group URLs", zap.String("error", secrets.RedactText(err.Error())))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to resolve group URLs",
+			"details": secrets.RedactText(err.Error()),
+		})
+		return nil, nil, nil, false
+	}
+	
+	outputs := downloader.GroupOutputPaths(req.OutputDir, urls)
+	entries := make([]GroupEntry, len(urls))
+	for i := range urls {
+		entries[i] = GroupEntry{URL: urls[i], OutputPath: outputs[i]}
+	}
+	
+	return &req, entries, nil, true
+}
+
+// repoEntries lists the files of the Hugging Face repo at an hf:// repo_url,
+// or asks the LFS server of the Git repository at repo_url for the objects
+// of lfs_objects. Each entry carries the size and SHA-256 its file is
+// checked against. The batch request sends the request's headers and those
+// of the domain rules matching the repository.
+func (s *QueuedDownloadServer) repoEntries(ctx context.Context, req *GroupDownloadRequest) ([]GroupEntry, []map[string]string, error) {
+	var objects []lfs.Object
+	if lfs.IsRepo(req.RepoURL) {
+		if len(req.LfsObjects) > 0 {
+			return nil, nil, errors.New("lfs_objects need a Git repository URL, not an hf:// URL")
+		}
+		repo, err := lfs.ParseRepo(req.RepoURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		if objects, err = lfs.NewHub().Objects(ctx, repo); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if len(req.LfsObjects) == 0 {
+			return nil, nil, errors.New("a Git repository URL needs the lfs_objects to download")
+		}
+		if err := openapi.CheckURL(req.RepoURL); err != nil {
+			return nil, nil, fmt.Errorf("repo_url %w", err)
+		}
+		for _, pointer := range req.LfsObjects {
+			obj, err := lfs.NewObject(pointer.Path, pointer.Oid, pointer.Size)
+			if err != nil {
+				return nil, nil, err
+			}
+			objects = append(objects, obj)
+		}
+		header := make(map[string]string, len(req.Headers))
+		for name, value := range req.Headers {
+			header[name] = value
+		}
+		header = domainrules.MergeHeaders(header, s.domainRules.Resolve(req.RepoURL).Headers)
+		var err error
+		if objects, err = lfs.Batch(ctx, &http.Client{Timeout: 60 * time.Second}, req.RepoURL, header, objects); err != nil {
+			return nil, nil, err
+		}
+	}
+	
+	entries := make([]GroupEntry, len(objects))
+	var headers []map[string]string
+	for i, obj := range objects {
+		entries[i] = GroupEntry{
+			URL:        obj.URL,
+			OutputPath: filepath.Join(req.OutputDir, filepath.FromSlash(obj.Path)),
+			Size:       obj.Size,
+			Sha256:     obj.SHA256,
+		}
+		if len(obj.Header) > 0 {
+			if headers == nil {
+				headers = make([]map[string]string, len(objects))
+			}
+			headers[i] = obj.Header
+		}
+	}
+	return entries, headers, nil
+}
+
+// robotsAllowed returns the links robots.txt lets a crawler sending
+// userAgent fetch. A page robots.txt disallows is an error.
+func (s *QueuedDownloadServer) robotsAllowed(ctx context.Context, pageURL string, links []string, userAgent string) ([]string, error) {
+	if userAgent == "" {
+		userAgent = downloader.DefaultUserAgent
+	}
+	crawler := robots.NewChecker(userAgent)
+	if ok, err := crawler.Allowed(ctx, pageURL); !ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("robots.txt disallows the page")
+	}
+	
+	var allowed []string
+	for _, link := range links {
+		if ok, _ := crawler.Allowed(ctx, link); ok {
+			allowed = append(allowed, link)
+		}
+	}
+	if skipped := len(links) - len(allowed); skipped > 0 {
+		s.logger.Info("Left out links robots.txt disallows",
+			zap.String("page", secrets.RedactURL(pageURL)),
+			zap.Int("skipped", skipped),
+			zap.Int("links", len(links)))
+	}
+	return allowed, nil
+}
+
+// previewGroupHandler handles POST /groups/preview - shows what a group would contain
+func (s *QueuedDownloadServer) previewGroupHandler(c *gin.Context) {
+	_, entries, _, ok := s.resolveGroupRequest(c)
+	if !ok {
+		return
+	}
+	
+	for i := range entries {
+		entries[i].URL = secrets.RedactURL(entries[i].URL)
+	}
+	
+	c.JSON(http.StatusOK, openapi.GroupPreview{
+		Downloads: entries,
+		Count:     len(entries),
+	})
+}
+
+// defaultThreads is the thread count of jobs that neither ask for one nor
+// match a domain rule that sets one
+const defaultThreads = 4
+
+// applyDomainRules gives a job the defaults of the domain rules matching its
+// URL wherever the request left them out. Threads and headers are decided
+// here; the worker applies the rate limit, cookies, retry policy,
+// User-Agents, jitter and bind.
+func (s *QueuedDownloadServer) applyDomainRules(job *DownloadJob) {
+	profile := s.domainRules.Resolve(job.URL)
+	if job.Threads == 0 {
+		job.Threads = profile.Threads
+	}
+	if job.Threads == 0 {
+		job.Threads = defaultThreads
+	}
+	if len(profile.Matched) == 0 {
+		return
+	}
+	
+	job.Headers = domainrules.MergeHeaders(job.Headers, profile.Headers)
+	job.RateLimit = profile.RateLimit
+	job.CookiesFile = profile.CookiesFile
+	job.MaxAttempts = profile.Retry.MaxAttempts
+	job.RetryDelay = profile.Retry.Delay
+	job.UserAgents = profile.UserAgents
+	job.Jitter = profile.Jitter
+	if job.Bind == "" {
+		job.Bind = profile.Bind
+	}
+	if job.BindRateLimit == 0 && (profile.Bind == "" || job.Bind == profile.Bind) {
+		job.BindRateLimit = profile.BindRateLimit
+	}
+	s.logger.Debug("Applied domain rules",
+		zap.String("job_id", job.ID),
+		zap.Strings("rules", profile.Matched))
+}
+
+// sealJobSecrets seals the job's credentials before it is stored. It writes an
+// error response and returns false if they cannot be sealed.
+func (s *QueuedDownloadServer) sealJobSecrets(c *gin.Context, job *DownloadJob) bool {
+	if reqErr := s.sealJob(job); reqErr != nil {
+		reqErr.respond(c)
+		return false
+	}
+	return true
+}
+
+// sealJob seals the job's credentials before it is stored
+func (s *QueuedDownloadServer) sealJob(job *DownloadJob) *requestError {
+	err := job.SealSecrets(s.secrets)
+	if err == nil {
+		return nil
+	}
+	
+	if errors.Is(err, secrets.ErrNoMasterKey) {
+		s.logger.Warn("Rejected job credentials without a master key", zap.String("job_id", job.ID))
+		return &requestError{status: http.StatusBadRequest, message: "Credentials are not accepted",
+			details: "headers and URLs with credentials require SECRETS_MASTER_KEY_FILE to be configured on the server"}
+	}
+	
+	s.logger.Error("Failed to seal job credentials", zap.String("job_id", job.ID), zap.Error(err))
+	return &requestError{status: http.StatusInternalServerError, message: "Failed to protect job credentials", details: err.Error()}
+}
+
+// enqueueGroupHandler handles POST /groups - enqueues every URL of a template or page as one group
+func (s *QueuedDownloadServer) enqueueGroupHandler(c *gin.Context) {
+	req, entries, headers, ok := s.resolveGroupRequest(c)
+	if !ok {
+		return
+	}
+	
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No URLs matched",
+		})
+		return
+	}
+	
+	s.enqueueGroup(c, entries, headers, DownloadJob{
+		Threads:   req.Threads,
+		UserAgent: req.UserAgent,
+		Referer:   req.Referer,
+		Headers:   req.Headers,
+	})
+}
+
+// enqueueGroup enqueues the entries as a new group and writes the response.
+// Per-job settings are copied from the template job; headers, when set,
+// add to the template's headers of each entry.
+func (s *QueuedDownloadServer) enqueueGroup(c *gin.Context, entries []GroupEntry, headers []map[string]string, template DownloadJob) {
+	groupID := uuid.New().String()
+	
+	jobs := make([]*DownloadJob, len(entries))
+	jobIDs := make([]string, len(entries))
+	for i, entry := range entries {
+		jobIDs[i] = uuid.New().String()
+		job := template
+		job.ID = jobIDs[i]
+		job.URL = entry.URL
+		job.OutputPath = entry.OutputPath
+		job.ExpectedSize = entry.Size
+		job.ExpectedSHA256 = entry.Sha256
+		if headers != nil && len(headers[i]) > 0 {
+			job.Headers = make(map[string]string, len(template.Headers)+len(headers[i]))
+			for name, value := range template.Headers {
+				job.Headers[name] = value
+			}
+			for name, value := range headers[i] {
+				job.Headers[name] = value
+			}
+		}
+		job.User = events.ActorFrom(c.Request.Context())
+		s.applyDomainRules(&job)
+		if !s.sealJobSecrets(c, &job) {
+			return
+		}
+		jobs[i] = &job
+	}
+	
+	if err := s.queueManager.EnqueueGroup(c.Request.Context(), groupID, jobs); err != nil {
+		s.logger.Error("Failed to enqueue group", 
+			zap.String("group_id", groupID),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to enqueue download group",
+			"details": err.Error(),
+		})
+		return
+	}
+	for _, jobID := range jobIDs {
+		s.events.Publish(events.Event{Type: events.Created, DownloadID: jobID, Status: lifecycle.Queued, Actor: events.ActorFrom(c.Request.Context())})
+	}
+	
+	c.JSON(http.StatusCreated, GroupDownloadResponse{
+		GroupID: groupID,
+		JobIDs:  jobIDs,
+		Count:   len(jobIDs),
+		Message: "Download group enqueued successfully",
+	})
+}
+
+// getGroupStatusHandler handles GET /groups/:id - status of every job in a group
+func (s *QueuedDownloadServer) getGroupStatusHandler(c *gin.Context) {
+	groupID := c.Param("id")
+	
+	jobIDs, err := s.queueManager.GetGroupJobs(c.Request.Context(), groupID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Group not found",
+		})
+		return
+	}
+	
+	summary := make(map[string]int)
+	statuses := make([]QueuedDownloadStatus, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		queueStatus, err := s.queueManager.GetJobStatus(c.Request.Context(), jobID)
+		if err != nil {
+			summary["unknown"]++
+			continue
+		}
+		summary[string(queueStatus.Status)]++
+		
+		status := QueuedDownloadStatus{
+			JobID:           queueStatus.ID,
+			Status:          string(queueStatus.Status),
+			Progress:        queueStatus.Progress,
+			BytesDownloaded: queueStatus.BytesDownloaded,
+			TotalBytes:      queueStatus.TotalBytes,
+			CreatedAt:       queueStatus.CreatedAt.Format(time.RFC3339),
+			WorkerID:        queueStatus.WorkerID,
+			ErrorMessage:    secrets.RedactText(queueStatus.ErrorMessage),
+			ThrottledByServer: queueStatus.ThrottledByServer,
+		}
+		statuses = append(statuses, status)
+	}
+	
+	response := openapi.GroupStatus{
+		GroupID:   groupID,
+		Downloads: statuses,
+		Summary:   summary,
+		Count:     len(jobIDs),
+	}
+	if sums, err := s.dbManager.GetGroupChecksums(groupID); err == nil && sums != nil {
+		response.Checksums = &openapi.GroupChecksums{
+			Files:       sums.Files,
+			Signed:      len(sums.Signature) > 0,
+			PublicKey:   sums.PublicKey,
+			Dir:         sums.Dir,
+			Node:        sums.Node,
+			GeneratedAt: sums.CreatedAt.UTC().Format(time.RFC3339),
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// groupChecksumsHandler handles GET /groups/:id/checksums - the SHA256SUMS
+// file written once the group finished
+func (s *QueuedDownloadServer) groupChecksumsHandler(c *gin.Context) {
+	sums, ok := s.groupChecksums(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", checksums.FileName))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(sums.Sums))
+}
+
+// groupSignatureHandler handles GET /groups/:id/checksums.sig - the
+// signature of the group's SHA256SUMS file
+func (s *QueuedDownloadServer) groupSignatureHandler(c *gin.Context) {
+	sums, ok := s.groupChecksums(c)
+	if !ok {
+		return
+	}
+	if len(sums.Signature) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Group checksums are not signed",
+			"details": "set GROUP_SIGNING_KEY_FILE on the workers to sign them",
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", checksums.FileName+checksums.SignatureSuffix))
+	c.Data(http.StatusOK, "application/octet-stream", sums.Signature)
+}
+
+// groupChecksums returns the checksum file of the group in the request, or
+// answers why there is none
+func (s *QueuedDownloadServer) groupChecksums(c *gin.Context) (*GroupChecksums, bool) {
+	groupID := c.Param("id")
+	sums, err := s.dbManager.GetGroupChecksums(groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to get group checksums",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+	if sums == nil {
+		if _, err := s.queueManager.GetGroupJobs(c.Request.Context(), groupID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Group not found",
+			})
+			return nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Group checksums not written",
+			"details": "they are written once no job of the group is left to run",
+		})
+		return nil, false
+	}
+	return sums, true
+}
+
+// maxCookiesUploadSize limits the size of an uploaded cookies.txt file
+const maxCookiesUploadSize = 1 << 20
+
+// readCookiesUpload returns cookies.txt content from a multipart "file" field or the raw request body
+func readCookiesUpload(c *gin.Context) (string, error) {
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return "", fmt.Errorf("missing \"file\" form field: %w", err)
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		reader = file
+	}
+	
+	data, err := io.ReadAll(io.LimitReader(reader, maxCookiesUploadSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxCookiesUploadSize {
+		return "", fmt.Errorf("cookies file exceeds %d bytes", maxCookiesUploadSize)
+	}
+	return string(data), nil
+}
+
+// importCookiesHandler handles POST /cookies - imports a Netscape cookies.txt file for all workers
+func (s *QueuedDownloadServer) importCookiesHandler(c *gin.Context) {
+	content, err := readCookiesUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid cookies upload",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	// Validate before storing so workers never see a broken file
+	cookies, err := downloader.ParseCookiesFile(strings.NewReader(content))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid cookies file",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	// Merge into the stored cookies so importing a file again replaces its
+	// cookies instead of growing the store
+	stored, err := s.loadCookies(c.Request.Context())
+	if err != nil {
+		s.logger.Error("Failed to load stored cookies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load stored cookies",
+			"details": err.Error(),
+		})
+		return
+	}
+	merged := downloader.MergeCookies(stored, cookies)
+	content = downloader.FormatCookiesFile(merged)
+	if len(content) > maxCookiesUploadSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Too many cookies",
+			"details": fmt.Sprintf("the stored cookies would exceed %d bytes; clear them with DELETE /cookies first", maxCookiesUploadSize),
+		})
+		return
+	}
+	
+	// Cookies are browser sessions, so they are never stored in plaintext
+	sealed, err := s.secrets.Seal(content)
+	if err != nil {
+		if errors.Is(err, secrets.ErrNoMasterKey) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Cookies are not accepted",
+				"details": "cookies require SECRETS_MASTER_KEY_FILE to be configured on the server",
+			})
+			return
+		}
+		s.logger.Error("Failed to seal cookies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to protect cookies",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	if err := s.queueManager.StoreCookies(c.Request.Context(), sealed); err != nil {
+		s.logger.Error("Failed to store cookies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to store cookies",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	domains := downloader.CookieDomains(cookies)
+	s.logger.Info("Cookies imported",
+		zap.Int("cookies", len(cookies)),
+		zap.Int("cookies_stored", len(merged)),
+		zap.Strings("domains", domains))
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Cookies imported successfully",
+		"cookies_imported": len(cookies),
+		"cookies_stored":   len(merged),
+		"domains":          domains,
+	})
+}
+
+// listDomainRulesHandler handles GET /domain-rules - the per-host defaults,
+// with header values redacted
+func (s *QueuedDownloadServer) listDomainRulesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, openapi.DomainRules{Rules: domainrules.Redact(s.domainRules.Rules())})
+}
+
+// replaceDomainRulesHandler handles PUT /domain-rules - replaces the
+// per-host defaults of jobs enqueued from now on
+func (s *QueuedDownloadServer) replaceDomainRulesHandler(c *gin.Context) {
+	var req openapi.DomainRules
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).respond(c)
+		return
+	}
+	
+	rules, err := s.domainRules.Replace(req.Rules)
+	if err != nil {
+		s.logger.Warn("Rejected domain rules", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid domain rules",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	s.logger.Info("Domain rules replaced",
+		zap.String("client_ip", c.ClientIP()),
+		zap.Int("rules", len(rules)))
+	c.JSON(http.StatusOK, openapi.DomainRules{Rules: domainrules.Redact(rules)})
+}
+
+// matchDomainRulesHandler handles GET /domain-rules/match - what the rules
+// give a job downloading a URL
+func (s *QueuedDownloadServer) matchDomainRulesHandler(c *gin.Context) {
+	rawURL := c.Query("url")
+	if parsed, err := url.Parse(rawURL); err != nil || parsed.Hostname() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid URL",
+			"details": "url must be an absolute URL with a host",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, s.domainRules.Resolve(rawURL).Response(rawURL))
+}
+
+// probeHandler handles GET /probe - asks the server of a URL what it offers
+// for the file, replacing the probe workers reuse
+func (s *QueuedDownloadServer) probeHandler(c *gin.Context) {
+	rawURL := c.Query("url")
+	if parsed, err := url.Parse(rawURL); err != nil || parsed.Hostname() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid URL",
+			"details": "url must be an absolute URL with a host",
+		})
+		return
+	}
+	
+	dl := downloader.NewDownloader(rawURL, "", 1)
+	dl.Probes = s.probes
+	probe, err := dl.Probe(c.Request.Context())
+	if err != nil {
+		s.logger.Warn("Failed to probe URL",
+			zap.String("url", secrets.RedactURL(rawURL)),
+			zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":   "Failed to probe URL",
+			"details": secrets.RedactText(err.Error()),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openapi.NewProbe(probe))
+}
+
+// loadCookies returns the cookies already imported for all workers
+func (s *QueuedDownloadServer) loadCookies(ctx context.Context) ([]*http.Cookie, error) {
+	stored, err := s.queueManager.GetCookies(ctx)
+	if err != nil || stored == "" {
+		return nil, err
+	}
+	content, err := s.secrets.Open(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stored cookies: %w", err)
+	}
+	return downloader.ParseCookiesFile(strings.NewReader(content))
+}
+
+// clearCookiesHandler handles DELETE /cookies - forgets every imported cookie
+func (s *QueuedDownloadServer) clearCookiesHandler(c *gin.Context) {
+	if err := s.queueManager.ClearCookies(c.Request.Context()); err != nil {
+		s.logger.Error("Failed to clear cookies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to clear cookies",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cookies cleared successfully",
+	})
+}
+
+// getQueueStatsHandler handles GET /queue/stats
+func (s *QueuedDownloadServer) getQueueStatsHandler(c *gin.Context) {
+	stats, err := s.queueManager.GetQueueStats(c.Request.Context())
+	if err != nil {
+		s.logger.Error("Failed to get queue statistics", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to get queue statistics",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"queue_stats": stats,
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// alertsHandler handles GET /alerts - the state of the alert rules
+func (s *QueuedDownloadServer) alertsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.alerts.Alerts())
+}
+
+// scalingHandler handles GET /scaling - the stalled-queue watchdog's last
+// sample and the worker nodes it suggests
+func (s *QueuedDownloadServer) scalingHandler(c *gin.Context) {
+	if s.watchdog == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Watchdog disabled",
+			"details": "QUEUE_STALL_WINDOW is 0",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, s.watchdog.API())
+}
+
+// metricsHandler handles GET /metrics - the watchdog's gauges in the
+// Prometheus text format
+func (s *QueuedDownloadServer) metricsHandler(c *gin.Context) {
+	if s.watchdog == nil {
+		c.String(http.StatusNotFound, "watchdog disabled: QUEUE_STALL_WINDOW is 0\n")
+		return
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.watchdog.WritePrometheus(c.Writer)
+}
+
+// usageHandler handles GET /usage - the bytes the workers transferred per
+// user, tag or domain, by day or month
+func (s *QueuedDownloadServer) usageHandler(c *gin.Context) {
+	q, err := usage.ParseQuery(c.Query("by"), c.Query("period"), c.Query("since"), c.Query("until"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid usage query",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	records, err := s.dbManager.GetUsage(q.By, q.Since, q.Until)
+	if err != nil {
+		s.logger.Error("Failed to get usage", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Failed to get usage",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, usage.Report(q, records, s.usageCostPerGB))
+}
+
+// alertMetrics measures what alert rules watch: the queue from Redis, and
+// speeds, failures and waits from the bridged worker events. The watchdog
+// takes its sample from them and adds its verdict.
+func (s *QueuedDownloadServer) alertMetrics(ctx context.Context) (map[string]float64, error) {
+	stats, err := s.queueManager.GetQueueStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	metrics := s.alertTracker.Metrics(now)
+	metrics[alerts.QueueDepth] = float64(stats["queued"])
+	metrics[alerts.RunningDownloads] = float64(stats["processing"])
+	alerts.AddSpeeds(metrics, s.activity.Running(now))
+	if s.watchdog != nil {
+		nodes, err := s.queueManager.ListNodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sample := alerts.Sample{
+			Time:       now,
+			QueueDepth: stats["queued"],
+			Running:    stats["processing"],
+			Speed:      metrics[alerts.TotalSpeed],
+			Finished:   s.alertTracker.Finished(),
+			Nodes:      len(nodes),
+		}
+		for _, node := range nodes {
+			sample.Slots += node.Workers
+		}
+		previous, observed := s.watchdog.Last()
+		hint := s.watchdog.Observe(sample)
+		hint.AddMetrics(metrics)
+		if observed && previous.DesiredNodes != hint.DesiredNodes {
+			s.notifyScale(ctx, previous.DesiredNodes)
+		}
+	}
+	return metrics, nil
+}
+
+// notifyScale logs that the worker nodes the watchdog suggests changed from
+// previous and posts its hint to the scale webhooks
+func (s *QueuedDownloadServer) notifyScale(ctx context.Context, previous int) {
+	hint := s.watchdog.API()
+	s.logger.Info("Scaling hint changed",
+		zap.Int("previous_workers", previous),
+		zap.Int("desired_workers", hint.DesiredWorkers),
+		zap.Int64("queue_depth", hint.QueueDepth),
+		zap.Bool("stalled", hint.Stalled))
+	for _, webhook := range s.scaleWebhooks {
+		notifyCtx, cancel := context.WithTimeout(ctx, alerts.NotifyTimeout)
+		if err := webhook.Scale(notifyCtx, s.events.Node(), previous, hint); err != nil {
+			s.logger.Warn("Failed to send scaling hint", zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+// configureAlerts evaluates cfg's rules every interval until ctx ends,
+// logging and posting to the webhooks when they fire and resolve
+func (s *QueuedDownloadServer) configureAlerts(ctx context.Context, cfg alerts.Config) {
+	notifiers := []alerts.Notifier{alerts.NotifierFunc(func(ctx context.Context, n alerts.Notification) error {
+		fields := []zap.Field{
+			zap.String("rule", n.Rule.Name),
+			zap.String("condition", n.Rule.String()),
+			zap.Float64("value", n.Value),
+			zap.String("severity", n.Rule.Severity),
+		}
+		if n.State == alerts.Firing {
+			s.logger.Warn("Alert firing", fields...)
+		} else {
+			s.logger.Info("Alert resolved", fields...)
+		}
+		return nil
+	})}
+	for _, url := range cfg.Webhooks {
+		notifiers = append(notifiers, &alerts.Webhook{URL: url})
+	}
+	rules := cfg.Rules
+	if cfg.StallWindow > 0 {
+		s.watchdog = alerts.NewWatchdog(cfg.StallWindow, cfg.IdleSpeed)
+		s.watchdog.MinNodes, s.watchdog.MaxNodes = cfg.MinWorkers, cfg.MaxWorkers
+		for _, url := range cfg.ScaleWebhooks {
+			s.scaleWebhooks = append(s.scaleWebhooks, &alerts.Webhook{URL: url})
+		}
+		// A rule of the same name replaces the built-in one
+		builtIn := true
+		for _, rule := range rules {
+			builtIn = builtIn && rule.Name != alerts.StalledRuleName
+		}
+		if builtIn {
+			rules = append(append([]alerts.Rule(nil), rules...), s.watchdog.StalledRule())
+		}
+	}
+	s.alerts = alerts.NewEngine(s.events.Node(), rules, notifiers...)
+	s.alerts.OnError = func(n alerts.Notification, err error) {
+		s.logger.Warn("Failed to send alert", zap.String("rule", n.Rule.Name), zap.Error(err))
+	}
+	go s.alerts.Run(ctx, cfg.Interval, s.alertMetrics, func(err error) {
+		s.logger.Warn("Failed to measure alert metrics", zap.Error(err))
+	})
+}
+
+// listQueuedJobsHandler handles GET /queue/jobs - lists the jobs waiting in
+// the main queue, the next one first
+func (s *QueuedDownloadServer) listQueuedJobsHandler(c *gin.Context) {
+	jobs, err := s.queueManager.ListQueuedJobs(c.Request.Context())
+	if err != nil {
+		s.logger.Error("Failed to list queued jobs", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list queued jobs",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	list := openapi.QueuedJobList{Jobs: make([]openapi.QueuedJob, 0, len(jobs))}
+	for _, job := range jobs {
+		list.Jobs = append(list.Jobs, openapi.QueuedJob{
+			Position:   job.Position,
+			Node:       job.Queue,
+			ID:         job.ID,
+			URL:        secrets.RedactURL(job.URL),
+			OutputPath: job.OutputPath,
+			Threads:    job.Threads,
+			Method:     job.Method,
+			GroupID:    job.GroupID,
+			CreatedAt:  job.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	list.Count = len(list.Jobs)
+	
+	c.JSON(http.StatusOK, list)
+}
+
+// moveJobToFrontHandler handles POST /queue/jobs/:id/move-to-front - makes a
+// queued job the next one a worker takes
+func (s *QueuedDownloadServer) moveJobToFrontHandler(c *gin.Context) {
+	jobID := c.Param("id")
+	
+	err := s.queueManager.MoveJobToFront(c.Request.Context(), jobID)
+	if errors.Is(err, ErrJobNotQueued) {
+		queueStatus, statusErr := s.queueManager.GetJobStatus(c.Request.Context(), jobID)
+		if statusErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Download not found",
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Download is not queued",
+			"details": fmt.Sprintf("download is %s", queueStatus.Status),
+		})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to move job to the front of the queue", zap.String("job_id", jobID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to move download",
+			"details": err.Error(),
+		})
+		return
+	}
 	
-	if !redisHealthy || !dbHealthy {
-		status = "unhealthy"
-		httpStatus = http.StatusServiceUnavailable
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Download moved to the front of the queue",
+	})
+}
+
+// archivedJobsHandler returns the handler for GET /queue/completed or
+// /queue/failed - lists the most recently finished jobs in queue
+func (s *QueuedDownloadServer) archivedJobsHandler(queue string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > MaxArchivedJobs {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "Invalid limit",
+					"details": fmt.Sprintf("limit must be a number from 1 to %d", MaxArchivedJobs),
+				})
+				return
+			}
+			limit = n
+		}
+		
+		jobs, err := s.queueManager.ListArchivedJobs(c.Request.Context(), queue, limit)
+		if err != nil {
+			s.logger.Error("Failed to list archived jobs", zap.String("queue", queue), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to list jobs",
+				"details": err.Error(),
+			})
+			return
+		}
+		
+		list := openapi.ArchivedJobList{Jobs: make([]openapi.ArchivedJob, 0, len(jobs))}
+		for _, job := range jobs {
+			archived := openapi.ArchivedJob{
+				ID:              job.ID,
+				URL:             secrets.RedactURL(job.URL),
+				OutputPath:      job.OutputPath,
+				GroupID:         job.GroupID,
+				Status:          string(job.Status),
+				WorkerID:        job.WorkerID,
+				BytesDownloaded: job.BytesDownloaded,
+				ErrorMessage:    job.ErrorMessage,
+				CreatedAt:       job.CreatedAt.Format(time.RFC3339),
+				CompletedAt:     job.CompletedAt.Format(time.RFC3339),
+				DurationSeconds: job.Duration().Seconds(),
+			}
+			if !job.StartedAt.IsZero() {
+				archived.StartedAt = job.StartedAt.Format(time.RFC3339)
+			}
+			list.Jobs = append(list.Jobs, archived)
+		}
+		list.Count = len(list.Jobs)
+		
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// getWorkerStatsHandler handles GET /workers/stats
+func (s *QueuedDownloadServer) getWorkerStatsHandler(c *gin.Context) {
+	// Per-worker stats live in each worker process; the registry only
+	// knows which worker nodes are running
+	nodes, err := s.queueManager.ListNodes(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list worker nodes",
+			"details": err.Error(),
+		})
+		return
 	}
 	
-	c.JSON(httpStatus, gin.H{
-		"status":    status,
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "2.0.0-queue",
-		"checks": gin.H{
-			"redis":    redisHealthy,
-			"database": dbHealthy,
+	c.JSON(http.StatusOK, gin.H{
+		"worker_stats": gin.H{
+			"message": "Worker stats available when running with worker manager",
 		},
+		"nodes":     nodes,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// scheduleInterval is how often the schedule leader looks for due schedules
+const scheduleInterval = 15 * time.Second
+
+// scheduleLeaseTTL is how long a server stays schedule leader without renewing
+const scheduleLeaseTTL = 30 * time.Second
+
+// createScheduleHandler handles POST /schedules - stores a download enqueued on a cron schedule
+func (s *QueuedDownloadServer) createScheduleHandler(c *gin.Context) {
+	var req openapi.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody(err).respond(c)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		invalidBody(err).respond(c)
+		return
+	}
+	if len(req.Download.DependsOn) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid download request",
+			"details": "a scheduled download cannot depend on other jobs",
+		})
+		return
+	}
+	
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid time zone",
+			"details": err.Error(),
+		})
+		return
+	}
+	spec, err := cron.Parse(req.Cron)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid cron schedule",
+			"details": err.Error(),
+		})
+		return
+	}
+	nextRun := spec.Next(time.Now().In(loc))
+	if nextRun.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid cron schedule",
+			"details": fmt.Sprintf("%q never fires", req.Cron),
+		})
+		return
+	}
+	
+	// Every run enqueues this job under a new ID; output templates are
+	// expanded once, with the schedule's ID, so all runs write one file
+	scheduleID := uuid.New().String()
+	job, reqErr := s.newJob(&req.Download, apiVersion(c), scheduleID)
+	if reqErr != nil {
+		reqErr.respond(c)
+		return
+	}
+	job.ID = ""
+	// Runs are accounted to who created the schedule
+	job.User = events.ActorFrom(c.Request.Context())
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to create schedule",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	schedule := &Schedule{
+		ID:       scheduleID,
+		Name:     req.Name,
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Job:      string(jobData),
+		Paused:   req.Paused,
+		NextRun:  nextRun,
+	}
+	if err := s.dbManager.CreateSchedule(schedule); err != nil {
+		s.logger.Error("Failed to create schedule", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to create schedule",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	s.logger.Info("Schedule created",
+		zap.String("schedule_id", scheduleID),
+		zap.String("cron", req.Cron),
+		zap.String("url", secrets.RedactURL(job.URL)),
+		zap.Time("next_run", nextRun))
+	c.JSON(http.StatusCreated, scheduleView(schedule))
+}
+
+// scheduleView describes a stored schedule, with times in its time zone
+func scheduleView(schedule *Schedule) openapi.Schedule {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	view := openapi.Schedule{
+		ID:        schedule.ID,
+		Name:      schedule.Name,
+		Cron:      schedule.Cron,
+		Timezone:  schedule.Timezone,
+		Paused:    schedule.Paused,
+		CreatedAt: schedule.CreatedAt.Format(time.RFC3339),
+	}
+	var job DownloadJob
+	if err := json.Unmarshal([]byte(schedule.Job), &job); err == nil {
+		view.URL = secrets.RedactURL(job.URL)
+		view.OutputPath = job.OutputPath
+		view.OnlyIfModified = job.OnlyIfModified
+	}
+	if !schedule.Paused {
+		view.NextRun = schedule.NextRun.In(loc).Format(time.RFC3339)
+	}
+	if !schedule.LastRun.IsZero() {
+		view.LastRun = schedule.LastRun.In(loc).Format(time.RFC3339)
+	}
+	return view
+}
+
+// listSchedulesHandler handles GET /schedules
+func (s *QueuedDownloadServer) listSchedulesHandler(c *gin.Context) {
+	schedules, err := s.dbManager.GetSchedules()
+	if err != nil {
+		s.logger.Error("Failed to list schedules", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list schedules",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	list := openapi.ScheduleList{Schedules: make([]openapi.Schedule, 0, len(schedules))}
+	for i := range schedules {
+		list.Schedules = append(list.Schedules, scheduleView(&schedules[i]))
+	}
+	list.Count = len(list.Schedules)
+	c.JSON(http.StatusOK, list)
+}
+
+// getSchedule looks up the schedule named in the path. It writes an error
+// response and returns nil if there is none.
+func (s *QueuedDownloadServer) getSchedule(c *gin.Context) *Schedule {
+	schedule, err := s.dbManager.GetSchedule(c.Param("id"))
+	if errors.Is(err, ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Schedule not found",
+		})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to get schedule",
+			"details": err.Error(),
+		})
+		return nil
+	}
+	return schedule
+}
+
+// getScheduleHandler handles GET /schedules/:id
+func (s *QueuedDownloadServer) getScheduleHandler(c *gin.Context) {
+	if schedule := s.getSchedule(c); schedule != nil {
+		c.JSON(http.StatusOK, scheduleView(schedule))
+	}
+}
+
+// pauseScheduleHandler handles POST /schedules/:id/pause and, with paused
+// false, POST /schedules/:id/resume
+func (s *QueuedDownloadServer) pauseScheduleHandler(paused bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schedule := s.getSchedule(c)
+		if schedule == nil {
+			return
+		}
+		
+		// A resumed schedule runs next at its next time from now, not at
+		// the times it missed
+		var nextRun time.Time
+		if !paused {
+			if nextRun = nextScheduleRun(schedule, time.Now()); nextRun.IsZero() {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":   "Schedule cannot be resumed",
+					"details": fmt.Sprintf("%q never fires", schedule.Cron),
+				})
+				return
+			}
+		}
+		if err := s.dbManager.SetSchedulePaused(schedule.ID, paused, nextRun); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to update schedule",
+				"details": err.Error(),
+			})
+			return
+		}
+		
+		schedule.Paused = paused
+		if !paused {
+			schedule.NextRun = nextRun
+		}
+		s.logger.Info("Schedule updated", zap.String("schedule_id", schedule.ID), zap.Bool("paused", paused))
+		c.JSON(http.StatusOK, scheduleView(schedule))
+	}
+}
+
+// deleteScheduleHandler handles DELETE /schedules/:id
+func (s *QueuedDownloadServer) deleteScheduleHandler(c *gin.Context) {
+	err := s.dbManager.DeleteSchedule(c.Param("id"))
+	if errors.Is(err, ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Schedule not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete schedule",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Schedule deleted",
 	})
 }
 
-// Run starts the HTTP server
-func (s *QueuedDownloadServer) Run(port string) error {
-	s.logger.Info("Starting queued download server", zap.String("port", port))
-	return s.router.Run(":" + port)
+// scheduleRunsHandler handles GET /schedules/:id/runs
+func (s *QueuedDownloadServer) scheduleRunsHandler(c *gin.Context) {
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxScheduleRuns {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid limit",
+				"details": fmt.Sprintf("limit must be a number from 1 to %d", maxScheduleRuns),
+			})
+			return
+		}
+		limit = n
+	}
+	schedule := s.getSchedule(c)
+	if schedule == nil {
+		return
+	}
+	
+	runs, err := s.dbManager.GetScheduleRuns(schedule.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list schedule runs",
+			"details": err.Error(),
+		})
+		return
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	list := openapi.ScheduleRunList{Runs: make([]openapi.ScheduleRun, 0, len(runs))}
+	for _, run := range runs {
+		list.Runs = append(list.Runs, openapi.ScheduleRun{
+			Time:    run.Time.In(loc).Format(time.RFC3339),
+			Result:  run.Result,
+			JobID:   run.JobID,
+			Details: run.Details,
+		})
+	}
+	list.Count = len(list.Runs)
+	c.JSON(http.StatusOK, list)
+}
+
+// nextScheduleRun returns when the schedule fires next after now, or the
+// zero time if it never does
+func nextScheduleRun(schedule *Schedule, now time.Time) time.Time {
+	spec, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return time.Time{}
+	}
+	return spec.Next(now.In(loc))
+}
+
+// RunSchedules enqueues the downloads of due schedules until ctx is
+// cancelled. Only the replica elected schedule leader looks for them; a
+// schedule that was down while several of its times passed runs once.
+func (s *QueuedDownloadServer) RunSchedules(ctx context.Context) {
+	elector := leader.NewElector(s.queueManager.LeaderStore(), "schedules", serverNode()+"-"+uuid.New().String()[:8], scheduleLeaseTTL)
+	elector.OnError = func(err error) {
+		s.logger.Warn("Schedule leader election failed", zap.Error(err))
+	}
+	go elector.Run(ctx)
+	
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !elector.IsLeader() {
+				continue
+			}
+			now := time.Now()
+			schedules, err := s.dbManager.GetDueSchedules(now)
+			if err != nil {
+				s.logger.Warn("Failed to look for due schedules", zap.Error(err))
+				continue
+			}
+			for i := range schedules {
+				s.runSchedule(ctx, &schedules[i], now)
+			}
+		}
+	}
+}
+
+// runSchedule claims one due run of the schedule, enqueues its download
+// and records the run
+func (s *QueuedDownloadServer) runSchedule(ctx context.Context, schedule *Schedule, now time.Time) {
+	logger := s.logger.With(zap.String("schedule_id", schedule.ID))
+	nextRun := nextScheduleRun(schedule, now)
+	if nextRun.IsZero() {
+		logger.Error("Pausing schedule that never fires again", zap.String("cron", schedule.Cron), zap.String("timezone", schedule.Timezone))
+		if err := s.dbManager.SetSchedulePaused(schedule.ID, true, time.Time{}); err != nil {
+			logger.Error("Failed to pause schedule", zap.Error(err))
+		}
+		return
+	}
+	claimed, err := s.dbManager.ClaimScheduleRun(schedule.ID, schedule.NextRun, nextRun, now)
+	if err != nil {
+		logger.Warn("Failed to claim schedule run", zap.Error(err))
+		return
+	}
+	if !claimed {
+		// Paused, deleted or run by another replica meanwhile
+		return
+	}
+	
+	run := &ScheduleRun{ScheduleID: schedule.ID, Time: now}
+	// Never let two runs write the same file at once
+	if schedule.LastJobID != "" {
+		if status, err := s.queueManager.GetJobStatus(ctx, schedule.LastJobID); err == nil && !status.Status.Terminal() {
+			run.Result = ScheduleSkipped
+			run.JobID = schedule.LastJobID
+			run.Details = fmt.Sprintf("previous job is still %s", status.Status)
+		}
+	}
+	if run.Result == "" {
+		var job DownloadJob
+		if err := json.Unmarshal([]byte(schedule.Job), &job); err != nil {
+			run.Result, run.Details = ScheduleFailed, fmt.Sprintf("invalid stored job: %v", err)
+		} else {
+			job.ID = uuid.New().String()
+			if _, reqErr := s.enqueueJob(events.WithActor(ctx, "schedule "+schedule.ID), &job); reqErr != nil {
+				run.Result, run.Details = ScheduleFailed, reqErr.Error()
+			} else {
+				run.Result, run.JobID = ScheduleEnqueued, job.ID
+			}
+		}
+	}
+	
+	if err := s.dbManager.RecordScheduleRun(run); err != nil {
+		logger.Error("Failed to record schedule run", zap.Error(err))
+	}
+	logger.Info("Schedule ran",
+		zap.String("result", run.Result),
+		zap.String("job_id", run.JobID),
+		zap.String("details", run.Details),
+		zap.Time("next_run", nextRun))
+}
+
+// healthHandler handles GET /health - checks Redis, the database and, when
+// the download directory is mounted here too, that files can be written
+func (s *QueuedDownloadServer) healthHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	resp := openapi.HealthResponse{
+		Status:    "healthy",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Version:   "2.0.0-queue",
+		Checks:    map[string]bool{},
+		Dependencies: map[string]openapi.DependencyCheck{
+			"redis": health.Check(ctx, func(ctx context.Context) error {
+				return s.queueManager.client.Ping(ctx).Err()
+			}),
+		},
+	}
+	if s.dbManager != nil {
+		resp.Dependencies["database"] = health.Check(ctx, s.dbManager.Ping)
+	} else {
+		resp.Checks["database"] = true
+	}
+	if s.downloadDir != "" {
+		resp.Dependencies["filesystem"] = health.Check(ctx, health.Filesystem(s.downloadDir))
+	}
+	health.Fill(&resp, s.downloadDir)
+	
+	httpStatus := http.StatusOK
+	for name, check := range resp.Dependencies {
+		resp.Checks[name] = check.Healthy
+		if !check.Healthy {
+			s.logger.Warn("Health check failed",
+				zap.String("dependency", name),
+				zap.Float64("latency_ms", check.LatencyMs),
+				zap.String("error", check.Error))
+			resp.Status = "unhealthy"
+			httpStatus = http.StatusServiceUnavailable
+		}
+	}
+	if apiVersion(c) == string(apiversion.V1) {
+		c.JSON(httpStatus, resp.V1())
+		return
+	}
+	c.JSON(httpStatus, resp)
+}
+
+// Run serves the API on ln until it fails. The admin routes are served on
+// adminLn when it is not nil, and on ln otherwise.
+func (s *QueuedDownloadServer) Run(ln, adminLn net.Listener) error {
+	s.setupRoutes(adminLn != nil)
+	if adminLn != nil {
+		s.logger.Info("Starting admin server", zap.String("address", listener.Describe(adminLn)))
+		go func() {
+			if err := s.adminRouter.RunListener(adminLn); err != nil {
+				s.logger.Fatal("Failed to start admin server", zap.Error(err))
+			}
+		}()
+	}
+	s.logger.Info("Starting queued download server", zap.String("address", listener.Describe(ln)))
+	return s.router.RunListener(ln)
 }
 
 // main function for running the queued server
 func main() {
-	// Initialize logger
-	logger, err := zap.NewProduction()
+	// Initialize logger from LOG_CONFIG and LOG_* settings
+	logger, closeLog, err := logging.NewFromEnv()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize logger: %v", err))
 	}
-	defer logger.Sync()
+	defer closeLog()
 	
 	// Configuration from environment variables
 	redisURL := getEnv("REDIS_URL", "redis://localhost:6379")
@@ -415,7 +2572,9 @@ func main() {
 	port := getEnv("PORT", "8080")
 	
 	logger.Info("Starting queued download server",
-		zap.String("redis_url", redisURL),
+		zap.String("version", health.Version),
+		zap.String("commit", health.Commit),
+		zap.String("redis_url", secrets.RedactURL(redisURL)),
 		zap.String("port", port))
 	
 	// Initialize queue manager
@@ -438,24 +2597,167 @@ func main() {
 	// Create and start server
 	server := NewQueuedDownloadServer(queueManager, dbManager, logger)
 	
+	// Configure the User-Agent/Referer spoofing policy
+	policy, err := downloader.ParseSpoofingPolicy(getEnv("SPOOFING_POLICY", ""))
+	if err != nil {
+		logger.Fatal("Invalid SPOOFING_POLICY", zap.Error(err))
+	}
+	server.spoofingPolicy = policy
+	
+	// Guard the admin routes, which move to ADMIN_ADDR when it is set
+	adminPolicy, err := access.PolicyFromEnv()
+	if err != nil {
+		logger.Fatal("Invalid admin access settings", zap.Error(err))
+	}
+	server.adminPolicy = adminPolicy
+	adminAddr := getEnv("ADMIN_ADDR", "")
+	server.browserPolicy, err = browser.PolicyFromEnv()
+	if err != nil {
+		logger.Fatal("Invalid browser settings", zap.Error(err))
+	}
+	server.maxBodyBytes, err = openapi.MaxBodyBytesFromEnv()
+	if err != nil {
+		logger.Fatal("Invalid request body limit", zap.Error(err))
+	}
+	server.downloadDir = getEnv("DOWNLOAD_DIR", "")
+	server.debugEndpoints = getEnv("DEBUG_ENDPOINTS", "") == "true"
+	
+	// Per-host defaults for jobs
+	if rulesFile := getEnv("DOMAIN_RULES_FILE", ""); rulesFile != "" {
+		store, err := domainrules.NewStore(rulesFile)
+		if err != nil {
+			logger.Fatal("Invalid DOMAIN_RULES_FILE", zap.Error(err))
+		}
+		server.domainRules = store
+		logger.Info("Domain rules loaded",
+			zap.String("file", rulesFile),
+			zap.Int("rules", len(store.Rules())))
+	}
+	
+	// Load the master key that seals job credentials
+	if keyFile := getEnv("SECRETS_MASTER_KEY_FILE", ""); keyFile != "" {
+		box, err := secrets.LoadBox(keyFile)
+		if err != nil {
+			logger.Fatal("Invalid SECRETS_MASTER_KEY_FILE", zap.Error(err))
+		}
+		server.secrets = box
+	}
+	
+	// Show the range checks the workers will reuse
+	probeTTL, err := time.ParseDuration(getEnv("PROBE_CACHE_TTL", DefaultProbeCacheTTL.String()))
+	if err != nil || probeTTL < 0 {
+		logger.Fatal("Invalid PROBE_CACHE_TTL", zap.String("value", os.Getenv("PROBE_CACHE_TTL")))
+	}
+	if probeTTL > 0 {
+		server.probes = dbManager.ProbeCache(probeTTL)
+	}
+	
+	// Receive lifecycle events from the workers
+	if getEnv("EVENT_BRIDGE_ENABLED", "true") == "true" {
+		bridgeCtx, stopBridge := context.WithCancel(context.Background())
+		defer stopBridge()
+		go queueManager.BridgeEvents(bridgeCtx, server.events)
+	} else {
+		server.watcher = nil
+	}
+	defer server.events.Close()
+	
+	// Estimate what transferred bytes cost in usage reports
+	server.usageCostPerGB, err = usage.CostFromEnv()
+	if err != nil {
+		logger.Fatal("Invalid usage settings", zap.Error(err))
+	}
+	
+	// Evaluate the alert rules
+	alertConfig, err := alerts.FromEnv()
+	if err != nil {
+		logger.Fatal("Invalid alert settings", zap.Error(err))
+	}
+	if len(alertConfig.Rules) > 0 || alertConfig.StallWindow > 0 {
+		alertCtx, stopAlerts := context.WithCancel(context.Background())
+		defer stopAlerts()
+		server.configureAlerts(alertCtx, alertConfig)
+		logger.Info("Alert rules loaded",
+			zap.Int("rules", len(alertConfig.Rules)),
+			zap.Int("webhooks", len(alertConfig.Webhooks)),
+			zap.Duration("interval", alertConfig.Interval),
+			zap.Duration("stall_window", alertConfig.StallWindow))
+	}
+	server.scalingMetrics = getEnv("SCALING_METRICS_ENABLED", "") == "true"
+	
+	// Enqueue the downloads of due schedules
+	scheduleCtx, stopSchedules := context.WithCancel(context.Background())
+	defer stopSchedules()
+	go server.RunSchedules(scheduleCtx)
+	
 	logger.Info("Queued download server starting",
 		zap.String("port", port),
 		zap.String("mode", "queue-based"))
 	
 	fmt.Println("Queued Multithreaded Downloader REST API Server")
 	fmt.Println("===============================================")
-	fmt.Printf("Server starting on port %s...\n", port)
-	fmt.Printf("API endpoints available at http://localhost:%s\n", port)
-	fmt.Println("\nAvailable endpoints:")
+	// Listen on PORT, or the Unix socket in UNIX_SOCKET or the socket
+	// passed by systemd
+	ln, err := listener.FromEnv(":" + port)
+	if err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
+	}
+	fmt.Printf("Server listening on %s...\n", listener.Describe(ln))
+	var adminLn net.Listener
+	adminAt := "on the same port"
+	if adminAddr != "" {
+		adminLn, err = listener.Open(adminAddr, 0600)
+		if err != nil {
+			logger.Fatal("Failed to start admin server", zap.Error(err))
+		}
+		adminAt = "on " + listener.Describe(adminLn)
+	}
+	fmt.Println("\nAvailable endpoints (under /api/v1 and /api/v2; unversioned paths are deprecated v1 aliases):")
 	fmt.Println("  POST   /downloads           - Enqueue a new download")
 	fmt.Println("  GET    /downloads           - List all downloads")
 	fmt.Println("  GET    /downloads/:id/status - Get download status")
+	fmt.Println("  PATCH  /downloads/:id        - Change a running download's rate limit or threads (v2)")
+	fmt.Println("  GET    /downloads/:id/parts  - Connection diagnostics of every part (v2)")
+	fmt.Println("  GET    /downloads/:id/map    - Bitmap and segments of the completed byte ranges (v2)")
+	fmt.Println("  GET    /downloads/:id/events - When the download was queued, started, requeued or finished (v2)")
+	fmt.Println("  POST   /downloads/:id/parts/:index/restart - Retry a stuck part's connection (v2)")
+	fmt.Println("  POST   /groups              - Enqueue a group from a URL template or page links (v2)")
+	fmt.Println("  POST   /groups/preview      - Preview the URLs a group would contain (v2)")
+	fmt.Println("  GET    /groups/:id          - Get status of a download group (v2)")
+	fmt.Println("  GET    /groups/:id/checksums - Get the SHA256SUMS file of a finished group (v2)")
+	fmt.Println("  GET    /groups/:id/checksums.sig - Get its signature (v2)")
+	fmt.Println("  POST   /schedules           - Enqueue a download on a cron schedule (v2)")
+	fmt.Println("  GET    /schedules           - List schedules (v2)")
+	fmt.Println("  GET    /schedules/:id       - Get a schedule (v2)")
+	fmt.Println("  DELETE /schedules/:id       - Delete a schedule (v2)")
+	fmt.Println("  POST   /schedules/:id/pause - Pause or, with /resume, resume a schedule (v2)")
+	fmt.Println("  GET    /schedules/:id/runs  - Past runs of a schedule (v2)")
+	fmt.Println("  GET    /probe?url=          - Range support, size and validators of a URL (v2)")
+	fmt.Println("  GET    /health              - Health check")
+	fmt.Println("  GET    /openapi.json        - OpenAPI document")
+	fmt.Println("  GET    /docs                - Swagger UI")
+	fmt.Printf("\nAdmin endpoints (%s):\n", adminAt)
+	fmt.Println("  POST   /cookies             - Import a cookies.txt file")
+	fmt.Println("  DELETE /cookies             - Clear imported cookies")
+	fmt.Println("  GET    /domain-rules        - Per-host download defaults (v2)")
+	fmt.Println("  PUT    /domain-rules        - Replace the per-host defaults (v2)")
+	fmt.Println("  GET    /domain-rules/match  - Defaults a URL gets from the rules (v2)")
 	fmt.Println("  GET    /queue/stats         - Get queue statistics")
+	fmt.Println("  GET    /queue/jobs          - List queued jobs in order (v2)")
+	fmt.Println("  POST   /queue/jobs/:id/move-to-front - Move a queued job to the front (v2)")
+	fmt.Println("  GET    /queue/completed     - List recently completed jobs (v2)")
+	fmt.Println("  GET    /queue/failed        - List recently failed jobs (v2)")
 	fmt.Println("  GET    /workers/stats       - Get worker statistics")
-	fmt.Println("  GET    /health              - Health check")
+	fmt.Println("  GET    /alerts              - State of the alert rules (v2)")
+	fmt.Println("  GET    /usage               - Bytes transferred per user, tag or domain (v2)")
+	if server.debugEndpoints {
+		fmt.Println("  GET    /debug/pprof/        - CPU, heap and goroutine profiles")
+		fmt.Println("  GET    /debug/vars          - expvar variables")
+		fmt.Println("  GET    /debug/downloads     - Queue and worker node state")
+	}
 	fmt.Println("\nNote: This server enqueues jobs. Start workers separately to process downloads.")
 	
-	if err := server.Run(port); err != nil {
+	if err := server.Run(ln, adminLn); err != nil {
 		logger.Fatal("Failed to start server", zap.Error(err))
 	}
 }
@@ -0,0 +1,155 @@
+// Package service registers the agent or an API server with the operating
+// system's service manager, so it starts with the machine or the user's
+// session and is restarted when it fails: a systemd unit on Linux, a launchd
+// agent or daemon on macOS and a Windows service on Windows.
+package service
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupported is returned on systems without a supported service manager
+var ErrUnsupported = errors.New("services are not supported on this system")
+
+// LabelPrefix starts the launchd label of every service
+const LabelPrefix = "org.mtdl."
+
+// Config describes a program to run as a service
+type Config struct {
+	// Name identifies the service, such as mtdl-agent
+	Name        string
+	Description string
+	// Exec is the absolute path of the program and Args its arguments
+	Exec string
+	Args []string
+	// Env holds KEY=VALUE pairs set for the program
+	Env []string
+	// System installs the service for the whole machine, started at boot;
+	// otherwise it runs in the user's session. Windows services are always
+	// installed for the machine.
+	System bool
+	// LogFile receives the program's output; empty uses the journal with
+	// systemd and a file named after the service elsewhere
+	LogFile string
+}
+
+// Validate checks that the service can be installed
+func (c *Config) Validate() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, `/\ "'`) {
+		return fmt.Errorf("invalid service name %q", c.Name)
+	}
+	if !filepath.IsAbs(c.Exec) {
+		return fmt.Errorf("program path must be absolute: %s", c.Exec)
+	}
+	for _, pair := range c.Env {
+		if i := strings.Index(pair, "="); i < 1 {
+			return fmt.Errorf("invalid environment variable %q: want KEY=VALUE", pair)
+		}
+	}
+	return nil
+}
+
+// Label returns the launchd label of the service
+func (c *Config) Label() string {
+	return LabelPrefix + c.Name
+}
+
+// systemdUnit returns the unit file of the service
+func systemdUnit(c Config) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", systemdEscape(c.Description))
+	if c.System {
+		// The user manager has no network-online.target
+		b.WriteString("Wants=network-online.target\nAfter=network-online.target\n")
+	}
+
+	b.WriteString("\n[Service]\nType=simple\n")
+	command := []string{systemdQuote(c.Exec)}
+	for _, arg := range c.Args {
+		command = append(command, systemdQuote(arg))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(command, " "))
+	for _, pair := range c.Env {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(pair))
+	}
+	if c.LogFile != "" {
+		fmt.Fprintf(&b, "StandardOutput=append:%s\n", systemdEscape(c.LogFile))
+		fmt.Fprintf(&b, "StandardError=append:%s\n", systemdEscape(c.LogFile))
+	}
+	// SIGINT lets downloads save their progress before the program exits
+	b.WriteString("KillSignal=SIGINT\nTimeoutStopSec=30\nRestart=on-failure\nRestartSec=5\n")
+
+	b.WriteString("\n[Install]\n")
+	if c.System {
+		b.WriteString("WantedBy=multi-user.target\n")
+	} else {
+		b.WriteString("WantedBy=default.target\n")
+	}
+	return b.String()
+}
+
+// systemdEscape escapes the specifiers and variables systemd expands
+func systemdEscape(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// systemdQuote quotes a word of an ExecStart or Environment line
+func systemdQuote(s string) string {
+	s = systemdEscape(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// launchdPlist returns the property list of the service
+func launchdPlist(c Config) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	plistKey(&b, "Label", c.Label())
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{c.Exec}, c.Args...) {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	if len(c.Env) > 0 {
+		env := append([]string(nil), c.Env...)
+		sort.Strings(env)
+		b.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, pair := range env {
+			i := strings.Index(pair, "=")
+			fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", xmlEscape(pair[:i]), xmlEscape(pair[i+1:]))
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	// Restart the program when it fails, but not after a clean stop
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	b.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>5</integer>\n")
+	plistKey(&b, "StandardOutPath", c.LogFile)
+	plistKey(&b, "StandardErrorPath", c.LogFile)
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+func plistKey(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "\t<key>%s</key>\n\t<string>%s</string>\n", key, xmlEscape(value))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
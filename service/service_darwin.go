@@ -0,0 +1,96 @@
+//go:build darwin
+// +build darwin
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// plistPath returns where the service's property list goes
+func plistPath(c Config) (string, error) {
+	if c.System {
+		return filepath.Join("/Library/LaunchDaemons", c.Label()+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", c.Label()+".plist"), nil
+}
+
+// defaultLogFile returns where the service logs without a LogFile
+func defaultLogFile(c Config) (string, error) {
+	if c.System {
+		return filepath.Join("/Library/Logs/mtdl", c.Name+".log"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "Logs", "mtdl", c.Name+".log"), nil
+}
+
+// Install writes a launchd agent, or a daemon for System services, and
+// loads it. It returns the path of the property list.
+func Install(c Config) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	path, err := plistPath(c)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("service %s is already installed at %s", c.Name, path)
+	}
+	if c.LogFile == "" {
+		if c.LogFile, err = defaultLogFile(c); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(c.LogFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(launchdPlist(c)), 0644); err != nil {
+		return "", fmt.Errorf("failed to write property list: %w", err)
+	}
+	if err := launchctl("load", "-w", path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// Uninstall unloads the service and removes its property list
+func Uninstall(c Config) error {
+	path, err := plistPath(c)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("service %s is not installed", c.Name)
+	}
+	if err := launchctl("unload", "-w", path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove property list: %w", err)
+	}
+	return nil
+}
+
+func launchctl(args ...string) error {
+	output, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// unitPath returns where the service's unit file goes
+func unitPath(c Config) (string, error) {
+	if c.System {
+		return filepath.Join("/etc/systemd/system", c.Name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", c.Name+".service"), nil
+}
+
+// Install writes a systemd unit for the service, then enables and starts it.
+// It returns the path of the unit.
+func Install(c Config) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	path, err := unitPath(c)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("service %s is already installed at %s", c.Name, path)
+	}
+	if c.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(c.LogFile), 0755); err != nil {
+			return "", fmt.Errorf("failed to create log directory: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create unit directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(systemdUnit(c)), 0644); err != nil {
+		return "", fmt.Errorf("failed to write unit: %w", err)
+	}
+	if err := systemctl(c, "daemon-reload"); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	if err := systemctl(c, "enable", "--now", c.Name+".service"); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// Uninstall stops and disables the service and removes its unit
+func Uninstall(c Config) error {
+	path, err := unitPath(c)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("service %s is not installed", c.Name)
+	}
+	if err := systemctl(c, "disable", "--now", c.Name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove unit: %w", err)
+	}
+	return systemctl(c, "daemon-reload")
+}
+
+// systemctl runs systemctl against the system or the user manager
+func systemctl(c Config, args ...string) error {
+	if !c.System {
+		args = append([]string{"--user"}, args...)
+	}
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
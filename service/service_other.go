@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package service
+
+// Install is not supported on this system
+func Install(c Config) (string, error) {
+	return "", ErrUnsupported
+}
+
+// Uninstall is not supported on this system
+func Uninstall(c Config) error {
+	return ErrUnsupported
+}
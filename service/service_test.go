@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit(Config{
+		Name:        "mtdl-server",
+		Description: "Downloader API at 100% uptime",
+		Exec:        "/opt/mtdl/server",
+		Args:        []string{"--flag", "two words"},
+		Env:         []string{"UNIX_SOCKET=/run/mtdl/api.sock", "NOTE=$HOME"},
+		System:      true,
+		LogFile:     "/var/log/mtdl.log",
+	})
+	for _, want := range []string{
+		"Description=Downloader API at 100%% uptime\n",
+		"After=network-online.target\n",
+		`ExecStart=/opt/mtdl/server --flag "two words"` + "\n",
+		"Environment=UNIX_SOCKET=/run/mtdl/api.sock\n",
+		"Environment=NOTE=$$HOME\n",
+		"StandardOutput=append:/var/log/mtdl.log\n",
+		"KillSignal=SIGINT\n",
+		"Restart=on-failure\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit lacks %q:\n%s", want, unit)
+		}
+	}
+
+	user := systemdUnit(Config{Name: "mtdl-agent", Exec: "/usr/bin/downloader", Args: []string{"agent"}})
+	if strings.Contains(user, "network-online") || !strings.Contains(user, "WantedBy=default.target") || strings.Contains(user, "StandardOutput") {
+		t.Errorf("user unit:\n%s", user)
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":       "plain",
+		"":            `""`,
+		"a b":         `"a b"`,
+		`say "hi"`:    `"say \"hi\""`,
+		`C:\dir`:      `"C:\\dir"`,
+		"50%":         "50%%",
+		"semi;colon":  `"semi;colon"`,
+		"$PATH value": `"$$PATH value"`,
+	} {
+		if got := systemdQuote(in); got != want {
+			t.Errorf("systemdQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	plist := launchdPlist(Config{
+		Name:    "mtdl-agent",
+		Exec:    "/usr/local/bin/downloader",
+		Args:    []string{"agent", "--concurrency", "2"},
+		Env:     []string{"MTDL_HOME=/Users/me/<mtdl>"},
+		LogFile: "/Users/me/Library/Logs/mtdl/mtdl-agent.log",
+	})
+	if err := xml.Unmarshal([]byte(plist), new(interface{})); err != nil {
+		t.Fatalf("plist is not XML: %v\n%s", err, plist)
+	}
+	for _, want := range []string{
+		"<string>org.mtdl.mtdl-agent</string>",
+		"<string>/usr/local/bin/downloader</string>\n\t\t<string>agent</string>",
+		"<key>MTDL_HOME</key>\n\t\t<string>/Users/me/&lt;mtdl&gt;</string>",
+		"<key>SuccessfulExit</key>\n\t\t<false/>",
+		"<key>StandardErrorPath</key>\n\t<string>/Users/me/Library/Logs/mtdl/mtdl-agent.log</string>",
+	} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("plist lacks %q:\n%s", want, plist)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []Config{
+		{Name: "", Exec: "/bin/true"},
+		{Name: "has space", Exec: "/bin/true"},
+		{Name: "ok", Exec: "relative/server"},
+		{Name: "ok", Exec: "/bin/true", Env: []string{"NOEQUALS"}},
+		{Name: "ok", Exec: "/bin/true", Env: []string{"=value"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", c)
+		}
+	}
+	valid := Config{Name: "mtdl-agent", Exec: "/bin/true", Env: []string{"A=b=c"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
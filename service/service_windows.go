@@ -0,0 +1,179 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// restartDelay is how long Windows waits before restarting a failed service
+const restartDelay = 5 * time.Second
+
+// defaultLogFile returns where the service logs without a LogFile
+func defaultLogFile(c Config) string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "mtdl", "logs", c.Name+".log")
+}
+
+// Install registers a Windows service that starts with the machine and is
+// restarted when it fails, and starts it. Programs run as services must
+// answer the service manager, so the service runs this executable's
+// WrapperCommand, which starts the program and writes its output to the log
+// file. It returns the log file.
+func Install(c Config) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	if c.LogFile == "" {
+		c.LogFile = defaultLogFile(c)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the downloader executable: %w", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to the service manager (run as administrator): %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(c.Name); err == nil {
+		s.Close()
+		return "", fmt.Errorf("service %s is already installed", c.Name)
+	}
+
+	args := []string{WrapperCommand, "--name", c.Name, "--log-file", c.LogFile}
+	for _, pair := range c.Env {
+		args = append(args, "--env", pair)
+	}
+	args = append(append(args, "--", c.Exec), c.Args...)
+	s, err := m.CreateService(c.Name, self, mgr.Config{
+		DisplayName: c.Name,
+		Description: c.Description,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: restartDelay}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete()
+		return "", fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+	// Restart the program also when it exits with an error by itself
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		s.Delete()
+		return "", fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+	if err := s.Start(); err != nil {
+		return c.LogFile, fmt.Errorf("installed but failed to start: %w", err)
+	}
+	return c.LogFile, nil
+}
+
+// Uninstall stops the service and removes it
+func Uninstall(c Config) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager (run as administrator): %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(c.Name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", c.Name)
+	}
+	defer s.Close()
+
+	if status, err := s.Control(svc.Stop); err == nil {
+		for deadline := time.Now().Add(30 * time.Second); status.State != svc.Stopped && time.Now().Before(deadline); {
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to remove service: %w", err)
+	}
+	return nil
+}
+
+// RunWrapped runs command for the service manager as the service name,
+// appending its output to logFile, until the program exits or the service
+// is stopped. It is what WrapperCommand runs.
+func RunWrapped(name, logFile string, env, command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("no program to run")
+	}
+	if isService, err := svc.IsWindowsService(); err != nil || !isService {
+		return fmt.Errorf("%s is only run by the Windows service manager", WrapperCommand)
+	}
+	return svc.Run(name, &wrapper{logFile: logFile, env: env, command: command})
+}
+
+// wrapper is the service handler that runs the program
+type wrapper struct {
+	logFile string
+	env     []string
+	command []string
+}
+
+func (w *wrapper) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	if err := os.MkdirAll(filepath.Dir(w.logFile), 0755); err != nil {
+		return true, 1
+	}
+	log, err := os.OpenFile(w.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return true, 1
+	}
+	defer log.Close()
+
+	cmd := exec.Command(w.command[0], w.command[1:]...)
+	cmd.Stdout, cmd.Stderr = log, log
+	cmd.Env = append(os.Environ(), w.env...)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(log, "%s failed to start %s: %v\n", time.Now().Format(time.RFC3339), w.command[0], err)
+		return true, 1
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-exited:
+			if err != nil {
+				fmt.Fprintf(log, "%s %s exited: %v\n", time.Now().Format(time.RFC3339), w.command[0], err)
+				// A service specific error makes Windows restart it
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				// Services have no console to send an interrupt to; the
+				// program's saved progress lets its downloads resume
+				cmd.Process.Kill()
+				<-exited
+				return false, 0
+			}
+		}
+	}
+}
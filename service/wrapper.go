@@ -0,0 +1,5 @@
+package service
+
+// WrapperCommand is the downloader subcommand Windows services run to start
+// the program they wrap
+const WrapperCommand = "service-run"
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import "fmt"
+
+// RunWrapped is only used by Windows services
+func RunWrapped(name, logFile string, env, command []string) error {
+	return fmt.Errorf("%s is only run by the Windows service manager", WrapperCommand)
+}
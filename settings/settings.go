@@ -0,0 +1,106 @@
+// Package settings holds the server knobs operators can change at runtime
+// through the API. Settings are stored as one name/value row each, so a
+// replica can load what another one saved and every change can be recorded
+// key by key in the audit log.
+package settings
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Names of the stored settings, also used as their JSON field names
+const (
+	GlobalRateLimit        = "global_rate_limit"
+	MaxConcurrentDownloads = "max_concurrent_downloads"
+	DefaultThreads         = "default_threads"
+	RetentionDays          = "retention_days"
+	MinPartSize            = "min_part_size"
+)
+
+// Names lists every setting in the order they are reported
+var Names = []string{GlobalRateLimit, MaxConcurrentDownloads, DefaultThreads, RetentionDays, MinPartSize}
+
+// Settings are the runtime knobs of a server
+type Settings struct {
+	// GlobalRateLimit caps the bytes per second of all downloads together;
+	// zero is unlimited
+	GlobalRateLimit int64
+	// MaxConcurrentDownloads caps how many downloads transfer at once; more
+	// wait as queued. Zero is unlimited.
+	MaxConcurrentDownloads int
+	// DefaultThreads is used for downloads that do not ask for a thread count
+	DefaultThreads int
+	// RetentionDays is how long completed downloads stay in the database
+	RetentionDays int
+	// MinPartSize is the smallest part in bytes a file is split into, so
+	// small files use fewer threads than asked for
+	MinPartSize int64
+}
+
+// Defaults returns the settings of a server nobody has configured
+func Defaults() Settings {
+	return Settings{DefaultThreads: 4, RetentionDays: 7, MinPartSize: 1024 * 1024}
+}
+
+// Retention returns how long completed downloads are kept
+func (s Settings) Retention() time.Duration {
+	return time.Duration(s.RetentionDays) * 24 * time.Hour
+}
+
+// Values returns the settings as name/value pairs for storage
+func (s Settings) Values() map[string]string {
+	return map[string]string{
+		GlobalRateLimit:        strconv.FormatInt(s.GlobalRateLimit, 10),
+		MaxConcurrentDownloads: strconv.Itoa(s.MaxConcurrentDownloads),
+		DefaultThreads:         strconv.Itoa(s.DefaultThreads),
+		RetentionDays:          strconv.Itoa(s.RetentionDays),
+		MinPartSize:            strconv.FormatInt(s.MinPartSize, 10),
+	}
+}
+
+// Parse reads stored name/value pairs. Settings that were never stored keep
+// their default; unknown names are ignored so an older server can read what
+// a newer one saved.
+func Parse(values map[string]string) (Settings, error) {
+	s := Defaults()
+	for name, value := range values {
+		var err error
+		switch name {
+		case GlobalRateLimit:
+			s.GlobalRateLimit, err = strconv.ParseInt(value, 10, 64)
+		case MaxConcurrentDownloads:
+			s.MaxConcurrentDownloads, err = strconv.Atoi(value)
+		case DefaultThreads:
+			s.DefaultThreads, err = strconv.Atoi(value)
+		case RetentionDays:
+			s.RetentionDays, err = strconv.Atoi(value)
+		case MinPartSize:
+			s.MinPartSize, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return Defaults(), fmt.Errorf("invalid setting %s=%q: %w", name, value, err)
+		}
+	}
+	return s, nil
+}
+
+// Change is one setting that differs between two versions
+type Change struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// Diff lists the settings that differ from old to new, in the order of Names
+func Diff(old, new Settings) []Change {
+	before, after := old.Values(), new.Values()
+	var changes []Change
+	for _, name := range Names {
+		if before[name] != after[name] {
+			changes = append(changes, Change{Name: name, Old: before[name], New: after[name]})
+		}
+	}
+	return changes
+}
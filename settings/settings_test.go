@@ -0,0 +1,60 @@
+package settings
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValuesRoundTrip(t *testing.T) {
+	want := Settings{GlobalRateLimit: 1 << 20, MaxConcurrentDownloads: 3, DefaultThreads: 8, RetentionDays: 30, MinPartSize: 256 * 1024}
+	got, err := Parse(want.Values())
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got != want {
+		t.Fatalf("Parse(Values()) = %+v, want %+v", got, want)
+	}
+	if got.Retention() != 30*24*time.Hour {
+		t.Errorf("Retention() = %s", got.Retention())
+	}
+}
+
+func TestParseKeepsDefaults(t *testing.T) {
+	got, err := Parse(map[string]string{DefaultThreads: "2", "future_knob": "x"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := Defaults()
+	want.DefaultThreads = 2
+	if got != want {
+		t.Fatalf("Parse() = %+v, want %+v", got, want)
+	}
+
+	if _, err := Parse(map[string]string{RetentionDays: "a week"}); err == nil {
+		t.Error("Parse() accepted a malformed value")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := Defaults()
+	new := old
+	new.GlobalRateLimit = 500
+	new.RetentionDays = 14
+
+	changes := Diff(old, new)
+	want := []Change{
+		{Name: GlobalRateLimit, Old: "0", New: "500"},
+		{Name: RetentionDays, Old: "7", New: "14"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Diff() = %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Diff() of equal settings = %+v", changes)
+	}
+}
@@ -6,50 +6,47 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"multithreaded-downloader/apiversion"
 	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/events"
+	"multithreaded-downloader/health"
+	"multithreaded-downloader/lifecycle"
+	"multithreaded-downloader/listener"
+	"multithreaded-downloader/openapi"
 )
 
-// Simple download request
-type SimpleDownloadRequest struct {
-	URL     string `json:"url"`
-	Output  string `json:"output"`
-	Threads int    `json:"threads"`
-}
-
-// Simple download response
-type SimpleDownloadResponse struct {
-	DownloadID string `json:"download_id"`
-	Message    string `json:"message"`
-}
-
-// Simple download status
-type SimpleDownloadStatus struct {
-	DownloadID       string  `json:"download_id"`
-	URL              string  `json:"url"`
-	Filename         string  `json:"filename"`
-	Status           string  `json:"status"`
-	PercentCompleted float64 `json:"percent_completed"`
-	BytesDownloaded  int64   `json:"bytes_downloaded"`
-	TotalSize        int64   `json:"total_size"`
-	ThreadsUsed      int     `json:"threads_used"`
-	StartTime        string  `json:"start_time"`
-	Error            string  `json:"error,omitempty"`
-}
+// Simple payloads share the generated types of the direct server, so both
+// servers accept and return the same shapes described in openapi/openapi.json
+type (
+	SimpleDownloadRequest  = openapi.DownloadRequest
+	SimpleDownloadResponse = openapi.DownloadResponse
+	SimpleDownloadStatus   = openapi.DownloadStatus
+)
 
 // Simple managed download
 type SimpleManagedDownload struct {
 	ID         string
 	Downloader *downloader.Downloader
-	Status     string
+	Status     lifecycle.Status
 	StartTime  time.Time
 	Error      error
 	Mutex      sync.RWMutex
 }
 
+// setStatus moves the download to next unless the lifecycle forbids it; the
+// caller holds m.Mutex
+func (m *SimpleManagedDownload) setStatus(next lifecycle.Status) {
+	if status, err := m.Status.Transition(next); err == nil {
+		m.Status = status
+	}
+}
+
 // Simple download manager
 type SimpleDownloadManager struct {
 	downloads map[string]*SimpleManagedDownload
@@ -68,7 +65,7 @@ func (dm *SimpleDownloadManager) AddDownload(id string, dl *downloader.Downloade
 	managed := &SimpleManagedDownload{
 		ID:         id,
 		Downloader: dl,
-		Status:     "downloading",
+		Status:     lifecycle.Downloading,
 		StartTime:  time.Now(),
 	}
 	
@@ -100,30 +97,57 @@ func (dm *SimpleDownloadManager) GetAllDownloads() map[string]*SimpleManagedDown
 	return result
 }
 
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an ErrorResponse as described in the OpenAPI document
+func writeError(w http.ResponseWriter, status int, message, details string) {
+	writeJSON(w, status, openapi.ErrorResponse{Error: message, Details: details})
+}
+
+// writeInvalidBody refuses a request body, listing the fields at fault
+func writeInvalidBody(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, openapi.ErrorResponse{
+		Error:   "Invalid request body",
+		Details: err.Error(),
+		Fields:  openapi.FieldErrors(err),
+	})
+}
+
 // Global simple download manager
 var simpleDownloadManager = NewSimpleDownloadManager()
 
 // Simple start download handler
-func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request) {
+func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request, version apiversion.Version) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
 		return
 	}
 	
 	var req SimpleDownloadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeInvalidBody(w, err)
 		return
 	}
 	
-	// Set default threads
-	if req.Threads <= 0 {
-		req.Threads = 4
+	// Check the request against the OpenAPI document and fill in defaults
+	if err := req.Validate(); err != nil {
+		writeInvalidBody(w, err)
+		return
 	}
+	if err := req.CheckVersion(string(version)); err != nil {
+		writeError(w, http.StatusBadRequest, "Field not available in this API version", err.Error())
+		return
+	}
+	req.ApplyDefaults()
 	
-	// Validate threads
-	if req.Threads > 16 {
-		http.Error(w, "Maximum 16 threads allowed", http.StatusBadRequest)
+	userAgent, referer, err := downloader.SpoofingAllowAny.Resolve(req.UserAgentProfile, req.UserAgent, req.Referer)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request headers", err.Error())
 		return
 	}
 	
@@ -135,6 +159,13 @@ func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// Create downloader
 	dl := downloader.NewDownloader(req.URL, filename, req.Threads)
+	dl.UserAgent = userAgent
+	dl.Referer = referer
+	dl.PreviewBytes = req.PreviewBytes
+	if err := dl.SetRequest(req.Method, []byte(req.Body), req.BodyType); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request method or body", err.Error())
+		return
+	}
 	
 	// Add to manager
 	managed := simpleDownloadManager.AddDownload(downloadID, dl)
@@ -144,8 +175,8 @@ func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if r := recover(); r != nil {
 				managed.Mutex.Lock()
-				managed.Status = "failed"
 				managed.Error = fmt.Errorf("panic: %v", r)
+				managed.setStatus(lifecycle.Failed)
 				managed.Mutex.Unlock()
 			}
 		}()
@@ -153,8 +184,8 @@ func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request) {
 		// Initialize progress
 		if err := dl.LoadOrCreateProgress(); err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("failed to initialize download: %w", err)
+			managed.setStatus(lifecycle.Failed)
 			managed.Mutex.Unlock()
 			return
 		}
@@ -162,8 +193,8 @@ func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request) {
 		// Start download
 		if err := dl.Download(); err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("download failed: %w", err)
+			managed.setStatus(lifecycle.Failed)
 			managed.Mutex.Unlock()
 			return
 		}
@@ -171,47 +202,59 @@ func simpleStartDownloadHandler(w http.ResponseWriter, r *http.Request) {
 		// Verify download
 		if err := dl.VerifyDownload(); err != nil {
 			managed.Mutex.Lock()
-			managed.Status = "failed"
 			managed.Error = fmt.Errorf("verification failed: %w", err)
+			managed.setStatus(lifecycle.Failed)
 			managed.Mutex.Unlock()
 			return
 		}
 		
 		managed.Mutex.Lock()
-		managed.Status = "completed"
+		managed.setStatus(lifecycle.Completed)
 		managed.Mutex.Unlock()
 	}()
 	
 	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(SimpleDownloadResponse{
+	writeJSON(w, http.StatusCreated, SimpleDownloadResponse{
 		DownloadID: downloadID,
 		Message:    "Download started successfully",
 	})
 }
 
 // Simple get status handler
-func simpleGetStatusHandler(w http.ResponseWriter, r *http.Request) {
+func simpleGetStatusHandler(w http.ResponseWriter, r *http.Request, version apiversion.Version) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
 		return
 	}
 	
-	// Extract ID from URL path
-	path := r.URL.Path
-	if len(path) < 20 || path[:20] != "/downloads/" {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
-		return
-	}
+	// Extract ID from /downloads/{id}/status
+	downloadID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/downloads/"), "/status")
 	
-	downloadID := path[20:]
-	if len(downloadID) > 0 && downloadID[len(downloadID)-7:] == "/status" {
-		downloadID = downloadID[:len(downloadID)-7]
+	query := r.URL.Query()
+	poll, err := events.ParsePoll(query.Get("wait"), query.Get("min_progress"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid long-poll parameters", err.Error())
+		return
 	}
+	// No event bus here, so a long-poll re-reads the download
+	events.PollSnapshot(r.Context(), poll, events.PollInterval, func() (events.Snapshot, bool) {
+		managed, exists := simpleDownloadManager.GetDownload(downloadID)
+		if !exists {
+			return events.Snapshot{}, false
+		}
+		managed.Mutex.RLock()
+		defer managed.Mutex.RUnlock()
+		snapshot := events.Snapshot{Status: managed.Status}
+		if managed.Downloader.Progress != nil {
+			snapshot.BytesDownloaded = managed.Downloader.Progress.GetTotalDownloaded()
+			snapshot.TotalBytes = managed.Downloader.Progress.TotalSize
+		}
+		return snapshot, true
+	})
 	
 	managed, exists := simpleDownloadManager.GetDownload(downloadID)
 	if !exists {
-		http.Error(w, "Download not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "Download not found", "")
 		return
 	}
 	
@@ -219,12 +262,13 @@ func simpleGetStatusHandler(w http.ResponseWriter, r *http.Request) {
 	defer managed.Mutex.RUnlock()
 	
 	status := SimpleDownloadStatus{
-		DownloadID:  downloadID,
-		URL:         managed.Downloader.URL,
-		Filename:    managed.Downloader.Filename,
-		Status:      managed.Status,
-		ThreadsUsed: managed.Downloader.NumThreads,
-		StartTime:   managed.StartTime.Format(time.RFC3339),
+		DownloadID:       downloadID,
+		URL:              managed.Downloader.URL,
+		Filename:         managed.Downloader.Filename,
+		Status:           string(managed.Status),
+		ThreadsUsed:      managed.Downloader.NumThreads,
+		ThreadsRequested: managed.Downloader.RequestedThreads(),
+		StartTime:        managed.StartTime.Format(time.RFC3339),
 	}
 	
 	if managed.Error != nil {
@@ -233,19 +277,27 @@ func simpleGetStatusHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// Get progress information
 	if managed.Downloader.Progress != nil {
+		addChecksum(&status, managed.Downloader.Progress.Checksum)
 		status.PercentCompleted = managed.Downloader.Progress.GetOverallPercent()
 		status.BytesDownloaded = managed.Downloader.Progress.GetTotalDownloaded()
 		status.TotalSize = managed.Downloader.Progress.TotalSize
+		status.PreviewReady = managed.Downloader.Progress.PreviewReady()
+	}
+	if managed.Status == lifecycle.Downloading {
+		status.ActiveConnections = managed.Downloader.ActiveThreads()
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	if version == apiversion.V1 {
+		writeJSON(w, http.StatusOK, status.V1())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
 }
 
 // Simple list downloads handler
-func simpleListDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+func simpleListDownloadsHandler(w http.ResponseWriter, r *http.Request, version apiversion.Version) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
 		return
 	}
 	
@@ -256,12 +308,13 @@ func simpleListDownloadsHandler(w http.ResponseWriter, r *http.Request) {
 		managed.Mutex.RLock()
 		
 		status := SimpleDownloadStatus{
-			DownloadID:  id,
-			URL:         managed.Downloader.URL,
-			Filename:    managed.Downloader.Filename,
-			Status:      managed.Status,
-			ThreadsUsed: managed.Downloader.NumThreads,
-			StartTime:   managed.StartTime.Format(time.RFC3339),
+			DownloadID:       id,
+			URL:              managed.Downloader.URL,
+			Filename:         managed.Downloader.Filename,
+			Status:           string(managed.Status),
+			ThreadsUsed:      managed.Downloader.NumThreads,
+			ThreadsRequested: managed.Downloader.RequestedThreads(),
+			StartTime:        managed.StartTime.Format(time.RFC3339),
 		}
 		
 		if managed.Error != nil {
@@ -269,52 +322,107 @@ func simpleListDownloadsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		
 		if managed.Downloader.Progress != nil {
+			addChecksum(&status, managed.Downloader.Progress.Checksum)
 			status.PercentCompleted = managed.Downloader.Progress.GetOverallPercent()
 			status.BytesDownloaded = managed.Downloader.Progress.GetTotalDownloaded()
 			status.TotalSize = managed.Downloader.Progress.TotalSize
+			status.PreviewReady = managed.Downloader.Progress.PreviewReady()
+		}
+		if managed.Status == lifecycle.Downloading {
+			status.ActiveConnections = managed.Downloader.ActiveThreads()
 		}
 		
 		statuses = append(statuses, status)
 		managed.Mutex.RUnlock()
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"downloads": statuses,
-		"count":     len(statuses),
+	// Without an event bus there is no speed to sort by; list the
+	// downloads transferring now first, then the newest
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].ActiveConnections != statuses[j].ActiveConnections {
+			return statuses[i].ActiveConnections > statuses[j].ActiveConnections
+		}
+		return statuses[i].StartTime > statuses[j].StartTime
 	})
+	
+	list := openapi.DownloadList{
+		Downloads: statuses,
+		Count:     len(statuses),
+	}
+	if version == apiversion.V1 {
+		writeJSON(w, http.StatusOK, list.V1())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
 }
 
 // Simple health handler
-func simpleHealthHandler(w http.ResponseWriter, r *http.Request) {
+func simpleHealthHandler(w http.ResponseWriter, r *http.Request, version apiversion.Version) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
 		return
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "1.0.0-simple",
-	})
+	resp := openapi.HealthResponse{
+		Status:    "healthy",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Version:   "1.0.0-simple",
+		Dependencies: map[string]openapi.DependencyCheck{
+			"filesystem": health.Check(r.Context(), health.Filesystem(".")),
+		},
+	}
+	health.Fill(&resp, ".")
+	status := http.StatusOK
+	if !resp.Dependencies["filesystem"].Healthy {
+		resp.Status = "unhealthy"
+		status = http.StatusServiceUnavailable
+	}
+	
+	if version == apiversion.V1 {
+		writeJSON(w, status, resp.V1())
+		return
+	}
+	writeJSON(w, status, resp)
+}
+
+// addChecksum fills in the result of checking the download against the
+// checksums its server sent
+func addChecksum(status *SimpleDownloadStatus, checksum *downloader.Checksum) {
+	if checksum == nil {
+		return
+	}
+	status.ChecksumStatus = checksum.Status
+	status.ChecksumAlgorithm = checksum.Algorithm
+	status.ChecksumSource = checksum.Source
 }
 
-// Simple router
+// Simple router; routes are served under every version prefix and at the
+// root, where they are deprecated aliases of v1
 func simpleRouter(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
+	mountVersion, path, legacy := apiversion.Split(r.URL.Path)
+	mount := apiversion.Mount{Method: r.Method, Path: r.URL.Path, Version: mountVersion, Legacy: legacy}
+	version, err := mount.Negotiate(w.Header(), r, apiversion.DefaultPolicy)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Unsupported API version", err.Error())
+		return
+	}
+	r.URL.Path = path
 	
 	switch {
 	case path == "/health":
-		simpleHealthHandler(w, r)
+		simpleHealthHandler(w, r, version)
+	case path == "/openapi.json":
+		openapi.WriteSpec(w, openapi.ServerSimple, string(version))
+	case path == "/docs":
+		openapi.SwaggerUIHandler().ServeHTTP(w, r)
 	case path == "/downloads" && r.Method == http.MethodPost:
-		simpleStartDownloadHandler(w, r)
+		simpleStartDownloadHandler(w, r, version)
 	case path == "/downloads" && r.Method == http.MethodGet:
-		simpleListDownloadsHandler(w, r)
-	case len(path) > 20 && path[:20] == "/downloads/" && path[len(path)-7:] == "/status":
-		simpleGetStatusHandler(w, r)
+		simpleListDownloadsHandler(w, r, version)
+	case strings.HasPrefix(path, "/downloads/") && strings.HasSuffix(path, "/status"):
+		simpleGetStatusHandler(w, r, version)
 	default:
-		http.Error(w, "Not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "Not found", "")
 	}
 }
 
@@ -327,15 +435,20 @@ func main() {
 	
 	// Start server
 	port := "8080"
-	fmt.Printf("Server starting on port %s...\n", port)
-	fmt.Printf("API endpoints available at http://localhost:%s\n", port)
-	fmt.Println("\nAvailable endpoints:")
+	ln, err := listener.FromEnv(":" + port)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	fmt.Printf("Server listening on %s...\n", listener.Describe(ln))
+	fmt.Println("\nAvailable endpoints (under /api/v1 and /api/v2; unversioned paths are deprecated v1 aliases):")
 	fmt.Println("  POST   /downloads           - Start a new download")
 	fmt.Println("  GET    /downloads           - List all downloads")
 	fmt.Println("  GET    /downloads/:id/status - Get download status")
 	fmt.Println("  GET    /health              - Health check")
+	fmt.Println("  GET    /openapi.json        - OpenAPI document")
+	fmt.Println("  GET    /docs                - Swagger UI")
 	
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := http.Serve(ln, nil); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"multithreaded-downloader/lifecycle"
 )
 
 func main() {
@@ -37,7 +39,7 @@ func main() {
 	
 	// Test 2: Update progress
 	fmt.Println("\n2. Updating progress...")
-	if err := UpdateProgress(downloadID, 1024, 10240, "downloading"); err != nil {
+	if err := UpdateProgress(downloadID, 1024, 10240, lifecycle.Downloading); err != nil {
 		log.Fatalf("Failed to update progress: %v", err)
 	}
 	fmt.Println("Progress updated successfully")
@@ -60,7 +62,7 @@ func main() {
 	
 	// Test 5: Update status to completed
 	fmt.Println("\n5. Marking as completed...")
-	if err := UpdateStatus(downloadID, "completed", ""); err != nil {
+	if err := UpdateStatus(downloadID, lifecycle.Completed, ""); err != nil {
 		log.Fatalf("Failed to update status: %v", err)
 	}
 	fmt.Println("Status updated to completed")
@@ -0,0 +1,148 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxDepth bounds the nesting of lists and dictionaries a file may use
+const maxDepth = 64
+
+// errTruncated is returned for input that ends inside a value
+var errTruncated = errors.New("bencode: unexpected end of input")
+
+// decoder reads bencoded values: integers as int64, strings as string,
+// lists as []interface{} and dictionaries as map[string]interface{}. It
+// remembers where the top level "info" dictionary starts and ends, whose
+// bytes give the info hash.
+type decoder struct {
+	data      []byte
+	pos       int
+	depth     int
+	infoStart int
+	infoEnd   int
+}
+
+// decode reads the value at the current position
+func (d *decoder) decode() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, errTruncated
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c >= '0' && c <= '9':
+		return d.str()
+	case c == 'l':
+		return d.list()
+	case c == 'd':
+		return d.dict()
+	default:
+		return nil, fmt.Errorf("bencode: unexpected %q at offset %d", c, d.pos)
+	}
+}
+
+func (d *decoder) integer() (int64, error) {
+	end := d.index('e', d.pos+1)
+	if end < 0 {
+		return 0, errTruncated
+	}
+	raw := string(d.data[d.pos+1 : end])
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || (len(raw) > 1 && (raw[0] == '0' || raw[:2] == "-0")) {
+		return 0, fmt.Errorf("bencode: invalid integer %q at offset %d", raw, d.pos)
+	}
+	d.pos = end + 1
+	return n, nil
+}
+
+func (d *decoder) str() (string, error) {
+	colon := d.index(':', d.pos)
+	if colon < 0 {
+		return "", errTruncated
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos:colon]))
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("bencode: invalid string length at offset %d", d.pos)
+	}
+	if n > len(d.data)-colon-1 {
+		return "", errTruncated
+	}
+	d.pos = colon + 1 + n
+	return string(d.data[colon+1 : d.pos]), nil
+}
+
+func (d *decoder) list() ([]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	list := []interface{}{}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, errTruncated
+		}
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			return list, nil
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+func (d *decoder) dict() (map[string]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	dict := map[string]interface{}{}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, errTruncated
+		}
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			return dict, nil
+		}
+		key, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		start := d.pos
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if key == "info" && d.depth == 1 {
+			d.infoStart, d.infoEnd = start, d.pos
+		}
+		dict[key] = v
+	}
+}
+
+func (d *decoder) enter() error {
+	d.pos++
+	if d.depth++; d.depth > maxDepth {
+		return errors.New("bencode: nested too deeply")
+	}
+	return nil
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
+
+// index returns the offset of the first c at or after from, or -1
+func (d *decoder) index(c byte, from int) int {
+	for i := from; i < len(d.data); i++ {
+		if d.data[i] == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bstr bencodes a string
+func bstr(s string) string {
+	return fmt.Sprintf("%d:%s", len(s), s)
+}
+
+func TestParseSingleFile(t *testing.T) {
+	pieces := strings.Repeat("a", sha1.Size) + strings.Repeat("b", sha1.Size)
+	info := "d" + bstr("length") + "i40000e" + bstr("name") + bstr("distro.iso") +
+		bstr("piece length") + "i32768e" + bstr("pieces") + bstr(pieces) + "e"
+	data := "d" + bstr("announce") + bstr("http://tracker.example.com/announce") +
+		bstr("info") + info +
+		bstr("url-list") + "l" + bstr("https://eu.example.com/pub/") + bstr("https://us.example.com/distro.iso") + bstr("ftp://old.example.com/") + "e" +
+		"e"
+
+	m, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.SingleFile() || m.Name != "distro.iso" || m.Length != 40000 || m.PieceLength != 32768 || len(m.Pieces) != 2 {
+		t.Errorf("Parse() = %+v", m)
+	}
+	if m.InfoHash != sha1.Sum([]byte(info)) {
+		t.Error("info hash is not the SHA-1 of the info dictionary")
+	}
+	want := []string{"https://eu.example.com/pub/distro.iso", "https://us.example.com/distro.iso"}
+	if got := m.WebSeedURLs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WebSeedURLs() = %v, want %v", got, want)
+	}
+}
+
+func TestParseMultiFile(t *testing.T) {
+	file := func(length int, path ...string) string {
+		s := "d" + bstr("length") + fmt.Sprintf("i%de", length) + bstr("path") + "l"
+		for _, p := range path {
+			s += bstr(p)
+		}
+		return s + "ee"
+	}
+	info := "d" + bstr("files") + "l" + file(10, "a.txt") + file(20, "sub", "b.txt") + "e" +
+		bstr("name") + bstr("set") + bstr("piece length") + "i16e" + bstr("pieces") + bstr(strings.Repeat("x", 2*sha1.Size)) + "e"
+	m, err := Parse([]byte("d" + bstr("info") + info + bstr("url-list") + bstr("https://example.com/") + "e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SingleFile() || m.Length != 30 || !reflect.DeepEqual(m.Files[1].Path, []string{"sub", "b.txt"}) {
+		t.Errorf("Parse() = %+v", m)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	piece := bstr(strings.Repeat("a", sha1.Size))
+	for _, data := range []string{
+		"",
+		"i42e",
+		"d" + bstr("info") + "d" + bstr("name") + bstr("f") + "e" + "e",
+		// One piece cannot hold 40000 bytes in pieces of 16
+		"d" + bstr("info") + "d" + bstr("length") + "i40000e" + bstr("name") + bstr("f") + bstr("piece length") + "i16e" + bstr("pieces") + piece + "ee",
+		// Names must not climb out of the output directory
+		"d" + bstr("info") + "d" + bstr("length") + "i1e" + bstr("name") + bstr("..") + bstr("piece length") + "i16e" + bstr("pieces") + piece + "ee",
+		"d" + bstr("info") + "d" + bstr("length") + "i1e",
+		"d" + bstr("info") + "d" + bstr("length") + "i01e" + "ee",
+		"d" + bstr("info") + "d" + bstr("length") + "i1e" + bstr("name") + bstr("f") + bstr("piece length") + "i16e" + bstr("pieces") + piece + "ee" + "trailing",
+		strings.Repeat("l", maxDepth+1) + strings.Repeat("e", maxDepth+1),
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) accepted", data)
+		}
+	}
+}
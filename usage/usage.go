@@ -0,0 +1,381 @@
+// Package usage accounts the bytes downloads transfer, by day, to the user
+// who started them, their tags and the domain they come from, so teams on
+// metered egress can attribute bandwidth costs. A Meter follows the
+// progress events of the bus and adds up what every download fetched since
+// the last event; Rollup sums the stored days into daily or monthly rows.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-downloader/events"
+	"multithreaded-downloader/openapi"
+)
+
+// Dimensions bytes are accounted by
+const (
+	User   = "user"
+	Tag    = "tag"
+	Domain = "domain"
+)
+
+// Dimensions lists the dimensions
+var Dimensions = []string{User, Tag, Domain}
+
+// Periods of a rollup
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+const (
+	// DefaultFlushInterval is how often a Meter writes what it counted
+	DefaultFlushInterval = time.Minute
+	// MaxTags is how many tags a download may carry
+	MaxTags = 10
+	// maxTagLength bounds a tag
+	maxTagLength = 64
+)
+
+// MeteredEvents are the events a Meter needs
+var MeteredEvents = []events.Type{events.Started, events.Progress, events.Paused, events.Completed, events.Failed, events.Requeued, events.Deleted}
+
+// ValidateTags checks the tags of a download: at most MaxTags of letters,
+// digits and . _ : - each, starting with a letter or digit
+func ValidateTags(tags []string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("at most %d tags are allowed, got %d", MaxTags, len(tags))
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength {
+			return fmt.Errorf("tag %q must be 1 to %d characters", tag, maxTagLength)
+		}
+		for i, r := range tag {
+			letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+			if !letter && (i == 0 || !strings.ContainsRune("._:-", r)) {
+				return fmt.Errorf("tag %q may only hold letters, digits and . _ : -, starting with a letter or digit", tag)
+			}
+		}
+	}
+	return nil
+}
+
+// DomainOf returns the host a download's bytes are charged to
+func DomainOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Attribution is who and what a download's bytes are charged to
+type Attribution struct {
+	User   string
+	Domain string
+	Tags   []string
+	// Bytes is how much of the download was there before metering began,
+	// e.g. from an earlier run it resumes
+	Bytes int64
+}
+
+// Record is the bytes charged to one key of a dimension on one day
+type Record struct {
+	// Day is midnight UTC
+	Day       time.Time
+	Dimension string
+	Key       string
+	Bytes     int64
+}
+
+// Store keeps the records a Meter counts
+type Store interface {
+	// AddUsage adds the bytes of records to those stored for the same day,
+	// dimension and key
+	AddUsage(records []Record) error
+}
+
+// Lookup returns the attribution of a download
+type Lookup func(downloadID string) (Attribution, error)
+
+// tracked is a download a Meter follows
+type tracked struct {
+	attribution Attribution
+	// last is the download's size at the last event counted
+	last int64
+}
+
+// recordKey identifies a pending record
+type recordKey struct {
+	day       time.Time
+	dimension string
+	key       string
+}
+
+// Meter counts the bytes downloads transfer from their events. It is safe
+// for concurrent use.
+type Meter struct {
+	lookup Lookup
+	store  Store
+	// OnError, when set, is told about lookups and flushes that failed
+	OnError func(error)
+
+	mu        sync.Mutex
+	downloads map[string]*tracked
+	pending   map[recordKey]int64
+}
+
+// NewMeter creates a meter attributing downloads with lookup and writing to
+// store; subscribe its Handle to MeteredEvents, local ones only, so every
+// byte is counted by the node that fetched it
+func NewMeter(lookup Lookup, store Store) *Meter {
+	return &Meter{lookup: lookup, store: store, downloads: map[string]*tracked{}, pending: map[recordKey]int64{}}
+}
+
+// Handle counts what the download of e fetched since its last event. Files
+// placed from a cache or a peer were not fetched and are not counted.
+func (m *Meter) Handle(e events.Event) {
+	m.mu.Lock()
+	t, ok := m.downloads[e.DownloadID]
+	m.mu.Unlock()
+	if !ok {
+		if e.Type == events.Deleted || e.Cached {
+			return
+		}
+		attribution, err := m.lookup(e.DownloadID)
+		if err != nil {
+			m.report(fmt.Errorf("failed to attribute download %s: %w", e.DownloadID, err))
+			return
+		}
+		t = &tracked{attribution: attribution, last: attribution.Bytes}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloads[e.DownloadID] = t
+	switch e.Type {
+	case events.Progress, events.Paused, events.Completed, events.Failed, events.Requeued:
+		if e.Cached {
+			break
+		}
+		// Progress falls when parts are fetched again; they count as they
+		// arrive once more
+		if e.BytesDownloaded > t.last {
+			m.add(t.attribution, day(e.Time), e.BytesDownloaded-t.last)
+		}
+		if e.BytesDownloaded > 0 || e.Type == events.Progress {
+			t.last = e.BytesDownloaded
+		}
+	}
+	switch e.Type {
+	case events.Paused, events.Completed, events.Failed, events.Requeued, events.Deleted:
+		delete(m.downloads, e.DownloadID)
+	}
+}
+
+// add charges bytes on day to every dimension of a
+func (m *Meter) add(a Attribution, day time.Time, bytes int64) {
+	m.pending[recordKey{day, User, a.User}] += bytes
+	m.pending[recordKey{day, Domain, a.Domain}] += bytes
+	for _, tag := range a.Tags {
+		m.pending[recordKey{day, Tag, tag}] += bytes
+	}
+}
+
+// Flush writes what was counted since the last flush. Records that cannot
+// be written are kept for the next one.
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[recordKey]int64{}
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	records := make([]Record, 0, len(pending))
+	for k, bytes := range pending {
+		records = append(records, Record{Day: k.day, Dimension: k.dimension, Key: k.key, Bytes: bytes})
+	}
+	if err := m.store.AddUsage(records); err != nil {
+		m.mu.Lock()
+		for k, bytes := range pending {
+			m.pending[k] += bytes
+		}
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes every interval until ctx is done, and once more then
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.Flush(); err != nil {
+				m.report(err)
+			}
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				m.report(err)
+			}
+		}
+	}
+}
+
+func (m *Meter) report(err error) {
+	if m.OnError != nil {
+		m.OnError(err)
+	}
+}
+
+// day returns midnight UTC of the day t falls on
+func day(t time.Time) time.Time {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	y, mo, d := t.UTC().Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Row is the bytes charged to a key over one period of a rollup
+type Row struct {
+	// Period is the day as 2006-01-02 or the month as 2006-01
+	Period string
+	Key    string
+	Bytes  int64
+}
+
+// Rollup sums records into rows by period, Daily or Monthly, and key,
+// ordered by period and then by bytes, most first
+func Rollup(records []Record, period string) []Row {
+	layout := "2006-01-02"
+	if period == Monthly {
+		layout = "2006-01"
+	}
+	type rowKey struct{ period, key string }
+	sums := map[rowKey]int64{}
+	for _, r := range records {
+		sums[rowKey{r.Day.UTC().Format(layout), r.Key}] += r.Bytes
+	}
+	rows := make([]Row, 0, len(sums))
+	for k, bytes := range sums {
+		rows = append(rows, Row{Period: k.period, Key: k.key, Bytes: bytes})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Period != rows[j].Period {
+			return rows[i].Period < rows[j].Period
+		}
+		if rows[i].Bytes != rows[j].Bytes {
+			return rows[i].Bytes > rows[j].Bytes
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
+
+// Cost estimates what bytes cost at costPerGB per 10^9 bytes
+func Cost(bytes int64, costPerGB float64) float64 {
+	return float64(bytes) / 1e9 * costPerGB
+}
+
+// CostFromEnv reads USAGE_COST_PER_GB, the cost of 10^9 bytes reports
+// estimate with; it is 0, no estimate, when unset
+func CostFromEnv() (float64, error) {
+	raw := os.Getenv("USAGE_COST_PER_GB")
+	if raw == "" {
+		return 0, nil
+	}
+	cost, err := strconv.ParseFloat(raw, 64)
+	if err != nil || cost < 0 {
+		return 0, fmt.Errorf("USAGE_COST_PER_GB must be a number of at least 0, got %q", raw)
+	}
+	return cost, nil
+}
+
+// Query is what a report covers
+type Query struct {
+	By     string
+	Period string
+	// Since is the first day and Until the day after the last
+	Since, Until time.Time
+}
+
+// dateLayout is how days are given and reported
+const dateLayout = "2006-01-02"
+
+// ParseQuery reads the parameters of GET /usage; by defaults to User,
+// period to Daily, until to the day of now and since to 30 days or eleven
+// months before it
+func ParseQuery(by, period, since, until string, now time.Time) (Query, error) {
+	q := Query{By: by, Period: period}
+	if q.By == "" {
+		q.By = User
+	}
+	if q.By != User && q.By != Tag && q.By != Domain {
+		return q, fmt.Errorf("by must be one of %s", strings.Join(Dimensions, ", "))
+	}
+	if q.Period == "" {
+		q.Period = Daily
+	}
+	if q.Period != Daily && q.Period != Monthly {
+		return q, fmt.Errorf("period must be %s or %s", Daily, Monthly)
+	}
+
+	last := day(now)
+	if until != "" {
+		t, err := time.Parse(dateLayout, until)
+		if err != nil {
+			return q, fmt.Errorf("until must be a date like 2006-01-02, got %q", until)
+		}
+		last = t
+	}
+	q.Until = last.AddDate(0, 0, 1)
+	switch {
+	case since != "":
+		t, err := time.Parse(dateLayout, since)
+		if err != nil {
+			return q, fmt.Errorf("since must be a date like 2006-01-02, got %q", since)
+		}
+		q.Since = t
+	case q.Period == Monthly:
+		q.Since = time.Date(last.Year(), last.Month()-11, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		q.Since = last.AddDate(0, 0, -30)
+	}
+	if !q.Since.Before(q.Until) {
+		return q, fmt.Errorf("since must not be after until")
+	}
+	return q, nil
+}
+
+// Report rolls up the records q selected, estimating their cost at
+// costPerGB when it is above 0
+func Report(q Query, records []Record, costPerGB float64) openapi.UsageReport {
+	report := openapi.UsageReport{
+		By:        q.By,
+		Period:    q.Period,
+		Since:     q.Since.Format(dateLayout),
+		Until:     q.Until.AddDate(0, 0, -1).Format(dateLayout),
+		Rows:      []openapi.UsageRow{},
+		CostPerGb: costPerGB,
+	}
+	for _, row := range Rollup(records, q.Period) {
+		report.Rows = append(report.Rows, openapi.UsageRow{Period: row.Period, Key: row.Key, Bytes: row.Bytes, EstimatedCost: Cost(row.Bytes, costPerGB)})
+		report.TotalBytes += row.Bytes
+	}
+	report.EstimatedCost = Cost(report.TotalBytes, costPerGB)
+	return report
+}
@@ -0,0 +1,175 @@
+package usage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"multithreaded-downloader/events"
+)
+
+type memoryStore struct {
+	records []Record
+	err     error
+}
+
+func (s *memoryStore) AddUsage(records []Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+// sums adds up the stored bytes of every dimension and key
+func (s *memoryStore) sums() map[string]int64 {
+	sums := map[string]int64{}
+	for _, r := range s.records {
+		sums[r.Dimension+"/"+r.Key] += r.Bytes
+	}
+	return sums
+}
+
+func TestMeterCountsTransferredBytes(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	attributions := map[string]Attribution{
+		"a": {User: "10.0.0.1", Domain: "example.com", Tags: []string{"team-a", "nightly"}},
+		// b resumes a download that had 100 bytes before
+		"b": {User: "10.0.0.2", Domain: "cdn.example.org", Bytes: 100},
+		"c": {User: "10.0.0.1", Domain: "example.com"},
+	}
+	store := &memoryStore{}
+	m := NewMeter(func(id string) (Attribution, error) {
+		return attributions[id], nil
+	}, store)
+
+	for _, e := range []events.Event{
+		{Type: events.Started, DownloadID: "a", Time: now},
+		{Type: events.Progress, DownloadID: "a", BytesDownloaded: 300, Time: now},
+		// A part fetched again sets progress back; it counts once more
+		{Type: events.Progress, DownloadID: "a", BytesDownloaded: 200, Time: now},
+		{Type: events.Completed, DownloadID: "a", BytesDownloaded: 500, Time: now},
+		{Type: events.Started, DownloadID: "b", Time: now},
+		{Type: events.Progress, DownloadID: "b", BytesDownloaded: 150, Time: now},
+		{Type: events.Failed, DownloadID: "b", Time: now},
+		// c was placed from the cache
+		{Type: events.Started, DownloadID: "c", Time: now},
+		{Type: events.Completed, DownloadID: "c", BytesDownloaded: 1000, Cached: true, Time: now},
+	} {
+		m.Handle(e)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{
+		"user/10.0.0.1":          600,
+		"user/10.0.0.2":          50,
+		"domain/example.com":     600,
+		"domain/cdn.example.org": 50,
+		"tag/team-a":             600,
+		"tag/nightly":            600,
+	}
+	if got := store.sums(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	for _, r := range store.records {
+		if !r.Day.Equal(time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("record %+v is not on the day of the events", r)
+		}
+	}
+}
+
+func TestMeterKeepsRecordsUntilStored(t *testing.T) {
+	store := &memoryStore{err: errors.New("database unavailable")}
+	m := NewMeter(func(id string) (Attribution, error) {
+		return Attribution{User: "u", Domain: "d"}, nil
+	}, store)
+	m.Handle(events.Event{Type: events.Progress, DownloadID: "a", BytesDownloaded: 10})
+	if err := m.Flush(); err == nil {
+		t.Fatal("Flush() succeeded with an unavailable store")
+	}
+	m.Handle(events.Event{Type: events.Progress, DownloadID: "a", BytesDownloaded: 25})
+
+	store.err = nil
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.sums()["user/u"]; got != 25 {
+		t.Errorf("stored %d bytes, want 25", got)
+	}
+}
+
+func TestRollupAndReport(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	records := []Record{
+		{Day: day(30), Dimension: Tag, Key: "a", Bytes: 1e9},
+		{Day: day(31), Dimension: Tag, Key: "b", Bytes: 3e9},
+		{Day: day(31), Dimension: Tag, Key: "a", Bytes: 1e9},
+		{Day: day(31).AddDate(0, 0, 1), Dimension: Tag, Key: "a", Bytes: 5e8},
+	}
+
+	q, err := ParseQuery(Tag, Monthly, "2026-01-01", "2026-02-28", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Report(q, records, 0.09)
+	if report.Since != "2026-01-01" || report.Until != "2026-02-28" || report.TotalBytes != 55e8 {
+		t.Errorf("Report() = %+v", report)
+	}
+	var periods []string
+	for _, row := range report.Rows {
+		periods = append(periods, row.Period+"/"+row.Key)
+	}
+	if want := []string{"2026-01/b", "2026-01/a", "2026-02/a"}; !reflect.DeepEqual(periods, want) {
+		t.Errorf("rows %v, want %v", periods, want)
+	}
+	if cost := report.Rows[0].EstimatedCost; cost < 0.2699 || cost > 0.2701 {
+		t.Errorf("estimated cost of 3 GB is %v, want 0.27", cost)
+	}
+
+	if rows := Rollup(records, Daily); len(rows) != 4 || rows[1].Period != "2026-01-31" || rows[1].Key != "b" {
+		t.Errorf("Rollup(daily) = %+v", rows)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2026, 3, 14, 18, 0, 0, 0, time.UTC)
+	q, err := ParseQuery("", "", "", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.By != User || q.Period != Daily || !q.Since.Equal(time.Date(2026, 2, 12, 0, 0, 0, 0, time.UTC)) || !q.Until.Equal(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ParseQuery() = %+v", q)
+	}
+	if q, _ := ParseQuery(Domain, Monthly, "", "", now); !q.Since.Equal(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("monthly since = %v, want 2025-04-01", q.Since)
+	}
+	for _, args := range [][4]string{
+		{"team", "", "", ""},
+		{"", "weekly", "", ""},
+		{"", "", "14/03/2026", ""},
+		{"", "", "2026-03-15", "2026-03-14"},
+	} {
+		if _, err := ParseQuery(args[0], args[1], args[2], args[3], now); err == nil {
+			t.Errorf("ParseQuery(%q) accepted", args)
+		}
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	if err := ValidateTags([]string{"team-a", "project:x.1", "Q3_2026"}); err != nil {
+		t.Error(err)
+	}
+	for _, tags := range [][]string{
+		{""},
+		{"-leading"},
+		{"with space"},
+		{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"},
+	} {
+		if err := ValidateTags(tags); err == nil {
+			t.Errorf("ValidateTags(%q) accepted", tags)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package zsync
+
+import (
+	"crypto/sha1"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/md4"
+)
+
+// rsum is the weak rolling checksum of a block: a is the sum of its bytes
+// and b the sum weighted by the distance from the end, both modulo 2^16
+func rsum(block []byte) (a, b uint16) {
+	n := len(block)
+	for i, c := range block {
+		a += uint16(c)
+		b += uint16(n-i) * uint16(c)
+	}
+	return a, b
+}
+
+// roll moves the checksum of a window of size bytes one byte on, dropping
+// out and taking in in
+func roll(a, b uint16, out, in byte, size int) (uint16, uint16) {
+	a = a - uint16(out) + uint16(in)
+	b = b - uint16(size)*uint16(out) + a
+	return a, b
+}
+
+// strongSum is the MD4 checksum zsync identifies blocks by
+func strongSum(block []byte) []byte {
+	h := md4.New()
+	h.Write(block)
+	return h.Sum(nil)
+}
+
+// newSHA1 hashes the whole file, which the control file names by SHA-1
+func newSHA1() hash.Hash {
+	return sha1.New()
+}
+
+// seedReader reads a local file byte by byte through a buffer, with the
+// zeros the last block is padded with past its end
+type seedReader struct {
+	r     io.ReaderAt
+	size  int64
+	buf   []byte
+	start int64
+	n     int
+}
+
+func newSeedReader(r io.ReaderAt, size int64, blockSize int) *seedReader {
+	return &seedReader{r: r, size: size, buf: make([]byte, 1<<20+2*blockSize)}
+}
+
+// byteAt returns the byte at off. Reads go forward, so the buffer is
+// refilled a block before off to keep the byte leaving the window.
+func (s *seedReader) byteAt(off int64, blockSize int) (byte, error) {
+	if off >= s.size {
+		return 0, nil
+	}
+	if off < s.start || off >= s.start+int64(s.n) {
+		start := off - int64(blockSize)
+		if start < 0 {
+			start = 0
+		}
+		n, err := s.r.ReadAt(s.buf, start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		s.start, s.n = start, n
+	}
+	return s.buf[off-s.start], nil
+}
+
+// block reads blockSize bytes at off, padded with zeros past the end
+func (s *seedReader) block(off int64, blockSize int) ([]byte, error) {
+	block := make([]byte, blockSize)
+	if off >= s.size {
+		return block, nil
+	}
+	if _, err := s.r.ReadAt(block, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return block, nil
+}
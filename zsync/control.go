@@ -0,0 +1,247 @@
+// Package zsync updates a file from a previous version of it, fetching only
+// the blocks that changed. The server publishes a .zsync control file next
+// to the file with a weak rolling checksum and a strong checksum of every
+// block, as made by zsyncmake; blocks found anywhere in the local copy are
+// reused and the rest are fetched with range requests.
+package zsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+)
+
+// ErrInvalidControl is returned for a control file this package cannot read
+var ErrInvalidControl = errors.New("invalid zsync control file")
+
+// Version is the control file format written by Encode
+const Version = "0.6.2"
+
+// DefaultBlockSize is the block size of control files made by Make
+const DefaultBlockSize = 2048
+
+// Control is a parsed .zsync control file
+type Control struct {
+	Filename  string
+	MTime     string
+	BlockSize int
+	Length    int64
+	// SeqMatches is how many consecutive blocks must match before one is
+	// reused, which lets the control file store shorter checksums
+	SeqMatches int
+	// RsumBytes and ChecksumBytes are how much of each block's rolling
+	// and MD4 checksum the file stores
+	RsumBytes     int
+	ChecksumBytes int
+	// URL is where the file is, relative to the control file
+	URL string
+	// SHA1 is the hex SHA-1 of the whole file
+	SHA1   string
+	Blocks []Block
+}
+
+// Block holds the stored checksums of one block of the file
+type Block struct {
+	Rsum     uint32
+	Checksum []byte
+}
+
+// NumBlocks returns how many blocks a file of length bytes has
+func (c *Control) NumBlocks() int {
+	return int((c.Length + int64(c.BlockSize) - 1) / int64(c.BlockSize))
+}
+
+// blockLength returns how many bytes of the file block i holds; the last
+// block may be short
+func (c *Control) blockLength(i int) int64 {
+	start := int64(i) * int64(c.BlockSize)
+	if end := start + int64(c.BlockSize); end > c.Length {
+		return c.Length - start
+	}
+	return int64(c.BlockSize)
+}
+
+// rsumMask keeps the bytes of a rolling checksum the control file stores
+func (c *Control) rsumMask() uint32 {
+	if c.RsumBytes >= 4 {
+		return 0xffffffff
+	}
+	return 1<<(8*uint(c.RsumBytes)) - 1
+}
+
+// Parse reads a control file: header lines up to a blank line, then the
+// checksums of every block
+func Parse(r io.Reader) (*Control, error) {
+	br := bufio.NewReader(r)
+	c := &Control{SeqMatches: 1, RsumBytes: 4, ChecksumBytes: 16}
+	seen := map[string]bool{}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: header ends early", ErrInvalidControl)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		colon := strings.Index(line, ": ")
+		if colon < 0 {
+			return nil, fmt.Errorf("%w: bad header line %q", ErrInvalidControl, line)
+		}
+		key, value := line[:colon], line[colon+2:]
+		seen[key] = true
+		if err := c.setHeader(key, value); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidControl, key, err)
+		}
+	}
+	for _, key := range []string{"Blocksize", "Length"} {
+		if !seen[key] {
+			return nil, fmt.Errorf("%w: no %s header", ErrInvalidControl, key)
+		}
+	}
+
+	c.Blocks = make([]Block, c.NumBlocks())
+	entry := make([]byte, c.RsumBytes+c.ChecksumBytes)
+	for i := range c.Blocks {
+		if _, err := io.ReadFull(br, entry); err != nil {
+			return nil, fmt.Errorf("%w: checksums of %d blocks, found %d", ErrInvalidControl, len(c.Blocks), i)
+		}
+		var rsum [4]byte
+		copy(rsum[4-c.RsumBytes:], entry[:c.RsumBytes])
+		c.Blocks[i] = Block{
+			Rsum:     binary.BigEndian.Uint32(rsum[:]),
+			Checksum: append([]byte(nil), entry[c.RsumBytes:]...),
+		}
+	}
+	return c, nil
+}
+
+// setHeader applies one header line of a control file
+func (c *Control) setHeader(key, value string) error {
+	switch key {
+	case "zsync":
+		if !strings.HasPrefix(value, "0.") {
+			return fmt.Errorf("unsupported version %s", value)
+		}
+	case "Filename":
+		c.Filename = value
+	case "MTime":
+		c.MTime = value
+	case "Blocksize":
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 16 || size&(size-1) != 0 {
+			return fmt.Errorf("block size must be a power of two of at least 16, got %q", value)
+		}
+		c.BlockSize = size
+	case "Length":
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid length %q", value)
+		}
+		c.Length = length
+	case "Hash-Lengths":
+		fields := strings.Split(value, ",")
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid hash lengths %q", value)
+		}
+		var n [3]int
+		for i, field := range fields {
+			var err error
+			if n[i], err = strconv.Atoi(field); err != nil {
+				return fmt.Errorf("invalid hash lengths %q", value)
+			}
+		}
+		if n[0] < 1 || n[0] > 2 || n[1] < 1 || n[1] > 4 || n[2] < 3 || n[2] > md4.Size {
+			return fmt.Errorf("unsupported hash lengths %q", value)
+		}
+		c.SeqMatches, c.RsumBytes, c.ChecksumBytes = n[0], n[1], n[2]
+	case "URL":
+		c.URL = value
+	case "SHA-1":
+		if sum, err := hex.DecodeString(value); err != nil || len(sum) != 20 {
+			return fmt.Errorf("invalid SHA-1 %q", value)
+		}
+		c.SHA1 = strings.ToLower(value)
+	case "Z-URL", "Z-Map2", "Recompress":
+		return errors.New("compressed targets are not supported")
+	}
+	// Other headers, such as Z-Filename, do not affect the blocks
+	return nil
+}
+
+// Make computes the control file of the file in r with blocks of blockSize
+// bytes, storing whole checksums
+func Make(r io.Reader, filename string, blockSize int) (*Control, error) {
+	if blockSize < 16 || blockSize&(blockSize-1) != 0 {
+		return nil, fmt.Errorf("block size must be a power of two of at least 16, got %d", blockSize)
+	}
+	c := &Control{
+		Filename:      filename,
+		BlockSize:     blockSize,
+		SeqMatches:    1,
+		RsumBytes:     4,
+		ChecksumBytes: md4.Size,
+		URL:           filename,
+	}
+	whole := newSHA1()
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			whole.Write(block[:n])
+			c.Length += int64(n)
+			// The last block is checksummed padded with zeros
+			for i := n; i < blockSize; i++ {
+				block[i] = 0
+			}
+			a, b := rsum(block)
+			c.Blocks = append(c.Blocks, Block{Rsum: uint32(a)<<16 | uint32(b), Checksum: strongSum(block)})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.SHA1 = hex.EncodeToString(whole.Sum(nil))
+	return c, nil
+}
+
+// Encode writes c in the control file format
+func Encode(w io.Writer, c *Control) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "zsync: %s\n", Version)
+	if c.Filename != "" {
+		fmt.Fprintf(&buf, "Filename: %s\n", c.Filename)
+	}
+	if c.MTime != "" {
+		fmt.Fprintf(&buf, "MTime: %s\n", c.MTime)
+	}
+	fmt.Fprintf(&buf, "Blocksize: %d\n", c.BlockSize)
+	fmt.Fprintf(&buf, "Length: %d\n", c.Length)
+	fmt.Fprintf(&buf, "Hash-Lengths: %d,%d,%d\n", c.SeqMatches, c.RsumBytes, c.ChecksumBytes)
+	if c.URL != "" {
+		fmt.Fprintf(&buf, "URL: %s\n", c.URL)
+	}
+	if c.SHA1 != "" {
+		fmt.Fprintf(&buf, "SHA-1: %s\n", c.SHA1)
+	}
+	buf.WriteString("\n")
+	for _, block := range c.Blocks {
+		var rsum [4]byte
+		binary.BigEndian.PutUint32(rsum[:], block.Rsum)
+		buf.Write(rsum[4-c.RsumBytes:])
+		buf.Write(block.Checksum[:c.ChecksumBytes])
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,94 @@
+package zsync
+
+import (
+	"bytes"
+	"io"
+)
+
+// Match finds the blocks of the file c describes in seed, a local file of
+// size bytes such as an older version, and returns the offset in seed of
+// every block found. Each window of seed is tried against the rolling
+// checksums, and only windows that pass are checked with MD4.
+func (c *Control) Match(seed io.ReaderAt, size int64) (map[int]int64, error) {
+	found := make(map[int]int64)
+	if size == 0 || len(c.Blocks) == 0 {
+		return found, nil
+	}
+
+	mask := c.rsumMask()
+	index := make(map[uint32][]int, len(c.Blocks))
+	for i, block := range c.Blocks {
+		index[block.Rsum&mask] = append(index[block.Rsum&mask], i)
+	}
+
+	bs := c.BlockSize
+	reader := newSeedReader(seed, size, bs)
+	window, err := reader.block(0, bs)
+	if err != nil {
+		return nil, err
+	}
+	a, b := rsum(window)
+	for pos := int64(0); pos < size; {
+		if candidates := index[(uint32(a)<<16|uint32(b))&mask]; len(candidates) > 0 {
+			matched, err := c.matchWindow(reader, pos, candidates, found)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				// A block seldom overlaps another that matches, so skip it
+				pos += int64(bs)
+				if window, err = reader.block(pos, bs); err != nil {
+					return nil, err
+				}
+				a, b = rsum(window)
+				continue
+			}
+		}
+
+		out, err := reader.byteAt(pos, bs)
+		if err != nil {
+			return nil, err
+		}
+		in, err := reader.byteAt(pos+int64(bs), bs)
+		if err != nil {
+			return nil, err
+		}
+		a, b = roll(a, b, out, in, bs)
+		pos++
+	}
+	return found, nil
+}
+
+// matchWindow checks the window of seed at pos against the blocks whose
+// rolling checksum it has, recording those it holds in found
+func (c *Control) matchWindow(reader *seedReader, pos int64, candidates []int, found map[int]int64) (bool, error) {
+	window, err := reader.block(pos, c.BlockSize)
+	if err != nil {
+		return false, err
+	}
+	sum := strongSum(window)[:c.ChecksumBytes]
+
+	var next []byte
+	matched := false
+	for _, i := range candidates {
+		if _, ok := found[i]; ok || !bytes.Equal(sum, c.Blocks[i].Checksum[:c.ChecksumBytes]) {
+			continue
+		}
+		// Short checksums are only trusted for a run of blocks
+		if c.SeqMatches > 1 && i+1 < len(c.Blocks) {
+			if next == nil {
+				block, err := reader.block(pos+int64(c.BlockSize), c.BlockSize)
+				if err != nil {
+					return false, err
+				}
+				next = strongSum(block)[:c.ChecksumBytes]
+			}
+			if !bytes.Equal(next, c.Blocks[i+1].Checksum[:c.ChecksumBytes]) {
+				continue
+			}
+		}
+		found[i] = pos
+		matched = true
+	}
+	return matched, nil
+}
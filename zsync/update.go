@@ -0,0 +1,308 @@
+package zsync
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"multithreaded-downloader/downloader"
+	"multithreaded-downloader/secrets"
+)
+
+// ErrNoRanges is returned when the server ignores range requests, so
+// changed blocks cannot be fetched on their own
+var ErrNoRanges = errors.New("server does not support range requests")
+
+// ErrChecksumMismatch is returned when a fetched block or the updated file
+// does not match the control file
+var ErrChecksumMismatch = fmt.Errorf("zsync: %w", downloader.ErrChecksumMismatch)
+
+// maxControlSize limits the control file; it holds about 20 bytes per block
+const maxControlSize = 256 << 20
+
+// maxRangeSize is the most a single range request asks for
+const maxRangeSize = 4 << 20
+
+// Options configure how an update talks to the server
+type Options struct {
+	// Client sends the requests; http.DefaultClient when nil
+	Client *http.Client
+	// Prepare adjusts every request, e.g. to set headers
+	Prepare func(*http.Request)
+	// Threads is how many ranges are fetched at once
+	Threads int
+}
+
+func (o Options) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// Result tells how much of the updated file came from the local copy
+type Result struct {
+	Length     int64
+	Reused     int64
+	Downloaded int64
+	Blocks     int
+	// BlocksReused is how many of the Blocks were found locally
+	BlocksReused int
+}
+
+// FetchControl downloads and parses the control file at controlURL
+func FetchControl(ctx context.Context, controlURL string, opts Options) (*Control, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, controlURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Prepare != nil {
+		opts.Prepare(req)
+	}
+	resp, err := opts.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("control file %s: %w: %s", secrets.RedactURL(controlURL), downloader.ErrServerStatus, resp.Status)
+	}
+	return Parse(io.LimitReader(resp.Body, maxControlSize))
+}
+
+// FileURL returns where the file is: the control file's URL resolved
+// against controlURL
+func (c *Control) FileURL(controlURL string) (string, error) {
+	if c.URL == "" {
+		return "", fmt.Errorf("%w: no URL header", ErrInvalidControl)
+	}
+	base, err := url.Parse(controlURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad URL %q", ErrInvalidControl, c.URL)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Update writes the file c describes to output, copying the blocks found in
+// seed and fetching the rest from fileURL. seed may be output itself, which
+// is replaced only once the new file matches the control file; a seed that
+// does not exist is an empty one.
+func Update(ctx context.Context, c *Control, fileURL, seed, output string, opts Options) (Result, error) {
+	result := Result{Length: c.Length, Blocks: len(c.Blocks)}
+
+	var found map[int]int64
+	var seedFile *os.File
+	if f, err := os.Open(seed); err == nil {
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return result, err
+		}
+		if found, err = c.Match(f, info.Size()); err != nil {
+			return result, fmt.Errorf("error reading %s: %w", seed, err)
+		}
+		seedFile = f
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return result, err
+	}
+
+	tmp := output + ".zsync-tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return result, fmt.Errorf("error creating output file: %w", err)
+	}
+	defer func() {
+		out.Close()
+		os.Remove(tmp)
+	}()
+	if err := out.Truncate(c.Length); err != nil {
+		return result, fmt.Errorf("error creating output file: %w", err)
+	}
+
+	// Copy what the seed has, then fetch the rest
+	var missing []int
+	for i := range c.Blocks {
+		offset, ok := found[i]
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		block := make([]byte, c.blockLength(i))
+		if _, err := seedFile.ReadAt(block, offset); err != nil && err != io.EOF {
+			return result, fmt.Errorf("error reading %s: %w", seed, err)
+		}
+		if _, err := out.WriteAt(block, int64(i)*int64(c.BlockSize)); err != nil {
+			return result, fmt.Errorf("error writing to file: %w", err)
+		}
+		result.Reused += int64(len(block))
+		result.BlocksReused++
+	}
+	downloaded, err := c.fetchBlocks(ctx, fileURL, missing, out, opts)
+	result.Downloaded = downloaded
+	if err != nil {
+		return result, err
+	}
+
+	if err := c.verify(out); err != nil {
+		return result, err
+	}
+	if err := out.Sync(); err != nil {
+		return result, fmt.Errorf("error writing to file: %w", err)
+	}
+	out.Close()
+	if err := os.Rename(tmp, output); err != nil {
+		return result, fmt.Errorf("error replacing %s: %w", output, err)
+	}
+	return result, nil
+}
+
+// byteRange is a run of blocks fetched with one range request
+type byteRange struct {
+	first, last int
+}
+
+// ranges groups the missing blocks into runs no larger than maxRangeSize
+func (c *Control) ranges(missing []int) []byteRange {
+	perRange := maxRangeSize / c.BlockSize
+	var out []byteRange
+	for _, i := range missing {
+		if n := len(out); n > 0 && out[n-1].last == i-1 && i-out[n-1].first < perRange {
+			out[n-1].last = i
+			continue
+		}
+		out = append(out, byteRange{first: i, last: i})
+	}
+	return out
+}
+
+// fetchBlocks fetches the missing blocks from fileURL into out over
+// opts.Threads connections and returns how many bytes it fetched
+func (c *Control) fetchBlocks(ctx context.Context, fileURL string, missing []int, out io.WriterAt, opts Options) (int64, error) {
+	ranges := c.ranges(missing)
+	if len(ranges) == 0 {
+		return 0, nil
+	}
+	threads := opts.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	if threads > len(ranges) {
+		threads = len(ranges)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := make(chan byteRange)
+	var mu sync.Mutex
+	var total int64
+	var firstErr error
+	var wg sync.WaitGroup
+	for t := 0; t < threads; t++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				n, err := c.fetchRange(ctx, fileURL, r, out, opts)
+				mu.Lock()
+				total += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, r := range ranges {
+		select {
+		case work <- r:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return total, firstErr
+}
+
+// fetchRange fetches one run of blocks, checks every block against its
+// checksum and writes them to out
+func (c *Control) fetchRange(ctx context.Context, fileURL string, r byteRange, out io.WriterAt, opts Options) (int64, error) {
+	start := int64(r.first) * int64(c.BlockSize)
+	end := int64(r.last)*int64(c.BlockSize) + c.blockLength(r.last) - 1
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Prepare != nil {
+		opts.Prepare(req)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := opts.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return 0, ErrNoRanges
+	default:
+		return 0, fmt.Errorf("%w: %s", downloader.ErrServerStatus, resp.Status)
+	}
+	cr, err := downloader.ParseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return 0, err
+	}
+	if cr.Start != start || cr.End != end || (cr.Total >= 0 && cr.Total != c.Length) {
+		return 0, fmt.Errorf("server returned range %d-%d/%d, requested %d-%d/%d", cr.Start, cr.End, cr.Total, start, end, c.Length)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return 0, fmt.Errorf("short response for bytes %d-%d: %w", start, end, err)
+	}
+	for i := r.first; i <= r.last; i++ {
+		offset := int64(i-r.first) * int64(c.BlockSize)
+		block := make([]byte, c.BlockSize)
+		copy(block, data[offset:offset+c.blockLength(i)])
+		if sum := strongSum(block)[:c.ChecksumBytes]; string(sum) != string(c.Blocks[i].Checksum[:c.ChecksumBytes]) {
+			return 0, fmt.Errorf("%w: block %d", ErrChecksumMismatch, i)
+		}
+	}
+	if _, err := out.WriteAt(data, start); err != nil {
+		return 0, fmt.Errorf("error writing to file: %w", err)
+	}
+	return int64(len(data)), nil
+}
+
+// verify checks the whole updated file against the control file's SHA-1
+func (c *Control) verify(file *os.File) error {
+	if c.SHA1 == "" {
+		return nil
+	}
+	h := newSHA1()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, c.Length)); err != nil {
+		return fmt.Errorf("error reading file for checksum: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != c.SHA1 {
+		return fmt.Errorf("%w: SHA-1 %s, expected %s", ErrChecksumMismatch, got, c.SHA1)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package zsync
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestControlRoundTrip(t *testing.T) {
+	data := testData(10000, 1)
+	c, err := Make(bytes.NewReader(data), "file.bin", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Length != 10000 || parsed.BlockSize != 1024 || len(parsed.Blocks) != 10 || parsed.SHA1 != c.SHA1 || parsed.URL != "file.bin" {
+		t.Errorf("Parse() = %+v", parsed)
+	}
+	for i := range c.Blocks {
+		if parsed.Blocks[i].Rsum != c.Blocks[i].Rsum || !bytes.Equal(parsed.Blocks[i].Checksum, c.Blocks[i].Checksum) {
+			t.Fatalf("block %d differs after a round trip", i)
+		}
+	}
+}
+
+func TestParseRejectsCompressedTargets(t *testing.T) {
+	control := "zsync: 0.6.2\nBlocksize: 2048\nLength: 0\nZ-URL: file.gz\n\n"
+	if _, err := Parse(bytes.NewReader([]byte(control))); err == nil {
+		t.Error("Parse() accepted a compressed target")
+	}
+}
+
+func TestUpdateReusesLocalBlocks(t *testing.T) {
+	const blockSize = 1024
+	newData := testData(64*blockSize+300, 2)
+	// The old version lacks a few blocks and has everything shifted by an
+	// inserted prefix
+	old := append(testData(100, 3), newData[:10*blockSize]...)
+	old = append(old, newData[14*blockSize:]...)
+
+	c, err := Make(bytes.NewReader(newData), "file.bin", blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(newData))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(output, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Update(context.Background(), c, server.URL+"/file.bin", output, output, Options{Threads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+		t.Fatal("updated file does not match the new version")
+	}
+	if result.Downloaded != 4*blockSize || result.Reused != int64(len(newData))-4*blockSize {
+		t.Errorf("reused %d and downloaded %d bytes, want %d and %d", result.Reused, result.Downloaded, len(newData)-4*blockSize, 4*blockSize)
+	}
+	if requests != 1 {
+		t.Errorf("made %d requests, want the missing blocks in one", requests)
+	}
+}
+
+func TestUpdateWithoutRanges(t *testing.T) {
+	newData := testData(4096, 4)
+	c, err := Make(bytes.NewReader(newData), "file.bin", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(newData)
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "file.bin")
+	if _, err := Update(context.Background(), c, server.URL, output, output, Options{}); err != ErrNoRanges {
+		t.Errorf("Update() = %v, want ErrNoRanges", err)
+	}
+	if _, err := os.Stat(output + ".zsync-tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file was left behind")
+	}
+}